@@ -6,24 +6,35 @@ import (
 	"github.com/spf13/viper"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 type Config struct {
 	// Core
-	Params               *lib.DeSoParams
-	ProtocolPort         uint16
-	DataDirectory        string
-	MempoolDumpDirectory string
-	TXIndex              bool
-	Regtest              bool
-	PostgresURI          string
+	Params                *lib.DeSoParams
+	ProtocolPort          uint16
+	DataDirectory         string
+	MempoolDumpDirectory  string
+	TXIndex               bool
+	IndexQueue            bool
+	SearchIndex           bool
+	HotFeed               bool
+	HotFeedWeights        []string
+	HotFeedHalfLife       time.Duration
+	EventJournal          bool
+	StateChangeStreamAddr string
+	PostExtraDataIndex    []string
+	DBMaxValueSizes       []string
+	Regtest               bool
+	PostgresURI           string
 
 	// Peers
-	ConnectIPs          []string
-	AddIPs              []string
-	AddSeeds            []string
-	TargetOutboundPeers uint32
-	StallTimeoutSeconds uint64
+	ConnectIPs            []string
+	AddIPs                []string
+	AddSeeds              []string
+	TargetOutboundPeers   uint32
+	StallTimeoutSeconds   uint64
+	MaxBlockDownloadPeers uint64
 
 	// Peer Restrictions
 	PrivateMode       bool
@@ -33,26 +44,43 @@ type Config struct {
 	MaxInboundPeers   uint32
 	OneInboundPerIp   bool
 
+	// Peer Bans
+	PeerBanThreshold       uint64
+	PeerBanDurationSeconds uint64
+	MaxInvItemsPerSecond   uint64
+
 	// Snapshot
 	HyperSync                 bool
 	ForceChecksum             bool
+	HyperSyncPrefixes         []string
 	SyncType                  lib.NodeSyncType
 	MaxSyncBlockHeight        uint32
 	SnapshotBlockHeightPeriod uint64
 	DisableEncoderMigrations  bool
+	PruneBlockDepth           uint32
+	UtxoOpsRetentionBlocks    uint32
+
+	// Snapshot cache
+	DatabaseCacheSize             uint
+	DatabaseCacheMaxSize          uint
+	DatabaseCacheDisabledPrefixes []string
 
 	// Mining
 	MinerPublicKeys  []string
 	NumMiningThreads uint64
 
 	// Fees
-	RateLimitFeerate uint64
-	MinFeerate       uint64
+	RateLimitFeerate        uint64
+	MinFeerate              uint64
+	MaxPostsPerHour         uint64
+	MempoolFilteredTxnTypes []string
 
 	// BlockProducer
 	MaxBlockTemplatesCache          uint64
 	MinBlockUpdateInterval          uint64
 	BlockCypherAPIKey               string
+	BitcoinHeaderSource             lib.BitcoinHeaderSourceConfig
+	BitcoinMinBurnConfirmations     uint32
 	BlockProducerSeed               string
 	TrustedBlockProducerPublicKeys  []string
 	TrustedBlockProducerStartHeight uint64
@@ -64,6 +92,10 @@ type Config struct {
 	LogDBSummarySnapshots bool
 	DatadogProfiler       bool
 	TimeEvents            bool
+	BlockConnectProfiling bool
+
+	BatchSignatureVerification bool
+	AdminKVUnlockToken         string
 }
 
 func LoadConfig() *Config {
@@ -93,14 +125,29 @@ func LoadConfig() *Config {
 
 	config.MempoolDumpDirectory = viper.GetString("mempool-dump-dir")
 	config.TXIndex = viper.GetBool("txindex")
+	config.IndexQueue = viper.GetBool("index-queue")
+	config.SearchIndex = viper.GetBool("search-index")
+	config.HotFeed = viper.GetBool("hot-feed")
+	config.HotFeedWeights = viper.GetStringSlice("hot-feed-weights")
+	config.HotFeedHalfLife = time.Duration(viper.GetUint64("hot-feed-half-life-seconds")) * time.Second
+	config.EventJournal = viper.GetBool("event-journal")
+	config.StateChangeStreamAddr = viper.GetString("state-change-stream-addr")
+	config.PostExtraDataIndex = viper.GetStringSlice("post-extra-data-index")
+	config.DBMaxValueSizes = viper.GetStringSlice("db-max-value-sizes")
 	config.Regtest = viper.GetBool("regtest")
 	config.PostgresURI = viper.GetString("postgres-uri")
 	config.HyperSync = viper.GetBool("hypersync")
 	config.ForceChecksum = viper.GetBool("force-checksum")
+	config.HyperSyncPrefixes = viper.GetStringSlice("hypersync-prefixes")
 	config.SyncType = lib.NodeSyncType(viper.GetString("sync-type"))
 	config.MaxSyncBlockHeight = viper.GetUint32("max-sync-block-height")
 	config.SnapshotBlockHeightPeriod = viper.GetUint64("snapshot-block-height-period")
 	config.DisableEncoderMigrations = viper.GetBool("disable-encoder-migrations")
+	config.PruneBlockDepth = viper.GetUint32("prune-block-depth")
+	config.UtxoOpsRetentionBlocks = viper.GetUint32("utxo-ops-retention-blocks")
+	config.DatabaseCacheSize = viper.GetUint("database-cache-size")
+	config.DatabaseCacheMaxSize = viper.GetUint("database-cache-max-size")
+	config.DatabaseCacheDisabledPrefixes = viper.GetStringSlice("database-cache-disabled-prefixes")
 
 	// Peers
 	config.ConnectIPs = viper.GetStringSlice("connect-ips")
@@ -108,6 +155,7 @@ func LoadConfig() *Config {
 	config.AddSeeds = viper.GetStringSlice("add-seeds")
 	config.TargetOutboundPeers = viper.GetUint32("target-outbound-peers")
 	config.StallTimeoutSeconds = viper.GetUint64("stall-timeout-seconds")
+	config.MaxBlockDownloadPeers = viper.GetUint64("max-block-download-peers")
 
 	// Peer Restrictions
 	config.PrivateMode = viper.GetBool("private-mode")
@@ -117,6 +165,11 @@ func LoadConfig() *Config {
 	config.MaxInboundPeers = viper.GetUint32("max-inbound-peers")
 	config.OneInboundPerIp = viper.GetBool("one-inbound-per-ip")
 
+	// Peer Bans
+	config.PeerBanThreshold = viper.GetUint64("peer-ban-threshold")
+	config.PeerBanDurationSeconds = viper.GetUint64("peer-ban-duration-seconds")
+	config.MaxInvItemsPerSecond = viper.GetUint64("max-inv-items-per-second")
+
 	// Mining + Admin
 	config.MinerPublicKeys = viper.GetStringSlice("miner-public-keys")
 	config.NumMiningThreads = viper.GetUint64("num-mining-threads")
@@ -124,11 +177,22 @@ func LoadConfig() *Config {
 	// Fees
 	config.RateLimitFeerate = viper.GetUint64("rate-limit-feerate")
 	config.MinFeerate = viper.GetUint64("min-feerate")
+	config.MaxPostsPerHour = viper.GetUint64("max-posts-per-hour")
+	config.MempoolFilteredTxnTypes = viper.GetStringSlice("mempool-filtered-txn-types")
 
 	// BlockProducer
 	config.MaxBlockTemplatesCache = viper.GetUint64("max-block-templates-cache")
 	config.MinBlockUpdateInterval = viper.GetUint64("min-block-update-interval")
 	config.BlockCypherAPIKey = viper.GetString("block-cypher-api-key")
+	config.BitcoinHeaderSource = lib.BitcoinHeaderSourceConfig{
+		Type:        lib.BitcoinHeaderSourceType(viper.GetString("bitcoin-header-source")),
+		PeerAddr:    viper.GetString("bitcoin-header-peer"),
+		RPCURL:      viper.GetString("bitcoin-rpc-url"),
+		RPCUser:     viper.GetString("bitcoin-rpc-user"),
+		RPCPassword: viper.GetString("bitcoin-rpc-password"),
+		FilePath:    viper.GetString("bitcoin-header-file"),
+	}
+	config.BitcoinMinBurnConfirmations = viper.GetUint32("bitcoin-min-burn-confirmations")
 	config.BlockProducerSeed = viper.GetString("block-producer-seed")
 	config.TrustedBlockProducerStartHeight = viper.GetUint64("trusted-block-producer-start-height")
 	config.TrustedBlockProducerPublicKeys = viper.GetStringSlice("trusted-block-producer-public-keys")
@@ -143,6 +207,9 @@ func LoadConfig() *Config {
 	config.LogDBSummarySnapshots = viper.GetBool("log-db-summary-snapshots")
 	config.DatadogProfiler = viper.GetBool("datadog-profiler")
 	config.TimeEvents = viper.GetBool("time-events")
+	config.BlockConnectProfiling = viper.GetBool("block-connect-profiling")
+	config.BatchSignatureVerification = viper.GetBool("batch-signature-verification")
+	config.AdminKVUnlockToken = viper.GetString("admin-kv-unlock-token")
 
 	return &config
 }
@@ -172,16 +239,36 @@ func (config *Config) Print() {
 			"connecting to a trustworthy sync peer."))
 	}
 
+	if len(config.HyperSyncPrefixes) > 0 {
+		glog.Infof("HyperSyncPrefixes: %v - Only these state prefixes will be synced, and the node "+
+			"will stop at the snapshot height", config.HyperSyncPrefixes)
+	}
+
 	if config.SnapshotBlockHeightPeriod > 0 {
 		glog.Infof("SnapshotBlockHeightPeriod: %v", config.SnapshotBlockHeightPeriod)
 	}
 
+	if len(config.DatabaseCacheDisabledPrefixes) > 0 {
+		glog.Infof("DatabaseCacheDisabledPrefixes: %v", config.DatabaseCacheDisabledPrefixes)
+	}
+
 	if lib.IsNodeArchival(config.SyncType) {
 		glog.Infof("ArchivalMode: ON")
 	}
 
 	glog.Infof("SyncType: %v", config.SyncType)
 
+	if config.PruneBlockDepth > 0 {
+		glog.Infof("PruneBlockDepth: %v", config.PruneBlockDepth)
+	}
+	if config.UtxoOpsRetentionBlocks > 0 {
+		glog.Infof("UtxoOpsRetentionBlocks: %v", config.UtxoOpsRetentionBlocks)
+	}
+
+	if config.BitcoinHeaderSource.Type != lib.BitcoinHeaderSourceNone {
+		glog.Infof("BitcoinHeaderSource: %v", config.BitcoinHeaderSource.Type)
+	}
+
 	if config.MaxSyncBlockHeight > 0 {
 		glog.Infof("MaxSyncBlockHeight: %v", config.MaxSyncBlockHeight)
 	}
@@ -211,6 +298,14 @@ func (config *Config) Print() {
 	}
 
 	glog.Infof("Max Inbound Peers: %d", config.MaxInboundPeers)
+	glog.Infof("Max Block Download Peers: %d", config.MaxBlockDownloadPeers)
+
+	if config.PeerBanThreshold > 0 {
+		glog.Infof("Peer Ban Threshold: %d, Peer Ban Duration: %ds", config.PeerBanThreshold,
+			config.PeerBanDurationSeconds)
+	} else {
+		glog.Infof("AUTOMATIC PEER BANS DISABLED")
+	}
 	glog.Infof("Protocol listening on port %d", config.ProtocolPort)
 
 	if len(config.MinerPublicKeys) > 0 {
@@ -219,4 +314,11 @@ func (config *Config) Print() {
 
 	glog.Infof("Rate Limit Feerate: %d", config.RateLimitFeerate)
 	glog.Infof("Min Feerate: %d", config.MinFeerate)
+	if config.MaxPostsPerHour > 0 {
+		glog.Infof("Max Posts Per Hour: %d", config.MaxPostsPerHour)
+	}
+
+	if len(config.MempoolFilteredTxnTypes) > 0 {
+		glog.Infof("Mempool Filtered Txn Types: %v", config.MempoolFilteredTxnTypes)
+	}
 }
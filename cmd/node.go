@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
@@ -27,12 +28,17 @@ import (
 )
 
 type Node struct {
-	Server   *lib.Server
-	ChainDB  *badger.DB
-	TXIndex  *lib.TXIndex
-	Params   *lib.DeSoParams
-	Config   *Config
-	Postgres *lib.Postgres
+	Server     *lib.Server
+	ChainDB    *badger.DB
+	TXIndex    *lib.TXIndex
+	IndexQueue *lib.IndexQueue
+	// StateChangeStream serves the state changes when --state-change-stream-addr is set.
+	StateChangeStream *http.Server
+	// BitcoinHeaderManager syncs the Bitcoin header chain when --bitcoin-header-source is set.
+	BitcoinHeaderManager *lib.BitcoinHeaderManager
+	Params               *lib.DeSoParams
+	Config               *Config
+	Postgres             *lib.Postgres
 
 	// IsRunning is false when a NewNode is created, set to true on Start(), set to false
 	// after Stop() is called. Mainly used in testing.
@@ -105,6 +111,18 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 		lib.Mode = lib.EnableTimer
 	}
 
+	if node.Config.BlockConnectProfiling {
+		lib.EnableBlockConnectProfiling(true)
+	}
+
+	if node.Config.BatchSignatureVerification {
+		lib.EnableBatchSignatureVerification(true)
+	}
+
+	if node.Config.AdminKVUnlockToken != "" {
+		lib.SetAdminKVUnlockToken(node.Config.AdminKVUnlockToken)
+	}
+
 	// Setup statsd
 	statsdClient, err := statsd.New(fmt.Sprintf("%s:%d", os.Getenv("DD_AGENT_HOST"), 8125))
 	if err != nil {
@@ -148,6 +166,12 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 		panic(err)
 	}
 
+	// Refuse to run on a data directory that was created by an incompatible node, e.g. a testnet data
+	// directory opened with the mainnet params, and record this node as the last one to open it.
+	if _, err = lib.CheckAndUpdateNodeInfo(lib.NewBadgerDeSoDB(node.ChainDB), node.Params); err != nil {
+		glog.Fatal(err)
+	}
+
 	// Setup snapshot logger
 	if node.Config.LogDBSummarySnapshots {
 		lib.StartDBSummarySnapshots(node.ChainDB)
@@ -161,6 +185,10 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 		glog.Fatal("--postgres-uri is not supported when --hypersync=true. We're " +
 			"working on Hypersync support for Postgres though!")
 	}
+	// The txindex is built from the block bodies, so they can't be pruned.
+	if node.Config.PruneBlockDepth > 0 && node.Config.TXIndex {
+		glog.Fatal("--prune-block-depth is not supported when --txindex=true.")
+	}
 	var db *pg.DB
 	if node.Config.PostgresURI != "" {
 		options, err := pg.ParseURL(node.Config.PostgresURI)
@@ -183,9 +211,49 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 		}
 	}
 
+	// Parse the state prefixes to hypersync, if only some of them should be synced.
+	hyperSyncPrefixes, err := lib.ParseStatePrefixes(node.Config.HyperSyncPrefixes)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	// Apply the overrides of the db value size limits before anything is written.
+	dbMaxValueSizes, err := lib.ParseDBMaxValueSizes(node.Config.DBMaxValueSizes)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	lib.SetDBMaxValueSizes(dbMaxValueSizes)
+
+	// Configure the snapshot cache before the snapshot is created.
+	if err := lib.SetDatabaseCacheSizeLimits(node.Config.DatabaseCacheSize, node.Config.DatabaseCacheMaxSize); err != nil {
+		glog.Fatal(err)
+	}
+	databaseCacheDisabledPrefixes, err := lib.ParseStatePrefixes(node.Config.DatabaseCacheDisabledPrefixes)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	lib.SetDatabaseCacheDisabledPrefixes(databaseCacheDisabledPrefixes)
+
 	// Setup eventManager
 	eventManager := lib.NewEventManager()
 
+	// Setup the state change stream - not compatible with postgres. It's registered with the
+	// eventManager before any block is processed.
+	if node.Config.StateChangeStreamAddr != "" {
+		if node.Config.PostgresURI != "" {
+			glog.Fatal("--state-change-stream-addr is not supported with --postgres-uri.")
+		}
+		node.StateChangeStream = &http.Server{
+			Addr:    node.Config.StateChangeStreamAddr,
+			Handler: lib.NewStateChangeStreamServer(eventManager),
+		}
+		go func(stateChangeStream *http.Server) {
+			if err := stateChangeStream.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				glog.Errorf("Node.Start: Problem serving state change stream: %v", err)
+			}
+		}(node.StateChangeStream)
+	}
+
 	// Setup the server. ShouldRestart is used whenever we detect an issue and should restart the node after a recovery
 	// process, just in case. These issues usually arise when the node was shutdown unexpectedly mid-operation. The node
 	// performs regular health checks to detect whenever this occurs.
@@ -225,7 +293,12 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 		node.Config.TrustedBlockProducerStartHeight,
 		eventManager,
 		node.nodeMessageChan,
-		node.Config.ForceChecksum)
+		node.Config.ForceChecksum,
+		hyperSyncPrefixes,
+		node.Config.PeerBanThreshold,
+		time.Duration(node.Config.PeerBanDurationSeconds)*time.Second,
+		node.Config.MaxInvItemsPerSecond,
+		node.Config.MaxBlockDownloadPeers)
 	if err != nil {
 		if shouldRestart {
 			glog.Infof(lib.CLog(lib.Red, fmt.Sprintf("Start: Got en error while starting server and shouldRestart "+
@@ -237,6 +310,101 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 	}
 
 	if !shouldRestart {
+		// Setup IndexQueue - not compatible with postgres. It's set on the blockchain before the server
+		// starts so that every block the server connects or disconnects gets indexed.
+		if node.Config.IndexQueue && node.Postgres == nil {
+			node.IndexQueue = lib.NewIndexQueue(node.ChainDB)
+			lib.RegisterDAOCoinPairVolumeHandlers(node.IndexQueue)
+			lib.RegisterPinnedPostsHandlers(node.IndexQueue)
+			lib.RegisterPostHashtagsAndMentionsHandlers(node.IndexQueue)
+			lib.RegisterBlockRewardPayoutHandlers(node.IndexQueue)
+			lib.RegisterStaleDAOCoinLimitOrderHandlers(node.IndexQueue)
+			lib.RegisterFollowEventHandlers(node.IndexQueue)
+			if node.Config.SearchIndex {
+				if !lib.DbIsSearchIndexBuilt(node.ChainDB) {
+					glog.Infof("Start: Building the search index. This may take a while...")
+					if err := lib.RebuildSearchIndex(node.ChainDB); err != nil {
+						glog.Fatal(err)
+					}
+				}
+				lib.RegisterSearchIndexHandlers(node.IndexQueue)
+			}
+			if node.Config.HotFeed {
+				hotFeedParams, err := lib.ParseHotnessFeedParams(node.Config.HotFeedWeights, node.Config.HotFeedHalfLife)
+				if err != nil {
+					glog.Fatal(err)
+				}
+				hotnessFeed := lib.NewHotnessFeed(node.ChainDB, hotFeedParams)
+				if err = hotnessFeed.RebuildIfStale(); err != nil {
+					glog.Fatal(err)
+				}
+				hotnessFeed.RegisterHandlers(node.IndexQueue)
+			}
+			if len(node.Config.PostExtraDataIndex) > 0 {
+				postExtraDataIndexSpecs, err := lib.ParsePostExtraDataIndexSpecs(node.Config.PostExtraDataIndex)
+				if err != nil {
+					glog.Fatal(err)
+				}
+				lib.RegisterPostExtraDataIndexHandlers(node.IndexQueue, postExtraDataIndexSpecs)
+			}
+			node.Server.GetBlockchain().SetIndexQueue(node.IndexQueue)
+			node.IndexQueue.Start()
+		}
+		// A search index or hotness feed that isn't kept up to date would return stale results, so
+		// it's marked as stale to be rebuilt when the node runs with it enabled again.
+		if node.Postgres == nil && (node.IndexQueue == nil || !node.Config.SearchIndex) {
+			if err := lib.DbSetSearchIndexBuilt(node.ChainDB, false); err != nil {
+				glog.Fatal(err)
+			}
+		}
+		if node.Postgres == nil && (node.IndexQueue == nil || !node.Config.HotFeed) {
+			if err := lib.DbPutHotnessFeedParams(node.ChainDB, nil); err != nil {
+				glog.Fatal(err)
+			}
+		}
+
+		// Setup the event journal. Like the IndexQueue, it's set before the server starts so that
+		// every block the server connects or disconnects is journaled.
+		if node.Config.EventJournal {
+			node.Server.GetBlockchain().SetEventJournal(lib.NewEventJournal(node.ChainDB))
+		}
+
+		// Setup block pruning. It's set before the server starts so that the blocks are pruned
+		// as soon as the first one is processed.
+		if node.Config.PruneBlockDepth > 0 {
+			if err := node.Server.GetBlockchain().SetBlockPruneDepth(node.Config.PruneBlockDepth); err != nil {
+				glog.Fatal(err)
+			}
+		}
+		if node.Config.UtxoOpsRetentionBlocks > 0 {
+			if err := node.Server.GetBlockchain().SetUtxoOpsRetention(node.Config.UtxoOpsRetentionBlocks); err != nil {
+				glog.Fatal(err)
+			}
+		}
+
+		// Setup the Bitcoin header sync. It's set on the mempool before the server starts so that
+		// every BitcoinExchange txn is checked against the header chain.
+		bitcoinHeaderSource, err := lib.NewBitcoinHeaderSource(&node.Config.BitcoinHeaderSource, node.Params)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		if bitcoinHeaderSource != nil {
+			node.BitcoinHeaderManager, err = lib.NewBitcoinHeaderManager(
+				node.ChainDB, node.Params, bitcoinHeaderSource, node.Config.BitcoinMinBurnConfirmations)
+			if err != nil {
+				glog.Fatal(err)
+			}
+			node.Server.GetMempool().SetBitcoinHeaderManager(node.BitcoinHeaderManager)
+			node.BitcoinHeaderManager.Start()
+		}
+
+		node.Server.GetMempool().SetMaxPostsPerHour(node.Config.MaxPostsPerHour)
+		mempoolFilteredTxnTypes, err := lib.ParseTxnTypes(node.Config.MempoolFilteredTxnTypes)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		node.Server.GetMempool().SetFilteredTxnTypes(mempoolFilteredTxnTypes)
+
 		node.Server.Start()
 
 		// Setup TXIndex - not compatible with postgres
@@ -295,6 +463,24 @@ func (node *Node) Stop() {
 	glog.Infof(lib.CLog(lib.Yellow, "Node is shutting down. This might take a minute. Please don't "+
 		"close the node now or else you might corrupt the state."))
 
+	// StateChangeStream
+	if node.StateChangeStream != nil {
+		glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: Stopping state change stream..."))
+		if err := node.StateChangeStream.Close(); err != nil {
+			glog.Errorf("Node.Stop: Problem stopping state change stream: %v", err)
+		}
+		node.StateChangeStream = nil
+		glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: State change stream successfully stopped."))
+	}
+
+	// BitcoinHeaderManager
+	if node.BitcoinHeaderManager != nil {
+		glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: Stopping Bitcoin header sync..."))
+		node.BitcoinHeaderManager.Stop()
+		node.BitcoinHeaderManager = nil
+		glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: Bitcoin header sync successfully stopped."))
+	}
+
 	// Server
 	glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: Stopping server..."))
 	node.Server.Stop()
@@ -317,6 +503,13 @@ func (node *Node) Stop() {
 		glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: TXIndex successfully stopped."))
 	}
 
+	// IndexQueue
+	if node.IndexQueue != nil {
+		glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: Stopping IndexQueue..."))
+		node.IndexQueue.Stop()
+		glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: IndexQueue successfully stopped."))
+	}
+
 	// Databases
 	glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: Closing all databases..."))
 	node.closeDb(node.ChainDB, "chain")
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+)
+
+// The rebuild command has its own flags rather than the run flags, since viper binds the run flags
+// to runCmd. It must not run while a node is using the same data directory.
+var rebuildSearchIndexCmd = &cobra.Command{
+	Use:   "rebuild-search-index",
+	Short: "Rebuild the full-text search index of a stopped node",
+	Long: `Drops the search index and indexes every post and profile in the node's db again. Run it ` +
+		`after a hypersync, since posts and profiles that are hypersynced aren't indexed.`,
+	Run: RebuildSearchIndex,
+}
+
+func init() {
+	rebuildSearchIndexCmd.Flags().Bool("testnet", false, "Use the DeSo testnet. Mainnet is used by default")
+	rebuildSearchIndexCmd.Flags().String("data-dir", "",
+		"The data directory of the node. When unset, defaults to the system's configuration directory.")
+	rootCmd.AddCommand(rebuildSearchIndexCmd)
+}
+
+func RebuildSearchIndex(cmd *cobra.Command, args []string) {
+	params := &lib.DeSoMainnetParams
+	if testnet, _ := cmd.Flags().GetBool("testnet"); testnet {
+		params = &lib.DeSoTestnetParams
+	}
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+	if dataDir == "" {
+		dataDir = lib.GetDataDir(params)
+	}
+
+	dbDir := lib.GetBadgerDbPath(filepath.Join(dataDir, lib.DBVersionString))
+	opts := lib.PerformanceBadgerOptions(dbDir)
+	opts.ValueDir = dbDir
+	db, err := badger.Open(opts)
+	if err != nil {
+		glog.Fatalf("RebuildSearchIndex: Problem opening db at %v: %v", dbDir, err)
+	}
+	defer db.Close()
+
+	if err = lib.RebuildSearchIndex(db); err != nil {
+		glog.Fatal(err)
+	}
+	glog.Info("RebuildSearchIndex: Done")
+}
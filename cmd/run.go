@@ -51,6 +51,38 @@ func SetupRunFlags(cmd *cobra.Command) {
 			"ids to transaction information. This enables the use of certain API calls "+
 			"like ones that allow the lookup of particular transactions by their ID. "+
 			"Defaults to false because the index can be large.")
+	cmd.PersistentFlags().Bool("index-queue", false,
+		"When set to true, block connects and disconnects enqueue tasks in a durable queue "+
+			"that background workers consume to update non-consensus indexes, so that "+
+			"these indexes don't slow down block processing.")
+	cmd.PersistentFlags().Bool("search-index", false,
+		"When set to true, the node keeps a full-text search index over post bodies and profile "+
+			"usernames and descriptions. Requires --index-queue. Not supported with --postgres-uri.")
+	cmd.PersistentFlags().Bool("hot-feed", false,
+		"When set to true, the node ranks posts by their likes, diamonds, reposts, quote reposts and "+
+			"comments, decayed over time, in a hotness feed. Requires --index-queue. Not supported with "+
+			"--postgres-uri.")
+	cmd.PersistentFlags().StringSlice("hot-feed-weights", []string{},
+		"Overrides of the weights of the interactions the hotness feed ranks posts by, given as "+
+			"<interaction>=<weight> with the interaction one of like, diamond, repost, quote-repost or "+
+			"comment. Changing the weights rescores every post when the node starts.")
+	cmd.PersistentFlags().Uint64("hot-feed-half-life-seconds", 12*60*60,
+		"The number of seconds it takes for a post's hotness to halve. Changing it rescores every post "+
+			"when the node starts.")
+	cmd.PersistentFlags().Bool("event-journal", false,
+		"When set to true, the blocks and txns the node connects and disconnects are recorded in a "+
+			"persistent journal with sequence numbers, so that consumers can replay the events they "+
+			"missed, including reorgs. Defaults to false because the journal is never trimmed.")
+	cmd.PersistentFlags().String("state-change-stream-addr", "",
+		"If set, the node serves a stream of the changes that block connects and disconnects make to "+
+			"the state on this address, as newline-delimited JSON. Not supported with --postgres-uri.")
+	cmd.PersistentFlags().StringSlice("post-extra-data-index", []string{},
+		"PostEntry ExtraData keys to index posts by. Requires --index-queue. A key is indexed under its "+
+			"raw value, or under each item of its comma-separated value if it's given as key:list.")
+	cmd.PersistentFlags().StringSlice("db-max-value-sizes", []string{},
+		"Overrides of the max value size of db prefixes, given as <prefix>=<bytes> with the prefix by name "+
+			"or id. Writes over a prefix's limit fail, so a limit below what consensus allows on a state "+
+			"prefix stops the node from connecting blocks. A limit of 0 removes the prefix's limit.")
 	cmd.PersistentFlags().Bool("regtest", false,
 		"Can only be used in conjunction with --testnet. Creates a private testnet node with fast block times"+
 			"and instantly spendable block rewards.")
@@ -63,10 +95,30 @@ func SetupRunFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().Bool("hypersync", true, "Use hyper sync protocol for faster block syncing")
 	cmd.PersistentFlags().Bool("force-checksum", true, "When true, the node will panic if the "+
 		"local state checksum differs from the network checksum reported by its peers.")
+	cmd.PersistentFlags().StringSlice("hypersync-prefixes", []string{}, "Only hypersync these state "+
+		"prefixes, given by name or id. The node doesn't sync blocks after the snapshot, and reads from the other "+
+		"state prefixes return ErrPrefixNotSynced. Useful for lightweight mirrors. Syncs all prefixes if empty.")
 	// Snapshot
 	cmd.PersistentFlags().Uint64("snapshot-block-height-period", 1000, "Set the snapshot epoch period. Snapshots are taken at block heights divisible by the period.")
+	cmd.PersistentFlags().Uint("database-cache-size", 0, "The number of state records the snapshot "+
+		"cache starts out holding. The cache is then resized based on its hit rate and memory pressure. "+
+		"If 0, the size from the last run is used.")
+	cmd.PersistentFlags().Uint("database-cache-max-size", 0, "The most state records the snapshot cache "+
+		"can hold. Set it to --database-cache-size to keep the cache at a fixed size. If 0, uses the default.")
+	cmd.PersistentFlags().StringSlice("database-cache-disabled-prefixes", []string{}, "State prefixes, given "+
+		"by name or id, whose records aren't kept in the snapshot cache. Useful for prefixes with lots of "+
+		"writes and few repeated reads.")
 	// Archival mode
 	cmd.PersistentFlags().Bool("archival-mode", true, "Download all historical blocks after finishing hypersync.")
+	// Block pruning
+	cmd.PersistentFlags().Uint32("prune-block-depth", 0, "If nonzero, delete the bodies and utxo operations of "+
+		"the blocks more than this many blocks below the tip, keeping their headers and the state. Blocks after the "+
+		"current snapshot are always kept. Requires --sync-type=hypersync, and isn't supported with --txindex or "+
+		"--postgres-uri. Must be at least 1000.")
+	cmd.PersistentFlags().Uint32("utxo-ops-retention-blocks", 0, "If nonzero, delete the utxo operations of the "+
+		"blocks more than this many blocks below the tip, keeping their bodies. A reorg that needs the operations "+
+		"of an older block regenerates them by reconnecting the blocks after the current snapshot. Requires "+
+		"--sync-type=hypersync, and isn't supported with --postgres-uri. Must be at least 100.")
 	// Disable encoder migrations
 	cmd.PersistentFlags().Bool("disable-encoder-migrations", false, "Disable badgerDB encoder migrations")
 	// Disable slow sync
@@ -97,6 +149,9 @@ func SetupRunFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().Uint64("stall-timeout-seconds", 900,
 		"How long the node will wait for a peer to reply to certain types of requests. "+
 			"We make this gratuitous just in case the node we're connecting to is backed up.")
+	cmd.PersistentFlags().Uint64("max-block-download-peers", 4,
+		"The number of peers blocks are downloaded from in parallel while syncing blocks. "+
+			"Set to 0 to download blocks from the sync peer only.")
 
 	// Peer Restrictions
 	cmd.PersistentFlags().Bool("private-mode", false, "The node does not look up addresses from DNS seeds.")
@@ -113,6 +168,15 @@ func SetupRunFlags(cmd *cobra.Command) {
 			"our connections and potentially make onerous requests as well. Useful to "+
 			"disable this flag when testing locally to allow multiple inbound connections "+
 			"from test servers")
+	cmd.PersistentFlags().Uint64("peer-ban-threshold", 100,
+		"The misbehavior score at which a peer's IP is banned. An invalid block scores 100, a message "+
+			"that's too large 50 and an inv over the inv rate limit 10, and scores halve every ten minutes. "+
+			"Set to 0 to never ban peers automatically.")
+	cmd.PersistentFlags().Uint64("peer-ban-duration-seconds", 24*60*60,
+		"How long a peer's IP stays banned once its misbehavior score reaches --peer-ban-threshold.")
+	cmd.PersistentFlags().Uint64("max-inv-items-per-second", 1000,
+		"The rate of inv items a peer can send before its invs are dropped and count towards banning "+
+			"it. Peers can send up to 100000 items at once. Set to 0 to disable the inv rate limit.")
 
 	// Listeners
 	cmd.PersistentFlags().Uint64("protocol-port", 0,
@@ -145,6 +209,15 @@ func SetupRunFlags(cmd *cobra.Command) {
 			"rate-limit-feerate, should be the first line of "+
 			"defense against attacks that involve flooding the network with low-fee "+
 			"transactions in an attempt to overflow the mempool")
+	cmd.PersistentFlags().Uint64("max-posts-per-hour", 0,
+		"The maximum number of posts and comments a public key can make in the last hour's worth "+
+			"of blocks, counting the ones in the mempool, for the mempool to accept another one "+
+			"relayed by peers. Edits to existing posts aren't limited. Disabled when zero. With "+
+			"postgres, only the posts in the mempool are counted.")
+	cmd.PersistentFlags().StringSlice("mempool-filtered-txn-types", []string{},
+		"Txn types the mempool rejects, by name (e.g. SUBMIT_POST) or number, so that the node never "+
+			"relays or mines them. Blocks with them are still accepted. The number of txns rejected per "+
+			"type is reported as MEMPOOL.FILTERED_TXNS.")
 
 	// BlockProducer
 	cmd.PersistentFlags().Uint64("max-block-templates-cache", 100,
@@ -157,6 +230,22 @@ func SetupRunFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().String("block-cypher-api-key", "",
 		"When specified, this key is used to power the BitcoinExchange flow "+
 			"and to check for double-spends in the mempool")
+	cmd.PersistentFlags().String("bitcoin-header-source", "",
+		"Where the node gets Bitcoin headers from to check BitcoinExchange txns against, for nodes that "+
+			"don't get them from their DeSo peers. One of p2p (a Bitcoin peer, see --bitcoin-header-peer), "+
+			"rpc (a bitcoind, see --bitcoin-rpc-url) or file (see --bitcoin-header-file). Disabled when empty.")
+	cmd.PersistentFlags().String("bitcoin-header-peer", "",
+		"The host:port of the Bitcoin peer to get headers from with --bitcoin-header-source=p2p.")
+	cmd.PersistentFlags().String("bitcoin-rpc-url", "",
+		"The JSON-RPC URL of the bitcoind to get headers from with --bitcoin-header-source=rpc.")
+	cmd.PersistentFlags().String("bitcoin-rpc-user", "", "The bitcoind JSON-RPC user.")
+	cmd.PersistentFlags().String("bitcoin-rpc-password", "", "The bitcoind JSON-RPC password.")
+	cmd.PersistentFlags().String("bitcoin-header-file", "",
+		"The file to get headers from with --bitcoin-header-source=file, with one hex-encoded "+
+			"80-byte Bitcoin header per line.")
+	cmd.PersistentFlags().Uint32("bitcoin-min-burn-confirmations", 1,
+		"The number of confirmations the Bitcoin txn of a BitcoinExchange txn needs in the Bitcoin "+
+			"header chain for the mempool to accept it. Only used with --bitcoin-header-source.")
 	cmd.PersistentFlags().String("block-producer-seed", "",
 		"When set, all blocks produced by the block producer will be signed by this "+
 			"seed.")
@@ -190,9 +279,18 @@ func SetupRunFlags(cmd *cobra.Command) {
 			"where pattern is a literal file name (minus the \".go\" suffix) or \"glob\" "+
 			"pattern and N is a V level. For instance, -vmodule=gopher*=3 sets the V "+
 			"level to 3 in all Go files whose names begin \"gopher\".")
-	cmd.PersistentFlags().Bool("log-db-summary-snapshots", false, "The node will log a snapshot of all DB keys every 30s.")
+	cmd.PersistentFlags().Bool("log-db-summary-snapshots", false, "The node will keep per-prefix key counts and sizes of the DB and log them every 30s.")
 	cmd.PersistentFlags().Bool("datadog-profiler", false, "Enable the DataDog profiler for performance testing")
 	cmd.PersistentFlags().Bool("time-events", false, "Enable simple event timer, helpful in hands-on performance testing")
+	cmd.PersistentFlags().Bool("block-connect-profiling", false,
+		"Time each phase of block connect and persist a profile for every block, so that the "+
+			"cost of each txn type and db prefix can be measured under real load")
+	cmd.PersistentFlags().Bool("batch-signature-verification", false,
+		"Check the signatures of all the txns in a block in parallel before connecting it, "+
+			"which speeds up sync on machines with several cores")
+	cmd.PersistentFlags().String("admin-kv-unlock-token", "",
+		"Unlocks the admin key/value put and delete APIs for callers that pass the same token. "+
+			"These writes bypass all validation, so leave this empty unless you're repairing the db")
 
 	cmd.PersistentFlags().VisitAll(func(flag *pflag.Flag) {
 		viper.BindPFlag(flag.Name, flag)
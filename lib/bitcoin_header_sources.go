@@ -0,0 +1,454 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// BitcoinHeaderSourceType is the value of --bitcoin-header-source.
+type BitcoinHeaderSourceType string
+
+const (
+	// BitcoinHeaderSourceNone disables the Bitcoin header sync.
+	BitcoinHeaderSourceNone BitcoinHeaderSourceType = ""
+	// BitcoinHeaderSourceP2P gets headers from a Bitcoin peer.
+	BitcoinHeaderSourceP2P BitcoinHeaderSourceType = "p2p"
+	// BitcoinHeaderSourceRPC gets headers from a bitcoind over its JSON-RPC interface.
+	BitcoinHeaderSourceRPC BitcoinHeaderSourceType = "rpc"
+	// BitcoinHeaderSourceFile gets headers from a static file of hex-encoded headers.
+	BitcoinHeaderSourceFile BitcoinHeaderSourceType = "file"
+)
+
+// BitcoinHeaderSourceConfig holds the flags that configure the Bitcoin header source. Only the
+// fields of the selected Type are used.
+type BitcoinHeaderSourceConfig struct {
+	Type BitcoinHeaderSourceType
+	// PeerAddr is the host:port of the Bitcoin peer. The port defaults to the network's.
+	PeerAddr string
+	// RPCURL, RPCUser and RPCPassword are the address and credentials of the bitcoind.
+	RPCURL      string
+	RPCUser     string
+	RPCPassword string
+	// FilePath is the path of the header file.
+	FilePath string
+}
+
+// NewBitcoinHeaderSource returns the source the config selects, or nil if the Bitcoin header
+// sync is disabled.
+func NewBitcoinHeaderSource(config *BitcoinHeaderSourceConfig, params *DeSoParams) (BitcoinHeaderSource, error) {
+	switch config.Type {
+	case BitcoinHeaderSourceNone:
+		return nil, nil
+	case BitcoinHeaderSourceP2P:
+		if config.PeerAddr == "" {
+			return nil, fmt.Errorf("NewBitcoinHeaderSource: --bitcoin-header-peer is required with the p2p source")
+		}
+		return NewBitcoinP2PHeaderSource(config.PeerAddr, params), nil
+	case BitcoinHeaderSourceRPC:
+		if config.RPCURL == "" {
+			return nil, fmt.Errorf("NewBitcoinHeaderSource: --bitcoin-rpc-url is required with the rpc source")
+		}
+		return NewBitcoinRPCHeaderSource(config.RPCURL, config.RPCUser, config.RPCPassword), nil
+	case BitcoinHeaderSourceFile:
+		if config.FilePath == "" {
+			return nil, fmt.Errorf("NewBitcoinHeaderSource: --bitcoin-header-file is required with the file source")
+		}
+		source, err := NewBitcoinFileHeaderSource(config.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		return source, nil
+	default:
+		return nil, fmt.Errorf("NewBitcoinHeaderSource: Unknown Bitcoin header source %q, must be one of "+
+			"%q, %q or %q", config.Type, BitcoinHeaderSourceP2P, BitcoinHeaderSourceRPC, BitcoinHeaderSourceFile)
+	}
+}
+
+// ======================================================================================
+// BitcoinFileHeaderSource
+// ======================================================================================
+
+// BitcoinFileHeaderSource serves headers from a static checkpoint file, e.g. for nodes that
+// can't reach the Bitcoin network. The file has one hex-encoded 80-byte header per line, in
+// chain order. Blank lines and lines starting with # are ignored. The file has to start at or
+// before the block after the start node, and the headers are validated like any other.
+type BitcoinFileHeaderSource struct {
+	headers []*wire.BlockHeader
+	// headerIndexByHash maps the hash of each header to its index in headers.
+	headerIndexByHash map[BlockHash]int
+}
+
+func NewBitcoinFileHeaderSource(filePath string) (*BitcoinFileHeaderSource, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewBitcoinFileHeaderSource: Problem opening header file")
+	}
+	defer file.Close()
+
+	source := &BitcoinFileHeaderSource{
+		headerIndexByHash: make(map[BlockHash]int),
+	}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		headerBytes, err := hex.DecodeString(line)
+		if err != nil || len(headerBytes) != wire.MaxBlockHeaderPayload {
+			return nil, fmt.Errorf("NewBitcoinFileHeaderSource: Line %d isn't a hex-encoded %d-byte header",
+				lineNumber, wire.MaxBlockHeaderPayload)
+		}
+		header := &wire.BlockHeader{}
+		if err = header.Deserialize(bytes.NewReader(headerBytes)); err != nil {
+			return nil, errors.Wrapf(err, "NewBitcoinFileHeaderSource: Problem decoding header on line %d", lineNumber)
+		}
+		source.headerIndexByHash[(BlockHash)(header.BlockHash())] = len(source.headers)
+		source.headers = append(source.headers, header)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "NewBitcoinFileHeaderSource: Problem reading header file")
+	}
+	return source, nil
+}
+
+func (source *BitcoinFileHeaderSource) GetHeaders(locator []*BlockHash, maxHeaders int) ([]*wire.BlockHeader, error) {
+	for _, hash := range locator {
+		// The headers start after the locator's block, which is either in the file or the block
+		// before the file's first header.
+		startIndex := 0
+		if hashIndex, exists := source.headerIndexByHash[*hash]; exists {
+			startIndex = hashIndex + 1
+		} else if len(source.headers) == 0 || *hash != (BlockHash)(source.headers[0].PrevBlock) {
+			continue
+		}
+		endIndex := startIndex + maxHeaders
+		if endIndex > len(source.headers) {
+			endIndex = len(source.headers)
+		}
+		return source.headers[startIndex:endIndex], nil
+	}
+	return nil, nil
+}
+
+func (source *BitcoinFileHeaderSource) Close() {}
+
+// ======================================================================================
+// BitcoinRPCHeaderSource
+// ======================================================================================
+
+// BitcoinRPCHeaderSource gets headers from a bitcoind over its JSON-RPC interface, using
+// getblockheader and getblockhash.
+type BitcoinRPCHeaderSource struct {
+	url      string
+	user     string
+	password string
+	client   *http.Client
+}
+
+func NewBitcoinRPCHeaderSource(url string, user string, password string) *BitcoinRPCHeaderSource {
+	return &BitcoinRPCHeaderSource{
+		url:      url,
+		user:     user,
+		password: password,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type bitcoinRPCRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      string        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type bitcoinRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type bitcoinRPCResponse struct {
+	Result json.RawMessage  `json:"result"`
+	Error  *bitcoinRPCError `json:"error"`
+}
+
+// bitcoinRPCErrorBlockNotFound is the code bitcoind returns for a block it doesn't know.
+const bitcoinRPCErrorBlockNotFound = -5
+
+func (source *BitcoinRPCHeaderSource) _call(method string, result interface{}, params ...interface{}) (*bitcoinRPCError, error) {
+	requestBytes, err := json.Marshal(&bitcoinRPCRequest{
+		JSONRPC: "1.0",
+		ID:      "deso",
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(http.MethodPost, source.url, bytes.NewReader(requestBytes))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if source.user != "" || source.password != "" {
+		request.SetBasicAuth(source.user, source.password)
+	}
+	response, err := source.client.Do(request)
+	if err != nil {
+		return nil, errors.Wrapf(err, "BitcoinRPCHeaderSource: Problem calling %v", method)
+	}
+	defer response.Body.Close()
+
+	// bitcoind returns errors with a non-200 status, so we only fail on statuses without a body.
+	rpcResponse := &bitcoinRPCResponse{}
+	if err = json.NewDecoder(response.Body).Decode(rpcResponse); err != nil {
+		return nil, fmt.Errorf("BitcoinRPCHeaderSource: Problem decoding %v response with status %v: %v",
+			method, response.Status, err)
+	}
+	if rpcResponse.Error != nil {
+		return rpcResponse.Error, nil
+	}
+	if err = json.Unmarshal(rpcResponse.Result, result); err != nil {
+		return nil, errors.Wrapf(err, "BitcoinRPCHeaderSource: Problem decoding %v result", method)
+	}
+	return nil, nil
+}
+
+func (source *BitcoinRPCHeaderSource) GetHeaders(locator []*BlockHash, maxHeaders int) ([]*wire.BlockHeader, error) {
+	// Find the first block of the locator that's in bitcoind's best chain. bitcoind returns -1
+	// confirmations for a block that's been reorged out.
+	startHeight := int64(-1)
+	for _, hash := range locator {
+		headerInfo := struct {
+			Height        int64 `json:"height"`
+			Confirmations int64 `json:"confirmations"`
+		}{}
+		rpcErr, err := source._call("getblockheader", &headerInfo, (*chainhash.Hash)(hash).String(), true)
+		if err != nil {
+			return nil, err
+		}
+		if rpcErr != nil {
+			if rpcErr.Code == bitcoinRPCErrorBlockNotFound {
+				continue
+			}
+			return nil, fmt.Errorf("BitcoinRPCHeaderSource: getblockheader failed: %v", rpcErr.Message)
+		}
+		if headerInfo.Confirmations >= 0 {
+			startHeight = headerInfo.Height
+			break
+		}
+	}
+	if startHeight < 0 {
+		return nil, fmt.Errorf("BitcoinRPCHeaderSource: bitcoind doesn't know any block of the locator")
+	}
+
+	var headers []*wire.BlockHeader
+	for height := startHeight + 1; len(headers) < maxHeaders; height++ {
+		var hashString string
+		rpcErr, err := source._call("getblockhash", &hashString, height)
+		if err != nil {
+			return nil, err
+		}
+		if rpcErr != nil {
+			// bitcoind returns an out of range error past its tip.
+			break
+		}
+		var headerHex string
+		rpcErr, err = source._call("getblockheader", &headerHex, hashString, false)
+		if err != nil {
+			return nil, err
+		}
+		if rpcErr != nil {
+			return nil, fmt.Errorf("BitcoinRPCHeaderSource: getblockheader failed for block %v: %v",
+				hashString, rpcErr.Message)
+		}
+		headerBytes, err := hex.DecodeString(headerHex)
+		if err != nil {
+			return nil, errors.Wrapf(err, "BitcoinRPCHeaderSource: Problem decoding header of block %v", hashString)
+		}
+		header := &wire.BlockHeader{}
+		if err = header.Deserialize(bytes.NewReader(headerBytes)); err != nil {
+			return nil, errors.Wrapf(err, "BitcoinRPCHeaderSource: Problem decoding header of block %v", hashString)
+		}
+		headers = append(headers, header)
+	}
+	return headers, nil
+}
+
+func (source *BitcoinRPCHeaderSource) Close() {
+	source.client.CloseIdleConnections()
+}
+
+// ======================================================================================
+// BitcoinP2PHeaderSource
+// ======================================================================================
+
+// BitcoinP2PHeaderSource gets headers from a Bitcoin peer with getheaders messages. It connects
+// when it's first asked for headers, and reconnects after any error.
+type BitcoinP2PHeaderSource struct {
+	peerAddr string
+	params   *DeSoParams
+
+	// connLock protects conn.
+	connLock sync.Mutex
+	conn     net.Conn
+}
+
+// bitcoinP2PTimeout is how long the source waits on the peer for a handshake or a response.
+const bitcoinP2PTimeout = 30 * time.Second
+
+func NewBitcoinP2PHeaderSource(peerAddr string, params *DeSoParams) *BitcoinP2PHeaderSource {
+	if _, _, err := net.SplitHostPort(peerAddr); err != nil {
+		peerAddr = net.JoinHostPort(peerAddr, params.BitcoinBtcdParams.DefaultPort)
+	}
+	return &BitcoinP2PHeaderSource{
+		peerAddr: peerAddr,
+		params:   params,
+	}
+}
+
+func (source *BitcoinP2PHeaderSource) _writeMessage(msg wire.Message) error {
+	return wire.WriteMessage(source.conn, msg, wire.ProtocolVersion, source.params.BitcoinBtcdParams.Net)
+}
+
+// _readMessage reads the next message from the peer, and answers the pings it reads on the way.
+func (source *BitcoinP2PHeaderSource) _readMessage() (wire.Message, error) {
+	for {
+		msg, _, err := wire.ReadMessage(source.conn, wire.ProtocolVersion, source.params.BitcoinBtcdParams.Net)
+		if err != nil {
+			return nil, err
+		}
+		if ping, isPing := msg.(*wire.MsgPing); isPing {
+			if err = source._writeMessage(wire.NewMsgPong(ping.Nonce)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return msg, nil
+	}
+}
+
+// _connect opens a connection to the peer and does the version handshake. The connLock must be held.
+func (source *BitcoinP2PHeaderSource) _connect() error {
+	conn, err := net.DialTimeout("tcp", source.peerAddr, bitcoinP2PTimeout)
+	if err != nil {
+		return errors.Wrapf(err, "BitcoinP2PHeaderSource: Problem connecting to %v", source.peerAddr)
+	}
+	source.conn = conn
+	if err = conn.SetDeadline(time.Now().Add(bitcoinP2PTimeout)); err != nil {
+		return err
+	}
+
+	host, portString, _ := net.SplitHostPort(source.peerAddr)
+	port, _ := strconv.ParseUint(portString, 10, 16)
+	peerNetAddr := wire.NewNetAddressIPPort(net.ParseIP(host), uint16(port), 0)
+	localNetAddr := wire.NewNetAddressIPPort(net.IPv4zero, 0, 0)
+	versionMsg := wire.NewMsgVersion(localNetAddr, peerNetAddr, rand.Uint64(), 0)
+	if err = source._writeMessage(versionMsg); err != nil {
+		return errors.Wrapf(err, "BitcoinP2PHeaderSource: Problem sending version")
+	}
+
+	// The handshake is done once we got the peer's version and its verack of ours.
+	gotVersion, gotVerAck := false, false
+	for !gotVersion || !gotVerAck {
+		msg, err := source._readMessage()
+		if err != nil {
+			return errors.Wrapf(err, "BitcoinP2PHeaderSource: Problem during handshake")
+		}
+		switch msg.(type) {
+		case *wire.MsgVersion:
+			gotVersion = true
+			if err = source._writeMessage(wire.NewMsgVerAck()); err != nil {
+				return errors.Wrapf(err, "BitcoinP2PHeaderSource: Problem sending verack")
+			}
+		case *wire.MsgVerAck:
+			gotVerAck = true
+		}
+	}
+	glog.V(1).Infof("BitcoinP2PHeaderSource: Connected to Bitcoin peer %v", source.peerAddr)
+	return nil
+}
+
+// _disconnect must be called with the connLock held.
+func (source *BitcoinP2PHeaderSource) _disconnect() {
+	if source.conn != nil {
+		source.conn.Close()
+		source.conn = nil
+	}
+}
+
+func (source *BitcoinP2PHeaderSource) GetHeaders(locator []*BlockHash, maxHeaders int) ([]*wire.BlockHeader, error) {
+	source.connLock.Lock()
+	defer source.connLock.Unlock()
+
+	headers, err := source._getHeaders(locator, maxHeaders)
+	if err != nil {
+		source._disconnect()
+		return nil, err
+	}
+	return headers, nil
+}
+
+func (source *BitcoinP2PHeaderSource) _getHeaders(locator []*BlockHash, maxHeaders int) ([]*wire.BlockHeader, error) {
+	if source.conn == nil {
+		if err := source._connect(); err != nil {
+			return nil, err
+		}
+	}
+	if err := source.conn.SetDeadline(time.Now().Add(bitcoinP2PTimeout)); err != nil {
+		return nil, err
+	}
+
+	getHeadersMsg := wire.NewMsgGetHeaders()
+	for _, hash := range locator {
+		if len(getHeadersMsg.BlockLocatorHashes) == wire.MaxBlockLocatorsPerMsg {
+			break
+		}
+		if err := getHeadersMsg.AddBlockLocatorHash((*chainhash.Hash)(hash)); err != nil {
+			return nil, err
+		}
+	}
+	if err := source._writeMessage(getHeadersMsg); err != nil {
+		return nil, errors.Wrapf(err, "BitcoinP2PHeaderSource: Problem sending getheaders")
+	}
+
+	// The peer can send other messages, like inv or addr, before the headers.
+	for {
+		msg, err := source._readMessage()
+		if err != nil {
+			return nil, errors.Wrapf(err, "BitcoinP2PHeaderSource: Problem waiting for headers")
+		}
+		headersMsg, isHeaders := msg.(*wire.MsgHeaders)
+		if !isHeaders {
+			continue
+		}
+		headers := headersMsg.Headers
+		if len(headers) > maxHeaders {
+			headers = headers[:maxHeaders]
+		}
+		return headers, nil
+	}
+}
+
+func (source *BitcoinP2PHeaderSource) Close() {
+	source.connLock.Lock()
+	defer source.connLock.Unlock()
+
+	source._disconnect()
+}
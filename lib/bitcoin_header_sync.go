@@ -0,0 +1,470 @@
+package lib
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	btcdchain "github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	merkletree "github.com/deso-protocol/go-merkle-tree"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// BitcoinHeaderSource is where the BitcoinHeaderManager gets Bitcoin headers from. Nodes that
+// don't get Bitcoin headers relayed by their DeSo peers can pick whichever source they have access
+// to: a Bitcoin peer, a bitcoind they run, or a static file of headers.
+type BitcoinHeaderSource interface {
+	// GetHeaders returns up to maxHeaders headers, in order, that follow the first hash of the
+	// locator the source knows about. The locator starts at the manager's best header and goes back
+	// towards the start node, like the locator of a Bitcoin getheaders message. An empty result
+	// means the source has no headers past the locator.
+	GetHeaders(locator []*BlockHash, maxHeaders int) ([]*wire.BlockHeader, error)
+
+	// Close releases the connections the source holds. GetHeaders isn't called after Close.
+	Close()
+}
+
+// BitcoinHeaderManager maintains the Bitcoin header chain that BitcoinExchange txns are checked
+// against. It starts at the params' BitcoinStartBlockNode, validates the headers it gets from its
+// BitcoinHeaderSource, and stores them under _PrefixBitcoinHeightHashToNodeInfo, with the tip of
+// the chain with the most work under _PrefixBestBitcoinHeaderHash. As with the DeSo block index,
+// every header that's stored is valid, and only the best chain moves on a reorg.
+//
+// Only the header fields that are needed later are kept: the hash, height, difficulty and work
+// in the BlockNode, and the prev hash, merkle root and timestamp in its MsgDeSoHeader.
+type BitcoinHeaderManager struct {
+	db     *badger.DB
+	params *DeSoParams
+	source BitcoinHeaderSource
+
+	// minBurnConfirmations is the number of blocks, including its own, that have to be mined on
+	// the block of a BitcoinExchange txn for ValidateBitcoinExchange to accept it.
+	minBurnConfirmations uint32
+
+	// headerLock protects headerIndex and bestHeaderChain.
+	headerLock      sync.RWMutex
+	headerIndex     map[BlockHash]*BlockNode
+	bestHeaderChain []*BlockNode
+
+	// Update wait group
+	updateWaitGroup sync.WaitGroup
+
+	// Shutdown channel
+	stopUpdateChannel chan struct{}
+}
+
+// NewBitcoinHeaderManager loads the Bitcoin header chain from the db, or initializes it with
+// the params' BitcoinStartBlockNode if it's empty. The source can be nil if the chain is only
+// read, e.g. in tests.
+func NewBitcoinHeaderManager(db *badger.DB, params *DeSoParams, source BitcoinHeaderSource,
+	minBurnConfirmations uint32) (*BitcoinHeaderManager, error) {
+
+	if params.BitcoinStartBlockNode == nil {
+		return nil, fmt.Errorf("NewBitcoinHeaderManager: The params don't have a BitcoinStartBlockNode")
+	}
+
+	bhm := &BitcoinHeaderManager{
+		db:                   db,
+		params:               params,
+		source:               source,
+		minBurnConfirmations: minBurnConfirmations,
+		stopUpdateChannel:    make(chan struct{}),
+	}
+
+	headerIndex, err := GetBlockIndex(db, true /*bitcoinNodes*/)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewBitcoinHeaderManager: Problem reading Bitcoin headers")
+	}
+	bestHash := DbGetBestHash(db, nil, ChainTypeBitcoinHeader)
+
+	// If the chain is empty, we start it at the start node. Its header only has a timestamp,
+	// but we never need anything else from it since the headers before it aren't known.
+	if len(headerIndex) == 0 || bestHash == nil {
+		startNode := *params.BitcoinStartBlockNode
+		startHeader := *params.BitcoinStartBlockNode.Header
+		startNode.Header = &startHeader
+		startNode.Parent = nil
+		if startNode.CumWork == nil {
+			startNode.CumWork = big.NewInt(0)
+		}
+		err = db.Update(func(txn *badger.Txn) error {
+			if err := PutHeightHashToNodeInfoWithTxn(txn, nil, &startNode, true /*bitcoinNodes*/); err != nil {
+				return err
+			}
+			return PutBestHashWithTxn(txn, nil, startNode.Hash, ChainTypeBitcoinHeader)
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "NewBitcoinHeaderManager: Problem storing start node")
+		}
+		headerIndex = map[BlockHash]*BlockNode{*startNode.Hash: &startNode}
+		bestHash = startNode.Hash
+	}
+
+	tipNode, exists := headerIndex[*bestHash]
+	if !exists {
+		return nil, fmt.Errorf("NewBitcoinHeaderManager: Best Bitcoin header %v isn't in the header index", bestHash)
+	}
+	bestHeaderChain, err := GetBestChain(tipNode, headerIndex)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewBitcoinHeaderManager: Problem building best Bitcoin header chain")
+	}
+	bhm.headerIndex = headerIndex
+	bhm.bestHeaderChain = bestHeaderChain
+
+	glog.V(1).Infof("NewBitcoinHeaderManager: Loaded %d Bitcoin headers, best header %v at height %d",
+		len(headerIndex), tipNode.Hash, tipNode.Height)
+	return bhm, nil
+}
+
+// Start syncs the header chain from the source in the background until Stop is called.
+func (bhm *BitcoinHeaderManager) Start() {
+	glog.Info("BitcoinHeaderManager: Starting header sync")
+
+	bhm.updateWaitGroup.Add(1)
+	go func() {
+		defer bhm.updateWaitGroup.Done()
+
+		for {
+			numHeaders, err := bhm.SyncHeaders()
+			if err != nil {
+				glog.Errorf("BitcoinHeaderManager: Problem syncing headers, will retry: %v", err)
+			}
+
+			// If we got a full batch there are probably more headers, so we keep going.
+			// Otherwise, we wait a bit for new Bitcoin blocks to be mined.
+			waitDuration := BitcoinHeaderPollInterval
+			if err != nil {
+				waitDuration = BitcoinHeaderRetryInterval
+			} else if numHeaders == BitcoinHeaderBatchSize {
+				waitDuration = 0
+			}
+
+			select {
+			case <-bhm.stopUpdateChannel:
+				return
+			case <-time.After(waitDuration):
+			}
+		}
+	}()
+}
+
+// Stop waits for the sync to finish the batch it's on, and closes the source.
+func (bhm *BitcoinHeaderManager) Stop() {
+	glog.Info("BitcoinHeaderManager: Stopping header sync")
+
+	close(bhm.stopUpdateChannel)
+	bhm.updateWaitGroup.Wait()
+	if bhm.source != nil {
+		bhm.source.Close()
+	}
+}
+
+// SyncHeaders asks the source for one batch of headers past the best header and processes it.
+// It returns the number of headers the source returned.
+func (bhm *BitcoinHeaderManager) SyncHeaders() (_numHeaders int, _err error) {
+	if bhm.source == nil {
+		return 0, fmt.Errorf("BitcoinHeaderManager.SyncHeaders: No header source")
+	}
+	headers, err := bhm.source.GetHeaders(bhm.GetBlockLocator(), BitcoinHeaderBatchSize)
+	if err != nil {
+		return 0, errors.Wrapf(err, "BitcoinHeaderManager.SyncHeaders: Problem getting headers")
+	}
+	if _, err = bhm.ProcessHeaders(headers); err != nil {
+		return 0, errors.Wrapf(err, "BitcoinHeaderManager.SyncHeaders: ")
+	}
+	return len(headers), nil
+}
+
+// GetBlockLocator returns hashes of the best header chain, starting at the tip and going back
+// one block at a time for ten blocks, then doubling the step. The start node is always last.
+func (bhm *BitcoinHeaderManager) GetBlockLocator() []*BlockHash {
+	bhm.headerLock.RLock()
+	defer bhm.headerLock.RUnlock()
+
+	var locator []*BlockHash
+	step := 1
+	for ii := len(bhm.bestHeaderChain) - 1; ii > 0; ii -= step {
+		locator = append(locator, bhm.bestHeaderChain[ii].Hash)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	return append(locator, bhm.bestHeaderChain[0].Hash)
+}
+
+// ProcessHeaders validates the headers and stores the ones that are new. The headers must be
+// in order, and the first one must connect to a header we have. If a header is invalid, none of
+// the headers are stored. It returns the number of headers that were new.
+func (bhm *BitcoinHeaderManager) ProcessHeaders(headers []*wire.BlockHeader) (_numNewHeaders int, _err error) {
+	bhm.headerLock.Lock()
+	defer bhm.headerLock.Unlock()
+
+	// The new nodes are validated against each other before any of them is added to the index.
+	newNodes := make(map[BlockHash]*BlockNode)
+	var newNodesInOrder []*BlockNode
+	for _, header := range headers {
+		hash := (BlockHash)(header.BlockHash())
+		if _, exists := bhm.headerIndex[hash]; exists {
+			continue
+		}
+		if _, exists := newNodes[hash]; exists {
+			continue
+		}
+
+		prevHash := (BlockHash)(header.PrevBlock)
+		parent, exists := bhm.headerIndex[prevHash]
+		if !exists {
+			parent, exists = newNodes[prevHash]
+		}
+		if !exists {
+			return 0, errors.Wrapf(HeaderErrorInvalidParent, "BitcoinHeaderManager.ProcessHeaders: "+
+				"Bitcoin header %v doesn't connect to a known header", &hash)
+		}
+		node, err := bhm._validateHeader(header, &hash, parent)
+		if err != nil {
+			return 0, errors.Wrapf(err, "BitcoinHeaderManager.ProcessHeaders: Bitcoin header %v is invalid", &hash)
+		}
+		newNodes[hash] = node
+		newNodesInOrder = append(newNodesInOrder, node)
+	}
+	if len(newNodesInOrder) == 0 {
+		return 0, nil
+	}
+
+	// The best chain only moves if one of the new nodes has more work than the current tip.
+	bestTip := bhm.bestHeaderChain[len(bhm.bestHeaderChain)-1]
+	newTip := bestTip
+	for _, node := range newNodesInOrder {
+		if node.CumWork.Cmp(newTip.CumWork) > 0 {
+			newTip = node
+		}
+	}
+
+	err := bhm.db.Update(func(txn *badger.Txn) error {
+		for _, node := range newNodesInOrder {
+			if err := PutHeightHashToNodeInfoWithTxn(txn, nil, node, true /*bitcoinNodes*/); err != nil {
+				return err
+			}
+		}
+		if newTip != bestTip {
+			return PutBestHashWithTxn(txn, nil, newTip.Hash, ChainTypeBitcoinHeader)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "BitcoinHeaderManager.ProcessHeaders: Problem storing headers")
+	}
+
+	for _, node := range newNodesInOrder {
+		bhm.headerIndex[*node.Hash] = node
+	}
+	if newTip != bestTip {
+		bhm._setBestHeaderTip(newTip)
+		glog.V(1).Infof("BitcoinHeaderManager.ProcessHeaders: Best Bitcoin header is now %v at height %d",
+			newTip.Hash, newTip.Height)
+	}
+
+	return len(newNodesInOrder), nil
+}
+
+// _setBestHeaderTip replaces the part of the best chain after the fork point with the new
+// tip's ancestors. The headerLock must be held.
+func (bhm *BitcoinHeaderManager) _setBestHeaderTip(newTip *BlockNode) {
+	var reversedNewNodes []*BlockNode
+	node := newTip
+	for node != nil && !bhm._isInBestHeaderChain(node) {
+		reversedNewNodes = append(reversedNewNodes, node)
+		node = node.Parent
+	}
+
+	// Every stored node descends from the start node, so the fork point is always found.
+	forkIndex := int(node.Height - bhm.bestHeaderChain[0].Height)
+	bestHeaderChain := bhm.bestHeaderChain[:forkIndex+1]
+	for ii := len(reversedNewNodes) - 1; ii >= 0; ii-- {
+		bestHeaderChain = append(bestHeaderChain, reversedNewNodes[ii])
+	}
+	bhm.bestHeaderChain = bestHeaderChain
+}
+
+// _isInBestHeaderChain must be called with the headerLock held.
+func (bhm *BitcoinHeaderManager) _isInBestHeaderChain(node *BlockNode) bool {
+	startHeight := bhm.bestHeaderChain[0].Height
+	if node.Height < startHeight {
+		return false
+	}
+	index := int(node.Height - startHeight)
+	return index < len(bhm.bestHeaderChain) && *bhm.bestHeaderChain[index].Hash == *node.Hash
+}
+
+// _validateHeader checks the header's proof of work, difficulty and timestamp against its
+// parent, and returns the node to store for it.
+func (bhm *BitcoinHeaderManager) _validateHeader(header *wire.BlockHeader, hash *BlockHash,
+	parent *BlockNode) (*BlockNode, error) {
+
+	btcParams := bhm.params.BitcoinBtcdParams
+
+	// The hash has to be below the target, and the target below the network's limit.
+	target := btcdchain.CompactToBig(header.Bits)
+	if target.Sign() <= 0 || target.Cmp(btcParams.PowLimit) > 0 {
+		return nil, errors.Wrapf(HeaderErrorBlockDifficultyAboveTarget,
+			"_validateHeader: Target %064x is out of range", target)
+	}
+	if btcdchain.HashToBig((*chainhash.Hash)(hash)).Cmp(target) > 0 {
+		return nil, errors.Wrapf(HeaderErrorBlockDifficultyAboveTarget,
+			"_validateHeader: Hash %v is above the target %064x", hash, target)
+	}
+
+	// The difficulty has to be the one the network requires after the parent.
+	requiredBits, checkBits := bhm._requiredBits(parent, header)
+	if checkBits && header.Bits != requiredBits {
+		return nil, errors.Wrapf(HeaderErrorDifficultyBitsNotConsistentWithTargetDifficultyComputedFromParent,
+			"_validateHeader: Bits %08x != required bits %08x", header.Bits, requiredBits)
+	}
+
+	// The timestamp has to be after the median of the previous blocks, and not too far in the future.
+	tstampSecs := uint64(header.Timestamp.Unix())
+	if tstampSecs <= _medianTstampSecs(parent) {
+		return nil, errors.Wrapf(HeaderErrorTimestampTooEarly,
+			"_validateHeader: Timestamp %d isn't after the median time of the previous blocks", tstampSecs)
+	}
+	maxTstampSecs := uint64(time.Now().Add(2 * time.Hour).Unix())
+	if tstampSecs > maxTstampSecs {
+		return nil, errors.Wrapf(HeaderErrorBlockTooFarInTheFuture,
+			"_validateHeader: Timestamp %d is after %d", tstampSecs, maxTstampSecs)
+	}
+
+	prevHash := *parent.Hash
+	merkleRoot := (BlockHash)(header.MerkleRoot)
+	cumWork := new(big.Int).Add(parent.CumWork, btcdchain.CalcWork(header.Bits))
+	return NewBlockNode(
+		parent,
+		hash,
+		parent.Height+1,
+		BigintToHash(target),
+		cumWork,
+		// As with the start node, we store the Bitcoin header fields we need in a DeSo header.
+		&MsgDeSoHeader{
+			PrevBlockHash:         &prevHash,
+			TransactionMerkleRoot: &merkleRoot,
+			TstampSecs:            tstampSecs,
+			Nonce:                 uint64(header.Nonce),
+		},
+		StatusBitcoinHeaderValidated,
+	), nil
+}
+
+// _requiredBits returns the difficulty bits the block after the parent must have, and false if
+// they can't be checked. They can't be checked on networks that allow blocks at the minimum
+// difficulty, like testnet, or at a retarget when the first block of the period is before the
+// start node. In both cases, the header still has to meet its own target.
+func (bhm *BitcoinHeaderManager) _requiredBits(parent *BlockNode, header *wire.BlockHeader) (uint32, bool) {
+	btcParams := bhm.params.BitcoinBtcdParams
+	parentBits := btcdchain.BigToCompact(HashToBigint(parent.DifficultyTarget))
+	if btcParams.PoWNoRetargeting {
+		return parentBits, true
+	}
+	if btcParams.ReduceMinDifficulty {
+		return 0, false
+	}
+
+	blocksPerRetarget := uint32(btcParams.TargetTimespan / btcParams.TargetTimePerBlock)
+	if (parent.Height+1)%blocksPerRetarget != 0 {
+		return parentBits, true
+	}
+
+	firstNode := parent
+	for ii := uint32(0); ii < blocksPerRetarget-1; ii++ {
+		if firstNode.Parent == nil {
+			return 0, false
+		}
+		firstNode = firstNode.Parent
+	}
+
+	// This is the retarget from btcd's calcNextRequiredDifficulty.
+	targetTimespan := int64(btcParams.TargetTimespan / time.Second)
+	minTimespan := targetTimespan / btcParams.RetargetAdjustmentFactor
+	maxTimespan := targetTimespan * btcParams.RetargetAdjustmentFactor
+	actualTimespan := int64(parent.Header.TstampSecs) - int64(firstNode.Header.TstampSecs)
+	if actualTimespan < minTimespan {
+		actualTimespan = minTimespan
+	} else if actualTimespan > maxTimespan {
+		actualTimespan = maxTimespan
+	}
+	newTarget := new(big.Int).Mul(HashToBigint(parent.DifficultyTarget), big.NewInt(actualTimespan))
+	newTarget.Div(newTarget, big.NewInt(targetTimespan))
+	if newTarget.Cmp(btcParams.PowLimit) > 0 {
+		newTarget.Set(btcParams.PowLimit)
+	}
+	return btcdchain.BigToCompact(newTarget), true
+}
+
+// _medianTstampSecs returns the median timestamp of the node and the ten blocks before it, or
+// of as many of them as we have.
+func _medianTstampSecs(node *BlockNode) uint64 {
+	var tstamps []uint64
+	for ii := 0; ii < 11 && node != nil; ii++ {
+		tstamps = append(tstamps, node.Header.TstampSecs)
+		node = node.Parent
+	}
+	sort.Slice(tstamps, func(ii, jj int) bool {
+		return tstamps[ii] < tstamps[jj]
+	})
+	return tstamps[len(tstamps)/2]
+}
+
+// GetBestHeaderTip returns the tip of the Bitcoin header chain with the most work.
+func (bhm *BitcoinHeaderManager) GetBestHeaderTip() *BlockNode {
+	bhm.headerLock.RLock()
+	defer bhm.headerLock.RUnlock()
+
+	return bhm.bestHeaderChain[len(bhm.bestHeaderChain)-1]
+}
+
+// GetHeaderNode returns the node of the Bitcoin block, or nil if we don't have its header, and
+// whether it's in the best chain.
+func (bhm *BitcoinHeaderManager) GetHeaderNode(hash *BlockHash) (_node *BlockNode, _isInBestChain bool) {
+	bhm.headerLock.RLock()
+	defer bhm.headerLock.RUnlock()
+
+	node, exists := bhm.headerIndex[*hash]
+	if !exists {
+		return nil, false
+	}
+	return node, bhm._isInBestHeaderChain(node)
+}
+
+// ValidateBitcoinExchange checks that the Bitcoin txn of a BitcoinExchange was mined into a block
+// of the best Bitcoin header chain with at least minBurnConfirmations confirmations.
+func (bhm *BitcoinHeaderManager) ValidateBitcoinExchange(txnMeta *BitcoinExchangeMetadata) error {
+	if txnMeta.BitcoinBlockHash == nil || txnMeta.BitcoinMerkleRoot == nil {
+		return RuleErrorBitcoinExchangeBlockHashNotFoundInMainBitcoinChain
+	}
+	node, isInBestChain := bhm.GetHeaderNode(txnMeta.BitcoinBlockHash)
+	if node == nil || !isInBestChain {
+		return errors.Wrapf(RuleErrorBitcoinExchangeBlockHashNotFoundInMainBitcoinChain,
+			"ValidateBitcoinExchange: Bitcoin block %v", txnMeta.BitcoinBlockHash)
+	}
+	if *node.Header.TransactionMerkleRoot != *txnMeta.BitcoinMerkleRoot {
+		return errors.Wrapf(RuleErrorBitcoinExchangeHasBadMerkleRoot,
+			"ValidateBitcoinExchange: Merkle root %v != %v in Bitcoin block %v",
+			txnMeta.BitcoinMerkleRoot, node.Header.TransactionMerkleRoot, node.Hash)
+	}
+	bitcoinTxHash := txnMeta.BitcoinTransaction.TxHash()
+	if !merkletree.VerifyProof(bitcoinTxHash[:], txnMeta.BitcoinMerkleProof, txnMeta.BitcoinMerkleRoot[:]) {
+		return errors.Wrapf(RuleErrorBitcoinExchangeInvalidMerkleProof,
+			"ValidateBitcoinExchange: Bitcoin txn %v", &bitcoinTxHash)
+	}
+	numConfirmations := bhm.GetBestHeaderTip().Height - node.Height + 1
+	if numConfirmations < bhm.minBurnConfirmations {
+		return errors.Wrapf(RuleErrorBitcoinExchangeInsufficientConfirmations,
+			"ValidateBitcoinExchange: Bitcoin block %v has %d confirmations, %d are required",
+			node.Hash, numConfirmations, bhm.minBurnConfirmations)
+	}
+	return nil
+}
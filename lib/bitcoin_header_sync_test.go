@@ -0,0 +1,457 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	btcdchain "github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	merkletree "github.com/deso-protocol/go-merkle-tree"
+	"github.com/stretchr/testify/require"
+)
+
+// _testBitcoinHeaderParams returns params with a Bitcoin network that retargets every four blocks
+// and has a target easy enough to mine headers in tests.
+func _testBitcoinHeaderParams() *DeSoParams {
+	btcParams := chaincfg.MainNetParams
+	btcParams.PowLimit = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(1))
+	btcParams.PowLimitBits = 0x207fffff
+	btcParams.TargetTimePerBlock = 10 * time.Minute
+	btcParams.TargetTimespan = 40 * time.Minute
+
+	params := DeSoTestnetParams
+	params.BitcoinBtcdParams = &btcParams
+	params.BitcoinStartBlockNode = NewBlockNode(
+		nil,
+		&BlockHash{1},
+		100,
+		_difficultyBitsToHash(btcParams.PowLimitBits),
+		big.NewInt(0),
+		&MsgDeSoHeader{
+			TstampSecs: 1600000000,
+		},
+		StatusBitcoinHeaderValidated,
+	)
+	return &params
+}
+
+func _mineTestBitcoinHeader(prevHash *BlockHash, tstampSecs uint64, bits uint32, merkleRoot *BlockHash) *wire.BlockHeader {
+	header := &wire.BlockHeader{
+		Version:    1,
+		PrevBlock:  chainhash.Hash(*prevHash),
+		MerkleRoot: chainhash.Hash(*merkleRoot),
+		Timestamp:  time.Unix(int64(tstampSecs), 0),
+		Bits:       bits,
+	}
+	target := btcdchain.CompactToBig(bits)
+	for {
+		hash := header.BlockHash()
+		if btcdchain.HashToBig(&hash).Cmp(target) <= 0 {
+			return header
+		}
+		header.Nonce++
+	}
+}
+
+// _buildTestBitcoinHeaders mines numHeaders headers ten minutes apart on the parent, with the
+// difficulty the test network requires.
+func _buildTestBitcoinHeaders(params *DeSoParams, parent *BlockNode, parentBits uint32,
+	numHeaders int, merkleRoot *BlockHash) []*wire.BlockHeader {
+
+	var headers []*wire.BlockHeader
+	prevHash := parent.Hash
+	bits := parentBits
+	for ii := 1; ii <= numHeaders; ii++ {
+		height := parent.Height + uint32(ii)
+		// Blocks are exactly ten minutes apart, so each retarget makes the target 3/4 of what it
+		// was: the timespan from the first block of a period to the last is three blocks.
+		if height%4 == 0 {
+			target := btcdchain.CompactToBig(bits)
+			target.Mul(target, big.NewInt(3))
+			target.Div(target, big.NewInt(4))
+			bits = btcdchain.BigToCompact(target)
+		}
+		tstampSecs := parent.Header.TstampSecs + uint64(ii)*600
+		header := _mineTestBitcoinHeader(prevHash, tstampSecs, bits, merkleRoot)
+		headers = append(headers, header)
+		hash := (BlockHash)(header.BlockHash())
+		prevHash = &hash
+	}
+	return headers
+}
+
+func TestBitcoinHeaderManager(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	params := _testBitcoinHeaderParams()
+	startNode := params.BitcoinStartBlockNode
+	startBits := params.BitcoinBtcdParams.PowLimitBits
+
+	// A new chain starts at the start node.
+	bhm, err := NewBitcoinHeaderManager(db, params, nil, 2)
+	require.NoError(err)
+	require.Equal(startNode.Hash, bhm.GetBestHeaderTip().Hash)
+	require.Equal([]*BlockHash{startNode.Hash}, bhm.GetBlockLocator())
+
+	// Headers are synced from a file.
+	headers := _buildTestBitcoinHeaders(params, startNode, startBits, 8, &BlockHash{})
+	var fileLines []string
+	fileLines = append(fileLines, "# Test headers", "")
+	for _, header := range headers {
+		headerBytes := bytes.Buffer{}
+		require.NoError(header.Serialize(&headerBytes))
+		fileLines = append(fileLines, hex.EncodeToString(headerBytes.Bytes()))
+	}
+	headerFilePath := filepath.Join(dir, "headers.txt")
+	require.NoError(ioutil.WriteFile(headerFilePath, []byte(strings.Join(fileLines, "\n")), 0644))
+	source, err := NewBitcoinHeaderSource(&BitcoinHeaderSourceConfig{
+		Type:     BitcoinHeaderSourceFile,
+		FilePath: headerFilePath,
+	}, params)
+	require.NoError(err)
+	bhm.source = source
+	numHeaders, err := bhm.SyncHeaders()
+	require.NoError(err)
+	require.Equal(8, numHeaders)
+	tip := bhm.GetBestHeaderTip()
+	require.Equal(uint32(108), tip.Height)
+	require.Equal((BlockHash)(headers[7].BlockHash()), *tip.Hash)
+	expectedWork := big.NewInt(0)
+	for _, header := range headers {
+		expectedWork.Add(expectedWork, btcdchain.CalcWork(header.Bits))
+	}
+	require.Zero(expectedWork.Cmp(tip.CumWork))
+	locator := bhm.GetBlockLocator()
+	require.Equal(tip.Hash, locator[0])
+	require.Equal(startNode.Hash, locator[len(locator)-1])
+
+	// Once we're caught up, the file has nothing left for us.
+	numHeaders, err = bhm.SyncHeaders()
+	require.NoError(err)
+	require.Equal(0, numHeaders)
+
+	// The chain is loaded back from the db.
+	bhm, err = NewBitcoinHeaderManager(db, params, nil, 2)
+	require.NoError(err)
+	require.Equal(tip.Hash, bhm.GetBestHeaderTip().Hash)
+	require.Zero(expectedWork.Cmp(bhm.GetBestHeaderTip().CumWork))
+	node, isInBestChain := bhm.GetHeaderNode(tip.Hash)
+	require.True(isInBestChain)
+	require.Equal(uint64(headers[7].Timestamp.Unix()), node.Header.TstampSecs)
+	require.Equal(uint32(101), node.Parent.Parent.Parent.Parent.Parent.Parent.Parent.Height)
+
+	// A fork with more work becomes the best chain.
+	forkParent, _ := bhm.GetHeaderNode((*BlockHash)(&headers[3].PrevBlock))
+	require.Equal(uint32(103), forkParent.Height)
+	forkHeaders := _buildTestBitcoinHeaders(params, forkParent, startBits, 7, &BlockHash{2})
+	numNewHeaders, err := bhm.ProcessHeaders(forkHeaders)
+	require.NoError(err)
+	require.Equal(7, numNewHeaders)
+	forkTip := bhm.GetBestHeaderTip()
+	require.Equal(uint32(110), forkTip.Height)
+	require.Equal((BlockHash)(forkHeaders[6].BlockHash()), *forkTip.Hash)
+	node, isInBestChain = bhm.GetHeaderNode(tip.Hash)
+	require.NotNil(node)
+	require.False(isInBestChain)
+	node, isInBestChain = bhm.GetHeaderNode((*BlockHash)(&headers[2].PrevBlock))
+	require.True(isInBestChain)
+	require.Equal(uint32(102), node.Height)
+	bhm, err = NewBitcoinHeaderManager(db, params, nil, 2)
+	require.NoError(err)
+	require.Equal(forkTip.Hash, bhm.GetBestHeaderTip().Hash)
+
+	// Processing headers we already have does nothing.
+	numNewHeaders, err = bhm.ProcessHeaders(headers)
+	require.NoError(err)
+	require.Equal(0, numNewHeaders)
+	require.Equal(forkTip.Hash, bhm.GetBestHeaderTip().Hash)
+
+	// Invalid headers are rejected, along with the rest of their batch.
+	forkTipBits := forkHeaders[6].Bits
+	nextTstampSecs := forkTip.Header.TstampSecs + 600
+	validHeader := _mineTestBitcoinHeader(forkTip.Hash, nextTstampSecs, forkTipBits, &BlockHash{})
+	validHash := (BlockHash)(validHeader.BlockHash())
+	unknownParentHeader := _mineTestBitcoinHeader(&BlockHash{3}, nextTstampSecs, forkTipBits, &BlockHash{})
+	wrongBitsHeader := _mineTestBitcoinHeader(forkTip.Hash, nextTstampSecs, 0x207ffffe, &BlockHash{})
+	earlyHeader := _mineTestBitcoinHeader(forkTip.Hash, _medianTstampSecs(forkTip), forkTipBits, &BlockHash{})
+	futureHeader := _mineTestBitcoinHeader(forkTip.Hash, uint64(time.Now().Add(3*time.Hour).Unix()),
+		forkTipBits, &BlockHash{})
+	unminedHeader := &wire.BlockHeader{
+		PrevBlock: chainhash.Hash(*forkTip.Hash),
+		Timestamp: time.Unix(int64(nextTstampSecs), 0),
+		Bits:      0x1d00ffff,
+	}
+	for _, testCase := range []struct {
+		header      *wire.BlockHeader
+		expectedErr RuleError
+	}{
+		{unknownParentHeader, HeaderErrorInvalidParent},
+		{wrongBitsHeader, HeaderErrorDifficultyBitsNotConsistentWithTargetDifficultyComputedFromParent},
+		{earlyHeader, HeaderErrorTimestampTooEarly},
+		{futureHeader, HeaderErrorBlockTooFarInTheFuture},
+		{unminedHeader, HeaderErrorBlockDifficultyAboveTarget},
+	} {
+		_, err = bhm.ProcessHeaders([]*wire.BlockHeader{validHeader, testCase.header})
+		require.Error(err)
+		require.Contains(err.Error(), testCase.expectedErr.Error())
+		node, _ = bhm.GetHeaderNode(&validHash)
+		require.Nil(node)
+	}
+	require.Nil(GetHeightHashToNodeInfo(db, nil, 111, &validHash, true))
+	require.Equal(forkTip.Hash, bhm.GetBestHeaderTip().Hash)
+
+	// The retarget is checked against the first block of the period.
+	retargetParent, _ := bhm.GetHeaderNode(forkTip.Parent.Parent.Parent.Hash)
+	require.Equal(uint32(107), retargetParent.Height)
+	_, err = bhm.ProcessHeaders([]*wire.BlockHeader{
+		_mineTestBitcoinHeader(retargetParent.Hash, retargetParent.Header.TstampSecs+600,
+			forkHeaders[3].Bits, &BlockHash{4}),
+	})
+	require.Error(err)
+	require.Contains(err.Error(), HeaderErrorDifficultyBitsNotConsistentWithTargetDifficultyComputedFromParent.Error())
+	require.NotEqual(forkHeaders[3].Bits, forkHeaders[4].Bits)
+}
+
+func TestBitcoinHeaderManagerValidateBitcoinExchange(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	params := _testBitcoinHeaderParams()
+	bhm, err := NewBitcoinHeaderManager(db, params, nil, 2)
+	require.NoError(err)
+
+	// A Bitcoin block with three txns, the second of which is the burn.
+	var bitcoinTxns []*wire.MsgTx
+	var serializedTxns [][]byte
+	for ii := 0; ii < 3; ii++ {
+		bitcoinTxn := wire.NewMsgTx(1)
+		bitcoinTxn.AddTxOut(wire.NewTxOut(int64(ii+1), []byte{byte(ii)}))
+		txnBytes := bytes.Buffer{}
+		require.NoError(bitcoinTxn.SerializeNoWitness(&txnBytes))
+		bitcoinTxns = append(bitcoinTxns, bitcoinTxn)
+		serializedTxns = append(serializedTxns, txnBytes.Bytes())
+	}
+	merkleTree := merkletree.NewTree(merkletree.Sha256DoubleHash, serializedTxns)
+	merkleRoot := NewBlockHash(merkleTree.Root.GetHash())
+	burnTxHash := bitcoinTxns[1].TxHash()
+	proof, err := merkleTree.CreateProof(burnTxHash[:])
+	require.NoError(err)
+
+	headers := _buildTestBitcoinHeaders(params, params.BitcoinStartBlockNode,
+		params.BitcoinBtcdParams.PowLimitBits, 1, merkleRoot)
+	_, err = bhm.ProcessHeaders(headers)
+	require.NoError(err)
+	blockHash := (BlockHash)(headers[0].BlockHash())
+	txnMeta := &BitcoinExchangeMetadata{
+		BitcoinTransaction: bitcoinTxns[1],
+		BitcoinBlockHash:   &blockHash,
+		BitcoinMerkleRoot:  merkleRoot,
+		BitcoinMerkleProof: proof.PathToRoot,
+	}
+
+	// The block needs a second confirmation.
+	err = bhm.ValidateBitcoinExchange(txnMeta)
+	require.Error(err)
+	require.Contains(err.Error(), RuleErrorBitcoinExchangeInsufficientConfirmations.Error())
+	tip, _ := bhm.GetHeaderNode(&blockHash)
+	_, err = bhm.ProcessHeaders(_buildTestBitcoinHeaders(params, tip, headers[0].Bits, 1, &BlockHash{}))
+	require.NoError(err)
+	require.NoError(bhm.ValidateBitcoinExchange(txnMeta))
+
+	// The proof has to be for the txn, and the root the block's.
+	badTxnMeta := *txnMeta
+	badTxnMeta.BitcoinTransaction = bitcoinTxns[0]
+	err = bhm.ValidateBitcoinExchange(&badTxnMeta)
+	require.Error(err)
+	require.Contains(err.Error(), RuleErrorBitcoinExchangeInvalidMerkleProof.Error())
+	badTxnMeta = *txnMeta
+	badTxnMeta.BitcoinMerkleRoot = &BlockHash{5}
+	err = bhm.ValidateBitcoinExchange(&badTxnMeta)
+	require.Error(err)
+	require.Contains(err.Error(), RuleErrorBitcoinExchangeHasBadMerkleRoot.Error())
+	badTxnMeta = *txnMeta
+	badTxnMeta.BitcoinBlockHash = &BlockHash{6}
+	err = bhm.ValidateBitcoinExchange(&badTxnMeta)
+	require.Error(err)
+	require.Contains(err.Error(), RuleErrorBitcoinExchangeBlockHashNotFoundInMainBitcoinChain.Error())
+}
+
+func TestBitcoinHeaderSources(t *testing.T) {
+	require := require.New(t)
+
+	params := _testBitcoinHeaderParams()
+	startNode := params.BitcoinStartBlockNode
+	headers := _buildTestBitcoinHeaders(params, startNode, params.BitcoinBtcdParams.PowLimitBits, 5, &BlockHash{})
+	headerHashes := []*BlockHash{startNode.Hash}
+	for _, header := range headers {
+		hash := (BlockHash)(header.BlockHash())
+		headerHashes = append(headerHashes, &hash)
+	}
+	expectedHeaders := func(actualHeaders []*wire.BlockHeader, startIndex int, endIndex int) {
+		require.Equal(endIndex-startIndex, len(actualHeaders))
+		for ii, header := range actualHeaders {
+			require.Equal(headers[startIndex+ii].BlockHash(), header.BlockHash())
+		}
+	}
+
+	// A bitcoind that has the start node and the headers in its best chain.
+	bitcoind := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		user, password, _ := request.BasicAuth()
+		require.Equal("user", user)
+		require.Equal("password", password)
+		rpcRequest := &bitcoinRPCRequest{}
+		require.NoError(json.NewDecoder(request.Body).Decode(rpcRequest))
+		response := map[string]interface{}{"result": nil, "error": nil}
+		notFound := map[string]interface{}{"code": bitcoinRPCErrorBlockNotFound, "message": "Block not found"}
+		switch rpcRequest.Method {
+		case "getblockheader":
+			response["error"] = notFound
+			for ii, hash := range headerHashes {
+				if (*chainhash.Hash)(hash).String() != rpcRequest.Params[0].(string) {
+					continue
+				}
+				response["error"] = nil
+				if rpcRequest.Params[1].(bool) {
+					response["result"] = map[string]interface{}{"height": int(startNode.Height) + ii, "confirmations": 1}
+				} else {
+					headerBytes := bytes.Buffer{}
+					require.NoError(headers[ii-1].Serialize(&headerBytes))
+					response["result"] = hex.EncodeToString(headerBytes.Bytes())
+				}
+			}
+		case "getblockhash":
+			index := int(rpcRequest.Params[0].(float64)) - int(startNode.Height)
+			if index < len(headerHashes) {
+				response["result"] = (*chainhash.Hash)(headerHashes[index]).String()
+			} else {
+				response["error"] = map[string]interface{}{"code": -8, "message": "Block height out of range"}
+			}
+		}
+		writer.WriteHeader(http.StatusOK)
+		require.NoError(json.NewEncoder(writer).Encode(response))
+	}))
+	defer bitcoind.Close()
+	rpcSource, err := NewBitcoinHeaderSource(&BitcoinHeaderSourceConfig{
+		Type:        BitcoinHeaderSourceRPC,
+		RPCURL:      bitcoind.URL,
+		RPCUser:     "user",
+		RPCPassword: "password",
+	}, params)
+	require.NoError(err)
+	defer rpcSource.Close()
+	rpcHeaders, err := rpcSource.GetHeaders([]*BlockHash{{7}, headerHashes[2], startNode.Hash}, 10)
+	require.NoError(err)
+	expectedHeaders(rpcHeaders, 2, 5)
+	rpcHeaders, err = rpcSource.GetHeaders([]*BlockHash{startNode.Hash}, 2)
+	require.NoError(err)
+	expectedHeaders(rpcHeaders, 0, 2)
+	_, err = rpcSource.GetHeaders([]*BlockHash{{7}}, 2)
+	require.Error(err)
+
+	// A Bitcoin peer that only knows the start node, and pings us before sending the headers.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	defer listener.Close()
+	btcNet := params.BitcoinBtcdParams.Net
+	peerErrs := make(chan error, 1)
+	go func() {
+		peerErrs <- func() error {
+			conn, err := listener.Accept()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			readMessage := func() (wire.Message, error) {
+				msg, _, err := wire.ReadMessage(conn, wire.ProtocolVersion, btcNet)
+				return msg, err
+			}
+			if msg, err := readMessage(); err != nil {
+				return err
+			} else if _, isVersion := msg.(*wire.MsgVersion); !isVersion {
+				return fmt.Errorf("expected version, got %v", msg.Command())
+			}
+			versionMsg := wire.NewMsgVersion(wire.NewNetAddressIPPort(net.IPv4zero, 0, 0),
+				wire.NewNetAddressIPPort(net.IPv4zero, 0, 0), 1, 0)
+			for _, msg := range []wire.Message{versionMsg, wire.NewMsgVerAck()} {
+				if err := wire.WriteMessage(conn, msg, wire.ProtocolVersion, btcNet); err != nil {
+					return err
+				}
+			}
+			if msg, err := readMessage(); err != nil {
+				return err
+			} else if _, isVerAck := msg.(*wire.MsgVerAck); !isVerAck {
+				return fmt.Errorf("expected verack, got %v", msg.Command())
+			}
+			msg, err := readMessage()
+			if err != nil {
+				return err
+			}
+			getHeadersMsg, isGetHeaders := msg.(*wire.MsgGetHeaders)
+			if !isGetHeaders {
+				return fmt.Errorf("expected getheaders, got %v", msg.Command())
+			}
+			if len(getHeadersMsg.BlockLocatorHashes) != 2 ||
+				*getHeadersMsg.BlockLocatorHashes[1] != chainhash.Hash(*startNode.Hash) {
+				return fmt.Errorf("unexpected locator %v", getHeadersMsg.BlockLocatorHashes)
+			}
+			if err := wire.WriteMessage(conn, wire.NewMsgPing(2), wire.ProtocolVersion, btcNet); err != nil {
+				return err
+			}
+			if msg, err := readMessage(); err != nil {
+				return err
+			} else if pong, isPong := msg.(*wire.MsgPong); !isPong || pong.Nonce != 2 {
+				return fmt.Errorf("expected pong, got %v", msg.Command())
+			}
+			headersMsg := wire.NewMsgHeaders()
+			for _, header := range headers {
+				if err := headersMsg.AddBlockHeader(header); err != nil {
+					return err
+				}
+			}
+			return wire.WriteMessage(conn, headersMsg, wire.ProtocolVersion, btcNet)
+		}()
+	}()
+	p2pSource, err := NewBitcoinHeaderSource(&BitcoinHeaderSourceConfig{
+		Type:     BitcoinHeaderSourceP2P,
+		PeerAddr: listener.Addr().String(),
+	}, params)
+	require.NoError(err)
+	defer p2pSource.Close()
+	p2pHeaders, err := p2pSource.GetHeaders([]*BlockHash{{7}, startNode.Hash}, 4)
+	require.NoError(err)
+	require.NoError(<-peerErrs)
+	expectedHeaders(p2pHeaders, 0, 4)
+
+	// Sources have to be configured fully.
+	for _, config := range []*BitcoinHeaderSourceConfig{
+		{Type: BitcoinHeaderSourceP2P},
+		{Type: BitcoinHeaderSourceRPC},
+		{Type: BitcoinHeaderSourceFile},
+		{Type: "electrum"},
+	} {
+		_, err = NewBitcoinHeaderSource(config, params)
+		require.Error(err)
+	}
+	noSource, err := NewBitcoinHeaderSource(&BitcoinHeaderSourceConfig{}, params)
+	require.NoError(err)
+	require.Nil(noSource)
+}
@@ -0,0 +1,268 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// block_caches.go persists the orphan blocks and the blocks that failed to connect, so that a
+// node doesn't have to fetch its orphans again or reconnect blocks it already knows are invalid
+// after a restart. Both caches are only kept in badger, so a node running on postgres has its
+// orphans in memory only and doesn't remember invalid blocks.
+
+// _loadOrphanBlocks fills the orphan list with the orphans in the db. Orphans whose block was
+// processed since they were stored are deleted instead.
+func (bc *Blockchain) _loadOrphanBlocks() error {
+	if bc.postgres != nil {
+		return nil
+	}
+	orphanBlocks, err := DbGetOrphanBlocks(bc.db)
+	if err != nil {
+		return errors.Wrapf(err, "_loadOrphanBlocks: ")
+	}
+	for _, orphanBlock := range orphanBlocks {
+		blockNode, exists := bc.blockIndex[*orphanBlock.Hash]
+		if (exists && (blockNode.Status&StatusBlockProcessed) != 0) ||
+			bc.orphanList.Len() >= MaxOrphansInMemory {
+
+			if err = bc._deleteOrphanBlockFromDb(orphanBlock); err != nil {
+				return errors.Wrapf(err, "_loadOrphanBlocks: ")
+			}
+			continue
+		}
+		bc.orphanList.PushBack(orphanBlock)
+	}
+	return nil
+}
+
+func (bc *Blockchain) _putOrphanBlockInDb(orphanBlock *OrphanBlock) error {
+	if bc.postgres != nil {
+		return nil
+	}
+	return bc.db.Update(func(txn *badger.Txn) error {
+		return DbPutOrphanBlockWithTxn(txn, orphanBlock.Hash, orphanBlock.Block)
+	})
+}
+
+func (bc *Blockchain) _deleteOrphanBlockFromDb(orphanBlock *OrphanBlock) error {
+	if bc.postgres != nil {
+		return nil
+	}
+	return bc.db.Update(func(txn *badger.Txn) error {
+		return DbDeleteOrphanBlockWithTxn(txn, orphanBlock.Block.Header.Height, orphanBlock.Hash)
+	})
+}
+
+// _removeOrphanBlockElem removes the orphan from the orphan list and from the db. A failure to
+// delete it from the db is only logged, since the orphan is deleted when it's loaded at startup
+// if its block was processed by then.
+func (bc *Blockchain) _removeOrphanBlockElem(orphanElem *list.Element) *OrphanBlock {
+	orphanBlock := bc.orphanList.Remove(orphanElem).(*OrphanBlock)
+	if err := bc._deleteOrphanBlockFromDb(orphanBlock); err != nil {
+		glog.Errorf("_removeOrphanBlockElem: Problem deleting orphan block %v: %v", orphanBlock.Hash, err)
+	}
+	return orphanBlock
+}
+
+// _lowestOrphanBlockElem returns the orphan with the lowest height, which is the one that's
+// evicted when the orphan list is full.
+func (bc *Blockchain) _lowestOrphanBlockElem() *list.Element {
+	var lowestElem *list.Element
+	for orphanElem := bc.orphanList.Front(); orphanElem != nil; orphanElem = orphanElem.Next() {
+		if lowestElem == nil || orphanElem.Value.(*OrphanBlock).Block.Header.Height <
+			lowestElem.Value.(*OrphanBlock).Block.Header.Height {
+
+			lowestElem = orphanElem
+		}
+	}
+	return lowestElem
+}
+
+// _removeOrphanBlock removes the orphan with the given hash, if we have it.
+func (bc *Blockchain) _removeOrphanBlock(blockHash *BlockHash) {
+	for orphanElem := bc.orphanList.Front(); orphanElem != nil; orphanElem = orphanElem.Next() {
+		if *orphanElem.Value.(*OrphanBlock).Hash == *blockHash {
+			bc._removeOrphanBlockElem(orphanElem)
+			return
+		}
+	}
+}
+
+// _processOrphanChildren processes the orphans that were waiting for the block with the given
+// hash, and then the orphans that were waiting for them, and so on. Orphans that fail are
+// dropped, and those that are still orphans are kept.
+func (bc *Blockchain) _processOrphanChildren(parentHash *BlockHash, verifySignatures bool) {
+	parentHashes := []*BlockHash{parentHash}
+	for len(parentHashes) > 0 {
+		currentParentHash := parentHashes[0]
+		parentHashes = parentHashes[1:]
+
+		var children []*OrphanBlock
+		for orphanElem := bc.orphanList.Front(); orphanElem != nil; {
+			nextElem := orphanElem.Next()
+			if *orphanElem.Value.(*OrphanBlock).Block.Header.PrevBlockHash == *currentParentHash {
+				children = append(children, bc._removeOrphanBlockElem(orphanElem))
+			}
+			orphanElem = nextElem
+		}
+
+		for _, child := range children {
+			_, isOrphan, err := bc.processBlock(child.Block, verifySignatures)
+			if err != nil {
+				glog.Errorf("_processOrphanChildren: Problem processing orphan block %v: %v", child.Hash, err)
+				continue
+			}
+			// processBlock stores the child again if it's still an orphan.
+			if isOrphan {
+				continue
+			}
+			glog.V(1).Infof("_processOrphanChildren: Processed orphan block %v", child.Hash)
+			parentHashes = append(parentHashes, child.Hash)
+		}
+	}
+}
+
+// GetOrphanBlocks returns the orphan blocks we're holding on to, ordered by height.
+func (bc *Blockchain) GetOrphanBlocks() []*OrphanBlock {
+	bc.ChainLock.RLock()
+	defer bc.ChainLock.RUnlock()
+
+	orphanBlocks := []*OrphanBlock{}
+	for orphanElem := bc.orphanList.Front(); orphanElem != nil; orphanElem = orphanElem.Next() {
+		orphanBlocks = append(orphanBlocks, orphanElem.Value.(*OrphanBlock))
+	}
+	sort.SliceStable(orphanBlocks, func(ii, jj int) bool {
+		return orphanBlocks[ii].Block.Header.Height < orphanBlocks[jj].Block.Header.Height
+	})
+	return orphanBlocks
+}
+
+// PruneOrphanBlocks drops the orphans at or below maxHeight and returns how many were dropped.
+func (bc *Blockchain) PruneOrphanBlocks(maxHeight uint64) int {
+	bc.ChainLock.Lock()
+	defer bc.ChainLock.Unlock()
+
+	numPruned := 0
+	for orphanElem := bc.orphanList.Front(); orphanElem != nil; {
+		nextElem := orphanElem.Next()
+		if orphanElem.Value.(*OrphanBlock).Block.Header.Height <= maxHeight {
+			bc._removeOrphanBlockElem(orphanElem)
+			numPruned++
+		}
+		orphanElem = nextElem
+	}
+	return numPruned
+}
+
+// _recordInvalidBlock records that the block failed to connect with the RuleError. Recording is
+// best-effort, since the block is rejected either way.
+func (bc *Blockchain) _recordInvalidBlock(node *BlockNode, ruleErr error) {
+	if bc.postgres != nil {
+		return
+	}
+	entry := &InvalidBlockEntry{
+		Hash:           node.Hash,
+		Height:         uint64(node.Height),
+		RuleError:      RuleError(ruleErr.Error()),
+		ErrorMessage:   ruleErr.Error(),
+		TimestampNanos: uint64(time.Now().UnixNano()),
+	}
+	if ruleError, ok := errors.Cause(ruleErr).(RuleError); ok {
+		entry.RuleError = ruleError
+	}
+	err := bc.db.Update(func(txn *badger.Txn) error {
+		return DbPutInvalidBlockEntryWithTxn(txn, entry)
+	})
+	if err != nil {
+		glog.Errorf("_recordInvalidBlock: Problem recording invalid block %v: %v", node.Hash, err)
+	}
+}
+
+// _getInvalidBlock returns nil if the block isn't known to be invalid.
+func (bc *Blockchain) _getInvalidBlock(blockHash *BlockHash) *InvalidBlockEntry {
+	if bc.postgres != nil {
+		return nil
+	}
+	entry, err := DbGetInvalidBlockEntry(bc.db, blockHash)
+	if err != nil {
+		glog.Errorf("_getInvalidBlock: %v", err)
+		return nil
+	}
+	return entry
+}
+
+// GetInvalidBlock returns why the block failed to connect, or nil if it isn't known to be invalid.
+func (bc *Blockchain) GetInvalidBlock(blockHash *BlockHash) (*InvalidBlockEntry, error) {
+	if bc.postgres != nil {
+		return nil, nil
+	}
+	return DbGetInvalidBlockEntry(bc.db, blockHash)
+}
+
+// GetInvalidBlocks returns the blocks that are known to be invalid, ordered by height.
+func (bc *Blockchain) GetInvalidBlocks() ([]*InvalidBlockEntry, error) {
+	if bc.postgres != nil {
+		return nil, nil
+	}
+	entries, err := DbGetInvalidBlockEntries(bc.db)
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetInvalidBlocks: ")
+	}
+	sort.SliceStable(entries, func(ii, jj int) bool {
+		return entries[ii].Height < entries[jj].Height
+	})
+	return entries, nil
+}
+
+// RemoveInvalidBlock forgets that the block is invalid, so that it's connected again the next
+// time we get it. This is useful if a block was rejected because of a bug that has been fixed.
+func (bc *Blockchain) RemoveInvalidBlock(blockHash *BlockHash) error {
+	if bc.postgres != nil {
+		return fmt.Errorf("RemoveInvalidBlock: Invalid blocks aren't recorded with postgres")
+	}
+	return bc.db.Update(func(txn *badger.Txn) error {
+		return DbDeleteInvalidBlockEntryWithTxn(txn, blockHash)
+	})
+}
+
+// PruneInvalidBlocks forgets the invalid blocks at or below maxHeight and returns how many there were.
+func (bc *Blockchain) PruneInvalidBlocks(maxHeight uint64) (int, error) {
+	entries, err := bc.GetInvalidBlocks()
+	if err != nil {
+		return 0, errors.Wrapf(err, "PruneInvalidBlocks: ")
+	}
+	numPruned := 0
+	err = bc.db.Update(func(txn *badger.Txn) error {
+		for _, entry := range entries {
+			if entry.Height > maxHeight {
+				break
+			}
+			if err := DbDeleteInvalidBlockEntryWithTxn(txn, entry.Hash); err != nil {
+				return err
+			}
+			numPruned++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "PruneInvalidBlocks: ")
+	}
+	return numPruned, nil
+}
+
+// _storeOrphanBlock keeps the orphan until its parent is processed. Failing to keep it is only
+// logged, since we can always fetch the block again.
+func (bc *Blockchain) _storeOrphanBlock(desoBlock *MsgDeSoBlock, blockHash *BlockHash) {
+	err := bc.ProcessOrphanBlock(desoBlock, blockHash)
+	if err != nil && errors.Cause(err) != RuleErrorDuplicateOrphan {
+		glog.Errorf("_storeOrphanBlock: Problem storing orphan block %v: %v", blockHash, err)
+	}
+}
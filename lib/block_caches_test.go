@@ -0,0 +1,115 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"container/list"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrphanAndInvalidBlockCaches(t *testing.T) {
+	require := require.New(t)
+
+	_, _, blockB1, blockB2, blockB3, blockB4, _ := getForkedChain(t)
+	chain, _, db := NewLowDifficultyBlockchain()
+	if chain.postgres != nil {
+		t.Skip("Orphan and invalid blocks are only persisted in badger")
+	}
+	blockHashB2, err := blockB2.Hash()
+	require.NoError(err)
+	blockHashB3, err := blockB3.Hash()
+	require.NoError(err)
+	blockHashB4, err := blockB4.Hash()
+	require.NoError(err)
+
+	// Blocks whose parent we don't have are stored as orphans.
+	_, isOrphan, err := chain.ProcessBlock(blockB2, true)
+	require.NoError(err)
+	require.True(isOrphan)
+	_, isOrphan, err = chain.ProcessBlock(blockB4, true)
+	require.NoError(err)
+	require.True(isOrphan)
+	orphanBlocks := chain.GetOrphanBlocks()
+	require.Equal(2, len(orphanBlocks))
+	require.Equal(*blockHashB2, *orphanBlocks[0].Hash)
+	require.Equal(*blockHashB4, *orphanBlocks[1].Hash)
+
+	// The orphans survive a restart.
+	chain.orphanList = list.New()
+	require.NoError(chain._loadOrphanBlocks())
+	require.Equal(orphanBlocks, chain.GetOrphanBlocks())
+
+	// Pruning drops the orphans at or below the height.
+	require.Equal(0, chain.PruneOrphanBlocks(blockB2.Header.Height-1))
+	require.Equal(1, chain.PruneOrphanBlocks(blockB2.Header.Height))
+	dbOrphanBlocks, err := DbGetOrphanBlocks(db)
+	require.NoError(err)
+	require.Equal(1, len(dbOrphanBlocks))
+	require.Equal(*blockHashB4, *dbOrphanBlocks[0].Hash)
+
+	// An orphan is processed once its parent is.
+	_, isOrphan, err = chain.ProcessBlock(blockB2, true)
+	require.NoError(err)
+	require.True(isOrphan)
+	isMainChain, isOrphan, err := chain.ProcessBlock(blockB1, true)
+	require.NoError(err)
+	require.True(isMainChain)
+	require.False(isOrphan)
+	require.Equal(*blockHashB2, *chain.blockTip().Hash)
+	orphanBlocks = chain.GetOrphanBlocks()
+	require.Equal(1, len(orphanBlocks))
+	require.Equal(*blockHashB4, *orphanBlocks[0].Hash)
+
+	// A block that's known to be invalid is rejected without connecting it.
+	chain._recordInvalidBlock(&BlockNode{Hash: blockHashB3, Height: uint32(blockB3.Header.Height)},
+		errors.Wrapf(RuleErrorTxnMustHaveAtLeastOneInput, "ConnectBlock: "))
+	invalidBlock, err := chain.GetInvalidBlock(blockHashB3)
+	require.NoError(err)
+	require.Equal(RuleErrorTxnMustHaveAtLeastOneInput, invalidBlock.RuleError)
+	require.Equal(blockB3.Header.Height, invalidBlock.Height)
+	_, _, err = chain.ProcessBlock(blockB3, true)
+	require.Error(err)
+	require.Equal(RuleErrorKnownInvalidBlock, errors.Cause(err))
+
+	// Once it's forgotten the block connects, and so does the orphan that was waiting for it.
+	invalidBlocks, err := chain.GetInvalidBlocks()
+	require.NoError(err)
+	require.Equal(1, len(invalidBlocks))
+	numPruned, err := chain.PruneInvalidBlocks(blockB3.Header.Height - 1)
+	require.NoError(err)
+	require.Zero(numPruned)
+	numPruned, err = chain.PruneInvalidBlocks(blockB3.Header.Height)
+	require.NoError(err)
+	require.Equal(1, numPruned)
+	invalidBlock, err = chain.GetInvalidBlock(blockHashB3)
+	require.NoError(err)
+	require.Nil(invalidBlock)
+	isMainChain, isOrphan, err = chain.ProcessBlock(blockB3, true)
+	require.NoError(err)
+	require.True(isMainChain)
+	require.False(isOrphan)
+	require.Equal(*blockHashB4, *chain.blockTip().Hash)
+	require.Empty(chain.GetOrphanBlocks())
+	dbOrphanBlocks, err = DbGetOrphanBlocks(db)
+	require.NoError(err)
+	require.Empty(dbOrphanBlocks)
+}
+
+func TestInvalidBlockEntryEncoding(t *testing.T) {
+	require := require.New(t)
+
+	entry := &InvalidBlockEntry{
+		Hash:           &BlockHash{0x01, 0x02},
+		Height:         1234,
+		RuleError:      RuleErrorInvalidTxnMerkleRoot,
+		ErrorMessage:   "ConnectBlock: RuleErrorInvalidTxnMerkleRoot",
+		TimestampNanos: 5678,
+	}
+	decodedEntry := &InvalidBlockEntry{}
+	require.NoError(decodedEntry.FromBytes(entry.ToBytes()))
+	require.Equal(entry, decodedEntry)
+	require.Error(decodedEntry.FromBytes([]byte{0x01}))
+}
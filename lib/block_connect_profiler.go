@@ -0,0 +1,314 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// Block connect profiling is opt-in. When it's enabled, every block connected to the tip gets a
+// BlockConnectProfile that times the phases of the block connect, i.e. signature checks, the view
+// connect of each txn type and the flush of each db prefix. Profiles are persisted under
+// PrefixBlockConnectProfileByHeightAndHash so that the cost of new txn types can be measured under
+// real load. Profiling adds a few clock reads per txn and per db write, so it's kept off by default.
+var blockConnectProfilingEnabled int32
+
+// EnableBlockConnectProfiling turns block connect profiling on or off.
+func EnableBlockConnectProfiling(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&blockConnectProfilingEnabled, 1)
+	} else {
+		atomic.StoreInt32(&blockConnectProfilingEnabled, 0)
+	}
+}
+
+func IsBlockConnectProfilingEnabled() bool {
+	return atomic.LoadInt32(&blockConnectProfilingEnabled) == 1
+}
+
+// blockConnectProfilesByTxn maps a *badger.Txn to the *BlockConnectProfile timing its flush.
+var blockConnectProfilesByTxn sync.Map
+
+// BlockConnectProfile holds the time spent in each phase of connecting one block. All the methods
+// are safe to call on a nil profile, which is what callers get when profiling is disabled.
+type BlockConnectProfile struct {
+	BlockHash   *BlockHash
+	BlockHeight uint64
+
+	// ConnectNanos is the time spent in UtxoView.ConnectBlock. It includes TxnConnectNanosByType,
+	// which in turn includes SignatureCheckNanos.
+	ConnectNanos        uint64
+	SignatureCheckNanos uint64
+	NumSignatureChecks  uint64
+
+	TxnConnectNanosByType map[TxnType]uint64
+	NumTxnsByType         map[TxnType]uint64
+
+	// FlushNanos is the time spent flushing the view to the db. It includes FlushNanosByPrefix.
+	FlushNanos          uint64
+	FlushNanosByPrefix  map[byte]uint64
+	NumFlushOpsByPrefix map[byte]uint64
+
+	// flushTxn is the txn the profile is attributing db writes to, if any.
+	flushTxn *badger.Txn
+}
+
+// NewBlockConnectProfile returns nil unless block connect profiling is enabled.
+func NewBlockConnectProfile(blockHash *BlockHash, blockHeight uint64) *BlockConnectProfile {
+	if !IsBlockConnectProfilingEnabled() {
+		return nil
+	}
+	return &BlockConnectProfile{
+		BlockHash:             blockHash,
+		BlockHeight:           blockHeight,
+		TxnConnectNanosByType: make(map[TxnType]uint64),
+		NumTxnsByType:         make(map[TxnType]uint64),
+		FlushNanosByPrefix:    make(map[byte]uint64),
+		NumFlushOpsByPrefix:   make(map[byte]uint64),
+	}
+}
+
+func (profile *BlockConnectProfile) RecordConnect(startTime time.Time) {
+	if profile == nil {
+		return
+	}
+	profile.ConnectNanos += uint64(time.Since(startTime).Nanoseconds())
+}
+
+func (profile *BlockConnectProfile) RecordSignatureCheck(startTime time.Time) {
+	if profile == nil {
+		return
+	}
+	profile.SignatureCheckNanos += uint64(time.Since(startTime).Nanoseconds())
+	profile.NumSignatureChecks++
+}
+
+func (profile *BlockConnectProfile) RecordTxnConnect(txnType TxnType, startTime time.Time) {
+	if profile == nil {
+		return
+	}
+	profile.TxnConnectNanosByType[txnType] += uint64(time.Since(startTime).Nanoseconds())
+	profile.NumTxnsByType[txnType]++
+}
+
+// TrackFlushTxn makes the profile time every db write and delete made in the txn, by prefix,
+// until EndFlush is called. A profile can only track one txn.
+func (profile *BlockConnectProfile) TrackFlushTxn(txn *badger.Txn) {
+	if profile == nil || txn == nil || profile.flushTxn != nil {
+		return
+	}
+	profile.flushTxn = txn
+	blockConnectProfilesByTxn.Store(txn, profile)
+}
+
+// EndFlush stops tracking the flush txn and records the total time spent flushing.
+func (profile *BlockConnectProfile) EndFlush(startTime time.Time) {
+	if profile == nil {
+		return
+	}
+	if profile.flushTxn != nil {
+		blockConnectProfilesByTxn.Delete(profile.flushTxn)
+		profile.flushTxn = nil
+	}
+	profile.FlushNanos += uint64(time.Since(startTime).Nanoseconds())
+}
+
+func (profile *BlockConnectProfile) recordFlushOp(key []byte, startTime time.Time) {
+	profile.FlushNanosByPrefix[key[0]] += uint64(time.Since(startTime).Nanoseconds())
+	profile.NumFlushOpsByPrefix[key[0]]++
+}
+
+// _blockConnectProfileForTxn is called by the db write wrappers. It returns nil unless profiling is
+// enabled and some profile tracks the txn.
+func _blockConnectProfileForTxn(txn *badger.Txn, key []byte) *BlockConnectProfile {
+	if !IsBlockConnectProfilingEnabled() || len(key) == 0 {
+		return nil
+	}
+	if profile, exists := blockConnectProfilesByTxn.Load(txn); exists {
+		return profile.(*BlockConnectProfile)
+	}
+	return nil
+}
+
+func (profile *BlockConnectProfile) ToBytes() []byte {
+	data := append([]byte{}, profile.BlockHash[:]...)
+	data = append(data, UintToBuf(profile.BlockHeight)...)
+	data = append(data, UintToBuf(profile.ConnectNanos)...)
+	data = append(data, UintToBuf(profile.SignatureCheckNanos)...)
+	data = append(data, UintToBuf(profile.NumSignatureChecks)...)
+
+	// Map entries are sorted by key so that the encoding is deterministic.
+	txnTypes := []TxnType{}
+	for txnType := range profile.TxnConnectNanosByType {
+		txnTypes = append(txnTypes, txnType)
+	}
+	sort.Slice(txnTypes, func(ii, jj int) bool { return txnTypes[ii] < txnTypes[jj] })
+	data = append(data, UintToBuf(uint64(len(txnTypes)))...)
+	for _, txnType := range txnTypes {
+		data = append(data, byte(txnType))
+		data = append(data, UintToBuf(profile.TxnConnectNanosByType[txnType])...)
+		data = append(data, UintToBuf(profile.NumTxnsByType[txnType])...)
+	}
+
+	data = append(data, UintToBuf(profile.FlushNanos)...)
+	prefixes := []byte{}
+	for prefix := range profile.FlushNanosByPrefix {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(ii, jj int) bool { return prefixes[ii] < prefixes[jj] })
+	data = append(data, UintToBuf(uint64(len(prefixes)))...)
+	for _, prefix := range prefixes {
+		data = append(data, prefix)
+		data = append(data, UintToBuf(profile.FlushNanosByPrefix[prefix])...)
+		data = append(data, UintToBuf(profile.NumFlushOpsByPrefix[prefix])...)
+	}
+
+	return data
+}
+
+func (profile *BlockConnectProfile) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+	var err error
+
+	profile.BlockHash = &BlockHash{}
+	if _, err = io.ReadFull(rr, profile.BlockHash[:]); err != nil {
+		return errors.Wrapf(err, "BlockConnectProfile.FromBytes: Problem reading BlockHash")
+	}
+	if profile.BlockHeight, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "BlockConnectProfile.FromBytes: Problem reading BlockHeight")
+	}
+	if profile.ConnectNanos, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "BlockConnectProfile.FromBytes: Problem reading ConnectNanos")
+	}
+	if profile.SignatureCheckNanos, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "BlockConnectProfile.FromBytes: Problem reading SignatureCheckNanos")
+	}
+	if profile.NumSignatureChecks, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "BlockConnectProfile.FromBytes: Problem reading NumSignatureChecks")
+	}
+
+	numTxnTypes, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "BlockConnectProfile.FromBytes: Problem reading number of txn types")
+	}
+	profile.TxnConnectNanosByType = make(map[TxnType]uint64)
+	profile.NumTxnsByType = make(map[TxnType]uint64)
+	for ii := uint64(0); ii < numTxnTypes; ii++ {
+		txnType, err := rr.ReadByte()
+		if err != nil {
+			return errors.Wrapf(err, "BlockConnectProfile.FromBytes: Problem reading txn type")
+		}
+		if profile.TxnConnectNanosByType[TxnType(txnType)], err = ReadUvarint(rr); err != nil {
+			return errors.Wrapf(err, "BlockConnectProfile.FromBytes: Problem reading txn connect nanos")
+		}
+		if profile.NumTxnsByType[TxnType(txnType)], err = ReadUvarint(rr); err != nil {
+			return errors.Wrapf(err, "BlockConnectProfile.FromBytes: Problem reading number of txns")
+		}
+	}
+
+	if profile.FlushNanos, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "BlockConnectProfile.FromBytes: Problem reading FlushNanos")
+	}
+	numPrefixes, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "BlockConnectProfile.FromBytes: Problem reading number of prefixes")
+	}
+	profile.FlushNanosByPrefix = make(map[byte]uint64)
+	profile.NumFlushOpsByPrefix = make(map[byte]uint64)
+	for ii := uint64(0); ii < numPrefixes; ii++ {
+		prefix, err := rr.ReadByte()
+		if err != nil {
+			return errors.Wrapf(err, "BlockConnectProfile.FromBytes: Problem reading prefix")
+		}
+		if profile.FlushNanosByPrefix[prefix], err = ReadUvarint(rr); err != nil {
+			return errors.Wrapf(err, "BlockConnectProfile.FromBytes: Problem reading prefix flush nanos")
+		}
+		if profile.NumFlushOpsByPrefix[prefix], err = ReadUvarint(rr); err != nil {
+			return errors.Wrapf(err, "BlockConnectProfile.FromBytes: Problem reading number of flush ops")
+		}
+	}
+
+	return nil
+}
+
+// BlockConnectPhaseSummary aggregates the time spent in one phase of block connect over many blocks.
+type BlockConnectPhaseSummary struct {
+	// Count is the number of times the phase ran, e.g. the number of txns of a type.
+	Count      uint64
+	TotalNanos uint64
+	// MaxBlockNanos is the most time the phase took in a single block.
+	MaxBlockNanos uint64
+}
+
+func (summary *BlockConnectPhaseSummary) add(count uint64, nanos uint64) {
+	summary.Count += count
+	summary.TotalNanos += nanos
+	if nanos > summary.MaxBlockNanos {
+		summary.MaxBlockNanos = nanos
+	}
+}
+
+// AverageNanos returns the average time the phase took each time it ran.
+func (summary *BlockConnectPhaseSummary) AverageNanos() uint64 {
+	if summary.Count == 0 {
+		return 0
+	}
+	return summary.TotalNanos / summary.Count
+}
+
+type BlockConnectProfileSummary struct {
+	NumBlocks       uint64
+	Connect         BlockConnectPhaseSummary
+	SignatureChecks BlockConnectPhaseSummary
+	Flush           BlockConnectPhaseSummary
+
+	TxnConnectByType map[TxnType]*BlockConnectPhaseSummary
+	FlushByPrefix    map[byte]*BlockConnectPhaseSummary
+}
+
+// SummarizeBlockConnectProfiles aggregates the phases of the profiles. Block-level phases count
+// blocks, while signature checks, txn types and prefixes count signatures, txns and db ops.
+func SummarizeBlockConnectProfiles(profiles []*BlockConnectProfile) *BlockConnectProfileSummary {
+	summary := &BlockConnectProfileSummary{
+		TxnConnectByType: make(map[TxnType]*BlockConnectPhaseSummary),
+		FlushByPrefix:    make(map[byte]*BlockConnectPhaseSummary),
+	}
+	for _, profile := range profiles {
+		summary.NumBlocks++
+		summary.Connect.add(1, profile.ConnectNanos)
+		summary.SignatureChecks.add(profile.NumSignatureChecks, profile.SignatureCheckNanos)
+		summary.Flush.add(1, profile.FlushNanos)
+
+		for txnType, nanos := range profile.TxnConnectNanosByType {
+			if _, exists := summary.TxnConnectByType[txnType]; !exists {
+				summary.TxnConnectByType[txnType] = &BlockConnectPhaseSummary{}
+			}
+			summary.TxnConnectByType[txnType].add(profile.NumTxnsByType[txnType], nanos)
+		}
+		for prefix, nanos := range profile.FlushNanosByPrefix {
+			if _, exists := summary.FlushByPrefix[prefix]; !exists {
+				summary.FlushByPrefix[prefix] = &BlockConnectPhaseSummary{}
+			}
+			summary.FlushByPrefix[prefix].add(profile.NumFlushOpsByPrefix[prefix], nanos)
+		}
+	}
+	return summary
+}
+
+// DbGetBlockConnectProfileSummary summarizes the profiles of the blocks with heights in
+// [startHeight, endHeight].
+func DbGetBlockConnectProfileSummary(handle *badger.DB, startHeight uint64, endHeight uint64) (
+	*BlockConnectProfileSummary, error) {
+
+	profiles, err := DbGetBlockConnectProfiles(handle, startHeight, endHeight)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetBlockConnectProfileSummary: ")
+	}
+	return SummarizeBlockConnectProfiles(profiles), nil
+}
@@ -0,0 +1,86 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockConnectProfileEncoding(t *testing.T) {
+	require := require.New(t)
+
+	profile := &BlockConnectProfile{
+		BlockHash:             NewBlockHash(RandomBytes(HashSizeBytes)),
+		BlockHeight:           123456,
+		ConnectNanos:          1000,
+		SignatureCheckNanos:   300,
+		NumSignatureChecks:    3,
+		TxnConnectNanosByType: map[TxnType]uint64{TxnTypeBlockReward: 100, TxnTypeBasicTransfer: 800},
+		NumTxnsByType:         map[TxnType]uint64{TxnTypeBlockReward: 1, TxnTypeBasicTransfer: 3},
+		FlushNanos:            500,
+		FlushNanosByPrefix:    map[byte]uint64{5: 200, 9: 100},
+		NumFlushOpsByPrefix:   map[byte]uint64{5: 4, 9: 1},
+	}
+	decodedProfile := &BlockConnectProfile{}
+	require.NoError(decodedProfile.FromBytes(profile.ToBytes()))
+	require.Equal(profile, decodedProfile)
+
+	// The encoding doesn't depend on map iteration order.
+	require.Equal(profile.ToBytes(), decodedProfile.ToBytes())
+
+	// Truncated profiles fail to decode.
+	profileBytes := profile.ToBytes()
+	require.Error((&BlockConnectProfile{}).FromBytes(profileBytes[:len(profileBytes)-1]))
+}
+
+func TestBlockConnectProfiling(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+
+	// Nothing is profiled while profiling is disabled.
+	_, err := miner.MineAndProcessSingleBlock(0, mempool)
+	require.NoError(err)
+	profiles, err := DbGetBlockConnectProfiles(db, 0, 100)
+	require.NoError(err)
+	require.Empty(profiles)
+
+	EnableBlockConnectProfiling(true)
+	defer EnableBlockConnectProfiling(false)
+
+	minedBlockHeights := []uint64{}
+	for ii := 0; ii < 3; ii++ {
+		block, err := miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+		minedBlockHeights = append(minedBlockHeights, block.Header.Height)
+	}
+
+	// Every block connected while profiling is enabled has a profile, in height order.
+	profiles, err = DbGetBlockConnectProfiles(db, 0, 100)
+	require.NoError(err)
+	require.Equal(3, len(profiles))
+	for ii, profile := range profiles {
+		require.Equal(minedBlockHeights[ii], profile.BlockHeight)
+		require.Equal(uint64(1), profile.NumTxnsByType[TxnTypeBlockReward])
+		require.NotEmpty(profile.NumFlushOpsByPrefix)
+		require.Greater(profile.ConnectNanos, uint64(0))
+		require.Greater(profile.FlushNanos, uint64(0))
+	}
+
+	// The height range is inclusive.
+	profiles, err = DbGetBlockConnectProfiles(db, minedBlockHeights[1], minedBlockHeights[1])
+	require.NoError(err)
+	require.Equal(1, len(profiles))
+	require.Equal(minedBlockHeights[1], profiles[0].BlockHeight)
+
+	summary, err := DbGetBlockConnectProfileSummary(db, 0, 100)
+	require.NoError(err)
+	require.Equal(uint64(3), summary.NumBlocks)
+	require.Equal(uint64(3), summary.Connect.Count)
+	require.Equal(uint64(3), summary.TxnConnectByType[TxnTypeBlockReward].Count)
+	require.LessOrEqual(summary.Connect.MaxBlockNanos, summary.Connect.TotalNanos)
+	require.Equal(summary.Connect.TotalNanos/3, summary.Connect.AverageNanos())
+}
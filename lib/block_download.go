@@ -0,0 +1,185 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"time"
+)
+
+// block_download.go implements the block download pipeline we use while syncing blocks. Once the
+// header chain is synced, the blocks of the best header chain are requested from several peers at
+// once rather than from the sync peer only. Blocks can then arrive out of order, so the ones whose
+// parent hasn't been connected yet are staged by height until it is, and blocks are always
+// connected in order. Only blocks within BlockDownloadWindow of the block tip are requested, which
+// bounds the staging area. A peer that holds up the window by not sending the next block we need
+// is a staller, and is disconnected so that the block can be requested from another peer.
+//
+// The BlockDownloader is only accessed from the Server's message handler thread, like SyncPeer.
+
+type blockDownloadRequest struct {
+	Hash          *BlockHash
+	Height        uint32
+	Peer          *Peer
+	TimeRequested time.Time
+}
+
+type stagedBlock struct {
+	Block *MsgDeSoBlock
+	Hash  *BlockHash
+	Peer  *Peer
+}
+
+type BlockDownloader struct {
+	maxPeers     int
+	window       uint32
+	stallTimeout time.Duration
+
+	// inFlight holds the blocks we requested and haven't received yet.
+	inFlight map[BlockHash]*blockDownloadRequest
+	// staged holds the blocks we received before their parent was connected, keyed by height.
+	staged map[uint32]*stagedBlock
+}
+
+// NewBlockDownloader returns a BlockDownloader that downloads blocks from up to maxPeers peers.
+func NewBlockDownloader(maxPeers int, window uint32, stallTimeout time.Duration) *BlockDownloader {
+	return &BlockDownloader{
+		maxPeers:     maxPeers,
+		window:       window,
+		stallTimeout: stallTimeout,
+		inFlight:     make(map[BlockHash]*blockDownloadRequest),
+		staged:       make(map[uint32]*stagedBlock),
+	}
+}
+
+// removeInFlight returns whether the block was requested by the BlockDownloader, and forgets
+// the request.
+func (bd *BlockDownloader) removeInFlight(blockHash *BlockHash) bool {
+	if _, exists := bd.inFlight[*blockHash]; !exists {
+		return false
+	}
+	delete(bd.inFlight, *blockHash)
+	return true
+}
+
+// removePeer forgets the requests we sent to the peer, so that the blocks are requested from
+// another peer. The blocks the peer already sent us stay staged.
+func (bd *BlockDownloader) removePeer(pp *Peer) {
+	for blockHash, request := range bd.inFlight {
+		if request.Peer == pp {
+			delete(bd.inFlight, blockHash)
+		}
+	}
+}
+
+func (bd *BlockDownloader) stageBlock(blk *MsgDeSoBlock, blockHash *BlockHash, pp *Peer) {
+	bd.staged[uint32(blk.Header.Height)] = &stagedBlock{
+		Block: blk,
+		Hash:  blockHash,
+		Peer:  pp,
+	}
+}
+
+// popStagedBlock returns and unstages the block at the height, if one is staged. Blocks staged
+// below the height can't be connected anymore, so they're dropped.
+func (bd *BlockDownloader) popStagedBlock(height uint32) *stagedBlock {
+	for stagedHeight := range bd.staged {
+		if stagedHeight < height {
+			delete(bd.staged, stagedHeight)
+		}
+	}
+	block, exists := bd.staged[height]
+	if !exists {
+		return nil
+	}
+	delete(bd.staged, height)
+	return block
+}
+
+func (bd *BlockDownloader) numStagedBlocks() int {
+	return len(bd.staged)
+}
+
+// _isRequestedOrStaged returns whether we already have or are waiting for the block.
+func (bd *BlockDownloader) _isRequestedOrStaged(node *BlockNode) bool {
+	if _, exists := bd.inFlight[*node.Hash]; exists {
+		return true
+	}
+	block, exists := bd.staged[node.Height]
+	return exists && *block.Hash == *node.Hash
+}
+
+// assignBlocks decides which of the blocks after the tip to request from which peer, and records
+// the requests as in flight. Blocks are spread over the peers in turn, and each peer is only
+// asked for blocks up to the height it had when it connected, and for as many as it has room for
+// under MaxBlocksInFlight.
+func (bd *BlockDownloader) assignBlocks(tipHeight uint32, headerChain []*BlockNode, peers []*Peer,
+	now time.Time) map[*Peer][]*BlockNode {
+
+	assignments := make(map[*Peer][]*BlockNode)
+	if len(peers) == 0 {
+		return assignments
+	}
+	remainingCapacity := make(map[*Peer]int)
+	for _, pp := range peers {
+		remainingCapacity[pp] = MaxBlocksInFlight - len(pp.requestedBlocks)
+	}
+
+	endHeight := tipHeight + bd.window
+	if endHeight >= uint32(len(headerChain)) {
+		endHeight = uint32(len(headerChain)) - 1
+	}
+	nextPeerIndex := 0
+	for height := tipHeight + 1; height <= endHeight; height++ {
+		node := headerChain[height]
+		if bd._isRequestedOrStaged(node) {
+			continue
+		}
+
+		assigned := false
+		for ii := 0; ii < len(peers); ii++ {
+			peerIndex := (nextPeerIndex + ii) % len(peers)
+			pp := peers[peerIndex]
+			if remainingCapacity[pp] <= 0 || pp.StartingBlockHeight() < height {
+				continue
+			}
+			assignments[pp] = append(assignments[pp], node)
+			remainingCapacity[pp]--
+			bd.inFlight[*node.Hash] = &blockDownloadRequest{
+				Hash:          node.Hash,
+				Height:        height,
+				Peer:          pp,
+				TimeRequested: now,
+			}
+			nextPeerIndex = (peerIndex + 1) % len(peers)
+			assigned = true
+			break
+		}
+		// None of the peers can take this block, and since the peers that are full stay full
+		// and those that don't have this block don't have the ones after it either, we're done.
+		if !assigned {
+			break
+		}
+	}
+	return assignments
+}
+
+// stalledPeer returns the peer that's holding up the window, if any. That's the case when the
+// block right after the tip has been in flight for longer than the stall timeout while the rest
+// of the window is requested or staged, so that we can't make progress without it.
+func (bd *BlockDownloader) stalledPeer(tipHeight uint32, headerChain []*BlockNode, now time.Time) *Peer {
+	if tipHeight+1 >= uint32(len(headerChain)) {
+		return nil
+	}
+	request, exists := bd.inFlight[*headerChain[tipHeight+1].Hash]
+	if !exists || now.Sub(request.TimeRequested) <= bd.stallTimeout {
+		return nil
+	}
+	windowSize := bd.window
+	if tipHeight+windowSize >= uint32(len(headerChain)) {
+		windowSize = uint32(len(headerChain)) - 1 - tipHeight
+	}
+	if uint32(len(bd.inFlight)+len(bd.staged)) < windowSize {
+		return nil
+	}
+	return request.Peer
+}
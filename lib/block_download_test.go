@@ -0,0 +1,133 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func _blockDownloadTestHeaderChain(numBlocks int) []*BlockNode {
+	var headerChain []*BlockNode
+	var parent *BlockNode
+	for ii := 0; ii < numBlocks; ii++ {
+		node := NewBlockNode(parent, &BlockHash{byte(ii), byte(ii >> 8), 0x01}, uint32(ii),
+			nil, nil, nil, StatusHeaderValidated)
+		headerChain = append(headerChain, node)
+		parent = node
+	}
+	return headerChain
+}
+
+func _blockDownloadTestPeer(startingHeight uint32) *Peer {
+	return &Peer{
+		startingHeight:  startingHeight,
+		requestedBlocks: make(map[BlockHash]bool),
+	}
+}
+
+func TestBlockDownloaderAssignBlocks(t *testing.T) {
+	require := require.New(t)
+
+	headerChain := _blockDownloadTestHeaderChain(20)
+	peerA := _blockDownloadTestPeer(19)
+	peerB := _blockDownloadTestPeer(4)
+	now := time.Now()
+
+	// Blocks are spread over the peers in turn, and a peer is never asked for blocks above the
+	// height it has.
+	bd := NewBlockDownloader(2, 10, time.Second)
+	assignments := bd.assignBlocks(0, headerChain, []*Peer{peerA, peerB}, now)
+	require.Equal(2, len(assignments))
+	var heightsA, heightsB []uint32
+	for _, node := range assignments[peerA] {
+		heightsA = append(heightsA, node.Height)
+	}
+	for _, node := range assignments[peerB] {
+		heightsB = append(heightsB, node.Height)
+	}
+	require.Equal([]uint32{1, 3, 5, 6, 7, 8, 9, 10}, heightsA)
+	require.Equal([]uint32{2, 4}, heightsB)
+	require.Equal(10, len(bd.inFlight))
+
+	// Blocks that are in flight aren't assigned again, and nothing past the window is assigned.
+	require.Empty(bd.assignBlocks(0, headerChain, []*Peer{peerA, peerB}, now))
+
+	// Once the peer is gone its blocks are assigned to the other peer.
+	bd.removePeer(peerB)
+	require.Equal(8, len(bd.inFlight))
+	assignments = bd.assignBlocks(0, headerChain, []*Peer{peerA}, now)
+	require.Equal([]*BlockNode{headerChain[2], headerChain[4]}, assignments[peerA])
+
+	// Staged blocks aren't requested again either.
+	require.True(bd.removeInFlight(headerChain[3].Hash))
+	require.False(bd.removeInFlight(headerChain[3].Hash))
+	bd.stageBlock(&MsgDeSoBlock{Header: &MsgDeSoHeader{Height: 3}}, headerChain[3].Hash, peerA)
+	require.Empty(bd.assignBlocks(0, headerChain, []*Peer{peerA}, now))
+
+	// A peer that has MaxBlocksInFlight requests outstanding isn't assigned anything.
+	fullPeer := _blockDownloadTestPeer(19)
+	for ii := 0; ii < MaxBlocksInFlight; ii++ {
+		fullPeer.requestedBlocks[BlockHash{byte(ii), byte(ii >> 8), 0x02}] = true
+	}
+	require.Empty(NewBlockDownloader(1, 10, time.Second).assignBlocks(
+		0, headerChain, []*Peer{fullPeer}, now))
+}
+
+func TestBlockDownloaderStagedBlocks(t *testing.T) {
+	require := require.New(t)
+
+	headerChain := _blockDownloadTestHeaderChain(10)
+	pp := _blockDownloadTestPeer(9)
+	bd := NewBlockDownloader(1, 10, time.Second)
+
+	for _, height := range []uint32{2, 4, 5} {
+		bd.stageBlock(&MsgDeSoBlock{Header: &MsgDeSoHeader{Height: uint64(height)}},
+			headerChain[height].Hash, pp)
+	}
+	require.Equal(3, bd.numStagedBlocks())
+	require.Nil(bd.popStagedBlock(3))
+	// The block staged below the height is dropped.
+	require.Equal(2, bd.numStagedBlocks())
+	staged := bd.popStagedBlock(4)
+	require.NotNil(staged)
+	require.Equal(*headerChain[4].Hash, *staged.Hash)
+	require.Equal(pp, staged.Peer)
+	require.Equal(1, bd.numStagedBlocks())
+	require.NotNil(bd.popStagedBlock(5))
+	require.Equal(0, bd.numStagedBlocks())
+}
+
+func TestBlockDownloaderStalledPeer(t *testing.T) {
+	require := require.New(t)
+
+	headerChain := _blockDownloadTestHeaderChain(10)
+	peerA := _blockDownloadTestPeer(9)
+	peerB := _blockDownloadTestPeer(9)
+	now := time.Now()
+
+	bd := NewBlockDownloader(2, 4, time.Second)
+	assignments := bd.assignBlocks(0, headerChain, []*Peer{peerA, peerB}, now)
+	require.Equal([]*BlockNode{headerChain[1], headerChain[3]}, assignments[peerA])
+
+	// Nobody stalls before the timeout.
+	require.Nil(bd.stalledPeer(0, headerChain, now))
+	// Nor when the rest of the window isn't requested yet.
+	require.True(bd.removeInFlight(headerChain[4].Hash))
+	require.Nil(bd.stalledPeer(0, headerChain, now.Add(2*time.Second)))
+	// The peer holding the block after the tip stalls once the rest of the window has arrived.
+	bd.stageBlock(&MsgDeSoBlock{Header: &MsgDeSoHeader{Height: 4}}, headerChain[4].Hash, peerB)
+	require.Equal(peerA, bd.stalledPeer(0, headerChain, now.Add(2*time.Second)))
+
+	// The window is cut short at the end of the header chain.
+	require.True(bd.removeInFlight(headerChain[1].Hash))
+	require.True(bd.removeInFlight(headerChain[2].Hash))
+	require.True(bd.removeInFlight(headerChain[3].Hash))
+	require.Equal(0, len(bd.inFlight))
+	assignments = bd.assignBlocks(7, headerChain, []*Peer{peerB}, now)
+	require.Equal([]*BlockNode{headerChain[8], headerChain[9]}, assignments[peerB])
+	require.Equal(peerB, bd.stalledPeer(7, headerChain, now.Add(2*time.Second)))
+	require.Nil(bd.stalledPeer(9, headerChain, now.Add(2*time.Second)))
+}
@@ -0,0 +1,256 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	btcdchain "github.com/btcsuite/btcd/blockchain"
+	"github.com/pkg/errors"
+)
+
+type BlockStatus uint32
+
+const (
+	StatusNone BlockStatus = 0
+
+	// Headers must always be Validated or ValidateFailed. We
+	// don't store orphan headers and therefore any header that we do
+	// have in our node index will be known definitively to be valid or
+	// invalid one way or the other.
+	StatusHeaderValidated = 1 << iota
+	StatusHeaderValidateFailed
+
+	StatusBlockProcessed
+	StatusBlockStored
+	StatusBlockValidated
+	StatusBlockValidateFailed
+
+	StatusBitcoinHeaderValidated      // Set on the headers stored by the BitcoinHeaderManager
+	StatusBitcoinHeaderValidateFailed // Deprecated
+)
+
+// IsFullyProcessed determines if the BlockStatus corresponds to a fully processed and stored block.
+func (blockStatus BlockStatus) IsFullyProcessed() bool {
+	return blockStatus&StatusHeaderValidated != 0 &&
+		blockStatus&StatusBlockStored != 0 &&
+		blockStatus&StatusBlockProcessed != 0 &&
+		blockStatus&StatusBlockValidated != 0
+}
+
+func (blockStatus BlockStatus) String() string {
+	if blockStatus == 0 {
+		return "NONE"
+	}
+
+	statuses := []string{}
+	if blockStatus&StatusHeaderValidated != 0 {
+		statuses = append(statuses, "HEADER_VALIDATED")
+		blockStatus ^= StatusHeaderValidated
+	}
+	if blockStatus&StatusHeaderValidateFailed != 0 {
+		statuses = append(statuses, "HEADER_VALIDATE_FAILED")
+		blockStatus ^= StatusHeaderValidateFailed
+	}
+	if blockStatus&StatusBlockProcessed != 0 {
+		statuses = append(statuses, "BLOCK_PROCESSED")
+		blockStatus ^= StatusBlockProcessed
+	}
+	if blockStatus&StatusBlockStored != 0 {
+		statuses = append(statuses, "BLOCK_STORED")
+		blockStatus ^= StatusBlockStored
+	}
+	if blockStatus&StatusBlockValidated != 0 {
+		statuses = append(statuses, "BLOCK_VALIDATED")
+		blockStatus ^= StatusBlockValidated
+	}
+	if blockStatus&StatusBlockValidateFailed != 0 {
+		statuses = append(statuses, "BLOCK_VALIDATE_FAILED")
+		blockStatus ^= StatusBlockValidateFailed
+	}
+
+	// If at this point the blockStatus isn't zeroed out then
+	// we have an unknown status remaining.
+	if blockStatus != 0 {
+		statuses = append(statuses, "ERROR_UNKNOWN_STATUS!")
+	}
+
+	return strings.Join(statuses, " | ")
+}
+
+// Add some fields in addition to the header to aid in the selection
+// of the best chain.
+type BlockNode struct {
+	// Pointer to a node representing the block's parent.
+	Parent *BlockNode
+
+	// The hash computed on this block.
+	Hash *BlockHash
+
+	// Height is the position in the block chain.
+	Height uint32
+
+	// The difficulty target for this block. Used to compute the next
+	// block's difficulty target so it can be validated.
+	DifficultyTarget *BlockHash
+
+	// A computation of the total amount of work that has been performed
+	// on this chain, including the current node.
+	CumWork *big.Int
+
+	// The block header.
+	Header *MsgDeSoHeader
+
+	// Status holds the validation state for the block and whether or not
+	// it's stored in the database.
+	Status BlockStatus
+}
+
+func _difficultyBitsToHash(diffBits uint32) (_diffHash *BlockHash) {
+	diffBigint := btcdchain.CompactToBig(diffBits)
+	return BigintToHash(diffBigint)
+}
+
+func (nn *BlockNode) String() string {
+	var parentHash *BlockHash
+	if nn.Parent != nil {
+		parentHash = nn.Parent.Hash
+	}
+	tstamp := uint32(0)
+	if nn.Header != nil {
+		tstamp = uint32(nn.Header.TstampSecs)
+	}
+	return fmt.Sprintf("< TstampSecs: %d, Height: %d, Hash: %s, ParentHash %s, Status: %s, CumWork: %v>",
+		tstamp, nn.Header.Height, nn.Hash, parentHash, nn.Status, nn.CumWork)
+}
+
+// TODO: Height not needed in this since it's in the header.
+func NewBlockNode(
+	parent *BlockNode,
+	hash *BlockHash,
+	height uint32,
+	difficultyTarget *BlockHash,
+	cumWork *big.Int,
+	header *MsgDeSoHeader,
+	status BlockStatus) *BlockNode {
+
+	return &BlockNode{
+		Parent:           parent,
+		Hash:             hash,
+		Height:           height,
+		DifficultyTarget: difficultyTarget,
+		CumWork:          cumWork,
+		Header:           header,
+		Status:           status,
+	}
+}
+
+func (nn *BlockNode) Ancestor(height uint32) *BlockNode {
+	if height > nn.Height {
+		return nil
+	}
+
+	node := nn
+	for ; node != nil && node.Height != height; node = node.Parent {
+		// Keep iterating node until the condition no longer holds.
+	}
+
+	return node
+}
+
+// RelativeAncestor returns the ancestor block node a relative 'distance' blocks
+// before this node. This is equivalent to calling Ancestor with the node's
+// height minus provided distance.
+//
+// This function is safe for concurrent access.
+func (nn *BlockNode) RelativeAncestor(distance uint32) *BlockNode {
+	return nn.Ancestor(nn.Height - distance)
+}
+
+var (
+	maxHash = BlockHash{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff}
+	maxHashBigint = HashToBigint(&maxHash)
+	bigOneInt     = big.NewInt(1)
+)
+
+// The number of hashing attempts in expectation it would take to produce the
+// hash passed in. This is computed as:
+//    E(min(X_i, ..., X_n)) where:
+//    - n = (number of attempted hashes) and
+//    - the X_i are all U(0, MAX_HASH)
+// -> E(min(X_i, ..., X_n)) = MAX_HASH / (n + 1)
+// -> E(n) ~= MAX_HASH / min_hash - 1
+//    - where min_hash is the block hash
+//
+// We approximate this as MAX_HASH / (min_hash + 1), adding 1 to min_hash in
+// order to mitigate the possibility of a divide-by-zero error.
+//
+// The value returned is the expected number of hashes performed to produce
+// the input hash formatted as a big-endian big integer that uses the
+// BlockHash type for convenience (though it is likely to be much lower
+// in terms of magnitude than a typical BlockHash object).
+func ExpectedWorkForBlockHash(hash *BlockHash) *BlockHash {
+	hashBigint := HashToBigint(hash)
+	ratioBigint := new(big.Int)
+	ratioBigint.Div(maxHashBigint, hashBigint.Add(hashBigint, bigOneInt))
+	return BigintToHash(ratioBigint)
+}
+
+type OrphanBlock struct {
+	Block *MsgDeSoBlock
+	Hash  *BlockHash
+}
+
+// InvalidBlockEntry records that a block failed to connect with a RuleError. Only blocks whose
+// txns match their header's merkle root are recorded, since a peer can't change the txns of such
+// a block without changing its hash.
+type InvalidBlockEntry struct {
+	Hash           *BlockHash
+	Height         uint64
+	RuleError      RuleError
+	ErrorMessage   string
+	TimestampNanos uint64
+}
+
+func (entry *InvalidBlockEntry) ToBytes() []byte {
+	data := append([]byte{}, entry.Hash[:]...)
+	data = append(data, UintToBuf(entry.Height)...)
+	data = append(data, EncodeByteArray([]byte(entry.RuleError))...)
+	data = append(data, EncodeByteArray([]byte(entry.ErrorMessage))...)
+	data = append(data, UintToBuf(entry.TimestampNanos)...)
+	return data
+}
+
+func (entry *InvalidBlockEntry) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+	var err error
+
+	entry.Hash = &BlockHash{}
+	if _, err = io.ReadFull(rr, entry.Hash[:]); err != nil {
+		return errors.Wrapf(err, "InvalidBlockEntry.FromBytes: Problem reading Hash")
+	}
+	if entry.Height, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "InvalidBlockEntry.FromBytes: Problem reading Height")
+	}
+	ruleErrorBytes, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "InvalidBlockEntry.FromBytes: Problem reading RuleError")
+	}
+	entry.RuleError = RuleError(ruleErrorBytes)
+	errorMessageBytes, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "InvalidBlockEntry.FromBytes: Problem reading ErrorMessage")
+	}
+	entry.ErrorMessage = string(errorMessageBytes)
+	if entry.TimestampNanos, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "InvalidBlockEntry.FromBytes: Problem reading TimestampNanos")
+	}
+
+	return nil
+}
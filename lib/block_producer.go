@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -322,7 +324,7 @@ func (desoBlockProducer *DeSoBlockProducer) _getBlockTemplate(publicKey []byte)
 	}
 
 	glog.Infof("Produced block with %v txns with approx %v total txns in mempool",
-		len(blockRet.Txns), len(desoBlockProducer.mempool.readOnlyUniversalTransactionList))
+		len(blockRet.Txns), desoBlockProducer.mempool.Count())
 	return blockRet, diffTarget, lastNode, nil
 }
 
@@ -0,0 +1,126 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"fmt"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// MinBlockPruneDepth is the fewest blocks below the tip that a pruning node keeps the bodies
+// and utxo operations of. A reorg deeper than the prune depth can't be connected, since the
+// blocks it disconnects no longer have their utxo operations.
+var MinBlockPruneDepth = uint32(1000)
+
+// MaxBlocksToPrunePerBlock bounds the blocks pruned each time a block is processed, so a node
+// that just turned pruning on catches up gradually instead of holding the ChainLock for long.
+var MaxBlocksToPrunePerBlock = 1000
+
+// SetBlockPruneDepth makes the node delete the bodies and utxo operations of the main chain
+// blocks that are more than pruneDepth blocks below the tip. Their headers and the state are
+// kept. The blocks after the current snapshot are never pruned, since the peers that hypersync
+// from it download them, so pruning requires hypersync. It's incompatible with archival mode,
+// since an archival node serves every block to its peers.
+func (bc *Blockchain) SetBlockPruneDepth(pruneDepth uint32) error {
+	bc.ChainLock.Lock()
+	defer bc.ChainLock.Unlock()
+
+	if pruneDepth < MinBlockPruneDepth {
+		return fmt.Errorf("SetBlockPruneDepth: Prune depth %v is below the minimum of %v",
+			pruneDepth, MinBlockPruneDepth)
+	}
+	if bc.snapshot == nil {
+		return fmt.Errorf("SetBlockPruneDepth: Pruning blocks requires hypersync")
+	}
+	if bc.archivalMode {
+		return fmt.Errorf("SetBlockPruneDepth: Pruning blocks isn't supported in archival mode")
+	}
+	if bc.postgres != nil {
+		return fmt.Errorf("SetBlockPruneDepth: Pruning blocks isn't supported with postgres")
+	}
+
+	bc.blockPruneDepth = pruneDepth
+	return nil
+}
+
+// IsBlockPruned returns whether the block with the given hash is on the main chain and had its
+// body and utxo operations pruned. Blocks stay pruned if pruning is turned off later.
+func (bc *Blockchain) IsBlockPruned(blockHash *BlockHash) bool {
+	bc.ChainLock.RLock()
+	defer bc.ChainLock.RUnlock()
+
+	blockNode, exists := bc.bestChainMap[*blockHash]
+	return exists && blockNode.Height > 0 && uint64(blockNode.Height) <= bc.prunedBlockHeight
+}
+
+// PrunedBlockHeight returns the height of the highest main chain block that was pruned, or zero
+// if no block was. The genesis block is never pruned.
+func (bc *Blockchain) PrunedBlockHeight() uint64 {
+	bc.ChainLock.RLock()
+	defer bc.ChainLock.RUnlock()
+
+	return bc.prunedBlockHeight
+}
+
+// blockPruneHorizon returns the height that main chain blocks are pruned below, or zero if
+// none should be.
+func (bc *Blockchain) blockPruneHorizon() uint64 {
+	if bc.blockPruneDepth == 0 || bc.snapshot == nil || bc.snapshot.CurrentEpochSnapshotMetadata == nil {
+		return 0
+	}
+
+	tipHeight := uint64(bc.blockTip().Height)
+	if tipHeight <= uint64(bc.blockPruneDepth) {
+		return 0
+	}
+	horizon := tipHeight - uint64(bc.blockPruneDepth)
+
+	// Keep the blocks from the snapshot onwards, which are what peers download after they
+	// hypersync the snapshot's state from us.
+	if snapshotHeight := bc.snapshot.CurrentEpochSnapshotMetadata.SnapshotBlockHeight; snapshotHeight < horizon {
+		horizon = snapshotHeight
+	}
+	return horizon
+}
+
+// pruneBlocks prunes up to maxBlocks of the main chain blocks below the prune horizon that
+// haven't been pruned yet, lowest first, and returns the number it pruned. It must be called
+// with the ChainLock held.
+func (bc *Blockchain) pruneBlocks(maxBlocks int) (_numPruned int, _err error) {
+	// The state isn't complete while we're hypersyncing, so neither is the snapshot.
+	if bc.syncingState {
+		return 0, nil
+	}
+
+	horizon := bc.blockPruneHorizon()
+	startHeight := bc.prunedBlockHeight + 1
+	if startHeight >= horizon {
+		return 0, nil
+	}
+	endHeight := horizon
+	if endHeight-startHeight > uint64(maxBlocks) {
+		endHeight = startHeight + uint64(maxBlocks)
+	}
+
+	err := bc.db.Update(func(txn *badger.Txn) error {
+		for height := startHeight; height < endHeight; height++ {
+			blockHash := bc.bestChain[height].Hash
+			if err := DeleteBlockWithTxn(txn, bc.snapshot, blockHash); err != nil {
+				return errors.Wrapf(err, "Problem deleting block %v at height %v", blockHash, height)
+			}
+			if err := DeleteUtxoOperationsForBlockWithTxn(txn, bc.snapshot, blockHash); err != nil {
+				return errors.Wrapf(err, "Problem deleting utxo operations for block %v at height %v",
+					blockHash, height)
+			}
+		}
+		return DbPutPrunedBlockHeightWithTxn(txn, endHeight-1)
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "pruneBlocks: ")
+	}
+
+	bc.prunedBlockHeight = endHeight - 1
+	return int(endHeight - startHeight), nil
+}
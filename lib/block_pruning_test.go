@@ -0,0 +1,69 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockPruning(t *testing.T) {
+	require := require.New(t)
+
+	oldMinBlockPruneDepth := MinBlockPruneDepth
+	oldMaxBlocksToPrunePerBlock := MaxBlocksToPrunePerBlock
+	MinBlockPruneDepth = 2
+	defer func() {
+		MinBlockPruneDepth = oldMinBlockPruneDepth
+		MaxBlocksToPrunePerBlock = oldMaxBlocksToPrunePerBlock
+	}()
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+	require.Error(chain.SetBlockPruneDepth(1))
+	require.NoError(chain.SetBlockPruneDepth(3))
+
+	mineBlock := func() {
+		_, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
+		require.NoError(err)
+	}
+	requirePrunedUpTo := func(prunedBlockHeight uint64) {
+		require.Equal(prunedBlockHeight, chain.PrunedBlockHeight())
+		require.Equal(prunedBlockHeight, DbGetPrunedBlockHeight(db))
+		for _, blockNode := range chain.bestChain {
+			isPruned := blockNode.Height > 0 && uint64(blockNode.Height) <= prunedBlockHeight
+			require.Equal(isPruned, chain.IsBlockPruned(blockNode.Hash))
+			// The header is kept either way.
+			require.NotNil(chain.GetBlockNodeWithHash(blockNode.Hash).Header)
+			if isPruned {
+				require.Nil(chain.GetBlock(blockNode.Hash))
+				_, err := GetUtxoOperationsForBlock(db, chain.snapshot, blockNode.Hash)
+				require.Error(err)
+			} else {
+				require.NotNil(chain.GetBlock(blockNode.Hash))
+			}
+		}
+	}
+
+	// Nothing is pruned while the snapshot is at the genesis block.
+	for ii := 0; ii < 10; ii++ {
+		mineBlock()
+	}
+	requirePrunedUpTo(0)
+
+	// The blocks below the snapshot are pruned, even though the prune depth would allow more.
+	chain.snapshot.CurrentEpochSnapshotMetadata.SnapshotBlockHeight = 6
+	mineBlock()
+	requirePrunedUpTo(5)
+
+	// The blocks more than the prune depth below the tip are pruned, a few at a time.
+	chain.snapshot.CurrentEpochSnapshotMetadata.SnapshotBlockHeight = 100
+	MaxBlocksToPrunePerBlock = 2
+	mineBlock()
+	requirePrunedUpTo(7)
+	mineBlock()
+	requirePrunedUpTo(9)
+	mineBlock()
+	requirePrunedUpTo(10)
+}
@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"fmt"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// BlockRewardPayout is the total block reward a public key got from the main chain block at Height.
+type BlockRewardPayout struct {
+	PublicKey   []byte
+	Height      uint64
+	AmountNanos uint64
+}
+
+// RegisterBlockRewardPayoutHandlers makes the IndexQueue maintain the block reward payout index and
+// lifetime earnings. Both handlers are idempotent: a connect replaces any payout already recorded at
+// the block's height, and a disconnect only reverts the payouts that are still recorded.
+func RegisterBlockRewardPayoutHandlers(indexQueue *IndexQueue) {
+	db := indexQueue.db
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockConnected, func(task *IndexQueueTask) error {
+		return db.Update(func(txn *badger.Txn) error {
+			return _applyBlockRewardPayoutsWithTxn(txn, task.BlockHash, task.BlockHeight)
+		})
+	})
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockDisconnected, func(task *IndexQueueTask) error {
+		return db.Update(func(txn *badger.Txn) error {
+			return _revertBlockRewardPayoutsWithTxn(txn, task.BlockHash, task.BlockHeight)
+		})
+	})
+}
+
+func _getBlockRewardsForBlockWithTxn(txn *badger.Txn, blockHash *BlockHash) (map[PkMapKey]uint64, error) {
+	block := GetBlockWithTxn(txn, nil, blockHash)
+	if block == nil {
+		return nil, fmt.Errorf("_getBlockRewardsForBlockWithTxn: Block %v not found", blockHash)
+	}
+	if len(block.Txns) == 0 || block.Txns[0].TxnMeta.GetTxnType() != TxnTypeBlockReward {
+		return nil, fmt.Errorf("_getBlockRewardsForBlockWithTxn: Block %v has no block reward", blockHash)
+	}
+	return GetBlockRewardsByPublicKey(block), nil
+}
+
+func _applyBlockRewardPayoutsWithTxn(txn *badger.Txn, blockHash *BlockHash, height uint64) error {
+	blockRewards, err := _getBlockRewardsForBlockWithTxn(txn, blockHash)
+	if err != nil {
+		return errors.Wrapf(err, "_applyBlockRewardPayoutsWithTxn: ")
+	}
+	for pkMapKey, amountNanos := range blockRewards {
+		publicKey := pkMapKey[:]
+		if err := _revertBlockRewardPayoutForPublicKeyWithTxn(txn, publicKey, height); err != nil {
+			return errors.Wrapf(err, "_applyBlockRewardPayoutsWithTxn: ")
+		}
+		earnings, err := DbGetBlockRewardLifetimeEarningsWithTxn(txn, publicKey)
+		if err != nil {
+			return errors.Wrapf(err, "_applyBlockRewardPayoutsWithTxn: ")
+		}
+		newEarnings, err := SafeUint64().Add(earnings, amountNanos)
+		if err != nil {
+			return errors.Wrapf(err, "_applyBlockRewardPayoutsWithTxn: Lifetime earnings of public key %v overflow",
+				PkToStringBoth(publicKey))
+		}
+		if err = DbPutBlockRewardPayoutWithTxn(txn, publicKey, height, amountNanos); err != nil {
+			return errors.Wrapf(err, "_applyBlockRewardPayoutsWithTxn: ")
+		}
+		if err = DbPutBlockRewardLifetimeEarningsWithTxn(txn, publicKey, newEarnings); err != nil {
+			return errors.Wrapf(err, "_applyBlockRewardPayoutsWithTxn: ")
+		}
+	}
+	return nil
+}
+
+func _revertBlockRewardPayoutsWithTxn(txn *badger.Txn, blockHash *BlockHash, height uint64) error {
+	blockRewards, err := _getBlockRewardsForBlockWithTxn(txn, blockHash)
+	if err != nil {
+		return errors.Wrapf(err, "_revertBlockRewardPayoutsWithTxn: ")
+	}
+	for pkMapKey := range blockRewards {
+		if err := _revertBlockRewardPayoutForPublicKeyWithTxn(txn, pkMapKey[:], height); err != nil {
+			return errors.Wrapf(err, "_revertBlockRewardPayoutsWithTxn: ")
+		}
+	}
+	return nil
+}
+
+// _revertBlockRewardPayoutForPublicKeyWithTxn removes the public key's payout at the height, if
+// there's one, and deducts it from the public key's lifetime earnings.
+func _revertBlockRewardPayoutForPublicKeyWithTxn(txn *badger.Txn, publicKey []byte, height uint64) error {
+	amountNanos, exists, err := DbGetBlockRewardPayoutWithTxn(txn, publicKey, height)
+	if err != nil || !exists {
+		return err
+	}
+	earnings, err := DbGetBlockRewardLifetimeEarningsWithTxn(txn, publicKey)
+	if err != nil {
+		return err
+	}
+	if earnings < amountNanos {
+		return fmt.Errorf("_revertBlockRewardPayoutForPublicKeyWithTxn: Lifetime earnings %v of public key %v "+
+			"are less than its payout %v at height %v", earnings, PkToStringBoth(publicKey), amountNanos, height)
+	}
+	if err = DbDeleteBlockRewardPayoutWithTxn(txn, publicKey, height); err != nil {
+		return err
+	}
+	return DbPutBlockRewardLifetimeEarningsWithTxn(txn, publicKey, earnings-amountNanos)
+}
@@ -0,0 +1,78 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockRewardPayouts(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+	indexQueue := NewIndexQueue(db)
+	RegisterBlockRewardPayoutHandlers(indexQueue)
+	chain.SetIndexQueue(indexQueue)
+
+	senderPkBytes, _, err := Base58CheckDecode(senderPkString)
+	require.NoError(err)
+
+	var blocks []*MsgDeSoBlock
+	for ii := 0; ii < 3; ii++ {
+		block, err := miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+		blocks = append(blocks, block)
+	}
+	_, err = indexQueue.ProcessTasks(IndexQueueBatchSize)
+	require.NoError(err)
+
+	var expectedPayouts []*BlockRewardPayout
+	expectedEarnings := uint64(0)
+	for _, block := range blocks {
+		amountNanos := GetBlockRewardsByPublicKey(block)[MakePkMapKey(senderPkBytes)]
+		expectedPayouts = append(expectedPayouts, &BlockRewardPayout{
+			PublicKey:   senderPkBytes,
+			Height:      uint64(block.Header.Height),
+			AmountNanos: amountNanos,
+		})
+		expectedEarnings += amountNanos
+	}
+	requirePayouts := func(payouts []*BlockRewardPayout, earnings uint64) {
+		dbPayouts, err := DbGetBlockRewardPayouts(db, senderPkBytes, 0, uint64(chain.blockTip().Height))
+		require.NoError(err)
+		require.Equal(payouts, dbPayouts)
+		dbEarnings, err := DbGetBlockRewardLifetimeEarnings(db, senderPkBytes)
+		require.NoError(err)
+		require.Equal(earnings, dbEarnings)
+	}
+	requirePayouts(expectedPayouts, expectedEarnings)
+
+	// The height range is inclusive.
+	dbPayouts, err := DbGetBlockRewardPayouts(db, senderPkBytes, expectedPayouts[1].Height, expectedPayouts[1].Height)
+	require.NoError(err)
+	require.Equal(expectedPayouts[1:2], dbPayouts)
+
+	// Connecting a block again doesn't count its payout twice.
+	blockHash, err := blocks[2].Hash()
+	require.NoError(err)
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return _applyBlockRewardPayoutsWithTxn(txn, blockHash, expectedPayouts[2].Height)
+	}))
+	requirePayouts(expectedPayouts, expectedEarnings)
+
+	// Disconnecting the last two blocks removes their payouts from the index and the earnings.
+	require.NoError(chain.DisconnectBlocksToHeight(expectedPayouts[0].Height))
+	_, err = indexQueue.ProcessTasks(IndexQueueBatchSize)
+	require.NoError(err)
+	requirePayouts(expectedPayouts[:1], expectedPayouts[0].AmountNanos)
+
+	// Disconnecting a block again doesn't deduct its payout twice.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return _revertBlockRewardPayoutsWithTxn(txn, blockHash, expectedPayouts[2].Height)
+	}))
+	requirePayouts(expectedPayouts[:1], expectedPayouts[0].AmountNanos)
+}
@@ -0,0 +1,178 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/deso-protocol/go-deadlock"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// BlockSubscription delivers the main chain's blocks to a channel in height order, without gaps.
+// Blocks are always read from the main chain index and the db, so the events only have Block and
+// UtxoOps set. The EventManager's notifications just tell the subscription that there's something
+// new to read, which means that a slow consumer never holds up the blockchain: it falls behind and
+// catches up from the db instead. When blocks are disconnected below the next height to deliver,
+// the subscription starts over from the lowest disconnected height, so the blocks that replace
+// them get delivered at heights the consumer has already seen.
+type BlockSubscription struct {
+	bc *Blockchain
+	ch chan<- *BlockEvent
+
+	// nextHeight is the height of the next block to deliver, and rewound is set when a disconnect
+	// lowers it while a block is being delivered.
+	nextHeight uint64
+	rewound    bool
+	mtx        deadlock.Mutex
+
+	wake chan struct{}
+	quit chan struct{}
+}
+
+// SubscribeBlocks delivers every main chain block from fromHeight onwards to ch, replaying the ones
+// that are already in the db before streaming new ones. It needs the main chain index, so it isn't
+// supported on postgres, and an EventManager to be notified of new blocks. Close the subscription
+// to stop it. ch isn't closed.
+func (bc *Blockchain) SubscribeBlocks(fromHeight uint64, ch chan<- *BlockEvent) (*BlockSubscription, error) {
+	if bc.postgres != nil {
+		return nil, fmt.Errorf("SubscribeBlocks: Block subscriptions aren't supported on postgres")
+	}
+	if bc.eventManager == nil {
+		return nil, fmt.Errorf("SubscribeBlocks: Block subscriptions need an EventManager")
+	}
+	sub := &BlockSubscription{
+		bc:         bc,
+		ch:         ch,
+		nextHeight: fromHeight,
+		wake:       make(chan struct{}, 1),
+		quit:       make(chan struct{}),
+	}
+	bc.blockSubscriptionsLock.Lock()
+	bc.blockSubscriptions[sub] = true
+	bc.blockSubscriptionsLock.Unlock()
+
+	go sub.run()
+	return sub, nil
+}
+
+// Close stops the subscription. It's safe to call more than once.
+func (sub *BlockSubscription) Close() {
+	sub.bc.blockSubscriptionsLock.Lock()
+	defer sub.bc.blockSubscriptionsLock.Unlock()
+
+	if !sub.bc.blockSubscriptions[sub] {
+		return
+	}
+	delete(sub.bc.blockSubscriptions, sub)
+	close(sub.quit)
+}
+
+func (bc *Blockchain) _notifyBlockSubscriptions(event *BlockEvent) {
+	bc.blockSubscriptionsLock.Lock()
+	defer bc.blockSubscriptionsLock.Unlock()
+
+	for sub := range bc.blockSubscriptions {
+		sub._wake()
+	}
+}
+
+func (bc *Blockchain) _rewindBlockSubscriptions(event *BlockEvent) {
+	bc.blockSubscriptionsLock.Lock()
+	defer bc.blockSubscriptionsLock.Unlock()
+
+	for sub := range bc.blockSubscriptions {
+		sub._rewind(event.Block.Header.Height)
+	}
+}
+
+func (sub *BlockSubscription) _wake() {
+	// The channel has room for one wake up, which is enough for run to read everything that's new.
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (sub *BlockSubscription) _rewind(height uint64) {
+	sub.mtx.Lock()
+	if height <= sub.nextHeight {
+		sub.nextHeight = height
+		sub.rewound = true
+	}
+	sub.mtx.Unlock()
+	sub._wake()
+}
+
+func (sub *BlockSubscription) run() {
+	ticker := time.NewTicker(BlockSubscriptionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := sub._deliverMainChainBlocks(); err != nil {
+			glog.Errorf("BlockSubscription.run: Problem delivering blocks, will retry: %v", err)
+		}
+		select {
+		case <-sub.quit:
+			return
+		case <-sub.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// _deliverMainChainBlocks delivers blocks until it reaches the end of the main chain index or the
+// subscription is closed.
+func (sub *BlockSubscription) _deliverMainChainBlocks() error {
+	for {
+		sub.mtx.Lock()
+		height := sub.nextHeight
+		sub.rewound = false
+		sub.mtx.Unlock()
+
+		event, err := sub._getMainChainBlockEvent(height)
+		if err != nil {
+			return errors.Wrapf(err, "_deliverMainChainBlocks: ")
+		}
+		if event == nil {
+			return nil
+		}
+		select {
+		case sub.ch <- event:
+		case <-sub.quit:
+			return nil
+		}
+
+		sub.mtx.Lock()
+		if !sub.rewound {
+			sub.nextHeight = height + 1
+		}
+		sub.mtx.Unlock()
+	}
+}
+
+// _getMainChainBlockEvent returns nil if the main chain doesn't reach the height yet.
+func (sub *BlockSubscription) _getMainChainBlockEvent(height uint64) (*BlockEvent, error) {
+	var event *BlockEvent
+	err := sub.bc.db.View(func(txn *badger.Txn) error {
+		blockHash := DbGetMainChainHashAtHeightWithTxn(txn, height)
+		if blockHash == nil {
+			return nil
+		}
+		block := GetBlockWithTxn(txn, nil, blockHash)
+		if block == nil {
+			return fmt.Errorf("_getMainChainBlockEvent: Main chain block %v at height %v not found", blockHash, height)
+		}
+		// Blocks from before a hypersync snapshot don't have utxo ops.
+		utxoOps, err := GetUtxoOperationsForBlockWithTxn(txn, nil, blockHash)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return errors.Wrapf(err, "_getMainChainBlockEvent: Problem getting utxo ops for block %v", blockHash)
+		}
+		event = &BlockEvent{Block: block, UtxoOps: utxoOps}
+		return nil
+	})
+	return event, err
+}
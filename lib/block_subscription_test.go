@@ -0,0 +1,78 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+	"time"
+
+	chainlib "github.com/btcsuite/btcd/blockchain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockSubscription(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	if chain.postgres != nil {
+		t.Skip("Block subscriptions are only supported on badger")
+	}
+	mempool, miner := NewTestMiner(t, chain, params, true)
+	for ii := 0; ii < 2; ii++ {
+		_, err := miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+	}
+
+	// Reopen the chain with an EventManager, so that it can notify subscriptions.
+	chain, err := NewBlockchain([]string{blockSignerPk}, 0, 0, params,
+		chainlib.NewMedianTime(), db, nil, NewEventManager(), nil, false)
+	require.NoError(err)
+	mempool, miner = NewTestMiner(t, chain, params, true)
+
+	blockEvents := make(chan *BlockEvent)
+	sub, err := chain.SubscribeBlocks(1, blockEvents)
+	require.NoError(err)
+	defer sub.Close()
+	requireNextBlockEvent := func(height uint64) *BlockEvent {
+		select {
+		case event := <-blockEvents:
+			require.Equal(height, event.Block.Header.Height)
+			blockHash, err := event.Block.Hash()
+			require.NoError(err)
+			require.Equal(DbGetMainChainHashAtHeight(db, height), blockHash)
+			require.NotNil(event.UtxoOps)
+			return event
+		case <-time.After(10 * time.Second):
+			require.FailNow("Timed out waiting for a block event", "height %v", height)
+			return nil
+		}
+	}
+
+	// The blocks that were already in the db are replayed, then new blocks are streamed.
+	requireNextBlockEvent(1)
+	requireNextBlockEvent(2)
+	_, err = miner.MineAndProcessSingleBlock(0, mempool)
+	require.NoError(err)
+	requireNextBlockEvent(3)
+
+	// Once the block at height 3 is disconnected, the block that replaces it is delivered too.
+	require.NoError(chain.DisconnectBlocksToHeight(2))
+	_, err = miner.MineAndProcessSingleBlock(0, mempool)
+	require.NoError(err)
+	requireNextBlockEvent(3)
+
+	// Nothing is delivered once the subscription is closed.
+	sub.Close()
+	sub.Close()
+	_, err = miner.MineAndProcessSingleBlock(0, mempool)
+	require.NoError(err)
+	select {
+	case event := <-blockEvents:
+		require.FailNow("Got a block event after closing the subscription", "height %v", event.Block.Header.Height)
+	case <-time.After(2 * BlockSubscriptionPollInterval):
+	}
+
+	chainWithoutEvents, _, _ := NewLowDifficultyBlockchain()
+	_, err = chainWithoutEvents.SubscribeBlocks(1, make(chan *BlockEvent))
+	require.Error(err)
+}
@@ -1,6 +1,9 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"github.com/davecgh/go-spew/spew"
@@ -12,7 +15,9 @@ import (
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/dgraph-io/badger/v3"
 	"github.com/golang/glog"
+	"github.com/holiman/uint256"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // block_view.go is the main work-horse for validating transactions in blocks.
@@ -38,6 +43,10 @@ type UtxoView struct {
 	GlobalParamsEntry  *GlobalParamsEntry
 	BitcoinBurnTxIDs   map[BlockHash]bool
 
+	// The GlobalParamsEntry each block left in effect after updating the global params, by block
+	// height. A nil entry means the block's updates were disconnected.
+	GlobalParamsHistory map[uint64]*GlobalParamsEntry
+
 	// Forbidden block signature pubkeys
 	ForbiddenPubKeyToForbiddenPubKeyEntry map[PkMapKey]*ForbiddenPubKeyEntry
 
@@ -64,6 +73,12 @@ type UtxoView struct {
 	// Like data
 	LikeKeyToLikeEntry map[LikeKey]*LikeEntry
 
+	// Post association data
+	PostAssociationKeyToPostAssociationEntry map[PostAssociationKey]*PostAssociationEntry
+
+	// User association data
+	UserAssociationKeyToUserAssociationEntry map[UserAssociationKey]*UserAssociationEntry
+
 	// Repost data
 	RepostKeyToRepostEntry map[RepostKey]*RepostEntry
 
@@ -89,6 +104,31 @@ type UtxoView struct {
 	// DAO coin limit order entry mapping.
 	DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry map[DAOCoinLimitOrderMapKey]*DAOCoinLimitOrderEntry
 
+	// DAO coin limit trigger order entry mapping. Map key is the trigger order's OrderID.
+	DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry map[DAOCoinLimitOrderMapKey]*DAOCoinLimitOrderTriggerEntry
+
+	// The keys of the DAO coin limit order and trigger entries that were set or deleted
+	// since the view was last flushed. Matching an order loads whole pages of the book
+	// into the view, so only these entries are written to the db when the view is
+	// flushed. Entries that were only read from the db aren't tracked here.
+	//
+	// These are the only maps whose flush is limited to dirty entries. The other maps
+	// only get the entries that a txn looks up, which it mostly does to modify them, so
+	// FlushToDb writes all of their entries. The getters cache db reads with the same _set*Mappings functions
+	// that txns modify entries with, so tracking another map means giving its getters a
+	// separate function that doesn't mark entries dirty, like _cacheDAOCoinLimitOrderEntry.
+	dirtyDAOCoinLimitOrderMapKeys        map[DAOCoinLimitOrderMapKey]bool
+	dirtyDAOCoinLimitOrderTriggerMapKeys map[DAOCoinLimitOrderMapKey]bool
+
+	// The PKIDs of the profiles that were set or deleted since the view was last flushed.
+	// All profiles are still written, but only these move in the profile modified-height
+	// index. The getters cache db reads with _cacheProfileEntry so they aren't marked.
+	dirtyProfilePKIDs map[PKID]bool
+	// The height of the block that DisconnectBlock reverted the view to, or nil if the view's
+	// last block operation wasn't a disconnect. The reverted profiles are indexed at this
+	// height rather than at the height of the block that was disconnected.
+	revertedToBlockHeight *uint64
+
 	// The hash of the tip the view is currently referencing. Mainly used
 	// for error-checking when doing a bulk operation on the view.
 	TipHash *BlockHash
@@ -97,8 +137,24 @@ type UtxoView struct {
 	Postgres *Postgres
 	Params   *DeSoParams
 	Snapshot *Snapshot
+
+	// blockConnectProfile times the last block connected by ConnectBlock. It's nil unless block
+	// connect profiling is enabled.
+	blockConnectProfile *BlockConnectProfile
+
+	// batchSignatureChecks holds the results of VerifyTxnSignaturesInBatch for the txns of the
+	// block being connected. It's nil unless batch signature verification is enabled.
+	batchSignatureChecks map[*MsgDeSoTxn]*TxnSignatureCheck
+
+	// isReadOnly is set on the views made by CopyReadOnly, which share their entries with
+	// the view they were copied from. See ErrUtxoViewReadOnly.
+	isReadOnly bool
 }
 
+// ErrUtxoViewReadOnly is returned when connecting, disconnecting or flushing a view made by
+// CopyReadOnly, since doing so would modify the entries it shares with other views.
+var ErrUtxoViewReadOnly = errors.New("UtxoView is read-only")
+
 // Assumes the db Handle is already set on the view, but otherwise the
 // initialization is full.
 func (bav *UtxoView) _ResetViewMappingsAfterFlush() {
@@ -113,6 +169,7 @@ func (bav *UtxoView) _ResetViewMappingsAfterFlush() {
 	bav.USDCentsPerBitcoin = DbGetUSDCentsPerBitcoinExchangeRate(bav.Handle, bav.Snapshot)
 	bav.GlobalParamsEntry = DbGetGlobalParamsEntry(bav.Handle, bav.Snapshot)
 	bav.BitcoinBurnTxIDs = make(map[BlockHash]bool)
+	bav.GlobalParamsHistory = make(map[uint64]*GlobalParamsEntry)
 
 	// Forbidden block signature pub key info.
 	bav.ForbiddenPubKeyToForbiddenPubKeyEntry = make(map[PkMapKey]*ForbiddenPubKeyEntry)
@@ -145,6 +202,12 @@ func (bav *UtxoView) _ResetViewMappingsAfterFlush() {
 	// Like data
 	bav.LikeKeyToLikeEntry = make(map[LikeKey]*LikeEntry)
 
+	// Post association data
+	bav.PostAssociationKeyToPostAssociationEntry = make(map[PostAssociationKey]*PostAssociationEntry)
+
+	// User association data
+	bav.UserAssociationKeyToUserAssociationEntry = make(map[UserAssociationKey]*UserAssociationEntry)
+
 	// Repost data
 	bav.RepostKeyToRepostEntry = make(map[RepostKey]*RepostEntry)
 
@@ -159,6 +222,17 @@ func (bav *UtxoView) _ResetViewMappingsAfterFlush() {
 
 	// DAO Coin Limit Order Entries
 	bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry = make(map[DAOCoinLimitOrderMapKey]*DAOCoinLimitOrderEntry)
+
+	// DAO Coin Limit Order Trigger Entries
+	bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry = make(
+		map[DAOCoinLimitOrderMapKey]*DAOCoinLimitOrderTriggerEntry)
+
+	// Dirty DAO Coin Limit Order and Trigger Entry keys
+	bav.dirtyDAOCoinLimitOrderMapKeys = make(map[DAOCoinLimitOrderMapKey]bool)
+	bav.dirtyDAOCoinLimitOrderTriggerMapKeys = make(map[DAOCoinLimitOrderMapKey]bool)
+
+	// Dirty Profile PKIDs
+	bav.dirtyProfilePKIDs = make(map[PKID]bool)
 }
 
 func (bav *UtxoView) CopyUtxoView() (*UtxoView, error) {
@@ -194,6 +268,10 @@ func (bav *UtxoView) CopyUtxoView() (*UtxoView, error) {
 	// Copy the GlobalParamsEntry
 	newGlobalParamsEntry := *bav.GlobalParamsEntry
 	newView.GlobalParamsEntry = &newGlobalParamsEntry
+	newView.GlobalParamsHistory = make(map[uint64]*GlobalParamsEntry, len(bav.GlobalParamsHistory))
+	for blockHeight, globalParamsEntry := range bav.GlobalParamsHistory {
+		newView.GlobalParamsHistory[blockHeight] = globalParamsEntry
+	}
 
 	// Copy the post data
 	newView.PostHashToPostEntry = make(map[BlockHash]*PostEntry, len(bav.PostHashToPostEntry))
@@ -281,6 +359,20 @@ func (bav *UtxoView) CopyUtxoView() (*UtxoView, error) {
 		newView.LikeKeyToLikeEntry[likeKey] = &newLikeEntry
 	}
 
+	// Copy the post association data
+	newView.PostAssociationKeyToPostAssociationEntry = make(
+		map[PostAssociationKey]*PostAssociationEntry, len(bav.PostAssociationKeyToPostAssociationEntry))
+	for associationKey, associationEntry := range bav.PostAssociationKeyToPostAssociationEntry {
+		newView.PostAssociationKeyToPostAssociationEntry[associationKey] = associationEntry.Copy()
+	}
+
+	// Copy the user association data
+	newView.UserAssociationKeyToUserAssociationEntry = make(
+		map[UserAssociationKey]*UserAssociationEntry, len(bav.UserAssociationKeyToUserAssociationEntry))
+	for associationKey, associationEntry := range bav.UserAssociationKeyToUserAssociationEntry {
+		newView.UserAssociationKeyToUserAssociationEntry[associationKey] = associationEntry.Copy()
+	}
+
 	// Copy the repost data
 	newView.RepostKeyToRepostEntry = make(map[RepostKey]*RepostEntry, len(bav.RepostKeyToRepostEntry))
 	for repostKey, repostEntry := range bav.RepostKeyToRepostEntry {
@@ -353,9 +445,183 @@ func (bav *UtxoView) CopyUtxoView() (*UtxoView, error) {
 		newEntry := *entry
 		newView.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry[entryKey] = &newEntry
 	}
+
+	// Copy the DAO Coin Limit Order Trigger Entries
+	newView.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry = make(
+		map[DAOCoinLimitOrderMapKey]*DAOCoinLimitOrderTriggerEntry,
+		len(bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry))
+	for entryKey, entry := range bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry {
+		newView.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry[entryKey] = entry.Copy()
+	}
+
+	// Copy the dirty DAO Coin Limit Order and Trigger Entry keys
+	newView.dirtyDAOCoinLimitOrderMapKeys = make(map[DAOCoinLimitOrderMapKey]bool,
+		len(bav.dirtyDAOCoinLimitOrderMapKeys))
+	for entryKey := range bav.dirtyDAOCoinLimitOrderMapKeys {
+		newView.dirtyDAOCoinLimitOrderMapKeys[entryKey] = true
+	}
+	newView.dirtyDAOCoinLimitOrderTriggerMapKeys = make(map[DAOCoinLimitOrderMapKey]bool,
+		len(bav.dirtyDAOCoinLimitOrderTriggerMapKeys))
+	for entryKey := range bav.dirtyDAOCoinLimitOrderTriggerMapKeys {
+		newView.dirtyDAOCoinLimitOrderTriggerMapKeys[entryKey] = true
+	}
+
+	// Copy the dirty Profile PKIDs
+	newView.dirtyProfilePKIDs = make(map[PKID]bool, len(bav.dirtyProfilePKIDs))
+	for profilePKID := range bav.dirtyProfilePKIDs {
+		newView.dirtyProfilePKIDs[profilePKID] = true
+	}
+	if bav.revertedToBlockHeight != nil {
+		revertedToBlockHeight := *bav.revertedToBlockHeight
+		newView.revertedToBlockHeight = &revertedToBlockHeight
+	}
 	return newView, nil
 }
 
+// CopyReadOnly returns a read-only copy of the view that can be read concurrently with the
+// view it was copied from, as long as that view isn't modified either. Unlike CopyUtxoView,
+// the copy's maps point to the same entries as the view's, so it costs a fraction as much to
+// make, and it can't connect, disconnect or flush anything. Reading the copy may still load
+// entries from the db into its own maps, so each goroutine should make its own copy.
+func (bav *UtxoView) CopyReadOnly() *UtxoView {
+	newView := &UtxoView{
+		NumUtxoEntries:     bav.NumUtxoEntries,
+		NanosPurchased:     bav.NanosPurchased,
+		USDCentsPerBitcoin: bav.USDCentsPerBitcoin,
+		GlobalParamsEntry:  bav.GlobalParamsEntry,
+		TipHash:            bav.TipHash,
+		Handle:             bav.Handle,
+		Postgres:           bav.Postgres,
+		Params:             bav.Params,
+		Snapshot:           bav.Snapshot,
+		isReadOnly:         true,
+	}
+	newView.UtxoKeyToUtxoEntry = make(map[UtxoKey]*UtxoEntry, len(bav.UtxoKeyToUtxoEntry))
+	for key, value := range bav.UtxoKeyToUtxoEntry {
+		newView.UtxoKeyToUtxoEntry[key] = value
+	}
+	newView.PublicKeyToDeSoBalanceNanos = make(map[PublicKey]uint64, len(bav.PublicKeyToDeSoBalanceNanos))
+	for key, value := range bav.PublicKeyToDeSoBalanceNanos {
+		newView.PublicKeyToDeSoBalanceNanos[key] = value
+	}
+	newView.BitcoinBurnTxIDs = make(map[BlockHash]bool, len(bav.BitcoinBurnTxIDs))
+	for key, value := range bav.BitcoinBurnTxIDs {
+		newView.BitcoinBurnTxIDs[key] = value
+	}
+	newView.GlobalParamsHistory = make(map[uint64]*GlobalParamsEntry, len(bav.GlobalParamsHistory))
+	for key, value := range bav.GlobalParamsHistory {
+		newView.GlobalParamsHistory[key] = value
+	}
+	newView.ForbiddenPubKeyToForbiddenPubKeyEntry = make(map[PkMapKey]*ForbiddenPubKeyEntry, len(bav.ForbiddenPubKeyToForbiddenPubKeyEntry))
+	for key, value := range bav.ForbiddenPubKeyToForbiddenPubKeyEntry {
+		newView.ForbiddenPubKeyToForbiddenPubKeyEntry[key] = value
+	}
+	newView.MessageKeyToMessageEntry = make(map[MessageKey]*MessageEntry, len(bav.MessageKeyToMessageEntry))
+	for key, value := range bav.MessageKeyToMessageEntry {
+		newView.MessageKeyToMessageEntry[key] = value
+	}
+	newView.MessagingGroupKeyToMessagingGroupEntry = make(map[MessagingGroupKey]*MessagingGroupEntry, len(bav.MessagingGroupKeyToMessagingGroupEntry))
+	for key, value := range bav.MessagingGroupKeyToMessagingGroupEntry {
+		newView.MessagingGroupKeyToMessagingGroupEntry[key] = value
+	}
+	newView.MessageMap = make(map[BlockHash]*PGMessage, len(bav.MessageMap))
+	for key, value := range bav.MessageMap {
+		newView.MessageMap[key] = value
+	}
+	newView.FollowKeyToFollowEntry = make(map[FollowKey]*FollowEntry, len(bav.FollowKeyToFollowEntry))
+	for key, value := range bav.FollowKeyToFollowEntry {
+		newView.FollowKeyToFollowEntry[key] = value
+	}
+	newView.NFTKeyToNFTEntry = make(map[NFTKey]*NFTEntry, len(bav.NFTKeyToNFTEntry))
+	for key, value := range bav.NFTKeyToNFTEntry {
+		newView.NFTKeyToNFTEntry[key] = value
+	}
+	newView.NFTBidKeyToNFTBidEntry = make(map[NFTBidKey]*NFTBidEntry, len(bav.NFTBidKeyToNFTBidEntry))
+	for key, value := range bav.NFTBidKeyToNFTBidEntry {
+		newView.NFTBidKeyToNFTBidEntry[key] = value
+	}
+	newView.NFTKeyToAcceptedNFTBidHistory = make(map[NFTKey]*[]*NFTBidEntry, len(bav.NFTKeyToAcceptedNFTBidHistory))
+	for key, value := range bav.NFTKeyToAcceptedNFTBidHistory {
+		newView.NFTKeyToAcceptedNFTBidHistory[key] = value
+	}
+	newView.DiamondKeyToDiamondEntry = make(map[DiamondKey]*DiamondEntry, len(bav.DiamondKeyToDiamondEntry))
+	for key, value := range bav.DiamondKeyToDiamondEntry {
+		newView.DiamondKeyToDiamondEntry[key] = value
+	}
+	newView.LikeKeyToLikeEntry = make(map[LikeKey]*LikeEntry, len(bav.LikeKeyToLikeEntry))
+	for key, value := range bav.LikeKeyToLikeEntry {
+		newView.LikeKeyToLikeEntry[key] = value
+	}
+	newView.PostAssociationKeyToPostAssociationEntry = make(
+		map[PostAssociationKey]*PostAssociationEntry, len(bav.PostAssociationKeyToPostAssociationEntry))
+	for key, value := range bav.PostAssociationKeyToPostAssociationEntry {
+		newView.PostAssociationKeyToPostAssociationEntry[key] = value
+	}
+	newView.UserAssociationKeyToUserAssociationEntry = make(
+		map[UserAssociationKey]*UserAssociationEntry, len(bav.UserAssociationKeyToUserAssociationEntry))
+	for key, value := range bav.UserAssociationKeyToUserAssociationEntry {
+		newView.UserAssociationKeyToUserAssociationEntry[key] = value
+	}
+	newView.RepostKeyToRepostEntry = make(map[RepostKey]*RepostEntry, len(bav.RepostKeyToRepostEntry))
+	for key, value := range bav.RepostKeyToRepostEntry {
+		newView.RepostKeyToRepostEntry[key] = value
+	}
+	newView.PostHashToPostEntry = make(map[BlockHash]*PostEntry, len(bav.PostHashToPostEntry))
+	for key, value := range bav.PostHashToPostEntry {
+		newView.PostHashToPostEntry[key] = value
+	}
+	newView.PublicKeyToPKIDEntry = make(map[PkMapKey]*PKIDEntry, len(bav.PublicKeyToPKIDEntry))
+	for key, value := range bav.PublicKeyToPKIDEntry {
+		newView.PublicKeyToPKIDEntry[key] = value
+	}
+	newView.PKIDToPublicKey = make(map[PKID]*PKIDEntry, len(bav.PKIDToPublicKey))
+	for key, value := range bav.PKIDToPublicKey {
+		newView.PKIDToPublicKey[key] = value
+	}
+	newView.ProfilePKIDToProfileEntry = make(map[PKID]*ProfileEntry, len(bav.ProfilePKIDToProfileEntry))
+	for key, value := range bav.ProfilePKIDToProfileEntry {
+		newView.ProfilePKIDToProfileEntry[key] = value
+	}
+	newView.ProfileUsernameToProfileEntry = make(map[UsernameMapKey]*ProfileEntry, len(bav.ProfileUsernameToProfileEntry))
+	for key, value := range bav.ProfileUsernameToProfileEntry {
+		newView.ProfileUsernameToProfileEntry[key] = value
+	}
+	newView.HODLerPKIDCreatorPKIDToBalanceEntry = make(map[BalanceEntryMapKey]*BalanceEntry, len(bav.HODLerPKIDCreatorPKIDToBalanceEntry))
+	for key, value := range bav.HODLerPKIDCreatorPKIDToBalanceEntry {
+		newView.HODLerPKIDCreatorPKIDToBalanceEntry[key] = value
+	}
+	newView.HODLerPKIDCreatorPKIDToDAOCoinBalanceEntry = make(map[BalanceEntryMapKey]*BalanceEntry, len(bav.HODLerPKIDCreatorPKIDToDAOCoinBalanceEntry))
+	for key, value := range bav.HODLerPKIDCreatorPKIDToDAOCoinBalanceEntry {
+		newView.HODLerPKIDCreatorPKIDToDAOCoinBalanceEntry[key] = value
+	}
+	newView.DerivedKeyToDerivedEntry = make(map[DerivedKeyMapKey]*DerivedKeyEntry, len(bav.DerivedKeyToDerivedEntry))
+	for key, value := range bav.DerivedKeyToDerivedEntry {
+		newView.DerivedKeyToDerivedEntry[key] = value
+	}
+	newView.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry = make(map[DAOCoinLimitOrderMapKey]*DAOCoinLimitOrderEntry, len(bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry))
+	for key, value := range bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry {
+		newView.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry[key] = value
+	}
+	newView.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry = make(map[DAOCoinLimitOrderMapKey]*DAOCoinLimitOrderTriggerEntry, len(bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry))
+	for key, value := range bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry {
+		newView.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry[key] = value
+	}
+	newView.dirtyDAOCoinLimitOrderMapKeys = make(map[DAOCoinLimitOrderMapKey]bool, len(bav.dirtyDAOCoinLimitOrderMapKeys))
+	for key, value := range bav.dirtyDAOCoinLimitOrderMapKeys {
+		newView.dirtyDAOCoinLimitOrderMapKeys[key] = value
+	}
+	newView.dirtyDAOCoinLimitOrderTriggerMapKeys = make(map[DAOCoinLimitOrderMapKey]bool, len(bav.dirtyDAOCoinLimitOrderTriggerMapKeys))
+	for key, value := range bav.dirtyDAOCoinLimitOrderTriggerMapKeys {
+		newView.dirtyDAOCoinLimitOrderTriggerMapKeys[key] = value
+	}
+	newView.dirtyProfilePKIDs = make(map[PKID]bool, len(bav.dirtyProfilePKIDs))
+	for key, value := range bav.dirtyProfilePKIDs {
+		newView.dirtyProfilePKIDs[key] = value
+	}
+	newView.revertedToBlockHeight = bav.revertedToBlockHeight
+	return newView
+}
+
 func NewUtxoView(
 	_handle *badger.DB,
 	_params *DeSoParams,
@@ -892,6 +1158,8 @@ func (bav *UtxoView) _disconnectUpdateGlobalParams(
 		prevGlobalParamEntry = &InitialGlobalParamsEntry
 	}
 	bav.GlobalParamsEntry = prevGlobalParamEntry
+	// Blocks are always disconnected in full, so none of this block's updates are left.
+	bav.GlobalParamsHistory[uint64(blockHeight)] = nil
 
 	// Reset any modified forbidden pub key entries if they exist.
 	if operationData.PrevForbiddenPubKeyEntry != nil {
@@ -908,6 +1176,10 @@ func (bav *UtxoView) _disconnectUpdateGlobalParams(
 func (bav *UtxoView) DisconnectTransaction(currentTxn *MsgDeSoTxn, txnHash *BlockHash,
 	utxoOpsForTxn []*UtxoOperation, blockHeight uint32) error {
 
+	if bav.isReadOnly {
+		return ErrUtxoViewReadOnly
+	}
+
 	if currentTxn.TxnMeta.GetTxnType() == TxnTypeBlockReward || currentTxn.TxnMeta.GetTxnType() == TxnTypeBasicTransfer {
 		return bav._disconnectBasicTransfer(
 			currentTxn, txnHash, utxoOpsForTxn, blockHeight)
@@ -968,6 +1240,14 @@ func (bav *UtxoView) DisconnectTransaction(currentTxn *MsgDeSoTxn, txnHash *Bloc
 		return bav._disconnectDAOCoinLimitOrder(
 			OperationTypeDAOCoinLimitOrder, currentTxn, txnHash, utxoOpsForTxn, blockHeight)
 
+	} else if currentTxn.TxnMeta.GetTxnType() == TxnTypePostAssociation {
+		return bav._disconnectPostAssociation(
+			OperationTypePostAssociation, currentTxn, txnHash, utxoOpsForTxn, blockHeight)
+
+	} else if currentTxn.TxnMeta.GetTxnType() == TxnTypeUserAssociation {
+		return bav._disconnectUserAssociation(
+			OperationTypeUserAssociation, currentTxn, txnHash, utxoOpsForTxn, blockHeight)
+
 	} else if currentTxn.TxnMeta.GetTxnType() == TxnTypeSwapIdentity {
 		return bav._disconnectSwapIdentity(
 			OperationTypeSwapIdentity, currentTxn, txnHash, utxoOpsForTxn, blockHeight)
@@ -1012,6 +1292,10 @@ func (bav *UtxoView) DisconnectTransaction(currentTxn *MsgDeSoTxn, txnHash *Bloc
 func (bav *UtxoView) DisconnectBlock(
 	desoBlock *MsgDeSoBlock, txHashes []*BlockHash, utxoOps [][]*UtxoOperation, blockHeight uint64) error {
 
+	if bav.isReadOnly {
+		return ErrUtxoViewReadOnly
+	}
+
 	glog.Infof("DisconnectBlock: Disconnecting block %v", desoBlock)
 
 	// Verify that the block being disconnected is the current tip. DisconnectBlock
@@ -1082,6 +1366,12 @@ func (bav *UtxoView) DisconnectBlock(
 		utxoOpsForTxn := utxoOps[txnIndex]
 		desoBlockHeight := desoBlock.Header.Height
 
+		// The block reward's ops end with the op that pruned the block's expired
+		// DAO coin limit orders, if any were, so we restore them first.
+		if txnIndex == 0 {
+			utxoOpsForTxn = bav._disconnectDAOCoinLimitOrderExpiration(utxoOpsForTxn)
+		}
+
 		err := bav.DisconnectTransaction(currentTxn, txnHash, utxoOpsForTxn, uint32(desoBlockHeight))
 		if err != nil {
 			return errors.Wrapf(err, "DisconnectBlock: Problem disconnecting transaction: %v", currentTxn)
@@ -1095,6 +1385,10 @@ func (bav *UtxoView) DisconnectBlock(
 	// Update the tip to point to the parent of this block since we've managed
 	// to successfully disconnect it.
 	bav.TipHash = desoBlock.Header.PrevBlockHash
+	if blockHeight > 0 {
+		revertedToBlockHeight := blockHeight - 1
+		bav.revertedToBlockHeight = &revertedToBlockHeight
+	}
 
 	return nil
 }
@@ -1115,15 +1409,24 @@ func _isEntryImmatureBlockReward(utxoEntry *UtxoEntry, blockHeight uint32, param
 }
 
 func (bav *UtxoView) _verifySignature(txn *MsgDeSoTxn, blockHeight uint32) (_derivedPkBytes []byte, _err error) {
+	defer bav.blockConnectProfile.RecordSignatureCheck(time.Now())
+
 	if txn.Signature.Sign == nil {
 		return nil, fmt.Errorf("_verifySignature: Transaction signature is empty")
 	}
-	// Compute a hash of the transaction.
-	txBytes, err := txn.ToBytes(true /*preSignature*/)
-	if err != nil {
-		return nil, errors.Wrapf(err, "_verifySignature: Problem serializing txn without signature: ")
+	// Compute a hash of the transaction, unless the batch check already did.
+	batchCheck := bav.batchSignatureChecks[txn]
+	isBatchVerified := batchCheck != nil && batchCheck.IsValid
+	var txHash *BlockHash
+	if batchCheck != nil {
+		txHash = batchCheck.SignatureHash
+	} else {
+		txBytes, err := txn.ToBytes(true /*preSignature*/)
+		if err != nil {
+			return nil, errors.Wrapf(err, "_verifySignature: Problem serializing txn without signature: ")
+		}
+		txHash = Sha256DoubleHash(txBytes)
 	}
-	txHash := Sha256DoubleHash(txBytes)
 
 	// Look for the derived key in transaction ExtraData and validate it. For transactions
 	// signed using a derived key, the derived public key is passed in ExtraData. Alternatively,
@@ -1137,7 +1440,7 @@ func (bav *UtxoView) _verifySignature(txn *MsgDeSoTxn, blockHeight uint32) (_der
 	}
 	// If we got a derived key then try parsing it.
 	if isDerived {
-		derivedPk, err = btcec.ParsePubKey(derivedPkBytes, btcec.S256())
+		derivedPk, err = ParsePubKeyCached(derivedPkBytes)
 		if err != nil {
 			return nil, fmt.Errorf("%v %v", RuleErrorDerivedKeyInvalidExtraData, RuleErrorDerivedKeyInvalidRecoveryId)
 		}
@@ -1145,7 +1448,7 @@ func (bav *UtxoView) _verifySignature(txn *MsgDeSoTxn, blockHeight uint32) (_der
 
 	// Get the owner public key and attempt turning it into *btcec.PublicKey.
 	ownerPkBytes := txn.PublicKey
-	ownerPk, err := btcec.ParsePubKey(ownerPkBytes, btcec.S256())
+	ownerPk, err := ParsePubKeyCached(ownerPkBytes)
 	if err != nil {
 		return nil, errors.Wrapf(err, "_verifySignature: Problem parsing owner public key: ")
 	}
@@ -1154,7 +1457,7 @@ func (bav *UtxoView) _verifySignature(txn *MsgDeSoTxn, blockHeight uint32) (_der
 	// If derived key *was* used, we check if transaction was signed by the derived key.
 	if derivedPk == nil {
 		// Verify that the transaction is signed by the specified key.
-		if txn.Signature.Verify(txHash[:], ownerPk) {
+		if isBatchVerified || txn.Signature.Verify(txHash[:], ownerPk) {
 			return nil, nil
 		}
 	} else {
@@ -1165,7 +1468,7 @@ func (bav *UtxoView) _verifySignature(txn *MsgDeSoTxn, blockHeight uint32) (_der
 
 		// All checks passed so we try to verify the signature. This step can be avoided for DeSo-DER signatures
 		// but we run it redundantly just in case.
-		if txn.Signature.Verify(txHash[:], derivedPk) {
+		if isBatchVerified || txn.Signature.Verify(txHash[:], derivedPk) {
 			return derivedPk.SerializeCompressed(), nil
 		}
 
@@ -1559,6 +1862,16 @@ func (bav *UtxoView) _checkDerivedKeySpendingLimit(
 		return utxoOpsForTxn, errors.Wrap(RuleErrorDerivedKeyNotAuthorized,
 			"_checkDerivedKeySpendingLimit: TransactionSpendingLimitTracker is nil")
 	}
+	txnType := txn.TxnMeta.GetTxnType()
+
+	// If the derived key is scoped to a set of txn types, it can't sign any other txn type. This
+	// applies to unlimited keys as well.
+	allowedTxnTypes := derivedKeyEntry.TransactionSpendingLimitTracker.AllowedTxnTypes
+	if allowedTxnTypes != 0 && !allowedTxnTypes.Contains(txnType) {
+		return utxoOpsForTxn, errors.Wrapf(RuleErrorDerivedKeyTxnTypeNotInScope,
+			"_checkDerivedKeySpendingLimit: Txn type %v is not in the scope of the derived key", txnType.String())
+	}
+
 	// If the derived key is an unlimited key, we don't need to check spending limits whatsoever.
 	if derivedKeyEntry.TransactionSpendingLimitTracker.IsUnlimited {
 		return utxoOpsForTxn, nil
@@ -1601,7 +1914,15 @@ func (bav *UtxoView) _checkDerivedKeySpendingLimit(
 	// Decrement the global limit by the spend amount
 	derivedKeyEntry.TransactionSpendingLimitTracker.GlobalDESOLimit -= spendAmount
 
-	txnType := txn.TxnMeta.GetTxnType()
+	// If the derived key has a DESO limit for this txn type, the spend amount counts against it as well.
+	if txnTypeDESOLimit, exists := derivedKeyEntry.TransactionSpendingLimitTracker.TxnTypeDESOLimitMap[txnType]; exists {
+		if spendAmount > txnTypeDESOLimit {
+			return utxoOpsForTxn, errors.Wrapf(RuleErrorDerivedKeyTxnSpendsMoreThanTxnTypeDESOLimit,
+				"_checkDerivedKeySpendingLimit: Spend Amount %v Exceeds DESO Limit %v for Txn Type %v",
+				spendAmount, txnTypeDESOLimit, txnType.String())
+		}
+		derivedKeyEntry.TransactionSpendingLimitTracker.TxnTypeDESOLimitMap[txnType] -= spendAmount
+	}
 
 	var err error
 	// Okay now we've validated that we can do the op. Decrement the special counters if applicable
@@ -2117,6 +2438,122 @@ func (bav *UtxoView) _connectUpdateGlobalParams(
 		newGlobalParamsEntry.MaxCopiesPerNFT = newMaxCopiesPerNFT
 	}
 
+	if blockHeight >= bav.Params.ForkHeights.DAOCoinLimitOrderMinSizeAndTickBlockHeight {
+		if minQuantityBytes, exists := extraData[MinDAOCoinLimitOrderQuantityKey]; exists {
+			if len(minQuantityBytes) > 32 {
+				return 0, 0, nil, fmt.Errorf("_connectUpdateGlobalParams: unable to decode " +
+					"MinDAOCoinLimitOrderQuantityInBaseUnits as uint256")
+			}
+			newGlobalParamsEntry.MinDAOCoinLimitOrderQuantityInBaseUnits = uint256.NewInt().SetBytes(minQuantityBytes)
+		}
+		if exchangeRateTickBytes, exists := extraData[DAOCoinLimitOrderExchangeRateTickKey]; exists {
+			if len(exchangeRateTickBytes) > 32 {
+				return 0, 0, nil, fmt.Errorf("_connectUpdateGlobalParams: unable to decode " +
+					"DAOCoinLimitOrderScaledExchangeRateTick as uint256")
+			}
+			newGlobalParamsEntry.DAOCoinLimitOrderScaledExchangeRateTick = uint256.NewInt().SetBytes(exchangeRateTickBytes)
+		}
+	}
+
+	if blockHeight >= bav.Params.ForkHeights.DAOCoinLimitOrderMakerTakerFeesBlockHeight {
+		if len(extraData[DAOCoinLimitOrderMakerFeeBasisPointsKey]) > 0 {
+			newMakerFeeBasisPoints, makerFeeBasisPointsBytesRead := Uvarint(extraData[DAOCoinLimitOrderMakerFeeBasisPointsKey])
+			if makerFeeBasisPointsBytesRead <= 0 {
+				return 0, 0, nil, fmt.Errorf("_connectUpdateGlobalParams: unable to decode DAOCoinLimitOrderMakerFeeBasisPoints as uint64")
+			}
+			if newMakerFeeBasisPoints > MaxDAOCoinLimitOrderFeeBasisPoints {
+				return 0, 0, nil, RuleErrorDAOCoinLimitOrderMakerFeeTooHigh
+			}
+			newGlobalParamsEntry.DAOCoinLimitOrderMakerFeeBasisPoints = newMakerFeeBasisPoints
+		}
+		if len(extraData[DAOCoinLimitOrderTakerFeeBasisPointsKey]) > 0 {
+			newTakerFeeBasisPoints, takerFeeBasisPointsBytesRead := Uvarint(extraData[DAOCoinLimitOrderTakerFeeBasisPointsKey])
+			if takerFeeBasisPointsBytesRead <= 0 {
+				return 0, 0, nil, fmt.Errorf("_connectUpdateGlobalParams: unable to decode DAOCoinLimitOrderTakerFeeBasisPoints as uint64")
+			}
+			if newTakerFeeBasisPoints > MaxDAOCoinLimitOrderFeeBasisPoints {
+				return 0, 0, nil, RuleErrorDAOCoinLimitOrderTakerFeeTooHigh
+			}
+			newGlobalParamsEntry.DAOCoinLimitOrderTakerFeeBasisPoints = newTakerFeeBasisPoints
+		}
+		// An empty fee destination turns the fees off.
+		if feeDestinationPubKey, exists := extraData[DAOCoinLimitOrderFeeDestinationPublicKeyKey]; exists {
+			if len(feeDestinationPubKey) != 0 && len(feeDestinationPubKey) != btcec.PubKeyBytesLenCompressed {
+				return 0, 0, nil, RuleErrorDAOCoinLimitOrderFeeDestinationPubKeyLength
+			}
+			newGlobalParamsEntry.DAOCoinLimitOrderFeeDestinationPublicKey = feeDestinationPubKey
+		}
+	}
+
+	if blockHeight >= bav.Params.ForkHeights.TxnTypeGlobalParamsBlockHeight {
+		if minFeesBytes, exists := extraData[MinNetworkFeeNanosPerKBByTxnTypeKey]; exists {
+			minFees, err := DeserializeTxnTypeToUint64Map(minFeesBytes)
+			if err != nil {
+				return 0, 0, nil, errors.Wrapf(err, "_connectUpdateGlobalParams: unable to decode "+
+					"MinNetworkFeeNanosPerKBByTxnType")
+			}
+			// Build a new map so that the previous entry, which the disconnect restores, is untouched.
+			newMinFees := make(map[TxnType]uint64)
+			for txnType, minFeeNanosPerKB := range prevGlobalParamsEntry.MinimumNetworkFeeNanosPerKBByTxnType {
+				newMinFees[txnType] = minFeeNanosPerKB
+			}
+			for txnType, minFeeNanosPerKB := range minFees {
+				if txnType == TxnTypeUnset || txnType.GetTxnString() == TxnStringUndefined {
+					return 0, 0, nil, errors.Wrapf(RuleErrorGlobalParamsInvalidTxnType,
+						"_connectUpdateGlobalParams: txn type %d", txnType)
+				}
+				if minFeeNanosPerKB > MaxNetworkFeeNanosPerKBValue {
+					return 0, 0, nil, RuleErrorMinNetworkFeeTooHigh
+				}
+				if minFeeNanosPerKB == 0 {
+					delete(newMinFees, txnType)
+					continue
+				}
+				newMinFees[txnType] = minFeeNanosPerKB
+			}
+			newGlobalParamsEntry.MinimumNetworkFeeNanosPerKBByTxnType = nil
+			if len(newMinFees) > 0 {
+				newGlobalParamsEntry.MinimumNetworkFeeNanosPerKBByTxnType = newMinFees
+			}
+		}
+		if disabledBytes, exists := extraData[DisabledTxnTypesKey]; exists {
+			disabled, err := DeserializeTxnTypeToUint64Map(disabledBytes)
+			if err != nil {
+				return 0, 0, nil, errors.Wrapf(err, "_connectUpdateGlobalParams: unable to decode "+
+					"DisabledTxnTypes")
+			}
+			newDisabledTxnTypes := make(map[TxnType]bool)
+			for txnType := range prevGlobalParamsEntry.DisabledTxnTypes {
+				newDisabledTxnTypes[txnType] = true
+			}
+			for txnType, isDisabled := range disabled {
+				if txnType == TxnTypeUnset || txnType.GetTxnString() == TxnStringUndefined {
+					return 0, 0, nil, errors.Wrapf(RuleErrorGlobalParamsInvalidTxnType,
+						"_connectUpdateGlobalParams: txn type %d", txnType)
+				}
+				// Block rewards can't be disabled or no block could be connected, and global params
+				// updates can't be disabled or they could never be enabled again.
+				if txnType == TxnTypeBlockReward || txnType == TxnTypeUpdateGlobalParams {
+					return 0, 0, nil, errors.Wrapf(RuleErrorGlobalParamsCannotDisableTxnType,
+						"_connectUpdateGlobalParams: txn type %v", txnType)
+				}
+				switch isDisabled {
+				case 0:
+					delete(newDisabledTxnTypes, txnType)
+				case 1:
+					newDisabledTxnTypes[txnType] = true
+				default:
+					return 0, 0, nil, errors.Wrapf(RuleErrorGlobalParamsInvalidDisabledValue,
+						"_connectUpdateGlobalParams: value %d for txn type %v", isDisabled, txnType)
+				}
+			}
+			newGlobalParamsEntry.DisabledTxnTypes = nil
+			if len(newDisabledTxnTypes) > 0 {
+				newGlobalParamsEntry.DisabledTxnTypes = newDisabledTxnTypes
+			}
+		}
+	}
+
 	var newForbiddenPubKeyEntry *ForbiddenPubKeyEntry
 	var prevForbiddenPubKeyEntry *ForbiddenPubKeyEntry
 	var forbiddenPubKey []byte
@@ -2158,6 +2595,8 @@ func (bav *UtxoView) _connectUpdateGlobalParams(
 	// Update the GlobalParamsEntry using the txn's ExtraData. Save the previous value
 	// so it can be easily reverted.
 	bav.GlobalParamsEntry = &newGlobalParamsEntry
+	// Record the entry in the params history. If the block has several updates, the last one wins.
+	bav.GlobalParamsHistory[uint64(blockHeight)] = &newGlobalParamsEntry
 
 	// Update the forbidden pub key entry on the view, if we have one to update.
 	if newForbiddenPubKeyEntry != nil {
@@ -2231,6 +2670,10 @@ func (bav *UtxoView) ConnectTransaction(txn *MsgDeSoTxn, txHash *BlockHash,
 	_utxoOps []*UtxoOperation, _totalInput uint64, _totalOutput uint64,
 	_fees uint64, _err error) {
 
+	if bav.isReadOnly {
+		return nil, 0, 0, 0, ErrUtxoViewReadOnly
+	}
+
 	return bav._connectTransaction(txn, txHash,
 		txnSizeBytes,
 		blockHeight, verifySignatures,
@@ -2248,6 +2691,14 @@ func (bav *UtxoView) _connectTransaction(txn *MsgDeSoTxn, txHash *BlockHash,
 		return nil, 0, 0, 0, errors.Wrapf(err, "_connectTransaction: ")
 	}
 
+	// The param updater can disable txn types.
+	if blockHeight >= bav.Params.ForkHeights.TxnTypeGlobalParamsBlockHeight &&
+		bav.GlobalParamsEntry.DisabledTxnTypes[txn.TxnMeta.GetTxnType()] {
+
+		return nil, 0, 0, 0, errors.Wrapf(RuleErrorTxnTypeDisabled, "_connectTransaction: %v",
+			txn.TxnMeta.GetTxnType())
+	}
+
 	// Don't allow transactions that take up more than half of the block.
 	txnBytes, err := txn.ToBytes(false)
 	if err != nil {
@@ -2335,6 +2786,16 @@ func (bav *UtxoView) _connectTransaction(txn *MsgDeSoTxn, txHash *BlockHash,
 			bav._connectDAOCoinLimitOrder(
 				txn, txHash, blockHeight, verifySignatures)
 
+	} else if txn.TxnMeta.GetTxnType() == TxnTypePostAssociation {
+		totalInput, totalOutput, utxoOpsForTxn, err =
+			bav._connectPostAssociation(
+				txn, txHash, blockHeight, verifySignatures)
+
+	} else if txn.TxnMeta.GetTxnType() == TxnTypeUserAssociation {
+		totalInput, totalOutput, utxoOpsForTxn, err =
+			bav._connectUserAssociation(
+				txn, txHash, blockHeight, verifySignatures)
+
 	} else if txn.TxnMeta.GetTxnType() == TxnTypeSwapIdentity {
 		totalInput, totalOutput, utxoOpsForTxn, err =
 			bav._connectSwapIdentity(
@@ -2411,13 +2872,18 @@ func (bav *UtxoView) _connectTransaction(txn *MsgDeSoTxn, txHash *BlockHash,
 	// enough fees to get mined into the Bitcoin blockchain itself then they're almost certainly not spam.
 	// If the transaction size was set to 0, skip validating the fee is above the minimum.
 	// If the current minimum network fee per kb is set to 0, that indicates we should not assess a minimum fee.
-	if txn.TxnMeta.GetTxnType() != TxnTypeBitcoinExchange && txnSizeBytes != 0 && bav.GlobalParamsEntry.MinimumNetworkFeeNanosPerKB != 0 {
+	// After the TxnTypeGlobalParamsBlockHeight, the txn type can have a minimum fee of its own.
+	minNetworkFeeNanosPerKB := bav.GlobalParamsEntry.MinimumNetworkFeeNanosPerKB
+	if blockHeight >= bav.Params.ForkHeights.TxnTypeGlobalParamsBlockHeight {
+		minNetworkFeeNanosPerKB = bav.GlobalParamsEntry.MinimumNetworkFeeNanosPerKBForTxnType(txn.TxnMeta.GetTxnType())
+	}
+	if txn.TxnMeta.GetTxnType() != TxnTypeBitcoinExchange && txnSizeBytes != 0 && minNetworkFeeNanosPerKB != 0 {
 		// Make sure there isn't overflow in the fee.
 		if fees != ((fees * 1000) / 1000) {
 			return nil, 0, 0, 0, RuleErrorOverflowDetectedInFeeRateCalculation
 		}
 		// If the fee is less than the minimum network fee per KB, return an error.
-		if (fees*1000)/uint64(txnSizeBytes) < bav.GlobalParamsEntry.MinimumNetworkFeeNanosPerKB {
+		if (fees*1000)/uint64(txnSizeBytes) < minNetworkFeeNanosPerKB {
 			return nil, 0, 0, 0, RuleErrorTxnFeeBelowNetworkMinimum
 		}
 	}
@@ -2427,16 +2893,48 @@ func (bav *UtxoView) _connectTransaction(txn *MsgDeSoTxn, txHash *BlockHash,
 
 func (bav *UtxoView) ConnectBlock(
 	desoBlock *MsgDeSoBlock, txHashes []*BlockHash, verifySignatures bool, eventManager *EventManager, blockHeight uint64) (
-	[][]*UtxoOperation, error) {
+	_utxoOpsForBlock [][]*UtxoOperation, _err error) {
+
+	if bav.isReadOnly {
+		return nil, ErrUtxoViewReadOnly
+	}
 
 	glog.V(1).Infof("ConnectBlock: Connecting block %v", desoBlock)
 
+	_, dbSpan := StartDBSpan(context.Background(), "UtxoView.ConnectBlock", nil,
+		attribute.Int64("block.height", int64(blockHeight)),
+		attribute.Int("block.num_txns", len(desoBlock.Txns)))
+	defer func() {
+		dbSpan.End(_err)
+	}()
+
 	// Check that the block being connected references the current tip. ConnectBlock
 	// can only add a block to the current tip. We do this to keep the API simple.
 	if *desoBlock.Header.PrevBlockHash != *bav.TipHash {
 		return nil, fmt.Errorf("ConnectBlock: Parent hash of block being connected does not match tip")
 	}
 
+	// Start a new profile for this block if profiling is enabled. The caller persists it when
+	// it flushes the view.
+	bav.blockConnectProfile = nil
+	if IsBlockConnectProfilingEnabled() {
+		profileBlockHash, err := desoBlock.Header.Hash()
+		if err != nil {
+			return nil, errors.Wrapf(err, "ConnectBlock: Problem computing block hash")
+		}
+		bav.blockConnectProfile = NewBlockConnectProfile(profileBlockHash, blockHeight)
+	}
+	defer bav.blockConnectProfile.RecordConnect(time.Now())
+
+	// Check all the signatures up front if batch verification is enabled. The results only apply
+	// to this block, so they're dropped once it's connected.
+	if verifySignatures && IsBatchSignatureVerificationEnabled() {
+		bav.batchSignatureChecks = VerifyTxnSignaturesInBatch(desoBlock.Txns)
+		defer func() {
+			bav.batchSignatureChecks = nil
+		}()
+	}
+
 	blockHeader := desoBlock.Header
 	// Loop through all the transactions and validate them using the view. Also
 	// keep track of the total fees throughout.
@@ -2453,9 +2951,11 @@ func (bav *UtxoView) ConnectBlock(
 		// would slow down block processing significantly. We should figure out a way to
 		// enforce this check in the future, but for now the only attack vector is one in
 		// which a miner is trying to spam the network, which should generally never happen.
+		txnConnectStartTime := time.Now()
 		utxoOpsForTxn, totalInput, totalOutput, currentFees, err := bav.ConnectTransaction(
 			txn, txHash, 0, uint32(blockHeader.Height), verifySignatures, false /*ignoreUtxos*/)
 		_, _ = totalInput, totalOutput // A bit surprising we don't use these
+		bav.blockConnectProfile.RecordTxnConnect(txn.TxnMeta.GetTxnType(), txnConnectStartTime)
 		if err != nil {
 			return nil, errors.Wrapf(err, "ConnectBlock: error connecting txn #%d", txIndex)
 		}
@@ -2468,6 +2968,18 @@ func (bav *UtxoView) ConnectBlock(
 		}
 		totalFees += currentFees
 
+		// Once the block reward connects, prune the DAO coin limit orders that expired
+		// before this block. The op that restores them goes with the block reward's ops.
+		if txIndex == 0 && uint32(blockHeader.Height) >= bav.Params.ForkHeights.DAOCoinLimitOrderExpirationBlockHeight {
+			expirationOp, err := bav._expireDAOCoinLimitOrders(uint32(blockHeader.Height))
+			if err != nil {
+				return nil, errors.Wrapf(err, "ConnectBlock: Problem expiring DAO coin limit orders")
+			}
+			if expirationOp != nil {
+				utxoOpsForTxn = append(utxoOpsForTxn, expirationOp)
+			}
+		}
+
 		// Add the utxo operations to our list for all the txns.
 		utxoOps = append(utxoOps, utxoOpsForTxn)
 
@@ -2524,6 +3036,7 @@ func (bav *UtxoView) ConnectBlock(
 		return nil, fmt.Errorf("ConnectBlock: Problem computing block hash after validation")
 	}
 	bav.TipHash = blockHash
+	bav.revertedToBlockHeight = nil
 
 	return utxoOps, nil
 }
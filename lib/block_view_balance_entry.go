@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -298,7 +300,7 @@ func (bav *UtxoView) HelpConnectCoinTransfer(
 	if len(receiverPublicKey) != btcec.PubKeyBytesLenCompressed {
 		return 0, 0, nil, RuleErrorCoinTransferInvalidReceiverPubKeySize
 	}
-	if _, err = btcec.ParsePubKey(receiverPublicKey, btcec.S256()); err != nil {
+	if _, err = ParsePubKeyCached(receiverPublicKey); err != nil {
 		return 0, 0, nil, errors.Wrap(
 			RuleErrorCoinTransferInvalidReceiverPubKey, err.Error())
 	}
@@ -313,7 +315,7 @@ func (bav *UtxoView) HelpConnectCoinTransfer(
 	if len(profilePublicKey) != btcec.PubKeyBytesLenCompressed {
 		return 0, 0, nil, RuleErrorCoinTransferInvalidProfilePubKeySize
 	}
-	if _, err = btcec.ParsePubKey(profilePublicKey, btcec.S256()); err != nil {
+	if _, err = ParsePubKeyCached(profilePublicKey); err != nil {
 		return 0, 0, nil, errors.Wrap(
 			RuleErrorCoinTransferInvalidProfilePubKey, err.Error())
 	}
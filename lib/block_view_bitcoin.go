@@ -1,8 +1,9 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
 	"fmt"
-	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
@@ -46,39 +47,6 @@ func (bav *UtxoView) _deleteBitcoinBurnTxIDMappings(bitcoinBurnTxID *BlockHash)
 	bav.BitcoinBurnTxIDs[*bitcoinBurnTxID] = false
 }
 
-func ExtractBitcoinPublicKeyFromBitcoinTransactionInputs(
-	bitcoinTransaction *wire.MsgTx, btcdParams *chaincfg.Params) (
-	_publicKey *btcec.PublicKey, _err error) {
-
-	for _, input := range bitcoinTransaction.TxIn {
-		// P2PKH follows the form: <sig len> <sig> <pubKeyLen> <pubKey>
-		if len(input.SignatureScript) == 0 {
-			continue
-		}
-		sigLen := input.SignatureScript[0]
-		pubKeyStart := sigLen + 2
-		pubKeyBytes := input.SignatureScript[pubKeyStart:]
-		addr, err := btcutil.NewAddressPubKey(pubKeyBytes, btcdParams)
-		if err != nil {
-			continue
-		}
-
-		// If we were able to successfully decode the bytes into a public key, return it.
-		if addr.PubKey() != nil {
-			return addr.PubKey(), nil
-		}
-
-		// If we get here it means we could not extract a public key from this
-		// particular input. This is OK as long as we can find a public key in
-		// one of the other inputs.
-	}
-
-	// If we get here it means we went through all the inputs and were not able to
-	// successfully decode a public key from the inputs. Error in this case.
-	return nil, fmt.Errorf("ExtractBitcoinPublicKeyFromBitcoinTransactionInputs: " +
-		"No valid public key found after scanning all input signature scripts")
-}
-
 func _computeBitcoinBurnOutput(bitcoinTransaction *wire.MsgTx, bitcoinBurnAddress string,
 	btcdParams *chaincfg.Params) (_burnedOutputSatoshis int64, _err error) {
 
@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -1896,6 +1898,13 @@ func TestSpendOffOfUnminedTxnsBitcoinExchange(t *testing.T) {
 					-1,
 					0,
 					nil,
+					nil,
+					nil,
+					-1,
+					-1,
+					nil,
+					nil,
+					nil,
 					100, /*feeRateNanosPerKB*/
 					nil,
 					[]*DeSoOutput{})
@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -332,7 +334,7 @@ func (bav *UtxoView) HelpConnectDAOCoinInitialization(txn *MsgDeSoTxn, txHash *B
 		return 0, 0, nil, nil, RuleErrorDAOCoinInvalidPubKeySize
 	}
 
-	if _, err = btcec.ParsePubKey(txMeta.ProfilePublicKey, btcec.S256()); err != nil {
+	if _, err = ParsePubKeyCached(txMeta.ProfilePublicKey); err != nil {
 		return 0, 0, nil, nil, errors.Wrap(RuleErrorDAOCoinInvalidPubKey, err.Error())
 	}
 
@@ -1,9 +1,12 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
 	"bytes"
 	"fmt"
 	"github.com/davecgh/go-spew/spew"
+	"github.com/dgraph-io/badger/v3"
 	"github.com/golang/glog"
 	"github.com/holiman/uint256"
 	"github.com/pkg/errors"
@@ -213,6 +216,27 @@ func (bav *UtxoView) _connectDAOCoinLimitOrder(
 	// Grab the txn metadata.
 	txMeta := txn.TxnMeta.(*DAOCoinLimitOrderMetadata)
 
+	if (txMeta.CancelAllForPair || txMeta.ReplaceOrderID != nil) &&
+		blockHeight < bav.Params.ForkHeights.DAOCoinLimitOrderCancelAllAndReplaceBlockHeight {
+		return 0, 0, nil, RuleErrorDAOCoinLimitOrderCancelAllAndReplaceBeforeBlockHeight
+	}
+	if txMeta.MarketOrderMaxSlippageBasisPoints != 0 &&
+		blockHeight < bav.Params.ForkHeights.DAOCoinLimitOrderMarketOrderSlippageBlockHeight {
+		return 0, 0, nil, RuleErrorDAOCoinLimitOrderMarketOrderSlippageBeforeBlockHeight
+	}
+	if txMeta.TriggerScaledExchangeRate != nil &&
+		blockHeight < bav.Params.ForkHeights.DAOCoinLimitOrderTriggerBlockHeight {
+		return 0, 0, nil, RuleErrorDAOCoinLimitOrderTriggerBeforeBlockHeight
+	}
+	if txMeta.ExpirationBlockHeight != 0 {
+		if blockHeight < bav.Params.ForkHeights.DAOCoinLimitOrderExpirationBlockHeight {
+			return 0, 0, nil, RuleErrorDAOCoinLimitOrderExpirationBeforeBlockHeight
+		}
+		if txMeta.ExpirationBlockHeight < blockHeight {
+			return 0, 0, nil, RuleErrorDAOCoinLimitOrderExpirationBlockHeightPassed
+		}
+	}
+
 	// Validate txn metadata.
 	err := bav.IsValidDAOCoinLimitOrderMetadata(txn.PublicKey, txMeta)
 	if err != nil {
@@ -272,7 +296,11 @@ func (bav *UtxoView) _connectDAOCoinLimitOrder(
 	if (txMeta.FeeNanos * 1000) <= txMeta.FeeNanos {
 		return 0, 0, nil, RuleErrorDAOCoinLimitOrderFeeNanosOverflow
 	}
-	if (txMeta.FeeNanos*1000)/uint64(len(txnBytes)) < bav.GlobalParamsEntry.MinimumNetworkFeeNanosPerKB ||
+	minNetworkFeeNanosPerKB := bav.GlobalParamsEntry.MinimumNetworkFeeNanosPerKB
+	if blockHeight >= bav.Params.ForkHeights.TxnTypeGlobalParamsBlockHeight {
+		minNetworkFeeNanosPerKB = bav.GlobalParamsEntry.MinimumNetworkFeeNanosPerKBForTxnType(TxnTypeDAOCoinLimitOrder)
+	}
+	if (txMeta.FeeNanos*1000)/uint64(len(txnBytes)) < minNetworkFeeNanosPerKB ||
 		txMeta.FeeNanos == 0 {
 		return 0, 0, nil, RuleErrorDAOCoinLimitOrderFeeNanosBelowMinTxFee
 	}
@@ -293,6 +321,34 @@ func (bav *UtxoView) _connectDAOCoinLimitOrder(
 		if err != nil {
 			return 0, 0, nil, err
 		}
+
+		// If there's no such order on the book, it may be a dormant trigger order.
+		if existingTransactorOrder == nil &&
+			blockHeight >= bav.Params.ForkHeights.DAOCoinLimitOrderTriggerBlockHeight {
+
+			existingTriggerEntry, err := bav._getDAOCoinLimitOrderTriggerEntry(txMeta.CancelOrderID)
+			if err != nil {
+				return 0, 0, nil, err
+			}
+			if existingTriggerEntry != nil {
+				if !transactorPKIDEntry.PKID.Eq(existingTriggerEntry.Order.TransactorPKID) {
+					return 0, 0, nil, RuleErrorDAOCoinLimitOrderToCancelNotYours
+				}
+				prevTriggerEntry := existingTriggerEntry.Copy()
+				bav._deleteDAOCoinLimitOrderTriggerEntryMappings(existingTriggerEntry)
+
+				utxoOpsForTxn = append(utxoOpsForTxn, &UtxoOperation{
+					Type:                                OperationTypeDAOCoinLimitOrder,
+					PrevDAOCoinLimitOrderTriggerEntries: []*DAOCoinLimitOrderTriggerEntry{prevTriggerEntry},
+				})
+				return totalInput, totalOutput, utxoOpsForTxn, nil
+			}
+		}
+		// An expired order is as good as gone, since it's pruned before
+		// any txn in the block connects.
+		if existingTransactorOrder != nil && existingTransactorOrder.IsExpired(blockHeight) {
+			existingTransactorOrder = nil
+		}
 		if existingTransactorOrder == nil {
 			return 0, 0, nil, RuleErrorDAOCoinLimitOrderToCancelNotFound
 		}
@@ -314,6 +370,50 @@ func (bav *UtxoView) _connectDAOCoinLimitOrder(
 		return totalInput, totalOutput, utxoOpsForTxn, nil
 	}
 
+	// If the transactor wants to cancel all of their
+	// orders for a pair, find and delete them all.
+	if txMeta.CancelAllForPair {
+		totalInput, totalOutput, utxoOpsForTxn, err := bav._connectBasicTransfer(
+			txn, txHash, blockHeight, verifySignatures)
+		if err != nil {
+			return 0, 0, nil, errors.Wrapf(err, "_connectDAOCoinLimitOrder")
+		}
+
+		buyCoinPKID := bav.GetPKIDForPublicKey(txMeta.BuyingDAOCoinCreatorPublicKey.ToBytes()).PKID
+		sellCoinPKID := bav.GetPKIDForPublicKey(txMeta.SellingDAOCoinCreatorPublicKey.ToBytes()).PKID
+		transactorOrders, err := bav.GetAllDAOCoinLimitOrdersForThisTransactor(transactorPKIDEntry.PKID)
+		if err != nil {
+			return 0, 0, nil, errors.Wrapf(err, "_connectDAOCoinLimitOrder: ")
+		}
+		// Orders are returned in map order, so we sort them to keep the utxo op deterministic.
+		sort.Slice(transactorOrders, func(ii, jj int) bool {
+			return bytes.Compare(transactorOrders[ii].OrderID[:], transactorOrders[jj].OrderID[:]) < 0
+		})
+
+		// The cancelled orders are saved in PrevMatchingOrders, which
+		// disconnect already restores. Orders on both sides of the
+		// book are cancelled.
+		prevTransactorOrders := []*DAOCoinLimitOrderEntry{}
+		for _, transactorOrder := range transactorOrders {
+			isSameSide := transactorOrder.BuyingDAOCoinCreatorPKID.Eq(buyCoinPKID) &&
+				transactorOrder.SellingDAOCoinCreatorPKID.Eq(sellCoinPKID)
+			isOtherSide := transactorOrder.BuyingDAOCoinCreatorPKID.Eq(sellCoinPKID) &&
+				transactorOrder.SellingDAOCoinCreatorPKID.Eq(buyCoinPKID)
+			if (!isSameSide && !isOtherSide) || transactorOrder.IsExpired(blockHeight) {
+				continue
+			}
+			prevTransactorOrders = append(prevTransactorOrders, transactorOrder.Copy())
+			bav._deleteDAOCoinLimitOrderEntryMappings(transactorOrder)
+		}
+
+		utxoOpsForTxn = append(utxoOpsForTxn, &UtxoOperation{
+			Type:               OperationTypeDAOCoinLimitOrder,
+			PrevMatchingOrders: prevTransactorOrders,
+		})
+
+		return totalInput, totalOutput, utxoOpsForTxn, nil
+	}
+
 	// Extract the buyCoin and sellCoin PKIDs from the txn's public keys.
 	// Note that if any of these are ZeroPublicKey, then GetPKIDForPublicKey will
 	// return ZeroPKID back to us, which is what we want. Recall that ZeroPKID
@@ -332,6 +432,60 @@ func (bav *UtxoView) _connectDAOCoinLimitOrder(
 			spew.Sdump(buyCoinPKIDEntry))
 	}
 
+	// If this is a trigger order, it isn't matched. It's stored as a dormant
+	// trigger entry instead, and placed on the book once a fill triggers it.
+	if txMeta.TriggerScaledExchangeRate != nil {
+		totalInput, totalOutput, utxoOpsForTxn, err := bav._connectBasicTransfer(
+			txn, txHash, blockHeight, verifySignatures)
+		if err != nil {
+			return 0, 0, nil, errors.Wrapf(err, "_connectDAOCoinLimitOrder")
+		}
+
+		bav._setDAOCoinLimitOrderTriggerEntryMappings(&DAOCoinLimitOrderTriggerEntry{
+			Order: &DAOCoinLimitOrderEntry{
+				OrderID:                   txHash,
+				TransactorPKID:            transactorPKIDEntry.PKID,
+				BuyingDAOCoinCreatorPKID:  buyCoinPKIDEntry.PKID,
+				SellingDAOCoinCreatorPKID: sellCoinPKIDEntry.PKID,
+				ScaledExchangeRateCoinsToSellPerCoinToBuy: txMeta.ScaledExchangeRateCoinsToSellPerCoinToBuy,
+				QuantityToFillInBaseUnits:                 txMeta.QuantityToFillInBaseUnits,
+				OperationType:                             txMeta.OperationType,
+				FillType:                                  txMeta.FillType,
+				BlockHeight:                               blockHeight,
+				ExpirationBlockHeight:                     txMeta.ExpirationBlockHeight,
+			},
+			TriggerScaledExchangeRate: txMeta.TriggerScaledExchangeRate,
+		})
+
+		utxoOpsForTxn = append(utxoOpsForTxn, &UtxoOperation{
+			Type: OperationTypeDAOCoinLimitOrder,
+		})
+
+		return totalInput, totalOutput, utxoOpsForTxn, nil
+	}
+
+	// If the transactor is replacing an existing order, delete it before
+	// we submit the new one. It's saved so that we can revert.
+	var prevTransactorOrder *DAOCoinLimitOrderEntry
+	if txMeta.ReplaceOrderID != nil {
+		existingTransactorOrder, err := bav._getDAOCoinLimitOrderEntry(txMeta.ReplaceOrderID)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if existingTransactorOrder == nil || existingTransactorOrder.IsExpired(blockHeight) {
+			return 0, 0, nil, RuleErrorDAOCoinLimitOrderToReplaceNotFound
+		}
+		if !transactorPKIDEntry.PKID.Eq(existingTransactorOrder.TransactorPKID) {
+			return 0, 0, nil, RuleErrorDAOCoinLimitOrderToReplaceNotYours
+		}
+		if !buyCoinPKIDEntry.PKID.Eq(existingTransactorOrder.BuyingDAOCoinCreatorPKID) ||
+			!sellCoinPKIDEntry.PKID.Eq(existingTransactorOrder.SellingDAOCoinCreatorPKID) {
+			return 0, 0, nil, RuleErrorDAOCoinLimitOrderToReplaceDifferentPair
+		}
+		prevTransactorOrder = existingTransactorOrder.Copy()
+		bav._deleteDAOCoinLimitOrderEntryMappings(existingTransactorOrder)
+	}
+
 	// Create entry from txn metadata for the transactor.
 	transactorOrder := &DAOCoinLimitOrderEntry{
 		OrderID:                   txHash,
@@ -343,6 +497,8 @@ func (bav *UtxoView) _connectDAOCoinLimitOrder(
 		OperationType:                             txMeta.OperationType,
 		FillType:                                  txMeta.FillType,
 		BlockHeight:                               blockHeight,
+		ExpirationBlockHeight:                     txMeta.ExpirationBlockHeight,
+		marketOrderMaxSlippageBasisPoints:         txMeta.MarketOrderMaxSlippageBasisPoints,
 	}
 
 	// These maps contain all of the balance changes that this transaction
@@ -362,6 +518,26 @@ func (bav *UtxoView) _connectDAOCoinLimitOrder(
 	// Note that DESO is just dao coin PKID = ZeroPKID
 	balanceDeltas := make(map[PKID]map[PKID]*big.Int)
 
+	// Look up the maker and taker fees. Fees are only charged once a fee
+	// destination has been set. The taker is the transactor, and the makers
+	// are the matching orders on the book.
+	var feeDestinationPKID *PKID
+	makerFeeBasisPoints := uint64(0)
+	takerFeeBasisPoints := uint64(0)
+	if blockHeight >= bav.Params.ForkHeights.DAOCoinLimitOrderMakerTakerFeesBlockHeight &&
+		len(bav.GlobalParamsEntry.DAOCoinLimitOrderFeeDestinationPublicKey) != 0 {
+
+		feeDestinationPKIDEntry := bav.GetPKIDForPublicKey(bav.GlobalParamsEntry.DAOCoinLimitOrderFeeDestinationPublicKey)
+		if feeDestinationPKIDEntry == nil || feeDestinationPKIDEntry.isDeleted {
+			return 0, 0, nil, fmt.Errorf(
+				"_connectDAOCoinLimitOrder: feeDestinationPKIDEntry is deleted: %v",
+				spew.Sdump(feeDestinationPKIDEntry))
+		}
+		feeDestinationPKID = feeDestinationPKIDEntry.PKID
+		makerFeeBasisPoints = bav.GlobalParamsEntry.DAOCoinLimitOrderMakerFeeBasisPoints
+		takerFeeBasisPoints = bav.GlobalParamsEntry.DAOCoinLimitOrderTakerFeeBasisPoints
+	}
+
 	// Now, we find all the orders that we can match against the seller, and adjust the
 	// increase and decrease maps accordingly.
 	//
@@ -387,9 +563,20 @@ func (bav *UtxoView) _connectDAOCoinLimitOrder(
 	// for now.
 	filledOrders := []*FilledDAOCoinLimitOrder{}
 	orderFilled := false
+	slippageBoundReached := false
+	// The lowest and highest exchange rates of the matching orders that were filled,
+	// which determine the trigger orders that this txn triggers.
+	var minFilledScaledExchangeRate, maxFilledScaledExchangeRate *uint256.Int
 	for len(matchingOrders) > 0 {
 		// 1-by-1 match existing orders to the transactor's order.
 		for _, matchingOrder := range matchingOrders {
+			// If the transactor's market order has a max slippage, stop at the first
+			// order past it. The orders are sorted best first, so the rest are past it too.
+			if !transactorOrder.IsValidMatchingOrderPrice(matchingOrder) {
+				slippageBoundReached = true
+				break
+			}
+
 			prevMatchingOrders = append(prevMatchingOrders, matchingOrder.Copy())
 			// In what follows, we refer to the coin the transactor is trying to buy as the
 			// "buy coin" and we refer to the coin the transactor is trying to sell as the
@@ -444,6 +631,9 @@ func (bav *UtxoView) _connectDAOCoinLimitOrder(
 			// of a balance of the "buy coin" to cover it, even after matching all previous
 			// orders.
 
+			// The first order the transactor's market order matches sets its slippage bound.
+			transactorOrder.setMarketOrderSlippageBound(matchingOrder)
+
 			// Sanity-check to make sure that the transactor has enough to cover the amount that
 			// they're trying to buy here.
 			transactorSellCoinBalanceBaseUnits, err := bav.getAdjustedDAOCoinBalanceForUserInBaseUnits(
@@ -461,6 +651,39 @@ func (bav *UtxoView) _connectDAOCoinLimitOrder(
 					sellCoinPKIDEntry.PKID)
 			}
 
+			// Compute the fees. Each side pays its fee out of the coins it receives,
+			// so the fees don't change how much either side needs to cover.
+			var takerFeeBaseUnits, makerFeeBaseUnits *uint256.Int
+			if feeDestinationPKID != nil {
+				takerFeeBaseUnits, err = ComputeDAOCoinLimitOrderFeeBaseUnits(
+					coinBaseUnitsBoughtByTransactor, takerFeeBasisPoints)
+				if err != nil {
+					return 0, 0, nil, errors.Wrapf(err, "_connectDAOCoinLimitOrder: Problem computing taker fee: ")
+				}
+				makerFeeBaseUnits, err = ComputeDAOCoinLimitOrderFeeBaseUnits(
+					coinBaseUnitsSoldByTransactor, makerFeeBasisPoints)
+				if err != nil {
+					return 0, 0, nil, errors.Wrapf(err, "_connectDAOCoinLimitOrder: Problem computing maker fee: ")
+				}
+
+				// The fees are DAO coin transfers to the fee destination, so they have to
+				// respect the coins' transfer restrictions like any other transfer.
+				feeDestinationPublicKey := bav.GlobalParamsEntry.DAOCoinLimitOrderFeeDestinationPublicKey
+				if !takerFeeBaseUnits.IsZero() {
+					if err = bav.IsValidDAOCoinLimitOrderFeeTransfer(
+						buyCoinPKIDEntry.PKID, txn.PublicKey, feeDestinationPublicKey); err != nil {
+						return 0, 0, nil, errors.Wrapf(err, "_connectDAOCoinLimitOrder: Taker fee violates transfer restriction: ")
+					}
+				}
+				if !makerFeeBaseUnits.IsZero() {
+					if err = bav.IsValidDAOCoinLimitOrderFeeTransfer(
+						sellCoinPKIDEntry.PKID, bav.GetPublicKeyForPKID(matchingOrder.TransactorPKID),
+						feeDestinationPublicKey); err != nil {
+						return 0, 0, nil, errors.Wrapf(err, "_connectDAOCoinLimitOrder: Maker fee violates transfer restriction: ")
+					}
+				}
+			}
+
 			// Update quantity for transactor's order.
 			transactorOrderFilledOrder := &FilledDAOCoinLimitOrder{
 				OrderID:                       transactorOrder.OrderID,
@@ -469,6 +692,7 @@ func (bav *UtxoView) _connectDAOCoinLimitOrder(
 				SellingDAOCoinCreatorPKID:     transactorOrder.SellingDAOCoinCreatorPKID,
 				CoinQuantityInBaseUnitsBought: coinBaseUnitsBoughtByTransactor,
 				CoinQuantityInBaseUnitsSold:   coinBaseUnitsSoldByTransactor,
+				FeeInBaseUnitsPaid:            takerFeeBaseUnits,
 			}
 			if updatedTransactorOrderQuantityToFill.IsZero() {
 				// Transactor's order was fully filled.
@@ -492,6 +716,7 @@ func (bav *UtxoView) _connectDAOCoinLimitOrder(
 				SellingDAOCoinCreatorPKID:     matchingOrder.SellingDAOCoinCreatorPKID,
 				CoinQuantityInBaseUnitsBought: coinBaseUnitsSoldByTransactor,
 				CoinQuantityInBaseUnitsSold:   coinBaseUnitsBoughtByTransactor,
+				FeeInBaseUnitsPaid:            makerFeeBaseUnits,
 			}
 			matchingOrder.QuantityToFillInBaseUnits = updatedMatchingOrderQuantityToFill
 			remainingUnitsToBuy, err := matchingOrder.BaseUnitsToBuyUint256()
@@ -526,28 +751,50 @@ func (bav *UtxoView) _connectDAOCoinLimitOrder(
 				bav._setDAOCoinLimitOrderEntryMappings(matchingOrder)
 			}
 			filledOrders = append(filledOrders, matchingOrderFilledOrder)
+			matchingOrderRate := matchingOrder.ScaledExchangeRateCoinsToSellPerCoinToBuy
+			if minFilledScaledExchangeRate == nil || matchingOrderRate.Lt(minFilledScaledExchangeRate) {
+				minFilledScaledExchangeRate = matchingOrderRate.Clone()
+			}
+			if maxFilledScaledExchangeRate == nil || matchingOrderRate.Gt(maxFilledScaledExchangeRate) {
+				maxFilledScaledExchangeRate = matchingOrderRate.Clone()
+			}
 
 			// Now adjust the balances in our maps to reflect the coins that just changed hands.
-			// Transactor got buyCoins
+			coinBaseUnitsReceivedByTransactor := coinBaseUnitsBoughtByTransactor.ToBig()
+			coinBaseUnitsReceivedBySeller := coinBaseUnitsSoldByTransactor.ToBig()
+			if feeDestinationPKID != nil {
+				coinBaseUnitsReceivedByTransactor.Sub(coinBaseUnitsReceivedByTransactor, takerFeeBaseUnits.ToBig())
+				coinBaseUnitsReceivedBySeller.Sub(coinBaseUnitsReceivedBySeller, makerFeeBaseUnits.ToBig())
+			}
+			// Transactor got buyCoins, minus the taker fee
 			bav.balanceChange(transactorPKIDEntry.PKID, buyCoinPKIDEntry.PKID,
-				coinBaseUnitsBoughtByTransactor.ToBig(), balanceDeltas, prevBalances)
+				coinBaseUnitsReceivedByTransactor, balanceDeltas, prevBalances)
 			// Seller lost buyCoins
 			bav.balanceChange(matchingOrder.TransactorPKID, buyCoinPKIDEntry.PKID,
 				big.NewInt(0).Neg(coinBaseUnitsBoughtByTransactor.ToBig()),
 				balanceDeltas, prevBalances)
-			// Seller got sellCoins
+			// Seller got sellCoins, minus the maker fee
 			bav.balanceChange(matchingOrder.TransactorPKID, sellCoinPKIDEntry.PKID,
-				coinBaseUnitsSoldByTransactor.ToBig(), balanceDeltas, prevBalances)
+				coinBaseUnitsReceivedBySeller, balanceDeltas, prevBalances)
 			// Transactor lost sellCoins
 			bav.balanceChange(transactorPKIDEntry.PKID, sellCoinPKIDEntry.PKID,
 				big.NewInt(0).Neg(coinBaseUnitsSoldByTransactor.ToBig()),
 				balanceDeltas, prevBalances)
+			// Fee destination got the taker fee in buyCoins and the maker fee in sellCoins
+			if feeDestinationPKID != nil && !takerFeeBaseUnits.IsZero() {
+				bav.balanceChange(feeDestinationPKID, buyCoinPKIDEntry.PKID,
+					takerFeeBaseUnits.ToBig(), balanceDeltas, prevBalances)
+			}
+			if feeDestinationPKID != nil && !makerFeeBaseUnits.IsZero() {
+				bav.balanceChange(feeDestinationPKID, sellCoinPKIDEntry.PKID,
+					makerFeeBaseUnits.ToBig(), balanceDeltas, prevBalances)
+			}
 
 			if orderFilled {
 				break
 			}
 		}
-		if orderFilled {
+		if orderFilled || slippageBoundReached {
 			break
 		}
 		lastSeenOrder = prevMatchingOrders[len(prevMatchingOrders)-1]
@@ -583,6 +830,18 @@ func (bav *UtxoView) _connectDAOCoinLimitOrder(
 		}
 	}
 
+	// Place the trigger orders that this txn's fills triggered on the book.
+	var prevTriggerEntries []*DAOCoinLimitOrderTriggerEntry
+	if blockHeight >= bav.Params.ForkHeights.DAOCoinLimitOrderTriggerBlockHeight &&
+		maxFilledScaledExchangeRate != nil {
+
+		prevTriggerEntries, err = bav._triggerDAOCoinLimitOrders(transactorOrder,
+			minFilledScaledExchangeRate, maxFilledScaledExchangeRate, blockHeight)
+		if err != nil {
+			return 0, 0, nil, errors.Wrapf(err, "_connectDAOCoinLimitOrder: ")
+		}
+	}
+
 	// Now, we need to update all the balances of all the users who were involved in
 	// all of the matching that we did above. We do this via the following steps:
 	//
@@ -870,10 +1129,11 @@ func (bav *UtxoView) _connectDAOCoinLimitOrder(
 	// a separate place, but here it makes sense.
 	utxoOpsForTxn = append(utxoOpsForTxn, &UtxoOperation{
 		Type:                                 OperationTypeDAOCoinLimitOrder,
-		PrevTransactorDAOCoinLimitOrderEntry: nil, // prevTransactorOrder is only used in cancelling an order.
+		PrevTransactorDAOCoinLimitOrderEntry: prevTransactorOrder, // Only set if this order replaced an existing one.
 		PrevBalanceEntries:                   prevBalances,
 		PrevMatchingOrders:                   prevMatchingOrders,
 		FilledDAOCoinLimitOrders:             filledOrders,
+		PrevDAOCoinLimitOrderTriggerEntries:  prevTriggerEntries,
 	})
 
 	// Just to be safe, we confirm that totalOutput doesn't exceed totalInput.
@@ -896,6 +1156,13 @@ func (bav *UtxoView) _connectDAOCoinLimitOrder(
 func (bav *UtxoView) GetNextLimitOrdersToFill(
 	transactorOrder *DAOCoinLimitOrderEntry, lastSeenOrder *DAOCoinLimitOrderEntry, blockHeight uint32) (
 	[]*DAOCoinLimitOrderEntry, error) {
+	// After this fork height, we walk the merged order book page by page
+	// and stop as soon as the transactor's quantity is filled, rather than
+	// loading every matching order into the view.
+	if blockHeight >= bav.Params.ForkHeights.DAOCoinLimitOrderMergedOrderBookBlockHeight {
+		return bav._getNextLimitOrdersToFillFromMergedOrderBook(transactorOrder, lastSeenOrder, blockHeight)
+	}
+
 	// Construct map of potential-matching orders in the view. We skip
 	// pulling these from the db as we already have them in the view.
 	// This was a breaking-change efficiency improvement, so we gate
@@ -918,9 +1185,7 @@ func (bav *UtxoView) GetNextLimitOrdersToFill(
 
 	// Update UTXO with relevant limit order entries from database.
 	for _, matchingOrder := range matchingOrders {
-		if _, exists := bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry[matchingOrder.ToMapKey()]; !exists {
-			bav._setDAOCoinLimitOrderEntryMappings(matchingOrder)
-		}
+		bav._cacheDAOCoinLimitOrderEntry(matchingOrder)
 	}
 
 	// Aggregate all matching orders then sort.
@@ -928,6 +1193,11 @@ func (bav *UtxoView) GetNextLimitOrdersToFill(
 
 	// Aggregate matching orders.
 	for _, matchingOrder := range bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry {
+		// Expired orders can't be matched, even before they're pruned.
+		if matchingOrder.IsExpired(blockHeight) {
+			continue
+		}
+
 		// This doesn't mean that the matching order is invalid and should be deleted.
 		// It just means that the matching order isn't actually a viable match.
 		err := bav.IsValidDAOCoinLimitOrderMatch(transactorOrder, matchingOrder)
@@ -978,6 +1248,76 @@ func (bav *UtxoView) GetNextLimitOrdersToFill(
 	return outputMatchingOrders, nil
 }
 
+func (bav *UtxoView) _getNextLimitOrdersToFillFromMergedOrderBook(
+	transactorOrder *DAOCoinLimitOrderEntry, lastSeenOrder *DAOCoinLimitOrderEntry, blockHeight uint32) (
+	[]*DAOCoinLimitOrderEntry, error) {
+
+	// Matching orders are buying the coin the transactor is
+	// selling and selling the coin the transactor is buying.
+	matchingPair := &DAOCoinLimitOrderPair{
+		BuyingDAOCoinCreatorPKID:  transactorOrder.SellingDAOCoinCreatorPKID,
+		SellingDAOCoinCreatorPKID: transactorOrder.BuyingDAOCoinCreatorPKID,
+	}
+	pagination := &DAOCoinLimitOrderBookPagination{
+		StartAfterOrder: lastSeenOrder,
+		MaxOrders:       DAOCoinLimitOrderMatchingPageSize,
+	}
+
+	outputMatchingOrders := []*DAOCoinLimitOrderEntry{}
+	transactorOrderQuantityToFill := transactorOrder.QuantityToFillInBaseUnits.Clone()
+
+	for {
+		matchingOrders, err := GetMergedOrderBook(bav, matchingPair, pagination)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, matchingOrder := range matchingOrders {
+			// The order book is sorted best price first, so once we hit
+			// an order whose price doesn't match, none of the rest will.
+			if !transactorOrder.IsValidMatchingOrderPrice(matchingOrder) {
+				return outputMatchingOrders, nil
+			}
+
+			// Expired orders can't be matched, even before they're pruned.
+			if matchingOrder.IsExpired(blockHeight) {
+				continue
+			}
+
+			// This doesn't mean that the matching order is invalid and should be deleted.
+			// It just means that the matching order isn't actually a viable match.
+			if err = bav.IsValidDAOCoinLimitOrderMatch(transactorOrder, matchingOrder); err != nil {
+				// If matching own order, fail immediately. Otherwise just skip this order.
+				if err == RuleErrorDAOCoinLimitOrderMatchingOwnOrder {
+					return nil, err
+				}
+				continue
+			}
+
+			outputMatchingOrders = append(outputMatchingOrders, matchingOrder)
+
+			// Calculate transactor's updated quantity
+			// to fill after matching with this order.
+			transactorOrderQuantityToFill, _, _, _, err = _calculateDAOCoinsTransferredInLimitOrderMatch(
+				matchingOrder, transactorOrder.OperationType, transactorOrderQuantityToFill)
+			if err != nil {
+				return nil, err
+			}
+
+			// Return once the transactor's quantity to fill is zero.
+			if transactorOrderQuantityToFill.IsZero() {
+				return outputMatchingOrders, nil
+			}
+		}
+
+		// A short page means we've reached the end of the order book.
+		if len(matchingOrders) < pagination.MaxOrders {
+			return outputMatchingOrders, nil
+		}
+		pagination.StartAfterOrder = matchingOrders[len(matchingOrders)-1]
+	}
+}
+
 func (bav *UtxoView) _disconnectDAOCoinLimitOrder(
 	operationType OperationType, currentTxn *MsgDeSoTxn, txnHash *BlockHash,
 	utxoOpsForTxn []*UtxoOperation, blockHeight uint32) error {
@@ -998,7 +1338,21 @@ func (bav *UtxoView) _disconnectDAOCoinLimitOrder(
 
 	transactorPKID := bav.GetPKIDForPublicKey(currentTxn.PublicKey).PKID
 
-	if txMeta.CancelOrderID == nil {
+	if txMeta.TriggerScaledExchangeRate != nil {
+		// Delete the trigger order created by this txn.
+		bav._deleteDAOCoinLimitOrderTriggerEntryMappings(&DAOCoinLimitOrderTriggerEntry{
+			Order: &DAOCoinLimitOrderEntry{
+				OrderID:                   txnHash,
+				TransactorPKID:            transactorPKID,
+				BuyingDAOCoinCreatorPKID:  bav.GetPKIDForPublicKey(txMeta.BuyingDAOCoinCreatorPublicKey.ToBytes()).PKID,
+				SellingDAOCoinCreatorPKID: bav.GetPKIDForPublicKey(txMeta.SellingDAOCoinCreatorPublicKey.ToBytes()).PKID,
+				ScaledExchangeRateCoinsToSellPerCoinToBuy: txMeta.ScaledExchangeRateCoinsToSellPerCoinToBuy,
+				QuantityToFillInBaseUnits:                 txMeta.QuantityToFillInBaseUnits,
+				BlockHeight:                               blockHeight,
+			},
+			TriggerScaledExchangeRate: txMeta.TriggerScaledExchangeRate,
+		})
+	} else if txMeta.CancelOrderID == nil && !txMeta.CancelAllForPair {
 		// Delete the order created by this txn.
 		bav._deleteDAOCoinLimitOrderEntryMappings(&DAOCoinLimitOrderEntry{
 			OrderID:                   txnHash,
@@ -1009,13 +1363,29 @@ func (bav *UtxoView) _disconnectDAOCoinLimitOrder(
 			QuantityToFillInBaseUnits:                 txMeta.QuantityToFillInBaseUnits,
 			BlockHeight:                               blockHeight,
 		})
-	} else {
+		// Replace the order this txn replaced, if any.
+		if txMeta.ReplaceOrderID != nil {
+			bav._setDAOCoinLimitOrderEntryMappings(operationData.PrevTransactorDAOCoinLimitOrderEntry)
+		}
+	} else if txMeta.CancelOrderID != nil && operationData.PrevTransactorDAOCoinLimitOrderEntry != nil {
 		// Replace the order cancelled by this txn. Note:
 		// PrevTransactorDAOCoinLimitOrderEntry is only set
-		// if this transaction cancelled an existing order.
+		// if this transaction cancelled an existing order,
+		// rather than a trigger order.
 		bav._setDAOCoinLimitOrderEntryMappings(operationData.PrevTransactorDAOCoinLimitOrderEntry)
 	}
 
+	// Revert the trigger orders that this txn triggered or cancelled. The orders it
+	// triggered are taken off the book before the matching orders are restored below.
+	for _, prevTriggerEntry := range operationData.PrevDAOCoinLimitOrderTriggerEntries {
+		if txMeta.CancelOrderID == nil {
+			triggeredOrder := prevTriggerEntry.Order.Copy()
+			triggeredOrder.BlockHeight = blockHeight
+			bav._deleteDAOCoinLimitOrderEntryMappings(triggeredOrder)
+		}
+		bav._setDAOCoinLimitOrderTriggerEntryMappings(prevTriggerEntry)
+	}
+
 	// Revert DAO Coin balance entries
 	if len(operationData.PrevBalanceEntries) != 0 {
 		for _, daoCoinPKIDToBalanceEntryMap := range operationData.PrevBalanceEntries {
@@ -1084,6 +1454,16 @@ func (bav *UtxoView) _setDAOCoinLimitOrderEntryMappings(entry *DAOCoinLimitOrder
 	}
 
 	bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry[entry.ToMapKey()] = entry
+	bav.dirtyDAOCoinLimitOrderMapKeys[entry.ToMapKey()] = true
+}
+
+// _cacheDAOCoinLimitOrderEntry adds an order read from the db to the view, unless the view
+// already has it. Unlike _setDAOCoinLimitOrderEntryMappings, the order isn't marked dirty,
+// so flushing the view doesn't write it back.
+func (bav *UtxoView) _cacheDAOCoinLimitOrderEntry(entry *DAOCoinLimitOrderEntry) {
+	if _, exists := bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry[entry.ToMapKey()]; !exists {
+		bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry[entry.ToMapKey()] = entry
+	}
 }
 
 func (bav *UtxoView) _deleteDAOCoinLimitOrderEntryMappings(entry *DAOCoinLimitOrderEntry) {
@@ -1101,6 +1481,218 @@ func (bav *UtxoView) _deleteDAOCoinLimitOrderEntryMappings(entry *DAOCoinLimitOr
 	bav._setDAOCoinLimitOrderEntryMappings(&tombstoneEntry)
 }
 
+// _expireDAOCoinLimitOrders deletes the orders on the book that expired before blockHeight,
+// and returns the op that restores them on disconnect, or nil if no order expired.
+// ConnectBlock calls it once the block reward connects, so expired orders are
+// pruned before any other txn in the block runs.
+func (bav *UtxoView) _expireDAOCoinLimitOrders(blockHeight uint32) (*UtxoOperation, error) {
+	// Temporarily use badger to support DAO Coin limit order DB operations
+	var dbOrders []*DAOCoinLimitOrderEntry
+	err := bav.Handle.View(func(txn *badger.Txn) error {
+		var err error
+		dbOrders, err = DBGetDAOCoinLimitOrdersExpiringBeforeBlockHeightWithTxn(txn, blockHeight)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "_expireDAOCoinLimitOrders: ")
+	}
+	for _, order := range dbOrders {
+		bav._cacheDAOCoinLimitOrderEntry(order)
+	}
+
+	// The view may have expired orders that aren't in the db yet, e.g. ones
+	// placed by blocks that haven't been flushed.
+	var expiredOrders []*DAOCoinLimitOrderEntry
+	for _, order := range bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry {
+		if !order.isDeleted && order.IsExpired(blockHeight) {
+			expiredOrders = append(expiredOrders, order)
+		}
+	}
+	if len(expiredOrders) == 0 {
+		return nil, nil
+	}
+	// The view's entries are in map order, so we sort them to keep the utxo op deterministic.
+	sort.Slice(expiredOrders, func(ii, jj int) bool {
+		return bytes.Compare(expiredOrders[ii].OrderID[:], expiredOrders[jj].OrderID[:]) < 0
+	})
+
+	prevOrders := []*DAOCoinLimitOrderEntry{}
+	for _, order := range expiredOrders {
+		prevOrders = append(prevOrders, order.Copy())
+		bav._deleteDAOCoinLimitOrderEntryMappings(order)
+	}
+	return &UtxoOperation{
+		Type:               OperationTypeDAOCoinLimitOrderExpiration,
+		PrevMatchingOrders: prevOrders,
+	}, nil
+}
+
+// _disconnectDAOCoinLimitOrderExpiration restores the orders that _expireDAOCoinLimitOrders
+// pruned, if the block reward's utxo ops end with its op, and returns the rest of the ops.
+func (bav *UtxoView) _disconnectDAOCoinLimitOrderExpiration(utxoOpsForTxn []*UtxoOperation) []*UtxoOperation {
+	if len(utxoOpsForTxn) == 0 ||
+		utxoOpsForTxn[len(utxoOpsForTxn)-1].Type != OperationTypeDAOCoinLimitOrderExpiration {
+		return utxoOpsForTxn
+	}
+	for _, prevOrder := range utxoOpsForTxn[len(utxoOpsForTxn)-1].PrevMatchingOrders {
+		bav._setDAOCoinLimitOrderEntryMappings(prevOrder)
+	}
+	return utxoOpsForTxn[:len(utxoOpsForTxn)-1]
+}
+
+// _triggerDAOCoinLimitOrders places the trigger orders that a fill triggers on the book,
+// and returns copies of their trigger entries. The filled orders traded at exchange rates
+// from minFilledScaledExchangeRate to maxFilledScaledExchangeRate, in their units, i.e.
+// the transactor's buying coins per selling coin. A trigger order is triggered once a
+// fill's exchange rate, in the trigger order's units, is at or above its trigger, so the
+// trigger orders on the filled orders' side of the pair are triggered up to the max
+// exchange rate, and the ones on the transactor's side are triggered up to the inverse of
+// the min exchange rate.
+//
+// Triggered orders are placed on the book as they are, without being matched, so they
+// match against the orders that come after them.
+func (bav *UtxoView) _triggerDAOCoinLimitOrders(
+	transactorOrder *DAOCoinLimitOrderEntry,
+	minFilledScaledExchangeRate *uint256.Int,
+	maxFilledScaledExchangeRate *uint256.Int,
+	blockHeight uint32) ([]*DAOCoinLimitOrderTriggerEntry, error) {
+
+	filledSideTriggerEntries, err := bav._getDAOCoinLimitOrderTriggerEntriesUpToRate(
+		transactorOrder.SellingDAOCoinCreatorPKID, transactorOrder.BuyingDAOCoinCreatorPKID,
+		maxFilledScaledExchangeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	// The inverse of a scaled exchange rate R is 1e38 * 1e38 / R, and it's capped at
+	// MaxUint256, which triggers every trigger order on the transactor's side.
+	maxTransactorSideScaledExchangeRate := MaxUint256.Clone()
+	inverseScaledExchangeRate := big.NewInt(0).Div(
+		big.NewInt(0).Mul(OneE38.ToBig(), OneE38.ToBig()), minFilledScaledExchangeRate.ToBig())
+	if inverseScaledExchangeRate.Cmp(MaxUint256.ToBig()) < 0 {
+		maxTransactorSideScaledExchangeRate, _ = uint256.FromBig(inverseScaledExchangeRate)
+	}
+	transactorSideTriggerEntries, err := bav._getDAOCoinLimitOrderTriggerEntriesUpToRate(
+		transactorOrder.BuyingDAOCoinCreatorPKID, transactorOrder.SellingDAOCoinCreatorPKID,
+		maxTransactorSideScaledExchangeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	var prevTriggerEntries []*DAOCoinLimitOrderTriggerEntry
+	for _, triggerEntry := range append(filledSideTriggerEntries, transactorSideTriggerEntries...) {
+		prevTriggerEntries = append(prevTriggerEntries, triggerEntry.Copy())
+		bav._deleteDAOCoinLimitOrderTriggerEntryMappings(triggerEntry)
+
+		triggeredOrder := triggerEntry.Order.Copy()
+		triggeredOrder.BlockHeight = blockHeight
+		bav._setDAOCoinLimitOrderEntryMappings(triggeredOrder)
+	}
+	return prevTriggerEntries, nil
+}
+
+func (bav *UtxoView) _setDAOCoinLimitOrderTriggerEntryMappings(entry *DAOCoinLimitOrderTriggerEntry) {
+	// This function shouldn't be called with nil.
+	if entry == nil {
+		glog.Errorf("_setDAOCoinLimitOrderTriggerEntryMappings: Called with nil entry; this should never happen")
+		return
+	}
+
+	bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry[entry.ToMapKey()] = entry
+	bav.dirtyDAOCoinLimitOrderTriggerMapKeys[entry.ToMapKey()] = true
+}
+
+// _cacheDAOCoinLimitOrderTriggerEntry adds a trigger entry read from the db to the view, unless
+// the view already has it. The entry isn't marked dirty, so flushing the view doesn't write it back.
+func (bav *UtxoView) _cacheDAOCoinLimitOrderTriggerEntry(entry *DAOCoinLimitOrderTriggerEntry) {
+	if _, exists := bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry[entry.ToMapKey()]; !exists {
+		bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry[entry.ToMapKey()] = entry
+	}
+}
+
+func (bav *UtxoView) _deleteDAOCoinLimitOrderTriggerEntryMappings(entry *DAOCoinLimitOrderTriggerEntry) {
+	// This function shouldn't be called with nil.
+	if entry == nil {
+		glog.Errorf("_deleteDAOCoinLimitOrderTriggerEntryMappings: Called with nil entry; this should never happen")
+		return
+	}
+
+	// Create a tombstone entry.
+	tombstoneEntry := *entry
+	tombstoneEntry.isDeleted = true
+
+	// Set the mappings to point to the tombstone entry.
+	bav._setDAOCoinLimitOrderTriggerEntryMappings(&tombstoneEntry)
+}
+
+func (bav *UtxoView) _getDAOCoinLimitOrderTriggerEntry(orderID *BlockHash) (*DAOCoinLimitOrderTriggerEntry, error) {
+	// This function shouldn't be called with nil.
+	if orderID == nil {
+		return nil, errors.Errorf("_getDAOCoinLimitOrderTriggerEntry: Called with nil orderID; this should never happen")
+	}
+
+	// First check if we have the trigger entry in the UTXO view.
+	triggerEntry, exists := bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry[DAOCoinLimitOrderMapKey{OrderID: *orderID}]
+	if !exists {
+		// If not, check if we have the trigger entry in the database.
+		// Temporarily use badger to support DAO Coin limit order DB operations
+		var err error
+		triggerEntry, err = DBGetDAOCoinLimitOrderTrigger(bav.Handle, bav.Snapshot, orderID)
+		if err != nil {
+			return nil, err
+		}
+		if triggerEntry == nil {
+			return nil, nil
+		}
+		bav._cacheDAOCoinLimitOrderTriggerEntry(triggerEntry)
+	}
+	if triggerEntry.isDeleted {
+		return nil, nil
+	}
+	return triggerEntry, nil
+}
+
+// _getDAOCoinLimitOrderTriggerEntriesUpToRate returns the trigger orders buying buyingPKID with
+// sellingPKID whose trigger is at most maxTriggerScaledExchangeRate, sorted by trigger and then
+// OrderID. The ones in the db are loaded into the view.
+func (bav *UtxoView) _getDAOCoinLimitOrderTriggerEntriesUpToRate(buyingPKID *PKID, sellingPKID *PKID,
+	maxTriggerScaledExchangeRate *uint256.Int) ([]*DAOCoinLimitOrderTriggerEntry, error) {
+
+	// Temporarily use badger to support DAO Coin limit order DB operations
+	var dbTriggerEntries []*DAOCoinLimitOrderTriggerEntry
+	err := bav.Handle.View(func(txn *badger.Txn) error {
+		var err error
+		dbTriggerEntries, err = DBGetDAOCoinLimitOrderTriggersUpToRateWithTxn(
+			txn, buyingPKID, sellingPKID, maxTriggerScaledExchangeRate)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "_getDAOCoinLimitOrderTriggerEntriesUpToRate: ")
+	}
+	for _, triggerEntry := range dbTriggerEntries {
+		bav._cacheDAOCoinLimitOrderTriggerEntry(triggerEntry)
+	}
+
+	var triggerEntries []*DAOCoinLimitOrderTriggerEntry
+	for _, triggerEntry := range bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry {
+		if triggerEntry.isDeleted ||
+			!triggerEntry.Order.BuyingDAOCoinCreatorPKID.Eq(buyingPKID) ||
+			!triggerEntry.Order.SellingDAOCoinCreatorPKID.Eq(sellingPKID) ||
+			triggerEntry.TriggerScaledExchangeRate.Gt(maxTriggerScaledExchangeRate) {
+			continue
+		}
+		triggerEntries = append(triggerEntries, triggerEntry)
+	}
+	// The view's entries are in map order, so we sort them to keep connecting deterministic.
+	sort.Slice(triggerEntries, func(ii, jj int) bool {
+		if !triggerEntries[ii].TriggerScaledExchangeRate.Eq(triggerEntries[jj].TriggerScaledExchangeRate) {
+			return triggerEntries[ii].TriggerScaledExchangeRate.Lt(triggerEntries[jj].TriggerScaledExchangeRate)
+		}
+		return bytes.Compare(triggerEntries[ii].Order.OrderID[:], triggerEntries[jj].Order.OrderID[:]) < 0
+	})
+	return triggerEntries, nil
+}
+
 func _calculateDAOCoinsTransferredInLimitOrderMatch(
 	matchingOrder *DAOCoinLimitOrderEntry,
 	transactorOrderOperationType DAOCoinLimitOrderOperationType,
@@ -1398,11 +1990,7 @@ func (bav *UtxoView) _getAllDAOCoinLimitOrders() ([]*DAOCoinLimitOrderEntry, err
 	}
 
 	for _, orderEntry := range dbOrderEntries {
-		orderMapKey := orderEntry.ToMapKey()
-
-		if _, exists := bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry[orderMapKey]; !exists {
-			bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry[orderMapKey] = orderEntry
-		}
+		bav._cacheDAOCoinLimitOrderEntry(orderEntry)
 	}
 
 	// Get matching orders from the UTXO view.
@@ -1416,49 +2004,114 @@ func (bav *UtxoView) _getAllDAOCoinLimitOrders() ([]*DAOCoinLimitOrderEntry, err
 	return outputEntries, nil
 }
 
-func (bav *UtxoView) GetAllDAOCoinLimitOrdersForThisDAOCoinPair(
-	buyingDAOCoinCreatorPKID *PKID, sellingDAOCoinCreatorPKID *PKID) ([]*DAOCoinLimitOrderEntry, error) {
-	// This function is used by the API to construct all open
-	// orders for the input buying and selling DAO coins.
-	if buyingDAOCoinCreatorPKID == nil {
-		return nil, errors.Errorf("GetAllDAOCoinLimitOrdersForThisDAOCoinPair: Called with nil buy coin PKID; this should never happen")
+// DAOCoinLimitOrderBookPagination is used to page through an order book. Pages
+// start with the order right after StartAfterOrder, which is typically the last
+// order of the previous page. A MaxOrders of zero returns all remaining orders.
+type DAOCoinLimitOrderBookPagination struct {
+	StartAfterOrder *DAOCoinLimitOrderEntry
+	MaxOrders       int
+}
+
+// GetMergedOrderBook returns the open orders for the input pair with the view's
+// pending changes overlaid on the db: an order in the view replaces its db version,
+// and an order deleted in the view is a tombstone that hides its db version. Orders
+// are sorted best first, i.e. by IsBetterMatchingOrderThan, which is the same order
+// the db index is iterated in. The view itself is not modified.
+func GetMergedOrderBook(
+	bav *UtxoView, pair *DAOCoinLimitOrderPair, pagination *DAOCoinLimitOrderBookPagination) (
+	[]*DAOCoinLimitOrderEntry, error) {
+
+	if pair == nil || pair.BuyingDAOCoinCreatorPKID == nil || pair.SellingDAOCoinCreatorPKID == nil {
+		return nil, errors.Errorf("GetMergedOrderBook: Called with nil DAO coin pair; this should never happen")
 	}
-	if sellingDAOCoinCreatorPKID == nil {
-		return nil, errors.Errorf("GetAllDAOCoinLimitOrdersForThisDAOCoinPair: Called with nil sell coin PKID; this should never happen")
+
+	var startAfterOrder *DAOCoinLimitOrderEntry
+	maxOrders := 0
+	if pagination != nil {
+		startAfterOrder = pagination.StartAfterOrder
+		maxOrders = pagination.MaxOrders
+	}
+	if maxOrders < 0 {
+		return nil, errors.Errorf("GetMergedOrderBook: Called with negative MaxOrders %d", maxOrders)
+	}
+	if startAfterOrder != nil &&
+		(!startAfterOrder.BuyingDAOCoinCreatorPKID.Eq(pair.BuyingDAOCoinCreatorPKID) ||
+			!startAfterOrder.SellingDAOCoinCreatorPKID.Eq(pair.SellingDAOCoinCreatorPKID)) {
+		return nil, errors.Errorf("GetMergedOrderBook: StartAfterOrder is for a different DAO coin pair")
 	}
 
-	outputEntries := []*DAOCoinLimitOrderEntry{}
+	mergedOrders := []*DAOCoinLimitOrderEntry{}
 
-	// Iterate over matching database orders and add them to the
-	// UTXO view if they are not already there. This dedups orders
-	// from the database + orders from the UTXO view as well.
-	dbOrderEntries, err := bav.GetDbAdapter().GetAllDAOCoinLimitOrdersForThisDAOCoinPair(
-		buyingDAOCoinCreatorPKID, sellingDAOCoinCreatorPKID)
-	if err != nil {
-		return nil, err
+	// Get the orders for this pair from the view that come after the start
+	// after order. Deleted orders are skipped here, but they still shadow
+	// the db below.
+	for _, orderEntry := range bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry {
+		if orderEntry.isDeleted ||
+			!orderEntry.BuyingDAOCoinCreatorPKID.Eq(pair.BuyingDAOCoinCreatorPKID) ||
+			!orderEntry.SellingDAOCoinCreatorPKID.Eq(pair.SellingDAOCoinCreatorPKID) {
+			continue
+		}
+		if startAfterOrder != nil && !startAfterOrder.IsBetterMatchingOrderThan(orderEntry) {
+			continue
+		}
+		mergedOrders = append(mergedOrders, orderEntry)
 	}
 
-	for _, orderEntry := range dbOrderEntries {
-		orderMapKey := orderEntry.ToMapKey()
+	// Page through the db until we have maxOrders orders that aren't in the view
+	// or we run out of orders. Since the db pages are sorted the same way as the
+	// output, no other db orders can make it into the output.
+	dbStartAfterOrder := startAfterOrder
+	numDbOrders := 0
+	for {
+		dbOrders, err := bav.GetDbAdapter().GetPaginatedDAOCoinLimitOrdersForThisDAOCoinPair(
+			pair.BuyingDAOCoinCreatorPKID, pair.SellingDAOCoinCreatorPKID, dbStartAfterOrder, maxOrders)
+		if err != nil {
+			return nil, errors.Wrapf(err, "GetMergedOrderBook: Problem getting orders from db: ")
+		}
+
+		for _, dbOrder := range dbOrders {
+			// Dedup against the view, which has the latest version of the order.
+			if _, existsInView := bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry[dbOrder.ToMapKey()]; existsInView {
+				continue
+			}
+			mergedOrders = append(mergedOrders, dbOrder)
+			numDbOrders++
+		}
 
-		if _, exists := bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry[orderMapKey]; !exists {
-			bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry[orderMapKey] = orderEntry
+		if maxOrders == 0 || len(dbOrders) < maxOrders || numDbOrders >= maxOrders {
+			break
 		}
+		dbStartAfterOrder = dbOrders[len(dbOrders)-1]
 	}
 
-	// Get matching orders from the UTXO view.
-	//   + BuyingDAOCoinCreatorPKID should match.
-	//   + SellingDAOCoincreatorPKID should match.
-	//   + orderEntry is not deleted.
-	for _, orderEntry := range bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry {
-		if !orderEntry.isDeleted &&
-			orderEntry.BuyingDAOCoinCreatorPKID.Eq(buyingDAOCoinCreatorPKID) &&
-			orderEntry.SellingDAOCoinCreatorPKID.Eq(sellingDAOCoinCreatorPKID) {
-			outputEntries = append(outputEntries, orderEntry)
-		}
+	sort.Slice(mergedOrders, func(ii, jj int) bool {
+		return mergedOrders[ii].IsBetterMatchingOrderThan(mergedOrders[jj])
+	})
+	if maxOrders > 0 && len(mergedOrders) > maxOrders {
+		mergedOrders = mergedOrders[:maxOrders]
 	}
 
-	return outputEntries, nil
+	return mergedOrders, nil
+}
+
+func (bav *UtxoView) GetAllDAOCoinLimitOrdersForThisDAOCoinPair(
+	buyingDAOCoinCreatorPKID *PKID, sellingDAOCoinCreatorPKID *PKID) ([]*DAOCoinLimitOrderEntry, error) {
+	// This function is used by the API to construct all open
+	// orders for the input buying and selling DAO coins.
+	if buyingDAOCoinCreatorPKID == nil {
+		return nil, errors.Errorf("GetAllDAOCoinLimitOrdersForThisDAOCoinPair: Called with nil buy coin PKID; this should never happen")
+	}
+	if sellingDAOCoinCreatorPKID == nil {
+		return nil, errors.Errorf("GetAllDAOCoinLimitOrdersForThisDAOCoinPair: Called with nil sell coin PKID; this should never happen")
+	}
+
+	// The merged order book dedups orders from the database
+	// against orders from the UTXO view, and skips orders
+	// that have been deleted in the view.
+	return GetMergedOrderBook(bav, &DAOCoinLimitOrderPair{
+		BuyingDAOCoinCreatorPKID:  buyingDAOCoinCreatorPKID,
+		SellingDAOCoinCreatorPKID: sellingDAOCoinCreatorPKID,
+	}, nil)
 }
 
 func (bav *UtxoView) GetAllDAOCoinLimitOrdersForThisTransactor(transactorPKID *PKID) ([]*DAOCoinLimitOrderEntry, error) {
@@ -1478,11 +2131,7 @@ func (bav *UtxoView) GetAllDAOCoinLimitOrdersForThisTransactor(transactorPKID *P
 	}
 
 	for _, orderEntry := range dbOrderEntries {
-		orderMapKey := orderEntry.ToMapKey()
-
-		if _, exists := bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry[orderMapKey]; !exists {
-			bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry[orderMapKey] = orderEntry
-		}
+		bav._cacheDAOCoinLimitOrderEntry(orderEntry)
 	}
 
 	// Get matching orders from the UTXO view.
@@ -1509,12 +2158,48 @@ func (bav *UtxoView) IsValidDAOCoinLimitOrderMetadata(transactorPK []byte, metad
 		return RuleErrorDAOCoinLimitOrderFeeNanosBelowMinTxFee
 	}
 
+	// At most one of CancelOrderID, CancelAllForPair, and ReplaceOrderID can be set.
+	if (metadata.CancelOrderID != nil && (metadata.CancelAllForPair || metadata.ReplaceOrderID != nil)) ||
+		(metadata.CancelAllForPair && metadata.ReplaceOrderID != nil) {
+		return RuleErrorDAOCoinLimitOrderConflictingCancelFields
+	}
+
+	// A trigger order is a new order, so it can't cancel or replace anything.
+	if metadata.TriggerScaledExchangeRate != nil &&
+		(metadata.CancelOrderID != nil || metadata.CancelAllForPair || metadata.ReplaceOrderID != nil) {
+		return RuleErrorDAOCoinLimitOrderTriggerWithCancelOrReplace
+	}
+
+	// Only a GoodTillCancelled order rests on the book, so only it can expire.
+	if metadata.ExpirationBlockHeight != 0 &&
+		(metadata.CancelOrderID != nil || metadata.CancelAllForPair ||
+			metadata.FillType != DAOCoinLimitOrderFillTypeGoodTillCancelled) {
+		return RuleErrorDAOCoinLimitOrderInvalidExpiration
+	}
+
 	// If the transactor is just cancelling an order,
 	// then the below validations do not apply.
 	if metadata.CancelOrderID != nil {
 		return nil
 	}
 
+	// If the transactor is cancelling all their orders for a pair,
+	// then we only need to validate the pair.
+	if metadata.CancelAllForPair {
+		buyCoinPKIDEntry := bav.GetPKIDForPublicKey(metadata.BuyingDAOCoinCreatorPublicKey.ToBytes())
+		if buyCoinPKIDEntry == nil || buyCoinPKIDEntry.isDeleted {
+			return RuleErrorDAOCoinLimitOrderInvalidBuyingDAOCoinCreatorPKID
+		}
+		sellCoinPKIDEntry := bav.GetPKIDForPublicKey(metadata.SellingDAOCoinCreatorPublicKey.ToBytes())
+		if sellCoinPKIDEntry == nil || sellCoinPKIDEntry.isDeleted {
+			return RuleErrorDAOCoinLimitOrderInvalidSellingDAOCoinCreatorPKID
+		}
+		if buyCoinPKIDEntry.PKID.Eq(sellCoinPKIDEntry.PKID) {
+			return RuleErrorDAOCoinLimitOrderCannotBuyAndSellSameCoin
+		}
+		return nil
+	}
+
 	// Validate TransactorPublicKey.
 	transactorPKIDEntry := bav.GetPKIDForPublicKey(transactorPK)
 	if transactorPKIDEntry == nil || transactorPKIDEntry.isDeleted {
@@ -1545,7 +2230,49 @@ func (bav *UtxoView) IsValidDAOCoinLimitOrderMetadata(transactorPK []byte, metad
 	}
 
 	// Validate order entry.
-	return bav.IsValidDAOCoinLimitOrder(order)
+	if err := bav.IsValidDAOCoinLimitOrder(order); err != nil {
+		return err
+	}
+
+	// Validate the trigger. A triggered order rests on the book, so it has to be
+	// GoodTillCancelled, and a trigger order doesn't match when it's placed, so it
+	// doesn't spend any bidder's inputs.
+	if metadata.TriggerScaledExchangeRate != nil {
+		if metadata.TriggerScaledExchangeRate.IsZero() {
+			return RuleErrorDAOCoinLimitOrderInvalidTriggerExchangeRate
+		}
+		if metadata.FillType != DAOCoinLimitOrderFillTypeGoodTillCancelled {
+			return RuleErrorDAOCoinLimitOrderTriggerOnNonGoodTillCancelledOrder
+		}
+		if len(metadata.BidderInputs) != 0 {
+			return RuleErrorDAOCoinLimitOrderTriggerWithBidderInputs
+		}
+	}
+
+	// Validate the max slippage, which only applies to market orders.
+	if metadata.MarketOrderMaxSlippageBasisPoints != 0 {
+		if !order.IsMarketOrder() {
+			return RuleErrorDAOCoinLimitOrderSlippageOnNonMarketOrder
+		}
+		if metadata.MarketOrderMaxSlippageBasisPoints > 10000 {
+			return RuleErrorDAOCoinLimitOrderInvalidSlippage
+		}
+	}
+
+	// Validate the order against the min quantity and exchange rate tick global params. These
+	// only apply to new orders, so unlike the checks above they aren't part of IsValidDAOCoinLimitOrder,
+	// which also runs on orders that are already on the book.
+	if minQuantity := bav.GlobalParamsEntry.MinDAOCoinLimitOrderQuantityInBaseUnits; minQuantity != nil &&
+		order.QuantityToFillInBaseUnits.Lt(minQuantity) {
+		return RuleErrorDAOCoinLimitOrderQuantityBelowMinimum
+	}
+	// Market orders don't specify an exchange rate, so the tick doesn't apply to them.
+	if exchangeRateTick := bav.GlobalParamsEntry.DAOCoinLimitOrderScaledExchangeRateTick; !order.IsMarketOrder() &&
+		exchangeRateTick != nil && !exchangeRateTick.IsZero() &&
+		!uint256.NewInt().Mod(order.ScaledExchangeRateCoinsToSellPerCoinToBuy, exchangeRateTick).IsZero() {
+		return RuleErrorDAOCoinLimitOrderExchangeRateNotMultipleOfTick
+	}
+	return nil
 }
 
 func (bav *UtxoView) IsValidDAOCoinLimitOrder(order *DAOCoinLimitOrderEntry) error {
@@ -1699,9 +2426,12 @@ func (order *DAOCoinLimitOrderEntry) IsValidMatchingOrderPrice(matchingOrder *DA
 	// willing to accept any price, so we should always return true here.
 	// The matching orders are sorted elsewhere by best-price first, so the
 	// transactor is guaranteed that they are getting the best price available
-	// in the order book for the specified buying + selling coin pair.
+	// in the order book for the specified buying + selling coin pair. The
+	// exception is a market order with a max slippage that has already
+	// matched an order, which only accepts prices within its slippage bound.
 	if order.IsMarketOrder() {
-		return true
+		return order.marketOrderMinMatchingScaledExchangeRate == nil ||
+			!matchingOrder.ScaledExchangeRateCoinsToSellPerCoinToBuy.Lt(order.marketOrderMinMatchingScaledExchangeRate)
 	}
 
 	// Return false if the price on the order exceeds the value we're looking for. We have
@@ -1798,6 +2528,22 @@ func (bav *UtxoView) IsValidDAOCoinLimitOrderMatch(
 	return nil
 }
 
+// IsValidDAOCoinLimitOrderFeeTransfer returns an error if the DAO coin's transfer
+// restriction status doesn't allow the payer to send a fee to the fee destination.
+// Fees paid in DESO are always allowed.
+func (bav *UtxoView) IsValidDAOCoinLimitOrderFeeTransfer(
+	daoCoinCreatorPKID *PKID, payerPublicKey []byte, feeDestinationPublicKey []byte) error {
+
+	if daoCoinCreatorPKID.IsZeroPKID() {
+		return nil
+	}
+	creatorProfileEntry := bav.GetProfileEntryForPKID(daoCoinCreatorPKID)
+	if creatorProfileEntry == nil || creatorProfileEntry.isDeleted {
+		return RuleErrorDAOCoinLimitOrderBuyingDAOCoinCreatorMissingProfile
+	}
+	return bav.IsValidDAOCoinTransfer(creatorProfileEntry, payerPublicKey, feeDestinationPublicKey)
+}
+
 func CalculateScaledExchangeRateFromString(priceStr string) (*uint256.Int, error) {
 	return ScaleFloatFormatStringToUint256(priceStr, OneE38)
 }
@@ -1806,6 +2552,45 @@ func CalculateScaledExchangeRate(price float64) (*uint256.Int, error) {
 	return CalculateScaledExchangeRateFromString(fmt.Sprintf("%v", price))
 }
 
+// ComputeDAOCoinLimitOrderFeeBaseUnits returns the maker or taker fee, rounded down, that is
+// charged on coinBaseUnitsReceived in a match. The product is computed as a big.Int because it
+// can exceed MaxUint256 even though the fee itself never exceeds coinBaseUnitsReceived.
+func ComputeDAOCoinLimitOrderFeeBaseUnits(coinBaseUnitsReceived *uint256.Int, feeBasisPoints uint64) (
+	*uint256.Int, error) {
+
+	if feeBasisPoints > 100*100 {
+		return nil, fmt.Errorf("ComputeDAOCoinLimitOrderFeeBaseUnits: fee of %v basis points exceeds 100%%",
+			feeBasisPoints)
+	}
+	feeBig := big.NewInt(0).Mul(coinBaseUnitsReceived.ToBig(), big.NewInt(0).SetUint64(feeBasisPoints))
+	feeBig.Div(feeBig, big.NewInt(100*100))
+	fee, _ := uint256.FromBig(feeBig)
+	return fee, nil
+}
+
+// RoundScaledExchangeRateToTick rounds a scaled exchange rate to a multiple of the tick, rounding up if
+// roundUp is set and down otherwise. A nil or zero tick leaves the exchange rate unchanged.
+func RoundScaledExchangeRateToTick(
+	scaledExchangeRate *uint256.Int, tick *uint256.Int, roundUp bool) (*uint256.Int, error) {
+
+	if scaledExchangeRate == nil {
+		return nil, fmt.Errorf("RoundScaledExchangeRateToTick: scaledExchangeRate is nil")
+	}
+	if tick == nil || tick.IsZero() {
+		return scaledExchangeRate.Clone(), nil
+	}
+	remainder := uint256.NewInt().Mod(scaledExchangeRate, tick)
+	roundedDown := uint256.NewInt().Sub(scaledExchangeRate, remainder)
+	if !roundUp || remainder.IsZero() {
+		return roundedDown, nil
+	}
+	roundedUp, err := SafeUint256().Add(roundedDown, tick)
+	if err != nil {
+		return nil, errors.Wrapf(err, "RoundScaledExchangeRateToTick: ")
+	}
+	return roundedUp, nil
+}
+
 // ScaleFloatFormatStringToUint256 The most accurate way we've found to convert a decimal into a
 // "scaled" value is to parse a string representation into a "whole" bigint
 // and a "decimal" bigint. Once we have these two pieces of the number, we
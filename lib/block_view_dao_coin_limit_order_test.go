@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -3457,194 +3459,1389 @@ func TestDAOCoinLimitOrder(t *testing.T) {
 	_executeAllTestRollbackAndFlush(testMeta)
 }
 
-func TestCalculateDAOCoinsTransferredInLimitOrderMatch(t *testing.T) {
+func TestDAOCoinLimitOrderCancelAllAndReplace(t *testing.T) {
+	// -----------------------
+	// Initialization
+	// -----------------------
+
+	// Test constants
+	const feeRateNanosPerKb = uint64(101)
+
+	// Initialize test chain and miner.
 	require := require.New(t)
-	m0PKID := NewPKID(m0PkBytes)
-	m1PKID := NewPKID(m1PkBytes)
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
 
-	// Scenario 1: one ASK, one BID, exactly matching orders
-	{
-		// m0 sells 1000 DAO coin base units @ 0.1 $DESO / DAO coin.
-		exchangeRate, err := CalculateScaledExchangeRate(10.0)
-		require.NoError(err)
-		m0Order := &DAOCoinLimitOrderEntry{
-			OrderID:                   NewBlockHash(uint256.NewInt().SetUint64(1).Bytes()), // Not used
-			TransactorPKID:            m0PKID,
-			BuyingDAOCoinCreatorPKID:  &ZeroPKID,
-			SellingDAOCoinCreatorPKID: m0PKID,
-			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
-			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(1000),
-			OperationType:                             DAOCoinLimitOrderOperationTypeASK,
-			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
-		}
+	params.ForkHeights.DAOCoinBlockHeight = uint32(0)
+	params.ForkHeights.DAOCoinLimitOrderBlockHeight = uint32(0)
+	params.ForkHeights.OrderBookDBFetchOptimizationBlockHeight = uint32(0)
+	params.ForkHeights.DAOCoinLimitOrderCancelAllAndReplaceBlockHeight = uint32(math.MaxUint32)
 
-		// m1 buys 1000 DAO coin base units @ 0.1 $DESO / DAO coin.
-		exchangeRate, err = CalculateScaledExchangeRate(0.1)
-		require.NoError(err)
-		m1Order := &DAOCoinLimitOrderEntry{
-			OrderID:                   NewBlockHash(uint256.NewInt().SetUint64(1).Bytes()), // Not used
-			TransactorPKID:            m1PKID,
-			BuyingDAOCoinCreatorPKID:  m0PKID,
-			SellingDAOCoinCreatorPKID: &ZeroPKID,
-			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
-			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(1000),
-			OperationType:                             DAOCoinLimitOrderOperationTypeBID,
-			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
-		}
+	utxoView, err := NewUtxoView(db, params, chain.postgres, chain.snapshot)
+	require.NoError(err)
+	dbAdapter := utxoView.GetDbAdapter()
 
-		// m0 = transactor, m1 = matching order
-		updatedTransactorQuantityToFillInBaseUnits,
-			updatedMatchingQuantityToFillInBaseUnits,
-			transactorBuyingCoinBaseUnitsTransferred,
-			transactorSellingCoinBaseUnitsTransferred,
-			err := _calculateDAOCoinsTransferredInLimitOrderMatch(m1Order, m0Order.OperationType, m0Order.QuantityToFillInBaseUnits)
+	// Mine a few blocks to give the senderPkString some money.
+	for ii := 0; ii < 4; ii++ {
+		_, err = miner.MineAndProcessSingleBlock(0, mempool)
 		require.NoError(err)
-		require.Equal(updatedTransactorQuantityToFillInBaseUnits, uint256.NewInt())
-		require.Equal(updatedMatchingQuantityToFillInBaseUnits, uint256.NewInt())
-		require.Equal(transactorBuyingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(100))
-		require.Equal(transactorSellingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(1000))
+	}
 
-		// m1 = transactor, m0 = matching order
-		updatedTransactorQuantityToFillInBaseUnits,
-			updatedMatchingQuantityToFillInBaseUnits,
-			transactorBuyingCoinBaseUnitsTransferred,
-			transactorSellingCoinBaseUnitsTransferred,
-			err = _calculateDAOCoinsTransferredInLimitOrderMatch(m0Order, m1Order.OperationType, m1Order.QuantityToFillInBaseUnits)
-		require.NoError(err)
-		require.Equal(updatedTransactorQuantityToFillInBaseUnits, uint256.NewInt())
-		require.Equal(updatedMatchingQuantityToFillInBaseUnits, uint256.NewInt())
-		require.Equal(transactorBuyingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(1000))
-		require.Equal(transactorSellingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(100))
+	// We build the testMeta obj after mining blocks so that we save the correct block height.
+	testMeta := &TestMeta{
+		t:           t,
+		chain:       chain,
+		params:      params,
+		db:          db,
+		mempool:     mempool,
+		miner:       miner,
+		savedHeight: chain.blockTip().Height + 1,
 	}
 
-	// Scenario 2: one BID, one ASK, matching orders w/ mismatched prices
+	_registerOrTransferWithTestMeta(testMeta, "m0", senderPkString, m0Pub, senderPrivString, 7000)
+	_registerOrTransferWithTestMeta(testMeta, "m1", senderPkString, m1Pub, senderPrivString, 4000)
+
+	m0PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m0PkBytes)
+	m1PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m1PkBytes)
+
+	// Create a profile for m0 and mint some of their DAO coins.
 	{
-		// m0 buys 1000 DAO coin base units @ 10 $DESO / DAO coin.
-		exchangeRate, err := CalculateScaledExchangeRate(10.0)
-		require.NoError(err)
-		m0Order := &DAOCoinLimitOrderEntry{
-			OrderID:                   NewBlockHash(uint256.NewInt().SetUint64(1).Bytes()), // Not used
-			TransactorPKID:            m0PKID,
-			BuyingDAOCoinCreatorPKID:  m0PKID,
-			SellingDAOCoinCreatorPKID: &ZeroPKID,
-			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
-			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(1000),
-			OperationType:                             DAOCoinLimitOrderOperationTypeBID,
-			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
-		}
+		_updateProfileWithTestMeta(
+			testMeta,
+			feeRateNanosPerKb, /*feeRateNanosPerKB*/
+			m0Pub,             /*updaterPkBase58Check*/
+			m0Priv,            /*updaterPrivBase58Check*/
+			[]byte{},          /*profilePubKey*/
+			"m0",              /*newUsername*/
+			"i am the m0",     /*newDescription*/
+			shortPic,          /*newProfilePic*/
+			10*100,            /*newCreatorBasisPoints*/
+			1.25*100*100,      /*newStakeMultipleBasisPoints*/
+			false,             /*isHidden*/
+		)
 
-		// m1 sells 500 DAO coin base units @ 5 $DESO / DAO coin.
-		exchangeRate, err = CalculateScaledExchangeRate(0.2)
-		require.NoError(err)
-		m1Order := &DAOCoinLimitOrderEntry{
-			OrderID:                   NewBlockHash(uint256.NewInt().SetUint64(1).Bytes()), // Not used
-			TransactorPKID:            m1PKID,
-			BuyingDAOCoinCreatorPKID:  &ZeroPKID,
-			SellingDAOCoinCreatorPKID: m0PKID,
-			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
-			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(500),
-			OperationType:                             DAOCoinLimitOrderOperationTypeASK,
-			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+		daoCoinMintMetadata := DAOCoinMetadata{
+			ProfilePublicKey: m0PkBytes,
+			OperationType:    DAOCoinOperationTypeMint,
+			CoinsToMintNanos: *uint256.NewInt().SetUint64(1e8),
 		}
+		_daoCoinTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, daoCoinMintMetadata)
+	}
 
-		// m0 = transactor, m1 = matching order
-		// m0 buys 500 DAO coin base units @ 5 $DESO / DAO coin.
-		updatedTransactorQuantityToFillInBaseUnits,
-			updatedMatchingQuantityToFillInBaseUnits,
-			transactorBuyingCoinBaseUnitsTransferred,
-			transactorSellingCoinBaseUnitsTransferred,
-			err := _calculateDAOCoinsTransferredInLimitOrderMatch(m1Order, m0Order.OperationType, m0Order.QuantityToFillInBaseUnits)
-		require.NoError(err)
-		require.Equal(updatedTransactorQuantityToFillInBaseUnits, uint256.NewInt().SetUint64(500))
-		require.Equal(updatedMatchingQuantityToFillInBaseUnits, uint256.NewInt())
-		require.Equal(transactorBuyingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(500))
-		require.Equal(transactorSellingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(2500))
-
-		// m1 = transactor, m0 = matching order
-		// m1 sells 500 DAO coin base units @ 10 $DESO / DAO coin.
-		updatedTransactorQuantityToFillInBaseUnits,
-			updatedMatchingQuantityToFillInBaseUnits,
-			transactorBuyingCoinBaseUnitsTransferred,
-			transactorSellingCoinBaseUnitsTransferred,
-			err = _calculateDAOCoinsTransferredInLimitOrderMatch(m0Order, m1Order.OperationType, m1Order.QuantityToFillInBaseUnits)
-		require.NoError(err)
-		require.Equal(updatedTransactorQuantityToFillInBaseUnits, uint256.NewInt())
-		require.Equal(updatedMatchingQuantityToFillInBaseUnits, uint256.NewInt().SetUint64(500))
-		require.Equal(transactorBuyingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(5000))
-		require.Equal(transactorSellingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(500))
+	// Helper function to submit an order and return its OrderID.
+	submitOrder := func(publicKey string, privateKey string, metadata DAOCoinLimitOrderMetadata) *BlockHash {
+		_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, publicKey, privateKey, metadata)
+		return testMeta.txns[len(testMeta.txns)-1].Hash()
+	}
+	orderIDs := func(orderEntries []*DAOCoinLimitOrderEntry) []BlockHash {
+		ids := []BlockHash{}
+		for _, orderEntry := range orderEntries {
+			ids = append(ids, *orderEntry.OrderID)
+		}
+		return ids
 	}
 
-	// Scenario 3: m0 and m1 both submit BIDs that should match
-	{
-		// m0 buys 100 DAO coin base units @ 10 $DESO / DAO coin.
-		exchangeRate, err := CalculateScaledExchangeRate(10.0)
+	// -----------------------
+	// Tests
+	// -----------------------
+
+	// m0 bids for their own DAO coin at 0.1 and 0.2 $DESO / DAO coin, and asks 1 $DESO / DAO coin.
+	// m1 also bids for m0's DAO coin at 0.1 $DESO / DAO coin. None of these orders match.
+	bidMetadata := func(price float64) DAOCoinLimitOrderMetadata {
+		exchangeRate, err := CalculateScaledExchangeRate(price)
 		require.NoError(err)
-		m0Order := &DAOCoinLimitOrderEntry{
-			OrderID:                   NewBlockHash(uint256.NewInt().SetUint64(1).Bytes()), // Not used
-			TransactorPKID:            m0PKID,
-			BuyingDAOCoinCreatorPKID:  m0PKID,
-			SellingDAOCoinCreatorPKID: &ZeroPKID,
+		return DAOCoinLimitOrderMetadata{
+			BuyingDAOCoinCreatorPublicKey:             NewPublicKey(m0PkBytes),
+			SellingDAOCoinCreatorPublicKey:            &ZeroPublicKey,
 			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
 			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(100),
 			OperationType:                             DAOCoinLimitOrderOperationTypeBID,
 			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
 		}
+	}
+	exchangeRate, err := CalculateScaledExchangeRate(1.0)
+	require.NoError(err)
+	askMetadataM0 := DAOCoinLimitOrderMetadata{
+		BuyingDAOCoinCreatorPublicKey:             &ZeroPublicKey,
+		SellingDAOCoinCreatorPublicKey:            NewPublicKey(m0PkBytes),
+		ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
+		QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(100),
+		OperationType:                             DAOCoinLimitOrderOperationTypeASK,
+		FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+	}
+	firstBidIDM0 := submitOrder(m0Pub, m0Priv, bidMetadata(0.1))
+	secondBidIDM0 := submitOrder(m0Pub, m0Priv, bidMetadata(0.2))
+	askIDM0 := submitOrder(m0Pub, m0Priv, askMetadataM0)
+	bidIDM1 := submitOrder(m1Pub, m1Priv, bidMetadata(0.1))
 
-		// m1 buys 1000 $DESO @ 0.1 DAO coin / $DESO.
-		exchangeRate, err = CalculateScaledExchangeRate(0.1)
-		require.NoError(err)
-		m1Order := &DAOCoinLimitOrderEntry{
-			OrderID:                   NewBlockHash(uint256.NewInt().SetUint64(1).Bytes()), // Not used
-			TransactorPKID:            m1PKID,
-			BuyingDAOCoinCreatorPKID:  &ZeroPKID,
-			SellingDAOCoinCreatorPKID: m0PKID,
-			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
-			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(1000),
-			OperationType:                             DAOCoinLimitOrderOperationTypeBID,
-			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+	orderEntries, err := dbAdapter.GetAllDAOCoinLimitOrdersForThisTransactor(m0PKID.PKID)
+	require.NoError(err)
+	require.ElementsMatch([]BlockHash{*firstBidIDM0, *secondBidIDM0, *askIDM0}, orderIDs(orderEntries))
+
+	// RuleErrorDAOCoinLimitOrderCancelAllAndReplaceBeforeBlockHeight
+	{
+		cancelAllMetadata := DAOCoinLimitOrderMetadata{
+			BuyingDAOCoinCreatorPublicKey:  NewPublicKey(m0PkBytes),
+			SellingDAOCoinCreatorPublicKey: &ZeroPublicKey,
+			CancelAllForPair:               true,
 		}
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv, cancelAllMetadata)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderCancelAllAndReplaceBeforeBlockHeight)
 
-		// m0 = transactor, m1 = matching order
-		// m0 buys 100 DAO coin base units @ 10 $DESO / DAO coin.
-		updatedTransactorQuantityToFillInBaseUnits,
-			updatedMatchingQuantityToFillInBaseUnits,
-			transactorBuyingCoinBaseUnitsTransferred,
-			transactorSellingCoinBaseUnitsTransferred,
-			err := _calculateDAOCoinsTransferredInLimitOrderMatch(m1Order, m0Order.OperationType, m0Order.QuantityToFillInBaseUnits)
-		require.NoError(err)
-		require.Equal(updatedTransactorQuantityToFillInBaseUnits, uint256.NewInt())
-		require.Equal(updatedMatchingQuantityToFillInBaseUnits, uint256.NewInt())
-		require.Equal(transactorBuyingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(100))
-		require.Equal(transactorSellingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(1000))
+		replaceMetadata := bidMetadata(0.15)
+		replaceMetadata.ReplaceOrderID = firstBidIDM0
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv, replaceMetadata)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderCancelAllAndReplaceBeforeBlockHeight)
+	}
 
-		// m1 = transactor, m0 = matching order
-		// m1 buys 1000 $DESO @ 0.1 DAO coin / $DESO.
-		updatedTransactorQuantityToFillInBaseUnits,
-			updatedMatchingQuantityToFillInBaseUnits,
-			transactorBuyingCoinBaseUnitsTransferred,
-			transactorSellingCoinBaseUnitsTransferred,
-			err = _calculateDAOCoinsTransferredInLimitOrderMatch(m0Order, m1Order.OperationType, m1Order.QuantityToFillInBaseUnits)
-		require.NoError(err)
-		require.Equal(updatedTransactorQuantityToFillInBaseUnits, uint256.NewInt())
-		require.Equal(updatedMatchingQuantityToFillInBaseUnits, uint256.NewInt())
-		require.Equal(transactorBuyingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(1000))
-		require.Equal(transactorSellingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(100))
+	params.ForkHeights.DAOCoinLimitOrderCancelAllAndReplaceBlockHeight = uint32(0)
+
+	// RuleErrorDAOCoinLimitOrderConflictingCancelFields
+	{
+		metadata := bidMetadata(0.15)
+		metadata.ReplaceOrderID = firstBidIDM0
+		metadata.CancelAllForPair = true
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv, metadata)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderConflictingCancelFields)
 	}
 
-	// Scenario 4: m0 and m1 both submit BIDs that match, m1 gets a better price than expected
+	// RuleErrorDAOCoinLimitOrderToReplaceNotFound, RuleErrorDAOCoinLimitOrderToReplaceNotYours,
+	// and RuleErrorDAOCoinLimitOrderToReplaceDifferentPair
 	{
-		// m0 buys 100 DAO coin base units @ 10 $DESO / DAO coin.
-		exchangeRate, err := CalculateScaledExchangeRate(10.0)
+		metadata := bidMetadata(0.15)
+		metadata.ReplaceOrderID = NewBlockHash(uint256.NewInt().SetUint64(1).Bytes())
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv, metadata)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderToReplaceNotFound)
+
+		metadata.ReplaceOrderID = firstBidIDM0
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m1Pub, m1Priv, metadata)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderToReplaceNotYours)
+
+		metadata = askMetadataM0
+		metadata.ReplaceOrderID = firstBidIDM0
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv, metadata)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderToReplaceDifferentPair)
+	}
+
+	// Scenario: m0 replaces their first bid with a bid at a new price and quantity.
+	{
+		metadata := bidMetadata(0.15)
+		metadata.QuantityToFillInBaseUnits = uint256.NewInt().SetUint64(50)
+		metadata.ReplaceOrderID = firstBidIDM0
+		replacementBidIDM0 := submitOrder(m0Pub, m0Priv, metadata)
+
+		orderEntries, err = dbAdapter.GetAllDAOCoinLimitOrdersForThisTransactor(m0PKID.PKID)
 		require.NoError(err)
-		m0Order := &DAOCoinLimitOrderEntry{
-			OrderID:                   NewBlockHash(uint256.NewInt().SetUint64(1).Bytes()), // Not used
-			TransactorPKID:            m0PKID,
-			BuyingDAOCoinCreatorPKID:  m0PKID,
-			SellingDAOCoinCreatorPKID: &ZeroPKID,
-			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
-			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(100),
-			OperationType:                             DAOCoinLimitOrderOperationTypeBID,
-			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+		require.ElementsMatch([]BlockHash{*replacementBidIDM0, *secondBidIDM0, *askIDM0}, orderIDs(orderEntries))
+		replacementOrder, err := dbAdapter.GetDAOCoinLimitOrder(replacementBidIDM0)
+		require.NoError(err)
+		require.Equal(uint64(50), replacementOrder.QuantityToFillInBaseUnits.Uint64())
+	}
+
+	// Scenario: m0 cancels all of their orders for the pair, on both sides of the book.
+	// m1's order is left untouched.
+	{
+		cancelAllMetadata := DAOCoinLimitOrderMetadata{
+			BuyingDAOCoinCreatorPublicKey:  NewPublicKey(m0PkBytes),
+			SellingDAOCoinCreatorPublicKey: &ZeroPublicKey,
+			CancelAllForPair:               true,
+		}
+		_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, cancelAllMetadata)
+
+		orderEntries, err = dbAdapter.GetAllDAOCoinLimitOrdersForThisTransactor(m0PKID.PKID)
+		require.NoError(err)
+		require.Empty(orderEntries)
+		orderEntries, err = dbAdapter.GetAllDAOCoinLimitOrdersForThisTransactor(m1PKID.PKID)
+		require.NoError(err)
+		require.ElementsMatch([]BlockHash{*bidIDM1}, orderIDs(orderEntries))
+	}
+
+	_executeAllTestRollbackAndFlush(testMeta)
+}
+
+func TestDAOCoinLimitOrderMinQuantityAndTick(t *testing.T) {
+	// -----------------------
+	// Initialization
+	// -----------------------
+
+	// Test constants
+	const feeRateNanosPerKb = uint64(101)
+
+	// Initialize test chain and miner.
+	require := require.New(t)
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+
+	params.ForkHeights.DAOCoinBlockHeight = uint32(0)
+	params.ForkHeights.DAOCoinLimitOrderBlockHeight = uint32(0)
+	params.ForkHeights.OrderBookDBFetchOptimizationBlockHeight = uint32(0)
+	params.ForkHeights.DAOCoinLimitOrderMinSizeAndTickBlockHeight = uint32(0)
+	params.ExtraRegtestParamUpdaterKeys[MakePkMapKey(m4PkBytes)] = true
+
+	// Mine a few blocks to give the senderPkString some money.
+	for ii := 0; ii < 4; ii++ {
+		_, err := miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+	}
+
+	// We build the testMeta obj after mining blocks so that we save the correct block height.
+	testMeta := &TestMeta{
+		t:           t,
+		chain:       chain,
+		params:      params,
+		db:          db,
+		mempool:     mempool,
+		miner:       miner,
+		savedHeight: chain.blockTip().Height + 1,
+	}
+
+	_registerOrTransferWithTestMeta(testMeta, "m0", senderPkString, m0Pub, senderPrivString, 7000)
+	_registerOrTransferWithTestMeta(testMeta, "m4", senderPkString, m4Pub, senderPrivString, 100)
+
+	// Create a profile for m0 so that there is a DAO coin to bid for.
+	_updateProfileWithTestMeta(
+		testMeta,
+		feeRateNanosPerKb, /*feeRateNanosPerKB*/
+		m0Pub,             /*updaterPkBase58Check*/
+		m0Priv,            /*updaterPrivBase58Check*/
+		[]byte{},          /*profilePubKey*/
+		"m0",              /*newUsername*/
+		"i am the m0",     /*newDescription*/
+		shortPic,          /*newProfilePic*/
+		10*100,            /*newCreatorBasisPoints*/
+		1.25*100*100,      /*newStakeMultipleBasisPoints*/
+		false,             /*isHidden*/
+	)
+
+	// Helper function for m4, a param updater, to set the min quantity and exchange rate tick.
+	updateGlobalParams := func(minQuantity *uint256.Int, exchangeRateTick *uint256.Int) {
+		testMeta.expectedSenderBalances = append(
+			testMeta.expectedSenderBalances, _getBalance(t, chain, nil, m4Pub))
+
+		txn, _, _, _, err := chain.CreateUpdateGlobalParamsTxn(
+			m4PkBytes, -1, -1, -1, -1, -1, nil, minQuantity, exchangeRateTick, -1, -1, nil, nil, nil,
+			feeRateNanosPerKb, nil, []*DeSoOutput{})
+		require.NoError(err)
+		_signTxn(t, txn, m4Priv)
+
+		utxoView, err := NewUtxoView(db, params, chain.postgres, chain.snapshot)
+		require.NoError(err)
+		utxoOps, _, _, _, err := utxoView.ConnectTransaction(
+			txn, txn.Hash(), getTxnSize(*txn), chain.blockTip().Height+1, true /*verifySignature*/, false /*ignoreUtxos*/)
+		require.NoError(err)
+		require.NoError(utxoView.FlushToDb(0))
+
+		testMeta.txnOps = append(testMeta.txnOps, utxoOps)
+		testMeta.txns = append(testMeta.txns, txn)
+	}
+	bidMetadata := func(price float64, quantity uint64) DAOCoinLimitOrderMetadata {
+		exchangeRate, err := CalculateScaledExchangeRate(price)
+		require.NoError(err)
+		return DAOCoinLimitOrderMetadata{
+			BuyingDAOCoinCreatorPublicKey:             NewPublicKey(m0PkBytes),
+			SellingDAOCoinCreatorPublicKey:            &ZeroPublicKey,
+			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
+			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(quantity),
+			OperationType:                             DAOCoinLimitOrderOperationTypeBID,
+			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+		}
+	}
+
+	// -----------------------
+	// Tests
+	// -----------------------
+
+	// Without the params set, any quantity and exchange rate is accepted.
+	_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, bidMetadata(0.15, 10))
+
+	// m4 sets a min quantity of 50 base units and a tick of 0.1 $DESO / DAO coin.
+	minQuantity := uint256.NewInt().SetUint64(50)
+	exchangeRateTick, err := CalculateScaledExchangeRate(0.1)
+	require.NoError(err)
+	updateGlobalParams(minQuantity, exchangeRateTick)
+
+	globalParamsEntry := DbGetGlobalParamsEntry(db, chain.snapshot)
+	require.Equal(minQuantity, globalParamsEntry.MinDAOCoinLimitOrderQuantityInBaseUnits)
+	require.Equal(exchangeRateTick, globalParamsEntry.DAOCoinLimitOrderScaledExchangeRateTick)
+
+	// The update is recorded in the params history at its height, and earlier heights still have
+	// the initial params.
+	updateHeight := uint64(chain.blockTip().Height + 1)
+	historyEntry, err := DbGetGlobalParamsEntryAtHeight(db, updateHeight)
+	require.NoError(err)
+	require.Equal(globalParamsEntry, historyEntry)
+	historyEntry, err = DbGetGlobalParamsEntryAtHeight(db, updateHeight+10)
+	require.NoError(err)
+	require.Equal(globalParamsEntry, historyEntry)
+	historyEntry, err = DbGetGlobalParamsEntryAtHeight(db, updateHeight-1)
+	require.NoError(err)
+	require.Equal(&InitialGlobalParamsEntry, historyEntry)
+
+	// RuleErrorDAOCoinLimitOrderQuantityBelowMinimum
+	{
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv, bidMetadata(0.2, 49))
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderQuantityBelowMinimum)
+	}
+
+	// RuleErrorDAOCoinLimitOrderExchangeRateNotMultipleOfTick
+	{
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv, bidMetadata(0.15, 50))
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderExchangeRateNotMultipleOfTick)
+	}
+
+	// An order at the min quantity and on a tick is accepted, as is an order whose exchange
+	// rate has been rounded to the tick.
+	_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, bidMetadata(0.2, 50))
+	{
+		metadata := bidMetadata(0.15, 50)
+		metadata.ScaledExchangeRateCoinsToSellPerCoinToBuy, err = RoundScaledExchangeRateToTick(
+			metadata.ScaledExchangeRateCoinsToSellPerCoinToBuy, exchangeRateTick, false)
+		require.NoError(err)
+		_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, metadata)
+	}
+
+	// m4 removes the tick by setting it to zero. The min quantity is left unchanged.
+	updateGlobalParams(nil, uint256.NewInt())
+	globalParamsEntry = DbGetGlobalParamsEntry(db, chain.snapshot)
+	require.Equal(minQuantity, globalParamsEntry.MinDAOCoinLimitOrderQuantityInBaseUnits)
+	require.True(globalParamsEntry.DAOCoinLimitOrderScaledExchangeRateTick.IsZero())
+	_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, bidMetadata(0.15, 50))
+
+	// Both updates were at the same height, so the history only has the last one.
+	history, err := DbGetGlobalParamsHistory(db)
+	require.NoError(err)
+	require.Equal([]*GlobalParamsHistoryRecord{{BlockHeight: updateHeight, GlobalParamsEntry: globalParamsEntry}}, history)
+
+	_executeAllTestRollbackAndFlush(testMeta)
+
+	// Disconnecting the updates removes them from the history.
+	history, err = DbGetGlobalParamsHistory(db)
+	require.NoError(err)
+	require.Empty(history)
+}
+
+func TestDAOCoinLimitOrderMakerTakerFees(t *testing.T) {
+	// -----------------------
+	// Initialization
+	// -----------------------
+
+	// Test constants
+	const feeRateNanosPerKb = uint64(101)
+
+	// Initialize test chain and miner.
+	require := require.New(t)
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+
+	params.ForkHeights.DAOCoinBlockHeight = uint32(0)
+	params.ForkHeights.DAOCoinLimitOrderBlockHeight = uint32(0)
+	params.ForkHeights.OrderBookDBFetchOptimizationBlockHeight = uint32(0)
+	params.ForkHeights.DAOCoinLimitOrderMakerTakerFeesBlockHeight = uint32(0)
+	params.ExtraRegtestParamUpdaterKeys[MakePkMapKey(m4PkBytes)] = true
+
+	utxoView, err := NewUtxoView(db, params, chain.postgres, chain.snapshot)
+	require.NoError(err)
+	dbAdapter := utxoView.GetDbAdapter()
+
+	// Mine a few blocks to give the senderPkString some money.
+	for ii := 0; ii < 4; ii++ {
+		_, err = miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+	}
+
+	// We build the testMeta obj after mining blocks so that we save the correct block height.
+	testMeta := &TestMeta{
+		t:           t,
+		chain:       chain,
+		params:      params,
+		db:          db,
+		mempool:     mempool,
+		miner:       miner,
+		savedHeight: chain.blockTip().Height + 1,
+	}
+
+	_registerOrTransferWithTestMeta(testMeta, "m0", senderPkString, m0Pub, senderPrivString, 7000)
+	_registerOrTransferWithTestMeta(testMeta, "m1", senderPkString, m1Pub, senderPrivString, 4000)
+	_registerOrTransferWithTestMeta(testMeta, "m2", senderPkString, m2Pub, senderPrivString, 100)
+	_registerOrTransferWithTestMeta(testMeta, "m4", senderPkString, m4Pub, senderPrivString, 100)
+
+	m0PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m0PkBytes)
+	m1PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m1PkBytes)
+	m2PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m2PkBytes)
+
+	// Create a profile for m0 and mint some of their DAO coins.
+	{
+		_updateProfileWithTestMeta(
+			testMeta,
+			feeRateNanosPerKb, /*feeRateNanosPerKB*/
+			m0Pub,             /*updaterPkBase58Check*/
+			m0Priv,            /*updaterPrivBase58Check*/
+			[]byte{},          /*profilePubKey*/
+			"m0",              /*newUsername*/
+			"i am the m0",     /*newDescription*/
+			shortPic,          /*newProfilePic*/
+			10*100,            /*newCreatorBasisPoints*/
+			1.25*100*100,      /*newStakeMultipleBasisPoints*/
+			false,             /*isHidden*/
+		)
+
+		daoCoinMintMetadata := DAOCoinMetadata{
+			ProfilePublicKey: m0PkBytes,
+			OperationType:    DAOCoinOperationTypeMint,
+			CoinsToMintNanos: *uint256.NewInt().SetUint64(1e8),
+		}
+		_daoCoinTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, daoCoinMintMetadata)
+	}
+
+	// Helper function for m4, a param updater, to set the maker and taker fees and the fee destination.
+	updateFees := func(makerFeeBasisPoints int64, takerFeeBasisPoints int64, feeDestinationPublicKey []byte) error {
+		balance := _getBalance(t, chain, nil, m4Pub)
+
+		txn, _, _, _, err := chain.CreateUpdateGlobalParamsTxn(
+			m4PkBytes, -1, -1, -1, -1, -1, nil, nil, nil,
+			makerFeeBasisPoints, takerFeeBasisPoints, feeDestinationPublicKey, nil, nil,
+			feeRateNanosPerKb, nil, []*DeSoOutput{})
+		require.NoError(err)
+		_signTxn(t, txn, m4Priv)
+
+		utxoView, err := NewUtxoView(db, params, chain.postgres, chain.snapshot)
+		require.NoError(err)
+		utxoOps, _, _, _, err := utxoView.ConnectTransaction(
+			txn, txn.Hash(), getTxnSize(*txn), chain.blockTip().Height+1, true /*verifySignature*/, false /*ignoreUtxos*/)
+		if err != nil {
+			return err
+		}
+		require.NoError(utxoView.FlushToDb(0))
+
+		testMeta.expectedSenderBalances = append(testMeta.expectedSenderBalances, balance)
+		testMeta.txnOps = append(testMeta.txnOps, utxoOps)
+		testMeta.txns = append(testMeta.txns, txn)
+		return nil
+	}
+	getDAOCoinBalance := func(pkid *PKIDEntry) uint64 {
+		balanceEntry := dbAdapter.GetBalanceEntry(pkid.PKID, m0PKID.PKID, true)
+		if balanceEntry == nil {
+			return 0
+		}
+		return balanceEntry.BalanceNanos.Uint64()
+	}
+
+	// m1 bids for 10000 base units of m0's DAO coin at 0.1 $DESO / DAO coin, and m0
+	// sells into the bid. m1 is the maker and m0 is the taker.
+	exchangeRate, err := CalculateScaledExchangeRate(0.1)
+	require.NoError(err)
+	bidMetadataM1 := DAOCoinLimitOrderMetadata{
+		BuyingDAOCoinCreatorPublicKey:             NewPublicKey(m0PkBytes),
+		SellingDAOCoinCreatorPublicKey:            &ZeroPublicKey,
+		ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
+		QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(10000),
+		OperationType:                             DAOCoinLimitOrderOperationTypeBID,
+		FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+	}
+	exchangeRate, err = CalculateScaledExchangeRate(10.0)
+	require.NoError(err)
+	askMetadataM0 := DAOCoinLimitOrderMetadata{
+		BuyingDAOCoinCreatorPublicKey:             &ZeroPublicKey,
+		SellingDAOCoinCreatorPublicKey:            NewPublicKey(m0PkBytes),
+		ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
+		QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(10000),
+		OperationType:                             DAOCoinLimitOrderOperationTypeASK,
+		FillType:                                  DAOCoinLimitOrderFillTypeImmediateOrCancel,
+	}
+
+	// -----------------------
+	// Tests
+	// -----------------------
+
+	// RuleErrorDAOCoinLimitOrderMakerFeeTooHigh, RuleErrorDAOCoinLimitOrderTakerFeeTooHigh,
+	// and RuleErrorDAOCoinLimitOrderFeeDestinationPubKeyLength
+	{
+		err = updateFees(MaxDAOCoinLimitOrderFeeBasisPoints+1, -1, nil)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderMakerFeeTooHigh)
+
+		err = updateFees(-1, MaxDAOCoinLimitOrderFeeBasisPoints+1, nil)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderTakerFeeTooHigh)
+
+		err = updateFees(-1, -1, m2PkBytes[:10])
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderFeeDestinationPubKeyLength)
+	}
+
+	// m4 sets a 1% maker fee and a 2% taker fee, paid to m2.
+	require.NoError(updateFees(100, 200, m2PkBytes))
+	globalParamsEntry := DbGetGlobalParamsEntry(db, chain.snapshot)
+	require.Equal(uint64(100), globalParamsEntry.DAOCoinLimitOrderMakerFeeBasisPoints)
+	require.Equal(uint64(200), globalParamsEntry.DAOCoinLimitOrderTakerFeeBasisPoints)
+	require.Equal(m2PkBytes, globalParamsEntry.DAOCoinLimitOrderFeeDestinationPublicKey)
+
+	// Scenario: m0 sells 10000 DAO coin base units for 1000 $DESO nanos into m1's bid.
+	//   * m1 pays a 1% maker fee of 100 DAO coin base units.
+	//   * m0 pays a 2% taker fee of 20 $DESO nanos.
+	{
+		_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m1Pub, m1Priv, bidMetadataM1)
+
+		originalM0DESOBalance := _getBalance(t, chain, nil, m0Pub)
+		originalM1DESOBalance := _getBalance(t, chain, nil, m1Pub)
+		originalM2DESOBalance := _getBalance(t, chain, nil, m2Pub)
+		originalM0DAOCoinBalance := getDAOCoinBalance(m0PKID)
+
+		_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, askMetadataM0)
+		feeNanos := testMeta.txns[len(testMeta.txns)-1].TxnMeta.(*DAOCoinLimitOrderMetadata).FeeNanos
+
+		orderEntries, err := dbAdapter.GetAllDAOCoinLimitOrders()
+		require.NoError(err)
+		require.Empty(orderEntries)
+
+		require.Equal(originalM0DESOBalance+1000-20-feeNanos, _getBalance(t, chain, nil, m0Pub))
+		require.Equal(originalM1DESOBalance-1000, _getBalance(t, chain, nil, m1Pub))
+		require.Equal(originalM2DESOBalance+20, _getBalance(t, chain, nil, m2Pub))
+		require.Equal(originalM0DAOCoinBalance-10000, getDAOCoinBalance(m0PKID))
+		require.Equal(uint64(9900), getDAOCoinBalance(m1PKID))
+		require.Equal(uint64(100), getDAOCoinBalance(m2PKID))
+
+		// The fees are recorded in the fill records for both orders.
+		utxoOps := testMeta.txnOps[len(testMeta.txnOps)-1]
+		filledOrders := utxoOps[len(utxoOps)-1].FilledDAOCoinLimitOrders
+		require.Len(filledOrders, 2)
+		require.True(filledOrders[0].TransactorPKID.Eq(m0PKID.PKID))
+		require.Equal(uint64(1000), filledOrders[0].CoinQuantityInBaseUnitsBought.Uint64())
+		require.Equal(uint64(20), filledOrders[0].FeeInBaseUnitsPaid.Uint64())
+		require.True(filledOrders[1].TransactorPKID.Eq(m1PKID.PKID))
+		require.Equal(uint64(10000), filledOrders[1].CoinQuantityInBaseUnitsBought.Uint64())
+		require.Equal(uint64(100), filledOrders[1].FeeInBaseUnitsPaid.Uint64())
+	}
+
+	// Scenario: m0 restricts transfers of their DAO coin to the profile owner. m1's maker
+	// fee would be a transfer of m0's DAO coin from m1 to m2, so the order is rejected.
+	{
+		_daoCoinTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, DAOCoinMetadata{
+			ProfilePublicKey:          m0PkBytes,
+			OperationType:             DAOCoinOperationTypeUpdateTransferRestrictionStatus,
+			TransferRestrictionStatus: TransferRestrictionStatusProfileOwnerOnly,
+		})
+		_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m1Pub, m1Priv, bidMetadataM1)
+
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv, askMetadataM0)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinTransferProfileOwnerOnlyViolation)
+		require.Equal(uint64(100), getDAOCoinBalance(m2PKID))
+
+		_daoCoinTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, DAOCoinMetadata{
+			ProfilePublicKey:          m0PkBytes,
+			OperationType:             DAOCoinOperationTypeUpdateTransferRestrictionStatus,
+			TransferRestrictionStatus: TransferRestrictionStatusUnrestricted,
+		})
+	}
+
+	// Scenario: m4 clears the fee destination, which turns the fees off.
+	{
+		require.NoError(updateFees(-1, -1, []byte{}))
+
+		_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m1Pub, m1Priv, bidMetadataM1)
+
+		originalM2DESOBalance := _getBalance(t, chain, nil, m2Pub)
+		_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, askMetadataM0)
+
+		require.Equal(uint64(9900+10000), getDAOCoinBalance(m1PKID))
+		require.Equal(uint64(100), getDAOCoinBalance(m2PKID))
+		require.Equal(originalM2DESOBalance, _getBalance(t, chain, nil, m2Pub))
+
+		utxoOps := testMeta.txnOps[len(testMeta.txnOps)-1]
+		for _, filledOrder := range utxoOps[len(utxoOps)-1].FilledDAOCoinLimitOrders {
+			require.Nil(filledOrder.FeeInBaseUnitsPaid)
+		}
+	}
+
+	_executeAllTestRollbackAndFlush(testMeta)
+}
+
+func TestDAOCoinLimitOrderMarketOrderSlippage(t *testing.T) {
+	// -----------------------
+	// Initialization
+	// -----------------------
+
+	// Test constants
+	const feeRateNanosPerKb = uint64(101)
+
+	// Initialize test chain and miner.
+	require := require.New(t)
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+
+	params.ForkHeights.DAOCoinBlockHeight = uint32(0)
+	params.ForkHeights.DAOCoinLimitOrderBlockHeight = uint32(0)
+	params.ForkHeights.OrderBookDBFetchOptimizationBlockHeight = uint32(0)
+	params.ForkHeights.DAOCoinLimitOrderMarketOrderSlippageBlockHeight = uint32(math.MaxUint32)
+
+	utxoView, err := NewUtxoView(db, params, chain.postgres, chain.snapshot)
+	require.NoError(err)
+	dbAdapter := utxoView.GetDbAdapter()
+
+	// Mine a few blocks to give the senderPkString some money.
+	for ii := 0; ii < 4; ii++ {
+		_, err = miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+	}
+
+	// We build the testMeta obj after mining blocks so that we save the correct block height.
+	testMeta := &TestMeta{
+		t:           t,
+		chain:       chain,
+		params:      params,
+		db:          db,
+		mempool:     mempool,
+		miner:       miner,
+		savedHeight: chain.blockTip().Height + 1,
+	}
+
+	_registerOrTransferWithTestMeta(testMeta, "m0", senderPkString, m0Pub, senderPrivString, 7000)
+	_registerOrTransferWithTestMeta(testMeta, "m1", senderPkString, m1Pub, senderPrivString, 4000)
+
+	m0PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m0PkBytes)
+	m1PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m1PkBytes)
+
+	// Create a profile for m0 and mint some of their DAO coins.
+	{
+		_updateProfileWithTestMeta(
+			testMeta,
+			feeRateNanosPerKb, /*feeRateNanosPerKB*/
+			m0Pub,             /*updaterPkBase58Check*/
+			m0Priv,            /*updaterPrivBase58Check*/
+			[]byte{},          /*profilePubKey*/
+			"m0",              /*newUsername*/
+			"i am the m0",     /*newDescription*/
+			shortPic,          /*newProfilePic*/
+			10*100,            /*newCreatorBasisPoints*/
+			1.25*100*100,      /*newStakeMultipleBasisPoints*/
+			false,             /*isHidden*/
+		)
+
+		daoCoinMintMetadata := DAOCoinMetadata{
+			ProfilePublicKey: m0PkBytes,
+			OperationType:    DAOCoinOperationTypeMint,
+			CoinsToMintNanos: *uint256.NewInt().SetUint64(1e8),
+		}
+		_daoCoinTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, daoCoinMintMetadata)
+	}
+
+	// -----------------------
+	// Tests
+	// -----------------------
+
+	// m0 asks to sell 100 of their DAO coins at 1.0, 0.95 and 0.5 DAO coins / $DESO.
+	askMetadata := func(price float64) DAOCoinLimitOrderMetadata {
+		exchangeRate, err := CalculateScaledExchangeRate(price)
+		require.NoError(err)
+		return DAOCoinLimitOrderMetadata{
+			BuyingDAOCoinCreatorPublicKey:             &ZeroPublicKey,
+			SellingDAOCoinCreatorPublicKey:            NewPublicKey(m0PkBytes),
+			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
+			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(100),
+			OperationType:                             DAOCoinLimitOrderOperationTypeASK,
+			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+		}
+	}
+	for _, price := range []float64{1.0, 0.95, 0.5} {
+		_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, askMetadata(price))
+	}
+	worstAskID := testMeta.txns[len(testMeta.txns)-1].Hash()
+
+	// m1 bids for 300 of m0's DAO coins with a market order that accepts 10% slippage.
+	marketBidMetadata := DAOCoinLimitOrderMetadata{
+		BuyingDAOCoinCreatorPublicKey:             NewPublicKey(m0PkBytes),
+		SellingDAOCoinCreatorPublicKey:            &ZeroPublicKey,
+		ScaledExchangeRateCoinsToSellPerCoinToBuy: uint256.NewInt(),
+		QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(300),
+		OperationType:                             DAOCoinLimitOrderOperationTypeBID,
+		FillType:                                  DAOCoinLimitOrderFillTypeImmediateOrCancel,
+		MarketOrderMaxSlippageBasisPoints:         1000,
+	}
+
+	// The max slippage survives encoding.
+	{
+		marketBidMetadata.FeeNanos = 1
+		metadataBytes, err := marketBidMetadata.ToBytes(false)
+		require.NoError(err)
+		decodedMetadata := &DAOCoinLimitOrderMetadata{}
+		require.NoError(decodedMetadata.FromBytes(metadataBytes))
+		require.Equal(&marketBidMetadata, decodedMetadata)
+		marketBidMetadata.FeeNanos = 0
+	}
+
+	// RuleErrorDAOCoinLimitOrderMarketOrderSlippageBeforeBlockHeight
+	{
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m1Pub, m1Priv, marketBidMetadata)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderMarketOrderSlippageBeforeBlockHeight)
+	}
+
+	params.ForkHeights.DAOCoinLimitOrderMarketOrderSlippageBlockHeight = uint32(0)
+
+	// RuleErrorDAOCoinLimitOrderSlippageOnNonMarketOrder and RuleErrorDAOCoinLimitOrderInvalidSlippage
+	{
+		metadata := askMetadata(1.0)
+		metadata.MarketOrderMaxSlippageBasisPoints = 1000
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv, metadata)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderSlippageOnNonMarketOrder)
+
+		metadata = marketBidMetadata
+		metadata.MarketOrderMaxSlippageBasisPoints = 10001
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m1Pub, m1Priv, metadata)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderInvalidSlippage)
+	}
+
+	// Scenario: m1's market order fills the asks at 1.0 and 0.95, and stops before the ask at 0.5,
+	// which is more than 10% worse than the first ask it filled.
+	{
+		_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m1Pub, m1Priv, marketBidMetadata)
+
+		m1BalanceEntry := dbAdapter.GetBalanceEntry(m1PKID.PKID, m0PKID.PKID, true)
+		require.Equal(*uint256.NewInt().SetUint64(200), m1BalanceEntry.BalanceNanos)
+		orderEntries, err := dbAdapter.GetAllDAOCoinLimitOrdersForThisTransactor(m0PKID.PKID)
+		require.NoError(err)
+		require.Len(orderEntries, 1)
+		require.Equal(worstAskID, orderEntries[0].OrderID)
+	}
+
+	_executeAllTestRollbackAndFlush(testMeta)
+}
+
+func TestDAOCoinLimitOrderTrigger(t *testing.T) {
+	// -----------------------
+	// Initialization
+	// -----------------------
+
+	// Test constants
+	const feeRateNanosPerKb = uint64(101)
+
+	// Initialize test chain and miner.
+	require := require.New(t)
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+
+	params.ForkHeights.DAOCoinBlockHeight = uint32(0)
+	params.ForkHeights.DAOCoinLimitOrderBlockHeight = uint32(0)
+	params.ForkHeights.OrderBookDBFetchOptimizationBlockHeight = uint32(0)
+	params.ForkHeights.DAOCoinLimitOrderTriggerBlockHeight = uint32(math.MaxUint32)
+
+	utxoView, err := NewUtxoView(db, params, chain.postgres, chain.snapshot)
+	require.NoError(err)
+	dbAdapter := utxoView.GetDbAdapter()
+
+	// Mine a few blocks to give the senderPkString some money.
+	for ii := 0; ii < 4; ii++ {
+		_, err = miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+	}
+
+	// We build the testMeta obj after mining blocks so that we save the correct block height.
+	testMeta := &TestMeta{
+		t:           t,
+		chain:       chain,
+		params:      params,
+		db:          db,
+		mempool:     mempool,
+		miner:       miner,
+		savedHeight: chain.blockTip().Height + 1,
+	}
+
+	_registerOrTransferWithTestMeta(testMeta, "m0", senderPkString, m0Pub, senderPrivString, 7000)
+	_registerOrTransferWithTestMeta(testMeta, "m1", senderPkString, m1Pub, senderPrivString, 4000)
+
+	m0PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m0PkBytes)
+	m1PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m1PkBytes)
+
+	// Create a profile for m0 and mint some of their DAO coins.
+	{
+		_updateProfileWithTestMeta(
+			testMeta,
+			feeRateNanosPerKb, /*feeRateNanosPerKB*/
+			m0Pub,             /*updaterPkBase58Check*/
+			m0Priv,            /*updaterPrivBase58Check*/
+			[]byte{},          /*profilePubKey*/
+			"m0",              /*newUsername*/
+			"i am the m0",     /*newDescription*/
+			shortPic,          /*newProfilePic*/
+			10*100,            /*newCreatorBasisPoints*/
+			1.25*100*100,      /*newStakeMultipleBasisPoints*/
+			false,             /*isHidden*/
+		)
+
+		daoCoinMintMetadata := DAOCoinMetadata{
+			ProfilePublicKey: m0PkBytes,
+			OperationType:    DAOCoinOperationTypeMint,
+			CoinsToMintNanos: *uint256.NewInt().SetUint64(1e8),
+		}
+		_daoCoinTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, daoCoinMintMetadata)
+	}
+
+	scaledExchangeRate := func(price float64) *uint256.Int {
+		exchangeRate, err := CalculateScaledExchangeRate(price)
+		require.NoError(err)
+		return exchangeRate
+	}
+	// Asks sell 100 of m0's DAO coins for $DESO, at DAO coins / $DESO.
+	askMetadata := func(price float64) DAOCoinLimitOrderMetadata {
+		return DAOCoinLimitOrderMetadata{
+			BuyingDAOCoinCreatorPublicKey:             &ZeroPublicKey,
+			SellingDAOCoinCreatorPublicKey:            NewPublicKey(m0PkBytes),
+			ScaledExchangeRateCoinsToSellPerCoinToBuy: scaledExchangeRate(price),
+			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(100),
+			OperationType:                             DAOCoinLimitOrderOperationTypeASK,
+			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+		}
+	}
+	// Bids buy 100 of m0's DAO coins with $DESO, at $DESO / DAO coin.
+	bidMetadata := func(price float64) DAOCoinLimitOrderMetadata {
+		return DAOCoinLimitOrderMetadata{
+			BuyingDAOCoinCreatorPublicKey:             NewPublicKey(m0PkBytes),
+			SellingDAOCoinCreatorPublicKey:            &ZeroPublicKey,
+			ScaledExchangeRateCoinsToSellPerCoinToBuy: scaledExchangeRate(price),
+			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(100),
+			OperationType:                             DAOCoinLimitOrderOperationTypeBID,
+			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+		}
+	}
+	getTriggerEntry := func(orderID *BlockHash) *DAOCoinLimitOrderTriggerEntry {
+		triggerEntry, err := DBGetDAOCoinLimitOrderTrigger(db, chain.snapshot, orderID)
+		require.NoError(err)
+		return triggerEntry
+	}
+	getOrderIDs := func(transactorPKID *PKID) []BlockHash {
+		orderEntries, err := dbAdapter.GetAllDAOCoinLimitOrdersForThisTransactor(transactorPKID)
+		require.NoError(err)
+		var orderIDs []BlockHash
+		for _, orderEntry := range orderEntries {
+			orderIDs = append(orderIDs, *orderEntry.OrderID)
+		}
+		return orderIDs
+	}
+
+	// -----------------------
+	// Tests
+	// -----------------------
+
+	// m0 places a stop-loss that sells their DAO coins once they trade at 2 DAO coins / $DESO or more.
+	stopLossMetadata := askMetadata(2.0)
+	stopLossMetadata.TriggerScaledExchangeRate = scaledExchangeRate(2.0)
+
+	// The trigger survives encoding.
+	{
+		stopLossMetadata.FeeNanos = 1
+		metadataBytes, err := stopLossMetadata.ToBytes(false)
+		require.NoError(err)
+		decodedMetadata := &DAOCoinLimitOrderMetadata{}
+		require.NoError(decodedMetadata.FromBytes(metadataBytes))
+		require.Equal(&stopLossMetadata, decodedMetadata)
+		stopLossMetadata.FeeNanos = 0
+	}
+
+	// RuleErrorDAOCoinLimitOrderTriggerBeforeBlockHeight
+	{
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv, stopLossMetadata)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderTriggerBeforeBlockHeight)
+	}
+
+	params.ForkHeights.DAOCoinLimitOrderTriggerBlockHeight = uint32(0)
+
+	// RuleErrorDAOCoinLimitOrderInvalidTriggerExchangeRate and
+	// RuleErrorDAOCoinLimitOrderTriggerOnNonGoodTillCancelledOrder
+	{
+		metadata := stopLossMetadata
+		metadata.TriggerScaledExchangeRate = uint256.NewInt()
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv, metadata)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderInvalidTriggerExchangeRate)
+
+		metadata = stopLossMetadata
+		metadata.FillType = DAOCoinLimitOrderFillTypeImmediateOrCancel
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv, metadata)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderTriggerOnNonGoodTillCancelledOrder)
+	}
+
+	// Trigger orders are dormant until they're triggered, and can be cancelled.
+	_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, stopLossMetadata)
+	stopLossID := testMeta.txns[len(testMeta.txns)-1].Hash()
+	{
+		require.Empty(getOrderIDs(m0PKID.PKID))
+		require.NotNil(getTriggerEntry(stopLossID))
+
+		metadata := askMetadata(3.0)
+		metadata.TriggerScaledExchangeRate = scaledExchangeRate(3.0)
+		_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, metadata)
+		cancelledTriggerID := testMeta.txns[len(testMeta.txns)-1].Hash()
+		require.NotNil(getTriggerEntry(cancelledTriggerID))
+
+		_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv,
+			DAOCoinLimitOrderMetadata{CancelOrderID: cancelledTriggerID})
+		require.Nil(getTriggerEntry(cancelledTriggerID))
+	}
+
+	// m1 places stop-buys that buy m0's DAO coins once they trade at 0.5 and 0.6 $DESO / DAO coin or more.
+	triggeredStopBuyMetadata := bidMetadata(0.4)
+	triggeredStopBuyMetadata.TriggerScaledExchangeRate = scaledExchangeRate(0.5)
+	_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m1Pub, m1Priv, triggeredStopBuyMetadata)
+	triggeredStopBuyID := testMeta.txns[len(testMeta.txns)-1].Hash()
+	dormantStopBuyMetadata := bidMetadata(0.4)
+	dormantStopBuyMetadata.TriggerScaledExchangeRate = scaledExchangeRate(0.6)
+	_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m1Pub, m1Priv, dormantStopBuyMetadata)
+	dormantStopBuyID := testMeta.txns[len(testMeta.txns)-1].Hash()
+
+	// Scenario: m1 fills m0's ask at 2 DAO coins / $DESO, i.e. 0.5 $DESO / DAO coin. This triggers m0's
+	// stop-loss on the ask's side of the book and m1's stop-buy at 0.5 on the bid's side, which are placed
+	// on the book. m1's stop-buy at 0.6 stays dormant.
+	{
+		_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, askMetadata(2.0))
+		askID := testMeta.txns[len(testMeta.txns)-1].Hash()
+		require.Equal([]BlockHash{*askID}, getOrderIDs(m0PKID.PKID))
+
+		metadata := bidMetadata(0.5)
+		metadata.FillType = DAOCoinLimitOrderFillTypeImmediateOrCancel
+		_doDAOCoinLimitOrderTxnWithTestMeta(testMeta, feeRateNanosPerKb, m1Pub, m1Priv, metadata)
+		m1BalanceEntry := dbAdapter.GetBalanceEntry(m1PKID.PKID, m0PKID.PKID, true)
+		require.Equal(*uint256.NewInt().SetUint64(100), m1BalanceEntry.BalanceNanos)
+
+		require.Equal([]BlockHash{*stopLossID}, getOrderIDs(m0PKID.PKID))
+		require.Equal([]BlockHash{*triggeredStopBuyID}, getOrderIDs(m1PKID.PKID))
+		require.Nil(getTriggerEntry(stopLossID))
+		require.Nil(getTriggerEntry(triggeredStopBuyID))
+		require.NotNil(getTriggerEntry(dormantStopBuyID))
+
+		// The triggered orders are placed on the book at the height they're triggered at.
+		stopLossOrder, err := dbAdapter.GetDAOCoinLimitOrder(stopLossID)
+		require.NoError(err)
+		require.Equal(chain.blockTip().Height+1, stopLossOrder.BlockHeight)
+	}
+
+	_executeAllTestRollbackAndFlush(testMeta)
+}
+
+func TestDAOCoinLimitOrderExpiration(t *testing.T) {
+	// -----------------------
+	// Initialization
+	// -----------------------
+
+	// Test constants
+	const feeRateNanosPerKb = uint64(101)
+
+	// Initialize test chain and miner.
+	require := require.New(t)
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+
+	params.ForkHeights.DAOCoinBlockHeight = uint32(0)
+	params.ForkHeights.DAOCoinLimitOrderBlockHeight = uint32(0)
+	params.ForkHeights.OrderBookDBFetchOptimizationBlockHeight = uint32(0)
+	params.ForkHeights.DAOCoinLimitOrderExpirationBlockHeight = uint32(math.MaxUint32)
+
+	// Mine a few blocks to give the senderPkString some money.
+	for ii := 0; ii < 4; ii++ {
+		_, err := miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+	}
+
+	// We build the testMeta obj after mining blocks so that we save the correct block height.
+	testMeta := &TestMeta{
+		t:           t,
+		chain:       chain,
+		params:      params,
+		db:          db,
+		mempool:     mempool,
+		miner:       miner,
+		savedHeight: chain.blockTip().Height + 1,
+	}
+
+	_registerOrTransferWithTestMeta(testMeta, "m0", senderPkString, m0Pub, senderPrivString, 7000)
+	_registerOrTransferWithTestMeta(testMeta, "m1", senderPkString, m1Pub, senderPrivString, 4000)
+
+	m0PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m0PkBytes)
+	m1PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m1PkBytes)
+
+	// Create a profile for m0 and mint some of their DAO coins.
+	{
+		_updateProfileWithTestMeta(
+			testMeta,
+			feeRateNanosPerKb, /*feeRateNanosPerKB*/
+			m0Pub,             /*updaterPkBase58Check*/
+			m0Priv,            /*updaterPrivBase58Check*/
+			[]byte{},          /*profilePubKey*/
+			"m0",              /*newUsername*/
+			"i am the m0",     /*newDescription*/
+			shortPic,          /*newProfilePic*/
+			10*100,            /*newCreatorBasisPoints*/
+			1.25*100*100,      /*newStakeMultipleBasisPoints*/
+			false,             /*isHidden*/
+		)
+
+		daoCoinMintMetadata := DAOCoinMetadata{
+			ProfilePublicKey: m0PkBytes,
+			OperationType:    DAOCoinOperationTypeMint,
+			CoinsToMintNanos: *uint256.NewInt().SetUint64(1e8),
+		}
+		_daoCoinTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv, daoCoinMintMetadata)
+	}
+
+	// m0's ask sells 100 of their DAO coins for $DESO at 2 DAO coins / $DESO.
+	exchangeRate, err := CalculateScaledExchangeRate(2.0)
+	require.NoError(err)
+	askMetadata := DAOCoinLimitOrderMetadata{
+		BuyingDAOCoinCreatorPublicKey:             &ZeroPublicKey,
+		SellingDAOCoinCreatorPublicKey:            NewPublicKey(m0PkBytes),
+		ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
+		QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(100),
+		OperationType:                             DAOCoinLimitOrderOperationTypeASK,
+		FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+		ExpirationBlockHeight:                     chain.blockTip().Height + 1,
+	}
+	getDbOrders := func() []*DAOCoinLimitOrderEntry {
+		orderEntries, err := DBGetAllDAOCoinLimitOrders(db)
+		require.NoError(err)
+		return orderEntries
+	}
+	getDbOrdersExpiringBefore := func(blockHeight uint32) []*DAOCoinLimitOrderEntry {
+		var orderEntries []*DAOCoinLimitOrderEntry
+		require.NoError(db.View(func(txn *badger.Txn) error {
+			var err error
+			orderEntries, err = DBGetDAOCoinLimitOrdersExpiringBeforeBlockHeightWithTxn(txn, blockHeight)
+			return err
+		}))
+		return orderEntries
+	}
+
+	// -----------------------
+	// Tests
+	// -----------------------
+
+	// The expiration survives encoding.
+	{
+		askMetadata.FeeNanos = 1
+		metadataBytes, err := askMetadata.ToBytes(false)
+		require.NoError(err)
+		decodedMetadata := &DAOCoinLimitOrderMetadata{}
+		require.NoError(decodedMetadata.FromBytes(metadataBytes))
+		require.Equal(&askMetadata, decodedMetadata)
+		askMetadata.FeeNanos = 0
+	}
+
+	// RuleErrorDAOCoinLimitOrderExpirationBeforeBlockHeight
+	{
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv, askMetadata)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderExpirationBeforeBlockHeight)
+	}
+
+	params.ForkHeights.DAOCoinLimitOrderExpirationBlockHeight = uint32(0)
+
+	// RuleErrorDAOCoinLimitOrderExpirationBlockHeightPassed and
+	// RuleErrorDAOCoinLimitOrderInvalidExpiration
+	{
+		metadata := askMetadata
+		metadata.ExpirationBlockHeight = chain.blockTip().Height
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv, metadata)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderExpirationBlockHeightPassed)
+
+		metadata = askMetadata
+		metadata.FillType = DAOCoinLimitOrderFillTypeImmediateOrCancel
+		_, _, _, err = _doDAOCoinLimitOrderTxn(
+			t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv, metadata)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderInvalidExpiration)
+	}
+
+	// m0's ask is mined into the block at its expiration height, so it rests on the
+	// book and is in the expiration index.
+	{
+		txn, _, _, _, err := chain.CreateDAOCoinLimitOrderTxn(
+			m0PkBytes, &askMetadata, feeRateNanosPerKb, mempool, []*DeSoOutput{})
+		require.NoError(err)
+		_signTxn(t, txn, m0Priv)
+		_, err = mempool.ProcessTransaction(txn, false, false, 0, true)
+		require.NoError(err)
+		_, err = miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+		require.Equal(askMetadata.ExpirationBlockHeight, chain.blockTip().Height)
+
+		require.Len(getDbOrders(), 1)
+		require.Equal(askMetadata.ExpirationBlockHeight, getDbOrders()[0].ExpirationBlockHeight)
+		require.Empty(getDbOrdersExpiringBefore(askMetadata.ExpirationBlockHeight))
+		require.Len(getDbOrdersExpiringBefore(askMetadata.ExpirationBlockHeight+1), 1)
+	}
+
+	// Past its expiration height, m0's ask can't be matched or cancelled.
+	{
+		utxoView, err := NewUtxoView(db, params, chain.postgres, chain.snapshot)
+		require.NoError(err)
+		bidExchangeRate, err := CalculateScaledExchangeRate(0.5)
+		require.NoError(err)
+		bidOrder := &DAOCoinLimitOrderEntry{
+			TransactorPKID:                            m1PKID.PKID,
+			BuyingDAOCoinCreatorPKID:                  m0PKID.PKID,
+			SellingDAOCoinCreatorPKID:                 &ZeroPKID,
+			ScaledExchangeRateCoinsToSellPerCoinToBuy: bidExchangeRate,
+			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(100),
+			OperationType:                             DAOCoinLimitOrderOperationTypeBID,
+			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+		}
+		matchingOrders, err := utxoView.GetNextLimitOrdersToFill(bidOrder, nil, askMetadata.ExpirationBlockHeight)
+		require.NoError(err)
+		require.Len(matchingOrders, 1)
+		matchingOrders, err = utxoView.GetNextLimitOrdersToFill(bidOrder, nil, askMetadata.ExpirationBlockHeight+1)
+		require.NoError(err)
+		require.Empty(matchingOrders)
+
+		_, _, _, err = _doDAOCoinLimitOrderTxn(t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv,
+			DAOCoinLimitOrderMetadata{CancelOrderID: getDbOrders()[0].OrderID})
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDAOCoinLimitOrderToCancelNotFound)
+	}
+
+	// The next block prunes m0's ask, and disconnecting it restores the ask.
+	{
+		block, err := miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+		require.Empty(getDbOrders())
+		require.Empty(getDbOrdersExpiringBefore(math.MaxUint32))
+
+		hash, err := block.Header.Hash()
+		require.NoError(err)
+		utxoOps, err := GetUtxoOperationsForBlock(db, chain.snapshot, hash)
+		require.NoError(err)
+		expirationOp := utxoOps[0][len(utxoOps[0])-1]
+		require.Equal(OperationTypeDAOCoinLimitOrderExpiration, expirationOp.Type)
+		require.Len(expirationOp.PrevMatchingOrders, 1)
+
+		utxoView, err := NewUtxoView(db, params, chain.postgres, chain.snapshot)
+		require.NoError(err)
+		txHashes, err := ComputeTransactionHashes(block.Txns)
+		require.NoError(err)
+		blockHeight := uint64(chain.BlockTip().Height)
+		require.NoError(utxoView.DisconnectBlock(block, txHashes, utxoOps, blockHeight))
+		require.NoError(utxoView.FlushToDb(blockHeight))
+		require.Len(getDbOrders(), 1)
+		require.Len(getDbOrdersExpiringBefore(math.MaxUint32), 1)
+	}
+}
+
+func TestCalculateDAOCoinsTransferredInLimitOrderMatch(t *testing.T) {
+	require := require.New(t)
+	m0PKID := NewPKID(m0PkBytes)
+	m1PKID := NewPKID(m1PkBytes)
+
+	// Scenario 1: one ASK, one BID, exactly matching orders
+	{
+		// m0 sells 1000 DAO coin base units @ 0.1 $DESO / DAO coin.
+		exchangeRate, err := CalculateScaledExchangeRate(10.0)
+		require.NoError(err)
+		m0Order := &DAOCoinLimitOrderEntry{
+			OrderID:                   NewBlockHash(uint256.NewInt().SetUint64(1).Bytes()), // Not used
+			TransactorPKID:            m0PKID,
+			BuyingDAOCoinCreatorPKID:  &ZeroPKID,
+			SellingDAOCoinCreatorPKID: m0PKID,
+			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
+			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(1000),
+			OperationType:                             DAOCoinLimitOrderOperationTypeASK,
+			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+		}
+
+		// m1 buys 1000 DAO coin base units @ 0.1 $DESO / DAO coin.
+		exchangeRate, err = CalculateScaledExchangeRate(0.1)
+		require.NoError(err)
+		m1Order := &DAOCoinLimitOrderEntry{
+			OrderID:                   NewBlockHash(uint256.NewInt().SetUint64(1).Bytes()), // Not used
+			TransactorPKID:            m1PKID,
+			BuyingDAOCoinCreatorPKID:  m0PKID,
+			SellingDAOCoinCreatorPKID: &ZeroPKID,
+			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
+			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(1000),
+			OperationType:                             DAOCoinLimitOrderOperationTypeBID,
+			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+		}
+
+		// m0 = transactor, m1 = matching order
+		updatedTransactorQuantityToFillInBaseUnits,
+			updatedMatchingQuantityToFillInBaseUnits,
+			transactorBuyingCoinBaseUnitsTransferred,
+			transactorSellingCoinBaseUnitsTransferred,
+			err := _calculateDAOCoinsTransferredInLimitOrderMatch(m1Order, m0Order.OperationType, m0Order.QuantityToFillInBaseUnits)
+		require.NoError(err)
+		require.Equal(updatedTransactorQuantityToFillInBaseUnits, uint256.NewInt())
+		require.Equal(updatedMatchingQuantityToFillInBaseUnits, uint256.NewInt())
+		require.Equal(transactorBuyingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(100))
+		require.Equal(transactorSellingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(1000))
+
+		// m1 = transactor, m0 = matching order
+		updatedTransactorQuantityToFillInBaseUnits,
+			updatedMatchingQuantityToFillInBaseUnits,
+			transactorBuyingCoinBaseUnitsTransferred,
+			transactorSellingCoinBaseUnitsTransferred,
+			err = _calculateDAOCoinsTransferredInLimitOrderMatch(m0Order, m1Order.OperationType, m1Order.QuantityToFillInBaseUnits)
+		require.NoError(err)
+		require.Equal(updatedTransactorQuantityToFillInBaseUnits, uint256.NewInt())
+		require.Equal(updatedMatchingQuantityToFillInBaseUnits, uint256.NewInt())
+		require.Equal(transactorBuyingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(1000))
+		require.Equal(transactorSellingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(100))
+	}
+
+	// Scenario 2: one BID, one ASK, matching orders w/ mismatched prices
+	{
+		// m0 buys 1000 DAO coin base units @ 10 $DESO / DAO coin.
+		exchangeRate, err := CalculateScaledExchangeRate(10.0)
+		require.NoError(err)
+		m0Order := &DAOCoinLimitOrderEntry{
+			OrderID:                   NewBlockHash(uint256.NewInt().SetUint64(1).Bytes()), // Not used
+			TransactorPKID:            m0PKID,
+			BuyingDAOCoinCreatorPKID:  m0PKID,
+			SellingDAOCoinCreatorPKID: &ZeroPKID,
+			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
+			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(1000),
+			OperationType:                             DAOCoinLimitOrderOperationTypeBID,
+			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+		}
+
+		// m1 sells 500 DAO coin base units @ 5 $DESO / DAO coin.
+		exchangeRate, err = CalculateScaledExchangeRate(0.2)
+		require.NoError(err)
+		m1Order := &DAOCoinLimitOrderEntry{
+			OrderID:                   NewBlockHash(uint256.NewInt().SetUint64(1).Bytes()), // Not used
+			TransactorPKID:            m1PKID,
+			BuyingDAOCoinCreatorPKID:  &ZeroPKID,
+			SellingDAOCoinCreatorPKID: m0PKID,
+			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
+			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(500),
+			OperationType:                             DAOCoinLimitOrderOperationTypeASK,
+			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+		}
+
+		// m0 = transactor, m1 = matching order
+		// m0 buys 500 DAO coin base units @ 5 $DESO / DAO coin.
+		updatedTransactorQuantityToFillInBaseUnits,
+			updatedMatchingQuantityToFillInBaseUnits,
+			transactorBuyingCoinBaseUnitsTransferred,
+			transactorSellingCoinBaseUnitsTransferred,
+			err := _calculateDAOCoinsTransferredInLimitOrderMatch(m1Order, m0Order.OperationType, m0Order.QuantityToFillInBaseUnits)
+		require.NoError(err)
+		require.Equal(updatedTransactorQuantityToFillInBaseUnits, uint256.NewInt().SetUint64(500))
+		require.Equal(updatedMatchingQuantityToFillInBaseUnits, uint256.NewInt())
+		require.Equal(transactorBuyingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(500))
+		require.Equal(transactorSellingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(2500))
+
+		// m1 = transactor, m0 = matching order
+		// m1 sells 500 DAO coin base units @ 10 $DESO / DAO coin.
+		updatedTransactorQuantityToFillInBaseUnits,
+			updatedMatchingQuantityToFillInBaseUnits,
+			transactorBuyingCoinBaseUnitsTransferred,
+			transactorSellingCoinBaseUnitsTransferred,
+			err = _calculateDAOCoinsTransferredInLimitOrderMatch(m0Order, m1Order.OperationType, m1Order.QuantityToFillInBaseUnits)
+		require.NoError(err)
+		require.Equal(updatedTransactorQuantityToFillInBaseUnits, uint256.NewInt())
+		require.Equal(updatedMatchingQuantityToFillInBaseUnits, uint256.NewInt().SetUint64(500))
+		require.Equal(transactorBuyingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(5000))
+		require.Equal(transactorSellingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(500))
+	}
+
+	// Scenario 3: m0 and m1 both submit BIDs that should match
+	{
+		// m0 buys 100 DAO coin base units @ 10 $DESO / DAO coin.
+		exchangeRate, err := CalculateScaledExchangeRate(10.0)
+		require.NoError(err)
+		m0Order := &DAOCoinLimitOrderEntry{
+			OrderID:                   NewBlockHash(uint256.NewInt().SetUint64(1).Bytes()), // Not used
+			TransactorPKID:            m0PKID,
+			BuyingDAOCoinCreatorPKID:  m0PKID,
+			SellingDAOCoinCreatorPKID: &ZeroPKID,
+			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
+			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(100),
+			OperationType:                             DAOCoinLimitOrderOperationTypeBID,
+			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+		}
+
+		// m1 buys 1000 $DESO @ 0.1 DAO coin / $DESO.
+		exchangeRate, err = CalculateScaledExchangeRate(0.1)
+		require.NoError(err)
+		m1Order := &DAOCoinLimitOrderEntry{
+			OrderID:                   NewBlockHash(uint256.NewInt().SetUint64(1).Bytes()), // Not used
+			TransactorPKID:            m1PKID,
+			BuyingDAOCoinCreatorPKID:  &ZeroPKID,
+			SellingDAOCoinCreatorPKID: m0PKID,
+			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
+			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(1000),
+			OperationType:                             DAOCoinLimitOrderOperationTypeBID,
+			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+		}
+
+		// m0 = transactor, m1 = matching order
+		// m0 buys 100 DAO coin base units @ 10 $DESO / DAO coin.
+		updatedTransactorQuantityToFillInBaseUnits,
+			updatedMatchingQuantityToFillInBaseUnits,
+			transactorBuyingCoinBaseUnitsTransferred,
+			transactorSellingCoinBaseUnitsTransferred,
+			err := _calculateDAOCoinsTransferredInLimitOrderMatch(m1Order, m0Order.OperationType, m0Order.QuantityToFillInBaseUnits)
+		require.NoError(err)
+		require.Equal(updatedTransactorQuantityToFillInBaseUnits, uint256.NewInt())
+		require.Equal(updatedMatchingQuantityToFillInBaseUnits, uint256.NewInt())
+		require.Equal(transactorBuyingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(100))
+		require.Equal(transactorSellingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(1000))
+
+		// m1 = transactor, m0 = matching order
+		// m1 buys 1000 $DESO @ 0.1 DAO coin / $DESO.
+		updatedTransactorQuantityToFillInBaseUnits,
+			updatedMatchingQuantityToFillInBaseUnits,
+			transactorBuyingCoinBaseUnitsTransferred,
+			transactorSellingCoinBaseUnitsTransferred,
+			err = _calculateDAOCoinsTransferredInLimitOrderMatch(m0Order, m1Order.OperationType, m1Order.QuantityToFillInBaseUnits)
+		require.NoError(err)
+		require.Equal(updatedTransactorQuantityToFillInBaseUnits, uint256.NewInt())
+		require.Equal(updatedMatchingQuantityToFillInBaseUnits, uint256.NewInt())
+		require.Equal(transactorBuyingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(1000))
+		require.Equal(transactorSellingCoinBaseUnitsTransferred, uint256.NewInt().SetUint64(100))
+	}
+
+	// Scenario 4: m0 and m1 both submit BIDs that match, m1 gets a better price than expected
+	{
+		// m0 buys 100 DAO coin base units @ 10 $DESO / DAO coin.
+		exchangeRate, err := CalculateScaledExchangeRate(10.0)
+		require.NoError(err)
+		m0Order := &DAOCoinLimitOrderEntry{
+			OrderID:                   NewBlockHash(uint256.NewInt().SetUint64(1).Bytes()), // Not used
+			TransactorPKID:            m0PKID,
+			BuyingDAOCoinCreatorPKID:  m0PKID,
+			SellingDAOCoinCreatorPKID: &ZeroPKID,
+			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
+			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(100),
+			OperationType:                             DAOCoinLimitOrderOperationTypeBID,
+			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
 		}
 
 		// m1 buys 250 $DESO @ 0.2 DAO coin / $DESO.
@@ -4031,6 +5228,71 @@ func TestCalculateScaledExchangeRate(t *testing.T) {
 	}
 }
 
+func TestRoundScaledExchangeRateToTick(t *testing.T) {
+	require := require.New(t)
+
+	tick := uint256.NewInt().SetUint64(100)
+	{
+		// A nil or zero tick leaves the exchange rate unchanged.
+		rounded, err := RoundScaledExchangeRateToTick(uint256.NewInt().SetUint64(1234), nil, true)
+		require.NoError(err)
+		require.Equal(uint256.NewInt().SetUint64(1234), rounded)
+		rounded, err = RoundScaledExchangeRateToTick(uint256.NewInt().SetUint64(1234), uint256.NewInt(), false)
+		require.NoError(err)
+		require.Equal(uint256.NewInt().SetUint64(1234), rounded)
+	}
+	{
+		rounded, err := RoundScaledExchangeRateToTick(uint256.NewInt().SetUint64(1234), tick, false)
+		require.NoError(err)
+		require.Equal(uint256.NewInt().SetUint64(1200), rounded)
+		rounded, err = RoundScaledExchangeRateToTick(uint256.NewInt().SetUint64(1234), tick, true)
+		require.NoError(err)
+		require.Equal(uint256.NewInt().SetUint64(1300), rounded)
+	}
+	{
+		// Multiples of the tick are left unchanged in both directions.
+		rounded, err := RoundScaledExchangeRateToTick(uint256.NewInt().SetUint64(1200), tick, false)
+		require.NoError(err)
+		require.Equal(uint256.NewInt().SetUint64(1200), rounded)
+		rounded, err = RoundScaledExchangeRateToTick(uint256.NewInt().SetUint64(1200), tick, true)
+		require.NoError(err)
+		require.Equal(uint256.NewInt().SetUint64(1200), rounded)
+	}
+	{
+		// Rounding up past MaxUint256 fails.
+		_, err := RoundScaledExchangeRateToTick(MaxUint256, tick, true)
+		require.Error(err)
+		rounded, err := RoundScaledExchangeRateToTick(MaxUint256, tick, false)
+		require.NoError(err)
+		require.True(rounded.Lt(MaxUint256))
+	}
+	{
+		_, err := RoundScaledExchangeRateToTick(nil, tick, false)
+		require.Error(err)
+	}
+}
+
+func TestComputeDAOCoinLimitOrderFeeBaseUnits(t *testing.T) {
+	require := require.New(t)
+
+	// Fees are rounded down.
+	fee, err := ComputeDAOCoinLimitOrderFeeBaseUnits(uint256.NewInt().SetUint64(1999), 50)
+	require.NoError(err)
+	require.Equal(uint256.NewInt().SetUint64(9), fee)
+
+	fee, err = ComputeDAOCoinLimitOrderFeeBaseUnits(uint256.NewInt().SetUint64(1999), 0)
+	require.NoError(err)
+	require.True(fee.IsZero())
+
+	// The fee is computed without overflowing even when the quantity is MaxUint256.
+	fee, err = ComputeDAOCoinLimitOrderFeeBaseUnits(MaxUint256, 100*100)
+	require.NoError(err)
+	require.Equal(MaxUint256, fee)
+
+	_, err = ComputeDAOCoinLimitOrderFeeBaseUnits(uint256.NewInt().SetUint64(1999), 100*100+1)
+	require.Error(err)
+}
+
 //
 // ----- HELPERS
 //
@@ -4194,6 +5456,124 @@ func (txnData *DAOCoinLimitOrderMetadata) ToEntry(
 	}
 }
 
+func TestGetMergedOrderBook(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	blockHeight := uint64(chain.blockTip().Height) + 1
+
+	m0PKID := NewPKID(m0PkBytes)
+	m1PKID := NewPKID(m1PkBytes)
+	pair := &DAOCoinLimitOrderPair{
+		BuyingDAOCoinCreatorPKID:  m0PKID,
+		SellingDAOCoinCreatorPKID: &ZeroPKID,
+	}
+
+	newOrder := func(orderID byte, buyingPKID *PKID, sellingPKID *PKID, price float64, height uint32) *DAOCoinLimitOrderEntry {
+		exchangeRate, err := CalculateScaledExchangeRate(price)
+		require.NoError(err)
+		return &DAOCoinLimitOrderEntry{
+			OrderID:                   NewBlockHash([]byte{orderID}),
+			TransactorPKID:            m1PKID,
+			BuyingDAOCoinCreatorPKID:  buyingPKID,
+			SellingDAOCoinCreatorPKID: sellingPKID,
+			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
+			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(100),
+			OperationType:                             DAOCoinLimitOrderOperationTypeBID,
+			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+			BlockHeight:                               height,
+		}
+	}
+
+	// Orders in the db.
+	order1 := newOrder(1, m0PKID, &ZeroPKID, 3.0, 1)
+	order2 := newOrder(2, m0PKID, &ZeroPKID, 2.0, 1)
+	order3 := newOrder(3, m0PKID, &ZeroPKID, 2.0, 2)
+	order4 := newOrder(4, m0PKID, &ZeroPKID, 1.0, 1)
+	otherPairDbOrder := newOrder(5, &ZeroPKID, m0PKID, 2.0, 1)
+	for _, order := range []*DAOCoinLimitOrderEntry{order1, order2, order3, order4, otherPairDbOrder} {
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			return DBPutDAOCoinLimitOrderWithTxn(txn, nil, order, blockHeight)
+		}))
+	}
+
+	// Pending changes in the view: order2 is deleted, order3 is
+	// partially filled, and order6 is new.
+	utxoView, err := NewUtxoView(db, params, chain.postgres, chain.snapshot)
+	require.NoError(err)
+	utxoView._deleteDAOCoinLimitOrderEntryMappings(order2.Copy())
+	order3InView := order3.Copy()
+	order3InView.QuantityToFillInBaseUnits = uint256.NewInt().SetUint64(40)
+	utxoView._setDAOCoinLimitOrderEntryMappings(order3InView)
+	order6 := newOrder(6, m0PKID, &ZeroPKID, 2.5, 3)
+	utxoView._setDAOCoinLimitOrderEntryMappings(order6)
+	utxoView._setDAOCoinLimitOrderEntryMappings(newOrder(7, &ZeroPKID, m0PKID, 2.0, 3))
+	numViewOrders := len(utxoView.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry)
+
+	requireOrderIDs := func(orders []*DAOCoinLimitOrderEntry, expectedOrders ...*DAOCoinLimitOrderEntry) {
+		require.Equal(len(expectedOrders), len(orders))
+		for ii, order := range orders {
+			require.Equal(expectedOrders[ii].OrderID, order.OrderID)
+		}
+	}
+
+	// The full order book is sorted best first, drops the deleted
+	// order, and uses the view's version of the updated order.
+	orders, err := GetMergedOrderBook(utxoView, pair, nil)
+	require.NoError(err)
+	requireOrderIDs(orders, order1, order6, order3, order4)
+	require.Equal(uint256.NewInt().SetUint64(40), orders[2].QuantityToFillInBaseUnits)
+
+	// The API getter returns the same orders.
+	orders, err = utxoView.GetAllDAOCoinLimitOrdersForThisDAOCoinPair(m0PKID, &ZeroPKID)
+	require.NoError(err)
+	requireOrderIDs(orders, order1, order6, order3, order4)
+
+	// Page through the order book two orders at a time.
+	pagination := &DAOCoinLimitOrderBookPagination{MaxOrders: 2}
+	orders, err = GetMergedOrderBook(utxoView, pair, pagination)
+	require.NoError(err)
+	requireOrderIDs(orders, order1, order6)
+	pagination.StartAfterOrder = orders[1]
+	orders, err = GetMergedOrderBook(utxoView, pair, pagination)
+	require.NoError(err)
+	requireOrderIDs(orders, order3, order4)
+	pagination.StartAfterOrder = orders[1]
+	orders, err = GetMergedOrderBook(utxoView, pair, pagination)
+	require.NoError(err)
+	require.Empty(orders)
+
+	// Page through one order at a time. The second page has to
+	// skip past the tombstoned and overridden db orders.
+	pagination = &DAOCoinLimitOrderBookPagination{StartAfterOrder: order1, MaxOrders: 1}
+	orders, err = GetMergedOrderBook(utxoView, pair, pagination)
+	require.NoError(err)
+	requireOrderIDs(orders, order6)
+
+	// Starting after a deleted order still works.
+	pagination = &DAOCoinLimitOrderBookPagination{StartAfterOrder: order2, MaxOrders: 1}
+	orders, err = GetMergedOrderBook(utxoView, pair, pagination)
+	require.NoError(err)
+	requireOrderIDs(orders, order3)
+
+	// The other side of the book only sees its own orders.
+	orders, err = GetMergedOrderBook(utxoView, &DAOCoinLimitOrderPair{
+		BuyingDAOCoinCreatorPKID:  &ZeroPKID,
+		SellingDAOCoinCreatorPKID: m0PKID,
+	}, nil)
+	require.NoError(err)
+	require.Equal(2, len(orders))
+
+	// A start after order from a different pair is rejected.
+	_, err = GetMergedOrderBook(utxoView, pair, &DAOCoinLimitOrderBookPagination{
+		StartAfterOrder: otherPairDbOrder,
+	})
+	require.Error(err)
+
+	// None of this modified the view.
+	require.Equal(numViewOrders, len(utxoView.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry))
+}
+
 func TestFlushingDAOCoinLimitOrders(t *testing.T) {
 	// Test constants
 	const feeRateNanosPerKb = uint64(101)
@@ -4401,3 +5781,123 @@ func TestFlushingDAOCoinLimitOrders(t *testing.T) {
 		require.Equal(t, orderEntries[0].QuantityToFillInBaseUnits.Uint64(), uint64(200))
 	}
 }
+
+func TestFlushingOnlyDirtyDAOCoinLimitOrders(t *testing.T) {
+	require := require.New(t)
+	chain, params, db := NewLowDifficultyBlockchain()
+	blockHeight := uint64(chain.blockTip().Height) + 1
+	orders := _putTestDAOCoinLimitOrders(t, db, chain.snapshot, blockHeight, 10)
+	versionsBefore := _getTestDAOCoinLimitOrderVersions(t, db, orders)
+
+	// Load the whole book into the view, which shouldn't mark any order dirty.
+	utxoView, err := NewUtxoView(db, params, nil, chain.snapshot)
+	require.NoError(err)
+	allOrders, err := utxoView._getAllDAOCoinLimitOrders()
+	require.NoError(err)
+	require.Len(allOrders, len(orders))
+	require.Len(utxoView.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry, len(orders))
+	require.Empty(utxoView.dirtyDAOCoinLimitOrderMapKeys)
+
+	// Partially fill the first order and cancel the second.
+	filledOrder := orders[0].Copy()
+	filledOrder.QuantityToFillInBaseUnits = uint256.NewInt().SetUint64(50)
+	utxoView._setDAOCoinLimitOrderEntryMappings(filledOrder)
+	utxoView._deleteDAOCoinLimitOrderEntryMappings(orders[1])
+	require.Len(utxoView.dirtyDAOCoinLimitOrderMapKeys, 2)
+
+	// Only the two dirty orders are written.
+	require.NoError(utxoView.FlushToDb(blockHeight))
+	require.Empty(utxoView.dirtyDAOCoinLimitOrderMapKeys)
+	versionsAfter := _getTestDAOCoinLimitOrderVersions(t, db, orders)
+	require.NotEqual(versionsBefore[0], versionsAfter[0])
+	require.Zero(versionsAfter[1])
+	require.Equal(versionsBefore[2:], versionsAfter[2:])
+
+	dbOrder, err := DBGetDAOCoinLimitOrder(db, chain.snapshot, filledOrder.OrderID)
+	require.NoError(err)
+	require.Equal(filledOrder.QuantityToFillInBaseUnits, dbOrder.QuantityToFillInBaseUnits)
+	dbOrder, err = DBGetDAOCoinLimitOrder(db, chain.snapshot, orders[1].OrderID)
+	require.NoError(err)
+	require.Nil(dbOrder)
+}
+
+// BenchmarkFlushingDAOCoinLimitOrders flushes a view that loaded a large book, like the
+// view of a block whose txns match against it, while only a few of the orders change.
+func BenchmarkFlushingDAOCoinLimitOrders(b *testing.B) {
+	const numDirtyOrders = 10
+	for _, numOrders := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("Orders%d", numOrders), func(b *testing.B) {
+			chain, params, db := NewLowDifficultyBlockchain()
+			blockHeight := uint64(chain.blockTip().Height) + 1
+			orders := _putTestDAOCoinLimitOrders(b, db, chain.snapshot, blockHeight, numOrders)
+
+			b.ResetTimer()
+			for ii := 0; ii < b.N; ii++ {
+				b.StopTimer()
+				utxoView, err := NewUtxoView(db, params, nil, chain.snapshot)
+				require.NoError(b, err)
+				_, err = utxoView._getAllDAOCoinLimitOrders()
+				require.NoError(b, err)
+				for _, order := range orders[:numDirtyOrders] {
+					filledOrder := order.Copy()
+					filledOrder.QuantityToFillInBaseUnits = uint256.NewInt().SetUint64(uint64(ii + 1))
+					utxoView._setDAOCoinLimitOrderEntryMappings(filledOrder)
+				}
+				b.StartTimer()
+
+				require.NoError(b, utxoView.FlushToDb(blockHeight))
+			}
+		})
+	}
+}
+
+func _putTestDAOCoinLimitOrders(tb testing.TB, db *badger.DB, snap *Snapshot, blockHeight uint64,
+	numOrders int) []*DAOCoinLimitOrderEntry {
+
+	buyingPKID := NewPKID(RandomBytes(33))
+	sellingPKID := NewPKID(RandomBytes(33))
+	var orders []*DAOCoinLimitOrderEntry
+	err := db.Update(func(txn *badger.Txn) error {
+		for ii := 0; ii < numOrders; ii++ {
+			order := &DAOCoinLimitOrderEntry{
+				OrderID:                   NewBlockHash(RandomBytes(32)),
+				TransactorPKID:            NewPKID(RandomBytes(33)),
+				BuyingDAOCoinCreatorPKID:  buyingPKID,
+				SellingDAOCoinCreatorPKID: sellingPKID,
+				ScaledExchangeRateCoinsToSellPerCoinToBuy: OneE38.Clone(),
+				QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(100),
+				OperationType:                             DAOCoinLimitOrderOperationTypeBID,
+				FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+				BlockHeight:                               uint32(blockHeight),
+			}
+			if err := DBPutDAOCoinLimitOrderWithTxn(txn, snap, order, blockHeight); err != nil {
+				return err
+			}
+			orders = append(orders, order)
+		}
+		return nil
+	})
+	require.NoError(tb, err)
+	return orders
+}
+
+// _getTestDAOCoinLimitOrderVersions returns the badger version of each order's record,
+// which changes whenever the order is written, or zero if the order isn't in the db.
+func _getTestDAOCoinLimitOrderVersions(tb testing.TB, db *badger.DB, orders []*DAOCoinLimitOrderEntry) []uint64 {
+	versions := make([]uint64, len(orders))
+	err := db.View(func(txn *badger.Txn) error {
+		for ii, order := range orders {
+			item, err := txn.Get(DBKeyForDAOCoinLimitOrder(order))
+			if err == badger.ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			versions[ii] = item.Version()
+		}
+		return nil
+	})
+	require.NoError(tb, err)
+	return versions
+}
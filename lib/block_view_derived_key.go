@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -128,7 +130,7 @@ func (bav *UtxoView) _connectAuthorizeDerivedKey(
 	if len(ownerPublicKey) != btcec.PubKeyBytesLenCompressed {
 		return 0, 0, nil, RuleErrorAuthorizeDerivedKeyInvalidOwnerPublicKey
 	}
-	if _, err := btcec.ParsePubKey(ownerPublicKey, btcec.S256()); err != nil {
+	if _, err := ParsePubKeyCached(ownerPublicKey); err != nil {
 		return 0, 0, nil, errors.Wrap(
 			RuleErrorAuthorizeDerivedKeyInvalidOwnerPublicKey, err.Error())
 	}
@@ -211,6 +213,16 @@ func (bav *UtxoView) _connectAuthorizeDerivedKey(
 
 				// A valid unlimited spending limit object only has the IsUnlimited field set.
 				newTransactionSpendingLimit.IsUnlimited = isUnlimited
+
+				// Like the global DESO limit, the txn type scope is always overwritten. Unlike the other
+				// limits, it can be set on unlimited keys too.
+				if blockHeight >= bav.Params.ForkHeights.DerivedKeyTxnTypeScopingBlockHeight {
+					if err = transactionSpendingLimit.AllowedTxnTypes.Validate(); err != nil {
+						return 0, 0, nil, errors.Wrapf(err, "_connectAuthorizeDerivedKey: ")
+					}
+					newTransactionSpendingLimit.AllowedTxnTypes = transactionSpendingLimit.AllowedTxnTypes
+				}
+
 				if !newTransactionSpendingLimit.IsUnlimited {
 
 					// TODO: how can we serialize this in a way that we don't have to specify it everytime
@@ -252,6 +264,16 @@ func (bav *UtxoView) _connectAuthorizeDerivedKey(
 							newTransactionSpendingLimit.DAOCoinLimitOrderLimitMap[daoCoinLimitOrderLimitKey] = transactionCount
 						}
 					}
+					for txnType, desoLimit := range transactionSpendingLimit.TxnTypeDESOLimitMap {
+						if newTransactionSpendingLimit.TxnTypeDESOLimitMap == nil {
+							newTransactionSpendingLimit.TxnTypeDESOLimitMap = make(map[TxnType]uint64)
+						}
+						if desoLimit == 0 {
+							delete(newTransactionSpendingLimit.TxnTypeDESOLimitMap, txnType)
+						} else {
+							newTransactionSpendingLimit.TxnTypeDESOLimitMap[txnType] = desoLimit
+						}
+					}
 				}
 			}
 		}
@@ -380,7 +402,7 @@ func (bav *UtxoView) _disconnectAuthorizeDerivedKey(
 	if len(currentTxn.PublicKey) != btcec.PubKeyBytesLenCompressed {
 		return fmt.Errorf("_disconnectAuthorizeDerivedKey invalid public key: %v", currentTxn.PublicKey)
 	}
-	_, err := btcec.ParsePubKey(currentTxn.PublicKey, btcec.S256())
+	_, err := ParsePubKeyCached(currentTxn.PublicKey)
 	if err != nil {
 		return fmt.Errorf("_disconnectAuthorizeDerivedKey invalid public key: %v", err)
 	}
@@ -391,7 +413,7 @@ func (bav *UtxoView) _disconnectAuthorizeDerivedKey(
 	if len(txMeta.DerivedPublicKey) != btcec.PubKeyBytesLenCompressed {
 		return fmt.Errorf("_disconnectAuthorizeDerivedKey invalid derived key: %v", txMeta.DerivedPublicKey)
 	}
-	_, err = btcec.ParsePubKey(txMeta.DerivedPublicKey, btcec.S256())
+	_, err = ParsePubKeyCached(txMeta.DerivedPublicKey)
 	if err != nil {
 		return fmt.Errorf("_disconnectAuthorizeDerivedKey invalid derived key: %v", err)
 	}
@@ -452,3 +474,24 @@ func (bav *UtxoView) _disconnectAuthorizeDerivedKey(
 	return bav._disconnectBasicTransfer(
 		currentTxn, txnHash, utxoOpsForTxn[:operationIndex], blockHeight)
 }
+
+func (bav *UtxoView) CheckIfValidUnlimitedSpendingLimit(tsl *TransactionSpendingLimit, blockHeight uint32) (_isUnlimited bool, _err error) {
+	AssertDependencyStructFieldNumbers(&TransactionSpendingLimit{}, 9)
+
+	if tsl.IsUnlimited && blockHeight < bav.Params.ForkHeights.DeSoUnlimitedDerivedKeysBlockHeight {
+		return false, RuleErrorUnlimitedDerivedKeyBeforeBlockHeight
+	}
+
+	if tsl.IsUnlimited && (tsl.GlobalDESOLimit > 0 ||
+		len(tsl.TransactionCountLimitMap) > 0 ||
+		len(tsl.CreatorCoinOperationLimitMap) > 0 ||
+		len(tsl.DAOCoinOperationLimitMap) > 0 ||
+		len(tsl.NFTOperationLimitMap) > 0 ||
+		len(tsl.DAOCoinLimitOrderLimitMap) > 0 ||
+		len(tsl.TxnTypeDESOLimitMap) > 0) {
+
+		return tsl.IsUnlimited, RuleErrorUnlimitedDerivedKeyNonEmptySpendingLimits
+	}
+
+	return tsl.IsUnlimited, nil
+}
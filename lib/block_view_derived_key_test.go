@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -435,6 +437,13 @@ func _doTxnWithBlockHeight(
 			maxCopiesPerNFT,
 			minNetworkFeeNanosPerKB,
 			nil,
+			nil,
+			nil,
+			-1,
+			-1,
+			nil,
+			nil,
+			nil,
 			feeRateNanosPerKB,
 			nil,
 			nil,
@@ -3905,3 +3914,106 @@ REPEAT:
 	}
 	_executeAllTestRollbackAndFlush(testMeta)
 }
+
+func TestAuthorizeDerivedKeyWithTxnTypeScoping(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true /*isSender*/)
+
+	GlobalDeSoParams = *params
+	for ii := range GlobalDeSoParams.EncoderMigrationHeightsList {
+		migration := GlobalDeSoParams.EncoderMigrationHeightsList[ii]
+		if migration.Name == UnlimitedDerivedKeysMigration || migration.Name == DerivedKeyTxnTypeScopingMigration {
+			GlobalDeSoParams.EncoderMigrationHeightsList[ii].Height = 0
+		}
+	}
+	params.ForkHeights.NFTTransferOrBurnAndDerivedKeysBlockHeight = uint32(0)
+	params.ForkHeights.DerivedKeySetSpendingLimitsBlockHeight = uint32(0)
+	params.ForkHeights.DerivedKeyTrackSpendingLimitsBlockHeight = uint32(0)
+	params.ForkHeights.DeSoUnlimitedDerivedKeysBlockHeight = uint32(0)
+	params.ForkHeights.DerivedKeyEthSignatureCompatibilityBlockHeight = uint32(0)
+	params.ForkHeights.DerivedKeyTxnTypeScopingBlockHeight = uint32(0)
+
+	// Mine a few blocks to give the senderPkString some money.
+	for ii := 0; ii < 4; ii++ {
+		_, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
+		require.NoError(err)
+	}
+
+	testMeta := &TestMeta{
+		t:           t,
+		chain:       chain,
+		params:      params,
+		db:          db,
+		mempool:     mempool,
+		miner:       miner,
+		savedHeight: chain.blockTip().Height + 1,
+	}
+	_registerOrTransferWithTestMeta(testMeta, "", senderPkString, m1Pub, senderPrivString, 1000)
+
+	// Authorize a derived key for m1 that is scoped to basic transfers and authorize txns. It has
+	// quota for posts, but they're out of its scope. Basic transfers can spend at most 15 nanos.
+	m1PrivKeyBytes, _, err := Base58CheckDecode(m1Priv)
+	require.NoError(err)
+	m1PrivateKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), m1PrivKeyBytes)
+	allowedTxnTypes := NewTxnTypeBitmap(TxnTypeAuthorizeDerivedKey, TxnTypeBasicTransfer)
+	transactionSpendingLimit := &TransactionSpendingLimit{
+		GlobalDESOLimit: 100,
+		TransactionCountLimitMap: map[TxnType]uint64{
+			TxnTypeAuthorizeDerivedKey: 1,
+			TxnTypeBasicTransfer:       2,
+			TxnTypeSubmitPost:          1,
+		},
+		CreatorCoinOperationLimitMap: make(map[CreatorCoinOperationLimitKey]uint64),
+		DAOCoinOperationLimitMap:     make(map[DAOCoinOperationLimitKey]uint64),
+		NFTOperationLimitMap:         make(map[NFTOperationLimitKey]uint64),
+		DAOCoinLimitOrderLimitMap:    make(map[DAOCoinLimitOrderLimitKey]uint64),
+		AllowedTxnTypes:              allowedTxnTypes,
+		TxnTypeDESOLimitMap:          map[TxnType]uint64{TxnTypeBasicTransfer: 15},
+	}
+	blockHeight, err := GetBlockTipHeight(db, false)
+	require.NoError(err)
+	authTxnMeta, derivedPriv := _getAuthorizeDerivedKeyMetadataWithTransactionSpendingLimit(
+		t, m1PrivateKey, 100, transactionSpendingLimit, false, blockHeight+1)
+	derivedPrivBase58Check := Base58CheckEncode(derivedPriv.Serialize(), true, params)
+	{
+		extraData := make(map[string]interface{})
+		extraData[TransactionSpendingLimitKey] = transactionSpendingLimit
+		_doTxnWithTestMeta(testMeta, 10, m1Pub, derivedPrivBase58Check, true,
+			TxnTypeAuthorizeDerivedKey, authTxnMeta, extraData, blockHeight+1)
+	}
+
+	// The scope is in the index.
+	m1PublicKey := *NewPublicKey(m1PkBytes)
+	derivedPublicKey := *NewPublicKey(authTxnMeta.DerivedPublicKey)
+	require.Equal(allowedTxnTypes, DBGetDerivedKeyAllowedTxnTypes(db, chain.snapshot, m1PublicKey, derivedPublicKey))
+
+	// The derived key can't submit a post, even though it has quota for it.
+	{
+		err = _doTxnWithTextMetaWithBlockHeightWithError(testMeta, 10, m1Pub, derivedPrivBase58Check, true,
+			TxnTypeSubmitPost, &SubmitPostMetadata{Body: []byte(`{"Body": "out of scope"}`)}, nil, blockHeight+1)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDerivedKeyTxnTypeNotInScope)
+	}
+
+	// The first transfer fits in the basic transfer DESO limit, but the second one doesn't.
+	{
+		extraData := map[string]interface{}{
+			BasicTransferRecipient: m0PkBytes,
+			BasicTransferAmount:    uint64(10),
+		}
+		_doTxnWithTestMeta(testMeta, 10, m1Pub, derivedPrivBase58Check, true,
+			TxnTypeBasicTransfer, nil, extraData, blockHeight+1)
+
+		err = _doTxnWithTextMetaWithBlockHeightWithError(testMeta, 10, m1Pub, derivedPrivBase58Check, true,
+			TxnTypeBasicTransfer, nil, extraData, blockHeight+1)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorDerivedKeyTxnSpendsMoreThanTxnTypeDESOLimit)
+	}
+
+	_executeAllTestRollbackAndFlush(testMeta)
+
+	// Disconnecting the authorize txn removes the scope from the index.
+	require.Equal(TxnTypeBitmap(0), DBGetDerivedKeyAllowedTxnTypes(db, chain.snapshot, m1PublicKey, derivedPublicKey))
+}
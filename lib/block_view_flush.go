@@ -1,15 +1,23 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
+	"context"
 	"fmt"
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/dgraph-io/badger/v3"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 	"reflect"
 )
 
 func (bav *UtxoView) FlushToDb(blockHeight uint64) error {
+	if bav.isReadOnly {
+		return ErrUtxoViewReadOnly
+	}
+
 	// Make sure everything happens inside a single transaction.
 	var err error
 	if bav.Postgres != nil {
@@ -19,12 +27,20 @@ func (bav *UtxoView) FlushToDb(blockHeight uint64) error {
 		}
 	}
 
+	// The snapshot updates are staged in a FlushSession, so that they're dropped if the txn fails.
+	session := bav.Snapshot.NewFlushSession()
 	err = bav.Handle.Update(func(txn *badger.Txn) error {
+		session.TrackTxn(txn)
+		defer session.EndTrack()
 		return bav.FlushToDbWithTxn(txn, blockHeight)
 	})
+	session.Finish(err)
 	if err != nil {
 		return err
 	}
+	if err := _injectDBFault(DBFaultPointAfterCommit); err != nil {
+		return err
+	}
 
 	// After a successful flush, reset the in-memory mappings for the view
 	// so that it can be re-used if desired.
@@ -38,15 +54,43 @@ func (bav *UtxoView) FlushToDb(blockHeight uint64) error {
 	return nil
 }
 
-func (bav *UtxoView) FlushToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+// FlushToDbWithTxn writes the view's entries to the db in the txn. Every entry in the view's maps is
+// written, except for the DAO coin limit orders and trigger entries, of which only the ones that were
+// set or deleted are written, see dirtyDAOCoinLimitOrderMapKeys.
+func (bav *UtxoView) FlushToDbWithTxn(txn *badger.Txn, blockHeight uint64) (_err error) {
+	if bav.isReadOnly {
+		return ErrUtxoViewReadOnly
+	}
+	// The span counts every record the flush reads, writes and deletes, broken down by prefix.
+	_, dbSpan := StartDBSpan(context.Background(), "UtxoView.FlushToDb", nil,
+		attribute.Int64("block.height", int64(blockHeight)))
+	dbSpan.TrackTxn(txn)
+	defer func() {
+		dbSpan.End(_err)
+	}()
+
 	// We're about to flush records to the main DB, so we initiate the snapshot update.
-	// This function prepares the data structures in the snapshot.
-	if bav.Snapshot != nil {
+	// This function prepares the data structures in the snapshot. If the txn is part of a
+	// FlushSession, the session has already done this, and finishes the update once the txn
+	// is committed or discarded.
+	if bav.Snapshot != nil && bav.Snapshot.flushSessionForTxn(txn) == nil {
 		bav.Snapshot.PrepareAncestralRecordsFlush()
 
 		// When we finish flushing to the main DB, we'll also flush to ancestral records.
 		// This happens concurrently, which is why we have the 2-phase prepare-flush happening for snapshot.
-		defer bav.Snapshot.StartAncestralRecordsFlush(true)
+		// If a test injected a fault, we act as if the node died and never get to the ancestral flush.
+		defer func() {
+			if !_isDBFaultInjected() {
+				bav.Snapshot.StartAncestralRecordsFlush(true)
+			}
+		}()
+	}
+
+	// The NFT collection summaries are updated from the NFTs, bids, and accepted bids this view
+	// changes, which we find by comparing them to the db before they're flushed.
+	var nftCollectionSummaryChanges map[BlockHash]*nftCollectionSummaryChange
+	if bav.Postgres == nil {
+		nftCollectionSummaryChanges = bav._getNFTCollectionSummaryChangesWithTxn(txn)
 	}
 
 	// Only flush to BadgerDB if Postgres is disabled
@@ -102,6 +146,10 @@ func (bav *UtxoView) FlushToDbWithTxn(txn *badger.Txn, blockHeight uint64) error
 		//}
 	}
 
+	if err := _injectDBFault(DBFaultPointBetweenFlushStages); err != nil {
+		return err
+	}
+
 	// Always flush to BadgerDB.
 	if err := bav._flushBitcoinExchangeDataWithTxn(txn); err != nil {
 		return err
@@ -112,6 +160,14 @@ func (bav *UtxoView) FlushToDbWithTxn(txn *badger.Txn, blockHeight uint64) error
 	if err := bav._flushAcceptedBidEntriesToDbWithTxn(txn, blockHeight); err != nil {
 		return err
 	}
+	// The NFT collection summaries are derived from the NFT, bid, and accepted bid
+	// indexes so they have to be updated after all of those have been flushed.
+	if bav.Postgres == nil {
+		if err := bav._flushNFTCollectionSummariesToDbWithTxn(
+			txn, blockHeight, nftCollectionSummaryChanges); err != nil {
+			return err
+		}
+	}
 	if err := bav._flushRepostEntriesToDbWithTxn(txn, blockHeight); err != nil {
 		return err
 	}
@@ -122,7 +178,16 @@ func (bav *UtxoView) FlushToDbWithTxn(txn *badger.Txn, blockHeight uint64) error
 	if err := bav._flushDAOCoinLimitOrderEntriesToDbWithTxn(txn, blockHeight); err != nil {
 		return err
 	}
-	return nil
+	if err := bav._flushDAOCoinLimitOrderTriggerEntriesToDbWithTxn(txn, blockHeight); err != nil {
+		return err
+	}
+	if err := bav._flushPostAssociationEntriesToDbWithTxn(txn, blockHeight); err != nil {
+		return err
+	}
+	if err := bav._flushUserAssociationEntriesToDbWithTxn(txn, blockHeight); err != nil {
+		return err
+	}
+	return _injectDBFault(DBFaultPointBeforeCommit)
 }
 
 func (bav *UtxoView) _flushUtxosToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
@@ -184,26 +249,28 @@ func (bav *UtxoView) _flushDeSoBalancesToDbWithTxn(txn *badger.Txn) error {
 	glog.V(2).Infof("_flushDeSoBalancesToDbWithTxn: flushing %d mappings",
 		len(bav.PublicKeyToDeSoBalanceNanos))
 
-	for pubKeyIter := range bav.PublicKeyToDeSoBalanceNanos {
+	// The balances are flushed with the batched db API, since a block touches the balances of most of
+	// its transactors and the snapshot bookkeeping dominates the cost of writing them one by one.
+	balanceKeys := [][]byte{}
+	balanceEntries := []*DBEntry{}
+	for pubKeyIter, balanceNanos := range bav.PublicKeyToDeSoBalanceNanos {
 		// Make a copy of the iterator since it might change from under us.
 		pubKey := pubKeyIter[:]
 
 		// Start by deleting the pre-existing mappings in the db for this key if they
 		// have not yet been modified.
-		if err := DbDeletePublicKeyToDeSoBalanceWithTxn(txn, bav.Snapshot, pubKey); err != nil {
-			return err
-		}
-	}
-	for pubKeyIter, balanceNanos := range bav.PublicKeyToDeSoBalanceNanos {
-		// Make a copy of the iterator since it might change from under us.
-		pubKey := pubKeyIter[:]
-
+		balanceKey := _dbKeyForPublicKeyToDeSoBalanceNanos(pubKey)
+		balanceKeys = append(balanceKeys, balanceKey)
 		if balanceNanos > 0 {
-			if err := DbPutDeSoBalanceForPublicKeyWithTxn(txn, bav.Snapshot, pubKey, balanceNanos); err != nil {
-				return err
-			}
+			balanceEntries = append(balanceEntries, &DBEntry{Key: balanceKey, Value: EncodeUint64(balanceNanos)})
 		}
 	}
+	if err := DBBatchDeleteWithTxn(txn, bav.Snapshot, balanceKeys); err != nil {
+		return errors.Wrapf(err, "_flushDeSoBalancesToDbWithTxn: Problem deleting balances")
+	}
+	if err := DBBatchSetWithTxn(txn, bav.Snapshot, balanceEntries); err != nil {
+		return errors.Wrapf(err, "_flushDeSoBalancesToDbWithTxn: Problem setting balances")
+	}
 
 	return nil
 }
@@ -213,6 +280,17 @@ func (bav *UtxoView) _flushGlobalParamsEntryToDbWithTxn(txn *badger.Txn, blockHe
 	if err := DbPutGlobalParamsEntryWithTxn(txn, bav.Snapshot, blockHeight, *globalParamsEntry); err != nil {
 		return errors.Wrapf(err, "_flushGlobalParamsEntryToDbWithTxn: Problem putting global params entry in DB")
 	}
+	for historyHeight, historyEntry := range bav.GlobalParamsHistory {
+		if historyEntry == nil {
+			if err := DbDeleteGlobalParamsHistoryEntryWithTxn(txn, historyHeight); err != nil {
+				return errors.Wrapf(err, "_flushGlobalParamsEntryToDbWithTxn: ")
+			}
+			continue
+		}
+		if err := DbPutGlobalParamsHistoryEntryWithTxn(txn, historyHeight, historyEntry); err != nil {
+			return errors.Wrapf(err, "_flushGlobalParamsEntryToDbWithTxn: ")
+		}
+	}
 	return nil
 }
 
@@ -411,6 +489,90 @@ func (bav *UtxoView) _flushLikeEntriesToDbWithTxn(txn *badger.Txn) error {
 	return nil
 }
 
+func (bav *UtxoView) _flushPostAssociationEntriesToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+
+	// Go through all the entries in the PostAssociationKeyToPostAssociationEntry map.
+	for associationKeyIter, associationEntry := range bav.PostAssociationKeyToPostAssociationEntry {
+		// Make a copy of the iterator since we make references to it below.
+		associationKey := associationKeyIter
+
+		// Sanity-check that the PostAssociationKey computed from the PostAssociationEntry is
+		// equal to the PostAssociationKey that maps to that entry.
+		associationKeyInEntry := associationEntry.ToMapKey()
+		if associationKeyInEntry != associationKey {
+			return fmt.Errorf("_flushPostAssociationEntriesToDbWithTxn: PostAssociationEntry has "+
+				"PostAssociationKey: %v, which doesn't match the PostAssociationKeyToPostAssociationEntry "+
+				"map key %v", &associationKeyInEntry, &associationKey)
+		}
+
+		// Delete the existing mappings in the db for this PostAssociationKey. They will be
+		// re-added if the corresponding entry in memory has isDeleted=false.
+		if err := DBDeletePostAssociationEntryWithTxn(txn, bav.Snapshot, associationEntry); err != nil {
+			return errors.Wrapf(
+				err, "_flushPostAssociationEntriesToDbWithTxn: Problem deleting mappings "+
+					"for PostAssociationKey: %v: ", &associationKey)
+		}
+	}
+
+	// Go through all the entries in the PostAssociationKeyToPostAssociationEntry map.
+	for _, associationEntry := range bav.PostAssociationKeyToPostAssociationEntry {
+		if associationEntry.isDeleted {
+			// If the PostAssociationEntry has isDeleted=true then there's nothing to do
+			// because we already deleted the entry above.
+		} else {
+			// If the PostAssociationEntry has (isDeleted = false) then we put the
+			// corresponding mappings for it into the db.
+			if err := DBPutPostAssociationEntryWithTxn(txn, bav.Snapshot, blockHeight, associationEntry); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (bav *UtxoView) _flushUserAssociationEntriesToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+
+	// Go through all the entries in the UserAssociationKeyToUserAssociationEntry map.
+	for associationKeyIter, associationEntry := range bav.UserAssociationKeyToUserAssociationEntry {
+		// Make a copy of the iterator since we make references to it below.
+		associationKey := associationKeyIter
+
+		// Sanity-check that the UserAssociationKey computed from the UserAssociationEntry is
+		// equal to the UserAssociationKey that maps to that entry.
+		associationKeyInEntry := associationEntry.ToMapKey()
+		if associationKeyInEntry != associationKey {
+			return fmt.Errorf("_flushUserAssociationEntriesToDbWithTxn: UserAssociationEntry has "+
+				"UserAssociationKey: %v, which doesn't match the UserAssociationKeyToUserAssociationEntry "+
+				"map key %v", &associationKeyInEntry, &associationKey)
+		}
+
+		// Delete the existing mappings in the db for this UserAssociationKey. They will be
+		// re-added if the corresponding entry in memory has isDeleted=false.
+		if err := DBDeleteUserAssociationEntryWithTxn(txn, bav.Snapshot, associationEntry); err != nil {
+			return errors.Wrapf(
+				err, "_flushUserAssociationEntriesToDbWithTxn: Problem deleting mappings "+
+					"for UserAssociationKey: %v: ", &associationKey)
+		}
+	}
+
+	// Go through all the entries in the UserAssociationKeyToUserAssociationEntry map.
+	for _, associationEntry := range bav.UserAssociationKeyToUserAssociationEntry {
+		if associationEntry.isDeleted {
+			// If the UserAssociationEntry has isDeleted=true then there's nothing to do
+			// because we already deleted the entry above.
+		} else {
+			// If the UserAssociationEntry has (isDeleted = false) then we put the
+			// corresponding mappings for it into the db.
+			if err := DBPutUserAssociationEntryWithTxn(txn, bav.Snapshot, blockHeight, associationEntry); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 func (bav *UtxoView) _flushFollowEntriesToDbWithTxn(txn *badger.Txn) error {
 
 	// Go through all the entries in the FollowKeyToFollowEntry map.
@@ -543,6 +705,107 @@ func (bav *UtxoView) _flushAcceptedBidEntriesToDbWithTxn(txn *badger.Txn, blockH
 	return nil
 }
 
+// _getNFTCollectionSummaryChangesWithTxn compares the NFTs, bids, and accepted bids in the view to
+// the ones in the db, and returns the changes by post. It has to be called before they're flushed.
+func (bav *UtxoView) _getNFTCollectionSummaryChangesWithTxn(txn *badger.Txn) map[BlockHash]*nftCollectionSummaryChange {
+
+	changes := make(map[BlockHash]*nftCollectionSummaryChange)
+	getChange := func(nftPostHash BlockHash) *nftCollectionSummaryChange {
+		if _, exists := changes[nftPostHash]; !exists {
+			changes[nftPostHash] = &nftCollectionSummaryChange{}
+		}
+		return changes[nftPostHash]
+	}
+
+	for nftKeyIter, nftEntry := range bav.NFTKeyToNFTEntry {
+		nftKey := nftKeyIter
+		prevNFTEntry := DBGetNFTEntryByPostHashSerialNumberWithTxn(
+			txn, bav.Snapshot, &nftKey.NFTPostHash, nftKey.SerialNumber)
+		if nftEntry.isDeleted {
+			nftEntry = nil
+		}
+		if prevNFTEntry == nil && nftEntry == nil {
+			continue
+		}
+		if prevNFTEntry != nil && nftEntry != nil && prevNFTEntry.IsForSale == nftEntry.IsForSale &&
+			(!nftEntry.IsForSale ||
+				_nftCollectionSummaryPriceNanos(prevNFTEntry) == _nftCollectionSummaryPriceNanos(nftEntry)) {
+			continue
+		}
+		change := getChange(nftKey.NFTPostHash)
+		if prevNFTEntry != nil {
+			change.removedNFTEntries = append(change.removedNFTEntries, prevNFTEntry)
+		}
+		if nftEntry != nil {
+			change.addedNFTEntries = append(change.addedNFTEntries, nftEntry)
+		}
+	}
+
+	for nftBidKeyIter, nftBidEntry := range bav.NFTBidKeyToNFTBidEntry {
+		nftBidKey := nftBidKeyIter
+		prevNFTBidEntry := DBGetNFTBidEntryForNFTBidKeyWithTxn(txn, bav.Snapshot, &nftBidKey)
+		if nftBidEntry.isDeleted {
+			nftBidEntry = nil
+		}
+		if prevNFTBidEntry == nil && nftBidEntry == nil {
+			continue
+		}
+		if prevNFTBidEntry != nil && nftBidEntry != nil &&
+			prevNFTBidEntry.BidAmountNanos == nftBidEntry.BidAmountNanos {
+			continue
+		}
+		change := getChange(nftBidKey.NFTPostHash)
+		if prevNFTBidEntry != nil {
+			change.removedBidAmountsNanos = append(change.removedBidAmountsNanos, prevNFTBidEntry.BidAmountNanos)
+		}
+		if nftBidEntry != nil {
+			change.addedBidAmountsNanos = append(change.addedBidAmountsNanos, nftBidEntry.BidAmountNanos)
+		}
+	}
+
+	// Accepted bids are only ever appended to the history of a serial when blocks are connected.
+	for nftKeyIter, acceptedNFTBidEntries := range bav.NFTKeyToAcceptedNFTBidHistory {
+		nftKey := nftKeyIter
+		var prevEntries, entries []*NFTBidEntry
+		if prevAcceptedNFTBidEntries := DBGetAcceptedNFTBidEntriesByPostHashSerialNumberWithTxn(
+			txn, bav.Snapshot, &nftKey.NFTPostHash, nftKey.SerialNumber); prevAcceptedNFTBidEntries != nil {
+			prevEntries = *prevAcceptedNFTBidEntries
+		}
+		if acceptedNFTBidEntries != nil {
+			entries = *acceptedNFTBidEntries
+		}
+		isAppend := len(entries) >= len(prevEntries)
+		for ii := 0; isAppend && ii < len(prevEntries); ii++ {
+			isAppend = prevEntries[ii].BidAmountNanos == entries[ii].BidAmountNanos &&
+				reflect.DeepEqual(prevEntries[ii].AcceptedBlockHeight, entries[ii].AcceptedBlockHeight)
+		}
+		if isAppend && len(entries) == len(prevEntries) {
+			continue
+		}
+		change := getChange(nftKey.NFTPostHash)
+		if !isAppend {
+			change.hasRemovedAcceptedBids = true
+			continue
+		}
+		change.addedAcceptedBidEntries = append(change.addedAcceptedBidEntries, entries[len(prevEntries):]...)
+	}
+
+	return changes
+}
+
+func (bav *UtxoView) _flushNFTCollectionSummariesToDbWithTxn(txn *badger.Txn, blockHeight uint64,
+	changes map[BlockHash]*nftCollectionSummaryChange) error {
+
+	for nftPostHashIter, change := range changes {
+		nftPostHash := nftPostHashIter
+		if err := _dbApplyNFTCollectionSummaryChangeWithTxn(txn, blockHeight, &nftPostHash, change); err != nil {
+			return errors.Wrapf(err, "_flushNFTCollectionSummariesToDbWithTxn: ")
+		}
+	}
+
+	return nil
+}
+
 func (bav *UtxoView) _flushNFTBidEntriesToDbWithTxn(txn *badger.Txn) error {
 
 	// Go through and delete all the entries so they can be added back fresh.
@@ -749,6 +1012,20 @@ func (bav *UtxoView) _flushPKIDEntriesToDbWithTxn(txn *badger.Txn, blockHeight u
 func (bav *UtxoView) _flushProfileEntriesToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
 	glog.V(2).Infof("_flushProfilesToDbWithTxn: flushing %d mappings", len(bav.ProfilePKIDToProfileEntry))
 
+	// Move the profiles that were set or deleted to the current height in the modified-height
+	// index. If the view was disconnected, the profiles were reverted at the height of the new tip.
+	modifiedHeight := blockHeight
+	if bav.revertedToBlockHeight != nil {
+		modifiedHeight = *bav.revertedToBlockHeight
+	}
+	for profilePKIDIter := range bav.dirtyProfilePKIDs {
+		// Make a copy of the iterator since we take references to it below.
+		profilePKID := profilePKIDIter
+		if err := DBPutProfileModifiedHeightWithTxn(txn, bav.Snapshot, &profilePKID, modifiedHeight); err != nil {
+			return errors.Wrapf(err, "_flushProfileEntriesToDbWithTxn: ")
+		}
+	}
+
 	// Go through all the entries in the ProfilePublicKeyToProfileEntry map.
 	for profilePKIDIter, profileEntry := range bav.ProfilePKIDToProfileEntry {
 		// Make a copy of the iterator since we take references to it below.
@@ -1025,12 +1302,15 @@ func (bav *UtxoView) _flushMessagingGroupEntriesToDbWithTxn(txn *badger.Txn, blo
 }
 
 func (bav *UtxoView) _flushDAOCoinLimitOrderEntriesToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
-	glog.V(1).Infof("_flushDAOCoinLimitOrderEntriesToDbWithTxn: flushing %d mappings", len(bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry))
+	glog.V(1).Infof("_flushDAOCoinLimitOrderEntriesToDbWithTxn: flushing %d of %d mappings",
+		len(bav.dirtyDAOCoinLimitOrderMapKeys), len(bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry))
 
-	// Go through all the entries in the DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry map.
-	for orderIter, orderEntry := range bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry {
+	// Go through the dirty entries in the DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry map.
+	// The rest were only read from the db, so they're already up-to-date there.
+	for orderIter := range bav.dirtyDAOCoinLimitOrderMapKeys {
 		// Make a copy of the iterator since we take references to it below.
 		orderKey := orderIter
+		orderEntry := bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry[orderKey]
 
 		// Validate order map key matches order entry.
 		orderMapKey := orderEntry.ToMapKey()
@@ -1054,9 +1334,9 @@ func (bav *UtxoView) _flushDAOCoinLimitOrderEntriesToDbWithTxn(txn *badger.Txn,
 	numDeleted := 0
 	numPut := 0
 
-	// Go through all the entries in the DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry map.
-	for _, orderEntry := range bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry {
-		// Make a copy of the iterator since we take references to it below.
+	// Go through the dirty entries in the DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry map.
+	for orderKey := range bav.dirtyDAOCoinLimitOrderMapKeys {
+		orderEntry := bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry[orderKey]
 		if orderEntry.isDeleted {
 			numDeleted++
 			// If the OrderEntry has isDeleted=true then there's nothing to do because
@@ -1076,3 +1356,41 @@ func (bav *UtxoView) _flushDAOCoinLimitOrderEntriesToDbWithTxn(txn *badger.Txn,
 	// At this point all of the DAO coin limit order mappings in the db should be up-to-date.
 	return nil
 }
+
+func (bav *UtxoView) _flushDAOCoinLimitOrderTriggerEntriesToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+	glog.V(1).Infof("_flushDAOCoinLimitOrderTriggerEntriesToDbWithTxn: flushing %d of %d mappings",
+		len(bav.dirtyDAOCoinLimitOrderTriggerMapKeys), len(bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry))
+
+	// Delete the existing mappings in the db for the dirty entries. They will be re-added
+	// if the corresponding entry in memory has isDeleted=false. A trigger entry never
+	// changes once it's created, so its index keys are the same as the db's.
+	for triggerKey := range bav.dirtyDAOCoinLimitOrderTriggerMapKeys {
+		triggerEntry := bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry[triggerKey]
+		if triggerMapKey := triggerEntry.ToMapKey(); triggerKey != triggerMapKey {
+			return fmt.Errorf("_flushDAOCoinLimitOrderTriggerEntriesToDbWithTxn: DAOCoinLimitOrderTriggerEntry "+
+				"has map key: %v which does not match the DAOCoinLimitOrderMapKey map key %v",
+				triggerMapKey, triggerKey)
+		}
+		if err := DBDeleteDAOCoinLimitOrderTriggerWithTxn(txn, bav.Snapshot, triggerEntry); err != nil {
+			return errors.Wrapf(err, "_flushDAOCoinLimitOrderTriggerEntriesToDbWithTxn: problem deleting mappings")
+		}
+	}
+
+	numDeleted := 0
+	numPut := 0
+	for triggerKey := range bav.dirtyDAOCoinLimitOrderTriggerMapKeys {
+		triggerEntry := bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderTriggerEntry[triggerKey]
+		if triggerEntry.isDeleted {
+			numDeleted++
+		} else {
+			numPut++
+			if err := DBPutDAOCoinLimitOrderTriggerWithTxn(txn, bav.Snapshot, triggerEntry, blockHeight); err != nil {
+				return err
+			}
+		}
+	}
+
+	glog.V(1).Infof("_flushDAOCoinLimitOrderTriggerEntriesToDbWithTxn: deleted %d mappings, put %d mappings",
+		numDeleted, numPut)
+	return nil
+}
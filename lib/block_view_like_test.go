@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -307,6 +309,28 @@ func TestLikeTxns(t *testing.T) {
 		require.Equal(uint64(len(likingP3)), post3.LikeCount)
 	}
 
+	// Verify the posts ordered by like count.
+	{
+		postHashes, likeCounts, postEntries, err := DBGetPaginatedPostsOrderedByLikeCount(
+			db, chain.snapshot, 0, nil, 0, true /*fetchPostEntries*/, true /*reverse*/)
+		require.NoError(err)
+		require.Equal([]*BlockHash{&post1Hash, &post2Hash, &post3Hash}, postHashes)
+		require.Equal([]uint64{3, 2, 1}, likeCounts)
+		require.Len(postEntries, 3)
+
+		// Start at the second most liked post.
+		postHashes, likeCounts, _, err = DBGetPaginatedPostsOrderedByLikeCount(
+			db, chain.snapshot, 2, &post2Hash, 1, false /*fetchPostEntries*/, true /*reverse*/)
+		require.NoError(err)
+		require.Equal([]*BlockHash{&post2Hash}, postHashes)
+		require.Equal([]uint64{2}, likeCounts)
+
+		postHashes, _, _, err = DBGetPaginatedPostsOrderedByLikeCount(
+			db, chain.snapshot, 0, nil, 2, false /*fetchPostEntries*/, false /*reverse*/)
+		require.NoError(err)
+		require.Equal([]*BlockHash{&post3Hash, &post2Hash}, postHashes)
+	}
+
 	m0Likes := []BlockHash{
 		post1Hash,
 	}
@@ -498,11 +522,22 @@ func TestLikeTxns(t *testing.T) {
 			require.Equal(uint64(0), post2.LikeCount)
 			post3 := DBGetPostEntryByPostHash(db, chain.snapshot, &post3Hash)
 			require.Equal(uint64(0), post3.LikeCount)
+
+			_, likeCounts, _, err := DBGetPaginatedPostsOrderedByLikeCount(
+				db, chain.snapshot, 0, nil, 0, false /*fetchPostEntries*/, true /*reverse*/)
+			require.NoError(err)
+			require.Equal([]uint64{0, 0, 0}, likeCounts)
 		}
 	}
 
 	testDisconnectedState := func() {
 		// Verify that all the pks liking each post hash have been deleted and like count == 0.
+		{
+			postHashes, _, _, err := DBGetPaginatedPostsOrderedByLikeCount(
+				db, chain.snapshot, 0, nil, 0, false /*fetchPostEntries*/, true /*reverse*/)
+			require.NoError(err)
+			require.Empty(postHashes)
+		}
 		{
 			likingPks, err := DbGetLikerPubKeysLikingAPostHash(db, post1Hash)
 			require.NoError(err)
@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -90,19 +92,11 @@ func (bav *UtxoView) GetMessagingGroupKeyToMessagingGroupEntryMapping(
 	//		return nil
 	//	}
 	//
-	//	memberEntries := []*MessagingGroupMember{}
-	//	if err := gob.NewDecoder(
-	//		bytes.NewReader(pgMessagingGroup.MessagingGroupMembers)).Decode(&memberEntries); err != nil {
+	//	messagingGroupEntry, err := pgMessagingGroup.NewMessagingGroupEntry()
+	//	if err != nil {
 	//		glog.Errorf("Error decoding MessagingGroupMembers from DB: %v", err)
 	//		return nil
 	//	}
-	//
-	//	messagingGroupEntry := &MessagingGroupEntry{
-	//		GroupOwnerPublicKey:   pgMessagingGroup.GroupOwnerPublicKey,
-	//		MessagingPublicKey:    pgMessagingGroup.MessagingPublicKey,
-	//		MessagingGroupKeyName: pgMessagingGroup.MessagingGroupKeyName,
-	//		MessagingGroupMembers: memberEntries,
-	//	}
 	//	bav._setMessagingGroupKeyToMessagingGroupEntryMapping(&messagingGroupKey.OwnerPublicKey, messagingGroupEntry)
 	//	return messagingGroupEntry
 	//
@@ -320,30 +314,6 @@ func ReadMessageVersion(txn *MsgDeSoTxn) (_version uint8, _err error) {
 	return uint8(version), nil
 }
 
-func ValidateGroupPublicKeyAndName(messagingPublicKey, keyName []byte) error {
-	// This is a helper function that allows us to verify messaging public key and key name.
-
-	// First validate the messagingPublicKey.
-	if err := IsByteArrayValidPublicKey(messagingPublicKey); err != nil {
-		return errors.Wrapf(err, "ValidateGroupPublicKeyAndName: "+
-			"Problem validating sender's messaging key: %v", messagingPublicKey)
-	}
-
-	// If we get here, it means that we have a valid messaging public key.
-	// Sanity-check messaging key name.
-	if len(keyName) < MinMessagingKeyNameCharacters {
-		return errors.Wrapf(RuleErrorMessagingKeyNameTooShort, "ValidateGroupPublicKeyAndName: "+
-			"Too few characters in key name: min = %v, provided = %v",
-			MinMessagingKeyNameCharacters, len(keyName))
-	}
-	if len(keyName) > MaxMessagingKeyNameCharacters {
-		return errors.Wrapf(RuleErrorMessagingKeyNameTooLong, "ValidateGroupPublicKeyAndName: "+
-			"Too many characters in key name: max = %v; provided = %v",
-			MaxMessagingKeyNameCharacters, len(keyName))
-	}
-	return nil
-}
-
 // ValidateKeyAndNameWithUtxo validates public key and key name, which are used in DeSo V3 Messages protocol.
 // The function first checks that the key and name are valid and then fetches an entry from UtxoView or DB
 // to check if the key has been previously saved. This is particularly useful for connecting V3 messages.
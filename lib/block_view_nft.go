@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -462,6 +464,77 @@ func (bav *UtxoView) GetAllNFTBidEntries(nftPostHash *BlockHash, serialNumber ui
 	return nftBidEntries
 }
 
+// _isBetterNFTBid orders bids the same way DBGetBestBidsForNFTPostHash does: by bid amount,
+// with ties broken by the larger BidderPKID.
+func _isBetterNFTBid(bidEntry *NFTBidEntry, otherBidEntry *NFTBidEntry) bool {
+	if otherBidEntry == nil {
+		return true
+	}
+	if bidEntry.BidAmountNanos != otherBidEntry.BidAmountNanos {
+		return bidEntry.BidAmountNanos > otherBidEntry.BidAmountNanos
+	}
+	return bytes.Compare(bidEntry.BidderPKID[:], otherBidEntry.BidderPKID[:]) > 0
+}
+
+// GetBestBidsForNFTPostHash returns the highest bid for every serial number of an NFT post that
+// has at least one bid, ordered by serial number. Unlike DBGetBestBidsForNFTPostHash, bids that
+// were placed, updated, or canceled in the view are taken into account. At most limit entries
+// are returned; if limit is zero or negative, every serial number with a bid is returned.
+func (bav *UtxoView) GetBestBidsForNFTPostHash(nftPostHash *BlockHash, limit int) []*NFTBidEntry {
+	serialNumberToBestBidEntry := make(map[uint64]*NFTBidEntry)
+	considerBidEntry := func(bidEntry *NFTBidEntry) {
+		if _isBetterNFTBid(bidEntry, serialNumberToBestBidEntry[bidEntry.SerialNumber]) {
+			serialNumberToBestBidEntry[bidEntry.SerialNumber] = bidEntry
+		}
+	}
+
+	if bav.Postgres != nil {
+		// Postgres has no index across serial numbers so we check each one, including the
+		// serial number zero bids.
+		postEntry := bav.GetPostEntryForPostHash(nftPostHash)
+		if postEntry == nil {
+			return []*NFTBidEntry{}
+		}
+		for serialNumber := uint64(0); serialNumber <= postEntry.NumNFTCopies; serialNumber++ {
+			for _, bidEntry := range bav.GetAllNFTBidEntries(nftPostHash, serialNumber) {
+				considerBidEntry(bidEntry)
+			}
+		}
+	} else {
+		for _, dbBestBidEntry := range DBGetBestBidsForNFTPostHash(bav.Handle, nftPostHash, 0) {
+			// If the view has touched the best bid from the DB then it may have been updated or
+			// canceled, in which case the next best bid could be any bid on the serial number.
+			bidKey := MakeNFTBidKey(dbBestBidEntry.BidderPKID, dbBestBidEntry.NFTPostHash, dbBestBidEntry.SerialNumber)
+			if _, exists := bav.NFTBidKeyToNFTBidEntry[bidKey]; exists {
+				for _, bidEntry := range bav.GetAllNFTBidEntries(nftPostHash, dbBestBidEntry.SerialNumber) {
+					considerBidEntry(bidEntry)
+				}
+				continue
+			}
+			considerBidEntry(dbBestBidEntry)
+		}
+	}
+
+	// Then we loop over the view for anything we missed.
+	for _, nftBidEntry := range bav.NFTBidKeyToNFTBidEntry {
+		if !nftBidEntry.isDeleted && reflect.DeepEqual(nftBidEntry.NFTPostHash, nftPostHash) {
+			considerBidEntry(nftBidEntry)
+		}
+	}
+
+	bestBidEntries := []*NFTBidEntry{}
+	for _, bidEntry := range serialNumberToBestBidEntry {
+		bestBidEntries = append(bestBidEntries, bidEntry)
+	}
+	sort.Slice(bestBidEntries, func(ii, jj int) bool {
+		return bestBidEntries[ii].SerialNumber < bestBidEntries[jj].SerialNumber
+	})
+	if limit > 0 && len(bestBidEntries) > limit {
+		bestBidEntries = bestBidEntries[:limit]
+	}
+	return bestBidEntries
+}
+
 func (bav *UtxoView) _getBuyNowExtraData(txn *MsgDeSoTxn, blockHeight uint32) (
 	_isBuyNow bool, _buyNowPrice uint64, _err error) {
 
@@ -506,7 +579,7 @@ func (bav *UtxoView) extractAdditionalRoyaltyMap(
 			pkBytess := pkBytesIter
 
 			// Validate the public key
-			if _, err = btcec.ParsePubKey(pkBytess[:], btcec.S256()); err != nil {
+			if _, err = ParsePubKeyCached(pkBytess[:]); err != nil {
 				return nil, 0, errors.Wrapf(
 					RuleErrorAdditionalRoyaltyPubKeyMustBeValid,
 					"Error parsing public key: %v, %v", PkToStringBoth(pkBytess[:]), err)
@@ -538,6 +611,50 @@ func (bav *UtxoView) extractAdditionalRoyaltyMap(
 	return additionalRoyalties, additionalRoyaltiesBasisPoints, nil
 }
 
+// extractNFTOwnershipSharesMap converts the NFTOwnershipSharesMapKey in extraData to a map from
+// PKID to the basis points of the NFT that the PKID owns. It returns nil if the NFT isn't shared.
+func (bav *UtxoView) extractNFTOwnershipSharesMap(extraData map[string][]byte, blockHeight uint32) (
+	_ownershipSharesBasisPoints map[PKID]uint64, _err error) {
+
+	mapBytes, exists := extraData[NFTOwnershipSharesMapKey]
+	if !exists || blockHeight < bav.Params.ForkHeights.NFTOwnershipSharesBlockHeight {
+		return nil, nil
+	}
+	ownershipSharesByPubKey, err := DeserializePubKeyToUint64Map(mapBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "Problem reading bytes for ownership shares: ")
+	}
+
+	ownershipShares := make(map[PKID]uint64)
+	totalBasisPoints := uint64(0)
+	for pkBytesIter, bps := range ownershipSharesByPubKey {
+		// Make a copy of the iterator
+		pkBytess := pkBytesIter
+
+		if _, err = ParsePubKeyCached(pkBytess[:]); err != nil {
+			return nil, errors.Wrapf(RuleErrorNFTOwnershipSharePubKeyMustBeValid,
+				"Error parsing public key: %v, %v", PkToStringBoth(pkBytess[:]), err)
+		}
+		if bps == 0 {
+			return nil, errors.Wrapf(RuleErrorNFTOwnershipShareMustBeNonZero,
+				"Zero share for public key: %v", PkToStringBoth(pkBytess[:]))
+		}
+		pkid := bav.GetPKIDForPublicKey(pkBytess[:])
+		ownershipShares[*pkid.PKID] = bps
+
+		if totalBasisPoints > math.MaxUint64-bps {
+			return nil, errors.Wrapf(RuleErrorNFTOwnershipSharesOverflow,
+				"totalBasisPoints: %v, bps: %v", totalBasisPoints, bps)
+		}
+		totalBasisPoints += bps
+	}
+	if totalBasisPoints != 100*100 {
+		return nil, errors.Wrapf(RuleErrorNFTOwnershipSharesMustAddUpToOneHundredPct,
+			"totalBasisPoints: %v", totalBasisPoints)
+	}
+	return ownershipShares, nil
+}
+
 func (bav *UtxoView) _connectCreateNFT(
 	txn *MsgDeSoTxn, txHash *BlockHash, blockHeight uint32, verifySignatures bool) (
 	_totalInput uint64, _totalOutput uint64, _utxoOps []*UtxoOperation, _err error) {
@@ -573,6 +690,12 @@ func (bav *UtxoView) _connectCreateNFT(
 			"_connectCreateNFT: Problem extract additional Coin Royalties: ")
 	}
 
+	ownershipShares, err := bav.extractNFTOwnershipSharesMap(txn.ExtraData, blockHeight)
+	if err != nil {
+		return 0, 0, nil, errors.Wrap(err,
+			"_connectCreateNFT: Problem extracting ownership shares: ")
+	}
+
 	// Validate the txMeta.
 	if txMeta.NumCopies > bav.GlobalParamsEntry.MaxCopiesPerNFT {
 		return 0, 0, nil, RuleErrorTooManyNFTCopies
@@ -723,6 +846,8 @@ func (bav *UtxoView) _connectCreateNFT(
 			IsBuyNow:          isBuyNow,
 			BuyNowPriceNanos:  buyNowPrice,
 			ExtraData:         extraData,
+
+			OwnershipSharesBasisPoints: ownershipShares,
 		}
 		bav._setNFTEntryMappings(nftEntry)
 	}
@@ -850,6 +975,9 @@ func (bav *UtxoView) _connectUpdateNFT(
 		// We do this because you're not allowed to update the ExtraData on an
 		// NFTEntry.
 		ExtraData: prevNFTEntry.ExtraData,
+
+		// The ownership shares can't be updated either.
+		OwnershipSharesBasisPoints: prevNFTEntry.OwnershipSharesBasisPoints,
 	}
 	bav._setNFTEntryMappings(newNFTEntry)
 
@@ -1231,7 +1359,7 @@ func (bav *UtxoView) _helpConnectNFTSold(args HelpConnectNFTSoldStruct) (
 				return 0, nil, fmt.Errorf(
 					"_helpConnectNFTSold: invalid public key found for pkid in additional DESO royalty map")
 			}
-			if _, err = btcec.ParsePubKey(pkBytes, btcec.S256()); err != nil {
+			if _, err = ParsePubKeyCached(pkBytes); err != nil {
 				return 0, nil, errors.Wrapf(err, "Unable to parse public key")
 			}
 
@@ -1287,6 +1415,43 @@ func (bav *UtxoView) _helpConnectNFTSold(args HelpConnectNFTSoldStruct) (
 	bidAmountMinusRoyalties := args.BidAmountNanos - creatorRoyaltyNanos - creatorCoinRoyaltyNanos -
 		additionalCoinRoyaltyNanos - additionalDESORoyaltiesNanos
 
+	// If the NFT has ownership shares, the proceeds are split between its owners. Each owner other
+	// than the seller gets their share rounded down, and the seller gets the rest.
+	sellerProceedsNanos := bidAmountMinusRoyalties
+	var ownershipSharePayments []*PublicKeyRoyaltyPair
+	for pkidIter, bps := range prevNFTEntry.OwnershipSharesBasisPoints {
+		pkid := pkidIter
+		if pkid == *prevNFTEntry.OwnerPKID {
+			continue
+		}
+		shareNanos := IntDiv(
+			IntMul(
+				big.NewInt(int64(bidAmountMinusRoyalties)),
+				big.NewInt(int64(bps))),
+			big.NewInt(100*100)).Uint64()
+		if shareNanos > sellerProceedsNanos {
+			return 0, 0, nil, fmt.Errorf(
+				"_helpConnectNFTSold: ownership shares (%d) exceed the proceeds of the sale (%d)",
+				shareNanos, sellerProceedsNanos)
+		}
+		pkBytes := bav.GetPublicKeyForPKID(&pkid)
+		if len(pkBytes) != btcec.PubKeyBytesLenCompressed {
+			return 0, 0, nil, fmt.Errorf(
+				"_helpConnectNFTSold: invalid public key found for pkid in ownership shares map")
+		}
+		if shareNanos > 0 {
+			sellerProceedsNanos -= shareNanos
+			ownershipSharePayments = append(ownershipSharePayments, &PublicKeyRoyaltyPair{
+				PublicKey:          pkBytes,
+				RoyaltyAmountNanos: shareNanos,
+			})
+		}
+	}
+	// Like the royalties, the payments must be sorted so that every node creates the same UTXOs.
+	sort.Slice(ownershipSharePayments, func(ii, jj int) bool {
+		return bytes.Compare(ownershipSharePayments[ii].PublicKey, ownershipSharePayments[jj].PublicKey) < 0
+	})
+
 	if args.VerifySignatures {
 		// _connectBasicTransfer has already checked that the transaction is
 		// signed by the top-level public key, which we take to be the poster's
@@ -1302,7 +1467,8 @@ func (bav *UtxoView) _helpConnectNFTSold(args HelpConnectNFTSoldStruct) (
 	//  (6) Add creator coin royalties to deso locked.
 	//  (7) Decrement the nftPostEntry NumNFTCopiesForSale.
 
-	// (1) Set an appropriate NFTEntry for the new owner.
+	// (1) Set an appropriate NFTEntry for the new owner. The bidder owns all of the NFT, so
+	// any ownership shares are dropped.
 
 	newNFTEntry := &NFTEntry{
 		LastOwnerPKID:  prevNFTEntry.OwnerPKID,
@@ -1381,11 +1547,20 @@ func (bav *UtxoView) _helpConnectNFTSold(args HelpConnectNFTSoldStruct) (
 	}
 
 	// (3) Pay the seller by creating a new entry for this output and add it to the view.
-	if err = createUTXO(bidAmountMinusRoyalties, sellerPublicKey, UtxoTypeNFTSeller); err != nil {
+	if err = createUTXO(sellerProceedsNanos, sellerPublicKey, UtxoTypeNFTSeller); err != nil {
 		return 0, 0, nil, errors.Wrapf(
 			err, "_helpConnectNFTSold: Problem creating UTXO for seller: ")
 	}
 
+	// (3-a) Pay the other owners their shares of the NFT.
+	for _, ownershipSharePayment := range ownershipSharePayments {
+		if err = createUTXO(ownershipSharePayment.RoyaltyAmountNanos, ownershipSharePayment.PublicKey,
+			UtxoTypeNFTSeller); err != nil {
+			return 0, 0, nil, errors.Wrapf(
+				err, "_helpConnectNFTSold: Problem creating UTXO for ownership share: ")
+		}
+	}
+
 	// (4) Pay royalties to the original artist.
 	if creatorRoyaltyNanos > 0 {
 		if err = createUTXO(creatorRoyaltyNanos, nftPostEntry.PosterPublicKey, UtxoTypeNFTCreatorRoyalty); err != nil {
@@ -2024,6 +2199,11 @@ func (bav *UtxoView) _connectBurnNFT(
 		return 0, 0, nil, RuleErrorCannotBurnNFTThatIsForSale
 	}
 
+	// The owner can't burn the other owners' shares of the NFT.
+	if len(nftEntry.OwnershipSharesBasisPoints) > 0 {
+		return 0, 0, nil, RuleErrorCannotBurnNFTWithOwnershipShares
+	}
+
 	// Sanity check that the NFT entry is correct.
 	if !reflect.DeepEqual(nftEntry.NFTPostHash, txMeta.NFTPostHash) ||
 		!reflect.DeepEqual(nftEntry.SerialNumber, txMeta.SerialNumber) {
@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -7688,3 +7690,226 @@ func TestNFTSplitsHardcorePKIDBug(t *testing.T) {
 	_disconnectTestMetaTxnsFromViewAndFlush(testMeta)
 	_connectBlockThenDisconnectBlockAndFlush(testMeta)
 }
+
+func TestGetBestBidsForNFTPostHash(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	_ = assert
+	_ = require
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	nftPostHash := NewBlockHash(RandomBytes(HashSizeBytes))
+	otherPostHash := NewBlockHash(RandomBytes(HashSizeBytes))
+	m0PKID := NewPKID(m0PkBytes)
+	m1PKID := NewPKID(m1PkBytes)
+	m2PKID := NewPKID(m2PkBytes)
+
+	newBid := func(postHash *BlockHash, bidderPKID *PKID, serialNumber uint64, bidAmountNanos uint64) *NFTBidEntry {
+		return &NFTBidEntry{
+			BidderPKID:     bidderPKID,
+			NFTPostHash:    postHash,
+			SerialNumber:   serialNumber,
+			BidAmountNanos: bidAmountNanos,
+		}
+	}
+	bidSummaries := func(bidEntries []*NFTBidEntry) [][3]uint64 {
+		summaries := [][3]uint64{}
+		for _, bidEntry := range bidEntries {
+			require.Equal(*nftPostHash, *bidEntry.NFTPostHash)
+			bidder := uint64(0)
+			switch *bidEntry.BidderPKID {
+			case *m1PKID:
+				bidder = 1
+			case *m2PKID:
+				bidder = 2
+			}
+			summaries = append(summaries, [3]uint64{bidEntry.SerialNumber, bidEntry.BidAmountNanos, bidder})
+		}
+		return summaries
+	}
+
+	// Nothing to return before any bids are placed.
+	require.Empty(DBGetBestBidsForNFTPostHash(db, nftPostHash, 0))
+
+	// Serial number 2 has no bids and the other post's bids must not leak into the results.
+	for _, bidEntry := range []*NFTBidEntry{
+		newBid(nftPostHash, m0PKID, 0, 50),
+		newBid(nftPostHash, m0PKID, 1, 100),
+		newBid(nftPostHash, m1PKID, 1, 300),
+		newBid(nftPostHash, m2PKID, 1, 200),
+		newBid(nftPostHash, m0PKID, 3, math.MaxUint64),
+		newBid(nftPostHash, m1PKID, 3, 10),
+		newBid(nftPostHash, m0PKID, 4, 75),
+		newBid(otherPostHash, m0PKID, 2, 1000),
+	} {
+		require.NoError(DBPutNFTBidEntryMappings(db, chain.snapshot, bidEntry))
+	}
+
+	dbBestBids := [][3]uint64{{0, 50, 0}, {1, 300, 1}, {3, math.MaxUint64, 0}, {4, 75, 0}}
+	require.Equal(dbBestBids, bidSummaries(DBGetBestBidsForNFTPostHash(db, nftPostHash, 0)))
+	require.Equal(dbBestBids[:2], bidSummaries(DBGetBestBidsForNFTPostHash(db, nftPostHash, 2)))
+
+	// Without any changes in the view, the view matches the DB.
+	utxoView, err := NewUtxoView(db, params, nil, chain.snapshot)
+	require.NoError(err)
+	require.Equal(dbBestBids, bidSummaries(utxoView.GetBestBidsForNFTPostHash(nftPostHash, 0)))
+
+	// Cancel the best bid on serial number 1, lower the best bid on serial number 3, place a
+	// bid on serial number 2, and outbid the DB on serial number 4.
+	utxoView._deleteNFTBidEntryMappings(newBid(nftPostHash, m1PKID, 1, 300))
+	utxoView._setNFTBidEntryMappings(newBid(nftPostHash, m0PKID, 3, 5))
+	utxoView._setNFTBidEntryMappings(newBid(nftPostHash, m2PKID, 2, 20))
+	utxoView._setNFTBidEntryMappings(newBid(nftPostHash, m2PKID, 4, 80))
+
+	viewBestBids := [][3]uint64{{0, 50, 0}, {1, 200, 2}, {2, 20, 2}, {3, 10, 1}, {4, 80, 2}}
+	require.Equal(viewBestBids, bidSummaries(utxoView.GetBestBidsForNFTPostHash(nftPostHash, 0)))
+	require.Equal(viewBestBids[:3], bidSummaries(utxoView.GetBestBidsForNFTPostHash(nftPostHash, 3)))
+
+	// The DB is unaffected until the view is flushed.
+	require.Equal(dbBestBids, bidSummaries(DBGetBestBidsForNFTPostHash(db, nftPostHash, 0)))
+}
+
+func TestNFTOwnershipShares(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true /*isSender*/)
+	params.ExtraRegtestParamUpdaterKeys[MakePkMapKey(m4PkBytes)] = true
+	params.ForkHeights.NFTOwnershipSharesBlockHeight = uint32(0)
+
+	// NFTEntries only encode their ownership shares once the encoder migration is triggered.
+	prevGlobalDeSoParams := GlobalDeSoParams
+	defer func() {
+		GlobalDeSoParams = prevGlobalDeSoParams
+	}()
+	GlobalDeSoParams = *params
+	GlobalDeSoParams.EncoderMigrationHeightsList = GetEncoderMigrationHeightsList(&params.ForkHeights)
+
+	// Mine a few blocks to give the senderPkString some money.
+	for ii := 0; ii < 4; ii++ {
+		_, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
+		require.NoError(err)
+	}
+
+	testMeta := &TestMeta{
+		t:           t,
+		chain:       chain,
+		params:      params,
+		db:          db,
+		mempool:     mempool,
+		miner:       miner,
+		savedHeight: chain.blockTip().Height + 1,
+	}
+
+	_registerOrTransferWithTestMeta(testMeta, "", senderPkString, m0Pub, senderPrivString, 1000)
+	_registerOrTransferWithTestMeta(testMeta, "", senderPkString, m1Pub, senderPrivString, 1000)
+	_registerOrTransferWithTestMeta(testMeta, "", senderPkString, m2Pub, senderPrivString, 1000)
+	_registerOrTransferWithTestMeta(testMeta, "", senderPkString, m3Pub, senderPrivString, 1000)
+	_registerOrTransferWithTestMeta(testMeta, "", senderPkString, m4Pub, senderPrivString, 1000)
+
+	// Set max copies to a non-zero value to activate NFTs.
+	_updateGlobalParamsEntryWithTestMeta(testMeta, 10 /*FeeRateNanosPerKB*/, m4Pub, m4Priv,
+		-1, -1, -1, -1, 1000 /*maxCopiesPerNFT*/)
+
+	_submitPostWithTestMeta(testMeta, 10 /*feeRateNanosPerKB*/, m0Pub, m0Priv, []byte{}, []byte{},
+		&DeSoBodySchema{Body: "m0 post 1"}, []byte{}, 1502947011*1e9 /*tstampNanos*/, false /*isHidden*/)
+	post1Hash := testMeta.txns[len(testMeta.txns)-1].Hash()
+
+	_updateProfileWithTestMeta(testMeta, 10 /*feeRateNanosPerKB*/, m0Pub, m0Priv, []byte{}, "m0",
+		"i am the m0", shortPic, 10*100 /*newCreatorBasisPoints*/, 1.25*100*100, false /*isHidden*/)
+
+	ownershipSharesExtraData := func(ownershipShares map[PublicKey]uint64) map[string][]byte {
+		ownershipSharesBytes, err := SerializePubKeyToUint64Map(ownershipShares)
+		require.NoError(err)
+		return map[string][]byte{NFTOwnershipSharesMapKey: ownershipSharesBytes}
+	}
+	createNFT := func(ownershipShares map[PublicKey]uint64) error {
+		_, _, _, err := _createNFTWithExtraData(t, chain, db, params, 10, m0Pub, m0Priv, post1Hash,
+			2 /*numCopies*/, false /*hasUnlockable*/, true /*isForSale*/, 0, /*minBidAmountNanos*/
+			0 /*nftFee*/, 0 /*nftRoyaltyToCreatorBasisPoints*/, 0, /*nftRoyaltyToCoinBasisPoints*/
+			false /*isBuyNow*/, 0 /*buyNowPriceNanos*/, nil, nil, ownershipSharesExtraData(ownershipShares))
+		return err
+	}
+
+	// The shares must add up to 100%.
+	{
+		err := createNFT(map[PublicKey]uint64{
+			*NewPublicKey(m0PkBytes): 50 * 100,
+			*NewPublicKey(m1PkBytes): 40 * 100,
+		})
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorNFTOwnershipSharesMustAddUpToOneHundredPct)
+	}
+
+	// Every share must be non-zero.
+	{
+		err := createNFT(map[PublicKey]uint64{
+			*NewPublicKey(m0PkBytes): 100 * 100,
+			*NewPublicKey(m1PkBytes): 0,
+		})
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorNFTOwnershipShareMustBeNonZero)
+	}
+
+	// m0 mints an NFT that m1 owns 30% of and m2 owns 20% of.
+	_createNFTWithAdditionalRoyaltiesAndExtraDataWithTestMeta(testMeta, 10, m0Pub, m0Priv, post1Hash,
+		2 /*numCopies*/, false /*hasUnlockable*/, true /*isForSale*/, 0, /*minBidAmountNanos*/
+		0 /*nftFee*/, 0 /*nftRoyaltyToCreatorBasisPoints*/, 0, /*nftRoyaltyToCoinBasisPoints*/
+		false /*isBuyNow*/, 0 /*buyNowPriceNanos*/, nil, nil,
+		ownershipSharesExtraData(map[PublicKey]uint64{
+			*NewPublicKey(m0PkBytes): 50 * 100,
+			*NewPublicKey(m1PkBytes): 30 * 100,
+			*NewPublicKey(m2PkBytes): 20 * 100,
+		}))
+
+	m0PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m0PkBytes).PKID
+	m1PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m1PkBytes).PKID
+	m2PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m2PkBytes).PKID
+	expectedOwnershipShares := map[PKID]uint64{
+		*m0PKID: 50 * 100,
+		*m1PKID: 30 * 100,
+		*m2PKID: 20 * 100,
+	}
+	for _, serialNumber := range []uint64{1, 2} {
+		ownershipShares, err := DBGetNFTOwnershipSharesForSerial(db, chain.snapshot, post1Hash, serialNumber)
+		require.NoError(err)
+		require.Equal(expectedOwnershipShares, ownershipShares)
+		nftEntry := DBGetNFTEntryByPostHashSerialNumber(db, chain.snapshot, post1Hash, serialNumber)
+		require.Equal(m0PKID, nftEntry.OwnerPKID)
+		require.Equal(expectedOwnershipShares, nftEntry.OwnershipSharesBasisPoints)
+	}
+
+	// The owner can't burn the other owners' shares.
+	{
+		_updateNFTWithTestMeta(testMeta, 10, m0Pub, m0Priv, post1Hash, 2, /*serialNumber*/
+			false /*isForSale*/, 0 /*minBidAmountNanos*/, false /*isBuyNow*/, 0 /*buyNowPriceNanos*/)
+		_, _, _, err := _burnNFT(t, chain, db, params, 10, m0Pub, m0Priv, post1Hash, 2)
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorCannotBurnNFTWithOwnershipShares)
+	}
+
+	// When m0 accepts m3's bid, m1 and m2 get their shares of it.
+	{
+		_createNFTBidWithTestMeta(testMeta, 10, m3Pub, m3Priv, post1Hash, 1 /*serialNumber*/, 500)
+
+		m1BalanceBefore := _getBalance(t, chain, nil, m1Pub)
+		m2BalanceBefore := _getBalance(t, chain, nil, m2Pub)
+		_acceptNFTBidWithTestMeta(testMeta, 10, m0Pub, m0Priv, post1Hash, 1, /*serialNumber*/
+			m3Pub, 500 /*bidAmountNanos*/, "")
+		require.Equal(m1BalanceBefore+150, _getBalance(t, chain, nil, m1Pub))
+		require.Equal(m2BalanceBefore+100, _getBalance(t, chain, nil, m2Pub))
+
+		// m3 owns all of serial 1 now, while the shares of serial 2 are unchanged.
+		nftEntry := DBGetNFTEntryByPostHashSerialNumber(db, chain.snapshot, post1Hash, 1)
+		require.Equal(DBGetPKIDEntryForPublicKey(db, chain.snapshot, m3PkBytes).PKID, nftEntry.OwnerPKID)
+		require.Empty(nftEntry.OwnershipSharesBasisPoints)
+		ownershipShares, err := DBGetNFTOwnershipSharesForSerial(db, chain.snapshot, post1Hash, 1)
+		require.NoError(err)
+		require.Empty(ownershipShares)
+		ownershipShares, err = DBGetNFTOwnershipSharesForSerial(db, chain.snapshot, post1Hash, 2)
+		require.NoError(err)
+		require.Equal(expectedOwnershipShares, ownershipShares)
+	}
+
+	_executeAllTestRollbackAndFlush(testMeta)
+}
@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -0,0 +1,317 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"sort"
+)
+
+func (bav *UtxoView) _getPostAssociationEntry(
+	associationType []byte, transactorPKID *PKID, targetPostHash *BlockHash) *PostAssociationEntry {
+
+	// If an entry exists in the in-memory map, return the value of that mapping.
+	associationKey := MakePostAssociationKey(associationType, transactorPKID, targetPostHash)
+	mapValue, existsMapValue := bav.PostAssociationKeyToPostAssociationEntry[associationKey]
+	if existsMapValue {
+		return mapValue
+	}
+
+	// If we get here it means no value exists in our in-memory map. In this case,
+	// defer to the db. If a mapping exists in the db, return it. If not, return
+	// nil. Either way, save the value to the in-memory view mapping got later.
+	// Like DAO coin limit orders, post associations are always stored in badger.
+	dbAssociationEntry := DBGetPostAssociationEntry(
+		bav.Handle, bav.Snapshot, associationType, transactorPKID, targetPostHash)
+	if dbAssociationEntry != nil {
+		bav._setPostAssociationEntryMappings(dbAssociationEntry)
+	}
+	return dbAssociationEntry
+}
+
+func (bav *UtxoView) _setPostAssociationEntryMappings(associationEntry *PostAssociationEntry) {
+	// This function shouldn't be called with nil.
+	if associationEntry == nil {
+		glog.Errorf("_setPostAssociationEntryMappings: Called with nil PostAssociationEntry; " +
+			"this should never happen.")
+		return
+	}
+
+	bav.PostAssociationKeyToPostAssociationEntry[associationEntry.ToMapKey()] = associationEntry
+}
+
+func (bav *UtxoView) _deletePostAssociationEntryMappings(associationEntry *PostAssociationEntry) {
+
+	// Create a tombstone entry.
+	tombstoneAssociationEntry := *associationEntry
+	tombstoneAssociationEntry.isDeleted = true
+
+	// Set the mappings to point to the tombstone entry.
+	bav._setPostAssociationEntryMappings(&tombstoneAssociationEntry)
+}
+
+// GetPostAssociationEntry returns the transactor's association of the given type with the
+// post, or nil if there isn't one.
+func (bav *UtxoView) GetPostAssociationEntry(
+	associationType []byte, transactorPKID *PKID, targetPostHash *BlockHash) *PostAssociationEntry {
+
+	associationEntry := bav._getPostAssociationEntry(associationType, transactorPKID, targetPostHash)
+	if associationEntry == nil || associationEntry.isDeleted {
+		return nil
+	}
+	return associationEntry
+}
+
+// GetPostAssociationsByTransactor returns up to limit of the transactor's associations of the
+// given type, sorted by post hash, starting at startPostHash if it's set. The association with
+// startPostHash is included if it exists. A limit of zero returns all of them.
+func (bav *UtxoView) GetPostAssociationsByTransactor(associationType []byte,
+	transactorPKID *PKID, startPostHash *BlockHash, limit int) ([]*PostAssociationEntry, error) {
+
+	var startSortKey []byte
+	if startPostHash != nil {
+		startSortKey = startPostHash[:]
+	}
+	associationEntries, err := bav._getPostAssociationsPage(
+		func(associationEntry *PostAssociationEntry) bool {
+			return bytes.Equal(associationEntry.AssociationType, associationType) &&
+				associationEntry.TransactorPKID.Eq(transactorPKID)
+		},
+		func(associationEntry *PostAssociationEntry) []byte {
+			return associationEntry.TargetPostHash[:]
+		},
+		startSortKey, limit,
+		func(numToFetch int) ([]*PostAssociationEntry, error) {
+			return DBGetPostAssociationsByTransactor(
+				bav.Handle, associationType, transactorPKID, startPostHash, numToFetch)
+		})
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetPostAssociationsByTransactor: ")
+	}
+	return associationEntries, nil
+}
+
+// GetPostAssociationsForPost returns up to limit of the post's associations of the given type,
+// sorted by transactor PKID, starting at startTransactorPKID if it's set. The association with
+// startTransactorPKID is included if it exists. A limit of zero returns all of them.
+func (bav *UtxoView) GetPostAssociationsForPost(associationType []byte,
+	targetPostHash *BlockHash, startTransactorPKID *PKID, limit int) ([]*PostAssociationEntry, error) {
+
+	var startSortKey []byte
+	if startTransactorPKID != nil {
+		startSortKey = startTransactorPKID[:]
+	}
+	associationEntries, err := bav._getPostAssociationsPage(
+		func(associationEntry *PostAssociationEntry) bool {
+			return bytes.Equal(associationEntry.AssociationType, associationType) &&
+				associationEntry.TargetPostHash.IsEqual(targetPostHash)
+		},
+		func(associationEntry *PostAssociationEntry) []byte {
+			return associationEntry.TransactorPKID[:]
+		},
+		startSortKey, limit,
+		func(numToFetch int) ([]*PostAssociationEntry, error) {
+			return DBGetPostAssociationsByPostHash(
+				bav.Handle, associationType, targetPostHash, startTransactorPKID, numToFetch)
+		})
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetPostAssociationsForPost: ")
+	}
+	return associationEntries, nil
+}
+
+// _getPostAssociationsPage merges the associations in the view into a page of the associations
+// in the db. An entry in the view can hide at most one entry in the db, by replacing or deleting
+// it, so fetching the limit plus the number of entries in the view from the db always fills the
+// page.
+func (bav *UtxoView) _getPostAssociationsPage(
+	isInPage func(*PostAssociationEntry) bool,
+	sortKey func(*PostAssociationEntry) []byte,
+	startSortKey []byte,
+	limit int,
+	getDBEntries func(numToFetch int) ([]*PostAssociationEntry, error),
+) ([]*PostAssociationEntry, error) {
+
+	var viewEntries []*PostAssociationEntry
+	for _, associationEntry := range bav.PostAssociationKeyToPostAssociationEntry {
+		if isInPage(associationEntry) && bytes.Compare(sortKey(associationEntry), startSortKey) >= 0 {
+			viewEntries = append(viewEntries, associationEntry)
+		}
+	}
+
+	numToFetch := 0
+	if limit > 0 {
+		numToFetch = limit + len(viewEntries)
+	}
+	dbEntries, err := getDBEntries(numToFetch)
+	if err != nil {
+		return nil, err
+	}
+
+	// The entries in the view take precedence over the ones in the db.
+	associationEntries := []*PostAssociationEntry{}
+	for _, dbEntry := range dbEntries {
+		if _, existsMapValue := bav.PostAssociationKeyToPostAssociationEntry[dbEntry.ToMapKey()]; !existsMapValue {
+			associationEntries = append(associationEntries, dbEntry)
+		}
+	}
+	for _, viewEntry := range viewEntries {
+		if !viewEntry.isDeleted {
+			associationEntries = append(associationEntries, viewEntry)
+		}
+	}
+
+	sort.Slice(associationEntries, func(ii, jj int) bool {
+		return bytes.Compare(sortKey(associationEntries[ii]), sortKey(associationEntries[jj])) < 0
+	})
+	if limit > 0 && len(associationEntries) > limit {
+		associationEntries = associationEntries[:limit]
+	}
+	return associationEntries, nil
+}
+
+func (bav *UtxoView) _connectPostAssociation(
+	txn *MsgDeSoTxn, txHash *BlockHash, blockHeight uint32, verifySignatures bool) (
+	_totalInput uint64, _totalOutput uint64, _utxoOps []*UtxoOperation, _err error) {
+
+	// Post associations are only allowed after the PostAssociationBlockHeight.
+	if blockHeight < bav.Params.ForkHeights.PostAssociationBlockHeight {
+		return 0, 0, nil, errors.Wrapf(RuleErrorPostAssociationBeforeBlockHeight,
+			"_connectPostAssociation: ")
+	}
+
+	// Check that the transaction has the right TxnType.
+	if txn.TxnMeta.GetTxnType() != TxnTypePostAssociation {
+		return 0, 0, nil, fmt.Errorf("_connectPostAssociation: called with bad TxnType %s",
+			txn.TxnMeta.GetTxnType().String())
+	}
+	txMeta := txn.TxnMeta.(*PostAssociationMetadata)
+
+	// Connect basic txn to get the total input and the total output without
+	// considering the transaction metadata.
+	totalInput, totalOutput, utxoOpsForTxn, err := bav._connectBasicTransfer(
+		txn, txHash, blockHeight, verifySignatures)
+	if err != nil {
+		return 0, 0, nil, errors.Wrapf(err, "_connectPostAssociation: ")
+	}
+
+	// At this point the inputs and outputs have been processed. Now we need to handle
+	// the metadata.
+	if len(txMeta.AssociationType) == 0 || len(txMeta.AssociationType) > MaxPostAssociationTypeLengthBytes {
+		return 0, 0, nil, errors.Wrapf(RuleErrorPostAssociationInvalidType,
+			"_connectPostAssociation: AssociationType has length %d, must be between 1 and %d",
+			len(txMeta.AssociationType), MaxPostAssociationTypeLengthBytes)
+	}
+	if len(txMeta.AssociationValue) > MaxPostAssociationValueLengthBytes {
+		return 0, 0, nil, errors.Wrapf(RuleErrorPostAssociationValueTooLong,
+			"_connectPostAssociation: AssociationValue has length %d > %d",
+			len(txMeta.AssociationValue), MaxPostAssociationValueLengthBytes)
+	}
+
+	// Check that the post to associate with actually exists.
+	existingPostEntry := bav.GetPostEntryForPostHash(txMeta.TargetPostHash)
+	if existingPostEntry == nil || existingPostEntry.isDeleted {
+		return 0, 0, nil, errors.Wrapf(RuleErrorPostAssociationOnNonexistentPost,
+			"_connectPostAssociation: Post hash: %v", txMeta.TargetPostHash)
+	}
+
+	transactorPKID := bav.GetPKIDForPublicKey(txn.PublicKey)
+	if transactorPKID == nil || transactorPKID.isDeleted {
+		return 0, 0, nil, fmt.Errorf("_connectPostAssociation: transactorPKID was nil or deleted; " +
+			"this should never happen")
+	}
+
+	// At this point the code diverges and considers the create / delete flows differently
+	// since the presence of an existing association has a different effect in either case.
+	existingAssociationEntry := bav.GetPostAssociationEntry(
+		txMeta.AssociationType, transactorPKID.PKID, txMeta.TargetPostHash)
+	if txMeta.IsDelete {
+		if len(txMeta.AssociationValue) != 0 {
+			return 0, 0, nil, errors.Wrapf(RuleErrorPostAssociationDeleteWithValue,
+				"_connectPostAssociation: ")
+		}
+		// Ensure that there *is* an existing association to delete.
+		if existingAssociationEntry == nil {
+			return 0, 0, nil, errors.Wrapf(RuleErrorCannotDeleteNonexistentPostAssociation,
+				"_connectPostAssociation: Type: %v, Post hash: %v",
+				string(txMeta.AssociationType), txMeta.TargetPostHash)
+		}
+		bav._deletePostAssociationEntryMappings(existingAssociationEntry)
+	} else {
+		// Creating an association replaces the existing one, if there is one.
+		bav._setPostAssociationEntryMappings(&PostAssociationEntry{
+			AssociationType:  txMeta.AssociationType,
+			TransactorPKID:   transactorPKID.PKID,
+			TargetPostHash:   txMeta.TargetPostHash,
+			AssociationValue: txMeta.AssociationValue,
+		})
+	}
+
+	// Add an operation to the list at the end indicating we've changed an association.
+	utxoOpsForTxn = append(utxoOpsForTxn, &UtxoOperation{
+		Type:                     OperationTypePostAssociation,
+		PrevPostAssociationEntry: existingAssociationEntry,
+	})
+
+	return totalInput, totalOutput, utxoOpsForTxn, nil
+}
+
+func (bav *UtxoView) _disconnectPostAssociation(
+	operationType OperationType, currentTxn *MsgDeSoTxn, txnHash *BlockHash,
+	utxoOpsForTxn []*UtxoOperation, blockHeight uint32) error {
+
+	// Verify that the last operation is a PostAssociation operation
+	if len(utxoOpsForTxn) == 0 {
+		return fmt.Errorf("_disconnectPostAssociation: utxoOperations are missing")
+	}
+	operationIndex := len(utxoOpsForTxn) - 1
+	if utxoOpsForTxn[operationIndex].Type != OperationTypePostAssociation {
+		return fmt.Errorf("_disconnectPostAssociation: Trying to revert "+
+			"OperationTypePostAssociation but found type %v",
+			utxoOpsForTxn[operationIndex].Type)
+	}
+
+	// Now we know the txMeta is a PostAssociation
+	txMeta := currentTxn.TxnMeta.(*PostAssociationMetadata)
+	transactorPKID := bav.GetPKIDForPublicKey(currentTxn.PublicKey)
+	if transactorPKID == nil || transactorPKID.isDeleted {
+		return fmt.Errorf("_disconnectPostAssociation: transactorPKID was nil or deleted; " +
+			"this should never happen")
+	}
+
+	prevAssociationEntry := utxoOpsForTxn[operationIndex].PrevPostAssociationEntry
+	if prevAssociationEntry != nil {
+		// Sanity check: verify that the previous association is the one the txn changed.
+		if prevAssociationEntry.ToMapKey() != MakePostAssociationKey(
+			txMeta.AssociationType, transactorPKID.PKID, txMeta.TargetPostHash) {
+
+			return fmt.Errorf("_disconnectPostAssociation: PrevPostAssociationEntry %v "+
+				"doesn't match the txn's association", prevAssociationEntry.ToMapKey())
+		}
+
+		// Set the association back to its previous state, whether it was replaced or deleted.
+		bav._setPostAssociationEntryMappings(prevAssociationEntry)
+	} else {
+		// If there was no previous association then the txn created one, which we delete.
+		if txMeta.IsDelete {
+			return fmt.Errorf("_disconnectPostAssociation: PrevPostAssociationEntry is " +
+				"missing for a txn that deleted an association")
+		}
+		associationEntry := bav.GetPostAssociationEntry(
+			txMeta.AssociationType, transactorPKID.PKID, txMeta.TargetPostHash)
+		if associationEntry == nil {
+			return fmt.Errorf("_disconnectPostAssociation: PostAssociationEntry for "+
+				"type %v and post %v was found to be nil or deleted",
+				string(txMeta.AssociationType), txMeta.TargetPostHash)
+		}
+		bav._deletePostAssociationEntryMappings(associationEntry)
+	}
+
+	// Now revert the basic transfer with the remaining operations. Cut off
+	// the PostAssociation operation at the end since we just reverted it.
+	return bav._disconnectBasicTransfer(
+		currentTxn, txnHash, utxoOpsForTxn[:operationIndex], blockHeight)
+}
@@ -0,0 +1,323 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"bytes"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func _doPostAssociationTxnWithTestMeta(
+	testMeta *TestMeta,
+	feeRateNanosPerKB uint64,
+	TransactorPublicKeyBase58Check string,
+	TransactorPrivateKeyBase58Check string,
+	metadata PostAssociationMetadata) {
+
+	testMeta.expectedSenderBalances = append(
+		testMeta.expectedSenderBalances, _getBalance(testMeta.t, testMeta.chain, nil, TransactorPublicKeyBase58Check))
+
+	currentOps, currentTxn, _, err := _doPostAssociationTxn(testMeta.t, testMeta.chain, testMeta.db, testMeta.params,
+		feeRateNanosPerKB, TransactorPublicKeyBase58Check, TransactorPrivateKeyBase58Check, metadata)
+
+	require.NoError(testMeta.t, err)
+	testMeta.txnOps = append(testMeta.txnOps, currentOps)
+	testMeta.txns = append(testMeta.txns, currentTxn)
+}
+
+func _doPostAssociationTxn(t *testing.T, chain *Blockchain, db *badger.DB,
+	params *DeSoParams, feeRateNanosPerKB uint64,
+	TransactorPublicKeyBase58Check string,
+	TransactorPrivateKeyBase58Check string,
+	metadata PostAssociationMetadata) (
+	_utxoOps []*UtxoOperation, _txn *MsgDeSoTxn, _height uint32, _err error) {
+
+	require := require.New(t)
+
+	transactorPkBytes, _, err := Base58CheckDecode(TransactorPublicKeyBase58Check)
+	require.NoError(err)
+
+	utxoView, err := NewUtxoView(db, params, chain.postgres, chain.snapshot)
+	require.NoError(err)
+
+	txn, totalInputMake, changeAmountMake, feesMake, err := chain.CreatePostAssociationTxn(
+		transactorPkBytes, *metadata.TargetPostHash, metadata.AssociationType, metadata.AssociationValue,
+		metadata.IsDelete, feeRateNanosPerKB, nil, []*DeSoOutput{})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	require.Equal(totalInputMake, changeAmountMake+feesMake)
+
+	// Sign the transaction now that its inputs are set up.
+	_signTxn(t, txn, TransactorPrivateKeyBase58Check)
+
+	txHash := txn.Hash()
+	// Always use height+1 for validation since it's assumed the transaction will
+	// get mined into the next block.
+	blockHeight := chain.blockTip().Height + 1
+	utxoOps, totalInput, totalOutput, fees, err :=
+		utxoView.ConnectTransaction(txn, txHash, getTxnSize(*txn), blockHeight, true, /*verifySignature*/
+			false /*ignoreUtxos*/)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	require.Equal(totalInput, totalOutput+fees)
+	require.Equal(totalInput, totalInputMake)
+
+	// We should have one SPEND UtxoOperation for each input, one ADD operation
+	// for each output, and one OperationTypePostAssociation operation at the end.
+	require.Equal(len(txn.TxInputs)+len(txn.TxOutputs)+1, len(utxoOps))
+	for ii := 0; ii < len(txn.TxInputs); ii++ {
+		require.Equal(OperationTypeSpendUtxo, utxoOps[ii].Type)
+	}
+	require.Equal(OperationTypePostAssociation, utxoOps[len(utxoOps)-1].Type)
+
+	require.NoError(utxoView.FlushToDb(uint64(blockHeight)))
+
+	return utxoOps, txn, blockHeight, nil
+}
+
+func TestPostAssociation(t *testing.T) {
+	// -----------------------
+	// Initialization
+	// -----------------------
+
+	// Test constants
+	const feeRateNanosPerKb = uint64(101)
+	reactionType := []byte("REACTION")
+	tagType := []byte("TAG")
+
+	// Initialize test chain and miner.
+	require := require.New(t)
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+
+	params.ForkHeights.PostAssociationBlockHeight = uint32(math.MaxUint32)
+
+	// Mine a few blocks to give the senderPkString some money.
+	for ii := 0; ii < 4; ii++ {
+		_, err := miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+	}
+
+	// We build the testMeta obj after mining blocks so that we save the correct block height.
+	testMeta := &TestMeta{
+		t:           t,
+		chain:       chain,
+		params:      params,
+		db:          db,
+		mempool:     mempool,
+		miner:       miner,
+		savedHeight: chain.blockTip().Height + 1,
+	}
+
+	_registerOrTransferWithTestMeta(testMeta, "m0", senderPkString, m0Pub, senderPrivString, 1000)
+	_registerOrTransferWithTestMeta(testMeta, "m1", senderPkString, m1Pub, senderPrivString, 1000)
+	_registerOrTransferWithTestMeta(testMeta, "m2", senderPkString, m2Pub, senderPrivString, 1000)
+
+	m1PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m1PkBytes).PKID
+	m2PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m2PkBytes).PKID
+
+	submitPost := func(body string) *BlockHash {
+		_submitPostWithTestMeta(
+			testMeta,
+			feeRateNanosPerKb,           /*feeRateNanosPerKB*/
+			m0Pub,                       /*updaterPkBase58Check*/
+			m0Priv,                      /*updaterPrivBase58Check*/
+			[]byte{},                    /*postHashToModify*/
+			[]byte{},                    /*parentStakeID*/
+			&DeSoBodySchema{Body: body}, /*body*/
+			[]byte{},                    /*repostedPostHash*/
+			1502947011*1e9,              /*tstampNanos*/
+			false /*isHidden*/)
+		return testMeta.txns[len(testMeta.txns)-1].Hash()
+	}
+	post1Hash := submitPost("m0 post 1")
+	post2Hash := submitPost("m0 post 2")
+
+	associationMetadata := func(associationType []byte, postHash *BlockHash, value string) PostAssociationMetadata {
+		return PostAssociationMetadata{
+			TargetPostHash:   postHash,
+			AssociationType:  associationType,
+			AssociationValue: []byte(value),
+		}
+	}
+	deleteMetadata := func(associationType []byte, postHash *BlockHash) PostAssociationMetadata {
+		return PostAssociationMetadata{
+			TargetPostHash:  postHash,
+			AssociationType: associationType,
+			IsDelete:        true,
+		}
+	}
+	getAssociationValue := func(associationType []byte, transactorPKID *PKID, postHash *BlockHash) []byte {
+		associationEntry := DBGetPostAssociationEntry(db, chain.snapshot, associationType, transactorPKID, postHash)
+		if associationEntry == nil {
+			return nil
+		}
+		return associationEntry.AssociationValue
+	}
+
+	// -----------------------
+	// Tests
+	// -----------------------
+
+	// The metadata survives encoding.
+	{
+		metadata := associationMetadata(reactionType, post1Hash, "LIKE")
+		metadataBytes, err := metadata.ToBytes(false)
+		require.NoError(err)
+		decodedMetadata := &PostAssociationMetadata{}
+		require.NoError(decodedMetadata.FromBytes(metadataBytes))
+		require.Equal(&metadata, decodedMetadata)
+	}
+
+	// RuleErrorPostAssociationBeforeBlockHeight
+	{
+		_, _, _, err := _doPostAssociationTxn(t, chain, db, params, feeRateNanosPerKb, m1Pub, m1Priv,
+			associationMetadata(reactionType, post1Hash, "LIKE"))
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorPostAssociationBeforeBlockHeight)
+	}
+
+	params.ForkHeights.PostAssociationBlockHeight = uint32(0)
+
+	// Invalid associations are rejected.
+	{
+		_, _, _, err := _doPostAssociationTxn(t, chain, db, params, feeRateNanosPerKb, m1Pub, m1Priv,
+			associationMetadata([]byte{}, post1Hash, "LIKE"))
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorPostAssociationInvalidType)
+
+		_, _, _, err = _doPostAssociationTxn(t, chain, db, params, feeRateNanosPerKb, m1Pub, m1Priv,
+			associationMetadata(reactionType, post1Hash, string(make([]byte, MaxPostAssociationValueLengthBytes+1))))
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorPostAssociationValueTooLong)
+
+		_, _, _, err = _doPostAssociationTxn(t, chain, db, params, feeRateNanosPerKb, m1Pub, m1Priv,
+			associationMetadata(reactionType, NewBlockHash(RandomBytes(HashSizeBytes)), "LIKE"))
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorPostAssociationOnNonexistentPost)
+
+		_, _, _, err = _doPostAssociationTxn(t, chain, db, params, feeRateNanosPerKb, m1Pub, m1Priv,
+			deleteMetadata(reactionType, post1Hash))
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorCannotDeleteNonexistentPostAssociation)
+	}
+
+	// m1 and m2 react to m0's posts, and m1 tags one of them.
+	_doPostAssociationTxnWithTestMeta(testMeta, feeRateNanosPerKb, m1Pub, m1Priv,
+		associationMetadata(reactionType, post1Hash, "LIKE"))
+	_doPostAssociationTxnWithTestMeta(testMeta, feeRateNanosPerKb, m2Pub, m2Priv,
+		associationMetadata(reactionType, post1Hash, "LOVE"))
+	_doPostAssociationTxnWithTestMeta(testMeta, feeRateNanosPerKb, m1Pub, m1Priv,
+		associationMetadata(reactionType, post2Hash, "LAUGH"))
+	_doPostAssociationTxnWithTestMeta(testMeta, feeRateNanosPerKb, m1Pub, m1Priv,
+		associationMetadata(tagType, post1Hash, "funny"))
+	{
+		require.Equal([]byte("LIKE"), getAssociationValue(reactionType, m1PKID, post1Hash))
+		require.Equal([]byte("LOVE"), getAssociationValue(reactionType, m2PKID, post1Hash))
+		require.Equal([]byte("LAUGH"), getAssociationValue(reactionType, m1PKID, post2Hash))
+		require.Equal([]byte("funny"), getAssociationValue(tagType, m1PKID, post1Hash))
+	}
+
+	// m1 changes their reaction, and m2 removes theirs.
+	{
+		_, _, _, err := _doPostAssociationTxn(t, chain, db, params, feeRateNanosPerKb, m2Pub, m2Priv,
+			PostAssociationMetadata{
+				TargetPostHash:   post1Hash,
+				AssociationType:  reactionType,
+				AssociationValue: []byte("LOVE"),
+				IsDelete:         true,
+			})
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorPostAssociationDeleteWithValue)
+
+		_doPostAssociationTxnWithTestMeta(testMeta, feeRateNanosPerKb, m1Pub, m1Priv,
+			associationMetadata(reactionType, post1Hash, "LAUGH"))
+		_doPostAssociationTxnWithTestMeta(testMeta, feeRateNanosPerKb, m2Pub, m2Priv,
+			deleteMetadata(reactionType, post1Hash))
+		require.Equal([]byte("LAUGH"), getAssociationValue(reactionType, m1PKID, post1Hash))
+		require.Nil(getAssociationValue(reactionType, m2PKID, post1Hash))
+	}
+
+	// m2 reacts again so that post1 has two reactions to page through.
+	_doPostAssociationTxnWithTestMeta(testMeta, feeRateNanosPerKb, m2Pub, m2Priv,
+		associationMetadata(reactionType, post1Hash, "LIKE"))
+
+	// Associations can be paged through by transactor and by post.
+	{
+		firstPKID, secondPKID := m1PKID, m2PKID
+		if bytes.Compare(firstPKID[:], secondPKID[:]) > 0 {
+			firstPKID, secondPKID = secondPKID, firstPKID
+		}
+		firstPostHash, secondPostHash := post1Hash, post2Hash
+		if bytes.Compare(firstPostHash[:], secondPostHash[:]) > 0 {
+			firstPostHash, secondPostHash = secondPostHash, firstPostHash
+		}
+		getTransactorPKIDs := func(associationEntries []*PostAssociationEntry) []PKID {
+			var transactorPKIDs []PKID
+			for _, associationEntry := range associationEntries {
+				transactorPKIDs = append(transactorPKIDs, *associationEntry.TransactorPKID)
+			}
+			return transactorPKIDs
+		}
+		getPostHashes := func(associationEntries []*PostAssociationEntry) []BlockHash {
+			var postHashes []BlockHash
+			for _, associationEntry := range associationEntries {
+				postHashes = append(postHashes, *associationEntry.TargetPostHash)
+			}
+			return postHashes
+		}
+
+		associationEntries, err := DBGetPostAssociationsByPostHash(db, reactionType, post1Hash, nil, 0)
+		require.NoError(err)
+		require.Equal([]PKID{*firstPKID, *secondPKID}, getTransactorPKIDs(associationEntries))
+		associationEntries, err = DBGetPostAssociationsByPostHash(db, reactionType, post1Hash, nil, 1)
+		require.NoError(err)
+		require.Equal([]PKID{*firstPKID}, getTransactorPKIDs(associationEntries))
+		associationEntries, err = DBGetPostAssociationsByPostHash(db, reactionType, post1Hash, secondPKID, 1)
+		require.NoError(err)
+		require.Equal([]PKID{*secondPKID}, getTransactorPKIDs(associationEntries))
+
+		associationEntries, err = DBGetPostAssociationsByTransactor(db, reactionType, m1PKID, nil, 0)
+		require.NoError(err)
+		require.Equal([]BlockHash{*firstPostHash, *secondPostHash}, getPostHashes(associationEntries))
+		associationEntries, err = DBGetPostAssociationsByTransactor(db, reactionType, m1PKID, secondPostHash, 0)
+		require.NoError(err)
+		require.Equal([]BlockHash{*secondPostHash}, getPostHashes(associationEntries))
+		associationEntries, err = DBGetPostAssociationsByTransactor(db, tagType, m1PKID, nil, 0)
+		require.NoError(err)
+		require.Equal([]BlockHash{*post1Hash}, getPostHashes(associationEntries))
+
+		// Changes in the view take precedence over the db.
+		utxoView, err := NewUtxoView(db, params, chain.postgres, chain.snapshot)
+		require.NoError(err)
+		firstAssociationEntry := utxoView.GetPostAssociationEntry(reactionType, firstPKID, post1Hash)
+		require.NotNil(firstAssociationEntry)
+		utxoView._deletePostAssociationEntryMappings(firstAssociationEntry)
+		associationEntries, err = utxoView.GetPostAssociationsForPost(reactionType, post1Hash, nil, 1)
+		require.NoError(err)
+		require.Equal([]PKID{*secondPKID}, getTransactorPKIDs(associationEntries))
+		associationEntries, err = utxoView.GetPostAssociationsByTransactor(reactionType, firstPKID, nil, 0)
+		require.NoError(err)
+		for _, associationEntry := range associationEntries {
+			require.NotEqual(*post1Hash, *associationEntry.TargetPostHash)
+		}
+	}
+
+	_executeAllTestRollbackAndFlush(testMeta)
+
+	// Every association is gone once the txns are rolled back.
+	{
+		associationEntries, err := DBGetPostAssociationsByPostHash(db, reactionType, post1Hash, nil, 0)
+		require.NoError(err)
+		require.Empty(associationEntries)
+		associationEntries, err = DBGetPostAssociationsByTransactor(db, tagType, m1PKID, nil, 0)
+		require.NoError(err)
+		require.Empty(associationEntries)
+	}
+}
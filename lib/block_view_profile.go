@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -158,7 +160,7 @@ func (bav *UtxoView) GetProfileEntryForUsername(nonLowercaseUsername []byte) *Pr
 	} else {
 		dbProfileEntry := DBGetProfileEntryForUsername(bav.Handle, bav.Snapshot, nonLowercaseUsername)
 		if dbProfileEntry != nil {
-			bav._setProfileEntryMappings(dbProfileEntry)
+			bav._cacheProfileEntry(dbProfileEntry)
 		}
 		return dbProfileEntry
 	}
@@ -303,7 +305,7 @@ func (bav *UtxoView) GetProfileEntryForPKID(pkid *PKID) *ProfileEntry {
 	} else {
 		dbProfileEntry := DBGetProfileEntryForPKID(bav.Handle, bav.Snapshot, pkid)
 		if dbProfileEntry != nil {
-			bav._setProfileEntryMappings(dbProfileEntry)
+			bav._cacheProfileEntry(dbProfileEntry)
 		}
 		return dbProfileEntry
 	}
@@ -317,6 +319,13 @@ func (bav *UtxoView) _setProfileEntryMappings(profileEntry *ProfileEntry) {
 		return
 	}
 
+	pkid := bav._cacheProfileEntry(profileEntry)
+	bav.dirtyProfilePKIDs[*pkid] = true
+}
+
+// _cacheProfileEntry adds the profile to the view, and returns its PKID. Unlike _setProfileEntryMappings,
+// the profile isn't marked dirty, so it's for profiles read from the db.
+func (bav *UtxoView) _cacheProfileEntry(profileEntry *ProfileEntry) *PKID {
 	// Look up the current PKID for the profile. Never nil because we create the entry if it doesn't exist
 	pkidEntry := bav.GetPKIDForPublicKey(profileEntry.PublicKey)
 
@@ -324,6 +333,7 @@ func (bav *UtxoView) _setProfileEntryMappings(profileEntry *ProfileEntry) {
 	bav.ProfilePKIDToProfileEntry[*pkidEntry.PKID] = profileEntry
 	// Note the username will be lowercased when used as a map key.
 	bav.ProfileUsernameToProfileEntry[MakeUsernameMapKey(profileEntry.Username)] = profileEntry
+	return pkidEntry.PKID
 }
 
 func (bav *UtxoView) _deleteProfileEntryMappings(profileEntry *ProfileEntry) {
@@ -478,7 +488,7 @@ func (bav *UtxoView) setProfileMappings(profile *PGProfile) (*ProfileEntry, *PKI
 			ExtraData: profile.ExtraData,
 		}
 
-		bav._setProfileEntryMappings(profileEntry)
+		bav._cacheProfileEntry(profileEntry)
 	}
 
 	return profileEntry, pkidEntry
@@ -576,7 +586,7 @@ func (bav *UtxoView) _connectUpdateProfile(
 		if len(txMeta.ProfilePublicKey) != btcec.PubKeyBytesLenCompressed {
 			return 0, 0, nil, errors.Wrapf(RuleErrorProfilePublicKeySize, "_connectUpdateProfile: %#v", txMeta.ProfilePublicKey)
 		}
-		_, err := btcec.ParsePubKey(txMeta.ProfilePublicKey, btcec.S256())
+		_, err := ParsePubKeyCached(txMeta.ProfilePublicKey)
 		if err != nil {
 			return 0, 0, nil, errors.Wrapf(RuleErrorProfileBadPublicKey, "_connectUpdateProfile: %v", err)
 		}
@@ -821,7 +831,7 @@ func (bav *UtxoView) _connectSwapIdentity(
 	if len(fromPublicKey) != btcec.PubKeyBytesLenCompressed {
 		return 0, 0, nil, RuleErrorFromPublicKeyIsRequired
 	}
-	if _, err := btcec.ParsePubKey(fromPublicKey, btcec.S256()); err != nil {
+	if _, err := ParsePubKeyCached(fromPublicKey); err != nil {
 		return 0, 0, nil, errors.Wrap(RuleErrorInvalidFromPublicKey, err.Error())
 	}
 
@@ -830,7 +840,7 @@ func (bav *UtxoView) _connectSwapIdentity(
 	if len(toPublicKey) != btcec.PubKeyBytesLenCompressed {
 		return 0, 0, nil, RuleErrorToPublicKeyIsRequired
 	}
-	if _, err := btcec.ParsePubKey(toPublicKey, btcec.S256()); err != nil {
+	if _, err := ParsePubKeyCached(toPublicKey); err != nil {
 		return 0, 0, nil, errors.Wrap(RuleErrorInvalidToPublicKey, err.Error())
 	}
 
@@ -963,7 +973,7 @@ func _verifyDeSoSignature(signer, data, signature []byte) error {
 	}
 
 	// Verify signature.
-	ownerPk, _ := btcec.ParsePubKey(signer, btcec.S256())
+	ownerPk, _ := ParsePubKeyCached(signer)
 	if !sign.Verify(bytes[:], ownerPk) {
 		return fmt.Errorf("_verifyBytesSignature: Invalid signature")
 	}
@@ -983,7 +993,7 @@ func TextAndHash(data []byte) ([]byte, string) {
 func VerifyEthPersonalSignature(signer, data, signature []byte) error {
 	// Ethereum likes uncompressed public keys while we use compressed keys a lot. Make sure we have uncompressed pk bytes.
 	var uncompressedSigner []byte
-	pubKey, err := btcec.ParsePubKey(signer, btcec.S256())
+	pubKey, err := ParsePubKeyCached(signer)
 	if err != nil {
 		return errors.Wrapf(err, "VerifyEthPersonalSignature: Problem parsing signer public key")
 	}
@@ -1043,7 +1053,7 @@ func (bav *UtxoView) _disconnectUpdateProfile(
 		if len(txMeta.ProfilePublicKey) != btcec.PubKeyBytesLenCompressed {
 			return fmt.Errorf("_disconnectUpdateProfile: %#v", txMeta.ProfilePublicKey)
 		}
-		_, err := btcec.ParsePubKey(txMeta.ProfilePublicKey, btcec.S256())
+		_, err := ParsePubKeyCached(txMeta.ProfilePublicKey)
 		if err != nil {
 			return fmt.Errorf("_disconnectUpdateProfile: %v", err)
 		}
@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -3310,3 +3312,53 @@ func TestEthSignature(t *testing.T) {
 	// verify signature
 	require.NoError(VerifyEthPersonalSignature(ownerPublicKeyBytes, accessBytes, signature))
 }
+
+func TestProfileModifiedHeightOnlyIndexesDirtyProfiles(t *testing.T) {
+	require := require.New(t)
+	chain, params, db := NewLowDifficultyBlockchain()
+	snap := chain.snapshot
+
+	pkids := []*PKID{PublicKeyToPKID(m0PkBytes), PublicKeyToPKID(m1PkBytes)}
+	for ii, publicKey := range [][]byte{m0PkBytes, m1PkBytes} {
+		profileEntry := &ProfileEntry{
+			PublicKey: publicKey,
+			Username:  []byte(fmt.Sprintf("user%d", ii)),
+		}
+		require.NoError(DBPutProfileEntryMappings(db, snap, 0, profileEntry, pkids[ii], params))
+	}
+	getModifiedProfiles := func() []*ProfileModification {
+		profiles, _, err := DbGetProfilesModifiedSince(db, snap, 0, nil, 10)
+		require.NoError(err)
+		return profiles
+	}
+
+	// Reading the profiles doesn't mark them dirty, so only the updated one is indexed.
+	utxoView, err := NewUtxoView(db, params, nil, snap)
+	require.NoError(err)
+	require.NotNil(utxoView.GetProfileEntryForPKID(pkids[0]))
+	updatedProfileEntry := *utxoView.GetProfileEntryForPKID(pkids[1])
+	require.Empty(utxoView.dirtyProfilePKIDs)
+	updatedProfileEntry.Description = []byte("updated")
+	utxoView._setProfileEntryMappings(&updatedProfileEntry)
+	require.Len(utxoView.dirtyProfilePKIDs, 1)
+
+	require.NoError(utxoView.FlushToDb(10))
+	require.Empty(utxoView.dirtyProfilePKIDs)
+	profiles := getModifiedProfiles()
+	require.Len(profiles, 1)
+	require.Equal(*pkids[1], *profiles[0].PKID)
+	require.Equal(uint64(10), profiles[0].ModifiedHeight)
+
+	// Profiles reverted by a disconnect are indexed at the height the chain reverted to.
+	revertedToBlockHeight := uint64(11)
+	utxoView.revertedToBlockHeight = &revertedToBlockHeight
+	revertedProfileEntry := *utxoView.GetProfileEntryForPKID(pkids[0])
+	revertedProfileEntry.Description = []byte("reverted")
+	utxoView._setProfileEntryMappings(&revertedProfileEntry)
+	require.NoError(utxoView.FlushToDb(12))
+	profiles = getModifiedProfiles()
+	require.Len(profiles, 2)
+	require.Equal(*pkids[1], *profiles[0].PKID)
+	require.Equal(*pkids[0], *profiles[1].PKID)
+	require.Equal(uint64(11), profiles[1].ModifiedHeight)
+}
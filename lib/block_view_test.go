@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -135,6 +137,13 @@ func _updateGlobalParamsEntry(t *testing.T, chain *Blockchain, db *badger.DB,
 		maxCopiesPerNFT,
 		minimumNetworkFeesNanosPerKB,
 		nil,
+		nil,
+		nil,
+		-1,
+		-1,
+		nil,
+		nil,
+		nil,
 		feeRateNanosPerKB,
 		nil,
 		[]*DeSoOutput{})
@@ -488,6 +497,111 @@ func TestUpdateGlobalParams(t *testing.T) {
 	}
 }
 
+func TestUpdateGlobalParamsTxnTypeRules(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true /*isSender*/)
+	_, _ = mempool, miner
+
+	params.ExtraRegtestParamUpdaterKeys = make(map[PkMapKey]bool)
+	params.ExtraRegtestParamUpdaterKeys[MakePkMapKey(MustBase58CheckDecode(moneyPkString))] = true
+
+	updateTxnTypeRules := func(minFees map[TxnType]uint64, disabledTxnTypes map[TxnType]bool) (
+		*MsgDeSoTxn, []*UtxoOperation, error) {
+
+		txn, _, _, _, err := chain.CreateUpdateGlobalParamsTxn(
+			MustBase58CheckDecode(moneyPkString), -1, -1, -1, -1, -1, nil, nil, nil, -1, -1, nil,
+			minFees, disabledTxnTypes, 200 /*feeRateNanosPerKB*/, nil, []*DeSoOutput{})
+		require.NoError(err)
+		_signTxn(t, txn, moneyPrivString)
+
+		utxoView, err := NewUtxoView(db, params, chain.postgres, chain.snapshot)
+		require.NoError(err)
+		utxoOps, _, _, _, err := utxoView.ConnectTransaction(
+			txn, txn.Hash(), getTxnSize(*txn), chain.blockTip().Height+1, true /*verifySignature*/, false /*ignoreUtxos*/)
+		if err != nil {
+			return nil, nil, err
+		}
+		require.NoError(utxoView.FlushToDb(0))
+		return txn, utxoOps, nil
+	}
+	basicTransfer := func(feeRateNanosPerKB uint64) error {
+		txn := _assembleBasicTransferTxnFullySigned(
+			t, chain, 10, feeRateNanosPerKB, moneyPkString, m0Pub, moneyPrivString, nil)
+		utxoView, err := NewUtxoView(db, params, chain.postgres, chain.snapshot)
+		require.NoError(err)
+		_, _, _, _, err = utxoView.ConnectTransaction(
+			txn, txn.Hash(), getTxnSize(*txn), chain.blockTip().Height+1, true /*verifySignature*/, false /*ignoreUtxos*/)
+		if err != nil {
+			return err
+		}
+		return utxoView.FlushToDb(0)
+	}
+
+	// Basic transfers get a minimum fee of their own, which doesn't apply to other txn types.
+	_, _, err := updateTxnTypeRules(map[TxnType]uint64{TxnTypeBasicTransfer: 1000}, nil)
+	require.NoError(err)
+	globalParams := DbGetGlobalParamsEntry(db, chain.snapshot)
+	require.Equal(map[TxnType]uint64{TxnTypeBasicTransfer: 1000}, globalParams.MinimumNetworkFeeNanosPerKBByTxnType)
+	require.Equal(uint64(1000), globalParams.MinimumNetworkFeeNanosPerKBForTxnType(TxnTypeBasicTransfer))
+	require.Equal(uint64(0), globalParams.MinimumNetworkFeeNanosPerKBForTxnType(TxnTypeSubmitPost))
+	err = basicTransfer(11)
+	require.Error(err)
+	require.Contains(err.Error(), RuleErrorTxnFeeBelowNetworkMinimum)
+	require.NoError(basicTransfer(2000))
+
+	// Disabled txn types can't be connected, and updates are applied on top of the previous ones.
+	prevGlobalParams := DbGetGlobalParamsEntry(db, chain.snapshot)
+	disableTxn, disableUtxoOps, err := updateTxnTypeRules(nil, map[TxnType]bool{
+		TxnTypeBasicTransfer: true,
+		TxnTypeLike:          true,
+	})
+	require.NoError(err)
+	globalParams = DbGetGlobalParamsEntry(db, chain.snapshot)
+	require.Equal(map[TxnType]bool{TxnTypeBasicTransfer: true, TxnTypeLike: true}, globalParams.DisabledTxnTypes)
+	require.Equal(prevGlobalParams.MinimumNetworkFeeNanosPerKBByTxnType, globalParams.MinimumNetworkFeeNanosPerKBByTxnType)
+	err = basicTransfer(2000)
+	require.Error(err)
+	require.Contains(err.Error(), RuleErrorTxnTypeDisabled)
+
+	// Disconnecting the update restores the previous params.
+	{
+		utxoView, err := NewUtxoView(db, params, chain.postgres, chain.snapshot)
+		require.NoError(err)
+		require.NoError(utxoView.DisconnectTransaction(
+			disableTxn, disableTxn.Hash(), disableUtxoOps, chain.blockTip().Height+1))
+		require.NoError(utxoView.FlushToDb(0))
+		require.Equal(prevGlobalParams, DbGetGlobalParamsEntry(db, chain.snapshot))
+		require.NoError(basicTransfer(2000))
+	}
+
+	// Txn types are enabled again with a zero, and fee minimums are removed with a zero.
+	_, _, err = updateTxnTypeRules(nil, map[TxnType]bool{TxnTypeBasicTransfer: true, TxnTypeLike: true})
+	require.NoError(err)
+	_, _, err = updateTxnTypeRules(
+		map[TxnType]uint64{TxnTypeBasicTransfer: 0}, map[TxnType]bool{TxnTypeBasicTransfer: false})
+	require.NoError(err)
+	globalParams = DbGetGlobalParamsEntry(db, chain.snapshot)
+	require.Nil(globalParams.MinimumNetworkFeeNanosPerKBByTxnType)
+	require.Equal(map[TxnType]bool{TxnTypeLike: true}, globalParams.DisabledTxnTypes)
+	require.NoError(basicTransfer(11))
+
+	// Block rewards and global params updates can't be disabled, and unknown txn types are rejected.
+	_, _, err = updateTxnTypeRules(nil, map[TxnType]bool{TxnTypeBlockReward: true})
+	require.Error(err)
+	require.Contains(err.Error(), RuleErrorGlobalParamsCannotDisableTxnType)
+	_, _, err = updateTxnTypeRules(nil, map[TxnType]bool{TxnTypeUpdateGlobalParams: true})
+	require.Error(err)
+	require.Contains(err.Error(), RuleErrorGlobalParamsCannotDisableTxnType)
+	_, _, err = updateTxnTypeRules(map[TxnType]uint64{TxnType(200): 1000}, nil)
+	require.Error(err)
+	require.Contains(err.Error(), RuleErrorGlobalParamsInvalidTxnType)
+	_, _, err = updateTxnTypeRules(map[TxnType]uint64{TxnTypeBasicTransfer: MaxNetworkFeeNanosPerKBValue + 1}, nil)
+	require.Error(err)
+	require.Contains(err.Error(), RuleErrorMinNetworkFeeTooHigh)
+}
+
 func TestBasicTransfer(t *testing.T) {
 	assert := assert.New(t)
 	require := require.New(t)
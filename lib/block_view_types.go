@@ -100,6 +100,11 @@ const (
 	EncoderTypeBlockHash
 	EncoderTypeDAOCoinLimitOrderEntry
 	EncoderTypeFilledDAOCoinLimitOrder
+	EncoderTypeNFTCollectionSummary
+	EncoderTypeSignerSetEntry
+	EncoderTypeDAOCoinLimitOrderTriggerEntry
+	EncoderTypePostAssociationEntry
+	EncoderTypeUserAssociationEntry
 
 	// EncoderTypeEndBlockView encoder type should be at the end and is used for automated tests.
 	EncoderTypeEndBlockView
@@ -130,6 +135,7 @@ const (
 	EncoderTypeDAOCoinTxindexMetadata
 	EncoderTypeCreateNFTTxindexMetadata
 	EncoderTypeUpdateNFTTxindexMetadata
+	EncoderTypeResolvedInputTxindexMetadata
 
 	// EncoderTypeEndTxIndex encoder type should be at the end and is used for automated tests.
 	EncoderTypeEndTxIndex
@@ -199,6 +205,16 @@ func (encoderType EncoderType) New() DeSoEncoder {
 		return &DAOCoinLimitOrderEntry{}
 	case EncoderTypeFilledDAOCoinLimitOrder:
 		return &FilledDAOCoinLimitOrder{}
+	case EncoderTypeNFTCollectionSummary:
+		return &NFTCollectionSummary{}
+	case EncoderTypeSignerSetEntry:
+		return &SignerSetEntry{}
+	case EncoderTypeDAOCoinLimitOrderTriggerEntry:
+		return &DAOCoinLimitOrderTriggerEntry{}
+	case EncoderTypePostAssociationEntry:
+		return &PostAssociationEntry{}
+	case EncoderTypeUserAssociationEntry:
+		return &UserAssociationEntry{}
 	}
 
 	// Txindex encoder types
@@ -249,6 +265,8 @@ func (encoderType EncoderType) New() DeSoEncoder {
 		return &CreateNFTTxindexMetadata{}
 	case EncoderTypeUpdateNFTTxindexMetadata:
 		return &UpdateNFTTxindexMetadata{}
+	case EncoderTypeResolvedInputTxindexMetadata:
+		return &ResolvedInputTxindexMetadata{}
 	default:
 		return nil
 	}
@@ -517,8 +535,11 @@ const (
 	OperationTypeDAOCoinTransfer              OperationType = 26
 	OperationTypeSpendingLimitAccounting      OperationType = 27
 	OperationTypeDAOCoinLimitOrder            OperationType = 28
+	OperationTypeDAOCoinLimitOrderExpiration  OperationType = 29
+	OperationTypePostAssociation              OperationType = 30
+	OperationTypeUserAssociation              OperationType = 31
 
-	// NEXT_TAG = 29
+	// NEXT_TAG = 32
 )
 
 func (op OperationType) String() string {
@@ -635,6 +656,18 @@ func (op OperationType) String() string {
 		{
 			return "OperationTypeDAOCoinLimitOrder"
 		}
+	case OperationTypeDAOCoinLimitOrderExpiration:
+		{
+			return "OperationTypeDAOCoinLimitOrderExpiration"
+		}
+	case OperationTypePostAssociation:
+		{
+			return "OperationTypePostAssociation"
+		}
+	case OperationTypeUserAssociation:
+		{
+			return "OperationTypeUserAssociation"
+		}
 	}
 	return "OperationTypeUNKNOWN"
 }
@@ -789,6 +822,19 @@ type UtxoOperation struct {
 	// that represent all orders fulfilled by the DAO Coin Limit Order transaction.
 	// These are used to construct notifications for order fulfillment.
 	FilledDAOCoinLimitOrders []*FilledDAOCoinLimitOrder
+
+	// PrevDAOCoinLimitOrderTriggerEntries are the trigger orders that were placed
+	// on the book, or cancelled, by the DAO Coin Limit Order transaction. They're
+	// restored in the event of a disconnect.
+	PrevDAOCoinLimitOrderTriggerEntries []*DAOCoinLimitOrderTriggerEntry
+
+	// PrevPostAssociationEntry is the association that a PostAssociation transaction
+	// replaced or deleted, if there was one. It's restored in the event of a disconnect.
+	PrevPostAssociationEntry *PostAssociationEntry
+
+	// PrevUserAssociationEntry is the association that a UserAssociation transaction
+	// replaced or deleted, if there was one. It's restored in the event of a disconnect.
+	PrevUserAssociationEntry *UserAssociationEntry
 }
 
 func (op *UtxoOperation) RawEncodeWithoutMetadata(blockHeight uint64, skipMetadata ...bool) []byte {
@@ -1073,6 +1119,24 @@ func (op *UtxoOperation) RawEncodeWithoutMetadata(blockHeight uint64, skipMetada
 		data = append(data, EncodeToBytes(blockHeight, entry, skipMetadata...)...)
 	}
 
+	if MigrationTriggered(blockHeight, DAOCoinLimitOrderTriggerMigration) {
+		// PrevDAOCoinLimitOrderTriggerEntries
+		data = append(data, UintToBuf(uint64(len(op.PrevDAOCoinLimitOrderTriggerEntries)))...)
+		for _, entry := range op.PrevDAOCoinLimitOrderTriggerEntries {
+			data = append(data, EncodeToBytes(blockHeight, entry, skipMetadata...)...)
+		}
+	}
+
+	if MigrationTriggered(blockHeight, PostAssociationMigration) {
+		// PrevPostAssociationEntry
+		data = append(data, EncodeToBytes(blockHeight, op.PrevPostAssociationEntry, skipMetadata...)...)
+	}
+
+	if MigrationTriggered(blockHeight, UserAssociationMigration) {
+		// PrevUserAssociationEntry
+		data = append(data, EncodeToBytes(blockHeight, op.PrevUserAssociationEntry, skipMetadata...)...)
+	}
+
 	return data
 }
 
@@ -1607,11 +1671,48 @@ func (op *UtxoOperation) RawDecodeWithoutMetadata(blockHeight uint64, rr *bytes.
 		return errors.Wrapf(err, "UtxoOperation.Decode: Problem reading FilledDAOCoinLimitOrder")
 	}
 
+	if MigrationTriggered(blockHeight, DAOCoinLimitOrderTriggerMigration) {
+		// PrevDAOCoinLimitOrderTriggerEntries
+		if lenPrevTriggerEntries, err := ReadUvarint(rr); err == nil {
+			for ; lenPrevTriggerEntries > 0; lenPrevTriggerEntries-- {
+				prevTriggerEntry := &DAOCoinLimitOrderTriggerEntry{}
+				if exist, err := DecodeFromBytes(prevTriggerEntry, rr); exist && err == nil {
+					op.PrevDAOCoinLimitOrderTriggerEntries = append(op.PrevDAOCoinLimitOrderTriggerEntries, prevTriggerEntry)
+				} else {
+					return errors.Wrapf(err, "UtxoOperation.Decode: Problem reading PrevDAOCoinLimitOrderTriggerEntries")
+				}
+			}
+		} else {
+			return errors.Wrapf(err, "UtxoOperation.Decode: Problem reading PrevDAOCoinLimitOrderTriggerEntries")
+		}
+	}
+
+	if MigrationTriggered(blockHeight, PostAssociationMigration) {
+		// PrevPostAssociationEntry
+		prevPostAssociationEntry := &PostAssociationEntry{}
+		if exist, err := DecodeFromBytes(prevPostAssociationEntry, rr); exist && err == nil {
+			op.PrevPostAssociationEntry = prevPostAssociationEntry
+		} else if err != nil {
+			return errors.Wrapf(err, "UtxoOperation.Decode: Problem reading PrevPostAssociationEntry")
+		}
+	}
+
+	if MigrationTriggered(blockHeight, UserAssociationMigration) {
+		// PrevUserAssociationEntry
+		prevUserAssociationEntry := &UserAssociationEntry{}
+		if exist, err := DecodeFromBytes(prevUserAssociationEntry, rr); exist && err == nil {
+			op.PrevUserAssociationEntry = prevUserAssociationEntry
+		} else if err != nil {
+			return errors.Wrapf(err, "UtxoOperation.Decode: Problem reading PrevUserAssociationEntry")
+		}
+	}
+
 	return nil
 }
 
 func (op *UtxoOperation) GetVersionByte(blockHeight uint64) byte {
-	return 0
+	return GetMigrationVersion(
+		blockHeight, DAOCoinLimitOrderTriggerMigration, PostAssociationMigration, UserAssociationMigration)
 }
 
 func (op *UtxoOperation) GetEncoderType() EncoderType {
@@ -2267,6 +2368,182 @@ func (likeEntry *LikeEntry) GetEncoderType() EncoderType {
 	return EncoderTypeLikeEntry
 }
 
+func MakePostAssociationKey(associationType []byte, transactorPKID *PKID, targetPostHash *BlockHash) PostAssociationKey {
+	return PostAssociationKey{
+		AssociationType: string(associationType),
+		TransactorPKID:  *transactorPKID,
+		TargetPostHash:  *targetPostHash,
+	}
+}
+
+// PostAssociationKey identifies a post association. A user can have at most one
+// association of each type with a post.
+type PostAssociationKey struct {
+	AssociationType string
+	TransactorPKID  PKID
+	TargetPostHash  BlockHash
+}
+
+// PostAssociationEntry is an association between a user and a post, like a reaction.
+// The AssociationType says what kind of association it is, and the AssociationValue
+// holds whatever data the association's type calls for, e.g. which emoji was used.
+type PostAssociationEntry struct {
+	AssociationType  []byte
+	TransactorPKID   *PKID
+	TargetPostHash   *BlockHash
+	AssociationValue []byte
+
+	// Whether or not this entry is deleted in the view.
+	isDeleted bool
+}
+
+func (associationEntry *PostAssociationEntry) Copy() *PostAssociationEntry {
+	return &PostAssociationEntry{
+		AssociationType:  append([]byte{}, associationEntry.AssociationType...),
+		TransactorPKID:   associationEntry.TransactorPKID.NewPKID(),
+		TargetPostHash:   associationEntry.TargetPostHash.NewBlockHash(),
+		AssociationValue: append([]byte{}, associationEntry.AssociationValue...),
+		isDeleted:        associationEntry.isDeleted,
+	}
+}
+
+func (associationEntry *PostAssociationEntry) ToMapKey() PostAssociationKey {
+	return MakePostAssociationKey(
+		associationEntry.AssociationType, associationEntry.TransactorPKID, associationEntry.TargetPostHash)
+}
+
+func (associationEntry *PostAssociationEntry) RawEncodeWithoutMetadata(blockHeight uint64, skipMetadata ...bool) []byte {
+	var data []byte
+
+	data = append(data, EncodeByteArray(associationEntry.AssociationType)...)
+	data = append(data, EncodeToBytes(blockHeight, associationEntry.TransactorPKID, skipMetadata...)...)
+	data = append(data, EncodeToBytes(blockHeight, associationEntry.TargetPostHash, skipMetadata...)...)
+	data = append(data, EncodeByteArray(associationEntry.AssociationValue)...)
+	return data
+}
+
+func (associationEntry *PostAssociationEntry) RawDecodeWithoutMetadata(blockHeight uint64, rr *bytes.Reader) error {
+	var err error
+
+	associationEntry.AssociationType, err = DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "PostAssociationEntry.Decode: problem reading AssociationType")
+	}
+	transactorPKID := &PKID{}
+	if exist, err := DecodeFromBytes(transactorPKID, rr); exist && err == nil {
+		associationEntry.TransactorPKID = transactorPKID
+	} else if err != nil {
+		return errors.Wrapf(err, "PostAssociationEntry.Decode: problem reading TransactorPKID")
+	}
+	targetPostHash := &BlockHash{}
+	if exist, err := DecodeFromBytes(targetPostHash, rr); exist && err == nil {
+		associationEntry.TargetPostHash = targetPostHash
+	} else if err != nil {
+		return errors.Wrapf(err, "PostAssociationEntry.Decode: problem reading TargetPostHash")
+	}
+	associationEntry.AssociationValue, err = DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "PostAssociationEntry.Decode: problem reading AssociationValue")
+	}
+	return nil
+}
+
+func (associationEntry *PostAssociationEntry) GetVersionByte(blockHeight uint64) byte {
+	return 0
+}
+
+func (associationEntry *PostAssociationEntry) GetEncoderType() EncoderType {
+	return EncoderTypePostAssociationEntry
+}
+
+func MakeUserAssociationKey(transactorPKID *PKID, targetPKID *PKID, associationType []byte) UserAssociationKey {
+	return UserAssociationKey{
+		TransactorPKID:  *transactorPKID,
+		TargetPKID:      *targetPKID,
+		AssociationType: string(associationType),
+	}
+}
+
+// UserAssociationKey identifies a user association. A user can have at most one
+// association of each type with another user.
+type UserAssociationKey struct {
+	TransactorPKID  PKID
+	TargetPKID      PKID
+	AssociationType string
+}
+
+// UserAssociationEntry is an attestation that one user makes about another, like an
+// endorsement. The AssociationType says what kind of association it is, and the
+// AssociationValue holds whatever data the association's type calls for.
+type UserAssociationEntry struct {
+	TransactorPKID   *PKID
+	TargetPKID       *PKID
+	AssociationType  []byte
+	AssociationValue []byte
+
+	// Whether or not this entry is deleted in the view.
+	isDeleted bool
+}
+
+func (associationEntry *UserAssociationEntry) Copy() *UserAssociationEntry {
+	return &UserAssociationEntry{
+		TransactorPKID:   associationEntry.TransactorPKID.NewPKID(),
+		TargetPKID:       associationEntry.TargetPKID.NewPKID(),
+		AssociationType:  append([]byte{}, associationEntry.AssociationType...),
+		AssociationValue: append([]byte{}, associationEntry.AssociationValue...),
+		isDeleted:        associationEntry.isDeleted,
+	}
+}
+
+func (associationEntry *UserAssociationEntry) ToMapKey() UserAssociationKey {
+	return MakeUserAssociationKey(
+		associationEntry.TransactorPKID, associationEntry.TargetPKID, associationEntry.AssociationType)
+}
+
+func (associationEntry *UserAssociationEntry) RawEncodeWithoutMetadata(blockHeight uint64, skipMetadata ...bool) []byte {
+	var data []byte
+
+	data = append(data, EncodeToBytes(blockHeight, associationEntry.TransactorPKID, skipMetadata...)...)
+	data = append(data, EncodeToBytes(blockHeight, associationEntry.TargetPKID, skipMetadata...)...)
+	data = append(data, EncodeByteArray(associationEntry.AssociationType)...)
+	data = append(data, EncodeByteArray(associationEntry.AssociationValue)...)
+	return data
+}
+
+func (associationEntry *UserAssociationEntry) RawDecodeWithoutMetadata(blockHeight uint64, rr *bytes.Reader) error {
+	var err error
+
+	transactorPKID := &PKID{}
+	if exist, err := DecodeFromBytes(transactorPKID, rr); exist && err == nil {
+		associationEntry.TransactorPKID = transactorPKID
+	} else if err != nil {
+		return errors.Wrapf(err, "UserAssociationEntry.Decode: problem reading TransactorPKID")
+	}
+	targetPKID := &PKID{}
+	if exist, err := DecodeFromBytes(targetPKID, rr); exist && err == nil {
+		associationEntry.TargetPKID = targetPKID
+	} else if err != nil {
+		return errors.Wrapf(err, "UserAssociationEntry.Decode: problem reading TargetPKID")
+	}
+	associationEntry.AssociationType, err = DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "UserAssociationEntry.Decode: problem reading AssociationType")
+	}
+	associationEntry.AssociationValue, err = DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "UserAssociationEntry.Decode: problem reading AssociationValue")
+	}
+	return nil
+}
+
+func (associationEntry *UserAssociationEntry) GetVersionByte(blockHeight uint64) byte {
+	return 0
+}
+
+func (associationEntry *UserAssociationEntry) GetEncoderType() EncoderType {
+	return EncoderTypeUserAssociationEntry
+}
+
 func MakeNFTKey(nftPostHash *BlockHash, serialNumber uint64) NFTKey {
 	return NFTKey{
 		NFTPostHash:  *nftPostHash,
@@ -2303,6 +2580,11 @@ type NFTEntry struct {
 
 	ExtraData map[string][]byte
 
+	// If an NFT was minted with ownership shares, this maps each owner's PKID to the basis
+	// points of the NFT they own. OwnerPKID manages the NFT, but the proceeds of its sale are
+	// split between all the owners. The shares are cleared once the NFT is sold.
+	OwnershipSharesBasisPoints map[PKID]uint64
+
 	// Whether or not this entry is deleted in the view.
 	isDeleted bool
 }
@@ -2322,6 +2604,10 @@ func (nft *NFTEntry) RawEncodeWithoutMetadata(blockHeight uint64, skipMetadata .
 	data = append(data, BoolToByte(nft.IsBuyNow))
 	data = append(data, UintToBuf(nft.BuyNowPriceNanos)...)
 	data = append(data, EncodeExtraData(nft.ExtraData)...)
+
+	if MigrationTriggered(blockHeight, NFTOwnershipSharesMigration) {
+		data = append(data, EncodePKIDuint64Map(nft.OwnershipSharesBasisPoints)...)
+	}
 	return data
 }
 
@@ -2394,11 +2680,18 @@ func (nft *NFTEntry) RawDecodeWithoutMetadata(blockHeight uint64, rr *bytes.Read
 		return errors.Wrapf(err, "NFTEntry.Decode: Problem decoding extra data")
 	}
 
+	if MigrationTriggered(blockHeight, NFTOwnershipSharesMigration) {
+		nft.OwnershipSharesBasisPoints, err = DecodePKIDuint64Map(rr)
+		if err != nil {
+			return errors.Wrapf(err, "NFTEntry.Decode: Problem reading OwnershipSharesBasisPoints")
+		}
+	}
+
 	return nil
 }
 
 func (nft *NFTEntry) GetVersionByte(blockHeight uint64) byte {
-	return 0
+	return GetMigrationVersion(blockHeight, NFTOwnershipSharesMigration)
 }
 
 func (nft *NFTEntry) GetEncoderType() EncoderType {
@@ -2552,6 +2845,210 @@ func (bundle *NFTBidEntryBundle) GetEncoderType() EncoderType {
 	return EncoderTypeNFTBidEntryBundle
 }
 
+// NFTCollectionSummary aggregates the state of all the serial numbers minted for a
+// single NFT post. It is maintained by the flush logic so that callers don't have to
+// combine the NFT, bid, and accepted bid indexes themselves.
+type NFTCollectionSummary struct {
+	NFTPostHash *BlockHash
+
+	// The number of serial numbers that currently exist for the post.
+	NumSerials uint64
+	// The number of serial numbers that are currently for sale.
+	NumForSale uint64
+	// The lowest price at which one of the serial numbers for sale can be acquired. For
+	// Buy Now NFTs this is the BuyNowPriceNanos, otherwise it's the MinBidAmountNanos.
+	// Zero when nothing is for sale.
+	FloorPriceNanos uint64
+	// The largest open bid on any serial number, including standing offers on serial 0.
+	HighestBidNanos uint64
+	// The amount and height of the most recently accepted bid across all serials.
+	LastSalePriceNanos  uint64
+	LastSaleBlockHeight uint64
+}
+
+func (summary *NFTCollectionSummary) RawEncodeWithoutMetadata(blockHeight uint64, skipMetadata ...bool) []byte {
+	var data []byte
+
+	data = append(data, EncodeToBytes(blockHeight, summary.NFTPostHash, skipMetadata...)...)
+	data = append(data, UintToBuf(summary.NumSerials)...)
+	data = append(data, UintToBuf(summary.NumForSale)...)
+	data = append(data, UintToBuf(summary.FloorPriceNanos)...)
+	data = append(data, UintToBuf(summary.HighestBidNanos)...)
+	data = append(data, UintToBuf(summary.LastSalePriceNanos)...)
+	data = append(data, UintToBuf(summary.LastSaleBlockHeight)...)
+	return data
+}
+
+func (summary *NFTCollectionSummary) RawDecodeWithoutMetadata(blockHeight uint64, rr *bytes.Reader) error {
+	var err error
+
+	nftPostHash := &BlockHash{}
+	if exist, err := DecodeFromBytes(nftPostHash, rr); exist && err == nil {
+		summary.NFTPostHash = nftPostHash
+	} else if err != nil {
+		return errors.Wrapf(err, "NFTCollectionSummary.Decode: Problem reading NFTPostHash")
+	}
+	summary.NumSerials, err = ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "NFTCollectionSummary.Decode: Problem reading NumSerials")
+	}
+	summary.NumForSale, err = ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "NFTCollectionSummary.Decode: Problem reading NumForSale")
+	}
+	summary.FloorPriceNanos, err = ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "NFTCollectionSummary.Decode: Problem reading FloorPriceNanos")
+	}
+	summary.HighestBidNanos, err = ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "NFTCollectionSummary.Decode: Problem reading HighestBidNanos")
+	}
+	summary.LastSalePriceNanos, err = ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "NFTCollectionSummary.Decode: Problem reading LastSalePriceNanos")
+	}
+	summary.LastSaleBlockHeight, err = ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "NFTCollectionSummary.Decode: Problem reading LastSaleBlockHeight")
+	}
+	return nil
+}
+
+func (summary *NFTCollectionSummary) GetVersionByte(blockHeight uint64) byte {
+	return 0
+}
+
+func (summary *NFTCollectionSummary) GetEncoderType() EncoderType {
+	return EncoderTypeNFTCollectionSummary
+}
+
+// SignerSetKey identifies a signer set. A public key can own several signer sets, which
+// are told apart by an ID of the owner's choosing.
+type SignerSetKey struct {
+	OwnerPublicKey PublicKey
+	SignerSetID    BlockHash
+}
+
+func NewSignerSetKey(ownerPublicKey *PublicKey, signerSetID *BlockHash) *SignerSetKey {
+	return &SignerSetKey{
+		OwnerPublicKey: *ownerPublicKey,
+		SignerSetID:    *signerSetID,
+	}
+}
+
+// SignerSetEntry is an m-of-n set of signers associated with the owner public key. It only
+// describes who the signers are and how many of them need to sign, it's up to the higher
+// layers to decide what a signer set is allowed to authorize.
+type SignerSetEntry struct {
+	OwnerPublicKey *PublicKey
+	SignerSetID    *BlockHash
+
+	// Threshold is the number of signers, the m in m-of-n, that need to sign.
+	Threshold uint64
+	// SignerPublicKeys are the n members of the set.
+	SignerPublicKeys []*PublicKey
+
+	ExtraData map[string][]byte
+}
+
+func (entry *SignerSetEntry) GetSignerSetKey() *SignerSetKey {
+	return NewSignerSetKey(entry.OwnerPublicKey, entry.SignerSetID)
+}
+
+// Validate checks that the signer set is well-formed, i.e. that it has at least one signer,
+// no duplicate signers, and a threshold that the signers can actually reach.
+func (entry *SignerSetEntry) Validate() error {
+	if entry.OwnerPublicKey == nil || entry.SignerSetID == nil {
+		return fmt.Errorf("SignerSetEntry.Validate: OwnerPublicKey and SignerSetID must be set")
+	}
+	if len(entry.SignerPublicKeys) == 0 {
+		return fmt.Errorf("SignerSetEntry.Validate: Signer set must have at least one signer")
+	}
+	if len(entry.SignerPublicKeys) > MaxSignersPerSignerSet {
+		return fmt.Errorf("SignerSetEntry.Validate: Signer set has %v signers, which exceeds the "+
+			"maximum of %v", len(entry.SignerPublicKeys), MaxSignersPerSignerSet)
+	}
+	if entry.Threshold == 0 || entry.Threshold > uint64(len(entry.SignerPublicKeys)) {
+		return fmt.Errorf("SignerSetEntry.Validate: Threshold %v must be between 1 and the "+
+			"number of signers %v", entry.Threshold, len(entry.SignerPublicKeys))
+	}
+	signers := make(map[PublicKey]bool)
+	for _, signer := range entry.SignerPublicKeys {
+		if signer == nil {
+			return fmt.Errorf("SignerSetEntry.Validate: Signer public key must be set")
+		}
+		if _, exists := signers[*signer]; exists {
+			return fmt.Errorf("SignerSetEntry.Validate: Duplicate signer %v",
+				PkToStringMainnet(signer[:]))
+		}
+		signers[*signer] = true
+	}
+	return nil
+}
+
+func (entry *SignerSetEntry) RawEncodeWithoutMetadata(blockHeight uint64, skipMetadata ...bool) []byte {
+	var data []byte
+
+	data = append(data, EncodeToBytes(blockHeight, entry.OwnerPublicKey, skipMetadata...)...)
+	data = append(data, EncodeToBytes(blockHeight, entry.SignerSetID, skipMetadata...)...)
+	data = append(data, UintToBuf(entry.Threshold)...)
+	// Signers are encoded in the order the owner gave them, which is always deterministic
+	// since they're stored as a slice.
+	data = append(data, UintToBuf(uint64(len(entry.SignerPublicKeys)))...)
+	for _, signer := range entry.SignerPublicKeys {
+		data = append(data, EncodeToBytes(blockHeight, signer, skipMetadata...)...)
+	}
+	data = append(data, EncodeExtraData(entry.ExtraData)...)
+	return data
+}
+
+func (entry *SignerSetEntry) RawDecodeWithoutMetadata(blockHeight uint64, rr *bytes.Reader) error {
+	var err error
+
+	ownerPublicKey := &PublicKey{}
+	if exist, err := DecodeFromBytes(ownerPublicKey, rr); exist && err == nil {
+		entry.OwnerPublicKey = ownerPublicKey
+	} else if err != nil {
+		return errors.Wrapf(err, "SignerSetEntry.Decode: Problem reading OwnerPublicKey")
+	}
+	signerSetID := &BlockHash{}
+	if exist, err := DecodeFromBytes(signerSetID, rr); exist && err == nil {
+		entry.SignerSetID = signerSetID
+	} else if err != nil {
+		return errors.Wrapf(err, "SignerSetEntry.Decode: Problem reading SignerSetID")
+	}
+	entry.Threshold, err = ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "SignerSetEntry.Decode: Problem reading Threshold")
+	}
+	numSigners, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "SignerSetEntry.Decode: Problem reading number of signers")
+	}
+	for ; numSigners > 0; numSigners-- {
+		signer := &PublicKey{}
+		if exist, err := DecodeFromBytes(signer, rr); exist && err == nil {
+			entry.SignerPublicKeys = append(entry.SignerPublicKeys, signer)
+		} else if err != nil {
+			return errors.Wrapf(err, "SignerSetEntry.Decode: Problem reading signer")
+		}
+	}
+	entry.ExtraData, err = DecodeExtraData(rr)
+	if err != nil {
+		return errors.Wrapf(err, "SignerSetEntry.Decode: Problem reading ExtraData")
+	}
+	return nil
+}
+
+func (entry *SignerSetEntry) GetVersionByte(blockHeight uint64) byte {
+	return 0
+}
+
+func (entry *SignerSetEntry) GetEncoderType() EncoderType {
+	return EncoderTypeSignerSetEntry
+}
+
 type DerivedKeyEntry struct {
 	// Owner public key
 	OwnerPublicKey PublicKey
@@ -2657,7 +3154,7 @@ func (key *DerivedKeyEntry) RawDecodeWithoutMetadata(blockHeight uint64, rr *byt
 }
 
 func (key *DerivedKeyEntry) GetVersionByte(blockHeight uint64) byte {
-	return GetMigrationVersion(blockHeight, UnlimitedDerivedKeysMigration)
+	return GetMigrationVersion(blockHeight, UnlimitedDerivedKeysMigration, DerivedKeyTxnTypeScopingMigration)
 }
 
 func (key *DerivedKeyEntry) GetEncoderType() EncoderType {
@@ -2881,6 +3378,36 @@ type GlobalParamsEntry struct {
 
 	// The new minimum fee the network will accept
 	MinimumNetworkFeeNanosPerKB uint64
+
+	// The minimum QuantityToFillInBaseUnits of a new DAO coin limit order. Nil or zero means no minimum.
+	MinDAOCoinLimitOrderQuantityInBaseUnits *uint256.Int
+
+	// The ScaledExchangeRateCoinsToSellPerCoinToBuy of a new DAO coin limit order must be a
+	// multiple of this tick. Nil or zero means any exchange rate is allowed.
+	DAOCoinLimitOrderScaledExchangeRateTick *uint256.Int
+
+	// The fees, in basis points, charged on the coins received by the maker (the order on the
+	// book) and the taker (the transactor) when DAO coin limit orders match. The fees are paid
+	// to DAOCoinLimitOrderFeeDestinationPublicKey, and none are charged if it isn't set.
+	DAOCoinLimitOrderMakerFeeBasisPoints     uint64
+	DAOCoinLimitOrderTakerFeeBasisPoints     uint64
+	DAOCoinLimitOrderFeeDestinationPublicKey []byte
+
+	// The minimum network fee per KB of the txn types that have their own. The other txn types pay
+	// MinimumNetworkFeeNanosPerKB. Copies of the entry share this map and DisabledTxnTypes, so
+	// they're replaced rather than modified when the global params are updated.
+	MinimumNetworkFeeNanosPerKBByTxnType map[TxnType]uint64
+	// The txn types that can't be connected.
+	DisabledTxnTypes map[TxnType]bool
+}
+
+// MinimumNetworkFeeNanosPerKBForTxnType returns the minimum network fee per KB that txns of the
+// type have to pay.
+func (gp *GlobalParamsEntry) MinimumNetworkFeeNanosPerKBForTxnType(txnType TxnType) uint64 {
+	if minFeeNanosPerKB, exists := gp.MinimumNetworkFeeNanosPerKBByTxnType[txnType]; exists {
+		return minFeeNanosPerKB
+	}
+	return gp.MinimumNetworkFeeNanosPerKB
 }
 
 func (gp *GlobalParamsEntry) RawEncodeWithoutMetadata(blockHeight uint64, skipMetadata ...bool) []byte {
@@ -2891,6 +3418,25 @@ func (gp *GlobalParamsEntry) RawEncodeWithoutMetadata(blockHeight uint64, skipMe
 	data = append(data, UintToBuf(gp.CreateNFTFeeNanos)...)
 	data = append(data, UintToBuf(gp.MaxCopiesPerNFT)...)
 	data = append(data, UintToBuf(gp.MinimumNetworkFeeNanosPerKB)...)
+	if MigrationTriggered(blockHeight, DAOCoinLimitOrderMinSizeAndTickMigration) {
+		data = append(data, EncodeOptionalUint256(gp.MinDAOCoinLimitOrderQuantityInBaseUnits)...)
+		data = append(data, EncodeOptionalUint256(gp.DAOCoinLimitOrderScaledExchangeRateTick)...)
+	}
+	if MigrationTriggered(blockHeight, DAOCoinLimitOrderMakerTakerFeesMigration) {
+		data = append(data, UintToBuf(gp.DAOCoinLimitOrderMakerFeeBasisPoints)...)
+		data = append(data, UintToBuf(gp.DAOCoinLimitOrderTakerFeeBasisPoints)...)
+		data = append(data, EncodeByteArray(gp.DAOCoinLimitOrderFeeDestinationPublicKey)...)
+	}
+	if MigrationTriggered(blockHeight, TxnTypeGlobalParamsMigration) {
+		data = append(data, SerializeTxnTypeToUint64Map(gp.MinimumNetworkFeeNanosPerKBByTxnType)...)
+		disabledTxnTypes := make(map[TxnType]uint64, len(gp.DisabledTxnTypes))
+		for txnType, disabled := range gp.DisabledTxnTypes {
+			if disabled {
+				disabledTxnTypes[txnType] = 1
+			}
+		}
+		data = append(data, SerializeTxnTypeToUint64Map(disabledTxnTypes)...)
+	}
 
 	return data
 }
@@ -2918,12 +3464,56 @@ func (gp *GlobalParamsEntry) RawDecodeWithoutMetadata(blockHeight uint64, rr *by
 	if err != nil {
 		return errors.Wrapf(err, "GlobalParamsEntry.Decode: Problem reading MinimumNetworkFeeNanosPerKB")
 	}
+	if MigrationTriggered(blockHeight, DAOCoinLimitOrderMinSizeAndTickMigration) {
+		gp.MinDAOCoinLimitOrderQuantityInBaseUnits, err = ReadOptionalUint256(rr)
+		if err != nil {
+			return errors.Wrapf(err, "GlobalParamsEntry.Decode: Problem reading MinDAOCoinLimitOrderQuantityInBaseUnits")
+		}
+		gp.DAOCoinLimitOrderScaledExchangeRateTick, err = ReadOptionalUint256(rr)
+		if err != nil {
+			return errors.Wrapf(err, "GlobalParamsEntry.Decode: Problem reading DAOCoinLimitOrderScaledExchangeRateTick")
+		}
+	}
+	if MigrationTriggered(blockHeight, DAOCoinLimitOrderMakerTakerFeesMigration) {
+		gp.DAOCoinLimitOrderMakerFeeBasisPoints, err = ReadUvarint(rr)
+		if err != nil {
+			return errors.Wrapf(err, "GlobalParamsEntry.Decode: Problem reading DAOCoinLimitOrderMakerFeeBasisPoints")
+		}
+		gp.DAOCoinLimitOrderTakerFeeBasisPoints, err = ReadUvarint(rr)
+		if err != nil {
+			return errors.Wrapf(err, "GlobalParamsEntry.Decode: Problem reading DAOCoinLimitOrderTakerFeeBasisPoints")
+		}
+		gp.DAOCoinLimitOrderFeeDestinationPublicKey, err = DecodeByteArray(rr)
+		if err != nil {
+			return errors.Wrapf(err, "GlobalParamsEntry.Decode: Problem reading DAOCoinLimitOrderFeeDestinationPublicKey")
+		}
+	}
+	if MigrationTriggered(blockHeight, TxnTypeGlobalParamsMigration) {
+		minimumNetworkFeeNanosPerKBByTxnType, err := ReadTxnTypeToUint64Map(rr)
+		if err != nil {
+			return errors.Wrapf(err, "GlobalParamsEntry.Decode: Problem reading MinimumNetworkFeeNanosPerKBByTxnType")
+		}
+		if len(minimumNetworkFeeNanosPerKBByTxnType) > 0 {
+			gp.MinimumNetworkFeeNanosPerKBByTxnType = minimumNetworkFeeNanosPerKBByTxnType
+		}
+		disabledTxnTypes, err := ReadTxnTypeToUint64Map(rr)
+		if err != nil {
+			return errors.Wrapf(err, "GlobalParamsEntry.Decode: Problem reading DisabledTxnTypes")
+		}
+		if len(disabledTxnTypes) > 0 {
+			gp.DisabledTxnTypes = make(map[TxnType]bool, len(disabledTxnTypes))
+			for txnType := range disabledTxnTypes {
+				gp.DisabledTxnTypes[txnType] = true
+			}
+		}
+	}
 
 	return nil
 }
 
 func (gp *GlobalParamsEntry) GetVersionByte(blockHeight uint64) byte {
-	return 0
+	return GetMigrationVersion(blockHeight, DAOCoinLimitOrderMinSizeAndTickMigration, DAOCoinLimitOrderMakerTakerFeesMigration,
+		TxnTypeGlobalParamsMigration)
 }
 
 func (gp *GlobalParamsEntry) GetEncoderType() EncoderType {
@@ -4043,6 +4633,15 @@ type DAOCoinLimitOrderEntry struct {
 	// to break ties between orders. If there are two orders that could be filled, we
 	// pick the one that was submitted earlier.
 	BlockHeight uint32
+	// If nonzero, the order can't be matched in blocks past this height, and it's
+	// pruned from the book when the first of them connects. See IsExpired.
+	ExpirationBlockHeight uint32
+
+	// These are only set on the market order of the txn being connected, which is
+	// never stored. See DAOCoinLimitOrderMetadata.MarketOrderMaxSlippageBasisPoints.
+	// The min exchange rate is set once the order matches its first order.
+	marketOrderMaxSlippageBasisPoints        uint64
+	marketOrderMinMatchingScaledExchangeRate *uint256.Int
 
 	isDeleted bool
 }
@@ -4089,6 +4688,9 @@ type FilledDAOCoinLimitOrder struct {
 	CoinQuantityInBaseUnitsBought *uint256.Int
 	CoinQuantityInBaseUnitsSold   *uint256.Int
 	IsFulfilled                   bool
+	// The maker or taker fee paid out of CoinQuantityInBaseUnitsBought. The order's
+	// transactor received CoinQuantityInBaseUnitsBought minus this fee.
+	FeeInBaseUnitsPaid *uint256.Int
 }
 
 func (order *DAOCoinLimitOrderEntry) Copy() *DAOCoinLimitOrderEntry {
@@ -4102,6 +4704,7 @@ func (order *DAOCoinLimitOrderEntry) Copy() *DAOCoinLimitOrderEntry {
 		OperationType:                             order.OperationType,
 		FillType:                                  order.FillType,
 		BlockHeight:                               order.BlockHeight,
+		ExpirationBlockHeight:                     order.ExpirationBlockHeight,
 		isDeleted:                                 order.isDeleted,
 	}
 }
@@ -4118,6 +4721,9 @@ func (order *DAOCoinLimitOrderEntry) RawEncodeWithoutMetadata(blockHeight uint64
 	data = append(data, UintToBuf(uint64(order.OperationType))...)
 	data = append(data, UintToBuf(uint64(order.FillType))...)
 	data = append(data, UintToBuf(uint64(order.BlockHeight))...)
+	if MigrationTriggered(blockHeight, DAOCoinLimitOrderExpirationMigration) {
+		data = append(data, UintToBuf(uint64(order.ExpirationBlockHeight))...)
+	}
 
 	return data
 }
@@ -4198,17 +4804,34 @@ func (order *DAOCoinLimitOrderEntry) RawDecodeWithoutMetadata(blockHeight uint64
 	}
 	order.BlockHeight = uint32(daoBlockHeight)
 
+	if MigrationTriggered(blockHeight, DAOCoinLimitOrderExpirationMigration) {
+		expirationBlockHeight, err := ReadUvarint(rr)
+		if err != nil {
+			return fmt.Errorf("DAOCoinLimitOrderEntry.Decode: Error reading ExpirationBlockHeight: %v", err)
+		}
+		if expirationBlockHeight > uint64(math.MaxUint32) {
+			return fmt.Errorf("DAOCoinLimitOrderEntry.FromBytes: Invalid expiration block height %d: "+
+				"Greater than max uint32", expirationBlockHeight)
+		}
+		order.ExpirationBlockHeight = uint32(expirationBlockHeight)
+	}
+
 	return nil
 }
 
 func (order *DAOCoinLimitOrderEntry) GetVersionByte(blockHeight uint64) byte {
-	return byte(0)
+	return GetMigrationVersion(blockHeight, DAOCoinLimitOrderExpirationMigration)
 }
 
 func (order *DAOCoinLimitOrderEntry) GetEncoderType() EncoderType {
 	return EncoderTypeDAOCoinLimitOrderEntry
 }
 
+// IsExpired returns true if the order can't be matched in the block at blockHeight.
+func (order *DAOCoinLimitOrderEntry) IsExpired(blockHeight uint32) bool {
+	return order.ExpirationBlockHeight != 0 && order.ExpirationBlockHeight < blockHeight
+}
+
 func (order *DAOCoinLimitOrderEntry) IsMarketOrder() bool {
 	// For ImmediateOrCancel and FillOrKill orders, the exchange
 	// rate can be zero, in which case it is ignored and the order
@@ -4219,6 +4842,24 @@ func (order *DAOCoinLimitOrderEntry) IsMarketOrder() bool {
 		order.ScaledExchangeRateCoinsToSellPerCoinToBuy.IsZero()
 }
 
+// setMarketOrderSlippageBound sets the min exchange rate of the orders that a market order with a
+// max slippage can match, given the first order it matched. It does nothing if the bound is already
+// set or if the order doesn't have a max slippage.
+func (order *DAOCoinLimitOrderEntry) setMarketOrderSlippageBound(firstMatchingOrder *DAOCoinLimitOrderEntry) {
+	if order.marketOrderMaxSlippageBasisPoints == 0 || order.marketOrderMinMatchingScaledExchangeRate != nil {
+		return
+	}
+	// The matching orders' exchange rates are in coins the transactor buys per coin the transactor
+	// sells, so a worse price is a lower exchange rate.
+	// Min exchange rate = Best exchange rate * (10000 - Max slippage basis points) / 10000
+	minExchangeRateBigInt := big.NewInt(0).Mul(
+		firstMatchingOrder.ScaledExchangeRateCoinsToSellPerCoinToBuy.ToBig(),
+		big.NewInt(int64(10000-order.marketOrderMaxSlippageBasisPoints)))
+	minExchangeRateBigInt.Div(minExchangeRateBigInt, big.NewInt(10000))
+	// This can't overflow since it's at most the exchange rate of the first matching order.
+	order.marketOrderMinMatchingScaledExchangeRate, _ = uint256.FromBig(minExchangeRateBigInt)
+}
+
 func (order *DAOCoinLimitOrderEntry) IsBetterMatchingOrderThan(other *DAOCoinLimitOrderEntry) bool {
 	// We prefer the order with the higher exchange rate. This would result
 	// in more of their selling DAO coin being offered to the transactor
@@ -4410,6 +5051,9 @@ func (order *FilledDAOCoinLimitOrder) RawEncodeWithoutMetadata(blockHeight uint6
 	data = append(data, EncodeUint256(order.CoinQuantityInBaseUnitsBought)...)
 	data = append(data, EncodeUint256(order.CoinQuantityInBaseUnitsSold)...)
 	data = append(data, BoolToByte(order.IsFulfilled))
+	if MigrationTriggered(blockHeight, DAOCoinLimitOrderMakerTakerFeesMigration) {
+		data = append(data, EncodeOptionalUint256(order.FeeInBaseUnitsPaid)...)
+	}
 
 	return data
 }
@@ -4464,13 +5108,113 @@ func (order *FilledDAOCoinLimitOrder) RawDecodeWithoutMetadata(blockHeight uint6
 		return errors.Wrapf(err, "FilledDAOCoinLimiteOrder.Decode: Problem reading IsFulfilled")
 	}
 
+	// FeeInBaseUnitsPaid
+	if MigrationTriggered(blockHeight, DAOCoinLimitOrderMakerTakerFeesMigration) {
+		if order.FeeInBaseUnitsPaid, err = ReadOptionalUint256(rr); err != nil {
+			return errors.Wrapf(err, "FilledDAOCoinLimiteOrder.Decode: Problem reading FeeInBaseUnitsPaid")
+		}
+	}
+
 	return nil
 }
 
 func (order *FilledDAOCoinLimitOrder) GetVersionByte(blockHeight uint64) byte {
-	return byte(0)
+	return GetMigrationVersion(blockHeight, DAOCoinLimitOrderMakerTakerFeesMigration)
 }
 
 func (order *FilledDAOCoinLimitOrder) GetEncoderType() EncoderType {
 	return EncoderTypeFilledDAOCoinLimitOrder
 }
+
+func ValidateGroupPublicKeyAndName(messagingPublicKey, keyName []byte) error {
+	// This is a helper function that allows us to verify messaging public key and key name.
+
+	// First validate the messagingPublicKey.
+	if err := IsByteArrayValidPublicKey(messagingPublicKey); err != nil {
+		return errors.Wrapf(err, "ValidateGroupPublicKeyAndName: "+
+			"Problem validating sender's messaging key: %v", messagingPublicKey)
+	}
+
+	// If we get here, it means that we have a valid messaging public key.
+	// Sanity-check messaging key name.
+	if len(keyName) < MinMessagingKeyNameCharacters {
+		return errors.Wrapf(RuleErrorMessagingKeyNameTooShort, "ValidateGroupPublicKeyAndName: "+
+			"Too few characters in key name: min = %v, provided = %v",
+			MinMessagingKeyNameCharacters, len(keyName))
+	}
+	if len(keyName) > MaxMessagingKeyNameCharacters {
+		return errors.Wrapf(RuleErrorMessagingKeyNameTooLong, "ValidateGroupPublicKeyAndName: "+
+			"Too many characters in key name: max = %v; provided = %v",
+			MaxMessagingKeyNameCharacters, len(keyName))
+	}
+	return nil
+}
+
+// DAOCoinLimitOrderTriggerEntry is a dormant trigger order. See
+// DAOCoinLimitOrderMetadata.TriggerScaledExchangeRate.
+type DAOCoinLimitOrderTriggerEntry struct {
+	// Order is the order that's placed on the book once the trigger is hit. Its
+	// BlockHeight is the height at which the trigger order was placed, and it's
+	// set to the height at which it's triggered when it's placed on the book.
+	Order *DAOCoinLimitOrderEntry
+	// TriggerScaledExchangeRate is scaled like the order's exchange rate, and is in
+	// the same units, i.e. the order's coins to sell per coin to buy.
+	TriggerScaledExchangeRate *uint256.Int
+
+	isDeleted bool
+}
+
+func (triggerEntry *DAOCoinLimitOrderTriggerEntry) Copy() *DAOCoinLimitOrderTriggerEntry {
+	return &DAOCoinLimitOrderTriggerEntry{
+		Order:                     triggerEntry.Order.Copy(),
+		TriggerScaledExchangeRate: triggerEntry.TriggerScaledExchangeRate.Clone(),
+		isDeleted:                 triggerEntry.isDeleted,
+	}
+}
+
+func (triggerEntry *DAOCoinLimitOrderTriggerEntry) ToMapKey() DAOCoinLimitOrderMapKey {
+	return triggerEntry.Order.ToMapKey()
+}
+
+func (triggerEntry *DAOCoinLimitOrderTriggerEntry) RawEncodeWithoutMetadata(blockHeight uint64, skipMetadata ...bool) []byte {
+	var data []byte
+
+	data = append(data, EncodeToBytes(blockHeight, triggerEntry.Order, skipMetadata...)...)
+	data = append(data, EncodeUint256(triggerEntry.TriggerScaledExchangeRate)...)
+
+	return data
+}
+
+func (triggerEntry *DAOCoinLimitOrderTriggerEntry) RawDecodeWithoutMetadata(blockHeight uint64, rr *bytes.Reader) error {
+	var err error
+
+	// Order
+	order := &DAOCoinLimitOrderEntry{}
+	if exist, err := DecodeFromBytes(order, rr); exist && err == nil {
+		triggerEntry.Order = order
+	} else if err != nil {
+		return errors.Wrapf(err, "DAOCoinLimitOrderTriggerEntry.Decode: Problem reading Order")
+	}
+
+	// TriggerScaledExchangeRate
+	if triggerEntry.TriggerScaledExchangeRate, err = DecodeUint256(rr); err != nil {
+		return errors.Wrapf(err, "DAOCoinLimitOrderTriggerEntry.Decode: Problem reading TriggerScaledExchangeRate")
+	}
+
+	return nil
+}
+
+func (triggerEntry *DAOCoinLimitOrderTriggerEntry) GetVersionByte(blockHeight uint64) byte {
+	return byte(0)
+}
+
+func (triggerEntry *DAOCoinLimitOrderTriggerEntry) GetEncoderType() EncoderType {
+	return EncoderTypeDAOCoinLimitOrderTriggerEntry
+}
+
+// DAOCoinLimitOrderPair identifies one side of a DAO coin order book: all of the
+// orders buying BuyingDAOCoinCreatorPKID and selling SellingDAOCoinCreatorPKID.
+type DAOCoinLimitOrderPair struct {
+	BuyingDAOCoinCreatorPKID  *PKID
+	SellingDAOCoinCreatorPKID *PKID
+}
@@ -0,0 +1,328 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"sort"
+)
+
+func (bav *UtxoView) _getUserAssociationEntry(
+	transactorPKID *PKID, targetPKID *PKID, associationType []byte) *UserAssociationEntry {
+
+	// If an entry exists in the in-memory map, return the value of that mapping.
+	associationKey := MakeUserAssociationKey(transactorPKID, targetPKID, associationType)
+	mapValue, existsMapValue := bav.UserAssociationKeyToUserAssociationEntry[associationKey]
+	if existsMapValue {
+		return mapValue
+	}
+
+	// If we get here it means no value exists in our in-memory map. In this case,
+	// defer to the db. If a mapping exists in the db, return it. If not, return
+	// nil. Either way, save the value to the in-memory view mapping got later.
+	// Like post associations, user associations are always stored in badger.
+	dbAssociationEntry := DBGetUserAssociationEntry(
+		bav.Handle, bav.Snapshot, transactorPKID, targetPKID, associationType)
+	if dbAssociationEntry != nil {
+		bav._setUserAssociationEntryMappings(dbAssociationEntry)
+	}
+	return dbAssociationEntry
+}
+
+func (bav *UtxoView) _setUserAssociationEntryMappings(associationEntry *UserAssociationEntry) {
+	// This function shouldn't be called with nil.
+	if associationEntry == nil {
+		glog.Errorf("_setUserAssociationEntryMappings: Called with nil UserAssociationEntry; " +
+			"this should never happen.")
+		return
+	}
+
+	bav.UserAssociationKeyToUserAssociationEntry[associationEntry.ToMapKey()] = associationEntry
+}
+
+func (bav *UtxoView) _deleteUserAssociationEntryMappings(associationEntry *UserAssociationEntry) {
+
+	// Create a tombstone entry.
+	tombstoneAssociationEntry := *associationEntry
+	tombstoneAssociationEntry.isDeleted = true
+
+	// Set the mappings to point to the tombstone entry.
+	bav._setUserAssociationEntryMappings(&tombstoneAssociationEntry)
+}
+
+// GetUserAssociationEntry returns the transactor's association of the given type with the
+// target, or nil if there isn't one.
+func (bav *UtxoView) GetUserAssociationEntry(
+	transactorPKID *PKID, targetPKID *PKID, associationType []byte) *UserAssociationEntry {
+
+	associationEntry := bav._getUserAssociationEntry(transactorPKID, targetPKID, associationType)
+	if associationEntry == nil || associationEntry.isDeleted {
+		return nil
+	}
+	return associationEntry
+}
+
+// GetUserAssociationsByTransactor returns up to limit of the transactor's associations with other
+// users, sorted by target PKID and then by type. If startTargetPKID is set, the page starts at the
+// association with startTargetPKID and startAssociationType, inclusive. A limit of zero returns all
+// of them.
+func (bav *UtxoView) GetUserAssociationsByTransactor(transactorPKID *PKID,
+	startTargetPKID *PKID, startAssociationType []byte, limit int) ([]*UserAssociationEntry, error) {
+
+	var startSortKey []byte
+	if startTargetPKID != nil {
+		startSortKey = append(append([]byte{}, startTargetPKID[:]...), startAssociationType...)
+	}
+	associationEntries, err := bav._getUserAssociationsPage(
+		func(associationEntry *UserAssociationEntry) bool {
+			return associationEntry.TransactorPKID.Eq(transactorPKID)
+		},
+		func(associationEntry *UserAssociationEntry) []byte {
+			return append(append([]byte{}, associationEntry.TargetPKID[:]...), associationEntry.AssociationType...)
+		},
+		startSortKey, limit,
+		func(numToFetch int) ([]*UserAssociationEntry, error) {
+			return DBGetUserAssociationsByTransactor(
+				bav.Handle, transactorPKID, startTargetPKID, startAssociationType, numToFetch)
+		})
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetUserAssociationsByTransactor: ")
+	}
+	return associationEntries, nil
+}
+
+// GetUserAssociationsForTarget returns up to limit of the associations other users have with the
+// target, sorted by transactor PKID and then by type. If startTransactorPKID is set, the page starts
+// at the association with startTransactorPKID and startAssociationType, inclusive. A limit of zero
+// returns all of them.
+func (bav *UtxoView) GetUserAssociationsForTarget(targetPKID *PKID,
+	startTransactorPKID *PKID, startAssociationType []byte, limit int) ([]*UserAssociationEntry, error) {
+
+	var startSortKey []byte
+	if startTransactorPKID != nil {
+		startSortKey = append(append([]byte{}, startTransactorPKID[:]...), startAssociationType...)
+	}
+	associationEntries, err := bav._getUserAssociationsPage(
+		func(associationEntry *UserAssociationEntry) bool {
+			return associationEntry.TargetPKID.Eq(targetPKID)
+		},
+		func(associationEntry *UserAssociationEntry) []byte {
+			return append(append([]byte{}, associationEntry.TransactorPKID[:]...), associationEntry.AssociationType...)
+		},
+		startSortKey, limit,
+		func(numToFetch int) ([]*UserAssociationEntry, error) {
+			return DBGetUserAssociationsByTarget(
+				bav.Handle, targetPKID, startTransactorPKID, startAssociationType, numToFetch)
+		})
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetUserAssociationsForTarget: ")
+	}
+	return associationEntries, nil
+}
+
+// _getUserAssociationsPage merges the associations in the view into a page of the associations
+// in the db, the same way _getPostAssociationsPage does for post associations.
+func (bav *UtxoView) _getUserAssociationsPage(
+	isInPage func(*UserAssociationEntry) bool,
+	sortKey func(*UserAssociationEntry) []byte,
+	startSortKey []byte,
+	limit int,
+	getDBEntries func(numToFetch int) ([]*UserAssociationEntry, error),
+) ([]*UserAssociationEntry, error) {
+
+	var viewEntries []*UserAssociationEntry
+	for _, associationEntry := range bav.UserAssociationKeyToUserAssociationEntry {
+		if isInPage(associationEntry) && bytes.Compare(sortKey(associationEntry), startSortKey) >= 0 {
+			viewEntries = append(viewEntries, associationEntry)
+		}
+	}
+
+	numToFetch := 0
+	if limit > 0 {
+		numToFetch = limit + len(viewEntries)
+	}
+	dbEntries, err := getDBEntries(numToFetch)
+	if err != nil {
+		return nil, err
+	}
+
+	// The entries in the view take precedence over the ones in the db.
+	associationEntries := []*UserAssociationEntry{}
+	for _, dbEntry := range dbEntries {
+		if _, existsMapValue := bav.UserAssociationKeyToUserAssociationEntry[dbEntry.ToMapKey()]; !existsMapValue {
+			associationEntries = append(associationEntries, dbEntry)
+		}
+	}
+	for _, viewEntry := range viewEntries {
+		if !viewEntry.isDeleted {
+			associationEntries = append(associationEntries, viewEntry)
+		}
+	}
+
+	sort.Slice(associationEntries, func(ii, jj int) bool {
+		return bytes.Compare(sortKey(associationEntries[ii]), sortKey(associationEntries[jj])) < 0
+	})
+	if limit > 0 && len(associationEntries) > limit {
+		associationEntries = associationEntries[:limit]
+	}
+	return associationEntries, nil
+}
+
+func (bav *UtxoView) _connectUserAssociation(
+	txn *MsgDeSoTxn, txHash *BlockHash, blockHeight uint32, verifySignatures bool) (
+	_totalInput uint64, _totalOutput uint64, _utxoOps []*UtxoOperation, _err error) {
+
+	// User associations are only allowed after the UserAssociationBlockHeight.
+	if blockHeight < bav.Params.ForkHeights.UserAssociationBlockHeight {
+		return 0, 0, nil, errors.Wrapf(RuleErrorUserAssociationBeforeBlockHeight,
+			"_connectUserAssociation: ")
+	}
+
+	// Check that the transaction has the right TxnType.
+	if txn.TxnMeta.GetTxnType() != TxnTypeUserAssociation {
+		return 0, 0, nil, fmt.Errorf("_connectUserAssociation: called with bad TxnType %s",
+			txn.TxnMeta.GetTxnType().String())
+	}
+	txMeta := txn.TxnMeta.(*UserAssociationMetadata)
+
+	// Check that a proper public key is provided in the message metadata
+	if len(txMeta.TargetUserPublicKey) != btcec.PubKeyBytesLenCompressed {
+		return 0, 0, nil, errors.Wrapf(
+			RuleErrorUserAssociationTargetPubKeyLen, "_connectUserAssociation: "+
+				"TargetUserPubKeyLen = %d; Expected length = %d",
+			len(txMeta.TargetUserPublicKey), btcec.PubKeyBytesLenCompressed)
+	}
+
+	// Connect basic txn to get the total input and the total output without
+	// considering the transaction metadata.
+	totalInput, totalOutput, utxoOpsForTxn, err := bav._connectBasicTransfer(
+		txn, txHash, blockHeight, verifySignatures)
+	if err != nil {
+		return 0, 0, nil, errors.Wrapf(err, "_connectUserAssociation: ")
+	}
+
+	// At this point the inputs and outputs have been processed. Now we need to handle
+	// the metadata.
+	if len(txMeta.AssociationType) == 0 || len(txMeta.AssociationType) > MaxUserAssociationTypeLengthBytes {
+		return 0, 0, nil, errors.Wrapf(RuleErrorUserAssociationInvalidType,
+			"_connectUserAssociation: AssociationType has length %d, must be between 1 and %d",
+			len(txMeta.AssociationType), MaxUserAssociationTypeLengthBytes)
+	}
+	if len(txMeta.AssociationValue) > MaxUserAssociationValueLengthBytes {
+		return 0, 0, nil, errors.Wrapf(RuleErrorUserAssociationValueTooLong,
+			"_connectUserAssociation: AssociationValue has length %d > %d",
+			len(txMeta.AssociationValue), MaxUserAssociationValueLengthBytes)
+	}
+
+	// Get the PKIDs for the public keys associated with the transactor and the target.
+	transactorPKID := bav.GetPKIDForPublicKey(txn.PublicKey)
+	if transactorPKID == nil || transactorPKID.isDeleted {
+		return 0, 0, nil, fmt.Errorf("_connectUserAssociation: transactorPKID was nil or deleted; " +
+			"this should never happen")
+	}
+	targetPKID := bav.GetPKIDForPublicKey(txMeta.TargetUserPublicKey)
+	if targetPKID == nil || targetPKID.isDeleted {
+		return 0, 0, nil, fmt.Errorf("_connectUserAssociation: targetPKID was nil or deleted; " +
+			"this should never happen")
+	}
+
+	// At this point the code diverges and considers the create / delete flows differently
+	// since the presence of an existing association has a different effect in either case.
+	existingAssociationEntry := bav.GetUserAssociationEntry(
+		transactorPKID.PKID, targetPKID.PKID, txMeta.AssociationType)
+	if txMeta.IsDelete {
+		if len(txMeta.AssociationValue) != 0 {
+			return 0, 0, nil, errors.Wrapf(RuleErrorUserAssociationDeleteWithValue,
+				"_connectUserAssociation: ")
+		}
+		// Ensure that there *is* an existing association to delete.
+		if existingAssociationEntry == nil {
+			return 0, 0, nil, errors.Wrapf(RuleErrorCannotDeleteNonexistentUserAssociation,
+				"_connectUserAssociation: Type: %v, Target pub key: %v",
+				string(txMeta.AssociationType), PkToStringBoth(txMeta.TargetUserPublicKey))
+		}
+		bav._deleteUserAssociationEntryMappings(existingAssociationEntry)
+	} else {
+		// Creating an association replaces the existing one, if there is one.
+		bav._setUserAssociationEntryMappings(&UserAssociationEntry{
+			TransactorPKID:   transactorPKID.PKID,
+			TargetPKID:       targetPKID.PKID,
+			AssociationType:  txMeta.AssociationType,
+			AssociationValue: txMeta.AssociationValue,
+		})
+	}
+
+	// Add an operation to the list at the end indicating we've changed an association.
+	utxoOpsForTxn = append(utxoOpsForTxn, &UtxoOperation{
+		Type:                     OperationTypeUserAssociation,
+		PrevUserAssociationEntry: existingAssociationEntry,
+	})
+
+	return totalInput, totalOutput, utxoOpsForTxn, nil
+}
+
+func (bav *UtxoView) _disconnectUserAssociation(
+	operationType OperationType, currentTxn *MsgDeSoTxn, txnHash *BlockHash,
+	utxoOpsForTxn []*UtxoOperation, blockHeight uint32) error {
+
+	// Verify that the last operation is a UserAssociation operation
+	if len(utxoOpsForTxn) == 0 {
+		return fmt.Errorf("_disconnectUserAssociation: utxoOperations are missing")
+	}
+	operationIndex := len(utxoOpsForTxn) - 1
+	if utxoOpsForTxn[operationIndex].Type != OperationTypeUserAssociation {
+		return fmt.Errorf("_disconnectUserAssociation: Trying to revert "+
+			"OperationTypeUserAssociation but found type %v",
+			utxoOpsForTxn[operationIndex].Type)
+	}
+
+	// Now we know the txMeta is a UserAssociation
+	txMeta := currentTxn.TxnMeta.(*UserAssociationMetadata)
+	transactorPKID := bav.GetPKIDForPublicKey(currentTxn.PublicKey)
+	if transactorPKID == nil || transactorPKID.isDeleted {
+		return fmt.Errorf("_disconnectUserAssociation: transactorPKID was nil or deleted; " +
+			"this should never happen")
+	}
+	targetPKID := bav.GetPKIDForPublicKey(txMeta.TargetUserPublicKey)
+	if targetPKID == nil || targetPKID.isDeleted {
+		return fmt.Errorf("_disconnectUserAssociation: targetPKID was nil or deleted; " +
+			"this should never happen")
+	}
+
+	prevAssociationEntry := utxoOpsForTxn[operationIndex].PrevUserAssociationEntry
+	if prevAssociationEntry != nil {
+		// Sanity check: verify that the previous association is the one the txn changed.
+		if prevAssociationEntry.ToMapKey() != MakeUserAssociationKey(
+			transactorPKID.PKID, targetPKID.PKID, txMeta.AssociationType) {
+
+			return fmt.Errorf("_disconnectUserAssociation: PrevUserAssociationEntry %v "+
+				"doesn't match the txn's association", prevAssociationEntry.ToMapKey())
+		}
+
+		// Set the association back to its previous state, whether it was replaced or deleted.
+		bav._setUserAssociationEntryMappings(prevAssociationEntry)
+	} else {
+		// If there was no previous association then the txn created one, which we delete.
+		if txMeta.IsDelete {
+			return fmt.Errorf("_disconnectUserAssociation: PrevUserAssociationEntry is " +
+				"missing for a txn that deleted an association")
+		}
+		associationEntry := bav.GetUserAssociationEntry(
+			transactorPKID.PKID, targetPKID.PKID, txMeta.AssociationType)
+		if associationEntry == nil {
+			return fmt.Errorf("_disconnectUserAssociation: UserAssociationEntry for "+
+				"type %v and target %v was found to be nil or deleted",
+				string(txMeta.AssociationType), PkToStringBoth(txMeta.TargetUserPublicKey))
+		}
+		bav._deleteUserAssociationEntryMappings(associationEntry)
+	}
+
+	// Now revert the basic transfer with the remaining operations. Cut off
+	// the UserAssociation operation at the end since we just reverted it.
+	return bav._disconnectBasicTransfer(
+		currentTxn, txnHash, utxoOpsForTxn[:operationIndex], blockHeight)
+}
@@ -0,0 +1,293 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"bytes"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func _doUserAssociationTxnWithTestMeta(
+	testMeta *TestMeta,
+	feeRateNanosPerKB uint64,
+	TransactorPublicKeyBase58Check string,
+	TransactorPrivateKeyBase58Check string,
+	metadata UserAssociationMetadata) {
+
+	testMeta.expectedSenderBalances = append(
+		testMeta.expectedSenderBalances, _getBalance(testMeta.t, testMeta.chain, nil, TransactorPublicKeyBase58Check))
+
+	currentOps, currentTxn, _, err := _doUserAssociationTxn(testMeta.t, testMeta.chain, testMeta.db, testMeta.params,
+		feeRateNanosPerKB, TransactorPublicKeyBase58Check, TransactorPrivateKeyBase58Check, metadata)
+
+	require.NoError(testMeta.t, err)
+	testMeta.txnOps = append(testMeta.txnOps, currentOps)
+	testMeta.txns = append(testMeta.txns, currentTxn)
+}
+
+func _doUserAssociationTxn(t *testing.T, chain *Blockchain, db *badger.DB,
+	params *DeSoParams, feeRateNanosPerKB uint64,
+	TransactorPublicKeyBase58Check string,
+	TransactorPrivateKeyBase58Check string,
+	metadata UserAssociationMetadata) (
+	_utxoOps []*UtxoOperation, _txn *MsgDeSoTxn, _height uint32, _err error) {
+
+	require := require.New(t)
+
+	transactorPkBytes, _, err := Base58CheckDecode(TransactorPublicKeyBase58Check)
+	require.NoError(err)
+
+	utxoView, err := NewUtxoView(db, params, chain.postgres, chain.snapshot)
+	require.NoError(err)
+
+	txn, totalInputMake, changeAmountMake, feesMake, err := chain.CreateUserAssociationTxn(
+		transactorPkBytes, metadata.TargetUserPublicKey, metadata.AssociationType, metadata.AssociationValue,
+		metadata.IsDelete, feeRateNanosPerKB, nil, []*DeSoOutput{})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	require.Equal(totalInputMake, changeAmountMake+feesMake)
+
+	// Sign the transaction now that its inputs are set up.
+	_signTxn(t, txn, TransactorPrivateKeyBase58Check)
+
+	txHash := txn.Hash()
+	// Always use height+1 for validation since it's assumed the transaction will
+	// get mined into the next block.
+	blockHeight := chain.blockTip().Height + 1
+	utxoOps, totalInput, totalOutput, fees, err :=
+		utxoView.ConnectTransaction(txn, txHash, getTxnSize(*txn), blockHeight, true, /*verifySignature*/
+			false /*ignoreUtxos*/)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	require.Equal(totalInput, totalOutput+fees)
+	require.Equal(totalInput, totalInputMake)
+
+	// We should have one SPEND UtxoOperation for each input, one ADD operation
+	// for each output, and one OperationTypeUserAssociation operation at the end.
+	require.Equal(len(txn.TxInputs)+len(txn.TxOutputs)+1, len(utxoOps))
+	for ii := 0; ii < len(txn.TxInputs); ii++ {
+		require.Equal(OperationTypeSpendUtxo, utxoOps[ii].Type)
+	}
+	require.Equal(OperationTypeUserAssociation, utxoOps[len(utxoOps)-1].Type)
+
+	require.NoError(utxoView.FlushToDb(uint64(blockHeight)))
+
+	return utxoOps, txn, blockHeight, nil
+}
+
+func TestUserAssociation(t *testing.T) {
+	// -----------------------
+	// Initialization
+	// -----------------------
+
+	// Test constants
+	const feeRateNanosPerKb = uint64(101)
+	endorseType := []byte("ENDORSE")
+	verifyType := []byte("VERIFY")
+
+	// Initialize test chain and miner.
+	require := require.New(t)
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+
+	params.ForkHeights.UserAssociationBlockHeight = uint32(math.MaxUint32)
+
+	// Mine a few blocks to give the senderPkString some money.
+	for ii := 0; ii < 4; ii++ {
+		_, err := miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+	}
+
+	// We build the testMeta obj after mining blocks so that we save the correct block height.
+	testMeta := &TestMeta{
+		t:           t,
+		chain:       chain,
+		params:      params,
+		db:          db,
+		mempool:     mempool,
+		miner:       miner,
+		savedHeight: chain.blockTip().Height + 1,
+	}
+
+	_registerOrTransferWithTestMeta(testMeta, "m0", senderPkString, m0Pub, senderPrivString, 1000)
+	_registerOrTransferWithTestMeta(testMeta, "m1", senderPkString, m1Pub, senderPrivString, 1000)
+
+	m0PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m0PkBytes).PKID
+	m1PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m1PkBytes).PKID
+	m2PKID := DBGetPKIDEntryForPublicKey(db, chain.snapshot, m2PkBytes).PKID
+
+	associationMetadata := func(targetPkBytes []byte, associationType []byte, value string) UserAssociationMetadata {
+		return UserAssociationMetadata{
+			TargetUserPublicKey: targetPkBytes,
+			AssociationType:     associationType,
+			AssociationValue:    []byte(value),
+		}
+	}
+	deleteMetadata := func(targetPkBytes []byte, associationType []byte) UserAssociationMetadata {
+		return UserAssociationMetadata{
+			TargetUserPublicKey: targetPkBytes,
+			AssociationType:     associationType,
+			IsDelete:            true,
+		}
+	}
+	getAssociationValue := func(transactorPKID *PKID, targetPKID *PKID, associationType []byte) []byte {
+		associationEntry := DBGetUserAssociationEntry(db, chain.snapshot, transactorPKID, targetPKID, associationType)
+		if associationEntry == nil {
+			return nil
+		}
+		return associationEntry.AssociationValue
+	}
+	getAssociationKeys := func(associationEntries []*UserAssociationEntry) []UserAssociationKey {
+		var associationKeys []UserAssociationKey
+		for _, associationEntry := range associationEntries {
+			associationKeys = append(associationKeys, associationEntry.ToMapKey())
+		}
+		return associationKeys
+	}
+
+	// -----------------------
+	// Tests
+	// -----------------------
+
+	// The metadata survives encoding.
+	{
+		metadata := associationMetadata(m1PkBytes, endorseType, "solidity")
+		metadataBytes, err := metadata.ToBytes(false)
+		require.NoError(err)
+		decodedMetadata := &UserAssociationMetadata{}
+		require.NoError(decodedMetadata.FromBytes(metadataBytes))
+		require.Equal(&metadata, decodedMetadata)
+	}
+
+	// RuleErrorUserAssociationBeforeBlockHeight
+	{
+		_, _, _, err := _doUserAssociationTxn(t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv,
+			associationMetadata(m1PkBytes, endorseType, "solidity"))
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorUserAssociationBeforeBlockHeight)
+	}
+
+	params.ForkHeights.UserAssociationBlockHeight = uint32(0)
+
+	// Invalid associations are rejected.
+	{
+		_, _, _, err := _doUserAssociationTxn(t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv,
+			associationMetadata(m1PkBytes, []byte{}, "solidity"))
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorUserAssociationInvalidType)
+
+		_, _, _, err = _doUserAssociationTxn(t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv,
+			associationMetadata(m1PkBytes, endorseType, string(make([]byte, MaxUserAssociationValueLengthBytes+1))))
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorUserAssociationValueTooLong)
+
+		_, _, _, err = _doUserAssociationTxn(t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv,
+			deleteMetadata(m1PkBytes, endorseType))
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorCannotDeleteNonexistentUserAssociation)
+	}
+
+	// m0 endorses and verifies m1, and endorses m2, who doesn't need to have made a txn.
+	_doUserAssociationTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv,
+		associationMetadata(m1PkBytes, endorseType, "solidity"))
+	_doUserAssociationTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv,
+		associationMetadata(m1PkBytes, verifyType, "kyc"))
+	_doUserAssociationTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv,
+		associationMetadata(m2PkBytes, endorseType, "go"))
+	// m1 endorses m0 back.
+	_doUserAssociationTxnWithTestMeta(testMeta, feeRateNanosPerKb, m1Pub, m1Priv,
+		associationMetadata(m0PkBytes, endorseType, "rust"))
+	{
+		require.Equal([]byte("solidity"), getAssociationValue(m0PKID, m1PKID, endorseType))
+		require.Equal([]byte("kyc"), getAssociationValue(m0PKID, m1PKID, verifyType))
+		require.Equal([]byte("go"), getAssociationValue(m0PKID, m2PKID, endorseType))
+		require.Equal([]byte("rust"), getAssociationValue(m1PKID, m0PKID, endorseType))
+	}
+
+	// m0 changes their endorsement of m1, and removes their endorsement of m2.
+	{
+		_, _, _, err := _doUserAssociationTxn(t, chain, db, params, feeRateNanosPerKb, m0Pub, m0Priv,
+			UserAssociationMetadata{
+				TargetUserPublicKey: m2PkBytes,
+				AssociationType:     endorseType,
+				AssociationValue:    []byte("go"),
+				IsDelete:            true,
+			})
+		require.Error(err)
+		require.Contains(err.Error(), RuleErrorUserAssociationDeleteWithValue)
+
+		_doUserAssociationTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv,
+			associationMetadata(m1PkBytes, endorseType, "solidity,go"))
+		_doUserAssociationTxnWithTestMeta(testMeta, feeRateNanosPerKb, m0Pub, m0Priv,
+			deleteMetadata(m2PkBytes, endorseType))
+		require.Equal([]byte("solidity,go"), getAssociationValue(m0PKID, m1PKID, endorseType))
+		require.Nil(getAssociationValue(m0PKID, m2PKID, endorseType))
+	}
+
+	// m2 endorses m1 so that m1 has associations from two users to page through.
+	_registerOrTransferWithTestMeta(testMeta, "m2", senderPkString, m2Pub, senderPrivString, 1000)
+	_doUserAssociationTxnWithTestMeta(testMeta, feeRateNanosPerKb, m2Pub, m2Priv,
+		associationMetadata(m1PkBytes, endorseType, "go"))
+
+	// Associations can be paged through by transactor and by target.
+	{
+		m0EndorseKey := MakeUserAssociationKey(m0PKID, m1PKID, endorseType)
+		m0VerifyKey := MakeUserAssociationKey(m0PKID, m1PKID, verifyType)
+		m2EndorseKey := MakeUserAssociationKey(m2PKID, m1PKID, endorseType)
+		m1Associations := []UserAssociationKey{m0EndorseKey, m0VerifyKey, m2EndorseKey}
+		if bytes.Compare(m0PKID[:], m2PKID[:]) > 0 {
+			m1Associations = []UserAssociationKey{m2EndorseKey, m0EndorseKey, m0VerifyKey}
+		}
+
+		associationEntries, err := DBGetUserAssociationsByTarget(db, m1PKID, nil, nil, 0)
+		require.NoError(err)
+		require.Equal(m1Associations, getAssociationKeys(associationEntries))
+		associationEntries, err = DBGetUserAssociationsByTarget(db, m1PKID, nil, nil, 2)
+		require.NoError(err)
+		require.Equal(m1Associations[:2], getAssociationKeys(associationEntries))
+		startKey := m1Associations[1]
+		associationEntries, err = DBGetUserAssociationsByTarget(
+			db, m1PKID, &startKey.TransactorPKID, []byte(startKey.AssociationType), 2)
+		require.NoError(err)
+		require.Equal(m1Associations[1:], getAssociationKeys(associationEntries))
+
+		associationEntries, err = DBGetUserAssociationsByTransactor(db, m0PKID, nil, nil, 0)
+		require.NoError(err)
+		require.Equal([]UserAssociationKey{m0EndorseKey, m0VerifyKey}, getAssociationKeys(associationEntries))
+		associationEntries, err = DBGetUserAssociationsByTransactor(db, m0PKID, m1PKID, verifyType, 0)
+		require.NoError(err)
+		require.Equal([]UserAssociationKey{m0VerifyKey}, getAssociationKeys(associationEntries))
+
+		// Changes in the view take precedence over the db.
+		utxoView, err := NewUtxoView(db, params, chain.postgres, chain.snapshot)
+		require.NoError(err)
+		associationEntry := utxoView.GetUserAssociationEntry(m0PKID, m1PKID, endorseType)
+		require.NotNil(associationEntry)
+		utxoView._deleteUserAssociationEntryMappings(associationEntry)
+		associationEntries, err = utxoView.GetUserAssociationsByTransactor(m0PKID, nil, nil, 1)
+		require.NoError(err)
+		require.Equal([]UserAssociationKey{m0VerifyKey}, getAssociationKeys(associationEntries))
+		associationEntries, err = utxoView.GetUserAssociationsForTarget(m1PKID, nil, nil, 0)
+		require.NoError(err)
+		require.NotContains(getAssociationKeys(associationEntries), m0EndorseKey)
+		require.Len(associationEntries, 2)
+	}
+
+	_executeAllTestRollbackAndFlush(testMeta)
+
+	// Every association is gone once the txns are rolled back.
+	{
+		associationEntries, err := DBGetUserAssociationsByTarget(db, m1PKID, nil, nil, 0)
+		require.NoError(err)
+		require.Empty(associationEntries)
+		associationEntries, err = DBGetUserAssociationsByTransactor(db, m0PKID, nil, nil, 0)
+		require.NoError(err)
+		require.Empty(associationEntries)
+	}
+}
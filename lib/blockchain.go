@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -11,10 +13,8 @@ import (
 	"reflect"
 	"runtime/debug"
 	"sort"
-	"strings"
 	"time"
 
-	btcdchain "github.com/btcsuite/btcd/blockchain"
 	chainlib "github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/wire"
@@ -53,108 +53,6 @@ const (
 	MaxBlockIndexNodes = 5000000
 )
 
-type BlockStatus uint32
-
-const (
-	StatusNone BlockStatus = 0
-
-	// Headers must always be Validated or ValidateFailed. We
-	// don't store orphan headers and therefore any header that we do
-	// have in our node index will be known definitively to be valid or
-	// invalid one way or the other.
-	StatusHeaderValidated = 1 << iota
-	StatusHeaderValidateFailed
-
-	StatusBlockProcessed
-	StatusBlockStored
-	StatusBlockValidated
-	StatusBlockValidateFailed
-
-	StatusBitcoinHeaderValidated      // Deprecated
-	StatusBitcoinHeaderValidateFailed // Deprecated
-)
-
-// IsFullyProcessed determines if the BlockStatus corresponds to a fully processed and stored block.
-func (blockStatus BlockStatus) IsFullyProcessed() bool {
-	return blockStatus&StatusHeaderValidated != 0 &&
-		blockStatus&StatusBlockStored != 0 &&
-		blockStatus&StatusBlockProcessed != 0 &&
-		blockStatus&StatusBlockValidated != 0
-}
-
-func (blockStatus BlockStatus) String() string {
-	if blockStatus == 0 {
-		return "NONE"
-	}
-
-	statuses := []string{}
-	if blockStatus&StatusHeaderValidated != 0 {
-		statuses = append(statuses, "HEADER_VALIDATED")
-		blockStatus ^= StatusHeaderValidated
-	}
-	if blockStatus&StatusHeaderValidateFailed != 0 {
-		statuses = append(statuses, "HEADER_VALIDATE_FAILED")
-		blockStatus ^= StatusHeaderValidateFailed
-	}
-	if blockStatus&StatusBlockProcessed != 0 {
-		statuses = append(statuses, "BLOCK_PROCESSED")
-		blockStatus ^= StatusBlockProcessed
-	}
-	if blockStatus&StatusBlockStored != 0 {
-		statuses = append(statuses, "BLOCK_STORED")
-		blockStatus ^= StatusBlockStored
-	}
-	if blockStatus&StatusBlockValidated != 0 {
-		statuses = append(statuses, "BLOCK_VALIDATED")
-		blockStatus ^= StatusBlockValidated
-	}
-	if blockStatus&StatusBlockValidateFailed != 0 {
-		statuses = append(statuses, "BLOCK_VALIDATE_FAILED")
-		blockStatus ^= StatusBlockValidateFailed
-	}
-
-	// If at this point the blockStatus isn't zeroed out then
-	// we have an unknown status remaining.
-	if blockStatus != 0 {
-		statuses = append(statuses, "ERROR_UNKNOWN_STATUS!")
-	}
-
-	return strings.Join(statuses, " | ")
-}
-
-// Add some fields in addition to the header to aid in the selection
-// of the best chain.
-type BlockNode struct {
-	// Pointer to a node representing the block's parent.
-	Parent *BlockNode
-
-	// The hash computed on this block.
-	Hash *BlockHash
-
-	// Height is the position in the block chain.
-	Height uint32
-
-	// The difficulty target for this block. Used to compute the next
-	// block's difficulty target so it can be validated.
-	DifficultyTarget *BlockHash
-
-	// A computation of the total amount of work that has been performed
-	// on this chain, including the current node.
-	CumWork *big.Int
-
-	// The block header.
-	Header *MsgDeSoHeader
-
-	// Status holds the validation state for the block and whether or not
-	// it's stored in the database.
-	Status BlockStatus
-}
-
-func _difficultyBitsToHash(diffBits uint32) (_diffHash *BlockHash) {
-	diffBigint := btcdchain.CompactToBig(diffBits)
-	return BigintToHash(diffBigint)
-}
-
 func ExtractBitcoinBurnTransactionsFromBitcoinBlock(
 	bitcoinBlock *wire.MsgBlock, bitcoinBurnAddress string, params *DeSoParams) []*wire.MsgTx {
 
@@ -271,62 +169,6 @@ func ExtractBitcoinExchangeTransactionsFromBitcoinBlock(
 	return bitcoinExchangeTxns, nil
 }
 
-func (nn *BlockNode) String() string {
-	var parentHash *BlockHash
-	if nn.Parent != nil {
-		parentHash = nn.Parent.Hash
-	}
-	tstamp := uint32(0)
-	if nn.Header != nil {
-		tstamp = uint32(nn.Header.TstampSecs)
-	}
-	return fmt.Sprintf("< TstampSecs: %d, Height: %d, Hash: %s, ParentHash %s, Status: %s, CumWork: %v>",
-		tstamp, nn.Header.Height, nn.Hash, parentHash, nn.Status, nn.CumWork)
-}
-
-// TODO: Height not needed in this since it's in the header.
-func NewBlockNode(
-	parent *BlockNode,
-	hash *BlockHash,
-	height uint32,
-	difficultyTarget *BlockHash,
-	cumWork *big.Int,
-	header *MsgDeSoHeader,
-	status BlockStatus) *BlockNode {
-
-	return &BlockNode{
-		Parent:           parent,
-		Hash:             hash,
-		Height:           height,
-		DifficultyTarget: difficultyTarget,
-		CumWork:          cumWork,
-		Header:           header,
-		Status:           status,
-	}
-}
-
-func (nn *BlockNode) Ancestor(height uint32) *BlockNode {
-	if height > nn.Height {
-		return nil
-	}
-
-	node := nn
-	for ; node != nil && node.Height != height; node = node.Parent {
-		// Keep iterating node until the condition no longer holds.
-	}
-
-	return node
-}
-
-// RelativeAncestor returns the ancestor block node a relative 'distance' blocks
-// before this node. This is equivalent to calling Ancestor with the node's
-// height minus provided distance.
-//
-// This function is safe for concurrent access.
-func (nn *BlockNode) RelativeAncestor(distance uint32) *BlockNode {
-	return nn.Ancestor(nn.Height - distance)
-}
-
 // CalcNextDifficultyTarget computes the difficulty target expected of the
 // next block.
 func CalcNextDifficultyTarget(
@@ -396,11 +238,6 @@ func CalcNextDifficultyTarget(
 	return BigintToHash(nextDiffBigint), nil
 }
 
-type OrphanBlock struct {
-	Block *MsgDeSoBlock
-	Hash  *BlockHash
-}
-
 type Blockchain struct {
 	db                              *badger.DB
 	postgres                        *Postgres
@@ -412,6 +249,29 @@ type Blockchain struct {
 	params                          *DeSoParams
 	eventManager                    *EventManager
 
+	// readGenerations retains recent views of the db for paginated reads.
+	readGenerations *ReadGenerationManager
+
+	// validationFailureLog persists recent block and txn rejections.
+	validationFailureLog *ValidationFailureLog
+
+	// indexQueue is set when non-consensus indexes are updated in the background. Block
+	// connects and disconnects then enqueue tasks for it in the same txn as the flush.
+	indexQueue *IndexQueue
+
+	// eventJournal is set when block connects and disconnects are journaled. The events are
+	// written in the same txn as the flush.
+	eventJournal *EventJournal
+
+	// lastStateChangeFlushId is the flush id of the last state changes recorded for the
+	// EventManager's OnStateChangesFlushed handlers.
+	lastStateChangeFlushId uint64
+
+	// blockSubscriptions are notified of every block the eventManager reports as connected or
+	// disconnected. See SubscribeBlocks.
+	blockSubscriptions     map[*BlockSubscription]bool
+	blockSubscriptionsLock deadlock.Mutex
+
 	// Archival mode determines if we'll be downloading historical blocks after finishing hypersync.
 	// It is turned off by default, meaning we won't be downloading blocks prior to the first snapshot
 	// height, nor we'll be downloading utxoops for these blocks. This is OK because we're assuming a
@@ -419,6 +279,18 @@ type Blockchain struct {
 	// syncing node to re-run hypersync, which is a tiny overhead. Moreover, we are moving away from
 	// utxoops overall. They'll only be needed close to the tip to handle reorgs and nowhere else.
 	archivalMode bool
+	// blockPruneDepth is the number of blocks below the tip that keep their bodies and utxo
+	// operations, or zero if blocks aren't pruned. See SetBlockPruneDepth.
+	blockPruneDepth uint32
+	// prunedBlockHeight is the height of the highest main chain block that was pruned. It's
+	// read from the db, since blocks stay pruned if pruning is turned off.
+	prunedBlockHeight uint64
+	// utxoOpsRetention is the number of blocks below the tip that keep their utxo operations,
+	// or zero if they're kept for every block. See SetUtxoOpsRetention.
+	utxoOpsRetention uint32
+	// utxoOpsPrunedBlockHeight is the height of the highest main chain block whose utxo
+	// operations were pruned by the retention policy. Like prunedBlockHeight, it's read from the db.
+	utxoOpsPrunedBlockHeight uint64
 	// Returns true once all of the housekeeping in creating the
 	// blockchain is complete. This includes setting up the genesis block.
 	isInitialized bool
@@ -440,8 +312,9 @@ type Blockchain struct {
 	bestHeaderChainMap map[BlockHash]*BlockNode
 
 	// We keep track of orphan blocks with the following data structures. Orphans
-	// are not written to disk and are only cached in memory. Moreover we only keep
-	// up to MaxOrphansInMemory of them in order to prevent memory exhaustion.
+	// are also written to PrefixOrphanBlocks so they survive restarts, see
+	// block_caches.go. Moreover we only keep up to MaxOrphansInMemory of them in
+	// order to prevent memory exhaustion.
 	orphanList *list.List
 
 	// We connect many blocks in the same view and flush every X number of blocks
@@ -570,11 +443,27 @@ func (bc *Blockchain) _initChain() error {
 			return fmt.Errorf("_initChain(block): Best hash (%#v) not found in block index", bestBlockHash)
 		}
 
-		// Walk back from the best node to the genesis block and store them all
-		// in bestChain.
-		bc.bestChain, err = GetBestChain(tipNode, bc.blockIndex)
-		if err != nil {
-			return errors.Wrapf(err, "_initChain(block): Problem reading best chain from db")
+		// Read the best chain from the main chain index if it matches the block index.
+		// Otherwise, walk back from the best node to the genesis block and store them
+		// all in bestChain, and rebuild the index from it.
+		var mainChainIndexMatches bool
+		if bc.postgres == nil {
+			bc.bestChain, mainChainIndexMatches, err = GetBestChainFromMainChainIndex(bc.db, tipNode, bc.blockIndex)
+			if err != nil {
+				return errors.Wrapf(err, "_initChain(block): Problem reading best chain from main chain index")
+			}
+		}
+		if !mainChainIndexMatches {
+			bc.bestChain, err = GetBestChain(tipNode, bc.blockIndex)
+			if err != nil {
+				return errors.Wrapf(err, "_initChain(block): Problem reading best chain from db")
+			}
+			if bc.postgres == nil {
+				glog.Infof("_initChain: Rebuilding main chain index up to height %v", tipNode.Height)
+				if err = DbPutMainChain(bc.db, bc.bestChain); err != nil {
+					return errors.Wrapf(err, "_initChain(block): Problem rebuilding main chain index")
+				}
+			}
 		}
 		for _, bestChainNode := range bc.bestChain {
 			bc.bestChainMap[*bestChainNode.Hash] = bestChainNode
@@ -646,6 +535,10 @@ func NewBlockchain(
 		params:                          params,
 		eventManager:                    eventManager,
 		archivalMode:                    archivalMode,
+		prunedBlockHeight:               DbGetPrunedBlockHeight(db),
+		utxoOpsPrunedBlockHeight:        DbGetUtxoOpsPrunedBlockHeight(db),
+		readGenerations:                 NewReadGenerationManager(db),
+		validationFailureLog:            NewValidationFailureLog(db),
 
 		blockIndex:   make(map[BlockHash]*BlockNode),
 		bestChainMap: make(map[BlockHash]*BlockNode),
@@ -655,6 +548,16 @@ func NewBlockchain(
 		orphanList: list.New(),
 		timer:      timer,
 	}
+	// An archival node serves every block to its peers, which a node that pruned blocks can't.
+	if archivalMode && bc.prunedBlockHeight > 0 {
+		return nil, fmt.Errorf("NewBlockchain: Can't run in archival mode because blocks up to "+
+			"height %v were pruned", bc.prunedBlockHeight)
+	}
+	if eventManager != nil {
+		bc.blockSubscriptions = make(map[*BlockSubscription]bool)
+		eventManager.OnBlockConnected(bc._notifyBlockSubscriptions)
+		eventManager.OnBlockDisconnected(bc._rewindBlockSubscriptions)
+	}
 
 	// Hold the chain lock whenever we modify this object from now on.
 	bc.ChainLock.Lock()
@@ -666,10 +569,113 @@ func NewBlockchain(
 	if err := bc._initChain(); err != nil {
 		return nil, errors.Wrapf(err, "NewBlockchain: ")
 	}
+	if err := bc._loadOrphanBlocks(); err != nil {
+		return nil, errors.Wrapf(err, "NewBlockchain: ")
+	}
+
+	// Finish a prefix repair that was interrupted, so that we never read a partially swapped prefix.
+	if bc.postgres == nil {
+		if err := ResumePrefixRepair(bc.db, bc.snapshot); err != nil {
+			return nil, errors.Wrapf(err, "NewBlockchain: ")
+		}
+	}
+
+	// Make sure the db is consistent now rather than failing in the middle of a sync later on.
+	if bc.postgres == nil {
+		if err := bc.checkStartupConsistency(); err != nil {
+			return nil, errors.Wrapf(err, "NewBlockchain: ")
+		}
+	}
+
+	// Dbs that synced before we maintained the utxo aggregates need them backfilled.
+	if bc.postgres == nil && !DbIsUtxoAggregatesBackfilled(bc.db) {
+		glog.Infof("NewBlockchain: Backfilling utxo aggregates")
+		if err := DbBackfillUtxoAggregates(bc.db, bc.snapshot); err != nil {
+			return nil, errors.Wrapf(err, "NewBlockchain: ")
+		}
+	}
+
+	// Same for the coin holder leaderboards.
+	if bc.postgres == nil && !DbIsHolderLeaderboardsBackfilled(bc.db) {
+		glog.Infof("NewBlockchain: Backfilling coin holder leaderboards")
+		if err := DbBackfillHolderLeaderboards(bc.db, bc.snapshot); err != nil {
+			return nil, errors.Wrapf(err, "NewBlockchain: ")
+		}
+	}
+
+	// And for the post counts.
+	if bc.postgres == nil && !DbIsPostCountsBackfilled(bc.db) {
+		glog.Infof("NewBlockchain: Backfilling post counts")
+		if err := DbBackfillPostCounts(bc.db, bc.snapshot); err != nil {
+			return nil, errors.Wrapf(err, "NewBlockchain: ")
+		}
+	}
+
+	// And for the diamond aggregates.
+	if bc.postgres == nil && !DbIsDiamondAggregatesBackfilled(bc.db) {
+		glog.Infof("NewBlockchain: Backfilling diamond aggregates")
+		if err := DbBackfillDiamondAggregates(bc.db, bc.snapshot); err != nil {
+			return nil, errors.Wrapf(err, "NewBlockchain: ")
+		}
+	}
+
+	// And for the NFT collection summaries.
+	if bc.postgres == nil && !DbIsNFTCollectionSummariesBackfilled(bc.db) {
+		glog.Infof("NewBlockchain: Backfilling NFT collection summaries")
+		if err := DbBackfillNFTCollectionSummaries(bc.db); err != nil {
+			return nil, errors.Wrapf(err, "NewBlockchain: ")
+		}
+	}
 
 	return bc, nil
 }
 
+// checkStartupConsistency makes sure that the best block hash in the db points to a validated block in the
+// block index, and that its height matches the height the state was last flushed at. These are written in
+// the same txn, so a mismatch means the db was corrupted or modified by hand.
+func (bc *Blockchain) checkStartupConsistency() error {
+	bestBlockHash := DbGetBestHash(bc.db, bc.snapshot, ChainTypeDeSoBlock)
+	if bestBlockHash == nil {
+		return fmt.Errorf("checkStartupConsistency: Best block hash is missing from the db. The db is " +
+			"corrupted, delete the data directory and resync the node")
+	}
+	bestNode, exists := bc.blockIndex[*bestBlockHash]
+	if !exists {
+		return fmt.Errorf("checkStartupConsistency: Best block hash %v isn't in the block index. The db "+
+			"is corrupted, delete the data directory and resync the node", bestBlockHash)
+	}
+	if (bestNode.Status & StatusBlockValidated) == 0 {
+		return fmt.Errorf("checkStartupConsistency: Best block %v at height %v has status %v, which isn't "+
+			"validated. The db is corrupted, delete the data directory and resync the node",
+			bestBlockHash, bestNode.Height, bestNode.Status)
+	}
+
+	flushHeight := DbGetStateFlushHeight(bc.db, bc.snapshot)
+	if flushHeight == nil {
+		// Dbs created before we started recording the flush height won't have it until the next block.
+		glog.Warningf("checkStartupConsistency: State flush height is missing from the db, skipping check")
+		return nil
+	}
+	if *flushHeight != uint64(bestNode.Height) {
+		return fmt.Errorf("checkStartupConsistency: State was last flushed at height %v but the best "+
+			"block %v is at height %v. The node likely crashed in the middle of a write, delete the data "+
+			"directory and resync the node", *flushHeight, bestBlockHash, bestNode.Height)
+	}
+	return nil
+}
+
+// CheckTxindexTipConsistency makes sure the txindex isn't ahead of the chain, which would mean the
+// txindex was built from a different db than the one the node is running with.
+func (bc *Blockchain) CheckTxindexTipConsistency(txindexTip *BlockNode) error {
+	chainTip := bc.BlockTip()
+	if txindexTip.Height > chainTip.Height {
+		return fmt.Errorf("CheckTxindexTipConsistency: Txindex tip %v at height %v is ahead of the "+
+			"chain tip %v at height %v. Delete the txindex directory to rebuild the txindex",
+			txindexTip.Hash, txindexTip.Height, chainTip.Hash, chainTip.Height)
+	}
+	return nil
+}
+
 // log2FloorMasks defines the masks to use when quickly calculating
 // floor(log2(x)) in a constant log2(32) = 5 steps, where x is a uint32, using
 // shifts.  They are derived from (2^(2^x) - 1) * (2^(2^x)), for x in 4..0.
@@ -1252,6 +1258,50 @@ func (bc *Blockchain) Snapshot() *Snapshot {
 	return bc.snapshot
 }
 
+// SetIndexQueue makes block connects and disconnects enqueue tasks for the IndexQueue.
+func (bc *Blockchain) SetIndexQueue(indexQueue *IndexQueue) {
+	bc.ChainLock.Lock()
+	defer bc.ChainLock.Unlock()
+
+	bc.indexQueue = indexQueue
+}
+
+// enqueueIndexQueueTaskWithTxn is a no-op unless an IndexQueue has been set.
+func (bc *Blockchain) enqueueIndexQueueTaskWithTxn(
+	txn *badger.Txn, taskType IndexQueueTaskType, node *BlockNode) error {
+
+	if bc.indexQueue == nil {
+		return nil
+	}
+	return DbEnqueueIndexQueueTaskWithTxn(txn, bc.snapshot, &IndexQueueTask{
+		TaskType:    taskType,
+		BlockHeight: uint64(node.Height),
+		BlockHash:   node.Hash,
+	})
+}
+
+// SetEventJournal makes block connects and disconnects append their events to the EventJournal.
+func (bc *Blockchain) SetEventJournal(eventJournal *EventJournal) {
+	bc.ChainLock.Lock()
+	defer bc.ChainLock.Unlock()
+
+	bc.eventJournal = eventJournal
+}
+
+func (bc *Blockchain) EventJournal() *EventJournal {
+	return bc.eventJournal
+}
+
+// journalBlockWithTxn is a no-op unless an EventJournal has been set.
+func (bc *Blockchain) journalBlockWithTxn(
+	txn *badger.Txn, block *MsgDeSoBlock, node *BlockNode, isConnect bool) error {
+
+	if bc.eventJournal == nil {
+		return nil
+	}
+	return bc.eventJournal.journalBlockWithTxn(txn, block, node.Hash, uint64(node.Height), isConnect)
+}
+
 // blockTip returns the tip of the main block chain. We fetch headers first
 // and then, once the header chain looks good, we fetch blocks. As such, we
 // store two separate "best" chains: One containing the best headers, and
@@ -1278,6 +1328,15 @@ func (bc *Blockchain) BlockTip() *BlockNode {
 	return bc.blockTip()
 }
 
+// ReadGenerations returns the ReadGenerationManager used to paginate through the chain's db.
+func (bc *Blockchain) ReadGenerations() *ReadGenerationManager {
+	return bc.readGenerations
+}
+
+func (bc *Blockchain) ValidationFailureLog() *ValidationFailureLog {
+	return bc.validationFailureLog
+}
+
 func (bc *Blockchain) BestChain() []*BlockNode {
 	return bc.bestChain
 }
@@ -1332,12 +1391,9 @@ func (bc *Blockchain) _validateOrphanBlock(desoBlock *MsgDeSoBlock) error {
 
 // ProcessOrphanBlock runs some very basic validation on the orphan block and adds
 // it to our orphan data structure if it passes. If there are too many orphan blocks
-// in our data structure, it also evicts the oldest block to make room for this one.
-//
-// TODO: Currently we only remove orphan blocks if we have too many. This means in
-// a steady state we are potentially keeping MaxOrphansInMemory at all times, which
-// is wasteful of resources. Better would be to clean up orphan blocks once they're
-// too old or something like that.
+// in our data structure, it also evicts the lowest block to make room for this one.
+// Orphans are dropped once their parent is processed, and PruneOrphanBlocks drops
+// the ones that are too old.
 func (bc *Blockchain) ProcessOrphanBlock(desoBlock *MsgDeSoBlock, blockHash *BlockHash) error {
 	err := bc._validateOrphanBlock(desoBlock)
 	if err != nil {
@@ -1358,19 +1414,22 @@ func (bc *Blockchain) ProcessOrphanBlock(desoBlock *MsgDeSoBlock, blockHash *Blo
 
 	// At this point we know we are adding a new orphan to the list.
 
-	// If we are at capacity remove an orphan block by simply deleting the front
-	// element of the orphan list, which is also the oldest orphan.
+	// If we are at capacity remove the orphan block with the lowest height, which
+	// is the least likely to ever connect.
 	if bc.orphanList.Len() >= MaxOrphansInMemory {
-		elemToRemove := bc.orphanList.Front()
-		bc.orphanList.Remove(elemToRemove)
+		bc._removeOrphanBlockElem(bc._lowestOrphanBlockElem())
 	}
 
 	// Add the orphan block to our data structure. We can also assume the orphan
 	// is not a duplicate and therefore simply add a new entry to the end of the list.
-	bc.orphanList.PushBack(&OrphanBlock{
+	orphanBlock := &OrphanBlock{
 		Block: desoBlock,
 		Hash:  blockHash,
-	})
+	}
+	if err = bc._putOrphanBlockInDb(orphanBlock); err != nil {
+		return errors.Wrapf(err, "ProcessOrphanBlock: Problem storing orphan block")
+	}
+	bc.orphanList.PushBack(orphanBlock)
 
 	return nil
 }
@@ -1533,7 +1592,14 @@ func CheckTransactionSanity(txn *MsgDeSoTxn) error {
 
 func GetReorgBlocks(tip *BlockNode, newNode *BlockNode) (_commonAncestor *BlockNode, _detachNodes []*BlockNode, _attachNodes []*BlockNode) {
 	// Find the common ancestor of this block and the main header chain.
-	commonAncestor := _FindCommonAncestor(tip, newNode)
+	return GetReorgBlocksFromCommonAncestor(tip, newNode, _FindCommonAncestor(tip, newNode))
+}
+
+// GetReorgBlocksFromCommonAncestor is GetReorgBlocks for a common ancestor that's already known,
+// e.g. from DbGetForkPointWithMainChain.
+func GetReorgBlocksFromCommonAncestor(tip *BlockNode, newNode *BlockNode, commonAncestor *BlockNode) (
+	_commonAncestor *BlockNode, _detachNodes []*BlockNode, _attachNodes []*BlockNode) {
+
 	// Log a warning if the reorg is going to be a big one.
 	numBlocks := tip.Height - commonAncestor.Height
 	if numBlocks > 10 {
@@ -1782,6 +1848,26 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 	bc.ChainLock.Lock()
 	defer bc.ChainLock.Unlock()
 
+	isMainChain, isOrphan, err := bc.processBlock(desoBlock, verifySignatures)
+	if err != nil || isOrphan {
+		return isMainChain, isOrphan, err
+	}
+
+	// The block may be one we were holding on to as an orphan, or the parent that some of
+	// our orphans were waiting for.
+	blockHash, err := desoBlock.Header.Hash()
+	if err != nil {
+		return false, false, errors.Wrapf(err, "ProcessBlock: Problem computing block hash")
+	}
+	bc._removeOrphanBlock(blockHash)
+	bc._processOrphanChildren(blockHash, verifySignatures)
+
+	return isMainChain, isOrphan, nil
+}
+
+// processBlock is ProcessBlock for callers that hold the ChainLock. Orphans are stored
+// but not connected once their parent shows up.
+func (bc *Blockchain) processBlock(desoBlock *MsgDeSoBlock, verifySignatures bool) (_isMainChain bool, _isOrphan bool, _err error) {
 	blockHeight := uint64(bc.BlockTip().Height + 1)
 
 	bc.timer.Start("Blockchain.ProcessBlock: Initial")
@@ -1794,10 +1880,20 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 	if blockHeader == nil {
 		return false, false, fmt.Errorf("ProcessBlock: Block header was nil")
 	}
+	// Connecting a block needs all of the state, which a selectively synced node doesn't have.
+	if err := CheckAllStatePrefixesSynced(); err != nil {
+		return false, false, errors.Wrapf(err, "ProcessBlock: Can't process blocks on a partially synced node")
+	}
 	blockHash, err := blockHeader.Hash()
 	if err != nil {
 		return false, false, errors.Wrapf(err, "ProcessBlock: Problem computing block hash")
 	}
+	// Don't bother connecting a block that we already know is invalid.
+	if invalidBlock := bc._getInvalidBlock(blockHash); invalidBlock != nil {
+		return false, false, errors.Wrapf(RuleErrorKnownInvalidBlock,
+			"ProcessBlock: Block %v failed to connect before with error: %v",
+			blockHash, invalidBlock.ErrorMessage)
+	}
 	// If a trusted block producer public key is set, then we only accept blocks
 	// if they have been signed by one of these public keys.
 	if len(bc.trustedBlockProducerPublicKeys) > 0 {
@@ -1843,7 +1939,7 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 			// trusted.
 
 			signature := desoBlock.BlockProducerInfo.Signature
-			pkObj, err := btcec.ParsePubKey(publicKey, btcec.S256())
+			pkObj, err := ParsePubKeyCached(publicKey)
 			if err != nil {
 				return false, false, errors.Wrapf(err,
 					"ProcessBlock: Error parsing block producer public key: %v.",
@@ -1873,11 +1969,10 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 			// should be marked as invalid, which should be sufficient.
 			return false, false, err
 		}
-		// If the header is an orphan, return early. We don't process orphan
-		// blocks. If the block and its header are truly legitimate then we
-		// should re-request it and its parents from a peer and reprocess it
-		// once it is no longer an orphan.
+		// If the header is an orphan, store the block and return early. It's
+		// processed once its parent is.
 		if isOrphan {
+			bc._storeOrphanBlock(desoBlock, blockHash)
 			return false, true, nil
 		}
 
@@ -1899,11 +1994,10 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 	//
 	// Find the parent node in our block index. If the node doesn't exist or if the
 	// node exists without StatusBlockProcessed, then the current block is an orphan.
-	// In this case go ahead and return early. If its parents are truly legitimate then we
-	// should re-request it and its parents from a node and reprocess it
-	// once it is no longer an orphan.
+	// In this case store the block and return early. It's processed once its parent is.
 	parentNode, parentNodeExists := bc.blockIndex[*blockHeader.PrevBlockHash]
 	if !parentNodeExists || (parentNode.Status&StatusBlockProcessed) == 0 {
+		bc._storeOrphanBlock(desoBlock, blockHash)
 		return false, true, nil
 	}
 
@@ -2105,6 +2199,7 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 				// If we have a RuleError, mark the block as invalid before
 				// returning.
 				bc.MarkBlockInvalid(nodeToValidate, RuleError(err.Error()))
+				bc._recordInvalidBlock(nodeToValidate, err)
 				return false, false, err
 			}
 
@@ -2139,11 +2234,21 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 				if err = PutUtxoOperationsForBlockWithTxn(txn, bc.snapshot, blockHeight, blockHash, utxoOpsForBlock); err != nil {
 					return errors.Wrapf(err, "ProcessBlock: Problem writing utxo operations to db on simple add to tip")
 				}
+				if err = bc.journalBlockWithTxn(txn, desoBlock, nodeToValidate, true /*isConnect*/); err != nil {
+					return errors.Wrapf(err, "ProcessBlock: Problem journaling block on simple add to tip")
+				}
 				return nil
 			})
 		} else {
 			bc.timer.Start("Blockchain.ProcessBlock: Transactions Db put")
+			stateChanges := bc.newStateChangeRecorder(blockHeight)
+			flushSession := bc.snapshot.NewFlushSession()
 			err = bc.db.Update(func(txn *badger.Txn) error {
+				stateChanges.TrackTxn(txn)
+				defer stateChanges.EndTrack()
+				flushSession.TrackTxn(txn)
+				defer flushSession.EndTrack()
+
 				// This will update the node's status.
 				bc.timer.Start("Blockchain.ProcessBlock: Transactions Db height & hash")
 				if err := PutHeightHashToNodeInfoWithTxn(txn, bc.snapshot, nodeToValidate, false /*bitcoinNodes*/); err != nil {
@@ -2156,6 +2261,12 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 				if err := PutBestHashWithTxn(txn, bc.snapshot, blockHash, ChainTypeDeSoBlock); err != nil {
 					return err
 				}
+				if err := DbPutMainChainHashAtHeightWithTxn(txn, uint64(nodeToValidate.Height), blockHash); err != nil {
+					return err
+				}
+				if err := DbPutStateFlushHeightWithTxn(txn, bc.snapshot, uint64(nodeToValidate.Height)); err != nil {
+					return err
+				}
 				bc.timer.End("Blockchain.ProcessBlock: Transactions Db height & hash")
 				bc.timer.Start("Blockchain.ProcessBlock: Transactions Db utxo flush")
 
@@ -2166,15 +2277,38 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 				}
 				bc.timer.End("Blockchain.ProcessBlock: Transactions Db snapshot & operations")
 
-				// Write the modified utxo set to the view.
-				if err := bc.blockView.FlushToDbWithTxn(txn, blockHeight); err != nil {
+				// Write the modified utxo set to the view. If the block connect was profiled, time
+				// the flush too and persist the profile along with the block.
+				blockConnectProfile := bc.blockView.blockConnectProfile
+				flushStartTime := time.Now()
+				blockConnectProfile.TrackFlushTxn(txn)
+				err := bc.blockView.FlushToDbWithTxn(txn, blockHeight)
+				blockConnectProfile.EndFlush(flushStartTime)
+				if err != nil {
 					return errors.Wrapf(err, "ProcessBlock: Problem writing utxo view to db on simple add to tip")
 				}
+				if blockConnectProfile != nil {
+					if err := DbPutBlockConnectProfileWithTxn(txn, bc.snapshot, blockConnectProfile); err != nil {
+						return errors.Wrapf(err, "ProcessBlock: Problem writing block connect profile on simple add to tip")
+					}
+				}
+
+				// Queue up the non-consensus index updates for this block.
+				if err := bc.enqueueIndexQueueTaskWithTxn(txn, IndexQueueTaskTypeBlockConnected, nodeToValidate); err != nil {
+					return errors.Wrapf(err, "ProcessBlock: Problem enqueueing index task on simple add to tip")
+				}
+				if err := bc.journalBlockWithTxn(txn, desoBlock, nodeToValidate, true /*isConnect*/); err != nil {
+					return errors.Wrapf(err, "ProcessBlock: Problem journaling block on simple add to tip")
+				}
 				bc.timer.End("Blockchain.ProcessBlock: Transactions Db utxo flush")
 				bc.timer.Start("Blockchain.ProcessBlock: Transactions Db snapshot & operations")
 
 				return nil
 			})
+			flushSession.Finish(err)
+			if err == nil {
+				bc.emitStateChanges(stateChanges)
+			}
 			bc.timer.End("Blockchain.ProcessBlock: Transactions Db put")
 		}
 		bc.timer.Start("Blockchain.ProcessBlock: Transactions Db end")
@@ -2254,8 +2388,19 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 		// above steps are processed using an in-memory view before writing anything
 		// to the database.
 
-		// Find the common ancestor of this block and the main chain.
-		commonAncestor, detachBlocks, attachBlocks := GetReorgBlocks(currentTip, nodeToValidate)
+		// Find the common ancestor of this block and the main chain. The main chain index is
+		// written in the same txn as the current tip, so its fork point with this block is the
+		// common ancestor, and looking it up only visits this block's side of the fork. We fall
+		// back to walking both sides if the index doesn't have it, e.g. on postgres nodes.
+		var commonAncestor *BlockNode
+		if bc.postgres == nil {
+			commonAncestor = DbGetForkPointWithMainChain(bc.db, nodeToValidate)
+		}
+		if commonAncestor == nil || bc.bestChainMap[*commonAncestor.Hash] == nil {
+			commonAncestor = _FindCommonAncestor(currentTip, nodeToValidate)
+		}
+		commonAncestor, detachBlocks, attachBlocks := GetReorgBlocksFromCommonAncestor(
+			currentTip, nodeToValidate, commonAncestor)
 		// Log a warning if the reorg is going to be a big one.
 		numBlocks := currentTip.Height - commonAncestor.Height
 		if numBlocks > 10 {
@@ -2281,6 +2426,14 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 				"not the current tip hash (%v)", *utxoView.TipHash, *currentTip)
 		}
 
+		// The blocks whose utxo operations were pruned by the retention policy have them
+		// regenerated before anything is detached, since the regeneration reads the snapshot.
+		regeneratedUtxoOps, err := bc.regeneratePrunedUtxoOps(detachBlocks)
+		if err != nil {
+			return false, false, errors.Wrapf(err, "ProcessBlock: Problem regenerating "+
+				"utxo operations for reorg")
+		}
+
 		// Go through and detach all of the blocks down to the common ancestor. We
 		// shouldn't encounter any errors but if we do, return without marking the
 		// block as invalid.
@@ -2288,7 +2441,10 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 		for _, nodeToDetach := range detachBlocks {
 			// Fetch the utxo operations for the block we're detaching. We need these
 			// in order to be able to detach the block.
-			utxoOps, err := GetUtxoOperationsForBlock(bc.db, bc.snapshot, nodeToDetach.Hash)
+			utxoOps, isRegenerated := regeneratedUtxoOps[*nodeToDetach.Hash]
+			if !isRegenerated {
+				utxoOps, err = GetUtxoOperationsForBlock(bc.db, bc.snapshot, nodeToDetach.Hash)
+			}
 			if err != nil {
 				return false, false, errors.Wrapf(err, "ProcessBlock: Problem fetching "+
 					"utxo operations during detachment of block (%v) "+
@@ -2378,6 +2534,7 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 					// yet because we need to mark all of the child blocks as invalid as
 					// well first.
 					bc.MarkBlockInvalid(attachNode, RuleError(err.Error()))
+					bc._recordInvalidBlock(attachNode, err)
 					ruleErrorsFound = append(ruleErrorsFound, RuleError(err.Error()))
 					continue
 				} else {
@@ -2420,11 +2577,33 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 		// the state after applying the reorg. With this information, it is possible to
 		// roll back the blocks and fast forward the db to the post-reorg state with a
 		// single transaction.
+		stateChanges := bc.newStateChangeRecorder(blockHeight)
+		flushSession := bc.snapshot.NewFlushSession()
 		err = bc.db.Update(func(txn *badger.Txn) error {
+			stateChanges.TrackTxn(txn)
+			defer stateChanges.EndTrack()
+			flushSession.TrackTxn(txn)
+			defer flushSession.EndTrack()
+
 			// Set the best node hash to the new tip.
 			if err := PutBestHashWithTxn(txn, bc.snapshot, newTipNode.Hash, ChainTypeDeSoBlock); err != nil {
 				return err
 			}
+			// Replace the detached blocks with the attached ones in the main chain index.
+			// The attached blocks overwrite the detached ones at the heights they share.
+			for _, detachNode := range detachBlocks {
+				if err := DbDeleteMainChainHashAtHeightWithTxn(txn, uint64(detachNode.Height)); err != nil {
+					return err
+				}
+			}
+			for _, attachNode := range attachBlocks {
+				if err := DbPutMainChainHashAtHeightWithTxn(txn, uint64(attachNode.Height), attachNode.Hash); err != nil {
+					return err
+				}
+			}
+			if err := DbPutStateFlushHeightWithTxn(txn, bc.snapshot, uint64(newTipNode.Height)); err != nil {
+				return err
+			}
 
 			for _, detachNode := range detachBlocks {
 				// Delete the utxo operations for the blocks we're detaching since we don't need
@@ -2438,6 +2617,13 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 				// data on the db). But this seems like a minor optimization that comes at
 				// the minor cost of side chains not being retained by the network as reliably.
 			}
+			// The attached blocks get their utxo operations, so only the blocks up to the common
+			// ancestor stay pruned.
+			if bc.utxoOpsPrunedBlockHeight > uint64(commonAncestor.Height) {
+				if err := DbPutUtxoOpsPrunedBlockHeightWithTxn(txn, uint64(commonAncestor.Height)); err != nil {
+					return errors.Wrapf(err, "ProcessBlock: Problem putting utxo ops pruned block height")
+				}
+			}
 
 			for ii, attachNode := range attachBlocks {
 				// Add the utxo operations for the blocks we're attaching so we can roll them back
@@ -2452,11 +2638,41 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 				return errors.Wrapf(err, "ProcessBlock: Problem flushing to db")
 			}
 
+			// Queue up the non-consensus index updates for the reorg, in the order
+			// the blocks were detached and attached.
+			for _, detachNode := range detachBlocks {
+				if err := bc.enqueueIndexQueueTaskWithTxn(txn, IndexQueueTaskTypeBlockDisconnected, detachNode); err != nil {
+					return errors.Wrapf(err, "ProcessBlock: Problem enqueueing index task for detached block")
+				}
+			}
+			for _, attachNode := range attachBlocks {
+				if err := bc.enqueueIndexQueueTaskWithTxn(txn, IndexQueueTaskTypeBlockConnected, attachNode); err != nil {
+					return errors.Wrapf(err, "ProcessBlock: Problem enqueueing index task for attached block")
+				}
+			}
+
+			// Journal the reorg in the same order.
+			for ii, detachNode := range detachBlocks {
+				if err := bc.journalBlockWithTxn(txn, blocksToDetach[ii], detachNode, false /*isConnect*/); err != nil {
+					return errors.Wrapf(err, "ProcessBlock: Problem journaling detached block")
+				}
+			}
+			for ii, attachNode := range attachBlocks {
+				if err := bc.journalBlockWithTxn(txn, blocksToAttach[ii], attachNode, true /*isConnect*/); err != nil {
+					return errors.Wrapf(err, "ProcessBlock: Problem journaling attached block")
+				}
+			}
+
 			return nil
 		})
+		flushSession.Finish(err)
 		if err != nil {
 			return false, false, errors.Errorf("ProcessBlock: Problem updating: %v", err)
 		}
+		bc.emitStateChanges(stateChanges)
+		if bc.utxoOpsPrunedBlockHeight > uint64(commonAncestor.Height) {
+			bc.utxoOpsPrunedBlockHeight = uint64(commonAncestor.Height)
+		}
 
 		// Now the db has been updated, update our in-memory best chain. Note that there
 		// is no need to update the node index because it was updated as we went along.
@@ -2509,6 +2725,19 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 	if bc.snapshot != nil {
 		bc.snapshot.FinishProcessBlock(bc.blockTip())
 	}
+
+	// Prune the blocks that fell below the prune horizon, if pruning is on. The block was
+	// processed either way, so a failure is only logged, and retried with the next block.
+	if bc.blockPruneDepth > 0 {
+		if _, err := bc.pruneBlocks(MaxBlocksToPrunePerBlock); err != nil {
+			glog.Errorf("ProcessBlock: Problem pruning blocks: %v", err)
+		}
+	}
+	if bc.utxoOpsRetention > 0 {
+		if _, err := bc.pruneUtxoOps(MaxBlocksToPrunePerBlock); err != nil {
+			glog.Errorf("ProcessBlock: Problem pruning utxo operations: %v", err)
+		}
+	}
 	// If we've made it this far, the block has been validated and we have either added
 	// the block to the tip, done nothing with it (because its cumwork isn't high enough)
 	// or added it via a reorg and the db and our in-memory data structures reflect this
@@ -2575,12 +2804,27 @@ func (bc *Blockchain) DisconnectBlocksToHeight(blockHeight uint64) error {
 		}
 	}
 
+	// The utxo operations pruned by the retention policy are regenerated up front, since the
+	// regeneration reads the snapshot, which the disconnects below don't maintain.
+	var nodesToDetach []*BlockNode
+	for ii := len(bc.bestChain) - 1; ii > 0 && uint64(bc.bestChain[ii].Height) > blockHeight; ii-- {
+		nodesToDetach = append(nodesToDetach, bc.bestChain[ii])
+	}
+	regeneratedUtxoOps, err := bc.regeneratePrunedUtxoOps(nodesToDetach)
+	if err != nil {
+		return errors.Wrapf(err, "DisconnectBlocksToHeight: ")
+	}
+
 	for ii := len(bc.bestChain) - 1; ii > 0 && uint64(bc.bestChain[ii].Height) > blockHeight; ii-- {
 		node := bc.bestChain[ii]
 		prevHash := *bc.bestChain[ii-1].Hash
 		hash := *bc.bestChain[ii].Hash
 		height := uint64(bc.bestChain[ii].Height)
+		stateChanges := bc.newStateChangeRecorder(height)
 		err := bc.db.Update(func(txn *badger.Txn) error {
+			stateChanges.TrackTxn(txn)
+			defer stateChanges.EndTrack()
+
 			utxoView, err := NewUtxoView(bc.db, bc.params, bc.postgres, nil)
 			if err != nil {
 				return err
@@ -2591,9 +2835,12 @@ func (bc *Blockchain) DisconnectBlocksToHeight(blockHeight uint64) error {
 			}
 			// Fetch the utxo operations for the block we're detaching. We need these
 			// in order to be able to detach the block.
-			utxoOps, err := GetUtxoOperationsForBlock(bc.db, nil, &hash)
-			if err != nil {
-				return err
+			utxoOps, isRegenerated := regeneratedUtxoOps[hash]
+			if !isRegenerated {
+				utxoOps, err = GetUtxoOperationsForBlock(bc.db, nil, &hash)
+				if err != nil {
+					return err
+				}
 			}
 
 			// Compute the hashes for all the transactions.
@@ -2610,8 +2857,9 @@ func (bc *Blockchain) DisconnectBlocksToHeight(blockHeight uint64) error {
 				return err
 			}
 
-			// Flushing the view after applying and rolling back should work.
-			err = utxoView.FlushToDb(height)
+			// Flushing the view after applying and rolling back should work. The view is flushed in
+			// this txn so that the state changes are committed along with the new tip.
+			err = utxoView.FlushToDbWithTxn(txn, height)
 			if err != nil {
 				return err
 			}
@@ -2620,6 +2868,17 @@ func (bc *Blockchain) DisconnectBlocksToHeight(blockHeight uint64) error {
 			if err := PutBestHashWithTxn(txn, nil, &prevHash, ChainTypeDeSoBlock); err != nil {
 				return err
 			}
+			if err := DbDeleteMainChainHashAtHeightWithTxn(txn, uint64(node.Height)); err != nil {
+				return err
+			}
+			if err := DbPutStateFlushHeightWithTxn(txn, nil, uint64(bc.bestChain[ii-1].Height)); err != nil {
+				return err
+			}
+			if bc.utxoOpsPrunedBlockHeight >= height {
+				if err := DbPutUtxoOpsPrunedBlockHeightWithTxn(txn, height-1); err != nil {
+					return err
+				}
+			}
 
 			// Delete the utxo operations for the blocks we're detaching since we don't need
 			// them anymore.
@@ -2631,6 +2890,13 @@ func (bc *Blockchain) DisconnectBlocksToHeight(blockHeight uint64) error {
 				return errors.Wrapf(err, "DisconnectBlocksToHeight: Problem deleting block reward")
 			}
 
+			if err := bc.enqueueIndexQueueTaskWithTxn(txn, IndexQueueTaskTypeBlockDisconnected, node); err != nil {
+				return errors.Wrapf(err, "DisconnectBlocksToHeight: Problem enqueueing index task")
+			}
+			if err := bc.journalBlockWithTxn(txn, blockToDetach, node, false /*isConnect*/); err != nil {
+				return errors.Wrapf(err, "DisconnectBlocksToHeight: Problem journaling block")
+			}
+
 			node.Status = StatusHeaderValidated
 			if err := PutHeightHashToNodeInfoWithTxn(txn, nil, node, false); err != nil {
 				return errors.Wrapf(err, "DisconnectBlocksToHeight: Problem deleting height hash to node info")
@@ -2649,6 +2915,10 @@ func (bc *Blockchain) DisconnectBlocksToHeight(blockHeight uint64) error {
 			return errors.Wrapf(err, "DisconnectBlocksToHeight: Problem disconnecting block "+
 				"with hash: (%v) at blockHeight: (%v)", hash, height)
 		}
+		bc.emitStateChanges(stateChanges)
+		if bc.utxoOpsPrunedBlockHeight >= height {
+			bc.utxoOpsPrunedBlockHeight = height - 1
+		}
 
 		bc.bestChain = bc.bestChain[:len(bc.bestChain)-1]
 		delete(bc.bestChainMap, hash)
@@ -2707,76 +2977,6 @@ func (bc *Blockchain) ValidateTransaction(
 	return nil
 }
 
-var (
-	maxHash = BlockHash{
-		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
-		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
-		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
-		0xff, 0xff}
-	maxHashBigint = HashToBigint(&maxHash)
-	bigOneInt     = big.NewInt(1)
-)
-
-// The number of hashing attempts in expectation it would take to produce the
-// hash passed in. This is computed as:
-//    E(min(X_i, ..., X_n)) where:
-//    - n = (number of attempted hashes) and
-//    - the X_i are all U(0, MAX_HASH)
-// -> E(min(X_i, ..., X_n)) = MAX_HASH / (n + 1)
-// -> E(n) ~= MAX_HASH / min_hash - 1
-//    - where min_hash is the block hash
-//
-// We approximate this as MAX_HASH / (min_hash + 1), adding 1 to min_hash in
-// order to mitigate the possibility of a divide-by-zero error.
-//
-// The value returned is the expected number of hashes performed to produce
-// the input hash formatted as a big-endian big integer that uses the
-// BlockHash type for convenience (though it is likely to be much lower
-// in terms of magnitude than a typical BlockHash object).
-func ExpectedWorkForBlockHash(hash *BlockHash) *BlockHash {
-	hashBigint := HashToBigint(hash)
-	ratioBigint := new(big.Int)
-	ratioBigint.Div(maxHashBigint, hashBigint.Add(hashBigint, bigOneInt))
-	return BigintToHash(ratioBigint)
-}
-
-func ComputeTransactionHashes(txns []*MsgDeSoTxn) ([]*BlockHash, error) {
-	txHashes := make([]*BlockHash, len(txns))
-
-	for ii, currentTxn := range txns {
-		txHashes[ii] = currentTxn.Hash()
-	}
-
-	return txHashes, nil
-}
-
-func ComputeMerkleRoot(txns []*MsgDeSoTxn) (_merkle *BlockHash, _txHashes []*BlockHash, _err error) {
-	if len(txns) == 0 {
-		return nil, nil, fmt.Errorf("ComputeMerkleRoot: Block must contain at least one txn")
-	}
-
-	// Compute the hashes of all the transactions.
-	hashes := [][]byte{}
-	for _, txn := range txns {
-		txHash := txn.Hash()
-		hashes = append(hashes, txHash[:])
-	}
-
-	merkleTree := merkletree.NewTreeFromHashes(merkletree.Sha256DoubleHash, hashes)
-
-	rootHash := &BlockHash{}
-	copy(rootHash[:], merkleTree.Root.GetHash()[:])
-
-	txHashes := []*BlockHash{}
-	for _, leafNode := range merkleTree.Rows[0] {
-		currentHash := &BlockHash{}
-		copy(currentHash[:], leafNode.GetHash())
-		txHashes = append(txHashes, currentHash)
-	}
-
-	return rootHash, txHashes, nil
-}
-
 func (bc *Blockchain) GetSpendableUtxosForPublicKey(spendPublicKeyBytes []byte, mempool *DeSoMempool, referenceUtxoView *UtxoView) ([]*UtxoEntry, error) {
 	// If we have access to a mempool, use it to account for utxos we might not
 	// get otherwise.
@@ -3056,6 +3256,78 @@ func (bc *Blockchain) CreateLikeTxn(
 	return txn, totalInput, changeAmount, fees, nil
 }
 
+func (bc *Blockchain) CreatePostAssociationTxn(
+	userPublicKey []byte, targetPostHash BlockHash, associationType []byte,
+	associationValue []byte, isDelete bool,
+	minFeeRateNanosPerKB uint64, mempool *DeSoMempool, additionalOutputs []*DeSoOutput) (
+	_txn *MsgDeSoTxn, _totalInput uint64, _changeAmount uint64, _fees uint64,
+	_err error) {
+
+	// A PostAssociation transaction doesn't need any inputs or outputs (except additionalOutputs provided).
+	txn := &MsgDeSoTxn{
+		PublicKey: userPublicKey,
+		TxnMeta: &PostAssociationMetadata{
+			TargetPostHash:   &targetPostHash,
+			AssociationType:  associationType,
+			AssociationValue: associationValue,
+			IsDelete:         isDelete,
+		},
+		TxOutputs: additionalOutputs,
+		// We wait to compute the signature until we've added all the
+		// inputs and change.
+	}
+
+	totalInput, spendAmount, changeAmount, fees, err :=
+		bc.AddInputsAndChangeToTransaction(txn, minFeeRateNanosPerKB, mempool)
+	if err != nil {
+		return nil, 0, 0, 0, errors.Wrapf(
+			err, "CreatePostAssociationTxn: Problem adding inputs: ")
+	}
+
+	// Sanity-check that the spendAmount is zero.
+	if err = amountEqualsAdditionalOutputs(spendAmount, additionalOutputs); err != nil {
+		return nil, 0, 0, 0, fmt.Errorf("CreatePostAssociationTxn: %v", err)
+	}
+
+	return txn, totalInput, changeAmount, fees, nil
+}
+
+func (bc *Blockchain) CreateUserAssociationTxn(
+	userPublicKey []byte, targetUserPublicKey []byte, associationType []byte,
+	associationValue []byte, isDelete bool,
+	minFeeRateNanosPerKB uint64, mempool *DeSoMempool, additionalOutputs []*DeSoOutput) (
+	_txn *MsgDeSoTxn, _totalInput uint64, _changeAmount uint64, _fees uint64,
+	_err error) {
+
+	// A UserAssociation transaction doesn't need any inputs or outputs (except additionalOutputs provided).
+	txn := &MsgDeSoTxn{
+		PublicKey: userPublicKey,
+		TxnMeta: &UserAssociationMetadata{
+			TargetUserPublicKey: targetUserPublicKey,
+			AssociationType:     associationType,
+			AssociationValue:    associationValue,
+			IsDelete:            isDelete,
+		},
+		TxOutputs: additionalOutputs,
+		// We wait to compute the signature until we've added all the
+		// inputs and change.
+	}
+
+	totalInput, spendAmount, changeAmount, fees, err :=
+		bc.AddInputsAndChangeToTransaction(txn, minFeeRateNanosPerKB, mempool)
+	if err != nil {
+		return nil, 0, 0, 0, errors.Wrapf(
+			err, "CreateUserAssociationTxn: Problem adding inputs: ")
+	}
+
+	// Sanity-check that the spendAmount is zero.
+	if err = amountEqualsAdditionalOutputs(spendAmount, additionalOutputs); err != nil {
+		return nil, 0, 0, 0, fmt.Errorf("CreateUserAssociationTxn: %v", err)
+	}
+
+	return txn, totalInput, changeAmount, fees, nil
+}
+
 func (bc *Blockchain) CreateFollowTxn(
 	senderPublicKey []byte, followedPublicKey []byte, isUnfollow bool,
 	minFeeRateNanosPerKB uint64, mempool *DeSoMempool, additionalOutputs []*DeSoOutput) (
@@ -3096,6 +3368,13 @@ func (bc *Blockchain) CreateUpdateGlobalParamsTxn(updaterPublicKey []byte,
 	maxCopiesPerNFT int64,
 	minimumNetworkFeeNanosPerKb int64,
 	forbiddenPubKey []byte,
+	minDAOCoinLimitOrderQuantityInBaseUnits *uint256.Int,
+	daoCoinLimitOrderScaledExchangeRateTick *uint256.Int,
+	daoCoinLimitOrderMakerFeeBasisPoints int64,
+	daoCoinLimitOrderTakerFeeBasisPoints int64,
+	daoCoinLimitOrderFeeDestinationPublicKey []byte,
+	minNetworkFeeNanosPerKBByTxnType map[TxnType]uint64,
+	disabledTxnTypes map[TxnType]bool,
 	// Standard transaction fields
 	minFeeRateNanosPerKB uint64, mempool *DeSoMempool, additionalOutputs []*DeSoOutput) (
 	_txn *MsgDeSoTxn, _totalInput uint64, _changeAmount uint64, _fees uint64, _err error) {
@@ -3120,6 +3399,35 @@ func (bc *Blockchain) CreateUpdateGlobalParamsTxn(updaterPublicKey []byte,
 	if len(forbiddenPubKey) > 0 {
 		extraData[ForbiddenBlockSignaturePubKeyKey] = forbiddenPubKey
 	}
+	if minDAOCoinLimitOrderQuantityInBaseUnits != nil {
+		extraData[MinDAOCoinLimitOrderQuantityKey] = minDAOCoinLimitOrderQuantityInBaseUnits.Bytes()
+	}
+	if daoCoinLimitOrderScaledExchangeRateTick != nil {
+		extraData[DAOCoinLimitOrderExchangeRateTickKey] = daoCoinLimitOrderScaledExchangeRateTick.Bytes()
+	}
+	if daoCoinLimitOrderMakerFeeBasisPoints >= 0 {
+		extraData[DAOCoinLimitOrderMakerFeeBasisPointsKey] = UintToBuf(uint64(daoCoinLimitOrderMakerFeeBasisPoints))
+	}
+	if daoCoinLimitOrderTakerFeeBasisPoints >= 0 {
+		extraData[DAOCoinLimitOrderTakerFeeBasisPointsKey] = UintToBuf(uint64(daoCoinLimitOrderTakerFeeBasisPoints))
+	}
+	if daoCoinLimitOrderFeeDestinationPublicKey != nil {
+		extraData[DAOCoinLimitOrderFeeDestinationPublicKeyKey] = daoCoinLimitOrderFeeDestinationPublicKey
+	}
+	if len(minNetworkFeeNanosPerKBByTxnType) > 0 {
+		extraData[MinNetworkFeeNanosPerKBByTxnTypeKey] = SerializeTxnTypeToUint64Map(minNetworkFeeNanosPerKBByTxnType)
+	}
+	if len(disabledTxnTypes) > 0 {
+		disabledTxnTypesMap := make(map[TxnType]uint64, len(disabledTxnTypes))
+		for txnType, disabled := range disabledTxnTypes {
+			if disabled {
+				disabledTxnTypesMap[txnType] = 1
+			} else {
+				disabledTxnTypesMap[txnType] = 0
+			}
+		}
+		extraData[DisabledTxnTypesKey] = SerializeTxnTypeToUint64Map(disabledTxnTypesMap)
+	}
 
 	txn := &MsgDeSoTxn{
 		PublicKey: updaterPublicKey,
@@ -3548,12 +3856,15 @@ func (bc *Blockchain) CreateDAOCoinLimitOrderTxn(
 
 	// Construct transactor order if submitting a new order so
 	// we can calculate BidderInputs and additional $DESO fees.
-	// This is not necessary if cancelling an existing order.
+	// This is not necessary if cancelling existing orders, or if
+	// placing a trigger order, which doesn't match when it's placed.
 	blockHeight := bc.blockTip().Height + 1
 	var transactorOrder *DAOCoinLimitOrderEntry
+	isSubmittingOrder := metadata.CancelOrderID == nil && !metadata.CancelAllForPair &&
+		metadata.TriggerScaledExchangeRate == nil
 
-	if metadata.CancelOrderID == nil {
-		// CancelOrderID is nil, so we know we're submitting a new order.
+	if isSubmittingOrder {
+		// We're not cancelling anything, so we know we're submitting a new order.
 		transactorOrder = &DAOCoinLimitOrderEntry{
 			OrderID:                   txn.Hash(),
 			TransactorPKID:            utxoView.GetPKIDForPublicKey(UpdaterPublicKey).PKID,
@@ -3564,20 +3875,23 @@ func (bc *Blockchain) CreateDAOCoinLimitOrderTxn(
 			OperationType:                             metadata.OperationType,
 			FillType:                                  metadata.FillType,
 			BlockHeight:                               blockHeight,
+			ExpirationBlockHeight:                     metadata.ExpirationBlockHeight,
+			marketOrderMaxSlippageBasisPoints:         metadata.MarketOrderMaxSlippageBasisPoints,
 		}
 	}
 
 	// We use "additionalFees" to track how much we need to spend to cover the transactor's bid in DESO.
 	var additionalFees uint64
-	if metadata.CancelOrderID == nil &&
+	if isSubmittingOrder &&
 		metadata.BuyingDAOCoinCreatorPublicKey.IsZeroPublicKey() {
 		// If buying $DESO, we need to find inputs from all the orders that match.
 		// This will move to txn construction as this will be put in the metadata.
 		var lastSeenOrder *DAOCoinLimitOrderEntry
 		desoNanosToConsumeMap := make(map[PKID]uint64)
 		transactorQuantityToFill := transactorOrder.QuantityToFillInBaseUnits.Clone()
+		slippageBoundReached := false
 
-		for transactorQuantityToFill.GtUint64(0) {
+		for transactorQuantityToFill.GtUint64(0) && !slippageBoundReached {
 			var matchingOrderEntries []*DAOCoinLimitOrderEntry
 			matchingOrderEntries, err = utxoView.GetNextLimitOrdersToFill(transactorOrder, lastSeenOrder, blockHeight)
 			if err != nil {
@@ -3588,6 +3902,12 @@ func (bc *Blockchain) CreateDAOCoinLimitOrderTxn(
 				break
 			}
 			for _, matchingOrder := range matchingOrderEntries {
+				// Stop where connecting the txn stops if the transactor's
+				// market order has a max slippage.
+				if !transactorOrder.IsValidMatchingOrderPrice(matchingOrder) {
+					slippageBoundReached = true
+					break
+				}
 				lastSeenOrder = matchingOrder
 
 				var matchingOrderDESOBalanceNanos uint64
@@ -3622,6 +3942,7 @@ func (bc *Blockchain) CreateDAOCoinLimitOrderTxn(
 				if desoNanosExchanged.GtUint64(matchingOrderDESOBalanceNanos) {
 					continue
 				}
+				transactorOrder.setMarketOrderSlippageBound(matchingOrder)
 
 				// Initialize map tracking total $DESO consumed if the matching
 				// order transactor PKID hasn't been seen before.
@@ -3656,7 +3977,7 @@ func (bc *Blockchain) CreateDAOCoinLimitOrderTxn(
 
 			metadata.BidderInputs = append(metadata.BidderInputs, &inputsByTransactor)
 		}
-	} else if metadata.CancelOrderID == nil &&
+	} else if isSubmittingOrder &&
 		metadata.SellingDAOCoinCreatorPublicKey.IsZeroPublicKey() {
 		// If selling $DESO for DAO coins, we need to find the matching orders
 		// and add that as an additional fee when adding inputs and outputs.
@@ -3664,8 +3985,9 @@ func (bc *Blockchain) CreateDAOCoinLimitOrderTxn(
 
 		desoNanosToFulfillOrders := uint256.NewInt()
 		transactorQuantityToFill := transactorOrder.QuantityToFillInBaseUnits.Clone()
+		slippageBoundReached := false
 
-		for transactorQuantityToFill.GtUint64(0) {
+		for transactorQuantityToFill.GtUint64(0) && !slippageBoundReached {
 			var matchingOrderEntries []*DAOCoinLimitOrderEntry
 			matchingOrderEntries, err = utxoView.GetNextLimitOrdersToFill(transactorOrder, lastSeenOrder, blockHeight)
 			if err != nil {
@@ -3676,6 +3998,12 @@ func (bc *Blockchain) CreateDAOCoinLimitOrderTxn(
 				break
 			}
 			for _, matchingOrder := range matchingOrderEntries {
+				// Stop where connecting the txn stops if the transactor's
+				// market order has a max slippage.
+				if !transactorOrder.IsValidMatchingOrderPrice(matchingOrder) {
+					slippageBoundReached = true
+					break
+				}
 				lastSeenOrder = matchingOrder
 
 				matchingOrderBalanceEntry := utxoView._getBalanceEntryForHODLerPKIDAndCreatorPKID(
@@ -3709,6 +4037,7 @@ func (bc *Blockchain) CreateDAOCoinLimitOrderTxn(
 				// Now that we know this is a legitimate matching order
 				// we can update the transactor quantity to fill.
 				transactorQuantityToFill = updatedTransactorQuantityToFill
+				transactorOrder.setMarketOrderSlippageBound(matchingOrder)
 
 				// Track total $DESO exchanged across all matching orders.
 				desoNanosToFulfillOrders, err = SafeUint256().Add(
@@ -4160,44 +4489,6 @@ func (bc *Blockchain) CreateUpdateNFTTxn(
 	return txn, totalInput, changeAmount, fees, nil
 }
 
-// Each diamond level is worth a fixed amount of DeSo. These amounts can be changed
-// in the future by simply returning a new set of values after a particular block height.
-func GetDeSoNanosDiamondLevelMapAtBlockHeight(
-	blockHeight int64) map[int64]uint64 {
-
-	return map[int64]uint64{
-		1: 50000,
-		2: 500000,
-		3: 5000000,
-		4: 50000000,
-		5: 500000000,
-		6: 5000000000,
-		7: 50000000000,
-		8: 500000000000,
-	}
-}
-
-func GetDeSoNanosForDiamondLevelAtBlockHeight(
-	diamondLevel int64, blockHeight int64) uint64 {
-
-	// Caller is responsible for passing a valid diamond level.
-	desoNanosMap := GetDeSoNanosDiamondLevelMapAtBlockHeight(blockHeight)
-	desoNanosForLevel, levelExists := desoNanosMap[diamondLevel]
-	if !levelExists {
-		// We allow a special case for diamondLevel zero, in which case we
-		// know that the value should also be zero.
-		if diamondLevel != 0 {
-			// If a non-existent level is requested, return zero
-			glog.Errorf("GetDeSoNanosForDiamondLevelAtBlockHeight: "+
-				"Diamond level %v does not exist in map %v; this should never happen",
-				diamondLevel, desoNanosMap)
-		}
-		return 0
-	}
-
-	return desoNanosForLevel
-}
-
 // At a particular diamond level, a fixed amount of DeSo is converted into creator coins
 // and then sent to a user. This function computes the amount of creator coins required for
 // a particular level.
@@ -4887,3 +5178,101 @@ func (bc *Blockchain) EstimateDefaultFeeRateNanosPerKB(
 	}
 	return allFeesNanosPerKB[medianPos]
 }
+
+// ForceResetToLastSnapshot is a doomsday scenario recovery mode. It will be triggered if the node was shutdown midway,
+// resulting in a corrupted ancestral records or checksum. To recover from this situation, we will revert to the beginning
+// of the current snapshot epoch. We do this by disconnecting blocks from the tip to the epoch's start and resetting the checksum.
+func (snap *Snapshot) ForceResetToLastSnapshot(chain *Blockchain) error {
+	snap.stopped = true
+
+	// First we'll stop and reset the snapshot operation channel.
+	snap.OperationChannel.EnqueueOperation(&SnapshotOperation{
+		operationType: SnapshotOperationExit,
+	})
+	snap.WaitForAllOperationsToFinish()
+	snap.updateWaitGroup.Wait()
+	snap.OperationChannel.StateSemaphore = 0
+
+	// Now, we'll reset the snapshot db status semaphores.
+	snap.Status.MainDBSemaphore = 0
+	snap.Status.AncestralDBSemaphore = 0
+
+	// Now, disconnect the blocks to the beginning of the snapshot epoch, or equivalently, end of the last snapshot epoch.
+	lastEpochHeight := snap.CurrentEpochSnapshotMetadata.SnapshotBlockHeight
+	err := chain.DisconnectBlocksToHeight(lastEpochHeight)
+	if err != nil {
+		return errors.Wrapf(err, "ForceResetToLastSnapshot: Problem disconnecting blocks")
+	}
+
+	// Reset the state checksum to the one we got at the beginning of this epoch.
+	if len(snap.CurrentEpochSnapshotMetadata.CurrentEpochChecksumBytes) == 0 {
+		snap.Checksum.ResetChecksum()
+	} else {
+		err = snap.Checksum.FromBytes(snap.CurrentEpochSnapshotMetadata.CurrentEpochChecksumBytes)
+		if err != nil {
+			return errors.Wrapf(err, "ForceResetToLastSnapshot: problem resetting checksum bytes")
+		}
+	}
+	err = snap.Checksum.SaveChecksum()
+	if err != nil {
+		return errors.Wrapf(err, "ForceResetToLastSnapshot: Problem saving checksum")
+	}
+
+	// Similarly, we'll reset the encoder migration checksums.
+	snap.Migrations.ResetChecksums()
+	if err = snap.Migrations.SaveMigrations(); err != nil {
+		return errors.Wrapf(err, "ForceResetToLastSnapshot: Problem saving migrations")
+	}
+
+	// Save the operation channel status and the db status.
+	if err = snap.OperationChannel.SaveOperationChannel(); err != nil {
+		return errors.Errorf("ForceResetToLastSnapshot: Problem saving operation channel in database. Error: (%v)", err)
+	}
+	snap.Status.SaveStatus()
+
+	// Delete all ancestral records for the current snapshot epoch.
+	if err = snap.DeleteAncestralRecords(lastEpochHeight); err != nil {
+		return errors.Wrapf(err, "ForceResetToLastSnapshot: Problem deleting ancestral records at height (%v)", lastEpochHeight)
+	}
+
+	// Now we'll verify that the final state checksum matches the last snapshot checksum. We do it in a slightly hacky way
+	// where we create and start an empty migration. The StartMigration function will basically scan the entire node's
+	// db and compute the checksum. It's easier than having a separate function for this; although, we might have a
+	// dedicated function for this in the future for better code clarity.
+	glog.Infof(CLog(Yellow, "ForceResetToLastSnapshot: Finished node reset, will now proceed to verify state checksum."))
+	verificationMigration := EncoderMigration{}
+	verificationMigration.InitializeSingleHeight(chain.db, snap.SnapshotDb, snap.SnapshotDbMutex, lastEpochHeight, snap.params)
+	if err := verificationMigration.StartMigrations(); err != nil {
+		return errors.Wrapf(err, "ForceResetToLastSnapshot: Problem starting verification migration.")
+	}
+	if len(verificationMigration.migrationChecksums) != 1 {
+		return errors.Errorf("ForceResetToLastSnapshot: Number of migration checksums is invalid.")
+	}
+	verificationChecksum, err := verificationMigration.migrationChecksums[0].Checksum.ToBytes()
+	if err != nil {
+		return errors.Wrapf(err, "ForceResetToLastSnapshot: Problem getting verification migration.")
+	}
+	if len(snap.CurrentEpochSnapshotMetadata.CurrentEpochChecksumBytes) == 0 {
+		identitySum := &StateChecksum{}
+		identitySum.Initialize(nil, nil)
+		snap.CurrentEpochSnapshotMetadata.CurrentEpochChecksumBytes, err = identitySum.ToBytes()
+		if err != nil {
+			return errors.Wrapf(err, "ForceResetToLastSnapshot: Current epoch checksum was empty but failed to reset")
+		}
+	}
+	// Make sure the snapshot epoch checksum is equal to the checksum that we've computed during the StartMigrations,
+	// i.e. the checksum we got by scanning the entire db and manually recomputing the checksum from scratch.
+	// This check is very important, if it fails then it means that there is no way for us to recover and we should resync.
+	if !reflect.DeepEqual(snap.CurrentEpochSnapshotMetadata.CurrentEpochChecksumBytes, verificationChecksum) {
+
+		return errors.Errorf("ForceRestartToLastSnapshot: Snapshot epoch checksum: (%v), and verification "+
+			"checksum: (%v), are not equal. This means recovery failed. Unfortunatelly, we have to resync your node.",
+			snap.CurrentEpochSnapshotMetadata.CurrentEpochChecksumBytes, verificationChecksum)
+	}
+
+	if err := snap.SnapshotDb.Close(); err != nil {
+		return errors.Wrapf(err, "ForceResetToLastSnapshot: Problem closing snapshot db.")
+	}
+	glog.Infof(CLog(Yellow, "ForceResetToLastSnapshot: Finished rolling back blocks and recovering snapshot. Node should now be restarted."))
+	return nil
+}
@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -1561,7 +1563,7 @@ func TestForbiddenBlockSignaturePubKey(t *testing.T) {
 	blockSignerPkBytes, _, err := Base58CheckDecode(blockSignerPk)
 	require.NoError(err)
 	txn, _, _, _, err := chain.CreateUpdateGlobalParamsTxn(
-		senderPkBytes, -1, -1, -1, -1, -1, blockSignerPkBytes, 100 /*feeRateNanosPerKB*/, nil, []*DeSoOutput{})
+		senderPkBytes, -1, -1, -1, -1, -1, blockSignerPkBytes, nil, nil, -1, -1, nil, nil, nil, 100 /*feeRateNanosPerKB*/, nil, []*DeSoOutput{})
 	require.NoError(err)
 
 	// Mine a few blocks to give the senderPkString some money.
@@ -1592,3 +1594,182 @@ func TestForbiddenBlockSignaturePubKey(t *testing.T) {
 	require.Error(err)
 	require.Contains(err.Error(), RuleErrorForbiddenBlockProducerPublicKey)
 }
+
+func TestStartupConsistencyCheck(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	if chain.postgres != nil {
+		t.Skip("The startup consistency check only runs on badger")
+	}
+	mempool, miner := NewTestMiner(t, chain, params, true /*isSender*/)
+	for ii := 0; ii < 3; ii++ {
+		_, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
+		require.NoError(err)
+	}
+	tipNode := chain.BlockTip()
+	flushHeight := DbGetStateFlushHeight(db, nil)
+	require.NotNil(flushHeight)
+	require.Equal(uint64(tipNode.Height), *flushHeight)
+
+	reopenChain := func() error {
+		_, err := NewBlockchain([]string{blockSignerPk}, 0, 0, params,
+			chainlib.NewMedianTime(), db, nil, nil, nil, false)
+		return err
+	}
+	require.NoError(reopenChain())
+
+	// A flush height that doesn't match the best block is caught on startup.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return DbPutStateFlushHeightWithTxn(txn, nil, uint64(tipNode.Height)+1)
+	}))
+	require.Error(reopenChain())
+
+	// So is a best block that was never validated.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		if err := DbPutStateFlushHeightWithTxn(txn, nil, uint64(tipNode.Height)); err != nil {
+			return err
+		}
+		unvalidatedNode := *tipNode
+		unvalidatedNode.Status = StatusHeaderValidated | StatusBlockProcessed | StatusBlockStored
+		return PutHeightHashToNodeInfoWithTxn(txn, nil, &unvalidatedNode, false /*bitcoinNodes*/)
+	}))
+	require.Error(reopenChain())
+
+	// Dbs that don't have a flush height yet are let through.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		if err := PutHeightHashToNodeInfoWithTxn(txn, nil, tipNode, false /*bitcoinNodes*/); err != nil {
+			return err
+		}
+		return txn.Delete(Prefixes.PrefixStateFlushHeight)
+	}))
+	require.NoError(reopenChain())
+
+	// A txindex that's ahead of the chain is rejected.
+	require.NoError(chain.CheckTxindexTipConsistency(tipNode))
+	aheadNode := *tipNode
+	aheadNode.Height++
+	require.Error(chain.CheckTxindexTipConsistency(&aheadNode))
+}
+
+func TestMainChainIndex(t *testing.T) {
+	require := require.New(t)
+
+	blockA1, blockA2, blockB1, blockB2, blockB3, _, _ := getForkedChain(t)
+	chain, params, db := NewLowDifficultyBlockchain()
+	if chain.postgres != nil {
+		t.Skip("The main chain index is only kept on badger")
+	}
+	requireIndexMatchesBestChain := func() {
+		mainChainHashes, err := DbGetMainChainHashes(db)
+		require.NoError(err)
+		require.Equal(len(chain.bestChain), len(mainChainHashes))
+		for ii, node := range chain.bestChain {
+			require.Equal(*node.Hash, *mainChainHashes[ii])
+		}
+		require.Nil(DbGetMainChainHashAtHeight(db, uint64(len(chain.bestChain))))
+	}
+
+	_shouldConnectBlock(blockA1, t, chain)
+	_shouldConnectBlock(blockA2, t, chain)
+	requireIndexMatchesBestChain()
+
+	// The reorg to the B chain replaces the A blocks in the index.
+	for _, block := range []*MsgDeSoBlock{blockB1, blockB2} {
+		_, _, err := chain.ProcessBlock(block, true /*verifySignatures*/)
+		require.NoError(err)
+	}
+	blockA2Hash, err := blockA2.Hash()
+	require.NoError(err)
+	blockA2Node := chain.blockIndex[*blockA2Hash]
+	require.Equal(blockA2Node, DbGetForkPointWithMainChain(db, blockA2Node))
+	_shouldConnectBlock(blockB3, t, chain)
+	requireIndexMatchesBestChain()
+
+	// The A chain forked off at the genesis block.
+	require.Equal(chain.bestChain[0], DbGetForkPointWithMainChain(db, blockA2Node))
+	require.Equal(chain.blockTip(), DbGetForkPointWithMainChain(db, chain.blockTip()))
+
+	// The best chain is read from the index on startup, and the index is rebuilt if it doesn't
+	// match the block index.
+	reopenChain := func() *Blockchain {
+		reopenedChain, err := NewBlockchain([]string{blockSignerPk}, 0, 0, params,
+			chainlib.NewMedianTime(), db, nil, nil, nil, false)
+		require.NoError(err)
+		return reopenedChain
+	}
+	require.Equal(len(chain.bestChain), len(reopenChain().bestChain))
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return DbDeleteMainChainHashAtHeightWithTxn(txn, 1)
+	}))
+	_, matches, err := GetBestChainFromMainChainIndex(db, chain.blockTip(), chain.blockIndex)
+	require.NoError(err)
+	require.False(matches)
+	require.Equal(len(chain.bestChain), len(reopenChain().bestChain))
+	requireIndexMatchesBestChain()
+
+	// Rebuilding the index also removes heights above the tip.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return DbPutMainChainHashAtHeightWithTxn(txn, uint64(len(chain.bestChain)+5), chain.blockTip().Hash)
+	}))
+	require.NoError(DbPutMainChain(db, chain.bestChain))
+	requireIndexMatchesBestChain()
+
+	// Blocks downloaded by height are looked up in the index.
+	resp, err := NewDownloadServer(chain, chain.snapshot).GetBlocksByHeightRange(&BlockRangeRequest{
+		StartHeight: 1,
+		EndHeight:   uint64(len(chain.bestChain) + 10),
+	})
+	require.NoError(err)
+	require.True(resp.Complete)
+	require.Equal(len(chain.bestChain)-1, len(resp.Blocks))
+	for ii, downloadedBlock := range resp.Blocks {
+		require.Equal(*chain.bestChain[ii+1].Hash, *downloadedBlock.Hash)
+	}
+
+	// Disconnecting blocks removes them from the index.
+	require.NoError(chain.DisconnectBlocksToHeight(1))
+	mainChainHashes, err := DbGetMainChainHashes(db)
+	require.NoError(err)
+	require.Equal(2, len(mainChainHashes))
+}
+
+func TestBlockStats(t *testing.T) {
+	require := require.New(t)
+
+	blockA1, blockA2, blockB1, blockB2, blockB3, _, _ := getForkedChain(t)
+	chain, _, db := NewLowDifficultyBlockchain()
+	if chain.postgres != nil {
+		t.Skip("Block stats are only kept on badger")
+	}
+	requireStatsMatchBlocks := func(blocks []*MsgDeSoBlock) {
+		blockStatsList, err := DbGetBlockStatsForHeightRange(db, 1, uint64(len(chain.bestChain)))
+		require.NoError(err)
+		require.Equal(len(blocks), len(blockStatsList))
+		for ii, block := range blocks {
+			blockHash, err := block.Hash()
+			require.NoError(err)
+			blockBytes, err := block.ToBytes(false)
+			require.NoError(err)
+			require.Equal(ComputeBlockStats(block, blockHash, uint64(len(blockBytes))), blockStatsList[ii])
+			require.Equal(uint64(len(block.Txns)), blockStatsList[ii].TxnCount)
+		}
+	}
+
+	_shouldConnectBlock(blockA1, t, chain)
+	_shouldConnectBlock(blockA2, t, chain)
+	requireStatsMatchBlocks([]*MsgDeSoBlock{blockA1, blockA2})
+
+	// Only the main chain's blocks are returned after the reorg to the B chain.
+	for _, block := range []*MsgDeSoBlock{blockB1, blockB2, blockB3} {
+		_, _, err := chain.ProcessBlock(block, true /*verifySignatures*/)
+		require.NoError(err)
+	}
+	requireStatsMatchBlocks([]*MsgDeSoBlock{blockB1, blockB2, blockB3})
+
+	// The height range is inclusive.
+	blockStatsList, err := DbGetBlockStatsForHeightRange(db, 2, 2)
+	require.NoError(err)
+	require.Len(blockStatsList, 1)
+	require.Equal(uint64(2), blockStatsList[0].Height)
+}
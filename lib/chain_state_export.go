@@ -0,0 +1,322 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// ExportChainState and ImportChainState move the state of a node to another machine without a
+// resync. An export is:
+//   - A header with ChainStateExportMagic and the format version.
+//   - Chunks of about ChainStateExportChunkSize bytes. A chunk is the number of records it holds,
+//     the records as length-prefixed keys and values, and the sha256 of the records.
+//   - A trailer, which is a zero record count followed by the total number of records and the
+//     checksum of all the chunks. See _chainStateChecksum.
+//
+// Only the state prefixes are exported, so like after a hypersync, the node downloads the blocks
+// from its peers once the state is imported.
+
+var ChainStateExportMagic = []byte("DESOSTATE")
+
+const ChainStateExportVersion uint64 = 1
+
+// chainStateMaxRecordFieldSize bounds the length of the keys and values ImportChainState accepts,
+// so that a corrupted length doesn't make it allocate the length.
+const chainStateMaxRecordFieldSize = 1 << 30
+
+// ChainStateProgress is passed to the progress callback of ExportChainState and ImportChainState
+// after every chunk. NumBytes counts the bytes of the keys and values.
+type ChainStateProgress struct {
+	NumChunks  uint64
+	NumRecords uint64
+	NumBytes   uint64
+	// NumSkippedChunks is the number of chunks a resumed import skipped because they were written
+	// by the import that didn't finish. They're included in the other counts.
+	NumSkippedChunks uint64
+}
+
+type ChainStateProgressFunc func(progress *ChainStateProgress)
+
+// ChainStateImportProgress is stored after every chunk an import writes, so that importing the
+// same export again resumes after the chunks that were already written.
+type ChainStateImportProgress struct {
+	NumChunks  uint64
+	NumRecords uint64
+	// Checksum is the checksum of the chunks that were written, which must match when the import
+	// is resumed. It makes sure the import is resumed from the same export.
+	Checksum *BlockHash
+}
+
+func (importProgress *ChainStateImportProgress) ToBytes() []byte {
+	data := UintToBuf(importProgress.NumChunks)
+	data = append(data, UintToBuf(importProgress.NumRecords)...)
+	data = append(data, importProgress.Checksum[:]...)
+	return data
+}
+
+func (importProgress *ChainStateImportProgress) FromBytes(rr io.Reader) error {
+	var err error
+	if importProgress.NumChunks, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "ChainStateImportProgress.FromBytes: Problem reading NumChunks")
+	}
+	if importProgress.NumRecords, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "ChainStateImportProgress.FromBytes: Problem reading NumRecords")
+	}
+	importProgress.Checksum = &BlockHash{}
+	if _, err = io.ReadFull(rr, importProgress.Checksum[:]); err != nil {
+		return errors.Wrapf(err, "ChainStateImportProgress.FromBytes: Problem reading Checksum")
+	}
+	return nil
+}
+
+// _chainStateChecksum chains the checksum of a chunk onto the checksum of the chunks before it.
+func _chainStateChecksum(checksum BlockHash, chunkChecksum [sha256.Size]byte) BlockHash {
+	return BlockHash(sha256.Sum256(append(checksum[:], chunkChecksum[:]...)))
+}
+
+// ExportChainState writes the records of every state prefix to w. The records are read in a single
+// txn, so the export is a consistent view of the state even if the node keeps running. They're
+// read with iterators rather than the badger Stream API, because the Stream API sends key ranges
+// out of order, and a resumed import relies on getting the same chunks from every read of the
+// export. progressFunc can be nil.
+func ExportChainState(handle *badger.DB, w io.Writer, progressFunc ChainStateProgressFunc) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(append(append([]byte{}, ChainStateExportMagic...),
+		UintToBuf(ChainStateExportVersion)...)); err != nil {
+
+		return errors.Wrapf(err, "ExportChainState: Problem writing header")
+	}
+
+	progress := &ChainStateProgress{}
+	checksum := BlockHash{}
+	chunk := []byte{}
+	numChunkRecords := uint64(0)
+	numChunkBytes := uint64(0)
+	writeChunk := func() error {
+		if numChunkRecords == 0 {
+			return nil
+		}
+		chunkChecksum := sha256.Sum256(chunk)
+		data := append(UintToBuf(numChunkRecords), chunk...)
+		data = append(data, chunkChecksum[:]...)
+		if _, err := bw.Write(data); err != nil {
+			return err
+		}
+		checksum = _chainStateChecksum(checksum, chunkChecksum)
+		progress.NumChunks++
+		progress.NumRecords += numChunkRecords
+		progress.NumBytes += numChunkBytes
+		if progressFunc != nil {
+			progressFunc(progress)
+		}
+		chunk = []byte{}
+		numChunkRecords = 0
+		numChunkBytes = 0
+		return nil
+	}
+
+	err := handle.View(func(txn *badger.Txn) error {
+		exportPrefix := func(prefix []byte) error {
+			it := txn.NewIterator(badger.DefaultIteratorOptions)
+			defer it.Close()
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				key := it.Item().Key()
+				value, err := it.Item().ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				chunk = append(chunk, EncodeByteArray(key)...)
+				chunk = append(chunk, EncodeByteArray(value)...)
+				numChunkRecords++
+				numChunkBytes += uint64(len(key) + len(value))
+				if len(chunk) >= ChainStateExportChunkSize {
+					if err = writeChunk(); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+		for _, prefix := range StatePrefixes.StatePrefixesList {
+			if err := exportPrefix(prefix); err != nil {
+				return errors.Wrapf(err, "Problem exporting prefix %v", prefix)
+			}
+		}
+		return writeChunk()
+	})
+	if err != nil {
+		return errors.Wrapf(err, "ExportChainState: ")
+	}
+
+	trailer := append(UintToBuf(0), UintToBuf(progress.NumRecords)...)
+	trailer = append(trailer, checksum[:]...)
+	if _, err = bw.Write(trailer); err != nil {
+		return errors.Wrapf(err, "ExportChainState: Problem writing trailer")
+	}
+	if err = bw.Flush(); err != nil {
+		return errors.Wrapf(err, "ExportChainState: Problem flushing export")
+	}
+	return nil
+}
+
+// ImportChainState writes the records of an export made by ExportChainState to the db. It's meant
+// for a new data directory: the records overwrite the ones already in the db, and they're written
+// without a snapshot, so the snapshot checksum isn't updated.
+//
+// Each chunk is verified against its checksum and written in its own txn. If the import is
+// interrupted, calling ImportChainState again with the same export skips the chunks that were
+// already written. progressFunc can be nil.
+func ImportChainState(handle *badger.DB, r io.Reader, progressFunc ChainStateProgressFunc) error {
+	rr := bufio.NewReader(r)
+	magic := make([]byte, len(ChainStateExportMagic))
+	if _, err := io.ReadFull(rr, magic); err != nil || !bytes.Equal(magic, ChainStateExportMagic) {
+		return fmt.Errorf("ImportChainState: The input isn't a chain state export")
+	}
+	version, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "ImportChainState: Problem reading version")
+	}
+	if version != ChainStateExportVersion {
+		return fmt.Errorf("ImportChainState: Export version %v isn't supported, expected version %v",
+			version, ChainStateExportVersion)
+	}
+
+	importProgress, err := DbGetChainStateImportProgress(handle)
+	if err != nil {
+		return errors.Wrapf(err, "ImportChainState: ")
+	}
+
+	progress := &ChainStateProgress{}
+	checksum := BlockHash{}
+	for {
+		numChunkRecords, err := ReadUvarint(rr)
+		if err != nil {
+			return errors.Wrapf(err, "ImportChainState: Problem reading chunk %v", progress.NumChunks)
+		}
+		if numChunkRecords == 0 {
+			break
+		}
+		records, chunkChecksum, err := _readChainStateChunk(rr, numChunkRecords)
+		if err != nil {
+			return errors.Wrapf(err, "ImportChainState: Problem reading chunk %v", progress.NumChunks)
+		}
+		checksum = _chainStateChecksum(checksum, chunkChecksum)
+		progress.NumChunks++
+		progress.NumRecords += numChunkRecords
+		for _, record := range records {
+			progress.NumBytes += uint64(len(record.Key) + len(record.Value))
+		}
+
+		if importProgress != nil && progress.NumChunks <= importProgress.NumChunks {
+			progress.NumSkippedChunks++
+			if progress.NumChunks == importProgress.NumChunks && checksum != *importProgress.Checksum {
+				return fmt.Errorf("ImportChainState: The db has an unfinished import of a different export")
+			}
+			if progressFunc != nil {
+				progressFunc(progress)
+			}
+			continue
+		}
+
+		err = handle.Update(func(txn *badger.Txn) error {
+			for _, record := range records {
+				if !isStateKey(record.Key) {
+					return fmt.Errorf("Record key %v isn't a state key", record.Key)
+				}
+				if err := DBSetWithTxn(txn, nil, record.Key, record.Value); err != nil {
+					return err
+				}
+			}
+			return DbPutChainStateImportProgressWithTxn(txn, &ChainStateImportProgress{
+				NumChunks:  progress.NumChunks,
+				NumRecords: progress.NumRecords,
+				Checksum:   &checksum,
+			})
+		})
+		if err != nil {
+			return errors.Wrapf(err, "ImportChainState: Problem writing chunk %v", progress.NumChunks-1)
+		}
+		if progressFunc != nil {
+			progressFunc(progress)
+		}
+	}
+	if importProgress != nil && progress.NumChunks < importProgress.NumChunks {
+		return fmt.Errorf("ImportChainState: The db has an unfinished import of a different export")
+	}
+
+	numRecords, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "ImportChainState: Problem reading trailer")
+	}
+	expectedChecksum := BlockHash{}
+	if _, err = io.ReadFull(rr, expectedChecksum[:]); err != nil {
+		return errors.Wrapf(err, "ImportChainState: Problem reading trailer")
+	}
+	if numRecords != progress.NumRecords || checksum != expectedChecksum {
+		return fmt.Errorf("ImportChainState: The export has %v records with checksum %v, but the "+
+			"trailer expects %v records with checksum %v", progress.NumRecords, &checksum, numRecords,
+			&expectedChecksum)
+	}
+
+	if err = handle.Update(func(txn *badger.Txn) error {
+		return DBDeleteWithTxn(txn, nil, Prefixes.PrefixChainStateImportProgress)
+	}); err != nil {
+		return errors.Wrapf(err, "ImportChainState: Problem deleting import progress")
+	}
+	return nil
+}
+
+// _readChainStateChunk reads the records of a chunk and verifies them against the chunk's checksum.
+func _readChainStateChunk(rr io.Reader, numRecords uint64) (
+	_records []*DBEntry, _chunkChecksum [sha256.Size]byte, _err error) {
+
+	hasher := sha256.New()
+	chunkReader := io.TeeReader(rr, hasher)
+	records := []*DBEntry{}
+	for ii := uint64(0); ii < numRecords; ii++ {
+		key, err := _readChainStateRecordField(chunkReader)
+		if err != nil {
+			return nil, [sha256.Size]byte{}, errors.Wrapf(err, "Problem reading key of record %v", ii)
+		}
+		if len(key) == 0 {
+			return nil, [sha256.Size]byte{}, fmt.Errorf("Record %v has an empty key", ii)
+		}
+		value, err := _readChainStateRecordField(chunkReader)
+		if err != nil {
+			return nil, [sha256.Size]byte{}, errors.Wrapf(err, "Problem reading value of record %v", ii)
+		}
+		records = append(records, &DBEntry{Key: key, Value: value})
+	}
+
+	var chunkChecksum [sha256.Size]byte
+	copy(chunkChecksum[:], hasher.Sum(nil))
+	var expectedChecksum [sha256.Size]byte
+	if _, err := io.ReadFull(rr, expectedChecksum[:]); err != nil {
+		return nil, [sha256.Size]byte{}, errors.Wrapf(err, "Problem reading checksum")
+	}
+	if chunkChecksum != expectedChecksum {
+		return nil, [sha256.Size]byte{}, fmt.Errorf("The chunk doesn't match its checksum")
+	}
+	return records, chunkChecksum, nil
+}
+
+func _readChainStateRecordField(rr io.Reader) ([]byte, error) {
+	fieldLen, err := ReadUvarint(rr)
+	if err != nil {
+		return nil, err
+	}
+	if fieldLen > chainStateMaxRecordFieldSize {
+		return nil, fmt.Errorf("Length %v is over the limit of %v", fieldLen, chainStateMaxRecordFieldSize)
+	}
+	field := make([]byte, fieldLen)
+	if _, err = io.ReadFull(rr, field); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
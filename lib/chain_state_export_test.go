@@ -0,0 +1,95 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainStateExportImport(t *testing.T) {
+	require := require.New(t)
+
+	sourceDb, sourceDir := GetTestBadgerDb()
+	defer os.RemoveAll(sourceDir)
+	defer sourceDb.Close()
+
+	// Write enough state for a few chunks, and a record that isn't state.
+	stateRecords := make(map[string][]byte)
+	require.NoError(sourceDb.Update(func(txn *badger.Txn) error {
+		for ii := 0; ii < 300; ii++ {
+			key := append([]byte{}, Prefixes.PrefixPostHashToPostEntry...)
+			key = append(key, RandomBytes(HashSizeBytes)...)
+			value := RandomBytes(10000)
+			stateRecords[string(key)] = value
+			if err := DBSetWithTxn(txn, nil, key, value); err != nil {
+				return err
+			}
+		}
+		return DBSetWithTxn(txn, nil, append([]byte{}, Prefixes.PrefixBlockStats...), []byte{1})
+	}))
+	exportState := func(db *badger.DB) []byte {
+		var export bytes.Buffer
+		var lastProgress ChainStateProgress
+		require.NoError(ExportChainState(db, &export, func(progress *ChainStateProgress) {
+			lastProgress = *progress
+		}))
+		require.Equal(uint64(len(stateRecords)), lastProgress.NumRecords)
+		require.Greater(lastProgress.NumChunks, uint64(2))
+		return export.Bytes()
+	}
+	export := exportState(sourceDb)
+
+	targetDb, targetDir := GetTestBadgerDb()
+	defer os.RemoveAll(targetDir)
+	defer targetDb.Close()
+
+	// A corrupted export fails the import at the corrupted chunk, after writing the chunks before it.
+	corruptedExport := append([]byte{}, export...)
+	corruptedExport[len(corruptedExport)/2] ^= 0xFF
+	require.Error(ImportChainState(targetDb, bytes.NewReader(corruptedExport), nil))
+	importProgress, err := DbGetChainStateImportProgress(targetDb)
+	require.NoError(err)
+	require.NotNil(importProgress)
+	require.Greater(importProgress.NumChunks, uint64(0))
+
+	// The import can't be resumed with another export.
+	firstKey := ""
+	for key := range stateRecords {
+		if firstKey == "" || key < firstKey {
+			firstKey = key
+		}
+	}
+	require.NoError(sourceDb.Update(func(txn *badger.Txn) error {
+		return DBSetWithTxn(txn, nil, []byte(firstKey), RandomBytes(10000))
+	}))
+	otherExport := exportState(sourceDb)
+	err = ImportChainState(targetDb, bytes.NewReader(otherExport), nil)
+	require.Error(err)
+	require.Contains(err.Error(), "different export")
+
+	// Importing the export again skips the chunks that were already written.
+	var lastProgress ChainStateProgress
+	require.NoError(ImportChainState(targetDb, bytes.NewReader(export), func(progress *ChainStateProgress) {
+		lastProgress = *progress
+	}))
+	require.Equal(importProgress.NumChunks, lastProgress.NumSkippedChunks)
+	require.Equal(uint64(len(stateRecords)), lastProgress.NumRecords)
+	importProgress, err = DbGetChainStateImportProgress(targetDb)
+	require.NoError(err)
+	require.Nil(importProgress)
+
+	// The target has the state records and nothing else.
+	keys, values := EnumerateKeysForPrefix(targetDb, []byte{})
+	require.Len(keys, len(stateRecords))
+	for ii, key := range keys {
+		require.Equal(stateRecords[string(key)], values[ii])
+	}
+
+	// Exports that aren't chain state exports are refused.
+	require.Error(ImportChainState(targetDb, bytes.NewReader([]byte("not an export")), nil))
+}
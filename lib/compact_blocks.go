@@ -0,0 +1,155 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// compact_blocks.go implements compact block relay. Rather than sending a full block to a
+// peer that's caught up, we send the block's header along with a short ID for every txn
+// the peer most likely has in its mempool already. The peer then rebuilds the block from
+// its mempool, and falls back to requesting the full block if it can't.
+
+// ComputeCompactBlockShortTxIDKey computes the key that the short txn IDs of a compact
+// block are derived from. Mixing in a nonce means that someone who wants to create
+// collisions can't precompute them for all of the blocks we send.
+func ComputeCompactBlockShortTxIDKey(blockHash *BlockHash, nonce uint64) [32]byte {
+	data := append([]byte{}, blockHash[:]...)
+	nonceBytes := [8]byte{}
+	binary.BigEndian.PutUint64(nonceBytes[:], nonce)
+	data = append(data, nonceBytes[:]...)
+	return sha256.Sum256(data)
+}
+
+// ComputeCompactBlockShortTxID computes the short ID of a txn for a compact block whose
+// short txn ID key is the one passed in.
+func ComputeCompactBlockShortTxID(key [32]byte, txHash *BlockHash) CompactBlockShortTxID {
+	shortTxIDHash := sha256.Sum256(append(key[:], txHash[:]...))
+	shortTxID := CompactBlockShortTxID{}
+	copy(shortTxID[:], shortTxIDHash[:CompactBlockShortTxIDLen])
+	return shortTxID
+}
+
+// NewCompactBlock builds a compact block out of a block. The block reward is always sent in
+// full, as is every txn for which isTxnKnownToPeer returns false.
+func NewCompactBlock(blk *MsgDeSoBlock, nonce uint64,
+	isTxnKnownToPeer func(_txHash *BlockHash) bool) (*MsgDeSoCompactBlock, error) {
+
+	if blk == nil || blk.Header == nil {
+		return nil, fmt.Errorf("NewCompactBlock: nil block or nil header")
+	}
+	blockHash, err := blk.Header.Hash()
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewCompactBlock: Problem computing block hash")
+	}
+	shortTxIDKey := ComputeCompactBlockShortTxIDKey(blockHash, nonce)
+
+	compactBlock := &MsgDeSoCompactBlock{
+		Header:            blk.Header,
+		BlockProducerInfo: blk.BlockProducerInfo,
+		ShortTxIDNonce:    nonce,
+	}
+	for ii, txn := range blk.Txns {
+		txHash := txn.Hash()
+		if ii == 0 || isTxnKnownToPeer == nil || !isTxnKnownToPeer(txHash) {
+			compactBlock.PrefilledTxns = append(compactBlock.PrefilledTxns, &CompactBlockPrefilledTxn{
+				Index: uint64(ii),
+				Txn:   txn,
+			})
+			continue
+		}
+		compactBlock.ShortTxIDs = append(compactBlock.ShortTxIDs,
+			ComputeCompactBlockShortTxID(shortTxIDKey, txHash))
+	}
+
+	return compactBlock, nil
+}
+
+// ReconstructBlock rebuilds the full block out of the compact block's prefilled txns and the
+// txns passed in, which are usually the txns in our mempool. It errors if a short ID doesn't
+// match exactly one of the txns or if the rebuilt block doesn't match the header's merkle
+// root. In either case the caller should request the full block instead.
+func (msg *MsgDeSoCompactBlock) ReconstructBlock(txns []*MsgDeSoTxn) (*MsgDeSoBlock, error) {
+	blockHash, err := msg.Hash()
+	if err != nil {
+		return nil, errors.Wrapf(err, "ReconstructBlock: ")
+	}
+	numTxns := msg.NumTxns()
+	if numTxns == 0 {
+		return nil, fmt.Errorf("ReconstructBlock: Block %v has no txns", blockHash)
+	}
+	shortTxIDKey := ComputeCompactBlockShortTxIDKey(blockHash, msg.ShortTxIDNonce)
+
+	// Index the txns by the short IDs we're looking for. A short ID that matches more than one
+	// txn is ambiguous so we keep track of those in order to fail the reconstruction.
+	txnsByShortTxID := make(map[CompactBlockShortTxID]*MsgDeSoTxn, len(msg.ShortTxIDs))
+	for _, shortTxID := range msg.ShortTxIDs {
+		txnsByShortTxID[shortTxID] = nil
+	}
+	ambiguousShortTxIDs := make(map[CompactBlockShortTxID]bool)
+	for _, txn := range txns {
+		txHash := txn.Hash()
+		shortTxID := ComputeCompactBlockShortTxID(shortTxIDKey, txHash)
+		existingTxn, wanted := txnsByShortTxID[shortTxID]
+		if !wanted {
+			continue
+		}
+		if existingTxn != nil && *existingTxn.Hash() != *txHash {
+			ambiguousShortTxIDs[shortTxID] = true
+			continue
+		}
+		txnsByShortTxID[shortTxID] = txn
+	}
+
+	blockTxns := make([]*MsgDeSoTxn, 0, numTxns)
+	nextPrefilledTxn := 0
+	nextShortTxID := 0
+	for ii := uint64(0); ii < numTxns; ii++ {
+		if nextPrefilledTxn < len(msg.PrefilledTxns) && msg.PrefilledTxns[nextPrefilledTxn].Index == ii {
+			blockTxns = append(blockTxns, msg.PrefilledTxns[nextPrefilledTxn].Txn)
+			nextPrefilledTxn++
+			continue
+		}
+		if nextShortTxID >= len(msg.ShortTxIDs) {
+			return nil, fmt.Errorf("ReconstructBlock: Ran out of short txn IDs at index %d of block %v",
+				ii, blockHash)
+		}
+		shortTxID := msg.ShortTxIDs[nextShortTxID]
+		nextShortTxID++
+		if ambiguousShortTxIDs[shortTxID] {
+			return nil, fmt.Errorf("ReconstructBlock: Short txn ID %x at index %d of block %v "+
+				"matches more than one txn", shortTxID, ii, blockHash)
+		}
+		txn := txnsByShortTxID[shortTxID]
+		if txn == nil {
+			return nil, fmt.Errorf("ReconstructBlock: Missing txn with short txn ID %x at index %d "+
+				"of block %v", shortTxID, ii, blockHash)
+		}
+		blockTxns = append(blockTxns, txn)
+	}
+	if nextPrefilledTxn != len(msg.PrefilledTxns) || nextShortTxID != len(msg.ShortTxIDs) {
+		return nil, fmt.Errorf("ReconstructBlock: Prefilled txn indexes don't line up with the "+
+			"%d txns of block %v", numTxns, blockHash)
+	}
+
+	merkleRoot, _, err := ComputeMerkleRoot(blockTxns)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ReconstructBlock: Problem computing merkle root")
+	}
+	if msg.Header.TransactionMerkleRoot == nil ||
+		!bytes.Equal(merkleRoot[:], msg.Header.TransactionMerkleRoot[:]) {
+		return nil, fmt.Errorf("ReconstructBlock: Merkle root %v of the reconstructed block doesn't "+
+			"match the header's merkle root %v for block %v", merkleRoot,
+			msg.Header.TransactionMerkleRoot, blockHash)
+	}
+
+	return &MsgDeSoBlock{
+		Header:            msg.Header,
+		Txns:              blockTxns,
+		BlockProducerInfo: msg.BlockProducerInfo,
+	}, nil
+}
@@ -0,0 +1,151 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/stretchr/testify/require"
+)
+
+func _compactBlockTestBlock(t *testing.T, numTxns int) *MsgDeSoBlock {
+	require := require.New(t)
+
+	txns := []*MsgDeSoTxn{
+		{
+			TxnMeta: &BlockRewardMetadataa{
+				ExtraData: []byte{0x01, 0x02},
+			},
+		},
+	}
+	for ii := 1; ii < numTxns; ii++ {
+		publicKey := make([]byte, btcec.PubKeyBytesLenCompressed)
+		publicKey[0] = byte(ii)
+		txns = append(txns, &MsgDeSoTxn{
+			TxOutputs: []*DeSoOutput{
+				{
+					PublicKey:   publicKey,
+					AmountNanos: uint64(ii),
+				},
+			},
+			TxnMeta:   &BasicTransferMetadata{},
+			PublicKey: publicKey,
+		})
+	}
+
+	header := *expectedBlockHeader
+	merkleRoot, _, err := ComputeMerkleRoot(txns)
+	require.NoError(err)
+	header.TransactionMerkleRoot = merkleRoot
+	return &MsgDeSoBlock{
+		Header: &header,
+		Txns:   txns,
+	}
+}
+
+func TestCompactBlockSerialize(t *testing.T) {
+	require := require.New(t)
+
+	blk := _compactBlockTestBlock(t, 5)
+	blk.BlockProducerInfo = &BlockProducerInfo{
+		PublicKey: make([]byte, btcec.PubKeyBytesLenCompressed),
+	}
+	compactBlock, err := NewCompactBlock(blk, 12345, func(txHash *BlockHash) bool {
+		return *txHash != *blk.Txns[2].Hash()
+	})
+	require.NoError(err)
+	require.Equal(3, len(compactBlock.ShortTxIDs))
+	require.Equal(2, len(compactBlock.PrefilledTxns))
+	require.Equal(uint64(0), compactBlock.PrefilledTxns[0].Index)
+	require.Equal(uint64(2), compactBlock.PrefilledTxns[1].Index)
+
+	data, err := compactBlock.ToBytes(false)
+	require.NoError(err)
+	testCompactBlock := NewMessage(MsgTypeCompactBlock).(*MsgDeSoCompactBlock)
+	require.NoError(testCompactBlock.FromBytes(data))
+	testData, err := testCompactBlock.ToBytes(false)
+	require.NoError(err)
+	require.Equal(data, testData)
+	require.Equal(*compactBlock.Header, *testCompactBlock.Header)
+	require.Equal(compactBlock.ShortTxIDNonce, testCompactBlock.ShortTxIDNonce)
+	require.Equal(compactBlock.ShortTxIDs, testCompactBlock.ShortTxIDs)
+	require.Equal(compactBlock.BlockProducerInfo.PublicKey, testCompactBlock.BlockProducerInfo.PublicKey)
+
+	// Prefilled txns have to be sorted by index.
+	compactBlock.PrefilledTxns[0], compactBlock.PrefilledTxns[1] =
+		compactBlock.PrefilledTxns[1], compactBlock.PrefilledTxns[0]
+	data, err = compactBlock.ToBytes(false)
+	require.NoError(err)
+	require.Error(NewMessage(MsgTypeCompactBlock).FromBytes(data))
+
+	// GetCompactBlocks is encoded like GetBlocks.
+	getCompactBlocks := &MsgDeSoGetCompactBlocks{
+		HashList: []*BlockHash{blk.Txns[1].Hash(), blk.Txns[2].Hash()},
+	}
+	data, err = getCompactBlocks.ToBytes(false)
+	require.NoError(err)
+	testGetCompactBlocks := NewMessage(MsgTypeGetCompactBlocks).(*MsgDeSoGetCompactBlocks)
+	require.NoError(testGetCompactBlocks.FromBytes(data))
+	require.Equal(getCompactBlocks, testGetCompactBlocks)
+}
+
+func TestCompactBlockReconstruct(t *testing.T) {
+	require := require.New(t)
+
+	blk := _compactBlockTestBlock(t, 6)
+	compactBlock, err := NewCompactBlock(blk, 999, func(txHash *BlockHash) bool {
+		return true
+	})
+	require.NoError(err)
+	require.Equal(1, len(compactBlock.PrefilledTxns))
+	require.Equal(5, len(compactBlock.ShortTxIDs))
+
+	// The block is rebuilt in order no matter the order of the mempool txns, and unrelated txns
+	// are ignored.
+	otherTxns := _compactBlockTestBlock(t, 10).Txns[6:]
+	mempoolTxns := append([]*MsgDeSoTxn{}, otherTxns...)
+	for ii := len(blk.Txns) - 1; ii >= 1; ii-- {
+		mempoolTxns = append(mempoolTxns, blk.Txns[ii])
+	}
+	reconstructedBlock, err := compactBlock.ReconstructBlock(mempoolTxns)
+	require.NoError(err)
+	require.Equal(len(blk.Txns), len(reconstructedBlock.Txns))
+	for ii := range blk.Txns {
+		require.Equal(*blk.Txns[ii].Hash(), *reconstructedBlock.Txns[ii].Hash())
+	}
+	blockHash, err := blk.Hash()
+	require.NoError(err)
+	reconstructedBlockHash, err := reconstructedBlock.Hash()
+	require.NoError(err)
+	require.Equal(*blockHash, *reconstructedBlockHash)
+
+	// A txn missing from the mempool fails the reconstruction.
+	_, err = compactBlock.ReconstructBlock(mempoolTxns[:len(mempoolTxns)-1])
+	require.Error(err)
+	require.Contains(err.Error(), "Missing txn")
+
+	// So does a block whose txns don't match the merkle root.
+	badHeader := *compactBlock.Header
+	badHeader.TransactionMerkleRoot = &BlockHash{0x01}
+	badCompactBlock := *compactBlock
+	badCompactBlock.Header = &badHeader
+	badCompactBlock.ShortTxIDs = nil
+	badBlockHash, err := badHeader.Hash()
+	require.NoError(err)
+	key := ComputeCompactBlockShortTxIDKey(badBlockHash, compactBlock.ShortTxIDNonce)
+	for _, txn := range blk.Txns[1:] {
+		badCompactBlock.ShortTxIDs = append(badCompactBlock.ShortTxIDs,
+			ComputeCompactBlockShortTxID(key, txn.Hash()))
+	}
+	_, err = badCompactBlock.ReconstructBlock(mempoolTxns)
+	require.Error(err)
+	require.Contains(err.Error(), "Merkle root")
+
+	// Short IDs are salted with the nonce.
+	otherCompactBlock, err := NewCompactBlock(blk, 1000, func(txHash *BlockHash) bool {
+		return true
+	})
+	require.NoError(err)
+	require.NotEqual(compactBlock.ShortTxIDs, otherCompactBlock.ShortTxIDs)
+}
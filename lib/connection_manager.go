@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -236,6 +238,11 @@ func (cmgr *ConnectionManager) getRandomAddr() *wire.NetAddress {
 			continue
 		}
 
+		if cmgr._isBanned(addr.NetAddress().IP) {
+			glog.V(2).Infof("ConnectionManager.getRandomAddr: Not choosing banned address %v:%v", addr.NetAddress().IP, addr.NetAddress().Port)
+			continue
+		}
+
 		// We can only have one outbound address per /16. This is similar to
 		// Bitcoin and we do it to prevent Sybil attacks.
 		if cmgr.isRedundantGroupKey(addr.NetAddress()) {
@@ -333,6 +340,13 @@ func (cmgr *ConnectionManager) _getOutboundConn(persistentAddr *wire.NetAddress)
 			continue
 		}
 
+		// Addresses from the addrmgr were already checked for bans in getRandomAddr.
+		if isPersistent && cmgr._isBanned(ipNetAddr.IP) {
+			glog.V(1).Infof("_getOutboundConn: Not connecting to persistent peer (%s:%d) because it's banned",
+				ipNetAddr.IP.String(), ipNetAddr.Port)
+			continue
+		}
+
 		netAddr := net.TCPAddr{
 			IP:   ipNetAddr.IP,
 			Port: int(ipNetAddr.Port),
@@ -559,6 +573,23 @@ func (cmgr *ConnectionManager) _isFromRedundantInboundIPAddress(addrToCheck net.
 	return false
 }
 
+// _isBanned returns whether the IP is banned by the Server's PeerBanManager.
+func (cmgr *ConnectionManager) _isBanned(ip net.IP) bool {
+	if cmgr.srv == nil {
+		return false
+	}
+	return cmgr.srv.peerBanManager.IsBanned(ip)
+}
+
+// _isFromBannedIPAddress returns whether the address of an inbound connection is banned.
+func (cmgr *ConnectionManager) _isFromBannedIPAddress(addrToCheck net.Addr) bool {
+	host, _, err := net.SplitHostPort(addrToCheck.String())
+	if err != nil {
+		host = addrToCheck.String()
+	}
+	return cmgr._isBanned(net.ParseIP(host))
+}
+
 func (cmgr *ConnectionManager) _handleInboundConnections() {
 	for _, outerListener := range cmgr.listeners {
 		go func(ll net.Listener) {
@@ -595,6 +626,14 @@ func (cmgr *ConnectionManager) _handleInboundConnections() {
 					continue
 				}
 
+				if cmgr._isFromBannedIPAddress(conn.RemoteAddr()) {
+					glog.Infof("Rejecting INBOUND peer (%s) because its IP is banned.",
+						conn.RemoteAddr().String())
+					conn.Close()
+
+					continue
+				}
+
 				// If we want to limit inbound connections to one per IP address, check to
 				// make sure this address isn't already connected.
 				if cmgr.limitOneInboundConnectionPerIP &&
@@ -0,0 +1,308 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// The types below are the canonical form DumpConsensusState writes the state in. Keys and PKIDs are
+// printed as base58 public keys, hashes as hex and uint256 amounts as decimal strings, so that a diff
+// of two dumps is readable without any tooling.
+
+type consensusStateDump struct {
+	DeSoBalances        []*deSoBalanceDump
+	CreatorCoinBalances []*balanceEntryDump
+	DAOCoinBalances     []*balanceEntryDump
+	DAOCoinLimitOrders  []*daoCoinLimitOrderDump
+	NFTs                []*nftEntryDump
+	Profiles            []*profileEntryDump
+}
+
+type deSoBalanceDump struct {
+	PublicKey    string
+	BalanceNanos uint64
+}
+
+type balanceEntryDump struct {
+	HODLerPKID   string
+	CreatorPKID  string
+	BalanceNanos string
+	HasPurchased bool
+}
+
+type daoCoinLimitOrderDump struct {
+	OrderID                                   string
+	TransactorPKID                            string
+	BuyingDAOCoinCreatorPKID                  string
+	SellingDAOCoinCreatorPKID                 string
+	ScaledExchangeRateCoinsToSellPerCoinToBuy string
+	QuantityToFillInBaseUnits                 string
+	OperationType                             string
+	FillType                                  uint8
+	BlockHeight                               uint32
+}
+
+type nftEntryDump struct {
+	NFTPostHash                string
+	SerialNumber               uint64
+	OwnerPKID                  string
+	LastOwnerPKID              string
+	IsForSale                  bool
+	MinBidAmountNanos          uint64
+	UnlockableText             string
+	LastAcceptedBidAmountNanos uint64
+	IsPending                  bool
+	IsBuyNow                   bool
+	BuyNowPriceNanos           uint64
+	ExtraData                  map[string]string
+}
+
+type coinEntryDump struct {
+	CreatorBasisPoints        uint64
+	DeSoLockedNanos           uint64
+	NumberOfHolders           uint64
+	CoinsInCirculationNanos   string
+	CoinWatermarkNanos        uint64
+	MintingDisabled           bool
+	TransferRestrictionStatus string
+}
+
+type profileEntryDump struct {
+	PublicKey        string
+	Username         string
+	Description      string
+	ProfilePic       string
+	IsHidden         bool
+	CreatorCoinEntry *coinEntryDump
+	DAOCoinEntry     *coinEntryDump
+	ExtraData        map[string]string
+}
+
+// DumpConsensusState writes a canonical JSON dump of the balances, DAO coin limit orders, NFTs and
+// profiles in the view to w. The dump covers the union of the view and the db, with the view taking
+// precedence, and every list is sorted, so two views with the same state always produce the same
+// bytes. The view passed in isn't modified. This is meant for golden-file comparisons in tests and
+// loads the whole state into memory, so it shouldn't be used on mainnet-sized dbs.
+func DumpConsensusState(bav *UtxoView, w io.Writer) error {
+	if bav.Postgres != nil {
+		return errors.New("DumpConsensusState: Postgres is not supported")
+	}
+
+	// Load everything into a copy of the view, so that the entries we dump are the view's entries
+	// where it has them and the db's otherwise.
+	view, err := bav.CopyUtxoView()
+	if err != nil {
+		return errors.Wrapf(err, "DumpConsensusState: Problem copying view")
+	}
+
+	dump := &consensusStateDump{}
+	if dump.DeSoBalances, err = _dumpDeSoBalances(view); err != nil {
+		return errors.Wrapf(err, "DumpConsensusState: ")
+	}
+	dump.CreatorCoinBalances = _dumpBalanceEntries(view, false)
+	dump.DAOCoinBalances = _dumpBalanceEntries(view, true)
+	if dump.DAOCoinLimitOrders, err = _dumpDAOCoinLimitOrders(view); err != nil {
+		return errors.Wrapf(err, "DumpConsensusState: ")
+	}
+	dump.NFTs = _dumpNFTEntries(view)
+	if dump.Profiles, err = _dumpProfileEntries(view); err != nil {
+		return errors.Wrapf(err, "DumpConsensusState: ")
+	}
+
+	dumpBytes, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "DumpConsensusState: Problem encoding dump")
+	}
+	if _, err = w.Write(append(dumpBytes, '\n')); err != nil {
+		return errors.Wrapf(err, "DumpConsensusState: Problem writing dump")
+	}
+	return nil
+}
+
+func _dumpDeSoBalances(view *UtxoView) ([]*deSoBalanceDump, error) {
+	prefix := DbGetPrefixForPublicKeyToDesoBalanceNanos()
+	dbKeys, _ := EnumerateKeysForPrefix(view.Handle, prefix)
+	for _, dbKey := range dbKeys {
+		if _, err := view.GetDeSoBalanceNanosForPublicKey(dbKey[len(prefix):]); err != nil {
+			return nil, errors.Wrapf(err, "_dumpDeSoBalances: Problem getting balance")
+		}
+	}
+
+	// Zero balances are skipped since a public key with a zero balance is indistinguishable
+	// from one that was never seen.
+	balances := []*deSoBalanceDump{}
+	for publicKey, balanceNanos := range view.PublicKeyToDeSoBalanceNanos {
+		if balanceNanos == 0 {
+			continue
+		}
+		balances = append(balances, &deSoBalanceDump{
+			PublicKey:    PkToString(publicKey.ToBytes(), view.Params),
+			BalanceNanos: balanceNanos,
+		})
+	}
+	sort.Slice(balances, func(ii, jj int) bool {
+		return balances[ii].PublicKey < balances[jj].PublicKey
+	})
+	return balances, nil
+}
+
+func _dumpBalanceEntries(view *UtxoView, isDAOCoin bool) []*balanceEntryDump {
+	prefix := _dbGetPrefixForHODLerPKIDCreatorPKIDToBalanceEntry(isDAOCoin)
+	dbKeys, _ := EnumerateKeysForPrefix(view.Handle, prefix)
+	for _, dbKey := range dbKeys {
+		// <prefix, HODLerPKID, CreatorPKID>
+		hodlerPKID := NewPKID(dbKey[len(prefix) : len(prefix)+PublicKeyLenCompressed])
+		creatorPKID := NewPKID(dbKey[len(prefix)+PublicKeyLenCompressed:])
+		view._getBalanceEntryForHODLerPKIDAndCreatorPKID(hodlerPKID, creatorPKID, isDAOCoin)
+	}
+
+	balances := []*balanceEntryDump{}
+	for _, balanceEntry := range view.GetHODLerPKIDCreatorPKIDToBalanceEntryMap(isDAOCoin) {
+		if balanceEntry == nil || balanceEntry.isDeleted {
+			continue
+		}
+		balances = append(balances, &balanceEntryDump{
+			HODLerPKID:   PkToString(balanceEntry.HODLerPKID[:], view.Params),
+			CreatorPKID:  PkToString(balanceEntry.CreatorPKID[:], view.Params),
+			BalanceNanos: balanceEntry.BalanceNanos.ToBig().String(),
+			HasPurchased: balanceEntry.HasPurchased,
+		})
+	}
+	sort.Slice(balances, func(ii, jj int) bool {
+		if balances[ii].HODLerPKID != balances[jj].HODLerPKID {
+			return balances[ii].HODLerPKID < balances[jj].HODLerPKID
+		}
+		return balances[ii].CreatorPKID < balances[jj].CreatorPKID
+	})
+	return balances
+}
+
+func _dumpDAOCoinLimitOrders(view *UtxoView) ([]*daoCoinLimitOrderDump, error) {
+	orderEntries, err := view._getAllDAOCoinLimitOrders()
+	if err != nil {
+		return nil, errors.Wrapf(err, "_dumpDAOCoinLimitOrders: Problem getting orders")
+	}
+
+	orders := []*daoCoinLimitOrderDump{}
+	for _, orderEntry := range orderEntries {
+		orders = append(orders, &daoCoinLimitOrderDump{
+			OrderID:                   orderEntry.OrderID.String(),
+			TransactorPKID:            PkToString(orderEntry.TransactorPKID[:], view.Params),
+			BuyingDAOCoinCreatorPKID:  PkToString(orderEntry.BuyingDAOCoinCreatorPKID[:], view.Params),
+			SellingDAOCoinCreatorPKID: PkToString(orderEntry.SellingDAOCoinCreatorPKID[:], view.Params),
+			ScaledExchangeRateCoinsToSellPerCoinToBuy: orderEntry.ScaledExchangeRateCoinsToSellPerCoinToBuy.ToBig().String(),
+			QuantityToFillInBaseUnits:                 orderEntry.QuantityToFillInBaseUnits.ToBig().String(),
+			OperationType:                             orderEntry.OperationType.String(),
+			FillType:                                  uint8(orderEntry.FillType),
+			BlockHeight:                               orderEntry.BlockHeight,
+		})
+	}
+	sort.Slice(orders, func(ii, jj int) bool {
+		return orders[ii].OrderID < orders[jj].OrderID
+	})
+	return orders, nil
+}
+
+func _dumpNFTEntries(view *UtxoView) []*nftEntryDump {
+	prefix := Prefixes.PrefixPostHashSerialNumberToNFTEntry
+	dbKeys, _ := EnumerateKeysForPrefix(view.Handle, prefix)
+	for _, dbKey := range dbKeys {
+		// <prefix, NFTPostHash, SerialNumber uint64>
+		nftKey := MakeNFTKey(
+			NewBlockHash(dbKey[len(prefix):len(prefix)+HashSizeBytes]),
+			DecodeUint64(dbKey[len(prefix)+HashSizeBytes:]))
+		view.GetNFTEntryForNFTKey(&nftKey)
+	}
+
+	nfts := []*nftEntryDump{}
+	for _, nftEntry := range view.NFTKeyToNFTEntry {
+		if nftEntry == nil || nftEntry.isDeleted {
+			continue
+		}
+		nftDump := &nftEntryDump{
+			NFTPostHash:                nftEntry.NFTPostHash.String(),
+			SerialNumber:               nftEntry.SerialNumber,
+			OwnerPKID:                  PkToString(nftEntry.OwnerPKID[:], view.Params),
+			IsForSale:                  nftEntry.IsForSale,
+			MinBidAmountNanos:          nftEntry.MinBidAmountNanos,
+			UnlockableText:             string(nftEntry.UnlockableText),
+			LastAcceptedBidAmountNanos: nftEntry.LastAcceptedBidAmountNanos,
+			IsPending:                  nftEntry.IsPending,
+			IsBuyNow:                   nftEntry.IsBuyNow,
+			BuyNowPriceNanos:           nftEntry.BuyNowPriceNanos,
+			ExtraData:                  _dumpExtraData(nftEntry.ExtraData),
+		}
+		if nftEntry.LastOwnerPKID != nil {
+			nftDump.LastOwnerPKID = PkToString(nftEntry.LastOwnerPKID[:], view.Params)
+		}
+		nfts = append(nfts, nftDump)
+	}
+	sort.Slice(nfts, func(ii, jj int) bool {
+		if nfts[ii].NFTPostHash != nfts[jj].NFTPostHash {
+			return nfts[ii].NFTPostHash < nfts[jj].NFTPostHash
+		}
+		return nfts[ii].SerialNumber < nfts[jj].SerialNumber
+	})
+	return nfts
+}
+
+func _dumpProfileEntries(view *UtxoView) ([]*profileEntryDump, error) {
+	_, _, dbProfileEntries, err := DBGetAllProfilesByCoinValue(view.Handle, view.Snapshot, true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "_dumpProfileEntries: Problem getting profiles")
+	}
+	for _, dbProfileEntry := range dbProfileEntries {
+		view.GetProfileEntryForPublicKey(dbProfileEntry.PublicKey)
+	}
+
+	profiles := []*profileEntryDump{}
+	for _, profileEntry := range view.ProfilePKIDToProfileEntry {
+		if profileEntry == nil || profileEntry.isDeleted {
+			continue
+		}
+		profiles = append(profiles, &profileEntryDump{
+			PublicKey:        PkToString(profileEntry.PublicKey, view.Params),
+			Username:         string(profileEntry.Username),
+			Description:      string(profileEntry.Description),
+			ProfilePic:       string(profileEntry.ProfilePic),
+			IsHidden:         profileEntry.IsHidden,
+			CreatorCoinEntry: _dumpCoinEntry(&profileEntry.CreatorCoinEntry),
+			DAOCoinEntry:     _dumpCoinEntry(&profileEntry.DAOCoinEntry),
+			ExtraData:        _dumpExtraData(profileEntry.ExtraData),
+		})
+	}
+	sort.Slice(profiles, func(ii, jj int) bool {
+		return profiles[ii].PublicKey < profiles[jj].PublicKey
+	})
+	return profiles, nil
+}
+
+func _dumpCoinEntry(coinEntry *CoinEntry) *coinEntryDump {
+	return &coinEntryDump{
+		CreatorBasisPoints:        coinEntry.CreatorBasisPoints,
+		DeSoLockedNanos:           coinEntry.DeSoLockedNanos,
+		NumberOfHolders:           coinEntry.NumberOfHolders,
+		CoinsInCirculationNanos:   coinEntry.CoinsInCirculationNanos.ToBig().String(),
+		CoinWatermarkNanos:        coinEntry.CoinWatermarkNanos,
+		MintingDisabled:           coinEntry.MintingDisabled,
+		TransferRestrictionStatus: coinEntry.TransferRestrictionStatus.String(),
+	}
+}
+
+// _dumpExtraData hex-encodes the values. The json encoder sorts the keys.
+func _dumpExtraData(extraData map[string][]byte) map[string]string {
+	if len(extraData) == 0 {
+		return nil
+	}
+	extraDataDump := make(map[string]string)
+	for key, value := range extraData {
+		extraDataDump[key] = hex.EncodeToString(value)
+	}
+	return extraDataDump
+}
@@ -0,0 +1,95 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/require"
+)
+
+// AssertStateEqual compares the consensus state of the view, as dumped by DumpConsensusState, to the
+// golden file at expectedFile. Run the tests with UPDATE_GOLDEN_FILES set to (re)write the golden
+// files instead, and review the diff before committing them.
+func AssertStateEqual(t *testing.T, view *UtxoView, expectedFile string) {
+	require := require.New(t)
+
+	var dump bytes.Buffer
+	require.NoError(DumpConsensusState(view, &dump))
+
+	if len(os.Getenv("UPDATE_GOLDEN_FILES")) > 0 {
+		require.NoError(os.MkdirAll(filepath.Dir(expectedFile), os.ModePerm))
+		require.NoError(ioutil.WriteFile(expectedFile, dump.Bytes(), 0644))
+		return
+	}
+
+	expectedDump, err := ioutil.ReadFile(expectedFile)
+	require.NoError(err, "Problem reading %v, run with UPDATE_GOLDEN_FILES=1 to create it", expectedFile)
+	// Comparing strings makes testify print a readable diff.
+	require.Equal(string(expectedDump), dump.String(), "State doesn't match %v", expectedFile)
+}
+
+func TestDumpConsensusState(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	m0PkBytes, _, err := Base58CheckDecode(m0Pub)
+	require.NoError(err)
+	m1PkBytes, _, err := Base58CheckDecode(m1Pub)
+	require.NoError(err)
+	m0PKID := PublicKeyToPKID(m0PkBytes)
+	m1PKID := PublicKeyToPKID(m1PkBytes)
+
+	dumpView := func(view *UtxoView) string {
+		var dump bytes.Buffer
+		require.NoError(DumpConsensusState(view, &dump))
+		return dump.String()
+	}
+
+	view, err := NewUtxoView(db, params, nil, chain.snapshot)
+	require.NoError(err)
+	emptyDump := dumpView(view)
+
+	// Entries that only exist in the view are dumped.
+	view._setBalanceEntryMappingsWithPKIDs(&BalanceEntry{
+		HODLerPKID:   m0PKID,
+		CreatorPKID:  m1PKID,
+		BalanceNanos: *uint256.NewInt().SetUint64(1000),
+	}, m0PKID, m1PKID, true)
+	view._setBalanceEntryMappingsWithPKIDs(&BalanceEntry{
+		HODLerPKID:   m1PKID,
+		CreatorPKID:  m1PKID,
+		BalanceNanos: *uint256.NewInt().SetUint64(2000),
+		HasPurchased: true,
+	}, m1PKID, m1PKID, true)
+	numBalanceEntries := len(view.HODLerPKIDCreatorPKIDToDAOCoinBalanceEntry)
+	viewDump := dumpView(view)
+	require.NotEqual(emptyDump, viewDump)
+	require.Contains(viewDump, "2000")
+
+	// The dump is canonical, so dumping again gives the same bytes regardless of map order,
+	// and dumping doesn't add anything to the view.
+	require.Equal(viewDump, dumpView(view))
+	require.Equal(numBalanceEntries, len(view.HODLerPKIDCreatorPKIDToDAOCoinBalanceEntry))
+
+	// Once flushed, a fresh view reads the same state from the db.
+	require.NoError(view.FlushToDb(0))
+	freshView, err := NewUtxoView(db, params, nil, chain.snapshot)
+	require.NoError(err)
+	require.Equal(viewDump, dumpView(freshView))
+
+	// Deleted view entries shadow the db.
+	freshView._deleteBalanceEntryMappingsWithPKIDs(
+		freshView._getBalanceEntryForHODLerPKIDAndCreatorPKID(m1PKID, m1PKID, true), m1PKID, m1PKID, true)
+	require.NotContains(dumpView(freshView), "2000")
+
+	// Golden files round trip.
+	goldenFile := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(ioutil.WriteFile(goldenFile, []byte(viewDump), 0644))
+	AssertStateEqual(t, view, goldenFile)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"log"
+	"math"
 	"math/big"
 	"os"
 	"path/filepath"
@@ -38,6 +39,73 @@ const (
 	// MessagesToFetchPerCall is used to limit the number of messages to fetch
 	// when getting a user's inbox.
 	MessagesToFetchPerInboxCall = 10000
+
+	// DAOCoinLimitOrderMatchingPageSize is the number of orders the matcher pulls from
+	// the merged order book at a time when looking for orders to fill.
+	DAOCoinLimitOrderMatchingPageSize = 100
+
+	// IndexQueueBatchSize is the number of tasks the IndexQueue reads from the db at a time,
+	// and IndexQueuePollInterval is how long it waits for new tasks once the queue is empty.
+	IndexQueueBatchSize    = 100
+	IndexQueuePollInterval = 1 * time.Second
+
+	// MaxHashtagLengthBytes is the longest hashtag the hashtag index picks up from a post body, and
+	// MaxPostHashtagsAndMentions caps the number of hashtags, and of mentions, a post is indexed under.
+	MaxHashtagLengthBytes      = 100
+	MaxPostHashtagsAndMentions = 20
+
+	// MinSearchTermLengthBytes and MaxSearchTermLengthBytes bound the terms the search index picks up
+	// from posts and profiles, and MaxSearchTermsPerDocument caps the number of terms a post or
+	// profile is indexed under. MaxSearchScanKeys caps the number of index keys a search reads, and
+	// SearchIndexRebuildBatchSize is the number of posts or profiles indexed per badger txn when
+	// rebuilding the search index.
+	MinSearchTermLengthBytes    = 2
+	MaxSearchTermLengthBytes    = 40
+	MaxSearchTermsPerDocument   = 200
+	MaxSearchScanKeys           = 100000
+	SearchIndexRebuildBatchSize = 100
+
+	// DefaultHotnessFeedHalfLife is how long it takes by default for a post's hotness to halve, and
+	// HotnessFeedRebuildBatchSize is the number of posts scored per badger txn when rebuilding the
+	// hotness feed.
+	DefaultHotnessFeedHalfLife  = 12 * time.Hour
+	HotnessFeedRebuildBatchSize = 100
+
+	// BlockSubscriptionPollInterval is how often a BlockSubscription checks the main chain index for
+	// blocks it wasn't notified about, e.g. because the notification came before the txn committed.
+	BlockSubscriptionPollInterval = 1 * time.Second
+
+	// DAOCoinPairVolumeBucketDuration is the granularity of the DAO coin pair volume statistics,
+	// and DAOCoinPairVolumeRetention is how long buckets are kept. The retention has to cover the
+	// longest DAOCoinPairVolumeWindow.
+	DAOCoinPairVolumeBucketDuration = 1 * time.Hour
+	DAOCoinPairVolumeRetention      = 8 * 24 * time.Hour
+
+	// BitcoinHeaderBatchSize is the max number of Bitcoin headers the BitcoinHeaderManager asks its
+	// source for at a time, which is the max a Bitcoin peer sends in a headers message.
+	// BitcoinHeaderPollInterval is how long it waits before asking again once it's caught up, and
+	// BitcoinHeaderRetryInterval is how long it waits after the source fails.
+	BitcoinHeaderBatchSize     = 2000
+	BitcoinHeaderPollInterval  = 30 * time.Second
+	BitcoinHeaderRetryInterval = 10 * time.Second
+
+	// PeerMisbehaviorHalfLife is how long it takes for a peer's misbehavior score to halve, so that
+	// a peer that misbehaves once in a while is never banned for it.
+	PeerMisbehaviorHalfLife = 10 * time.Minute
+	// PeerInvRateLimitBurst is the max number of inv items a peer can send us at once under the
+	// inv rate limit. It's big enough for the inv of a full mempool a peer sends us after we connect.
+	PeerInvRateLimitBurst = 100000
+
+	// CompactBlocksProtocolVersion is the first protocol version in which peers can exchange
+	// compact blocks. See compact_blocks.go.
+	CompactBlocksProtocolVersion uint64 = 2
+
+	// BlockDownloadWindow is how far past the block tip we download blocks while syncing, which
+	// bounds the number of blocks that are staged in memory. See BlockDownloader.
+	BlockDownloadWindow uint32 = 1024
+	// BlockDownloadStallTimeout is how long a peer can hold up the block download window before
+	// it's disconnected.
+	BlockDownloadStallTimeout = 10 * time.Second
 )
 
 type NodeMessage uint32
@@ -52,6 +120,14 @@ const (
 	// GetSnapshotTimeout is used in Peer when we fetch a snapshot chunk, and we need to retry.
 	GetSnapshotTimeout = 100 * time.Millisecond
 
+	// RepairPrefixMaxConcurrencyFaults is how many times RepairPrefixFromPeer retries a chunk the peer
+	// couldn't serve because it was flushing, waiting RepairPrefixConcurrencyFaultRetryInterval in between.
+	RepairPrefixMaxConcurrencyFaults          = 100
+	RepairPrefixConcurrencyFaultRetryInterval = 100 * time.Millisecond
+
+	// RepairPrefixBatchSize is the number of records RepairPrefixFromPeer writes or deletes per badger txn.
+	RepairPrefixBatchSize = 10000
+
 	// SnapshotBlockHeightPeriod is the constant height offset between individual snapshot epochs.
 	SnapshotBlockHeightPeriod uint64 = 1000
 
@@ -61,9 +137,63 @@ const (
 	// DatabaseCacheSize is used to save read operations when fetching records from the main Db.
 	DatabaseCacheSize uint = 1000000 // 1M
 
+	// DatabaseCacheMinSize and DatabaseCacheMaxSize bound the adaptive DatabaseCache sizing.
+	DatabaseCacheMinSize uint = 100000  // 100K
+	DatabaseCacheMaxSize uint = 8000000 // 8M
+
+	// DatabaseCacheResizeBlockInterval is how often, in blocks, we reconsider the DatabaseCache size.
+	DatabaseCacheResizeBlockInterval uint64 = 100
+
+	// NegativeLookupCacheSize is the number of missing keys we remember, for NegativeLookupCacheTTL at most.
+	NegativeLookupCacheSize uint = 100000 // 100K
+	NegativeLookupCacheTTL       = 30 * time.Second
+
+	// ReadGenerationRetentionCount is the number of read generations we keep around for paginated reads,
+	// and ReadGenerationMaxAge is the longest we keep any of them.
+	ReadGenerationRetentionCount = 8
+	ReadGenerationMaxAge         = 10 * time.Minute
+
+	// ValidationFailureLogCapacity is the number of block and txn rejections kept in the db. Once
+	// the log is full, each new rejection overwrites the oldest one.
+	ValidationFailureLogCapacity = 10000
+
+	// NonceMigrationBatchSize is the number of nonces written per badger txn when initializing nonces.
+	NonceMigrationBatchSize = 10000
+
+	// MainChainIndexBatchSize is the number of heights written per badger txn when rebuilding the main
+	// chain index.
+	MainChainIndexBatchSize = 10000
+
+	// UtxoAggregateMigrationBatchSize is the number of public keys written per badger txn when
+	// backfilling the utxo aggregates.
+	UtxoAggregateMigrationBatchSize = 10000
+
+	// HolderLeaderboardMigrationBatchSize is the number of keys written per badger txn when
+	// backfilling the coin holder leaderboards.
+	HolderLeaderboardMigrationBatchSize = 10000
+
+	// PostCountMigrationBatchSize is the number of keys written per badger txn when backfilling the
+	// post counts.
+	PostCountMigrationBatchSize = 10000
+
+	// DiamondAggregateMigrationBatchSize is the number of aggregates written per badger txn when
+	// backfilling the diamond aggregates.
+	DiamondAggregateMigrationBatchSize = 10000
+
+	// NFTCollectionSummaryMigrationBatchSize is the number of summaries written per badger txn when
+	// backfilling the NFT collection summaries.
+	NFTCollectionSummaryMigrationBatchSize = 10000
+
 	// HashToCurveCache is used to save computation on hashing to curve.
 	HashToCurveCache uint = 10000 // 10K
 
+	// ParsedPubKeyCacheSize is the number of parsed public keys kept by ParsePubKeyCached.
+	ParsedPubKeyCacheSize uint = 100000 // 100K
+
+	// ChainStateExportChunkSize is the approximate size in bytes of the checksummed chunks
+	// ExportChainState writes. ImportChainState writes each chunk in its own badger txn.
+	ChainStateExportChunkSize = 1 << 20 // 1MB
+
 	// MetadataRetryCount is used to retry updating data in badger just in case.
 	MetadataRetryCount int = 5
 
@@ -134,6 +264,14 @@ func (nt NetworkType) String() string {
 
 const (
 	MaxUsernameLengthBytes = 25
+
+	// The maximum lengths of a post association's AssociationType and AssociationValue.
+	MaxPostAssociationTypeLengthBytes  = 64
+	MaxPostAssociationValueLengthBytes = 256
+
+	// The maximum lengths of a user association's AssociationType and AssociationValue.
+	MaxUserAssociationTypeLengthBytes  = 64
+	MaxUserAssociationValueLengthBytes = 256
 )
 
 var (
@@ -252,6 +390,56 @@ type ForkHeights struct {
 	// we introduce derived keys without a spending limit.
 	DeSoUnlimitedDerivedKeysBlockHeight uint32
 
+	// DAOCoinLimitOrderCancelAllAndReplaceBlockHeight defines the height at which
+	// DAO coin limit orders can cancel all of the transactor's orders for a pair, or
+	// replace an existing order with new terms.
+	DAOCoinLimitOrderCancelAllAndReplaceBlockHeight uint32
+
+	// DAOCoinLimitOrderMinSizeAndTickBlockHeight defines the height at which the param
+	// updater can set a minimum DAO coin limit order quantity and an exchange rate tick size.
+	DAOCoinLimitOrderMinSizeAndTickBlockHeight uint32
+
+	// DAOCoinLimitOrderMakerTakerFeesBlockHeight defines the height at which the param
+	// updater can set maker and taker fees that are charged when DAO coin limit orders match.
+	DAOCoinLimitOrderMakerTakerFeesBlockHeight uint32
+
+	// DAOCoinLimitOrderMergedOrderBookBlockHeight defines the height at which the matcher
+	// walks the merged DB and UtxoView order book page by page instead of pulling every
+	// matching order into the view.
+	DAOCoinLimitOrderMergedOrderBookBlockHeight uint32
+
+	// DerivedKeyTxnTypeScopingBlockHeight defines the height at which derived key spending limits
+	// can restrict the key to a set of txn types and set a DESO limit per txn type.
+	DerivedKeyTxnTypeScopingBlockHeight uint32
+
+	// DAOCoinLimitOrderMarketOrderSlippageBlockHeight defines the height at which market
+	// DAO coin limit orders can bound how far their fills stray from the best price.
+	DAOCoinLimitOrderMarketOrderSlippageBlockHeight uint32
+
+	// DAOCoinLimitOrderTriggerBlockHeight defines the height at which DAO coin limit orders
+	// can be placed as trigger orders that stay dormant until the pair trades at their trigger.
+	DAOCoinLimitOrderTriggerBlockHeight uint32
+
+	// DAOCoinLimitOrderExpirationBlockHeight defines the height at which DAO coin limit orders
+	// can expire at a block height, and expired orders are pruned when blocks connect.
+	DAOCoinLimitOrderExpirationBlockHeight uint32
+
+	// PostAssociationBlockHeight defines the height at which users can create and delete
+	// associations with posts, which generalize likes to arbitrary reactions.
+	PostAssociationBlockHeight uint32
+
+	// UserAssociationBlockHeight defines the height at which users can create and delete
+	// associations with other users, e.g. endorsements.
+	UserAssociationBlockHeight uint32
+
+	// NFTOwnershipSharesBlockHeight defines the height at which NFTs can be minted with
+	// ownership shares that split the proceeds of their sale between several owners.
+	NFTOwnershipSharesBlockHeight uint32
+
+	// TxnTypeGlobalParamsBlockHeight defines the height at which the param updater can set a
+	// minimum network fee per txn type and disable txn types.
+	TxnTypeGlobalParamsBlockHeight uint32
+
 	// Be sure to update EncoderMigrationHeights as well via
 	// GetEncoderMigrationHeights if you're modifying schema.
 }
@@ -312,8 +500,17 @@ type MigrationHeight struct {
 }
 
 const (
-	DefaultMigration              MigrationName = "DefaultMigration"
-	UnlimitedDerivedKeysMigration MigrationName = "UnlimitedDerivedKeysMigration"
+	DefaultMigration                         MigrationName = "DefaultMigration"
+	UnlimitedDerivedKeysMigration            MigrationName = "UnlimitedDerivedKeysMigration"
+	DAOCoinLimitOrderMinSizeAndTickMigration MigrationName = "DAOCoinLimitOrderMinSizeAndTickMigration"
+	DAOCoinLimitOrderMakerTakerFeesMigration MigrationName = "DAOCoinLimitOrderMakerTakerFeesMigration"
+	DerivedKeyTxnTypeScopingMigration        MigrationName = "DerivedKeyTxnTypeScopingMigration"
+	DAOCoinLimitOrderTriggerMigration        MigrationName = "DAOCoinLimitOrderTriggerMigration"
+	DAOCoinLimitOrderExpirationMigration     MigrationName = "DAOCoinLimitOrderExpirationMigration"
+	PostAssociationMigration                 MigrationName = "PostAssociationMigration"
+	UserAssociationMigration                 MigrationName = "UserAssociationMigration"
+	NFTOwnershipSharesMigration              MigrationName = "NFTOwnershipSharesMigration"
+	TxnTypeGlobalParamsMigration             MigrationName = "TxnTypeGlobalParamsMigration"
 )
 
 type EncoderMigrationHeights struct {
@@ -321,6 +518,33 @@ type EncoderMigrationHeights struct {
 
 	// DeSoUnlimitedDerivedKeys coincides with the DeSoUnlimitedDerivedKeysBlockHeight block
 	DeSoUnlimitedDerivedKeys MigrationHeight
+
+	// DAOCoinLimitOrderMinSizeAndTick coincides with the DAOCoinLimitOrderMinSizeAndTickBlockHeight block
+	DAOCoinLimitOrderMinSizeAndTick MigrationHeight
+
+	// DAOCoinLimitOrderMakerTakerFees coincides with the DAOCoinLimitOrderMakerTakerFeesBlockHeight block
+	DAOCoinLimitOrderMakerTakerFees MigrationHeight
+
+	// DerivedKeyTxnTypeScoping coincides with the DerivedKeyTxnTypeScopingBlockHeight block
+	DerivedKeyTxnTypeScoping MigrationHeight
+
+	// DAOCoinLimitOrderTrigger coincides with the DAOCoinLimitOrderTriggerBlockHeight block
+	DAOCoinLimitOrderTrigger MigrationHeight
+
+	// DAOCoinLimitOrderExpiration coincides with the DAOCoinLimitOrderExpirationBlockHeight block
+	DAOCoinLimitOrderExpiration MigrationHeight
+
+	// PostAssociation coincides with the PostAssociationBlockHeight block
+	PostAssociation MigrationHeight
+
+	// UserAssociation coincides with the UserAssociationBlockHeight block
+	UserAssociation MigrationHeight
+
+	// NFTOwnershipShares coincides with the NFTOwnershipSharesBlockHeight block
+	NFTOwnershipShares MigrationHeight
+
+	// TxnTypeGlobalParams coincides with the TxnTypeGlobalParamsBlockHeight block
+	TxnTypeGlobalParams MigrationHeight
 }
 
 func GetEncoderMigrationHeights(forkHeights *ForkHeights) *EncoderMigrationHeights {
@@ -335,6 +559,51 @@ func GetEncoderMigrationHeights(forkHeights *ForkHeights) *EncoderMigrationHeigh
 			Height:  uint64(forkHeights.DeSoUnlimitedDerivedKeysBlockHeight),
 			Name:    UnlimitedDerivedKeysMigration,
 		},
+		DAOCoinLimitOrderMinSizeAndTick: MigrationHeight{
+			Version: 2,
+			Height:  uint64(forkHeights.DAOCoinLimitOrderMinSizeAndTickBlockHeight),
+			Name:    DAOCoinLimitOrderMinSizeAndTickMigration,
+		},
+		DAOCoinLimitOrderMakerTakerFees: MigrationHeight{
+			Version: 3,
+			Height:  uint64(forkHeights.DAOCoinLimitOrderMakerTakerFeesBlockHeight),
+			Name:    DAOCoinLimitOrderMakerTakerFeesMigration,
+		},
+		DerivedKeyTxnTypeScoping: MigrationHeight{
+			Version: 4,
+			Height:  uint64(forkHeights.DerivedKeyTxnTypeScopingBlockHeight),
+			Name:    DerivedKeyTxnTypeScopingMigration,
+		},
+		DAOCoinLimitOrderTrigger: MigrationHeight{
+			Version: 5,
+			Height:  uint64(forkHeights.DAOCoinLimitOrderTriggerBlockHeight),
+			Name:    DAOCoinLimitOrderTriggerMigration,
+		},
+		DAOCoinLimitOrderExpiration: MigrationHeight{
+			Version: 6,
+			Height:  uint64(forkHeights.DAOCoinLimitOrderExpirationBlockHeight),
+			Name:    DAOCoinLimitOrderExpirationMigration,
+		},
+		PostAssociation: MigrationHeight{
+			Version: 7,
+			Height:  uint64(forkHeights.PostAssociationBlockHeight),
+			Name:    PostAssociationMigration,
+		},
+		UserAssociation: MigrationHeight{
+			Version: 8,
+			Height:  uint64(forkHeights.UserAssociationBlockHeight),
+			Name:    UserAssociationMigration,
+		},
+		NFTOwnershipShares: MigrationHeight{
+			Version: 9,
+			Height:  uint64(forkHeights.NFTOwnershipSharesBlockHeight),
+			Name:    NFTOwnershipSharesMigration,
+		},
+		TxnTypeGlobalParams: MigrationHeight{
+			Version: 10,
+			Height:  uint64(forkHeights.TxnTypeGlobalParamsBlockHeight),
+			Name:    TxnTypeGlobalParamsMigration,
+		},
 	}
 }
 func GetEncoderMigrationHeightsList(forkHeights *ForkHeights) (
@@ -550,6 +819,12 @@ type DeSoParams struct {
 
 	EncoderMigrationHeights     *EncoderMigrationHeights
 	EncoderMigrationHeightsList []*MigrationHeight
+
+	// StateChecksumCheckpoints maps block heights to the state checksums that are known to be
+	// correct at those heights. When we HyperSync from a snapshot taken at one of these heights,
+	// peers whose snapshot checksum doesn't match the checkpoint are disconnected. Snapshots at
+	// other heights aren't checked.
+	StateChecksumCheckpoints map[uint64][]byte
 }
 
 var RegtestForkHeights = ForkHeights{
@@ -574,6 +849,18 @@ var RegtestForkHeights = ForkHeights{
 	OrderBookDBFetchOptimizationBlockHeight:              uint32(0),
 	ParamUpdaterRefactorBlockHeight:                      uint32(0),
 	DeSoUnlimitedDerivedKeysBlockHeight:                  uint32(0),
+	DAOCoinLimitOrderCancelAllAndReplaceBlockHeight:      uint32(0),
+	DAOCoinLimitOrderMinSizeAndTickBlockHeight:           uint32(0),
+	DAOCoinLimitOrderMakerTakerFeesBlockHeight:           uint32(0),
+	DAOCoinLimitOrderMergedOrderBookBlockHeight:          uint32(0),
+	DerivedKeyTxnTypeScopingBlockHeight:                  uint32(0),
+	DAOCoinLimitOrderMarketOrderSlippageBlockHeight:      uint32(0),
+	DAOCoinLimitOrderTriggerBlockHeight:                  uint32(0),
+	DAOCoinLimitOrderExpirationBlockHeight:               uint32(0),
+	PostAssociationBlockHeight:                           uint32(0),
+	UserAssociationBlockHeight:                           uint32(0),
+	NFTOwnershipSharesBlockHeight:                        uint32(0),
+	TxnTypeGlobalParamsBlockHeight:                       uint32(0),
 
 	// Be sure to update EncoderMigrationHeights as well via
 	// GetEncoderMigrationHeights if you're modifying schema.
@@ -717,6 +1004,20 @@ var MainnetForkHeights = ForkHeights{
 	// Mon Sept 19 @ 12pm PST
 	DeSoUnlimitedDerivedKeysBlockHeight: uint32(166066),
 
+	// Not scheduled yet.
+	DAOCoinLimitOrderCancelAllAndReplaceBlockHeight: uint32(math.MaxUint32),
+	DAOCoinLimitOrderMinSizeAndTickBlockHeight:      uint32(math.MaxUint32),
+	DAOCoinLimitOrderMakerTakerFeesBlockHeight:      uint32(math.MaxUint32),
+	DAOCoinLimitOrderMergedOrderBookBlockHeight:     uint32(math.MaxUint32),
+	DerivedKeyTxnTypeScopingBlockHeight:             uint32(math.MaxUint32),
+	DAOCoinLimitOrderMarketOrderSlippageBlockHeight: uint32(math.MaxUint32),
+	DAOCoinLimitOrderTriggerBlockHeight:             uint32(math.MaxUint32),
+	DAOCoinLimitOrderExpirationBlockHeight:          uint32(math.MaxUint32),
+	PostAssociationBlockHeight:                      uint32(math.MaxUint32),
+	UserAssociationBlockHeight:                      uint32(math.MaxUint32),
+	NFTOwnershipSharesBlockHeight:                   uint32(math.MaxUint32),
+	TxnTypeGlobalParamsBlockHeight:                  uint32(math.MaxUint32),
+
 	// Be sure to update EncoderMigrationHeights as well via
 	// GetEncoderMigrationHeights if you're modifying schema.
 }
@@ -724,7 +1025,7 @@ var MainnetForkHeights = ForkHeights{
 // DeSoMainnetParams defines the DeSo parameters for the mainnet.
 var DeSoMainnetParams = DeSoParams{
 	NetworkType:        NetworkType_MAINNET,
-	ProtocolVersion:    1,
+	ProtocolVersion:    CompactBlocksProtocolVersion,
 	MinProtocolVersion: 1,
 	UserAgent:          "Architect",
 	DNSSeeds: []string{
@@ -967,6 +1268,20 @@ var TestnetForkHeights = ForkHeights{
 	// Tues Sept 13 @ 10am PT
 	DeSoUnlimitedDerivedKeysBlockHeight: uint32(467217),
 
+	// Not scheduled yet.
+	DAOCoinLimitOrderCancelAllAndReplaceBlockHeight: uint32(math.MaxUint32),
+	DAOCoinLimitOrderMinSizeAndTickBlockHeight:      uint32(math.MaxUint32),
+	DAOCoinLimitOrderMakerTakerFeesBlockHeight:      uint32(math.MaxUint32),
+	DAOCoinLimitOrderMergedOrderBookBlockHeight:     uint32(math.MaxUint32),
+	DerivedKeyTxnTypeScopingBlockHeight:             uint32(math.MaxUint32),
+	DAOCoinLimitOrderMarketOrderSlippageBlockHeight: uint32(math.MaxUint32),
+	DAOCoinLimitOrderTriggerBlockHeight:             uint32(math.MaxUint32),
+	DAOCoinLimitOrderExpirationBlockHeight:          uint32(math.MaxUint32),
+	PostAssociationBlockHeight:                      uint32(math.MaxUint32),
+	UserAssociationBlockHeight:                      uint32(math.MaxUint32),
+	NFTOwnershipSharesBlockHeight:                   uint32(math.MaxUint32),
+	TxnTypeGlobalParamsBlockHeight:                  uint32(math.MaxUint32),
+
 	// Be sure to update EncoderMigrationHeights as well via
 	// GetEncoderMigrationHeights if you're modifying schema.
 }
@@ -974,7 +1289,7 @@ var TestnetForkHeights = ForkHeights{
 // DeSoTestnetParams defines the DeSo parameters for the testnet.
 var DeSoTestnetParams = DeSoParams{
 	NetworkType:        NetworkType_TESTNET,
-	ProtocolVersion:    0,
+	ProtocolVersion:    CompactBlocksProtocolVersion,
 	MinProtocolVersion: 0,
 	UserAgent:          "Architect",
 	DNSSeeds: []string{
@@ -1141,6 +1456,9 @@ const (
 	RepostedPostHash = "RecloutedPostHash"
 	// Key in transaction's extra map -- The presence of this key indicates that this post is a repost with a quote.
 	IsQuotedRepostKey = "IsQuotedReclout"
+	// Key in a post's extra data map. If present, the post is pinned to its poster's profile, and the value is
+	// the uvarint position of the post among the poster's pinned posts.
+	PinnedPostOrderKey = "PinnedPostOrder"
 
 	// Keys for a GlobalParamUpdate transaction's extra data map.
 	USDCentsPerBitcoinKey            = "USDCentsPerBitcoin"
@@ -1149,6 +1467,20 @@ const (
 	CreateNFTFeeNanosKey             = "CreateNFTFeeNanos"
 	MaxCopiesPerNFTKey               = "MaxCopiesPerNFT"
 	ForbiddenBlockSignaturePubKeyKey = "ForbiddenBlockSignaturePubKey"
+	// The values of these two keys are big-endian uint256s.
+	MinDAOCoinLimitOrderQuantityKey      = "MinDAOCoinLimitOrderQuantityInBaseUnits"
+	DAOCoinLimitOrderExchangeRateTickKey = "DAOCoinLimitOrderScaledExchangeRateTick"
+	// The fee keys are uvarint basis points, and the fee destination key is a public key.
+	DAOCoinLimitOrderMakerFeeBasisPointsKey     = "DAOCoinLimitOrderMakerFeeBasisPoints"
+	DAOCoinLimitOrderTakerFeeBasisPointsKey     = "DAOCoinLimitOrderTakerFeeBasisPoints"
+	DAOCoinLimitOrderFeeDestinationPublicKeyKey = "DAOCoinLimitOrderFeeDestinationPublicKey"
+	// The value of this key maps txn types to their minimum network fee per KB, and is encoded
+	// with SerializeTxnTypeToUint64Map. A zero fee removes the txn type's minimum, after which
+	// it pays MinNetworkFeeNanosPerKB like the rest.
+	MinNetworkFeeNanosPerKBByTxnTypeKey = "MinNetworkFeeNanosPerKBByTxnType"
+	// The value of this key maps txn types to 1 to disable them or 0 to enable them again, and
+	// is encoded the same way.
+	DisabledTxnTypesKey = "DisabledTxnTypes"
 
 	DiamondLevelKey    = "DiamondLevel"
 	DiamondPostHashKey = "DiamondPostHash"
@@ -1174,6 +1506,11 @@ const (
 	// the amount of royalties that should be added to pkid's creator coin upon sale of this NFT.
 	CoinRoyaltiesMapKey = "CoinRoyaltiesMap"
 
+	// Key in transaction's extra data map. If present, the value represents a map of pkid to basis points representing
+	// the share of the NFT that each pkid owns. The shares must add up to 100%, and the proceeds of the NFT's sale are
+	// split between the owners according to them.
+	NFTOwnershipSharesMapKey = "NFTOwnershipSharesMap"
+
 	// Used to distinguish v3 messages from previous iterations
 	MessagesVersionString = "V"
 	MessagesVersion1      = 1
@@ -1240,7 +1577,49 @@ const (
 	// Min/MaxMaxCopiesPerNFTNanos - Min/max value to which the create NFT fee can be set.
 	MinMaxCopiesPerNFT = 1
 	MaxMaxCopiesPerNFT = 10000
+	// MaxDAOCoinLimitOrderFeeBasisPoints - Max value to which the maker and taker fees can be set.
+	MaxDAOCoinLimitOrderFeeBasisPoints = 10 * 100
 	// Messaging key constants
 	MinMessagingKeyNameCharacters = 1
 	MaxMessagingKeyNameCharacters = 32
+	// Signer set constants
+	MaxSignersPerSignerSet = 64
 )
+
+// Each diamond level is worth a fixed amount of DeSo. These amounts can be changed
+// in the future by simply returning a new set of values after a particular block height.
+func GetDeSoNanosDiamondLevelMapAtBlockHeight(
+	blockHeight int64) map[int64]uint64 {
+
+	return map[int64]uint64{
+		1: 50000,
+		2: 500000,
+		3: 5000000,
+		4: 50000000,
+		5: 500000000,
+		6: 5000000000,
+		7: 50000000000,
+		8: 500000000000,
+	}
+}
+
+func GetDeSoNanosForDiamondLevelAtBlockHeight(
+	diamondLevel int64, blockHeight int64) uint64 {
+
+	// Caller is responsible for passing a valid diamond level.
+	desoNanosMap := GetDeSoNanosDiamondLevelMapAtBlockHeight(blockHeight)
+	desoNanosForLevel, levelExists := desoNanosMap[diamondLevel]
+	if !levelExists {
+		// We allow a special case for diamondLevel zero, in which case we
+		// know that the value should also be zero.
+		if diamondLevel != 0 {
+			// If a non-existent level is requested, return zero
+			glog.Errorf("GetDeSoNanosForDiamondLevelAtBlockHeight: "+
+				"Diamond level %v does not exist in map %v; this should never happen",
+				diamondLevel, desoNanosMap)
+		}
+		return 0
+	}
+
+	return desoNanosForLevel
+}
@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// Batch getters, e.g. the ones that fetch the posts for a feed, skip the entries they can't decode
+// instead of failing the whole batch. Every skipped entry is counted and reported in
+// PrefixCorruptedEntries, so that the corruption isn't silently hidden: operators can watch the
+// count and the number of reports, which the statsd reporter sends as DB.CORRUPTED_ENTRIES.SKIPPED
+// and DB.CORRUPTED_ENTRIES.REPORTED, list the reports with DbGetCorruptedEntryReports, and repair
+// the reported prefixes from a peer with RepairCorruptedEntriesFromPeer.
+
+// numCorruptedEntriesSkipped counts the entries skipped since the node started.
+var numCorruptedEntriesSkipped uint64
+
+// NumCorruptedEntriesSkipped returns the number of entries batch getters skipped because they
+// couldn't be decoded since the node started. An entry that's read again is counted again.
+func NumCorruptedEntriesSkipped() uint64 {
+	return atomic.LoadUint64(&numCorruptedEntriesSkipped)
+}
+
+// CorruptedEntryReport describes an entry that couldn't be decoded. TimestampNanos is the last time
+// the entry was skipped.
+type CorruptedEntryReport struct {
+	Key            []byte
+	ErrorMessage   string
+	TimestampNanos uint64
+}
+
+func (report *CorruptedEntryReport) ToBytes() []byte {
+	data := EncodeByteArray(report.Key)
+	data = append(data, EncodeByteArray([]byte(report.ErrorMessage))...)
+	data = append(data, UintToBuf(report.TimestampNanos)...)
+	return data
+}
+
+func (report *CorruptedEntryReport) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+	var err error
+
+	if report.Key, err = DecodeByteArray(rr); err != nil {
+		return errors.Wrapf(err, "CorruptedEntryReport.FromBytes: Problem reading Key")
+	}
+	errorMessage, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "CorruptedEntryReport.FromBytes: Problem reading ErrorMessage")
+	}
+	report.ErrorMessage = string(errorMessage)
+	if report.TimestampNanos, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "CorruptedEntryReport.FromBytes: Problem reading TimestampNanos")
+	}
+	return nil
+}
+
+// RecordCorruptedEntry counts and reports an entry that a batch getter skipped. Reporting is
+// best-effort: a failure to write the report is only logged, so that it never fails the read.
+func RecordCorruptedEntry(handle *badger.DB, key []byte, decodeErr error) {
+	atomic.AddUint64(&numCorruptedEntriesSkipped, 1)
+	glog.Errorf("RecordCorruptedEntry: Skipping corrupted entry with key %v: %v", key, decodeErr)
+
+	report := &CorruptedEntryReport{
+		Key:            append([]byte{}, key...),
+		ErrorMessage:   decodeErr.Error(),
+		TimestampNanos: uint64(time.Now().UnixNano()),
+	}
+	if err := DbPutCorruptedEntryReport(handle, report); err != nil {
+		glog.Errorf("RecordCorruptedEntry: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorruptedPostEntriesAreSkipped(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	var postHashes []*BlockHash
+	for ii := 0; ii < 3; ii++ {
+		postEntry := &PostEntry{
+			PostHash:        NewBlockHash(RandomBytes(HashSizeBytes)),
+			PosterPublicKey: RandomBytes(33),
+			Body:            []byte("post"),
+			TimestampNanos:  uint64(ii + 1),
+		}
+		require.NoError(DBPutPostEntryMappings(db, nil, 0, postEntry, &DeSoTestnetParams))
+		postHashes = append(postHashes, postEntry.PostHash)
+	}
+
+	// Corrupt the second post.
+	corruptedKey := _dbKeyForPostEntryHash(postHashes[1])
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return txn.Set(corruptedKey, []byte{1, 0xFF, 0xFF})
+	}))
+
+	numSkippedBefore := NumCorruptedEntriesSkipped()
+	postEntries, indexes, err := DBGetPostEntriesForPostHashes(db, nil, postHashes)
+	require.NoError(err)
+	require.Equal([]int{0, 2}, indexes)
+	require.Equal(*postHashes[0], *postEntries[0].PostHash)
+	require.Equal(*postHashes[2], *postEntries[1].PostHash)
+	require.Equal(numSkippedBefore+1, NumCorruptedEntriesSkipped())
+
+	// The feed getters drop the corrupted post along with its hash and timestamp.
+	tstamps, fetchedPostHashes, postEntries, err := DBGetAllPostsByTstamp(db, nil, true)
+	require.NoError(err)
+	require.Equal([]uint64{3, 1}, tstamps)
+	require.Equal([]*BlockHash{postHashes[2], postHashes[0]}, fetchedPostHashes)
+	require.Equal(2, len(postEntries))
+
+	// The corrupted entry is reported once, however often it's skipped.
+	reports, err := DbGetCorruptedEntryReports(db)
+	require.NoError(err)
+	require.Equal(1, len(reports))
+	require.Equal(corruptedKey, reports[0].Key)
+	require.NotEmpty(reports[0].ErrorMessage)
+
+	// Missing posts still fail the batch.
+	_, _, err = DBGetPostEntriesForPostHashes(db, nil, []*BlockHash{NewBlockHash(RandomBytes(HashSizeBytes))})
+	require.Error(err)
+}
@@ -0,0 +1,132 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"github.com/holiman/uint256"
+	"github.com/pkg/errors"
+)
+
+// orderBookDepthPageSize is the number of orders read at a time while building order book depth, so that
+// the depth of the top levels of a deep order book doesn't need the whole book.
+const orderBookDepthPageSize = 100
+
+// DAOCoinOrderBookLevel aggregates the open orders on one side of a DAO coin pair that have the same
+// exchange rate.
+type DAOCoinOrderBookLevel struct {
+	// ScaledExchangeRateCoinsToSellPerCoinToBuy is the exchange rate of the level's orders, in the units
+	// of their side of the pair. See DAOCoinLimitOrderEntry.
+	ScaledExchangeRateCoinsToSellPerCoinToBuy *uint256.Int
+	// BaseUnitsToBuy and BaseUnitsToSell are the remaining quantities of the level's orders, whether
+	// the orders are ASKs or BIDs.
+	BaseUnitsToBuy  *uint256.Int
+	BaseUnitsToSell *uint256.Int
+	NumOrders       int
+}
+
+// DAOCoinOrderBookDepth is the order book of a DAO coin pair, aggregated by exchange rate. Bids are the
+// orders buying the pair's buying coin with its selling coin, and Asks are the orders on the other side
+// of the pair, i.e. buying the pair's selling coin with its buying coin, so the Asks' exchange rates are
+// in buying coins per selling coin. Both sides are sorted best first.
+type DAOCoinOrderBookDepth struct {
+	Bids []*DAOCoinOrderBookLevel
+	Asks []*DAOCoinOrderBookLevel
+}
+
+// GetOrderBookDepthForCoinPair returns up to numLevels levels on each side of the pair, or every level if
+// numLevels is zero. The view's pending orders are merged with the db's, see GetMergedOrderBook.
+func (bav *UtxoView) GetOrderBookDepthForCoinPair(
+	buyingDAOCoinCreatorPKID *PKID, sellingDAOCoinCreatorPKID *PKID, numLevels int) (
+	*DAOCoinOrderBookDepth, error) {
+
+	return _getOrderBookDepthForCoinPair(buyingDAOCoinCreatorPKID, sellingDAOCoinCreatorPKID, numLevels,
+		func(buyingPKID *PKID, sellingPKID *PKID, startAfterOrder *DAOCoinLimitOrderEntry, maxOrders int) (
+			[]*DAOCoinLimitOrderEntry, error) {
+
+			return GetMergedOrderBook(bav, &DAOCoinLimitOrderPair{
+				BuyingDAOCoinCreatorPKID:  buyingPKID,
+				SellingDAOCoinCreatorPKID: sellingPKID,
+			}, &DAOCoinLimitOrderBookPagination{StartAfterOrder: startAfterOrder, MaxOrders: maxOrders})
+		})
+}
+
+// getOrderBookPageFunc returns the orders buying buyingPKID with sellingPKID that come after
+// startAfterOrder, best first, up to maxOrders of them.
+type getOrderBookPageFunc func(buyingPKID *PKID, sellingPKID *PKID, startAfterOrder *DAOCoinLimitOrderEntry,
+	maxOrders int) ([]*DAOCoinLimitOrderEntry, error)
+
+func _getOrderBookDepthForCoinPair(buyingDAOCoinCreatorPKID *PKID, sellingDAOCoinCreatorPKID *PKID,
+	numLevels int, getOrderBookPage getOrderBookPageFunc) (*DAOCoinOrderBookDepth, error) {
+
+	if buyingDAOCoinCreatorPKID == nil || sellingDAOCoinCreatorPKID == nil {
+		return nil, errors.Errorf("GetOrderBookDepthForCoinPair: Called with nil DAO coin pair; this should never happen")
+	}
+	if numLevels < 0 {
+		return nil, errors.Errorf("GetOrderBookDepthForCoinPair: Called with negative numLevels %d", numLevels)
+	}
+
+	bids, err := _getOrderBookDepthLevels(buyingDAOCoinCreatorPKID, sellingDAOCoinCreatorPKID, numLevels, getOrderBookPage)
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetOrderBookDepthForCoinPair: Problem getting bids: ")
+	}
+	asks, err := _getOrderBookDepthLevels(sellingDAOCoinCreatorPKID, buyingDAOCoinCreatorPKID, numLevels, getOrderBookPage)
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetOrderBookDepthForCoinPair: Problem getting asks: ")
+	}
+	return &DAOCoinOrderBookDepth{Bids: bids, Asks: asks}, nil
+}
+
+// _getOrderBookDepthLevels aggregates one side of the pair. Since the orders come best first, the orders
+// of a level are consecutive, and the pages stop being read once a level past numLevels shows up.
+func _getOrderBookDepthLevels(buyingPKID *PKID, sellingPKID *PKID, numLevels int,
+	getOrderBookPage getOrderBookPageFunc) ([]*DAOCoinOrderBookLevel, error) {
+
+	levels := []*DAOCoinOrderBookLevel{}
+	var startAfterOrder *DAOCoinLimitOrderEntry
+	for {
+		orders, err := getOrderBookPage(buyingPKID, sellingPKID, startAfterOrder, orderBookDepthPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, order := range orders {
+			var level *DAOCoinOrderBookLevel
+			if len(levels) > 0 && levels[len(levels)-1].ScaledExchangeRateCoinsToSellPerCoinToBuy.Eq(
+				order.ScaledExchangeRateCoinsToSellPerCoinToBuy) {
+
+				level = levels[len(levels)-1]
+			} else {
+				if numLevels > 0 && len(levels) == numLevels {
+					return levels, nil
+				}
+				level = &DAOCoinOrderBookLevel{
+					ScaledExchangeRateCoinsToSellPerCoinToBuy: order.ScaledExchangeRateCoinsToSellPerCoinToBuy.Clone(),
+					BaseUnitsToBuy:  uint256.NewInt(),
+					BaseUnitsToSell: uint256.NewInt(),
+				}
+				levels = append(levels, level)
+			}
+
+			baseUnitsToBuy, err := order.BaseUnitsToBuyUint256()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Problem getting base units to buy of order %v: ", order.OrderID)
+			}
+			baseUnitsToSell, err := order.BaseUnitsToSellUint256()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Problem getting base units to sell of order %v: ", order.OrderID)
+			}
+			if level.BaseUnitsToBuy, err = SafeUint256().Add(level.BaseUnitsToBuy, baseUnitsToBuy); err != nil {
+				return nil, errors.Wrapf(err, "Base units to buy overflow at order %v: ", order.OrderID)
+			}
+			if level.BaseUnitsToSell, err = SafeUint256().Add(level.BaseUnitsToSell, baseUnitsToSell); err != nil {
+				return nil, errors.Wrapf(err, "Base units to sell overflow at order %v: ", order.OrderID)
+			}
+			level.NumOrders++
+		}
+
+		if len(orders) < orderBookDepthPageSize {
+			return levels, nil
+		}
+		startAfterOrder = orders[len(orders)-1]
+	}
+}
@@ -0,0 +1,104 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOrderBookDepthForCoinPair(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	defer db.Close()
+	params := &DeSoTestnetParams
+
+	m0PKID := PublicKeyToPKID(m0PkBytes)
+	m1PKID := PublicKeyToPKID(m1PkBytes)
+	daoCoinPKID := PublicKeyToPKID(m2PkBytes)
+	newOrder := func(buyingPKID *PKID, sellingPKID *PKID, price string, quantity uint64,
+		operationType DAOCoinLimitOrderOperationType) *DAOCoinLimitOrderEntry {
+
+		exchangeRate, err := CalculateScaledExchangeRateFromString(price)
+		require.NoError(err)
+		return &DAOCoinLimitOrderEntry{
+			OrderID:                   NewBlockHash(RandomBytes(HashSizeBytes)),
+			TransactorPKID:            m0PKID,
+			BuyingDAOCoinCreatorPKID:  buyingPKID,
+			SellingDAOCoinCreatorPKID: sellingPKID,
+			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
+			QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(quantity),
+			OperationType:                             operationType,
+			FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+			BlockHeight:                               1,
+		}
+	}
+
+	// Bids buy the DAO coin with DESO, asks buy DESO with the DAO coin. The second bid level has a BID
+	// and an ASK, whose quantities are in different coins.
+	bids := []*DAOCoinLimitOrderEntry{
+		newOrder(daoCoinPKID, &ZeroPKID, "3.0", 100, DAOCoinLimitOrderOperationTypeBID),
+		newOrder(daoCoinPKID, &ZeroPKID, "2.0", 100, DAOCoinLimitOrderOperationTypeBID),
+		newOrder(daoCoinPKID, &ZeroPKID, "2.0", 100, DAOCoinLimitOrderOperationTypeASK),
+		newOrder(daoCoinPKID, &ZeroPKID, "1.0", 100, DAOCoinLimitOrderOperationTypeBID),
+	}
+	asks := []*DAOCoinLimitOrderEntry{
+		newOrder(&ZeroPKID, daoCoinPKID, "0.5", 100, DAOCoinLimitOrderOperationTypeBID),
+	}
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		for _, order := range append(append([]*DAOCoinLimitOrderEntry{}, bids...), asks...) {
+			if err := DBPutDAOCoinLimitOrderWithTxn(txn, nil, order, 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	newLevel := func(price string, baseUnitsToBuy uint64, baseUnitsToSell uint64, numOrders int) *DAOCoinOrderBookLevel {
+		exchangeRate, err := CalculateScaledExchangeRateFromString(price)
+		require.NoError(err)
+		return &DAOCoinOrderBookLevel{
+			ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
+			BaseUnitsToBuy:  uint256.NewInt().SetUint64(baseUnitsToBuy),
+			BaseUnitsToSell: uint256.NewInt().SetUint64(baseUnitsToSell),
+			NumOrders:       numOrders,
+		}
+	}
+	utxoView, err := NewUtxoView(db, params, nil, nil)
+	require.NoError(err)
+
+	// The db and a view without changes have the same depth, limited to numLevels on each side.
+	expectedDepth := &DAOCoinOrderBookDepth{
+		Bids: []*DAOCoinOrderBookLevel{newLevel("3.0", 100, 300, 1), newLevel("2.0", 150, 300, 2)},
+		Asks: []*DAOCoinOrderBookLevel{newLevel("0.5", 100, 50, 1)},
+	}
+	depth, err := utxoView.GetDbAdapter().GetOrderBookDepthForCoinPair(daoCoinPKID, &ZeroPKID, 2)
+	require.NoError(err)
+	require.Equal(expectedDepth, depth)
+	depth, err = utxoView.GetOrderBookDepthForCoinPair(daoCoinPKID, &ZeroPKID, 2)
+	require.NoError(err)
+	require.Equal(expectedDepth, depth)
+
+	// The view's pending orders replace the db's.
+	utxoView._deleteDAOCoinLimitOrderEntryMappings(bids[0])
+	utxoView._setDAOCoinLimitOrderEntryMappings(
+		newOrder(daoCoinPKID, &ZeroPKID, "1.0", 50, DAOCoinLimitOrderOperationTypeBID))
+	depth, err = utxoView.GetOrderBookDepthForCoinPair(daoCoinPKID, &ZeroPKID, 0)
+	require.NoError(err)
+	require.Equal(&DAOCoinOrderBookDepth{
+		Bids: []*DAOCoinOrderBookLevel{newLevel("2.0", 150, 300, 2), newLevel("1.0", 150, 150, 2)},
+		Asks: []*DAOCoinOrderBookLevel{newLevel("0.5", 100, 50, 1)},
+	}, depth)
+
+	// A pair without orders has no levels.
+	depth, err = utxoView.GetOrderBookDepthForCoinPair(m1PKID, &ZeroPKID, 0)
+	require.NoError(err)
+	require.Empty(depth.Bids)
+	require.Empty(depth.Asks)
+}
@@ -0,0 +1,386 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/holiman/uint256"
+	"github.com/pkg/errors"
+)
+
+// DAOCoinPairVolumeWindow is a rolling window that DAO coin pair volume can be queried over.
+type DAOCoinPairVolumeWindow uint8
+
+const (
+	DAOCoinPairVolumeWindow24h DAOCoinPairVolumeWindow = 0
+	DAOCoinPairVolumeWindow7d  DAOCoinPairVolumeWindow = 1
+)
+
+func (window DAOCoinPairVolumeWindow) Duration() time.Duration {
+	switch window {
+	case DAOCoinPairVolumeWindow24h:
+		return 24 * time.Hour
+	case DAOCoinPairVolumeWindow7d:
+		return 7 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+func (window DAOCoinPairVolumeWindow) String() string {
+	switch window {
+	case DAOCoinPairVolumeWindow24h:
+		return "24h"
+	case DAOCoinPairVolumeWindow7d:
+		return "7d"
+	default:
+		return fmt.Sprintf("DAOCoinPairVolumeWindow(%d)", uint8(window))
+	}
+}
+
+// DAOCoinPairVolume is the volume traded by the orders on one side of a DAO coin pair, i.e. by
+// the orders buying BuyingDAOCoinCreatorPKID with SellingDAOCoinCreatorPKID. Every fill shows up
+// once on each side of the pair, since the two orders it matches are on opposite sides.
+type DAOCoinPairVolume struct {
+	BaseUnitsBought *uint256.Int
+	BaseUnitsSold   *uint256.Int
+	// DeSoNotionalNanos is the DESO leg of the fills, and is zero when neither coin is DESO.
+	DeSoNotionalNanos *uint256.Int
+	NumFills          uint64
+}
+
+func NewDAOCoinPairVolume() *DAOCoinPairVolume {
+	return &DAOCoinPairVolume{
+		BaseUnitsBought:   uint256.NewInt(),
+		BaseUnitsSold:     uint256.NewInt(),
+		DeSoNotionalNanos: uint256.NewInt(),
+	}
+}
+
+func (volume *DAOCoinPairVolume) add(other *DAOCoinPairVolume) error {
+	var err error
+	if volume.BaseUnitsBought, err = SafeUint256().Add(volume.BaseUnitsBought, other.BaseUnitsBought); err != nil {
+		return errors.Wrapf(err, "DAOCoinPairVolume.add: BaseUnitsBought overflows")
+	}
+	if volume.BaseUnitsSold, err = SafeUint256().Add(volume.BaseUnitsSold, other.BaseUnitsSold); err != nil {
+		return errors.Wrapf(err, "DAOCoinPairVolume.add: BaseUnitsSold overflows")
+	}
+	if volume.DeSoNotionalNanos, err = SafeUint256().Add(volume.DeSoNotionalNanos, other.DeSoNotionalNanos); err != nil {
+		return errors.Wrapf(err, "DAOCoinPairVolume.add: DeSoNotionalNanos overflows")
+	}
+	volume.NumFills += other.NumFills
+	return nil
+}
+
+// subtract removes volume that was previously added. It saturates at zero rather than failing, so
+// that a disconnect can't get stuck on a bucket that was pruned and started over.
+func (volume *DAOCoinPairVolume) subtract(other *DAOCoinPairVolume) {
+	saturatingSub := func(x *uint256.Int, y *uint256.Int) *uint256.Int {
+		if x.Lt(y) {
+			return uint256.NewInt()
+		}
+		return uint256.NewInt().Sub(x, y)
+	}
+	volume.BaseUnitsBought = saturatingSub(volume.BaseUnitsBought, other.BaseUnitsBought)
+	volume.BaseUnitsSold = saturatingSub(volume.BaseUnitsSold, other.BaseUnitsSold)
+	volume.DeSoNotionalNanos = saturatingSub(volume.DeSoNotionalNanos, other.DeSoNotionalNanos)
+	if volume.NumFills < other.NumFills {
+		volume.NumFills = 0
+	} else {
+		volume.NumFills -= other.NumFills
+	}
+}
+
+func (volume *DAOCoinPairVolume) ToBytes() []byte {
+	var data []byte
+	data = append(data, EncodeUint256(volume.BaseUnitsBought)...)
+	data = append(data, EncodeUint256(volume.BaseUnitsSold)...)
+	data = append(data, EncodeUint256(volume.DeSoNotionalNanos)...)
+	data = append(data, UintToBuf(volume.NumFills)...)
+	return data
+}
+
+func (volume *DAOCoinPairVolume) FromBytes(rr *bytes.Reader) error {
+	var err error
+	if volume.BaseUnitsBought, err = DecodeUint256(rr); err != nil {
+		return errors.Wrapf(err, "DAOCoinPairVolume.FromBytes: Problem reading BaseUnitsBought")
+	}
+	if volume.BaseUnitsSold, err = DecodeUint256(rr); err != nil {
+		return errors.Wrapf(err, "DAOCoinPairVolume.FromBytes: Problem reading BaseUnitsSold")
+	}
+	if volume.DeSoNotionalNanos, err = DecodeUint256(rr); err != nil {
+		return errors.Wrapf(err, "DAOCoinPairVolume.FromBytes: Problem reading DeSoNotionalNanos")
+	}
+	if volume.NumFills, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "DAOCoinPairVolume.FromBytes: Problem reading NumFills")
+	}
+	return nil
+}
+
+// DAOCoinPairVolumeBlockContribution is the volume one block added to each side of each pair. All
+// of a block's fills fall in the bucket of the block's timestamp.
+type DAOCoinPairVolumeBlockContribution struct {
+	BucketStartSecs uint64
+	Pairs           []*DAOCoinLimitOrderPair
+	Volumes         []*DAOCoinPairVolume
+}
+
+func (contribution *DAOCoinPairVolumeBlockContribution) ToBytes() []byte {
+	data := UintToBuf(contribution.BucketStartSecs)
+	data = append(data, UintToBuf(uint64(len(contribution.Pairs)))...)
+	for ii, pair := range contribution.Pairs {
+		data = append(data, pair.BuyingDAOCoinCreatorPKID[:]...)
+		data = append(data, pair.SellingDAOCoinCreatorPKID[:]...)
+		data = append(data, contribution.Volumes[ii].ToBytes()...)
+	}
+	return data
+}
+
+func (contribution *DAOCoinPairVolumeBlockContribution) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+	var err error
+
+	if contribution.BucketStartSecs, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "DAOCoinPairVolumeBlockContribution.FromBytes: Problem reading BucketStartSecs")
+	}
+	numPairs, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "DAOCoinPairVolumeBlockContribution.FromBytes: Problem reading number of pairs")
+	}
+	contribution.Pairs = []*DAOCoinLimitOrderPair{}
+	contribution.Volumes = []*DAOCoinPairVolume{}
+	for ii := uint64(0); ii < numPairs; ii++ {
+		pair := &DAOCoinLimitOrderPair{
+			BuyingDAOCoinCreatorPKID:  &PKID{},
+			SellingDAOCoinCreatorPKID: &PKID{},
+		}
+		if _, err = io.ReadFull(rr, pair.BuyingDAOCoinCreatorPKID[:]); err != nil {
+			return errors.Wrapf(err, "DAOCoinPairVolumeBlockContribution.FromBytes: Problem reading BuyingDAOCoinCreatorPKID")
+		}
+		if _, err = io.ReadFull(rr, pair.SellingDAOCoinCreatorPKID[:]); err != nil {
+			return errors.Wrapf(err, "DAOCoinPairVolumeBlockContribution.FromBytes: Problem reading SellingDAOCoinCreatorPKID")
+		}
+		volume := &DAOCoinPairVolume{}
+		if err = volume.FromBytes(rr); err != nil {
+			return errors.Wrapf(err, "DAOCoinPairVolumeBlockContribution.FromBytes: ")
+		}
+		contribution.Pairs = append(contribution.Pairs, pair)
+		contribution.Volumes = append(contribution.Volumes, volume)
+	}
+	return nil
+}
+
+// ComputeDAOCoinPairVolumeBlockContribution aggregates the filled orders in a block's utxo ops by
+// side of pair. The pairs are sorted so that the result is deterministic.
+func ComputeDAOCoinPairVolumeBlockContribution(
+	blockTstampSecs uint64, utxoOpsForBlock [][]*UtxoOperation) (*DAOCoinPairVolumeBlockContribution, error) {
+
+	bucketSecs := uint64(DAOCoinPairVolumeBucketDuration.Seconds())
+	volumesByPair := make(map[DAOCoinLimitOrderPairMapKey]*DAOCoinPairVolume)
+	for _, utxoOpsForTxn := range utxoOpsForBlock {
+		for _, utxoOp := range utxoOpsForTxn {
+			for _, filledOrder := range utxoOp.FilledDAOCoinLimitOrders {
+				pairKey := DAOCoinLimitOrderPairMapKey{
+					BuyingDAOCoinCreatorPKID:  *filledOrder.BuyingDAOCoinCreatorPKID,
+					SellingDAOCoinCreatorPKID: *filledOrder.SellingDAOCoinCreatorPKID,
+				}
+				fillVolume := &DAOCoinPairVolume{
+					BaseUnitsBought:   filledOrder.CoinQuantityInBaseUnitsBought,
+					BaseUnitsSold:     filledOrder.CoinQuantityInBaseUnitsSold,
+					DeSoNotionalNanos: uint256.NewInt(),
+					NumFills:          1,
+				}
+				if filledOrder.BuyingDAOCoinCreatorPKID.IsZeroPKID() {
+					fillVolume.DeSoNotionalNanos = filledOrder.CoinQuantityInBaseUnitsBought
+				} else if filledOrder.SellingDAOCoinCreatorPKID.IsZeroPKID() {
+					fillVolume.DeSoNotionalNanos = filledOrder.CoinQuantityInBaseUnitsSold
+				}
+
+				if _, exists := volumesByPair[pairKey]; !exists {
+					volumesByPair[pairKey] = NewDAOCoinPairVolume()
+				}
+				if err := volumesByPair[pairKey].add(fillVolume); err != nil {
+					return nil, errors.Wrapf(err, "ComputeDAOCoinPairVolumeBlockContribution: ")
+				}
+			}
+		}
+	}
+
+	pairKeys := []DAOCoinLimitOrderPairMapKey{}
+	for pairKey := range volumesByPair {
+		pairKeys = append(pairKeys, pairKey)
+	}
+	sort.Slice(pairKeys, func(ii, jj int) bool {
+		if cmp := bytes.Compare(pairKeys[ii].BuyingDAOCoinCreatorPKID[:], pairKeys[jj].BuyingDAOCoinCreatorPKID[:]); cmp != 0 {
+			return cmp < 0
+		}
+		return bytes.Compare(pairKeys[ii].SellingDAOCoinCreatorPKID[:], pairKeys[jj].SellingDAOCoinCreatorPKID[:]) < 0
+	})
+
+	contribution := &DAOCoinPairVolumeBlockContribution{
+		BucketStartSecs: blockTstampSecs - blockTstampSecs%bucketSecs,
+	}
+	for _, pairKey := range pairKeys {
+		pairKeyCopy := pairKey
+		contribution.Pairs = append(contribution.Pairs, &DAOCoinLimitOrderPair{
+			BuyingDAOCoinCreatorPKID:  &pairKeyCopy.BuyingDAOCoinCreatorPKID,
+			SellingDAOCoinCreatorPKID: &pairKeyCopy.SellingDAOCoinCreatorPKID,
+		})
+		contribution.Volumes = append(contribution.Volumes, volumesByPair[pairKey])
+	}
+	return contribution, nil
+}
+
+// DAOCoinLimitOrderPairMapKey is the comparable version of DAOCoinLimitOrderPair.
+type DAOCoinLimitOrderPairMapKey struct {
+	BuyingDAOCoinCreatorPKID  PKID
+	SellingDAOCoinCreatorPKID PKID
+}
+
+// RegisterDAOCoinPairVolumeHandlers makes the IndexQueue maintain the DAO coin pair volume
+// statistics. Both handlers are idempotent: a block's contribution is only applied if it hasn't
+// been recorded yet, and only reverted if it has.
+func RegisterDAOCoinPairVolumeHandlers(indexQueue *IndexQueue) {
+	db := indexQueue.db
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockConnected, func(task *IndexQueueTask) error {
+		return db.Update(func(txn *badger.Txn) error {
+			return _applyDAOCoinPairVolumeForBlockWithTxn(txn, task.BlockHash)
+		})
+	})
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockDisconnected, func(task *IndexQueueTask) error {
+		return db.Update(func(txn *badger.Txn) error {
+			return _revertDAOCoinPairVolumeForBlockWithTxn(txn, task.BlockHash)
+		})
+	})
+}
+
+func _applyDAOCoinPairVolumeForBlockWithTxn(txn *badger.Txn, blockHash *BlockHash) error {
+	existingContribution, err := DbGetDAOCoinPairVolumeBlockContributionWithTxn(txn, blockHash)
+	if err != nil {
+		return errors.Wrapf(err, "_applyDAOCoinPairVolumeForBlockWithTxn: ")
+	}
+	if existingContribution != nil {
+		return nil
+	}
+
+	// If the block was disconnected before we got to it, its utxo ops are gone and there's
+	// nothing to apply. The disconnect task won't find a contribution to revert either.
+	utxoOpsForBlock, err := GetUtxoOperationsForBlockWithTxn(txn, nil, blockHash)
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "_applyDAOCoinPairVolumeForBlockWithTxn: Problem getting utxo ops for block %v", blockHash)
+	}
+	block := GetBlockWithTxn(txn, nil, blockHash)
+	if block == nil {
+		return fmt.Errorf("_applyDAOCoinPairVolumeForBlockWithTxn: Block %v not found", blockHash)
+	}
+
+	contribution, err := ComputeDAOCoinPairVolumeBlockContribution(block.Header.TstampSecs, utxoOpsForBlock)
+	if err != nil {
+		return errors.Wrapf(err, "_applyDAOCoinPairVolumeForBlockWithTxn: ")
+	}
+	pruneBeforeSecs := uint64(0)
+	if retentionSecs := uint64(DAOCoinPairVolumeRetention.Seconds()); contribution.BucketStartSecs > retentionSecs {
+		pruneBeforeSecs = contribution.BucketStartSecs - retentionSecs
+	}
+	for ii, pair := range contribution.Pairs {
+		volume, err := DbGetDAOCoinPairVolumeBucketWithTxn(txn, pair, contribution.BucketStartSecs)
+		if err != nil {
+			return errors.Wrapf(err, "_applyDAOCoinPairVolumeForBlockWithTxn: ")
+		}
+		if volume == nil {
+			volume = NewDAOCoinPairVolume()
+		}
+		if err = volume.add(contribution.Volumes[ii]); err != nil {
+			return errors.Wrapf(err, "_applyDAOCoinPairVolumeForBlockWithTxn: ")
+		}
+		if err = DbPutDAOCoinPairVolumeBucketWithTxn(txn, pair, contribution.BucketStartSecs, volume); err != nil {
+			return errors.Wrapf(err, "_applyDAOCoinPairVolumeForBlockWithTxn: ")
+		}
+		if err = DbDeleteDAOCoinPairVolumeBucketsBeforeWithTxn(txn, pair, pruneBeforeSecs); err != nil {
+			return errors.Wrapf(err, "_applyDAOCoinPairVolumeForBlockWithTxn: ")
+		}
+	}
+
+	// The contribution is recorded even when it's empty, so that we don't reload the block.
+	return DbPutDAOCoinPairVolumeBlockContributionWithTxn(txn, blockHash, contribution)
+}
+
+func _revertDAOCoinPairVolumeForBlockWithTxn(txn *badger.Txn, blockHash *BlockHash) error {
+	contribution, err := DbGetDAOCoinPairVolumeBlockContributionWithTxn(txn, blockHash)
+	if err != nil {
+		return errors.Wrapf(err, "_revertDAOCoinPairVolumeForBlockWithTxn: ")
+	}
+	if contribution == nil {
+		return nil
+	}
+
+	for ii, pair := range contribution.Pairs {
+		volume, err := DbGetDAOCoinPairVolumeBucketWithTxn(txn, pair, contribution.BucketStartSecs)
+		if err != nil {
+			return errors.Wrapf(err, "_revertDAOCoinPairVolumeForBlockWithTxn: ")
+		}
+		// The bucket may have been pruned already, in which case there's nothing to revert.
+		if volume == nil {
+			continue
+		}
+		volume.subtract(contribution.Volumes[ii])
+		if err = DbPutDAOCoinPairVolumeBucketWithTxn(txn, pair, contribution.BucketStartSecs, volume); err != nil {
+			return errors.Wrapf(err, "_revertDAOCoinPairVolumeForBlockWithTxn: ")
+		}
+	}
+
+	return DbDeleteDAOCoinPairVolumeBlockContributionWithTxn(txn, blockHash)
+}
+
+// DbGetPairVolume returns the volume traded on one side of a DAO coin pair over the window ending
+// now. Windows are aligned to DAOCoinPairVolumeBucketDuration, so they can reach back up to one
+// bucket further than their duration.
+func DbGetPairVolume(handle *badger.DB, buyingPKID *PKID, sellingPKID *PKID, window DAOCoinPairVolumeWindow) (
+	*DAOCoinPairVolume, error) {
+
+	return _dbGetPairVolumeAtTime(handle, buyingPKID, sellingPKID, window, uint64(time.Now().Unix()))
+}
+
+func _dbGetPairVolumeAtTime(handle *badger.DB, buyingPKID *PKID, sellingPKID *PKID,
+	window DAOCoinPairVolumeWindow, nowSecs uint64) (*DAOCoinPairVolume, error) {
+
+	windowSecs := uint64(window.Duration().Seconds())
+	if windowSecs == 0 {
+		return nil, fmt.Errorf("DbGetPairVolume: Unknown window %v", window)
+	}
+	startSecs := uint64(0)
+	if nowSecs > windowSecs {
+		startSecs = nowSecs - windowSecs
+	}
+	bucketSecs := uint64(DAOCoinPairVolumeBucketDuration.Seconds())
+	startSecs -= startSecs % bucketSecs
+
+	pair := &DAOCoinLimitOrderPair{
+		BuyingDAOCoinCreatorPKID:  buyingPKID,
+		SellingDAOCoinCreatorPKID: sellingPKID,
+	}
+	totalVolume := NewDAOCoinPairVolume()
+	err := handle.View(func(txn *badger.Txn) error {
+		volumes, err := DbGetDAOCoinPairVolumeBucketsWithTxn(txn, pair, startSecs, nowSecs)
+		if err != nil {
+			return err
+		}
+		for _, volume := range volumes {
+			if err := totalVolume.add(volume); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetPairVolume: ")
+	}
+	return totalVolume, nil
+}
@@ -0,0 +1,122 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDAOCoinPairVolume(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+	indexQueue := NewIndexQueue(db)
+	RegisterDAOCoinPairVolumeHandlers(indexQueue)
+	chain.SetIndexQueue(indexQueue)
+
+	m0PkBytes, _, err := Base58CheckDecode(m0Pub)
+	require.NoError(err)
+	coinPKID := PublicKeyToPKID(m0PkBytes)
+	buyingCoin := &DAOCoinLimitOrderPair{BuyingDAOCoinCreatorPKID: coinPKID, SellingDAOCoinCreatorPKID: &ZeroPKID}
+	sellingCoin := &DAOCoinLimitOrderPair{BuyingDAOCoinCreatorPKID: &ZeroPKID, SellingDAOCoinCreatorPKID: coinPKID}
+
+	block, err := miner.MineAndProcessSingleBlock(0, mempool)
+	require.NoError(err)
+	blockHash, err := block.Hash()
+	require.NoError(err)
+
+	// Replace the block's utxo ops with a fill of 100 coin base units for 50 DESO nanos. The fill
+	// shows up once on each side of the pair.
+	fillOp := &UtxoOperation{
+		Type: OperationTypeDAOCoinLimitOrder,
+		FilledDAOCoinLimitOrders: []*FilledDAOCoinLimitOrder{
+			{
+				OrderID:                       NewBlockHash(RandomBytes(HashSizeBytes)),
+				TransactorPKID:                coinPKID,
+				BuyingDAOCoinCreatorPKID:      coinPKID,
+				SellingDAOCoinCreatorPKID:     &ZeroPKID,
+				CoinQuantityInBaseUnitsBought: uint256.NewInt().SetUint64(100),
+				CoinQuantityInBaseUnitsSold:   uint256.NewInt().SetUint64(50),
+				FeeInBaseUnitsPaid:            uint256.NewInt(),
+			},
+			{
+				OrderID:                       NewBlockHash(RandomBytes(HashSizeBytes)),
+				TransactorPKID:                coinPKID,
+				BuyingDAOCoinCreatorPKID:      &ZeroPKID,
+				SellingDAOCoinCreatorPKID:     coinPKID,
+				CoinQuantityInBaseUnitsBought: uint256.NewInt().SetUint64(50),
+				CoinQuantityInBaseUnitsSold:   uint256.NewInt().SetUint64(100),
+				FeeInBaseUnitsPaid:            uint256.NewInt(),
+			},
+		},
+	}
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return PutUtxoOperationsForBlockWithTxn(txn, nil, block.Header.Height, blockHash,
+			[][]*UtxoOperation{{fillOp}})
+	}))
+
+	// The IndexQueue applies the block's fills.
+	_, err = indexQueue.ProcessTasks(IndexQueueBatchSize)
+	require.NoError(err)
+
+	checkVolume := func(pair *DAOCoinLimitOrderPair, window DAOCoinPairVolumeWindow, nowSecs uint64,
+		bought uint64, sold uint64, deso uint64, numFills uint64) {
+
+		volume, err := _dbGetPairVolumeAtTime(db, pair.BuyingDAOCoinCreatorPKID, pair.SellingDAOCoinCreatorPKID,
+			window, nowSecs)
+		require.NoError(err)
+		require.Equal(uint256.NewInt().SetUint64(bought), volume.BaseUnitsBought)
+		require.Equal(uint256.NewInt().SetUint64(sold), volume.BaseUnitsSold)
+		require.Equal(uint256.NewInt().SetUint64(deso), volume.DeSoNotionalNanos)
+		require.Equal(numFills, volume.NumFills)
+	}
+	tstampSecs := block.Header.TstampSecs
+	checkVolume(buyingCoin, DAOCoinPairVolumeWindow24h, tstampSecs, 100, 50, 50, 1)
+	checkVolume(sellingCoin, DAOCoinPairVolumeWindow24h, tstampSecs, 50, 100, 50, 1)
+
+	// Applying the block again is a no-op.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return _applyDAOCoinPairVolumeForBlockWithTxn(txn, blockHash)
+	}))
+	checkVolume(buyingCoin, DAOCoinPairVolumeWindow24h, tstampSecs, 100, 50, 50, 1)
+
+	// The fill drops out of the 24h window before it drops out of the 7d window.
+	twoDaysLaterSecs := tstampSecs + 2*24*60*60
+	checkVolume(buyingCoin, DAOCoinPairVolumeWindow24h, twoDaysLaterSecs, 0, 0, 0, 0)
+	checkVolume(buyingCoin, DAOCoinPairVolumeWindow7d, twoDaysLaterSecs, 100, 50, 50, 1)
+
+	// Reverting the block subtracts its fills, and reverting it again is a no-op.
+	for ii := 0; ii < 2; ii++ {
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			return _revertDAOCoinPairVolumeForBlockWithTxn(txn, blockHash)
+		}))
+		checkVolume(buyingCoin, DAOCoinPairVolumeWindow24h, tstampSecs, 0, 0, 0, 0)
+		checkVolume(sellingCoin, DAOCoinPairVolumeWindow24h, tstampSecs, 0, 0, 0, 0)
+	}
+}
+
+func TestDAOCoinPairVolumeBlockContributionEncoding(t *testing.T) {
+	require := require.New(t)
+
+	contribution := &DAOCoinPairVolumeBlockContribution{
+		BucketStartSecs: 3600 * 1000,
+		Pairs: []*DAOCoinLimitOrderPair{{
+			BuyingDAOCoinCreatorPKID:  NewPKID(RandomBytes(int32(PublicKeyLenCompressed))),
+			SellingDAOCoinCreatorPKID: &ZeroPKID,
+		}},
+		Volumes: []*DAOCoinPairVolume{{
+			BaseUnitsBought:   uint256.NewInt().SetUint64(123),
+			BaseUnitsSold:     uint256.NewInt().SetUint64(456),
+			DeSoNotionalNanos: uint256.NewInt().SetUint64(456),
+			NumFills:          2,
+		}},
+	}
+	decodedContribution := &DAOCoinPairVolumeBlockContribution{}
+	require.NoError(decodedContribution.FromBytes(contribution.ToBytes()))
+	require.Equal(contribution, decodedContribution)
+}
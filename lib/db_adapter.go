@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -90,6 +92,27 @@ func (adapter *DbAdapter) GetAllDAOCoinLimitOrdersForThisDAOCoinPair(buyingDAOCo
 	return DBGetAllDAOCoinLimitOrdersForThisDAOCoinPair(adapter.badgerDb, buyingDAOCoinCreatorPKID, sellingDAOCoinCreatorPKID)
 }
 
+func (adapter *DbAdapter) GetPaginatedDAOCoinLimitOrdersForThisDAOCoinPair(buyingDAOCoinCreatorPKID *PKID, sellingDAOCoinCreatorPKID *PKID, startAfterOrder *DAOCoinLimitOrderEntry, maxOrders int) ([]*DAOCoinLimitOrderEntry, error) {
+	// Temporarily use badger to support DAO Coin limit order DB operations
+	var outputOrders []*DAOCoinLimitOrderEntry
+	var err error
+
+	err = adapter.badgerDb.View(func(txn *badger.Txn) error {
+		outputOrders, err = DBGetPaginatedDAOCoinLimitOrdersForThisDAOCoinPair(
+			txn, buyingDAOCoinCreatorPKID, sellingDAOCoinCreatorPKID, startAfterOrder, maxOrders)
+		return err
+	})
+
+	return outputOrders, err
+}
+
+// GetOrderBookDepthForCoinPair is UtxoView.GetOrderBookDepthForCoinPair for the orders in the db.
+func (adapter *DbAdapter) GetOrderBookDepthForCoinPair(buyingDAOCoinCreatorPKID *PKID, sellingDAOCoinCreatorPKID *PKID, numLevels int) (*DAOCoinOrderBookDepth, error) {
+	// Temporarily use badger to support DAO Coin limit order DB operations
+	return _getOrderBookDepthForCoinPair(buyingDAOCoinCreatorPKID, sellingDAOCoinCreatorPKID, numLevels,
+		adapter.GetPaginatedDAOCoinLimitOrdersForThisDAOCoinPair)
+}
+
 func (adapter *DbAdapter) GetAllDAOCoinLimitOrdersForThisTransactor(transactorPKID *PKID) ([]*DAOCoinLimitOrderEntry, error) {
 	// Temporarily use badger to support DAO Coin limit order DB operations
 	//if adapter.postgresDb != nil {
@@ -0,0 +1,214 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// AdminPutKV and AdminDeleteKV let operators make surgical fixes to the db, e.g. removing a corrupt
+// key, without going through a txn. Since they bypass all validation, they're guarded by a few
+// interlocks:
+//   - The operator has to configure an unlock token with --admin-kv-unlock-token, and the caller
+//     has to pass the same token, so that they can't be called by accident or on a node that
+//     wasn't set up for it.
+//   - Keys under state prefixes are refused unless AllowStatePrefix is set, since changing the
+//     state makes this node's checksum differ from the rest of the network.
+//   - Every operation is recorded in PrefixAdminKVAuditLog, along with the previous value.
+// State writes go through the snapshot, so the checksum and ancestral records stay consistent with
+// the db, and they hold the ChainLock so that they don't interleave with a block being connected.
+
+// adminKVUnlockToken is the token set by the operator. The admin APIs are locked while it's empty.
+var adminKVUnlockToken atomic.Value
+
+// SetAdminKVUnlockToken sets the token that unlocks the admin APIs. An empty token locks them.
+func SetAdminKVUnlockToken(token string) {
+	adminKVUnlockToken.Store(token)
+}
+
+type AdminKVOptions struct {
+	// UnlockToken must match the token set with SetAdminKVUnlockToken.
+	UnlockToken string
+	// AllowStatePrefix must be set to write keys under state prefixes.
+	AllowStatePrefix bool
+	// Reason is recorded in the audit log, and can't be empty.
+	Reason string
+}
+
+type AdminKVOperationType uint8
+
+const (
+	AdminKVOperationTypePut    AdminKVOperationType = 0
+	AdminKVOperationTypeDelete AdminKVOperationType = 1
+)
+
+func (operationType AdminKVOperationType) String() string {
+	switch operationType {
+	case AdminKVOperationTypePut:
+		return "Put"
+	case AdminKVOperationTypeDelete:
+		return "Delete"
+	default:
+		return fmt.Sprintf("AdminKVOperationType(%d)", uint8(operationType))
+	}
+}
+
+// AdminKVAuditRecord describes one admin write. OldValue is only meaningful if OldValueExists.
+type AdminKVAuditRecord struct {
+	OperationType  AdminKVOperationType
+	TimestampNanos uint64
+	Key            []byte
+	OldValueExists bool
+	OldValue       []byte
+	NewValue       []byte
+	Reason         string
+}
+
+func (record *AdminKVAuditRecord) ToBytes() []byte {
+	data := []byte{byte(record.OperationType)}
+	data = append(data, UintToBuf(record.TimestampNanos)...)
+	data = append(data, EncodeByteArray(record.Key)...)
+	data = append(data, BoolToByte(record.OldValueExists))
+	data = append(data, EncodeByteArray(record.OldValue)...)
+	data = append(data, EncodeByteArray(record.NewValue)...)
+	data = append(data, EncodeByteArray([]byte(record.Reason))...)
+	return data
+}
+
+func (record *AdminKVAuditRecord) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+
+	operationType, err := rr.ReadByte()
+	if err != nil {
+		return errors.Wrapf(err, "AdminKVAuditRecord.FromBytes: Problem reading OperationType")
+	}
+	record.OperationType = AdminKVOperationType(operationType)
+	if record.TimestampNanos, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "AdminKVAuditRecord.FromBytes: Problem reading TimestampNanos")
+	}
+	if record.Key, err = DecodeByteArray(rr); err != nil {
+		return errors.Wrapf(err, "AdminKVAuditRecord.FromBytes: Problem reading Key")
+	}
+	if record.OldValueExists, err = ReadBoolByte(rr); err != nil {
+		return errors.Wrapf(err, "AdminKVAuditRecord.FromBytes: Problem reading OldValueExists")
+	}
+	if record.OldValue, err = DecodeByteArray(rr); err != nil {
+		return errors.Wrapf(err, "AdminKVAuditRecord.FromBytes: Problem reading OldValue")
+	}
+	if record.NewValue, err = DecodeByteArray(rr); err != nil {
+		return errors.Wrapf(err, "AdminKVAuditRecord.FromBytes: Problem reading NewValue")
+	}
+	reasonBytes, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "AdminKVAuditRecord.FromBytes: Problem reading Reason")
+	}
+	record.Reason = string(reasonBytes)
+
+	return nil
+}
+
+// _checkAdminKVInterlocks returns an error unless the options unlock a write to the key.
+func _checkAdminKVInterlocks(key []byte, opts *AdminKVOptions) error {
+	unlockToken, _ := adminKVUnlockToken.Load().(string)
+	if unlockToken == "" {
+		return fmt.Errorf("admin key/value writes are locked, set --admin-kv-unlock-token to unlock them")
+	}
+	if opts == nil || subtle.ConstantTimeCompare([]byte(opts.UnlockToken), []byte(unlockToken)) != 1 {
+		return fmt.Errorf("admin key/value writes are locked, the unlock token doesn't match")
+	}
+	if opts.Reason == "" {
+		return fmt.Errorf("a reason is required for the audit log")
+	}
+	if len(key) == 0 {
+		return fmt.Errorf("key is empty")
+	}
+	if bytes.HasPrefix(key, Prefixes.PrefixAdminKVAuditLog) {
+		return fmt.Errorf("the audit log can't be modified")
+	}
+	if isStateKey(key) && !opts.AllowStatePrefix {
+		return fmt.Errorf("key %v is under state prefix %v, set AllowStatePrefix to write it",
+			key, dbPrefixName(key[0]))
+	}
+	return nil
+}
+
+// AdminPutKV sets the key to the value and records the write in the audit log, in one txn. The
+// chainLock should be the Blockchain's ChainLock.
+func AdminPutKV(handle *badger.DB, snap *Snapshot, chainLock sync.Locker,
+	key []byte, value []byte, opts *AdminKVOptions) error {
+
+	if err := _checkAdminKVInterlocks(key, opts); err != nil {
+		return errors.Wrapf(err, "AdminPutKV: ")
+	}
+	return _adminWriteKV(handle, snap, chainLock, AdminKVOperationTypePut, key, value, opts)
+}
+
+// AdminDeleteKV deletes the key and records the delete in the audit log, in one txn. Deleting a key
+// that doesn't exist is an error, so that typos in the key don't go unnoticed.
+func AdminDeleteKV(handle *badger.DB, snap *Snapshot, chainLock sync.Locker,
+	key []byte, opts *AdminKVOptions) error {
+
+	if err := _checkAdminKVInterlocks(key, opts); err != nil {
+		return errors.Wrapf(err, "AdminDeleteKV: ")
+	}
+	return _adminWriteKV(handle, snap, chainLock, AdminKVOperationTypeDelete, key, nil, opts)
+}
+
+func _adminWriteKV(handle *badger.DB, snap *Snapshot, chainLock sync.Locker,
+	operationType AdminKVOperationType, key []byte, value []byte, opts *AdminKVOptions) error {
+
+	// Block connects flush the ancestral records the same way, so we hold the ChainLock for the
+	// whole write to keep them from interleaving with ours.
+	chainLock.Lock()
+	defer chainLock.Unlock()
+
+	// State writes update the ancestral records, which are flushed in the background once we're done.
+	if snap != nil {
+		snap.PrepareAncestralRecordsFlush()
+		defer snap.StartAncestralRecordsFlush(true)
+	}
+
+	err := handle.Update(func(txn *badger.Txn) error {
+		record := &AdminKVAuditRecord{
+			OperationType:  operationType,
+			TimestampNanos: uint64(time.Now().UnixNano()),
+			Key:            key,
+			NewValue:       value,
+			Reason:         opts.Reason,
+		}
+
+		oldValue, err := DBGetWithTxn(txn, snap, key)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return errors.Wrapf(err, "_adminWriteKV: Problem reading the current value of key %v", key)
+		}
+		if err == nil {
+			record.OldValueExists = true
+			record.OldValue = oldValue
+		}
+
+		switch operationType {
+		case AdminKVOperationTypePut:
+			if err := DBSetWithTxn(txn, snap, key, value); err != nil {
+				return errors.Wrapf(err, "AdminPutKV: ")
+			}
+		case AdminKVOperationTypeDelete:
+			if !record.OldValueExists {
+				return fmt.Errorf("AdminDeleteKV: Key %v doesn't exist", key)
+			}
+			if err := DBDeleteWithTxn(txn, snap, key); err != nil {
+				return errors.Wrapf(err, "AdminDeleteKV: ")
+			}
+		}
+
+		return DbPutAdminKVAuditRecordWithTxn(txn, snap, record)
+	})
+	// The write dropped the key from the NegativeLookupCache before the txn committed.
+	snap.InvalidateNegativeLookupsAfterCommit()
+	return err
+}
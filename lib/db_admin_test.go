@@ -0,0 +1,88 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminKV(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, db := NewLowDifficultyBlockchain()
+	snap := chain.snapshot
+	chainLock := &chain.ChainLock
+
+	// The admin APIs stay locked until the operator sets a token.
+	const unlockToken = "test-unlock-token"
+	opts := &AdminKVOptions{
+		UnlockToken: unlockToken,
+		Reason:      "remove corrupt key",
+	}
+	nonStateKey := append(append([]byte{}, Prefixes.PrefixBlockConnectProfileByHeightAndHash...), RandomBytes(8)...)
+	stateKey := append(append([]byte{}, Prefixes.PrefixPKIDToProfileEntry...), RandomBytes(33)...)
+	value := RandomBytes(16)
+
+	// The interlocks refuse the write without touching the db or the audit log.
+	require.Error(AdminPutKV(db, snap, chainLock, nonStateKey, value, opts))
+	SetAdminKVUnlockToken(unlockToken)
+	defer SetAdminKVUnlockToken("")
+	require.Error(AdminPutKV(db, snap, chainLock, nonStateKey, value, nil))
+	require.Error(AdminPutKV(db, snap, chainLock, nonStateKey, value, &AdminKVOptions{UnlockToken: "wrong", Reason: opts.Reason}))
+	require.Error(AdminPutKV(db, snap, chainLock, nonStateKey, value, &AdminKVOptions{UnlockToken: unlockToken}))
+	require.Error(AdminPutKV(db, snap, chainLock, []byte{}, value, opts))
+	require.Error(AdminPutKV(db, snap, chainLock, stateKey, value, opts))
+	require.Error(AdminPutKV(db, snap, chainLock, append([]byte{}, Prefixes.PrefixAdminKVAuditLog...), value, opts))
+	require.Error(AdminDeleteKV(db, snap, chainLock, nonStateKey, opts))
+	_, err := _dbGetForAdminKVTest(db, nonStateKey)
+	require.Equal(badger.ErrKeyNotFound, err)
+	records, err := DbGetAdminKVAuditRecords(db)
+	require.NoError(err)
+	require.Empty(records)
+
+	// Puts and deletes are applied and audited in order, with the previous value.
+	require.NoError(AdminPutKV(db, snap, chainLock, nonStateKey, value, opts))
+	storedValue, err := _dbGetForAdminKVTest(db, nonStateKey)
+	require.NoError(err)
+	require.Equal(value, storedValue)
+	require.NoError(AdminDeleteKV(db, snap, chainLock, nonStateKey, opts))
+	_, err = _dbGetForAdminKVTest(db, nonStateKey)
+	require.Equal(badger.ErrKeyNotFound, err)
+
+	records, err = DbGetAdminKVAuditRecords(db)
+	require.NoError(err)
+	require.Equal(2, len(records))
+	require.Equal(AdminKVOperationTypePut, records[0].OperationType)
+	require.Equal(nonStateKey, records[0].Key)
+	require.False(records[0].OldValueExists)
+	require.Equal(value, records[0].NewValue)
+	require.Equal(opts.Reason, records[0].Reason)
+	require.Equal(AdminKVOperationTypeDelete, records[1].OperationType)
+	require.True(records[1].OldValueExists)
+	require.Equal(value, records[1].OldValue)
+
+	// State keys can be written once explicitly allowed.
+	stateOpts := *opts
+	stateOpts.AllowStatePrefix = true
+	require.NoError(AdminPutKV(db, snap, chainLock, stateKey, value, &stateOpts))
+	storedValue, err = _dbGetForAdminKVTest(db, stateKey)
+	require.NoError(err)
+	require.Equal(value, storedValue)
+	records, err = DbGetAdminKVAuditRecords(db)
+	require.NoError(err)
+	require.Equal(3, len(records))
+}
+
+// _dbGetForAdminKVTest reads the key straight from the db, bypassing the snapshot caches.
+func _dbGetForAdminKVTest(db *badger.DB, key []byte) ([]byte, error) {
+	var value []byte
+	err := db.View(func(txn *badger.Txn) error {
+		var err error
+		value, err = DBGetWithTxn(txn, nil, key)
+		return err
+	})
+	return value, err
+}
@@ -0,0 +1,391 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/holiman/uint256"
+	"github.com/pkg/errors"
+)
+
+// KeyCodec builds and parses the keys of a db prefix, following the prefix's key_schema tag, so that
+// callers don't have to slice key bytes by hand. A schema lists the fields that follow the prefix,
+// e.g. "<PKID [33]byte, TstampNanos uint64>", and fields are encoded as follows:
+//   - [N]byte and BlockHash fields are the N, or 32, raw bytes.
+//   - uint8, uint32 and uint64 fields are big-endian, so that keys sort by them.
+//   - bool fields are one byte.
+//   - uint256 fields use EncodeUint256.
+//   - []byte fields are length-prefixed with EncodeByteArray, except for the last field of a
+//     key, which is just the remaining bytes.
+//
+// Fields whose values are transformed before they're put in the key, like the inverted block
+// height of PrefixDAOCoinLimitOrder, are encoded and decoded as they're stored.
+type KeyCodec struct {
+	Prefix []byte
+	Name   string
+	Fields []*KeyField
+}
+
+type KeyFieldType uint8
+
+const (
+	KeyFieldTypeFixedBytes KeyFieldType = iota
+	KeyFieldTypeUint8
+	KeyFieldTypeUint32
+	KeyFieldTypeUint64
+	KeyFieldTypeBool
+	KeyFieldTypeUint256
+	KeyFieldTypeBytes
+)
+
+// KeyField is one of the fields of a KeyCodec. Length is only set for fixed-length byte fields.
+type KeyField struct {
+	Name   string
+	Type   KeyFieldType
+	Length int
+}
+
+// NewKeyCodec parses the prefix's key schema.
+func NewKeyCodec(prefixInfo *DBPrefixInfo) (*KeyCodec, error) {
+	schema := strings.TrimSpace(prefixInfo.KeySchema)
+	if !strings.HasPrefix(schema, "<") || !strings.HasSuffix(schema, ">") {
+		return nil, fmt.Errorf("NewKeyCodec: Key schema %v of prefix %v isn't of the form <...>",
+			schema, prefixInfo.Name)
+	}
+	codec := &KeyCodec{
+		Prefix: prefixInfo.Prefix,
+		Name:   prefixInfo.Name,
+	}
+	schema = strings.TrimSpace(schema[1 : len(schema)-1])
+	if schema == "" {
+		return codec, nil
+	}
+	for _, fieldSpec := range strings.Split(schema, ",") {
+		field, err := _parseKeyField(strings.Fields(fieldSpec))
+		if err != nil {
+			return nil, errors.Wrapf(err, "NewKeyCodec: Problem parsing key schema of prefix %v", prefixInfo.Name)
+		}
+		codec.Fields = append(codec.Fields, field)
+	}
+	return codec, nil
+}
+
+// _parseKeyField parses a field given as its name followed by its type. A BlockHash field can
+// leave out its name, in which case it's named BlockHash.
+func _parseKeyField(tokens []string) (*KeyField, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("_parseKeyField: Empty field")
+	}
+	fieldType := tokens[len(tokens)-1]
+	name := strings.Join(tokens[:len(tokens)-1], " ")
+	if name == "" {
+		if fieldType != "BlockHash" {
+			return nil, fmt.Errorf("_parseKeyField: Field %v doesn't have a type", fieldType)
+		}
+		name = fieldType
+	}
+
+	field := &KeyField{Name: name}
+	switch {
+	case fieldType == "BlockHash":
+		field.Type, field.Length = KeyFieldTypeFixedBytes, HashSizeBytes
+	case fieldType == "uint8":
+		field.Type = KeyFieldTypeUint8
+	case fieldType == "uint32":
+		field.Type = KeyFieldTypeUint32
+	case fieldType == "uint64":
+		field.Type = KeyFieldTypeUint64
+	case fieldType == "bool":
+		field.Type = KeyFieldTypeBool
+	case fieldType == "uint256":
+		field.Type = KeyFieldTypeUint256
+	case fieldType == "[]byte":
+		field.Type = KeyFieldTypeBytes
+	case strings.HasPrefix(fieldType, "[") && strings.HasSuffix(fieldType, "]byte"):
+		length, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(fieldType, "["), "]byte"))
+		if err != nil || length <= 0 {
+			return nil, fmt.Errorf("_parseKeyField: Field %v has an invalid length", name)
+		}
+		field.Type, field.Length = KeyFieldTypeFixedBytes, length
+	default:
+		return nil, fmt.Errorf("_parseKeyField: Field %v has unknown type %v", name, fieldType)
+	}
+	return field, nil
+}
+
+var keyCodecsOnce sync.Once
+var keyCodecs map[byte]*KeyCodec
+
+// GetKeyCodec returns the KeyCodec of a prefix in PrefixRegistry.
+func GetKeyCodec(prefix []byte) (*KeyCodec, error) {
+	keyCodecsOnce.Do(func() {
+		keyCodecs = make(map[byte]*KeyCodec)
+		for _, prefixInfo := range PrefixRegistry.GetPrefixInfos() {
+			// The schemas are checked by the tests, so a prefix whose schema doesn't parse simply
+			// doesn't get a codec.
+			if codec, err := NewKeyCodec(prefixInfo); err == nil {
+				keyCodecs[prefixInfo.Prefix[0]] = codec
+			}
+		}
+	})
+	if len(prefix) == 0 {
+		return nil, fmt.Errorf("GetKeyCodec: Empty prefix")
+	}
+	codec, exists := keyCodecs[prefix[0]]
+	if !exists {
+		return nil, fmt.Errorf("GetKeyCodec: No codec for prefix %v", prefix)
+	}
+	return codec, nil
+}
+
+// MustGetKeyCodec is GetKeyCodec for the prefixes declared in DBPrefixes, whose codecs always exist.
+func MustGetKeyCodec(prefix []byte) *KeyCodec {
+	codec, err := GetKeyCodec(prefix)
+	if err != nil {
+		panic(any(err))
+	}
+	return codec
+}
+
+// Encode builds a key from the values of the fields, in schema order. Passing only the first few
+// fields returns the prefix to seek for all the keys that start with them. Fixed-length byte fields
+// take a []byte or any byte array type of the right length, like *PKID or BlockHash, and []byte
+// fields take a []byte or a string.
+func (codec *KeyCodec) Encode(fieldValues ...interface{}) ([]byte, error) {
+	if len(fieldValues) > len(codec.Fields) {
+		return nil, fmt.Errorf("KeyCodec.Encode: Prefix %v has %v fields but got %v values",
+			codec.Name, len(codec.Fields), len(fieldValues))
+	}
+	key := append([]byte{}, codec.Prefix...)
+	for ii, fieldValue := range fieldValues {
+		field := codec.Fields[ii]
+		isLastField := ii == len(codec.Fields)-1
+		fieldBytes, err := field.encode(fieldValue, isLastField)
+		if err != nil {
+			return nil, errors.Wrapf(err, "KeyCodec.Encode: Problem encoding field %v of prefix %v",
+				field.Name, codec.Name)
+		}
+		key = append(key, fieldBytes...)
+	}
+	return key, nil
+}
+
+// MustEncode is Encode for callers that pass values of the right types, which can't fail.
+func (codec *KeyCodec) MustEncode(fieldValues ...interface{}) []byte {
+	key, err := codec.Encode(fieldValues...)
+	if err != nil {
+		panic(any(err))
+	}
+	return key
+}
+
+func (field *KeyField) encode(fieldValue interface{}, isLastField bool) ([]byte, error) {
+	switch field.Type {
+	case KeyFieldTypeFixedBytes:
+		fieldBytes, ok := _keyFieldBytes(fieldValue)
+		if !ok {
+			return nil, fmt.Errorf("expected bytes, got %T", fieldValue)
+		}
+		if len(fieldBytes) != field.Length {
+			return nil, fmt.Errorf("expected %v bytes, got %v", field.Length, len(fieldBytes))
+		}
+		return fieldBytes, nil
+	case KeyFieldTypeUint8:
+		value, ok := fieldValue.(uint8)
+		if !ok {
+			return nil, fmt.Errorf("expected uint8, got %T", fieldValue)
+		}
+		return []byte{value}, nil
+	case KeyFieldTypeUint32:
+		value, ok := fieldValue.(uint32)
+		if !ok {
+			return nil, fmt.Errorf("expected uint32, got %T", fieldValue)
+		}
+		return _EncodeUint32(value), nil
+	case KeyFieldTypeUint64:
+		value, ok := fieldValue.(uint64)
+		if !ok {
+			return nil, fmt.Errorf("expected uint64, got %T", fieldValue)
+		}
+		return EncodeUint64(value), nil
+	case KeyFieldTypeBool:
+		value, ok := fieldValue.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected bool, got %T", fieldValue)
+		}
+		return []byte{BoolToByte(value)}, nil
+	case KeyFieldTypeUint256:
+		value, ok := fieldValue.(*uint256.Int)
+		if !ok {
+			return nil, fmt.Errorf("expected *uint256.Int, got %T", fieldValue)
+		}
+		return EncodeUint256(value), nil
+	case KeyFieldTypeBytes:
+		var fieldBytes []byte
+		if value, ok := fieldValue.(string); ok {
+			fieldBytes = []byte(value)
+		} else if fieldBytes, ok = fieldValue.([]byte); !ok {
+			return nil, fmt.Errorf("expected []byte or string, got %T", fieldValue)
+		}
+		if isLastField {
+			return fieldBytes, nil
+		}
+		return EncodeByteArray(fieldBytes), nil
+	default:
+		return nil, fmt.Errorf("unknown field type %v", field.Type)
+	}
+}
+
+// _keyFieldBytes returns the bytes of a []byte, a byte array, or a pointer to a byte array.
+func _keyFieldBytes(fieldValue interface{}) ([]byte, bool) {
+	if fieldBytes, ok := fieldValue.([]byte); ok {
+		return fieldBytes, true
+	}
+	value := reflect.ValueOf(fieldValue)
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil, false
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Array || value.Type().Elem().Kind() != reflect.Uint8 {
+		return nil, false
+	}
+	fieldBytes := make([]byte, value.Len())
+	reflect.Copy(reflect.ValueOf(fieldBytes), value)
+	return fieldBytes, true
+}
+
+// DecodedKey holds the fields of a key parsed by a KeyCodec. The accessors return the zero value
+// for names that aren't in the key's schema or that have a different type, so callers should use
+// the names from the schema.
+type DecodedKey struct {
+	Prefix []byte
+	Values map[string]interface{}
+}
+
+// Decode parses a key of the codec's prefix. It fails if the key doesn't have the prefix, or if its
+// length doesn't match the schema.
+func (codec *KeyCodec) Decode(key []byte) (*DecodedKey, error) {
+	if !bytes.HasPrefix(key, codec.Prefix) {
+		return nil, fmt.Errorf("KeyCodec.Decode: Key %v doesn't have prefix %v (%v)", key, codec.Name, codec.Prefix)
+	}
+	decodedKey := &DecodedKey{
+		Prefix: codec.Prefix,
+		Values: make(map[string]interface{}),
+	}
+	rr := bytes.NewReader(key[len(codec.Prefix):])
+	for ii, field := range codec.Fields {
+		isLastField := ii == len(codec.Fields)-1
+		value, err := field.decode(rr, isLastField)
+		if err != nil {
+			return nil, errors.Wrapf(err, "KeyCodec.Decode: Problem decoding field %v of prefix %v",
+				field.Name, codec.Name)
+		}
+		decodedKey.Values[field.Name] = value
+	}
+	if rr.Len() != 0 {
+		return nil, fmt.Errorf("KeyCodec.Decode: Key of prefix %v has %v extra bytes", codec.Name, rr.Len())
+	}
+	return decodedKey, nil
+}
+
+func (field *KeyField) decode(rr *bytes.Reader, isLastField bool) (interface{}, error) {
+	switch field.Type {
+	case KeyFieldTypeFixedBytes:
+		fieldBytes := make([]byte, field.Length)
+		if _, err := io.ReadFull(rr, fieldBytes); err != nil {
+			return nil, err
+		}
+		return fieldBytes, nil
+	case KeyFieldTypeUint8:
+		return rr.ReadByte()
+	case KeyFieldTypeUint32:
+		fieldBytes := make([]byte, 4)
+		if _, err := io.ReadFull(rr, fieldBytes); err != nil {
+			return nil, err
+		}
+		return DecodeUint32(fieldBytes), nil
+	case KeyFieldTypeUint64:
+		fieldBytes := make([]byte, 8)
+		if _, err := io.ReadFull(rr, fieldBytes); err != nil {
+			return nil, err
+		}
+		return DecodeUint64(fieldBytes), nil
+	case KeyFieldTypeBool:
+		return ReadBoolByte(rr)
+	case KeyFieldTypeUint256:
+		return DecodeUint256(rr)
+	case KeyFieldTypeBytes:
+		if !isLastField {
+			return DecodeByteArray(rr)
+		}
+		fieldBytes := make([]byte, rr.Len())
+		if _, err := io.ReadFull(rr, fieldBytes); err != nil {
+			return nil, err
+		}
+		return fieldBytes, nil
+	default:
+		return nil, fmt.Errorf("unknown field type %v", field.Type)
+	}
+}
+
+// Bytes returns the value of a byte field. The slice doesn't alias the decoded key.
+func (decodedKey *DecodedKey) Bytes(name string) []byte {
+	fieldBytes, _ := decodedKey.Values[name].([]byte)
+	return fieldBytes
+}
+
+func (decodedKey *DecodedKey) PKID(name string) *PKID {
+	fieldBytes := decodedKey.Bytes(name)
+	if len(fieldBytes) != PublicKeyLenCompressed {
+		return nil
+	}
+	return NewPKID(fieldBytes)
+}
+
+func (decodedKey *DecodedKey) PublicKey(name string) *PublicKey {
+	fieldBytes := decodedKey.Bytes(name)
+	if len(fieldBytes) != PublicKeyLenCompressed {
+		return nil
+	}
+	return NewPublicKey(fieldBytes)
+}
+
+func (decodedKey *DecodedKey) BlockHash(name string) *BlockHash {
+	fieldBytes := decodedKey.Bytes(name)
+	if len(fieldBytes) != HashSizeBytes {
+		return nil
+	}
+	return NewBlockHash(fieldBytes)
+}
+
+func (decodedKey *DecodedKey) Uint8(name string) uint8 {
+	value, _ := decodedKey.Values[name].(uint8)
+	return value
+}
+
+func (decodedKey *DecodedKey) Uint32(name string) uint32 {
+	value, _ := decodedKey.Values[name].(uint32)
+	return value
+}
+
+func (decodedKey *DecodedKey) Uint64(name string) uint64 {
+	value, _ := decodedKey.Values[name].(uint64)
+	return value
+}
+
+func (decodedKey *DecodedKey) Bool(name string) bool {
+	value, _ := decodedKey.Values[name].(bool)
+	return value
+}
+
+func (decodedKey *DecodedKey) Uint256(name string) *uint256.Int {
+	value, _ := decodedKey.Values[name].(*uint256.Int)
+	return value
+}
@@ -0,0 +1,110 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyCodec(t *testing.T) {
+	require := require.New(t)
+
+	// Every prefix has a codec, and random keys round-trip through it.
+	randomFieldValue := func(field *KeyField) interface{} {
+		switch field.Type {
+		case KeyFieldTypeFixedBytes:
+			return RandomBytes(int32(field.Length))
+		case KeyFieldTypeUint8:
+			return uint8(rand.Intn(256))
+		case KeyFieldTypeUint32:
+			return rand.Uint32()
+		case KeyFieldTypeUint64:
+			return rand.Uint64()
+		case KeyFieldTypeBool:
+			return rand.Intn(2) == 1
+		case KeyFieldTypeUint256:
+			return uint256.NewInt().SetBytes(RandomBytes(int32(rand.Intn(33))))
+		default:
+			return RandomBytes(int32(rand.Intn(64)))
+		}
+	}
+	for _, prefixInfo := range PrefixRegistry.GetPrefixInfos() {
+		codec, err := GetKeyCodec(prefixInfo.Prefix)
+		require.NoError(err, prefixInfo.Name)
+		require.Equal(prefixInfo.Prefix, codec.Prefix)
+
+		for ii := 0; ii < 10; ii++ {
+			var fieldValues []interface{}
+			for _, field := range codec.Fields {
+				fieldValues = append(fieldValues, randomFieldValue(field))
+			}
+			key, err := codec.Encode(fieldValues...)
+			require.NoError(err, prefixInfo.Name)
+			decodedKey, err := codec.Decode(key)
+			require.NoError(err, prefixInfo.Name)
+			for jj, field := range codec.Fields {
+				require.Equal(fieldValues[jj], decodedKey.Values[field.Name], "%v %v", prefixInfo.Name, field.Name)
+			}
+
+			// Encoding the first fields gives a prefix of the key.
+			if len(fieldValues) > 0 {
+				seekKey, err := codec.Encode(fieldValues[:len(fieldValues)-1]...)
+				require.NoError(err, prefixInfo.Name)
+				require.Equal(key[:len(seekKey)], seekKey, prefixInfo.Name)
+			}
+
+			// Truncated and extended keys are rejected.
+			if len(key) > len(codec.Prefix) && len(codec.Fields) > 0 &&
+				codec.Fields[len(codec.Fields)-1].Type != KeyFieldTypeBytes {
+				_, err = codec.Decode(key[:len(key)-1])
+				require.Error(err, prefixInfo.Name)
+				_, err = codec.Decode(append(key, 0))
+				require.Error(err, prefixInfo.Name)
+			}
+		}
+	}
+
+	// The codecs build the same keys as the db functions, and parse them back.
+	pkid := NewPKID(m0PkBytes)
+	postHash := NewBlockHash(RandomBytes(HashSizeBytes))
+	followCodec := MustGetKeyCodec(Prefixes.PrefixFollowerPKIDToFollowedPKID)
+	followKey := followCodec.MustEncode(pkid, NewPKID(m1PkBytes))
+	require.Equal(_dbKeyForFollowerToFollowedMapping(pkid, NewPKID(m1PkBytes)), followKey)
+	decodedFollowKey, err := followCodec.Decode(followKey)
+	require.NoError(err)
+	require.Equal(pkid, decodedFollowKey.PKID("FollowerPKID"))
+	require.Equal(NewPKID(m1PkBytes), decodedFollowKey.PKID("FollowedPKID"))
+
+	bidEntry := &NFTBidEntry{
+		BidderPKID:     pkid,
+		NFTPostHash:    postHash,
+		SerialNumber:   3,
+		BidAmountNanos: 1000,
+	}
+	bidCodec := MustGetKeyCodec(Prefixes.PrefixPostHashSerialNumberBidNanosBidderPKID)
+	require.Equal(_dbKeyForNFTPostHashSerialNumberBidNanosBidderPKID(bidEntry),
+		bidCodec.MustEncode(postHash, uint64(3), uint64(1000), pkid))
+	require.Equal(_dbSeekKeyForNFTBids(postHash, 3), bidCodec.MustEncode(postHash, uint64(3)))
+
+	// Values of the wrong type or length are rejected.
+	_, err = followCodec.Encode(pkid, uint64(1))
+	require.Error(err)
+	_, err = followCodec.Encode(m0PkBytes[:10])
+	require.Error(err)
+	_, err = followCodec.Encode(pkid, pkid, pkid)
+	require.Error(err)
+	_, err = followCodec.Decode(MustGetKeyCodec(Prefixes.PrefixFollowedPKIDToFollowerPKID).MustEncode(pkid, pkid))
+	require.Error(err)
+
+	// Schemas that don't parse are rejected.
+	_, err = NewKeyCodec(&DBPrefixInfo{Prefix: []byte{255}, Name: "PrefixNew", KeySchema: "<Amount float64>"})
+	require.Error(err)
+	_, err = NewKeyCodec(&DBPrefixInfo{Prefix: []byte{255}, Name: "PrefixNew", KeySchema: "<PKID>"})
+	require.Error(err)
+	_, err = GetKeyCodec([]byte{255})
+	require.Error(err)
+}
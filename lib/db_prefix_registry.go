@@ -0,0 +1,175 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// DBPrefixInfo describes a db prefix, as declared by the tags of its DBPrefixes field.
+type DBPrefixInfo struct {
+	Prefix []byte
+	// Name is the name of the DBPrefixes field.
+	Name string
+	// IsState is set for the prefixes that are part of the snapshot and hypersync.
+	IsState bool
+	// IsTxIndex is set for the prefixes of the txindex.
+	IsTxIndex bool
+	// MaxValueSize is the default size limit for the prefix's values, or 0 if they aren't limited.
+	// See CheckDBValueSize.
+	MaxValueSize int
+	// KeySchema lists the fields that follow the prefix in the prefix's keys, e.g.
+	// "<PKID [33]byte>", or "<>" for a prefix that's a key by itself.
+	KeySchema string
+}
+
+// ErrDBPrefixCollision is returned when a prefix is registered with the id or the name of a
+// prefix that's already registered.
+type ErrDBPrefixCollision struct {
+	Prefix       []byte
+	Name         string
+	ExistingName string
+}
+
+func (err *ErrDBPrefixCollision) Error() string {
+	return fmt.Sprintf("Prefix %v (%v) collides with prefix %v. Give it a new prefix_id, see NEXT_TAG",
+		err.Name, err.Prefix, err.ExistingName)
+}
+
+// IsErrDBPrefixCollision returns true if the error, or any error it wraps, is an
+// ErrDBPrefixCollision.
+func IsErrDBPrefixCollision(err error) bool {
+	var collisionErr *ErrDBPrefixCollision
+	return errors.As(err, &collisionErr)
+}
+
+// DBPrefixRegistry holds the DBPrefixInfo of every db prefix, and refuses prefixes whose id or name
+// is already taken. StatePrefixes is derived from it.
+type DBPrefixRegistry struct {
+	prefixInfos     map[byte]*DBPrefixInfo
+	prefixInfoNames map[string]*DBPrefixInfo
+}
+
+func NewDBPrefixRegistry() *DBPrefixRegistry {
+	return &DBPrefixRegistry{
+		prefixInfos:     make(map[byte]*DBPrefixInfo),
+		prefixInfoNames: make(map[string]*DBPrefixInfo),
+	}
+}
+
+// Register adds the prefix to the registry. It returns an ErrDBPrefixCollision if the prefix's id
+// or name is already registered.
+func (registry *DBPrefixRegistry) Register(prefixInfo *DBPrefixInfo) error {
+	if len(prefixInfo.Prefix) == 0 || len(prefixInfo.Prefix) > MaxPrefixLen {
+		return fmt.Errorf("DBPrefixRegistry.Register: Prefix %v has length %v but prefixes must have "+
+			"length 1 to MaxPrefixLen (%v)", prefixInfo.Name, len(prefixInfo.Prefix), MaxPrefixLen)
+	}
+	if prefixInfo.IsState && prefixInfo.IsTxIndex {
+		return fmt.Errorf("DBPrefixRegistry.Register: Prefix %v can't be both a state and a txindex "+
+			"prefix", prefixInfo.Name)
+	}
+	if prefixInfo.KeySchema == "" {
+		return fmt.Errorf("DBPrefixRegistry.Register: Prefix %v doesn't have a key schema", prefixInfo.Name)
+	}
+	if existingInfo, exists := registry.prefixInfos[prefixInfo.Prefix[0]]; exists {
+		return &ErrDBPrefixCollision{
+			Prefix: prefixInfo.Prefix, Name: prefixInfo.Name, ExistingName: existingInfo.Name}
+	}
+	if existingInfo, exists := registry.prefixInfoNames[prefixInfo.Name]; exists {
+		return &ErrDBPrefixCollision{
+			Prefix: prefixInfo.Prefix, Name: prefixInfo.Name, ExistingName: existingInfo.Name}
+	}
+	registry.prefixInfos[prefixInfo.Prefix[0]] = prefixInfo
+	registry.prefixInfoNames[prefixInfo.Name] = prefixInfo
+	return nil
+}
+
+// GetPrefixInfo returns the DBPrefixInfo of the prefix, if it's registered.
+func (registry *DBPrefixRegistry) GetPrefixInfo(prefix byte) (*DBPrefixInfo, bool) {
+	prefixInfo, exists := registry.prefixInfos[prefix]
+	return prefixInfo, exists
+}
+
+// GetPrefixInfos returns the DBPrefixInfo of every registered prefix, sorted by prefix.
+func (registry *DBPrefixRegistry) GetPrefixInfos() []*DBPrefixInfo {
+	prefixInfos := []*DBPrefixInfo{}
+	for _, prefixInfo := range registry.prefixInfos {
+		prefixInfos = append(prefixInfos, prefixInfo)
+	}
+	sort.Slice(prefixInfos, func(ii, jj int) bool {
+		return bytes.Compare(prefixInfos[ii].Prefix, prefixInfos[jj].Prefix) < 0
+	})
+	return prefixInfos
+}
+
+// StatePrefixes returns the state prefixes, sorted.
+func (registry *DBPrefixRegistry) StatePrefixes() [][]byte {
+	return registry.filterPrefixes(func(prefixInfo *DBPrefixInfo) bool { return prefixInfo.IsState })
+}
+
+// NonStatePrefixes returns the prefixes that aren't state prefixes, including the txindex
+// prefixes, sorted.
+func (registry *DBPrefixRegistry) NonStatePrefixes() [][]byte {
+	return registry.filterPrefixes(func(prefixInfo *DBPrefixInfo) bool { return !prefixInfo.IsState })
+}
+
+// TxIndexPrefixes returns the txindex prefixes, sorted.
+func (registry *DBPrefixRegistry) TxIndexPrefixes() [][]byte {
+	return registry.filterPrefixes(func(prefixInfo *DBPrefixInfo) bool { return prefixInfo.IsTxIndex })
+}
+
+func (registry *DBPrefixRegistry) filterPrefixes(include func(prefixInfo *DBPrefixInfo) bool) [][]byte {
+	prefixes := [][]byte{}
+	for _, prefixInfo := range registry.GetPrefixInfos() {
+		if include(prefixInfo) {
+			prefixes = append(prefixes, prefixInfo.Prefix)
+		}
+	}
+	return prefixes
+}
+
+// GetDBPrefixRegistry registers every DBPrefixes field, using its prefix_id, is_state, is_txindex,
+// max_value_size and key_schema tags. It returns an ErrDBPrefixCollision if two fields have the
+// same prefix_id.
+func GetDBPrefixRegistry() (*DBPrefixRegistry, error) {
+	registry := NewDBPrefixRegistry()
+
+	prefixElements := reflect.ValueOf(&DBPrefixes{}).Elem()
+	structFields := prefixElements.Type()
+	for i := 0; i < structFields.NumField(); i++ {
+		structField := structFields.Field(i)
+		prefixInfo := &DBPrefixInfo{
+			Prefix:    getPrefixIdValue(structField, prefixElements.Field(i).Type()).Bytes(),
+			Name:      structField.Name,
+			IsState:   structField.Tag.Get("is_state") == "true",
+			IsTxIndex: structField.Tag.Get("is_txindex") == "true",
+			KeySchema: structField.Tag.Get("key_schema"),
+		}
+		if value := structField.Tag.Get("max_value_size"); value != "" {
+			maxValueSize, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "GetDBPrefixRegistry: Prefix %v has an invalid max_value_size",
+					structField.Name)
+			}
+			prefixInfo.MaxValueSize = maxValueSize
+		}
+		if err := registry.Register(prefixInfo); err != nil {
+			return nil, errors.Wrapf(err, "GetDBPrefixRegistry: ")
+		}
+	}
+	return registry, nil
+}
+
+// mustGetDBPrefixRegistry makes the node fail at startup, before it opens its db, if the DBPrefixes
+// fields collide.
+func mustGetDBPrefixRegistry() *DBPrefixRegistry {
+	registry, err := GetDBPrefixRegistry()
+	if err != nil {
+		panic(any(err))
+	}
+	return registry
+}
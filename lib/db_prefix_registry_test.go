@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBPrefixRegistry(t *testing.T) {
+	require := require.New(t)
+
+	// Every DBPrefixes field is registered, and StatePrefixes agrees with the registry.
+	registry, err := GetDBPrefixRegistry()
+	require.NoError(err)
+	prefixInfos := registry.GetPrefixInfos()
+	require.Len(prefixInfos, reflect.TypeOf(DBPrefixes{}).NumField())
+	require.Equal(StatePrefixes.StatePrefixesList, registry.StatePrefixes())
+	require.Equal(StatePrefixes.NonStatePrefixesList, registry.NonStatePrefixes())
+	require.Equal(len(prefixInfos), len(StatePrefixes.StatePrefixesList)+len(StatePrefixes.NonStatePrefixesList))
+
+	postInfo, exists := registry.GetPrefixInfo(Prefixes.PrefixPostHashToPostEntry[0])
+	require.True(exists)
+	require.Equal(&DBPrefixInfo{
+		Prefix:       Prefixes.PrefixPostHashToPostEntry,
+		Name:         "PrefixPostHashToPostEntry",
+		IsState:      true,
+		MaxValueSize: 16000000,
+		KeySchema:    "<PostHash [32]byte>",
+	}, postInfo)
+
+	// Prefixes with a taken id or name are refused.
+	err = registry.Register(&DBPrefixInfo{
+		Prefix: []byte{Prefixes.PrefixAuthorizeDerivedKey[0]}, Name: "PrefixNew", KeySchema: "<>"})
+	require.True(IsErrDBPrefixCollision(err))
+	require.Contains(err.Error(), "PrefixAuthorizeDerivedKey")
+	err = registry.Register(&DBPrefixInfo{Prefix: []byte{255}, Name: "PrefixAuthorizeDerivedKey", KeySchema: "<>"})
+	require.True(IsErrDBPrefixCollision(err))
+
+	// So are prefixes without a key schema.
+	err = registry.Register(&DBPrefixInfo{Prefix: []byte{255}, Name: "PrefixNew"})
+	require.Error(err)
+	require.False(IsErrDBPrefixCollision(err))
+	require.NoError(registry.Register(&DBPrefixInfo{Prefix: []byte{255}, Name: "PrefixNew", KeySchema: "<>"}))
+}
@@ -0,0 +1,246 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// ErrSnapshotExpired is returned when a read is made at a generation that's no longer retained.
+// Callers paginating through results should start over from the first page.
+var ErrSnapshotExpired = errors.New("read generation expired, restart from the first page")
+
+// -------------------------------------------------------------------------------------
+// ReadGenerationManager
+// -------------------------------------------------------------------------------------
+
+// ReadGenerationManager lets paginated reads see the same view of the db across requests, even if
+// the db is flushed in between pages. A read generation is a read-only badger txn, which sees the db
+// exactly as it was when the txn was opened, and its ID is the txn's read timestamp. Since badger
+// bumps the read timestamp on every commit, a new generation is created the first time it's pinned
+// after a write. The manager retains the MaxGenerations most recent generations, and never keeps a
+// generation for longer than MaxGenerationAge so that old versions of records don't pile up in badger.
+type ReadGenerationManager struct {
+	db *badger.DB
+
+	MaxGenerations   int
+	MaxGenerationAge time.Duration
+
+	mtx sync.Mutex
+	// generations is ordered from oldest to newest.
+	generations []*readGeneration
+}
+
+type readGeneration struct {
+	id        uint64
+	txn       *badger.Txn
+	createdAt time.Time
+
+	// badger txns aren't safe for concurrent use so reads at the same generation are serialized.
+	txnLock sync.Mutex
+	// expired is set once the generation is evicted. The txn is discarded once the last reader is done.
+	expired  bool
+	numReads int
+}
+
+func NewReadGenerationManager(db *badger.DB) *ReadGenerationManager {
+	return &ReadGenerationManager{
+		db:               db,
+		MaxGenerations:   ReadGenerationRetentionCount,
+		MaxGenerationAge: ReadGenerationMaxAge,
+	}
+}
+
+// PinCurrentGeneration returns the ID of a generation that reflects the current state of the db.
+func (manager *ReadGenerationManager) PinCurrentGeneration() uint64 {
+	manager.mtx.Lock()
+	defer manager.mtx.Unlock()
+
+	manager._evictExpiredGenerations()
+
+	txn := manager.db.NewTransaction(false)
+	if len(manager.generations) > 0 {
+		latest := manager.generations[len(manager.generations)-1]
+		if latest.id == txn.ReadTs() {
+			// Nothing was written since the latest generation so we just reuse it.
+			txn.Discard()
+			return latest.id
+		}
+	}
+	manager.generations = append(manager.generations, &readGeneration{
+		id:        txn.ReadTs(),
+		txn:       txn,
+		createdAt: time.Now(),
+	})
+	manager._evictExpiredGenerations()
+	return txn.ReadTs()
+}
+
+// ViewAtGeneration runs fn with a read-only txn that sees the db as of the given generation. It
+// returns ErrSnapshotExpired if the generation is no longer retained.
+func (manager *ReadGenerationManager) ViewAtGeneration(generationID uint64, fn func(txn *badger.Txn) error) error {
+	manager.mtx.Lock()
+	manager._evictExpiredGenerations()
+	var generation *readGeneration
+	for _, gen := range manager.generations {
+		if gen.id == generationID {
+			generation = gen
+			break
+		}
+	}
+	if generation == nil {
+		manager.mtx.Unlock()
+		return ErrSnapshotExpired
+	}
+	generation.numReads++
+	manager.mtx.Unlock()
+
+	generation.txnLock.Lock()
+	err := fn(generation.txn)
+	generation.txnLock.Unlock()
+
+	manager.mtx.Lock()
+	generation.numReads--
+	if generation.expired && generation.numReads == 0 {
+		generation.txn.Discard()
+	}
+	manager.mtx.Unlock()
+	return err
+}
+
+// Close discards all retained generations.
+func (manager *ReadGenerationManager) Close() {
+	manager.mtx.Lock()
+	defer manager.mtx.Unlock()
+
+	for _, generation := range manager.generations {
+		manager._expireGeneration(generation)
+	}
+	manager.generations = nil
+}
+
+// _evictExpiredGenerations should be called with the manager's mtx held.
+func (manager *ReadGenerationManager) _evictExpiredGenerations() {
+	numToEvict := 0
+	for ii, generation := range manager.generations {
+		if len(manager.generations)-ii > manager.MaxGenerations ||
+			(manager.MaxGenerationAge > 0 && time.Since(generation.createdAt) > manager.MaxGenerationAge) {
+			numToEvict = ii + 1
+		}
+	}
+	for _, generation := range manager.generations[:numToEvict] {
+		manager._expireGeneration(generation)
+	}
+	manager.generations = manager.generations[numToEvict:]
+}
+
+// _expireGeneration should be called with the manager's mtx held.
+func (manager *ReadGenerationManager) _expireGeneration(generation *readGeneration) {
+	generation.expired = true
+	if generation.numReads == 0 {
+		generation.txn.Discard()
+	}
+}
+
+// -------------------------------------------------------------------------------------
+// Paginated reads
+// -------------------------------------------------------------------------------------
+
+// ReadPageToken is handed out with every page of a paginated read. It pins the generation the first
+// page was read at, so that all the following pages are read from the same view of the db.
+type ReadPageToken struct {
+	GenerationID uint64
+	// LastKey is the last key returned in the previous page. The next page starts right after it.
+	LastKey []byte
+}
+
+func (token *ReadPageToken) ToBytes() []byte {
+	var data []byte
+	data = append(data, UintToBuf(token.GenerationID)...)
+	data = append(data, EncodeByteArray(token.LastKey)...)
+	return data
+}
+
+func (token *ReadPageToken) FromBytes(rr *bytes.Reader) error {
+	var err error
+	token.GenerationID, err = ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "ReadPageToken.FromBytes: Problem reading GenerationID")
+	}
+	token.LastKey, err = DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "ReadPageToken.FromBytes: Problem reading LastKey")
+	}
+	return nil
+}
+
+// DBPaginatePrefixAtGeneration returns up to limit records under the prefix. Pass a nil pageToken to get
+// the first page, which pins the current generation, and the returned token to get the following pages.
+// A nil token is returned once there are no more records. If the generation the pagination started at
+// has since been evicted, ErrSnapshotExpired is returned.
+func DBPaginatePrefixAtGeneration(manager *ReadGenerationManager, prefix []byte, pageToken []byte,
+	limit int) (_entries []*DBEntry, _nextPageToken []byte, _err error) {
+
+	if limit <= 0 {
+		return nil, nil, fmt.Errorf("DBPaginatePrefixAtGeneration: Limit must be positive, got %v", limit)
+	}
+
+	token := &ReadPageToken{}
+	startKey := prefix
+	if len(pageToken) > 0 {
+		if err := token.FromBytes(bytes.NewReader(pageToken)); err != nil {
+			return nil, nil, errors.Wrapf(err, "DBPaginatePrefixAtGeneration: Problem parsing page token")
+		}
+		if !bytes.HasPrefix(token.LastKey, prefix) {
+			return nil, nil, fmt.Errorf("DBPaginatePrefixAtGeneration: Page token key %v doesn't "+
+				"have prefix %v", token.LastKey, prefix)
+		}
+		// The smallest key that comes after LastKey.
+		startKey = append(append([]byte{}, token.LastKey...), 0x00)
+	} else {
+		token.GenerationID = manager.PinCurrentGeneration()
+	}
+
+	var entries []*DBEntry
+	hasMore := false
+	err := manager.ViewAtGeneration(token.GenerationID, func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(startKey); it.ValidForPrefix(prefix); it.Next() {
+			if len(entries) == limit {
+				hasMore = true
+				break
+			}
+			item := it.Item()
+			err := item.Value(func(value []byte) error {
+				entries = append(entries, KeyValueToDBEntry(item.Key(), value))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == ErrSnapshotExpired {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "DBPaginatePrefixAtGeneration: Problem reading page")
+	}
+
+	if !hasMore {
+		return entries, nil, nil
+	}
+	nextToken := &ReadPageToken{
+		GenerationID: token.GenerationID,
+		LastKey:      entries[len(entries)-1].Key,
+	}
+	return entries, nextToken.ToBytes(), nil
+}
@@ -0,0 +1,105 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaginatePrefixAtGeneration(t *testing.T) {
+	require := require.New(t)
+
+	db, _ := GetTestBadgerDb()
+	defer db.Close()
+
+	prefix := []byte{0xfe}
+	setRecord := func(key byte, value byte) {
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			return txn.Set(append(append([]byte{}, prefix...), key), []byte{value})
+		}))
+	}
+	deleteRecord := func(key byte) {
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			return txn.Delete(append(append([]byte{}, prefix...), key))
+		}))
+	}
+	for ii := byte(0); ii < 5; ii++ {
+		setRecord(ii, ii)
+	}
+
+	manager := NewReadGenerationManager(db)
+	defer manager.Close()
+
+	// Mutate the db in between every page. All the pages should still come from the first view.
+	var allEntries []*DBEntry
+	var pageToken []byte
+	numPages := 0
+	for {
+		entries, nextPageToken, err := DBPaginatePrefixAtGeneration(manager, prefix, pageToken, 2)
+		require.NoError(err)
+		allEntries = append(allEntries, entries...)
+		numPages++
+		if nextPageToken == nil {
+			break
+		}
+		pageToken = nextPageToken
+
+		deleteRecord(byte(4 - numPages))
+		setRecord(byte(10+numPages), 0xff)
+	}
+	require.Equal(3, numPages)
+	require.Equal(5, len(allEntries))
+	for ii, entry := range allEntries {
+		require.Equal(append(append([]byte{}, prefix...), byte(ii)), entry.Key)
+		require.Equal([]byte{byte(ii)}, entry.Value)
+	}
+
+	// A new pagination sees the latest state of the db.
+	entries, nextPageToken, err := DBPaginatePrefixAtGeneration(manager, prefix, nil, 10)
+	require.NoError(err)
+	require.Nil(nextPageToken)
+	require.Equal(5, len(entries))
+}
+
+func TestReadGenerationExpiry(t *testing.T) {
+	require := require.New(t)
+
+	db, _ := GetTestBadgerDb()
+	defer db.Close()
+
+	prefix := []byte{0xfe}
+	for ii := byte(0); ii < 4; ii++ {
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			return txn.Set([]byte{prefix[0], ii}, []byte{ii})
+		}))
+	}
+
+	manager := NewReadGenerationManager(db)
+	manager.MaxGenerations = 2
+	defer manager.Close()
+
+	_, pageToken, err := DBPaginatePrefixAtGeneration(manager, prefix, nil, 2)
+	require.NoError(err)
+	require.NotNil(pageToken)
+
+	// Pinning without any writes in between reuses the latest generation.
+	firstGeneration := manager.PinCurrentGeneration()
+	require.Equal(firstGeneration, manager.PinCurrentGeneration())
+
+	// Push the first generation out of the retention window.
+	for ii := byte(0); ii < 2; ii++ {
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			return txn.Set([]byte{prefix[0], 0x10 + ii}, []byte{ii})
+		}))
+		require.NotEqual(firstGeneration, manager.PinCurrentGeneration())
+	}
+
+	_, _, err = DBPaginatePrefixAtGeneration(manager, prefix, pageToken, 2)
+	require.Equal(ErrSnapshotExpired, err)
+	require.Equal(ErrSnapshotExpired, manager.ViewAtGeneration(firstGeneration, func(txn *badger.Txn) error {
+		return nil
+	}))
+}
@@ -0,0 +1,209 @@
+package lib
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// DB stats keep a key count and an approximate size for every prefix of the db, so that callers can read
+// them with GetDBStats instead of scanning the db. StartDBStats seeds them with a single scan, and from then
+// on DBSetWithTxn, DBDeleteWithTxn and their batch versions update them as records are written. They're off
+// by default, in which case the only overhead on the write paths is a single atomic load.
+//
+// When they're on, every write looks up the size of the record it replaces. The stats are updated when the
+// write is made rather than when its txn commits, so a txn that's discarded after writing throws them off,
+// as do writes that don't go through the functions above, e.g. DBDeleteAllStateRecords. They also count the
+// writes made to any db in the process, not just the one they were seeded from. Calling StartDBStats again
+// rescans the db and corrects them.
+
+var dbStatsEnabled int32
+
+// dbStatsByPrefix holds the key count and size of every prefix, indexed by the prefix byte. Its fields are
+// only accessed atomically.
+var dbStatsByPrefix [256]struct {
+	numKeys  int64
+	numBytes int64
+}
+
+// DBPrefixStats is the key count and size of a db prefix.
+type DBPrefixStats struct {
+	Prefix []byte
+	// Name is the name of the prefix's DBPrefixes field, or "Unknown" if it doesn't have one.
+	Name    string
+	NumKeys int64
+	// NumBytes is the total size of the prefix's keys and values.
+	NumBytes int64
+}
+
+// StartDBStats scans the db to seed the stats, and turns them on. If the stats are already on, it
+// recounts them.
+func StartDBStats(db *badger.DB) error {
+	// The stats are turned on before the scan so that the writes made during the scan are counted.
+	atomic.StoreInt32(&dbStatsEnabled, 0)
+	for ii := range dbStatsByPrefix {
+		atomic.StoreInt64(&dbStatsByPrefix[ii].numKeys, 0)
+		atomic.StoreInt64(&dbStatsByPrefix[ii].numBytes, 0)
+	}
+	atomic.StoreInt32(&dbStatsEnabled, 1)
+
+	var numKeys, numBytes [256]int64
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		nodeIterator := txn.NewIterator(opts)
+		defer nodeIterator.Close()
+		for nodeIterator.Rewind(); nodeIterator.Valid(); nodeIterator.Next() {
+			item := nodeIterator.Item()
+			key := item.Key()
+			if len(key) == 0 {
+				continue
+			}
+			numKeys[key[0]]++
+			numBytes[key[0]] += int64(len(key)) + item.ValueSize()
+		}
+		return nil
+	})
+	if err != nil {
+		StopDBStats()
+		return errors.Wrapf(err, "StartDBStats: Problem counting db keys")
+	}
+	for ii := range dbStatsByPrefix {
+		atomic.AddInt64(&dbStatsByPrefix[ii].numKeys, numKeys[ii])
+		atomic.AddInt64(&dbStatsByPrefix[ii].numBytes, numBytes[ii])
+	}
+	return nil
+}
+
+// StopDBStats turns the stats off. GetDBStats returns nil until they're started again.
+func StopDBStats() {
+	atomic.StoreInt32(&dbStatsEnabled, 0)
+}
+
+func IsDBStatsEnabled() bool {
+	return atomic.LoadInt32(&dbStatsEnabled) == 1
+}
+
+// GetDBStats returns the stats of every prefix that has keys, sorted by prefix, or nil if the stats are off.
+func GetDBStats() []*DBPrefixStats {
+	if !IsDBStatsEnabled() {
+		return nil
+	}
+	var prefixStats []*DBPrefixStats
+	for ii := range dbStatsByPrefix {
+		numKeys := atomic.LoadInt64(&dbStatsByPrefix[ii].numKeys)
+		if numKeys <= 0 {
+			continue
+		}
+		prefixStats = append(prefixStats, &DBPrefixStats{
+			Prefix:   []byte{byte(ii)},
+			Name:     dbPrefixName(byte(ii)),
+			NumKeys:  numKeys,
+			NumBytes: atomic.LoadInt64(&dbStatsByPrefix[ii].numBytes),
+		})
+	}
+	return prefixStats
+}
+
+func LogDBStats() {
+	prefixStats := GetDBStats()
+	if prefixStats == nil {
+		glog.Infof("LogDBStats: DB stats are off")
+		return
+	}
+	summary := ""
+	for _, stats := range prefixStats {
+		summary += fmt.Sprintf("\n\t%v %v: %v keys, %v bytes", stats.Prefix, stats.Name, stats.NumKeys, stats.NumBytes)
+	}
+	glog.Infof("LogDBStats: Current DB stats:%v", summary)
+}
+
+// StartDBSummarySnapshots starts the stats and logs them every 30 seconds.
+func StartDBSummarySnapshots(db *badger.DB) {
+	glog.Info("StartDBSummarySnapshots: Counting DB keys...")
+	if err := StartDBStats(db); err != nil {
+		glog.Errorf("StartDBSummarySnapshots: %v", err)
+		return
+	}
+	go func() {
+		for {
+			LogDBStats()
+			time.Sleep(30 * time.Second)
+		}
+	}()
+}
+
+// dbStatsRecord holds the size of a record before it's written, so that the stats can be updated once the
+// write succeeds. All of its methods are safe to call on a nil *dbStatsRecord, which is what the write paths
+// get when the stats are off.
+type dbStatsRecord struct {
+	key       []byte
+	existed   bool
+	prevBytes int64
+}
+
+// _prepareDBStatsRecord looks up the current size of the record. It has to be called before the record is
+// written.
+func _prepareDBStatsRecord(txn DeSoDBTxn, key []byte) *dbStatsRecord {
+	if !IsDBStatsEnabled() || len(key) == 0 {
+		return nil
+	}
+	record := &dbStatsRecord{key: key}
+	if badgerTxn := txn.BadgerTxn(); badgerTxn != nil {
+		// Going to the badger txn lets us get the value size without copying the value.
+		if item, err := badgerTxn.Get(key); err == nil {
+			record.existed = true
+			record.prevBytes = int64(len(key)) + item.ValueSize()
+		}
+	} else if value, err := txn.Get(key); err == nil {
+		record.existed = true
+		record.prevBytes = int64(len(key) + len(value))
+	}
+	return record
+}
+
+// _prepareDBStatsRecords is _prepareDBStatsRecord for writes that don't run in a txn, like badger write
+// batches.
+func _prepareDBStatsRecords(handle *badger.DB, keys [][]byte) []*dbStatsRecord {
+	if !IsDBStatsEnabled() {
+		return nil
+	}
+	// A key that's repeated gets a nil record, so that it's only counted once.
+	records := make([]*dbStatsRecord, len(keys))
+	seenKeys := make(map[string]bool)
+	handle.View(func(txn *badger.Txn) error {
+		for ii, key := range keys {
+			if seenKeys[string(key)] {
+				continue
+			}
+			seenKeys[string(key)] = true
+			records[ii] = _prepareDBStatsRecord(WrapBadgerTxn(txn), key)
+		}
+		return nil
+	})
+	return records
+}
+
+func (record *dbStatsRecord) recordSet(value []byte) {
+	if record == nil {
+		return
+	}
+	stats := &dbStatsByPrefix[record.key[0]]
+	if !record.existed {
+		atomic.AddInt64(&stats.numKeys, 1)
+	}
+	atomic.AddInt64(&stats.numBytes, int64(len(record.key)+len(value))-record.prevBytes)
+}
+
+func (record *dbStatsRecord) recordDelete() {
+	if record == nil || !record.existed {
+		return
+	}
+	stats := &dbStatsByPrefix[record.key[0]]
+	atomic.AddInt64(&stats.numKeys, -1)
+	atomic.AddInt64(&stats.numBytes, -record.prevBytes)
+}
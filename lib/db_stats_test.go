@@ -0,0 +1,80 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBStats(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	defer db.Close()
+	defer StopDBStats()
+
+	require.Nil(GetDBStats())
+
+	// The stats are seeded from the records already in the db.
+	prefix := Prefixes.PrefixBlockStats[0]
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return DBSetWithTxn(txn, nil, []byte{prefix, 1}, []byte{1, 2, 3})
+	}))
+	require.NoError(StartDBStats(db))
+	getStats := func() *DBPrefixStats {
+		for _, stats := range GetDBStats() {
+			if stats.Prefix[0] == prefix {
+				return stats
+			}
+		}
+		return &DBPrefixStats{}
+	}
+	require.Equal(&DBPrefixStats{
+		Prefix:   []byte{prefix},
+		Name:     "PrefixBlockStats",
+		NumKeys:  1,
+		NumBytes: 5,
+	}, getStats())
+
+	// Writes update them: new keys are counted, overwrites only change the size.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		if err := DBSetWithTxn(txn, nil, []byte{prefix, 2}, []byte{1}); err != nil {
+			return err
+		}
+		if err := DBSetWithTxn(txn, nil, []byte{prefix, 1}, []byte{1}); err != nil {
+			return err
+		}
+		// Deleting a key that doesn't exist doesn't change anything.
+		return DBDeleteWithTxn(txn, nil, []byte{prefix, 3})
+	}))
+	require.Equal(int64(2), getStats().NumKeys)
+	require.Equal(int64(6), getStats().NumBytes)
+
+	require.NoError(DBBatchSet(db, nil, []*DBEntry{
+		{Key: []byte{prefix, 3}, Value: []byte{1, 2}},
+		{Key: []byte{prefix, 4}, Value: []byte{1}},
+		{Key: []byte{prefix, 4}, Value: []byte{1, 2, 3}},
+	}))
+	require.Equal(int64(4), getStats().NumKeys)
+	require.Equal(int64(15), getStats().NumBytes)
+
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return DBBatchDeleteWithTxn(txn, nil, [][]byte{{prefix, 1}})
+	}))
+	require.NoError(DBBatchDelete(db, nil, [][]byte{{prefix, 2}, {prefix, 2}}))
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return DBDeleteWithTxn(txn, nil, []byte{prefix, 3})
+	}))
+	require.Equal(int64(1), getStats().NumKeys)
+	require.Equal(int64(5), getStats().NumBytes)
+
+	// The incremental stats match a rescan of the db.
+	prefixStats := GetDBStats()
+	require.NoError(StartDBStats(db))
+	require.Equal(prefixStats, GetDBStats())
+}
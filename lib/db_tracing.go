@@ -0,0 +1,177 @@
+package lib
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DB tracing produces OpenTelemetry spans for the expensive db operations: view flushes, block connects,
+// prefix scans, and snapshot maintenance jobs. Spans are exported through the global TracerProvider, so a
+// binary that wants them has to install its own provider with otel.SetTracerProvider and then call
+// EnableDBTracing(true). Tracing is off by default, in which case StartDBSpan returns a nil *DBSpan and the
+// only overhead on the db hot paths is a single atomic load.
+//
+// Individual Get/Set/Delete calls are too frequent to get a span each. Instead, a span can track the badger
+// txn it runs in, and all the reads, writes and deletes made through DBGetWithTxn, DBSetWithTxn and
+// DBDeleteWithTxn in that txn are counted per prefix and attached to the span when it ends. That's what
+// shows which prefixes dominated e.g. a slow flush.
+
+const dbTracerName = "github.com/deso-protocol/core/lib"
+
+type DBOperationType uint8
+
+const (
+	DBOperationRead DBOperationType = iota
+	DBOperationWrite
+	DBOperationDelete
+)
+
+func (op DBOperationType) String() string {
+	switch op {
+	case DBOperationRead:
+		return "reads"
+	case DBOperationWrite:
+		return "writes"
+	case DBOperationDelete:
+		return "deletes"
+	default:
+		return "unknown"
+	}
+}
+
+var dbTracingEnabled int32
+
+// dbSpansByTxn maps the *badger.Txn tracked by a span to its *DBSpan.
+var dbSpansByTxn sync.Map
+
+// EnableDBTracing turns DB tracing on or off.
+func EnableDBTracing(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&dbTracingEnabled, 1)
+	} else {
+		atomic.StoreInt32(&dbTracingEnabled, 0)
+	}
+}
+
+func IsDBTracingEnabled() bool {
+	return atomic.LoadInt32(&dbTracingEnabled) == 1
+}
+
+// DBSpan wraps an OpenTelemetry span together with the per-prefix operation counts of the txn it tracks.
+// All of its methods are safe to call on a nil *DBSpan, which is what callers get when tracing is disabled.
+type DBSpan struct {
+	span trace.Span
+	txn  *badger.Txn
+
+	opCountsLock sync.Mutex
+	opCounts     map[DBOperationType]map[byte]int64
+}
+
+// StartDBSpan starts a span with the given name. If prefix is non-empty, the span is tagged with it.
+func StartDBSpan(ctx context.Context, name string, prefix []byte, attrs ...attribute.KeyValue) (context.Context, *DBSpan) {
+	if !IsDBTracingEnabled() {
+		return ctx, nil
+	}
+	if len(prefix) > 0 {
+		attrs = append(attrs, DBPrefixAttributes(prefix)...)
+	}
+	ctx, span := otel.Tracer(dbTracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(append(attrs, attribute.String("db.system", "badger"))...))
+	return ctx, &DBSpan{
+		span:     span,
+		opCounts: make(map[DBOperationType]map[byte]int64),
+	}
+}
+
+// DBPrefixAttributes returns the attributes that identify the db prefix of a key.
+func DBPrefixAttributes(key []byte) []attribute.KeyValue {
+	if len(key) == 0 {
+		return nil
+	}
+	return []attribute.KeyValue{
+		attribute.Int("db.prefix_id", int(key[0])),
+		attribute.String("db.prefix", dbPrefixName(key[0])),
+	}
+}
+
+func dbPrefixName(prefix byte) string {
+	if name, exists := StatePrefixes.PrefixNames[prefix]; exists {
+		return name
+	}
+	return "Unknown"
+}
+
+// TrackTxn makes the span count all the operations made in the txn until the span ends. A span can only
+// track one txn.
+func (dbSpan *DBSpan) TrackTxn(txn *badger.Txn) {
+	if dbSpan == nil || txn == nil || dbSpan.txn != nil {
+		return
+	}
+	dbSpan.txn = txn
+	dbSpansByTxn.Store(txn, dbSpan)
+}
+
+func (dbSpan *DBSpan) SetAttributes(attrs ...attribute.KeyValue) {
+	if dbSpan == nil {
+		return
+	}
+	dbSpan.span.SetAttributes(attrs...)
+}
+
+// End ends the span, attaching the operation counts and, if the operation failed, the error.
+func (dbSpan *DBSpan) End(err error) {
+	if dbSpan == nil {
+		return
+	}
+	if dbSpan.txn != nil {
+		dbSpansByTxn.Delete(dbSpan.txn)
+	}
+
+	dbSpan.opCountsLock.Lock()
+	var attrs []attribute.KeyValue
+	for op, countsByPrefix := range dbSpan.opCounts {
+		var total int64
+		for prefix, count := range countsByPrefix {
+			attrs = append(attrs, attribute.Int64("db."+op.String()+"."+dbPrefixName(prefix), count))
+			total += count
+		}
+		attrs = append(attrs, attribute.Int64("db."+op.String(), total))
+	}
+	dbSpan.opCountsLock.Unlock()
+	dbSpan.span.SetAttributes(attrs...)
+
+	if err != nil {
+		dbSpan.span.RecordError(err)
+		dbSpan.span.SetStatus(codes.Error, err.Error())
+	}
+	dbSpan.span.End()
+}
+
+func (dbSpan *DBSpan) recordOperation(op DBOperationType, key []byte) {
+	dbSpan.opCountsLock.Lock()
+	defer dbSpan.opCountsLock.Unlock()
+
+	if _, exists := dbSpan.opCounts[op]; !exists {
+		dbSpan.opCounts[op] = make(map[byte]int64)
+	}
+	dbSpan.opCounts[op][key[0]]++
+}
+
+// _recordDBOperation is called by the db wrappers on every operation. It's a no-op unless tracing is enabled
+// and some span tracks the txn.
+func _recordDBOperation(txn *badger.Txn, op DBOperationType, key []byte) {
+	if !IsDBTracingEnabled() || len(key) == 0 {
+		return
+	}
+	if dbSpan, exists := dbSpansByTxn.Load(txn); exists {
+		dbSpan.(*DBSpan).recordOperation(op, key)
+	}
+}
@@ -0,0 +1,62 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBSpanOperationCounts(t *testing.T) {
+	require := require.New(t)
+
+	db, _ := GetTestBadgerDb()
+	defer db.Close()
+
+	// Spans are nil and ignored while tracing is disabled.
+	_, dbSpan := StartDBSpan(context.Background(), "TestDBSpan", nil)
+	require.Nil(dbSpan)
+	dbSpan.TrackTxn(nil)
+	dbSpan.End(nil)
+
+	EnableDBTracing(true)
+	defer EnableDBTracing(false)
+
+	nonceKey := append(append([]byte{}, Prefixes.PrefixPublicKeyToNonce...), m0PkBytes...)
+	flushHeightKey := Prefixes.PrefixStateFlushHeight
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		_, dbSpan = StartDBSpan(context.Background(), "TestDBSpan", nil)
+		require.NotNil(dbSpan)
+		dbSpan.TrackTxn(txn)
+		defer dbSpan.End(nil)
+
+		require.NoError(DBSetWithTxn(txn, nil, nonceKey, EncodeUint64(1)))
+		require.NoError(DBSetWithTxn(txn, nil, flushHeightKey, EncodeUint64(1)))
+		_, err := DBGetWithTxn(txn, nil, nonceKey)
+		require.NoError(err)
+		return DBDeleteWithTxn(txn, nil, nonceKey)
+	}))
+
+	nonceCounts := map[DBOperationType]int64{}
+	for op, countsByPrefix := range dbSpan.opCounts {
+		nonceCounts[op] = countsByPrefix[Prefixes.PrefixPublicKeyToNonce[0]]
+	}
+	require.Equal(map[DBOperationType]int64{
+		DBOperationRead:   1,
+		DBOperationWrite:  1,
+		DBOperationDelete: 1,
+	}, nonceCounts)
+	require.Equal(int64(1), dbSpan.opCounts[DBOperationWrite][flushHeightKey[0]])
+
+	// Once the span ends, the txn's operations are no longer counted.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		_recordDBOperation(txn, DBOperationWrite, nonceKey)
+		return nil
+	}))
+	require.Equal(int64(1), dbSpan.opCounts[DBOperationWrite][nonceKey[0]])
+
+	require.Equal("PrefixPublicKeyToNonce", dbPrefixName(Prefixes.PrefixPublicKeyToNonce[0]))
+}
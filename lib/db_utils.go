@@ -2,6 +2,7 @@ package lib
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
@@ -12,17 +13,21 @@ import (
 	"log"
 	"math"
 	"math/big"
+	"net"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/btcsuite/btcd/btcec"
-	"github.com/davecgh/go-spew/spew"
 	"github.com/dgraph-io/badger/v3"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // This file contains all of the functions that interact with the database.
@@ -48,6 +53,10 @@ const (
 // Prefixes var when fetching prefixes to avoid parsing the prefix_id tags every time.
 var Prefixes = GetPrefixes()
 
+// PrefixRegistry holds the name, state flag and key schema of every prefix. Building it panics if
+// two prefixes collide, so that a prefix overlap stops the node at startup.
+var PrefixRegistry = mustGetDBPrefixRegistry()
+
 // StatePrefixes is a static variable that allows us to quickly fetch state-related prefixes. We make
 // the distinction between state and non-state prefixes for hyper sync, where the node is only syncing
 // state prefixes. This significantly speeds up the syncing process and the node will still work properly.
@@ -63,7 +72,7 @@ type DBPrefixes struct {
 	// The prefix for the block index:
 	// Key format: <prefix_id, hash BlockHash>
 	// Value format: serialized MsgDeSoBlock
-	PrefixBlockHashToBlock []byte `prefix_id:"[0]"`
+	PrefixBlockHashToBlock []byte `prefix_id:"[0]" key_schema:"<BlockHash>"`
 
 	// The prefix for the node index that we use to reconstruct the block tree.
 	// Storing the height in big-endian byte order allows us to read in all the
@@ -72,177 +81,177 @@ type DBPrefixes struct {
 	//
 	// Key format: <prefix_id, height uint32 (big-endian), hash BlockHash>
 	// Value format: serialized BlockNode
-	PrefixHeightHashToNodeInfo        []byte `prefix_id:"[1]"`
-	PrefixBitcoinHeightHashToNodeInfo []byte `prefix_id:"[2]"`
+	PrefixHeightHashToNodeInfo        []byte `prefix_id:"[1]" key_schema:"<Height uint32, BlockHash>"`
+	PrefixBitcoinHeightHashToNodeInfo []byte `prefix_id:"[2]" key_schema:"<Height uint32, BlockHash>"`
 
 	// We store the hash of the node that is the current tip of the main chain.
 	// This key is used to look it up.
 	// Value format: BlockHash
-	PrefixBestDeSoBlockHash []byte `prefix_id:"[3]"`
+	PrefixBestDeSoBlockHash []byte `prefix_id:"[3]" key_schema:"<>"`
 
-	PrefixBestBitcoinHeaderHash []byte `prefix_id:"[4]"`
+	PrefixBestBitcoinHeaderHash []byte `prefix_id:"[4]" key_schema:"<>"`
 
 	// Utxo table.
 	// <prefix_id, txid BlockHash, output_index uint64> -> UtxoEntry
-	PrefixUtxoKeyToUtxoEntry []byte `prefix_id:"[5]" is_state:"true"`
+	PrefixUtxoKeyToUtxoEntry []byte `prefix_id:"[5]" is_state:"true" key_schema:"<TxID BlockHash, OutputIndex uint32>"`
 	// <prefix_id, pubKey [33]byte, utxoKey< txid BlockHash, index uint32 >> -> <>
-	PrefixPubKeyUtxoKey []byte `prefix_id:"[7]" is_state:"true"`
+	PrefixPubKeyUtxoKey []byte `prefix_id:"[7]" is_state:"true" key_schema:"<PublicKey [33]byte, TxID BlockHash, OutputIndex uint32>"`
 	// The number of utxo entries in the database.
-	PrefixUtxoNumEntries []byte `prefix_id:"[8]" is_state:"true"`
+	PrefixUtxoNumEntries []byte `prefix_id:"[8]" is_state:"true" key_schema:"<>"`
 	// Utxo operations table.
 	// This table contains, for each blockhash on the main chain, the UtxoOperations
 	// that were applied by this block. To roll back the block, one must loop through
 	// the UtxoOperations for a particular block backwards and invert them.
 	//
 	// <prefix_id, hash *BlockHash > -> < serialized []UtxoOperation using custom encoding >
-	PrefixBlockHashToUtxoOperations []byte `prefix_id:"[9]"`
+	PrefixBlockHashToUtxoOperations []byte `prefix_id:"[9]" key_schema:"<BlockHash>"`
 	// The below are mappings related to the validation of BitcoinExchange transactions.
 	//
 	// The number of nanos that has been purchased thus far.
-	PrefixNanosPurchased []byte `prefix_id:"[10]" is_state:"true"`
+	PrefixNanosPurchased []byte `prefix_id:"[10]" is_state:"true" key_schema:"<>"`
 	// How much Bitcoin is work in USD cents.
-	PrefixUSDCentsPerBitcoinExchangeRate []byte `prefix_id:"[27]" is_state:"true"`
+	PrefixUSDCentsPerBitcoinExchangeRate []byte `prefix_id:"[27]" is_state:"true" key_schema:"<>"`
 	// <prefix_id, key> -> <GlobalParamsEntry encoded>
-	PrefixGlobalParams []byte `prefix_id:"[40]" is_state:"true"`
+	PrefixGlobalParams []byte `prefix_id:"[40]" is_state:"true" key_schema:"<>"`
 
 	// The prefix for the Bitcoin TxID map. If a key is set for a TxID that means this
 	// particular TxID has been processed as part of a BitcoinExchange transaction. If
 	// no key is set for a TxID that means it has not been processed (and thus it can be
 	// used to create new nanos).
 	// <prefix_id, BitcoinTxID BlockHash> -> <nothing>
-	PrefixBitcoinBurnTxIDs []byte `prefix_id:"[11]" is_state:"true"`
+	PrefixBitcoinBurnTxIDs []byte `prefix_id:"[11]" is_state:"true" key_schema:"<BitcoinTxID BlockHash>"`
 	// Messages are indexed by the public key of their senders and receivers. If
 	// a message sends from pkFrom to pkTo then there will be two separate entries,
 	// one for pkFrom and one for pkTo. The exact format is as follows:
 	// <public key (33 bytes) || uint64 big-endian> -> <MessageEntry>
-	PrefixPublicKeyTimestampToPrivateMessage []byte `prefix_id:"[12]" is_state:"true"`
+	PrefixPublicKeyTimestampToPrivateMessage []byte `prefix_id:"[12]" is_state:"true" max_value_size:"16000000" key_schema:"<PublicKey [33]byte, TstampNanos uint64>"`
 
 	// Tracks the tip of the transaction index. This is used to determine
 	// which blocks need to be processed in order to update the index.
-	PrefixTransactionIndexTip []byte `prefix_id:"[14]" is_txindex:"true"`
+	PrefixTransactionIndexTip []byte `prefix_id:"[14]" is_txindex:"true" key_schema:"<>"`
 	// <prefix_id, transactionID BlockHash> -> <TransactionMetadata struct>
-	PrefixTransactionIDToMetadata []byte `prefix_id:"[15]" is_txindex:"true"`
+	PrefixTransactionIDToMetadata []byte `prefix_id:"[15]" is_txindex:"true" max_value_size:"16000000" key_schema:"<TxID BlockHash>"`
 	// <prefix_id, publicKey []byte, index uint32> -> <txid BlockHash>
-	PrefixPublicKeyIndexToTransactionIDs []byte `prefix_id:"[16]" is_txindex:"true"`
+	PrefixPublicKeyIndexToTransactionIDs []byte `prefix_id:"[16]" is_txindex:"true" key_schema:"<PublicKey [33]byte, Index uint32>"`
 	// <prefix_id, publicKey []byte> -> <index uint32>
-	PrefixPublicKeyToNextIndex []byte `prefix_id:"[42]" is_txindex:"true"`
+	PrefixPublicKeyToNextIndex []byte `prefix_id:"[42]" is_txindex:"true" key_schema:"<PublicKey [33]byte>"`
 
 	// Main post index.
 	// <prefix_id, PostHash BlockHash> -> PostEntry
-	PrefixPostHashToPostEntry []byte `prefix_id:"[17]" is_state:"true"`
+	PrefixPostHashToPostEntry []byte `prefix_id:"[17]" is_state:"true" max_value_size:"16000000" key_schema:"<PostHash [32]byte>"`
 	// Post sorts
 	// <prefix_id, publicKey [33]byte, PostHash> -> <>
-	PrefixPosterPublicKeyPostHash []byte `prefix_id:"[18]" is_state:"true"`
+	PrefixPosterPublicKeyPostHash []byte `prefix_id:"[18]" is_state:"true" key_schema:"<PublicKey [33]byte, PostHash [32]byte>"`
 
 	// <prefix_id, tstampNanos uint64, PostHash> -> <>
-	PrefixTstampNanosPostHash []byte `prefix_id:"[19]" is_state:"true"`
+	PrefixTstampNanosPostHash []byte `prefix_id:"[19]" is_state:"true" key_schema:"<TstampNanos uint64, PostHash [32]byte>"`
 	// <prefix_id, creatorbps uint64, PostHash> -> <>
-	PrefixCreatorBpsPostHash []byte `prefix_id:"[20]" is_state:"true"`
+	PrefixCreatorBpsPostHash []byte `prefix_id:"[20]" is_state:"true" key_schema:"<CreatorBps uint64, PostHash [32]byte>"`
 	// <prefix_id, multiplebps uint64, PostHash> -> <>
-	PrefixMultipleBpsPostHash []byte `prefix_id:"[21]" is_state:"true"`
+	PrefixMultipleBpsPostHash []byte `prefix_id:"[21]" is_state:"true" key_schema:"<MultipleBps uint64, PostHash [32]byte>"`
 	// Comments are just posts that have their ParentStakeID set, and
 	// so we have a separate index that allows us to return all the
 	// comments for a given StakeID
 	// <prefix_id, parent stakeID [33]byte, tstampnanos uint64, post hash> -> <>
-	PrefixCommentParentStakeIDToPostHash []byte `prefix_id:"[22]" is_state:"true"`
+	PrefixCommentParentStakeIDToPostHash []byte `prefix_id:"[22]" is_state:"true" key_schema:"<ParentStakeID [33]byte, TstampNanos uint64, PostHash [32]byte>"`
 
 	// Main profile index
 	// <prefix_id, PKID [33]byte> -> ProfileEntry
-	PrefixPKIDToProfileEntry []byte `prefix_id:"[23]" is_state:"true"`
+	PrefixPKIDToProfileEntry []byte `prefix_id:"[23]" is_state:"true" max_value_size:"16000000" key_schema:"<PKID [33]byte>"`
 	// Profile sorts
 	// For username, we set the PKID as a value since the username is not fixed width.
 	// We always lowercase usernames when using them as map keys in order to make
 	// all uniqueness checks case-insensitive
 	// <prefix_id, username> -> <PKID>
-	PrefixProfileUsernameToPKID []byte `prefix_id:"[25]" is_state:"true"`
+	PrefixProfileUsernameToPKID []byte `prefix_id:"[25]" is_state:"true" key_schema:"<LowercaseUsername []byte>"`
 	// This allows us to sort the profiles by the value of their coin (since
 	// the amount of DeSo locked in a profile is proportional to coin price).
-	PrefixCreatorDeSoLockedNanosCreatorPKID []byte `prefix_id:"[32]" is_state:"true"`
+	PrefixCreatorDeSoLockedNanosCreatorPKID []byte `prefix_id:"[32]" is_state:"true" key_schema:"<DeSoLockedNanos uint64, CreatorPKID [33]byte>"`
 	// The StakeID is a post hash for posts and a public key for users.
 	// <prefix_id, StakeIDType, AmountNanos uint64, StakeID [var]byte> -> <>
-	PrefixStakeIDTypeAmountStakeIDIndex []byte `prefix_id:"[26]" is_state:"true"`
+	PrefixStakeIDTypeAmountStakeIDIndex []byte `prefix_id:"[26]" is_state:"true" key_schema:"<StakeIDType uint8, AmountNanos uint64, StakeID []byte>"`
 
 	// Prefixes for follows:
 	// <prefix_id, follower PKID [33]byte, followed PKID [33]byte> -> <>
 	// <prefix_id, followed PKID [33]byte, follower PKID [33]byte> -> <>
-	PrefixFollowerPKIDToFollowedPKID []byte `prefix_id:"[28]" is_state:"true"`
-	PrefixFollowedPKIDToFollowerPKID []byte `prefix_id:"[29]" is_state:"true"`
+	PrefixFollowerPKIDToFollowedPKID []byte `prefix_id:"[28]" is_state:"true" key_schema:"<FollowerPKID [33]byte, FollowedPKID [33]byte>"`
+	PrefixFollowedPKIDToFollowerPKID []byte `prefix_id:"[29]" is_state:"true" key_schema:"<FollowedPKID [33]byte, FollowerPKID [33]byte>"`
 
 	// Prefixes for likes:
 	// <prefix_id, user pub key [33]byte, liked post hash [32]byte> -> <>
 	// <prefix_id, post hash [32]byte, user pub key [33]byte> -> <>
-	PrefixLikerPubKeyToLikedPostHash []byte `prefix_id:"[30]" is_state:"true"`
-	PrefixLikedPostHashToLikerPubKey []byte `prefix_id:"[31]" is_state:"true"`
+	PrefixLikerPubKeyToLikedPostHash []byte `prefix_id:"[30]" is_state:"true" key_schema:"<LikerPublicKey [33]byte, LikedPostHash [32]byte>"`
+	PrefixLikedPostHashToLikerPubKey []byte `prefix_id:"[31]" is_state:"true" key_schema:"<LikedPostHash [32]byte, LikerPublicKey [33]byte>"`
 
 	// Prefixes for creator coin fields:
 	// <prefix_id, HODLer PKID [33]byte, creator PKID [33]byte> -> <BalanceEntry>
 	// <prefix_id, creator PKID [33]byte, HODLer PKID [33]byte> -> <BalanceEntry>
-	PrefixHODLerPKIDCreatorPKIDToBalanceEntry []byte `prefix_id:"[33]" is_state:"true"`
-	PrefixCreatorPKIDHODLerPKIDToBalanceEntry []byte `prefix_id:"[34]" is_state:"true"`
+	PrefixHODLerPKIDCreatorPKIDToBalanceEntry []byte `prefix_id:"[33]" is_state:"true" key_schema:"<HODLerPKID [33]byte, CreatorPKID [33]byte>"`
+	PrefixCreatorPKIDHODLerPKIDToBalanceEntry []byte `prefix_id:"[34]" is_state:"true" key_schema:"<CreatorPKID [33]byte, HODLerPKID [33]byte>"`
 
-	PrefixPosterPublicKeyTimestampPostHash []byte `prefix_id:"[35]" is_state:"true"`
+	PrefixPosterPublicKeyTimestampPostHash []byte `prefix_id:"[35]" is_state:"true" key_schema:"<PublicKey [33]byte, TstampNanos uint64, PostHash [32]byte>"`
 	// If no mapping exists for a particular public key, then the PKID is simply
 	// the public key itself.
 	// <prefix_id, [33]byte> -> <PKID [33]byte>
-	PrefixPublicKeyToPKID []byte `prefix_id:"[36]" is_state:"true"`
+	PrefixPublicKeyToPKID []byte `prefix_id:"[36]" is_state:"true" key_schema:"<PublicKey [33]byte>"`
 	// <prefix_id, PKID [33]byte> -> <PublicKey [33]byte>
-	PrefixPKIDToPublicKey []byte `prefix_id:"[37]" is_state:"true"`
+	PrefixPKIDToPublicKey []byte `prefix_id:"[37]" is_state:"true" key_schema:"<PKID [33]byte>"`
 	// Prefix for storing mempool transactions in badger. These stored transactions are
 	// used to restore the state of a node after it is shutdown.
 	// <prefix_id, tx hash BlockHash> -> <*MsgDeSoTxn>
-	PrefixMempoolTxnHashToMsgDeSoTxn []byte `prefix_id:"[38]"`
+	PrefixMempoolTxnHashToMsgDeSoTxn []byte `prefix_id:"[38]" key_schema:"<TxnHash BlockHash>"`
 
 	// Prefixes for Reposts:
 	// <prefix_id, user pub key [39]byte, reposted post hash [39]byte> -> RepostEntry
-	PrefixReposterPubKeyRepostedPostHashToRepostPostHash []byte `prefix_id:"[39]" is_state:"true"`
+	PrefixReposterPubKeyRepostedPostHashToRepostPostHash []byte `prefix_id:"[39]" is_state:"true" key_schema:"<ReposterPublicKey [33]byte, RepostedPostHash [32]byte, RepostPostHash [32]byte>"`
 	// Prefixes for diamonds:
 	//  <prefix_id, DiamondReceiverPKID [33]byte, DiamondSenderPKID [33]byte, posthash> -> <DiamondEntry>
 	//  <prefix_id, DiamondSenderPKID [33]byte, DiamondReceiverPKID [33]byte, posthash> -> <DiamondEntry>
-	PrefixDiamondReceiverPKIDDiamondSenderPKIDPostHash []byte `prefix_id:"[41]" is_state:"true"`
-	PrefixDiamondSenderPKIDDiamondReceiverPKIDPostHash []byte `prefix_id:"[43]" is_state:"true"`
+	PrefixDiamondReceiverPKIDDiamondSenderPKIDPostHash []byte `prefix_id:"[41]" is_state:"true" key_schema:"<ReceiverPKID [33]byte, SenderPKID [33]byte, PostHash [32]byte>"`
+	PrefixDiamondSenderPKIDDiamondReceiverPKIDPostHash []byte `prefix_id:"[43]" is_state:"true" key_schema:"<SenderPKID [33]byte, ReceiverPKID [33]byte, PostHash [32]byte>"`
 	// Public keys that have been restricted from signing blocks.
 	// <prefix_id, ForbiddenPublicKey [33]byte> -> <>
-	PrefixForbiddenBlockSignaturePubKeys []byte `prefix_id:"[44]" is_state:"true"`
+	PrefixForbiddenBlockSignaturePubKeys []byte `prefix_id:"[44]" is_state:"true" key_schema:"<PublicKey [33]byte>"`
 
 	// These indexes are used in order to fetch the pub keys of users that liked or diamonded a post.
 	// 		Reposts: <prefix_id, RepostedPostHash, ReposterPubKey> -> <>
 	// 		Quote Reposts: <prefix_id, RepostedPostHash, ReposterPubKey, RepostPostHash> -> <>
 	// 		Diamonds: <prefix_id, DiamondedPostHash, DiamonderPubKey [33]byte, DiamondLevel (uint64)> -> <>
-	PrefixRepostedPostHashReposterPubKey               []byte `prefix_id:"[45]" is_state:"true"`
-	PrefixRepostedPostHashReposterPubKeyRepostPostHash []byte `prefix_id:"[46]" is_state:"true"`
-	PrefixDiamondedPostHashDiamonderPKIDDiamondLevel   []byte `prefix_id:"[47]" is_state:"true"`
+	PrefixRepostedPostHashReposterPubKey               []byte `prefix_id:"[45]" is_state:"true" key_schema:"<RepostedPostHash [32]byte, ReposterPublicKey [33]byte>"`
+	PrefixRepostedPostHashReposterPubKeyRepostPostHash []byte `prefix_id:"[46]" is_state:"true" key_schema:"<RepostedPostHash [32]byte, ReposterPublicKey [33]byte, RepostPostHash [32]byte>"`
+	PrefixDiamondedPostHashDiamonderPKIDDiamondLevel   []byte `prefix_id:"[47]" is_state:"true" key_schema:"<DiamondedPostHash [32]byte, SenderPKID [33]byte, DiamondLevel uint64>"`
 	// Prefixes for NFT ownership:
 	// 	<prefix_id, NFTPostHash [32]byte, SerialNumber uint64> -> NFTEntry
-	PrefixPostHashSerialNumberToNFTEntry []byte `prefix_id:"[48]" is_state:"true"`
+	PrefixPostHashSerialNumberToNFTEntry []byte `prefix_id:"[48]" is_state:"true" key_schema:"<NFTPostHash [32]byte, SerialNumber uint64>"`
 	//  <prefix_id, PKID [33]byte, IsForSale bool, BidAmountNanos uint64, NFTPostHash[32]byte, SerialNumber uint64> -> NFTEntry
-	PrefixPKIDIsForSaleBidAmountNanosPostHashSerialNumberToNFTEntry []byte `prefix_id:"[49]" is_state:"true"`
+	PrefixPKIDIsForSaleBidAmountNanosPostHashSerialNumberToNFTEntry []byte `prefix_id:"[49]" is_state:"true" key_schema:"<PKID [33]byte, IsForSale bool, BidAmountNanos uint64, NFTPostHash [32]byte, SerialNumber uint64>"`
 	// Prefixes for NFT bids:
 	//  <prefix_id, NFTPostHash [32]byte, SerialNumber uint64, BidNanos uint64, PKID [33]byte> -> <>
-	PrefixPostHashSerialNumberBidNanosBidderPKID []byte `prefix_id:"[50]" is_state:"true"`
+	PrefixPostHashSerialNumberBidNanosBidderPKID []byte `prefix_id:"[50]" is_state:"true" key_schema:"<NFTPostHash [32]byte, SerialNumber uint64, BidNanos uint64, BidderPKID [33]byte>"`
 	//  <prefix_id, BidderPKID [33]byte, NFTPostHash [32]byte, SerialNumber uint64> -> <BidNanos uint64>
-	PrefixBidderPKIDPostHashSerialNumberToBidNanos []byte `prefix_id:"[51]" is_state:"true"`
+	PrefixBidderPKIDPostHashSerialNumberToBidNanos []byte `prefix_id:"[51]" is_state:"true" key_schema:"<BidderPKID [33]byte, NFTPostHash [32]byte, SerialNumber uint64>"`
 
 	// <prefix_id, PublicKey [33]byte> -> uint64
-	PrefixPublicKeyToDeSoBalanceNanos []byte `prefix_id:"[52]" is_state:"true"`
+	PrefixPublicKeyToDeSoBalanceNanos []byte `prefix_id:"[52]" is_state:"true" key_schema:"<PublicKey [33]byte>"`
 
 	// Block reward prefix:
 	//   - This index is needed because block rewards take N blocks to mature, which means we need
 	//     a way to deduct them from balance calculations until that point. Without this index, it
 	//     would be impossible to figure out which of a user's UTXOs have yet to mature.
 	//   - Schema: <prefix_id, hash BlockHash> -> <pubKey [33]byte, uint64 blockRewardNanos>
-	PrefixPublicKeyBlockHashToBlockReward []byte `prefix_id:"[53]" is_state:"true"`
+	PrefixPublicKeyBlockHashToBlockReward []byte `prefix_id:"[53]" is_state:"true" key_schema:"<PublicKey [33]byte, BlockHash>"`
 
 	// Prefix for NFT accepted bid entries:
 	//   - Note: this index uses a slice to track the history of winning bids for an NFT. It is
 	//     not core to consensus and should not be relied upon as it could get inefficient.
 	//   - Schema: <prefix_id>, NFTPostHash [32]byte, SerialNumber uint64 -> []NFTBidEntry
-	PrefixPostHashSerialNumberToAcceptedBidEntries []byte `prefix_id:"[54]" is_state:"true"`
+	PrefixPostHashSerialNumberToAcceptedBidEntries []byte `prefix_id:"[54]" is_state:"true" key_schema:"<NFTPostHash [32]byte, SerialNumber uint64>"`
 
 	// Prefixes for DAO coin fields:
 	// <prefix, HODLer PKID [33]byte, creator PKID [33]byte> -> <BalanceEntry>
 	// <prefix, creator PKID [33]byte, HODLer PKID [33]byte> -> <BalanceEntry>
-	PrefixHODLerPKIDCreatorPKIDToDAOCoinBalanceEntry []byte `prefix_id:"[55]" is_state:"true"`
-	PrefixCreatorPKIDHODLerPKIDToDAOCoinBalanceEntry []byte `prefix_id:"[56]" is_state:"true"`
+	PrefixHODLerPKIDCreatorPKIDToDAOCoinBalanceEntry []byte `prefix_id:"[55]" is_state:"true" key_schema:"<HODLerPKID [33]byte, CreatorPKID [33]byte>"`
+	PrefixCreatorPKIDHODLerPKIDToDAOCoinBalanceEntry []byte `prefix_id:"[56]" is_state:"true" key_schema:"<CreatorPKID [33]byte, HODLerPKID [33]byte>"`
 
 	// Prefix for MessagingGroupEntries indexed by OwnerPublicKey and GroupKeyName:
 	//
@@ -264,7 +273,7 @@ type DBPrefixes struct {
 	//   easy access to the owner key for decrypting messages.
 	//
 	// <prefix, GroupOwnerPublicKey [33]byte, GroupKeyName [32]byte> -> <MessagingGroupEntry>
-	PrefixMessagingGroupEntriesByOwnerPubKeyAndGroupKeyName []byte `prefix_id:"[57]" is_state:"true"`
+	PrefixMessagingGroupEntriesByOwnerPubKeyAndGroupKeyName []byte `prefix_id:"[57]" is_state:"true" key_schema:"<OwnerPublicKey [33]byte, GroupKeyName [32]byte>"`
 
 	// Prefix for Message MessagingGroupMembers:
 	//
@@ -287,11 +296,11 @@ type DBPrefixes struct {
 	//   you read all the fetching code around this index.
 	//
 	// <prefix, OwnerPublicKey [33]byte, GroupMessagingPublicKey [33]byte> -> <HackedMessagingKeyEntry>
-	PrefixMessagingGroupMetadataByMemberPubKeyAndGroupMessagingPubKey []byte `prefix_id:"[58]" is_state:"true"`
+	PrefixMessagingGroupMetadataByMemberPubKeyAndGroupMessagingPubKey []byte `prefix_id:"[58]" is_state:"true" key_schema:"<MemberPublicKey [33]byte, GroupMessagingPublicKey [33]byte>"`
 
 	// Prefix for Authorize Derived Key transactions:
 	// 		<prefix_id, OwnerPublicKey [33]byte, DerivedPublicKey [33]byte> -> <DerivedKeyEntry>
-	PrefixAuthorizeDerivedKey []byte `prefix_id:"[59]" is_state:"true"`
+	PrefixAuthorizeDerivedKey []byte `prefix_id:"[59]" is_state:"true" key_schema:"<OwnerPublicKey [33]byte, DerivedPublicKey [33]byte>"`
 
 	// Prefixes for DAO coin limit orders
 	// This index powers the order book.
@@ -319,10 +328,461 @@ type DBPrefixes struct {
 	//   _PrefixDAOCoinLimitOrderByOrderID
 	//   OrderID [32]byte
 	// > -> <DAOCoinLimitOrderEntry>
-	PrefixDAOCoinLimitOrder                 []byte `prefix_id:"[60]" is_state:"true"`
-	PrefixDAOCoinLimitOrderByTransactorPKID []byte `prefix_id:"[61]" is_state:"true"`
-	PrefixDAOCoinLimitOrderByOrderID        []byte `prefix_id:"[62]" is_state:"true"`
-	// NEXT_TAG: 63
+	PrefixDAOCoinLimitOrder                 []byte `prefix_id:"[60]" is_state:"true" key_schema:"<BuyingDAOCoinCreatorPKID [33]byte, SellingDAOCoinCreatorPKID [33]byte, ScaledExchangeRate uint256, MaxUint32 - BlockHeight uint32, OrderID [32]byte>"`
+	PrefixDAOCoinLimitOrderByTransactorPKID []byte `prefix_id:"[61]" is_state:"true" key_schema:"<TransactorPKID [33]byte, BuyingDAOCoinCreatorPKID [33]byte, SellingDAOCoinCreatorPKID [33]byte, OrderID [32]byte>"`
+	PrefixDAOCoinLimitOrderByOrderID        []byte `prefix_id:"[62]" is_state:"true" key_schema:"<OrderID [32]byte>"`
+
+	// Prefix for per-post NFT collection summaries:
+	//   - This index lets NFT detail pages fetch the aggregate state of every serial number
+	//     for a post in a single read instead of scanning the NFT, bid, and accepted bid
+	//     indexes. It is recomputed from those indexes whenever a view touching the post is
+	//     flushed, so it is never the source of truth for anything consensus-related.
+	//   - It isn't a state prefix, so it's left out of the snapshot checksum and hypersync.
+	//   - Schema: <prefix_id, NFTPostHash [32]byte> -> <NFTCollectionSummary>
+	PrefixPostHashToNFTCollectionSummary []byte `prefix_id:"[63]" key_schema:"<NFTPostHash [32]byte>"`
+
+	// Prefix for per-public-key transaction nonces:
+	//   - This is groundwork for moving from UTXOs to a balance model, where every txn will
+	//     carry its transactor's nonce to prevent replays. The nonce is the number of txns
+	//     the public key has submitted so far, i.e. the nonce its next txn should have.
+	//   - Block processing doesn't maintain the nonces yet. They're only written by
+	//     DbInitializeNoncesFromTxindex, so this is a local index rather than state, and it's
+	//     left out of the snapshot checksum and hypersync until the fork maintains it.
+	//   - Schema: <prefix_id, PublicKey [33]byte> -> <uint64 nonce>
+	PrefixPublicKeyToNonce []byte `prefix_id:"[64]" key_schema:"<PublicKey [33]byte>"`
+
+	// Prefixes for m-of-n signer sets:
+	//   - A public key can own several signer sets, each of which is a list of member public keys
+	//     and the number of them that need to sign. This is the storage multisig features build on.
+	//   - The member index lets us find every signer set a public key is a member of without
+	//     scanning all of them. Its values are empty.
+	// <prefix_id, OwnerPublicKey [33]byte, SignerSetID [32]byte> -> <SignerSetEntry>
+	PrefixSignerSetByOwnerPubKeyAndID []byte `prefix_id:"[65]" is_state:"true" key_schema:"<OwnerPublicKey [33]byte, SignerSetID [32]byte>"`
+	// <prefix_id, MemberPublicKey [33]byte, OwnerPublicKey [33]byte, SignerSetID [32]byte> -> <>
+	PrefixSignerSetMemberByMemberPubKeyOwnerPubKeyAndID []byte `prefix_id:"[66]" is_state:"true" key_schema:"<MemberPublicKey [33]byte, OwnerPublicKey [33]byte, SignerSetID [32]byte>"`
+
+	// Prefixes for finding the profiles that were modified since a given block height:
+	//   - Every time a flush creates, changes, or deletes a profile, its PKID is moved to
+	//     the height of the flush. Mirroring services use this to sync only what changed.
+	//   - These aren't state prefixes because reorgs make the heights node-specific. Nodes
+	//     that hypersynced only index the profiles modified after the snapshot.
+	// <prefix_id, LastModifiedHeight uint64, PKID [33]byte> -> <>
+	PrefixProfileModifiedHeightAndPKID []byte `prefix_id:"[67]" key_schema:"<LastModifiedHeight uint64, PKID [33]byte>"`
+	// <prefix_id, PKID [33]byte> -> <LastModifiedHeight uint64>
+	PrefixPKIDToProfileModifiedHeight []byte `prefix_id:"[68]" key_schema:"<PKID [33]byte>"`
+
+	// We store the height of the block the state was last flushed at, in the same txn that
+	// updates PrefixBestDeSoBlockHash, so that we can check the two agree on startup.
+	// Value format: uint64 block height
+	PrefixStateFlushHeight []byte `prefix_id:"[69]" key_schema:"<>"`
+
+	// Prefixes for the durable work queue that feeds non-consensus indexes:
+	//   - Block connects and disconnects enqueue compact tasks in the same txn that flushes
+	//     the state, and IndexQueue workers consume them in order in the background. A task
+	//     is only deleted once every handler for it succeeds, so handlers must be idempotent.
+	//   - These aren't state prefixes because the queue is local to this node.
+	// <prefix_id, Seq uint64> -> <IndexQueueTask>
+	PrefixIndexQueueSeqToTask []byte `prefix_id:"[70]" key_schema:"<Seq uint64>"`
+	// The sequence number the next enqueued task will get.
+	// Value format: uint64 sequence number
+	PrefixIndexQueueNextSeq []byte `prefix_id:"[71]" key_schema:"<>"`
+
+	// Diagnostics prefix for the block connect profiles written when block connect profiling is
+	// enabled. This isn't a state prefix because the profiles are local to this node.
+	// <prefix_id, BlockHeight uint64, BlockHash> -> <BlockConnectProfile>
+	PrefixBlockConnectProfileByHeightAndHash []byte `prefix_id:"[72]" key_schema:"<BlockHeight uint64, BlockHash>"`
+
+	// Audit log of the raw writes made with AdminPutKV and AdminDeleteKV. A record is written in
+	// the same txn as the write it describes, and keeps the previous value so that the write can
+	// be reverted by hand. The admin APIs refuse to write to this prefix.
+	// <prefix_id, TimestampNanos uint64, Key> -> <AdminKVAuditRecord>
+	PrefixAdminKVAuditLog []byte `prefix_id:"[73]" key_schema:"<TimestampNanos uint64, Key []byte>"`
+
+	// Prefixes for the DAO coin pair volume statistics, which the IndexQueue maintains from the
+	// filled orders of each connected block:
+	//   - Volume is aggregated into buckets of DAOCoinPairVolumeBucketDuration per side of a pair,
+	//     so that rolling windows only sum a few buckets instead of scanning fills.
+	//   - The volume each block added is kept by block hash, so that it can be subtracted exactly
+	//     when the block is disconnected.
+	//   - These aren't state prefixes because the stats are local to this node.
+	// <prefix_id, BuyingDAOCoinCreatorPKID, SellingDAOCoinCreatorPKID, BucketStartSecs uint64> -> <DAOCoinPairVolume>
+	PrefixDAOCoinPairVolumeBuckets []byte `prefix_id:"[74]" key_schema:"<BuyingDAOCoinCreatorPKID [33]byte, SellingDAOCoinCreatorPKID [33]byte, BucketStartSecs uint64>"`
+	// <prefix_id, BlockHash> -> <DAOCoinPairVolumeBlockContribution>
+	PrefixDAOCoinPairVolumeBlockContributions []byte `prefix_id:"[75]" key_schema:"<BlockHash>"`
+
+	// The state prefixes this node synced during a selective hypersync. If the key doesn't exist,
+	// the node has all of the state.
+	// <prefix_id> -> <[]StatePrefix>
+	PrefixSelectiveSyncStatePrefixes []byte `prefix_id:"[76]" key_schema:"<>"`
+
+	// The most recent block and txn rejections, for diagnosing why a node diverged or why txns
+	// keep bouncing. The log is a ring buffer of ValidationFailureLogCapacity slots, where each
+	// rejection goes in slot Seq % ValidationFailureLogCapacity.
+	// <prefix_id, Slot uint64> -> <ValidationFailureRecord>
+	PrefixValidationFailures []byte `prefix_id:"[77]" key_schema:"<Slot uint64>"`
+
+	// Index of the txn type scope of every derived key that has one. It's written along with the
+	// PrefixAuthorizeDerivedKey entry, and lets the mempool and API check a key's scope without
+	// decoding its whole TransactionSpendingLimit. Keys that aren't scoped have no record.
+	// <prefix_id, owner pub key [33]byte, derived pub key [33]byte> -> <AllowedTxnTypes uint64>
+	PrefixDerivedKeyAllowedTxnTypes []byte `prefix_id:"[78]" is_state:"true" key_schema:"<OwnerPublicKey [33]byte, DerivedPublicKey [33]byte>"`
+
+	// The optional subsystems an operator paused with DbSetSubsystemPaused. A subsystem is paused
+	// if its key exists. This isn't a state prefix because the flags are local to this node.
+	// <prefix_id, Subsystem []byte> -> <>
+	PrefixPausedSubsystems []byte `prefix_id:"[79]" key_schema:"<Subsystem []byte>"`
+
+	// Prefixes for the post ExtraData index, which the IndexQueue maintains for the ExtraData keys
+	// the node operator declared:
+	//   - The index maps each (key, value) to the posts indexed under it. Its values are empty.
+	//   - The entries each post is indexed under are kept by post hash, so that they can be
+	//     removed when the post is reindexed.
+	//   - These aren't state prefixes because the indexed keys are local to this node.
+	// <prefix_id, Key []byte, Value []byte, PostHash [32]byte> -> <>
+	PrefixPostExtraDataIndex []byte `prefix_id:"[80]" key_schema:"<Key []byte, Value []byte, PostHash [32]byte>"`
+	// <prefix_id, PostHash [32]byte> -> <[]PostExtraDataIndexEntry>
+	PrefixPostExtraDataIndexEntriesByPostHash []byte `prefix_id:"[81]" key_schema:"<PostHash [32]byte>"`
+
+	// The entries that batch getters skipped because they couldn't be decoded, for the operator to
+	// repair with RepairCorruptedEntriesFromPeer. A report is removed once its entry is repaired.
+	// This isn't a state prefix because the reports are local to this node.
+	// <prefix_id, Key []byte> -> <CorruptedEntryReport>
+	PrefixCorruptedEntries []byte `prefix_id:"[82]" key_schema:"<Key []byte>"`
+
+	// Prefixes for the index of the posts that profile owners pinned with PinnedPostOrderKey, which
+	// the IndexQueue maintains:
+	//   - The index orders each poster's pinned posts by pin order. Its values are empty.
+	//   - The index entry of each pinned post is kept by post hash, so that it can be removed when
+	//     the post is unpinned.
+	// <prefix_id, PosterPKID [33]byte, PinOrder uint64, PostHash [32]byte> -> <>
+	PrefixPinnedPostsByPKID []byte `prefix_id:"[83]" key_schema:"<PosterPKID [33]byte, PinOrder uint64, PostHash [32]byte>"`
+	// <prefix_id, PostHash [32]byte> -> <PinnedPostEntry>
+	PrefixPinnedPostEntryByPostHash []byte `prefix_id:"[84]" key_schema:"<PostHash [32]byte>"`
+
+	// The history of each messaging group's key rotations. Epochs start at 0 and are appended in
+	// block height order, so the last record for a group is its current key. This isn't a state
+	// prefix because rotations are stored by messaging clients rather than by txns.
+	// <prefix_id, OwnerPublicKey [33]byte, GroupKeyName [32]byte, Epoch uint64> -> <MessagingKeyRotationRecord>
+	PrefixMessagingKeyRotations []byte `prefix_id:"[85]" key_schema:"<OwnerPublicKey [33]byte, GroupKeyName [32]byte, Epoch uint64>"`
+
+	// The hash of the main chain block at each height. It's written in the same txn as the best
+	// DeSo block hash, so it always ends at the tip, and it lets callers find main chain blocks by
+	// height, or check whether a block is on the main chain, without walking the block index. This
+	// isn't a state prefix because it's derived from the block index.
+	// <prefix_id, Height uint64> -> <BlockHash>
+	PrefixMainChainHeightToHash []byte `prefix_id:"[86]" key_schema:"<Height uint64>"`
+
+	// Prefixes for the block reward payout index, which the IndexQueue maintains for miner payout
+	// dashboards and accounting exports:
+	//   - The payouts map each public key and main chain height to the total block reward the
+	//     public key got from the block at that height.
+	//   - The lifetime earnings are the sum of each public key's payouts.
+	//   - These aren't state prefixes because they're derived from the blocks.
+	// <prefix_id, PublicKey [33]byte, Height uint64> -> <AmountNanos uint64>
+	PrefixBlockRewardPayouts []byte `prefix_id:"[87]" key_schema:"<PublicKey [33]byte, Height uint64>"`
+	// <prefix_id, PublicKey [33]byte> -> <AmountNanos uint64>
+	PrefixBlockRewardLifetimeEarnings []byte `prefix_id:"[88]" key_schema:"<PublicKey [33]byte>"`
+
+	// The GlobalParamsEntry in effect after each block that updated the global params. It lets
+	// callers check which min order quantity, exchange rate tick, and fees applied at a past
+	// height. Records are removed when their block is disconnected. This isn't a state prefix
+	// because it's derived from the update global params txns, so a node that hypersyncs only
+	// has the history from its snapshot height onwards.
+	// <prefix_id, BlockHeight uint64> -> <GlobalParamsEntry>
+	PrefixGlobalParamsHistory []byte `prefix_id:"[89]" key_schema:"<BlockHeight uint64>"`
+
+	// Prefixes RepairPrefixFromPeer uses to swap a repaired prefix in without exceeding badger's txn
+	// size limits:
+	//   - The staging records hold the peer's records for the prefix being repaired, under their
+	//     original keys.
+	//   - The swap marker holds the prefix being repaired once its staging records are complete and
+	//     verified, so that an interrupted swap can be finished with ResumePrefixRepair.
+	// <prefix_id, Key []byte> -> <Value []byte>
+	PrefixRepairStagingRecords []byte `prefix_id:"[90]" key_schema:"<Key []byte>"`
+	// <prefix_id> -> <Prefix []byte>
+	PrefixRepairSwapMarker []byte `prefix_id:"[91]" key_schema:"<>"`
+
+	// Prefix for the DAO coin limit orders whose transactors can no longer cover the quantity they're
+	// selling. The IndexQueue refreshes a transactor's records whenever a block touches its balances,
+	// so that order books can leave these orders out before the matcher gets to cancel them. This
+	// isn't a state prefix because the records are derived from the order book and balances.
+	// <prefix_id, TransactorPKID, OrderID> -> <StaleDAOCoinLimitOrder>
+	PrefixStaleDAOCoinLimitOrders []byte `prefix_id:"[92]" key_schema:"<TransactorPKID [33]byte, OrderID [32]byte>"`
+
+	// Prefix for the size, txn count, and fees of every stored block, so that block size charts
+	// don't have to load and reserialize the blocks. Blocks that aren't on the main chain are kept
+	// too, so that the stats don't have to change on reorgs. This isn't a state prefix because
+	// it's derived from the blocks.
+	// <prefix_id, BlockHeight uint64, BlockHash> -> <BlockStats>
+	PrefixBlockStats []byte `prefix_id:"[93]" key_schema:"<BlockHeight uint64, BlockHash>"`
+
+	// Prefix for the follow and unfollow txns by the PKID being followed and the height of their
+	// block, so that follower growth can be counted over a window of blocks. The IndexQueue writes
+	// the events when blocks are connected and removes them when blocks are disconnected. This isn't
+	// a state prefix because it's derived from the follow txns.
+	// <prefix_id, FollowedPKID, BlockHeight uint64, FollowerPKID> -> <IsUnfollow bool>
+	PrefixFollowEvents []byte `prefix_id:"[94]" key_schema:"<FollowedPKID [33]byte, BlockHeight uint64, FollowerPKID [33]byte>"`
+
+	// Prefix for the record of the node software and configuration that last opened the data directory,
+	// so that a data directory isn't opened by an incompatible node. See CheckAndUpdateNodeInfo.
+	// <prefix_id> -> <NodeInfo>
+	PrefixNodeInfo []byte `prefix_id:"[95]" key_schema:"<>"`
+
+	// Prefix for the posts by like count, so that the most liked posts can be fetched without scanning
+	// the likes. It's kept up to date with the PostEntry mappings, so like and unlike txns update it
+	// when they're connected and disconnected. Like the other post sorts, comments aren't included.
+	// This isn't a state prefix because it's derived from the posts, so a node that hypersyncs only
+	// has the posts that were updated after its snapshot height.
+	// <prefix_id, LikeCount uint64, PostHash> -> <>
+	PrefixLikeCountPostHash []byte `prefix_id:"[96]" key_schema:"<LikeCount uint64, PostHash [32]byte>"`
+
+	// Prefixes for the number of followers and followed accounts of each PKID, so that follow counts
+	// don't have to enumerate the follow mappings. They're updated with the follow mappings. A PKID
+	// without a counter, e.g. because its follows were hypersynced or written before the counters
+	// existed, is counted from the follow mappings the first time its counter is needed. These
+	// aren't state prefixes because they're derived from the follow mappings.
+	// <prefix_id, PKID [33]byte> -> <FollowerCount uint64>
+	PrefixPKIDToFollowerCount []byte `prefix_id:"[97]" key_schema:"<PKID [33]byte>"`
+	// <prefix_id, PKID [33]byte> -> <FollowingCount uint64>
+	PrefixPKIDToFollowingCount []byte `prefix_id:"[98]" key_schema:"<PKID [33]byte>"`
+
+	// The progress of an ImportChainState that didn't finish, so that importing the same export
+	// again resumes after the chunks that were already written. It's removed once an import
+	// finishes.
+	// <prefix_id> -> <ChainStateImportProgress>
+	PrefixChainStateImportProgress []byte `prefix_id:"[99]" key_schema:"<>"`
+
+	// The index of each txn in the txns of the public keys it's indexed under, the reverse of
+	// PrefixPublicKeyIndexToTransactionIDs, so that a single mapping can be deleted without rewriting
+	// all of the public key's mappings. The migration marker is set once the reverse mappings have been
+	// written for a txindex that was built before they existed, see DbMigrateTxindexPublicKeyMappings.
+	// <prefix_id, publicKey []byte, txid BlockHash> -> <index uint32>
+	PrefixPublicKeyTransactionIDToIndex []byte `prefix_id:"[100]" is_txindex:"true" key_schema:"<PublicKey [33]byte, TxID BlockHash>"`
+	// <prefix_id> -> <>
+	PrefixPublicKeyTransactionIDMigrated []byte `prefix_id:"[101]" is_txindex:"true" key_schema:"<>"`
+
+	// Prefixes for dormant DAO coin limit trigger orders. Their orders aren't on the order book
+	// until they're triggered, at which point they're removed from these indexes.
+	// This index finds the orders that a fill on a pair triggers, lowest trigger first.
+	// <
+	//   _PrefixDAOCoinLimitOrderTrigger
+	//   BuyingDAOCoinCreatorPKID [33]byte
+	//   SellingDAOCoinCreatorPKID [33]byte
+	//   TriggerScaledExchangeRate [32]byte
+	//   OrderID [32]byte
+	// > -> <DAOCoinLimitOrderTriggerEntry>
+	//
+	// This index allows trigger orders to be cancelled by ID.
+	// <prefix_id, OrderID [32]byte> -> <DAOCoinLimitOrderTriggerEntry>
+	PrefixDAOCoinLimitOrderTrigger          []byte `prefix_id:"[102]" is_state:"true" key_schema:"<BuyingDAOCoinCreatorPKID [33]byte, SellingDAOCoinCreatorPKID [33]byte, TriggerScaledExchangeRate [32]byte, OrderID [32]byte>"`
+	PrefixDAOCoinLimitOrderTriggerByOrderID []byte `prefix_id:"[103]" is_state:"true" key_schema:"<OrderID [32]byte>"`
+
+	// This index finds the DAO coin limit orders on the book that expire before a given
+	// block height, earliest first. Orders without an ExpirationBlockHeight aren't in it.
+	// <
+	//   _PrefixDAOCoinLimitOrderByExpirationBlockHeight
+	//   ExpirationBlockHeight uint32
+	//   OrderID [32]byte
+	// > -> <DAOCoinLimitOrderEntry>
+	PrefixDAOCoinLimitOrderByExpirationBlockHeight []byte `prefix_id:"[104]" is_state:"true" key_schema:"<ExpirationBlockHeight uint32, OrderID [32]byte>"`
+
+	// The height of the highest main chain block whose body and utxo operations were pruned.
+	// The blocks above it still have theirs. It's only set on nodes that prune blocks, see
+	// Blockchain.SetBlockPruneDepth.
+	// <prefix_id> -> <PrunedBlockHeight uint64>
+	PrefixPrunedBlockHeight []byte `prefix_id:"[105]" key_schema:"<>"`
+
+	// Prefixes for post associations. The AssociationType is length-prefixed so that the keys
+	// for one type never share a prefix with the keys for another.
+	// This index finds the associations of a type that a user has with posts.
+	// <
+	//   _PrefixPostAssociationByTypeTransactorPostHash
+	//   AssociationType []byte
+	//   TransactorPKID [33]byte
+	//   TargetPostHash [32]byte
+	// > -> <PostAssociationEntry>
+	//
+	// This index finds the associations of a type that users have with a post.
+	// <
+	//   _PrefixPostAssociationByTypePostHashTransactor
+	//   AssociationType []byte
+	//   TargetPostHash [32]byte
+	//   TransactorPKID [33]byte
+	// > -> <PostAssociationEntry>
+	PrefixPostAssociationByTypeTransactorPostHash []byte `prefix_id:"[106]" is_state:"true" key_schema:"<AssociationType []byte, TransactorPKID [33]byte, TargetPostHash [32]byte>"`
+	PrefixPostAssociationByTypePostHashTransactor []byte `prefix_id:"[107]" is_state:"true" key_schema:"<AssociationType []byte, TargetPostHash [32]byte, TransactorPKID [33]byte>"`
+
+	// Prefixes for user associations. The AssociationType comes last so it doesn't need
+	// to be length-prefixed.
+	// This index finds the associations that a user has with other users.
+	// <
+	//   _PrefixUserAssociationByTransactorTargetType
+	//   TransactorPKID [33]byte
+	//   TargetPKID [33]byte
+	//   AssociationType []byte
+	// > -> <UserAssociationEntry>
+	//
+	// This index finds the associations that other users have with a user.
+	// <
+	//   _PrefixUserAssociationByTargetTransactorType
+	//   TargetPKID [33]byte
+	//   TransactorPKID [33]byte
+	//   AssociationType []byte
+	// > -> <UserAssociationEntry>
+	PrefixUserAssociationByTransactorTargetType []byte `prefix_id:"[108]" is_state:"true" key_schema:"<TransactorPKID [33]byte, TargetPKID [33]byte, AssociationType []byte>"`
+	PrefixUserAssociationByTargetTransactorType []byte `prefix_id:"[109]" is_state:"true" key_schema:"<TargetPKID [33]byte, TransactorPKID [33]byte, AssociationType []byte>"`
+
+	// Prefix for the format version of the mempool transactions stored under
+	// PrefixMempoolTxnHashToMsgDeSoTxn. Dumps without it store the raw transactions.
+	// <prefix_id> -> <FormatVersion uint64>
+	PrefixMempoolTxnFormatVersion []byte `prefix_id:"[110]" key_schema:"<>"`
+
+	// Prefix for the number and total value of the utxos of each public key. It's maintained along
+	// with PrefixPubKeyUtxoKey so that a public key's utxo balance can be read without iterating
+	// over its utxos. It isn't part of the state, so nodes that hypersync backfill it from the utxos
+	// they downloaded.
+	// <prefix_id, PublicKey [33]byte> -> <NumUtxos uint64, TotalAmountNanos uint64>
+	PrefixPubKeyToUtxoAggregate []byte `prefix_id:"[111]" key_schema:"<PublicKey [33]byte>"`
+	// Set once PrefixPubKeyToUtxoAggregate has been backfilled from the utxos in the db. Nodes that
+	// synced before the aggregates existed don't have it.
+	// <prefix_id> -> <>
+	PrefixUtxoAggregatesBackfilled []byte `prefix_id:"[112]" key_schema:"<>"`
+
+	// Prefix for the event journal, which records the blocks and txns the chain connected and
+	// disconnected so that consumers can replay them. See EventJournal.
+	// <prefix_id, Seq uint64> -> <EventJournalEntry>
+	PrefixEventJournal []byte `prefix_id:"[113]" key_schema:"<Seq uint64>"`
+
+	// Prefix for the ownership shares of NFTs that were minted with them. It's maintained along
+	// with PrefixPostHashSerialNumberToNFTEntry, and the shares are also stored in the NFTEntry.
+	// <prefix_id, NFTPostHash [32]byte, SerialNumber uint64, OwnerPKID [33]byte> -> <BasisPoints uint64>
+	PrefixNFTOwnershipSharesBySerial []byte `prefix_id:"[114]" is_state:"true" key_schema:"<NFTPostHash [32]byte, SerialNumber uint64, OwnerPKID [33]byte>"`
+
+	// Prefixes for the holders of each creator coin and DAO coin, sorted by balance. They're
+	// maintained along with the BalanceEntries so that the top holders of a coin can be read
+	// without loading all of its BalanceEntries. The balance is stored as MaxUint256 - BalanceNanos
+	// so that iterating forward returns the biggest holders first. Holders with a zero balance
+	// aren't indexed. The indexes aren't part of the state, so nodes that hypersync backfill them
+	// from the BalanceEntries they downloaded.
+	// <prefix_id, CreatorPKID [33]byte, InvertedBalanceNanos [32]byte, HODLerPKID [33]byte> -> <>
+	PrefixCreatorPKIDBalanceNanosHODLerPKID        []byte `prefix_id:"[115]" key_schema:"<CreatorPKID [33]byte, InvertedBalanceNanos [32]byte, HODLerPKID [33]byte>"`
+	PrefixCreatorPKIDDAOCoinBalanceNanosHODLerPKID []byte `prefix_id:"[116]" key_schema:"<CreatorPKID [33]byte, InvertedBalanceNanos [32]byte, HODLerPKID [33]byte>"`
+	// Set once the holder leaderboards have been backfilled from the BalanceEntries in the db.
+	// Nodes that synced before the leaderboards existed don't have it.
+	// <prefix_id> -> <>
+	PrefixHolderLeaderboardsBackfilled []byte `prefix_id:"[117]" key_schema:"<>"`
+
+	// Set once the TransactionMetadata of a txindex that was built before the resolved inputs
+	// existed has been given them, see DbMigrateTxindexResolvedInputs.
+	// <prefix_id> -> <>
+	PrefixTxindexResolvedInputsMigrated []byte `prefix_id:"[118]" is_txindex:"true" key_schema:"<>"`
+
+	// Prefix for the peers that are banned from connecting to the node, keyed by their IP in its
+	// 16-byte form. Bans are written when a peer misbehaves too much or when it's banned through the
+	// Server, and are deleted once they expire. See PeerBanManager.
+	// <prefix_id, IP [16]byte> -> <PeerBanEntry>
+	PrefixPeerBans []byte `prefix_id:"[119]" key_schema:"<IP [16]byte>"`
+
+	// Prefix for the orphan blocks we're holding on to until their parent shows up. The key is
+	// ordered by height so that the lowest orphans can be evicted first. See ProcessOrphanBlock.
+	// <prefix_id, Height uint64, BlockHash> -> <MsgDeSoBlock>
+	PrefixOrphanBlocks []byte `prefix_id:"[120]" key_schema:"<Height uint64, BlockHash>"`
+
+	// Prefix for the blocks that failed to connect with a RuleError, so that they're rejected
+	// without connecting them again if a peer sends them to us after a restart.
+	// <prefix_id, BlockHash> -> <InvalidBlockEntry>
+	PrefixInvalidBlocks []byte `prefix_id:"[121]" key_schema:"<BlockHash>"`
+
+	// Prefixes for the number of posts and comments each public key has made, and for the number
+	// of posts and comments each public key made in each block, keyed by the block height the posts
+	// were confirmed at. The former lets us return a profile's post count without scanning
+	// PrefixPosterPublicKeyTimestampPostHash, and the latter lets the mempool rate limit posts. Both
+	// are maintained incrementally when posts are flushed. They aren't part of the state, so nodes
+	// that hypersync backfill them from the PostEntries they downloaded.
+	// <prefix_id, PosterPublicKey [33]byte> -> <NumPosts uint64, NumComments uint64>
+	PrefixPosterPublicKeyToPostCount []byte `prefix_id:"[122]" key_schema:"<PosterPublicKey [33]byte>"`
+	// <prefix_id, PosterPublicKey [33]byte, BlockHeight uint64> -> <NumPosts uint64>
+	PrefixPosterPublicKeyBlockHeightToNumPosts []byte `prefix_id:"[123]" key_schema:"<PosterPublicKey [33]byte, BlockHeight uint64>"`
+	// Set once the post counts have been backfilled from the PostEntries in the db. Nodes that
+	// synced before the post counts existed don't have it.
+	// <prefix_id> -> <>
+	PrefixPostCountsBackfilled []byte `prefix_id:"[124]" key_schema:"<>"`
+
+	// Prefixes for the index of the hashtags and @mentions in post bodies, which the IndexQueue
+	// maintains:
+	//   - The hashtag index orders the posts with each lowercased hashtag by timestamp.
+	//   - The mention index orders the posts mentioning each PKID by timestamp.
+	//   - The hashtags and mentions of each indexed post are kept by post hash, so that its index
+	//     entries can be removed when the post is edited or its block is disconnected.
+	// <prefix_id, Hashtag []byte, TstampNanos uint64, PostHash [32]byte> -> <>
+	PrefixHashtagTstampNanosPostHash []byte `prefix_id:"[125]" key_schema:"<Hashtag []byte, TstampNanos uint64, PostHash [32]byte>"`
+	// <prefix_id, MentionedPKID [33]byte, TstampNanos uint64, PostHash [32]byte> -> <>
+	PrefixMentionedPKIDTstampNanosPostHash []byte `prefix_id:"[126]" key_schema:"<MentionedPKID [33]byte, TstampNanos uint64, PostHash [32]byte>"`
+	// <prefix_id, PostHash [32]byte> -> <PostHashtagsAndMentionsEntry>
+	PrefixPostHashtagsAndMentionsByPostHash []byte `prefix_id:"[127]" key_schema:"<PostHash [32]byte>"`
+
+	// Prefixes for the full-text search index over post bodies and profile usernames and
+	// descriptions, which the IndexQueue maintains when the search index is enabled:
+	//   - The post index orders the posts with each term by timestamp.
+	//   - The profile index orders the profiles with each term by PKID.
+	//   - The terms of each indexed post and profile are kept by post hash and PKID, so that its
+	//     index entries can be removed when it changes.
+	// <prefix_id, Term []byte, TstampNanos uint64, PostHash [32]byte> -> <>
+	PrefixSearchTermTstampNanosPostHash []byte `prefix_id:"[128]" key_schema:"<Term []byte, TstampNanos uint64, PostHash [32]byte>"`
+	// <prefix_id, PostHash [32]byte> -> <SearchIndexPostEntry>
+	PrefixSearchPostEntryByPostHash []byte `prefix_id:"[129]" key_schema:"<PostHash [32]byte>"`
+	// <prefix_id, Term []byte, PKID [33]byte> -> <>
+	PrefixSearchTermProfilePKID []byte `prefix_id:"[130]" key_schema:"<Term []byte, PKID [33]byte>"`
+	// <prefix_id, PKID [33]byte> -> <SearchIndexProfileEntry>
+	PrefixSearchProfileEntryByPKID []byte `prefix_id:"[131]" key_schema:"<PKID [33]byte>"`
+	// Set once the search index has been built from the posts and profiles in the db. It's removed
+	// whenever the node runs without maintaining the search index, since the index goes stale.
+	// <prefix_id> -> <>
+	PrefixSearchIndexBuilt []byte `prefix_id:"[132]" key_schema:"<>"`
+	// The hotness feed ranks posts by a score that decays over time. Scores are stored as the bits of a
+	// non-negative float64, which sort the same way as the scores.
+	// <prefix_id, Score uint64, PostHash [32]byte> -> <>
+	PrefixHotnessScorePostHash []byte `prefix_id:"[133]" key_schema:"<Score uint64, PostHash [32]byte>"`
+	// <prefix_id, PostHash [32]byte> -> <HotnessFeedEntry>
+	PrefixHotnessFeedEntryByPostHash []byte `prefix_id:"[134]" key_schema:"<PostHash [32]byte>"`
+	// The HotnessFeedParams the scores were computed with. It's removed whenever the node runs without
+	// maintaining the hotness feed, since the scores go stale.
+	// <prefix_id> -> <HotnessFeedParams>
+	PrefixHotnessFeedParams []byte `prefix_id:"[135]" key_schema:"<>"`
+	// Prefixes for the number and DESO value of the diamonds each post has received, each PKID has
+	// received and each PKID has given, and for leaderboards that order them by value. They're
+	// maintained incrementally when diamonds are flushed, so that clients don't have to walk
+	// PrefixDiamondedPostHashDiamonderPKIDDiamondLevel. They aren't part of the state, so nodes that
+	// hypersync backfill them from the DiamondEntries they downloaded. Posts and PKIDs without
+	// diamonds aren't on the leaderboards.
+	// <prefix_id, PostHash [32]byte> -> <DiamondAggregate>
+	PrefixDiamondAggregateByPostHash []byte `prefix_id:"[136]" key_schema:"<PostHash [32]byte>"`
+	// <prefix_id, ReceiverPKID [33]byte> -> <DiamondAggregate>
+	PrefixDiamondsReceivedAggregateByPKID []byte `prefix_id:"[137]" key_schema:"<ReceiverPKID [33]byte>"`
+	// <prefix_id, SenderPKID [33]byte> -> <DiamondAggregate>
+	PrefixDiamondsSentAggregateByPKID []byte `prefix_id:"[138]" key_schema:"<SenderPKID [33]byte>"`
+	// <prefix_id, TotalValueNanos uint64, PostHash [32]byte> -> <>
+	PrefixDiamondValuePostHash []byte `prefix_id:"[139]" key_schema:"<TotalValueNanos uint64, PostHash [32]byte>"`
+	// <prefix_id, TotalValueNanos uint64, ReceiverPKID [33]byte> -> <>
+	PrefixDiamondsReceivedValuePKID []byte `prefix_id:"[140]" key_schema:"<TotalValueNanos uint64, ReceiverPKID [33]byte>"`
+	// <prefix_id, TotalValueNanos uint64, SenderPKID [33]byte> -> <>
+	PrefixDiamondsSentValuePKID []byte `prefix_id:"[141]" key_schema:"<TotalValueNanos uint64, SenderPKID [33]byte>"`
+	// Set once the diamond aggregates have been backfilled from the DiamondEntries in the db. Nodes
+	// that synced before the diamond aggregates existed don't have it.
+	// <prefix_id> -> <>
+	PrefixDiamondAggregatesBackfilled []byte `prefix_id:"[142]" key_schema:"<>"`
+
+	// The height of the highest main chain block whose utxo operations were pruned by the utxo
+	// operation retention policy. The block bodies are kept, so the operations can be regenerated
+	// from the snapshot, see Blockchain.SetUtxoOpsRetention.
+	// <prefix_id> -> <UtxoOpsPrunedBlockHeight uint64>
+	PrefixUtxoOpsPrunedBlockHeight []byte `prefix_id:"[143]" key_schema:"<>"`
+	// Set once the NFT collection summaries have been backfilled from the NFT, bid, and accepted
+	// bid indexes. Nodes that synced before the summaries existed, or before they were maintained
+	// incrementally, don't have it.
+	// <prefix_id> -> <>
+	PrefixNFTCollectionSummariesBackfilled []byte `prefix_id:"[144]" key_schema:"<>"`
+	// NEXT_TAG: 145
 }
 
 // StatePrefixToDeSoEncoder maps each state prefix to a DeSoEncoder type that is stored under that prefix.
@@ -479,6 +939,45 @@ func StatePrefixToDeSoEncoder(prefix []byte) (_isEncoder bool, _encoder DeSoEnco
 	} else if bytes.Equal(prefix, Prefixes.PrefixDAOCoinLimitOrderByOrderID) {
 		// prefix_id:"[62]"
 		return true, &DAOCoinLimitOrderEntry{}
+	} else if bytes.Equal(prefix, Prefixes.PrefixPostHashToNFTCollectionSummary) {
+		// prefix_id:"[63]"
+		return false, nil
+	} else if bytes.Equal(prefix, Prefixes.PrefixPublicKeyToNonce) {
+		// prefix_id:"[64]"
+		return false, nil
+	} else if bytes.Equal(prefix, Prefixes.PrefixSignerSetByOwnerPubKeyAndID) {
+		// prefix_id:"[65]"
+		return true, &SignerSetEntry{}
+	} else if bytes.Equal(prefix, Prefixes.PrefixSignerSetMemberByMemberPubKeyOwnerPubKeyAndID) {
+		// prefix_id:"[66]"
+		return false, nil
+	} else if bytes.Equal(prefix, Prefixes.PrefixDerivedKeyAllowedTxnTypes) {
+		// prefix_id:"[78]"
+		return false, nil
+	} else if bytes.Equal(prefix, Prefixes.PrefixDAOCoinLimitOrderTrigger) {
+		// prefix_id:"[102]"
+		return true, &DAOCoinLimitOrderTriggerEntry{}
+	} else if bytes.Equal(prefix, Prefixes.PrefixDAOCoinLimitOrderTriggerByOrderID) {
+		// prefix_id:"[103]"
+		return true, &DAOCoinLimitOrderTriggerEntry{}
+	} else if bytes.Equal(prefix, Prefixes.PrefixDAOCoinLimitOrderByExpirationBlockHeight) {
+		// prefix_id:"[104]"
+		return true, &DAOCoinLimitOrderEntry{}
+	} else if bytes.Equal(prefix, Prefixes.PrefixPostAssociationByTypeTransactorPostHash) {
+		// prefix_id:"[106]"
+		return true, &PostAssociationEntry{}
+	} else if bytes.Equal(prefix, Prefixes.PrefixPostAssociationByTypePostHashTransactor) {
+		// prefix_id:"[107]"
+		return true, &PostAssociationEntry{}
+	} else if bytes.Equal(prefix, Prefixes.PrefixUserAssociationByTransactorTargetType) {
+		// prefix_id:"[108]"
+		return true, &UserAssociationEntry{}
+	} else if bytes.Equal(prefix, Prefixes.PrefixUserAssociationByTargetTransactorType) {
+		// prefix_id:"[109]"
+		return true, &UserAssociationEntry{}
+	} else if bytes.Equal(prefix, Prefixes.PrefixNFTOwnershipSharesBySerial) {
+		// prefix_id:"[114]"
+		return false, nil
 	}
 
 	return true, nil
@@ -534,56 +1033,40 @@ type DBStatePrefixes struct {
 	// StatePrefixesList is a list of state prefixes.
 	StatePrefixesList [][]byte
 
+	// NonStatePrefixesList is a list of the prefixes that aren't state prefixes.
+	NonStatePrefixesList [][]byte
+
 	// TxIndexPrefixes is a list of TxIndex prefixes
 	TxIndexPrefixes [][]byte
+
+	// PrefixNames maps prefixes to the name of their DBPrefixes field.
+	PrefixNames map[byte]string
+
+	// MaxValueSizes maps prefixes to the max_value_size tag of their DBPrefixes field, for the
+	// prefixes that have one. See CheckDBValueSize.
+	MaxValueSizes map[byte]int
 }
 
-// GetStatePrefixes() creates a DBStatePrefixes object from the DBPrefixes struct and returns it. We
-// parse the prefix_id and is_state tags.
+// GetStatePrefixes() creates a DBStatePrefixes object from the PrefixRegistry and returns it.
 func GetStatePrefixes() *DBStatePrefixes {
 	// Initialize the DBStatePrefixes struct.
 	statePrefixes := &DBStatePrefixes{}
 	statePrefixes.Prefixes = &DBPrefixes{}
 	statePrefixes.StatePrefixesMap = make(map[byte]bool)
+	statePrefixes.PrefixNames = make(map[byte]string)
+	statePrefixes.MaxValueSizes = make(map[byte]int)
 
-	// Iterate over all the DBPrefixes fields and parse the prefix_id and is_state tags.
-	prefixElements := reflect.ValueOf(statePrefixes.Prefixes).Elem()
-	structFields := prefixElements.Type()
-	for i := 0; i < structFields.NumField(); i++ {
-		prefixField := prefixElements.Field(i)
-		prefixId := getPrefixIdValue(structFields.Field(i), prefixField.Type())
-		prefixBytes := prefixId.Bytes()
-		if len(prefixBytes) > MaxPrefixLen {
-			panic(any(fmt.Errorf("prefix (%v) is longer than MaxPrefixLen: (%v)",
-				structFields.Field(i).Name, MaxPrefixLen)))
-		}
-		prefix := prefixBytes[0]
-		if statePrefixes.StatePrefixesMap[prefix] {
-			panic(any(fmt.Errorf("prefix (%v) already exists in StatePrefixesMap. You created a "+
-				"prefix overlap, fix it", structFields.Field(i).Name)))
-		}
-		if structFields.Field(i).Tag.Get("is_state") == "true" {
-			statePrefixes.StatePrefixesMap[prefix] = true
-			statePrefixes.StatePrefixesList = append(statePrefixes.StatePrefixesList, []byte{prefix})
-		} else if structFields.Field(i).Tag.Get("is_txindex") == "true" {
-			statePrefixes.TxIndexPrefixes = append(statePrefixes.TxIndexPrefixes, []byte{prefix})
-			statePrefixes.StatePrefixesMap[prefix] = false
-		} else {
-			statePrefixes.StatePrefixesMap[prefix] = false
+	for _, prefixInfo := range PrefixRegistry.GetPrefixInfos() {
+		prefix := prefixInfo.Prefix[0]
+		statePrefixes.StatePrefixesMap[prefix] = prefixInfo.IsState
+		statePrefixes.PrefixNames[prefix] = prefixInfo.Name
+		if prefixInfo.MaxValueSize != 0 {
+			statePrefixes.MaxValueSizes[prefix] = prefixInfo.MaxValueSize
 		}
 	}
-	// Sort prefixes.
-	sort.Slice(statePrefixes.StatePrefixesList, func(i int, j int) bool {
-		switch bytes.Compare(statePrefixes.StatePrefixesList[i], statePrefixes.StatePrefixesList[j]) {
-		case 0:
-			return true
-		case -1:
-			return true
-		case 1:
-			return false
-		}
-		return false
-	})
+	statePrefixes.StatePrefixesList = PrefixRegistry.StatePrefixes()
+	statePrefixes.NonStatePrefixesList = PrefixRegistry.NonStatePrefixes()
+	statePrefixes.TxIndexPrefixes = PrefixRegistry.TxIndexPrefixes()
 	return statePrefixes
 }
 
@@ -652,6 +1135,16 @@ func EncodeKeyAndValueForChecksum(key []byte, value []byte, blockHeight uint64)
 // prior to DB writes. In particular, we use it to maintain a dynamic LRU cache, compute the
 // state checksum, and to build DB snapshots with ancestral records.
 func DBSetWithTxn(txn *badger.Txn, snap *Snapshot, key []byte, value []byte) error {
+	return DBSetWithDeSoDBTxn(WrapBadgerTxn(txn), snap, key, value)
+}
+
+// DBSetWithDeSoDBTxn is DBSetWithTxn for any DeSoDB backend.
+func DBSetWithDeSoDBTxn(txn DeSoDBTxn, snap *Snapshot, key []byte, value []byte) error {
+	// Refuse values that are over their prefix's size limit before touching anything else.
+	if err := CheckDBValueSize(key, value); err != nil {
+		return errors.Wrapf(err, "DBSetWithTxn: ")
+	}
+
 	// We only cache / update ancestral records when we're dealing with state prefix.
 	isState := snap != nil && snap.isState(key)
 	var ancestralValue []byte
@@ -662,7 +1155,7 @@ func DBSetWithTxn(txn *badger.Txn, snap *Snapshot, key []byte, value []byte) err
 	if isState {
 		// We check if we've already read this key and stored it in the cache.
 		// Otherwise, we fetch the current value of this record from the DB.
-		ancestralValue, getError = DBGetWithTxn(txn, snap, key)
+		ancestralValue, getError = DBGetWithDeSoDBTxn(txn, snap, key)
 
 		// If there is some error with the DB read, other than non-existent key, we return.
 		if getError != nil && getError != badger.ErrKeyNotFound {
@@ -671,23 +1164,47 @@ func DBSetWithTxn(txn *badger.Txn, snap *Snapshot, key []byte, value []byte) err
 		}
 	}
 
+	// Give tests a chance to kill the write path right before the record is written.
+	if err := _injectDBFault(DBFaultPointSet); err != nil {
+		return errors.Wrapf(err, "DBSetWithTxn: Problem setting record in DB with key: %v", key)
+	}
+
 	// We update the DB record with the intended value.
+	_recordDBOperation(txn.BadgerTxn(), DBOperationWrite, key)
+	if profile := _blockConnectProfileForTxn(txn.BadgerTxn(), key); profile != nil {
+		defer profile.recordFlushOp(key, time.Now())
+	}
+	dbStats := _prepareDBStatsRecord(txn, key)
 	err := txn.Set(key, value)
 	if err != nil {
 		return errors.Wrapf(err, "DBSetWithTxn: Problem setting record "+
 			"in DB with key: %v, value: %v", key, value)
 	}
+	_recordStateChange(txn.BadgerTxn(), StateChangeOperationTypeUpsert, key, value)
+	dbStats.recordSet(value)
 
 	// After a successful DB write, we update the snapshot.
 	if isState {
 		keyString := hex.EncodeToString(key)
 
+		// If the txn is part of a FlushSession, the snapshot is only updated once the txn commits.
+		if session := snap.flushSessionForTxn(txn.BadgerTxn()); session != nil {
+			session._stageSet(key, keyString, value, ancestralValue, getError != badger.ErrKeyNotFound)
+			return nil
+		}
+
 		// Update ancestral record structures depending on the existing DB record.
 		if err := snap.PrepareAncestralRecord(keyString, ancestralValue, getError != badger.ErrKeyNotFound); err != nil {
 			return errors.Wrapf(err, "DBSetWithTxn: Problem preparing ancestral record")
 		}
-		// Now save the newest record to cache.
-		snap.DatabaseCache.Add(keyString, value)
+		// Now save the newest record to cache, unless caching is disabled for the prefix. In that case
+		// we still drop any record cached before it was disabled, so that it can't go stale.
+		if IsDatabaseCacheEnabledForKey(key) {
+			snap.DatabaseCache.Add(keyString, value)
+		} else {
+			snap.DatabaseCache.Delete(keyString)
+		}
+		snap.NegativeLookupCache.Invalidate(keyString)
 
 		if !snap.disableChecksum {
 			// We have to remove the previous value from the state checksum.
@@ -707,29 +1224,52 @@ func DBSetWithTxn(txn *badger.Txn, snap *Snapshot, key []byte, value []byte) err
 // Whenever we read/write records in the DB, we place a copy in the LRU cache to save
 // us lookup time.
 func DBGetWithTxn(txn *badger.Txn, snap *Snapshot, key []byte) ([]byte, error) {
-	// We only cache / update ancestral records when we're dealing with state prefix.
-	isState := snap != nil && snap.isState(key)
+	return DBGetWithDeSoDBTxn(WrapBadgerTxn(txn), snap, key)
+}
+
+// DBGetWithDeSoDBTxn is DBGetWithTxn for any DeSoDB backend.
+func DBGetWithDeSoDBTxn(txn DeSoDBTxn, snap *Snapshot, key []byte) ([]byte, error) {
+	// We only cache / update ancestral records when we're dealing with state prefix, and caching
+	// can also be disabled for some of the state prefixes.
+	if err := CheckPrefixSynced(key); err != nil {
+		return nil, err
+	}
+	isCached := snap != nil && snap.isState(key) && IsDatabaseCacheEnabledForKey(key)
 	keyString := hex.EncodeToString(key)
+	_recordDBOperation(txn.BadgerTxn(), DBOperationRead, key)
 
 	// Lookup the snapshot cache and check if we've already stored a value there.
-	if isState {
-		if val, exists := snap.DatabaseCache.Lookup(keyString); exists {
+	if isCached {
+		val, exists := snap.DatabaseCache.Lookup(keyString)
+		snap.DatabaseCacheMetrics.RecordLookupForKey(key, exists)
+		if exists {
 			return val.([]byte), nil
 		}
+		// We might also know that the record doesn't exist.
+		if snap.NegativeLookupCache.IsKnownMissing(keyString) {
+			return nil, badger.ErrKeyNotFound
+		}
 	}
 
 	// If record doesn't exist in cache, we get it from the DB.
-	item, err := txn.Get(key)
-	if err != nil {
+	itemData, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound && isCached {
+		// Same as with the DatabaseCache, we don't update the cache during a flush.
+		// The read timestamp tells the cache whether the txn could have missed a recent write,
+		// so we can only add the key with a badger txn.
+		snap.Status.MemoryLock.Lock()
+		if badgerTxn := txn.BadgerTxn(); badgerTxn != nil && !snap.Status.IsFlushingWithoutLock() {
+			snap.NegativeLookupCache.AddMissing(keyString, badgerTxn.ReadTs())
+		}
+		snap.Status.MemoryLock.Unlock()
 		return nil, err
 	}
-	itemData, err := item.ValueCopy(nil)
 	if err != nil {
 		return nil, err
 	}
 
 	// If a flush takes place, we don't update cache. It will be updated in DBSetWithTxn.
-	if isState {
+	if isCached {
 		// Hold the snapshot memory lock just to be e
 		snap.Status.MemoryLock.Lock()
 		defer snap.Status.MemoryLock.Unlock()
@@ -743,6 +1283,11 @@ func DBGetWithTxn(txn *badger.Txn, snap *Snapshot, key []byte) ([]byte, error) {
 // DBDeleteWithTxn is a wrapper function around BadgerDB delete function.
 // It allows us to update the snapshot LRU cache, checksum, and ancestral records.
 func DBDeleteWithTxn(txn *badger.Txn, snap *Snapshot, key []byte) error {
+	return DBDeleteWithDeSoDBTxn(WrapBadgerTxn(txn), snap, key)
+}
+
+// DBDeleteWithDeSoDBTxn is DBDeleteWithTxn for any DeSoDB backend.
+func DBDeleteWithDeSoDBTxn(txn DeSoDBTxn, snap *Snapshot, key []byte) error {
 	var ancestralValue []byte
 	var getError error
 	isState := snap != nil && snap.isState(key)
@@ -752,7 +1297,7 @@ func DBDeleteWithTxn(txn *badger.Txn, snap *Snapshot, key []byte) error {
 	if isState {
 		// We check if we've already read this key and stored it in the cache.
 		// Otherwise, we fetch the current value of this record from the DB.
-		ancestralValue, getError = DBGetWithTxn(txn, snap, key)
+		ancestralValue, getError = DBGetWithDeSoDBTxn(txn, snap, key)
 		// If the key doesn't exist then there is no point in deleting this entry.
 		if getError == badger.ErrKeyNotFound {
 			return nil
@@ -765,22 +1310,35 @@ func DBDeleteWithTxn(txn *badger.Txn, snap *Snapshot, key []byte) error {
 		}
 	}
 
+	_recordDBOperation(txn.BadgerTxn(), DBOperationDelete, key)
+	if profile := _blockConnectProfileForTxn(txn.BadgerTxn(), key); profile != nil {
+		defer profile.recordFlushOp(key, time.Now())
+	}
+	dbStats := _prepareDBStatsRecord(txn, key)
 	err := txn.Delete(key)
 	if err != nil {
 		return errors.Wrapf(err, "DBDeleteWithTxn: Problem deleting record "+
 			"from DB with key: %v", key)
 	}
+	_recordStateChange(txn.BadgerTxn(), StateChangeOperationTypeDelete, key, nil)
+	dbStats.recordDelete()
 
 	// After a successful DB delete, we update the snapshot.
 	if isState {
 		keyString := hex.EncodeToString(key)
 
+		if session := snap.flushSessionForTxn(txn.BadgerTxn()); session != nil {
+			session._stageDelete(key, keyString, ancestralValue)
+			return nil
+		}
+
 		// Update ancestral record structures depending on the existing DB record.
 		if err := snap.PrepareAncestralRecord(keyString, ancestralValue, true); err != nil {
 			return errors.Wrapf(err, "DBDeleteWithTxn: Problem preparing ancestral record")
 		}
 		// Now delete the past record from the cache.
 		snap.DatabaseCache.Delete(keyString)
+		snap.NegativeLookupCache.Invalidate(keyString)
 		// We have to remove the previous value from the state checksum.
 		// Because checksum is commutative, we can safely remove the past value here.
 		if !snap.disableChecksum {
@@ -790,6 +1348,251 @@ func DBDeleteWithTxn(txn *badger.Txn, snap *Snapshot, key []byte) error {
 	return nil
 }
 
+// DBBatchSetWithTxn sets all the entries, with the same result as calling DBSetWithTxn on each of
+// them in order. Instead of updating the snapshot record by record, it reads the current values of
+// the state records up front, then prepares their ancestral records and updates the state checksum
+// once for the whole batch, which makes large flushes much cheaper. If a key appears more than once,
+// its last value is the one that's written.
+func DBBatchSetWithTxn(txn *badger.Txn, snap *Snapshot, entries []*DBEntry) error {
+	entries = _dedupeDBEntries(entries)
+	for _, entry := range entries {
+		if err := CheckDBValueSize(entry.Key, entry.Value); err != nil {
+			return errors.Wrapf(err, "DBBatchSetWithTxn: ")
+		}
+	}
+
+	// Read the current values of the state records before anything is written.
+	ancestralRecords := make(map[string]*AncestralRecordValue)
+	var removedEntries, addedEntries []*DBEntry
+	for _, entry := range entries {
+		if snap == nil || !snap.isState(entry.Key) {
+			continue
+		}
+		ancestralValue, getError := DBGetWithTxn(txn, snap, entry.Key)
+		if getError != nil && getError != badger.ErrKeyNotFound {
+			return errors.Wrapf(getError, "DBBatchSetWithTxn: problem reading record "+
+				"from DB with key: %v", entry.Key)
+		}
+		ancestralRecords[hex.EncodeToString(entry.Key)] = &AncestralRecordValue{
+			Value:   ancestralValue,
+			Existed: getError != badger.ErrKeyNotFound,
+		}
+		if getError == nil {
+			removedEntries = append(removedEntries, &DBEntry{Key: entry.Key, Value: ancestralValue})
+		}
+		addedEntries = append(addedEntries, entry)
+	}
+
+	// Give tests a chance to kill the write path right before the records are written.
+	if err := _injectDBFault(DBFaultPointSet); err != nil {
+		return errors.Wrapf(err, "DBBatchSetWithTxn: Problem setting %v records in DB", len(entries))
+	}
+
+	for _, entry := range entries {
+		_recordDBOperation(txn, DBOperationWrite, entry.Key)
+		profile := _blockConnectProfileForTxn(txn, entry.Key)
+		setStartTime := time.Now()
+		dbStats := _prepareDBStatsRecord(WrapBadgerTxn(txn), entry.Key)
+		if err := txn.Set(entry.Key, entry.Value); err != nil {
+			return errors.Wrapf(err, "DBBatchSetWithTxn: Problem setting record "+
+				"in DB with key: %v, value: %v", entry.Key, entry.Value)
+		}
+		dbStats.recordSet(entry.Value)
+		if profile != nil {
+			profile.recordFlushOp(entry.Key, setStartTime)
+		}
+	}
+
+	// After a successful DB write, we update the snapshot.
+	if len(ancestralRecords) == 0 {
+		return nil
+	}
+	if session := snap.flushSessionForTxn(txn); session != nil {
+		for _, entry := range addedEntries {
+			keyString := hex.EncodeToString(entry.Key)
+			ancestralRecord := ancestralRecords[keyString]
+			session._stageSet(entry.Key, keyString, entry.Value, ancestralRecord.Value, ancestralRecord.Existed)
+		}
+		return nil
+	}
+	if err := snap.PrepareAncestralRecords(ancestralRecords); err != nil {
+		return errors.Wrapf(err, "DBBatchSetWithTxn: Problem preparing ancestral records")
+	}
+	for _, entry := range addedEntries {
+		keyString := hex.EncodeToString(entry.Key)
+		if IsDatabaseCacheEnabledForKey(entry.Key) {
+			snap.DatabaseCache.Add(keyString, entry.Value)
+		} else {
+			snap.DatabaseCache.Delete(keyString)
+		}
+		snap.NegativeLookupCache.Invalidate(keyString)
+	}
+	if !snap.disableChecksum {
+		snap.UpdateChecksumBytesInBatch(removedEntries, addedEntries)
+	}
+	return nil
+}
+
+// DBBatchDeleteWithTxn deletes all the keys, with the same result as calling DBDeleteWithTxn on each
+// of them, doing the snapshot bookkeeping once for the whole batch like DBBatchSetWithTxn.
+func DBBatchDeleteWithTxn(txn *badger.Txn, snap *Snapshot, keys [][]byte) error {
+	keysToDelete := [][]byte{}
+	ancestralRecords := make(map[string]*AncestralRecordValue)
+	var removedEntries []*DBEntry
+	for _, key := range keys {
+		keyString := hex.EncodeToString(key)
+		if _, exists := ancestralRecords[keyString]; exists {
+			continue
+		}
+		if snap == nil || !snap.isState(key) {
+			keysToDelete = append(keysToDelete, key)
+			continue
+		}
+		ancestralValue, getError := DBGetWithTxn(txn, snap, key)
+		// If the key doesn't exist then there is no point in deleting this entry.
+		if getError == badger.ErrKeyNotFound {
+			continue
+		}
+		if getError != nil {
+			return errors.Wrapf(getError, "DBBatchDeleteWithTxn: problem checking for DB record "+
+				"with key: %v", key)
+		}
+		ancestralRecords[keyString] = &AncestralRecordValue{Value: ancestralValue, Existed: true}
+		removedEntries = append(removedEntries, &DBEntry{Key: key, Value: ancestralValue})
+		keysToDelete = append(keysToDelete, key)
+	}
+
+	for _, key := range keysToDelete {
+		_recordDBOperation(txn, DBOperationDelete, key)
+		profile := _blockConnectProfileForTxn(txn, key)
+		deleteStartTime := time.Now()
+		dbStats := _prepareDBStatsRecord(WrapBadgerTxn(txn), key)
+		if err := txn.Delete(key); err != nil {
+			return errors.Wrapf(err, "DBBatchDeleteWithTxn: Problem deleting record "+
+				"from DB with key: %v", key)
+		}
+		dbStats.recordDelete()
+		if profile != nil {
+			profile.recordFlushOp(key, deleteStartTime)
+		}
+	}
+
+	// After a successful DB delete, we update the snapshot.
+	if len(ancestralRecords) == 0 {
+		return nil
+	}
+	if session := snap.flushSessionForTxn(txn); session != nil {
+		for _, entry := range removedEntries {
+			session._stageDelete(entry.Key, hex.EncodeToString(entry.Key), entry.Value)
+		}
+		return nil
+	}
+	if err := snap.PrepareAncestralRecords(ancestralRecords); err != nil {
+		return errors.Wrapf(err, "DBBatchDeleteWithTxn: Problem preparing ancestral records")
+	}
+	for keyString := range ancestralRecords {
+		snap.DatabaseCache.Delete(keyString)
+		snap.NegativeLookupCache.Invalidate(keyString)
+	}
+	if !snap.disableChecksum {
+		snap.UpdateChecksumBytesInBatch(removedEntries, nil)
+	}
+	return nil
+}
+
+// DBBatchSet sets all the entries. When none of them are state records it writes them with a badger
+// WriteBatch, which isn't bound by the size limit of a single txn. Otherwise the snapshot has to read
+// the current values, so the entries are set with DBBatchSetWithTxn in a single txn.
+func DBBatchSet(handle *badger.DB, snap *Snapshot, entries []*DBEntry) error {
+	if _hasStateDBEntry(snap, entries) {
+		return handle.Update(func(txn *badger.Txn) error {
+			return DBBatchSetWithTxn(txn, snap, entries)
+		})
+	}
+
+	entries = _dedupeDBEntries(entries)
+	writeBatch := handle.NewWriteBatch()
+	defer writeBatch.Cancel()
+	keys := [][]byte{}
+	for _, entry := range entries {
+		if err := CheckDBValueSize(entry.Key, entry.Value); err != nil {
+			return errors.Wrapf(err, "DBBatchSet: ")
+		}
+		keys = append(keys, entry.Key)
+	}
+	dbStats := _prepareDBStatsRecords(handle, keys)
+	for _, entry := range entries {
+		if err := writeBatch.Set(entry.Key, entry.Value); err != nil {
+			return errors.Wrapf(err, "DBBatchSet: Problem setting record in DB with key: %v", entry.Key)
+		}
+	}
+	if err := writeBatch.Flush(); err != nil {
+		return errors.Wrapf(err, "DBBatchSet: Problem flushing write batch")
+	}
+	for ii, record := range dbStats {
+		record.recordSet(entries[ii].Value)
+	}
+	return nil
+}
+
+// DBBatchDelete deletes all the keys, using a badger WriteBatch when none of them are state records
+// like DBBatchSet.
+func DBBatchDelete(handle *badger.DB, snap *Snapshot, keys [][]byte) error {
+	entries := []*DBEntry{}
+	for _, key := range keys {
+		entries = append(entries, &DBEntry{Key: key})
+	}
+	if _hasStateDBEntry(snap, entries) {
+		return handle.Update(func(txn *badger.Txn) error {
+			return DBBatchDeleteWithTxn(txn, snap, keys)
+		})
+	}
+
+	dbStats := _prepareDBStatsRecords(handle, keys)
+	writeBatch := handle.NewWriteBatch()
+	defer writeBatch.Cancel()
+	for _, key := range keys {
+		if err := writeBatch.Delete(key); err != nil {
+			return errors.Wrapf(err, "DBBatchDelete: Problem deleting record from DB with key: %v", key)
+		}
+	}
+	if err := writeBatch.Flush(); err != nil {
+		return errors.Wrapf(err, "DBBatchDelete: Problem flushing write batch")
+	}
+	for _, record := range dbStats {
+		record.recordDelete()
+	}
+	return nil
+}
+
+func _hasStateDBEntry(snap *Snapshot, entries []*DBEntry) bool {
+	if snap == nil {
+		return false
+	}
+	for _, entry := range entries {
+		if snap.isState(entry.Key) {
+			return true
+		}
+	}
+	return false
+}
+
+// _dedupeDBEntries keeps the last value of every key, in the order each key first appears.
+func _dedupeDBEntries(entries []*DBEntry) []*DBEntry {
+	entryIndexes := make(map[string]int)
+	dedupedEntries := []*DBEntry{}
+	for _, entry := range entries {
+		keyString := string(entry.Key)
+		if entryIndex, exists := entryIndexes[keyString]; exists {
+			dedupedEntries[entryIndex] = entry
+			continue
+		}
+		entryIndexes[keyString] = len(dedupedEntries)
+		dedupedEntries = append(dedupedEntries, entry)
+	}
+	return dedupedEntries
+}
+
 // DBIteratePrefixKeys fetches a chunk of records from the provided db at a provided prefix,
 // and beginning with the provided startKey. The chunk will have a total size of at least targetBytes.
 // If the startKey is a valid key in the db, it will be the first entry in the returned dbEntries.
@@ -801,6 +1604,18 @@ func DBIteratePrefixKeys(db *badger.DB, prefix []byte, startKey []byte, targetBy
 	var totalBytes int
 	var isChunkFull bool
 
+	// Don't serve snapshot chunks for prefixes we don't have.
+	if err := CheckPrefixSynced(prefix); err != nil {
+		return nil, false, err
+	}
+
+	_, dbSpan := StartDBSpan(context.Background(), "DBIteratePrefixKeys", prefix)
+	defer func() {
+		dbSpan.SetAttributes(attribute.Int("db.scan.entries", len(dbEntries)),
+			attribute.Int("db.scan.bytes", totalBytes))
+		dbSpan.End(_err)
+	}()
+
 	err := db.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 
@@ -1041,6 +1856,110 @@ func EnumerateKeysForPrefix(db *badger.DB, dbPrefix []byte) (_keysFound [][]byte
 	return _enumerateKeysForPrefix(db, dbPrefix)
 }
 
+// DBIteratorOptions configures DBForEachKeyWithPrefix. A nil *DBIteratorOptions iterates the keys in
+// order and fetches their values.
+type DBIteratorOptions struct {
+	// Reverse iterates from the last key under the prefix to the first.
+	Reverse bool
+	// KeysOnly skips fetching the values, and passes a nil value to the callback. It's much cheaper
+	// for indexes whose information is all in the key.
+	KeysOnly bool
+}
+
+// DBForEachKeyWithPrefix calls fn on every key under the prefix, and its value, without holding
+// them all in memory. The key and value are only valid until fn returns, so copy them if you need
+// to hold on to them. The iteration stops early if fn returns stop or an error, and the error is
+// returned.
+func DBForEachKeyWithPrefix(db *badger.DB, prefix []byte, opts *DBIteratorOptions,
+	fn func(key []byte, value []byte) (_stop bool, _err error)) error {
+
+	return db.View(func(txn *badger.Txn) error {
+		return DBForEachKeyWithPrefixWithTxn(txn, prefix, opts, fn)
+	})
+}
+
+func DBForEachKeyWithPrefixWithTxn(txn *badger.Txn, prefix []byte, opts *DBIteratorOptions,
+	fn func(key []byte, value []byte) (_stop bool, _err error)) error {
+
+	return _dbForEachKeyWithPrefixWithTxn(txn, "ForEachKeyWithPrefix", prefix, opts, fn)
+}
+
+// _dbForEachKeyWithPrefixWithTxn lets the enumerators built on DBForEachKeyWithPrefix keep their
+// own span names.
+func _dbForEachKeyWithPrefixWithTxn(txn *badger.Txn, spanName string, prefix []byte,
+	opts *DBIteratorOptions, fn func(key []byte, value []byte) (_stop bool, _err error),
+	spanAttrs ...attribute.KeyValue) (_err error) {
+
+	if opts == nil {
+		opts = &DBIteratorOptions{}
+	}
+	if err := CheckPrefixSynced(prefix); err != nil {
+		return err
+	}
+
+	numEntries := 0
+	_, dbSpan := StartDBSpan(context.Background(), spanName, prefix,
+		append(spanAttrs, attribute.Bool("db.scan.reverse", opts.Reverse))...)
+	defer func() {
+		dbSpan.SetAttributes(attribute.Int("db.scan.entries", numEntries))
+		dbSpan.End(_err)
+	}()
+
+	badgerOpts := badger.DefaultIteratorOptions
+	badgerOpts.Reverse = opts.Reverse
+	badgerOpts.PrefetchValues = !opts.KeysOnly
+	nodeIterator := txn.NewIterator(badgerOpts)
+	defer nodeIterator.Close()
+
+	if !opts.Reverse {
+		nodeIterator.Seek(prefix)
+	} else if upperBound := _dbPrefixUpperBound(prefix); upperBound != nil {
+		// Seeking to prefix + 0xff would miss the keys that continue past the 0xff byte, so seek to
+		// the first key after the prefix instead, and step back from it if it exists.
+		nodeIterator.Seek(upperBound)
+		if nodeIterator.Valid() && !nodeIterator.ValidForPrefix(prefix) {
+			nodeIterator.Next()
+		}
+	} else {
+		nodeIterator.Rewind()
+	}
+	for ; nodeIterator.ValidForPrefix(prefix); nodeIterator.Next() {
+		numEntries++
+		item := nodeIterator.Item()
+		var stop bool
+		var err error
+		if opts.KeysOnly {
+			stop, err = fn(item.Key(), nil)
+		} else {
+			err = item.Value(func(value []byte) error {
+				var fnErr error
+				stop, fnErr = fn(item.Key(), value)
+				return fnErr
+			})
+		}
+		if err != nil {
+			return err
+		}
+		if stop {
+			break
+		}
+	}
+	return nil
+}
+
+// _dbPrefixUpperBound returns the smallest key that's greater than every key under the prefix, or nil
+// if there isn't one because the prefix is empty or all 0xff bytes.
+func _dbPrefixUpperBound(prefix []byte) []byte {
+	upperBound := append([]byte{}, prefix...)
+	for ii := len(upperBound) - 1; ii >= 0; ii-- {
+		if upperBound[ii] < 0xff {
+			upperBound[ii]++
+			return upperBound[:ii+1]
+		}
+	}
+	return nil
+}
+
 // A helper function to enumerate all of the values for a particular prefix.
 func _enumerateKeysForPrefix(db *badger.DB, dbPrefix []byte) (_keysFound [][]byte, _valsFound [][]byte) {
 	keysFound := [][]byte{}
@@ -1066,25 +1985,105 @@ func _enumerateKeysForPrefixWithTxn(txn *badger.Txn, dbPrefix []byte) (_keysFoun
 	keysFound := [][]byte{}
 	valsFound := [][]byte{}
 
-	opts := badger.DefaultIteratorOptions
-	nodeIterator := txn.NewIterator(opts)
-	defer nodeIterator.Close()
-	prefix := dbPrefix
-	for nodeIterator.Seek(prefix); nodeIterator.ValidForPrefix(prefix); nodeIterator.Next() {
-		key := nodeIterator.Item().Key()
-		keyCopy := make([]byte, len(key))
-		copy(keyCopy[:], key[:])
-
-		valCopy, err := nodeIterator.Item().ValueCopy(nil)
-		if err != nil {
-			return nil, nil, err
-		}
-		keysFound = append(keysFound, keyCopy)
-		valsFound = append(valsFound, valCopy)
+	err := _dbForEachKeyWithPrefixWithTxn(txn, "EnumerateKeysForPrefix", dbPrefix, nil,
+		func(key []byte, value []byte) (bool, error) {
+			keysFound = append(keysFound, append([]byte{}, key...))
+			valsFound = append(valsFound, append([]byte(nil), value...))
+			return false, nil
+		})
+	if err != nil {
+		return nil, nil, err
 	}
 	return keysFound, valsFound, nil
 }
 
+// ParallelScanPrefix calls fn on every record under the prefix, like _enumerateKeysForPrefix, except
+// that the records are never all held in memory and the scan is split across goroutines. The keyspace
+// is partitioned into at most 256 shards by the byte that follows the prefix, and each shard is scanned
+// in its own read-only txn. fn is called concurrently from different shards, but records within a
+// shard are passed in key order. The key and value passed to fn are only valid until fn returns, so
+// copy them if you need to hold on to them. If fn returns an error, all shards stop and the first
+// error is returned.
+//
+// Since shards use separate txns, the scan doesn't see a single consistent view of the db if there
+// are concurrent writes. It's meant for offline jobs like recomputing the checksum or counting keys.
+func ParallelScanPrefix(db *badger.DB, prefix []byte, shards int, fn func(key []byte, value []byte) error) (_err error) {
+	if shards < 1 {
+		shards = 1
+	}
+	if shards > 256 {
+		shards = 256
+	}
+
+	var numEntries int64
+	_, dbSpan := StartDBSpan(context.Background(), "ParallelScanPrefix", prefix,
+		attribute.Int("db.scan.shards", shards))
+	defer func() {
+		dbSpan.SetAttributes(attribute.Int64("db.scan.entries", atomic.LoadInt64(&numEntries)))
+		dbSpan.End(_err)
+	}()
+
+	var scanErr error
+	var scanErrLock sync.Mutex
+	var stopped int32
+	var wg sync.WaitGroup
+	for ii := 0; ii < shards; ii++ {
+		// Shard ii covers the keys whose byte after the prefix is in [startByte, endByte).
+		startByte := ii * 256 / shards
+		endByte := (ii + 1) * 256 / shards
+
+		wg.Add(1)
+		go func(startByte int, endByte int) {
+			defer wg.Done()
+
+			err := db.View(func(txn *badger.Txn) error {
+				opts := badger.DefaultIteratorOptions
+				opts.Prefix = prefix
+				it := txn.NewIterator(opts)
+				defer it.Close()
+
+				// The first shard starts at the prefix itself in case there's a record stored
+				// right at the prefix.
+				seekKey := append([]byte{}, prefix...)
+				if startByte > 0 {
+					seekKey = append(seekKey, byte(startByte))
+				}
+				for it.Seek(seekKey); it.ValidForPrefix(prefix); it.Next() {
+					if atomic.LoadInt32(&stopped) == 1 {
+						return nil
+					}
+					item := it.Item()
+					key := item.Key()
+					if len(key) > len(prefix) && int(key[len(prefix)]) >= endByte {
+						break
+					}
+					atomic.AddInt64(&numEntries, 1)
+					if err := item.Value(func(value []byte) error {
+						return fn(key, value)
+					}); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				atomic.StoreInt32(&stopped, 1)
+				scanErrLock.Lock()
+				if scanErr == nil {
+					scanErr = err
+				}
+				scanErrLock.Unlock()
+			}
+		}(startByte, endByte)
+	}
+	wg.Wait()
+
+	if scanErr != nil {
+		return errors.Wrapf(scanErr, "ParallelScanPrefix: Problem scanning prefix %v", prefix)
+	}
+	return nil
+}
+
 // A helper function to enumerate a limited number of the values for a particular prefix.
 func _enumerateLimitedKeysReversedForPrefix(db *badger.DB, dbPrefix []byte, limit uint64) (_keysFound [][]byte, _valsFound [][]byte) {
 	keysFound := [][]byte{}
@@ -1107,32 +2106,17 @@ func _enumerateLimitedKeysReversedForPrefixWithTxn(txn *badger.Txn, dbPrefix []b
 	keysFound := [][]byte{}
 	valsFound := [][]byte{}
 
-	opts := badger.DefaultIteratorOptions
-
-	// Go in reverse order
-	opts.Reverse = true
-
-	nodeIterator := txn.NewIterator(opts)
-	defer nodeIterator.Close()
-	prefix := dbPrefix
-
-	counter := uint64(0)
-	for nodeIterator.Seek(append(prefix, 0xff)); nodeIterator.ValidForPrefix(prefix); nodeIterator.Next() {
-		if counter == limit {
-			break
-		}
-		counter++
-
-		key := nodeIterator.Item().Key()
-		keyCopy := make([]byte, len(key))
-		copy(keyCopy[:], key[:])
-
-		valCopy, err := nodeIterator.Item().ValueCopy(nil)
-		if err != nil {
-			return nil, nil, err
-		}
-		keysFound = append(keysFound, keyCopy)
-		valsFound = append(valsFound, valCopy)
+	if limit == 0 {
+		return keysFound, valsFound, nil
+	}
+	err := _dbForEachKeyWithPrefixWithTxn(txn, "EnumerateLimitedKeysReversedForPrefix", dbPrefix,
+		&DBIteratorOptions{Reverse: true}, func(key []byte, value []byte) (bool, error) {
+			keysFound = append(keysFound, append([]byte{}, key...))
+			valsFound = append(valsFound, append([]byte(nil), value...))
+			return uint64(len(keysFound)) == limit, nil
+		}, attribute.Int64("db.scan.limit", int64(limit)))
+	if err != nil {
+		return nil, nil, err
 	}
 	return keysFound, valsFound, nil
 }
@@ -1234,6 +2218,143 @@ func DbDeletePublicKeyToDeSoBalance(handle *badger.DB, snap *Snapshot, publicKey
 	})
 }
 
+// -------------------------------------------------------------------------------------
+// Nonce mapping functions
+// <public key (33 bytes)> -> <uint64 nonce>
+// -------------------------------------------------------------------------------------
+
+func _dbKeyForPublicKeyToNonce(publicKey []byte) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixPublicKeyToNonce...)
+	key := append(prefixCopy, publicKey...)
+	return key
+}
+
+func DbGetNonceWithTxn(txn *badger.Txn, snap *Snapshot, publicKey []byte) (_nonce uint64, _err error) {
+	nonceBytes, err := DBGetWithTxn(txn, snap, _dbKeyForPublicKeyToNonce(publicKey))
+	// Public keys that never submitted a txn don't have a nonce yet.
+	if err == badger.ErrKeyNotFound {
+		return uint64(0), nil
+	}
+	if err != nil {
+		return uint64(0), errors.Wrapf(err, "DbGetNonceWithTxn: Problem getting nonce for: %s ",
+			PkToStringBoth(publicKey))
+	}
+
+	return DecodeUint64(nonceBytes), nil
+}
+
+func DbGetNonce(handle *badger.DB, snap *Snapshot, publicKey []byte) (_nonce uint64, _err error) {
+	var nonce uint64
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		nonce, err = DbGetNonceWithTxn(txn, snap, publicKey)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return nonce, nil
+}
+
+func DbPutNonceWithTxn(txn *badger.Txn, snap *Snapshot, publicKey []byte, nonce uint64) error {
+	if len(publicKey) != btcec.PubKeyBytesLenCompressed {
+		return fmt.Errorf("DbPutNonceWithTxn: Public key "+
+			"length %d != %d", len(publicKey), btcec.PubKeyBytesLenCompressed)
+	}
+
+	if err := DBSetWithTxn(txn, snap, _dbKeyForPublicKeyToNonce(publicKey), EncodeUint64(nonce)); err != nil {
+		return errors.Wrapf(err, "DbPutNonceWithTxn: Problem setting nonce %d for: %s ",
+			nonce, PkToStringBoth(publicKey))
+	}
+
+	return nil
+}
+
+func DbPutNonce(handle *badger.DB, snap *Snapshot, publicKey []byte, nonce uint64) error {
+	return handle.Update(func(txn *badger.Txn) error {
+		return DbPutNonceWithTxn(txn, snap, publicKey, nonce)
+	})
+}
+
+func DbDeleteNonceWithTxn(txn *badger.Txn, snap *Snapshot, publicKey []byte) error {
+	if err := DBDeleteWithTxn(txn, snap, _dbKeyForPublicKeyToNonce(publicKey)); err != nil {
+		return errors.Wrapf(err, "DbDeleteNonceWithTxn: Problem deleting "+
+			"nonce for public key %s", PkToStringMainnet(publicKey))
+	}
+
+	return nil
+}
+
+// DbInitializeNoncesFromTxindex sets the nonce of every public key in the main db to the number of txns it
+// submitted according to the txindex. It's meant to be run once, ahead of the balance model fork, on a node
+// that has a fully synced txindex. Block rewards, BitcoinExchange txns and the genesis seed records aren't
+// signed by their transactor so they don't count towards its nonce. Returns the number of nonces that were
+// written.
+func DbInitializeNoncesFromTxindex(handle *badger.DB, snap *Snapshot, txindexHandle *badger.DB) (
+	_numNonces int, _err error) {
+
+	_, dbSpan := StartDBSpan(context.Background(), "DbInitializeNoncesFromTxindex", Prefixes.PrefixPublicKeyToNonce)
+	defer func() {
+		dbSpan.SetAttributes(attribute.Int("db.writes", _numNonces))
+		dbSpan.End(_err)
+	}()
+
+	var nonceMtx sync.Mutex
+	nonces := make(map[PkMapKey]uint64)
+	blockRewardTxnType := TxnTypeBlockReward.String()
+	bitcoinExchangeTxnType := TxnTypeBitcoinExchange.String()
+	err := ParallelScanPrefix(txindexHandle, Prefixes.PrefixTransactionIDToMetadata, runtime.GOMAXPROCS(0),
+		func(key []byte, value []byte) error {
+			txnMeta := &TransactionMetadata{}
+			if exists, err := DecodeFromBytes(txnMeta, bytes.NewReader(value)); !exists || err != nil {
+				return fmt.Errorf("Problem decoding txn metadata for key %v: %v", key, err)
+			}
+			if txnMeta.TxnType == blockRewardTxnType || txnMeta.TxnType == bitcoinExchangeTxnType ||
+				txnMeta.BlockHashHex == GenesisBlockHashHex {
+				return nil
+			}
+			transactorPublicKey, _, err := Base58CheckDecode(txnMeta.TransactorPublicKeyBase58Check)
+			if err != nil || len(transactorPublicKey) != btcec.PubKeyBytesLenCompressed {
+				return fmt.Errorf("Invalid transactor public key %v for key %v",
+					txnMeta.TransactorPublicKeyBase58Check, key)
+			}
+			nonceMtx.Lock()
+			nonces[MakePkMapKey(transactorPublicKey)]++
+			nonceMtx.Unlock()
+			return nil
+		})
+	if err != nil {
+		return 0, errors.Wrapf(err, "DbInitializeNoncesFromTxindex: Problem scanning txindex")
+	}
+
+	// Write the nonces in batches so that we don't exceed badger's txn size limits.
+	var publicKeys []PkMapKey
+	for pkMapKey := range nonces {
+		publicKeys = append(publicKeys, pkMapKey)
+	}
+	for start := 0; start < len(publicKeys); start += NonceMigrationBatchSize {
+		end := start + NonceMigrationBatchSize
+		if end > len(publicKeys) {
+			end = len(publicKeys)
+		}
+		err = handle.Update(func(txn *badger.Txn) error {
+			for _, pkMapKey := range publicKeys[start:end] {
+				publicKey := pkMapKey
+				if err := DbPutNonceWithTxn(txn, snap, publicKey[:], nonces[pkMapKey]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return start, errors.Wrapf(err, "DbInitializeNoncesFromTxindex: Problem writing nonces")
+		}
+	}
+	glog.Infof("DbInitializeNoncesFromTxindex: Initialized nonces for %v public keys", len(publicKeys))
+	return len(publicKeys), nil
+}
+
 // -------------------------------------------------------------------------------------
 // PrivateMessage mapping functions
 // <public key (33 bytes) || uint64 big-endian> -> <MessageEntry>
@@ -1370,6 +2491,47 @@ func DBGetMessageEntriesForPublicKey(handle *badger.DB, publicKey []byte) (
 	return privateMessages, nil
 }
 
+// DbGetPaginatedMessageEntriesForPublicKey returns up to limit of the public key's messages, starting
+// at the message with timestamp startTstampNanos, or the first one after it, in timestamp order. If
+// reverse is set, the messages are in reverse timestamp order and the page starts at or before
+// startTstampNanos, so passing math.MaxUint64 starts from the latest message. Only one page is read
+// into memory. If there are more messages, hasMore is set and nextStartTstampNanos is the timestamp
+// to pass as startTstampNanos to get the next page.
+func DbGetPaginatedMessageEntriesForPublicKey(handle *badger.DB, publicKey []byte, startTstampNanos uint64,
+	limit int, reverse bool) (_privateMessages []*MessageEntry, _nextStartTstampNanos uint64, _hasMore bool,
+	_err error) {
+
+	if limit <= 0 {
+		return nil, 0, false, fmt.Errorf("DbGetPaginatedMessageEntriesForPublicKey: Limit must be positive, got %v", limit)
+	}
+	prefix := _dbSeekPrefixForMessagePublicKey(publicKey)
+	startKey := _dbKeyForMessageEntry(publicKey, startTstampNanos)
+
+	// Fetch one extra message to find out whether there's another page.
+	keysFound, valsFound, err := DBGetPaginatedKeysAndValuesForPrefix(
+		handle, startKey, prefix, len(startKey), limit+1, reverse, true /*fetchValues*/)
+	if err != nil {
+		return nil, 0, false, errors.Wrapf(err, "DbGetPaginatedMessageEntriesForPublicKey: ")
+	}
+	if len(keysFound) > limit {
+		_nextStartTstampNanos = DecodeUint64(keysFound[limit][len(prefix):])
+		_hasMore = true
+		valsFound = valsFound[:limit]
+	}
+
+	privateMessages := []*MessageEntry{}
+	for _, valBytes := range valsFound {
+		privateMessageObj := &MessageEntry{}
+		rr := bytes.NewReader(valBytes)
+		if exists, err := DecodeFromBytes(privateMessageObj, rr); !exists || err != nil {
+			return nil, 0, false, errors.Wrapf(
+				err, "DbGetPaginatedMessageEntriesForPublicKey: Problem decoding value: ")
+		}
+		privateMessages = append(privateMessages, privateMessageObj)
+	}
+	return privateMessages, _nextStartTstampNanos, _hasMore, nil
+}
+
 func _enumerateLimitedMessagesForMessagingKeysReversedWithTxn(
 	txn *badger.Txn, messagingGroupEntries []*MessagingGroupEntry,
 	limit uint64) (_privateMessages []*MessageEntry, _err error) {
@@ -1439,8 +2601,7 @@ func _enumerateLimitedMessagesForMessagingKeysReversedWithTxn(
 					prefixes[latestTimestampIndex], messagingIterators[latestTimestampIndex].Item().Key())
 			}
 			message := &MessageEntry{}
-			rr := bytes.NewReader(messageBytes)
-			if exists, err := DecodeFromBytes(message, rr); !exists || err != nil {
+			if exists, _, err := DecodeFromBytesCompat(message, messageBytes); !exists || err != nil {
 				return nil, errors.Wrapf(err, "_enumerateLimitedMessagesForMessagingKeysReversedWithTxn: Problem decoding message "+
 					"from messaging iterator from prefix (%v) at key (%v)",
 					prefixes[latestTimestampIndex], messagingIterators[latestTimestampIndex].Item().Key())
@@ -1790,6 +2951,225 @@ func DBDeleteMessagingGroupMemberMappings(handle *badger.DB, snap *Snapshot,
 	})
 }
 
+// -------------------------------------------------------------------------------------
+// Signer set mapping functions
+// <prefix, OwnerPublicKey [33]byte, SignerSetID [32]byte> -> <SignerSetEntry>
+// <prefix, MemberPublicKey [33]byte, OwnerPublicKey [33]byte, SignerSetID [32]byte> -> <>
+// -------------------------------------------------------------------------------------
+
+func _dbKeyForSignerSetEntry(signerSetKey *SignerSetKey) []byte {
+	prefixCopy := append([]byte{}, Prefixes.PrefixSignerSetByOwnerPubKeyAndID...)
+	key := append(prefixCopy, signerSetKey.OwnerPublicKey[:]...)
+	key = append(key, signerSetKey.SignerSetID[:]...)
+	return key
+}
+
+func _dbSeekPrefixForSignerSetEntry(ownerPublicKey *PublicKey) []byte {
+	prefixCopy := append([]byte{}, Prefixes.PrefixSignerSetByOwnerPubKeyAndID...)
+	return append(prefixCopy, ownerPublicKey[:]...)
+}
+
+func _dbKeyForSignerSetMember(memberPublicKey *PublicKey, signerSetKey *SignerSetKey) []byte {
+	prefixCopy := append([]byte{}, Prefixes.PrefixSignerSetMemberByMemberPubKeyOwnerPubKeyAndID...)
+	key := append(prefixCopy, memberPublicKey[:]...)
+	key = append(key, signerSetKey.OwnerPublicKey[:]...)
+	key = append(key, signerSetKey.SignerSetID[:]...)
+	return key
+}
+
+func _dbSeekPrefixForSignerSetMember(memberPublicKey *PublicKey) []byte {
+	prefixCopy := append([]byte{}, Prefixes.PrefixSignerSetMemberByMemberPubKeyOwnerPubKeyAndID...)
+	return append(prefixCopy, memberPublicKey[:]...)
+}
+
+// DBPutSignerSetEntryWithTxn stores the signer set and indexes it by all of its members. If the signer
+// set already exists, it's replaced, and members that were dropped from it are removed from the index.
+func DBPutSignerSetEntryWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+	signerSetEntry *SignerSetEntry) error {
+
+	if err := signerSetEntry.Validate(); err != nil {
+		return errors.Wrapf(err, "DBPutSignerSetEntryWithTxn: Invalid signer set")
+	}
+
+	// Clear out the existing signer set first so that we don't leave stale member mappings behind.
+	signerSetKey := signerSetEntry.GetSignerSetKey()
+	if err := DBDeleteSignerSetEntryWithTxn(txn, snap, signerSetKey); err != nil {
+		return errors.Wrapf(err, "DBPutSignerSetEntryWithTxn: Problem deleting existing signer set")
+	}
+
+	if err := DBSetWithTxn(txn, snap, _dbKeyForSignerSetEntry(signerSetKey),
+		EncodeToBytes(blockHeight, signerSetEntry)); err != nil {
+		return errors.Wrapf(err, "DBPutSignerSetEntryWithTxn: Problem adding signer set entry mapping")
+	}
+	for _, signer := range signerSetEntry.SignerPublicKeys {
+		if err := DBSetWithTxn(txn, snap, _dbKeyForSignerSetMember(signer, signerSetKey), []byte{}); err != nil {
+			return errors.Wrapf(err, "DBPutSignerSetEntryWithTxn: Problem adding signer set member "+
+				"mapping for %v", PkToStringMainnet(signer[:]))
+		}
+	}
+
+	return nil
+}
+
+func DBPutSignerSetEntry(handle *badger.DB, snap *Snapshot, blockHeight uint64,
+	signerSetEntry *SignerSetEntry) error {
+
+	return handle.Update(func(txn *badger.Txn) error {
+		return DBPutSignerSetEntryWithTxn(txn, snap, blockHeight, signerSetEntry)
+	})
+}
+
+func DBGetSignerSetEntryWithTxn(txn *badger.Txn, snap *Snapshot, signerSetKey *SignerSetKey) *SignerSetEntry {
+	signerSetBytes, err := DBGetWithTxn(txn, snap, _dbKeyForSignerSetEntry(signerSetKey))
+	if err != nil {
+		return nil
+	}
+	signerSetEntry := &SignerSetEntry{}
+	rr := bytes.NewReader(signerSetBytes)
+	if exists, err := DecodeFromBytes(signerSetEntry, rr); !exists || err != nil {
+		glog.Errorf("DBGetSignerSetEntryWithTxn: Problem decoding signer set entry for key %v: %v",
+			signerSetKey, err)
+		return nil
+	}
+	return signerSetEntry
+}
+
+func DBGetSignerSetEntry(db *badger.DB, snap *Snapshot, signerSetKey *SignerSetKey) *SignerSetEntry {
+	var ret *SignerSetEntry
+	db.View(func(txn *badger.Txn) error {
+		ret = DBGetSignerSetEntryWithTxn(txn, snap, signerSetKey)
+		return nil
+	})
+	return ret
+}
+
+// DBDeleteSignerSetEntryWithTxn deletes the signer set along with all of its member mappings.
+func DBDeleteSignerSetEntryWithTxn(txn *badger.Txn, snap *Snapshot, signerSetKey *SignerSetKey) error {
+	// First pull up the existing signer set so we know which member mappings to delete.
+	// If one doesn't exist then there's nothing to do.
+	existingEntry := DBGetSignerSetEntryWithTxn(txn, snap, signerSetKey)
+	if existingEntry == nil {
+		return nil
+	}
+
+	for _, signer := range existingEntry.SignerPublicKeys {
+		if err := DBDeleteWithTxn(txn, snap, _dbKeyForSignerSetMember(signer, signerSetKey)); err != nil {
+			return errors.Wrapf(err, "DBDeleteSignerSetEntryWithTxn: Deleting member mapping for "+
+				"public key %v failed", PkToStringMainnet(signer[:]))
+		}
+	}
+	if err := DBDeleteWithTxn(txn, snap, _dbKeyForSignerSetEntry(signerSetKey)); err != nil {
+		return errors.Wrapf(err, "DBDeleteSignerSetEntryWithTxn: Deleting signer set entry failed")
+	}
+
+	return nil
+}
+
+func DBDeleteSignerSetEntry(handle *badger.DB, snap *Snapshot, signerSetKey *SignerSetKey) error {
+	return handle.Update(func(txn *badger.Txn) error {
+		return DBDeleteSignerSetEntryWithTxn(txn, snap, signerSetKey)
+	})
+}
+
+// DBGetSignerSetEntriesForOwnerWithTxn returns all the signer sets owned by the public key.
+func DBGetSignerSetEntriesForOwnerWithTxn(txn *badger.Txn, ownerPublicKey *PublicKey) (
+	_signerSetEntries []*SignerSetEntry, _err error) {
+
+	prefix := _dbSeekPrefixForSignerSetEntry(ownerPublicKey)
+	_, valuesFound, err := _enumerateKeysForPrefixWithTxn(txn, prefix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DBGetSignerSetEntriesForOwnerWithTxn: "+
+			"problem enumerating signer set entries for prefix (%v)", prefix)
+	}
+
+	signerSetEntries := []*SignerSetEntry{}
+	for _, valBytes := range valuesFound {
+		signerSetEntry := &SignerSetEntry{}
+		rr := bytes.NewReader(valBytes)
+		if exists, err := DecodeFromBytes(signerSetEntry, rr); !exists || err != nil {
+			return nil, errors.Wrapf(err, "DBGetSignerSetEntriesForOwnerWithTxn: "+
+				"problem decoding signer set entry for public key (%v)", ownerPublicKey)
+		}
+		signerSetEntries = append(signerSetEntries, signerSetEntry)
+	}
+
+	return signerSetEntries, nil
+}
+
+func DBGetSignerSetEntriesForOwner(handle *badger.DB, ownerPublicKey *PublicKey) (
+	_signerSetEntries []*SignerSetEntry, _err error) {
+
+	var signerSetEntries []*SignerSetEntry
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		signerSetEntries, err = DBGetSignerSetEntriesForOwnerWithTxn(txn, ownerPublicKey)
+		return err
+	})
+	return signerSetEntries, err
+}
+
+// DBGetSignerSetKeysForMemberWithTxn returns the keys of all the signer sets the public key is a member of.
+func DBGetSignerSetKeysForMemberWithTxn(txn *badger.Txn, memberPublicKey *PublicKey) (
+	_signerSetKeys []*SignerSetKey, _err error) {
+
+	prefix := _dbSeekPrefixForSignerSetMember(memberPublicKey)
+	keysFound, _, err := _enumerateKeysForPrefixWithTxn(txn, prefix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DBGetSignerSetKeysForMemberWithTxn: "+
+			"problem enumerating signer set members for prefix (%v)", prefix)
+	}
+
+	keyCodec := MustGetKeyCodec(Prefixes.PrefixSignerSetMemberByMemberPubKeyOwnerPubKeyAndID)
+	signerSetKeys := []*SignerSetKey{}
+	for _, keyBytes := range keysFound {
+		decodedKey, err := keyCodec.Decode(keyBytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DBGetSignerSetKeysForMemberWithTxn: ")
+		}
+		signerSetKey := &SignerSetKey{}
+		copy(signerSetKey.OwnerPublicKey[:], decodedKey.Bytes("OwnerPublicKey"))
+		copy(signerSetKey.SignerSetID[:], decodedKey.Bytes("SignerSetID"))
+		signerSetKeys = append(signerSetKeys, signerSetKey)
+	}
+
+	return signerSetKeys, nil
+}
+
+// DBGetSignerSetEntriesForMemberWithTxn returns all the signer sets the public key is a member of.
+func DBGetSignerSetEntriesForMemberWithTxn(txn *badger.Txn, snap *Snapshot, memberPublicKey *PublicKey) (
+	_signerSetEntries []*SignerSetEntry, _err error) {
+
+	signerSetKeys, err := DBGetSignerSetKeysForMemberWithTxn(txn, memberPublicKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DBGetSignerSetEntriesForMemberWithTxn: ")
+	}
+
+	signerSetEntries := []*SignerSetEntry{}
+	for _, signerSetKey := range signerSetKeys {
+		signerSetEntry := DBGetSignerSetEntryWithTxn(txn, snap, signerSetKey)
+		if signerSetEntry == nil {
+			return nil, fmt.Errorf("DBGetSignerSetEntriesForMemberWithTxn: Member mapping for "+
+				"public key %v points to missing signer set %v", PkToStringMainnet(memberPublicKey[:]),
+				signerSetKey)
+		}
+		signerSetEntries = append(signerSetEntries, signerSetEntry)
+	}
+
+	return signerSetEntries, nil
+}
+
+func DBGetSignerSetEntriesForMember(handle *badger.DB, snap *Snapshot, memberPublicKey *PublicKey) (
+	_signerSetEntries []*SignerSetEntry, _err error) {
+
+	var signerSetEntries []*SignerSetEntry
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		signerSetEntries, err = DBGetSignerSetEntriesForMemberWithTxn(txn, snap, memberPublicKey)
+		return err
+	})
+	return signerSetEntries, err
+}
+
 // -------------------------------------------------------------------------------------
 // Forbidden block signature public key functions
 // <prefix_id, public key> -> <>
@@ -2003,14 +3383,20 @@ func DbGetPostHashesYouLike(handle *badger.DB, yourPublicKey []byte) (
 	_postHashes []*BlockHash, _err error) {
 
 	prefix := _dbSeekPrefixForPostHashesYouLike(yourPublicKey)
-	keysFound, _ := _enumerateKeysForPrefix(handle, prefix)
 
+	keyCodec := MustGetKeyCodec(Prefixes.PrefixLikerPubKeyToLikedPostHash)
 	postHashesYouLike := []*BlockHash{}
-	for _, keyBytes := range keysFound {
-		// We must slice off the first byte and userPubKey to get the likedPostHash.
-		postHash := &BlockHash{}
-		copy(postHash[:], keyBytes[1+btcec.PubKeyBytesLenCompressed:])
-		postHashesYouLike = append(postHashesYouLike, postHash)
+	err := DBForEachKeyWithPrefix(handle, prefix, &DBIteratorOptions{KeysOnly: true},
+		func(keyBytes []byte, _ []byte) (bool, error) {
+			decodedKey, err := keyCodec.Decode(keyBytes)
+			if err != nil {
+				return true, err
+			}
+			postHashesYouLike = append(postHashesYouLike, decodedKey.BlockHash("LikedPostHash"))
+			return false, nil
+		})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetPostHashesYouLike: ")
 	}
 
 	return postHashesYouLike, nil
@@ -2020,63 +3406,377 @@ func DbGetLikerPubKeysLikingAPostHash(handle *badger.DB, likedPostHash BlockHash
 	_pubKeys [][]byte, _err error) {
 
 	prefix := _dbSeekPrefixForLikerPubKeysLikingAPostHash(likedPostHash)
-	keysFound, _ := _enumerateKeysForPrefix(handle, prefix)
 
+	keyCodec := MustGetKeyCodec(Prefixes.PrefixLikedPostHashToLikerPubKey)
 	userPubKeys := [][]byte{}
-	for _, keyBytes := range keysFound {
-		// We must slice off the first byte and likedPostHash to get the userPubKey.
-		userPubKey := keyBytes[1+HashSizeBytes:]
-		userPubKeys = append(userPubKeys, userPubKey)
+	err := DBForEachKeyWithPrefix(handle, prefix, &DBIteratorOptions{KeysOnly: true},
+		func(keyBytes []byte, _ []byte) (bool, error) {
+			decodedKey, err := keyCodec.Decode(keyBytes)
+			if err != nil {
+				return true, err
+			}
+			userPubKeys = append(userPubKeys, decodedKey.Bytes("LikerPublicKey"))
+			return false, nil
+		})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetLikerPubKeysLikingAPostHash: ")
 	}
 
 	return userPubKeys, nil
 }
 
 // -------------------------------------------------------------------------------------
-// Reposts mapping functions
-// 		<prefix_id, user pub key [33]byte, reposted post BlockHash> -> <>
-// 		<prefix_id, reposted post BlockHash, user pub key [33]byte> -> <>
+// Post association mapping functions
+// 		<prefix_id, AssociationType []byte, TransactorPKID [33]byte, TargetPostHash BlockHash> -> <PostAssociationEntry>
+// 		<prefix_id, AssociationType []byte, TargetPostHash BlockHash, TransactorPKID [33]byte> -> <PostAssociationEntry>
 // -------------------------------------------------------------------------------------
-//PrefixReposterPubKeyRepostedPostHashToRepostPostHash
-func _dbKeyForReposterPubKeyRepostedPostHashToRepostPostHash(userPubKey []byte, repostedPostHash BlockHash, repostPostHash BlockHash) []byte {
+
+func _dbSeekPrefixForPostAssociationsByTransactor(associationType []byte, transactorPKID *PKID) []byte {
 	// Make a copy to avoid multiple calls to this function re-using the same slice.
-	prefixCopy := append([]byte{}, Prefixes.PrefixReposterPubKeyRepostedPostHashToRepostPostHash...)
-	key := append(prefixCopy, userPubKey...)
-	key = append(key, repostedPostHash[:]...)
-	key = append(key, repostPostHash[:]...)
-	return key
+	prefixCopy := append([]byte{}, Prefixes.PrefixPostAssociationByTypeTransactorPostHash...)
+	key := append(prefixCopy, EncodeByteArray(associationType)...)
+	return append(key, transactorPKID[:]...)
 }
 
-// This is a little hacky but we can save space by encoding RepostEntry entirely in the prefix []byte{39} keys.
-// _dbKeyForReposterPubKeyRepostedPostHashToRepostEntry decodes these keys into RepostEntry.
-func _dbKeyForReposterPubKeyRepostedPostHashToRepostEntry(key []byte) *RepostEntry {
-	if len(key) != 1+33+32+32 {
-		return nil
-	}
-
-	entry := &RepostEntry{}
-	entry.ReposterPubKey = key[1:34]
-	entry.RepostedPostHash = NewBlockHash(key[34:66])
-	entry.RepostPostHash = NewBlockHash(key[66:98])
-	return entry
+func _dbSeekPrefixForPostAssociationsByPostHash(associationType []byte, targetPostHash *BlockHash) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixPostAssociationByTypePostHashTransactor...)
+	key := append(prefixCopy, EncodeByteArray(associationType)...)
+	return append(key, targetPostHash[:]...)
 }
 
-func _dbSeekKeyForReposterPubKeyRepostedPostHashToRepostPostHash(userPubKey []byte, repostedPostHash BlockHash) []byte {
-	// Make a copy to avoid multiple calls to this function re-using the same slice.
-	prefixCopy := append([]byte{}, Prefixes.PrefixReposterPubKeyRepostedPostHashToRepostPostHash...)
-	key := append(prefixCopy, userPubKey...)
-	key = append(key, repostedPostHash[:]...)
-	return key
+func _dbKeyForPostAssociationByTransactor(
+	associationType []byte, transactorPKID *PKID, targetPostHash *BlockHash) []byte {
+	return append(_dbSeekPrefixForPostAssociationsByTransactor(associationType, transactorPKID), targetPostHash[:]...)
 }
 
-func _dbSeekPrefixForPostHashesYouRepost(yourPubKey []byte) []byte {
-	// Make a copy to avoid multiple calls to this function re-using the same slice.
-	prefixCopy := append([]byte{}, Prefixes.PrefixReposterPubKeyRepostedPostHashToRepostPostHash...)
-	return append(prefixCopy, yourPubKey...)
+func _dbKeyForPostAssociationByPostHash(
+	associationType []byte, targetPostHash *BlockHash, transactorPKID *PKID) []byte {
+	return append(_dbSeekPrefixForPostAssociationsByPostHash(associationType, targetPostHash), transactorPKID[:]...)
 }
 
-//PrefixRepostedPostHashReposterPubKey
-func _dbKeyForRepostedPostHashReposterPubKey(repostedPostHash *BlockHash, reposterPubKey []byte) []byte {
+func DBGetPostAssociationEntryWithTxn(txn *badger.Txn, snap *Snapshot,
+	associationType []byte, transactorPKID *PKID, targetPostHash *BlockHash) *PostAssociationEntry {
+
+	key := _dbKeyForPostAssociationByTransactor(associationType, transactorPKID, targetPostHash)
+	associationEntryBytes, err := DBGetWithTxn(txn, snap, key)
+	if err != nil {
+		return nil
+	}
+
+	associationEntry := &PostAssociationEntry{}
+	rr := bytes.NewReader(associationEntryBytes)
+	if exist, err := DecodeFromBytes(associationEntry, rr); !exist || err != nil {
+		glog.Errorf("DBGetPostAssociationEntryWithTxn: Problem decoding post association entry for "+
+			"type %v, transactor %v, post %v: %v", string(associationType), transactorPKID, targetPostHash, err)
+		return nil
+	}
+	return associationEntry
+}
+
+func DBGetPostAssociationEntry(handle *badger.DB, snap *Snapshot,
+	associationType []byte, transactorPKID *PKID, targetPostHash *BlockHash) *PostAssociationEntry {
+
+	var ret *PostAssociationEntry
+	handle.View(func(txn *badger.Txn) error {
+		ret = DBGetPostAssociationEntryWithTxn(txn, snap, associationType, transactorPKID, targetPostHash)
+		return nil
+	})
+	return ret
+}
+
+// Note that this adds a mapping for the transactor *and* the post.
+func DBPutPostAssociationEntryWithTxn(txn *badger.Txn, snap *Snapshot,
+	blockHeight uint64, associationEntry *PostAssociationEntry) error {
+
+	if associationEntry == nil {
+		return nil
+	}
+
+	associationEntryBytes := EncodeToBytes(blockHeight, associationEntry)
+	if err := DBSetWithTxn(txn, snap, _dbKeyForPostAssociationByTransactor(associationEntry.AssociationType,
+		associationEntry.TransactorPKID, associationEntry.TargetPostHash), associationEntryBytes); err != nil {
+
+		return errors.Wrapf(err, "DBPutPostAssociationEntryWithTxn: Problem adding transactor mapping")
+	}
+	if err := DBSetWithTxn(txn, snap, _dbKeyForPostAssociationByPostHash(associationEntry.AssociationType,
+		associationEntry.TargetPostHash, associationEntry.TransactorPKID), associationEntryBytes); err != nil {
+
+		return errors.Wrapf(err, "DBPutPostAssociationEntryWithTxn: Problem adding post mapping")
+	}
+
+	return nil
+}
+
+func DBDeletePostAssociationEntryWithTxn(txn *badger.Txn, snap *Snapshot,
+	associationEntry *PostAssociationEntry) error {
+
+	if associationEntry == nil {
+		return nil
+	}
+
+	if err := DBDeleteWithTxn(txn, snap, _dbKeyForPostAssociationByTransactor(associationEntry.AssociationType,
+		associationEntry.TransactorPKID, associationEntry.TargetPostHash)); err != nil {
+
+		return errors.Wrapf(err, "DBDeletePostAssociationEntryWithTxn: Problem deleting transactor mapping")
+	}
+	if err := DBDeleteWithTxn(txn, snap, _dbKeyForPostAssociationByPostHash(associationEntry.AssociationType,
+		associationEntry.TargetPostHash, associationEntry.TransactorPKID)); err != nil {
+
+		return errors.Wrapf(err, "DBDeletePostAssociationEntryWithTxn: Problem deleting post mapping")
+	}
+
+	return nil
+}
+
+func _decodePostAssociationEntries(funcName string, valsFound [][]byte) ([]*PostAssociationEntry, error) {
+	associationEntries := []*PostAssociationEntry{}
+	for _, associationEntryBytes := range valsFound {
+		associationEntry := &PostAssociationEntry{}
+		rr := bytes.NewReader(associationEntryBytes)
+		if exist, err := DecodeFromBytes(associationEntry, rr); !exist || err != nil {
+			return nil, errors.Wrapf(err, "%v: Problem decoding post association entry", funcName)
+		}
+		associationEntries = append(associationEntries, associationEntry)
+	}
+	return associationEntries, nil
+}
+
+// DBGetPostAssociationsByTransactor returns up to limit of the transactor's associations of the
+// given type, sorted by post hash, starting at startPostHash if it's set. The association with
+// startPostHash is included if it exists. A limit of zero returns all of them.
+func DBGetPostAssociationsByTransactor(handle *badger.DB, associationType []byte,
+	transactorPKID *PKID, startPostHash *BlockHash, limit int) ([]*PostAssociationEntry, error) {
+
+	prefix := _dbSeekPrefixForPostAssociationsByTransactor(associationType, transactorPKID)
+	startKey := prefix
+	if startPostHash != nil {
+		startKey = _dbKeyForPostAssociationByTransactor(associationType, transactorPKID, startPostHash)
+	}
+	_, valsFound, err := DBGetPaginatedKeysAndValuesForPrefix(
+		handle, startKey, prefix, 0, limit, false, true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DBGetPostAssociationsByTransactor: ")
+	}
+	return _decodePostAssociationEntries("DBGetPostAssociationsByTransactor", valsFound)
+}
+
+// DBGetPostAssociationsByPostHash returns up to limit of the post's associations of the given
+// type, sorted by transactor PKID, starting at startTransactorPKID if it's set. The association
+// with startTransactorPKID is included if it exists. A limit of zero returns all of them.
+func DBGetPostAssociationsByPostHash(handle *badger.DB, associationType []byte,
+	targetPostHash *BlockHash, startTransactorPKID *PKID, limit int) ([]*PostAssociationEntry, error) {
+
+	prefix := _dbSeekPrefixForPostAssociationsByPostHash(associationType, targetPostHash)
+	startKey := prefix
+	if startTransactorPKID != nil {
+		startKey = _dbKeyForPostAssociationByPostHash(associationType, targetPostHash, startTransactorPKID)
+	}
+	_, valsFound, err := DBGetPaginatedKeysAndValuesForPrefix(
+		handle, startKey, prefix, 0, limit, false, true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DBGetPostAssociationsByPostHash: ")
+	}
+	return _decodePostAssociationEntries("DBGetPostAssociationsByPostHash", valsFound)
+}
+
+// -------------------------------------------------------------------------------------
+// User association mapping functions
+// 		<prefix_id, TransactorPKID [33]byte, TargetPKID [33]byte, AssociationType []byte> -> <UserAssociationEntry>
+// 		<prefix_id, TargetPKID [33]byte, TransactorPKID [33]byte, AssociationType []byte> -> <UserAssociationEntry>
+// -------------------------------------------------------------------------------------
+
+func _dbKeyForUserAssociationByTransactor(
+	transactorPKID *PKID, targetPKID *PKID, associationType []byte) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixUserAssociationByTransactorTargetType...)
+	key := append(prefixCopy, transactorPKID[:]...)
+	key = append(key, targetPKID[:]...)
+	return append(key, associationType...)
+}
+
+func _dbKeyForUserAssociationByTarget(
+	targetPKID *PKID, transactorPKID *PKID, associationType []byte) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixUserAssociationByTargetTransactorType...)
+	key := append(prefixCopy, targetPKID[:]...)
+	key = append(key, transactorPKID[:]...)
+	return append(key, associationType...)
+}
+
+func DBGetUserAssociationEntryWithTxn(txn *badger.Txn, snap *Snapshot,
+	transactorPKID *PKID, targetPKID *PKID, associationType []byte) *UserAssociationEntry {
+
+	key := _dbKeyForUserAssociationByTransactor(transactorPKID, targetPKID, associationType)
+	associationEntryBytes, err := DBGetWithTxn(txn, snap, key)
+	if err != nil {
+		return nil
+	}
+
+	associationEntry := &UserAssociationEntry{}
+	rr := bytes.NewReader(associationEntryBytes)
+	if exist, err := DecodeFromBytes(associationEntry, rr); !exist || err != nil {
+		glog.Errorf("DBGetUserAssociationEntryWithTxn: Problem decoding user association entry for "+
+			"transactor %v, target %v, type %v: %v", transactorPKID, targetPKID, string(associationType), err)
+		return nil
+	}
+	return associationEntry
+}
+
+func DBGetUserAssociationEntry(handle *badger.DB, snap *Snapshot,
+	transactorPKID *PKID, targetPKID *PKID, associationType []byte) *UserAssociationEntry {
+
+	var ret *UserAssociationEntry
+	handle.View(func(txn *badger.Txn) error {
+		ret = DBGetUserAssociationEntryWithTxn(txn, snap, transactorPKID, targetPKID, associationType)
+		return nil
+	})
+	return ret
+}
+
+// Note that this adds a mapping for the transactor *and* the target.
+func DBPutUserAssociationEntryWithTxn(txn *badger.Txn, snap *Snapshot,
+	blockHeight uint64, associationEntry *UserAssociationEntry) error {
+
+	if associationEntry == nil {
+		return nil
+	}
+
+	associationEntryBytes := EncodeToBytes(blockHeight, associationEntry)
+	if err := DBSetWithTxn(txn, snap, _dbKeyForUserAssociationByTransactor(associationEntry.TransactorPKID,
+		associationEntry.TargetPKID, associationEntry.AssociationType), associationEntryBytes); err != nil {
+
+		return errors.Wrapf(err, "DBPutUserAssociationEntryWithTxn: Problem adding transactor mapping")
+	}
+	if err := DBSetWithTxn(txn, snap, _dbKeyForUserAssociationByTarget(associationEntry.TargetPKID,
+		associationEntry.TransactorPKID, associationEntry.AssociationType), associationEntryBytes); err != nil {
+
+		return errors.Wrapf(err, "DBPutUserAssociationEntryWithTxn: Problem adding target mapping")
+	}
+
+	return nil
+}
+
+func DBDeleteUserAssociationEntryWithTxn(txn *badger.Txn, snap *Snapshot,
+	associationEntry *UserAssociationEntry) error {
+
+	if associationEntry == nil {
+		return nil
+	}
+
+	if err := DBDeleteWithTxn(txn, snap, _dbKeyForUserAssociationByTransactor(associationEntry.TransactorPKID,
+		associationEntry.TargetPKID, associationEntry.AssociationType)); err != nil {
+
+		return errors.Wrapf(err, "DBDeleteUserAssociationEntryWithTxn: Problem deleting transactor mapping")
+	}
+	if err := DBDeleteWithTxn(txn, snap, _dbKeyForUserAssociationByTarget(associationEntry.TargetPKID,
+		associationEntry.TransactorPKID, associationEntry.AssociationType)); err != nil {
+
+		return errors.Wrapf(err, "DBDeleteUserAssociationEntryWithTxn: Problem deleting target mapping")
+	}
+
+	return nil
+}
+
+func _decodeUserAssociationEntries(funcName string, valsFound [][]byte) ([]*UserAssociationEntry, error) {
+	associationEntries := []*UserAssociationEntry{}
+	for _, associationEntryBytes := range valsFound {
+		associationEntry := &UserAssociationEntry{}
+		rr := bytes.NewReader(associationEntryBytes)
+		if exist, err := DecodeFromBytes(associationEntry, rr); !exist || err != nil {
+			return nil, errors.Wrapf(err, "%v: Problem decoding user association entry", funcName)
+		}
+		associationEntries = append(associationEntries, associationEntry)
+	}
+	return associationEntries, nil
+}
+
+// DBGetUserAssociationsByTransactor returns up to limit of the transactor's associations with
+// other users, sorted by target PKID and then by type. If startTargetPKID is set, the page starts
+// at the association with startTargetPKID and startAssociationType, inclusive. A limit of zero
+// returns all of them.
+func DBGetUserAssociationsByTransactor(handle *badger.DB, transactorPKID *PKID,
+	startTargetPKID *PKID, startAssociationType []byte, limit int) ([]*UserAssociationEntry, error) {
+
+	prefix := append(append([]byte{}, Prefixes.PrefixUserAssociationByTransactorTargetType...), transactorPKID[:]...)
+	startKey := prefix
+	if startTargetPKID != nil {
+		startKey = _dbKeyForUserAssociationByTransactor(transactorPKID, startTargetPKID, startAssociationType)
+	}
+	_, valsFound, err := DBGetPaginatedKeysAndValuesForPrefix(
+		handle, startKey, prefix, 0, limit, false, true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DBGetUserAssociationsByTransactor: ")
+	}
+	return _decodeUserAssociationEntries("DBGetUserAssociationsByTransactor", valsFound)
+}
+
+// DBGetUserAssociationsByTarget returns up to limit of the associations other users have with
+// the target, sorted by transactor PKID and then by type. If startTransactorPKID is set, the page
+// starts at the association with startTransactorPKID and startAssociationType, inclusive. A limit
+// of zero returns all of them.
+func DBGetUserAssociationsByTarget(handle *badger.DB, targetPKID *PKID,
+	startTransactorPKID *PKID, startAssociationType []byte, limit int) ([]*UserAssociationEntry, error) {
+
+	prefix := append(append([]byte{}, Prefixes.PrefixUserAssociationByTargetTransactorType...), targetPKID[:]...)
+	startKey := prefix
+	if startTransactorPKID != nil {
+		startKey = _dbKeyForUserAssociationByTarget(targetPKID, startTransactorPKID, startAssociationType)
+	}
+	_, valsFound, err := DBGetPaginatedKeysAndValuesForPrefix(
+		handle, startKey, prefix, 0, limit, false, true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DBGetUserAssociationsByTarget: ")
+	}
+	return _decodeUserAssociationEntries("DBGetUserAssociationsByTarget", valsFound)
+}
+
+// -------------------------------------------------------------------------------------
+// Reposts mapping functions
+// 		<prefix_id, user pub key [33]byte, reposted post BlockHash> -> <>
+// 		<prefix_id, reposted post BlockHash, user pub key [33]byte> -> <>
+// -------------------------------------------------------------------------------------
+//PrefixReposterPubKeyRepostedPostHashToRepostPostHash
+func _dbKeyForReposterPubKeyRepostedPostHashToRepostPostHash(userPubKey []byte, repostedPostHash BlockHash, repostPostHash BlockHash) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixReposterPubKeyRepostedPostHashToRepostPostHash...)
+	key := append(prefixCopy, userPubKey...)
+	key = append(key, repostedPostHash[:]...)
+	key = append(key, repostPostHash[:]...)
+	return key
+}
+
+// This is a little hacky but we can save space by encoding RepostEntry entirely in the prefix []byte{39} keys.
+// _dbKeyForReposterPubKeyRepostedPostHashToRepostEntry decodes these keys into RepostEntry.
+func _dbKeyForReposterPubKeyRepostedPostHashToRepostEntry(key []byte) *RepostEntry {
+	decodedKey, err := MustGetKeyCodec(Prefixes.PrefixReposterPubKeyRepostedPostHashToRepostPostHash).Decode(key)
+	if err != nil {
+		return nil
+	}
+
+	entry := &RepostEntry{}
+	entry.ReposterPubKey = decodedKey.Bytes("ReposterPublicKey")
+	entry.RepostedPostHash = decodedKey.BlockHash("RepostedPostHash")
+	entry.RepostPostHash = decodedKey.BlockHash("RepostPostHash")
+	return entry
+}
+
+func _dbSeekKeyForReposterPubKeyRepostedPostHashToRepostPostHash(userPubKey []byte, repostedPostHash BlockHash) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixReposterPubKeyRepostedPostHashToRepostPostHash...)
+	key := append(prefixCopy, userPubKey...)
+	key = append(key, repostedPostHash[:]...)
+	return key
+}
+
+func _dbSeekPrefixForPostHashesYouRepost(yourPubKey []byte) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixReposterPubKeyRepostedPostHashToRepostPostHash...)
+	return append(prefixCopy, yourPubKey...)
+}
+
+//PrefixRepostedPostHashReposterPubKey
+func _dbKeyForRepostedPostHashReposterPubKey(repostedPostHash *BlockHash, reposterPubKey []byte) []byte {
 	// Make a copy to avoid multiple calls to this function re-using the same slice.
 	prefixCopy := append([]byte{}, Prefixes.PrefixRepostedPostHashReposterPubKey...)
 	key := append(prefixCopy, repostedPostHash[:]...)
@@ -2200,12 +3900,14 @@ func DbGetPostHashesYouRepost(handle *badger.DB, yourPublicKey []byte) (
 	prefix := _dbSeekPrefixForPostHashesYouRepost(yourPublicKey)
 	keysFound, _ := _enumerateKeysForPrefix(handle, prefix)
 
+	keyCodec := MustGetKeyCodec(Prefixes.PrefixReposterPubKeyRepostedPostHashToRepostPostHash)
 	postHashesYouRepost := []*BlockHash{}
 	for _, keyBytes := range keysFound {
-		// We must slice off the first byte and userPubKey to get the repostedPostHash.
-		postHash := &BlockHash{}
-		copy(postHash[:], keyBytes[1+btcec.PubKeyBytesLenCompressed:])
-		postHashesYouRepost = append(postHashesYouRepost, postHash)
+		decodedKey, err := keyCodec.Decode(keyBytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DbGetPostHashesYouRepost: ")
+		}
+		postHashesYouRepost = append(postHashesYouRepost, decodedKey.BlockHash("RepostedPostHash"))
 	}
 
 	return postHashesYouRepost, nil
@@ -2247,6 +3949,68 @@ func _dbSeekPrefixForPKIDsFollowingYou(yourPKID *PKID) []byte {
 	return append(prefixCopy, yourPKID[:]...)
 }
 
+func _dbKeyForPKIDToFollowerCount(pkid *PKID) []byte {
+	prefixCopy := append([]byte{}, Prefixes.PrefixPKIDToFollowerCount...)
+	return append(prefixCopy, pkid[:]...)
+}
+
+func _dbKeyForPKIDToFollowingCount(pkid *PKID) []byte {
+	prefixCopy := append([]byte{}, Prefixes.PrefixPKIDToFollowingCount...)
+	return append(prefixCopy, pkid[:]...)
+}
+
+// _dbGetFollowCountWithTxn returns the counter stored under countKey. If there's no counter yet,
+// it counts the follow mappings under mappingPrefix instead.
+func _dbGetFollowCountWithTxn(txn *badger.Txn, countKey []byte, mappingPrefix []byte) (uint64, error) {
+	countBytes, err := DBGetWithTxn(txn, nil, countKey)
+	if err == nil {
+		if len(countBytes) != 8 {
+			return 0, fmt.Errorf("_dbGetFollowCountWithTxn: Invalid count length %v", len(countBytes))
+		}
+		return DecodeUint64(countBytes), nil
+	}
+	if err != badger.ErrKeyNotFound {
+		return 0, err
+	}
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	count := uint64(0)
+	for it.Seek(mappingPrefix); it.ValidForPrefix(mappingPrefix); it.Next() {
+		count++
+	}
+	return count, nil
+}
+
+// _dbAddToFollowCountsWithTxn adds delta to the following count of the follower and to the follower
+// count of the followed PKID. It must be called before the follow mappings are added or deleted.
+func _dbAddToFollowCountsWithTxn(txn *badger.Txn, followerPKID *PKID, followedPKID *PKID, delta int64) error {
+	addToCount := func(countKey []byte, mappingPrefix []byte) error {
+		count, err := _dbGetFollowCountWithTxn(txn, countKey, mappingPrefix)
+		if err != nil {
+			return err
+		}
+		if delta < 0 && count < uint64(-delta) {
+			return fmt.Errorf("_dbAddToFollowCountsWithTxn: Count %v would go below zero", count)
+		}
+		return DBSetWithTxn(txn, nil, countKey, EncodeUint64(uint64(int64(count)+delta)))
+	}
+
+	if err := addToCount(_dbKeyForPKIDToFollowingCount(followerPKID),
+		_dbSeekPrefixForPKIDsYouFollow(followerPKID)); err != nil {
+
+		return errors.Wrapf(err, "_dbAddToFollowCountsWithTxn: Problem updating following count: ")
+	}
+	if err := addToCount(_dbKeyForPKIDToFollowerCount(followedPKID),
+		_dbSeekPrefixForPKIDsFollowingYou(followedPKID)); err != nil {
+
+		return errors.Wrapf(err, "_dbAddToFollowCountsWithTxn: Problem updating follower count: ")
+	}
+	return nil
+}
+
 // Note that this adds a mapping for the follower *and* the pub key being followed.
 func DbPutFollowMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 	followerPKID *PKID, followedPKID *PKID) error {
@@ -2260,6 +4024,13 @@ func DbPutFollowMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 			"length %d != %d", len(followerPKID), btcec.PubKeyBytesLenCompressed)
 	}
 
+	// Only count the follow if it's new, since putting an existing follow doesn't change anything.
+	if DbGetFollowerToFollowedMappingWithTxn(txn, snap, followerPKID, followedPKID) == nil {
+		if err := _dbAddToFollowCountsWithTxn(txn, followerPKID, followedPKID, 1); err != nil {
+			return errors.Wrapf(err, "DbPutFollowMappingsWithTxn: ")
+		}
+	}
+
 	if err := DBSetWithTxn(txn, snap, _dbKeyForFollowerToFollowedMapping(
 		followerPKID, followedPKID), []byte{}); err != nil {
 
@@ -2322,6 +4093,10 @@ func DbDeleteFollowMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 		return nil
 	}
 
+	if err := _dbAddToFollowCountsWithTxn(txn, followerPKID, followedPKID, -1); err != nil {
+		return errors.Wrapf(err, "DbDeleteFollowMappingsWithTxn: ")
+	}
+
 	// When a message exists, delete the mapping for the sender and receiver.
 	if err := DBDeleteWithTxn(txn, snap, _dbKeyForFollowerToFollowedMapping(followerPKID, followedPKID)); err != nil {
 		return errors.Wrapf(err, "DbDeleteFollowMappingsWithTxn: Deleting "+
@@ -2345,19 +4120,60 @@ func DbDeleteFollowMappings(handle *badger.DB, snap *Snapshot,
 	})
 }
 
+func DbGetFollowerCountForPKIDWithTxn(txn *badger.Txn, pkid *PKID) (uint64, error) {
+	count, err := _dbGetFollowCountWithTxn(
+		txn, _dbKeyForPKIDToFollowerCount(pkid), _dbSeekPrefixForPKIDsFollowingYou(pkid))
+	if err != nil {
+		return 0, errors.Wrapf(err, "DbGetFollowerCountForPKIDWithTxn: ")
+	}
+	return count, nil
+}
+
+// DbGetFollowerCountForPKID returns the number of PKIDs following the PKID.
+func DbGetFollowerCountForPKID(handle *badger.DB, pkid *PKID) (uint64, error) {
+	var count uint64
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		count, err = DbGetFollowerCountForPKIDWithTxn(txn, pkid)
+		return err
+	})
+	return count, err
+}
+
+func DbGetFollowingCountForPKIDWithTxn(txn *badger.Txn, pkid *PKID) (uint64, error) {
+	count, err := _dbGetFollowCountWithTxn(
+		txn, _dbKeyForPKIDToFollowingCount(pkid), _dbSeekPrefixForPKIDsYouFollow(pkid))
+	if err != nil {
+		return 0, errors.Wrapf(err, "DbGetFollowingCountForPKIDWithTxn: ")
+	}
+	return count, nil
+}
+
+// DbGetFollowingCountForPKID returns the number of PKIDs the PKID follows.
+func DbGetFollowingCountForPKID(handle *badger.DB, pkid *PKID) (uint64, error) {
+	var count uint64
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		count, err = DbGetFollowingCountForPKIDWithTxn(txn, pkid)
+		return err
+	})
+	return count, err
+}
+
 func DbGetPKIDsYouFollow(handle *badger.DB, yourPKID *PKID) (
 	_pkids []*PKID, _err error) {
 
 	prefix := _dbSeekPrefixForPKIDsYouFollow(yourPKID)
 	keysFound, _ := _enumerateKeysForPrefix(handle, prefix)
 
+	keyCodec := MustGetKeyCodec(Prefixes.PrefixFollowerPKIDToFollowedPKID)
 	pkidsYouFollow := []*PKID{}
 	for _, keyBytes := range keysFound {
-		// We must slice off the first byte and followerPKID to get the followedPKID.
-		followedPKIDBytes := keyBytes[1+btcec.PubKeyBytesLenCompressed:]
-		followedPKID := &PKID{}
-		copy(followedPKID[:], followedPKIDBytes)
-		pkidsYouFollow = append(pkidsYouFollow, followedPKID)
+		decodedKey, err := keyCodec.Decode(keyBytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DbGetPKIDsYouFollow: ")
+		}
+		pkidsYouFollow = append(pkidsYouFollow, decodedKey.PKID("FollowedPKID"))
 	}
 
 	return pkidsYouFollow, nil
@@ -2369,13 +4185,14 @@ func DbGetPKIDsFollowingYou(handle *badger.DB, yourPKID *PKID) (
 	prefix := _dbSeekPrefixForPKIDsFollowingYou(yourPKID)
 	keysFound, _ := _enumerateKeysForPrefix(handle, prefix)
 
+	keyCodec := MustGetKeyCodec(Prefixes.PrefixFollowedPKIDToFollowerPKID)
 	pkidsFollowingYou := []*PKID{}
 	for _, keyBytes := range keysFound {
-		// We must slice off the first byte and followedPKID to get the followerPKID.
-		followerPKIDBytes := keyBytes[1+btcec.PubKeyBytesLenCompressed:]
-		followerPKID := &PKID{}
-		copy(followerPKID[:], followerPKIDBytes)
-		pkidsFollowingYou = append(pkidsFollowingYou, followerPKID)
+		decodedKey, err := keyCodec.Decode(keyBytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DbGetPKIDsFollowingYou: ")
+		}
+		pkidsFollowingYou = append(pkidsFollowingYou, decodedKey.PKID("FollowerPKID"))
 	}
 
 	return pkidsFollowingYou, nil
@@ -2507,6 +4324,17 @@ func DbPutDiamondMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight ui
 			"length %d != %d", len(diamondEntry.SenderPKID), btcec.PubKeyBytesLenCompressed)
 	}
 
+	// The aggregates have to lose the diamond this one replaces, if any, before they gain this one.
+	if existingMapping := DbGetDiamondMappingsWithTxn(txn, snap,
+		diamondEntry.ReceiverPKID, diamondEntry.SenderPKID, diamondEntry.DiamondPostHash); existingMapping != nil {
+		if err := _dbAddDiamondToAggregatesWithTxn(txn, snap, existingMapping, false /*isAdd*/); err != nil {
+			return errors.Wrapf(err, "DbPutDiamondMappingsWithTxn: Problem updating diamond aggregates: ")
+		}
+	}
+	if err := _dbAddDiamondToAggregatesWithTxn(txn, snap, diamondEntry, true /*isAdd*/); err != nil {
+		return errors.Wrapf(err, "DbPutDiamondMappingsWithTxn: Problem updating diamond aggregates: ")
+	}
+
 	diamondEntryBytes := EncodeToBytes(blockHeight, diamondEntry)
 	if err := DBSetWithTxn(txn, snap, _dbKeyForDiamondReceiverToDiamondSenderMapping(diamondEntry), diamondEntryBytes); err != nil {
 		return errors.Wrapf(
@@ -2574,6 +4402,9 @@ func DbDeleteDiamondMappingsWithTxn(txn *badger.Txn, snap *Snapshot, diamondEntr
 	if existingMapping == nil {
 		return nil
 	}
+	if err := _dbAddDiamondToAggregatesWithTxn(txn, snap, existingMapping, false /*isAdd*/); err != nil {
+		return errors.Wrapf(err, "DbDeleteDiamondMappingsWithTxn: Problem updating diamond aggregates: ")
+	}
 
 	// When a DiamondEntry exists, delete the diamond mappings.
 	if err := DBDeleteWithTxn(txn, snap, _dbKeyForDiamondReceiverToDiamondSenderMapping(diamondEntry)); err != nil {
@@ -2618,16 +4449,10 @@ func DbGetPKIDsThatDiamondedYouMap(handle *badger.DB, yourPKID *PKID, fetchYouDi
 	_pkidToDiamondsMap map[PKID][]*DiamondEntry, _err error) {
 
 	prefix := _dbSeekPrefixForPKIDsThatDiamondedYou(yourPKID)
-	diamondSenderStartIdx := 1 + btcec.PubKeyBytesLenCompressed
-	diamondSenderEndIdx := 1 + 2*btcec.PubKeyBytesLenCompressed
-	diamondReceiverStartIdx := 1
-	diamondReceiverEndIdx := 1 + btcec.PubKeyBytesLenCompressed
+	keyCodec := MustGetKeyCodec(Prefixes.PrefixDiamondReceiverPKIDDiamondSenderPKIDPostHash)
 	if fetchYouDiamonded {
 		prefix = _dbSeekPrefixForPKIDsThatYouDiamonded(yourPKID)
-		diamondSenderStartIdx = 1
-		diamondSenderEndIdx = 1 + btcec.PubKeyBytesLenCompressed
-		diamondReceiverStartIdx = 1 + btcec.PubKeyBytesLenCompressed
-		diamondReceiverEndIdx = 1 + 2*btcec.PubKeyBytesLenCompressed
+		keyCodec = MustGetKeyCodec(Prefixes.PrefixDiamondSenderPKIDDiamondReceiverPKIDPostHash)
 	}
 	keysFound, valsFound := _enumerateKeysForPrefix(handle, prefix)
 
@@ -2643,20 +4468,16 @@ func DbGetPKIDsThatDiamondedYouMap(handle *badger.DB, yourPKID *PKID, fetchYouDi
 					"and key bytes %#v when seeking; this should never happen",
 				PkToStringMainnet(yourPKID[:]), keyBytes)
 		}
-		expectedDiamondKeyLen := 1 + 2*btcec.PubKeyBytesLenCompressed + HashSizeBytes
-		if len(keyBytes) != expectedDiamondKeyLen {
-			return nil, fmt.Errorf(
-				"DbGetPKIDsThatDiamondedYouMap: Invalid key length %v should be %v",
-				len(keyBytes), expectedDiamondKeyLen)
+		decodedKey, err := keyCodec.Decode(keyBytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DbGetPKIDsThatDiamondedYouMap: ")
 		}
 
 		// Note: The code below is mainly just sanity-checking. Checking the key isn't actually
 		// needed in this function, since all the information is duplicated in the entry.
 
-		// Chop out the diamond sender PKID.
-		diamondSenderPKIDBytes := keyBytes[diamondSenderStartIdx:diamondSenderEndIdx]
-		diamondSenderPKID := &PKID{}
-		copy(diamondSenderPKID[:], diamondSenderPKIDBytes)
+		// Get the diamond sender PKID.
+		diamondSenderPKID := decodedKey.PKID("SenderPKID")
 		// It must match what's in the DiamondEntry
 		if !reflect.DeepEqual(diamondSenderPKID, diamondEntry.SenderPKID) {
 			return nil, fmt.Errorf(
@@ -2665,10 +4486,8 @@ func DbGetPKIDsThatDiamondedYouMap(handle *badger.DB, yourPKID *PKID, fetchYouDi
 				PkToStringBoth(diamondSenderPKID[:]), PkToStringBoth(diamondEntry.SenderPKID[:]))
 		}
 
-		// Chop out the diamond receiver PKID
-		diamondReceiverPKIDBytes := keyBytes[diamondReceiverStartIdx:diamondReceiverEndIdx]
-		diamondReceiverPKID := &PKID{}
-		copy(diamondReceiverPKID[:], diamondReceiverPKIDBytes)
+		// Get the diamond receiver PKID
+		diamondReceiverPKID := decodedKey.PKID("ReceiverPKID")
 		// It must match what's in the DiamondEntry
 		if !reflect.DeepEqual(diamondReceiverPKID, diamondEntry.ReceiverPKID) {
 			return nil, fmt.Errorf(
@@ -2677,10 +4496,8 @@ func DbGetPKIDsThatDiamondedYouMap(handle *badger.DB, yourPKID *PKID, fetchYouDi
 				PkToStringBoth(diamondReceiverPKID[:]), PkToStringBoth(diamondEntry.ReceiverPKID[:]))
 		}
 
-		// Chop out the diamond post hash.
-		diamondPostHashBytes := keyBytes[1+2*btcec.PubKeyBytesLenCompressed:]
-		diamondPostHash := &BlockHash{}
-		copy(diamondPostHash[:], diamondPostHashBytes)
+		// Get the diamond post hash.
+		diamondPostHash := decodedKey.BlockHash("PostHash")
 		// It must match what's in the entry
 		if *diamondPostHash != *diamondEntry.DiamondPostHash {
 			return nil, fmt.Errorf(
@@ -2704,6 +4521,7 @@ func DbGetDiamondEntriesForSenderToReceiver(handle *badger.DB, receiverPKID *PKI
 
 	prefix := _dbSeekPrefixForReceiverPKIDAndSenderPKID(receiverPKID, senderPKID)
 	keysFound, valsFound := _enumerateKeysForPrefix(handle, prefix)
+	keyCodec := MustGetKeyCodec(Prefixes.PrefixDiamondReceiverPKIDDiamondSenderPKIDPostHash)
 	var diamondEntries []*DiamondEntry
 	for ii, keyBytes := range keysFound {
 		// The DiamondEntry found must not be nil.
@@ -2716,20 +4534,16 @@ func DbGetDiamondEntriesForSenderToReceiver(handle *badger.DB, receiverPKID *PKI
 					"and giver key %v when seeking; this should never happen",
 				PkToStringMainnet(receiverPKID[:]), PkToStringMainnet(senderPKID[:]))
 		}
-		expectedDiamondKeyLen := 1 + 2*btcec.PubKeyBytesLenCompressed + HashSizeBytes
-		if len(keyBytes) != expectedDiamondKeyLen {
-			return nil, fmt.Errorf(
-				"DbGetDiamondEntriesForGiverToReceiver: Invalid key length %v should be %v",
-				len(keyBytes), expectedDiamondKeyLen)
+		decodedKey, err := keyCodec.Decode(keyBytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DbGetDiamondEntriesForGiverToReceiver: ")
 		}
 
 		// Note: The code below is mainly just sanity-checking. Checking the key isn't actually
 		// needed in this function, since all the information is duplicated in the entry.
 
-		// Chop out the diamond sender PKID.
-		diamondSenderPKIDBytes := keyBytes[1+btcec.PubKeyBytesLenCompressed : 1+2*btcec.PubKeyBytesLenCompressed]
-		diamondSenderPKID := &PKID{}
-		copy(diamondSenderPKID[:], diamondSenderPKIDBytes)
+		// Get the diamond sender PKID.
+		diamondSenderPKID := decodedKey.PKID("SenderPKID")
 		// It must match what's in the DiamondEntry
 		if !reflect.DeepEqual(diamondSenderPKID, diamondEntry.SenderPKID) {
 			return nil, fmt.Errorf(
@@ -2738,10 +4552,8 @@ func DbGetDiamondEntriesForSenderToReceiver(handle *badger.DB, receiverPKID *PKI
 				PkToStringBoth(diamondSenderPKID[:]), PkToStringBoth(diamondEntry.SenderPKID[:]))
 		}
 
-		// Chop out the diamond post hash.
-		diamondPostHashBytes := keyBytes[1+2*btcec.PubKeyBytesLenCompressed:]
-		diamondPostHash := &BlockHash{}
-		copy(diamondPostHash[:], diamondPostHashBytes)
+		// Get the diamond post hash.
+		diamondPostHash := decodedKey.BlockHash("PostHash")
 		// It must match what's in the entry
 		if *diamondPostHash != *diamondEntry.DiamondPostHash {
 			return nil, fmt.Errorf(
@@ -2756,31 +4568,375 @@ func DbGetDiamondEntriesForSenderToReceiver(handle *badger.DB, receiverPKID *PKI
 }
 
 // -------------------------------------------------------------------------------------
-// BitcoinBurnTxID mapping functions
-// <BitcoinBurnTxID BlockHash> -> <>
+// Diamond aggregate mapping functions
+// <prefix_id, PostHash [32]byte> -> <DiamondAggregate>
+// <prefix_id, ReceiverPKID [33]byte> -> <DiamondAggregate>
+// <prefix_id, SenderPKID [33]byte> -> <DiamondAggregate>
+// <prefix_id, TotalValueNanos uint64, PostHash [32]byte> -> <>
+// <prefix_id, TotalValueNanos uint64, ReceiverPKID [33]byte> -> <>
+// <prefix_id, TotalValueNanos uint64, SenderPKID [33]byte> -> <>
 // -------------------------------------------------------------------------------------
 
-func _keyForBitcoinBurnTxID(bitcoinBurnTxID *BlockHash) []byte {
-	// Make a copy to avoid multiple calls to this function re-using the same
-	// underlying array.
-	prefixCopy := append([]byte{}, Prefixes.PrefixBitcoinBurnTxIDs...)
-	return append(prefixCopy, bitcoinBurnTxID[:]...)
+// DiamondAggregate is the number of diamonds a post or PKID has received or given, counting each
+// DiamondEntry as its level, and their value in DESO nanos at the current diamond levels.
+type DiamondAggregate struct {
+	NumDiamonds     uint64
+	TotalValueNanos uint64
 }
 
-func DbPutBitcoinBurnTxIDWithTxn(txn *badger.Txn, snap *Snapshot, bitcoinBurnTxID *BlockHash) error {
-	return DBSetWithTxn(txn, snap, _keyForBitcoinBurnTxID(bitcoinBurnTxID), []byte{})
-}
+// DiamondAggregateType is what a DiamondAggregate is the diamonds of.
+type DiamondAggregateType uint8
 
-func DbExistsBitcoinBurnTxIDWithTxn(txn *badger.Txn, snap *Snapshot, bitcoinBurnTxID *BlockHash) bool {
-	// We don't care about the value because we're just checking to see if the key exists.
-	if _, err := DBGetWithTxn(txn, snap, _keyForBitcoinBurnTxID(bitcoinBurnTxID)); err != nil {
-		return false
+const (
+	DiamondAggregateTypePost     DiamondAggregateType = 0
+	DiamondAggregateTypeReceived DiamondAggregateType = 1
+	DiamondAggregateTypeSent     DiamondAggregateType = 2
+)
+
+func _dbPrefixesForDiamondAggregateType(aggregateType DiamondAggregateType) (
+	_aggregatePrefix []byte, _leaderboardPrefix []byte) {
+
+	switch aggregateType {
+	case DiamondAggregateTypePost:
+		return Prefixes.PrefixDiamondAggregateByPostHash, Prefixes.PrefixDiamondValuePostHash
+	case DiamondAggregateTypeReceived:
+		return Prefixes.PrefixDiamondsReceivedAggregateByPKID, Prefixes.PrefixDiamondsReceivedValuePKID
+	default:
+		return Prefixes.PrefixDiamondsSentAggregateByPKID, Prefixes.PrefixDiamondsSentValuePKID
 	}
-	return true
 }
 
-func DbExistsBitcoinBurnTxID(db *badger.DB, snap *Snapshot, bitcoinBurnTxID *BlockHash) bool {
-	var exists bool
+var _allDiamondAggregateTypes = []DiamondAggregateType{
+	DiamondAggregateTypePost, DiamondAggregateTypeReceived, DiamondAggregateTypeSent}
+
+// _diamondAggregateIDForEntry returns the post hash or PKID whose aggregate of the type the
+// DiamondEntry counts towards.
+func _diamondAggregateIDForEntry(aggregateType DiamondAggregateType, diamondEntry *DiamondEntry) []byte {
+	switch aggregateType {
+	case DiamondAggregateTypePost:
+		return diamondEntry.DiamondPostHash[:]
+	case DiamondAggregateTypeReceived:
+		return diamondEntry.ReceiverPKID[:]
+	default:
+		return diamondEntry.SenderPKID[:]
+	}
+}
+
+func _dbKeyForDiamondAggregate(aggregateType DiamondAggregateType, id []byte) []byte {
+	aggregatePrefix, _ := _dbPrefixesForDiamondAggregateType(aggregateType)
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, aggregatePrefix...)
+	return append(prefixCopy, id...)
+}
+
+func _dbKeyForDiamondLeaderboard(aggregateType DiamondAggregateType, totalValueNanos uint64, id []byte) []byte {
+	_, leaderboardPrefix := _dbPrefixesForDiamondAggregateType(aggregateType)
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	key := append([]byte{}, leaderboardPrefix...)
+	key = append(key, EncodeUint64(totalValueNanos)...)
+	return append(key, id...)
+}
+
+func _dbGetDiamondAggregateWithTxn(txn *badger.Txn, snap *Snapshot, aggregateType DiamondAggregateType,
+	id []byte) (*DiamondAggregate, error) {
+
+	aggregateBytes, err := DBGetWithTxn(txn, snap, _dbKeyForDiamondAggregate(aggregateType, id))
+	// Posts and PKIDs without diamonds don't have an aggregate.
+	if err == badger.ErrKeyNotFound {
+		return &DiamondAggregate{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "_dbGetDiamondAggregateWithTxn: Problem getting aggregate for %v", id)
+	}
+	if len(aggregateBytes) != 16 {
+		return nil, fmt.Errorf("_dbGetDiamondAggregateWithTxn: Aggregate for %v has %v bytes, expected 16",
+			id, len(aggregateBytes))
+	}
+	return &DiamondAggregate{
+		NumDiamonds:     DecodeUint64(aggregateBytes[:8]),
+		TotalValueNanos: DecodeUint64(aggregateBytes[8:]),
+	}, nil
+}
+
+// _dbPutDiamondAggregateWithTxn replaces the aggregate and its leaderboard key. The old aggregate
+// is needed to find the old leaderboard key.
+func _dbPutDiamondAggregateWithTxn(txn *badger.Txn, snap *Snapshot, aggregateType DiamondAggregateType,
+	id []byte, oldAggregate *DiamondAggregate, newAggregate *DiamondAggregate) error {
+
+	if oldAggregate.NumDiamonds != 0 {
+		if err := DBDeleteWithTxn(txn, snap, _dbKeyForDiamondLeaderboard(
+			aggregateType, oldAggregate.TotalValueNanos, id)); err != nil {
+			return err
+		}
+	}
+	// Posts and PKIDs without diamonds don't need an aggregate.
+	if newAggregate.NumDiamonds == 0 {
+		return DBDeleteWithTxn(txn, snap, _dbKeyForDiamondAggregate(aggregateType, id))
+	}
+	aggregateBytes := append(EncodeUint64(newAggregate.NumDiamonds), EncodeUint64(newAggregate.TotalValueNanos)...)
+	if err := DBSetWithTxn(txn, snap, _dbKeyForDiamondAggregate(aggregateType, id), aggregateBytes); err != nil {
+		return err
+	}
+	return DBSetWithTxn(txn, snap, _dbKeyForDiamondLeaderboard(
+		aggregateType, newAggregate.TotalValueNanos, id), []byte{})
+}
+
+// _dbAddDiamondToAggregatesWithTxn adds or removes a DiamondEntry from the aggregates of its post,
+// its receiver and its sender.
+func _dbAddDiamondToAggregatesWithTxn(txn *badger.Txn, snap *Snapshot, diamondEntry *DiamondEntry, isAdd bool) error {
+	numDiamonds := uint64(diamondEntry.DiamondLevel)
+	valueNanos := GetDeSoNanosForDiamondLevelAtBlockHeight(diamondEntry.DiamondLevel, 0)
+	for _, aggregateType := range _allDiamondAggregateTypes {
+		id := _diamondAggregateIDForEntry(aggregateType, diamondEntry)
+		oldAggregate, err := _dbGetDiamondAggregateWithTxn(txn, snap, aggregateType, id)
+		if err != nil {
+			return err
+		}
+		newAggregate := *oldAggregate
+		if isAdd {
+			newAggregate.NumDiamonds += numDiamonds
+			newAggregate.TotalValueNanos += valueNanos
+		} else if newAggregate.NumDiamonds < numDiamonds || newAggregate.TotalValueNanos < valueNanos {
+			// This can only happen if the diamond aggregates weren't backfilled. It's not worth
+			// failing the flush over, since the aggregates aren't part of the state.
+			glog.Errorf("_dbAddDiamondToAggregatesWithTxn: Aggregate %+v for %v is missing diamond %+v",
+				oldAggregate, id, diamondEntry)
+			newAggregate = DiamondAggregate{}
+		} else {
+			newAggregate.NumDiamonds -= numDiamonds
+			newAggregate.TotalValueNanos -= valueNanos
+		}
+		if err = _dbPutDiamondAggregateWithTxn(txn, snap, aggregateType, id, oldAggregate, &newAggregate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func _dbGetDiamondAggregate(handle *badger.DB, snap *Snapshot, aggregateType DiamondAggregateType,
+	id []byte) (*DiamondAggregate, error) {
+
+	var aggregate *DiamondAggregate
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		aggregate, err = _dbGetDiamondAggregateWithTxn(txn, snap, aggregateType, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return aggregate, nil
+}
+
+// DbGetDiamondAggregateForPost returns the diamonds the post has received. It's a single lookup no
+// matter how many diamonds the post has.
+func DbGetDiamondAggregateForPost(handle *badger.DB, snap *Snapshot, postHash *BlockHash) (*DiamondAggregate, error) {
+	return _dbGetDiamondAggregate(handle, snap, DiamondAggregateTypePost, postHash[:])
+}
+
+// DbGetDiamondsReceivedAggregateForPKID returns the diamonds the PKID has received on all its posts.
+func DbGetDiamondsReceivedAggregateForPKID(handle *badger.DB, snap *Snapshot, pkid *PKID) (*DiamondAggregate, error) {
+	return _dbGetDiamondAggregate(handle, snap, DiamondAggregateTypeReceived, pkid[:])
+}
+
+// DbGetDiamondsSentAggregateForPKID returns the diamonds the PKID has given to all posts.
+func DbGetDiamondsSentAggregateForPKID(handle *badger.DB, snap *Snapshot, pkid *PKID) (*DiamondAggregate, error) {
+	return _dbGetDiamondAggregate(handle, snap, DiamondAggregateTypeSent, pkid[:])
+}
+
+// DbGetDiamondLeaderboard returns the ids of up to limit posts or PKIDs with the most valuable
+// diamonds of the aggregate type, most valuable first, along with their aggregates. Ids are post
+// hashes for DiamondAggregateTypePost and PKIDs otherwise. A limit of zero returns all of them.
+// Pagination starts at startKey, which is either empty or the _nextStartKey returned for the
+// previous page. _nextStartKey is nil once there are no more entries.
+func DbGetDiamondLeaderboard(handle *badger.DB, snap *Snapshot, aggregateType DiamondAggregateType,
+	limit int, startKey []byte) (_ids [][]byte, _aggregates []*DiamondAggregate, _nextStartKey []byte, _err error) {
+
+	_, prefix := _dbPrefixesForDiamondAggregateType(aggregateType)
+	if len(startKey) == 0 {
+		startKey = prefix
+	} else if !bytes.HasPrefix(startKey, prefix) {
+		return nil, nil, nil, fmt.Errorf("DbGetDiamondLeaderboard: Start key %v isn't a "+
+			"leaderboard key for aggregate type %v", startKey, aggregateType)
+	}
+	idLen := btcec.PubKeyBytesLenCompressed
+	if aggregateType == DiamondAggregateTypePost {
+		idLen = HashSizeBytes
+	}
+	keyLen := len(prefix) + 8 + idLen
+	numToFetch := 0
+	if limit > 0 {
+		// Fetch one extra key so we know where the next page starts.
+		numToFetch = limit + 1
+	}
+
+	var ids [][]byte
+	var aggregates []*DiamondAggregate
+	var nextStartKey []byte
+	err := handle.View(func(txn *badger.Txn) error {
+		keysFound, _, err := DBGetPaginatedKeysAndValuesForPrefixWithTxn(
+			txn, startKey, prefix, keyLen, numToFetch, true /*reverse*/, false /*fetchValues*/)
+		if err != nil {
+			return err
+		}
+		if limit > 0 && len(keysFound) > limit {
+			nextStartKey = keysFound[limit]
+			keysFound = keysFound[:limit]
+		}
+		for _, keyBytes := range keysFound {
+			id := keyBytes[keyLen-idLen:]
+			aggregate, err := _dbGetDiamondAggregateWithTxn(txn, snap, aggregateType, id)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+			aggregates = append(aggregates, aggregate)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, errors.Wrapf(err, "DbGetDiamondLeaderboard: ")
+	}
+	return ids, aggregates, nextStartKey, nil
+}
+
+func DbIsDiamondAggregatesBackfilled(handle *badger.DB) bool {
+	var isBackfilled bool
+	handle.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(Prefixes.PrefixDiamondAggregatesBackfilled)
+		isBackfilled = err == nil
+		return nil
+	})
+	return isBackfilled
+}
+
+// DbBackfillDiamondAggregates rebuilds the diamond aggregates and leaderboards from the
+// DiamondEntries in the db, and marks them as backfilled. It must not run concurrently with
+// diamond flushes.
+func DbBackfillDiamondAggregates(handle *badger.DB, snap *Snapshot) error {
+	// Drop the existing aggregates, since some of their diamonds might not exist anymore.
+	for _, aggregateType := range _allDiamondAggregateTypes {
+		aggregatePrefix, leaderboardPrefix := _dbPrefixesForDiamondAggregateType(aggregateType)
+		for _, prefix := range [][]byte{aggregatePrefix, leaderboardPrefix} {
+			staleKeys, _, err := DBGetPaginatedKeysAndValuesForPrefix(handle, prefix, prefix,
+				0 /*keyLen*/, 0 /*numToFetch*/, false /*reverse*/, false /*fetchValues*/)
+			if err != nil {
+				return errors.Wrapf(err, "DbBackfillDiamondAggregates: Problem reading existing aggregates")
+			}
+			for start := 0; start < len(staleKeys); start += DiamondAggregateMigrationBatchSize {
+				end := start + DiamondAggregateMigrationBatchSize
+				if end > len(staleKeys) {
+					end = len(staleKeys)
+				}
+				err = handle.Update(func(txn *badger.Txn) error {
+					for _, key := range staleKeys[start:end] {
+						if err := DBDeleteWithTxn(txn, snap, key); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				if err != nil {
+					return errors.Wrapf(err, "DbBackfillDiamondAggregates: Problem deleting existing aggregates")
+				}
+			}
+		}
+	}
+
+	type aggregateKey struct {
+		AggregateType DiamondAggregateType
+		// Post hashes and PKIDs both fit in a PkMapKey.
+		ID    PkMapKey
+		IDLen int
+	}
+	var aggregatesMtx sync.Mutex
+	aggregates := make(map[aggregateKey]*DiamondAggregate)
+	err := ParallelScanPrefix(handle, Prefixes.PrefixDiamondReceiverPKIDDiamondSenderPKIDPostHash, runtime.GOMAXPROCS(0),
+		func(key []byte, value []byte) error {
+			diamondEntry := &DiamondEntry{}
+			if exists, err := DecodeFromBytes(diamondEntry, bytes.NewReader(value)); !exists || err != nil {
+				return fmt.Errorf("Problem decoding diamond entry for key %v: %v", key, err)
+			}
+			valueNanos := GetDeSoNanosForDiamondLevelAtBlockHeight(diamondEntry.DiamondLevel, 0)
+			aggregatesMtx.Lock()
+			defer aggregatesMtx.Unlock()
+			for _, aggregateType := range _allDiamondAggregateTypes {
+				id := _diamondAggregateIDForEntry(aggregateType, diamondEntry)
+				mapKey := aggregateKey{AggregateType: aggregateType, IDLen: len(id)}
+				copy(mapKey.ID[:], id)
+				if _, exists := aggregates[mapKey]; !exists {
+					aggregates[mapKey] = &DiamondAggregate{}
+				}
+				aggregates[mapKey].NumDiamonds += uint64(diamondEntry.DiamondLevel)
+				aggregates[mapKey].TotalValueNanos += valueNanos
+			}
+			return nil
+		})
+	if err != nil {
+		return errors.Wrapf(err, "DbBackfillDiamondAggregates: Problem scanning diamond entries")
+	}
+
+	// Write the aggregates in batches so that we don't exceed badger's txn size limits.
+	var mapKeys []aggregateKey
+	for mapKey := range aggregates {
+		mapKeys = append(mapKeys, mapKey)
+	}
+	for start := 0; start < len(mapKeys); start += DiamondAggregateMigrationBatchSize {
+		end := start + DiamondAggregateMigrationBatchSize
+		if end > len(mapKeys) {
+			end = len(mapKeys)
+		}
+		err = handle.Update(func(txn *badger.Txn) error {
+			for _, mapKey := range mapKeys[start:end] {
+				id := mapKey.ID
+				if err := _dbPutDiamondAggregateWithTxn(txn, snap, mapKey.AggregateType, id[:mapKey.IDLen],
+					&DiamondAggregate{}, aggregates[mapKey]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "DbBackfillDiamondAggregates: Problem writing aggregates")
+		}
+	}
+
+	err = handle.Update(func(txn *badger.Txn) error {
+		return txn.Set(Prefixes.PrefixDiamondAggregatesBackfilled, []byte{})
+	})
+	if err != nil {
+		return errors.Wrapf(err, "DbBackfillDiamondAggregates: Problem marking diamond aggregates as backfilled")
+	}
+	glog.Infof("DbBackfillDiamondAggregates: Backfilled %v diamond aggregates", len(mapKeys))
+	return nil
+}
+
+// -------------------------------------------------------------------------------------
+// BitcoinBurnTxID mapping functions
+// <BitcoinBurnTxID BlockHash> -> <>
+// -------------------------------------------------------------------------------------
+
+func _keyForBitcoinBurnTxID(bitcoinBurnTxID *BlockHash) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same
+	// underlying array.
+	prefixCopy := append([]byte{}, Prefixes.PrefixBitcoinBurnTxIDs...)
+	return append(prefixCopy, bitcoinBurnTxID[:]...)
+}
+
+func DbPutBitcoinBurnTxIDWithTxn(txn *badger.Txn, snap *Snapshot, bitcoinBurnTxID *BlockHash) error {
+	return DBSetWithTxn(txn, snap, _keyForBitcoinBurnTxID(bitcoinBurnTxID), []byte{})
+}
+
+func DbExistsBitcoinBurnTxIDWithTxn(txn *badger.Txn, snap *Snapshot, bitcoinBurnTxID *BlockHash) bool {
+	// We don't care about the value because we're just checking to see if the key exists.
+	if _, err := DBGetWithTxn(txn, snap, _keyForBitcoinBurnTxID(bitcoinBurnTxID)); err != nil {
+		return false
+	}
+	return true
+}
+
+func DbExistsBitcoinBurnTxID(db *badger.DB, snap *Snapshot, bitcoinBurnTxID *BlockHash) bool {
+	var exists bool
 	db.View(func(txn *badger.Txn) error {
 		exists = DbExistsBitcoinBurnTxIDWithTxn(txn, snap, bitcoinBurnTxID)
 		return nil
@@ -2794,11 +4950,15 @@ func DbDeleteBitcoinBurnTxIDWithTxn(txn *badger.Txn, snap *Snapshot, bitcoinBurn
 
 func DbGetAllBitcoinBurnTxIDs(handle *badger.DB) (_bitcoinBurnTxIDs []*BlockHash) {
 	keysFound, _ := _enumerateKeysForPrefix(handle, Prefixes.PrefixBitcoinBurnTxIDs)
+	keyCodec := MustGetKeyCodec(Prefixes.PrefixBitcoinBurnTxIDs)
 	bitcoinBurnTxIDs := []*BlockHash{}
 	for _, key := range keysFound {
-		bbtxid := &BlockHash{}
-		copy(bbtxid[:], key[1:])
-		bitcoinBurnTxIDs = append(bitcoinBurnTxIDs, bbtxid)
+		decodedKey, err := keyCodec.Decode(key)
+		if err != nil {
+			glog.Errorf("DbGetAllBitcoinBurnTxIDs: Skipping invalid key: %v", err)
+			continue
+		}
+		bitcoinBurnTxIDs = append(bitcoinBurnTxIDs, decodedKey.BlockHash("BitcoinTxID"))
 	}
 
 	return bitcoinBurnTxIDs
@@ -2918,6 +5078,95 @@ func DbGetGlobalParamsEntry(handle *badger.DB, snap *Snapshot) *GlobalParamsEntr
 	return globalParamsEntry
 }
 
+// GlobalParamsHistoryRecord is the GlobalParamsEntry that was in effect after the block at
+// BlockHeight updated the global params.
+type GlobalParamsHistoryRecord struct {
+	BlockHeight       uint64
+	GlobalParamsEntry *GlobalParamsEntry
+}
+
+func _dbKeyForGlobalParamsHistory(blockHeight uint64) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixGlobalParamsHistory...)
+	return append(prefixCopy, EncodeUint64(blockHeight)...)
+}
+
+func DbPutGlobalParamsHistoryEntryWithTxn(txn *badger.Txn, blockHeight uint64,
+	globalParamsEntry *GlobalParamsEntry) error {
+
+	err := DBSetWithTxn(txn, nil, _dbKeyForGlobalParamsHistory(blockHeight),
+		EncodeToBytes(blockHeight, globalParamsEntry))
+	if err != nil {
+		return errors.Wrapf(err, "DbPutGlobalParamsHistoryEntryWithTxn: Problem adding global "+
+			"params history entry at height %v: ", blockHeight)
+	}
+	return nil
+}
+
+func DbDeleteGlobalParamsHistoryEntryWithTxn(txn *badger.Txn, blockHeight uint64) error {
+	if err := DBDeleteWithTxn(txn, nil, _dbKeyForGlobalParamsHistory(blockHeight)); err != nil {
+		return errors.Wrapf(err, "DbDeleteGlobalParamsHistoryEntryWithTxn: Problem deleting global "+
+			"params history entry at height %v: ", blockHeight)
+	}
+	return nil
+}
+
+// DbGetGlobalParamsEntryAtHeightWithTxn returns the GlobalParamsEntry in effect after the block at
+// blockHeight, which comes from the last update at or below that height. It returns
+// InitialGlobalParamsEntry if there was no update at or below that height.
+func DbGetGlobalParamsEntryAtHeightWithTxn(txn *badger.Txn, blockHeight uint64) (*GlobalParamsEntry, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.Reverse = true
+	opts.Prefix = Prefixes.PrefixGlobalParamsHistory
+	iterator := txn.NewIterator(opts)
+	defer iterator.Close()
+
+	// In reverse order, Seek finds the largest key that is less than or equal to the one given.
+	iterator.Seek(_dbKeyForGlobalParamsHistory(blockHeight))
+	if !iterator.ValidForPrefix(Prefixes.PrefixGlobalParamsHistory) {
+		return &InitialGlobalParamsEntry, nil
+	}
+	globalParamsEntryBytes, err := iterator.Item().ValueCopy(nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetGlobalParamsEntryAtHeightWithTxn: ")
+	}
+	globalParamsEntry := &GlobalParamsEntry{}
+	if exist, err := DecodeFromBytes(globalParamsEntry, bytes.NewReader(globalParamsEntryBytes)); !exist || err != nil {
+		return nil, fmt.Errorf("DbGetGlobalParamsEntryAtHeightWithTxn: Problem decoding "+
+			"global params entry at height %v: %v", blockHeight, err)
+	}
+	return globalParamsEntry, nil
+}
+
+func DbGetGlobalParamsEntryAtHeight(handle *badger.DB, blockHeight uint64) (*GlobalParamsEntry, error) {
+	var globalParamsEntry *GlobalParamsEntry
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		globalParamsEntry, err = DbGetGlobalParamsEntryAtHeightWithTxn(txn, blockHeight)
+		return err
+	})
+	return globalParamsEntry, err
+}
+
+// DbGetGlobalParamsHistory returns every recorded global params update, ordered by block height.
+func DbGetGlobalParamsHistory(handle *badger.DB) ([]*GlobalParamsHistoryRecord, error) {
+	keysFound, valsFound := _enumerateKeysForPrefix(handle, Prefixes.PrefixGlobalParamsHistory)
+	var records []*GlobalParamsHistoryRecord
+	for ii, keyBytes := range keysFound {
+		globalParamsEntry := &GlobalParamsEntry{}
+		blockHeight := DecodeUint64(keyBytes[len(Prefixes.PrefixGlobalParamsHistory):])
+		if exist, err := DecodeFromBytes(globalParamsEntry, bytes.NewReader(valsFound[ii])); !exist || err != nil {
+			return nil, fmt.Errorf("DbGetGlobalParamsHistory: Problem decoding global params "+
+				"entry at height %v: %v", blockHeight, err)
+		}
+		records = append(records, &GlobalParamsHistoryRecord{
+			BlockHeight:       blockHeight,
+			GlobalParamsEntry: globalParamsEntry,
+		})
+	}
+	return records, nil
+}
+
 func DbPutUSDCentsPerBitcoinExchangeRateWithTxn(txn *badger.Txn, snap *Snapshot,
 	usdCentsPerBitcoinExchangeRate uint64) error {
 
@@ -3114,90 +5363,283 @@ func DbGetUtxosForPubKey(publicKey []byte, handle *badger.DB, snap *Snapshot) ([
 	return utxoEntriesFound, nil
 }
 
-func DeleteUnmodifiedMappingsForUtxoWithTxn(txn *badger.Txn, snap *Snapshot, utxoKey *UtxoKey) error {
-	// Get the entry for the utxoKey from the db.
-	utxoEntry := DbGetUtxoEntryForUtxoKeyWithTxn(txn, snap, utxoKey)
-	if utxoEntry == nil {
-		// If an entry doesn't exist for this key then there is nothing in the
-		// db to delete.
-		return nil
-	}
-
-	// If the entry exists, delete the <UtxoKey -> UtxoEntry> mapping from the db.
-	// It is assumed that the entry corresponding to a key has not been modified
-	// and so is OK to delete
-	if err := DeleteUtxoEntryForKeyWithTxn(txn, snap, utxoKey); err != nil {
-		return err
-	}
-
-	// Delete the <pubkey, utxoKey> -> <> mapping.
-	if err := DeletePubKeyUtxoKeyMappingWithTxn(txn, snap, utxoEntry.PublicKey, utxoKey); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func PutMappingsForUtxoWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
-	utxoKey *UtxoKey, utxoEntry *UtxoEntry) error {
-	// Put the <utxoKey -> utxoEntry> mapping.
-	if err := PutUtxoEntryForUtxoKeyWithTxn(txn, snap, blockHeight, utxoKey, utxoEntry); err != nil {
-		return nil
-	}
-
-	// Put the <pubkey, utxoKey> -> <> mapping.
-	if err := PutPubKeyUtxoKeyWithTxn(txn, snap, utxoEntry.PublicKey, utxoKey); err != nil {
-		return err
-	}
+// -------------------------------------------------------------------------------------
+// Utxo aggregate mapping functions
+// <prefix_id, pubKey [33]byte> -> <NumUtxos uint64, TotalAmountNanos uint64>
+// -------------------------------------------------------------------------------------
 
-	return nil
+// UtxoAggregate is the number and total value of the utxos of a public key.
+type UtxoAggregate struct {
+	NumUtxos         uint64
+	TotalAmountNanos uint64
 }
 
-func _DbKeyForUtxoOps(blockHash *BlockHash) []byte {
-	return append(append([]byte{}, Prefixes.PrefixBlockHashToUtxoOperations...), blockHash[:]...)
+func _dbKeyForPubKeyToUtxoAggregate(publicKey []byte) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixPubKeyToUtxoAggregate...)
+	return append(prefixCopy, publicKey...)
 }
 
-func GetUtxoOperationsForBlockWithTxn(txn *badger.Txn, snap *Snapshot, blockHash *BlockHash) ([][]*UtxoOperation, error) {
-	utxoOpsBytes, err := DBGetWithTxn(txn, snap, _DbKeyForUtxoOps(blockHash))
+func DbGetUtxoAggregateForPubKeyWithTxn(txn *badger.Txn, snap *Snapshot, publicKey []byte) (*UtxoAggregate, error) {
+	aggregateBytes, err := DBGetWithTxn(txn, snap, _dbKeyForPubKeyToUtxoAggregate(publicKey))
+	// Public keys without utxos don't have an aggregate.
+	if err == badger.ErrKeyNotFound {
+		return &UtxoAggregate{}, nil
+	}
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrapf(err, "DbGetUtxoAggregateForPubKeyWithTxn: Problem getting aggregate for %v",
+			PkToStringBoth(publicKey))
 	}
-
-	utxoOpsBundle := &UtxoOperationBundle{}
-	rr := bytes.NewReader(utxoOpsBytes)
-	if exists, err := DecodeFromBytes(utxoOpsBundle, rr); !exists || err != nil {
-		return nil, errors.Wrapf(err, "GetUtxoOperationsForBlockWithTxn: Problem decoding utxoOpsBundle")
+	if len(aggregateBytes) != 16 {
+		return nil, fmt.Errorf("DbGetUtxoAggregateForPubKeyWithTxn: Aggregate for %v has %v bytes, expected 16",
+			PkToStringBoth(publicKey), len(aggregateBytes))
 	}
-
-	return utxoOpsBundle.UtxoOpBundle, nil
+	return &UtxoAggregate{
+		NumUtxos:         DecodeUint64(aggregateBytes[:8]),
+		TotalAmountNanos: DecodeUint64(aggregateBytes[8:]),
+	}, nil
 }
 
-func GetUtxoOperationsForBlock(handle *badger.DB, snap *Snapshot, blockHash *BlockHash) ([][]*UtxoOperation, error) {
-	var ops [][]*UtxoOperation
+// DbGetUtxoAggregateForPubKey returns the number and total value of the public key's utxos in the db.
+// Unlike DbGetUtxosForPubKey, it's a single lookup no matter how many utxos the public key has.
+func DbGetUtxoAggregateForPubKey(handle *badger.DB, snap *Snapshot, publicKey []byte) (*UtxoAggregate, error) {
+	var aggregate *UtxoAggregate
 	err := handle.View(func(txn *badger.Txn) error {
 		var err error
-		ops, err = GetUtxoOperationsForBlockWithTxn(txn, snap, blockHash)
+		aggregate, err = DbGetUtxoAggregateForPubKeyWithTxn(txn, snap, publicKey)
 		return err
 	})
-
-	return ops, err
+	if err != nil {
+		return nil, err
+	}
+	return aggregate, nil
 }
 
-func PutUtxoOperationsForBlockWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
-	blockHash *BlockHash, utxoOpsForBlock [][]*UtxoOperation) error {
+func DbPutUtxoAggregateForPubKeyWithTxn(txn *badger.Txn, snap *Snapshot, publicKey []byte,
+	aggregate *UtxoAggregate) error {
 
-	opBundle := &UtxoOperationBundle{
-		UtxoOpBundle: utxoOpsForBlock,
+	if len(publicKey) != btcec.PubKeyBytesLenCompressed {
+		return fmt.Errorf("DbPutUtxoAggregateForPubKeyWithTxn: Public key has improper length %d != %d",
+			len(publicKey), btcec.PubKeyBytesLenCompressed)
 	}
-	return DBSetWithTxn(txn, snap, _DbKeyForUtxoOps(blockHash), EncodeToBytes(blockHeight, opBundle))
+
+	// Public keys without utxos don't need an aggregate.
+	if aggregate.NumUtxos == 0 {
+		return DBDeleteWithTxn(txn, snap, _dbKeyForPubKeyToUtxoAggregate(publicKey))
+	}
+	aggregateBytes := append(EncodeUint64(aggregate.NumUtxos), EncodeUint64(aggregate.TotalAmountNanos)...)
+	return DBSetWithTxn(txn, snap, _dbKeyForPubKeyToUtxoAggregate(publicKey), aggregateBytes)
 }
 
-func DeleteUtxoOperationsForBlockWithTxn(txn *badger.Txn, snap *Snapshot, blockHash *BlockHash) error {
-	return DBDeleteWithTxn(txn, snap, _DbKeyForUtxoOps(blockHash))
+// _dbAddUtxoToAggregateWithTxn adds or removes a utxo from its public key's aggregate.
+func _dbAddUtxoToAggregateWithTxn(txn *badger.Txn, snap *Snapshot, utxoEntry *UtxoEntry, isAdd bool) error {
+	aggregate, err := DbGetUtxoAggregateForPubKeyWithTxn(txn, snap, utxoEntry.PublicKey)
+	if err != nil {
+		return err
+	}
+	if isAdd {
+		aggregate.NumUtxos++
+		aggregate.TotalAmountNanos += utxoEntry.AmountNanos
+	} else if aggregate.NumUtxos == 0 || aggregate.TotalAmountNanos < utxoEntry.AmountNanos {
+		// This can only happen if the aggregates weren't backfilled. It's not worth failing the
+		// flush over, since the aggregates aren't part of the state.
+		glog.Errorf("_dbAddUtxoToAggregateWithTxn: Aggregate %+v for %v is missing utxo with amount %v",
+			aggregate, PkToStringBoth(utxoEntry.PublicKey), utxoEntry.AmountNanos)
+		aggregate = &UtxoAggregate{}
+	} else {
+		aggregate.NumUtxos--
+		aggregate.TotalAmountNanos -= utxoEntry.AmountNanos
+	}
+	return DbPutUtxoAggregateForPubKeyWithTxn(txn, snap, utxoEntry.PublicKey, aggregate)
 }
 
-func SerializeBlockNode(blockNode *BlockNode) ([]byte, error) {
-	data := []byte{}
+func DbIsUtxoAggregatesBackfilled(handle *badger.DB) bool {
+	var isBackfilled bool
+	handle.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(Prefixes.PrefixUtxoAggregatesBackfilled)
+		isBackfilled = err == nil
+		return nil
+	})
+	return isBackfilled
+}
+
+// DbBackfillUtxoAggregates recomputes the utxo aggregate of every public key from the utxos in the
+// db, and marks the aggregates as backfilled. Aggregates of public keys that no longer have utxos
+// are deleted. It must not run concurrently with utxo flushes.
+func DbBackfillUtxoAggregates(handle *badger.DB, snap *Snapshot) error {
+	var aggregatesMtx sync.Mutex
+	aggregates := make(map[PkMapKey]*UtxoAggregate)
+	err := ParallelScanPrefix(handle, Prefixes.PrefixUtxoKeyToUtxoEntry, runtime.GOMAXPROCS(0),
+		func(key []byte, value []byte) error {
+			utxoEntry := &UtxoEntry{}
+			if exists, err := DecodeFromBytes(utxoEntry, bytes.NewReader(value)); !exists || err != nil {
+				return fmt.Errorf("Problem decoding utxo entry for key %v: %v", key, err)
+			}
+			if len(utxoEntry.PublicKey) != btcec.PubKeyBytesLenCompressed {
+				return fmt.Errorf("Invalid public key %v for key %v", utxoEntry.PublicKey, key)
+			}
+			pkMapKey := MakePkMapKey(utxoEntry.PublicKey)
+			aggregatesMtx.Lock()
+			defer aggregatesMtx.Unlock()
+			if _, exists := aggregates[pkMapKey]; !exists {
+				aggregates[pkMapKey] = &UtxoAggregate{}
+			}
+			aggregates[pkMapKey].NumUtxos++
+			aggregates[pkMapKey].TotalAmountNanos += utxoEntry.AmountNanos
+			return nil
+		})
+	if err != nil {
+		return errors.Wrapf(err, "DbBackfillUtxoAggregates: Problem scanning utxos")
+	}
+
+	// Public keys that have an aggregate but no utxos get an empty aggregate, which deletes it.
+	keyPrefixLen := len(Prefixes.PrefixPubKeyToUtxoAggregate)
+	err = ParallelScanPrefix(handle, Prefixes.PrefixPubKeyToUtxoAggregate, runtime.GOMAXPROCS(0),
+		func(key []byte, value []byte) error {
+			if len(key) != keyPrefixLen+btcec.PubKeyBytesLenCompressed {
+				return nil
+			}
+			pkMapKey := MakePkMapKey(key[keyPrefixLen:])
+			aggregatesMtx.Lock()
+			defer aggregatesMtx.Unlock()
+			if _, exists := aggregates[pkMapKey]; !exists {
+				aggregates[pkMapKey] = &UtxoAggregate{}
+			}
+			return nil
+		})
+	if err != nil {
+		return errors.Wrapf(err, "DbBackfillUtxoAggregates: Problem scanning aggregates")
+	}
+
+	// Write the aggregates in batches so that we don't exceed badger's txn size limits.
+	var publicKeys []PkMapKey
+	for pkMapKey := range aggregates {
+		publicKeys = append(publicKeys, pkMapKey)
+	}
+	for start := 0; start < len(publicKeys); start += UtxoAggregateMigrationBatchSize {
+		end := start + UtxoAggregateMigrationBatchSize
+		if end > len(publicKeys) {
+			end = len(publicKeys)
+		}
+		err = handle.Update(func(txn *badger.Txn) error {
+			for _, pkMapKey := range publicKeys[start:end] {
+				publicKey := pkMapKey
+				if err := DbPutUtxoAggregateForPubKeyWithTxn(txn, snap, publicKey[:], aggregates[pkMapKey]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "DbBackfillUtxoAggregates: Problem writing aggregates")
+		}
+	}
+
+	err = handle.Update(func(txn *badger.Txn) error {
+		return txn.Set(Prefixes.PrefixUtxoAggregatesBackfilled, []byte{})
+	})
+	if err != nil {
+		return errors.Wrapf(err, "DbBackfillUtxoAggregates: Problem marking aggregates as backfilled")
+	}
+	glog.Infof("DbBackfillUtxoAggregates: Backfilled utxo aggregates for %v public keys", len(publicKeys))
+	return nil
+}
+
+func DeleteUnmodifiedMappingsForUtxoWithTxn(txn *badger.Txn, snap *Snapshot, utxoKey *UtxoKey) error {
+	// Get the entry for the utxoKey from the db.
+	utxoEntry := DbGetUtxoEntryForUtxoKeyWithTxn(txn, snap, utxoKey)
+	if utxoEntry == nil {
+		// If an entry doesn't exist for this key then there is nothing in the
+		// db to delete.
+		return nil
+	}
+
+	// If the entry exists, delete the <UtxoKey -> UtxoEntry> mapping from the db.
+	// It is assumed that the entry corresponding to a key has not been modified
+	// and so is OK to delete
+	if err := DeleteUtxoEntryForKeyWithTxn(txn, snap, utxoKey); err != nil {
+		return err
+	}
+
+	// Delete the <pubkey, utxoKey> -> <> mapping.
+	if err := DeletePubKeyUtxoKeyMappingWithTxn(txn, snap, utxoEntry.PublicKey, utxoKey); err != nil {
+		return err
+	}
+
+	// Take the utxo out of its public key's aggregate.
+	if err := _dbAddUtxoToAggregateWithTxn(txn, snap, utxoEntry, false /*isAdd*/); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func PutMappingsForUtxoWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+	utxoKey *UtxoKey, utxoEntry *UtxoEntry) error {
+	// Put the <utxoKey -> utxoEntry> mapping.
+	if err := PutUtxoEntryForUtxoKeyWithTxn(txn, snap, blockHeight, utxoKey, utxoEntry); err != nil {
+		return nil
+	}
+
+	// Put the <pubkey, utxoKey> -> <> mapping.
+	if err := PutPubKeyUtxoKeyWithTxn(txn, snap, utxoEntry.PublicKey, utxoKey); err != nil {
+		return err
+	}
+
+	// Add the utxo to its public key's aggregate. The utxo isn't in the db yet, since the flush
+	// deletes the existing mappings of every utxo in the view before putting them back.
+	if err := _dbAddUtxoToAggregateWithTxn(txn, snap, utxoEntry, true /*isAdd*/); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func _DbKeyForUtxoOps(blockHash *BlockHash) []byte {
+	return append(append([]byte{}, Prefixes.PrefixBlockHashToUtxoOperations...), blockHash[:]...)
+}
+
+func GetUtxoOperationsForBlockWithTxn(txn *badger.Txn, snap *Snapshot, blockHash *BlockHash) ([][]*UtxoOperation, error) {
+	utxoOpsBytes, err := DBGetWithTxn(txn, snap, _DbKeyForUtxoOps(blockHash))
+	if err != nil {
+		return nil, err
+	}
+
+	// Blocks connected by old versions of the node may still have gob-encoded utxo operations. They're
+	// only read here, and go away when the block is disconnected or the node resyncs.
+	utxoOps, _, err := DecodeUtxoOperationsCompat(utxoOpsBytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetUtxoOperationsForBlockWithTxn: ")
+	}
+	return utxoOps, nil
+}
+
+func GetUtxoOperationsForBlock(handle *badger.DB, snap *Snapshot, blockHash *BlockHash) ([][]*UtxoOperation, error) {
+	var ops [][]*UtxoOperation
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		ops, err = GetUtxoOperationsForBlockWithTxn(txn, snap, blockHash)
+		return err
+	})
+
+	return ops, err
+}
+
+func PutUtxoOperationsForBlockWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+	blockHash *BlockHash, utxoOpsForBlock [][]*UtxoOperation) error {
+
+	opBundle := &UtxoOperationBundle{
+		UtxoOpBundle: utxoOpsForBlock,
+	}
+	return DBSetWithTxn(txn, snap, _DbKeyForUtxoOps(blockHash), EncodeToBytes(blockHeight, opBundle))
+}
+
+func DeleteUtxoOperationsForBlockWithTxn(txn *badger.Txn, snap *Snapshot, blockHash *BlockHash) error {
+	return DBDeleteWithTxn(txn, snap, _DbKeyForUtxoOps(blockHash))
+}
+
+func SerializeBlockNode(blockNode *BlockNode) ([]byte, error) {
+	data := []byte{}
 
 	// Hash
 	if blockNode.Hash == nil {
@@ -3347,6 +5789,193 @@ func PutBestHash(handle *badger.DB, snap *Snapshot, bh *BlockHash, chainType Cha
 	})
 }
 
+// DbGetStateFlushHeight returns the height of the block the state was last flushed at, or nil
+// if it was never recorded, e.g. in a db created before we started recording it.
+func DbGetStateFlushHeight(handle *badger.DB, snap *Snapshot) *uint64 {
+	var flushHeight *uint64
+	handle.View(func(txn *badger.Txn) error {
+		heightBytes, err := DBGetWithTxn(txn, snap, Prefixes.PrefixStateFlushHeight)
+		if err != nil {
+			return nil
+		}
+		height := DecodeUint64(heightBytes)
+		flushHeight = &height
+		return nil
+	})
+	return flushHeight
+}
+
+// DbPutStateFlushHeightWithTxn should be called in the same txn that sets the best DeSo block hash.
+func DbPutStateFlushHeightWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64) error {
+	return DBSetWithTxn(txn, snap, Prefixes.PrefixStateFlushHeight, EncodeUint64(blockHeight))
+}
+
+// DbGetPrunedBlockHeightWithTxn returns the height of the highest main chain block that was
+// pruned, or zero if no block was.
+func DbGetPrunedBlockHeightWithTxn(txn *badger.Txn) uint64 {
+	heightBytes, err := DBGetWithTxn(txn, nil, Prefixes.PrefixPrunedBlockHeight)
+	if err != nil {
+		return 0
+	}
+	return DecodeUint64(heightBytes)
+}
+
+func DbGetPrunedBlockHeight(handle *badger.DB) uint64 {
+	var prunedBlockHeight uint64
+	handle.View(func(txn *badger.Txn) error {
+		prunedBlockHeight = DbGetPrunedBlockHeightWithTxn(txn)
+		return nil
+	})
+	return prunedBlockHeight
+}
+
+// DbPutPrunedBlockHeightWithTxn should be called in the same txn that prunes the blocks up to blockHeight.
+func DbPutPrunedBlockHeightWithTxn(txn *badger.Txn, blockHeight uint64) error {
+	return DBSetWithTxn(txn, nil, Prefixes.PrefixPrunedBlockHeight, EncodeUint64(blockHeight))
+}
+
+// DbGetUtxoOpsPrunedBlockHeightWithTxn returns the height of the highest main chain block whose
+// utxo operations were pruned by the retention policy, or zero if none were.
+func DbGetUtxoOpsPrunedBlockHeightWithTxn(txn *badger.Txn) uint64 {
+	heightBytes, err := DBGetWithTxn(txn, nil, Prefixes.PrefixUtxoOpsPrunedBlockHeight)
+	if err != nil {
+		return 0
+	}
+	return DecodeUint64(heightBytes)
+}
+
+func DbGetUtxoOpsPrunedBlockHeight(handle *badger.DB) uint64 {
+	var prunedBlockHeight uint64
+	handle.View(func(txn *badger.Txn) error {
+		prunedBlockHeight = DbGetUtxoOpsPrunedBlockHeightWithTxn(txn)
+		return nil
+	})
+	return prunedBlockHeight
+}
+
+// DbPutUtxoOpsPrunedBlockHeightWithTxn should be called in the same txn that prunes the utxo
+// operations up to blockHeight.
+func DbPutUtxoOpsPrunedBlockHeightWithTxn(txn *badger.Txn, blockHeight uint64) error {
+	return DBSetWithTxn(txn, nil, Prefixes.PrefixUtxoOpsPrunedBlockHeight, EncodeUint64(blockHeight))
+}
+
+// DeleteBlockWithTxn deletes the body of the block with the given hash. The block's node and
+// the block stats and block reward indexes written with it are kept.
+func DeleteBlockWithTxn(txn *badger.Txn, snap *Snapshot, blockHash *BlockHash) error {
+	return DBDeleteWithTxn(txn, snap, BlockHashToBlockKey(blockHash))
+}
+
+func _dbKeyForMainChainHeight(height uint64) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixMainChainHeightToHash...)
+	return append(prefixCopy, EncodeUint64(height)...)
+}
+
+// DbPutMainChainHashAtHeightWithTxn should be called in the same txn that sets the best DeSo block
+// hash, for every block that joins the main chain.
+func DbPutMainChainHashAtHeightWithTxn(txn *badger.Txn, height uint64, blockHash *BlockHash) error {
+	return DBSetWithTxn(txn, nil, _dbKeyForMainChainHeight(height), blockHash[:])
+}
+
+// DbDeleteMainChainHashAtHeightWithTxn should be called in the same txn that sets the best DeSo
+// block hash, for every block that leaves the main chain without being replaced at its height.
+func DbDeleteMainChainHashAtHeightWithTxn(txn *badger.Txn, height uint64) error {
+	return DBDeleteWithTxn(txn, nil, _dbKeyForMainChainHeight(height))
+}
+
+func DbGetMainChainHashAtHeightWithTxn(txn *badger.Txn, height uint64) *BlockHash {
+	hashBytes, err := DBGetWithTxn(txn, nil, _dbKeyForMainChainHeight(height))
+	if err != nil {
+		return nil
+	}
+	return NewBlockHash(hashBytes)
+}
+
+// DbGetMainChainHashAtHeight returns the hash of the main chain block at the height, or nil if
+// the main chain doesn't reach the height.
+func DbGetMainChainHashAtHeight(handle *badger.DB, height uint64) *BlockHash {
+	var blockHash *BlockHash
+	handle.View(func(txn *badger.Txn) error {
+		blockHash = DbGetMainChainHashAtHeightWithTxn(txn, height)
+		return nil
+	})
+	return blockHash
+}
+
+// DbPutMainChain replaces the main chain index with the given best chain, e.g. to build the index for
+// a db that was created before it existed. The index is written MainChainIndexBatchSize heights per
+// txn so that a long chain doesn't exceed badger's txn size limits. If it's interrupted, the index
+// won't match the block index, so it's rebuilt again on the next start.
+func DbPutMainChain(handle *badger.DB, bestChain []*BlockNode) error {
+	// Heights above the tip are deleted, and every other height is overwritten.
+	keysFound, _ := _enumerateKeysForPrefix(handle, Prefixes.PrefixMainChainHeightToHash)
+	var staleKeys [][]byte
+	for _, key := range keysFound {
+		if DecodeUint64(key[len(Prefixes.PrefixMainChainHeightToHash):]) >= uint64(len(bestChain)) {
+			staleKeys = append(staleKeys, key)
+		}
+	}
+	for start := 0; start < len(staleKeys); start += MainChainIndexBatchSize {
+		end := start + MainChainIndexBatchSize
+		if end > len(staleKeys) {
+			end = len(staleKeys)
+		}
+		err := handle.Update(func(txn *badger.Txn) error {
+			for _, key := range staleKeys[start:end] {
+				if err := DBDeleteWithTxn(txn, nil, key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "DbPutMainChain: Problem deleting main chain index entries")
+		}
+	}
+
+	for start := 0; start < len(bestChain); start += MainChainIndexBatchSize {
+		end := start + MainChainIndexBatchSize
+		if end > len(bestChain) {
+			end = len(bestChain)
+		}
+		err := handle.Update(func(txn *badger.Txn) error {
+			for _, node := range bestChain[start:end] {
+				if err := DbPutMainChainHashAtHeightWithTxn(txn, uint64(node.Height), node.Hash); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "DbPutMainChain: Problem putting main chain index entries")
+		}
+	}
+	return nil
+}
+
+// DbGetMainChainHashes returns the main chain block hashes by height.
+func DbGetMainChainHashes(handle *badger.DB) ([]*BlockHash, error) {
+	var blockHashes []*BlockHash
+	err := handle.View(func(txn *badger.Txn) error {
+		keysFound, valsFound, err := _enumerateKeysForPrefixWithTxn(txn, Prefixes.PrefixMainChainHeightToHash)
+		if err != nil {
+			return err
+		}
+		for ii, key := range keysFound {
+			height := DecodeUint64(key[len(Prefixes.PrefixMainChainHeightToHash):])
+			if height != uint64(ii) {
+				return fmt.Errorf("Main chain index has no entry at height %v", ii)
+			}
+			blockHashes = append(blockHashes, NewBlockHash(valsFound[ii]))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetMainChainHashes: ")
+	}
+	return blockHashes, nil
+}
+
 func BlockHashToBlockKey(blockHash *BlockHash) []byte {
 	return append(append([]byte{}, Prefixes.PrefixBlockHashToBlock...), blockHash[:]...)
 }
@@ -3421,6 +6050,9 @@ func PutBlockWithTxn(txn *badger.Txn, snap *Snapshot, desoBlock *MsgDeSoBlock) e
 	if err := DBSetWithTxn(txn, snap, blockKey, data); err != nil {
 		return err
 	}
+	if err := DbPutBlockStatsWithTxn(txn, ComputeBlockStats(desoBlock, blockHash, uint64(len(data)))); err != nil {
+		return err
+	}
 
 	// Index the block reward. Used for deducting immature block rewards from user balances.
 	if len(desoBlock.Txns) == 0 {
@@ -3430,7 +6062,22 @@ func PutBlockWithTxn(txn *badger.Txn, snap *Snapshot, desoBlock *MsgDeSoBlock) e
 	if blockRewardTxn.TxnMeta.GetTxnType() != TxnTypeBlockReward {
 		return fmt.Errorf("PutBlockWithTxn: Got block without block reward as first txn %v", desoBlock)
 	}
-	// It's possible the block reward is split across multiple public keys.
+	for pkMapKeyIter, blockReward := range GetBlockRewardsByPublicKey(desoBlock) {
+		pkMapKey := pkMapKeyIter
+
+		blockRewardKey := PublicKeyBlockHashToBlockRewardKey(pkMapKey[:], blockHash)
+		if err := DBSetWithTxn(txn, snap, blockRewardKey, EncodeUint64(blockReward)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetBlockRewardsByPublicKey returns the total block reward each public key gets from the block,
+// since it's possible the block reward is split across multiple public keys. The block must have a
+// block reward as its first txn.
+func GetBlockRewardsByPublicKey(desoBlock *MsgDeSoBlock) map[PkMapKey]uint64 {
 	pubKeyToBlockRewardMap := make(map[PkMapKey]uint64)
 	for _, bro := range desoBlock.Txns[0].TxOutputs {
 		pkMapKey := MakePkMapKey(bro.PublicKey)
@@ -3440,40 +6087,136 @@ func PutBlockWithTxn(txn *badger.Txn, snap *Snapshot, desoBlock *MsgDeSoBlock) e
 			pubKeyToBlockRewardMap[pkMapKey] += bro.AmountNanos
 		}
 	}
-	for pkMapKeyIter, blockReward := range pubKeyToBlockRewardMap {
-		pkMapKey := pkMapKeyIter
+	return pubKeyToBlockRewardMap
+}
 
-		blockRewardKey := PublicKeyBlockHashToBlockRewardKey(pkMapKey[:], blockHash)
-		if err := DBSetWithTxn(txn, snap, blockRewardKey, EncodeUint64(blockReward)); err != nil {
-			return err
-		}
+// BlockStats is the size of a stored block, what's in it, and the fees its miner claimed.
+type BlockStats struct {
+	BlockHash  *BlockHash
+	Height     uint64
+	BlockBytes uint64
+	TxnCount   uint64
+	// TotalFeesNanos is how much the block reward pays out on top of the block subsidy, which is
+	// all of the block's fees unless the miner left some of them unclaimed.
+	TotalFeesNanos uint64
+}
+
+// ComputeBlockStats returns the stats of a block whose serialized size is blockBytes. The block
+// must have a block reward as its first txn.
+func ComputeBlockStats(desoBlock *MsgDeSoBlock, blockHash *BlockHash, blockBytes uint64) *BlockStats {
+	blockRewardNanos := uint64(0)
+	for _, output := range desoBlock.Txns[0].TxOutputs {
+		blockRewardNanos += output.AmountNanos
+	}
+	totalFeesNanos := uint64(0)
+	if subsidyNanos := CalcBlockRewardNanos(uint32(desoBlock.Header.Height)); blockRewardNanos > subsidyNanos {
+		totalFeesNanos = blockRewardNanos - subsidyNanos
 	}
+	return &BlockStats{
+		BlockHash:      blockHash.NewBlockHash(),
+		Height:         desoBlock.Header.Height,
+		BlockBytes:     blockBytes,
+		TxnCount:       uint64(len(desoBlock.Txns)),
+		TotalFeesNanos: totalFeesNanos,
+	}
+}
 
-	return nil
+func _dbKeyForBlockStats(height uint64, blockHash *BlockHash) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixBlockStats...)
+	key := append(prefixCopy, EncodeUint64(height)...)
+	return append(key, blockHash[:]...)
 }
 
-func PutBlock(handle *badger.DB, snap *Snapshot, desoBlock *MsgDeSoBlock) error {
-	err := handle.Update(func(txn *badger.Txn) error {
-		return PutBlockWithTxn(txn, snap, desoBlock)
-	})
-	if err != nil {
-		return err
+func DbPutBlockStatsWithTxn(txn *badger.Txn, blockStats *BlockStats) error {
+	data := UintToBuf(blockStats.BlockBytes)
+	data = append(data, UintToBuf(blockStats.TxnCount)...)
+	data = append(data, UintToBuf(blockStats.TotalFeesNanos)...)
+	if err := DBSetWithTxn(txn, nil, _dbKeyForBlockStats(blockStats.Height, blockStats.BlockHash), data); err != nil {
+		return errors.Wrapf(err, "DbPutBlockStatsWithTxn: Problem putting stats for block %v", blockStats.BlockHash)
 	}
-
 	return nil
 }
 
-func DeleteBlockReward(handle *badger.DB, snap *Snapshot, desoBlock *MsgDeSoBlock) error {
-	return handle.Update(func(txn *badger.Txn) error {
-		return DeleteBlockRewardWithTxn(txn, snap, desoBlock)
-	})
-}
+// DbGetBlockStatsForHeightRangeWithTxn returns the stats of the main chain blocks with heights in
+// [startHeight, endHeight], ordered by height. Heights whose block was stored before the stats
+// existed are skipped.
+func DbGetBlockStatsForHeightRangeWithTxn(txn *badger.Txn, startHeight uint64, endHeight uint64) (
+	[]*BlockStats, error) {
 
-func DeleteBlockRewardWithTxn(txn *badger.Txn, snap *Snapshot, desoBlock *MsgDeSoBlock) error {
-	blockHash, err := desoBlock.Header.Hash()
-	if err != nil {
-		return errors.Wrapf(err, "DeleteBlockRewardWithTxn: Problem hashing header: ")
-	}
+	iterator := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer iterator.Close()
+
+	blockStatsList := []*BlockStats{}
+	prefix := Prefixes.PrefixBlockStats
+	keyCodec := MustGetKeyCodec(prefix)
+	startKey := keyCodec.MustEncode(startHeight)
+	for iterator.Seek(startKey); iterator.ValidForPrefix(prefix); iterator.Next() {
+		decodedKey, err := keyCodec.Decode(iterator.Item().Key())
+		if err != nil {
+			return nil, errors.Wrapf(err, "DbGetBlockStatsForHeightRangeWithTxn: ")
+		}
+		height := decodedKey.Uint64("BlockHeight")
+		if height > endHeight {
+			break
+		}
+		blockHash := decodedKey.BlockHash("BlockHash")
+		if mainChainHash := DbGetMainChainHashAtHeightWithTxn(txn, height); mainChainHash == nil ||
+			*mainChainHash != *blockHash {
+			continue
+		}
+		data, err := iterator.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DbGetBlockStatsForHeightRangeWithTxn: Problem getting stats at height %v", height)
+		}
+		blockStats := &BlockStats{BlockHash: blockHash, Height: height}
+		rr := bytes.NewReader(data)
+		if blockStats.BlockBytes, err = ReadUvarint(rr); err != nil {
+			return nil, errors.Wrapf(err, "DbGetBlockStatsForHeightRangeWithTxn: Problem reading BlockBytes")
+		}
+		if blockStats.TxnCount, err = ReadUvarint(rr); err != nil {
+			return nil, errors.Wrapf(err, "DbGetBlockStatsForHeightRangeWithTxn: Problem reading TxnCount")
+		}
+		if blockStats.TotalFeesNanos, err = ReadUvarint(rr); err != nil {
+			return nil, errors.Wrapf(err, "DbGetBlockStatsForHeightRangeWithTxn: Problem reading TotalFeesNanos")
+		}
+		blockStatsList = append(blockStatsList, blockStats)
+	}
+	return blockStatsList, nil
+}
+
+func DbGetBlockStatsForHeightRange(handle *badger.DB, startHeight uint64, endHeight uint64) ([]*BlockStats, error) {
+	var blockStatsList []*BlockStats
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		blockStatsList, err = DbGetBlockStatsForHeightRangeWithTxn(txn, startHeight, endHeight)
+		return err
+	})
+	return blockStatsList, err
+}
+
+func PutBlock(handle *badger.DB, snap *Snapshot, desoBlock *MsgDeSoBlock) error {
+	err := handle.Update(func(txn *badger.Txn) error {
+		return PutBlockWithTxn(txn, snap, desoBlock)
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func DeleteBlockReward(handle *badger.DB, snap *Snapshot, desoBlock *MsgDeSoBlock) error {
+	return handle.Update(func(txn *badger.Txn) error {
+		return DeleteBlockRewardWithTxn(txn, snap, desoBlock)
+	})
+}
+
+func DeleteBlockRewardWithTxn(txn *badger.Txn, snap *Snapshot, desoBlock *MsgDeSoBlock) error {
+	blockHash, err := desoBlock.Header.Hash()
+	if err != nil {
+		return errors.Wrapf(err, "DeleteBlockRewardWithTxn: Problem hashing header: ")
+	}
 
 	// Index the block reward. Used for deducting immature block rewards from user balances.
 	if len(desoBlock.Txns) == 0 {
@@ -3628,149 +6371,6 @@ func DbBulkDeleteHeightHashToNodeInfo(handle *badger.DB, snap *Snapshot,
 	return nil
 }
 
-// InitDbWithGenesisBlock initializes the database to contain only the genesis
-// block.
-func InitDbWithDeSoGenesisBlock(params *DeSoParams, handle *badger.DB,
-	eventManager *EventManager, snap *Snapshot) error {
-	// Construct a node for the genesis block. Its height is zero and it has
-	// no parents. Its difficulty should be set to the initial
-	// difficulty specified in the parameters and it should be assumed to be
-	// valid and stored by the end of this function.
-	genesisBlock := params.GenesisBlock
-	diffTarget := MustDecodeHexBlockHash(params.MinDifficultyTargetHex)
-	blockHash := MustDecodeHexBlockHash(params.GenesisBlockHashHex)
-	genesisNode := NewBlockNode(
-		nil, // Parent
-		blockHash,
-		0, // Height
-		diffTarget,
-		BytesToBigint(ExpectedWorkForBlockHash(diffTarget)[:]), // CumWork
-		genesisBlock.Header, // Header
-		StatusHeaderValidated|StatusBlockProcessed|StatusBlockStored|StatusBlockValidated, // Status
-	)
-
-	// Set the fields in the db to reflect the current state of our chain.
-	//
-	// Set the best hash to the genesis block in the db since its the only node
-	// we're currently aware of. Set it for both the header chain and the block
-	// chain.
-	if snap != nil {
-		snap.PrepareAncestralRecordsFlush()
-	}
-
-	if err := PutBestHash(handle, snap, blockHash, ChainTypeDeSoBlock); err != nil {
-		return errors.Wrapf(err, "InitDbWithGenesisBlock: Problem putting genesis block hash into db for block chain")
-	}
-	// Add the genesis block to the (hash -> block) index.
-	if err := PutBlock(handle, snap, genesisBlock); err != nil {
-		return errors.Wrapf(err, "InitDbWithGenesisBlock: Problem putting genesis block into db")
-	}
-	// Add the genesis block to the (height, hash -> node info) index in the db.
-	if err := PutHeightHashToNodeInfo(handle, snap, genesisNode, false /*bitcoinNodes*/); err != nil {
-		return errors.Wrapf(err, "InitDbWithGenesisBlock: Problem putting (height, hash -> node) in db")
-	}
-	if err := DbPutNanosPurchased(handle, snap, params.DeSoNanosPurchasedAtGenesis); err != nil {
-		return errors.Wrapf(err, "InitDbWithGenesisBlock: Problem putting genesis block hash into db for block chain")
-	}
-	if err := DbPutGlobalParamsEntry(handle, snap, 0, InitialGlobalParamsEntry); err != nil {
-		return errors.Wrapf(err, "InitDbWithGenesisBlock: Problem putting GlobalParamsEntry into db for block chain")
-	}
-
-	if snap != nil {
-		snap.StartAncestralRecordsFlush(true)
-	}
-
-	// We apply seed transactions here. This step is useful for setting
-	// up the blockchain with a particular set of transactions, e.g. when
-	// hard forking the chain.
-	//
-	// TODO: Right now there's an issue where if we hit an error during this
-	// step of the initialization, the next time we run the program it will
-	// think things are initialized because we set the best block hash at the
-	// top. We should fix this at some point so that an error in this step
-	// wipes out the best hash.
-	utxoView, err := NewUtxoView(handle, params, nil, snap)
-	if err != nil {
-		return fmt.Errorf(
-			"InitDbWithDeSoGenesisBlock: Error initializing UtxoView")
-	}
-
-	// Add the seed balances to the view.
-	for index, txOutput := range params.SeedBalances {
-		outputKey := UtxoKey{
-			TxID:  BlockHash{},
-			Index: uint32(index),
-		}
-		utxoEntry := UtxoEntry{
-			AmountNanos: txOutput.AmountNanos,
-			PublicKey:   txOutput.PublicKey,
-			BlockHeight: 0,
-			// Just make this a normal transaction so that we don't have to wait for
-			// the block reward maturity.
-			UtxoType: UtxoTypeOutput,
-			UtxoKey:  &outputKey,
-		}
-
-		_, err := utxoView._addUtxo(&utxoEntry)
-		if err != nil {
-			return fmt.Errorf("InitDbWithDeSoGenesisBlock: Error adding "+
-				"seed balance at index %v ; output: %v: %v", index, txOutput, err)
-		}
-	}
-
-	// Add the seed txns to the view
-	utxoOpsForBlock := [][]*UtxoOperation{}
-	for txnIndex, txnHex := range params.SeedTxns {
-		txnBytes, err := hex.DecodeString(txnHex)
-		if err != nil {
-			return fmt.Errorf(
-				"InitDbWithDeSoGenesisBlock: Error decoding seed "+
-					"txn HEX: %v, txn index: %v, txn hex: %v",
-				err, txnIndex, txnHex)
-		}
-		txn := &MsgDeSoTxn{}
-		if err := txn.FromBytes(txnBytes); err != nil {
-			return fmt.Errorf(
-				"InitDbWithDeSoGenesisBlock: Error decoding seed "+
-					"txn BYTES: %v, txn index: %v, txn hex: %v",
-				err, txnIndex, txnHex)
-		}
-		// Important: ignoreUtxos makes it so that the inputs/outputs aren't
-		// processed, which is important.
-		// Set txnSizeBytes to 0 here as the minimum network fee is 0 at genesis block, so there is no need to serialize
-		// these transactions to check if they meet the minimum network fee requirement.
-		var utxoOpsForTxn []*UtxoOperation
-		utxoOpsForTxn, _, _, _, err = utxoView.ConnectTransaction(
-			txn, txn.Hash(), 0, 0 /*blockHeight*/, false /*verifySignatures*/, true /*ignoreUtxos*/)
-		if err != nil {
-			return fmt.Errorf(
-				"InitDbWithDeSoGenesisBlock: Error connecting transaction: %v, "+
-					"txn index: %v, txn hex: %v",
-				err, txnIndex, txnHex)
-		}
-		utxoOpsForBlock = append(utxoOpsForBlock, utxoOpsForTxn)
-	}
-
-	// If we have an event manager, initialize the genesis block with the current
-	// state of the view.
-	if eventManager != nil {
-		eventManager.blockConnected(&BlockEvent{
-			Block:    genesisBlock,
-			UtxoView: utxoView,
-			UtxoOps:  utxoOpsForBlock,
-		})
-	}
-
-	// Flush all the data in the view.
-	err = utxoView.FlushToDb(0)
-	if err != nil {
-		return fmt.Errorf(
-			"InitDbWithDeSoGenesisBlock: Error flushing seed txns to DB: %v", err)
-	}
-
-	return nil
-}
-
 // GetBlockTipHeight fetches the current block tip height from the database.
 func GetBlockTipHeight(handle *badger.DB, bitcoinNodes bool) (uint64, error) {
 	var blockHeight uint64
@@ -3870,6 +6470,62 @@ func GetBlockIndex(handle *badger.DB, bitcoinNodes bool) (map[BlockHash]*BlockNo
 	return blockIndex, nil
 }
 
+// GetBestChainFromMainChainIndex reads the best chain ending at tipNode from the main chain index
+// instead of walking parent pointers. It returns false if the index doesn't match the block index,
+// e.g. because the db was created before the index existed, in which case GetBestChain should be
+// used and the index rebuilt with DbPutMainChain.
+func GetBestChainFromMainChainIndex(handle *badger.DB, tipNode *BlockNode,
+	blockIndex map[BlockHash]*BlockNode) (_bestChain []*BlockNode, _matches bool, _err error) {
+
+	blockHashes, err := DbGetMainChainHashes(handle)
+	if err != nil {
+		return nil, false, nil
+	}
+	if uint64(len(blockHashes)) != uint64(tipNode.Height)+1 {
+		return nil, false, nil
+	}
+
+	bestChain := make([]*BlockNode, 0, len(blockHashes))
+	for ii, blockHash := range blockHashes {
+		node, exists := blockIndex[*blockHash]
+		if !exists || uint64(node.Height) != uint64(ii) {
+			return nil, false, nil
+		}
+		if ii > 0 && node.Parent != bestChain[ii-1] {
+			return nil, false, nil
+		}
+		if (node.Status&StatusBlockValidated) == 0 &&
+			(node.Status&StatusBitcoinHeaderValidated) == 0 {
+
+			return nil, false, fmt.Errorf("GetBestChainFromMainChainIndex: Invalid node found in main chain: %+v", node)
+		}
+		bestChain = append(bestChain, node)
+	}
+	if bestChain[len(bestChain)-1] != tipNode {
+		return nil, false, nil
+	}
+	return bestChain, true, nil
+}
+
+// DbGetForkPointWithMainChain returns the highest ancestor of the node, or the node itself, that's
+// on the main chain, or nil if there's none. Checking main chain membership against the index means
+// that only the blocks between the node and the fork point are visited, however far the node is from
+// the tip.
+func DbGetForkPointWithMainChain(handle *badger.DB, node *BlockNode) *BlockNode {
+	var forkPoint *BlockNode
+	handle.View(func(txn *badger.Txn) error {
+		for ; node != nil; node = node.Parent {
+			mainChainHash := DbGetMainChainHashAtHeightWithTxn(txn, uint64(node.Height))
+			if mainChainHash != nil && mainChainHash.IsEqual(node.Hash) {
+				forkPoint = node
+				return nil
+			}
+		}
+		return nil
+	})
+	return forkPoint
+}
+
 func GetBestChain(tipNode *BlockNode, blockIndex map[BlockHash]*BlockNode) ([]*BlockNode, error) {
 	reversedBestChain := []*BlockNode{}
 	for tipNode != nil {
@@ -3987,16 +6643,22 @@ func DbTxindexPublicKeyIndexToTxnKey(publicKey []byte, index uint32) []byte {
 	return append(prefix, _EncodeUint32(index)...)
 }
 
+func _DbTxindexPublicKeyTxnToIndexKey(publicKey []byte, txID *BlockHash) []byte {
+	prefix := append(append([]byte{}, Prefixes.PrefixPublicKeyTransactionIDToIndex...), publicKey...)
+	return append(prefix, txID[:]...)
+}
+
 func DbGetTxindexTxnsForPublicKeyWithTxn(txn *badger.Txn, publicKey []byte) []*BlockHash {
 	txIDs := []*BlockHash{}
-	_, valsFound, err := _enumerateKeysForPrefixWithTxn(txn, DbTxindexPublicKeyPrefix(publicKey))
+	err := DBForEachKeyWithPrefixWithTxn(txn, DbTxindexPublicKeyPrefix(publicKey), nil,
+		func(_ []byte, txIDBytes []byte) (bool, error) {
+			blockHash := &BlockHash{}
+			copy(blockHash[:], txIDBytes[:])
+			txIDs = append(txIDs, blockHash)
+			return false, nil
+		})
 	if err != nil {
-		return txIDs
-	}
-	for _, txIDBytes := range valsFound {
-		blockHash := &BlockHash{}
-		copy(blockHash[:], txIDBytes[:])
-		txIDs = append(txIDs, blockHash)
+		return []*BlockHash{}
 	}
 
 	return txIDs
@@ -4095,63 +6757,196 @@ func DbDeleteTxindexNextIndexForPublicKeyWithTxn(txn *badger.Txn, snap *Snapshot
 func DbPutTxindexPublicKeyToTxnMappingSingleWithTxn(txn *badger.Txn, snap *Snapshot,
 	publicKey []byte, txID *BlockHash) error {
 
+	// If the txn is already indexed under the public key there's nothing to do. This happens when
+	// the node dies after the mappings of a block were written but before the block was attached
+	// to the txindex chain, in which case the block's mappings are written again when it resumes.
+	_, err := DBGetWithTxn(txn, snap, _DbTxindexPublicKeyTxnToIndexKey(publicKey, txID))
+	if err == nil {
+		return nil
+	}
+	if err != badger.ErrKeyNotFound {
+		return errors.Wrapf(err, "DbPutTxindexPublicKeyToTxnMappingSingleWithTxn: Problem getting index")
+	}
+
 	nextIndex := _DbGetTxindexNextIndexForPublicKeyWithTxn(txn, snap, publicKey)
 	if nextIndex == nil {
 		return fmt.Errorf("Error getting next index")
 	}
 	key := DbTxindexPublicKeyIndexToTxnKey(publicKey, uint32(*nextIndex))
-	err := DbPutTxindexNextIndexForPublicKeyWithTxn(txn, snap, publicKey, uint64(*nextIndex+1))
+	err = DbPutTxindexNextIndexForPublicKeyWithTxn(txn, snap, publicKey, uint64(*nextIndex+1))
 	if err != nil {
 		return err
 	}
+	if err := DBSetWithTxn(txn, snap, _DbTxindexPublicKeyTxnToIndexKey(publicKey, txID),
+		_EncodeUint32(uint32(*nextIndex))); err != nil {
+		return err
+	}
 	return DBSetWithTxn(txn, snap, key, txID[:])
 }
 
 func DbDeleteTxindexPublicKeyToTxnMappingSingleWithTxn(txn *badger.Txn,
 	snap *Snapshot, publicKey []byte, txID *BlockHash) error {
 
-	// Get all the mappings corresponding to the public key passed in.
-	// TODO: This is inefficient but reorgs are rare so whatever.
-	txIDsInDB := DbGetTxindexTxnsForPublicKeyWithTxn(txn, publicKey)
-	numMappingsInDB := len(txIDsInDB)
-
-	// Loop over the list of txIDs and delete the one
-	// corresponding to the passed-in transaction. Note we can assume that
-	// only one occurrence exists in the list.
-	// TODO: Looping backwards would be more efficient.
-	for ii, singleTxID := range txIDsInDB {
-		if *singleTxID == *txID {
-			// If we get here it means the transaction we need to delete is at
-			// this index.
-			txIDsInDB = append(txIDsInDB[:ii], txIDsInDB[ii+1:]...)
-			break
-		}
+	// Find the txn's index in the public key's txns. If there's no index then the txn isn't
+	// indexed under this public key and there's nothing to delete.
+	indexKey := _DbTxindexPublicKeyTxnToIndexKey(publicKey, txID)
+	indexBytes, err := DBGetWithTxn(txn, snap, indexKey)
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "DbDeleteTxindexPublicKeyToTxnMappingSingleWithTxn: Problem getting index")
+	}
+	if len(indexBytes) != 4 {
+		return fmt.Errorf("DbDeleteTxindexPublicKeyToTxnMappingSingleWithTxn: Invalid index %v for "+
+			"txn %v", indexBytes, txID)
 	}
+	index := DecodeUint32(indexBytes)
 
-	// Delete all the mappings from the db.
-	for pkIndex := 0; pkIndex < numMappingsInDB; pkIndex++ {
-		key := DbTxindexPublicKeyIndexToTxnKey(publicKey, uint32(pkIndex))
-		if err := DBDeleteWithTxn(txn, snap, key); err != nil {
-			return err
+	if err := DBDeleteWithTxn(txn, snap, DbTxindexPublicKeyIndexToTxnKey(publicKey, index)); err != nil {
+		return err
+	}
+	if err := DBDeleteWithTxn(txn, snap, indexKey); err != nil {
+		return err
+	}
+
+	// The other mappings keep their indexes, which leaves a gap. Reorgs delete the newest txns though,
+	// so when the txn was the last one the next index is moved back to keep the indexes dense.
+	nextIndex := _DbGetTxindexNextIndexForPublicKeyWithTxn(txn, snap, publicKey)
+	if nextIndex != nil && *nextIndex == uint64(index)+1 {
+		return DbPutTxindexNextIndexForPublicKeyWithTxn(txn, snap, publicKey, uint64(index))
+	}
+	return nil
+}
+
+// DbMigrateTxindexPublicKeyMappings writes the PrefixPublicKeyTransactionIDToIndex mappings of a txindex
+// that was built before they existed, then sets the migration marker so that it only runs once. It's
+// called by NewTXIndex, and returns the number of mappings it wrote. The mappings are written with a
+// write batch so the migration isn't bound by badger's txn size limits. If it's interrupted, it starts
+// over the next time, which is safe since rewriting a mapping doesn't change it.
+func DbMigrateTxindexPublicKeyMappings(txindexHandle *badger.DB) (_numMappings int, _err error) {
+	markerKey := append([]byte{}, Prefixes.PrefixPublicKeyTransactionIDMigrated...)
+	migrated := false
+	err := txindexHandle.View(func(txn *badger.Txn) error {
+		_, err := DBGetWithTxn(txn, nil, markerKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
 		}
+		migrated = err == nil
+		return err
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "DbMigrateTxindexPublicKeyMappings: Problem getting migration marker")
+	}
+	if migrated {
+		return 0, nil
+	}
+
+	numMappings := 0
+	writeBatch := txindexHandle.NewWriteBatch()
+	defer writeBatch.Cancel()
+	err = DBForEachKeyWithPrefix(txindexHandle, Prefixes.PrefixPublicKeyIndexToTransactionIDs, nil,
+		func(key []byte, value []byte) (bool, error) {
+			// The key is <prefix_id, publicKey, index uint32> and the value is the txid.
+			if len(key) < 1+4 || len(value) != HashSizeBytes {
+				return false, fmt.Errorf("Invalid mapping with key %v and value %v", key, value)
+			}
+			publicKey := key[1 : len(key)-4]
+			index := append([]byte{}, key[len(key)-4:]...)
+			numMappings++
+			return false, writeBatch.Set(_DbTxindexPublicKeyTxnToIndexKey(publicKey, NewBlockHash(value)), index)
+		})
+	if err != nil {
+		return 0, errors.Wrapf(err, "DbMigrateTxindexPublicKeyMappings: Problem writing mappings")
+	}
+	if err := writeBatch.Flush(); err != nil {
+		return 0, errors.Wrapf(err, "DbMigrateTxindexPublicKeyMappings: Problem flushing mappings")
 	}
 
-	// Delete the next index for this public key
-	err := DbDeleteTxindexNextIndexForPublicKeyWithTxn(txn, snap, publicKey)
+	err = txindexHandle.Update(func(txn *badger.Txn) error {
+		return DBSetWithTxn(txn, nil, markerKey, []byte{})
+	})
 	if err != nil {
+		return 0, errors.Wrapf(err, "DbMigrateTxindexPublicKeyMappings: Problem setting migration marker")
+	}
+	glog.Infof("DbMigrateTxindexPublicKeyMappings: Wrote %v txindex public key mappings", numMappings)
+	return numMappings, nil
+}
+
+// DbMigrateTxindexResolvedInputs sets the TxnInputs of the TransactionMetadata in a txindex that was
+// built before they existed, then sets the migration marker so that it only runs once. It replays the
+// blocks of the txindex's best chain, resolving the inputs of each txn from the UtxoOperations the
+// txindex stored when it connected the block, or from the ones in the txn's metadata if the block's
+// are missing. Each block is rewritten in its own txn, and if the migration is interrupted it starts
+// over the next time, which is safe since resolving the inputs again doesn't change them. It's
+// called by NewTXIndex, and returns the number of txns it updated.
+func DbMigrateTxindexResolvedInputs(txindexHandle *badger.DB, bestChain []*BlockNode,
+	params *DeSoParams) (_numTxns int, _err error) {
+
+	markerKey := append([]byte{}, Prefixes.PrefixTxindexResolvedInputsMigrated...)
+	migrated := false
+	err := txindexHandle.View(func(txn *badger.Txn) error {
+		_, err := DBGetWithTxn(txn, nil, markerKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		migrated = err == nil
 		return err
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "DbMigrateTxindexResolvedInputs: Problem getting migration marker")
+	}
+	if migrated {
+		return 0, nil
 	}
 
-	// Re-add all the mappings to the db except the one we just deleted.
-	for _, singleTxID := range txIDsInDB {
-		if err := DbPutTxindexPublicKeyToTxnMappingSingleWithTxn(txn, snap, publicKey, singleTxID); err != nil {
-			return err
+	numTxns := 0
+	blockHeight := uint64(0)
+	if len(bestChain) > 0 {
+		blockHeight = uint64(bestChain[len(bestChain)-1].Height)
+	}
+	for _, blockNode := range bestChain {
+		blockMsg, err := GetBlock(blockNode.Hash, txindexHandle, nil)
+		if err != nil {
+			return 0, errors.Wrapf(err, "DbMigrateTxindexResolvedInputs: Problem getting block %v", blockNode.Hash)
+		}
+		// The genesis block doesn't have UtxoOperations, so a missing bundle isn't an error.
+		utxoOpsForBlock, _ := GetUtxoOperationsForBlock(txindexHandle, nil, blockNode.Hash)
+		err = txindexHandle.Update(func(txn *badger.Txn) error {
+			for ii, desoTxn := range blockMsg.Txns {
+				txID := desoTxn.Hash()
+				txnMeta := DbGetTxindexTransactionRefByTxIDWithTxn(txn, nil, txID)
+				if txnMeta == nil {
+					continue
+				}
+				var utxoOps []*UtxoOperation
+				if len(utxoOpsForBlock) == len(blockMsg.Txns) {
+					utxoOps = utxoOpsForBlock[ii]
+				} else if txnMeta.BasicTransferTxindexMetadata != nil {
+					utxoOps = txnMeta.BasicTransferTxindexMetadata.UtxoOps
+				}
+				txnMeta.TxnInputs = ComputeResolvedInputsTxindexMetadata(utxoOps, params)
+				if err := DbPutTxindexTransactionWithTxn(txn, nil, blockHeight, txID, txnMeta); err != nil {
+					return err
+				}
+				numTxns++
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, errors.Wrapf(err, "DbMigrateTxindexResolvedInputs: Problem updating the txns "+
+				"of block %v", blockNode.Hash)
 		}
 	}
 
-	// At this point the db should contain all transactions except the one
-	// that was deleted.
-	return nil
+	err = txindexHandle.Update(func(txn *badger.Txn) error {
+		return DBSetWithTxn(txn, nil, markerKey, []byte{})
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "DbMigrateTxindexResolvedInputs: Problem setting migration marker")
+	}
+	glog.Infof("DbMigrateTxindexResolvedInputs: Resolved the inputs of %v txindex txns", numTxns)
+	return numTxns, nil
 }
 
 func DbTxindexTxIDKey(txID *BlockHash) []byte {
@@ -4197,6 +6992,73 @@ func (pk *AffectedPublicKey) GetEncoderType() EncoderType {
 	return EncoderTypeAffectedPublicKey
 }
 
+// ResolvedInputTxindexMetadata is a utxo spent by a transaction, along with the public key that
+// owned it and its amount.
+type ResolvedInputTxindexMetadata struct {
+	PublicKeyBase58Check string
+	AmountNanos          uint64
+	UtxoKey              *UtxoKey
+}
+
+func (input *ResolvedInputTxindexMetadata) RawEncodeWithoutMetadata(blockHeight uint64, skipMetadata ...bool) []byte {
+	var data []byte
+
+	data = append(data, EncodeByteArray([]byte(input.PublicKeyBase58Check))...)
+	data = append(data, UintToBuf(input.AmountNanos)...)
+	data = append(data, EncodeToBytes(blockHeight, input.UtxoKey, skipMetadata...)...)
+	return data
+}
+
+func (input *ResolvedInputTxindexMetadata) RawDecodeWithoutMetadata(blockHeight uint64, rr *bytes.Reader) error {
+	var err error
+
+	publicKeyBase58CheckBytes, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "ResolvedInputTxindexMetadata.Decode: problem reading PublicKeyBase58Check")
+	}
+	input.PublicKeyBase58Check = string(publicKeyBase58CheckBytes)
+
+	input.AmountNanos, err = ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "ResolvedInputTxindexMetadata.Decode: problem reading AmountNanos")
+	}
+
+	utxoKey := &UtxoKey{}
+	if exists, err := DecodeFromBytes(utxoKey, rr); exists && err == nil {
+		input.UtxoKey = utxoKey
+	} else if err != nil {
+		return errors.Wrapf(err, "ResolvedInputTxindexMetadata.Decode: problem reading UtxoKey")
+	}
+	return nil
+}
+
+func (input *ResolvedInputTxindexMetadata) GetVersionByte(blockHeight uint64) byte {
+	return 0
+}
+
+func (input *ResolvedInputTxindexMetadata) GetEncoderType() EncoderType {
+	return EncoderTypeResolvedInputTxindexMetadata
+}
+
+// ComputeResolvedInputsTxindexMetadata returns the utxos spent by the UtxoOperations of a
+// transaction, in the order they were spent. This includes the utxos a transaction spends
+// on behalf of someone other than the transactor, like the bidder inputs of an AcceptNFTBid.
+func ComputeResolvedInputsTxindexMetadata(utxoOps []*UtxoOperation, params *DeSoParams) []*ResolvedInputTxindexMetadata {
+	var inputs []*ResolvedInputTxindexMetadata
+	for _, utxoOp := range utxoOps {
+		if utxoOp.Type != OperationTypeSpendUtxo || utxoOp.Entry == nil || utxoOp.Key == nil {
+			continue
+		}
+		utxoKey := *utxoOp.Key
+		inputs = append(inputs, &ResolvedInputTxindexMetadata{
+			PublicKeyBase58Check: PkToString(utxoOp.Entry.PublicKey, params),
+			AmountNanos:          utxoOp.Entry.AmountNanos,
+			UtxoKey:              &utxoKey,
+		})
+	}
+	return inputs
+}
+
 type BasicTransferTxindexMetadata struct {
 	TotalInputNanos  uint64
 	TotalOutputNanos uint64
@@ -4580,6 +7442,7 @@ type FilledDAOCoinLimitOrderMetadata struct {
 	CoinQuantityInBaseUnitsBought  *uint256.Int
 	CoinQuantityInBaseUnitsSold    *uint256.Int
 	IsFulfilled                    bool
+	FeeInBaseUnitsPaid             *uint256.Int
 }
 
 func (orderMeta *FilledDAOCoinLimitOrderMetadata) RawEncodeWithoutMetadata(blockHeight uint64, skipMetadata ...bool) []byte {
@@ -4591,6 +7454,9 @@ func (orderMeta *FilledDAOCoinLimitOrderMetadata) RawEncodeWithoutMetadata(block
 	data = append(data, EncodeUint256(orderMeta.CoinQuantityInBaseUnitsBought)...)
 	data = append(data, EncodeUint256(orderMeta.CoinQuantityInBaseUnitsSold)...)
 	data = append(data, BoolToByte(orderMeta.IsFulfilled))
+	if MigrationTriggered(blockHeight, DAOCoinLimitOrderMakerTakerFeesMigration) {
+		data = append(data, EncodeOptionalUint256(orderMeta.FeeInBaseUnitsPaid)...)
+	}
 
 	return data
 }
@@ -4634,11 +7500,18 @@ func (orderMeta *FilledDAOCoinLimitOrderMetadata) RawDecodeWithoutMetadata(block
 	if err != nil {
 		return errors.Wrapf(err, "FilledDAOCoinLimitOrderMetadata.Decode: Problem reading IsFulfilled")
 	}
+
+	if MigrationTriggered(blockHeight, DAOCoinLimitOrderMakerTakerFeesMigration) {
+		orderMeta.FeeInBaseUnitsPaid, err = ReadOptionalUint256(rr)
+		if err != nil {
+			return errors.Wrapf(err, "FilledDAOCoinLimitOrderMetadata.Decode: Problem reading FeeInBaseUnitsPaid")
+		}
+	}
 	return nil
 }
 
 func (orderMeta *FilledDAOCoinLimitOrderMetadata) GetVersionByte(blockHeight uint64) byte {
-	return byte(0)
+	return GetMigrationVersion(blockHeight, DAOCoinLimitOrderMakerTakerFeesMigration)
 }
 
 func (orderMeta *FilledDAOCoinLimitOrderMetadata) GetEncoderType() EncoderType {
@@ -5379,6 +8252,11 @@ type TransactionMetadata struct {
 	// We store these outputs so we don't have to load the full transaction from disk
 	// when looking up output amounts
 	TxnOutputs []*DeSoOutput
+	// The utxos the transaction spent, so that the public key and amount behind each
+	// input can be shown without looking up the transaction that created the utxo.
+	// Metadata written before these existed doesn't have them until the txindex is
+	// migrated, see DbMigrateTxindexResolvedInputs.
+	TxnInputs []*ResolvedInputTxindexMetadata
 
 	BasicTransferTxindexMetadata       *BasicTransferTxindexMetadata       `json:",omitempty"`
 	BitcoinExchangeTxindexMetadata     *BitcoinExchangeTxindexMetadata     `json:",omitempty"`
@@ -5460,6 +8338,12 @@ func (txnMeta *TransactionMetadata) RawEncodeWithoutMetadata(blockHeight uint64,
 	data = append(data, EncodeToBytes(blockHeight, txnMeta.UpdateNFTTxindexMetadata, skipMetadata...)...)
 	// encoding DAOCoinLimitOrderTxindexMetadata
 	data = append(data, EncodeToBytes(blockHeight, txnMeta.DAOCoinLimitOrderTxindexMetadata, skipMetadata...)...)
+
+	// TxnInputs are encoded last so that metadata written before they existed can still be decoded.
+	data = append(data, UintToBuf(uint64(len(txnMeta.TxnInputs)))...)
+	for _, input := range txnMeta.TxnInputs {
+		data = append(data, EncodeToBytes(blockHeight, input, skipMetadata...)...)
+	}
 	return data
 }
 
@@ -5653,6 +8537,21 @@ func (txnMeta *TransactionMetadata) RawDecodeWithoutMetadata(blockHeight uint64,
 	} else if err != nil {
 		return errors.Wrapf(err, "TransactionMetadata.Decode: Problem reading DAOCoinLimitOrderTxindexMetadata")
 	}
+
+	// decoding TxnInputs, which are only present in metadata written after they were added.
+	if rr.Len() > 0 {
+		lenTxnInputs, err := ReadUvarint(rr)
+		if err != nil {
+			return errors.Wrapf(err, "TransactionMetadata.Decode: problem reading len TxnInputs")
+		}
+		for ; lenTxnInputs > 0; lenTxnInputs-- {
+			txnInput := &ResolvedInputTxindexMetadata{}
+			if exists, err := DecodeFromBytes(txnInput, rr); !exists || err != nil {
+				return errors.Wrapf(err, "TransactionMetadata.Decode: problem reading TxnInput")
+			}
+			txnMeta.TxnInputs = append(txnMeta.TxnInputs, txnInput)
+		}
+	}
 	return nil
 }
 
@@ -5690,8 +8589,7 @@ func DbGetTxindexTransactionRefByTxIDWithTxn(txn *badger.Txn, snap *Snapshot, tx
 	if err != nil {
 		return nil
 	}
-	rr := bytes.NewReader(valBytes)
-	if exists, err := DecodeFromBytes(valObj, rr); !exists || err != nil {
+	if exists, _, err := DecodeFromBytesCompat(valObj, valBytes); !exists || err != nil {
 		return nil
 	}
 	return valObj
@@ -5705,6 +8603,164 @@ func DbGetTxindexTransactionRefByTxID(handle *badger.DB, snap *Snapshot, txID *B
 	})
 	return valObj
 }
+
+// AccountDeltaTxn describes how a single transaction changed a public key's
+// DeSo balance.
+type AccountDeltaTxn struct {
+	TxID               *BlockHash
+	BlockHeight        uint64
+	BalanceChangeNanos int64
+}
+
+// AccountDelta is everything a watch-only wallet needs in order to bring its
+// view of a public key up to date from a given block height, without having to
+// refetch the key's full history.
+type AccountDelta struct {
+	PublicKey   []byte
+	SinceHeight uint64
+
+	// The current DeSo balance of the public key.
+	BalanceNanos uint64
+	// The sum of all the BalanceChangeNanos in Txns.
+	NetBalanceChangeNanos int64
+
+	// UTXOs that were created for the public key after SinceHeight and that are
+	// still unspent, and UTXOs that existed at SinceHeight and have since been
+	// spent. A UTXO that was both created and spent after SinceHeight is omitted
+	// from both lists since the wallet never needs to know about it. UtxoKey is
+	// set on every entry.
+	NewUtxos   []*UtxoEntry
+	SpentUtxos []*UtxoEntry
+
+	// All transactions that touched the public key after SinceHeight, ordered
+	// the same way they were indexed.
+	Txns []*AccountDeltaTxn
+}
+
+// DbGetAccountDeltaWithTxn computes the AccountDelta for a public key from the
+// txindex. Only transactions mined in blocks strictly above sinceHeight are
+// considered. UTXO changes are derived from the utxo operations stored alongside
+// each transaction's metadata, which cover implicit outputs as well as explicit
+// ones. The handle passed in must be the txindex db.
+func DbGetAccountDeltaWithTxn(txn *badger.Txn, snap *Snapshot, publicKey []byte,
+	sinceHeight uint64) (*AccountDelta, error) {
+
+	balanceNanos, err := DbGetDeSoBalanceNanosForPublicKeyWithTxn(txn, snap, publicKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetAccountDeltaWithTxn: Problem getting balance")
+	}
+	delta := &AccountDelta{
+		PublicKey:    publicKey,
+		SinceHeight:  sinceHeight,
+		BalanceNanos: balanceNanos,
+	}
+
+	// Txns are appended to the public key's index as blocks are connected so we
+	// walk it backwards and stop as soon as we fall below sinceHeight.
+	blockHeights := make(map[string]uint64)
+	newUtxos := make(map[UtxoKey]*UtxoEntry)
+	spentUtxos := make(map[UtxoKey]*UtxoEntry)
+	var utxoKeysOrdered []UtxoKey
+	err = DBForEachKeyWithPrefixWithTxn(txn, DbTxindexPublicKeyPrefix(publicKey),
+		&DBIteratorOptions{Reverse: true}, func(_ []byte, txIDBytes []byte) (bool, error) {
+			txID := NewBlockHash(txIDBytes)
+			txnMeta := DbGetTxindexTransactionRefByTxIDWithTxn(txn, snap, txID)
+			if txnMeta == nil {
+				return false, fmt.Errorf("DbGetAccountDeltaWithTxn: Missing txindex "+
+					"metadata for txn %v", txID)
+			}
+			blockHeight, exists := blockHeights[txnMeta.BlockHashHex]
+			if !exists {
+				blockHashBytes, err := hex.DecodeString(txnMeta.BlockHashHex)
+				if err != nil || len(blockHashBytes) != HashSizeBytes {
+					return false, fmt.Errorf("DbGetAccountDeltaWithTxn: Invalid block "+
+						"hash %v for txn %v", txnMeta.BlockHashHex, txID)
+				}
+				block := GetBlockWithTxn(txn, snap, NewBlockHash(blockHashBytes))
+				if block == nil {
+					return false, fmt.Errorf("DbGetAccountDeltaWithTxn: Missing block "+
+						"%v for txn %v", txnMeta.BlockHashHex, txID)
+				}
+				blockHeight = block.Header.Height
+				blockHeights[txnMeta.BlockHashHex] = blockHeight
+			}
+			if blockHeight <= sinceHeight {
+				return true, nil
+			}
+
+			deltaTxn := &AccountDeltaTxn{
+				TxID:        txID,
+				BlockHeight: blockHeight,
+			}
+			if txnMeta.BasicTransferTxindexMetadata != nil {
+				for _, utxoOp := range txnMeta.BasicTransferTxindexMetadata.UtxoOps {
+					if utxoOp.Entry == nil || utxoOp.Key == nil ||
+						!bytes.Equal(utxoOp.Entry.PublicKey, publicKey) {
+						continue
+					}
+					utxoEntry := *utxoOp.Entry
+					utxoEntry.UtxoKey = utxoOp.Key
+					switch utxoOp.Type {
+					case OperationTypeAddUtxo:
+						deltaTxn.BalanceChangeNanos += int64(utxoEntry.AmountNanos)
+						newUtxos[*utxoOp.Key] = &utxoEntry
+					case OperationTypeSpendUtxo:
+						deltaTxn.BalanceChangeNanos -= int64(utxoEntry.AmountNanos)
+						spentUtxos[*utxoOp.Key] = &utxoEntry
+					default:
+						continue
+					}
+					utxoKeysOrdered = append(utxoKeysOrdered, *utxoOp.Key)
+				}
+			}
+			delta.NetBalanceChangeNanos += deltaTxn.BalanceChangeNanos
+			delta.Txns = append(delta.Txns, deltaTxn)
+			return false, nil
+		})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetAccountDeltaWithTxn: Problem walking txindex")
+	}
+
+	// We collected everything newest-first so flip it back around.
+	for ii, jj := 0, len(delta.Txns)-1; ii < jj; ii, jj = ii+1, jj-1 {
+		delta.Txns[ii], delta.Txns[jj] = delta.Txns[jj], delta.Txns[ii]
+	}
+	for ii := len(utxoKeysOrdered) - 1; ii >= 0; ii-- {
+		utxoKey := utxoKeysOrdered[ii]
+		newUtxo, isNew := newUtxos[utxoKey]
+		spentUtxo, isSpent := spentUtxos[utxoKey]
+		if isNew && isSpent {
+			continue
+		}
+		// Each key can show up twice in utxoKeysOrdered so drop it from the map
+		// once we've seen it.
+		if isNew {
+			delta.NewUtxos = append(delta.NewUtxos, newUtxo)
+			delete(newUtxos, utxoKey)
+		} else if isSpent {
+			delta.SpentUtxos = append(delta.SpentUtxos, spentUtxo)
+			delete(spentUtxos, utxoKey)
+		}
+	}
+
+	return delta, nil
+}
+
+func DbGetAccountDelta(handle *badger.DB, snap *Snapshot, publicKey []byte,
+	sinceHeight uint64) (*AccountDelta, error) {
+
+	var delta *AccountDelta
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		delta, err = DbGetAccountDeltaWithTxn(txn, snap, publicKey, sinceHeight)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return delta, nil
+}
+
 func DbPutTxindexTransactionWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
 	txID *BlockHash, txnMeta *TransactionMetadata) error {
 
@@ -5915,6 +8971,12 @@ func _dbKeyForStakeMultipleBpsPostHash(stakeMultipleBps uint64, postHash *BlockH
 	key = append(key, postHash[:]...)
 	return key
 }
+func _dbKeyForLikeCountPostHash(likeCount uint64, postHash *BlockHash) []byte {
+	key := append([]byte{}, Prefixes.PrefixLikeCountPostHash...)
+	key = append(key, EncodeUint64(likeCount)...)
+	key = append(key, postHash[:]...)
+	return key
+}
 func _dbKeyForCommentParentStakeIDToPostHash(
 	stakeID []byte, tstampNanos uint64, postHash *BlockHash) []byte {
 	key := append([]byte{}, Prefixes.PrefixCommentParentStakeIDToPostHash...)
@@ -5948,14 +9010,48 @@ func DBGetPostEntryByPostHash(db *badger.DB, snap *Snapshot, postHash *BlockHash
 	return ret
 }
 
-func DBDeletePostEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
-	postHash *BlockHash, params *DeSoParams) error {
+// DBGetPostEntriesForPostHashes fetches the posts for a batch of post hashes. A post that can't be
+// decoded is skipped and reported with RecordCorruptedEntry, so that one corrupted entry doesn't
+// fail the whole batch. It returns the positions in postHashes of the posts it returned, so that
+// callers can drop the skipped posts from the slices they return alongside the entries.
+func DBGetPostEntriesForPostHashes(handle *badger.DB, snap *Snapshot, postHashes []*BlockHash) (
+	_postEntries []*PostEntry, _indexes []int, _err error) {
 
-	// First pull up the mapping that exists for the post hash passed in.
-	// If one doesn't exist then there's nothing to do.
-	postEntry := DBGetPostEntryByPostHashWithTxn(txn, snap, postHash)
-	if postEntry == nil {
-		return nil
+	postEntries := []*PostEntry{}
+	indexes := []int{}
+	for ii, postHash := range postHashes {
+		key := _dbKeyForPostEntryHash(postHash)
+		var postEntryBytes []byte
+		err := handle.View(func(txn *badger.Txn) error {
+			var err error
+			postEntryBytes, err = DBGetWithTxn(txn, snap, key)
+			return err
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("DBGetPostEntriesForPostHashes: "+
+				"PostHash %v does not have corresponding entry", postHash)
+		}
+
+		postEntry := &PostEntry{}
+		if exists, err := DecodeFromBytes(postEntry, bytes.NewReader(postEntryBytes)); !exists || err != nil {
+			RecordCorruptedEntry(handle, key, fmt.Errorf("DBGetPostEntriesForPostHashes: Problem "+
+				"decoding post %v: exists %v, error %v", postHash, exists, err))
+			continue
+		}
+		postEntries = append(postEntries, postEntry)
+		indexes = append(indexes, ii)
+	}
+	return postEntries, indexes, nil
+}
+
+func DBDeletePostEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
+	postHash *BlockHash, params *DeSoParams) error {
+
+	// First pull up the mapping that exists for the post hash passed in.
+	// If one doesn't exist then there's nothing to do.
+	postEntry := DBGetPostEntryByPostHashWithTxn(txn, snap, postHash)
+	if postEntry == nil {
+		return nil
 	}
 
 	// When a post exists, delete the mapping for the post.
@@ -5963,6 +9059,10 @@ func DBDeletePostEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 		return errors.Wrapf(err, "DbDeletePostEntryMappingsWithTxn: Deleting "+
 			"post mapping for post hash %v", postHash)
 	}
+	if err := _dbAddPostToPostCountsWithTxn(txn, snap, postEntry, false /*isAdd*/); err != nil {
+		return errors.Wrapf(err, "DbDeletePostEntryMappingsWithTxn: Problem removing post %v "+
+			"from post counts", postHash)
+	}
 
 	// If the post is a comment we store it in a separate index. Comments are
 	// technically posts but they really should be treated as their own entity.
@@ -6007,6 +9107,12 @@ func DBDeletePostEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 			return errors.Wrapf(err, "DbDeletePostEntryMappingsWithTxn: Deleting "+
 				"stakeMultiple mapping for post hash %v: %v", postHash, err)
 		}
+		if err := DBDeleteWithTxn(txn, nil, _dbKeyForLikeCountPostHash(
+			postEntry.LikeCount, postEntry.PostHash)); err != nil {
+
+			return errors.Wrapf(err, "DbDeletePostEntryMappingsWithTxn: Deleting "+
+				"likeCount mapping for post hash %v: %v", postHash, err)
+		}
 	}
 
 	// Delete the repost entries for the post.
@@ -6051,6 +9157,10 @@ func DBPutPostEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight
 		return errors.Wrapf(err, "DbPutPostEntryMappingsWithTxn: Problem "+
 			"adding mapping for post: %v", postEntry.PostHash)
 	}
+	if err := _dbAddPostToPostCountsWithTxn(txn, snap, postEntry, true /*isAdd*/); err != nil {
+		return errors.Wrapf(err, "DbPutPostEntryMappingsWithTxn: Problem adding post %v "+
+			"to post counts", postEntry.PostHash)
+	}
 
 	// If the post is a comment we store it in a separate index. Comments are
 	// technically posts but they really should be treated as their own entity.
@@ -6103,6 +9213,12 @@ func DBPutPostEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight
 			return errors.Wrapf(err, "DbPutPostEntryMappingsWithTxn: Problem "+
 				"adding mapping for stakeMultipleBps: %v", postEntry)
 		}
+		if err := DBSetWithTxn(txn, nil, _dbKeyForLikeCountPostHash(
+			postEntry.LikeCount, postEntry.PostHash), []byte{}); err != nil {
+
+			return errors.Wrapf(err, "DbPutPostEntryMappingsWithTxn: Problem "+
+				"adding mapping for likeCount: %v", postEntry)
+		}
 	}
 	// We treat reposting the same for both comments and posts.
 	// We only store repost entry mappings for vanilla reposts
@@ -6142,6 +9258,347 @@ func DBPutPostEntryMappings(handle *badger.DB, snap *Snapshot, blockHeight uint6
 	})
 }
 
+// -------------------------------------------------------------------------------------
+// Post count mapping functions
+// <prefix_id, PosterPublicKey [33]byte> -> <NumPosts uint64, NumComments uint64>
+// <prefix_id, PosterPublicKey [33]byte, BlockHeight uint64> -> <NumPosts uint64>
+// -------------------------------------------------------------------------------------
+
+// PostCount is the number of posts and comments a public key has made. Reposts and quote reposts
+// count as posts or comments like any other post.
+type PostCount struct {
+	NumPosts    uint64
+	NumComments uint64
+}
+
+func _dbKeyForPosterPublicKeyToPostCount(posterPublicKey []byte) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixPosterPublicKeyToPostCount...)
+	return append(prefixCopy, posterPublicKey...)
+}
+
+func _dbKeyForPosterPublicKeyBlockHeightToNumPosts(posterPublicKey []byte, blockHeight uint64) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixPosterPublicKeyBlockHeightToNumPosts...)
+	key := append(prefixCopy, posterPublicKey...)
+	return append(key, EncodeUint64(blockHeight)...)
+}
+
+func DbGetPostCountForPublicKeyWithTxn(txn *badger.Txn, snap *Snapshot, posterPublicKey []byte) (*PostCount, error) {
+	postCountBytes, err := DBGetWithTxn(txn, snap, _dbKeyForPosterPublicKeyToPostCount(posterPublicKey))
+	// Public keys that haven't posted don't have a post count.
+	if err == badger.ErrKeyNotFound {
+		return &PostCount{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetPostCountForPublicKeyWithTxn: Problem getting post count for %v",
+			PkToStringBoth(posterPublicKey))
+	}
+	if len(postCountBytes) != 16 {
+		return nil, fmt.Errorf("DbGetPostCountForPublicKeyWithTxn: Post count for %v has %v bytes, expected 16",
+			PkToStringBoth(posterPublicKey), len(postCountBytes))
+	}
+	return &PostCount{
+		NumPosts:    DecodeUint64(postCountBytes[:8]),
+		NumComments: DecodeUint64(postCountBytes[8:]),
+	}, nil
+}
+
+// DbGetPostCountForPublicKey returns the number of posts and comments the public key has in the db.
+// Unlike DBGetAllPostsAndCommentsForPublicKeyOrderedByTimestamp, it's a single lookup no matter
+// how many posts the public key has made.
+func DbGetPostCountForPublicKey(handle *badger.DB, snap *Snapshot, posterPublicKey []byte) (*PostCount, error) {
+	var postCount *PostCount
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		postCount, err = DbGetPostCountForPublicKeyWithTxn(txn, snap, posterPublicKey)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return postCount, nil
+}
+
+func DbPutPostCountForPublicKeyWithTxn(txn *badger.Txn, snap *Snapshot, posterPublicKey []byte,
+	postCount *PostCount) error {
+
+	if len(posterPublicKey) != btcec.PubKeyBytesLenCompressed {
+		return fmt.Errorf("DbPutPostCountForPublicKeyWithTxn: Public key has improper length %d != %d",
+			len(posterPublicKey), btcec.PubKeyBytesLenCompressed)
+	}
+
+	// Public keys without posts don't need a post count.
+	if postCount.NumPosts == 0 && postCount.NumComments == 0 {
+		return DBDeleteWithTxn(txn, snap, _dbKeyForPosterPublicKeyToPostCount(posterPublicKey))
+	}
+	postCountBytes := append(EncodeUint64(postCount.NumPosts), EncodeUint64(postCount.NumComments)...)
+	return DBSetWithTxn(txn, snap, _dbKeyForPosterPublicKeyToPostCount(posterPublicKey), postCountBytes)
+}
+
+func _dbGetNumPostsForPublicKeyAtBlockHeightWithTxn(txn *badger.Txn, snap *Snapshot, posterPublicKey []byte,
+	blockHeight uint64) (uint64, error) {
+
+	numPostsBytes, err := DBGetWithTxn(txn, snap, _dbKeyForPosterPublicKeyBlockHeightToNumPosts(
+		posterPublicKey, blockHeight))
+	if err == badger.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "_dbGetNumPostsForPublicKeyAtBlockHeightWithTxn: Problem getting "+
+			"number of posts for %v at height %v", PkToStringBoth(posterPublicKey), blockHeight)
+	}
+	if len(numPostsBytes) != 8 {
+		return 0, fmt.Errorf("_dbGetNumPostsForPublicKeyAtBlockHeightWithTxn: Number of posts for %v "+
+			"at height %v has %v bytes, expected 8", PkToStringBoth(posterPublicKey), blockHeight, len(numPostsBytes))
+	}
+	return DecodeUint64(numPostsBytes), nil
+}
+
+func _dbPutNumPostsForPublicKeyAtBlockHeightWithTxn(txn *badger.Txn, snap *Snapshot, posterPublicKey []byte,
+	blockHeight uint64, numPosts uint64) error {
+
+	key := _dbKeyForPosterPublicKeyBlockHeightToNumPosts(posterPublicKey, blockHeight)
+	if numPosts == 0 {
+		return DBDeleteWithTxn(txn, snap, key)
+	}
+	return DBSetWithTxn(txn, snap, key, EncodeUint64(numPosts))
+}
+
+// DbGetNumPostsForPublicKeyInBlockRangeWithTxn returns the number of posts and comments the public key
+// made in the blocks from startHeight to endHeight, inclusive.
+func DbGetNumPostsForPublicKeyInBlockRangeWithTxn(txn *badger.Txn, snap *Snapshot, posterPublicKey []byte,
+	startHeight uint64, endHeight uint64) (uint64, error) {
+
+	if startHeight > endHeight {
+		return 0, nil
+	}
+	startKey := _dbKeyForPosterPublicKeyBlockHeightToNumPosts(posterPublicKey, startHeight)
+	validForPrefix := append(append([]byte{}, Prefixes.PrefixPosterPublicKeyBlockHeightToNumPosts...),
+		posterPublicKey...)
+	keyLen := len(startKey)
+
+	numPosts := uint64(0)
+	opts := badger.DefaultIteratorOptions
+	nodeIterator := txn.NewIterator(opts)
+	defer nodeIterator.Close()
+	for nodeIterator.Seek(startKey); nodeIterator.ValidForPrefix(validForPrefix); nodeIterator.Next() {
+		key := nodeIterator.Item().Key()
+		if len(key) != keyLen {
+			continue
+		}
+		if DecodeUint64(key[keyLen-8:]) > endHeight {
+			break
+		}
+		numPostsBytes, err := nodeIterator.Item().ValueCopy(nil)
+		if err != nil {
+			return 0, errors.Wrapf(err, "DbGetNumPostsForPublicKeyInBlockRangeWithTxn: ")
+		}
+		if len(numPostsBytes) != 8 {
+			return 0, fmt.Errorf("DbGetNumPostsForPublicKeyInBlockRangeWithTxn: Number of posts for "+
+				"key %v has %v bytes, expected 8", key, len(numPostsBytes))
+		}
+		numPosts += DecodeUint64(numPostsBytes)
+	}
+	return numPosts, nil
+}
+
+// DbGetNumPostsForPublicKeyInBlockRange returns the number of posts and comments the public key made
+// in the blocks from startHeight to endHeight, inclusive. The mempool uses it to rate limit posts.
+func DbGetNumPostsForPublicKeyInBlockRange(handle *badger.DB, snap *Snapshot, posterPublicKey []byte,
+	startHeight uint64, endHeight uint64) (uint64, error) {
+
+	var numPosts uint64
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		numPosts, err = DbGetNumPostsForPublicKeyInBlockRangeWithTxn(
+			txn, snap, posterPublicKey, startHeight, endHeight)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return numPosts, nil
+}
+
+// _dbAddPostToPostCountsWithTxn adds or removes a post from its poster's post count and from the
+// number of posts its poster made at the post's confirmation height.
+func _dbAddPostToPostCountsWithTxn(txn *badger.Txn, snap *Snapshot, postEntry *PostEntry, isAdd bool) error {
+	isComment := len(postEntry.ParentStakeID) != 0
+
+	postCount, err := DbGetPostCountForPublicKeyWithTxn(txn, snap, postEntry.PosterPublicKey)
+	if err != nil {
+		return err
+	}
+	count := &postCount.NumPosts
+	if isComment {
+		count = &postCount.NumComments
+	}
+	if isAdd {
+		*count++
+	} else if *count == 0 {
+		// This can only happen if the post counts weren't backfilled. It's not worth failing the
+		// flush over, since the post counts aren't part of the state.
+		glog.Errorf("_dbAddPostToPostCountsWithTxn: Post count %+v for %v is missing post %v",
+			postCount, PkToStringBoth(postEntry.PosterPublicKey), postEntry.PostHash)
+	} else {
+		*count--
+	}
+	if err = DbPutPostCountForPublicKeyWithTxn(txn, snap, postEntry.PosterPublicKey, postCount); err != nil {
+		return err
+	}
+
+	blockHeight := uint64(postEntry.ConfirmationBlockHeight)
+	numPosts, err := _dbGetNumPostsForPublicKeyAtBlockHeightWithTxn(
+		txn, snap, postEntry.PosterPublicKey, blockHeight)
+	if err != nil {
+		return err
+	}
+	if isAdd {
+		numPosts++
+	} else if numPosts == 0 {
+		glog.Errorf("_dbAddPostToPostCountsWithTxn: Number of posts for %v at height %v is missing post %v",
+			PkToStringBoth(postEntry.PosterPublicKey), blockHeight, postEntry.PostHash)
+	} else {
+		numPosts--
+	}
+	return _dbPutNumPostsForPublicKeyAtBlockHeightWithTxn(
+		txn, snap, postEntry.PosterPublicKey, blockHeight, numPosts)
+}
+
+func DbIsPostCountsBackfilled(handle *badger.DB) bool {
+	var isBackfilled bool
+	handle.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(Prefixes.PrefixPostCountsBackfilled)
+		isBackfilled = err == nil
+		return nil
+	})
+	return isBackfilled
+}
+
+// DbBackfillPostCounts rebuilds the post counts and the number of posts per block of every public
+// key from the PostEntries in the db, and marks them as backfilled. It must not run concurrently
+// with post flushes.
+func DbBackfillPostCounts(handle *badger.DB, snap *Snapshot) error {
+	// Drop the existing counts, since some of their posts might not exist anymore.
+	for _, prefix := range [][]byte{
+		Prefixes.PrefixPosterPublicKeyToPostCount, Prefixes.PrefixPosterPublicKeyBlockHeightToNumPosts} {
+
+		staleKeys, _, err := DBGetPaginatedKeysAndValuesForPrefix(handle, prefix, prefix,
+			0 /*keyLen*/, 0 /*numToFetch*/, false /*reverse*/, false /*fetchValues*/)
+		if err != nil {
+			return errors.Wrapf(err, "DbBackfillPostCounts: Problem reading existing post counts")
+		}
+		for start := 0; start < len(staleKeys); start += PostCountMigrationBatchSize {
+			end := start + PostCountMigrationBatchSize
+			if end > len(staleKeys) {
+				end = len(staleKeys)
+			}
+			err = handle.Update(func(txn *badger.Txn) error {
+				for _, key := range staleKeys[start:end] {
+					if err := DBDeleteWithTxn(txn, snap, key); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return errors.Wrapf(err, "DbBackfillPostCounts: Problem deleting existing post counts")
+			}
+		}
+	}
+
+	type postCountsAtHeightKey struct {
+		PosterPublicKey PkMapKey
+		BlockHeight     uint64
+	}
+	var postCountsMtx sync.Mutex
+	postCounts := make(map[PkMapKey]*PostCount)
+	numPostsAtHeight := make(map[postCountsAtHeightKey]uint64)
+	err := ParallelScanPrefix(handle, Prefixes.PrefixPostHashToPostEntry, runtime.GOMAXPROCS(0),
+		func(key []byte, value []byte) error {
+			postEntry := &PostEntry{}
+			if exists, err := DecodeFromBytes(postEntry, bytes.NewReader(value)); !exists || err != nil {
+				return fmt.Errorf("Problem decoding post entry for key %v: %v", key, err)
+			}
+			if len(postEntry.PosterPublicKey) != btcec.PubKeyBytesLenCompressed {
+				return fmt.Errorf("Invalid poster public key %v for key %v", postEntry.PosterPublicKey, key)
+			}
+			pkMapKey := MakePkMapKey(postEntry.PosterPublicKey)
+			postCountsMtx.Lock()
+			defer postCountsMtx.Unlock()
+			if _, exists := postCounts[pkMapKey]; !exists {
+				postCounts[pkMapKey] = &PostCount{}
+			}
+			if len(postEntry.ParentStakeID) != 0 {
+				postCounts[pkMapKey].NumComments++
+			} else {
+				postCounts[pkMapKey].NumPosts++
+			}
+			numPostsAtHeight[postCountsAtHeightKey{pkMapKey, uint64(postEntry.ConfirmationBlockHeight)}]++
+			return nil
+		})
+	if err != nil {
+		return errors.Wrapf(err, "DbBackfillPostCounts: Problem scanning post entries")
+	}
+
+	// Write the counts in batches so that we don't exceed badger's txn size limits.
+	var publicKeys []PkMapKey
+	for pkMapKey := range postCounts {
+		publicKeys = append(publicKeys, pkMapKey)
+	}
+	for start := 0; start < len(publicKeys); start += PostCountMigrationBatchSize {
+		end := start + PostCountMigrationBatchSize
+		if end > len(publicKeys) {
+			end = len(publicKeys)
+		}
+		err = handle.Update(func(txn *badger.Txn) error {
+			for _, pkMapKey := range publicKeys[start:end] {
+				publicKey := pkMapKey
+				if err := DbPutPostCountForPublicKeyWithTxn(txn, snap, publicKey[:], postCounts[pkMapKey]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "DbBackfillPostCounts: Problem writing post counts")
+		}
+	}
+	var heightKeys []postCountsAtHeightKey
+	for heightKey := range numPostsAtHeight {
+		heightKeys = append(heightKeys, heightKey)
+	}
+	for start := 0; start < len(heightKeys); start += PostCountMigrationBatchSize {
+		end := start + PostCountMigrationBatchSize
+		if end > len(heightKeys) {
+			end = len(heightKeys)
+		}
+		err = handle.Update(func(txn *badger.Txn) error {
+			for _, heightKey := range heightKeys[start:end] {
+				publicKey := heightKey.PosterPublicKey
+				if err := _dbPutNumPostsForPublicKeyAtBlockHeightWithTxn(txn, snap, publicKey[:],
+					heightKey.BlockHeight, numPostsAtHeight[heightKey]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "DbBackfillPostCounts: Problem writing number of posts per block")
+		}
+	}
+
+	err = handle.Update(func(txn *badger.Txn) error {
+		return txn.Set(Prefixes.PrefixPostCountsBackfilled, []byte{})
+	})
+	if err != nil {
+		return errors.Wrapf(err, "DbBackfillPostCounts: Problem marking post counts as backfilled")
+	}
+	glog.Infof("DbBackfillPostCounts: Backfilled post counts for %v public keys", len(publicKeys))
+	return nil
+}
+
 // Specifying minTimestampNanos gives you all posts after minTimestampNanos
 // Pass minTimestampNanos = 0 && maxTimestampNanos = 0 if you want all posts
 // Setting maxTimestampNanos = 0, will default maxTimestampNanos to the current time.
@@ -6151,7 +9608,7 @@ func DBGetAllPostsAndCommentsForPublicKeyOrderedByTimestamp(handle *badger.DB,
 
 	tstampsFetched := []uint64{}
 	postAndCommentHashesFetched := []*BlockHash{}
-	postAndCommentEntriesFetched := []*PostEntry{}
+	keyCodec := MustGetKeyCodec(Prefixes.PrefixPosterPublicKeyTimestampPostHash)
 	dbPrefixx := append([]byte{}, Prefixes.PrefixPosterPublicKeyTimestampPostHash...)
 	dbPrefixx = append(dbPrefixx, publicKey...)
 
@@ -6177,20 +9634,14 @@ func DBGetAllPostsAndCommentsForPublicKeyOrderedByTimestamp(handle *badger.DB,
 		}
 
 		for it.Seek(prefix); it.ValidForPrefix(dbPrefixx); it.Next() {
-			rawKey := it.Item().Key()
-
 			// Key should be
 			// [prefix][posterPublicKey][Timestamp][PostHash]
-
-			// Pull out the relevant fields
-			timestampSizeBytes := 8
-			keyWithoutPrefix := rawKey[1:]
-			//posterPublicKey := keyWithoutPrefix[:HashSizeBytes]
-			publicKeySizeBytes := HashSizeBytes + 1
-			tstampNanos := DecodeUint64(keyWithoutPrefix[publicKeySizeBytes:(publicKeySizeBytes + timestampSizeBytes)])
-
-			postHash := &BlockHash{}
-			copy(postHash[:], keyWithoutPrefix[(publicKeySizeBytes+timestampSizeBytes):])
+			decodedKey, err := keyCodec.Decode(it.Item().Key())
+			if err != nil {
+				return errors.Wrapf(err, "DBGetAllPostsAndCommentsForPublicKeyOrderedByTimestamp: ")
+			}
+			tstampNanos := decodedKey.Uint64("TstampNanos")
+			postHash := decodedKey.BlockHash("PostHash")
 
 			if tstampNanos < minTimestampNanos {
 				break
@@ -6209,16 +9660,18 @@ func DBGetAllPostsAndCommentsForPublicKeyOrderedByTimestamp(handle *badger.DB,
 		return tstampsFetched, postAndCommentHashesFetched, nil, nil
 	}
 
-	for _, postHash := range postAndCommentHashesFetched {
-		postEntry := DBGetPostEntryByPostHash(handle, snap, postHash)
-		if postEntry == nil {
-			return nil, nil, nil, fmt.Errorf("DBGetPostEntryByPostHash: "+
-				"PostHash %v does not have corresponding entry", postHash)
-		}
-		postAndCommentEntriesFetched = append(postAndCommentEntriesFetched, postEntry)
+	postAndCommentEntriesFetched, indexes, err := DBGetPostEntriesForPostHashes(handle, snap, postAndCommentHashesFetched)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	tstampsKept := []uint64{}
+	postAndCommentHashesKept := []*BlockHash{}
+	for _, index := range indexes {
+		tstampsKept = append(tstampsKept, tstampsFetched[index])
+		postAndCommentHashesKept = append(postAndCommentHashesKept, postAndCommentHashesFetched[index])
 	}
 
-	return tstampsFetched, postAndCommentHashesFetched, postAndCommentEntriesFetched, nil
+	return tstampsKept, postAndCommentHashesKept, postAndCommentEntriesFetched, nil
 }
 
 // DBGetAllPostsByTstamp returns all the posts in the db with the newest
@@ -6231,7 +9684,7 @@ func DBGetAllPostsByTstamp(handle *badger.DB, snap *Snapshot, fetchEntries bool)
 
 	tstampsFetched := []uint64{}
 	postHashesFetched := []*BlockHash{}
-	postEntriesFetched := []*PostEntry{}
+	keyCodec := MustGetKeyCodec(Prefixes.PrefixTstampNanosPostHash)
 	dbPrefixx := append([]byte{}, Prefixes.PrefixTstampNanosPostHash...)
 
 	err := handle.View(func(txn *badger.Txn) error {
@@ -6250,23 +9703,13 @@ func DBGetAllPostsByTstamp(handle *badger.DB, snap *Snapshot, fetchEntries bool)
 		maxBigEndianUint64Bytes := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
 		prefix := append(dbPrefixx, maxBigEndianUint64Bytes...)
 		for it.Seek(prefix); it.ValidForPrefix(dbPrefixx); it.Next() {
-			rawKey := it.Item().Key()
-
-			// Strip the prefix off the key and check its length. If it contains
-			// a big-endian uint64 then it should be at least eight bytes.
-			tstampPostHashKey := rawKey[1:]
-			uint64BytesLen := len(maxBigEndianUint64Bytes)
-			if len(tstampPostHashKey) != uint64BytesLen+HashSizeBytes {
-				return fmt.Errorf("DBGetAllPostsByTstamp: Invalid key "+
-					"length %d should be at least %d", len(tstampPostHashKey),
-					uint64BytesLen+HashSizeBytes)
+			// The key should contain a big-endian uint64 tstamp followed by the post hash.
+			decodedKey, err := keyCodec.Decode(it.Item().Key())
+			if err != nil {
+				return errors.Wrapf(err, "DBGetAllPostsByTstamp: ")
 			}
-
-			tstampNanos := DecodeUint64(tstampPostHashKey[:uint64BytesLen])
-
-			// Appended to the tstamp should be the post hash so extract it here.
-			postHash := &BlockHash{}
-			copy(postHash[:], tstampPostHashKey[uint64BytesLen:])
+			tstampNanos := decodedKey.Uint64("TstampNanos")
+			postHash := decodedKey.BlockHash("PostHash")
 
 			tstampsFetched = append(tstampsFetched, tstampNanos)
 			postHashesFetched = append(postHashesFetched, postHash)
@@ -6281,16 +9724,18 @@ func DBGetAllPostsByTstamp(handle *badger.DB, snap *Snapshot, fetchEntries bool)
 		return tstampsFetched, postHashesFetched, nil, nil
 	}
 
-	for _, postHash := range postHashesFetched {
-		postEntry := DBGetPostEntryByPostHash(handle, snap, postHash)
-		if postEntry == nil {
-			return nil, nil, nil, fmt.Errorf("DBGetPostEntryByPostHash: "+
-				"PostHash %v does not have corresponding entry", postHash)
-		}
-		postEntriesFetched = append(postEntriesFetched, postEntry)
+	postEntriesFetched, indexes, err := DBGetPostEntriesForPostHashes(handle, snap, postHashesFetched)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	tstampsKept := []uint64{}
+	postHashesKept := []*BlockHash{}
+	for _, index := range indexes {
+		tstampsKept = append(tstampsKept, tstampsFetched[index])
+		postHashesKept = append(postHashesKept, postHashesFetched[index])
 	}
 
-	return tstampsFetched, postHashesFetched, postEntriesFetched, nil
+	return tstampsKept, postHashesKept, postEntriesFetched, nil
 }
 
 // DBGetCommentPostHashesForParentStakeID returns all the comments, which are indexed by their
@@ -6304,7 +9749,7 @@ func DBGetCommentPostHashesForParentStakeID(
 
 	tstampsFetched := []uint64{}
 	commentPostHashes := []*BlockHash{}
-	commentEntriesFetched := []*PostEntry{}
+	keyCodec := MustGetKeyCodec(Prefixes.PrefixCommentParentStakeIDToPostHash)
 	dbPrefixx := append([]byte{}, Prefixes.PrefixCommentParentStakeIDToPostHash...)
 	dbPrefixx = append(dbPrefixx, stakeIDXXX...)
 
@@ -6315,31 +9760,16 @@ func DBGetCommentPostHashesForParentStakeID(
 
 		it := txn.NewIterator(opts)
 		defer it.Close()
-		// Since we iterate backwards, the prefix must be bigger than all possible
-		// counts that could actually exist. We use eight bytes since the count is
-		// encoded as a 64-bit big-endian byte slice, which will be eight bytes long.
-		maxBigEndianUint64Bytes := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
-		//prefix := append(dbPrefixx, maxBigEndianUint64Bytes...)
 		prefix := dbPrefixx
 		for it.Seek(prefix); it.ValidForPrefix(dbPrefixx); it.Next() {
-			rawKey := it.Item().Key()
-
-			// Strip the prefix off the key and check its length. It should contain
-			// a 33-byte stake id, an 8 byte tstamp, and a 32 byte comment hash.
-			stakeIDTstampPostHashKey := rawKey[1:]
-			uint64BytesLen := len(maxBigEndianUint64Bytes)
-			if len(stakeIDTstampPostHashKey) != btcec.PubKeyBytesLenCompressed+uint64BytesLen+HashSizeBytes {
-				return fmt.Errorf("DBGetCommentPostHashesForParentStakeID: Invalid key "+
-					"length %d should be at least %d", len(stakeIDTstampPostHashKey),
-					btcec.PubKeyBytesLenCompressed+uint64BytesLen+HashSizeBytes)
+			// The key should contain a 33-byte stake id, an 8 byte tstamp, and a 32 byte
+			// comment hash.
+			decodedKey, err := keyCodec.Decode(it.Item().Key())
+			if err != nil {
+				return errors.Wrapf(err, "DBGetCommentPostHashesForParentStakeID: ")
 			}
-
-			//stakeID := stakeIDTstampPostHashKey[:btcec.PubKeyBytesLenCompressed]
-			tstampNanos := DecodeUint64(stakeIDTstampPostHashKey[btcec.PubKeyBytesLenCompressed : btcec.PubKeyBytesLenCompressed+uint64BytesLen])
-
-			commentPostHashBytes := stakeIDTstampPostHashKey[btcec.PubKeyBytesLenCompressed+uint64BytesLen:]
-			commentPostHash := &BlockHash{}
-			copy(commentPostHash[:], commentPostHashBytes)
+			tstampNanos := decodedKey.Uint64("TstampNanos")
+			commentPostHash := decodedKey.BlockHash("PostHash")
 
 			//stakeIDsFetched = append(stakeIDsFetched, stakeID)
 			tstampsFetched = append(tstampsFetched, tstampNanos)
@@ -6355,16 +9785,18 @@ func DBGetCommentPostHashesForParentStakeID(
 		return tstampsFetched, commentPostHashes, nil, nil
 	}
 
-	for _, postHash := range commentPostHashes {
-		postEntry := DBGetPostEntryByPostHash(handle, snap, postHash)
-		if postEntry == nil {
-			return nil, nil, nil, fmt.Errorf("DBGetCommentPostHashesForParentStakeID: "+
-				"PostHash %v does not have corresponding entry", postHash)
-		}
-		commentEntriesFetched = append(commentEntriesFetched, postEntry)
+	commentEntriesFetched, indexes, err := DBGetPostEntriesForPostHashes(handle, snap, commentPostHashes)
+	if err != nil {
+		return nil, nil, nil, errors.Wrapf(err, "DBGetCommentPostHashesForParentStakeID: ")
+	}
+	tstampsKept := []uint64{}
+	commentPostHashesKept := []*BlockHash{}
+	for _, index := range indexes {
+		tstampsKept = append(tstampsKept, tstampsFetched[index])
+		commentPostHashesKept = append(commentPostHashesKept, commentPostHashes[index])
 	}
 
-	return tstampsFetched, commentPostHashes, commentEntriesFetched, nil
+	return tstampsKept, commentPostHashesKept, commentEntriesFetched, nil
 }
 
 // =======================================================================================
@@ -6388,6 +9820,14 @@ func _dbKeyForPKIDIsForSaleBidAmountNanosNFTPostHashSerialNumber(pkid *PKID, isF
 	return key
 }
 
+func _dbKeyForNFTOwnershipShare(nftPostHash *BlockHash, serialNumber uint64, ownerPKID *PKID) []byte {
+	prefixCopy := append([]byte{}, Prefixes.PrefixNFTOwnershipSharesBySerial...)
+	key := append(prefixCopy, nftPostHash[:]...)
+	key = append(key, EncodeUint64(serialNumber)...)
+	key = append(key, ownerPKID[:]...)
+	return key
+}
+
 func DBGetNFTEntryByPostHashSerialNumberWithTxn(txn *badger.Txn, snap *Snapshot,
 	postHash *BlockHash, serialNumber uint64) *NFTEntry {
 
@@ -6439,6 +9879,15 @@ func DBDeleteNFTMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 			"nft mapping for post hash %v serial number %d", nftPostHash, serialNumber)
 	}
 
+	for ownerPKIDIter := range nftEntry.OwnershipSharesBasisPoints {
+		ownerPKID := ownerPKIDIter
+		if err := DBDeleteWithTxn(txn, snap,
+			_dbKeyForNFTOwnershipShare(nftPostHash, serialNumber, &ownerPKID)); err != nil {
+			return errors.Wrapf(err, "DbDeleteNFTMappingsWithTxn: Deleting ownership share "+
+				"for pkid %v post hash %v serial number %d", ownerPKID, nftPostHash, serialNumber)
+		}
+	}
+
 	return nil
 }
 
@@ -6466,6 +9915,15 @@ func DBPutNFTEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight u
 			"adding mapping for pkid: %v, post: %v, serial number: %d", nftEntry.OwnerPKID, nftEntry.NFTPostHash, nftEntry.SerialNumber)
 	}
 
+	for ownerPKIDIter, basisPoints := range nftEntry.OwnershipSharesBasisPoints {
+		ownerPKID := ownerPKIDIter
+		if err := DBSetWithTxn(txn, snap, _dbKeyForNFTOwnershipShare(
+			nftEntry.NFTPostHash, nftEntry.SerialNumber, &ownerPKID), UintToBuf(basisPoints)); err != nil {
+			return errors.Wrapf(err, "DbPutNFTEntryMappingsWithTxn: Problem adding ownership share "+
+				"for pkid: %v, post: %v, serial number: %d", ownerPKID, nftEntry.NFTPostHash, nftEntry.SerialNumber)
+		}
+	}
+
 	return nil
 }
 
@@ -6476,17 +9934,60 @@ func DBPutNFTEntryMappings(handle *badger.DB, snap *Snapshot, blockHeight uint64
 	})
 }
 
+// DBGetNFTOwnershipSharesForSerialWithTxn returns the basis points of the NFT that each of its
+// owners has, or an empty map if the NFT wasn't minted with ownership shares.
+func DBGetNFTOwnershipSharesForSerialWithTxn(txn *badger.Txn, snap *Snapshot,
+	nftPostHash *BlockHash, serialNumber uint64) (map[PKID]uint64, error) {
+
+	prefix := append([]byte{}, Prefixes.PrefixNFTOwnershipSharesBySerial...)
+	prefix = append(prefix, nftPostHash[:]...)
+	prefix = append(prefix, EncodeUint64(serialNumber)...)
+	keysFound, valsFound, err := _enumerateKeysForPrefixWithTxn(txn, prefix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DBGetNFTOwnershipSharesForSerialWithTxn: ")
+	}
+
+	ownershipShares := make(map[PKID]uint64)
+	for ii, keyBytes := range keysFound {
+		if len(keyBytes) != len(prefix)+btcec.PubKeyBytesLenCompressed {
+			return nil, fmt.Errorf("DBGetNFTOwnershipSharesForSerialWithTxn: Invalid key length %d", len(keyBytes))
+		}
+		basisPoints, err := ReadUvarint(bytes.NewReader(valsFound[ii]))
+		if err != nil {
+			return nil, errors.Wrapf(err, "DBGetNFTOwnershipSharesForSerialWithTxn: Problem reading basis points")
+		}
+		ownershipShares[*NewPKID(keyBytes[len(prefix):])] = basisPoints
+	}
+	return ownershipShares, nil
+}
+
+func DBGetNFTOwnershipSharesForSerial(handle *badger.DB, snap *Snapshot,
+	nftPostHash *BlockHash, serialNumber uint64) (map[PKID]uint64, error) {
+
+	var ownershipShares map[PKID]uint64
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		ownershipShares, err = DBGetNFTOwnershipSharesForSerialWithTxn(txn, snap, nftPostHash, serialNumber)
+		return err
+	})
+	return ownershipShares, err
+}
+
 // DBGetNFTEntriesForPostHash gets NFT Entries *from the DB*. Does not include mempool txns.
 func DBGetNFTEntriesForPostHash(handle *badger.DB, nftPostHash *BlockHash) (_nftEntries []*NFTEntry) {
 	nftEntries := []*NFTEntry{}
 	prefix := append([]byte{}, Prefixes.PrefixPostHashSerialNumberToNFTEntry...)
 	keyPrefix := append(prefix, nftPostHash[:]...)
-	_, entryByteStringsFound := _enumerateKeysForPrefix(handle, keyPrefix)
-	for _, byteString := range entryByteStringsFound {
+	err := DBForEachKeyWithPrefix(handle, keyPrefix, nil, func(_ []byte, byteString []byte) (bool, error) {
 		currentEntry := &NFTEntry{}
 		rr := bytes.NewReader(byteString)
 		DecodeFromBytes(currentEntry, rr)
 		nftEntries = append(nftEntries, currentEntry)
+		return false, nil
+	})
+	if err != nil {
+		glog.Errorf("DBGetNFTEntriesForPostHash: Problem fetching NFT entries from db: %v", err)
+		return nil
 	}
 	return nftEntries
 }
@@ -6528,12 +10029,16 @@ func DBGetNFTEntriesForPKID(handle *badger.DB, ownerPKID *PKID) (_nftEntries []*
 	var nftEntries []*NFTEntry
 	prefix := append([]byte{}, Prefixes.PrefixPKIDIsForSaleBidAmountNanosPostHashSerialNumberToNFTEntry...)
 	keyPrefix := append(prefix, ownerPKID[:]...)
-	_, entryByteStringsFound := _enumerateKeysForPrefix(handle, keyPrefix)
-	for _, byteString := range entryByteStringsFound {
+	err := DBForEachKeyWithPrefix(handle, keyPrefix, nil, func(_ []byte, byteString []byte) (bool, error) {
 		currentEntry := &NFTEntry{}
 		rr := bytes.NewReader(byteString)
 		DecodeFromBytes(currentEntry, rr)
 		nftEntries = append(nftEntries, currentEntry)
+		return false, nil
+	})
+	if err != nil {
+		glog.Errorf("DBGetNFTEntriesForPKID: Problem fetching NFT entries from db: %v", err)
+		return nil
 	}
 	return nftEntries
 }
@@ -6853,22 +10358,17 @@ func DBGetNFTBidEntriesPaginated(
 
 	// Chop up the keyBytes into bid entries.
 	var bidEntries []*NFTBidEntry
+	keyCodec := MustGetKeyCodec(Prefixes.PrefixPostHashSerialNumberBidNanosBidderPKID)
 	for _, keyBytes := range keysBytes {
-		serialNumStartIdx := 1 + HashSizeBytes
-		bidAmountStartIdx := serialNumStartIdx + 8
-		bidderPKIDStartIdx := bidAmountStartIdx + 8
-
-		nftHashBytes := keyBytes[1:serialNumStartIdx]
-		serialNumberBytes := keyBytes[serialNumStartIdx:bidAmountStartIdx]
-		bidAmountBytes := keyBytes[bidAmountStartIdx:bidderPKIDStartIdx]
-		bidderPKIDBytes := keyBytes[bidderPKIDStartIdx:]
-
-		nftHash := &BlockHash{}
-		copy(nftHash[:], nftHashBytes)
-		serialNumber := DecodeUint64(serialNumberBytes)
-		bidAmount := DecodeUint64(bidAmountBytes)
-		bidderPKID := &PKID{}
-		copy(bidderPKID[:], bidderPKIDBytes)
+		decodedKey, err := keyCodec.Decode(keyBytes)
+		if err != nil {
+			glog.Errorf("DBGetNFTBidEntriesPaginated: Skipping invalid key: %v", err)
+			continue
+		}
+		nftHash := decodedKey.BlockHash("NFTPostHash")
+		serialNumber := decodedKey.Uint64("SerialNumber")
+		bidAmount := decodedKey.Uint64("BidNanos")
+		bidderPKID := decodedKey.PKID("BidderPKID")
 
 		bidEntry := &NFTBidEntry{
 			NFTPostHash:    nftHash,
@@ -6883,9 +10383,413 @@ func DBGetNFTBidEntriesPaginated(
 	return bidEntries
 }
 
-// ======================================================================================
-// Authorize derived key functions
-//  	<prefix_id, owner pub key [33]byte, derived pub key [33]byte> -> <DerivedKeyEntry>
+// DBGetBestBidsForNFTPostHash returns the highest bid *from the DB* for every serial number of
+// an NFT post that has at least one bid, ordered by serial number. At most limit entries are
+// returned; if limit is zero or negative, every serial number with a bid is returned. When two
+// bids on a serial number have the same amount, the one with the larger BidderPKID wins.
+// Does not include mempool txns; see UtxoView.GetBestBidsForNFTPostHash for that.
+func DBGetBestBidsForNFTPostHash(handle *badger.DB, nftPostHash *BlockHash, limit int,
+) (_bestBidEntries []*NFTBidEntry) {
+	bestBidEntries := []*NFTBidEntry{}
+	handle.View(func(txn *badger.Txn) error {
+		bestBidEntries = DBGetBestBidsForNFTPostHashWithTxn(txn, nftPostHash, limit)
+		return nil
+	})
+	return bestBidEntries
+}
+
+func DBGetBestBidsForNFTPostHashWithTxn(txn *badger.Txn, nftPostHash *BlockHash, limit int,
+) (_bestBidEntries []*NFTBidEntry) {
+	postHashPrefix := append([]byte{}, Prefixes.PrefixPostHashSerialNumberBidNanosBidderPKID...)
+	postHashPrefix = append(postHashPrefix, nftPostHash[:]...)
+
+	// Every key under a serial number is (bid amount) + (PKID) long after the serial number, so
+	// padding the serial number prefix with that many 0xff bytes gives a key that is greater than
+	// or equal to every bid on the serial number.
+	serialNumberEndPadding := bytes.Repeat([]byte{0xff}, 8+btcec.PubKeyBytesLenCompressed)
+	serialNumberEndKey := func(serialNumber uint64) []byte {
+		key := append([]byte{}, postHashPrefix...)
+		key = append(key, EncodeUint64(serialNumber)...)
+		return append(key, serialNumberEndPadding...)
+	}
+
+	// The forward iterator finds the next serial number that has a bid and the reverse
+	// iterator jumps to the highest bid on it, so we only touch one key per serial number.
+	forwardIterator := txn.NewIterator(badger.IteratorOptions{PrefetchValues: false, Prefix: postHashPrefix})
+	defer forwardIterator.Close()
+	reverseIterator := txn.NewIterator(badger.IteratorOptions{PrefetchValues: false, Prefix: postHashPrefix, Reverse: true})
+	defer reverseIterator.Close()
+
+	serialNumStartIdx := len(postHashPrefix)
+	bidAmountStartIdx := serialNumStartIdx + 8
+	bidderPKIDStartIdx := bidAmountStartIdx + 8
+
+	bestBidEntries := []*NFTBidEntry{}
+	for forwardIterator.Seek(postHashPrefix); forwardIterator.ValidForPrefix(postHashPrefix); {
+		if limit > 0 && len(bestBidEntries) >= limit {
+			break
+		}
+		serialNumber := DecodeUint64(forwardIterator.Item().Key()[serialNumStartIdx:bidAmountStartIdx])
+
+		// In reverse order, Seek finds the largest key that is less than or equal to the one given.
+		endKey := serialNumberEndKey(serialNumber)
+		reverseIterator.Seek(endKey)
+		if !reverseIterator.ValidForPrefix(postHashPrefix) {
+			break
+		}
+		keyBytes := reverseIterator.Item().KeyCopy(nil)
+		bidderPKID := &PKID{}
+		copy(bidderPKID[:], keyBytes[bidderPKIDStartIdx:])
+		bestBidEntries = append(bestBidEntries, &NFTBidEntry{
+			NFTPostHash:    nftPostHash,
+			SerialNumber:   serialNumber,
+			BidAmountNanos: DecodeUint64(keyBytes[bidAmountStartIdx:bidderPKIDStartIdx]),
+			BidderPKID:     bidderPKID,
+		})
+
+		// Move the forward iterator past every bid on this serial number. The end key itself
+		// can only be a bid if it is the highest one, in which case we step over it.
+		forwardIterator.Seek(endKey)
+		if forwardIterator.ValidForPrefix(postHashPrefix) && bytes.Equal(forwardIterator.Item().Key(), endKey) {
+			forwardIterator.Next()
+		}
+	}
+	return bestBidEntries
+}
+
+// =======================================================================================
+// NFTCollectionSummary db functions
+// NOTE: This index is derived entirely from the NFT, NFT bid, and accepted bid indexes.
+// It is updated from the entries a view changes when it's flushed, and only recomputed
+// from the indexes when an entry that one of its extremes came from goes away.
+// =======================================================================================
+
+func _dbKeyForPostHashToNFTCollectionSummary(nftPostHash *BlockHash) []byte {
+	prefixCopy := append([]byte{}, Prefixes.PrefixPostHashToNFTCollectionSummary...)
+	key := append(prefixCopy, nftPostHash[:]...)
+	return key
+}
+
+func DBGetNFTCollectionSummaryWithTxn(txn *badger.Txn, snap *Snapshot, nftPostHash *BlockHash) *NFTCollectionSummary {
+	key := _dbKeyForPostHashToNFTCollectionSummary(nftPostHash)
+	summaryBytes, err := DBGetWithTxn(txn, snap, key)
+	if err != nil {
+		return nil
+	}
+
+	summary := &NFTCollectionSummary{}
+	rr := bytes.NewReader(summaryBytes)
+	if exists, err := DecodeFromBytes(summary, rr); !exists || err != nil {
+		glog.Errorf("DBGetNFTCollectionSummaryWithTxn: Problem reading NFTCollectionSummary, error: (%v)", err)
+		return nil
+	}
+	return summary
+}
+
+// DbGetNFTCollectionSummary returns the aggregate NFT state for a post in a single read.
+// It returns nil if no NFTs exist for the post. Does not include mempool txns.
+func DbGetNFTCollectionSummary(db *badger.DB, snap *Snapshot, nftPostHash *BlockHash) *NFTCollectionSummary {
+	var ret *NFTCollectionSummary
+	db.View(func(txn *badger.Txn) error {
+		ret = DBGetNFTCollectionSummaryWithTxn(txn, snap, nftPostHash)
+		return nil
+	})
+	return ret
+}
+
+// DBComputeNFTCollectionSummaryWithTxn builds the summary for a post from the underlying
+// NFT, bid, and accepted bid indexes. It returns nil if no NFTs exist for the post.
+func DBComputeNFTCollectionSummaryWithTxn(txn *badger.Txn, nftPostHash *BlockHash) (
+	*NFTCollectionSummary, error) {
+
+	summary := &NFTCollectionSummary{
+		NFTPostHash: nftPostHash.NewBlockHash(),
+	}
+
+	// Tally up the serial numbers and find the floor price among those for sale.
+	nftPrefix := append(append([]byte{}, Prefixes.PrefixPostHashSerialNumberToNFTEntry...), nftPostHash[:]...)
+	_, nftEntryBytes, err := _enumerateKeysForPrefixWithTxn(txn, nftPrefix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DBComputeNFTCollectionSummaryWithTxn: Problem fetching NFT entries")
+	}
+	if len(nftEntryBytes) == 0 {
+		return nil, nil
+	}
+	for _, entryBytes := range nftEntryBytes {
+		nftEntry := &NFTEntry{}
+		rr := bytes.NewReader(entryBytes)
+		if exists, err := DecodeFromBytes(nftEntry, rr); !exists || err != nil {
+			return nil, errors.Wrapf(err, "DBComputeNFTCollectionSummaryWithTxn: Problem decoding NFT entry")
+		}
+		summary.NumSerials++
+		if !nftEntry.IsForSale {
+			continue
+		}
+		summary.NumForSale++
+		priceNanos := _nftCollectionSummaryPriceNanos(nftEntry)
+		if summary.NumForSale == 1 || priceNanos < summary.FloorPriceNanos {
+			summary.FloorPriceNanos = priceNanos
+		}
+	}
+
+	// The bid amount is part of the bid key so we don't need to fetch any values here.
+	bidPrefix := append(append([]byte{}, Prefixes.PrefixPostHashSerialNumberBidNanosBidderPKID...), nftPostHash[:]...)
+	bidKeys, _, err := _enumerateKeysForPrefixWithTxn(txn, bidPrefix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DBComputeNFTCollectionSummaryWithTxn: Problem fetching NFT bids")
+	}
+	bidAmountStartIdx := 1 + HashSizeBytes + 8
+	for _, bidKey := range bidKeys {
+		bidAmountNanos := DecodeUint64(bidKey[bidAmountStartIdx : bidAmountStartIdx+8])
+		if bidAmountNanos > summary.HighestBidNanos {
+			summary.HighestBidNanos = bidAmountNanos
+		}
+	}
+
+	// The last sale is the accepted bid with the greatest AcceptedBlockHeight, and the greatest
+	// amount among those accepted in the same block.
+	acceptedBidPrefix := append(append([]byte{}, Prefixes.PrefixPostHashSerialNumberToAcceptedBidEntries...),
+		nftPostHash[:]...)
+	_, acceptedBidBundles, err := _enumerateKeysForPrefixWithTxn(txn, acceptedBidPrefix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DBComputeNFTCollectionSummaryWithTxn: Problem fetching accepted bids")
+	}
+	for _, bundleBytes := range acceptedBidBundles {
+		bundle := &NFTBidEntryBundle{}
+		rr := bytes.NewReader(bundleBytes)
+		if exists, err := DecodeFromBytes(bundle, rr); !exists || err != nil {
+			return nil, errors.Wrapf(err, "DBComputeNFTCollectionSummaryWithTxn: Problem decoding accepted bids")
+		}
+		for _, bidEntry := range bundle.nftBidEntryBundle {
+			_addSaleToNFTCollectionSummary(summary, bidEntry)
+		}
+	}
+
+	return summary, nil
+}
+
+// DBUpdateNFTCollectionSummaryWithTxn recomputes the summary for a post and writes it,
+// deleting the record if the post no longer has any NFTs. The summary isn't state, so it's
+// written without the snapshot and never gets ancestral records.
+func DBUpdateNFTCollectionSummaryWithTxn(txn *badger.Txn, blockHeight uint64, nftPostHash *BlockHash) error {
+
+	summary, err := DBComputeNFTCollectionSummaryWithTxn(txn, nftPostHash)
+	if err != nil {
+		return errors.Wrapf(err, "DBUpdateNFTCollectionSummaryWithTxn: Problem computing summary "+
+			"for post hash %v", nftPostHash)
+	}
+
+	key := _dbKeyForPostHashToNFTCollectionSummary(nftPostHash)
+	if summary == nil {
+		if err := DBDeleteWithTxn(txn, nil, key); err != nil {
+			return errors.Wrapf(err, "DBUpdateNFTCollectionSummaryWithTxn: Problem deleting summary "+
+				"for post hash %v", nftPostHash)
+		}
+		return nil
+	}
+	if err := DBSetWithTxn(txn, nil, key, EncodeToBytes(blockHeight, summary)); err != nil {
+		return errors.Wrapf(err, "DBUpdateNFTCollectionSummaryWithTxn: Problem setting summary "+
+			"for post hash %v", nftPostHash)
+	}
+	return nil
+}
+
+// nftCollectionSummaryChange holds the changes a flush makes to the NFTs, bids, and accepted
+// bids of a post, as far as its NFTCollectionSummary is concerned. Entries that are replaced
+// are both removed and added.
+type nftCollectionSummaryChange struct {
+	removedNFTEntries []*NFTEntry
+	addedNFTEntries   []*NFTEntry
+
+	removedBidAmountsNanos []uint64
+	addedBidAmountsNanos   []uint64
+
+	addedAcceptedBidEntries []*NFTBidEntry
+	// Accepted bids are only removed when blocks are disconnected, so we don't keep track of them.
+	hasRemovedAcceptedBids bool
+}
+
+// _nftCollectionSummaryPriceNanos is the price an NFT for sale counts toward the floor price with.
+func _nftCollectionSummaryPriceNanos(nftEntry *NFTEntry) uint64 {
+	if nftEntry.IsBuyNow && nftEntry.BuyNowPriceNanos > 0 {
+		return nftEntry.BuyNowPriceNanos
+	}
+	return nftEntry.MinBidAmountNanos
+}
+
+func _addSaleToNFTCollectionSummary(summary *NFTCollectionSummary, bidEntry *NFTBidEntry) {
+	if bidEntry.AcceptedBlockHeight == nil {
+		return
+	}
+	acceptedBlockHeight := uint64(*bidEntry.AcceptedBlockHeight)
+	if acceptedBlockHeight > summary.LastSaleBlockHeight ||
+		(acceptedBlockHeight == summary.LastSaleBlockHeight && bidEntry.BidAmountNanos > summary.LastSalePriceNanos) {
+
+		summary.LastSaleBlockHeight = acceptedBlockHeight
+		summary.LastSalePriceNanos = bidEntry.BidAmountNanos
+	}
+}
+
+// _applyNFTCollectionSummaryChange updates the summary with the change. It returns false if the
+// change removes an entry that the floor price, the highest bid, or the last sale could have come
+// from, in which case the summary has to be recomputed from the indexes.
+func _applyNFTCollectionSummaryChange(summary *NFTCollectionSummary, change *nftCollectionSummaryChange) bool {
+	for _, nftEntry := range change.removedNFTEntries {
+		if summary.NumSerials == 0 {
+			return false
+		}
+		summary.NumSerials--
+		if !nftEntry.IsForSale {
+			continue
+		}
+		if summary.NumForSale == 0 || _nftCollectionSummaryPriceNanos(nftEntry) <= summary.FloorPriceNanos {
+			return false
+		}
+		summary.NumForSale--
+	}
+	for _, bidAmountNanos := range change.removedBidAmountsNanos {
+		if bidAmountNanos >= summary.HighestBidNanos {
+			return false
+		}
+	}
+	if change.hasRemovedAcceptedBids {
+		return false
+	}
+
+	for _, nftEntry := range change.addedNFTEntries {
+		summary.NumSerials++
+		if !nftEntry.IsForSale {
+			continue
+		}
+		priceNanos := _nftCollectionSummaryPriceNanos(nftEntry)
+		if summary.NumForSale == 0 || priceNanos < summary.FloorPriceNanos {
+			summary.FloorPriceNanos = priceNanos
+		}
+		summary.NumForSale++
+	}
+	for _, bidAmountNanos := range change.addedBidAmountsNanos {
+		if bidAmountNanos > summary.HighestBidNanos {
+			summary.HighestBidNanos = bidAmountNanos
+		}
+	}
+	for _, bidEntry := range change.addedAcceptedBidEntries {
+		_addSaleToNFTCollectionSummary(summary, bidEntry)
+	}
+	return true
+}
+
+// _dbApplyNFTCollectionSummaryChangeWithTxn updates the summary for a post with the changes a flush
+// made to its NFTs, bids, and accepted bids, which must have been written to the txn already. The
+// summary is recomputed if there's none yet or if the change can't be applied to it.
+func _dbApplyNFTCollectionSummaryChangeWithTxn(txn *badger.Txn, blockHeight uint64, nftPostHash *BlockHash,
+	change *nftCollectionSummaryChange) error {
+
+	summary := DBGetNFTCollectionSummaryWithTxn(txn, nil, nftPostHash)
+	if summary == nil || !_applyNFTCollectionSummaryChange(summary, change) {
+		return DBUpdateNFTCollectionSummaryWithTxn(txn, blockHeight, nftPostHash)
+	}
+
+	key := _dbKeyForPostHashToNFTCollectionSummary(nftPostHash)
+	if summary.NumSerials == 0 {
+		if err := DBDeleteWithTxn(txn, nil, key); err != nil {
+			return errors.Wrapf(err, "_dbApplyNFTCollectionSummaryChangeWithTxn: Problem deleting summary "+
+				"for post hash %v", nftPostHash)
+		}
+		return nil
+	}
+	if err := DBSetWithTxn(txn, nil, key, EncodeToBytes(blockHeight, summary)); err != nil {
+		return errors.Wrapf(err, "_dbApplyNFTCollectionSummaryChangeWithTxn: Problem setting summary "+
+			"for post hash %v", nftPostHash)
+	}
+	return nil
+}
+
+func DbIsNFTCollectionSummariesBackfilled(handle *badger.DB) bool {
+	var isBackfilled bool
+	handle.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(Prefixes.PrefixNFTCollectionSummariesBackfilled)
+		isBackfilled = err == nil
+		return nil
+	})
+	return isBackfilled
+}
+
+// DbBackfillNFTCollectionSummaries recomputes the NFTCollectionSummary of every post with NFTs
+// from the NFT, bid, and accepted bid indexes, and marks the summaries as backfilled. It must not
+// run concurrently with NFT flushes.
+func DbBackfillNFTCollectionSummaries(handle *badger.DB) error {
+	// Drop the existing summaries, since some of their posts might not have NFTs anymore.
+	staleKeys, _, err := DBGetPaginatedKeysAndValuesForPrefix(handle, Prefixes.PrefixPostHashToNFTCollectionSummary,
+		Prefixes.PrefixPostHashToNFTCollectionSummary, 0 /*keyLen*/, 0 /*numToFetch*/, false /*reverse*/,
+		false /*fetchValues*/)
+	if err != nil {
+		return errors.Wrapf(err, "DbBackfillNFTCollectionSummaries: Problem reading existing summaries")
+	}
+	for start := 0; start < len(staleKeys); start += NFTCollectionSummaryMigrationBatchSize {
+		end := start + NFTCollectionSummaryMigrationBatchSize
+		if end > len(staleKeys) {
+			end = len(staleKeys)
+		}
+		err = handle.Update(func(txn *badger.Txn) error {
+			for _, key := range staleKeys[start:end] {
+				if err := DBDeleteWithTxn(txn, nil, key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "DbBackfillNFTCollectionSummaries: Problem deleting existing summaries")
+		}
+	}
+
+	// The NFT entries are keyed by post hash first, so the serials of a post are next to each other.
+	nftKeys, _, err := DBGetPaginatedKeysAndValuesForPrefix(handle, Prefixes.PrefixPostHashSerialNumberToNFTEntry,
+		Prefixes.PrefixPostHashSerialNumberToNFTEntry, 0 /*keyLen*/, 0 /*numToFetch*/, false /*reverse*/,
+		false /*fetchValues*/)
+	if err != nil {
+		return errors.Wrapf(err, "DbBackfillNFTCollectionSummaries: Problem reading NFT entries")
+	}
+	var nftPostHashes []*BlockHash
+	for _, nftKey := range nftKeys {
+		nftPostHash := NewBlockHash(nftKey[1 : 1+HashSizeBytes])
+		if len(nftPostHashes) == 0 || *nftPostHashes[len(nftPostHashes)-1] != *nftPostHash {
+			nftPostHashes = append(nftPostHashes, nftPostHash)
+		}
+	}
+
+	// Write the summaries in batches so that we don't exceed badger's txn size limits.
+	for start := 0; start < len(nftPostHashes); start += NFTCollectionSummaryMigrationBatchSize {
+		end := start + NFTCollectionSummaryMigrationBatchSize
+		if end > len(nftPostHashes) {
+			end = len(nftPostHashes)
+		}
+		err = handle.Update(func(txn *badger.Txn) error {
+			for _, nftPostHash := range nftPostHashes[start:end] {
+				if err := DBUpdateNFTCollectionSummaryWithTxn(txn, 0, nftPostHash); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "DbBackfillNFTCollectionSummaries: Problem writing summaries")
+		}
+	}
+
+	err = handle.Update(func(txn *badger.Txn) error {
+		return txn.Set(Prefixes.PrefixNFTCollectionSummariesBackfilled, []byte{})
+	})
+	if err != nil {
+		return errors.Wrapf(err, "DbBackfillNFTCollectionSummaries: Problem marking summaries as backfilled")
+	}
+	glog.Infof("DbBackfillNFTCollectionSummaries: Backfilled summaries for %v posts", len(nftPostHashes))
+	return nil
+}
+
+// ======================================================================================
+// Authorize derived key functions
+//  	<prefix_id, owner pub key [33]byte, derived pub key [33]byte> -> <DerivedKeyEntry>
 // ======================================================================================
 
 func _dbKeyForOwnerToDerivedKeyMapping(
@@ -6905,12 +10809,37 @@ func _dbSeekPrefixForDerivedKeyMappings(
 	return key
 }
 
+func _dbKeyForDerivedKeyAllowedTxnTypes(
+	ownerPublicKey PublicKey, derivedPublicKey PublicKey) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixDerivedKeyAllowedTxnTypes...)
+	key := append(prefixCopy, ownerPublicKey[:]...)
+	key = append(key, derivedPublicKey[:]...)
+	return key
+}
+
 func DBPutDerivedKeyMappingWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
 	ownerPublicKey PublicKey, derivedPublicKey PublicKey, derivedKeyEntry *DerivedKeyEntry) error {
 
 	key := _dbKeyForOwnerToDerivedKeyMapping(ownerPublicKey, derivedPublicKey)
 
-	return DBSetWithTxn(txn, snap, key, EncodeToBytes(blockHeight, derivedKeyEntry))
+	if err := DBSetWithTxn(txn, snap, key, EncodeToBytes(blockHeight, derivedKeyEntry)); err != nil {
+		return err
+	}
+
+	// Keep the txn type scope index in sync with the entry. The scope can be removed by a later
+	// authorization, in which case the index record is deleted.
+	allowedTxnTypesKey := _dbKeyForDerivedKeyAllowedTxnTypes(ownerPublicKey, derivedPublicKey)
+	if derivedKeyEntry.TransactionSpendingLimitTracker != nil &&
+		derivedKeyEntry.TransactionSpendingLimitTracker.AllowedTxnTypes != 0 {
+
+		allowedTxnTypes := uint64(derivedKeyEntry.TransactionSpendingLimitTracker.AllowedTxnTypes)
+		if err := DBSetWithTxn(txn, snap, allowedTxnTypesKey, EncodeUint64(allowedTxnTypes)); err != nil {
+			return errors.Wrapf(err, "DBPutDerivedKeyMappingWithTxn: Problem setting allowed txn types")
+		}
+		return nil
+	}
+	return DBDeleteWithTxn(txn, snap, allowedTxnTypesKey)
 }
 
 func DBPutDerivedKeyMapping(handle *badger.DB, snap *Snapshot, blockHeight uint64,
@@ -6931,8 +10860,7 @@ func DBGetOwnerToDerivedKeyMappingWithTxn(txn *badger.Txn, snap *Snapshot,
 	}
 
 	derivedKeyEntry := &DerivedKeyEntry{}
-	rr := bytes.NewReader(derivedKeyBytes)
-	DecodeFromBytes(derivedKeyEntry, rr)
+	DecodeFromBytesCompat(derivedKeyEntry, derivedKeyBytes)
 	return derivedKeyEntry
 }
 
@@ -6956,6 +10884,11 @@ func DBDeleteDerivedKeyMappingWithTxn(txn *badger.Txn, snap *Snapshot,
 			"ownerPublicKey %s and derivedPublicKey %s failed",
 			PkToStringMainnet(ownerPublicKey[:]), PkToStringMainnet(derivedPublicKey[:]))
 	}
+	if err := DBDeleteWithTxn(txn, snap, _dbKeyForDerivedKeyAllowedTxnTypes(ownerPublicKey, derivedPublicKey)); err != nil {
+		return errors.Wrapf(err, "DBDeleteDerivedKeyMappingWithTxn: Deleting allowed txn types for "+
+			"ownerPublicKey %s and derivedPublicKey %s failed",
+			PkToStringMainnet(ownerPublicKey[:]), PkToStringMainnet(derivedPublicKey[:]))
+	}
 
 	return nil
 }
@@ -6967,6 +10900,30 @@ func DBDeleteDerivedKeyMapping(handle *badger.DB, snap *Snapshot,
 	})
 }
 
+// DBGetDerivedKeyAllowedTxnTypesWithTxn returns the txn type scope of a derived key from the index.
+// A zero bitmap means the key isn't scoped, or doesn't exist.
+func DBGetDerivedKeyAllowedTxnTypesWithTxn(txn *badger.Txn, snap *Snapshot,
+	ownerPublicKey PublicKey, derivedPublicKey PublicKey) TxnTypeBitmap {
+
+	key := _dbKeyForDerivedKeyAllowedTxnTypes(ownerPublicKey, derivedPublicKey)
+	allowedTxnTypesBytes, err := DBGetWithTxn(txn, snap, key)
+	if err != nil || len(allowedTxnTypesBytes) != 8 {
+		return 0
+	}
+	return TxnTypeBitmap(DecodeUint64(allowedTxnTypesBytes))
+}
+
+func DBGetDerivedKeyAllowedTxnTypes(db *badger.DB, snap *Snapshot,
+	ownerPublicKey PublicKey, derivedPublicKey PublicKey) TxnTypeBitmap {
+
+	var allowedTxnTypes TxnTypeBitmap
+	db.View(func(txn *badger.Txn) error {
+		allowedTxnTypes = DBGetDerivedKeyAllowedTxnTypesWithTxn(txn, snap, ownerPublicKey, derivedPublicKey)
+		return nil
+	})
+	return allowedTxnTypes
+}
+
 func DBGetAllOwnerToDerivedKeyMappings(handle *badger.DB, ownerPublicKey PublicKey) (
 	_entries []*DerivedKeyEntry, _err error) {
 
@@ -6976,8 +10933,7 @@ func DBGetAllOwnerToDerivedKeyMappings(handle *badger.DB, ownerPublicKey PublicK
 	var derivedEntries []*DerivedKeyEntry
 	for _, keyBytes := range valsFound {
 		derivedKeyEntry := &DerivedKeyEntry{}
-		rr := bytes.NewReader(keyBytes)
-		DecodeFromBytes(derivedKeyEntry, rr)
+		DecodeFromBytesCompat(derivedKeyEntry, keyBytes)
 		derivedEntries = append(derivedEntries, derivedKeyEntry)
 	}
 
@@ -7035,6 +10991,113 @@ func DBGetPKIDForUsername(db *badger.DB, snap *Snapshot, username []byte) *PKID
 	return ret
 }
 
+// UsernameCollision is a set of distinct username keys that fold to the same username. Username
+// keys are only lowercased, so characters with special case mappings, e.g. the long s in "ſam",
+// let a second profile squat on a username that looks the same as an existing one.
+type UsernameCollision struct {
+	FoldedUsername string
+	Usernames      []string
+	PKIDs          []*PKID
+}
+
+// _foldUsername maps every username that only differs by case, special case mappings included,
+// to the same string.
+func _foldUsername(username string) string {
+	return strings.ToLower(strings.ToUpper(username))
+}
+
+// _addUsernameCollisions appends a collision for every folded username with more than one key.
+// The collisions come out in the order their folded usernames were first seen.
+func _addUsernameCollisions(collisions []*UsernameCollision, foldedUsernames []string,
+	keysByFoldedUsername map[string][]string, pkidsByKey map[string]*PKID) []*UsernameCollision {
+
+	for _, foldedUsername := range foldedUsernames {
+		keys := keysByFoldedUsername[foldedUsername]
+		if len(keys) < 2 {
+			continue
+		}
+		collision := &UsernameCollision{FoldedUsername: foldedUsername}
+		for _, key := range keys {
+			collision.Usernames = append(collision.Usernames, key)
+			collision.PKIDs = append(collision.PKIDs, pkidsByKey[key])
+		}
+		collisions = append(collisions, collision)
+	}
+	return collisions
+}
+
+// DBGetPKIDsForUsernames resolves the usernames case-insensitively in one txn. The returned map is
+// keyed by the usernames as they were passed in, and leaves out the ones without a profile. If
+// some of the usernames resolve to different profiles but fold to the same username, they're
+// reported as collisions.
+func DBGetPKIDsForUsernames(db *badger.DB, snap *Snapshot, usernames []string) (
+	_pkidsByUsername map[string]*PKID, _collisions []*UsernameCollision, _err error) {
+
+	pkidsByUsername := make(map[string]*PKID)
+	pkidsByKey := make(map[string]*PKID)
+	keysByFoldedUsername := make(map[string][]string)
+	foldedUsernames := []string{}
+	err := db.View(func(txn *badger.Txn) error {
+		for _, username := range usernames {
+			// Lowercase each username once, the same way _dbKeyForProfileUsernameToPKID does.
+			key := strings.ToLower(username)
+			pkid, alreadyFetched := pkidsByKey[key]
+			if !alreadyFetched {
+				pkid = DBGetPKIDForUsernameWithTxn(txn, snap, []byte(key))
+				pkidsByKey[key] = pkid
+				if pkid != nil {
+					foldedUsername := _foldUsername(key)
+					if _, exists := keysByFoldedUsername[foldedUsername]; !exists {
+						foldedUsernames = append(foldedUsernames, foldedUsername)
+					}
+					keysByFoldedUsername[foldedUsername] = append(keysByFoldedUsername[foldedUsername], key)
+				}
+			}
+			if pkid != nil {
+				pkidsByUsername[username] = pkid
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "DBGetPKIDsForUsernames: ")
+	}
+	return pkidsByUsername, _addUsernameCollisions(nil, foldedUsernames, keysByFoldedUsername, pkidsByKey), nil
+}
+
+// DBScanUsernameCollisions scans every username key for collisions. Since UsernameRegex only allows
+// ASCII, a collision means some key was written without going through the username checks.
+func DBScanUsernameCollisions(db *badger.DB) ([]*UsernameCollision, error) {
+	pkidsByKey := make(map[string]*PKID)
+	keysByFoldedUsername := make(map[string][]string)
+	foldedUsernames := []string{}
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = Prefixes.PrefixProfileUsernameToPKID
+		iterator := txn.NewIterator(opts)
+		defer iterator.Close()
+
+		for iterator.Rewind(); iterator.ValidForPrefix(Prefixes.PrefixProfileUsernameToPKID); iterator.Next() {
+			key := string(iterator.Item().Key()[len(Prefixes.PrefixProfileUsernameToPKID):])
+			pkidBytes, err := iterator.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			pkidsByKey[key] = PublicKeyToPKID(pkidBytes)
+			foldedUsername := _foldUsername(key)
+			if _, exists := keysByFoldedUsername[foldedUsername]; !exists {
+				foldedUsernames = append(foldedUsernames, foldedUsername)
+			}
+			keysByFoldedUsername[foldedUsername] = append(keysByFoldedUsername[foldedUsername], key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DBScanUsernameCollisions: Problem scanning usernames")
+	}
+	return _addUsernameCollisions(nil, foldedUsernames, keysByFoldedUsername, pkidsByKey), nil
+}
+
 func DBGetProfileEntryForUsernameWithTxn(txn *badger.Txn,
 	snap *Snapshot, username []byte) *ProfileEntry {
 
@@ -7079,29 +11142,125 @@ func DBGetProfileEntryForPKID(db *badger.DB, snap *Snapshot, pkid *PKID) *Profil
 	return ret
 }
 
-func DBDeleteProfileEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
-	pkid *PKID, params *DeSoParams) error {
+func _dbKeyForProfileModifiedHeightAndPKID(blockHeight uint64, pkid *PKID) []byte {
+	prefixCopy := append([]byte{}, Prefixes.PrefixProfileModifiedHeightAndPKID...)
+	key := append(prefixCopy, EncodeUint64(blockHeight)...)
+	key = append(key, pkid[:]...)
+	return key
+}
 
-	// First pull up the mapping that exists for the profile pub key passed in.
-	// If one doesn't exist then there's nothing to do.
-	profileEntry := DBGetProfileEntryForPKIDWithTxn(txn, snap, pkid)
-	if profileEntry == nil {
-		return nil
+func _dbKeyForPKIDToProfileModifiedHeight(pkid *PKID) []byte {
+	prefixCopy := append([]byte{}, Prefixes.PrefixPKIDToProfileModifiedHeight...)
+	return append(prefixCopy, pkid[:]...)
+}
+
+// DBPutProfileModifiedHeightWithTxn records that the profile for the PKID was modified at blockHeight,
+// replacing whatever height it was last modified at.
+func DBPutProfileModifiedHeightWithTxn(txn *badger.Txn, snap *Snapshot, pkid *PKID, blockHeight uint64) error {
+	heightBytes, err := DBGetWithTxn(txn, snap, _dbKeyForPKIDToProfileModifiedHeight(pkid))
+	if err == nil {
+		if err := DBDeleteWithTxn(txn, snap, _dbKeyForProfileModifiedHeightAndPKID(
+			DecodeUint64(heightBytes), pkid)); err != nil {
+			return errors.Wrapf(err, "DBPutProfileModifiedHeightWithTxn: Problem deleting previous "+
+				"height for PKID %v", pkid)
+		}
+	} else if err != badger.ErrKeyNotFound {
+		return errors.Wrapf(err, "DBPutProfileModifiedHeightWithTxn: Problem getting previous "+
+			"height for PKID %v", pkid)
 	}
 
-	// When a profile exists, delete the pkid mapping for the profile.
-	if err := DBDeleteWithTxn(txn, snap, _dbKeyForPKIDToProfileEntry(pkid)); err != nil {
-		return errors.Wrapf(err, "DbDeleteProfileEntryMappingsWithTxn: Deleting "+
-			"profile mapping for profile PKID: %v",
-			PkToString(pkid[:], params))
+	if err := DBSetWithTxn(txn, snap, _dbKeyForProfileModifiedHeightAndPKID(blockHeight, pkid), []byte{}); err != nil {
+		return errors.Wrapf(err, "DBPutProfileModifiedHeightWithTxn: Problem adding height "+
+			"mapping for PKID %v", pkid)
+	}
+	if err := DBSetWithTxn(txn, snap, _dbKeyForPKIDToProfileModifiedHeight(pkid), EncodeUint64(blockHeight)); err != nil {
+		return errors.Wrapf(err, "DBPutProfileModifiedHeightWithTxn: Problem adding PKID "+
+			"mapping for PKID %v", pkid)
 	}
+	return nil
+}
 
-	if err := DBDeleteWithTxn(txn, snap,
-		_dbKeyForProfileUsernameToPKID(profileEntry.Username)); err != nil {
+// ProfileModification is a profile returned by DbGetProfilesModifiedSince.
+type ProfileModification struct {
+	PKID           *PKID
+	ModifiedHeight uint64
+	// ProfileEntry is the current profile, or nil if the profile was deleted.
+	ProfileEntry *ProfileEntry
+}
 
-		return errors.Wrapf(err, "DbDeleteProfileEntryMappingsWithTxn: Deleting "+
-			"username mapping for profile username %v", string(profileEntry.Username))
-	}
+// DbGetProfilesModifiedSince returns the profiles that were modified after sinceHeight, in the order they
+// were last modified. Each profile appears once, at the last height it was modified. At most limit profiles
+// are returned. Pass a nil startKey for the first page, and the returned nextStartKey for the following
+// ones; a nil nextStartKey means there are no more profiles.
+func DbGetProfilesModifiedSince(handle *badger.DB, snap *Snapshot, sinceHeight uint64, startKey []byte,
+	limit int) (_profiles []*ProfileModification, _nextStartKey []byte, _err error) {
+
+	if limit <= 0 {
+		return nil, nil, fmt.Errorf("DbGetProfilesModifiedSince: Limit must be positive, got %v", limit)
+	}
+	prefix := Prefixes.PrefixProfileModifiedHeightAndPKID
+	if len(startKey) == 0 {
+		startKey = _dbKeyForProfileModifiedHeightAndPKID(sinceHeight+1, &PKID{})
+	} else if !bytes.HasPrefix(startKey, prefix) {
+		return nil, nil, fmt.Errorf("DbGetProfilesModifiedSince: Invalid start key %v", startKey)
+	}
+
+	var profiles []*ProfileModification
+	var nextStartKey []byte
+	err := handle.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(startKey); it.ValidForPrefix(prefix); it.Next() {
+			key := it.Item().Key()
+			if len(profiles) == limit {
+				nextStartKey = it.Item().KeyCopy(nil)
+				break
+			}
+			if len(key) != len(prefix)+8+btcec.PubKeyBytesLenCompressed {
+				return fmt.Errorf("Invalid key length %v for key %v", len(key), key)
+			}
+			pkid := &PKID{}
+			copy(pkid[:], key[len(prefix)+8:])
+			profiles = append(profiles, &ProfileModification{
+				PKID:           pkid,
+				ModifiedHeight: DecodeUint64(key[len(prefix) : len(prefix)+8]),
+				ProfileEntry:   DBGetProfileEntryForPKIDWithTxn(txn, snap, pkid),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "DbGetProfilesModifiedSince: ")
+	}
+	return profiles, nextStartKey, nil
+}
+
+func DBDeleteProfileEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
+	pkid *PKID, params *DeSoParams) error {
+
+	// First pull up the mapping that exists for the profile pub key passed in.
+	// If one doesn't exist then there's nothing to do.
+	profileEntry := DBGetProfileEntryForPKIDWithTxn(txn, snap, pkid)
+	if profileEntry == nil {
+		return nil
+	}
+
+	// When a profile exists, delete the pkid mapping for the profile.
+	if err := DBDeleteWithTxn(txn, snap, _dbKeyForPKIDToProfileEntry(pkid)); err != nil {
+		return errors.Wrapf(err, "DbDeleteProfileEntryMappingsWithTxn: Deleting "+
+			"profile mapping for profile PKID: %v",
+			PkToString(pkid[:], params))
+	}
+
+	if err := DBDeleteWithTxn(txn, snap,
+		_dbKeyForProfileUsernameToPKID(profileEntry.Username)); err != nil {
+
+		return errors.Wrapf(err, "DbDeleteProfileEntryMappingsWithTxn: Deleting "+
+			"username mapping for profile username %v", string(profileEntry.Username))
+	}
 
 	// The coin deso mapping
 	if err := DBDeleteWithTxn(txn, snap,
@@ -7331,6 +11490,14 @@ func DBDeleteBalanceEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 			"mappings with keys: %v %v",
 			PkToStringBoth(hodlerPKID[:]), PkToStringBoth(creatorPKID[:]))
 	}
+	if !balanceEntry.BalanceNanos.IsZero() {
+		if err := DBDeleteWithTxn(txn, snap, _dbKeyForCreatorPKIDBalanceNanosHODLerPKID(
+			creatorPKID, &balanceEntry.BalanceNanos, hodlerPKID, isDAOCoin)); err != nil {
+			return errors.Wrapf(err, "DBDeleteBalanceEntryMappingsWithTxn: Deleting "+
+				"holder leaderboard mapping with keys: %v %v",
+				PkToStringBoth(hodlerPKID[:]), PkToStringBoth(creatorPKID[:]))
+		}
+	}
 
 	// Note: We don't update the CreatorDeSoLockedNanosCreatorPubKeyIIndex
 	// because we expect that the caller is keeping the individual holdings in
@@ -7380,6 +11547,19 @@ func DBPutBalanceEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeig
 			PkToStringBoth(balanceEntry.CreatorPKID[:]))
 	}
 
+	// Add the holder to the coin's leaderboard.
+	if !balanceEntry.BalanceNanos.IsZero() {
+		if err := DBSetWithTxn(txn, snap, _dbKeyForCreatorPKIDBalanceNanosHODLerPKID(
+			balanceEntry.CreatorPKID, &balanceEntry.BalanceNanos, balanceEntry.HODLerPKID, isDAOCoin),
+			[]byte{}); err != nil {
+
+			return errors.Wrapf(err, "DBPutBalanceEntryMappingsWithTxn: Problem "+
+				"adding holder leaderboard mapping for pub keys: %v %v",
+				PkToStringBoth(balanceEntry.HODLerPKID[:]),
+				PkToStringBoth(balanceEntry.CreatorPKID[:]))
+		}
+	}
+
 	return nil
 }
 
@@ -7392,33 +11572,6 @@ func DBPutBalanceEntryMappings(handle *badger.DB, snap *Snapshot, blockHeight ui
 	})
 }
 
-// GetSingleBalanceEntryFromPublicKeys fetches a single balance entry of a holder's creator or DAO coin.
-// Returns nil if the balance entry never existed.
-// TODO: This is suboptimal, shouldn't be passing UtxoView
-func GetSingleBalanceEntryFromPublicKeys(holder []byte, creator []byte, utxoView *UtxoView, isDAOCoin bool) (*BalanceEntry, error) {
-	holderPKIDEntry := utxoView.GetPKIDForPublicKey(holder)
-	if holderPKIDEntry == nil || holderPKIDEntry.isDeleted {
-		return nil, fmt.Errorf("DbGetSingleBalanceEntryFromPublicKeys: holderPKID was nil or deleted; this should never happen")
-	}
-	holderPKID := holderPKIDEntry.PKID
-	creatorPKIDEntry := utxoView.GetPKIDForPublicKey(creator)
-	if creatorPKIDEntry == nil || creatorPKIDEntry.isDeleted {
-		return nil, fmt.Errorf("DbGetSingleBalanceEntryFromPublicKeys: creatorPKID was nil or deleted; this should never happen")
-	}
-	creatorPKID := creatorPKIDEntry.PKID
-
-	// Check if there's a balance entry in the view
-	balanceEntryMapKey := MakeBalanceEntryKey(holderPKID, creatorPKID)
-	balanceEntryFromView := utxoView.GetHODLerPKIDCreatorPKIDToBalanceEntryMap(isDAOCoin)[balanceEntryMapKey]
-	if balanceEntryFromView != nil {
-		return balanceEntryFromView, nil
-	}
-
-	// Check if there's a balance entry in the database
-	balanceEntryFromDb := DbGetBalanceEntry(utxoView.Handle, utxoView.Snapshot, holderPKID, creatorPKID, isDAOCoin)
-	return balanceEntryFromDb, nil
-}
-
 // DbGetBalanceEntry returns a balance entry from the database
 func DbGetBalanceEntry(db *badger.DB, snap *Snapshot,
 	holder *PKID, creator *PKID, isDAOCoin bool) *BalanceEntry {
@@ -7493,6 +11646,179 @@ func DbGetBalanceEntriesHodlingYou(db *badger.DB, snap *Snapshot, pkid *PKID, fi
 	return balanceEntriesThatHodlYou, nil
 }
 
+// -------------------------------------------------------------------------------------
+// Holder leaderboard mapping functions
+// <prefix_id, creatorPKID [33]byte, MaxUint256 - balanceNanos [32]byte, hodlerPKID [33]byte> -> <>
+// -------------------------------------------------------------------------------------
+
+func _dbGetPrefixForCreatorPKIDBalanceNanosHODLerPKID(isDAOCoin bool) []byte {
+	if isDAOCoin {
+		return Prefixes.PrefixCreatorPKIDDAOCoinBalanceNanosHODLerPKID
+	} else {
+		return Prefixes.PrefixCreatorPKIDBalanceNanosHODLerPKID
+	}
+}
+
+func _dbKeyForCreatorPKIDBalanceNanosHODLerPKID(creatorPKID *PKID, balanceNanos *uint256.Int,
+	hodlerPKID *PKID, isDAOCoin bool) []byte {
+
+	key := append([]byte{}, _dbGetPrefixForCreatorPKIDBalanceNanosHODLerPKID(isDAOCoin)...)
+	key = append(key, creatorPKID[:]...)
+	// Store MaxUint256 - balanceNanos so that the biggest holders come first.
+	invertedBalanceNanos := uint256.NewInt().Sub(MaxUint256, balanceNanos).Bytes32()
+	key = append(key, invertedBalanceNanos[:]...)
+	key = append(key, hodlerPKID[:]...)
+	return key
+}
+
+// DbGetTopHoldersForCreatorPKID returns up to limit BalanceEntries of the creator's coin, biggest
+// balance first. A limit of zero returns all of them. Pagination starts at startKey, which is
+// either empty or the _nextStartKey returned for the previous page. _nextStartKey is nil once
+// there are no more holders.
+func DbGetTopHoldersForCreatorPKID(handle *badger.DB, snap *Snapshot, creatorPKID *PKID,
+	isDAOCoin bool, limit int, startKey []byte) (
+	_balanceEntries []*BalanceEntry, _nextStartKey []byte, _err error) {
+
+	prefix := append([]byte{}, _dbGetPrefixForCreatorPKIDBalanceNanosHODLerPKID(isDAOCoin)...)
+	prefix = append(prefix, creatorPKID[:]...)
+	if len(startKey) == 0 {
+		startKey = prefix
+	} else if !bytes.HasPrefix(startKey, prefix) {
+		return nil, nil, fmt.Errorf("DbGetTopHoldersForCreatorPKID: Start key %v isn't a "+
+			"leaderboard key for creator %v", startKey, PkToStringBoth(creatorPKID[:]))
+	}
+	keyLen := len(prefix) + 32 + btcec.PubKeyBytesLenCompressed
+	numToFetch := 0
+	if limit > 0 {
+		// Fetch one extra key so we know where the next page starts.
+		numToFetch = limit + 1
+	}
+
+	var balanceEntries []*BalanceEntry
+	var nextStartKey []byte
+	err := handle.View(func(txn *badger.Txn) error {
+		keysFound, _, err := DBGetPaginatedKeysAndValuesForPrefixWithTxn(
+			txn, startKey, prefix, keyLen, numToFetch, false /*reverse*/, false /*fetchValues*/)
+		if err != nil {
+			return err
+		}
+		if limit > 0 && len(keysFound) > limit {
+			nextStartKey = keysFound[limit]
+			keysFound = keysFound[:limit]
+		}
+		keyCodec := MustGetKeyCodec(_dbGetPrefixForCreatorPKIDBalanceNanosHODLerPKID(isDAOCoin))
+		for _, keyBytes := range keysFound {
+			decodedKey, err := keyCodec.Decode(keyBytes)
+			if err != nil {
+				return errors.Wrapf(err, "DbGetTopHoldersForCreatorPKID: ")
+			}
+			hodlerPKID := decodedKey.PKID("HODLerPKID")
+			balanceEntry := DBGetBalanceEntryForCreatorPKIDAndHODLerPubKeyWithTxn(
+				txn, snap, creatorPKID, hodlerPKID, isDAOCoin)
+			if balanceEntry == nil {
+				return fmt.Errorf("Holder %v of creator %v doesn't have a BalanceEntry",
+					PkToStringBoth(hodlerPKID[:]), PkToStringBoth(creatorPKID[:]))
+			}
+			balanceEntries = append(balanceEntries, balanceEntry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "DbGetTopHoldersForCreatorPKID: ")
+	}
+	return balanceEntries, nextStartKey, nil
+}
+
+func DbIsHolderLeaderboardsBackfilled(handle *badger.DB) bool {
+	var isBackfilled bool
+	handle.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(Prefixes.PrefixHolderLeaderboardsBackfilled)
+		isBackfilled = err == nil
+		return nil
+	})
+	return isBackfilled
+}
+
+// DbBackfillHolderLeaderboards rebuilds the creator coin and DAO coin holder leaderboards from the
+// BalanceEntries in the db, and marks them as backfilled. It must not run concurrently with
+// balance flushes.
+func DbBackfillHolderLeaderboards(handle *badger.DB, snap *Snapshot) error {
+	// Write the keys in batches so that we don't exceed badger's txn size limits.
+	writeKeys := func(keys [][]byte, isDelete bool) error {
+		for start := 0; start < len(keys); start += HolderLeaderboardMigrationBatchSize {
+			end := start + HolderLeaderboardMigrationBatchSize
+			if end > len(keys) {
+				end = len(keys)
+			}
+			err := handle.Update(func(txn *badger.Txn) error {
+				for _, key := range keys[start:end] {
+					if isDelete {
+						if err := DBDeleteWithTxn(txn, snap, key); err != nil {
+							return err
+						}
+					} else if err := DBSetWithTxn(txn, snap, key, []byte{}); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	numHolders := 0
+	for _, isDAOCoin := range []bool{false, true} {
+		// Drop the existing leaderboard, since some of its holders might not hold the coin anymore.
+		leaderboardPrefix := _dbGetPrefixForCreatorPKIDBalanceNanosHODLerPKID(isDAOCoin)
+		staleKeys, _, err := DBGetPaginatedKeysAndValuesForPrefix(handle, leaderboardPrefix, leaderboardPrefix,
+			0 /*keyLen*/, 0 /*numToFetch*/, false /*reverse*/, false /*fetchValues*/)
+		if err != nil {
+			return errors.Wrapf(err, "DbBackfillHolderLeaderboards: Problem reading existing leaderboard")
+		}
+		if err = writeKeys(staleKeys, true /*isDelete*/); err != nil {
+			return errors.Wrapf(err, "DbBackfillHolderLeaderboards: Problem deleting existing leaderboard")
+		}
+
+		var leaderboardKeysMtx sync.Mutex
+		var leaderboardKeys [][]byte
+		err = ParallelScanPrefix(handle, _dbGetPrefixForCreatorPKIDHODLerPKIDToBalanceEntry(isDAOCoin),
+			runtime.GOMAXPROCS(0), func(key []byte, value []byte) error {
+				balanceEntry := &BalanceEntry{}
+				if exists, err := DecodeFromBytes(balanceEntry, bytes.NewReader(value)); !exists || err != nil {
+					return fmt.Errorf("Problem decoding balance entry for key %v: %v", key, err)
+				}
+				if balanceEntry.BalanceNanos.IsZero() {
+					return nil
+				}
+				leaderboardKey := _dbKeyForCreatorPKIDBalanceNanosHODLerPKID(
+					balanceEntry.CreatorPKID, &balanceEntry.BalanceNanos, balanceEntry.HODLerPKID, isDAOCoin)
+				leaderboardKeysMtx.Lock()
+				defer leaderboardKeysMtx.Unlock()
+				leaderboardKeys = append(leaderboardKeys, leaderboardKey)
+				return nil
+			})
+		if err != nil {
+			return errors.Wrapf(err, "DbBackfillHolderLeaderboards: Problem scanning balance entries")
+		}
+		if err = writeKeys(leaderboardKeys, false /*isDelete*/); err != nil {
+			return errors.Wrapf(err, "DbBackfillHolderLeaderboards: Problem writing leaderboard")
+		}
+		numHolders += len(leaderboardKeys)
+	}
+
+	err := handle.Update(func(txn *badger.Txn) error {
+		return txn.Set(Prefixes.PrefixHolderLeaderboardsBackfilled, []byte{})
+	})
+	if err != nil {
+		return errors.Wrapf(err, "DbBackfillHolderLeaderboards: Problem marking leaderboards as backfilled")
+	}
+	glog.Infof("DbBackfillHolderLeaderboards: Backfilled %v coin holders", numHolders)
+	return nil
+}
+
 // =====================================================================================
 // End coin balance entry code
 // =====================================================================================
@@ -7522,6 +11848,13 @@ func DBGetPaginatedKeysAndValuesForPrefixWithTxn(
 	keysFound := [][]byte{}
 	valsFound := [][]byte{}
 
+	_, dbSpan := StartDBSpan(context.Background(), "DBGetPaginatedKeysAndValuesForPrefix", validForPrefix,
+		attribute.Bool("db.scan.reverse", reverse))
+	defer func() {
+		dbSpan.SetAttributes(attribute.Int("db.scan.entries", len(keysFound)))
+		dbSpan.End(_err)
+	}()
+
 	opts := badger.DefaultIteratorOptions
 
 	opts.PrefetchValues = fetchValues
@@ -7643,83 +11976,83 @@ func DBGetPaginatedPostsOrderedByTime(
 	}
 
 	// Fetch the PostEntries if desired.
-	var postEntries []*PostEntry
-	if fetchPostEntries {
-		for _, postHash := range postHashes {
-			postEntry := DBGetPostEntryByPostHash(db, snap, postHash)
-			if postEntry == nil {
-				return nil, nil, nil, fmt.Errorf("DBGetPaginatedPostsOrderedByTime: "+
-					"PostHash %v does not have corresponding entry", postHash)
-			}
-			postEntries = append(postEntries, postEntry)
-		}
+	if !fetchPostEntries {
+		return postHashes, tstamps, nil, nil
+	}
+	postEntries, indexes, err := DBGetPostEntriesForPostHashes(db, snap, postHashes)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("DBGetPaginatedPostsOrderedByTime: %v", err)
+	}
+	postHashesKept := []*BlockHash{}
+	tstampsKept := []uint64{}
+	for _, index := range indexes {
+		postHashesKept = append(postHashesKept, postHashes[index])
+		tstampsKept = append(tstampsKept, tstamps[index])
 	}
 
-	return postHashes, tstamps, postEntries, nil
+	return postHashesKept, tstampsKept, postEntries, nil
 }
 
-func DBGetProfilesByUsernamePrefixAndDeSoLocked(db *badger.DB,
-	snap *Snapshot, usernamePrefix string, utxoView *UtxoView) (
-	_profileEntries []*ProfileEntry, _err error) {
+// DBGetPaginatedPostsOrderedByLikeCount returns the posts ordered by like count, starting at the post
+// with startLikeCount and startPostHash. Pass reverse to get the most liked posts first, and pass
+// startLikeCount = 0 and startPostHash = nil to start from the beginning of the index in either
+// direction. Comments aren't included.
+func DBGetPaginatedPostsOrderedByLikeCount(
+	db *badger.DB, snap *Snapshot, startLikeCount uint64,
+	startPostHash *BlockHash, numToFetch int, fetchPostEntries bool, reverse bool) (
+	_postHashes []*BlockHash, _likeCounts []uint64, _postEntries []*PostEntry,
+	_err error) {
 
-	startPrefix := append([]byte{}, Prefixes.PrefixProfileUsernameToPKID...)
-	lowercaseUsernamePrefixString := strings.ToLower(usernamePrefix)
-	lowercaseUsernamePrefix := []byte(lowercaseUsernamePrefixString)
-	startPrefix = append(startPrefix, lowercaseUsernamePrefix...)
+	startPostPrefix := append([]byte{}, Prefixes.PrefixLikeCountPostHash...)
 
-	_, pkidsFound, err := DBGetPaginatedKeysAndValuesForPrefix(
-		db /*db*/, startPrefix, /*startPrefix*/
-		startPrefix /*validForPrefix*/, 0, /*keyLen (ignored when reverse == false)*/
-		0 /*numToFetch (zero fetches all)*/, false, /*reverse*/
-		true /*fetchValues*/)
-	if err != nil {
-		return nil, fmt.Errorf("DBGetProfilesByUsernamePrefixAndDeSoLocked: %v", err)
+	if startLikeCount > 0 || startPostHash != nil {
+		startPostPrefix = append(startPostPrefix, EncodeUint64(startLikeCount)...)
 	}
 
-	// Have to do this to convert the PKIDs back into public keys
-	// TODO: We should clean things up around public keys vs PKIDs
-	pubKeysMap := make(map[PkMapKey][]byte)
-	for _, pkidBytesIter := range pkidsFound {
-		pkidBytes := pkidBytesIter
-		if len(pkidBytes) != btcec.PubKeyBytesLenCompressed {
-			continue
-		}
-		pkid := &PKID{}
-		copy(pkid[:], pkidBytes)
-		pubKey := DBGetPublicKeyForPKID(db, snap, pkid)
-		if len(pubKey) != 0 {
-			pubKeysMap[MakePkMapKey(pubKey)] = pubKey
-		}
+	if startPostHash != nil {
+		startPostPrefix = append(startPostPrefix, startPostHash[:]...)
 	}
 
-	for username, profileEntry := range utxoView.ProfileUsernameToProfileEntry {
-		if strings.HasPrefix(string(username[:]), lowercaseUsernamePrefixString) {
-			pkMapKey := MakePkMapKey(profileEntry.PublicKey)
-			pubKeysMap[pkMapKey] = profileEntry.PublicKey
-		}
+	likeCountLen := 8
+	postIndexKeys, _, err := DBGetPaginatedKeysAndValuesForPrefix(
+		db, startPostPrefix, Prefixes.PrefixLikeCountPostHash, /*validForPrefix*/
+		len(Prefixes.PrefixLikeCountPostHash)+likeCountLen+HashSizeBytes, /*keyLen*/
+		numToFetch, reverse /*reverse*/, false /*fetchValues*/)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("DBGetPaginatedPostsOrderedByLikeCount: %v", err)
 	}
 
-	// Sigh.. convert the public keys *back* into PKIDs...
-	profilesFound := []*ProfileEntry{}
-	for _, pkIter := range pubKeysMap {
-		pk := pkIter
-		pkid := utxoView.GetPKIDForPublicKey(pk).PKID
-		profile := utxoView.GetProfileEntryForPKID(pkid)
-		// Double-check that a username matches the prefix.
-		// If a user had the handle "elon" and then changed to "jeff" and that transaction hadn't mined yet,
-		// we would return the profile for "jeff" when we search for "elon" which is incorrect.
-		if profile != nil && strings.HasPrefix(strings.ToLower(string(profile.Username[:])), lowercaseUsernamePrefixString) {
-			profilesFound = append(profilesFound, profile)
-		}
+	// Cut the post hashes and like counts out of the returned keys.
+	postHashes := []*BlockHash{}
+	likeCounts := []uint64{}
+	startLikeCountIndex := len(Prefixes.PrefixLikeCountPostHash)
+	hashStartIndex := startLikeCountIndex + likeCountLen
+	hashEndIndex := hashStartIndex + HashSizeBytes
+	for _, postKeyBytes := range postIndexKeys {
+		currentPostHash := &BlockHash{}
+		copy(currentPostHash[:], postKeyBytes[hashStartIndex:hashEndIndex])
+		postHashes = append(postHashes, currentPostHash)
+
+		likeCounts = append(likeCounts, DecodeUint64(
+			postKeyBytes[startLikeCountIndex:hashStartIndex]))
 	}
 
-	// If there is no error, sort and return numToFetch. Username searches are always
-	// sorted by coin value.
-	sort.Slice(profilesFound, func(ii, jj int) bool {
-		return profilesFound[ii].CreatorCoinEntry.DeSoLockedNanos > profilesFound[jj].CreatorCoinEntry.DeSoLockedNanos
-	})
+	// Fetch the PostEntries if desired.
+	if !fetchPostEntries {
+		return postHashes, likeCounts, nil, nil
+	}
+	postEntries, indexes, err := DBGetPostEntriesForPostHashes(db, snap, postHashes)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("DBGetPaginatedPostsOrderedByLikeCount: %v", err)
+	}
+	postHashesKept := []*BlockHash{}
+	likeCountsKept := []uint64{}
+	for _, index := range indexes {
+		postHashesKept = append(postHashesKept, postHashes[index])
+		likeCountsKept = append(likeCountsKept, likeCounts[index])
+	}
 
-	return profilesFound, nil
+	return postHashesKept, likeCountsKept, postEntries, nil
 }
 
 // DBGetPaginatedProfilesByDeSoLocked returns up to 'numToFetch' profiles from the db.
@@ -7829,6 +12162,13 @@ func DBKeyForDAOCoinLimitOrderByOrderID(order *DAOCoinLimitOrderEntry) []byte {
 	return key
 }
 
+func DBKeyForDAOCoinLimitOrderByExpirationBlockHeight(order *DAOCoinLimitOrderEntry) []byte {
+	key := append([]byte{}, Prefixes.PrefixDAOCoinLimitOrderByExpirationBlockHeight...)
+	key = append(key, _EncodeUint32(order.ExpirationBlockHeight)...)
+	key = append(key, order.OrderID.ToBytes()...)
+	return key
+}
+
 func DBGetDAOCoinLimitOrder(handle *badger.DB, snap *Snapshot, orderID *BlockHash) (
 	*DAOCoinLimitOrderEntry, error) {
 
@@ -7868,113 +12208,116 @@ func DBGetDAOCoinLimitOrderWithTxn(txn *badger.Txn, snap *Snapshot, orderID *Blo
 	return order, nil
 }
 
-func DBGetMatchingDAOCoinLimitOrders(
-	txn *badger.Txn, inputOrder *DAOCoinLimitOrderEntry, lastSeenOrder *DAOCoinLimitOrderEntry,
-	orderEntriesInView map[DAOCoinLimitOrderMapKey]bool) ([]*DAOCoinLimitOrderEntry, error) {
+func DBGetAllDAOCoinLimitOrders(handle *badger.DB) ([]*DAOCoinLimitOrderEntry, error) {
+	// Get all DAO Coin limit orders.
+	key := append([]byte{}, Prefixes.PrefixDAOCoinLimitOrder...)
+	return _DBGetAllDAOCoinLimitOrdersByPrefix(handle, key)
+}
+
+func DBGetAllDAOCoinLimitOrdersForThisDAOCoinPair(
+	handle *badger.DB,
+	buyingDAOCoinCreatorPKID *PKID,
+	sellingDAOCoinCreatorPKID *PKID) ([]*DAOCoinLimitOrderEntry, error) {
+
+	// Get all DAO coin limit orders for this DAO coin pair.
+	key := append([]byte{}, Prefixes.PrefixDAOCoinLimitOrder...)
+	key = append(key, buyingDAOCoinCreatorPKID.ToBytes()...)
+	key = append(key, sellingDAOCoinCreatorPKID.ToBytes()...)
+	return _DBGetAllDAOCoinLimitOrdersByPrefix(handle, key)
+}
 
-	queryOrder := inputOrder.Copy()
-	queryQuantityToFill := queryOrder.QuantityToFillInBaseUnits.Clone()
+// DBGetPaginatedDAOCoinLimitOrdersForThisDAOCoinPair returns up to maxOrders orders for this
+// DAO coin pair, best first, i.e. sorted by IsBetterMatchingOrderThan. If startAfterOrder is
+// passed, results start with the order right after it. A maxOrders of zero means no limit.
+func DBGetPaginatedDAOCoinLimitOrdersForThisDAOCoinPair(
+	txn *badger.Txn,
+	buyingDAOCoinCreatorPKID *PKID,
+	sellingDAOCoinCreatorPKID *PKID,
+	startAfterOrder *DAOCoinLimitOrderEntry,
+	maxOrders int) ([]*DAOCoinLimitOrderEntry, error) {
 
-	// Convert the input BID order to the ASK order to query for.
-	// Note that we seek in reverse for the best matching orders.
-	//   * Swap BuyingDAOCoinCreatorPKID and SellingDAOCoinCreatorPKID.
-	//   * Set ScaledExchangeRateCoinsToSellPerCoinToBuy to MaxUint256.
-	//   * Set BlockHeight to 0 as this becomes math.MaxUint32 in the key.
-	//   * Set OrderID to MaxBlockHash.
-	queryOrder.BuyingDAOCoinCreatorPKID = inputOrder.SellingDAOCoinCreatorPKID
-	queryOrder.SellingDAOCoinCreatorPKID = inputOrder.BuyingDAOCoinCreatorPKID
-	queryOrder.ScaledExchangeRateCoinsToSellPerCoinToBuy = MaxUint256.Clone()
-	queryOrder.BlockHeight = uint32(0)
-	queryOrder.OrderID = maxHash.NewBlockHash()
+	// Seek in reverse from the best possible order for this pair, which
+	// has the highest price, the lowest block height and the max OrderID.
+	queryOrder := &DAOCoinLimitOrderEntry{
+		BuyingDAOCoinCreatorPKID:                  buyingDAOCoinCreatorPKID,
+		SellingDAOCoinCreatorPKID:                 sellingDAOCoinCreatorPKID,
+		ScaledExchangeRateCoinsToSellPerCoinToBuy: MaxUint256.Clone(),
+		BlockHeight: uint32(0),
+		OrderID:     maxHash.NewBlockHash(),
+	}
 
 	key := DBKeyForDAOCoinLimitOrder(queryOrder)
 	prefixKey := DBPrefixKeyForDAOCoinLimitOrder(queryOrder)
 
-	// If passed a last seen order, start seeking from there.
+	// If passed a start after order, start seeking from there. The
+	// order may no longer be in the DB, in which case the seek lands
+	// on the next order.
 	var startKey []byte
-	if lastSeenOrder != nil {
-		startKey = DBKeyForDAOCoinLimitOrder(lastSeenOrder)
+	if startAfterOrder != nil {
+		startKey = DBKeyForDAOCoinLimitOrder(startAfterOrder)
 		key = startKey
 	}
 
-	// Go in reverse order to find the highest prices first.
-	// We break once we hit the input order's inverted scaled
-	// price or the input order's quantity is fulfilled.
 	opts := badger.DefaultIteratorOptions
 	opts.Reverse = true
 	iterator := txn.NewIterator(opts)
 	defer iterator.Close()
 
-	// Seek first matching order.
-	matchingOrders := []*DAOCoinLimitOrderEntry{}
-
-	for iterator.Seek(key); iterator.ValidForPrefix(prefixKey) && queryQuantityToFill.GtUint64(0); iterator.Next() {
-		// If picking up from where you left off, skip the first order which
-		// has already been processed previously.
-		if len(startKey) != 0 && bytes.Equal(key, startKey) {
-			startKey = nil
-			continue
-		}
-
-		matchingOrderBytes, err := iterator.Item().ValueCopy(nil)
-		if err != nil {
-			return nil, errors.Wrapf(err, "DBGetMatchingDAOCoinLimitOrders: problem getting limit order")
-		}
+	orders := []*DAOCoinLimitOrderEntry{}
 
-		matchingOrder := &DAOCoinLimitOrderEntry{}
-		rr := bytes.NewReader(matchingOrderBytes)
-		if exist, err := DecodeFromBytes(matchingOrder, rr); !exist || err != nil {
-			return nil, errors.Wrapf(err, "DBGetMatchingDAOCoinLimitOrders: problem decoding limit order")
+	for iterator.Seek(key); iterator.ValidForPrefix(prefixKey); iterator.Next() {
+		if maxOrders > 0 && len(orders) >= maxOrders {
+			break
 		}
 
-		// Skip if order is already in the view.
-		if _, exists := orderEntriesInView[matchingOrder.ToMapKey()]; exists {
+		// Skip the start after order, which was on the previous page.
+		if len(startKey) != 0 && bytes.Equal(iterator.Item().Key(), startKey) {
 			continue
 		}
 
-		// Validate matching order's price.
-		if !inputOrder.IsValidMatchingOrderPrice(matchingOrder) {
-			break
+		orderBytes, err := iterator.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DBGetPaginatedDAOCoinLimitOrdersForThisDAOCoinPair: problem getting limit order")
 		}
 
-		// Calculate how the transactor's quantity to fill will change
-		// after being matched with this order. If the transactor still
-		// has quantity to fill, we loop.
-		queryQuantityToFill, _, _, _, err = _calculateDAOCoinsTransferredInLimitOrderMatch(
-			matchingOrder, queryOrder.OperationType, queryQuantityToFill)
-		if err != nil {
-			return nil, errors.Wrapf(err, "DBGetMatchingDAOCoinLimitOrders: ")
+		order := &DAOCoinLimitOrderEntry{}
+		rr := bytes.NewReader(orderBytes)
+		if exist, err := DecodeFromBytes(order, rr); !exist || err != nil {
+			return nil, errors.Wrapf(err, "DBGetPaginatedDAOCoinLimitOrdersForThisDAOCoinPair: problem decoding limit order")
 		}
 
-		matchingOrders = append(matchingOrders, matchingOrder)
+		orders = append(orders, order)
 	}
 
-	return matchingOrders, nil
+	return orders, nil
 }
 
-func DBGetAllDAOCoinLimitOrders(handle *badger.DB) ([]*DAOCoinLimitOrderEntry, error) {
-	// Get all DAO Coin limit orders.
-	key := append([]byte{}, Prefixes.PrefixDAOCoinLimitOrder...)
+func DBGetAllDAOCoinLimitOrdersForThisTransactor(handle *badger.DB, transactorPKID *PKID) ([]*DAOCoinLimitOrderEntry, error) {
+	// Get all DAO coin limit orders for this transactor.
+	key := append([]byte{}, Prefixes.PrefixDAOCoinLimitOrderByTransactorPKID...)
+	key = append(key, transactorPKID[:]...)
 	return _DBGetAllDAOCoinLimitOrdersByPrefix(handle, key)
 }
 
-func DBGetAllDAOCoinLimitOrdersForThisDAOCoinPair(
-	handle *badger.DB,
-	buyingDAOCoinCreatorPKID *PKID,
-	sellingDAOCoinCreatorPKID *PKID) ([]*DAOCoinLimitOrderEntry, error) {
-
-	// Get all DAO coin limit orders for this DAO coin pair.
-	key := append([]byte{}, Prefixes.PrefixDAOCoinLimitOrder...)
-	key = append(key, buyingDAOCoinCreatorPKID.ToBytes()...)
-	key = append(key, sellingDAOCoinCreatorPKID.ToBytes()...)
-	return _DBGetAllDAOCoinLimitOrdersByPrefix(handle, key)
-}
+func DBGetAllDAOCoinLimitOrdersForThisTransactorWithTxn(txn *badger.Txn, transactorPKID *PKID) (
+	[]*DAOCoinLimitOrderEntry, error) {
 
-func DBGetAllDAOCoinLimitOrdersForThisTransactor(handle *badger.DB, transactorPKID *PKID) ([]*DAOCoinLimitOrderEntry, error) {
-	// Get all DAO coin limit orders for this transactor.
 	key := append([]byte{}, Prefixes.PrefixDAOCoinLimitOrderByTransactorPKID...)
 	key = append(key, transactorPKID[:]...)
-	return _DBGetAllDAOCoinLimitOrdersByPrefix(handle, key)
+	_, valsFound, err := _enumerateKeysForPrefixWithTxn(txn, key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DBGetAllDAOCoinLimitOrdersForThisTransactorWithTxn: problem getting limit orders")
+	}
+	orders := []*DAOCoinLimitOrderEntry{}
+	for _, valBytes := range valsFound {
+		order := &DAOCoinLimitOrderEntry{}
+		rr := bytes.NewReader(valBytes)
+		if exist, err := DecodeFromBytes(order, rr); !exist || err != nil {
+			return nil, fmt.Errorf("DBGetAllDAOCoinLimitOrdersForThisTransactorWithTxn: problem decoding limit order: %v", err)
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
 }
 
 func _DBGetAllDAOCoinLimitOrdersByPrefix(handle *badger.DB, prefixKey []byte) ([]*DAOCoinLimitOrderEntry, error) {
@@ -8021,6 +12364,15 @@ func DBPutDAOCoinLimitOrderWithTxn(txn *badger.Txn, snap *Snapshot, order *DAOCo
 		return errors.Wrapf(err, "DBPutDAOCoinLimitOrderWithTxn: problem storing order in index PrefixDAOCoinLimitOrderByOrderID")
 	}
 
+	// Store in index: PrefixDAOCoinLimitOrderByExpirationBlockHeight
+	if order.ExpirationBlockHeight != 0 {
+		key = DBKeyForDAOCoinLimitOrderByExpirationBlockHeight(order)
+		if err := DBSetWithTxn(txn, snap, key, orderBytes); err != nil {
+			return errors.Wrapf(err, "DBPutDAOCoinLimitOrderWithTxn: problem storing order in index "+
+				"PrefixDAOCoinLimitOrderByExpirationBlockHeight")
+		}
+	}
+
 	return nil
 }
 
@@ -8047,188 +12399,2178 @@ func DBDeleteDAOCoinLimitOrderWithTxn(txn *badger.Txn, snap *Snapshot, order *DA
 		return errors.Wrapf(err, "DBDeleteDAOCoinLimitOrderWithTxn: problem deleting order from index PrefixDAOCoinLimitOrderByOrderID")
 	}
 
+	// Delete from index: PrefixDAOCoinLimitOrderByExpirationBlockHeight
+	if order.ExpirationBlockHeight != 0 {
+		key = DBKeyForDAOCoinLimitOrderByExpirationBlockHeight(order)
+		if err := DBDeleteWithTxn(txn, snap, key); err != nil {
+			return errors.Wrapf(err, "DBDeleteDAOCoinLimitOrderWithTxn: problem deleting order from index "+
+				"PrefixDAOCoinLimitOrderByExpirationBlockHeight")
+		}
+	}
+
 	return nil
 }
 
-// -------------------------------------------------------------------------------------
-// Mempool Txn mapping funcions
-// <prefix_id, txn hash BlockHash> -> <*MsgDeSoTxn>
-// -------------------------------------------------------------------------------------
-
-func _dbKeyForMempoolTxn(mempoolTx *MempoolTx) []byte {
-	// Make a copy to avoid multiple calls to this function re-using the same slice.
-	prefixCopy := append([]byte{}, Prefixes.PrefixMempoolTxnHashToMsgDeSoTxn...)
-	timeAddedBytes := EncodeUint64(uint64(mempoolTx.Added.UnixNano()))
-	key := append(prefixCopy, timeAddedBytes...)
-	key = append(key, mempoolTx.Hash[:]...)
+// DBGetDAOCoinLimitOrdersExpiringBeforeBlockHeightWithTxn returns the orders whose
+// ExpirationBlockHeight is less than blockHeight, sorted by ExpirationBlockHeight and
+// then OrderID.
+func DBGetDAOCoinLimitOrdersExpiringBeforeBlockHeightWithTxn(txn *badger.Txn, blockHeight uint32) (
+	[]*DAOCoinLimitOrderEntry, error) {
 
-	return key
-}
+	prefixKey := Prefixes.PrefixDAOCoinLimitOrderByExpirationBlockHeight
+	blockHeightBytes := _EncodeUint32(blockHeight)
 
-func DbPutMempoolTxnWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64, mempoolTx *MempoolTx) error {
+	opts := badger.DefaultIteratorOptions
+	iterator := txn.NewIterator(opts)
+	defer iterator.Close()
 
-	mempoolTxnBytes, err := mempoolTx.Tx.ToBytes(false /*preSignatureBool*/)
-	if err != nil {
-		return errors.Wrapf(err, "DbPutMempoolTxnWithTxn: Problem encoding mempoolTxn to bytes.")
-	}
+	orders := []*DAOCoinLimitOrderEntry{}
+	for iterator.Seek(prefixKey); iterator.ValidForPrefix(prefixKey); iterator.Next() {
+		key := iterator.Item().Key()
+		if bytes.Compare(key[len(prefixKey):len(prefixKey)+len(blockHeightBytes)], blockHeightBytes) >= 0 {
+			break
+		}
 
-	if err := DBSetWithTxn(txn, snap, _dbKeyForMempoolTxn(mempoolTx), mempoolTxnBytes); err != nil {
-		return errors.Wrapf(err, "DbPutMempoolTxnWithTxn: Problem putting mapping for txn hash: %s", mempoolTx.Hash.String())
-	}
+		orderBytes, err := iterator.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DBGetDAOCoinLimitOrdersExpiringBeforeBlockHeightWithTxn: problem getting limit order")
+		}
+		order := &DAOCoinLimitOrderEntry{}
+		rr := bytes.NewReader(orderBytes)
+		if exist, err := DecodeFromBytes(order, rr); !exist || err != nil {
+			return nil, errors.Wrapf(err, "DBGetDAOCoinLimitOrdersExpiringBeforeBlockHeightWithTxn: problem decoding limit order")
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
+
+// -------------------------------------------------------------------------------------
+// DAO coin limit trigger order mapping functions
+// -------------------------------------------------------------------------------------
+
+func DBPrefixKeyForDAOCoinLimitOrderTrigger(buyingDAOCoinCreatorPKID *PKID, sellingDAOCoinCreatorPKID *PKID) []byte {
+	key := append([]byte{}, Prefixes.PrefixDAOCoinLimitOrderTrigger...)
+	key = append(key, buyingDAOCoinCreatorPKID.ToBytes()...)
+	key = append(key, sellingDAOCoinCreatorPKID.ToBytes()...)
+	return key
+}
+
+func DBKeyForDAOCoinLimitOrderTrigger(triggerEntry *DAOCoinLimitOrderTriggerEntry) []byte {
+	key := DBPrefixKeyForDAOCoinLimitOrderTrigger(
+		triggerEntry.Order.BuyingDAOCoinCreatorPKID, triggerEntry.Order.SellingDAOCoinCreatorPKID)
+	// The trigger rate is encoded with a fixed width so
+	// that the keys are sorted by trigger rate.
+	triggerRateBytes := triggerEntry.TriggerScaledExchangeRate.Bytes32()
+	key = append(key, triggerRateBytes[:]...)
+	key = append(key, triggerEntry.Order.OrderID.ToBytes()...)
+	return key
+}
+
+func DBKeyForDAOCoinLimitOrderTriggerByOrderID(orderID *BlockHash) []byte {
+	key := append([]byte{}, Prefixes.PrefixDAOCoinLimitOrderTriggerByOrderID...)
+	key = append(key, orderID.ToBytes()...)
+	return key
+}
+
+func DBGetDAOCoinLimitOrderTrigger(handle *badger.DB, snap *Snapshot, orderID *BlockHash) (
+	*DAOCoinLimitOrderTriggerEntry, error) {
+
+	var ret *DAOCoinLimitOrderTriggerEntry
+	var err error
+
+	handle.View(func(txn *badger.Txn) error {
+		ret, err = DBGetDAOCoinLimitOrderTriggerWithTxn(txn, snap, orderID)
+		return nil
+	})
+
+	return ret, err
+}
+
+func DBGetDAOCoinLimitOrderTriggerWithTxn(txn *badger.Txn, snap *Snapshot, orderID *BlockHash) (
+	*DAOCoinLimitOrderTriggerEntry, error) {
+
+	triggerEntryBytes, err := DBGetWithTxn(txn, snap, DBKeyForDAOCoinLimitOrderTriggerByOrderID(orderID))
+	if err != nil {
+		if err == badger.ErrKeyNotFound {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "DBGetDAOCoinLimitOrderTriggerWithTxn: problem getting trigger order")
+	}
+
+	triggerEntry := &DAOCoinLimitOrderTriggerEntry{}
+	rr := bytes.NewReader(triggerEntryBytes)
+	if exist, err := DecodeFromBytes(triggerEntry, rr); !exist || err != nil {
+		return nil, errors.Wrapf(err, "DBGetDAOCoinLimitOrderTriggerWithTxn: problem decoding trigger order")
+	}
+
+	return triggerEntry, nil
+}
+
+// DBGetDAOCoinLimitOrderTriggersUpToRateWithTxn returns the trigger orders buying
+// buyingDAOCoinCreatorPKID with sellingDAOCoinCreatorPKID whose trigger rate is at
+// most maxTriggerScaledExchangeRate, sorted by trigger rate and then OrderID.
+func DBGetDAOCoinLimitOrderTriggersUpToRateWithTxn(
+	txn *badger.Txn,
+	buyingDAOCoinCreatorPKID *PKID,
+	sellingDAOCoinCreatorPKID *PKID,
+	maxTriggerScaledExchangeRate *uint256.Int) ([]*DAOCoinLimitOrderTriggerEntry, error) {
+
+	prefixKey := DBPrefixKeyForDAOCoinLimitOrderTrigger(buyingDAOCoinCreatorPKID, sellingDAOCoinCreatorPKID)
+	maxTriggerRateBytes := maxTriggerScaledExchangeRate.Bytes32()
+
+	opts := badger.DefaultIteratorOptions
+	iterator := txn.NewIterator(opts)
+	defer iterator.Close()
+
+	triggerEntries := []*DAOCoinLimitOrderTriggerEntry{}
+	for iterator.Seek(prefixKey); iterator.ValidForPrefix(prefixKey); iterator.Next() {
+		key := iterator.Item().Key()
+		if bytes.Compare(key[len(prefixKey):len(prefixKey)+len(maxTriggerRateBytes)], maxTriggerRateBytes[:]) > 0 {
+			break
+		}
+
+		triggerEntryBytes, err := iterator.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DBGetDAOCoinLimitOrderTriggersUpToRateWithTxn: problem getting trigger order")
+		}
+		triggerEntry := &DAOCoinLimitOrderTriggerEntry{}
+		rr := bytes.NewReader(triggerEntryBytes)
+		if exist, err := DecodeFromBytes(triggerEntry, rr); !exist || err != nil {
+			return nil, errors.Wrapf(err, "DBGetDAOCoinLimitOrderTriggersUpToRateWithTxn: problem decoding trigger order")
+		}
+		triggerEntries = append(triggerEntries, triggerEntry)
+	}
+
+	return triggerEntries, nil
+}
+
+func DBPutDAOCoinLimitOrderTriggerWithTxn(txn *badger.Txn, snap *Snapshot,
+	triggerEntry *DAOCoinLimitOrderTriggerEntry, blockHeight uint64) error {
+
+	if triggerEntry == nil {
+		return nil
+	}
+
+	triggerEntryBytes := EncodeToBytes(blockHeight, triggerEntry)
+	// Store in index: PrefixDAOCoinLimitOrderTrigger
+	if err := DBSetWithTxn(txn, snap, DBKeyForDAOCoinLimitOrderTrigger(triggerEntry), triggerEntryBytes); err != nil {
+		return errors.Wrapf(err, "DBPutDAOCoinLimitOrderTriggerWithTxn: problem storing trigger order")
+	}
+
+	// Store in index: PrefixDAOCoinLimitOrderTriggerByOrderID
+	key := DBKeyForDAOCoinLimitOrderTriggerByOrderID(triggerEntry.Order.OrderID)
+	if err := DBSetWithTxn(txn, snap, key, triggerEntryBytes); err != nil {
+		return errors.Wrapf(err, "DBPutDAOCoinLimitOrderTriggerWithTxn: problem storing trigger order "+
+			"in index PrefixDAOCoinLimitOrderTriggerByOrderID")
+	}
+
+	return nil
+}
+
+func DBDeleteDAOCoinLimitOrderTriggerWithTxn(txn *badger.Txn, snap *Snapshot,
+	triggerEntry *DAOCoinLimitOrderTriggerEntry) error {
+
+	if triggerEntry == nil {
+		return nil
+	}
+
+	// Delete from index: PrefixDAOCoinLimitOrderTrigger
+	if err := DBDeleteWithTxn(txn, snap, DBKeyForDAOCoinLimitOrderTrigger(triggerEntry)); err != nil {
+		return errors.Wrapf(err, "DBDeleteDAOCoinLimitOrderTriggerWithTxn: problem deleting trigger order")
+	}
+
+	// Delete from index: PrefixDAOCoinLimitOrderTriggerByOrderID
+	key := DBKeyForDAOCoinLimitOrderTriggerByOrderID(triggerEntry.Order.OrderID)
+	if err := DBDeleteWithTxn(txn, snap, key); err != nil {
+		return errors.Wrapf(err, "DBDeleteDAOCoinLimitOrderTriggerWithTxn: problem deleting trigger order "+
+			"from index PrefixDAOCoinLimitOrderTriggerByOrderID")
+	}
+
+	return nil
+}
+
+// -------------------------------------------------------------------------------------
+// Mempool Txn mapping funcions
+// <prefix_id, time added uint64, txn hash BlockHash> -> <*MempoolTxDbRecord>
+// -------------------------------------------------------------------------------------
+
+const (
+	// In the legacy format, the mempool txn records are just the transaction bytes.
+	MempoolTxnFormatVersionLegacy uint64 = 0
+	// This format adds the fee and validation metadata to each record.
+	MempoolTxnFormatVersionWithMetadata uint64 = 1
+
+	// MempoolTxnFormatVersion is the format that we write mempool txns in.
+	MempoolTxnFormatVersion = MempoolTxnFormatVersionWithMetadata
+)
+
+// MempoolTxDbRecord is a mempool transaction as it's stored in the db, along with the
+// metadata we need to restore it without validating it from scratch.
+type MempoolTxDbRecord struct {
+	Tx *MsgDeSoTxn
+
+	// The total fee the txn pays and its fee rate in nanos per KB.
+	Fee      uint64
+	FeePerKB uint64
+
+	// IsValidated is true if the txn was connected to the mempool's view when it
+	// was stored. ValidatedBlockHeight is the height of the block it was validated
+	// against, i.e. the tip height plus one at the time.
+	IsValidated          bool
+	ValidatedBlockHeight uint64
+}
+
+func (record *MempoolTxDbRecord) ToBytes() ([]byte, error) {
+	txnBytes, err := record.Tx.ToBytes(false /*preSignature*/)
+	if err != nil {
+		return nil, errors.Wrapf(err, "MempoolTxDbRecord.ToBytes: Problem encoding txn")
+	}
+
+	var data []byte
+	data = append(data, EncodeByteArray(txnBytes)...)
+	data = append(data, UintToBuf(record.Fee)...)
+	data = append(data, UintToBuf(record.FeePerKB)...)
+	data = append(data, BoolToByte(record.IsValidated))
+	data = append(data, UintToBuf(record.ValidatedBlockHeight)...)
+	return data, nil
+}
+
+func (record *MempoolTxDbRecord) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+
+	txnBytes, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MempoolTxDbRecord.FromBytes: Problem reading txn bytes")
+	}
+	record.Tx = &MsgDeSoTxn{}
+	if err = record.Tx.FromBytes(txnBytes); err != nil {
+		return errors.Wrapf(err, "MempoolTxDbRecord.FromBytes: Problem decoding txn")
+	}
+	if record.Fee, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "MempoolTxDbRecord.FromBytes: Problem reading Fee")
+	}
+	if record.FeePerKB, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "MempoolTxDbRecord.FromBytes: Problem reading FeePerKB")
+	}
+	if record.IsValidated, err = ReadBoolByte(rr); err != nil {
+		return errors.Wrapf(err, "MempoolTxDbRecord.FromBytes: Problem reading IsValidated")
+	}
+	if record.ValidatedBlockHeight, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "MempoolTxDbRecord.FromBytes: Problem reading ValidatedBlockHeight")
+	}
+	return nil
+}
+
+// _decodeMempoolTxDbRecord decodes a mempool txn record stored in the given format version.
+// Legacy records don't have any metadata, so they're treated as unvalidated.
+func _decodeMempoolTxDbRecord(formatVersion uint64, recordBytes []byte) (*MempoolTxDbRecord, error) {
+	record := &MempoolTxDbRecord{}
+	switch formatVersion {
+	case MempoolTxnFormatVersionLegacy:
+		record.Tx = &MsgDeSoTxn{}
+		if err := record.Tx.FromBytes(recordBytes); err != nil {
+			return nil, err
+		}
+	case MempoolTxnFormatVersionWithMetadata:
+		if err := record.FromBytes(recordBytes); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("_decodeMempoolTxDbRecord: Unknown format version %v", formatVersion)
+	}
+	return record, nil
+}
+
+func DbPutMempoolTxnFormatVersionWithTxn(txn *badger.Txn, snap *Snapshot, formatVersion uint64) error {
+	if err := DBSetWithTxn(txn, snap, Prefixes.PrefixMempoolTxnFormatVersion, UintToBuf(formatVersion)); err != nil {
+		return errors.Wrapf(err, "DbPutMempoolTxnFormatVersionWithTxn: Problem putting format version")
+	}
+	return nil
+}
+
+// DbGetMempoolTxnFormatVersionWithTxn returns the format version of the stored mempool txns. If
+// no version was stored, the txns are in the legacy format.
+func DbGetMempoolTxnFormatVersionWithTxn(txn *badger.Txn, snap *Snapshot) (uint64, error) {
+	formatVersionBytes, err := DBGetWithTxn(txn, snap, Prefixes.PrefixMempoolTxnFormatVersion)
+	if err == badger.ErrKeyNotFound {
+		return MempoolTxnFormatVersionLegacy, nil
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "DbGetMempoolTxnFormatVersionWithTxn: Problem getting format version")
+	}
+	formatVersion, err := ReadUvarint(bytes.NewReader(formatVersionBytes))
+	if err != nil {
+		return 0, errors.Wrapf(err, "DbGetMempoolTxnFormatVersionWithTxn: Problem decoding format version")
+	}
+	return formatVersion, nil
+}
+
+func DbGetAllMempoolTxDbRecordsSortedByTimeAdded(handle *badger.DB) (_records []*MempoolTxDbRecord, _error error) {
+	var formatVersion uint64
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		formatVersion, err = DbGetMempoolTxnFormatVersionWithTxn(txn, nil)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetAllMempoolTxDbRecordsSortedByTimeAdded: ")
+	}
+
+	_, valuesFound := _enumerateKeysForPrefix(handle, Prefixes.PrefixMempoolTxnHashToMsgDeSoTxn)
+
+	records := []*MempoolTxDbRecord{}
+	for _, mempoolTxnBytes := range valuesFound {
+		record, err := _decodeMempoolTxDbRecord(formatVersion, mempoolTxnBytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DbGetAllMempoolTxDbRecordsSortedByTimeAdded: failed to decode mempoolTxnBytes.")
+		}
+		records = append(records, record)
+	}
+
+	// We don't need to sort the transactions because the DB keys include the time added and
+	// are therefore retrieved from badger in order.
+
+	return records, nil
+}
+
+// -------------------------------------------------------------------------------------
+// Index queue mapping functions
+// <prefix_id, Seq uint64> -> <IndexQueueTask>
+// -------------------------------------------------------------------------------------
+
+func _dbKeyForIndexQueueTask(seq uint64) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixIndexQueueSeqToTask...)
+	return append(prefixCopy, EncodeUint64(seq)...)
+}
+
+// DbEnqueueIndexQueueTaskWithTxn adds the task to the back of the index queue. It should be
+// called in the same txn that flushes the block the task refers to, so that a crash can't
+// leave us with a flushed block and no task, or vice versa.
+func DbEnqueueIndexQueueTaskWithTxn(txn *badger.Txn, snap *Snapshot, task *IndexQueueTask) error {
+	nextSeq := uint64(0)
+	nextSeqBytes, err := DBGetWithTxn(txn, snap, Prefixes.PrefixIndexQueueNextSeq)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return errors.Wrapf(err, "DbEnqueueIndexQueueTaskWithTxn: Problem getting next seq")
+	}
+	if err == nil {
+		nextSeq = DecodeUint64(nextSeqBytes)
+	}
+
+	if err := DBSetWithTxn(txn, snap, _dbKeyForIndexQueueTask(nextSeq), task.ToBytes()); err != nil {
+		return errors.Wrapf(err, "DbEnqueueIndexQueueTaskWithTxn: Problem putting task %v", nextSeq)
+	}
+	if err := DBSetWithTxn(txn, snap, Prefixes.PrefixIndexQueueNextSeq, EncodeUint64(nextSeq+1)); err != nil {
+		return errors.Wrapf(err, "DbEnqueueIndexQueueTaskWithTxn: Problem putting next seq")
+	}
+
+	return nil
+}
+
+// DbGetIndexQueueTasks returns up to maxTasks tasks from the front of the index queue,
+// along with their sequence numbers. The tasks stay in the queue until they are deleted.
+func DbGetIndexQueueTasks(handle *badger.DB, maxTasks int) (
+	_seqs []uint64, _tasks []*IndexQueueTask, _err error) {
+
+	seqs := []uint64{}
+	tasks := []*IndexQueueTask{}
+	err := handle.View(func(txn *badger.Txn) error {
+		iterator := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iterator.Close()
+
+		prefix := Prefixes.PrefixIndexQueueSeqToTask
+		for iterator.Seek(prefix); iterator.ValidForPrefix(prefix) && len(tasks) < maxTasks; iterator.Next() {
+			key := iterator.Item().Key()
+			taskBytes, err := iterator.Item().ValueCopy(nil)
+			if err != nil {
+				return errors.Wrapf(err, "DbGetIndexQueueTasks: Problem getting task")
+			}
+			task := &IndexQueueTask{}
+			if err := task.FromBytes(taskBytes); err != nil {
+				return errors.Wrapf(err, "DbGetIndexQueueTasks: Problem decoding task")
+			}
+
+			seqs = append(seqs, DecodeUint64(key[len(prefix):]))
+			tasks = append(tasks, task)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return seqs, tasks, nil
+}
+
+func DbDeleteIndexQueueTask(handle *badger.DB, snap *Snapshot, seq uint64) error {
+	return handle.Update(func(txn *badger.Txn) error {
+		if err := DBDeleteWithTxn(txn, snap, _dbKeyForIndexQueueTask(seq)); err != nil {
+			return errors.Wrapf(err, "DbDeleteIndexQueueTask: Problem deleting task %v", seq)
+		}
+		return nil
+	})
+}
+
+// -------------------------------------------------------------------------------------
+// Block connect profile mapping functions
+// <prefix_id, BlockHeight uint64, BlockHash> -> <BlockConnectProfile>
+// -------------------------------------------------------------------------------------
+
+func _dbKeyForBlockConnectProfile(blockHeight uint64, blockHash *BlockHash) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixBlockConnectProfileByHeightAndHash...)
+	key := append(prefixCopy, EncodeUint64(blockHeight)...)
+	return append(key, blockHash[:]...)
+}
+
+// DbPutBlockConnectProfileWithTxn should be called in the txn that flushes the profiled block,
+// after the profile's flush has ended.
+func DbPutBlockConnectProfileWithTxn(txn *badger.Txn, snap *Snapshot, profile *BlockConnectProfile) error {
+	key := _dbKeyForBlockConnectProfile(profile.BlockHeight, profile.BlockHash)
+	if err := DBSetWithTxn(txn, snap, key, profile.ToBytes()); err != nil {
+		return errors.Wrapf(err, "DbPutBlockConnectProfileWithTxn: Problem putting profile for block %v",
+			profile.BlockHash)
+	}
+	return nil
+}
+
+// DbGetBlockConnectProfiles returns the profiles of the blocks with heights in [startHeight, endHeight],
+// ordered by height. Blocks connected more than once, e.g. after a reorg, have one profile per hash.
+func DbGetBlockConnectProfiles(handle *badger.DB, startHeight uint64, endHeight uint64) (
+	[]*BlockConnectProfile, error) {
+
+	profiles := []*BlockConnectProfile{}
+	err := handle.View(func(txn *badger.Txn) error {
+		iterator := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iterator.Close()
+
+		prefix := Prefixes.PrefixBlockConnectProfileByHeightAndHash
+		startKey := append(append([]byte{}, prefix...), EncodeUint64(startHeight)...)
+		for iterator.Seek(startKey); iterator.ValidForPrefix(prefix); iterator.Next() {
+			key := iterator.Item().Key()
+			if DecodeUint64(key[len(prefix):len(prefix)+8]) > endHeight {
+				break
+			}
+			profileBytes, err := iterator.Item().ValueCopy(nil)
+			if err != nil {
+				return errors.Wrapf(err, "DbGetBlockConnectProfiles: Problem getting profile")
+			}
+			profile := &BlockConnectProfile{}
+			if err := profile.FromBytes(profileBytes); err != nil {
+				return errors.Wrapf(err, "DbGetBlockConnectProfiles: Problem decoding profile")
+			}
+			profiles = append(profiles, profile)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return profiles, nil
+}
+
+// -------------------------------------------------------------------------------------
+// Admin key/value audit log mapping functions
+// <prefix_id, TimestampNanos uint64, Key> -> <AdminKVAuditRecord>
+// -------------------------------------------------------------------------------------
+
+func _dbKeyForAdminKVAuditRecord(record *AdminKVAuditRecord) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixAdminKVAuditLog...)
+	key := append(prefixCopy, EncodeUint64(record.TimestampNanos)...)
+	return append(key, record.Key...)
+}
+
+func DbPutAdminKVAuditRecordWithTxn(txn *badger.Txn, snap *Snapshot, record *AdminKVAuditRecord) error {
+	if err := DBSetWithTxn(txn, snap, _dbKeyForAdminKVAuditRecord(record), record.ToBytes()); err != nil {
+		return errors.Wrapf(err, "DbPutAdminKVAuditRecordWithTxn: Problem putting audit record")
+	}
+	return nil
+}
+
+// DbGetAdminKVAuditRecords returns every admin key/value audit record, oldest first.
+func DbGetAdminKVAuditRecords(handle *badger.DB) ([]*AdminKVAuditRecord, error) {
+	records := []*AdminKVAuditRecord{}
+	_, recordsBytes := EnumerateKeysForPrefix(handle, Prefixes.PrefixAdminKVAuditLog)
+	for _, recordBytes := range recordsBytes {
+		record := &AdminKVAuditRecord{}
+		if err := record.FromBytes(recordBytes); err != nil {
+			return nil, errors.Wrapf(err, "DbGetAdminKVAuditRecords: Problem decoding audit record")
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// -------------------------------------------------------------------------------------
+// DAO coin pair volume mapping functions
+// <prefix_id, BuyingDAOCoinCreatorPKID, SellingDAOCoinCreatorPKID, BucketStartSecs uint64> -> <DAOCoinPairVolume>
+// <prefix_id, BlockHash> -> <DAOCoinPairVolumeBlockContribution>
+// -------------------------------------------------------------------------------------
+
+func _dbPrefixForDAOCoinPairVolumeBuckets(pair *DAOCoinLimitOrderPair) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixDAOCoinPairVolumeBuckets...)
+	key := append(prefixCopy, pair.BuyingDAOCoinCreatorPKID[:]...)
+	return append(key, pair.SellingDAOCoinCreatorPKID[:]...)
+}
+
+func _dbKeyForDAOCoinPairVolumeBucket(pair *DAOCoinLimitOrderPair, bucketStartSecs uint64) []byte {
+	return append(_dbPrefixForDAOCoinPairVolumeBuckets(pair), EncodeUint64(bucketStartSecs)...)
+}
+
+func _dbKeyForDAOCoinPairVolumeBlockContribution(blockHash *BlockHash) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixDAOCoinPairVolumeBlockContributions...)
+	return append(prefixCopy, blockHash[:]...)
+}
+
+// DbGetDAOCoinPairVolumeBucketWithTxn returns nil if the bucket doesn't exist.
+func DbGetDAOCoinPairVolumeBucketWithTxn(txn *badger.Txn, pair *DAOCoinLimitOrderPair, bucketStartSecs uint64) (
+	*DAOCoinPairVolume, error) {
+
+	volumeBytes, err := DBGetWithTxn(txn, nil, _dbKeyForDAOCoinPairVolumeBucket(pair, bucketStartSecs))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetDAOCoinPairVolumeBucketWithTxn: Problem getting bucket")
+	}
+	volume := &DAOCoinPairVolume{}
+	if err = volume.FromBytes(bytes.NewReader(volumeBytes)); err != nil {
+		return nil, errors.Wrapf(err, "DbGetDAOCoinPairVolumeBucketWithTxn: Problem decoding bucket")
+	}
+	return volume, nil
+}
+
+// DbGetDAOCoinPairVolumeBucketsWithTxn returns the buckets that start in [startSecs, endSecs].
+func DbGetDAOCoinPairVolumeBucketsWithTxn(txn *badger.Txn, pair *DAOCoinLimitOrderPair,
+	startSecs uint64, endSecs uint64) ([]*DAOCoinPairVolume, error) {
+
+	iterator := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer iterator.Close()
+
+	volumes := []*DAOCoinPairVolume{}
+	prefix := _dbPrefixForDAOCoinPairVolumeBuckets(pair)
+	for iterator.Seek(_dbKeyForDAOCoinPairVolumeBucket(pair, startSecs)); iterator.ValidForPrefix(prefix); iterator.Next() {
+		if DecodeUint64(iterator.Item().Key()[len(prefix):]) > endSecs {
+			break
+		}
+		volumeBytes, err := iterator.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DbGetDAOCoinPairVolumeBucketsWithTxn: Problem getting bucket")
+		}
+		volume := &DAOCoinPairVolume{}
+		if err = volume.FromBytes(bytes.NewReader(volumeBytes)); err != nil {
+			return nil, errors.Wrapf(err, "DbGetDAOCoinPairVolumeBucketsWithTxn: Problem decoding bucket")
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes, nil
+}
+
+func DbPutDAOCoinPairVolumeBucketWithTxn(txn *badger.Txn, pair *DAOCoinLimitOrderPair, bucketStartSecs uint64,
+	volume *DAOCoinPairVolume) error {
+
+	if err := DBSetWithTxn(txn, nil, _dbKeyForDAOCoinPairVolumeBucket(pair, bucketStartSecs), volume.ToBytes()); err != nil {
+		return errors.Wrapf(err, "DbPutDAOCoinPairVolumeBucketWithTxn: Problem putting bucket")
+	}
+	return nil
+}
+
+// DbDeleteDAOCoinPairVolumeBucketsBeforeWithTxn deletes the pair's buckets that start before beforeSecs.
+func DbDeleteDAOCoinPairVolumeBucketsBeforeWithTxn(txn *badger.Txn, pair *DAOCoinLimitOrderPair, beforeSecs uint64) error {
+	keysToDelete := [][]byte{}
+	iterator := txn.NewIterator(badger.IteratorOptions{PrefetchValues: false})
+	prefix := _dbPrefixForDAOCoinPairVolumeBuckets(pair)
+	for iterator.Seek(prefix); iterator.ValidForPrefix(prefix); iterator.Next() {
+		key := iterator.Item().KeyCopy(nil)
+		if DecodeUint64(key[len(prefix):]) >= beforeSecs {
+			break
+		}
+		keysToDelete = append(keysToDelete, key)
+	}
+	iterator.Close()
+
+	for _, key := range keysToDelete {
+		if err := DBDeleteWithTxn(txn, nil, key); err != nil {
+			return errors.Wrapf(err, "DbDeleteDAOCoinPairVolumeBucketsBeforeWithTxn: Problem deleting bucket")
+		}
+	}
+	return nil
+}
+
+// DbGetDAOCoinPairVolumeBlockContributionWithTxn returns nil if the block's contribution hasn't
+// been applied.
+func DbGetDAOCoinPairVolumeBlockContributionWithTxn(txn *badger.Txn, blockHash *BlockHash) (
+	*DAOCoinPairVolumeBlockContribution, error) {
+
+	contributionBytes, err := DBGetWithTxn(txn, nil, _dbKeyForDAOCoinPairVolumeBlockContribution(blockHash))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetDAOCoinPairVolumeBlockContributionWithTxn: Problem getting contribution")
+	}
+	contribution := &DAOCoinPairVolumeBlockContribution{}
+	if err = contribution.FromBytes(contributionBytes); err != nil {
+		return nil, errors.Wrapf(err, "DbGetDAOCoinPairVolumeBlockContributionWithTxn: Problem decoding contribution")
+	}
+	return contribution, nil
+}
+
+func DbPutDAOCoinPairVolumeBlockContributionWithTxn(txn *badger.Txn, blockHash *BlockHash,
+	contribution *DAOCoinPairVolumeBlockContribution) error {
+
+	if err := DBSetWithTxn(txn, nil, _dbKeyForDAOCoinPairVolumeBlockContribution(blockHash), contribution.ToBytes()); err != nil {
+		return errors.Wrapf(err, "DbPutDAOCoinPairVolumeBlockContributionWithTxn: Problem putting contribution")
+	}
+	return nil
+}
+
+func DbDeleteDAOCoinPairVolumeBlockContributionWithTxn(txn *badger.Txn, blockHash *BlockHash) error {
+	if err := DBDeleteWithTxn(txn, nil, _dbKeyForDAOCoinPairVolumeBlockContribution(blockHash)); err != nil {
+		return errors.Wrapf(err, "DbDeleteDAOCoinPairVolumeBlockContributionWithTxn: Problem deleting contribution")
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------
+// Selective sync mapping functions
+// <prefix_id> -> <[]StatePrefix>
+// -------------------------------------------------------------------------------------
+
+func DbPutSyncedStatePrefixesWithTxn(txn *badger.Txn, snap *Snapshot, syncedPrefixes [][]byte) error {
+	if err := DBSetWithTxn(txn, snap, Prefixes.PrefixSelectiveSyncStatePrefixes,
+		_encodeStatePrefixes(syncedPrefixes)); err != nil {
+
+		return errors.Wrapf(err, "DbPutSyncedStatePrefixesWithTxn: Problem putting synced state prefixes")
+	}
+	return nil
+}
+
+// DbGetSyncedStatePrefixes returns the state prefixes recorded by a selective hypersync, or nil if
+// the node has all of the state.
+func DbGetSyncedStatePrefixes(handle *badger.DB) ([][]byte, error) {
+	var syncedPrefixes [][]byte
+	err := handle.View(func(txn *badger.Txn) error {
+		syncedPrefixesBytes, err := DBGetWithTxn(txn, nil, Prefixes.PrefixSelectiveSyncStatePrefixes)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		syncedPrefixes, err = _decodeStatePrefixes(syncedPrefixesBytes)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetSyncedStatePrefixes: Problem getting synced state prefixes")
+	}
+	return syncedPrefixes, nil
+}
+
+// -------------------------------------------------------------------------------------
+// Validation failure mapping functions
+// <prefix_id, Slot uint64> -> <ValidationFailureRecord>
+// -------------------------------------------------------------------------------------
+
+func _dbKeyForValidationFailureSlot(slot uint64) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixValidationFailures...)
+	return append(prefixCopy, EncodeUint64(slot)...)
+}
+
+func DbPutValidationFailureRecordWithTxn(txn *badger.Txn, record *ValidationFailureRecord) error {
+	key := _dbKeyForValidationFailureSlot(record.Seq % ValidationFailureLogCapacity)
+	if err := DBSetWithTxn(txn, nil, key, record.ToBytes()); err != nil {
+		return errors.Wrapf(err, "DbPutValidationFailureRecordWithTxn: Problem putting record")
+	}
+	return nil
+}
+
+// DbGetValidationFailureRecords returns all the records in the log, from oldest to newest.
+func DbGetValidationFailureRecords(handle *badger.DB) ([]*ValidationFailureRecord, error) {
+	var records []*ValidationFailureRecord
+	err := handle.View(func(txn *badger.Txn) error {
+		_, valsFound, err := _enumerateKeysForPrefixWithTxn(txn, Prefixes.PrefixValidationFailures)
+		if err != nil {
+			return err
+		}
+		for _, recordBytes := range valsFound {
+			record := &ValidationFailureRecord{}
+			if err = record.FromBytes(recordBytes); err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetValidationFailureRecords: Problem getting records")
+	}
+	// Slots wrap around, so the records have to be sorted by Seq rather than by key.
+	sort.Slice(records, func(ii, jj int) bool {
+		return records[ii].Seq < records[jj].Seq
+	})
+	return records, nil
+}
+
+// -------------------------------------------------------------------------------------
+// Peer ban mapping functions
+// <prefix_id, IP [16]byte> -> <PeerBanEntry>
+// -------------------------------------------------------------------------------------
+
+func _dbKeyForPeerBan(ip net.IP) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixPeerBans...)
+	return append(prefixCopy, ip.To16()...)
+}
+
+func DbPutPeerBanEntryWithTxn(txn *badger.Txn, entry *PeerBanEntry) error {
+	if err := DBSetWithTxn(txn, nil, _dbKeyForPeerBan(entry.IP), entry.ToBytes()); err != nil {
+		return errors.Wrapf(err, "DbPutPeerBanEntryWithTxn: Problem putting ban for %v", entry.IP)
+	}
+	return nil
+}
+
+func DbDeletePeerBanEntryWithTxn(txn *badger.Txn, ip net.IP) error {
+	if err := DBDeleteWithTxn(txn, nil, _dbKeyForPeerBan(ip)); err != nil {
+		return errors.Wrapf(err, "DbDeletePeerBanEntryWithTxn: Problem deleting ban for %v", ip)
+	}
+	return nil
+}
+
+// DbGetPeerBanEntries returns all the bans in the db, including the ones that have expired but
+// haven't been deleted yet.
+func DbGetPeerBanEntries(handle *badger.DB) ([]*PeerBanEntry, error) {
+	var entries []*PeerBanEntry
+	err := handle.View(func(txn *badger.Txn) error {
+		_, valsFound, err := _enumerateKeysForPrefixWithTxn(txn, Prefixes.PrefixPeerBans)
+		if err != nil {
+			return err
+		}
+		for _, entryBytes := range valsFound {
+			entry := &PeerBanEntry{}
+			if err = entry.FromBytes(entryBytes); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetPeerBanEntries: Problem getting bans")
+	}
+	return entries, nil
+}
+
+// -------------------------------------------------------------------------------------
+// Orphan block mapping functions
+// <prefix_id, Height uint64, BlockHash> -> <MsgDeSoBlock>
+// -------------------------------------------------------------------------------------
+
+func _dbKeyForOrphanBlock(height uint64, blockHash *BlockHash) []byte {
+	return MustGetKeyCodec(Prefixes.PrefixOrphanBlocks).MustEncode(height, blockHash)
+}
+
+func DbPutOrphanBlockWithTxn(txn *badger.Txn, blockHash *BlockHash, desoBlock *MsgDeSoBlock) error {
+	blockBytes, err := desoBlock.ToBytes(false)
+	if err != nil {
+		return errors.Wrapf(err, "DbPutOrphanBlockWithTxn: Problem serializing orphan block %v", blockHash)
+	}
+	key := _dbKeyForOrphanBlock(desoBlock.Header.Height, blockHash)
+	if err = DBSetWithTxn(txn, nil, key, blockBytes); err != nil {
+		return errors.Wrapf(err, "DbPutOrphanBlockWithTxn: Problem putting orphan block %v", blockHash)
+	}
+	return nil
+}
+
+func DbDeleteOrphanBlockWithTxn(txn *badger.Txn, height uint64, blockHash *BlockHash) error {
+	if err := DBDeleteWithTxn(txn, nil, _dbKeyForOrphanBlock(height, blockHash)); err != nil {
+		return errors.Wrapf(err, "DbDeleteOrphanBlockWithTxn: Problem deleting orphan block %v", blockHash)
+	}
+	return nil
+}
+
+// DbGetOrphanBlocks returns all the orphan blocks in the db, ordered by height.
+func DbGetOrphanBlocks(handle *badger.DB) ([]*OrphanBlock, error) {
+	keyCodec := MustGetKeyCodec(Prefixes.PrefixOrphanBlocks)
+	var orphanBlocks []*OrphanBlock
+	err := DBForEachKeyWithPrefix(handle, Prefixes.PrefixOrphanBlocks, &DBIteratorOptions{},
+		func(keyBytes []byte, valueBytes []byte) (bool, error) {
+			decodedKey, err := keyCodec.Decode(keyBytes)
+			if err != nil {
+				return true, err
+			}
+			desoBlock := NewMessage(MsgTypeBlock).(*MsgDeSoBlock)
+			if err = desoBlock.FromBytes(valueBytes); err != nil {
+				return true, err
+			}
+			orphanBlocks = append(orphanBlocks, &OrphanBlock{
+				Block: desoBlock,
+				Hash:  decodedKey.BlockHash("BlockHash"),
+			})
+			return false, nil
+		})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetOrphanBlocks: Problem getting orphan blocks")
+	}
+	return orphanBlocks, nil
+}
+
+// -------------------------------------------------------------------------------------
+// Invalid block mapping functions
+// <prefix_id, BlockHash> -> <InvalidBlockEntry>
+// -------------------------------------------------------------------------------------
+
+func _dbKeyForInvalidBlock(blockHash *BlockHash) []byte {
+	return MustGetKeyCodec(Prefixes.PrefixInvalidBlocks).MustEncode(blockHash)
+}
+
+func DbPutInvalidBlockEntryWithTxn(txn *badger.Txn, entry *InvalidBlockEntry) error {
+	if err := DBSetWithTxn(txn, nil, _dbKeyForInvalidBlock(entry.Hash), entry.ToBytes()); err != nil {
+		return errors.Wrapf(err, "DbPutInvalidBlockEntryWithTxn: Problem putting invalid block %v", entry.Hash)
+	}
+	return nil
+}
+
+func DbDeleteInvalidBlockEntryWithTxn(txn *badger.Txn, blockHash *BlockHash) error {
+	if err := DBDeleteWithTxn(txn, nil, _dbKeyForInvalidBlock(blockHash)); err != nil {
+		return errors.Wrapf(err, "DbDeleteInvalidBlockEntryWithTxn: Problem deleting invalid block %v", blockHash)
+	}
+	return nil
+}
+
+// DbGetInvalidBlockEntry returns nil if the block isn't known to be invalid.
+func DbGetInvalidBlockEntry(handle *badger.DB, blockHash *BlockHash) (*InvalidBlockEntry, error) {
+	var entry *InvalidBlockEntry
+	err := handle.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(_dbKeyForInvalidBlock(blockHash))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		entryBytes, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		entry = &InvalidBlockEntry{}
+		return entry.FromBytes(entryBytes)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetInvalidBlockEntry: Problem getting invalid block %v", blockHash)
+	}
+	return entry, nil
+}
+
+// DbGetInvalidBlockEntries returns all the invalid blocks in the db.
+func DbGetInvalidBlockEntries(handle *badger.DB) ([]*InvalidBlockEntry, error) {
+	var entries []*InvalidBlockEntry
+	err := handle.View(func(txn *badger.Txn) error {
+		_, valsFound, err := _enumerateKeysForPrefixWithTxn(txn, Prefixes.PrefixInvalidBlocks)
+		if err != nil {
+			return err
+		}
+		for _, entryBytes := range valsFound {
+			entry := &InvalidBlockEntry{}
+			if err = entry.FromBytes(entryBytes); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetInvalidBlockEntries: Problem getting invalid blocks")
+	}
+	return entries, nil
+}
+
+// -------------------------------------------------------------------------------------
+// Event journal mapping functions
+// <prefix_id, Seq uint64> -> <EventJournalEntry>
+// -------------------------------------------------------------------------------------
+
+func _dbKeyForEventJournalSeq(seq uint64) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixEventJournal...)
+	return append(prefixCopy, EncodeUint64(seq)...)
+}
+
+func DbPutEventJournalEntryWithTxn(txn *badger.Txn, entry *EventJournalEntry) error {
+	if err := DBSetWithTxn(txn, nil, _dbKeyForEventJournalSeq(entry.Seq), entry.ToBytes()); err != nil {
+		return errors.Wrapf(err, "DbPutEventJournalEntryWithTxn: Problem putting entry")
+	}
+	return nil
+}
+
+// DbGetNextEventJournalSeqWithTxn returns the sequence number after that of the last entry in
+// the journal, or zero if the journal is empty.
+func DbGetNextEventJournalSeqWithTxn(txn *badger.Txn) (uint64, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.Reverse = true
+	opts.PrefetchValues = false
+	opts.Prefix = Prefixes.PrefixEventJournal
+	iterator := txn.NewIterator(opts)
+	defer iterator.Close()
+
+	// In reverse order, Seek finds the largest key that is less than or equal to the one given.
+	iterator.Seek(_dbKeyForEventJournalSeq(math.MaxUint64))
+	if !iterator.ValidForPrefix(Prefixes.PrefixEventJournal) {
+		return 0, nil
+	}
+	key := iterator.Item().Key()
+	if len(key) != len(Prefixes.PrefixEventJournal)+8 {
+		return 0, fmt.Errorf("DbGetNextEventJournalSeqWithTxn: Invalid key length %v", len(key))
+	}
+	return DecodeUint64(key[len(Prefixes.PrefixEventJournal):]) + 1, nil
+}
+
+// DbGetEventJournalEntries returns up to maxEntries entries starting with the one with sequence
+// number startSeq, oldest first.
+func DbGetEventJournalEntries(handle *badger.DB, startSeq uint64, maxEntries int) ([]*EventJournalEntry, error) {
+	var entries []*EventJournalEntry
+	err := handle.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = Prefixes.PrefixEventJournal
+		iterator := txn.NewIterator(opts)
+		defer iterator.Close()
+
+		for iterator.Seek(_dbKeyForEventJournalSeq(startSeq)); iterator.ValidForPrefix(Prefixes.PrefixEventJournal) &&
+			len(entries) < maxEntries; iterator.Next() {
+
+			entryBytes, err := iterator.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			entry := &EventJournalEntry{}
+			if err = entry.FromBytes(entryBytes); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetEventJournalEntries: Problem getting entries")
+	}
+	return entries, nil
+}
+
+// -------------------------------------------------------------------------------------
+// Paused subsystem mapping functions
+// <prefix_id, Subsystem []byte> -> <>
+// -------------------------------------------------------------------------------------
+
+func _dbKeyForPausedSubsystem(subsystem Subsystem) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixPausedSubsystems...)
+	return append(prefixCopy, []byte(subsystem)...)
+}
+
+// DbSetSubsystemPaused pauses or resumes one of the optional subsystems. The running subsystem picks
+// up the change the next time it checks the flag, so it doesn't have to be restarted.
+func DbSetSubsystemPaused(handle *badger.DB, subsystem Subsystem, paused bool) error {
+	if !subsystem.IsValid() {
+		return fmt.Errorf("DbSetSubsystemPaused: Unknown subsystem %v", subsystem)
+	}
+	err := handle.Update(func(txn *badger.Txn) error {
+		if paused {
+			return DBSetWithTxn(txn, nil, _dbKeyForPausedSubsystem(subsystem), []byte{})
+		}
+		return DBDeleteWithTxn(txn, nil, _dbKeyForPausedSubsystem(subsystem))
+	})
+	if err != nil {
+		return errors.Wrapf(err, "DbSetSubsystemPaused: Problem setting paused to %v for subsystem %v",
+			paused, subsystem)
+	}
+	return nil
+}
+
+// DbGetSubsystemPaused returns false if the flag can't be read, so that a db error doesn't stop
+// a subsystem.
+func DbGetSubsystemPaused(handle *badger.DB, subsystem Subsystem) bool {
+	var paused bool
+	handle.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(_dbKeyForPausedSubsystem(subsystem))
+		paused = err == nil
+		return nil
+	})
+	return paused
+}
+
+func DbGetPausedSubsystems(handle *badger.DB) ([]Subsystem, error) {
+	var pausedSubsystems []Subsystem
+	err := handle.View(func(txn *badger.Txn) error {
+		keysFound, _, err := _enumerateKeysForPrefixWithTxn(txn, Prefixes.PrefixPausedSubsystems)
+		if err != nil {
+			return err
+		}
+		for _, key := range keysFound {
+			pausedSubsystems = append(pausedSubsystems, Subsystem(key[len(Prefixes.PrefixPausedSubsystems):]))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetPausedSubsystems: Problem getting paused subsystems")
+	}
+	return pausedSubsystems, nil
+}
+
+// -------------------------------------------------------------------------------------
+// Post ExtraData index mapping functions
+// <prefix_id, Key []byte, Value []byte, PostHash [32]byte> -> <>
+// <prefix_id, PostHash [32]byte> -> <[]PostExtraDataIndexEntry>
+// -------------------------------------------------------------------------------------
+
+// The key and value are length-prefixed, so that the prefix for one (key, value) is never a
+// prefix of another.
+func _dbPrefixForPostExtraDataIndexValue(key string, value []byte) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixPostExtraDataIndex...)
+	prefixCopy = append(prefixCopy, EncodeByteArray([]byte(key))...)
+	return append(prefixCopy, EncodeByteArray(value)...)
+}
+
+func _dbKeyForPostExtraDataIndexEntry(entry *PostExtraDataIndexEntry, postHash *BlockHash) []byte {
+	return append(_dbPrefixForPostExtraDataIndexValue(entry.Key, entry.Value), postHash[:]...)
+}
+
+func _dbKeyForPostExtraDataIndexEntriesByPostHash(postHash *BlockHash) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixPostExtraDataIndexEntriesByPostHash...)
+	return append(prefixCopy, postHash[:]...)
+}
+
+func DbPutPostExtraDataIndexEntryWithTxn(txn *badger.Txn, entry *PostExtraDataIndexEntry, postHash *BlockHash) error {
+	if err := DBSetWithTxn(txn, nil, _dbKeyForPostExtraDataIndexEntry(entry, postHash), []byte{}); err != nil {
+		return errors.Wrapf(err, "DbPutPostExtraDataIndexEntryWithTxn: Problem putting entry")
+	}
+	return nil
+}
+
+func DbDeletePostExtraDataIndexEntryWithTxn(txn *badger.Txn, entry *PostExtraDataIndexEntry, postHash *BlockHash) error {
+	if err := DBDeleteWithTxn(txn, nil, _dbKeyForPostExtraDataIndexEntry(entry, postHash)); err != nil {
+		return errors.Wrapf(err, "DbDeletePostExtraDataIndexEntryWithTxn: Problem deleting entry")
+	}
+	return nil
+}
+
+// DbPutPostExtraDataIndexEntriesForPostWithTxn records the entries the post is indexed under. The
+// record is deleted if there are none.
+func DbPutPostExtraDataIndexEntriesForPostWithTxn(txn *badger.Txn, postHash *BlockHash,
+	entries []*PostExtraDataIndexEntry) error {
+
+	key := _dbKeyForPostExtraDataIndexEntriesByPostHash(postHash)
+	if len(entries) == 0 {
+		if err := DBDeleteWithTxn(txn, nil, key); err != nil {
+			return errors.Wrapf(err, "DbPutPostExtraDataIndexEntriesForPostWithTxn: Problem deleting entries")
+		}
+		return nil
+	}
+
+	data := UintToBuf(uint64(len(entries)))
+	for _, entry := range entries {
+		data = append(data, EncodeByteArray([]byte(entry.Key))...)
+		data = append(data, EncodeByteArray(entry.Value)...)
+	}
+	if err := DBSetWithTxn(txn, nil, key, data); err != nil {
+		return errors.Wrapf(err, "DbPutPostExtraDataIndexEntriesForPostWithTxn: Problem putting entries")
+	}
+	return nil
+}
+
+func DbGetPostExtraDataIndexEntriesForPostWithTxn(txn *badger.Txn, postHash *BlockHash) (
+	[]*PostExtraDataIndexEntry, error) {
+
+	data, err := DBGetWithTxn(txn, nil, _dbKeyForPostExtraDataIndexEntriesByPostHash(postHash))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetPostExtraDataIndexEntriesForPostWithTxn: Problem getting entries")
+	}
+
+	rr := bytes.NewReader(data)
+	numEntries, err := ReadUvarint(rr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetPostExtraDataIndexEntriesForPostWithTxn: Problem reading number of entries")
+	}
+	var entries []*PostExtraDataIndexEntry
+	for ii := uint64(0); ii < numEntries; ii++ {
+		key, err := DecodeByteArray(rr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DbGetPostExtraDataIndexEntriesForPostWithTxn: Problem reading key")
+		}
+		value, err := DecodeByteArray(rr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DbGetPostExtraDataIndexEntriesForPostWithTxn: Problem reading value")
+		}
+		entries = append(entries, &PostExtraDataIndexEntry{Key: string(key), Value: value})
+	}
+	return entries, nil
+}
+
+// -------------------------------------------------------------------------------------
+// Corrupted entry report mapping functions
+// <prefix_id, Key []byte> -> <CorruptedEntryReport>
+// -------------------------------------------------------------------------------------
+
+func _dbKeyForCorruptedEntryReport(key []byte) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixCorruptedEntries...)
+	return append(prefixCopy, key...)
+}
+
+func DbPutCorruptedEntryReport(handle *badger.DB, report *CorruptedEntryReport) error {
+	err := handle.Update(func(txn *badger.Txn) error {
+		return DBSetWithTxn(txn, nil, _dbKeyForCorruptedEntryReport(report.Key), report.ToBytes())
+	})
+	if err != nil {
+		return errors.Wrapf(err, "DbPutCorruptedEntryReport: Problem putting report for key %v", report.Key)
+	}
+	return nil
+}
+
+func DbDeleteCorruptedEntryReportWithTxn(txn *badger.Txn, key []byte) error {
+	if err := DBDeleteWithTxn(txn, nil, _dbKeyForCorruptedEntryReport(key)); err != nil {
+		return errors.Wrapf(err, "DbDeleteCorruptedEntryReportWithTxn: Problem deleting report for key %v", key)
+	}
+	return nil
+}
+
+// DbGetCorruptedEntryReports returns the reports in key order.
+func DbGetCorruptedEntryReports(handle *badger.DB) ([]*CorruptedEntryReport, error) {
+	var reports []*CorruptedEntryReport
+	err := handle.View(func(txn *badger.Txn) error {
+		_, valsFound, err := _enumerateKeysForPrefixWithTxn(txn, Prefixes.PrefixCorruptedEntries)
+		if err != nil {
+			return err
+		}
+		for _, val := range valsFound {
+			report := &CorruptedEntryReport{}
+			if err := report.FromBytes(val); err != nil {
+				return err
+			}
+			reports = append(reports, report)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetCorruptedEntryReports: Problem getting reports")
+	}
+	return reports, nil
+}
+
+// -------------------------------------------------------------------------------------
+// Pinned posts mapping functions
+// <prefix_id, PosterPKID [33]byte, PinOrder uint64, PostHash [32]byte> -> <>
+// <prefix_id, PostHash [32]byte> -> <PinnedPostEntry>
+// -------------------------------------------------------------------------------------
+
+func _dbPrefixForPinnedPostsByPKID(posterPKID *PKID) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixPinnedPostsByPKID...)
+	return append(prefixCopy, posterPKID[:]...)
+}
+
+func _dbKeyForPinnedPostByPKID(entry *PinnedPostEntry) []byte {
+	key := _dbPrefixForPinnedPostsByPKID(entry.PosterPKID)
+	key = append(key, EncodeUint64(entry.PinOrder)...)
+	return append(key, entry.PostHash[:]...)
+}
+
+func _dbKeyForPinnedPostEntryByPostHash(postHash *BlockHash) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixPinnedPostEntryByPostHash...)
+	return append(prefixCopy, postHash[:]...)
+}
+
+func DbPutPinnedPostEntryWithTxn(txn *badger.Txn, entry *PinnedPostEntry) error {
+	if err := DBSetWithTxn(txn, nil, _dbKeyForPinnedPostByPKID(entry), []byte{}); err != nil {
+		return errors.Wrapf(err, "DbPutPinnedPostEntryWithTxn: Problem putting index entry")
+	}
+	if err := DBSetWithTxn(txn, nil, _dbKeyForPinnedPostEntryByPostHash(entry.PostHash), entry.ToBytes()); err != nil {
+		return errors.Wrapf(err, "DbPutPinnedPostEntryWithTxn: Problem putting entry")
+	}
+	return nil
+}
+
+func DbDeletePinnedPostEntryWithTxn(txn *badger.Txn, entry *PinnedPostEntry) error {
+	if err := DBDeleteWithTxn(txn, nil, _dbKeyForPinnedPostByPKID(entry)); err != nil {
+		return errors.Wrapf(err, "DbDeletePinnedPostEntryWithTxn: Problem deleting index entry")
+	}
+	if err := DBDeleteWithTxn(txn, nil, _dbKeyForPinnedPostEntryByPostHash(entry.PostHash)); err != nil {
+		return errors.Wrapf(err, "DbDeletePinnedPostEntryWithTxn: Problem deleting entry")
+	}
+	return nil
+}
+
+func DbGetPinnedPostEntryWithTxn(txn *badger.Txn, postHash *BlockHash) (*PinnedPostEntry, error) {
+	data, err := DBGetWithTxn(txn, nil, _dbKeyForPinnedPostEntryByPostHash(postHash))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetPinnedPostEntryWithTxn: Problem getting entry")
+	}
+	entry := &PinnedPostEntry{}
+	if err := entry.FromBytes(data); err != nil {
+		return nil, errors.Wrapf(err, "DbGetPinnedPostEntryWithTxn: ")
+	}
+	return entry, nil
+}
+
+func DbGetPinnedPostEntry(handle *badger.DB, postHash *BlockHash) (*PinnedPostEntry, error) {
+	var entry *PinnedPostEntry
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		entry, err = DbGetPinnedPostEntryWithTxn(txn, postHash)
+		return err
+	})
+	return entry, err
+}
+
+// DbGetPinnedPostHashesForPKID returns the hashes of the posts the PKID pinned, by pin order. Posts
+// with the same pin order are in post hash order. At most limit hashes are returned, or all of them
+// if limit is 0.
+func DbGetPinnedPostHashesForPKID(handle *badger.DB, posterPKID *PKID, limit int) ([]*BlockHash, error) {
+	prefix := _dbPrefixForPinnedPostsByPKID(posterPKID)
+	var postHashes []*BlockHash
+	err := handle.View(func(txn *badger.Txn) error {
+		iterator := txn.NewIterator(badger.IteratorOptions{PrefetchValues: false, Prefix: prefix})
+		defer iterator.Close()
+
+		for iterator.Seek(prefix); iterator.ValidForPrefix(prefix); iterator.Next() {
+			if limit > 0 && len(postHashes) >= limit {
+				break
+			}
+			key := iterator.Item().Key()
+			postHashes = append(postHashes, NewBlockHash(key[len(prefix)+8:]))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetPinnedPostHashesForPKID: ")
+	}
+	return postHashes, nil
+}
+
+// -------------------------------------------------------------------------------------
+// Post hashtag and mention mapping functions
+// <prefix_id, Hashtag []byte, TstampNanos uint64, PostHash [32]byte> -> <>
+// <prefix_id, MentionedPKID [33]byte, TstampNanos uint64, PostHash [32]byte> -> <>
+// <prefix_id, PostHash [32]byte> -> <PostHashtagsAndMentionsEntry>
+// -------------------------------------------------------------------------------------
+
+// The hashtag is length-prefixed, so that the prefix for one hashtag is never a prefix of another.
+func _dbPrefixForHashtag(hashtag string) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixHashtagTstampNanosPostHash...)
+	return append(prefixCopy, EncodeByteArray([]byte(hashtag))...)
+}
+
+func _dbPrefixForMentionedPKID(mentionedPKID *PKID) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixMentionedPKIDTstampNanosPostHash...)
+	return append(prefixCopy, mentionedPKID[:]...)
+}
+
+func _dbKeyForPostHashtagOrMention(prefix []byte, tstampNanos uint64, postHash *BlockHash) []byte {
+	key := append([]byte{}, prefix...)
+	key = append(key, EncodeUint64(tstampNanos)...)
+	return append(key, postHash[:]...)
+}
+
+func _dbKeyForPostHashtagsAndMentionsByPostHash(postHash *BlockHash) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixPostHashtagsAndMentionsByPostHash...)
+	return append(prefixCopy, postHash[:]...)
+}
+
+// _dbGetPostHashtagAndMentionIndexKeys returns the index keys of the entry's hashtags and mentions.
+func _dbGetPostHashtagAndMentionIndexKeys(entry *PostHashtagsAndMentionsEntry) [][]byte {
+	var keys [][]byte
+	for _, hashtag := range entry.Hashtags {
+		keys = append(keys, _dbKeyForPostHashtagOrMention(
+			_dbPrefixForHashtag(hashtag), entry.TimestampNanos, entry.PostHash))
+	}
+	for _, mentionedPKID := range entry.MentionedPKIDs {
+		keys = append(keys, _dbKeyForPostHashtagOrMention(
+			_dbPrefixForMentionedPKID(mentionedPKID), entry.TimestampNanos, entry.PostHash))
+	}
+	return keys
+}
+
+func DbPutPostHashtagsAndMentionsEntryWithTxn(txn *badger.Txn, entry *PostHashtagsAndMentionsEntry) error {
+	for _, key := range _dbGetPostHashtagAndMentionIndexKeys(entry) {
+		if err := DBSetWithTxn(txn, nil, key, []byte{}); err != nil {
+			return errors.Wrapf(err, "DbPutPostHashtagsAndMentionsEntryWithTxn: Problem putting index entry")
+		}
+	}
+	if err := DBSetWithTxn(txn, nil, _dbKeyForPostHashtagsAndMentionsByPostHash(entry.PostHash),
+		entry.ToBytes()); err != nil {
+		return errors.Wrapf(err, "DbPutPostHashtagsAndMentionsEntryWithTxn: Problem putting entry")
+	}
+	return nil
+}
+
+func DbDeletePostHashtagsAndMentionsEntryWithTxn(txn *badger.Txn, entry *PostHashtagsAndMentionsEntry) error {
+	for _, key := range _dbGetPostHashtagAndMentionIndexKeys(entry) {
+		if err := DBDeleteWithTxn(txn, nil, key); err != nil {
+			return errors.Wrapf(err, "DbDeletePostHashtagsAndMentionsEntryWithTxn: Problem deleting index entry")
+		}
+	}
+	if err := DBDeleteWithTxn(txn, nil, _dbKeyForPostHashtagsAndMentionsByPostHash(entry.PostHash)); err != nil {
+		return errors.Wrapf(err, "DbDeletePostHashtagsAndMentionsEntryWithTxn: Problem deleting entry")
+	}
+	return nil
+}
+
+func DbGetPostHashtagsAndMentionsEntryWithTxn(txn *badger.Txn, postHash *BlockHash) (
+	*PostHashtagsAndMentionsEntry, error) {
+
+	data, err := DBGetWithTxn(txn, nil, _dbKeyForPostHashtagsAndMentionsByPostHash(postHash))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetPostHashtagsAndMentionsEntryWithTxn: Problem getting entry")
+	}
+	entry := &PostHashtagsAndMentionsEntry{}
+	if err := entry.FromBytes(data); err != nil {
+		return nil, errors.Wrapf(err, "DbGetPostHashtagsAndMentionsEntryWithTxn: ")
+	}
+	return entry, nil
+}
+
+func DbGetPostHashtagsAndMentionsEntry(handle *badger.DB, postHash *BlockHash) (*PostHashtagsAndMentionsEntry, error) {
+	var entry *PostHashtagsAndMentionsEntry
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		entry, err = DbGetPostHashtagsAndMentionsEntryWithTxn(txn, postHash)
+		return err
+	})
+	return entry, err
+}
+
+// _dbGetPaginatedPostHashesForPrefix returns up to limit post hashes of the hashtag or mention
+// index under the prefix, newest first. See DbGetPostHashesForHashtag.
+func _dbGetPaginatedPostHashesForPrefix(handle *badger.DB, prefix []byte, limit int, startKey []byte) (
+	_postHashes []*BlockHash, _nextStartKey []byte, _err error) {
+
+	if len(startKey) == 0 {
+		startKey = prefix
+	} else if !bytes.HasPrefix(startKey, prefix) {
+		return nil, nil, fmt.Errorf("_dbGetPaginatedPostHashesForPrefix: Start key %v doesn't "+
+			"have prefix %v", startKey, prefix)
+	}
+	keyLen := len(prefix) + 8 + HashSizeBytes
+	numToFetch := 0
+	if limit > 0 {
+		// Fetch one extra key so we know where the next page starts.
+		numToFetch = limit + 1
+	}
+
+	var postHashes []*BlockHash
+	var nextStartKey []byte
+	err := handle.View(func(txn *badger.Txn) error {
+		keysFound, _, err := DBGetPaginatedKeysAndValuesForPrefixWithTxn(
+			txn, startKey, prefix, keyLen, numToFetch, true /*reverse*/, false /*fetchValues*/)
+		if err != nil {
+			return err
+		}
+		if limit > 0 && len(keysFound) > limit {
+			nextStartKey = keysFound[limit]
+			keysFound = keysFound[:limit]
+		}
+		for _, keyBytes := range keysFound {
+			postHashes = append(postHashes, NewBlockHash(keyBytes[keyLen-HashSizeBytes:]))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "_dbGetPaginatedPostHashesForPrefix: ")
+	}
+	return postHashes, nextStartKey, nil
+}
+
+// DbGetPostHashesForHashtag returns up to limit hashes of the posts with the hashtag, newest first.
+// The hashtag is matched case-insensitively and without its leading #. A limit of zero returns all
+// of them. Pagination starts at startKey, which is either empty or the _nextStartKey returned for
+// the previous page. _nextStartKey is nil once there are no more posts.
+func DbGetPostHashesForHashtag(handle *badger.DB, hashtag string, limit int, startKey []byte) (
+	_postHashes []*BlockHash, _nextStartKey []byte, _err error) {
+
+	hashtag = strings.ToLower(strings.TrimPrefix(hashtag, "#"))
+	postHashes, nextStartKey, err := _dbGetPaginatedPostHashesForPrefix(
+		handle, _dbPrefixForHashtag(hashtag), limit, startKey)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "DbGetPostHashesForHashtag: ")
+	}
+	return postHashes, nextStartKey, nil
+}
+
+// DbGetPostHashesMentioningPKID returns up to limit hashes of the posts mentioning the PKID, newest
+// first. Pagination works like it does for DbGetPostHashesForHashtag.
+func DbGetPostHashesMentioningPKID(handle *badger.DB, mentionedPKID *PKID, limit int, startKey []byte) (
+	_postHashes []*BlockHash, _nextStartKey []byte, _err error) {
+
+	postHashes, nextStartKey, err := _dbGetPaginatedPostHashesForPrefix(
+		handle, _dbPrefixForMentionedPKID(mentionedPKID), limit, startKey)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "DbGetPostHashesMentioningPKID: ")
+	}
+	return postHashes, nextStartKey, nil
+}
+
+// -------------------------------------------------------------------------------------
+// Search index mapping functions
+// <prefix_id, Term []byte, TstampNanos uint64, PostHash [32]byte> -> <>
+// <prefix_id, PostHash [32]byte> -> <SearchIndexPostEntry>
+// <prefix_id, Term []byte, PKID [33]byte> -> <>
+// <prefix_id, PKID [33]byte> -> <SearchIndexProfileEntry>
+// -------------------------------------------------------------------------------------
+
+// Terms are length-prefixed, so that the prefix for one term is never a prefix of another.
+func _dbPrefixForSearchTermPosts(term string) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixSearchTermTstampNanosPostHash...)
+	return append(prefixCopy, EncodeByteArray([]byte(term))...)
+}
+
+func _dbKeyForSearchTermPost(term string, tstampNanos uint64, postHash *BlockHash) []byte {
+	key := _dbPrefixForSearchTermPosts(term)
+	key = append(key, EncodeUint64(tstampNanos)...)
+	return append(key, postHash[:]...)
+}
+
+func _dbKeyForSearchPostEntryByPostHash(postHash *BlockHash) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixSearchPostEntryByPostHash...)
+	return append(prefixCopy, postHash[:]...)
+}
+
+func _dbPrefixForSearchTermProfiles(term string) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixSearchTermProfilePKID...)
+	return append(prefixCopy, EncodeByteArray([]byte(term))...)
+}
+
+func _dbKeyForSearchTermProfile(term string, pkid *PKID) []byte {
+	return append(_dbPrefixForSearchTermProfiles(term), pkid[:]...)
+}
+
+func _dbKeyForSearchProfileEntryByPKID(pkid *PKID) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixSearchProfileEntryByPKID...)
+	return append(prefixCopy, pkid[:]...)
+}
+
+func DbPutSearchIndexPostEntryWithTxn(txn *badger.Txn, entry *SearchIndexPostEntry) error {
+	for _, term := range entry.Terms {
+		if err := DBSetWithTxn(txn, nil, _dbKeyForSearchTermPost(term, entry.TimestampNanos, entry.PostHash),
+			[]byte{}); err != nil {
+			return errors.Wrapf(err, "DbPutSearchIndexPostEntryWithTxn: Problem putting index entry")
+		}
+	}
+	if err := DBSetWithTxn(txn, nil, _dbKeyForSearchPostEntryByPostHash(entry.PostHash), entry.ToBytes()); err != nil {
+		return errors.Wrapf(err, "DbPutSearchIndexPostEntryWithTxn: Problem putting entry")
+	}
+	return nil
+}
+
+func DbDeleteSearchIndexPostEntryWithTxn(txn *badger.Txn, entry *SearchIndexPostEntry) error {
+	for _, term := range entry.Terms {
+		if err := DBDeleteWithTxn(txn, nil, _dbKeyForSearchTermPost(term, entry.TimestampNanos, entry.PostHash)); err != nil {
+			return errors.Wrapf(err, "DbDeleteSearchIndexPostEntryWithTxn: Problem deleting index entry")
+		}
+	}
+	if err := DBDeleteWithTxn(txn, nil, _dbKeyForSearchPostEntryByPostHash(entry.PostHash)); err != nil {
+		return errors.Wrapf(err, "DbDeleteSearchIndexPostEntryWithTxn: Problem deleting entry")
+	}
+	return nil
+}
+
+func DbGetSearchIndexPostEntryWithTxn(txn *badger.Txn, postHash *BlockHash) (*SearchIndexPostEntry, error) {
+	data, err := DBGetWithTxn(txn, nil, _dbKeyForSearchPostEntryByPostHash(postHash))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetSearchIndexPostEntryWithTxn: Problem getting entry")
+	}
+	entry := &SearchIndexPostEntry{}
+	if err := entry.FromBytes(data); err != nil {
+		return nil, errors.Wrapf(err, "DbGetSearchIndexPostEntryWithTxn: ")
+	}
+	return entry, nil
+}
+
+func DbPutSearchIndexProfileEntryWithTxn(txn *badger.Txn, entry *SearchIndexProfileEntry) error {
+	for _, term := range entry.Terms {
+		if err := DBSetWithTxn(txn, nil, _dbKeyForSearchTermProfile(term, entry.PKID), []byte{}); err != nil {
+			return errors.Wrapf(err, "DbPutSearchIndexProfileEntryWithTxn: Problem putting index entry")
+		}
+	}
+	if err := DBSetWithTxn(txn, nil, _dbKeyForSearchProfileEntryByPKID(entry.PKID), entry.ToBytes()); err != nil {
+		return errors.Wrapf(err, "DbPutSearchIndexProfileEntryWithTxn: Problem putting entry")
+	}
+	return nil
+}
+
+func DbDeleteSearchIndexProfileEntryWithTxn(txn *badger.Txn, entry *SearchIndexProfileEntry) error {
+	for _, term := range entry.Terms {
+		if err := DBDeleteWithTxn(txn, nil, _dbKeyForSearchTermProfile(term, entry.PKID)); err != nil {
+			return errors.Wrapf(err, "DbDeleteSearchIndexProfileEntryWithTxn: Problem deleting index entry")
+		}
+	}
+	if err := DBDeleteWithTxn(txn, nil, _dbKeyForSearchProfileEntryByPKID(entry.PKID)); err != nil {
+		return errors.Wrapf(err, "DbDeleteSearchIndexProfileEntryWithTxn: Problem deleting entry")
+	}
+	return nil
+}
+
+func DbGetSearchIndexProfileEntryWithTxn(txn *badger.Txn, pkid *PKID) (*SearchIndexProfileEntry, error) {
+	data, err := DBGetWithTxn(txn, nil, _dbKeyForSearchProfileEntryByPKID(pkid))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetSearchIndexProfileEntryWithTxn: Problem getting entry")
+	}
+	entry := &SearchIndexProfileEntry{}
+	if err := entry.FromBytes(data); err != nil {
+		return nil, errors.Wrapf(err, "DbGetSearchIndexProfileEntryWithTxn: ")
+	}
+	return entry, nil
+}
+
+func DbIsSearchIndexBuilt(handle *badger.DB) bool {
+	var isBuilt bool
+	handle.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(Prefixes.PrefixSearchIndexBuilt)
+		isBuilt = err == nil
+		return nil
+	})
+	return isBuilt
+}
+
+// DbSetSearchIndexBuilt marks the search index as built or stale.
+func DbSetSearchIndexBuilt(handle *badger.DB, isBuilt bool) error {
+	return handle.Update(func(txn *badger.Txn) error {
+		if isBuilt {
+			return txn.Set(Prefixes.PrefixSearchIndexBuilt, []byte{})
+		}
+		return txn.Delete(Prefixes.PrefixSearchIndexBuilt)
+	})
+}
+
+// -------------------------------------------------------------------------------------
+// Hotness feed mapping functions
+// <prefix_id, Score uint64, PostHash [32]byte> -> <>
+// <prefix_id, PostHash [32]byte> -> <HotnessFeedEntry>
+// <prefix_id> -> <HotnessFeedParams>
+// -------------------------------------------------------------------------------------
+
+func _dbKeyForHotnessScorePostHash(score float64, postHash *BlockHash) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	key := append([]byte{}, Prefixes.PrefixHotnessScorePostHash...)
+	key = append(key, EncodeUint64(math.Float64bits(score))...)
+	return append(key, postHash[:]...)
+}
+
+func _dbKeyForHotnessFeedEntryByPostHash(postHash *BlockHash) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixHotnessFeedEntryByPostHash...)
+	return append(prefixCopy, postHash[:]...)
+}
 
+func DbPutHotnessFeedEntryWithTxn(txn *badger.Txn, entry *HotnessFeedEntry) error {
+	if err := DBSetWithTxn(txn, nil, _dbKeyForHotnessScorePostHash(entry.Score, entry.PostHash), []byte{}); err != nil {
+		return errors.Wrapf(err, "DbPutHotnessFeedEntryWithTxn: Problem putting score")
+	}
+	if err := DBSetWithTxn(txn, nil, _dbKeyForHotnessFeedEntryByPostHash(entry.PostHash), entry.ToBytes()); err != nil {
+		return errors.Wrapf(err, "DbPutHotnessFeedEntryWithTxn: Problem putting entry")
+	}
 	return nil
 }
 
-func DbPutMempoolTxn(handle *badger.DB, snap *Snapshot, blockHeight uint64, mempoolTx *MempoolTx) error {
+func DbDeleteHotnessFeedEntryWithTxn(txn *badger.Txn, entry *HotnessFeedEntry) error {
+	if err := DBDeleteWithTxn(txn, nil, _dbKeyForHotnessScorePostHash(entry.Score, entry.PostHash)); err != nil {
+		return errors.Wrapf(err, "DbDeleteHotnessFeedEntryWithTxn: Problem deleting score")
+	}
+	if err := DBDeleteWithTxn(txn, nil, _dbKeyForHotnessFeedEntryByPostHash(entry.PostHash)); err != nil {
+		return errors.Wrapf(err, "DbDeleteHotnessFeedEntryWithTxn: Problem deleting entry")
+	}
+	return nil
+}
 
-	return handle.Update(func(txn *badger.Txn) error {
-		return DbPutMempoolTxnWithTxn(txn, snap, blockHeight, mempoolTx)
+func DbGetHotnessFeedEntryWithTxn(txn *badger.Txn, postHash *BlockHash) (*HotnessFeedEntry, error) {
+	data, err := DBGetWithTxn(txn, nil, _dbKeyForHotnessFeedEntryByPostHash(postHash))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetHotnessFeedEntryWithTxn: Problem getting entry")
+	}
+	entry := &HotnessFeedEntry{}
+	if err := entry.FromBytes(data); err != nil {
+		return nil, errors.Wrapf(err, "DbGetHotnessFeedEntryWithTxn: ")
+	}
+	return entry, nil
+}
+
+func DbGetHotnessFeedEntry(handle *badger.DB, postHash *BlockHash) (*HotnessFeedEntry, error) {
+	var entry *HotnessFeedEntry
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		entry, err = DbGetHotnessFeedEntryWithTxn(txn, postHash)
+		return err
 	})
+	return entry, err
 }
 
-func DbGetMempoolTxnWithTxn(txn *badger.Txn, snap *Snapshot, mempoolTx *MempoolTx) *MsgDeSoTxn {
+// DbGetHotPostHashes returns up to limit hashes of the posts in the hotness feed, hottest first.
+// A limit of zero returns all of them. Pagination works like it does for DbGetPostHashesForHashtag.
+func DbGetHotPostHashes(handle *badger.DB, limit int, startKey []byte) (
+	_postHashes []*BlockHash, _nextStartKey []byte, _err error) {
 
-	mempoolTxnObj := &MsgDeSoTxn{}
-	mempoolTxnBytes, err := DBGetWithTxn(txn, snap, _dbKeyForMempoolTxn(mempoolTx))
+	postHashes, nextStartKey, err := _dbGetPaginatedPostHashesForPrefix(
+		handle, Prefixes.PrefixHotnessScorePostHash, limit, startKey)
 	if err != nil {
-		return nil
+		return nil, nil, errors.Wrapf(err, "DbGetHotPostHashes: ")
 	}
+	return postHashes, nextStartKey, nil
+}
 
-	err = mempoolTxnObj.FromBytes(mempoolTxnBytes)
+// DbGetHotnessFeedParams returns the params the hotness feed's scores were computed with, or nil if
+// the scores are stale.
+func DbGetHotnessFeedParams(handle *badger.DB) (*HotnessFeedParams, error) {
+	var params *HotnessFeedParams
+	err := handle.View(func(txn *badger.Txn) error {
+		data, err := DBGetWithTxn(txn, nil, Prefixes.PrefixHotnessFeedParams)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		params = &HotnessFeedParams{}
+		return params.FromBytes(data)
+	})
 	if err != nil {
-		return nil
+		return nil, errors.Wrapf(err, "DbGetHotnessFeedParams: ")
 	}
-	return mempoolTxnObj
+	return params, nil
 }
 
-func DbGetMempoolTxn(db *badger.DB, snap *Snapshot, mempoolTx *MempoolTx) *MsgDeSoTxn {
-	var ret *MsgDeSoTxn
-	db.View(func(txn *badger.Txn) error {
-		ret = DbGetMempoolTxnWithTxn(txn, snap, mempoolTx)
-		return nil
+// DbPutHotnessFeedParams records the params the hotness feed's scores were computed with. Nil params
+// mark the scores as stale.
+func DbPutHotnessFeedParams(handle *badger.DB, params *HotnessFeedParams) error {
+	return handle.Update(func(txn *badger.Txn) error {
+		if params == nil {
+			return DBDeleteWithTxn(txn, nil, Prefixes.PrefixHotnessFeedParams)
+		}
+		return DBSetWithTxn(txn, nil, Prefixes.PrefixHotnessFeedParams, params.ToBytes())
 	})
-	return ret
 }
 
-func DbGetAllMempoolTxnsSortedByTimeAdded(handle *badger.DB) (_mempoolTxns []*MsgDeSoTxn, _error error) {
-	_, valuesFound := _enumerateKeysForPrefix(handle, Prefixes.PrefixMempoolTxnHashToMsgDeSoTxn)
+// -------------------------------------------------------------------------------------
+// Messaging key rotation mapping functions
+// <prefix_id, OwnerPublicKey [33]byte, GroupKeyName [32]byte, Epoch uint64> -> <MessagingKeyRotationRecord>
+// -------------------------------------------------------------------------------------
 
-	mempoolTxns := []*MsgDeSoTxn{}
-	for _, mempoolTxnBytes := range valuesFound {
-		mempoolTxn := &MsgDeSoTxn{}
-		err := mempoolTxn.FromBytes(mempoolTxnBytes)
+func _dbSeekPrefixForMessagingKeyRotations(ownerPublicKey *PublicKey) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixMessagingKeyRotations...)
+	return append(prefixCopy, ownerPublicKey[:]...)
+}
+
+func _dbPrefixForMessagingKeyRotations(ownerPublicKey *PublicKey, groupKeyName *GroupKeyName) []byte {
+	return append(_dbSeekPrefixForMessagingKeyRotations(ownerPublicKey), groupKeyName[:]...)
+}
+
+func _dbKeyForMessagingKeyRotation(ownerPublicKey *PublicKey, groupKeyName *GroupKeyName, epoch uint64) []byte {
+	return append(_dbPrefixForMessagingKeyRotations(ownerPublicKey, groupKeyName), EncodeUint64(epoch)...)
+}
+
+// DbAppendMessagingKeyRotation stores a rotation of the group's key as the given epoch, and returns
+// the record it stored. The epoch must be 0 for the group's first rotation and the group's current
+// epoch plus one after that, and the rotation can't be for a lower block height than the group's
+// current epoch.
+func DbAppendMessagingKeyRotation(handle *badger.DB, ownerPublicKey *PublicKey, groupKeyName *GroupKeyName,
+	epoch uint64, blockHeight uint64, messagingPublicKey *PublicKey, wrappedKeys []*MessagingGroupMember) (
+	*MessagingKeyRotationRecord, error) {
+
+	record := &MessagingKeyRotationRecord{
+		OwnerPublicKey:     ownerPublicKey,
+		GroupKeyName:       groupKeyName,
+		Epoch:              epoch,
+		BlockHeight:        blockHeight,
+		MessagingPublicKey: messagingPublicKey,
+		WrappedKeys:        wrappedKeys,
+	}
+	err := handle.Update(func(txn *badger.Txn) error {
+		latestRecord, err := DbGetLatestMessagingKeyRotationWithTxn(txn, ownerPublicKey, groupKeyName)
 		if err != nil {
-			return nil, errors.Wrapf(err, "DbGetAllMempoolTxnsSortedByTimeAdded: failed to decode mempoolTxnBytes.")
+			return err
+		}
+		expectedEpoch := uint64(0)
+		if latestRecord != nil {
+			if blockHeight < latestRecord.BlockHeight {
+				return fmt.Errorf("Block height %v is lower than the block height %v of epoch %v",
+					blockHeight, latestRecord.BlockHeight, latestRecord.Epoch)
+			}
+			expectedEpoch = latestRecord.Epoch + 1
 		}
-		mempoolTxns = append(mempoolTxns, mempoolTxn)
+		if epoch != expectedEpoch {
+			return fmt.Errorf("Epoch %v isn't the group's next epoch %v", epoch, expectedEpoch)
+		}
+		return DBSetWithTxn(txn, nil, _dbKeyForMessagingKeyRotation(
+			ownerPublicKey, groupKeyName, epoch), record.ToBytes())
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbAppendMessagingKeyRotation: Problem appending rotation")
 	}
+	return record, nil
+}
 
-	// We don't need to sort the transactions because the DB keys include the time added and
-	// are therefore retrieved from badger in order.
+// DbGetLatestMessagingKeyRotationWithTxn returns nil if the group's key was never rotated.
+func DbGetLatestMessagingKeyRotationWithTxn(txn *badger.Txn, ownerPublicKey *PublicKey,
+	groupKeyName *GroupKeyName) (*MessagingKeyRotationRecord, error) {
 
-	return mempoolTxns, nil
-}
+	prefix := _dbPrefixForMessagingKeyRotations(ownerPublicKey, groupKeyName)
+	iterator := txn.NewIterator(badger.IteratorOptions{Reverse: true, Prefix: prefix})
+	defer iterator.Close()
 
-func DbDeleteAllMempoolTxnsWithTxn(txn *badger.Txn, snap *Snapshot) error {
-	txnKeysFound, _, err := _enumerateKeysForPrefixWithTxn(txn, Prefixes.PrefixMempoolTxnHashToMsgDeSoTxn)
+	// Since we iterate backwards, we seek past the highest possible epoch.
+	iterator.Seek(append(append([]byte{}, prefix...), EncodeUint64(math.MaxUint64)...))
+	if !iterator.ValidForPrefix(prefix) {
+		return nil, nil
+	}
+	data, err := iterator.Item().ValueCopy(nil)
 	if err != nil {
-		return errors.Wrapf(err, "DbDeleteAllMempoolTxnsWithTxn: ")
+		return nil, errors.Wrapf(err, "DbGetLatestMessagingKeyRotationWithTxn: Problem getting value")
 	}
+	record := &MessagingKeyRotationRecord{}
+	if err := record.FromBytes(data); err != nil {
+		return nil, errors.Wrapf(err, "DbGetLatestMessagingKeyRotationWithTxn: ")
+	}
+	return record, nil
+}
+
+func DbGetLatestMessagingKeyRotation(handle *badger.DB, ownerPublicKey *PublicKey,
+	groupKeyName *GroupKeyName) (*MessagingKeyRotationRecord, error) {
+
+	var record *MessagingKeyRotationRecord
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		record, err = DbGetLatestMessagingKeyRotationWithTxn(txn, ownerPublicKey, groupKeyName)
+		return err
+	})
+	return record, err
+}
+
+// DbGetMessagingKeyRotation returns nil if the group has no such epoch.
+func DbGetMessagingKeyRotation(handle *badger.DB, ownerPublicKey *PublicKey, groupKeyName *GroupKeyName,
+	epoch uint64) (*MessagingKeyRotationRecord, error) {
 
-	for _, txnKey := range txnKeysFound {
-		err := DbDeleteMempoolTxnKeyWithTxn(txn, snap, txnKey)
+	var record *MessagingKeyRotationRecord
+	err := handle.View(func(txn *badger.Txn) error {
+		data, err := DBGetWithTxn(txn, nil, _dbKeyForMessagingKeyRotation(ownerPublicKey, groupKeyName, epoch))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
 		if err != nil {
-			return errors.Wrapf(err, "DbDeleteAllMempoolTxMappings: Deleting mempool txnKey failed.")
+			return err
 		}
+		record = &MessagingKeyRotationRecord{}
+		return record.FromBytes(data)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetMessagingKeyRotation: Problem getting epoch %v", epoch)
 	}
-
-	return nil
+	return record, nil
 }
 
-func FlushMempoolToDbWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64, allTxns []*MempoolTx) error {
-	for _, mempoolTx := range allTxns {
-		err := DbPutMempoolTxnWithTxn(txn, snap, blockHeight, mempoolTx)
-		if err != nil {
-			return errors.Wrapf(err, "FlushMempoolToDb: Putting "+
-				"mempool tx hash %s failed.", mempoolTx.Hash.String())
+// DbGetMessagingKeyRotationsForOwnerWithTxn returns the rotations of all the owner's groups, ordered
+// by group key name and then by epoch.
+func DbGetMessagingKeyRotationsForOwnerWithTxn(txn *badger.Txn, ownerPublicKey *PublicKey) (
+	[]*MessagingKeyRotationRecord, error) {
+
+	_, valsFound, err := _enumerateKeysForPrefixWithTxn(txn, _dbSeekPrefixForMessagingKeyRotations(ownerPublicKey))
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetMessagingKeyRotationsForOwnerWithTxn: Problem getting rotations")
+	}
+	var records []*MessagingKeyRotationRecord
+	for _, val := range valsFound {
+		record := &MessagingKeyRotationRecord{}
+		if err := record.FromBytes(val); err != nil {
+			return nil, errors.Wrapf(err, "DbGetMessagingKeyRotationsForOwnerWithTxn: ")
 		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// -------------------------------------------------------------------------------------
+// Block reward payout mapping functions
+// <prefix_id, PublicKey [33]byte, Height uint64> -> <AmountNanos uint64>
+// <prefix_id, PublicKey [33]byte> -> <AmountNanos uint64>
+// -------------------------------------------------------------------------------------
+
+func _dbPrefixForBlockRewardPayouts(publicKey []byte) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixBlockRewardPayouts...)
+	return append(prefixCopy, publicKey...)
+}
+
+func _dbKeyForBlockRewardPayout(publicKey []byte, height uint64) []byte {
+	return append(_dbPrefixForBlockRewardPayouts(publicKey), EncodeUint64(height)...)
+}
+
+func _dbKeyForBlockRewardLifetimeEarnings(publicKey []byte) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixBlockRewardLifetimeEarnings...)
+	return append(prefixCopy, publicKey...)
+}
+
+// DbGetBlockRewardPayoutWithTxn returns false if the public key has no payout at the height.
+func DbGetBlockRewardPayoutWithTxn(txn *badger.Txn, publicKey []byte, height uint64) (
+	_amountNanos uint64, _exists bool, _err error) {
+
+	amountBytes, err := DBGetWithTxn(txn, nil, _dbKeyForBlockRewardPayout(publicKey, height))
+	if err == badger.ErrKeyNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "DbGetBlockRewardPayoutWithTxn: Problem getting payout")
 	}
+	return DecodeUint64(amountBytes), true, nil
+}
 
+func DbPutBlockRewardPayoutWithTxn(txn *badger.Txn, publicKey []byte, height uint64, amountNanos uint64) error {
+	if err := DBSetWithTxn(txn, nil, _dbKeyForBlockRewardPayout(publicKey, height), EncodeUint64(amountNanos)); err != nil {
+		return errors.Wrapf(err, "DbPutBlockRewardPayoutWithTxn: Problem putting payout")
+	}
 	return nil
 }
 
-func FlushMempoolToDb(handle *badger.DB, snap *Snapshot, blockHeight uint64, allTxns []*MempoolTx) error {
-	err := handle.Update(func(txn *badger.Txn) error {
-		return FlushMempoolToDbWithTxn(txn, snap, blockHeight, allTxns)
+func DbDeleteBlockRewardPayoutWithTxn(txn *badger.Txn, publicKey []byte, height uint64) error {
+	if err := DBDeleteWithTxn(txn, nil, _dbKeyForBlockRewardPayout(publicKey, height)); err != nil {
+		return errors.Wrapf(err, "DbDeleteBlockRewardPayoutWithTxn: Problem deleting payout")
+	}
+	return nil
+}
+
+// DbGetBlockRewardPayouts returns the public key's payouts from startHeight up to and including
+// endHeight, in height order.
+func DbGetBlockRewardPayouts(handle *badger.DB, publicKey []byte, startHeight uint64, endHeight uint64) (
+	[]*BlockRewardPayout, error) {
+
+	prefix := _dbPrefixForBlockRewardPayouts(publicKey)
+	var payouts []*BlockRewardPayout
+	err := handle.View(func(txn *badger.Txn) error {
+		iterator := txn.NewIterator(badger.IteratorOptions{PrefetchValues: true, Prefix: prefix})
+		defer iterator.Close()
+
+		for iterator.Seek(_dbKeyForBlockRewardPayout(publicKey, startHeight)); iterator.ValidForPrefix(prefix); iterator.Next() {
+			height := DecodeUint64(iterator.Item().Key()[len(prefix):])
+			if height > endHeight {
+				break
+			}
+			amountBytes, err := iterator.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			payouts = append(payouts, &BlockRewardPayout{
+				PublicKey:   publicKey,
+				Height:      height,
+				AmountNanos: DecodeUint64(amountBytes),
+			})
+		}
+		return nil
 	})
 	if err != nil {
-		return err
+		return nil, errors.Wrapf(err, "DbGetBlockRewardPayouts: ")
 	}
+	return payouts, nil
+}
 
-	return nil
+func DbGetBlockRewardLifetimeEarningsWithTxn(txn *badger.Txn, publicKey []byte) (uint64, error) {
+	amountBytes, err := DBGetWithTxn(txn, nil, _dbKeyForBlockRewardLifetimeEarnings(publicKey))
+	if err == badger.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "DbGetBlockRewardLifetimeEarningsWithTxn: Problem getting earnings")
+	}
+	return DecodeUint64(amountBytes), nil
 }
 
-func DbDeleteAllMempoolTxns(handle *badger.DB, snap *Snapshot) error {
-	handle.Update(func(txn *badger.Txn) error {
-		return DbDeleteAllMempoolTxnsWithTxn(txn, snap)
+func DbGetBlockRewardLifetimeEarnings(handle *badger.DB, publicKey []byte) (uint64, error) {
+	var amountNanos uint64
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		amountNanos, err = DbGetBlockRewardLifetimeEarningsWithTxn(txn, publicKey)
+		return err
 	})
+	return amountNanos, err
+}
 
+// DbPutBlockRewardLifetimeEarningsWithTxn deletes the record if the earnings are zero.
+func DbPutBlockRewardLifetimeEarningsWithTxn(txn *badger.Txn, publicKey []byte, amountNanos uint64) error {
+	key := _dbKeyForBlockRewardLifetimeEarnings(publicKey)
+	if amountNanos == 0 {
+		if err := DBDeleteWithTxn(txn, nil, key); err != nil {
+			return errors.Wrapf(err, "DbPutBlockRewardLifetimeEarningsWithTxn: Problem deleting earnings")
+		}
+		return nil
+	}
+	if err := DBSetWithTxn(txn, nil, key, EncodeUint64(amountNanos)); err != nil {
+		return errors.Wrapf(err, "DbPutBlockRewardLifetimeEarningsWithTxn: Problem putting earnings")
+	}
 	return nil
 }
 
-func DbDeleteMempoolTxnWithTxn(txn *badger.Txn, snap *Snapshot, mempoolTx *MempoolTx) error {
+// -------------------------------------------------------------------------------------
+// Stale DAO coin limit order mapping functions
+// <prefix_id, TransactorPKID, OrderID> -> <StaleDAOCoinLimitOrder>
+// -------------------------------------------------------------------------------------
 
-	// When a mapping exists, delete it.
-	if err := DBDeleteWithTxn(txn, snap, _dbKeyForMempoolTxn(mempoolTx)); err != nil {
-		return errors.Wrapf(err, "DbDeleteMempoolTxMappingWithTxn: Deleting "+
-			"mempool tx key failed.")
+func _dbPrefixForStaleDAOCoinLimitOrders(transactorPKID *PKID) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixStaleDAOCoinLimitOrders...)
+	return append(prefixCopy, transactorPKID[:]...)
+}
+
+func _dbKeyForStaleDAOCoinLimitOrder(transactorPKID *PKID, orderID *BlockHash) []byte {
+	return append(_dbPrefixForStaleDAOCoinLimitOrders(transactorPKID), orderID[:]...)
+}
+
+func DbPutStaleDAOCoinLimitOrderWithTxn(txn *badger.Txn, staleOrder *StaleDAOCoinLimitOrder) error {
+	key := _dbKeyForStaleDAOCoinLimitOrder(staleOrder.TransactorPKID, staleOrder.OrderID)
+	if err := DBSetWithTxn(txn, nil, key, staleOrder.ToBytes()); err != nil {
+		return errors.Wrapf(err, "DbPutStaleDAOCoinLimitOrderWithTxn: Problem putting stale order")
 	}
+	return nil
+}
 
+// DbDeleteStaleDAOCoinLimitOrdersForTransactorWithTxn deletes all of the transactor's stale order records.
+func DbDeleteStaleDAOCoinLimitOrdersForTransactorWithTxn(txn *badger.Txn, transactorPKID *PKID) error {
+	keysFound, _, err := _enumerateKeysForPrefixWithTxn(txn, _dbPrefixForStaleDAOCoinLimitOrders(transactorPKID))
+	if err != nil {
+		return errors.Wrapf(err, "DbDeleteStaleDAOCoinLimitOrdersForTransactorWithTxn: Problem getting stale orders")
+	}
+	for _, key := range keysFound {
+		if err = DBDeleteWithTxn(txn, nil, key); err != nil {
+			return errors.Wrapf(err, "DbDeleteStaleDAOCoinLimitOrdersForTransactorWithTxn: Problem deleting stale order")
+		}
+	}
 	return nil
 }
 
-func DbDeleteMempoolTxn(handle *badger.DB, snap *Snapshot, mempoolTx *MempoolTx) error {
-	return handle.Update(func(txn *badger.Txn) error {
-		return DbDeleteMempoolTxnWithTxn(txn, snap, mempoolTx)
+func _decodeStaleDAOCoinLimitOrders(valsFound [][]byte) ([]*StaleDAOCoinLimitOrder, error) {
+	staleOrders := []*StaleDAOCoinLimitOrder{}
+	for _, valBytes := range valsFound {
+		staleOrder := &StaleDAOCoinLimitOrder{}
+		if err := staleOrder.FromBytes(valBytes); err != nil {
+			return nil, errors.Wrapf(err, "_decodeStaleDAOCoinLimitOrders: Problem decoding stale order")
+		}
+		staleOrders = append(staleOrders, staleOrder)
+	}
+	return staleOrders, nil
+}
+
+func DbGetStaleDAOCoinLimitOrdersForTransactorWithTxn(txn *badger.Txn, transactorPKID *PKID) (
+	[]*StaleDAOCoinLimitOrder, error) {
+
+	_, valsFound, err := _enumerateKeysForPrefixWithTxn(txn, _dbPrefixForStaleDAOCoinLimitOrders(transactorPKID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetStaleDAOCoinLimitOrdersForTransactorWithTxn: Problem getting stale orders")
+	}
+	return _decodeStaleDAOCoinLimitOrders(valsFound)
+}
+
+func DbGetStaleDAOCoinLimitOrdersForTransactor(handle *badger.DB, transactorPKID *PKID) (
+	[]*StaleDAOCoinLimitOrder, error) {
+
+	var staleOrders []*StaleDAOCoinLimitOrder
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		staleOrders, err = DbGetStaleDAOCoinLimitOrdersForTransactorWithTxn(txn, transactorPKID)
+		return err
 	})
+	return staleOrders, err
 }
 
-func DbDeleteMempoolTxnKey(handle *badger.DB, snap *Snapshot, txnKey []byte) error {
-	return handle.Update(func(txn *badger.Txn) error {
-		return DbDeleteMempoolTxnKeyWithTxn(txn, snap, txnKey)
+// DbGetStaleDAOCoinLimitOrders returns the stale order records of all transactors.
+func DbGetStaleDAOCoinLimitOrders(handle *badger.DB) ([]*StaleDAOCoinLimitOrder, error) {
+	_, valsFound := _enumerateKeysForPrefix(handle, Prefixes.PrefixStaleDAOCoinLimitOrders)
+	return _decodeStaleDAOCoinLimitOrders(valsFound)
+}
+
+// -------------------------------------------------------------------------------------
+// Follow event mapping functions
+// <prefix_id, FollowedPKID, BlockHeight uint64, FollowerPKID> -> <IsUnfollow bool>
+// -------------------------------------------------------------------------------------
+
+func _dbPrefixForFollowEvents(followedPKID *PKID) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixFollowEvents...)
+	return append(prefixCopy, followedPKID[:]...)
+}
+
+func _dbKeyForFollowEvent(followedPKID *PKID, blockHeight uint64, followerPKID *PKID) []byte {
+	key := append(_dbPrefixForFollowEvents(followedPKID), EncodeUint64(blockHeight)...)
+	return append(key, followerPKID[:]...)
+}
+
+func DbPutFollowEventWithTxn(txn *badger.Txn, event *FollowEvent) error {
+	key := _dbKeyForFollowEvent(event.FollowedPKID, event.BlockHeight, event.FollowerPKID)
+	if err := DBSetWithTxn(txn, nil, key, []byte{BoolToByte(event.IsUnfollow)}); err != nil {
+		return errors.Wrapf(err, "DbPutFollowEventWithTxn: Problem putting follow event")
+	}
+	return nil
+}
+
+func DbDeleteFollowEventWithTxn(txn *badger.Txn, event *FollowEvent) error {
+	key := _dbKeyForFollowEvent(event.FollowedPKID, event.BlockHeight, event.FollowerPKID)
+	if err := DBDeleteWithTxn(txn, nil, key); err != nil {
+		return errors.Wrapf(err, "DbDeleteFollowEventWithTxn: Problem deleting follow event")
+	}
+	return nil
+}
+
+// DbGetFollowEventsWithTxn returns the follow events of the PKID at heights in [startHeight, endHeight],
+// ordered by height.
+func DbGetFollowEventsWithTxn(txn *badger.Txn, followedPKID *PKID, startHeight uint64, endHeight uint64) (
+	[]*FollowEvent, error) {
+
+	iterator := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer iterator.Close()
+
+	events := []*FollowEvent{}
+	prefix := _dbPrefixForFollowEvents(followedPKID)
+	startKey := append(append([]byte{}, prefix...), EncodeUint64(startHeight)...)
+	for iterator.Seek(startKey); iterator.ValidForPrefix(prefix); iterator.Next() {
+		key := iterator.Item().Key()
+		blockHeight := DecodeUint64(key[len(prefix) : len(prefix)+8])
+		if blockHeight > endHeight {
+			break
+		}
+		value, err := iterator.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DbGetFollowEventsWithTxn: Problem getting follow event")
+		}
+		isUnfollow, err := ReadBoolByte(bytes.NewReader(value))
+		if err != nil {
+			return nil, errors.Wrapf(err, "DbGetFollowEventsWithTxn: Problem decoding follow event")
+		}
+		events = append(events, &FollowEvent{
+			FollowedPKID: followedPKID.NewPKID(),
+			FollowerPKID: NewPKID(key[len(prefix)+8:]),
+			BlockHeight:  blockHeight,
+			IsUnfollow:   isUnfollow,
+		})
+	}
+	return events, nil
+}
+
+func DbGetFollowEvents(handle *badger.DB, followedPKID *PKID, startHeight uint64, endHeight uint64) (
+	[]*FollowEvent, error) {
+
+	var events []*FollowEvent
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		events, err = DbGetFollowEventsWithTxn(txn, followedPKID, startHeight, endHeight)
+		return err
 	})
+	return events, err
 }
 
-func DbDeleteMempoolTxnKeyWithTxn(txn *badger.Txn, snap *Snapshot, txnKey []byte) error {
+// -------------------------------------------------------------------------------------
+// Node info mapping functions
+// <prefix_id> -> <NodeInfo>
+// -------------------------------------------------------------------------------------
 
-	// When a mapping exists, delete it.
-	if err := DBDeleteWithTxn(txn, snap, txnKey); err != nil {
-		return errors.Wrapf(err, "DbDeleteMempoolTxMappingWithTxn: Deleting "+
-			"mempool tx key failed.")
+func DbPutNodeInfoWithTxn(txn DeSoDBTxn, nodeInfo *NodeInfo) error {
+	if err := DBSetWithDeSoDBTxn(txn, nil, Prefixes.PrefixNodeInfo, nodeInfo.ToBytes()); err != nil {
+		return errors.Wrapf(err, "DbPutNodeInfoWithTxn: Problem putting node info")
+	}
+	return nil
+}
+
+// DbGetNodeInfoWithTxn returns nil if no node info was ever written to the db, i.e. the data
+// directory was created before the record existed.
+func DbGetNodeInfoWithTxn(txn DeSoDBTxn) (*NodeInfo, error) {
+	data, err := DBGetWithDeSoDBTxn(txn, nil, Prefixes.PrefixNodeInfo)
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetNodeInfoWithTxn: Problem getting node info")
+	}
+	nodeInfo := &NodeInfo{}
+	if err = nodeInfo.FromBytes(bytes.NewReader(data)); err != nil {
+		return nil, errors.Wrapf(err, "DbGetNodeInfoWithTxn: Problem decoding node info")
 	}
+	return nodeInfo, nil
+}
+
+func DbGetNodeInfo(handle DeSoDB) (*NodeInfo, error) {
+	var nodeInfo *NodeInfo
+	err := handle.View(func(txn DeSoDBTxn) error {
+		var err error
+		nodeInfo, err = DbGetNodeInfoWithTxn(txn)
+		return err
+	})
+	return nodeInfo, err
+}
 
+// -------------------------------------------------------------------------------------
+// Chain state import progress mapping functions
+// <prefix_id> -> <ChainStateImportProgress>
+// -------------------------------------------------------------------------------------
+
+func DbPutChainStateImportProgressWithTxn(txn *badger.Txn, importProgress *ChainStateImportProgress) error {
+	if err := DBSetWithTxn(txn, nil, Prefixes.PrefixChainStateImportProgress, importProgress.ToBytes()); err != nil {
+		return errors.Wrapf(err, "DbPutChainStateImportProgressWithTxn: Problem putting import progress")
+	}
 	return nil
 }
 
-func LogDBSummarySnapshot(db *badger.DB) {
-	keyCountMap := make(map[byte]int)
-	for prefixByte := byte(0); prefixByte < byte(40); prefixByte++ {
-		keysForPrefix, _ := EnumerateKeysForPrefix(db, []byte{prefixByte})
-		keyCountMap[prefixByte] = len(keysForPrefix)
+// DbGetChainStateImportProgressWithTxn returns nil if there's no unfinished import.
+func DbGetChainStateImportProgressWithTxn(txn *badger.Txn) (*ChainStateImportProgress, error) {
+	data, err := DBGetWithTxn(txn, nil, Prefixes.PrefixChainStateImportProgress)
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetChainStateImportProgressWithTxn: Problem getting import progress")
 	}
-	glog.Info(spew.Printf("LogDBSummarySnapshot: Current DB summary snapshot: %v", keyCountMap))
+	importProgress := &ChainStateImportProgress{}
+	if err = importProgress.FromBytes(bytes.NewReader(data)); err != nil {
+		return nil, errors.Wrapf(err, "DbGetChainStateImportProgressWithTxn: Problem decoding import progress")
+	}
+	return importProgress, nil
 }
 
-func StartDBSummarySnapshots(db *badger.DB) {
-	// Periodically count the number of keys for each prefix in the DB and log.
-	go func() {
-		for {
-			// Figure out how many keys there are for each prefix and log.
-			glog.Info("StartDBSummarySnapshots: Counting DB keys...")
-			LogDBSummarySnapshot(db)
-			time.Sleep(30 * time.Second)
-		}
-	}()
+func DbGetChainStateImportProgress(handle *badger.DB) (*ChainStateImportProgress, error) {
+	var importProgress *ChainStateImportProgress
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		importProgress, err = DbGetChainStateImportProgressWithTxn(txn)
+		return err
+	})
+	return importProgress, err
 }
 
 const (
@@ -8252,3 +14594,27 @@ func PerformanceBadgerOptions(dir string) badger.Options {
 
 	return opts
 }
+
+// _deleteKeysUnderPrefixInBatches deletes every record under the prefix, RepairPrefixBatchSize
+// records per txn.
+func _deleteKeysUnderPrefixInBatches(db *badger.DB, prefix []byte) error {
+	keys, _ := _enumerateKeysForPrefix(db, prefix)
+	for start := 0; start < len(keys); start += RepairPrefixBatchSize {
+		end := start + RepairPrefixBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		err := db.Update(func(txn *badger.Txn) error {
+			for _, key := range keys[start:end] {
+				if err := DBDeleteWithTxn(txn, nil, key); err != nil {
+					return errors.Wrapf(err, "Problem deleting key %v", key)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,517 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// InitDbWithGenesisBlock initializes the database to contain only the genesis
+// block.
+func InitDbWithDeSoGenesisBlock(params *DeSoParams, handle *badger.DB,
+	eventManager *EventManager, snap *Snapshot) error {
+	// Construct a node for the genesis block. Its height is zero and it has
+	// no parents. Its difficulty should be set to the initial
+	// difficulty specified in the parameters and it should be assumed to be
+	// valid and stored by the end of this function.
+	genesisBlock := params.GenesisBlock
+	diffTarget := MustDecodeHexBlockHash(params.MinDifficultyTargetHex)
+	blockHash := MustDecodeHexBlockHash(params.GenesisBlockHashHex)
+	genesisNode := NewBlockNode(
+		nil, // Parent
+		blockHash,
+		0, // Height
+		diffTarget,
+		BytesToBigint(ExpectedWorkForBlockHash(diffTarget)[:]), // CumWork
+		genesisBlock.Header, // Header
+		StatusHeaderValidated|StatusBlockProcessed|StatusBlockStored|StatusBlockValidated, // Status
+	)
+
+	// Set the fields in the db to reflect the current state of our chain.
+	//
+	// Set the best hash to the genesis block in the db since its the only node
+	// we're currently aware of. Set it for both the header chain and the block
+	// chain.
+	if snap != nil {
+		snap.PrepareAncestralRecordsFlush()
+	}
+
+	err := handle.Update(func(txn *badger.Txn) error {
+		if err := PutBestHashWithTxn(txn, snap, blockHash, ChainTypeDeSoBlock); err != nil {
+			return err
+		}
+		if err := DbPutMainChainHashAtHeightWithTxn(txn, 0, blockHash); err != nil {
+			return err
+		}
+		return DbPutStateFlushHeightWithTxn(txn, snap, 0)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "InitDbWithGenesisBlock: Problem putting genesis block hash into db for block chain")
+	}
+	// Add the genesis block to the (hash -> block) index.
+	if err := PutBlock(handle, snap, genesisBlock); err != nil {
+		return errors.Wrapf(err, "InitDbWithGenesisBlock: Problem putting genesis block into db")
+	}
+	// Add the genesis block to the (height, hash -> node info) index in the db.
+	if err := PutHeightHashToNodeInfo(handle, snap, genesisNode, false /*bitcoinNodes*/); err != nil {
+		return errors.Wrapf(err, "InitDbWithGenesisBlock: Problem putting (height, hash -> node) in db")
+	}
+	if err := DbPutNanosPurchased(handle, snap, params.DeSoNanosPurchasedAtGenesis); err != nil {
+		return errors.Wrapf(err, "InitDbWithGenesisBlock: Problem putting genesis block hash into db for block chain")
+	}
+	if err := DbPutGlobalParamsEntry(handle, snap, 0, InitialGlobalParamsEntry); err != nil {
+		return errors.Wrapf(err, "InitDbWithGenesisBlock: Problem putting GlobalParamsEntry into db for block chain")
+	}
+
+	if snap != nil {
+		snap.InvalidateNegativeLookupsAfterCommit()
+		snap.StartAncestralRecordsFlush(true)
+	}
+
+	// We apply seed transactions here. This step is useful for setting
+	// up the blockchain with a particular set of transactions, e.g. when
+	// hard forking the chain.
+	//
+	// TODO: Right now there's an issue where if we hit an error during this
+	// step of the initialization, the next time we run the program it will
+	// think things are initialized because we set the best block hash at the
+	// top. We should fix this at some point so that an error in this step
+	// wipes out the best hash.
+	utxoView, err := NewUtxoView(handle, params, nil, snap)
+	if err != nil {
+		return fmt.Errorf(
+			"InitDbWithDeSoGenesisBlock: Error initializing UtxoView")
+	}
+
+	// Add the seed balances to the view.
+	for index, txOutput := range params.SeedBalances {
+		outputKey := UtxoKey{
+			TxID:  BlockHash{},
+			Index: uint32(index),
+		}
+		utxoEntry := UtxoEntry{
+			AmountNanos: txOutput.AmountNanos,
+			PublicKey:   txOutput.PublicKey,
+			BlockHeight: 0,
+			// Just make this a normal transaction so that we don't have to wait for
+			// the block reward maturity.
+			UtxoType: UtxoTypeOutput,
+			UtxoKey:  &outputKey,
+		}
+
+		_, err := utxoView._addUtxo(&utxoEntry)
+		if err != nil {
+			return fmt.Errorf("InitDbWithDeSoGenesisBlock: Error adding "+
+				"seed balance at index %v ; output: %v: %v", index, txOutput, err)
+		}
+	}
+
+	// Add the seed txns to the view
+	utxoOpsForBlock := [][]*UtxoOperation{}
+	for txnIndex, txnHex := range params.SeedTxns {
+		txnBytes, err := hex.DecodeString(txnHex)
+		if err != nil {
+			return fmt.Errorf(
+				"InitDbWithDeSoGenesisBlock: Error decoding seed "+
+					"txn HEX: %v, txn index: %v, txn hex: %v",
+				err, txnIndex, txnHex)
+		}
+		txn := &MsgDeSoTxn{}
+		if err := txn.FromBytes(txnBytes); err != nil {
+			return fmt.Errorf(
+				"InitDbWithDeSoGenesisBlock: Error decoding seed "+
+					"txn BYTES: %v, txn index: %v, txn hex: %v",
+				err, txnIndex, txnHex)
+		}
+		// Important: ignoreUtxos makes it so that the inputs/outputs aren't
+		// processed, which is important.
+		// Set txnSizeBytes to 0 here as the minimum network fee is 0 at genesis block, so there is no need to serialize
+		// these transactions to check if they meet the minimum network fee requirement.
+		var utxoOpsForTxn []*UtxoOperation
+		utxoOpsForTxn, _, _, _, err = utxoView.ConnectTransaction(
+			txn, txn.Hash(), 0, 0 /*blockHeight*/, false /*verifySignatures*/, true /*ignoreUtxos*/)
+		if err != nil {
+			return fmt.Errorf(
+				"InitDbWithDeSoGenesisBlock: Error connecting transaction: %v, "+
+					"txn index: %v, txn hex: %v",
+				err, txnIndex, txnHex)
+		}
+		utxoOpsForBlock = append(utxoOpsForBlock, utxoOpsForTxn)
+	}
+
+	// If we have an event manager, initialize the genesis block with the current
+	// state of the view.
+	if eventManager != nil {
+		eventManager.blockConnected(&BlockEvent{
+			Block:    genesisBlock,
+			UtxoView: utxoView,
+			UtxoOps:  utxoOpsForBlock,
+		})
+	}
+
+	// Flush all the data in the view.
+	err = utxoView.FlushToDb(0)
+	if err != nil {
+		return fmt.Errorf(
+			"InitDbWithDeSoGenesisBlock: Error flushing seed txns to DB: %v", err)
+	}
+
+	return nil
+}
+
+// GetSingleBalanceEntryFromPublicKeys fetches a single balance entry of a holder's creator or DAO coin.
+// Returns nil if the balance entry never existed.
+// TODO: This is suboptimal, shouldn't be passing UtxoView
+func GetSingleBalanceEntryFromPublicKeys(holder []byte, creator []byte, utxoView *UtxoView, isDAOCoin bool) (*BalanceEntry, error) {
+	holderPKIDEntry := utxoView.GetPKIDForPublicKey(holder)
+	if holderPKIDEntry == nil || holderPKIDEntry.isDeleted {
+		return nil, fmt.Errorf("DbGetSingleBalanceEntryFromPublicKeys: holderPKID was nil or deleted; this should never happen")
+	}
+	holderPKID := holderPKIDEntry.PKID
+	creatorPKIDEntry := utxoView.GetPKIDForPublicKey(creator)
+	if creatorPKIDEntry == nil || creatorPKIDEntry.isDeleted {
+		return nil, fmt.Errorf("DbGetSingleBalanceEntryFromPublicKeys: creatorPKID was nil or deleted; this should never happen")
+	}
+	creatorPKID := creatorPKIDEntry.PKID
+
+	// Check if there's a balance entry in the view
+	balanceEntryMapKey := MakeBalanceEntryKey(holderPKID, creatorPKID)
+	balanceEntryFromView := utxoView.GetHODLerPKIDCreatorPKIDToBalanceEntryMap(isDAOCoin)[balanceEntryMapKey]
+	if balanceEntryFromView != nil {
+		return balanceEntryFromView, nil
+	}
+
+	// Check if there's a balance entry in the database
+	balanceEntryFromDb := DbGetBalanceEntry(utxoView.Handle, utxoView.Snapshot, holderPKID, creatorPKID, isDAOCoin)
+	return balanceEntryFromDb, nil
+}
+
+func DBGetProfilesByUsernamePrefixAndDeSoLocked(db *badger.DB,
+	snap *Snapshot, usernamePrefix string, utxoView *UtxoView) (
+	_profileEntries []*ProfileEntry, _err error) {
+
+	startPrefix := append([]byte{}, Prefixes.PrefixProfileUsernameToPKID...)
+	lowercaseUsernamePrefixString := strings.ToLower(usernamePrefix)
+	lowercaseUsernamePrefix := []byte(lowercaseUsernamePrefixString)
+	startPrefix = append(startPrefix, lowercaseUsernamePrefix...)
+
+	_, pkidsFound, err := DBGetPaginatedKeysAndValuesForPrefix(
+		db /*db*/, startPrefix, /*startPrefix*/
+		startPrefix /*validForPrefix*/, 0, /*keyLen (ignored when reverse == false)*/
+		0 /*numToFetch (zero fetches all)*/, false, /*reverse*/
+		true /*fetchValues*/)
+	if err != nil {
+		return nil, fmt.Errorf("DBGetProfilesByUsernamePrefixAndDeSoLocked: %v", err)
+	}
+
+	// Have to do this to convert the PKIDs back into public keys
+	// TODO: We should clean things up around public keys vs PKIDs
+	pubKeysMap := make(map[PkMapKey][]byte)
+	for _, pkidBytesIter := range pkidsFound {
+		pkidBytes := pkidBytesIter
+		if len(pkidBytes) != btcec.PubKeyBytesLenCompressed {
+			continue
+		}
+		pkid := &PKID{}
+		copy(pkid[:], pkidBytes)
+		pubKey := DBGetPublicKeyForPKID(db, snap, pkid)
+		if len(pubKey) != 0 {
+			pubKeysMap[MakePkMapKey(pubKey)] = pubKey
+		}
+	}
+
+	for username, profileEntry := range utxoView.ProfileUsernameToProfileEntry {
+		if strings.HasPrefix(string(username[:]), lowercaseUsernamePrefixString) {
+			pkMapKey := MakePkMapKey(profileEntry.PublicKey)
+			pubKeysMap[pkMapKey] = profileEntry.PublicKey
+		}
+	}
+
+	// Sigh.. convert the public keys *back* into PKIDs...
+	profilesFound := []*ProfileEntry{}
+	for _, pkIter := range pubKeysMap {
+		pk := pkIter
+		pkid := utxoView.GetPKIDForPublicKey(pk).PKID
+		profile := utxoView.GetProfileEntryForPKID(pkid)
+		// Double-check that a username matches the prefix.
+		// If a user had the handle "elon" and then changed to "jeff" and that transaction hadn't mined yet,
+		// we would return the profile for "jeff" when we search for "elon" which is incorrect.
+		if profile != nil && strings.HasPrefix(strings.ToLower(string(profile.Username[:])), lowercaseUsernamePrefixString) {
+			profilesFound = append(profilesFound, profile)
+		}
+	}
+
+	// If there is no error, sort and return numToFetch. Username searches are always
+	// sorted by coin value.
+	sort.Slice(profilesFound, func(ii, jj int) bool {
+		return profilesFound[ii].CreatorCoinEntry.DeSoLockedNanos > profilesFound[jj].CreatorCoinEntry.DeSoLockedNanos
+	})
+
+	return profilesFound, nil
+}
+
+func DBGetMatchingDAOCoinLimitOrders(
+	txn *badger.Txn, inputOrder *DAOCoinLimitOrderEntry, lastSeenOrder *DAOCoinLimitOrderEntry,
+	orderEntriesInView map[DAOCoinLimitOrderMapKey]bool) ([]*DAOCoinLimitOrderEntry, error) {
+
+	queryOrder := inputOrder.Copy()
+	queryQuantityToFill := queryOrder.QuantityToFillInBaseUnits.Clone()
+
+	// Convert the input BID order to the ASK order to query for.
+	// Note that we seek in reverse for the best matching orders.
+	//   * Swap BuyingDAOCoinCreatorPKID and SellingDAOCoinCreatorPKID.
+	//   * Set ScaledExchangeRateCoinsToSellPerCoinToBuy to MaxUint256.
+	//   * Set BlockHeight to 0 as this becomes math.MaxUint32 in the key.
+	//   * Set OrderID to MaxBlockHash.
+	queryOrder.BuyingDAOCoinCreatorPKID = inputOrder.SellingDAOCoinCreatorPKID
+	queryOrder.SellingDAOCoinCreatorPKID = inputOrder.BuyingDAOCoinCreatorPKID
+	queryOrder.ScaledExchangeRateCoinsToSellPerCoinToBuy = MaxUint256.Clone()
+	queryOrder.BlockHeight = uint32(0)
+	queryOrder.OrderID = maxHash.NewBlockHash()
+
+	key := DBKeyForDAOCoinLimitOrder(queryOrder)
+	prefixKey := DBPrefixKeyForDAOCoinLimitOrder(queryOrder)
+
+	// If passed a last seen order, start seeking from there.
+	var startKey []byte
+	if lastSeenOrder != nil {
+		startKey = DBKeyForDAOCoinLimitOrder(lastSeenOrder)
+		key = startKey
+	}
+
+	// Go in reverse order to find the highest prices first.
+	// We break once we hit the input order's inverted scaled
+	// price or the input order's quantity is fulfilled.
+	opts := badger.DefaultIteratorOptions
+	opts.Reverse = true
+	iterator := txn.NewIterator(opts)
+	defer iterator.Close()
+
+	// Seek first matching order.
+	matchingOrders := []*DAOCoinLimitOrderEntry{}
+
+	for iterator.Seek(key); iterator.ValidForPrefix(prefixKey) && queryQuantityToFill.GtUint64(0); iterator.Next() {
+		// If picking up from where you left off, skip the first order which
+		// has already been processed previously.
+		if len(startKey) != 0 && bytes.Equal(key, startKey) {
+			startKey = nil
+			continue
+		}
+
+		matchingOrderBytes, err := iterator.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DBGetMatchingDAOCoinLimitOrders: problem getting limit order")
+		}
+
+		matchingOrder := &DAOCoinLimitOrderEntry{}
+		rr := bytes.NewReader(matchingOrderBytes)
+		if exist, err := DecodeFromBytes(matchingOrder, rr); !exist || err != nil {
+			return nil, errors.Wrapf(err, "DBGetMatchingDAOCoinLimitOrders: problem decoding limit order")
+		}
+
+		// Skip if order is already in the view.
+		if _, exists := orderEntriesInView[matchingOrder.ToMapKey()]; exists {
+			continue
+		}
+
+		// Validate matching order's price.
+		if !inputOrder.IsValidMatchingOrderPrice(matchingOrder) {
+			break
+		}
+
+		// Calculate how the transactor's quantity to fill will change
+		// after being matched with this order. If the transactor still
+		// has quantity to fill, we loop.
+		queryQuantityToFill, _, _, _, err = _calculateDAOCoinsTransferredInLimitOrderMatch(
+			matchingOrder, queryOrder.OperationType, queryQuantityToFill)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DBGetMatchingDAOCoinLimitOrders: ")
+		}
+
+		matchingOrders = append(matchingOrders, matchingOrder)
+	}
+
+	return matchingOrders, nil
+}
+
+// -------------------------------------------------------------------------------------
+// Mempool Txn mapping funcions
+// <prefix_id, txn hash BlockHash> -> <*MsgDeSoTxn>
+// -------------------------------------------------------------------------------------
+
+func _dbKeyForMempoolTxn(mempoolTx *MempoolTx) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, Prefixes.PrefixMempoolTxnHashToMsgDeSoTxn...)
+	timeAddedBytes := EncodeUint64(uint64(mempoolTx.Added.UnixNano()))
+	key := append(prefixCopy, timeAddedBytes...)
+	key = append(key, mempoolTx.Hash[:]...)
+
+	return key
+}
+
+// DbPutMempoolTxnWithTxn stores the txn along with its fee and validation metadata. The caller
+// should also store the format version with DbPutMempoolTxnFormatVersionWithTxn.
+func DbPutMempoolTxnWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64, mempoolTx *MempoolTx) error {
+
+	// Txns only make it into the mempool once they've connected to the mempool's view, so they've
+	// been validated against the block at blockHeight.
+	record := &MempoolTxDbRecord{
+		Tx:                   mempoolTx.Tx,
+		Fee:                  mempoolTx.Fee,
+		FeePerKB:             mempoolTx.FeePerKB,
+		IsValidated:          true,
+		ValidatedBlockHeight: blockHeight,
+	}
+	mempoolTxnBytes, err := record.ToBytes()
+	if err != nil {
+		return errors.Wrapf(err, "DbPutMempoolTxnWithTxn: Problem encoding mempoolTxn to bytes.")
+	}
+
+	if err := DBSetWithTxn(txn, snap, _dbKeyForMempoolTxn(mempoolTx), mempoolTxnBytes); err != nil {
+		return errors.Wrapf(err, "DbPutMempoolTxnWithTxn: Problem putting mapping for txn hash: %s", mempoolTx.Hash.String())
+	}
+
+	return nil
+}
+
+func DbPutMempoolTxn(handle *badger.DB, snap *Snapshot, blockHeight uint64, mempoolTx *MempoolTx) error {
+
+	return handle.Update(func(txn *badger.Txn) error {
+		if err := DbPutMempoolTxnFormatVersionWithTxn(txn, snap, MempoolTxnFormatVersion); err != nil {
+			return err
+		}
+		return DbPutMempoolTxnWithTxn(txn, snap, blockHeight, mempoolTx)
+	})
+}
+
+func DbGetMempoolTxnWithTxn(txn *badger.Txn, snap *Snapshot, mempoolTx *MempoolTx) *MsgDeSoTxn {
+
+	formatVersion, err := DbGetMempoolTxnFormatVersionWithTxn(txn, snap)
+	if err != nil {
+		return nil
+	}
+	mempoolTxnBytes, err := DBGetWithTxn(txn, snap, _dbKeyForMempoolTxn(mempoolTx))
+	if err != nil {
+		return nil
+	}
+
+	record, err := _decodeMempoolTxDbRecord(formatVersion, mempoolTxnBytes)
+	if err != nil {
+		return nil
+	}
+	return record.Tx
+}
+
+func DbGetMempoolTxn(db *badger.DB, snap *Snapshot, mempoolTx *MempoolTx) *MsgDeSoTxn {
+	var ret *MsgDeSoTxn
+	db.View(func(txn *badger.Txn) error {
+		ret = DbGetMempoolTxnWithTxn(txn, snap, mempoolTx)
+		return nil
+	})
+	return ret
+}
+
+func DbGetAllMempoolTxnsSortedByTimeAdded(handle *badger.DB) (_mempoolTxns []*MsgDeSoTxn, _error error) {
+	records, err := DbGetAllMempoolTxDbRecordsSortedByTimeAdded(handle)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetAllMempoolTxnsSortedByTimeAdded: ")
+	}
+
+	mempoolTxns := []*MsgDeSoTxn{}
+	for _, record := range records {
+		mempoolTxns = append(mempoolTxns, record.Tx)
+	}
+	return mempoolTxns, nil
+}
+
+func DbDeleteAllMempoolTxnsWithTxn(txn *badger.Txn, snap *Snapshot) error {
+	txnKeysFound, _, err := _enumerateKeysForPrefixWithTxn(txn, Prefixes.PrefixMempoolTxnHashToMsgDeSoTxn)
+	if err != nil {
+		return errors.Wrapf(err, "DbDeleteAllMempoolTxnsWithTxn: ")
+	}
+
+	for _, txnKey := range txnKeysFound {
+		err := DbDeleteMempoolTxnKeyWithTxn(txn, snap, txnKey)
+		if err != nil {
+			return errors.Wrapf(err, "DbDeleteAllMempoolTxMappings: Deleting mempool txnKey failed.")
+		}
+	}
+
+	return nil
+}
+
+func FlushMempoolToDbWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64, allTxns []*MempoolTx) error {
+	if err := DbPutMempoolTxnFormatVersionWithTxn(txn, snap, MempoolTxnFormatVersion); err != nil {
+		return errors.Wrapf(err, "FlushMempoolToDb: ")
+	}
+	for _, mempoolTx := range allTxns {
+		err := DbPutMempoolTxnWithTxn(txn, snap, blockHeight, mempoolTx)
+		if err != nil {
+			return errors.Wrapf(err, "FlushMempoolToDb: Putting "+
+				"mempool tx hash %s failed.", mempoolTx.Hash.String())
+		}
+	}
+
+	return nil
+}
+
+func FlushMempoolToDb(handle *badger.DB, snap *Snapshot, blockHeight uint64, allTxns []*MempoolTx) error {
+	err := handle.Update(func(txn *badger.Txn) error {
+		return FlushMempoolToDbWithTxn(txn, snap, blockHeight, allTxns)
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func DbDeleteAllMempoolTxns(handle *badger.DB, snap *Snapshot) error {
+	handle.Update(func(txn *badger.Txn) error {
+		return DbDeleteAllMempoolTxnsWithTxn(txn, snap)
+	})
+
+	return nil
+}
+
+func DbDeleteMempoolTxnWithTxn(txn *badger.Txn, snap *Snapshot, mempoolTx *MempoolTx) error {
+
+	// When a mapping exists, delete it.
+	if err := DBDeleteWithTxn(txn, snap, _dbKeyForMempoolTxn(mempoolTx)); err != nil {
+		return errors.Wrapf(err, "DbDeleteMempoolTxMappingWithTxn: Deleting "+
+			"mempool tx key failed.")
+	}
+
+	return nil
+}
+
+func DbDeleteMempoolTxn(handle *badger.DB, snap *Snapshot, mempoolTx *MempoolTx) error {
+	return handle.Update(func(txn *badger.Txn) error {
+		return DbDeleteMempoolTxnWithTxn(txn, snap, mempoolTx)
+	})
+}
+
+func DbDeleteMempoolTxnKey(handle *badger.DB, snap *Snapshot, txnKey []byte) error {
+	return handle.Update(func(txn *badger.Txn) error {
+		return DbDeleteMempoolTxnKeyWithTxn(txn, snap, txnKey)
+	})
+}
+
+func DbDeleteMempoolTxnKeyWithTxn(txn *badger.Txn, snap *Snapshot, txnKey []byte) error {
+
+	// When a mapping exists, delete it.
+	if err := DBDeleteWithTxn(txn, snap, txnKey); err != nil {
+		return errors.Wrapf(err, "DbDeleteMempoolTxMappingWithTxn: Deleting "+
+			"mempool tx key failed.")
+	}
+
+	return nil
+}
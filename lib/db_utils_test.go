@@ -1,15 +1,23 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"math/big"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/dgraph-io/badger/v3"
+	"github.com/holiman/uint256"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -420,6 +428,40 @@ func TestPrivateMessages(t *testing.T) {
 		}, messages)
 	}
 
+	// Page through the messages for pk1 in both directions.
+	{
+		messages, nextTstamp, hasMore, err := DbGetPaginatedMessageEntriesForPublicKey(db, pk1, 0, 2, false)
+		require.NoError(err)
+		require.Equal([]*MessageEntry{message1, message2}, messages)
+		require.True(hasMore)
+		require.Equal(tstamp3, nextTstamp)
+
+		messages, nextTstamp, hasMore, err = DbGetPaginatedMessageEntriesForPublicKey(db, pk1, nextTstamp, 2, false)
+		require.NoError(err)
+		require.Equal([]*MessageEntry{message3, message4}, messages)
+		require.True(hasMore)
+
+		messages, _, hasMore, err = DbGetPaginatedMessageEntriesForPublicKey(db, pk1, nextTstamp, 2, false)
+		require.NoError(err)
+		require.Equal([]*MessageEntry{message5}, messages)
+		require.False(hasMore)
+
+		messages, nextTstamp, hasMore, err = DbGetPaginatedMessageEntriesForPublicKey(db, pk1, math.MaxUint64, 3, true)
+		require.NoError(err)
+		require.Equal([]*MessageEntry{message5, message4, message3}, messages)
+		require.True(hasMore)
+		require.Equal(tstamp2, nextTstamp)
+
+		// A start timestamp between two messages starts at the next one in the page's order.
+		messages, _, hasMore, err = DbGetPaginatedMessageEntriesForPublicKey(db, pk1, tstamp3-1, 5, true)
+		require.NoError(err)
+		require.Equal([]*MessageEntry{message2, message1}, messages)
+		require.False(hasMore)
+
+		_, _, _, err = DbGetPaginatedMessageEntriesForPublicKey(db, pk1, 0, 0, false)
+		require.Error(err)
+	}
+
 	// Delete message3
 	require.NoError(DBDeleteMessageEntryMappings(db, nil, pk1, tstamp3))
 	require.NoError(DBDeleteMessageEntryMappings(db, nil, pk3, tstamp3))
@@ -588,3 +630,1228 @@ func TestFollows(t *testing.T) {
 		require.Equal(len(pubKeys), 0)
 	}
 }
+
+func TestNFTCollectionSummary(t *testing.T) {
+	require := require.New(t)
+
+	// Create a test db and clean up the files at the end.
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+
+	postHash := &BlockHash{0x01}
+	otherPostHash := &BlockHash{0x02}
+	ownerPKID := NewPKID(m0PkBytes)
+	bidderPKID := NewPKID(m1PkBytes)
+
+	updateSummary := func(nftPostHash *BlockHash) {
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			return DBUpdateNFTCollectionSummaryWithTxn(txn, 0, nftPostHash)
+		}))
+	}
+
+	// No NFTs means no summary.
+	updateSummary(postHash)
+	require.Nil(DbGetNFTCollectionSummary(db, nil, postHash))
+
+	// Mint three serials. Two are for sale, one of which is Buy Now.
+	require.NoError(DBPutNFTEntryMappings(db, nil, 0, &NFTEntry{
+		OwnerPKID: ownerPKID, NFTPostHash: postHash, SerialNumber: 1,
+		IsForSale: true, MinBidAmountNanos: 500,
+	}))
+	require.NoError(DBPutNFTEntryMappings(db, nil, 0, &NFTEntry{
+		OwnerPKID: ownerPKID, NFTPostHash: postHash, SerialNumber: 2,
+		IsForSale: true, MinBidAmountNanos: 100, IsBuyNow: true, BuyNowPriceNanos: 300,
+	}))
+	require.NoError(DBPutNFTEntryMappings(db, nil, 0, &NFTEntry{
+		OwnerPKID: ownerPKID, NFTPostHash: postHash, SerialNumber: 3,
+		IsForSale: false, MinBidAmountNanos: 10,
+	}))
+	// An NFT on another post shouldn't be counted.
+	require.NoError(DBPutNFTEntryMappings(db, nil, 0, &NFTEntry{
+		OwnerPKID: ownerPKID, NFTPostHash: otherPostHash, SerialNumber: 1,
+		IsForSale: true, MinBidAmountNanos: 1,
+	}))
+
+	require.NoError(DBPutNFTBidEntryMappings(db, nil, &NFTBidEntry{
+		BidderPKID: bidderPKID, NFTPostHash: postHash, SerialNumber: 1, BidAmountNanos: 700,
+	}))
+	require.NoError(DBPutNFTBidEntryMappings(db, nil, &NFTBidEntry{
+		BidderPKID: bidderPKID, NFTPostHash: postHash, SerialNumber: 0, BidAmountNanos: 900,
+	}))
+	require.NoError(DBPutNFTBidEntryMappings(db, nil, &NFTBidEntry{
+		BidderPKID: bidderPKID, NFTPostHash: otherPostHash, SerialNumber: 1, BidAmountNanos: 5000,
+	}))
+
+	earlierHeight := uint32(10)
+	laterHeight := uint32(20)
+	require.NoError(DBPutAcceptedNFTBidEntriesMapping(db, nil, 0, MakeNFTKey(postHash, 3), &[]*NFTBidEntry{
+		{BidderPKID: bidderPKID, NFTPostHash: postHash, SerialNumber: 3, BidAmountNanos: 50,
+			AcceptedBlockHeight: &earlierHeight},
+		{BidderPKID: ownerPKID, NFTPostHash: postHash, SerialNumber: 3, BidAmountNanos: 80,
+			AcceptedBlockHeight: &laterHeight},
+	}))
+
+	updateSummary(postHash)
+	summary := DbGetNFTCollectionSummary(db, nil, postHash)
+	require.NotNil(summary)
+	require.Equal(*postHash, *summary.NFTPostHash)
+	require.Equal(uint64(3), summary.NumSerials)
+	require.Equal(uint64(2), summary.NumForSale)
+	require.Equal(uint64(300), summary.FloorPriceNanos)
+	require.Equal(uint64(900), summary.HighestBidNanos)
+	require.Equal(uint64(80), summary.LastSalePriceNanos)
+	require.Equal(uint64(20), summary.LastSaleBlockHeight)
+
+	// Take serial 2 off the market and drop the standing offer.
+	require.NoError(DBDeleteNFTMappings(db, nil, postHash, 2))
+	require.NoError(DBPutNFTEntryMappings(db, nil, 0, &NFTEntry{
+		OwnerPKID: ownerPKID, NFTPostHash: postHash, SerialNumber: 2, MinBidAmountNanos: 100,
+	}))
+	require.NoError(DBDeleteNFTBidMappings(db, nil, &NFTBidKey{
+		BidderPKID: *bidderPKID, NFTPostHash: *postHash, SerialNumber: 0,
+	}))
+	updateSummary(postHash)
+	summary = DbGetNFTCollectionSummary(db, nil, postHash)
+	require.NotNil(summary)
+	require.Equal(uint64(3), summary.NumSerials)
+	require.Equal(uint64(1), summary.NumForSale)
+	require.Equal(uint64(500), summary.FloorPriceNanos)
+	require.Equal(uint64(700), summary.HighestBidNanos)
+
+	// Burning every serial removes the summary.
+	for serialNumber := uint64(1); serialNumber <= 3; serialNumber++ {
+		require.NoError(DBDeleteNFTMappings(db, nil, postHash, serialNumber))
+	}
+	updateSummary(postHash)
+	require.Nil(DbGetNFTCollectionSummary(db, nil, postHash))
+}
+
+func TestNFTCollectionSummaryChanges(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+
+	postHash := &BlockHash{0x01}
+	otherPostHash := &BlockHash{0x02}
+	ownerPKID := NewPKID(m0PkBytes)
+	bidderPKID := NewPKID(m1PkBytes)
+
+	// Applying a change has to give the same summary as recomputing it.
+	applyChange := func(change *nftCollectionSummaryChange) *NFTCollectionSummary {
+		var computedSummary *NFTCollectionSummary
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			if err := _dbApplyNFTCollectionSummaryChangeWithTxn(txn, 0, postHash, change); err != nil {
+				return err
+			}
+			var err error
+			computedSummary, err = DBComputeNFTCollectionSummaryWithTxn(txn, postHash)
+			return err
+		}))
+		summary := DbGetNFTCollectionSummary(db, nil, postHash)
+		require.Equal(computedSummary, summary)
+		return summary
+	}
+
+	serialOne := &NFTEntry{OwnerPKID: ownerPKID, NFTPostHash: postHash, SerialNumber: 1,
+		IsForSale: true, MinBidAmountNanos: 500}
+	serialTwo := &NFTEntry{OwnerPKID: ownerPKID, NFTPostHash: postHash, SerialNumber: 2,
+		IsForSale: true, MinBidAmountNanos: 300}
+	require.NoError(DBPutNFTEntryMappings(db, nil, 0, serialOne))
+	require.NoError(DBPutNFTEntryMappings(db, nil, 0, serialTwo))
+	require.NoError(DBPutNFTBidEntryMappings(db, nil, &NFTBidEntry{
+		BidderPKID: bidderPKID, NFTPostHash: postHash, SerialNumber: 1, BidAmountNanos: 700,
+	}))
+	summary := applyChange(&nftCollectionSummaryChange{
+		addedNFTEntries: []*NFTEntry{serialOne, serialTwo}, addedBidAmountsNanos: []uint64{700}})
+	require.Equal(uint64(2), summary.NumSerials)
+	require.Equal(uint64(300), summary.FloorPriceNanos)
+
+	// Changes that don't remove the entries the extremes came from are applied as is.
+	serialThree := &NFTEntry{OwnerPKID: ownerPKID, NFTPostHash: postHash, SerialNumber: 3,
+		IsForSale: true, MinBidAmountNanos: 400}
+	require.NoError(DBPutNFTEntryMappings(db, nil, 0, serialThree))
+	require.NoError(DBPutNFTBidEntryMappings(db, nil, &NFTBidEntry{
+		BidderPKID: bidderPKID, NFTPostHash: postHash, SerialNumber: 3, BidAmountNanos: 800,
+	}))
+	acceptedBlockHeight := uint32(10)
+	acceptedBidEntry := &NFTBidEntry{BidderPKID: bidderPKID, NFTPostHash: postHash, SerialNumber: 1,
+		BidAmountNanos: 600, AcceptedBlockHeight: &acceptedBlockHeight}
+	require.NoError(DBPutAcceptedNFTBidEntriesMapping(db, nil, 0, MakeNFTKey(postHash, 1),
+		&[]*NFTBidEntry{acceptedBidEntry}))
+	change := &nftCollectionSummaryChange{
+		addedNFTEntries:         []*NFTEntry{serialThree},
+		addedBidAmountsNanos:    []uint64{800},
+		addedAcceptedBidEntries: []*NFTBidEntry{acceptedBidEntry},
+	}
+	require.True(_applyNFTCollectionSummaryChange(&NFTCollectionSummary{
+		NumSerials: 2, NumForSale: 2, FloorPriceNanos: 300, HighestBidNanos: 700}, change))
+	summary = applyChange(change)
+	require.Equal(uint64(3), summary.NumSerials)
+	require.Equal(uint64(3), summary.NumForSale)
+	require.Equal(uint64(300), summary.FloorPriceNanos)
+	require.Equal(uint64(800), summary.HighestBidNanos)
+	require.Equal(uint64(600), summary.LastSalePriceNanos)
+
+	// Taking the floor off the market means recomputing the floor.
+	require.NoError(DBDeleteNFTMappings(db, nil, postHash, 2))
+	serialTwoNotForSale := &NFTEntry{OwnerPKID: ownerPKID, NFTPostHash: postHash, SerialNumber: 2,
+		MinBidAmountNanos: 300}
+	require.NoError(DBPutNFTEntryMappings(db, nil, 0, serialTwoNotForSale))
+	change = &nftCollectionSummaryChange{
+		removedNFTEntries: []*NFTEntry{serialTwo},
+		addedNFTEntries:   []*NFTEntry{serialTwoNotForSale},
+	}
+	require.False(_applyNFTCollectionSummaryChange(&NFTCollectionSummary{
+		NumSerials: 3, NumForSale: 3, FloorPriceNanos: 300, HighestBidNanos: 800}, change))
+	summary = applyChange(change)
+	require.Equal(uint64(3), summary.NumSerials)
+	require.Equal(uint64(2), summary.NumForSale)
+	require.Equal(uint64(400), summary.FloorPriceNanos)
+
+	// The backfill recomputes every summary and drops the ones for posts without NFTs.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		if err := DBDeleteWithTxn(txn, nil, _dbKeyForPostHashToNFTCollectionSummary(postHash)); err != nil {
+			return err
+		}
+		return DBSetWithTxn(txn, nil, _dbKeyForPostHashToNFTCollectionSummary(otherPostHash),
+			EncodeToBytes(0, &NFTCollectionSummary{NFTPostHash: otherPostHash, NumSerials: 1}))
+	}))
+	require.False(DbIsNFTCollectionSummariesBackfilled(db))
+	require.NoError(DbBackfillNFTCollectionSummaries(db))
+	require.True(DbIsNFTCollectionSummariesBackfilled(db))
+	require.Equal(summary, DbGetNFTCollectionSummary(db, nil, postHash))
+	require.Nil(DbGetNFTCollectionSummary(db, nil, otherPostHash))
+}
+
+func TestAccountDelta(t *testing.T) {
+	require := require.New(t)
+
+	// Create a test db and clean up the files at the end.
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+
+	// Store a block at the given height and index the passed-in utxo ops as a single
+	// txn in it that touches m0.
+	nextTxID := byte(0)
+	connectTxn := func(height uint64, utxoOps []*UtxoOperation) *BlockHash {
+		nextTxID++
+		txID := &BlockHash{nextTxID}
+		block := &MsgDeSoBlock{
+			Header: &MsgDeSoHeader{
+				Version:               1,
+				PrevBlockHash:         &BlockHash{},
+				TransactionMerkleRoot: &BlockHash{},
+				Height:                height,
+			},
+			Txns: []*MsgDeSoTxn{{
+				TxInputs:  []*DeSoInput{},
+				TxOutputs: []*DeSoOutput{},
+				TxnMeta:   &BlockRewardMetadataa{},
+			}},
+		}
+		blockHash, err := block.Header.Hash()
+		require.NoError(err)
+		require.NoError(PutBlock(db, nil, block))
+
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			if err := DbPutTxindexTransactionWithTxn(txn, nil, height, txID, &TransactionMetadata{
+				BlockHashHex:                   hex.EncodeToString(blockHash[:]),
+				TxnType:                        TxnTypeBasicTransfer.String(),
+				TransactorPublicKeyBase58Check: m0Pub,
+				BasicTransferTxindexMetadata: &BasicTransferTxindexMetadata{
+					UtxoOps: utxoOps,
+				},
+			}); err != nil {
+				return err
+			}
+			return DbPutTxindexPublicKeyToTxnMappingSingleWithTxn(txn, nil, m0PkBytes, txID)
+		}))
+		return txID
+	}
+	utxoOp := func(opType OperationType, utxoKey *UtxoKey, publicKey []byte, amountNanos uint64) *UtxoOperation {
+		return &UtxoOperation{
+			Type: opType,
+			Key:  utxoKey,
+			Entry: &UtxoEntry{
+				AmountNanos: amountNanos,
+				PublicKey:   publicKey,
+				UtxoType:    UtxoTypeOutput,
+			},
+		}
+	}
+
+	utxoKeyA := &UtxoKey{TxID: BlockHash{0xA}, Index: 0}
+	utxoKeyB := &UtxoKey{TxID: BlockHash{0xB}, Index: 0}
+	utxoKeyC := &UtxoKey{TxID: BlockHash{0xC}, Index: 1}
+	utxoKeyOther := &UtxoKey{TxID: BlockHash{0xB}, Index: 1}
+
+	// A is created at height 5, then spent at height 10 to create B plus some change
+	// for m1. B is spent at height 15 while C is created.
+	connectTxn(5, []*UtxoOperation{
+		utxoOp(OperationTypeAddUtxo, utxoKeyA, m0PkBytes, 100),
+	})
+	txID2 := connectTxn(10, []*UtxoOperation{
+		utxoOp(OperationTypeSpendUtxo, utxoKeyA, m0PkBytes, 100),
+		utxoOp(OperationTypeAddUtxo, utxoKeyB, m0PkBytes, 60),
+		utxoOp(OperationTypeAddUtxo, utxoKeyOther, m1PkBytes, 35),
+	})
+	txID3 := connectTxn(15, []*UtxoOperation{
+		utxoOp(OperationTypeSpendUtxo, utxoKeyB, m0PkBytes, 60),
+		utxoOp(OperationTypeAddUtxo, utxoKeyC, m0PkBytes, 30),
+	})
+	require.NoError(DbPutDeSoBalanceForPublicKey(db, nil, m0PkBytes, 30))
+
+	// Syncing from height 5 should skip the first txn and drop B since it was
+	// created and spent inside the window.
+	delta, err := DbGetAccountDelta(db, nil, m0PkBytes, 5)
+	require.NoError(err)
+	require.Equal(uint64(30), delta.BalanceNanos)
+	require.Equal(int64(-70), delta.NetBalanceChangeNanos)
+	require.Len(delta.Txns, 2)
+	require.Equal(*txID2, *delta.Txns[0].TxID)
+	require.Equal(uint64(10), delta.Txns[0].BlockHeight)
+	require.Equal(int64(-40), delta.Txns[0].BalanceChangeNanos)
+	require.Equal(*txID3, *delta.Txns[1].TxID)
+	require.Equal(uint64(15), delta.Txns[1].BlockHeight)
+	require.Equal(int64(-30), delta.Txns[1].BalanceChangeNanos)
+	require.Len(delta.NewUtxos, 1)
+	require.Equal(*utxoKeyC, *delta.NewUtxos[0].UtxoKey)
+	require.Equal(uint64(30), delta.NewUtxos[0].AmountNanos)
+	require.Len(delta.SpentUtxos, 1)
+	require.Equal(*utxoKeyA, *delta.SpentUtxos[0].UtxoKey)
+
+	// Syncing from the tip returns nothing new.
+	delta, err = DbGetAccountDelta(db, nil, m0PkBytes, 15)
+	require.NoError(err)
+	require.Len(delta.Txns, 0)
+	require.Len(delta.NewUtxos, 0)
+	require.Len(delta.SpentUtxos, 0)
+	require.Equal(int64(0), delta.NetBalanceChangeNanos)
+
+	// Syncing from scratch sees every UTXO m0 still holds.
+	delta, err = DbGetAccountDelta(db, nil, m0PkBytes, 0)
+	require.NoError(err)
+	require.Len(delta.Txns, 3)
+	require.Equal(int64(30), delta.NetBalanceChangeNanos)
+	require.Len(delta.NewUtxos, 1)
+	require.Equal(*utxoKeyC, *delta.NewUtxos[0].UtxoKey)
+	require.Len(delta.SpentUtxos, 0)
+}
+
+func TestParallelScanPrefix(t *testing.T) {
+	require := require.New(t)
+
+	// Create a test db and clean up the files at the end.
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+
+	prefix := []byte{0x10, 0x20}
+	expectedKeys := make(map[string][]byte)
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		// A record right at the prefix, records spread across every possible next byte,
+		// and records under the neighboring prefixes that shouldn't be scanned.
+		keys := [][]byte{append([]byte{}, prefix...)}
+		for nextByte := 0; nextByte < 256; nextByte += 3 {
+			keys = append(keys, append(append([]byte{}, prefix...), byte(nextByte)))
+			keys = append(keys, append(append([]byte{}, prefix...), byte(nextByte), 0x01, 0x02))
+		}
+		for _, key := range keys {
+			value := append([]byte("value"), key...)
+			expectedKeys[string(key)] = value
+			if err := txn.Set(key, value); err != nil {
+				return err
+			}
+		}
+		if err := txn.Set([]byte{0x10, 0x1f, 0xff}, []byte{}); err != nil {
+			return err
+		}
+		return txn.Set([]byte{0x10, 0x21}, []byte{})
+	}))
+
+	for _, shards := range []int{0, 1, 3, 7, 256, 1000} {
+		var foundLock sync.Mutex
+		foundKeys := make(map[string][]byte)
+		err := ParallelScanPrefix(db, prefix, shards, func(key []byte, value []byte) error {
+			foundLock.Lock()
+			defer foundLock.Unlock()
+			_, exists := foundKeys[string(key)]
+			require.False(exists, "key %v scanned twice", key)
+			foundKeys[string(key)] = append([]byte{}, value...)
+			return nil
+		})
+		require.NoError(err)
+		require.Equal(expectedKeys, foundKeys, "shards: %v", shards)
+	}
+
+	// An error from the callback stops the scan and is returned.
+	err := ParallelScanPrefix(db, prefix, 4, func(key []byte, value []byte) error {
+		return fmt.Errorf("stop")
+	})
+	require.Error(err)
+}
+
+func TestDBForEachKeyWithPrefix(t *testing.T) {
+	require := require.New(t)
+
+	// Create a test db and clean up the files at the end.
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+
+	// Records under the prefix, including ones that continue past a 0xff byte, and records under
+	// the neighboring prefixes that shouldn't be iterated.
+	prefix := []byte{0x10, 0x20}
+	var expectedKeys [][]byte
+	for _, suffix := range [][]byte{{}, {0x00}, {0x01, 0x02}, {0xff}, {0xff, 0xff, 0x01}} {
+		expectedKeys = append(expectedKeys, append(append([]byte{}, prefix...), suffix...))
+	}
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		for _, key := range append(expectedKeys, []byte{0x10, 0x1f, 0xff}, []byte{0x10, 0x21}) {
+			if err := txn.Set(key, append([]byte("value"), key...)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	collect := func(opts *DBIteratorOptions, limit int) [][]byte {
+		var keysFound [][]byte
+		require.NoError(DBForEachKeyWithPrefix(db, prefix, opts, func(key []byte, value []byte) (bool, error) {
+			if opts != nil && opts.KeysOnly {
+				require.Nil(value)
+			} else {
+				require.Equal(append([]byte("value"), key...), value)
+			}
+			keysFound = append(keysFound, append([]byte{}, key...))
+			return len(keysFound) == limit, nil
+		}))
+		return keysFound
+	}
+	require.Equal(expectedKeys, collect(nil, -1))
+	require.Equal(expectedKeys, collect(&DBIteratorOptions{KeysOnly: true}, -1))
+	var reversedKeys [][]byte
+	for ii := len(expectedKeys) - 1; ii >= 0; ii-- {
+		reversedKeys = append(reversedKeys, expectedKeys[ii])
+	}
+	require.Equal(reversedKeys, collect(&DBIteratorOptions{Reverse: true}, -1))
+
+	// Returning stop ends the iteration early.
+	require.Equal(expectedKeys[:2], collect(nil, 2))
+	require.Equal(reversedKeys[:2], collect(&DBIteratorOptions{Reverse: true}, 2))
+
+	// So does an error, which is returned.
+	numCalls := 0
+	err := DBForEachKeyWithPrefix(db, prefix, nil, func(key []byte, value []byte) (bool, error) {
+		numCalls++
+		return false, fmt.Errorf("stop")
+	})
+	require.Error(err)
+	require.Equal(1, numCalls)
+
+	// The enumerators built on it see the same records.
+	keysFound, _ := _enumerateKeysForPrefix(db, prefix)
+	require.Equal(expectedKeys, keysFound)
+	keysFound, _ = _enumerateLimitedKeysReversedForPrefix(db, prefix, 3)
+	require.Equal(reversedKeys[:3], keysFound)
+}
+
+func TestNonces(t *testing.T) {
+	require := require.New(t)
+
+	db, _ := GetTestBadgerDb()
+	defer db.Close()
+	txindexDb, _ := GetTestBadgerDb()
+	defer txindexDb.Close()
+
+	// Public keys without a nonce start at zero.
+	nonce, err := DbGetNonce(db, nil, m0PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(0), nonce)
+
+	require.NoError(DbPutNonce(db, nil, m0PkBytes, 7))
+	nonce, err = DbGetNonce(db, nil, m0PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(7), nonce)
+	require.Error(DbPutNonce(db, nil, m0PkBytes[:10], 1))
+
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return DbDeleteNonceWithTxn(txn, nil, m0PkBytes)
+	}))
+	nonce, err = DbGetNonce(db, nil, m0PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(0), nonce)
+
+	// m0 submits three txns and m1 submits one. The block reward, the BitcoinExchange and
+	// the genesis records shouldn't count towards anyone's nonce.
+	blockHashHex := hex.EncodeToString(NewBlockHash(RandomBytes(HashSizeBytes))[:])
+	txnMetas := []*TransactionMetadata{
+		{TxnType: TxnTypeBasicTransfer.String(), TransactorPublicKeyBase58Check: m0Pub, BlockHashHex: blockHashHex},
+		{TxnType: TxnTypeSubmitPost.String(), TransactorPublicKeyBase58Check: m0Pub, BlockHashHex: blockHashHex},
+		{TxnType: TxnTypeFollow.String(), TransactorPublicKeyBase58Check: m0Pub, BlockHashHex: blockHashHex},
+		{TxnType: TxnTypeBasicTransfer.String(), TransactorPublicKeyBase58Check: m1Pub, BlockHashHex: blockHashHex},
+		{TxnType: TxnTypeBlockReward.String(), TransactorPublicKeyBase58Check: m1Pub, BlockHashHex: blockHashHex},
+		{TxnType: TxnTypeBitcoinExchange.String(), TransactorPublicKeyBase58Check: m1Pub, BlockHashHex: blockHashHex},
+		{TxnType: TxnTypeBasicTransfer.String(), TransactorPublicKeyBase58Check: m2Pub, BlockHashHex: GenesisBlockHashHex},
+	}
+	for _, txnMeta := range txnMetas {
+		txID := NewBlockHash(RandomBytes(HashSizeBytes))
+		require.NoError(DbPutTxindexTransaction(txindexDb, nil, 0, txID, txnMeta))
+	}
+
+	numNonces, err := DbInitializeNoncesFromTxindex(db, nil, txindexDb)
+	require.NoError(err)
+	require.Equal(2, numNonces)
+	for _, expected := range []struct {
+		publicKey []byte
+		nonce     uint64
+	}{{m0PkBytes, 3}, {m1PkBytes, 1}, {m2PkBytes, 0}} {
+		nonce, err = DbGetNonce(db, nil, expected.publicKey)
+		require.NoError(err)
+		require.Equal(expected.nonce, nonce)
+	}
+}
+
+func TestSignerSets(t *testing.T) {
+	require := require.New(t)
+
+	db, _ := GetTestBadgerDb()
+	defer db.Close()
+
+	owner := NewPublicKey(m0PkBytes)
+	member1 := NewPublicKey(m1PkBytes)
+	member2 := NewPublicKey(m2PkBytes)
+	member3 := NewPublicKey(m3PkBytes)
+	setID1 := NewBlockHash(RandomBytes(HashSizeBytes))
+	setID2 := NewBlockHash(RandomBytes(HashSizeBytes))
+
+	// Malformed signer sets are rejected.
+	for _, invalidEntry := range []*SignerSetEntry{
+		{OwnerPublicKey: owner, SignerSetID: setID1, Threshold: 1},
+		{OwnerPublicKey: owner, SignerSetID: setID1, Threshold: 0, SignerPublicKeys: []*PublicKey{member1}},
+		{OwnerPublicKey: owner, SignerSetID: setID1, Threshold: 2, SignerPublicKeys: []*PublicKey{member1}},
+		{OwnerPublicKey: owner, SignerSetID: setID1, Threshold: 1, SignerPublicKeys: []*PublicKey{member1, member1}},
+	} {
+		require.Error(DBPutSignerSetEntry(db, nil, 0, invalidEntry))
+	}
+
+	signerSet1 := &SignerSetEntry{
+		OwnerPublicKey:   owner,
+		SignerSetID:      setID1,
+		Threshold:        2,
+		SignerPublicKeys: []*PublicKey{member1, member2, member3},
+		ExtraData:        map[string][]byte{"name": []byte("treasury")},
+	}
+	signerSet2 := &SignerSetEntry{
+		OwnerPublicKey:   owner,
+		SignerSetID:      setID2,
+		Threshold:        1,
+		SignerPublicKeys: []*PublicKey{member1},
+	}
+	require.NoError(DBPutSignerSetEntry(db, nil, 0, signerSet1))
+	require.NoError(DBPutSignerSetEntry(db, nil, 0, signerSet2))
+
+	fetchedEntry := DBGetSignerSetEntry(db, nil, signerSet1.GetSignerSetKey())
+	require.NotNil(fetchedEntry)
+	require.Equal(EncodeToBytes(0, signerSet1), EncodeToBytes(0, fetchedEntry))
+
+	ownerEntries, err := DBGetSignerSetEntriesForOwner(db, owner)
+	require.NoError(err)
+	require.Equal(2, len(ownerEntries))
+
+	memberEntries, err := DBGetSignerSetEntriesForMember(db, nil, member1)
+	require.NoError(err)
+	require.Equal(2, len(memberEntries))
+	memberEntries, err = DBGetSignerSetEntriesForMember(db, nil, member3)
+	require.NoError(err)
+	require.Equal(1, len(memberEntries))
+	require.Equal(*setID1, *memberEntries[0].SignerSetID)
+
+	// Replacing a signer set drops the members that were removed from it.
+	signerSet1.Threshold = 1
+	signerSet1.SignerPublicKeys = []*PublicKey{member2}
+	require.NoError(DBPutSignerSetEntry(db, nil, 0, signerSet1))
+	memberEntries, err = DBGetSignerSetEntriesForMember(db, nil, member3)
+	require.NoError(err)
+	require.Equal(0, len(memberEntries))
+	memberEntries, err = DBGetSignerSetEntriesForMember(db, nil, member1)
+	require.NoError(err)
+	require.Equal(1, len(memberEntries))
+	require.Equal(*setID2, *memberEntries[0].SignerSetID)
+
+	// Deleting a signer set removes it from the member index too.
+	require.NoError(DBDeleteSignerSetEntry(db, nil, signerSet2.GetSignerSetKey()))
+	require.Nil(DBGetSignerSetEntry(db, nil, signerSet2.GetSignerSetKey()))
+	memberEntries, err = DBGetSignerSetEntriesForMember(db, nil, member1)
+	require.NoError(err)
+	require.Equal(0, len(memberEntries))
+	ownerEntries, err = DBGetSignerSetEntriesForOwner(db, owner)
+	require.NoError(err)
+	require.Equal(1, len(ownerEntries))
+}
+
+func TestProfilesModifiedSince(t *testing.T) {
+	require := require.New(t)
+
+	db, _ := GetTestBadgerDb()
+	defer db.Close()
+
+	params := &DeSoTestnetParams
+	pkids := []*PKID{PublicKeyToPKID(m0PkBytes), PublicKeyToPKID(m1PkBytes), PublicKeyToPKID(m2PkBytes)}
+	putModified := func(pkid *PKID, blockHeight uint64) {
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			return DBPutProfileModifiedHeightWithTxn(txn, nil, pkid, blockHeight)
+		}))
+	}
+	for ii, pkid := range pkids {
+		profileEntry := &ProfileEntry{
+			PublicKey: pkid[:],
+			Username:  []byte(fmt.Sprintf("user%d", ii)),
+		}
+		require.NoError(DBPutProfileEntryMappings(db, nil, 0, profileEntry, pkid, params))
+		putModified(pkid, uint64(10+ii))
+	}
+	// m0's profile is modified again, so it should only show up at its latest height.
+	putModified(pkids[0], 20)
+
+	profiles, nextStartKey, err := DbGetProfilesModifiedSince(db, nil, 10, nil, 10)
+	require.NoError(err)
+	require.Nil(nextStartKey)
+	require.Equal(3, len(profiles))
+	require.Equal(*pkids[1], *profiles[0].PKID)
+	require.Equal(uint64(11), profiles[0].ModifiedHeight)
+	require.Equal(*pkids[2], *profiles[1].PKID)
+	require.Equal(*pkids[0], *profiles[2].PKID)
+	require.Equal(uint64(20), profiles[2].ModifiedHeight)
+	require.Equal([]byte("user0"), profiles[2].ProfileEntry.Username)
+
+	// Paginate one profile at a time.
+	var paginated []*ProfileModification
+	var startKey []byte
+	for {
+		profiles, nextStartKey, err = DbGetProfilesModifiedSince(db, nil, 0, startKey, 1)
+		require.NoError(err)
+		paginated = append(paginated, profiles...)
+		if nextStartKey == nil {
+			break
+		}
+		startKey = nextStartKey
+	}
+	require.Equal(3, len(paginated))
+	require.Equal(*pkids[0], *paginated[2].PKID)
+
+	// Deleted profiles are reported without an entry.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		if err := DBDeleteProfileEntryMappingsWithTxn(txn, nil, pkids[1], params); err != nil {
+			return err
+		}
+		return DBPutProfileModifiedHeightWithTxn(txn, nil, pkids[1], 30)
+	}))
+	profiles, _, err = DbGetProfilesModifiedSince(db, nil, 20, nil, 10)
+	require.NoError(err)
+	require.Equal(1, len(profiles))
+	require.Equal(*pkids[1], *profiles[0].PKID)
+	require.Nil(profiles[0].ProfileEntry)
+}
+
+func TestUsernameCollisions(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+
+	// The long s in "ſam" uppercases to an ASCII S, so it folds to the same username as "sam".
+	alicePKID := NewPKID(RandomBytes(int32(btcec.PubKeyBytesLenCompressed)))
+	samPKID := NewPKID(RandomBytes(int32(btcec.PubKeyBytesLenCompressed)))
+	squatterPKID := NewPKID(RandomBytes(int32(btcec.PubKeyBytesLenCompressed)))
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		for username, pkid := range map[string]*PKID{"alice": alicePKID, "sam": samPKID, "ſam": squatterPKID} {
+			if err := DBSetWithTxn(txn, nil, _dbKeyForProfileUsernameToPKID([]byte(username)), pkid[:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	expectedCollisions := []*UsernameCollision{{
+		FoldedUsername: "sam",
+		Usernames:      []string{"sam", "ſam"},
+		PKIDs:          []*PKID{samPKID, squatterPKID},
+	}}
+
+	pkidsByUsername, collisions, err := DBGetPKIDsForUsernames(db, nil, []string{"Alice", "ALICE", "sam", "ſam", "bob"})
+	require.NoError(err)
+	require.Equal(map[string]*PKID{"Alice": alicePKID, "ALICE": alicePKID, "sam": samPKID, "ſam": squatterPKID},
+		pkidsByUsername)
+	require.Equal(expectedCollisions, collisions)
+
+	// Usernames that only differ by ASCII case resolve to the same key, which isn't a collision.
+	_, collisions, err = DBGetPKIDsForUsernames(db, nil, []string{"Sam", "sAM"})
+	require.NoError(err)
+	require.Empty(collisions)
+
+	collisions, err = DBScanUsernameCollisions(db)
+	require.NoError(err)
+	require.Equal(expectedCollisions, collisions)
+}
+
+func TestDBBatchWrites(t *testing.T) {
+	require := require.New(t)
+	params := DeSoTestnetParams
+
+	m0BalanceKey := _dbKeyForPublicKeyToDeSoBalanceNanos(m0PkBytes)
+	m1BalanceKey := _dbKeyForPublicKeyToDeSoBalanceNanos(m1PkBytes)
+	m2BalanceKey := _dbKeyForPublicKeyToDeSoBalanceNanos(m2PkBytes)
+	nonStateKey := append(append([]byte{}, Prefixes.PrefixBlockStats...), 1, 2, 3)
+
+	// writeRecords makes the same writes one record at a time, or in batches, and returns the
+	// resulting state checksum and ancestral records.
+	writeRecords := func(batched bool) ([]byte, map[string]*AncestralRecordValue, *badger.DB) {
+		db, dir := GetTestBadgerDb()
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		snap, err, _ := NewSnapshot(db, dir, SnapshotBlockHeightPeriod, false, false, &params, true)
+		require.NoError(err)
+		t.Cleanup(func() {
+			snap.Stop()
+			require.NoError(snap.SnapshotDb.Close())
+			db.Close()
+		})
+
+		// Seed the balances that get overwritten and deleted.
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			require.NoError(DBSetWithTxn(txn, snap, m0BalanceKey, EncodeUint64(1)))
+			return DBSetWithTxn(txn, snap, m2BalanceKey, EncodeUint64(2))
+		}))
+
+		snap.PrepareAncestralRecordsFlush()
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			if batched {
+				require.NoError(DBBatchDeleteWithTxn(txn, snap, [][]byte{m2BalanceKey, m1BalanceKey, m2BalanceKey}))
+				return DBBatchSetWithTxn(txn, snap, []*DBEntry{
+					{Key: m0BalanceKey, Value: EncodeUint64(10)},
+					{Key: m1BalanceKey, Value: EncodeUint64(20)},
+					{Key: nonStateKey, Value: []byte{1}},
+					{Key: m0BalanceKey, Value: EncodeUint64(30)},
+				})
+			}
+			require.NoError(DBDeleteWithTxn(txn, snap, m2BalanceKey))
+			require.NoError(DBDeleteWithTxn(txn, snap, m1BalanceKey))
+			require.NoError(DBSetWithTxn(txn, snap, m0BalanceKey, EncodeUint64(10)))
+			require.NoError(DBSetWithTxn(txn, snap, m1BalanceKey, EncodeUint64(20)))
+			require.NoError(DBSetWithTxn(txn, snap, nonStateKey, []byte{1}))
+			return DBSetWithTxn(txn, snap, m0BalanceKey, EncodeUint64(30))
+		}))
+		snap.WaitForAllOperationsToFinish()
+		require.NoError(snap.Checksum.Wait())
+		checksumBytes, err := snap.Checksum.ToBytes()
+		require.NoError(err)
+		return checksumBytes, snap.AncestralMemory.Last().(*AncestralCache).AncestralRecordsMap, db
+	}
+
+	requireBalance := func(db *badger.DB, pkBytes []byte, expectedBalance uint64) {
+		balance, err := DbGetDeSoBalanceNanosForPublicKey(db, nil, pkBytes)
+		require.NoError(err)
+		require.Equal(expectedBalance, balance)
+	}
+	getValue := func(db *badger.DB, key []byte) (value []byte, err error) {
+		require.NoError(db.View(func(txn *badger.Txn) error {
+			value, err = DBGetWithTxn(txn, nil, key)
+			return nil
+		}))
+		return value, err
+	}
+
+	expectedChecksum, expectedAncestralRecords, _ := writeRecords(false)
+	checksum, ancestralRecords, db := writeRecords(true)
+	require.Equal(expectedChecksum, checksum)
+	require.Equal(expectedAncestralRecords, ancestralRecords)
+	require.Len(ancestralRecords, 3)
+	requireBalance(db, m0PkBytes, 30)
+	requireBalance(db, m1PkBytes, 20)
+	requireBalance(db, m2PkBytes, 0)
+
+	// Without a snapshot, the records are written with a WriteBatch.
+	require.NoError(DBBatchSet(db, nil, []*DBEntry{
+		{Key: nonStateKey, Value: []byte{2}},
+		{Key: m2BalanceKey, Value: EncodeUint64(40)},
+	}))
+	value, err := getValue(db, nonStateKey)
+	require.NoError(err)
+	require.Equal([]byte{2}, value)
+	requireBalance(db, m2PkBytes, 40)
+	require.NoError(DBBatchDelete(db, nil, [][]byte{nonStateKey, m2BalanceKey}))
+	_, err = getValue(db, nonStateKey)
+	require.Equal(badger.ErrKeyNotFound, err)
+
+	// Values over their prefix's size limit are refused either way.
+	SetDBMaxValueSizes(map[byte]int{Prefixes.PrefixBlockStats[0]: 1})
+	defer SetDBMaxValueSizes(nil)
+	require.True(IsErrDBValueTooLarge(DBBatchSet(db, nil, []*DBEntry{{Key: nonStateKey, Value: []byte{1, 2}}})))
+}
+
+func TestFollowCounts(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	m0PKID := NewPKID(m0PkBytes)
+	m1PKID := NewPKID(m1PkBytes)
+	m2PKID := NewPKID(m2PkBytes)
+	requireCounts := func(pkid *PKID, expectedFollowers uint64, expectedFollowing uint64) {
+		followers, err := DbGetFollowerCountForPKID(db, pkid)
+		require.NoError(err)
+		require.Equal(expectedFollowers, followers)
+		following, err := DbGetFollowingCountForPKID(db, pkid)
+		require.NoError(err)
+		require.Equal(expectedFollowing, following)
+	}
+
+	// A follow that's written without the counters, e.g. by hypersync, is counted from the mappings.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		if err := DBSetWithTxn(txn, nil, _dbKeyForFollowerToFollowedMapping(m1PKID, m0PKID), []byte{}); err != nil {
+			return err
+		}
+		return DBSetWithTxn(txn, nil, _dbKeyForFollowedToFollowerMapping(m0PKID, m1PKID), []byte{})
+	}))
+	requireCounts(m0PKID, 1, 0)
+	requireCounts(m1PKID, 0, 1)
+
+	// Putting the same follow twice only counts it once.
+	require.NoError(DbPutFollowMappings(db, nil, m2PKID, m0PKID))
+	require.NoError(DbPutFollowMappings(db, nil, m2PKID, m0PKID))
+	require.NoError(DbPutFollowMappings(db, nil, m0PKID, m1PKID))
+	requireCounts(m0PKID, 2, 1)
+	requireCounts(m1PKID, 1, 1)
+	requireCounts(m2PKID, 0, 1)
+
+	// Deleting a follow that doesn't exist doesn't change the counts.
+	require.NoError(DbDeleteFollowMappings(db, nil, m2PKID, m0PKID))
+	require.NoError(DbDeleteFollowMappings(db, nil, m2PKID, m0PKID))
+	require.NoError(DbDeleteFollowMappings(db, nil, m1PKID, m0PKID))
+	requireCounts(m0PKID, 0, 1)
+	requireCounts(m1PKID, 1, 0)
+	requireCounts(m2PKID, 0, 0)
+}
+
+func TestTxindexPublicKeyMappings(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	publicKey := m0PkBytes
+	txIDs := []*BlockHash{}
+	for ii := 0; ii < 4; ii++ {
+		txID := NewBlockHash(RandomBytes(HashSizeBytes))
+		txIDs = append(txIDs, txID)
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			return DbPutTxindexPublicKeyToTxnMappingSingleWithTxn(txn, nil, publicKey, txID)
+		}))
+	}
+	deleteMapping := func(txID *BlockHash) {
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			return DbDeleteTxindexPublicKeyToTxnMappingSingleWithTxn(txn, nil, publicKey, txID)
+		}))
+	}
+
+	// Deleting a txn other than the last one leaves a gap in the indexes.
+	deleteMapping(txIDs[1])
+	require.Equal([]*BlockHash{txIDs[0], txIDs[2], txIDs[3]}, DbGetTxindexTxnsForPublicKey(db, publicKey))
+	require.Equal(uint64(4), *DbGetTxindexNextIndexForPublicKey(db, nil, publicKey))
+
+	// Deleting the last txn moves the next index back, and deleting a txn that isn't there does nothing.
+	deleteMapping(txIDs[3])
+	deleteMapping(txIDs[3])
+	require.Equal([]*BlockHash{txIDs[0], txIDs[2]}, DbGetTxindexTxnsForPublicKey(db, publicKey))
+	require.Equal(uint64(3), *DbGetTxindexNextIndexForPublicKey(db, nil, publicKey))
+
+	// A txindex without the txid to index mappings gets them from the migration, once.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		for _, txID := range txIDs {
+			if err := DBDeleteWithTxn(txn, nil, _DbTxindexPublicKeyTxnToIndexKey(publicKey, txID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	numMappings, err := DbMigrateTxindexPublicKeyMappings(db)
+	require.NoError(err)
+	require.Equal(2, numMappings)
+	numMappings, err = DbMigrateTxindexPublicKeyMappings(db)
+	require.NoError(err)
+	require.Equal(0, numMappings)
+
+	deleteMapping(txIDs[0])
+	require.Equal([]*BlockHash{txIDs[2]}, DbGetTxindexTxnsForPublicKey(db, publicKey))
+}
+
+func TestTxindexResolvedInputs(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	defer db.Close()
+	params := &DeSoTestnetParams
+
+	// A txn that spends a utxo of m0 and one of m1.
+	spentUtxoOps := []*UtxoOperation{
+		{
+			Type:  OperationTypeSpendUtxo,
+			Key:   &UtxoKey{TxID: *NewBlockHash(RandomBytes(HashSizeBytes)), Index: 1},
+			Entry: &UtxoEntry{AmountNanos: 100, PublicKey: m0PkBytes},
+		},
+		{
+			Type:  OperationTypeSpendUtxo,
+			Key:   &UtxoKey{TxID: *NewBlockHash(RandomBytes(HashSizeBytes)), Index: 0},
+			Entry: &UtxoEntry{AmountNanos: 50, PublicKey: m1PkBytes},
+		},
+		{Type: OperationTypeAddUtxo},
+	}
+	desoTxn := &MsgDeSoTxn{
+		TxInputs: []*DeSoInput{
+			(*DeSoInput)(spentUtxoOps[0].Key),
+			(*DeSoInput)(spentUtxoOps[1].Key),
+		},
+		TxOutputs: []*DeSoOutput{{PublicKey: m2PkBytes, AmountNanos: 140}},
+		TxnMeta:   &BasicTransferMetadata{},
+		PublicKey: m0PkBytes,
+	}
+	expectedInputs := []*ResolvedInputTxindexMetadata{
+		{PublicKeyBase58Check: PkToString(m0PkBytes, params), AmountNanos: 100, UtxoKey: spentUtxoOps[0].Key},
+		{PublicKeyBase58Check: PkToString(m1PkBytes, params), AmountNanos: 50, UtxoKey: spentUtxoOps[1].Key},
+	}
+	require.Equal(expectedInputs, ComputeResolvedInputsTxindexMetadata(spentUtxoOps, params))
+
+	// Metadata written before the inputs existed decodes without them.
+	txnMeta := &TransactionMetadata{
+		TxnType:                        desoTxn.TxnMeta.GetTxnType().String(),
+		TransactorPublicKeyBase58Check: PkToString(m0PkBytes, params),
+		TxnOutputs:                     desoTxn.TxOutputs,
+	}
+	encodedTxnMeta := EncodeToBytes(0, txnMeta)
+	// The last byte is the zero length of the inputs.
+	decodedTxnMeta := &TransactionMetadata{}
+	exists, err := DecodeFromBytes(decodedTxnMeta, bytes.NewReader(encodedTxnMeta[:len(encodedTxnMeta)-1]))
+	require.True(exists)
+	require.NoError(err)
+	require.Nil(decodedTxnMeta.TxnInputs)
+
+	// The migration resolves the inputs of the txns in the txindex's blocks, once.
+	block := &MsgDeSoBlock{
+		Header: &MsgDeSoHeader{Height: 1, TstampSecs: 1},
+		Txns:   []*MsgDeSoTxn{desoTxn},
+	}
+	blockHash, err := block.Hash()
+	require.NoError(err)
+	require.NoError(PutBlock(db, nil, block))
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		if err := PutUtxoOperationsForBlockWithTxn(txn, nil, 1, blockHash,
+			[][]*UtxoOperation{spentUtxoOps}); err != nil {
+			return err
+		}
+		return DbPutTxindexTransactionWithTxn(txn, nil, 1, desoTxn.Hash(), txnMeta)
+	}))
+	bestChain := []*BlockNode{NewBlockNode(nil, blockHash, 1, nil, nil, block.Header, StatusNone)}
+	numTxns, err := DbMigrateTxindexResolvedInputs(db, bestChain, params)
+	require.NoError(err)
+	require.Equal(1, numTxns)
+	require.Equal(expectedInputs, DbGetTxindexTransactionRefByTxID(db, nil, desoTxn.Hash()).TxnInputs)
+	numTxns, err = DbMigrateTxindexResolvedInputs(db, bestChain, params)
+	require.NoError(err)
+	require.Equal(0, numTxns)
+}
+
+func TestUtxoAggregates(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true /*isSender*/)
+	senderPkBytes, _, err := Base58CheckDecode(senderPkString)
+	require.NoError(err)
+	recipientPkBytes, _, err := Base58CheckDecode(recipientPkString)
+	require.NoError(err)
+
+	requireAggregateMatchesUtxos := func(publicKey []byte) *UtxoAggregate {
+		utxoEntries, err := DbGetUtxosForPubKey(publicKey, db, chain.snapshot)
+		require.NoError(err)
+		expectedAggregate := &UtxoAggregate{}
+		for _, utxoEntry := range utxoEntries {
+			expectedAggregate.NumUtxos++
+			expectedAggregate.TotalAmountNanos += utxoEntry.AmountNanos
+		}
+		aggregate, err := DbGetUtxoAggregateForPubKey(db, chain.snapshot, publicKey)
+		require.NoError(err)
+		require.Equal(expectedAggregate, aggregate)
+		return aggregate
+	}
+
+	// New dbs are marked as backfilled, and the aggregates are maintained as utxos are added and spent.
+	require.True(DbIsUtxoAggregatesBackfilled(db))
+	for ii := 0; ii < 3; ii++ {
+		_, err = miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
+		require.NoError(err)
+	}
+	require.Equal(uint64(3), requireAggregateMatchesUtxos(senderPkBytes).NumUtxos)
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 1000, 10,
+		senderPkString, recipientPkString, senderPrivString, mempool)
+	_, err = mempool.processTransaction(txn, false /*allowUnconnectedTxn*/, false, /*rateLimit*/
+		0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	_, err = miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
+	require.NoError(err)
+	requireAggregateMatchesUtxos(senderPkBytes)
+	require.Equal(uint64(1000), requireAggregateMatchesUtxos(recipientPkBytes).TotalAmountNanos)
+
+	// The backfill rebuilds aggregates that are missing or wrong.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		if err := DbPutUtxoAggregateForPubKeyWithTxn(txn, nil, recipientPkBytes, &UtxoAggregate{}); err != nil {
+			return err
+		}
+		if err := DbPutUtxoAggregateForPubKeyWithTxn(txn, nil, m0PkBytes, &UtxoAggregate{1, 1}); err != nil {
+			return err
+		}
+		return txn.Delete(Prefixes.PrefixUtxoAggregatesBackfilled)
+	}))
+	require.False(DbIsUtxoAggregatesBackfilled(db))
+	require.NoError(DbBackfillUtxoAggregates(db, chain.snapshot))
+	require.True(DbIsUtxoAggregatesBackfilled(db))
+	requireAggregateMatchesUtxos(senderPkBytes)
+	requireAggregateMatchesUtxos(recipientPkBytes)
+	requireAggregateMatchesUtxos(m0PkBytes)
+}
+
+func TestHolderLeaderboards(t *testing.T) {
+	require := require.New(t)
+
+	_, _, db := NewLowDifficultyBlockchain()
+	creatorPKID := NewPKID(m0PkBytes)
+	m1PKID, m2PKID, m3PKID, m4PKID := NewPKID(m1PkBytes), NewPKID(m2PkBytes), NewPKID(m3PkBytes), NewPKID(m4PkBytes)
+
+	putBalance := func(hodlerPKID *PKID, balanceNanos uint64, isDAOCoin bool) {
+		require.NoError(DBDeleteBalanceEntryMappings(db, nil, hodlerPKID, creatorPKID, isDAOCoin))
+		require.NoError(DBPutBalanceEntryMappings(db, nil, 0, &BalanceEntry{
+			HODLerPKID:   hodlerPKID,
+			CreatorPKID:  creatorPKID,
+			BalanceNanos: *uint256.NewInt().SetUint64(balanceNanos),
+			HasPurchased: true,
+		}, isDAOCoin))
+	}
+	getTopHolders := func(limit int, startKey []byte) ([]*PKID, []byte) {
+		balanceEntries, nextStartKey, err := DbGetTopHoldersForCreatorPKID(
+			db, nil, creatorPKID, true /*isDAOCoin*/, limit, startKey)
+		require.NoError(err)
+		var hodlerPKIDs []*PKID
+		for _, balanceEntry := range balanceEntries {
+			hodlerPKIDs = append(hodlerPKIDs, balanceEntry.HODLerPKID)
+		}
+		return hodlerPKIDs, nextStartKey
+	}
+
+	// Holders are returned biggest balance first, a page at a time. Holders with a zero balance
+	// and creator coin holders aren't on the DAO coin leaderboard.
+	require.True(DbIsHolderLeaderboardsBackfilled(db))
+	putBalance(m1PKID, 100, true)
+	putBalance(m2PKID, 300, true)
+	putBalance(m3PKID, 200, true)
+	putBalance(m4PKID, 0, true)
+	putBalance(m4PKID, 1000, false)
+	hodlerPKIDs, nextStartKey := getTopHolders(2, nil)
+	require.Equal([]*PKID{m2PKID, m3PKID}, hodlerPKIDs)
+	require.NotNil(nextStartKey)
+	hodlerPKIDs, nextStartKey = getTopHolders(2, nextStartKey)
+	require.Equal([]*PKID{m1PKID}, hodlerPKIDs)
+	require.Nil(nextStartKey)
+
+	// The leaderboard follows balance changes.
+	putBalance(m1PKID, 400, true)
+	putBalance(m2PKID, 0, true)
+	hodlerPKIDs, _ = getTopHolders(0, nil)
+	require.Equal([]*PKID{m1PKID, m3PKID}, hodlerPKIDs)
+
+	// The backfill rebuilds leaderboards that are missing or wrong.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(_dbKeyForCreatorPKIDBalanceNanosHODLerPKID(
+			creatorPKID, uint256.NewInt().SetUint64(400), m1PKID, true)); err != nil {
+			return err
+		}
+		if err := txn.Set(_dbKeyForCreatorPKIDBalanceNanosHODLerPKID(
+			creatorPKID, uint256.NewInt().SetUint64(5), m2PKID, true), []byte{}); err != nil {
+			return err
+		}
+		return txn.Delete(Prefixes.PrefixHolderLeaderboardsBackfilled)
+	}))
+	require.False(DbIsHolderLeaderboardsBackfilled(db))
+	require.NoError(DbBackfillHolderLeaderboards(db, nil))
+	require.True(DbIsHolderLeaderboardsBackfilled(db))
+	hodlerPKIDs, _ = getTopHolders(0, nil)
+	require.Equal([]*PKID{m1PKID, m3PKID}, hodlerPKIDs)
+}
+
+func TestPostCounts(t *testing.T) {
+	require := require.New(t)
+
+	_, _, db := NewLowDifficultyBlockchain()
+
+	// Posts are flushed by deleting their mappings and putting them back, like the view does.
+	putPost := func(postEntry *PostEntry) {
+		require.NoError(DBDeletePostEntryMappings(db, nil, postEntry.PostHash, &DeSoTestnetParams))
+		require.NoError(DBPutPostEntryMappings(db, nil, 0, postEntry, &DeSoTestnetParams))
+	}
+	newPost := func(posterPublicKey []byte, blockHeight uint32, isComment bool) *PostEntry {
+		postEntry := &PostEntry{
+			PostHash:                NewBlockHash(RandomBytes(HashSizeBytes)),
+			PosterPublicKey:         posterPublicKey,
+			Body:                    []byte("post"),
+			ConfirmationBlockHeight: blockHeight,
+		}
+		if isComment {
+			postEntry.ParentStakeID = RandomBytes(HashSizeBytes)
+		}
+		putPost(postEntry)
+		return postEntry
+	}
+	requirePostCount := func(posterPublicKey []byte, numPosts uint64, numComments uint64) {
+		postCount, err := DbGetPostCountForPublicKey(db, nil, posterPublicKey)
+		require.NoError(err)
+		require.Equal(PostCount{NumPosts: numPosts, NumComments: numComments}, *postCount)
+	}
+	requireNumPostsInRange := func(posterPublicKey []byte, startHeight uint64, endHeight uint64, expected uint64) {
+		numPosts, err := DbGetNumPostsForPublicKeyInBlockRange(db, nil, posterPublicKey, startHeight, endHeight)
+		require.NoError(err)
+		require.Equal(expected, numPosts)
+	}
+
+	require.True(DbIsPostCountsBackfilled(db))
+	post := newPost(m0PkBytes, 10, false)
+	newPost(m0PkBytes, 11, false)
+	comment := newPost(m0PkBytes, 11, true)
+	newPost(m0PkBytes, 20, true)
+	newPost(m1PkBytes, 11, false)
+	requirePostCount(m0PkBytes, 2, 2)
+	requirePostCount(m1PkBytes, 1, 0)
+	requirePostCount(m2PkBytes, 0, 0)
+	requireNumPostsInRange(m0PkBytes, 0, 100, 4)
+	requireNumPostsInRange(m0PkBytes, 11, 11, 2)
+	requireNumPostsInRange(m0PkBytes, 11, 19, 2)
+	requireNumPostsInRange(m0PkBytes, 12, 20, 1)
+	requireNumPostsInRange(m0PkBytes, 21, 100, 0)
+	requireNumPostsInRange(m1PkBytes, 0, 100, 1)
+
+	// Modifying a post doesn't change the counts, and deleting one removes it from them.
+	post.LikeCount = 5
+	putPost(post)
+	requirePostCount(m0PkBytes, 2, 2)
+	requireNumPostsInRange(m0PkBytes, 10, 10, 1)
+	require.NoError(DBDeletePostEntryMappings(db, nil, comment.PostHash, &DeSoTestnetParams))
+	requirePostCount(m0PkBytes, 2, 1)
+	requireNumPostsInRange(m0PkBytes, 11, 11, 1)
+
+	// The backfill rebuilds post counts that are missing or wrong.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		if err := DbPutPostCountForPublicKeyWithTxn(txn, nil, m0PkBytes, &PostCount{NumPosts: 7}); err != nil {
+			return err
+		}
+		if err := _dbPutNumPostsForPublicKeyAtBlockHeightWithTxn(txn, nil, m2PkBytes, 11, 3); err != nil {
+			return err
+		}
+		if err := txn.Delete(_dbKeyForPosterPublicKeyToPostCount(m1PkBytes)); err != nil {
+			return err
+		}
+		return txn.Delete(Prefixes.PrefixPostCountsBackfilled)
+	}))
+	require.False(DbIsPostCountsBackfilled(db))
+	require.NoError(DbBackfillPostCounts(db, nil))
+	require.True(DbIsPostCountsBackfilled(db))
+	requirePostCount(m0PkBytes, 2, 1)
+	requirePostCount(m1PkBytes, 1, 0)
+	requireNumPostsInRange(m0PkBytes, 0, 100, 3)
+	requireNumPostsInRange(m2PkBytes, 0, 100, 0)
+}
+
+func TestDiamondAggregates(t *testing.T) {
+	require := require.New(t)
+
+	_, _, db := NewLowDifficultyBlockchain()
+	m0PKID, m1PKID, m2PKID := NewPKID(m0PkBytes), NewPKID(m1PkBytes), NewPKID(m2PkBytes)
+	post1, post2 := NewBlockHash(RandomBytes(HashSizeBytes)), NewBlockHash(RandomBytes(HashSizeBytes))
+
+	// Diamonds are flushed by deleting their mappings and putting them back, like the view does.
+	putDiamond := func(senderPKID *PKID, receiverPKID *PKID, postHash *BlockHash, diamondLevel int64) {
+		diamondEntry := &DiamondEntry{
+			SenderPKID:      senderPKID,
+			ReceiverPKID:    receiverPKID,
+			DiamondPostHash: postHash,
+			DiamondLevel:    diamondLevel,
+		}
+		require.NoError(DbDeleteDiamondMappings(db, nil, diamondEntry))
+		require.NoError(DbPutDiamondMappings(db, nil, 0, diamondEntry))
+	}
+	requireAggregate := func(aggregate *DiamondAggregate, err error, numDiamonds uint64, totalValueNanos uint64) {
+		require.NoError(err)
+		require.Equal(DiamondAggregate{NumDiamonds: numDiamonds, TotalValueNanos: totalValueNanos}, *aggregate)
+	}
+	getLeaderboard := func(aggregateType DiamondAggregateType, limit int, startKey []byte) ([][]byte, []byte) {
+		ids, _, nextStartKey, err := DbGetDiamondLeaderboard(db, nil, aggregateType, limit, startKey)
+		require.NoError(err)
+		return ids, nextStartKey
+	}
+
+	require.True(DbIsDiamondAggregatesBackfilled(db))
+	putDiamond(m1PKID, m0PKID, post1, 1)
+	putDiamond(m2PKID, m0PKID, post1, 2)
+	putDiamond(m2PKID, m1PKID, post2, 3)
+	aggregate, err := DbGetDiamondAggregateForPost(db, nil, post1)
+	requireAggregate(aggregate, err, 3, 550000)
+	aggregate, err = DbGetDiamondsReceivedAggregateForPKID(db, nil, m0PKID)
+	requireAggregate(aggregate, err, 3, 550000)
+	aggregate, err = DbGetDiamondsSentAggregateForPKID(db, nil, m2PKID)
+	requireAggregate(aggregate, err, 5, 5500000)
+	aggregate, err = DbGetDiamondsSentAggregateForPKID(db, nil, m0PKID)
+	requireAggregate(aggregate, err, 0, 0)
+
+	// Leaderboards are ordered by value, a page at a time.
+	ids, nextStartKey := getLeaderboard(DiamondAggregateTypePost, 1, nil)
+	require.Equal([][]byte{post2[:]}, ids)
+	ids, nextStartKey = getLeaderboard(DiamondAggregateTypePost, 1, nextStartKey)
+	require.Equal([][]byte{post1[:]}, ids)
+	require.Nil(nextStartKey)
+	ids, _ = getLeaderboard(DiamondAggregateTypeReceived, 0, nil)
+	require.Equal([][]byte{m1PKID[:], m0PKID[:]}, ids)
+	ids, _ = getLeaderboard(DiamondAggregateTypeSent, 0, nil)
+	require.Equal([][]byte{m2PKID[:], m1PKID[:]}, ids)
+
+	// Upgrading a diamond replaces its old level, and deleting one removes it from the aggregates.
+	putDiamond(m1PKID, m0PKID, post1, 4)
+	aggregate, err = DbGetDiamondAggregateForPost(db, nil, post1)
+	requireAggregate(aggregate, err, 6, 50500000)
+	ids, _ = getLeaderboard(DiamondAggregateTypePost, 0, nil)
+	require.Equal([][]byte{post1[:], post2[:]}, ids)
+	require.NoError(DbDeleteDiamondMappings(db, nil, &DiamondEntry{
+		SenderPKID: m2PKID, ReceiverPKID: m1PKID, DiamondPostHash: post2, DiamondLevel: 3}))
+	aggregate, err = DbGetDiamondAggregateForPost(db, nil, post2)
+	requireAggregate(aggregate, err, 0, 0)
+	ids, _ = getLeaderboard(DiamondAggregateTypeReceived, 0, nil)
+	require.Equal([][]byte{m0PKID[:]}, ids)
+
+	// The backfill rebuilds aggregates that are missing or wrong.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		if err := _dbPutDiamondAggregateWithTxn(txn, nil, DiamondAggregateTypeSent, m1PKID[:],
+			&DiamondAggregate{}, &DiamondAggregate{NumDiamonds: 9, TotalValueNanos: 9}); err != nil {
+			return err
+		}
+		if err := txn.Delete(_dbKeyForDiamondAggregate(DiamondAggregateTypePost, post1[:])); err != nil {
+			return err
+		}
+		return txn.Delete(Prefixes.PrefixDiamondAggregatesBackfilled)
+	}))
+	require.False(DbIsDiamondAggregatesBackfilled(db))
+	require.NoError(DbBackfillDiamondAggregates(db, nil))
+	require.True(DbIsDiamondAggregatesBackfilled(db))
+	aggregate, err = DbGetDiamondAggregateForPost(db, nil, post1)
+	requireAggregate(aggregate, err, 6, 50500000)
+	aggregate, err = DbGetDiamondsSentAggregateForPKID(db, nil, m1PKID)
+	requireAggregate(aggregate, err, 4, 50000000)
+	ids, _ = getLeaderboard(DiamondAggregateTypeSent, 0, nil)
+	require.Equal([][]byte{m1PKID[:], m2PKID[:]}, ids)
+}
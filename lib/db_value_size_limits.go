@@ -0,0 +1,163 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// Values that are too large destabilize badger, so DBSetWithTxn refuses to write a value that's over
+// its prefix's size limit. The limits come from the max_value_size tags in DBPrefixes, and can be
+// changed with SetDBMaxValueSizes. The default limits are MaxBlockSizeBytes, which no single txn can
+// reach, so they can't make a node reject a valid block. Lowering a state prefix's limit below what
+// consensus allows means the node fails to connect the blocks that write larger values.
+
+// ErrDBValueTooLarge is returned when writing a value that's over its prefix's size limit.
+type ErrDBValueTooLarge struct {
+	Prefix       []byte
+	ValueSize    int
+	MaxValueSize int
+}
+
+func (err *ErrDBValueTooLarge) Error() string {
+	return fmt.Sprintf("Value of %v bytes is over the %v byte limit of prefix %v (%v)",
+		err.ValueSize, err.MaxValueSize, err.Prefix, dbPrefixName(err.Prefix[0]))
+}
+
+// IsErrDBValueTooLarge returns true if the error, or any error it wraps, is an ErrDBValueTooLarge.
+func IsErrDBValueTooLarge(err error) bool {
+	var tooLargeErr *ErrDBValueTooLarge
+	return errors.As(err, &tooLargeErr)
+}
+
+// dbMaxValueSizes holds the map[byte]int of prefix size limits when they've been changed from the
+// defaults in StatePrefixes.MaxValueSizes.
+var dbMaxValueSizes atomic.Value
+
+// GetDBMaxValueSizes returns the size limit of every prefix that has one.
+func GetDBMaxValueSizes() map[byte]int {
+	if maxValueSizes, ok := dbMaxValueSizes.Load().(map[byte]int); ok {
+		return maxValueSizes
+	}
+	return StatePrefixes.MaxValueSizes
+}
+
+// SetDBMaxValueSizes overrides the default size limits of the given prefixes. A limit of zero
+// removes the prefix's limit. Passing nil restores the defaults.
+func SetDBMaxValueSizes(overrides map[byte]int) {
+	maxValueSizes := make(map[byte]int)
+	for prefix, maxValueSize := range StatePrefixes.MaxValueSizes {
+		maxValueSizes[prefix] = maxValueSize
+	}
+	for prefix, maxValueSize := range overrides {
+		if maxValueSize == 0 {
+			delete(maxValueSizes, prefix)
+		} else {
+			maxValueSizes[prefix] = maxValueSize
+		}
+	}
+	dbMaxValueSizes.Store(maxValueSizes)
+}
+
+// ParseDBMaxValueSizes converts specs of the form <prefix>=<bytes>, where the prefix is given by name,
+// like "PrefixPostHashToPostEntry", or by id, like "17", into size limit overrides.
+func ParseDBMaxValueSizes(specs []string) (map[byte]int, error) {
+	overrides := make(map[byte]int)
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("ParseDBMaxValueSizes: Spec %v isn't of the form <prefix>=<bytes>", spec)
+		}
+		maxValueSize, err := strconv.Atoi(parts[1])
+		if err != nil || maxValueSize < 0 {
+			return nil, fmt.Errorf("ParseDBMaxValueSizes: Invalid size in spec %v", spec)
+		}
+		found := false
+		for prefix, name := range StatePrefixes.PrefixNames {
+			if parts[0] == name || parts[0] == strconv.Itoa(int(prefix)) {
+				overrides[prefix] = maxValueSize
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("ParseDBMaxValueSizes: %v is not a prefix", parts[0])
+		}
+	}
+	return overrides, nil
+}
+
+// CheckDBValueSize returns an ErrDBValueTooLarge if the value is over the size limit of the key's prefix.
+func CheckDBValueSize(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return nil
+	}
+	maxValueSize, exists := GetDBMaxValueSizes()[key[0]]
+	if !exists || len(value) <= maxValueSize {
+		return nil
+	}
+	return &ErrDBValueTooLarge{Prefix: []byte{key[0]}, ValueSize: len(value), MaxValueSize: maxValueSize}
+}
+
+// OversizedDBValue is a value that's already in the db and is over its prefix's size limit.
+type OversizedDBValue struct {
+	Key          []byte
+	ValueSize    int
+	MaxValueSize int
+	// TxnHash is the txn that created the value, for the prefixes whose keys identify it: a post's
+	// hash is the hash of the txn that submitted it, and txn metadata is keyed by its txn's hash.
+	// It's nil for the other prefixes.
+	TxnHash *BlockHash
+}
+
+// ScanOversizedDBValues reports the values in the db that are over their prefix's size limit, e.g. to
+// check what the limits would refuse before lowering them. Only the keys are read, so the value
+// sizes are the ones badger keeps in its index.
+func ScanOversizedDBValues(handle *badger.DB) ([]*OversizedDBValue, error) {
+	oversizedValues := []*OversizedDBValue{}
+	for prefix, maxValueSize := range GetDBMaxValueSizes() {
+		err := handle.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			opts.Prefix = []byte{prefix}
+			iterator := txn.NewIterator(opts)
+			defer iterator.Close()
+
+			for iterator.Rewind(); iterator.ValidForPrefix(opts.Prefix); iterator.Next() {
+				valueSize := int(iterator.Item().ValueSize())
+				if valueSize <= maxValueSize {
+					continue
+				}
+				key := iterator.Item().KeyCopy(nil)
+				oversizedValues = append(oversizedValues, &OversizedDBValue{
+					Key:          key,
+					ValueSize:    valueSize,
+					MaxValueSize: maxValueSize,
+					TxnHash:      _getTxnHashForDBKey(key),
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "ScanOversizedDBValues: Problem scanning prefix %v", dbPrefixName(prefix))
+		}
+	}
+	sort.Slice(oversizedValues, func(ii, jj int) bool {
+		return bytes.Compare(oversizedValues[ii].Key, oversizedValues[jj].Key) < 0
+	})
+	return oversizedValues, nil
+}
+
+func _getTxnHashForDBKey(key []byte) *BlockHash {
+	if (key[0] == Prefixes.PrefixPostHashToPostEntry[0] || key[0] == Prefixes.PrefixTransactionIDToMetadata[0]) &&
+		len(key) == 1+HashSizeBytes {
+		return NewBlockHash(key[1:])
+	}
+	return nil
+}
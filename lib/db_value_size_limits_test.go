@@ -0,0 +1,63 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBValueSizeLimits(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	defer SetDBMaxValueSizes(nil)
+
+	postPrefix := Prefixes.PrefixPostHashToPostEntry[0]
+	require.Equal(16000000, GetDBMaxValueSizes()[postPrefix])
+
+	overrides, err := ParseDBMaxValueSizes([]string{"PrefixPostHashToPostEntry=10", "15=0"})
+	require.NoError(err)
+	require.Equal(map[byte]int{postPrefix: 10, Prefixes.PrefixTransactionIDToMetadata[0]: 0}, overrides)
+	SetDBMaxValueSizes(overrides)
+	_, hasLimit := GetDBMaxValueSizes()[Prefixes.PrefixTransactionIDToMetadata[0]]
+	require.False(hasLimit)
+
+	for _, spec := range []string{"PrefixNope=10", "17", "17=-1"} {
+		_, err = ParseDBMaxValueSizes([]string{spec})
+		require.Error(err)
+	}
+
+	// Values up to the limit are written, larger ones are refused with a typed error.
+	postHash := NewBlockHash(RandomBytes(HashSizeBytes))
+	postKey := append(append([]byte{}, Prefixes.PrefixPostHashToPostEntry...), postHash[:]...)
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return DBSetWithTxn(txn, nil, postKey, RandomBytes(10))
+	}))
+	err = db.Update(func(txn *badger.Txn) error {
+		return DBSetWithTxn(txn, nil, postKey, RandomBytes(11))
+	})
+	require.True(IsErrDBValueTooLarge(err))
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return DBSetWithTxn(txn, nil, append(append([]byte{}, Prefixes.PrefixTransactionIDToMetadata...),
+			postHash[:]...), RandomBytes(100))
+	}))
+
+	// The scanner reports values that were written before the limit was lowered, along with the
+	// txn that created them.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return txn.Set(postKey, RandomBytes(20))
+	}))
+	oversizedValues, err := ScanOversizedDBValues(db)
+	require.NoError(err)
+	require.Equal([]*OversizedDBValue{{
+		Key:          postKey,
+		ValueSize:    20,
+		MaxValueSize: 10,
+		TxnHash:      postHash,
+	}}, oversizedValues)
+}
@@ -0,0 +1,356 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/deso-protocol/go-deadlock"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// DeSoDB is the key-value store behind the db functions. Badger is the default backend, see
+// NewBadgerDeSoDB, and NewMemDeSoDB is an in-memory backend for fast unit tests. Other stores can
+// be plugged in by implementing DeSoDB and DeSoDBTxn.
+//
+// The core db wrappers, i.e. DBGetWithDeSoDBTxn, DBSetWithDeSoDBTxn and DBDeleteWithDeSoDBTxn, work
+// on any DeSoDBTxn, and the *badger.Txn wrappers like DBGetWithTxn go through them. Most of the
+// Db* helpers and the UtxoView still take badger handles, and move over to DeSoDB as they're
+// touched. Code that's already on DeSoDB can reach the underlying badger txn with BadgerTxn.
+type DeSoDB interface {
+	// NewTransaction starts a txn, which can only write if update is set. The caller must Discard
+	// it, after committing it if it's an update txn.
+	NewTransaction(update bool) DeSoDBTxn
+	// View runs fn in a read-only txn.
+	View(fn func(txn DeSoDBTxn) error) error
+	// Update runs fn in a read-write txn, which is committed if fn returns nil.
+	Update(fn func(txn DeSoDBTxn) error) error
+
+	// Get, Set, Delete and Iterate run in a txn of their own.
+	Get(key []byte) ([]byte, error)
+	Set(key []byte, value []byte) error
+	Delete(key []byte) error
+	Iterate(prefix []byte, fn func(key []byte, value []byte) error) error
+
+	Close() error
+}
+
+// DeSoDBTxn is a txn on a DeSoDB. Get returns ErrDeSoDBKeyNotFound for keys that don't exist. Iterate
+// calls fn on the records whose keys start with prefix in ascending key order, including the writes
+// made in the txn, and stops without an error when fn returns ErrStopDeSoDBIteration. The slices
+// passed to fn are only valid until it returns.
+type DeSoDBTxn interface {
+	Get(key []byte) ([]byte, error)
+	Set(key []byte, value []byte) error
+	Delete(key []byte) error
+	Iterate(prefix []byte, fn func(key []byte, value []byte) error) error
+	Commit() error
+	Discard()
+
+	// BadgerTxn returns the underlying badger txn, or nil if the backend isn't badger.
+	BadgerTxn() *badger.Txn
+}
+
+// ErrDeSoDBKeyNotFound is the same error as badger.ErrKeyNotFound, so that callers can keep checking
+// for either one whatever the backend.
+var ErrDeSoDBKeyNotFound = badger.ErrKeyNotFound
+
+// ErrStopDeSoDBIteration can be returned by an Iterate callback to stop iterating.
+var ErrStopDeSoDBIteration = errors.New("stop iteration")
+
+// ErrDeSoDBTxnReadOnly is returned when writing in a read-only txn.
+var ErrDeSoDBTxnReadOnly = errors.New("the txn is read-only")
+
+func _viewDeSoDB(db DeSoDB, fn func(txn DeSoDBTxn) error) error {
+	txn := db.NewTransaction(false)
+	defer txn.Discard()
+	return fn(txn)
+}
+
+func _updateDeSoDB(db DeSoDB, fn func(txn DeSoDBTxn) error) error {
+	txn := db.NewTransaction(true)
+	defer txn.Discard()
+	if err := fn(txn); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// -------------------------------------------------------------------------------------
+// Badger backend
+// -------------------------------------------------------------------------------------
+
+// BadgerDeSoDB is the DeSoDB on a badger db.
+type BadgerDeSoDB struct {
+	db *badger.DB
+}
+
+func NewBadgerDeSoDB(db *badger.DB) *BadgerDeSoDB {
+	return &BadgerDeSoDB{db: db}
+}
+
+// BadgerDB returns the underlying badger db.
+func (bdb *BadgerDeSoDB) BadgerDB() *badger.DB {
+	return bdb.db
+}
+
+func (bdb *BadgerDeSoDB) NewTransaction(update bool) DeSoDBTxn {
+	return WrapBadgerTxn(bdb.db.NewTransaction(update))
+}
+
+func (bdb *BadgerDeSoDB) View(fn func(txn DeSoDBTxn) error) error {
+	return bdb.db.View(func(txn *badger.Txn) error {
+		return fn(WrapBadgerTxn(txn))
+	})
+}
+
+func (bdb *BadgerDeSoDB) Update(fn func(txn DeSoDBTxn) error) error {
+	return bdb.db.Update(func(txn *badger.Txn) error {
+		return fn(WrapBadgerTxn(txn))
+	})
+}
+
+func (bdb *BadgerDeSoDB) Get(key []byte) (value []byte, err error) {
+	err = bdb.View(func(txn DeSoDBTxn) error {
+		value, err = txn.Get(key)
+		return err
+	})
+	return value, err
+}
+
+func (bdb *BadgerDeSoDB) Set(key []byte, value []byte) error {
+	return bdb.Update(func(txn DeSoDBTxn) error {
+		return txn.Set(key, value)
+	})
+}
+
+func (bdb *BadgerDeSoDB) Delete(key []byte) error {
+	return bdb.Update(func(txn DeSoDBTxn) error {
+		return txn.Delete(key)
+	})
+}
+
+func (bdb *BadgerDeSoDB) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return bdb.View(func(txn DeSoDBTxn) error {
+		return txn.Iterate(prefix, fn)
+	})
+}
+
+func (bdb *BadgerDeSoDB) Close() error {
+	return bdb.db.Close()
+}
+
+// badgerDeSoDBTxn only holds a pointer and is used by value, so wrapping a badger txn in a DeSoDBTxn
+// doesn't allocate. The *badger.Txn wrappers like DBGetWithTxn wrap the txn on every call.
+type badgerDeSoDBTxn struct {
+	txn *badger.Txn
+}
+
+// WrapBadgerTxn returns the DeSoDBTxn for a badger txn, so that functions that take a DeSoDBTxn can
+// be called from code that holds a *badger.Txn.
+func WrapBadgerTxn(txn *badger.Txn) DeSoDBTxn {
+	return badgerDeSoDBTxn{txn: txn}
+}
+
+func (btxn badgerDeSoDBTxn) Get(key []byte) ([]byte, error) {
+	item, err := btxn.txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (btxn badgerDeSoDBTxn) Set(key []byte, value []byte) error {
+	return btxn.txn.Set(key, value)
+}
+
+func (btxn badgerDeSoDBTxn) Delete(key []byte) error {
+	return btxn.txn.Delete(key)
+}
+
+func (btxn badgerDeSoDBTxn) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	iterator := btxn.txn.NewIterator(opts)
+	defer iterator.Close()
+
+	for iterator.Rewind(); iterator.ValidForPrefix(prefix); iterator.Next() {
+		value, err := iterator.Item().ValueCopy(nil)
+		if err != nil {
+			return errors.Wrapf(err, "badgerDeSoDBTxn.Iterate: Problem reading value")
+		}
+		if err = fn(iterator.Item().Key(), value); err == ErrStopDeSoDBIteration {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (btxn badgerDeSoDBTxn) Commit() error {
+	return btxn.txn.Commit()
+}
+
+func (btxn badgerDeSoDBTxn) Discard() {
+	btxn.txn.Discard()
+}
+
+func (btxn badgerDeSoDBTxn) BadgerTxn() *badger.Txn {
+	return btxn.txn
+}
+
+// -------------------------------------------------------------------------------------
+// In-memory backend
+// -------------------------------------------------------------------------------------
+
+// MemDeSoDB is a DeSoDB that keeps its records in a map. Its txns see the db as it was when they
+// started, plus their own writes, and commits are applied atomically. Unlike badger, it doesn't
+// detect conflicts between concurrent txns: the last commit wins. It's meant for unit tests.
+type MemDeSoDB struct {
+	records map[string][]byte
+	mtx     deadlock.RWMutex
+}
+
+func NewMemDeSoDB() *MemDeSoDB {
+	return &MemDeSoDB{records: make(map[string][]byte)}
+}
+
+func (mdb *MemDeSoDB) NewTransaction(update bool) DeSoDBTxn {
+	// Copying the records on every txn keeps txns isolated in the simplest possible way. Test dbs
+	// are small enough for this not to matter.
+	mdb.mtx.RLock()
+	defer mdb.mtx.RUnlock()
+	records := make(map[string][]byte, len(mdb.records))
+	for key, value := range mdb.records {
+		records[key] = value
+	}
+	return &memDeSoDBTxn{
+		db:      mdb,
+		update:  update,
+		records: records,
+		writes:  make(map[string][]byte),
+	}
+}
+
+func (mdb *MemDeSoDB) View(fn func(txn DeSoDBTxn) error) error {
+	return _viewDeSoDB(mdb, fn)
+}
+
+func (mdb *MemDeSoDB) Update(fn func(txn DeSoDBTxn) error) error {
+	return _updateDeSoDB(mdb, fn)
+}
+
+func (mdb *MemDeSoDB) Get(key []byte) (value []byte, err error) {
+	err = mdb.View(func(txn DeSoDBTxn) error {
+		value, err = txn.Get(key)
+		return err
+	})
+	return value, err
+}
+
+func (mdb *MemDeSoDB) Set(key []byte, value []byte) error {
+	return mdb.Update(func(txn DeSoDBTxn) error {
+		return txn.Set(key, value)
+	})
+}
+
+func (mdb *MemDeSoDB) Delete(key []byte) error {
+	return mdb.Update(func(txn DeSoDBTxn) error {
+		return txn.Delete(key)
+	})
+}
+
+func (mdb *MemDeSoDB) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return mdb.View(func(txn DeSoDBTxn) error {
+		return txn.Iterate(prefix, fn)
+	})
+}
+
+func (mdb *MemDeSoDB) Close() error {
+	return nil
+}
+
+type memDeSoDBTxn struct {
+	db     *MemDeSoDB
+	update bool
+	// records is the txn's view of the db, including its writes. writes holds the values the txn
+	// set, and nil for the keys it deleted.
+	records map[string][]byte
+	writes  map[string][]byte
+	done    bool
+}
+
+func (mtxn *memDeSoDBTxn) Get(key []byte) ([]byte, error) {
+	value, exists := mtxn.records[string(key)]
+	if !exists {
+		return nil, ErrDeSoDBKeyNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (mtxn *memDeSoDBTxn) Set(key []byte, value []byte) error {
+	if !mtxn.update {
+		return ErrDeSoDBTxnReadOnly
+	}
+	valueCopy := append([]byte{}, value...)
+	mtxn.records[string(key)] = valueCopy
+	mtxn.writes[string(key)] = valueCopy
+	return nil
+}
+
+func (mtxn *memDeSoDBTxn) Delete(key []byte) error {
+	if !mtxn.update {
+		return ErrDeSoDBTxnReadOnly
+	}
+	delete(mtxn.records, string(key))
+	mtxn.writes[string(key)] = nil
+	return nil
+}
+
+func (mtxn *memDeSoDBTxn) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	keys := []string{}
+	for key := range mtxn.records {
+		if bytes.HasPrefix([]byte(key), prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn([]byte(key), append([]byte{}, mtxn.records[key]...)); err == ErrStopDeSoDBIteration {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mtxn *memDeSoDBTxn) Commit() error {
+	if mtxn.done {
+		return fmt.Errorf("memDeSoDBTxn.Commit: The txn was already committed or discarded")
+	}
+	mtxn.done = true
+	if !mtxn.update {
+		return nil
+	}
+	mtxn.db.mtx.Lock()
+	defer mtxn.db.mtx.Unlock()
+	for key, value := range mtxn.writes {
+		if value == nil {
+			delete(mtxn.db.records, key)
+		} else {
+			mtxn.db.records[key] = value
+		}
+	}
+	return nil
+}
+
+func (mtxn *memDeSoDBTxn) Discard() {
+	mtxn.done = true
+}
+
+func (mtxn *memDeSoDBTxn) BadgerTxn() *badger.Txn {
+	return nil
+}
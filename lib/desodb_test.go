@@ -0,0 +1,115 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeSoDBBackends(t *testing.T) {
+	badgerDb, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	defer badgerDb.Close()
+
+	for name, db := range map[string]DeSoDB{
+		"badger": NewBadgerDeSoDB(badgerDb),
+		"memory": NewMemDeSoDB(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_testDeSoDBBackend(t, db)
+		})
+	}
+}
+
+func _testDeSoDBBackend(t *testing.T, db DeSoDB) {
+	require := require.New(t)
+
+	// Records can be set, read and deleted.
+	require.NoError(db.Set([]byte{1, 2}, []byte("a")))
+	require.NoError(db.Set([]byte{1, 1}, []byte("b")))
+	require.NoError(db.Set([]byte{2, 1}, []byte("c")))
+	value, err := db.Get([]byte{1, 2})
+	require.NoError(err)
+	require.Equal([]byte("a"), value)
+	require.NoError(db.Delete([]byte{1, 2}))
+	_, err = db.Get([]byte{1, 2})
+	require.Equal(ErrDeSoDBKeyNotFound, err)
+
+	// Iteration is in key order, sees the txn's own writes, and can be stopped.
+	iterate := func(txn DeSoDBTxn, prefix []byte, limit int) []string {
+		values := []string{}
+		require.NoError(txn.Iterate(prefix, func(key []byte, value []byte) error {
+			values = append(values, string(value))
+			if len(values) == limit {
+				return ErrStopDeSoDBIteration
+			}
+			return nil
+		}))
+		return values
+	}
+	require.NoError(db.Update(func(txn DeSoDBTxn) error {
+		require.NoError(txn.Set([]byte{1, 0}, []byte("d")))
+		require.Equal([]string{"d", "b"}, iterate(txn, []byte{1}, 0))
+		require.Equal([]string{"d"}, iterate(txn, []byte{1}, 1))
+		return nil
+	}))
+
+	// Writes aren't visible outside the txn until it's committed, and are dropped if it isn't.
+	txn := db.NewTransaction(true)
+	require.NoError(txn.Set([]byte{3}, []byte("e")))
+	_, err = db.Get([]byte{3})
+	require.Equal(ErrDeSoDBKeyNotFound, err)
+	require.NoError(txn.Commit())
+	txn.Discard()
+	value, err = db.Get([]byte{3})
+	require.NoError(err)
+	require.Equal([]byte("e"), value)
+
+	txn = db.NewTransaction(true)
+	require.NoError(txn.Delete([]byte{3}))
+	txn.Discard()
+	_, err = db.Get([]byte{3})
+	require.NoError(err)
+
+	// Read-only txns can't write.
+	require.Error(db.View(func(txn DeSoDBTxn) error {
+		return txn.Set([]byte{4}, []byte("f"))
+	}))
+
+	// The core db wrappers work on the backend.
+	key := append(append([]byte{}, Prefixes.PrefixBlockStats...), 5)
+	require.NoError(db.Update(func(txn DeSoDBTxn) error {
+		return DBSetWithDeSoDBTxn(txn, nil, key, []byte("g"))
+	}))
+	require.NoError(db.View(func(txn DeSoDBTxn) error {
+		value, err := DBGetWithDeSoDBTxn(txn, nil, key)
+		require.NoError(err)
+		require.Equal([]byte("g"), value)
+		return nil
+	}))
+	require.NoError(db.Update(func(txn DeSoDBTxn) error {
+		return DBDeleteWithDeSoDBTxn(txn, nil, key)
+	}))
+	_, err = db.Get(key)
+	require.Equal(ErrDeSoDBKeyNotFound, err)
+}
+
+func TestWrapBadgerTxnDoesNotAllocate(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	txn := db.NewTransaction(false)
+	defer txn.Discard()
+	var wrappedTxn DeSoDBTxn
+	allocs := testing.AllocsPerRun(100, func() {
+		wrappedTxn = WrapBadgerTxn(txn)
+	})
+	require.Equal(float64(0), allocs)
+	require.Equal(txn, wrappedTxn.BadgerTxn())
+}
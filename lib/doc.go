@@ -0,0 +1,9 @@
+// Package lib implements the DeSo node: the wire types, the db schema, the block processing and the
+// networking.
+//
+// Tools that only encode and decode DeSo types or read the db can build the package with the
+// deso_embedded build tag. It compiles out the block processing, the mempool, the miner, the
+// txindex, postgres and the networking, and keeps the entry types, the wire types, the key
+// builders and the db_utils getters and putters, along with the snapshot they write through.
+// The files that are compiled out start with a "//go:build !deso_embedded" constraint.
+package lib
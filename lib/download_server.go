@@ -0,0 +1,793 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// -------------------------------------------------------------------------------------
+// DownloadServer
+// -------------------------------------------------------------------------------------
+
+// DownloadServer serves blocks by height range and snapshot state chunks to nodes that want to
+// pull data in bulk from a trusted peer, e.g. a new node bootstrapping or a backup job. It's a
+// lib-level handler so it can be exposed over any transport. Every response carries a resume token
+// that the client passes back to pick up exactly where the previous response stopped, and enough
+// information for the client to validate the data it got, see ValidateDownloadedBlock and
+// ValidateStateChunk.
+type DownloadServer struct {
+	blockchain *Blockchain
+	snapshot   *Snapshot
+
+	// MaxBlockBatchBytes caps the total size of the blocks returned in a single response.
+	MaxBlockBatchBytes uint64
+}
+
+func NewDownloadServer(blockchain *Blockchain, snapshot *Snapshot) *DownloadServer {
+	return &DownloadServer{
+		blockchain:         blockchain,
+		snapshot:           snapshot,
+		MaxBlockBatchBytes: uint64(SnapshotBatchSize),
+	}
+}
+
+type DownloadResumeTokenType uint8
+
+const (
+	DownloadResumeTokenTypeBlocks DownloadResumeTokenType = 0
+	DownloadResumeTokenTypeState  DownloadResumeTokenType = 1
+)
+
+// DownloadResumeToken records where a download left off. It's handed to clients as opaque bytes,
+// with a checksum appended so that a corrupted token is rejected rather than silently resuming
+// from the wrong place.
+type DownloadResumeToken struct {
+	TokenType DownloadResumeTokenType
+
+	// For block downloads, NextHeight is the first height that hasn't been served yet, and
+	// LastBlockHash is the hash of the block right before it. We use LastBlockHash to detect
+	// that the best chain was reorged in between requests.
+	NextHeight    uint64
+	LastBlockHash *BlockHash
+
+	// For state downloads, Prefix and NextKey locate the next chunk, and SnapshotBlockHeight is
+	// the snapshot epoch the download started in. Chunks from different epochs can't be combined.
+	Prefix              []byte
+	NextKey             []byte
+	SnapshotBlockHeight uint64
+}
+
+// downloadResumeTokenChecksumLen is the number of bytes of the token's double sha256 appended to it.
+const downloadResumeTokenChecksumLen = 4
+
+func (token *DownloadResumeToken) ToBytes() []byte {
+	var data []byte
+	data = append(data, byte(token.TokenType))
+	data = append(data, UintToBuf(token.NextHeight)...)
+	lastBlockHash := []byte{}
+	if token.LastBlockHash != nil {
+		lastBlockHash = token.LastBlockHash[:]
+	}
+	data = append(data, EncodeByteArray(lastBlockHash)...)
+	data = append(data, EncodeByteArray(token.Prefix)...)
+	data = append(data, EncodeByteArray(token.NextKey)...)
+	data = append(data, UintToBuf(token.SnapshotBlockHeight)...)
+
+	checksum := Sha256DoubleHash(data)
+	return append(data, checksum[:downloadResumeTokenChecksumLen]...)
+}
+
+func (token *DownloadResumeToken) FromBytes(tokenBytes []byte) error {
+	if len(tokenBytes) < downloadResumeTokenChecksumLen+1 {
+		return fmt.Errorf("DownloadResumeToken.FromBytes: Token is too short")
+	}
+	data := tokenBytes[:len(tokenBytes)-downloadResumeTokenChecksumLen]
+	checksum := Sha256DoubleHash(data)
+	if !bytes.Equal(checksum[:downloadResumeTokenChecksumLen], tokenBytes[len(data):]) {
+		return fmt.Errorf("DownloadResumeToken.FromBytes: Token checksum doesn't match")
+	}
+
+	rr := bytes.NewReader(data)
+	tokenType, err := rr.ReadByte()
+	if err != nil {
+		return errors.Wrapf(err, "DownloadResumeToken.FromBytes: Problem reading TokenType")
+	}
+	token.TokenType = DownloadResumeTokenType(tokenType)
+
+	token.NextHeight, err = ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "DownloadResumeToken.FromBytes: Problem reading NextHeight")
+	}
+
+	lastBlockHash, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "DownloadResumeToken.FromBytes: Problem reading LastBlockHash")
+	}
+	token.LastBlockHash = nil
+	if len(lastBlockHash) == HashSizeBytes {
+		token.LastBlockHash = NewBlockHash(lastBlockHash)
+	} else if len(lastBlockHash) != 0 {
+		return fmt.Errorf("DownloadResumeToken.FromBytes: Invalid LastBlockHash length %v", len(lastBlockHash))
+	}
+
+	token.Prefix, err = DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "DownloadResumeToken.FromBytes: Problem reading Prefix")
+	}
+
+	token.NextKey, err = DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "DownloadResumeToken.FromBytes: Problem reading NextKey")
+	}
+
+	token.SnapshotBlockHeight, err = ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "DownloadResumeToken.FromBytes: Problem reading SnapshotBlockHeight")
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------
+// Blocks by height range
+// -------------------------------------------------------------------------------------
+
+// BlockRangeRequest asks for the best chain blocks with heights in [StartHeight, EndHeight]. If
+// ResumeToken is set, StartHeight is ignored and the download continues from the token.
+type BlockRangeRequest struct {
+	StartHeight uint64
+	EndHeight   uint64
+	ResumeToken []byte
+}
+
+type DownloadedBlock struct {
+	Height     uint64
+	Hash       *BlockHash
+	BlockBytes []byte
+}
+
+type BlockRangeResponse struct {
+	Blocks []*DownloadedBlock
+	// Complete is true if the response reaches EndHeight, or the block tip if EndHeight is above it.
+	// Otherwise, ResumeToken should be passed in the next request.
+	Complete    bool
+	ResumeToken []byte
+}
+
+func (ds *DownloadServer) GetBlocksByHeightRange(req *BlockRangeRequest) (*BlockRangeResponse, error) {
+	if ds.blockchain.isSyncing() {
+		return nil, fmt.Errorf("DownloadServer.GetBlocksByHeightRange: Can't serve blocks while syncing")
+	}
+
+	startHeight := req.StartHeight
+	var lastBlockHash *BlockHash
+	if len(req.ResumeToken) > 0 {
+		token := &DownloadResumeToken{}
+		if err := token.FromBytes(req.ResumeToken); err != nil {
+			return nil, errors.Wrapf(err, "DownloadServer.GetBlocksByHeightRange: ")
+		}
+		if token.TokenType != DownloadResumeTokenTypeBlocks {
+			return nil, fmt.Errorf("DownloadServer.GetBlocksByHeightRange: Resume token is for a state download")
+		}
+		startHeight = token.NextHeight
+		lastBlockHash = token.LastBlockHash
+	}
+	if startHeight > req.EndHeight {
+		return nil, fmt.Errorf("DownloadServer.GetBlocksByHeightRange: StartHeight (%v) is greater "+
+			"than EndHeight (%v)", startHeight, req.EndHeight)
+	}
+
+	resp := &BlockRangeResponse{}
+	var err error
+	if ds.blockchain.postgres == nil {
+		resp.Blocks, resp.Complete, err = ds._getBlocksFromMainChainIndex(startHeight, req.EndHeight, lastBlockHash)
+	} else {
+		resp.Blocks, resp.Complete, err = ds._getBlocksFromBestChain(startHeight, req.EndHeight, lastBlockHash)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "DownloadServer.GetBlocksByHeightRange: ")
+	}
+	if len(resp.Blocks) > 0 {
+		lastBlock := resp.Blocks[len(resp.Blocks)-1]
+		token := &DownloadResumeToken{
+			TokenType:     DownloadResumeTokenTypeBlocks,
+			NextHeight:    lastBlock.Height + 1,
+			LastBlockHash: lastBlock.Hash,
+		}
+		resp.ResumeToken = token.ToBytes()
+	}
+	glog.V(2).Infof("DownloadServer.GetBlocksByHeightRange: Serving %v blocks starting at height %v, "+
+		"complete (%v)", len(resp.Blocks), startHeight, resp.Complete)
+	return resp, nil
+}
+
+// _getBlocksFromMainChainIndex looks the block hashes up in the main chain index, in the same txn as
+// the blocks, so the hashes and blocks are consistent without holding the ChainLock. Complete is
+// false if the response stopped at MaxBlockBatchBytes before reaching endHeight or the block tip.
+func (ds *DownloadServer) _getBlocksFromMainChainIndex(startHeight uint64, endHeight uint64,
+	lastBlockHash *BlockHash) (_blocks []*DownloadedBlock, _complete bool, _err error) {
+
+	var blocks []*DownloadedBlock
+	complete := true
+	err := ds.blockchain.db.View(func(txn *badger.Txn) error {
+		if lastBlockHash != nil {
+			var mainChainHash *BlockHash
+			if startHeight > 0 {
+				mainChainHash = DbGetMainChainHashAtHeightWithTxn(txn, startHeight-1)
+			}
+			if mainChainHash == nil || !mainChainHash.IsEqual(lastBlockHash) {
+				return fmt.Errorf("Block %v at height %v is no longer on the best chain, the download "+
+					"has to be restarted", lastBlockHash, int64(startHeight)-1)
+			}
+		}
+
+		numBytes := uint64(0)
+		for height := startHeight; height <= endHeight; height++ {
+			blockHash := DbGetMainChainHashAtHeightWithTxn(txn, height)
+			if blockHash == nil {
+				// We've reached the block tip.
+				break
+			}
+			blockBytes, err := DBGetWithTxn(txn, ds.snapshot, BlockHashToBlockKey(blockHash))
+			if err != nil {
+				return errors.Wrapf(err, "Problem fetching block %v", blockHash)
+			}
+			// Always return at least one block so that the download makes progress.
+			if len(blocks) > 0 && numBytes+uint64(len(blockBytes)) > ds.MaxBlockBatchBytes {
+				complete = false
+				break
+			}
+			numBytes += uint64(len(blockBytes))
+			blocks = append(blocks, &DownloadedBlock{
+				Height:     height,
+				Hash:       blockHash,
+				BlockBytes: blockBytes,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return blocks, complete, nil
+}
+
+// _getBlocksFromBestChain is used on postgres nodes, which don't maintain the main chain index. It
+// copies the hashes it needs out of the best chain so it doesn't hold the ChainLock while reading
+// blocks.
+func (ds *DownloadServer) _getBlocksFromBestChain(startHeight uint64, endHeight uint64,
+	lastBlockHash *BlockHash) (_blocks []*DownloadedBlock, _complete bool, _err error) {
+
+	var blockHashes []*BlockHash
+	ds.blockchain.ChainLock.RLock()
+	bestChain := ds.blockchain.bestChain
+	if lastBlockHash != nil && (startHeight == 0 || startHeight > uint64(len(bestChain)) ||
+		!bestChain[startHeight-1].Hash.IsEqual(lastBlockHash)) {
+
+		ds.blockchain.ChainLock.RUnlock()
+		return nil, false, fmt.Errorf("Block %v at height %v is no longer on the best chain, the "+
+			"download has to be restarted", lastBlockHash, int64(startHeight)-1)
+	}
+	for height := startHeight; height <= endHeight && height < uint64(len(bestChain)); height++ {
+		blockHashes = append(blockHashes, bestChain[height].Hash)
+	}
+	ds.blockchain.ChainLock.RUnlock()
+
+	var blocks []*DownloadedBlock
+	numBytes := uint64(0)
+	err := ds.blockchain.db.View(func(txn *badger.Txn) error {
+		for ii, blockHash := range blockHashes {
+			blockBytes, err := DBGetWithTxn(txn, ds.snapshot, BlockHashToBlockKey(blockHash))
+			if err != nil {
+				return errors.Wrapf(err, "Problem fetching block %v", blockHash)
+			}
+			// Always return at least one block so that the download makes progress.
+			if len(blocks) > 0 && numBytes+uint64(len(blockBytes)) > ds.MaxBlockBatchBytes {
+				break
+			}
+			numBytes += uint64(len(blockBytes))
+			blocks = append(blocks, &DownloadedBlock{
+				Height:     startHeight + uint64(ii),
+				Hash:       blockHash,
+				BlockBytes: blockBytes,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return blocks, len(blocks) == len(blockHashes), nil
+}
+
+// ValidateDownloadedBlock parses a downloaded block and checks that it's the block it claims to be,
+// i.e. that its header hashes to Hash, its height matches, and its merkle root matches its txns.
+func ValidateDownloadedBlock(downloadedBlock *DownloadedBlock) (*MsgDeSoBlock, error) {
+	block := NewMessage(MsgTypeBlock).(*MsgDeSoBlock)
+	if err := block.FromBytes(downloadedBlock.BlockBytes); err != nil {
+		return nil, errors.Wrapf(err, "ValidateDownloadedBlock: Problem parsing block")
+	}
+	blockHash, err := block.Header.Hash()
+	if err != nil {
+		return nil, errors.Wrapf(err, "ValidateDownloadedBlock: Problem hashing header")
+	}
+	if downloadedBlock.Hash == nil || !blockHash.IsEqual(downloadedBlock.Hash) {
+		return nil, fmt.Errorf("ValidateDownloadedBlock: Block hash %v doesn't match expected hash %v",
+			blockHash, downloadedBlock.Hash)
+	}
+	if block.Header.Height != downloadedBlock.Height {
+		return nil, fmt.Errorf("ValidateDownloadedBlock: Block height %v doesn't match expected height %v",
+			block.Header.Height, downloadedBlock.Height)
+	}
+	merkleRoot, _, err := ComputeMerkleRoot(block.Txns)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ValidateDownloadedBlock: Problem computing merkle root")
+	}
+	if block.Header.TransactionMerkleRoot == nil || !merkleRoot.IsEqual(block.Header.TransactionMerkleRoot) {
+		return nil, fmt.Errorf("ValidateDownloadedBlock: Merkle root %v doesn't match header merkle root %v",
+			merkleRoot, block.Header.TransactionMerkleRoot)
+	}
+	return block, nil
+}
+
+// -------------------------------------------------------------------------------------
+// State chunks
+// -------------------------------------------------------------------------------------
+
+// StateChunkRequest asks for the next chunk of snapshot records under Prefix, starting at StartKey.
+// If ResumeToken is set, Prefix and StartKey are ignored and the download continues from the token.
+type StateChunkRequest struct {
+	Prefix      []byte
+	StartKey    []byte
+	ResumeToken []byte
+}
+
+type StateChunkResponse struct {
+	Prefix           []byte
+	SnapshotMetadata *SnapshotEpochMetadata
+	Entries          []*DBEntry
+	// ChunkChecksum is the double sha256 of the serialized entries. Clients should check it with
+	// ValidateStateChunk, and once every prefix is downloaded, compare their state checksum against
+	// SnapshotMetadata.CurrentEpochChecksumBytes as hypersync does.
+	ChunkChecksum *BlockHash
+	// Complete is true if there are no more records under Prefix. Otherwise, ResumeToken should be
+	// passed in the next request.
+	Complete    bool
+	ResumeToken []byte
+}
+
+// GetStateChunk returns the next chunk of snapshot records. Similar to Snapshot.GetSnapshotChunk, it
+// returns a concurrencyFault if the db was being flushed while the chunk was read, in which case the
+// caller should retry the same request a bit later.
+func (ds *DownloadServer) GetStateChunk(req *StateChunkRequest) (
+	_resp *StateChunkResponse, _concurrencyFault bool, _err error) {
+
+	if ds.snapshot == nil {
+		return nil, false, fmt.Errorf("DownloadServer.GetStateChunk: Node doesn't support HyperSync")
+	}
+	if ds.blockchain.isSyncing() {
+		return nil, false, fmt.Errorf("DownloadServer.GetStateChunk: Can't serve state while syncing")
+	}
+
+	prefix := req.Prefix
+	startKey := req.StartKey
+	snapshotBlockHeight := ds.snapshot.CurrentEpochSnapshotMetadata.SnapshotBlockHeight
+	if len(req.ResumeToken) > 0 {
+		token := &DownloadResumeToken{}
+		if err := token.FromBytes(req.ResumeToken); err != nil {
+			return nil, false, errors.Wrapf(err, "DownloadServer.GetStateChunk: ")
+		}
+		if token.TokenType != DownloadResumeTokenTypeState {
+			return nil, false, fmt.Errorf("DownloadServer.GetStateChunk: Resume token is for a block download")
+		}
+		if token.SnapshotBlockHeight != snapshotBlockHeight {
+			return nil, false, fmt.Errorf("DownloadServer.GetStateChunk: Snapshot epoch moved from height %v "+
+				"to %v, the download has to be restarted", token.SnapshotBlockHeight, snapshotBlockHeight)
+		}
+		prefix = token.Prefix
+		startKey = token.NextKey
+	}
+	if len(prefix) == 0 || !isStateKey(prefix) {
+		return nil, false, fmt.Errorf("DownloadServer.GetStateChunk: Prefix %v is not a state prefix", prefix)
+	}
+	if len(startKey) == 0 {
+		startKey = prefix
+	}
+	if !bytes.HasPrefix(startKey, prefix) {
+		return nil, false, fmt.Errorf("DownloadServer.GetStateChunk: StartKey %v doesn't have prefix %v",
+			startKey, prefix)
+	}
+
+	entries, full, concurrencyFault, err := ds.snapshot.GetSnapshotChunk(ds.blockchain.db, prefix, startKey)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "DownloadServer.GetStateChunk: ")
+	}
+	if concurrencyFault {
+		return nil, true, nil
+	}
+	// GetSnapshotChunk returns a single empty entry when there's nothing left under the prefix.
+	if len(entries) == 1 && entries[0].IsEmpty() {
+		entries = []*DBEntry{}
+	}
+
+	resp := &StateChunkResponse{
+		Prefix:           prefix,
+		SnapshotMetadata: ds.snapshot.CurrentEpochSnapshotMetadata,
+		Entries:          entries,
+		ChunkChecksum:    ComputeStateChunkChecksum(entries),
+		Complete:         !full,
+	}
+	if full && len(entries) > 0 {
+		// The smallest key that comes after the last entry is the last key with a zero byte appended.
+		lastKey := entries[len(entries)-1].Key
+		token := &DownloadResumeToken{
+			TokenType:           DownloadResumeTokenTypeState,
+			Prefix:              prefix,
+			NextKey:             append(append([]byte{}, lastKey...), 0x00),
+			SnapshotBlockHeight: snapshotBlockHeight,
+		}
+		resp.ResumeToken = token.ToBytes()
+	}
+	return resp, false, nil
+}
+
+func ComputeStateChunkChecksum(entries []*DBEntry) *BlockHash {
+	var data []byte
+	for _, entry := range entries {
+		data = append(data, entry.ToBytes()...)
+	}
+	return Sha256DoubleHash(data)
+}
+
+// ValidateStateChunk checks that the entries in a state chunk match the chunk's checksum, are sorted,
+// and all fall under the requested prefix.
+func ValidateStateChunk(resp *StateChunkResponse) error {
+	checksum := ComputeStateChunkChecksum(resp.Entries)
+	if resp.ChunkChecksum == nil || !checksum.IsEqual(resp.ChunkChecksum) {
+		return fmt.Errorf("ValidateStateChunk: Chunk checksum %v doesn't match expected checksum %v",
+			checksum, resp.ChunkChecksum)
+	}
+	for ii, entry := range resp.Entries {
+		if !bytes.HasPrefix(entry.Key, resp.Prefix) {
+			return fmt.Errorf("ValidateStateChunk: Entry key %v doesn't have prefix %v", entry.Key, resp.Prefix)
+		}
+		if ii > 0 && bytes.Compare(resp.Entries[ii-1].Key, entry.Key) >= 0 {
+			return fmt.Errorf("ValidateStateChunk: Entries aren't sorted at index %v", ii)
+		}
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------
+// Prefix repair
+// -------------------------------------------------------------------------------------
+
+// StateChunkSource serves state chunks. It's implemented by DownloadServer, and can be implemented
+// by a client for a peer's DownloadServer over whatever transport exposes it.
+type StateChunkSource interface {
+	GetStateChunk(req *StateChunkRequest) (_resp *StateChunkResponse, _concurrencyFault bool, _err error)
+}
+
+// RepairPrefixFromPeer replaces all the records under a state prefix, e.g. a corrupted secondary
+// index, with the records the peer serves for it. Every chunk is checked with ValidateStateChunk,
+// and the peer's records are only swapped in once the whole prefix has been downloaded and the
+// state checksum, with the peer's records in place of ours, matches the local snapshot's checksum
+// for the epoch. A failed download or verification leaves the db as it was.
+//
+// The records are staged and swapped in in batches, since a large prefix doesn't fit in a single
+// badger txn. The swap isn't atomic, so nothing may read the db while it runs: call this on a db no
+// node has open, or through Blockchain.RepairPrefixFromPeer, which holds the ChainLock throughout.
+// If the swap is interrupted, the prefix is left partially replaced until ResumePrefixRepair
+// finishes it from the staged records, which NewBlockchain does on startup.
+//
+// The peer's records are only valid as of the block of its snapshot epoch, and they're verified
+// against the local snapshot's checksum for that epoch, so both of these have to hold:
+//   - The local best block hash is the epoch's CurrentEpochBlockHash, and the local state flush
+//     height is the epoch's SnapshotBlockHeight. Records from the epoch can't be swapped into a
+//     db that has connected blocks past it, since those blocks may have changed the prefix.
+//   - The local snapshot is at the same epoch as the peer's.
+//
+// Otherwise an error is returned and the db is left as it was. On a stopped node,
+// DisconnectBlocksToHeight can be used to roll back to the epoch's block first.
+func RepairPrefixFromPeer(db *badger.DB, snap *Snapshot, prefix []byte, peerChunkSource StateChunkSource) error {
+	if len(prefix) == 0 || !isStateKey(prefix) {
+		return fmt.Errorf("RepairPrefixFromPeer: Prefix %v is not a state prefix", prefix)
+	}
+	if snap == nil || snap.CurrentEpochSnapshotMetadata == nil ||
+		len(snap.CurrentEpochSnapshotMetadata.CurrentEpochChecksumBytes) == 0 {
+		return fmt.Errorf("RepairPrefixFromPeer: The local snapshot has no epoch checksum to verify against")
+	}
+	if err := ResumePrefixRepair(db, snap); err != nil {
+		return errors.Wrapf(err, "RepairPrefixFromPeer: ")
+	}
+
+	// Download and validate the entire prefix before touching the db.
+	var entries []*DBEntry
+	var snapshotMetadata *SnapshotEpochMetadata
+	req := &StateChunkRequest{Prefix: prefix}
+	numConcurrencyFaults := 0
+	for {
+		resp, concurrencyFault, err := peerChunkSource.GetStateChunk(req)
+		if err != nil {
+			return errors.Wrapf(err, "RepairPrefixFromPeer: Problem getting state chunk")
+		}
+		if concurrencyFault {
+			// The peer was flushing, so we retry the same request a bit later.
+			numConcurrencyFaults++
+			if numConcurrencyFaults > RepairPrefixMaxConcurrencyFaults {
+				return fmt.Errorf("RepairPrefixFromPeer: Peer returned too many concurrency faults")
+			}
+			time.Sleep(RepairPrefixConcurrencyFaultRetryInterval)
+			continue
+		}
+
+		if err := ValidateStateChunk(resp); err != nil {
+			return errors.Wrapf(err, "RepairPrefixFromPeer: ")
+		}
+		if !bytes.Equal(resp.Prefix, prefix) {
+			return fmt.Errorf("RepairPrefixFromPeer: Peer returned a chunk for prefix %v instead of %v",
+				resp.Prefix, prefix)
+		}
+		if resp.SnapshotMetadata == nil || resp.SnapshotMetadata.CurrentEpochBlockHash == nil {
+			return fmt.Errorf("RepairPrefixFromPeer: Peer returned a chunk without snapshot metadata")
+		}
+		if snapshotMetadata == nil {
+			snapshotMetadata = resp.SnapshotMetadata
+		} else if resp.SnapshotMetadata.SnapshotBlockHeight != snapshotMetadata.SnapshotBlockHeight ||
+			!resp.SnapshotMetadata.CurrentEpochBlockHash.IsEqual(snapshotMetadata.CurrentEpochBlockHash) {
+			return fmt.Errorf("RepairPrefixFromPeer: Peer's snapshot epoch changed during the download")
+		}
+		// Each chunk has to pick up after the last entry of the previous one.
+		if len(entries) > 0 && len(resp.Entries) > 0 &&
+			bytes.Compare(entries[len(entries)-1].Key, resp.Entries[0].Key) >= 0 {
+			return fmt.Errorf("RepairPrefixFromPeer: Chunk overlaps with the previous chunk")
+		}
+		entries = append(entries, resp.Entries...)
+
+		if resp.Complete {
+			break
+		}
+		if len(resp.ResumeToken) == 0 {
+			return fmt.Errorf("RepairPrefixFromPeer: Peer returned an incomplete chunk without a resume token")
+		}
+		req = &StateChunkRequest{ResumeToken: resp.ResumeToken}
+	}
+
+	// The peer's records only fit in with the rest of our state if we're at the same block, and
+	// they can only be verified against our snapshot if it's at the same epoch.
+	localFlushHeight := uint64(0)
+	if flushHeight := DbGetStateFlushHeight(db, nil); flushHeight != nil {
+		localFlushHeight = *flushHeight
+	}
+	localBestHash := DbGetBestHash(db, nil, ChainTypeDeSoBlock)
+	if localBestHash == nil || !localBestHash.IsEqual(snapshotMetadata.CurrentEpochBlockHash) ||
+		localFlushHeight != snapshotMetadata.SnapshotBlockHeight {
+
+		return fmt.Errorf("RepairPrefixFromPeer: Peer's snapshot is at block %v at height %v but our db "+
+			"is at block %v at height %v", snapshotMetadata.CurrentEpochBlockHash,
+			snapshotMetadata.SnapshotBlockHeight, localBestHash, localFlushHeight)
+	}
+	localMetadata := snap.CurrentEpochSnapshotMetadata
+	if localMetadata.CurrentEpochBlockHash == nil ||
+		!localMetadata.CurrentEpochBlockHash.IsEqual(snapshotMetadata.CurrentEpochBlockHash) ||
+		localMetadata.SnapshotBlockHeight != snapshotMetadata.SnapshotBlockHeight {
+
+		return fmt.Errorf("RepairPrefixFromPeer: Peer's snapshot is at block %v at height %v but our "+
+			"snapshot is at block %v at height %v", snapshotMetadata.CurrentEpochBlockHash,
+			snapshotMetadata.SnapshotBlockHeight, localMetadata.CurrentEpochBlockHash,
+			localMetadata.SnapshotBlockHeight)
+	}
+
+	// Don't trust the peer's records just because they match its own checksums: the state
+	// checksum with them in place of our records has to match the checksum our snapshot computed.
+	checksumBytes, err := _computeStateChecksumWithPrefixReplaced(
+		db, prefix, entries, snapshotMetadata.SnapshotBlockHeight)
+	if err != nil {
+		return errors.Wrapf(err, "RepairPrefixFromPeer: ")
+	}
+	if !bytes.Equal(checksumBytes, localMetadata.CurrentEpochChecksumBytes) {
+		return fmt.Errorf("RepairPrefixFromPeer: State checksum with the peer's records under prefix %v "+
+			"doesn't match the local snapshot checksum", prefix)
+	}
+
+	// Stage the records, then mark the swap as started so that it's finished even if we're
+	// interrupted from here on.
+	if err := _deleteKeysUnderPrefixInBatches(db, Prefixes.PrefixRepairStagingRecords); err != nil {
+		return errors.Wrapf(err, "RepairPrefixFromPeer: Problem clearing staged records")
+	}
+	if err := _setEntriesInBatches(db, Prefixes.PrefixRepairStagingRecords, entries); err != nil {
+		return errors.Wrapf(err, "RepairPrefixFromPeer: Problem staging records")
+	}
+	err = db.Update(func(txn *badger.Txn) error {
+		return DBSetWithTxn(txn, nil, Prefixes.PrefixRepairSwapMarker, prefix)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "RepairPrefixFromPeer: Problem setting swap marker")
+	}
+	if err := ResumePrefixRepair(db, snap); err != nil {
+		return errors.Wrapf(err, "RepairPrefixFromPeer: ")
+	}
+
+	glog.Infof("RepairPrefixFromPeer: Replaced the records under prefix %v with %v records from the peer "+
+		"at height %v", prefix, len(entries), snapshotMetadata.SnapshotBlockHeight)
+	return nil
+}
+
+// ResumePrefixRepair finishes a swap that RepairPrefixFromPeer started but didn't complete, and does
+// nothing if there isn't one. Every step can be repeated, so it's safe to call again if it's
+// interrupted itself. The swap writes to badger directly, so the snapshot's DatabaseCache and
+// NegativeLookupCache are reset once it's done.
+func ResumePrefixRepair(db *badger.DB, snap *Snapshot) error {
+	var prefix []byte
+	err := db.View(func(txn *badger.Txn) error {
+		var err error
+		prefix, err = DBGetWithTxn(txn, nil, Prefixes.PrefixRepairSwapMarker)
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "ResumePrefixRepair: Problem getting swap marker")
+	}
+
+	if err := _deleteKeysUnderPrefixInBatches(db, prefix); err != nil {
+		return errors.Wrapf(err, "ResumePrefixRepair: Problem deleting records under prefix %v", prefix)
+	}
+	stagingPrefix := Prefixes.PrefixRepairStagingRecords
+	stagedKeys, stagedVals := _enumerateKeysForPrefix(db, stagingPrefix)
+	var entries []*DBEntry
+	for ii, stagedKey := range stagedKeys {
+		entries = append(entries, &DBEntry{Key: stagedKey[len(stagingPrefix):], Value: stagedVals[ii]})
+	}
+	if err := _setEntriesInBatches(db, nil, entries); err != nil {
+		return errors.Wrapf(err, "ResumePrefixRepair: Problem swapping in records under prefix %v", prefix)
+	}
+	if err := _deleteKeysUnderPrefixInBatches(db, stagingPrefix); err != nil {
+		return errors.Wrapf(err, "ResumePrefixRepair: Problem clearing staged records")
+	}
+	err = db.Update(func(txn *badger.Txn) error {
+		return DBDeleteWithTxn(txn, nil, Prefixes.PrefixRepairSwapMarker)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "ResumePrefixRepair: Problem deleting swap marker")
+	}
+	if snap != nil {
+		snap.ResetDatabaseCache()
+	}
+	glog.Infof("ResumePrefixRepair: Swapped in %v records under prefix %v", len(entries), prefix)
+	return nil
+}
+
+// RepairPrefixFromPeer runs RepairPrefixFromPeer on the chain's db while holding the ChainLock, so
+// that the node doesn't read the prefix while it's being swapped.
+func (bc *Blockchain) RepairPrefixFromPeer(prefix []byte, peerChunkSource StateChunkSource) error {
+	bc.ChainLock.Lock()
+	defer bc.ChainLock.Unlock()
+
+	if bc.postgres != nil {
+		return fmt.Errorf("RepairPrefixFromPeer: Repairing prefixes isn't supported with postgres")
+	}
+	return RepairPrefixFromPeer(bc.db, bc.snapshot, prefix, peerChunkSource)
+}
+
+// RepairCorruptedEntriesFromPeer runs RepairCorruptedEntriesFromPeer on the chain's db while
+// holding the ChainLock.
+func (bc *Blockchain) RepairCorruptedEntriesFromPeer(peerChunkSource StateChunkSource) error {
+	bc.ChainLock.Lock()
+	defer bc.ChainLock.Unlock()
+
+	if bc.postgres != nil {
+		return fmt.Errorf("RepairCorruptedEntriesFromPeer: Repairing prefixes isn't supported with postgres")
+	}
+	return RepairCorruptedEntriesFromPeer(bc.db, bc.snapshot, peerChunkSource)
+}
+
+// _computeStateChecksumWithPrefixReplaced computes the state checksum of the db at blockHeight with
+// the given entries in place of the records under prefix.
+func _computeStateChecksumWithPrefixReplaced(db *badger.DB, prefix []byte, entries []*DBEntry,
+	blockHeight uint64) ([]byte, error) {
+
+	checksum := &StateChecksum{}
+	checksum.Initialize(nil, nil)
+	for _, statePrefix := range StatePrefixes.StatePrefixesList {
+		err := ParallelScanPrefix(db, statePrefix, runtime.GOMAXPROCS(0), func(key []byte, value []byte) error {
+			if bytes.HasPrefix(key, prefix) {
+				return nil
+			}
+			return checksum.AddBytes(EncodeKeyAndValueForChecksum(key, value, blockHeight))
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "_computeStateChecksumWithPrefixReplaced: Problem scanning prefix %v",
+				statePrefix)
+		}
+	}
+	for _, entry := range entries {
+		if err := checksum.AddBytes(EncodeKeyAndValueForChecksum(entry.Key, entry.Value, blockHeight)); err != nil {
+			return nil, errors.Wrapf(err, "_computeStateChecksumWithPrefixReplaced: ")
+		}
+	}
+	checksumBytes, err := checksum.ToBytes()
+	if err != nil {
+		return nil, errors.Wrapf(err, "_computeStateChecksumWithPrefixReplaced: ")
+	}
+	return checksumBytes, nil
+}
+
+// _setEntriesInBatches writes the entries under keyPrefix followed by their keys,
+// RepairPrefixBatchSize records per txn. The records are written without a snapshot, so the
+// caller has to reset the snapshot caches once it's done, as ResumePrefixRepair does.
+func _setEntriesInBatches(db *badger.DB, keyPrefix []byte, entries []*DBEntry) error {
+	for start := 0; start < len(entries); start += RepairPrefixBatchSize {
+		end := start + RepairPrefixBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		err := db.Update(func(txn *badger.Txn) error {
+			for _, entry := range entries[start:end] {
+				key := append(append([]byte{}, keyPrefix...), entry.Key...)
+				if err := DBSetWithTxn(txn, nil, key, entry.Value); err != nil {
+					return errors.Wrapf(err, "Problem setting key %v", key)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RepairCorruptedEntriesFromPeer repairs every state prefix with a reported entry with
+// RepairPrefixFromPeer, and removes the reports for the prefixes it repaired. It stops at the first
+// prefix that can't be repaired, so the reports for that prefix and the ones after it are kept. The
+// same requirements as for RepairPrefixFromPeer apply.
+func RepairCorruptedEntriesFromPeer(db *badger.DB, snap *Snapshot, peerChunkSource StateChunkSource) error {
+	reports, err := DbGetCorruptedEntryReports(db)
+	if err != nil {
+		return errors.Wrapf(err, "RepairCorruptedEntriesFromPeer: ")
+	}
+
+	// Group the reported keys by prefix. The reports are in key order, so keys with the same
+	// prefix are adjacent.
+	var prefixes [][]byte
+	keysByPrefix := make(map[byte][][]byte)
+	for _, report := range reports {
+		if len(report.Key) == 0 {
+			continue
+		}
+		prefix := report.Key[:1]
+		if _, exists := keysByPrefix[prefix[0]]; !exists {
+			prefixes = append(prefixes, prefix)
+		}
+		keysByPrefix[prefix[0]] = append(keysByPrefix[prefix[0]], report.Key)
+	}
+
+	for _, prefix := range prefixes {
+		if err := RepairPrefixFromPeer(db, snap, prefix, peerChunkSource); err != nil {
+			return errors.Wrapf(err, "RepairCorruptedEntriesFromPeer: Problem repairing prefix %v", prefix)
+		}
+		err := db.Update(func(txn *badger.Txn) error {
+			for _, key := range keysByPrefix[prefix[0]] {
+				if err := DbDeleteCorruptedEntryReportWithTxn(txn, key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "RepairCorruptedEntriesFromPeer: Problem removing reports for prefix %v", prefix)
+		}
+		glog.Infof("RepairCorruptedEntriesFromPeer: Repaired prefix %v with %v corrupted entries",
+			prefix, len(keysByPrefix[prefix[0]]))
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadResumeToken(t *testing.T) {
+	require := require.New(t)
+
+	token := &DownloadResumeToken{
+		TokenType:           DownloadResumeTokenTypeState,
+		NextHeight:          12,
+		LastBlockHash:       &BlockHash{0x01, 0x02},
+		Prefix:              []byte{5},
+		NextKey:             []byte{5, 6, 7, 0},
+		SnapshotBlockHeight: 1000,
+	}
+	tokenBytes := token.ToBytes()
+
+	readToken := &DownloadResumeToken{}
+	require.NoError(readToken.FromBytes(tokenBytes))
+	require.Equal(token, readToken)
+
+	// Flipping any bit should make the checksum fail.
+	tokenBytes[1] ^= 0x01
+	require.Error(readToken.FromBytes(tokenBytes))
+	require.Error(readToken.FromBytes([]byte{0x01}))
+}
+
+func TestDownloadServerBlocks(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, _ := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true /*isSender*/)
+	for ii := 0; ii < 4; ii++ {
+		_, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
+		require.NoError(err)
+	}
+	tipHeight := uint64(chain.BlockTip().Height)
+	require.Equal(uint64(4), tipHeight)
+
+	downloadServer := NewDownloadServer(chain, chain.snapshot)
+
+	// Everything fits in one response.
+	resp, err := downloadServer.GetBlocksByHeightRange(&BlockRangeRequest{StartHeight: 1, EndHeight: 3})
+	require.NoError(err)
+	require.True(resp.Complete)
+	require.Len(resp.Blocks, 3)
+	for ii, downloadedBlock := range resp.Blocks {
+		require.Equal(uint64(ii+1), downloadedBlock.Height)
+		block, err := ValidateDownloadedBlock(downloadedBlock)
+		require.NoError(err)
+		require.Equal(downloadedBlock.Height, block.Header.Height)
+	}
+
+	// Asking past the tip stops at the tip.
+	resp, err = downloadServer.GetBlocksByHeightRange(&BlockRangeRequest{StartHeight: 3, EndHeight: 100})
+	require.NoError(err)
+	require.True(resp.Complete)
+	require.Len(resp.Blocks, 2)
+
+	// With a tiny batch size every response only has one block and we resume with the token.
+	downloadServer.MaxBlockBatchBytes = 1
+	var heights []uint64
+	req := &BlockRangeRequest{StartHeight: 0, EndHeight: tipHeight}
+	for {
+		resp, err = downloadServer.GetBlocksByHeightRange(req)
+		require.NoError(err)
+		require.Len(resp.Blocks, 1)
+		heights = append(heights, resp.Blocks[0].Height)
+		if resp.Complete {
+			break
+		}
+		req.ResumeToken = resp.ResumeToken
+	}
+	require.Equal([]uint64{0, 1, 2, 3, 4}, heights)
+
+	// A token pointing at a block that's not on the best chain is rejected.
+	token := &DownloadResumeToken{
+		TokenType:     DownloadResumeTokenTypeBlocks,
+		NextHeight:    2,
+		LastBlockHash: &BlockHash{0x01},
+	}
+	_, err = downloadServer.GetBlocksByHeightRange(&BlockRangeRequest{EndHeight: 4, ResumeToken: token.ToBytes()})
+	require.Error(err)
+
+	// Tampering with a block is caught by validation.
+	resp, err = downloadServer.GetBlocksByHeightRange(&BlockRangeRequest{StartHeight: 2, EndHeight: 2})
+	require.NoError(err)
+	resp.Blocks[0].Height = 3
+	_, err = ValidateDownloadedBlock(resp.Blocks[0])
+	require.Error(err)
+	resp.Blocks[0].Height = 2
+	resp.Blocks[0].Hash = &BlockHash{0x01}
+	_, err = ValidateDownloadedBlock(resp.Blocks[0])
+	require.Error(err)
+}
+
+func TestDownloadServerState(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, _ := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true /*isSender*/)
+	for ii := 0; ii < 2; ii++ {
+		_, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
+		require.NoError(err)
+	}
+	chain.snapshot.WaitForAllOperationsToFinish()
+
+	downloadServer := NewDownloadServer(chain, chain.snapshot)
+	getStateChunk := func(req *StateChunkRequest) (*StateChunkResponse, error) {
+		for {
+			resp, concurrencyFault, err := downloadServer.GetStateChunk(req)
+			if !concurrencyFault {
+				return resp, err
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	prefix := Prefixes.PrefixUtxoKeyToUtxoEntry
+	resp, err := getStateChunk(&StateChunkRequest{Prefix: prefix})
+	require.NoError(err)
+	require.NoError(ValidateStateChunk(resp))
+	require.True(resp.Complete)
+	require.Nil(resp.ResumeToken)
+	require.Equal(chain.snapshot.CurrentEpochSnapshotMetadata, resp.SnapshotMetadata)
+
+	// Tampering with an entry is caught by validation.
+	if len(resp.Entries) > 0 {
+		resp.Entries[0].Value = append(resp.Entries[0].Value, 0x00)
+		require.Error(ValidateStateChunk(resp))
+	}
+
+	// Non-state prefixes and block tokens aren't accepted.
+	_, err = getStateChunk(&StateChunkRequest{Prefix: Prefixes.PrefixBlockHashToBlock})
+	require.Error(err)
+	blockToken := &DownloadResumeToken{TokenType: DownloadResumeTokenTypeBlocks}
+	_, err = getStateChunk(&StateChunkRequest{ResumeToken: blockToken.ToBytes()})
+	require.Error(err)
+
+	// A token from another snapshot epoch is rejected.
+	staleToken := &DownloadResumeToken{
+		TokenType:           DownloadResumeTokenTypeState,
+		Prefix:              prefix,
+		NextKey:             prefix,
+		SnapshotBlockHeight: chain.snapshot.CurrentEpochSnapshotMetadata.SnapshotBlockHeight + 1,
+	}
+	_, err = getStateChunk(&StateChunkRequest{ResumeToken: staleToken.ToBytes()})
+	require.Error(err)
+}
+
+// testStateChunkSource serves a fixed list of chunks in order, after a few concurrency faults.
+type testStateChunkSource struct {
+	chunks               []*StateChunkResponse
+	numConcurrencyFaults int
+	nextChunk            int
+}
+
+func (source *testStateChunkSource) GetStateChunk(req *StateChunkRequest) (*StateChunkResponse, bool, error) {
+	if source.numConcurrencyFaults > 0 {
+		source.numConcurrencyFaults--
+		return nil, true, nil
+	}
+	chunk := source.chunks[source.nextChunk]
+	source.nextChunk++
+	return chunk, false, nil
+}
+
+func TestRepairPrefixFromPeer(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true /*isSender*/)
+	for ii := 0; ii < 2; ii++ {
+		_, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
+		require.NoError(err)
+	}
+
+	prefix := Prefixes.PrefixUtxoKeyToUtxoEntry
+	goodKeys, goodVals := EnumerateKeysForPrefix(db, prefix)
+	require.True(len(goodKeys) >= 2)
+
+	// Serve the good records in two chunks, as of the current block.
+	snapshotMetadata := &SnapshotEpochMetadata{
+		SnapshotBlockHeight:   uint64(chain.blockTip().Height),
+		CurrentEpochBlockHash: chain.blockTip().Hash,
+	}
+
+	// The local snapshot has the checksum of the good state at the same block.
+	goodChecksum := &StateChecksum{}
+	require.NoError(goodChecksum.Initialize(nil, nil))
+	for _, statePrefix := range StatePrefixes.StatePrefixesList {
+		keys, vals := EnumerateKeysForPrefix(db, statePrefix)
+		for ii := range keys {
+			require.NoError(goodChecksum.AddBytes(
+				EncodeKeyAndValueForChecksum(keys[ii], vals[ii], snapshotMetadata.SnapshotBlockHeight)))
+		}
+	}
+	goodChecksumBytes, err := goodChecksum.ToBytes()
+	require.NoError(err)
+	snap := &Snapshot{
+		DatabaseCache:       NewResizableKVCache(100),
+		NegativeLookupCache: NewNegativeLookupCache(100, time.Minute),
+		CurrentEpochSnapshotMetadata: &SnapshotEpochMetadata{
+			SnapshotBlockHeight:       snapshotMetadata.SnapshotBlockHeight,
+			CurrentEpochBlockHash:     snapshotMetadata.CurrentEpochBlockHash,
+			CurrentEpochChecksumBytes: goodChecksumBytes,
+		},
+	}
+	newChunks := func() []*StateChunkResponse {
+		var chunks []*StateChunkResponse
+		for _, keyRange := range [][2]int{{0, 1}, {1, len(goodKeys)}} {
+			var entries []*DBEntry
+			for ii := keyRange[0]; ii < keyRange[1]; ii++ {
+				entries = append(entries, &DBEntry{Key: goodKeys[ii], Value: goodVals[ii]})
+			}
+			chunks = append(chunks, &StateChunkResponse{
+				Prefix:           prefix,
+				SnapshotMetadata: snapshotMetadata,
+				Entries:          entries,
+				ChunkChecksum:    ComputeStateChunkChecksum(entries),
+				ResumeToken:      []byte{0x01},
+			})
+		}
+		chunks[1].Complete = true
+		chunks[1].ResumeToken = nil
+		return chunks
+	}
+
+	// Corrupt the prefix by dropping a record and adding a bogus one.
+	bogusKey := append(append([]byte{}, prefix...), RandomBytes(HashSizeBytes)...)
+	corruptPrefix := func() {
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			if err := txn.Delete(goodKeys[0]); err != nil {
+				return err
+			}
+			return txn.Set(bogusKey, []byte{0x01})
+		}))
+	}
+	requireRecords := func(expectedKeys [][]byte) {
+		keys, _ := EnumerateKeysForPrefix(db, prefix)
+		require.Equal(len(expectedKeys), len(keys))
+		for ii := range keys {
+			require.Equal(expectedKeys[ii], keys[ii])
+		}
+	}
+	corruptPrefix()
+	corruptedKeys, _ := EnumerateKeysForPrefix(db, prefix)
+
+	// A tampered chunk fails the repair and leaves the db untouched.
+	chunks := newChunks()
+	chunks[1].Entries[0].Value = append([]byte{}, 0x00)
+	require.Error(RepairPrefixFromPeer(db, snap, prefix, &testStateChunkSource{chunks: chunks}))
+	requireRecords(corruptedKeys)
+
+	// So does a chunk whose checksum the peer computed over bad records, since the state checksum
+	// doesn't match the local snapshot's.
+	chunks = newChunks()
+	chunks[1].Entries[0].Value = append([]byte{}, 0x00)
+	chunks[1].ChunkChecksum = ComputeStateChunkChecksum(chunks[1].Entries)
+	require.Error(RepairPrefixFromPeer(db, snap, prefix, &testStateChunkSource{chunks: chunks}))
+	requireRecords(corruptedKeys)
+
+	// The repair can't be verified without the local snapshot checksum.
+	require.Error(RepairPrefixFromPeer(db, nil, prefix, &testStateChunkSource{chunks: newChunks()}))
+	requireRecords(corruptedKeys)
+
+	// So does a snapshot from another block.
+	chunks = newChunks()
+	otherMetadata := &SnapshotEpochMetadata{
+		SnapshotBlockHeight:   snapshotMetadata.SnapshotBlockHeight - 1,
+		CurrentEpochBlockHash: chain.bestChain[len(chain.bestChain)-2].Hash,
+	}
+	for _, chunk := range chunks {
+		chunk.SnapshotMetadata = otherMetadata
+	}
+	require.Error(RepairPrefixFromPeer(db, snap, prefix, &testStateChunkSource{chunks: chunks}))
+	requireRecords(corruptedKeys)
+
+	// Non-state prefixes can't be repaired.
+	require.Error(RepairPrefixFromPeer(db, snap, Prefixes.PrefixBlockHashToBlock, &testStateChunkSource{}))
+
+	// Valid chunks restore the prefix, even after a few concurrency faults. The swap bypasses the
+	// snapshot, so the caches are reset once it's done.
+	snap.DatabaseCache.Add(hex.EncodeToString(bogusKey), []byte{0x01})
+	snap.NegativeLookupCache.AddMissing(hex.EncodeToString(goodKeys[0]), 0)
+	require.NoError(RepairPrefixFromPeer(db, snap, prefix, &testStateChunkSource{
+		chunks:               newChunks(),
+		numConcurrencyFaults: 2,
+	}))
+	keys, vals := EnumerateKeysForPrefix(db, prefix)
+	require.Equal(goodKeys, keys)
+	require.Equal(goodVals, vals)
+	stagedKeys, _ := EnumerateKeysForPrefix(db, Prefixes.PrefixRepairStagingRecords)
+	require.Empty(stagedKeys)
+	_, exists := snap.DatabaseCache.Lookup(hex.EncodeToString(bogusKey))
+	require.False(exists)
+	require.False(snap.NegativeLookupCache.IsKnownMissing(hex.EncodeToString(goodKeys[0])))
+
+	// A swap that was interrupted after some of the records were deleted is finished from the
+	// staged records.
+	var goodEntries []*DBEntry
+	for ii := range goodKeys {
+		goodEntries = append(goodEntries, &DBEntry{Key: goodKeys[ii], Value: goodVals[ii]})
+	}
+	require.NoError(_setEntriesInBatches(db, Prefixes.PrefixRepairStagingRecords, goodEntries))
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(Prefixes.PrefixRepairSwapMarker, prefix); err != nil {
+			return err
+		}
+		return txn.Delete(goodKeys[1])
+	}))
+	corruptPrefix()
+	require.NoError(ResumePrefixRepair(db, snap))
+	keys, vals = EnumerateKeysForPrefix(db, prefix)
+	require.Equal(goodKeys, keys)
+	require.Equal(goodVals, vals)
+	stagedKeys, _ = EnumerateKeysForPrefix(db, Prefixes.PrefixRepairStagingRecords)
+	require.Empty(stagedKeys)
+	markerKeys, _ := EnumerateKeysForPrefix(db, Prefixes.PrefixRepairSwapMarker)
+	require.Empty(markerKeys)
+
+	// There's nothing left to resume.
+	require.NoError(ResumePrefixRepair(db, snap))
+}
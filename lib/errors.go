@@ -68,6 +68,7 @@ const (
 	RuleErrorInvalidBlockHeader                                 RuleError = "RuleErrorInvalidBlockHeader"
 	RuleErrorBlockAlreadyExists                                 RuleError = "RuleErrorBlockAlreadyExists"
 	RuleErrorOrphanBlock                                        RuleError = "RuleErrorOrphanBlock"
+	RuleErrorKnownInvalidBlock                                  RuleError = "RuleErrorKnownInvalidBlock"
 	RuleErrorInputWithPublicKeyDifferentFromTxnPublicKey        RuleError = "RuleErrorInputWithPublicKeyDifferentFromTxnPublicKey"
 	RuleErrorBlockRewardTxnNotAllowedToHaveInputs               RuleError = "RuleErrorBlockRewardTxnNotAllowedToHaveInputs"
 	RuleErrorBlockRewardTxnNotAllowedToHaveSignature            RuleError = "RuleErrorBlockRewardTxnNotAllowedToHaveSignature"
@@ -85,6 +86,7 @@ const (
 	RuleErrorBitcoinExchangeProblemComputingBurnOutput          RuleError = "RuleErrorBitcoinExchangeProblemComputingBurnOutput"
 	RuleErrorBitcoinExchangeFeeOverflow                         RuleError = "RuleErrorBitcoinExchangeFeeOverflow"
 	RuleErrorBitcoinExchangeTotalOutputLessThanOrEqualZero      RuleError = "RuleErrorBitcoinExchangeTotalOutputLessThanOrEqualZero"
+	RuleErrorBitcoinExchangeInsufficientConfirmations           RuleError = "RuleErrorBitcoinExchangeInsufficientConfirmations"
 	RuleErrorTxnSanity                                          RuleError = "RuleErrorTxnSanity"
 	RuleErrorTxnTooBig                                          RuleError = "RuleErrorTxnTooBig"
 
@@ -114,6 +116,20 @@ const (
 	RuleErrorCannotLikeNonexistentPost         RuleError = "RuleErrorCannotLikeNonexistentPost"
 	RuleErrorCannotUnlikeWithoutAnExistingLike RuleError = "RuleErrorCannotUnlikeWithoutAnExistingLike"
 
+	RuleErrorPostAssociationBeforeBlockHeight       RuleError = "RuleErrorPostAssociationBeforeBlockHeight"
+	RuleErrorPostAssociationOnNonexistentPost       RuleError = "RuleErrorPostAssociationOnNonexistentPost"
+	RuleErrorPostAssociationInvalidType             RuleError = "RuleErrorPostAssociationInvalidType"
+	RuleErrorPostAssociationValueTooLong            RuleError = "RuleErrorPostAssociationValueTooLong"
+	RuleErrorPostAssociationDeleteWithValue         RuleError = "RuleErrorPostAssociationDeleteWithValue"
+	RuleErrorCannotDeleteNonexistentPostAssociation RuleError = "RuleErrorCannotDeleteNonexistentPostAssociation"
+
+	RuleErrorUserAssociationBeforeBlockHeight       RuleError = "RuleErrorUserAssociationBeforeBlockHeight"
+	RuleErrorUserAssociationTargetPubKeyLen         RuleError = "RuleErrorUserAssociationTargetPubKeyLen"
+	RuleErrorUserAssociationInvalidType             RuleError = "RuleErrorUserAssociationInvalidType"
+	RuleErrorUserAssociationValueTooLong            RuleError = "RuleErrorUserAssociationValueTooLong"
+	RuleErrorUserAssociationDeleteWithValue         RuleError = "RuleErrorUserAssociationDeleteWithValue"
+	RuleErrorCannotDeleteNonexistentUserAssociation RuleError = "RuleErrorCannotDeleteNonexistentUserAssociation"
+
 	RuleErrorProfileUsernameTooShort            RuleError = "RuleErrorProfileUsernameTooShort"
 	RuleErrorProfileDescriptionTooShort         RuleError = "RuleErrorProfileDescriptionTooShort"
 	RuleErrorProfileUsernameTooLong             RuleError = "RuleErrorProfileUsernameTooLong"
@@ -174,6 +190,10 @@ const (
 	RuleErrorUserNotAuthorizedToUpdateExchangeRate RuleError = "RuleErrorUserNotAuthorizedToUpdateExchangeRate"
 	RuleErrorUserNotAuthorizedToUpdateGlobalParams RuleError = "RuleErrorUserNotAuthorizedToUpdateGlobalParams"
 	RuleErrorUserOutputMustBeNonzero               RuleError = "RuleErrorUserOutputMustBeNonzero"
+	RuleErrorGlobalParamsInvalidTxnType            RuleError = "RuleErrorGlobalParamsInvalidTxnType"
+	RuleErrorGlobalParamsCannotDisableTxnType      RuleError = "RuleErrorGlobalParamsCannotDisableTxnType"
+	RuleErrorGlobalParamsInvalidDisabledValue      RuleError = "RuleErrorGlobalParamsInvalidDisabledValue"
+	RuleErrorTxnTypeDisabled                       RuleError = "RuleErrorTxnTypeDisabled"
 
 	// DeSo Diamonds
 	RuleErrorBasicTransferHasDiamondPostHashWithoutDiamondLevel   RuleError = "RuleErrorBasicTransferHasDiamondPostHashWithoutDiamondLevel"
@@ -283,6 +303,27 @@ const (
 	RuleErrorDAOCoinLimitOrderTotalInputMinusTotalOutputNotEqualToFee RuleError = "RuleErrorDAOCoinLimitOrderTotalInputMinusTotalOutputNotEqualToFee"
 	RuleErrorDAOCoinLimitOrderInvalidFillType                         RuleError = "RuleErrorDAOCoinLimitOrderInvalidFillType"
 	RuleErrorDAOCoinLimitOrderFillOrKillOrderUnfulfilled              RuleError = "RuleErrorDAOCoinLimitOrderFillOrKillOrderUnfulfilled"
+	RuleErrorDAOCoinLimitOrderCancelAllAndReplaceBeforeBlockHeight    RuleError = "RuleErrorDAOCoinLimitOrderCancelAllAndReplaceBeforeBlockHeight"
+	RuleErrorDAOCoinLimitOrderConflictingCancelFields                 RuleError = "RuleErrorDAOCoinLimitOrderConflictingCancelFields"
+	RuleErrorDAOCoinLimitOrderToReplaceNotFound                       RuleError = "RuleErrorDAOCoinLimitOrderToReplaceNotFound"
+	RuleErrorDAOCoinLimitOrderToReplaceNotYours                       RuleError = "RuleErrorDAOCoinLimitOrderToReplaceNotYours"
+	RuleErrorDAOCoinLimitOrderToReplaceDifferentPair                  RuleError = "RuleErrorDAOCoinLimitOrderToReplaceDifferentPair"
+	RuleErrorDAOCoinLimitOrderQuantityBelowMinimum                    RuleError = "RuleErrorDAOCoinLimitOrderQuantityBelowMinimum"
+	RuleErrorDAOCoinLimitOrderExchangeRateNotMultipleOfTick           RuleError = "RuleErrorDAOCoinLimitOrderExchangeRateNotMultipleOfTick"
+	RuleErrorDAOCoinLimitOrderMakerFeeTooHigh                         RuleError = "RuleErrorDAOCoinLimitOrderMakerFeeTooHigh"
+	RuleErrorDAOCoinLimitOrderTakerFeeTooHigh                         RuleError = "RuleErrorDAOCoinLimitOrderTakerFeeTooHigh"
+	RuleErrorDAOCoinLimitOrderFeeDestinationPubKeyLength              RuleError = "RuleErrorDAOCoinLimitOrderFeeDestinationPubKeyLength"
+	RuleErrorDAOCoinLimitOrderMarketOrderSlippageBeforeBlockHeight    RuleError = "RuleErrorDAOCoinLimitOrderMarketOrderSlippageBeforeBlockHeight"
+	RuleErrorDAOCoinLimitOrderSlippageOnNonMarketOrder                RuleError = "RuleErrorDAOCoinLimitOrderSlippageOnNonMarketOrder"
+	RuleErrorDAOCoinLimitOrderInvalidSlippage                         RuleError = "RuleErrorDAOCoinLimitOrderInvalidSlippage"
+	RuleErrorDAOCoinLimitOrderTriggerBeforeBlockHeight                RuleError = "RuleErrorDAOCoinLimitOrderTriggerBeforeBlockHeight"
+	RuleErrorDAOCoinLimitOrderInvalidTriggerExchangeRate              RuleError = "RuleErrorDAOCoinLimitOrderInvalidTriggerExchangeRate"
+	RuleErrorDAOCoinLimitOrderTriggerOnNonGoodTillCancelledOrder      RuleError = "RuleErrorDAOCoinLimitOrderTriggerOnNonGoodTillCancelledOrder"
+	RuleErrorDAOCoinLimitOrderTriggerWithCancelOrReplace              RuleError = "RuleErrorDAOCoinLimitOrderTriggerWithCancelOrReplace"
+	RuleErrorDAOCoinLimitOrderTriggerWithBidderInputs                 RuleError = "RuleErrorDAOCoinLimitOrderTriggerWithBidderInputs"
+	RuleErrorDAOCoinLimitOrderExpirationBeforeBlockHeight             RuleError = "RuleErrorDAOCoinLimitOrderExpirationBeforeBlockHeight"
+	RuleErrorDAOCoinLimitOrderInvalidExpiration                       RuleError = "RuleErrorDAOCoinLimitOrderInvalidExpiration"
+	RuleErrorDAOCoinLimitOrderExpirationBlockHeightPassed             RuleError = "RuleErrorDAOCoinLimitOrderExpirationBlockHeightPassed"
 
 	// Derived Keys
 	RuleErrorAuthorizeDerivedKeyAccessSignatureNotValid RuleError = "RuleErrorAuthorizeDerivedKeyAccessSignatureNotValid"
@@ -344,6 +385,11 @@ const (
 	RuleErrorAdditionalCoinRoyaltyOverflow               RuleError = "RuleErrorAdditionalCoinRoyaltyOverflow"
 	RuleErrorCannotSpecifyCreatorAsAdditionalRoyalty     RuleError = "RuleErrorCannotSpecifyCreatorAsAdditionalRoyalty"
 	RuleErrorAdditionalRoyaltyPubKeyMustBeValid          RuleError = "RuleErrorAdditionalRoyaltyPubKeyMustBeValid"
+	RuleErrorNFTOwnershipSharePubKeyMustBeValid          RuleError = "RuleErrorNFTOwnershipSharePubKeyMustBeValid"
+	RuleErrorNFTOwnershipShareMustBeNonZero              RuleError = "RuleErrorNFTOwnershipShareMustBeNonZero"
+	RuleErrorNFTOwnershipSharesOverflow                  RuleError = "RuleErrorNFTOwnershipSharesOverflow"
+	RuleErrorNFTOwnershipSharesMustAddUpToOneHundredPct  RuleError = "RuleErrorNFTOwnershipSharesMustAddUpToOneHundredPct"
+	RuleErrorCannotBurnNFTWithOwnershipShares            RuleError = "RuleErrorCannotBurnNFTWithOwnershipShares"
 
 	// NFT Bids
 	RuleErrorNFTBidRequiresNonZeroInput                    RuleError = "RuleErrorNFTBidRequiresNonZeroInput"
@@ -410,6 +456,9 @@ const (
 	RuleErrorDerivedKeyDAOCoinOperationNotAuthorized     RuleError = "RuleErrorDerivedKeyDAOCoinOperationNotAuthorized"
 	RuleErrorDerivedKeyInvalidDAOCoinLimitOrderOrderID   RuleError = "RuleErrorDerivedKeyInvalidDAOCoinLimitOrderOrderID"
 	RuleErrorDerivedKeyDAOCoinLimitOrderNotAuthorized    RuleError = "RuleErrorDerivedKeyDAOCoinLimitOrderNotAuthorized"
+	RuleErrorDerivedKeyTxnTypeNotInScope                 RuleError = "RuleErrorDerivedKeyTxnTypeNotInScope"
+	RuleErrorDerivedKeyTxnSpendsMoreThanTxnTypeDESOLimit RuleError = "RuleErrorDerivedKeyTxnSpendsMoreThanTxnTypeDESOLimit"
+	RuleErrorDerivedKeyInvalidAllowedTxnTypes            RuleError = "RuleErrorDerivedKeyInvalidAllowedTxnTypes"
 
 	HeaderErrorDuplicateHeader                                                   RuleError = "HeaderErrorDuplicateHeader"
 	HeaderErrorNilPrevHash                                                       RuleError = "HeaderErrorNilPrevHash"
@@ -427,6 +476,8 @@ const (
 	TxErrorInsufficientFeeRateLimit     RuleError = "TxErrorInsufficientFeeRateLimit"
 	TxErrorInsufficientFeePriorityQueue RuleError = "TxErrorInsufficientFeePriorityQueue"
 	TxErrorUnconnectedTxnNotAllowed     RuleError = "TxErrorUnconnectedTxnNotAllowed"
+	TxErrorPostRateLimitExceeded        RuleError = "TxErrorPostRateLimitExceeded"
+	TxErrorTxnTypeFiltered              RuleError = "TxErrorTxnTypeFiltered"
 )
 
 func (e RuleError) Error() string {
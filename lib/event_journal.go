@@ -0,0 +1,190 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// EventJournal persists the blocks and txns that the chain connects and disconnects in
+// PrefixEventJournal, so that consumers that restart don't miss the events the EventManager
+// fired while they were down. Each event has a sequence number, and a consumer replays the
+// journal from the sequence number after the last event it processed.
+//
+// The events for a block are written in the same txn as the block's flush, so the journal
+// always agrees with the state. A reorg is journaled as the disconnects of the detached blocks
+// followed by the connects of the attached ones. The journal is never trimmed.
+type EventJournal struct {
+	db *badger.DB
+}
+
+type EventJournalEventType uint8
+
+const (
+	EventJournalEventTypeBlockConnected    EventJournalEventType = 0
+	EventJournalEventTypeBlockDisconnected EventJournalEventType = 1
+	EventJournalEventTypeTxnConnected      EventJournalEventType = 2
+	EventJournalEventTypeTxnDisconnected   EventJournalEventType = 3
+)
+
+func (eventType EventJournalEventType) String() string {
+	switch eventType {
+	case EventJournalEventTypeBlockConnected:
+		return "BlockConnected"
+	case EventJournalEventTypeBlockDisconnected:
+		return "BlockDisconnected"
+	case EventJournalEventTypeTxnConnected:
+		return "TxnConnected"
+	case EventJournalEventTypeTxnDisconnected:
+		return "TxnDisconnected"
+	default:
+		return fmt.Sprintf("EventJournalEventType(%d)", uint8(eventType))
+	}
+}
+
+// EventJournalEntry describes one journaled event. BlockHash and Height are those of the block
+// that was connected or disconnected, or of the block the txn is in. TxnHash and TxnIndex are
+// only set for txn events.
+type EventJournalEntry struct {
+	Seq       uint64
+	Type      EventJournalEventType
+	BlockHash *BlockHash
+	Height    uint64
+	TxnHash   *BlockHash
+	TxnIndex  uint64
+}
+
+func (entry *EventJournalEntry) ToBytes() []byte {
+	data := UintToBuf(entry.Seq)
+	data = append(data, byte(entry.Type))
+	data = append(data, entry.BlockHash[:]...)
+	data = append(data, UintToBuf(entry.Height)...)
+	var txnHashBytes []byte
+	if entry.TxnHash != nil {
+		txnHashBytes = entry.TxnHash[:]
+	}
+	data = append(data, EncodeByteArray(txnHashBytes)...)
+	data = append(data, UintToBuf(entry.TxnIndex)...)
+	return data
+}
+
+func (entry *EventJournalEntry) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+	var err error
+
+	if entry.Seq, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "EventJournalEntry.FromBytes: Problem reading Seq")
+	}
+	eventType, err := rr.ReadByte()
+	if err != nil {
+		return errors.Wrapf(err, "EventJournalEntry.FromBytes: Problem reading Type")
+	}
+	entry.Type = EventJournalEventType(eventType)
+	entry.BlockHash = &BlockHash{}
+	if _, err = io.ReadFull(rr, entry.BlockHash[:]); err != nil {
+		return errors.Wrapf(err, "EventJournalEntry.FromBytes: Problem reading BlockHash")
+	}
+	if entry.Height, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "EventJournalEntry.FromBytes: Problem reading Height")
+	}
+	txnHashBytes, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "EventJournalEntry.FromBytes: Problem reading TxnHash")
+	}
+	entry.TxnHash = nil
+	if len(txnHashBytes) > 0 {
+		entry.TxnHash = NewBlockHash(txnHashBytes)
+	}
+	if entry.TxnIndex, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "EventJournalEntry.FromBytes: Problem reading TxnIndex")
+	}
+
+	return nil
+}
+
+func NewEventJournal(db *badger.DB) *EventJournal {
+	return &EventJournal{
+		db: db,
+	}
+}
+
+// journalBlockWithTxn appends the events for connecting or disconnecting the block. A connect
+// is journaled as the block followed by its txns in order, and a disconnect as the exact reverse,
+// so a consumer can undo a connect by replaying the disconnect's events in the order given.
+func (journal *EventJournal) journalBlockWithTxn(txn *badger.Txn, block *MsgDeSoBlock,
+	blockHash *BlockHash, height uint64, isConnect bool) error {
+
+	txHashes, err := ComputeTransactionHashes(block.Txns)
+	if err != nil {
+		return errors.Wrapf(err, "EventJournal.journalBlockWithTxn: Problem computing txn hashes")
+	}
+	blockEventType, txnEventType := EventJournalEventTypeBlockConnected, EventJournalEventTypeTxnConnected
+	if !isConnect {
+		blockEventType, txnEventType = EventJournalEventTypeBlockDisconnected, EventJournalEventTypeTxnDisconnected
+	}
+
+	entries := []*EventJournalEntry{{Type: blockEventType, BlockHash: blockHash, Height: height}}
+	for ii, txHash := range txHashes {
+		entries = append(entries, &EventJournalEntry{
+			Type:      txnEventType,
+			BlockHash: blockHash,
+			Height:    height,
+			TxnHash:   txHash,
+			TxnIndex:  uint64(ii),
+		})
+	}
+	if !isConnect {
+		for ii, jj := 0, len(entries)-1; ii < jj; ii, jj = ii+1, jj-1 {
+			entries[ii], entries[jj] = entries[jj], entries[ii]
+		}
+	}
+
+	// The next sequence number is read in the txn, so that it accounts for the events of the
+	// other blocks written in the same txn.
+	nextSeq, err := DbGetNextEventJournalSeqWithTxn(txn)
+	if err != nil {
+		return errors.Wrapf(err, "EventJournal.journalBlockWithTxn: ")
+	}
+	for _, entry := range entries {
+		entry.Seq = nextSeq
+		if err = DbPutEventJournalEntryWithTxn(txn, entry); err != nil {
+			return errors.Wrapf(err, "EventJournal.journalBlockWithTxn: ")
+		}
+		nextSeq++
+	}
+	return nil
+}
+
+// GetEvents returns up to maxEvents events starting with the one with sequence number startSeq,
+// oldest first. Consumers replay the journal by passing the sequence number after the last
+// event they processed.
+func (journal *EventJournal) GetEvents(startSeq uint64, maxEvents int) ([]*EventJournalEntry, error) {
+	if journal == nil || journal.db == nil {
+		return nil, nil
+	}
+	entries, err := DbGetEventJournalEntries(journal.db, startSeq, maxEvents)
+	if err != nil {
+		return nil, errors.Wrapf(err, "EventJournal.GetEvents: ")
+	}
+	return entries, nil
+}
+
+// NextSeq returns the sequence number that the next event will have.
+func (journal *EventJournal) NextSeq() (uint64, error) {
+	if journal == nil || journal.db == nil {
+		return 0, nil
+	}
+	var nextSeq uint64
+	err := journal.db.View(func(txn *badger.Txn) error {
+		var err error
+		nextSeq, err = DbGetNextEventJournalSeqWithTxn(txn)
+		return err
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "EventJournal.NextSeq: ")
+	}
+	return nextSeq, nil
+}
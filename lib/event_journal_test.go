@@ -0,0 +1,98 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventJournalEntryEncoding(t *testing.T) {
+	require := require.New(t)
+
+	for _, entry := range []*EventJournalEntry{
+		{
+			Seq:       7,
+			Type:      EventJournalEventTypeBlockDisconnected,
+			BlockHash: NewBlockHash(RandomBytes(HashSizeBytes)),
+			Height:    123456,
+		},
+		{
+			Seq:       8,
+			Type:      EventJournalEventTypeTxnConnected,
+			BlockHash: NewBlockHash(RandomBytes(HashSizeBytes)),
+			Height:    123456,
+			TxnHash:   NewBlockHash(RandomBytes(HashSizeBytes)),
+			TxnIndex:  3,
+		},
+	} {
+		decodedEntry := &EventJournalEntry{}
+		require.NoError(decodedEntry.FromBytes(entry.ToBytes()))
+		require.Equal(entry, decodedEntry)
+	}
+}
+
+func TestEventJournal(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+
+	// Blocks connected before the journal is set aren't journaled.
+	_, err := miner.MineAndProcessSingleBlock(0, mempool)
+	require.NoError(err)
+	eventJournal := NewEventJournal(db)
+	chain.SetEventJournal(eventJournal)
+	nextSeq, err := eventJournal.NextSeq()
+	require.NoError(err)
+	require.Equal(uint64(0), nextSeq)
+
+	// A connected block is journaled, followed by its txns.
+	blocks := []*MsgDeSoBlock{}
+	for ii := 0; ii < 2; ii++ {
+		block, err := miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+		blocks = append(blocks, block)
+	}
+	events, err := eventJournal.GetEvents(0, 100)
+	require.NoError(err)
+	require.Equal(4, len(events))
+	for ii, block := range blocks {
+		blockHash, err := block.Hash()
+		require.NoError(err)
+		blockEvent, txnEvent := events[2*ii], events[2*ii+1]
+		require.Equal(EventJournalEventTypeBlockConnected, blockEvent.Type)
+		require.Equal(blockHash, blockEvent.BlockHash)
+		require.Equal(block.Header.Height, blockEvent.Height)
+		require.Nil(blockEvent.TxnHash)
+		require.Equal(EventJournalEventTypeTxnConnected, txnEvent.Type)
+		require.Equal(blockHash, txnEvent.BlockHash)
+		require.Equal(block.Txns[0].Hash(), txnEvent.TxnHash)
+		require.Equal(uint64(0), txnEvent.TxnIndex)
+	}
+
+	// A disconnect is journaled as the reverse of the connect.
+	require.NoError(chain.DisconnectBlocksToHeight(blocks[0].Header.Height))
+	events, err = eventJournal.GetEvents(4, 100)
+	require.NoError(err)
+	require.Equal(2, len(events))
+	lastBlockHash, err := blocks[1].Hash()
+	require.NoError(err)
+	require.Equal(uint64(4), events[0].Seq)
+	require.Equal(EventJournalEventTypeTxnDisconnected, events[0].Type)
+	require.Equal(blocks[1].Txns[0].Hash(), events[0].TxnHash)
+	require.Equal(uint64(5), events[1].Seq)
+	require.Equal(EventJournalEventTypeBlockDisconnected, events[1].Type)
+	require.Equal(lastBlockHash, events[1].BlockHash)
+
+	// Replaying from a sequence number returns at most the number of events asked for.
+	events, err = eventJournal.GetEvents(1, 2)
+	require.NoError(err)
+	require.Equal(2, len(events))
+	require.Equal(uint64(1), events[0].Seq)
+	require.Equal(uint64(2), events[1].Seq)
+	nextSeq, err = eventJournal.NextSeq()
+	require.NoError(err)
+	require.Equal(uint64(6), nextSeq)
+}
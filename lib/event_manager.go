@@ -1,8 +1,13 @@
+//go:build !deso_embedded
+
 package lib
 
+import "sync"
+
 type TransactionEventFunc func(event *TransactionEvent)
 type BlockEventFunc func(event *BlockEvent)
 type SnapshotCompletedEventFunc func()
+type StateChangesEventFunc func(event *StateChangesEvent)
 
 type TransactionEvent struct {
 	Txn     *MsgDeSoTxn
@@ -21,12 +26,24 @@ type BlockEvent struct {
 	UtxoOps  [][]*UtxoOperation
 }
 
+// StateChangesEvent holds the state changes of one committed flush, in the order they were made.
+type StateChangesEvent struct {
+	FlushId      uint64
+	BlockHeight  uint64
+	StateChanges []*StateChangeRecord
+}
+
+// EventManager calls the handlers registered for each event. Handlers can be registered while
+// events are being sent, e.g. by the TXIndex, which is created after the server starts.
 type EventManager struct {
+	handlersLock sync.RWMutex
+
 	transactionConnectedHandlers []TransactionEventFunc
 	blockConnectedHandlers       []BlockEventFunc
 	blockDisconnectedHandlers    []BlockEventFunc
 	blockAcceptedHandlers        []BlockEventFunc
 	snapshotCompletedHandlers    []SnapshotCompletedEventFunc
+	stateChangesFlushedHandlers  []StateChangesEventFunc
 }
 
 func NewEventManager() *EventManager {
@@ -34,51 +51,99 @@ func NewEventManager() *EventManager {
 }
 
 func (em *EventManager) OnTransactionConnected(handler TransactionEventFunc) {
+	em.handlersLock.Lock()
+	defer em.handlersLock.Unlock()
 	em.transactionConnectedHandlers = append(em.transactionConnectedHandlers, handler)
 }
 
 func (em *EventManager) transactionConnected(event *TransactionEvent) {
-	for _, handler := range em.transactionConnectedHandlers {
+	em.handlersLock.RLock()
+	handlers := em.transactionConnectedHandlers
+	em.handlersLock.RUnlock()
+	for _, handler := range handlers {
 		handler(event)
 	}
 }
 
 func (em *EventManager) OnBlockConnected(handler BlockEventFunc) {
+	em.handlersLock.Lock()
+	defer em.handlersLock.Unlock()
 	em.blockConnectedHandlers = append(em.blockConnectedHandlers, handler)
 }
 
 func (em *EventManager) blockConnected(event *BlockEvent) {
-	for _, handler := range em.blockConnectedHandlers {
+	em.handlersLock.RLock()
+	handlers := em.blockConnectedHandlers
+	em.handlersLock.RUnlock()
+	for _, handler := range handlers {
 		handler(event)
 	}
 }
 
 func (em *EventManager) OnBlockDisconnected(handler BlockEventFunc) {
+	em.handlersLock.Lock()
+	defer em.handlersLock.Unlock()
 	em.blockDisconnectedHandlers = append(em.blockDisconnectedHandlers, handler)
 }
 
 func (em *EventManager) blockDisconnected(event *BlockEvent) {
-	for _, handler := range em.blockDisconnectedHandlers {
+	em.handlersLock.RLock()
+	handlers := em.blockDisconnectedHandlers
+	em.handlersLock.RUnlock()
+	for _, handler := range handlers {
 		handler(event)
 	}
 }
 
 func (em *EventManager) OnSnapshotCompleted(handler SnapshotCompletedEventFunc) {
+	em.handlersLock.Lock()
+	defer em.handlersLock.Unlock()
 	em.snapshotCompletedHandlers = append(em.snapshotCompletedHandlers, handler)
 }
 
 func (em *EventManager) snapshotCompleted() {
-	for _, handler := range em.snapshotCompletedHandlers {
+	em.handlersLock.RLock()
+	handlers := em.snapshotCompletedHandlers
+	em.handlersLock.RUnlock()
+	for _, handler := range handlers {
 		handler()
 	}
 }
 
 func (em *EventManager) OnBlockAccepted(handler BlockEventFunc) {
+	em.handlersLock.Lock()
+	defer em.handlersLock.Unlock()
 	em.blockAcceptedHandlers = append(em.blockAcceptedHandlers, handler)
 }
 
 func (em *EventManager) blockAccepted(event *BlockEvent) {
-	for _, handler := range em.blockAcceptedHandlers {
+	em.handlersLock.RLock()
+	handlers := em.blockAcceptedHandlers
+	em.handlersLock.RUnlock()
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// OnStateChangesFlushed registers a handler for the state changes of every flush. Handlers are
+// called with the ChainLock held, so they shouldn't block.
+func (em *EventManager) OnStateChangesFlushed(handler StateChangesEventFunc) {
+	em.handlersLock.Lock()
+	defer em.handlersLock.Unlock()
+	em.stateChangesFlushedHandlers = append(em.stateChangesFlushedHandlers, handler)
+}
+
+func (em *EventManager) hasStateChangesFlushedHandlers() bool {
+	em.handlersLock.RLock()
+	defer em.handlersLock.RUnlock()
+	return len(em.stateChangesFlushedHandlers) > 0
+}
+
+func (em *EventManager) stateChangesFlushed(event *StateChangesEvent) {
+	em.handlersLock.RLock()
+	handlers := em.stateChangesFlushedHandlers
+	em.handlersLock.RUnlock()
+	for _, handler := range handlers {
 		handler(event)
 	}
 }
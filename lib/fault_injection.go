@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// DBFaultPoint identifies a place in the db write path where a DBFaultInjector can kill the write.
+type DBFaultPoint uint8
+
+const (
+	// DBFaultPointSet is hit in DBSetWithTxn right before every record is written.
+	DBFaultPointSet DBFaultPoint = iota
+	// DBFaultPointBetweenFlushStages is hit in UtxoView.FlushToDbWithTxn in between the batch of
+	// Badger-only mappings and the batch of mappings we always flush to Badger.
+	DBFaultPointBetweenFlushStages
+	// DBFaultPointBeforeCommit is hit once everything has been written to the badger txn, but before
+	// the txn is committed.
+	DBFaultPointBeforeCommit
+	// DBFaultPointAfterCommit is hit in UtxoView.FlushToDb once the main db txn was committed.
+	DBFaultPointAfterCommit
+	// DBFaultPointBeforeAncestralFlush is hit when the snapshot is about to journal a main db flush
+	// into the ancestral records, i.e. after the main db write but before the snapshot catches up.
+	DBFaultPointBeforeAncestralFlush
+)
+
+var ErrInjectedDBFault = errors.New("injected db fault")
+
+// DBFaultInjector simulates the node dying in the middle of a db write. It's only meant to be used
+// by tests, to make sure that a crash at any point of the write path leaves the db in a state the
+// node can recover from. Once the injector fires, it behaves as if the process is gone: every
+// subsequent fault point returns ErrInjectedDBFault, and the snapshot's ancestral records flush is
+// skipped, so the snapshot status is left mid-flush just like after a real crash.
+type DBFaultInjector struct {
+	// Point is where the fault is injected.
+	Point DBFaultPoint
+	// TriggerAfter is the number of times Point is reached without failing before the fault fires.
+	TriggerAfter uint64
+
+	hits    uint64
+	crashed uint32
+}
+
+func NewDBFaultInjector(point DBFaultPoint, triggerAfter uint64) *DBFaultInjector {
+	return &DBFaultInjector{
+		Point:        point,
+		TriggerAfter: triggerAfter,
+	}
+}
+
+// Crashed returns true once the fault has fired.
+func (injector *DBFaultInjector) Crashed() bool {
+	return injector != nil && atomic.LoadUint32(&injector.crashed) == 1
+}
+
+func (injector *DBFaultInjector) _reach(point DBFaultPoint) error {
+	if injector.Crashed() {
+		return ErrInjectedDBFault
+	}
+	if point != injector.Point {
+		return nil
+	}
+	if atomic.AddUint64(&injector.hits, 1) <= injector.TriggerAfter {
+		return nil
+	}
+	atomic.StoreUint32(&injector.crashed, 1)
+	return ErrInjectedDBFault
+}
+
+// dbFaultInjector holds the *DBFaultInjector currently in use, if any. It's an atomic.Value
+// because DBSetWithTxn reads it on every write.
+var dbFaultInjector atomic.Value
+
+func init() {
+	dbFaultInjector.Store((*DBFaultInjector)(nil))
+}
+
+// SetDBFaultInjector installs a fault injector for all db writes made by this process. Passing nil
+// removes it.
+func SetDBFaultInjector(injector *DBFaultInjector) {
+	dbFaultInjector.Store(injector)
+}
+
+func _getDBFaultInjector() *DBFaultInjector {
+	return dbFaultInjector.Load().(*DBFaultInjector)
+}
+
+// _injectDBFault returns ErrInjectedDBFault if a fault injector is installed and decides to fail
+// the write at this point.
+func _injectDBFault(point DBFaultPoint) error {
+	injector := _getDBFaultInjector()
+	if injector == nil {
+		return nil
+	}
+	return injector._reach(point)
+}
+
+// _isDBFaultInjected returns true if a fault injector has fired, i.e. the write path should act as
+// if the node died.
+func _isDBFaultInjected() bool {
+	return _getDBFaultInjector().Crashed()
+}
@@ -0,0 +1,233 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+// _flushBalancesWithFault flushes a view with a couple of balance updates while the passed-in fault
+// injector is installed, and returns the error from the flush along with the view.
+func _flushBalancesWithFault(t *testing.T, view *UtxoView, injector *DBFaultInjector) error {
+	view.PublicKeyToDeSoBalanceNanos[*NewPublicKey(m0PkBytes)] = 100
+	view.PublicKeyToDeSoBalanceNanos[*NewPublicKey(m1PkBytes)] = 200
+
+	SetDBFaultInjector(injector)
+	defer SetDBFaultInjector(nil)
+	return view.FlushToDb(0)
+}
+
+func TestFlushFaultInjectionBeforeCommit(t *testing.T) {
+	require := require.New(t)
+
+	for _, injector := range []*DBFaultInjector{
+		NewDBFaultInjector(DBFaultPointSet, 0),
+		NewDBFaultInjector(DBFaultPointSet, 1),
+		NewDBFaultInjector(DBFaultPointBetweenFlushStages, 0),
+		NewDBFaultInjector(DBFaultPointBeforeCommit, 0),
+	} {
+		db, dir := GetTestBadgerDb()
+		params := DeSoTestnetParams
+		view, err := NewUtxoView(db, &params, nil, nil)
+		require.NoError(err)
+
+		// The flush should fail, and since the badger txn was never committed nothing should
+		// have made it to the db, not even the records written before the fault.
+		err = _flushBalancesWithFault(t, view, injector)
+		require.Error(err)
+		require.True(injector.Crashed())
+		for _, publicKey := range [][]byte{m0PkBytes, m1PkBytes} {
+			balance, err := DbGetDeSoBalanceNanosForPublicKey(db, nil, publicKey)
+			require.NoError(err)
+			require.Equal(uint64(0), balance)
+		}
+
+		// Once the node is back up, the same flush goes through.
+		require.NoError(view.FlushToDb(0))
+		balance, err := DbGetDeSoBalanceNanosForPublicKey(db, nil, m0PkBytes)
+		require.NoError(err)
+		require.Equal(uint64(100), balance)
+		balance, err = DbGetDeSoBalanceNanosForPublicKey(db, nil, m1PkBytes)
+		require.NoError(err)
+		require.Equal(uint64(200), balance)
+
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFlushFaultInjectionAfterCommit(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	defer db.Close()
+	params := DeSoTestnetParams
+	view, err := NewUtxoView(db, &params, nil, nil)
+	require.NoError(err)
+
+	// The main db txn was committed so all the records are there even though the flush failed.
+	injector := NewDBFaultInjector(DBFaultPointAfterCommit, 0)
+	require.Error(_flushBalancesWithFault(t, view, injector))
+	require.True(injector.Crashed())
+	balance, err := DbGetDeSoBalanceNanosForPublicKey(db, nil, m1PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(200), balance)
+
+	// Flushing the same view again is harmless.
+	require.NoError(view.FlushToDb(0))
+	balance, err = DbGetDeSoBalanceNanosForPublicKey(db, nil, m1PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(200), balance)
+}
+
+func TestFlushFaultInjectionTriggersSnapshotRecovery(t *testing.T) {
+	require := require.New(t)
+
+	for _, testCase := range []struct {
+		injector              *DBFaultInjector
+		expectedShouldRestart bool
+	}{
+		// A clean flush doesn't require recovery.
+		{nil, false},
+		// A crash anywhere in the write path leaves the snapshot mid-flush, which the snapshot
+		// detects on restart and responds to by rolling back to the last snapshot epoch.
+		{NewDBFaultInjector(DBFaultPointSet, 0), true},
+		{NewDBFaultInjector(DBFaultPointBetweenFlushStages, 0), true},
+		{NewDBFaultInjector(DBFaultPointBeforeCommit, 0), true},
+		{NewDBFaultInjector(DBFaultPointBeforeAncestralFlush, 0), true},
+	} {
+		db, dir := GetTestBadgerDb()
+		params := DeSoTestnetParams
+		snap, err, shouldRestart := NewSnapshot(db, dir, SnapshotBlockHeightPeriod, false, false, &params, true)
+		require.NoError(err)
+		require.False(shouldRestart)
+
+		view, err := NewUtxoView(db, &params, nil, snap)
+		require.NoError(err)
+		SetDBFaultInjector(testCase.injector)
+		view.PublicKeyToDeSoBalanceNanos[*NewPublicKey(m0PkBytes)] = 100
+		err = view.FlushToDb(0)
+		// The ancestral records flush happens asynchronously after FlushToDb returns so we
+		// wait for it before checking on the injector.
+		snap.WaitForAllOperationsToFinish()
+		SetDBFaultInjector(nil)
+		if testCase.injector != nil {
+			require.True(testCase.injector.Crashed())
+		} else {
+			require.NoError(err)
+		}
+
+		// Shut the snapshot down and bring it back up on the same db.
+		snap.Stop()
+		require.NoError(snap.SnapshotDb.Close())
+		snap, err, shouldRestart = NewSnapshot(db, dir, SnapshotBlockHeightPeriod, false, false, &params, true)
+		require.NoError(err)
+		require.Equal(testCase.expectedShouldRestart, shouldRestart)
+
+		snap.Stop()
+		require.NoError(snap.SnapshotDb.Close())
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFlushFaultRecoveryMatchesCleanRun(t *testing.T) {
+	require := require.New(t)
+	params := DeSoTestnetParams
+
+	openSnapshot := func(db *badger.DB, dir string) (*Snapshot, bool) {
+		snap, err, shouldRestart := NewSnapshot(db, dir, SnapshotBlockHeightPeriod, false, false, &params, true)
+		require.NoError(err)
+		return snap, shouldRestart
+	}
+	closeSnapshot := func(snap *Snapshot) {
+		snap.Stop()
+		require.NoError(snap.SnapshotDb.Close())
+	}
+	// flushBalances flushes a couple of balance updates with the passed-in fault injector installed,
+	// and returns the state checksum once the snapshot has caught up with the flush.
+	flushBalances := func(db *badger.DB, snap *Snapshot, injector *DBFaultInjector) ([]byte, error) {
+		view, err := NewUtxoView(db, &params, nil, snap)
+		require.NoError(err)
+		view.PublicKeyToDeSoBalanceNanos[*NewPublicKey(m0PkBytes)] = 100
+		view.PublicKeyToDeSoBalanceNanos[*NewPublicKey(m1PkBytes)] = 200
+
+		SetDBFaultInjector(injector)
+		defer SetDBFaultInjector(nil)
+		flushErr := view.FlushToDb(0)
+		snap.WaitForAllOperationsToFinish()
+		checksumBytes, err := snap.Checksum.ToBytes()
+		require.NoError(err)
+		return checksumBytes, flushErr
+	}
+
+	cleanDb, cleanDir := GetTestBadgerDb()
+	defer os.RemoveAll(cleanDir)
+	defer cleanDb.Close()
+	cleanSnap, _ := openSnapshot(cleanDb, cleanDir)
+	cleanChecksum, err := flushBalances(cleanDb, cleanSnap, nil)
+	require.NoError(err)
+	closeSnapshot(cleanSnap)
+
+	for _, testCase := range []struct {
+		injector            *DBFaultInjector
+		expectRecoveryError bool
+	}{
+		{NewDBFaultInjector(DBFaultPointSet, 0), false},
+		{NewDBFaultInjector(DBFaultPointSet, 1), false},
+		{NewDBFaultInjector(DBFaultPointBetweenFlushStages, 0), false},
+		{NewDBFaultInjector(DBFaultPointBeforeCommit, 0), false},
+		// The balances made it to the main db but not to the snapshot, so the state can't be
+		// reconciled with the last epoch's checksum. Recovery has to refuse to carry on, which
+		// makes the node erase its data and resync.
+		{NewDBFaultInjector(DBFaultPointBeforeAncestralFlush, 0), true},
+	} {
+		db, dir := GetTestBadgerDb()
+		snap, _ := openSnapshot(db, dir)
+		flushBalances(db, snap, testCase.injector)
+		require.True(testCase.injector.Crashed())
+		closeSnapshot(snap)
+
+		// Restart the node. The snapshot detects the crash and the node rolls back to the last
+		// snapshot epoch, like NewServer does. No blocks were connected, so the chain only needs the
+		// genesis node for the rollback.
+		snap, shouldRestart := openSnapshot(db, dir)
+		require.True(shouldRestart)
+		chain := &Blockchain{
+			db:        db,
+			params:    &params,
+			bestChain: []*BlockNode{{Height: 0}},
+		}
+		err := snap.ForceResetToLastSnapshot(chain)
+		if testCase.expectRecoveryError {
+			require.Error(err)
+			require.NoError(snap.SnapshotDb.Close())
+		} else {
+			require.NoError(err)
+
+			// ForceResetToLastSnapshot leaves the node to be restarted once more. Replaying the flush
+			// on the recovered db has to give the same state and checksum as the run without a fault.
+			snap, shouldRestart = openSnapshot(db, dir)
+			require.False(shouldRestart)
+			checksum, err := flushBalances(db, snap, nil)
+			require.NoError(err)
+			require.Equal(cleanChecksum, checksum)
+			for _, publicKey := range [][]byte{m0PkBytes, m1PkBytes} {
+				balance, err := DbGetDeSoBalanceNanosForPublicKey(db, nil, publicKey)
+				require.NoError(err)
+				cleanBalance, err := DbGetDeSoBalanceNanosForPublicKey(cleanDb, nil, publicKey)
+				require.NoError(err)
+				require.Equal(cleanBalance, balance)
+			}
+			closeSnapshot(snap)
+		}
+
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,179 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"sort"
+
+	"github.com/deso-protocol/go-deadlock"
+)
+
+var (
+	// FeeEstimatorNumRecentBlocks is the number of recent blocks whose fee rates are used
+	// to estimate fees.
+	FeeEstimatorNumRecentBlocks = 10
+	// FeeEstimatorNextBlockPercentile is the percentile of recent fee rates that a txn should
+	// pay to be mined in the next block. Txns that can wait N blocks pay the percentile
+	// divided by N.
+	FeeEstimatorNextBlockPercentile = uint64(50)
+)
+
+// feeEstimatorTx is what the FeeEstimator tracks for each mempool txn.
+type feeEstimatorTx struct {
+	FeePerKB    uint64
+	TxSizeBytes uint64
+}
+
+// feeEstimatorBlock holds the fee rates of the txns in a block.
+type feeEstimatorBlock struct {
+	BlockHash BlockHash
+	FeeRates  []uint64
+}
+
+// FeeEstimator estimates the fee rate that a txn should pay based on the fee rates of the
+// txns in the mempool and in recent blocks. The mempool feeds it every txn it adds or removes
+// and every block it connects or disconnects. We only know the fee of the block txns that
+// were in our mempool, so the txns we didn't see before they were mined are ignored.
+type FeeEstimator struct {
+	mtx deadlock.RWMutex
+
+	// Fee rates are never estimated below minFeeRateNanosPerKB. maxBlockSizeBytes is used to
+	// determine how many blocks the mempool txns would fill.
+	minFeeRateNanosPerKB uint64
+	maxBlockSizeBytes    uint64
+
+	mempoolTxns map[BlockHash]*feeEstimatorTx
+	// recentBlocks holds the most recent blocks, oldest first.
+	recentBlocks []*feeEstimatorBlock
+}
+
+func NewFeeEstimator(minFeeRateNanosPerKB uint64, maxBlockSizeBytes uint64) *FeeEstimator {
+	return &FeeEstimator{
+		minFeeRateNanosPerKB: minFeeRateNanosPerKB,
+		maxBlockSizeBytes:    maxBlockSizeBytes,
+		mempoolTxns:          make(map[BlockHash]*feeEstimatorTx),
+	}
+}
+
+// AddMempoolTx starts tracking a txn that was added to the mempool.
+func (fe *FeeEstimator) AddMempoolTx(mempoolTx *MempoolTx) {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+
+	fe.mempoolTxns[*mempoolTx.Hash] = &feeEstimatorTx{
+		FeePerKB:    mempoolTx.FeePerKB,
+		TxSizeBytes: mempoolTx.TxSizeBytes,
+	}
+}
+
+// RemoveMempoolTx stops tracking a txn that was removed from the mempool.
+func (fe *FeeEstimator) RemoveMempoolTx(txHash *BlockHash) {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+
+	delete(fe.mempoolTxns, *txHash)
+}
+
+// ConnectBlock records the fee rates of the block's txns that are in the mempool, and
+// stops tracking them as mempool txns.
+func (fe *FeeEstimator) ConnectBlock(blockHash *BlockHash, block *MsgDeSoBlock) {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+
+	feeRates := []uint64{}
+	for _, txn := range block.Txns {
+		txHash := txn.Hash()
+		mempoolTx, exists := fe.mempoolTxns[*txHash]
+		if !exists {
+			continue
+		}
+		feeRates = append(feeRates, mempoolTx.FeePerKB)
+		delete(fe.mempoolTxns, *txHash)
+	}
+
+	fe.recentBlocks = append(fe.recentBlocks, &feeEstimatorBlock{
+		BlockHash: *blockHash,
+		FeeRates:  feeRates,
+	})
+	if len(fe.recentBlocks) > FeeEstimatorNumRecentBlocks {
+		fe.recentBlocks = fe.recentBlocks[len(fe.recentBlocks)-FeeEstimatorNumRecentBlocks:]
+	}
+}
+
+// DisconnectBlock forgets the fee rates of a block that was disconnected. The block's txns go
+// back to the mempool, which will add them back with AddMempoolTx.
+func (fe *FeeEstimator) DisconnectBlock(blockHash *BlockHash) {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+
+	for ii, recentBlock := range fe.recentBlocks {
+		if recentBlock.BlockHash == *blockHash {
+			fe.recentBlocks = append(fe.recentBlocks[:ii], fe.recentBlocks[ii+1:]...)
+			return
+		}
+	}
+}
+
+// EstimateFeeRate returns the fee rate in nanos per KB that a txn should pay to be mined
+// within targetBlocks blocks. It's the highest of:
+//   - The min fee rate.
+//   - A percentile of the fee rates of the txns in recent blocks. The more blocks the txn
+//     can wait, the lower the percentile.
+//   - If the mempool holds more than targetBlocks blocks worth of txns, the fee rate needed
+//     to be in the top targetBlocks blocks worth of them. When the mempool is full, txns
+//     with lower fee rates are evicted first.
+func (fe *FeeEstimator) EstimateFeeRate(targetBlocks int) uint64 {
+	fe.mtx.RLock()
+	defer fe.mtx.RUnlock()
+
+	if targetBlocks < 1 {
+		targetBlocks = 1
+	}
+
+	feeRate := fe.minFeeRateNanosPerKB
+	if recentBlocksFeeRate := fe._estimateFeeRateFromRecentBlocks(targetBlocks); recentBlocksFeeRate > feeRate {
+		feeRate = recentBlocksFeeRate
+	}
+	if mempoolFeeRate := fe._estimateFeeRateFromMempool(targetBlocks); mempoolFeeRate > feeRate {
+		feeRate = mempoolFeeRate
+	}
+	return feeRate
+}
+
+func (fe *FeeEstimator) _estimateFeeRateFromRecentBlocks(targetBlocks int) uint64 {
+	feeRates := []uint64{}
+	for _, recentBlock := range fe.recentBlocks {
+		feeRates = append(feeRates, recentBlock.FeeRates...)
+	}
+	if len(feeRates) == 0 {
+		return 0
+	}
+	sort.Slice(feeRates, func(ii, jj int) bool {
+		return feeRates[ii] < feeRates[jj]
+	})
+
+	percentile := FeeEstimatorNextBlockPercentile / uint64(targetBlocks)
+	index := uint64(len(feeRates)-1) * percentile / 100
+	return feeRates[index]
+}
+
+func (fe *FeeEstimator) _estimateFeeRateFromMempool(targetBlocks int) uint64 {
+	mempoolTxns := []*feeEstimatorTx{}
+	for _, mempoolTx := range fe.mempoolTxns {
+		mempoolTxns = append(mempoolTxns, mempoolTx)
+	}
+	sort.Slice(mempoolTxns, func(ii, jj int) bool {
+		return mempoolTxns[ii].FeePerKB > mempoolTxns[jj].FeePerKB
+	})
+
+	// Find the first txn that wouldn't fit in targetBlocks blocks, and outbid it.
+	capacityBytes := uint64(targetBlocks) * fe.maxBlockSizeBytes
+	totalSizeBytes := uint64(0)
+	for _, mempoolTx := range mempoolTxns {
+		totalSizeBytes += mempoolTx.TxSizeBytes
+		if totalSizeBytes > capacityBytes {
+			return mempoolTx.FeePerKB + 1
+		}
+	}
+	return 0
+}
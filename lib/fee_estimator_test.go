@@ -0,0 +1,91 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeeEstimator(t *testing.T) {
+	require := require.New(t)
+
+	feeEstimator := NewFeeEstimator(100 /*minFeeRateNanosPerKB*/, 1000 /*maxBlockSizeBytes*/)
+	newMempoolTx := func(ii uint32, feePerKB uint64, txSizeBytes uint64) *MempoolTx {
+		txn := &MsgDeSoTxn{
+			TxInputs: []*DeSoInput{{Index: ii}},
+			TxnMeta:  &BasicTransferMetadata{},
+		}
+		return &MempoolTx{Tx: txn, Hash: txn.Hash(), FeePerKB: feePerKB, TxSizeBytes: txSizeBytes}
+	}
+
+	// With nothing to go on, we estimate the min fee rate.
+	require.Equal(uint64(100), feeEstimator.EstimateFeeRate(1))
+
+	// Mine a block with ten txns paying 200 to 1100 nanos per KB.
+	block := &MsgDeSoBlock{Txns: []*MsgDeSoTxn{{TxnMeta: &BlockRewardMetadataa{}}}}
+	for ii := uint32(0); ii < 10; ii++ {
+		mempoolTx := newMempoolTx(ii, 200+uint64(ii)*100, 10)
+		feeEstimator.AddMempoolTx(mempoolTx)
+		block.Txns = append(block.Txns, mempoolTx.Tx)
+	}
+	blockHash := &BlockHash{1}
+	feeEstimator.ConnectBlock(blockHash, block)
+	require.Len(feeEstimator.mempoolTxns, 0)
+
+	// The more blocks we can wait, the lower the percentile of the recent fee rates.
+	require.Equal(uint64(600), feeEstimator.EstimateFeeRate(1))
+	require.Equal(uint64(600), feeEstimator.EstimateFeeRate(0))
+	require.Equal(uint64(400), feeEstimator.EstimateFeeRate(2))
+	require.Equal(uint64(200), feeEstimator.EstimateFeeRate(100))
+
+	// A congested mempool pushes the estimate up: the txns paying more than 600 fill one
+	// block, so we have to outbid the one that doesn't fit.
+	for ii := uint32(10); ii < 13; ii++ {
+		feeEstimator.AddMempoolTx(newMempoolTx(ii, 1000+uint64(ii), 400))
+	}
+	require.Equal(uint64(1011), feeEstimator.EstimateFeeRate(1))
+	require.Equal(uint64(400), feeEstimator.EstimateFeeRate(2))
+	feeEstimator.RemoveMempoolTx(newMempoolTx(10, 0, 0).Hash)
+	require.Equal(uint64(600), feeEstimator.EstimateFeeRate(1))
+
+	// Disconnecting the block forgets its fee rates.
+	feeEstimator.DisconnectBlock(blockHash)
+	require.Equal(uint64(100), feeEstimator.EstimateFeeRate(1))
+
+	// Only the most recent blocks count.
+	for ii := 0; ii < FeeEstimatorNumRecentBlocks+5; ii++ {
+		feeEstimator.ConnectBlock(&BlockHash{byte(ii)}, &MsgDeSoBlock{})
+	}
+	require.Len(feeEstimator.recentBlocks, FeeEstimatorNumRecentBlocks)
+}
+
+func TestMempoolFeeEstimator(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, _ := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true /*isSender*/)
+
+	// Mine a few blocks to have something to spend.
+	for ii := 0; ii < 2; ii++ {
+		_, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
+		require.NoError(err)
+	}
+
+	// The mempool tells the fee estimator about the txns it accepts.
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 100, 5000,
+		senderPkString, recipientPkString, senderPrivString, mempool)
+	mempoolTxs, err := mempool.processTransaction(txn, false /*allowUnconnectedTxn*/, false, /*rateLimit*/
+		0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Len(mempoolTxs, 1)
+	require.Contains(mempool.feeEstimator.mempoolTxns, *txn.Hash())
+
+	// Once the txn is mined, its fee rate counts towards the recent blocks.
+	block, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
+	require.NoError(err)
+	require.Len(block.Txns, 2)
+	require.NotContains(mempool.feeEstimator.mempoolTxns, *txn.Hash())
+	require.Equal(mempoolTxs[0].FeePerKB, mempool.EstimateFeeRate(1))
+}
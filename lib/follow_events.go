@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"fmt"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// The follow indexes only hold who follows whom right now, so the IndexQueue also keeps every follow
+// and unfollow txn as a FollowEvent, by the PKID being followed and the height of its block. Clients
+// can then count new followers over a window of blocks with DbGetFollowEventCounts instead of
+// scanning the txindex. Events are keyed by the PKIDs the public keys had when the block was indexed.
+// If a follower follows and unfollows the same PKID in one block, only the last txn is kept.
+
+// FollowEvent is a follow or unfollow txn in the main chain block at BlockHeight.
+type FollowEvent struct {
+	FollowedPKID *PKID
+	FollowerPKID *PKID
+	BlockHeight  uint64
+	IsUnfollow   bool
+}
+
+// FollowEventCounts are the number of follow and unfollow txns in a window of blocks.
+type FollowEventCounts struct {
+	NumFollows   uint64
+	NumUnfollows uint64
+}
+
+// RegisterFollowEventHandlers makes the IndexQueue maintain the follow event index. Both handlers are
+// idempotent, since the events of a block always have the same keys.
+func RegisterFollowEventHandlers(indexQueue *IndexQueue) {
+	db := indexQueue.db
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockConnected, func(task *IndexQueueTask) error {
+		return db.Update(func(txn *badger.Txn) error {
+			return _applyFollowEventsForBlockWithTxn(txn, task.BlockHash, task.BlockHeight)
+		})
+	})
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockDisconnected, func(task *IndexQueueTask) error {
+		return db.Update(func(txn *badger.Txn) error {
+			return _revertFollowEventsForBlockWithTxn(txn, task.BlockHash, task.BlockHeight)
+		})
+	})
+}
+
+func _applyFollowEventsForBlockWithTxn(txn *badger.Txn, blockHash *BlockHash, blockHeight uint64) error {
+	events, err := _getFollowEventsForBlockWithTxn(txn, blockHash, blockHeight)
+	if err != nil {
+		return errors.Wrapf(err, "_applyFollowEventsForBlockWithTxn: ")
+	}
+	for _, event := range events {
+		if err = DbPutFollowEventWithTxn(txn, event); err != nil {
+			return errors.Wrapf(err, "_applyFollowEventsForBlockWithTxn: ")
+		}
+	}
+	return nil
+}
+
+func _revertFollowEventsForBlockWithTxn(txn *badger.Txn, blockHash *BlockHash, blockHeight uint64) error {
+	events, err := _getFollowEventsForBlockWithTxn(txn, blockHash, blockHeight)
+	if err != nil {
+		return errors.Wrapf(err, "_revertFollowEventsForBlockWithTxn: ")
+	}
+	for _, event := range events {
+		if err = DbDeleteFollowEventWithTxn(txn, event); err != nil {
+			return errors.Wrapf(err, "_revertFollowEventsForBlockWithTxn: ")
+		}
+	}
+	return nil
+}
+
+func _getFollowEventsForBlockWithTxn(txn *badger.Txn, blockHash *BlockHash, blockHeight uint64) (
+	[]*FollowEvent, error) {
+
+	block := GetBlockWithTxn(txn, nil, blockHash)
+	if block == nil {
+		return nil, fmt.Errorf("_getFollowEventsForBlockWithTxn: Block %v not found", blockHash)
+	}
+	events := []*FollowEvent{}
+	for _, blockTxn := range block.Txns {
+		if blockTxn.TxnMeta.GetTxnType() != TxnTypeFollow {
+			continue
+		}
+		txMeta := blockTxn.TxnMeta.(*FollowMetadata)
+		followerPKIDEntry := DBGetPKIDEntryForPublicKeyWithTxn(txn, nil, blockTxn.PublicKey)
+		followedPKIDEntry := DBGetPKIDEntryForPublicKeyWithTxn(txn, nil, txMeta.FollowedPublicKey)
+		if followerPKIDEntry == nil || followedPKIDEntry == nil {
+			continue
+		}
+		events = append(events, &FollowEvent{
+			FollowedPKID: followedPKIDEntry.PKID,
+			FollowerPKID: followerPKIDEntry.PKID,
+			BlockHeight:  blockHeight,
+			IsUnfollow:   txMeta.IsUnfollow,
+		})
+	}
+	return events, nil
+}
+
+// DbGetFollowEventCounts counts the follow and unfollow txns of the PKID at heights in
+// [startHeight, endHeight], e.g. the last week of blocks for "new followers this week".
+func DbGetFollowEventCounts(handle *badger.DB, followedPKID *PKID, startHeight uint64, endHeight uint64) (
+	*FollowEventCounts, error) {
+
+	events, err := DbGetFollowEvents(handle, followedPKID, startHeight, endHeight)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetFollowEventCounts: ")
+	}
+	counts := &FollowEventCounts{}
+	for _, event := range events {
+		if event.IsUnfollow {
+			counts.NumUnfollows++
+		} else {
+			counts.NumFollows++
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,90 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollowEvents(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+
+	m0PkBytes, _, err := Base58CheckDecode(m0Pub)
+	require.NoError(err)
+	m1PkBytes, _, err := Base58CheckDecode(m1Pub)
+	require.NoError(err)
+	m2PkBytes, _, err := Base58CheckDecode(m2Pub)
+	require.NoError(err)
+	m0PKID, m1PKID, m2PKID := PublicKeyToPKID(m0PkBytes), PublicKeyToPKID(m1PkBytes), PublicKeyToPKID(m2PkBytes)
+
+	// Store blocks with follow txns at heights 5 and 8.
+	putBlock := func(height uint64, follows []*MsgDeSoTxn) *BlockHash {
+		block := &MsgDeSoBlock{
+			Header: &MsgDeSoHeader{
+				PrevBlockHash:         &BlockHash{},
+				TransactionMerkleRoot: &BlockHash{},
+				Height:                height,
+			},
+			Txns: append([]*MsgDeSoTxn{{TxnMeta: &BlockRewardMetadataa{}}}, follows...),
+		}
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			return PutBlockWithTxn(txn, nil, block)
+		}))
+		blockHash, err := block.Hash()
+		require.NoError(err)
+		return blockHash
+	}
+	follow := func(followerPkBytes []byte, isUnfollow bool) *MsgDeSoTxn {
+		return &MsgDeSoTxn{
+			PublicKey: followerPkBytes,
+			TxnMeta:   &FollowMetadata{FollowedPublicKey: m1PkBytes, IsUnfollow: isUnfollow},
+		}
+	}
+	block1Hash := putBlock(5, []*MsgDeSoTxn{follow(m0PkBytes, false), follow(m2PkBytes, false)})
+	block2Hash := putBlock(8, []*MsgDeSoTxn{follow(m0PkBytes, true)})
+
+	applyBlocks := func() {
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			if err := _applyFollowEventsForBlockWithTxn(txn, block1Hash, 5); err != nil {
+				return err
+			}
+			return _applyFollowEventsForBlockWithTxn(txn, block2Hash, 8)
+		}))
+	}
+	requireCounts := func(startHeight uint64, endHeight uint64, numFollows uint64, numUnfollows uint64) {
+		counts, err := DbGetFollowEventCounts(db, m1PKID, startHeight, endHeight)
+		require.NoError(err)
+		require.Equal(&FollowEventCounts{NumFollows: numFollows, NumUnfollows: numUnfollows}, counts)
+	}
+
+	// Applying the blocks twice doesn't count their events twice.
+	applyBlocks()
+	applyBlocks()
+	events, err := DbGetFollowEvents(db, m1PKID, 0, 10)
+	require.NoError(err)
+	require.Len(events, 3)
+	require.Equal(&FollowEvent{FollowedPKID: m1PKID, FollowerPKID: m0PKID, BlockHeight: 8, IsUnfollow: true}, events[2])
+	requireCounts(0, 10, 2, 1)
+
+	// The height range is inclusive.
+	requireCounts(5, 5, 2, 0)
+	requireCounts(6, 10, 0, 1)
+	requireCounts(9, 10, 0, 0)
+
+	// Reverting a block removes its events.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return _revertFollowEventsForBlockWithTxn(txn, block2Hash, 8)
+	}))
+	requireCounts(0, 10, 2, 0)
+	events, err = DbGetFollowEvents(db, m1PKID, 0, 10)
+	require.NoError(err)
+	require.Len(events, 2)
+	require.Contains(events, &FollowEvent{FollowedPKID: m1PKID, FollowerPKID: m2PKID, BlockHeight: 5})
+}
@@ -0,0 +1,212 @@
+package lib
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+
+	"github.com/deso-protocol/core/desohash"
+	merkletree "github.com/deso-protocol/go-merkle-tree"
+	"github.com/golang/glog"
+)
+
+func CopyBytesIntoBlockHash(data []byte) *BlockHash {
+	if len(data) != HashSizeBytes {
+		errorStr := fmt.Sprintf("CopyBytesIntoBlockHash: Got data of size %d for BlockHash of size %d", len(data), HashSizeBytes)
+		glog.Error(errorStr)
+		return nil
+	}
+	var blockHash BlockHash
+	copy(blockHash[:], data)
+	return &blockHash
+}
+
+// ProofOfWorkHash is a hash function designed for computing DeSo block hashes.
+// It seems the optimal hash function is one that satisfies two properties:
+// 1) It is not computable by any existing ASICs. If this property isn't satisfied
+//    then miners with pre-existing investments in ASICs for other coins can very
+//    cheaply mine on our chain for a short period of time to pull off a 51% attack.
+//    This has actually happened with "merge-mined" coins like Namecoin.
+// 2) If implemented on an ASIC, there is an "orders of magnitude" speed-up over
+//    using a CPU or GPU. This is because ASICs require some amount of capital
+//    expenditure up-front in order to mine, which then aligns the owner of the
+//    ASIC to care about the health of the network over a longer period of time. In
+//    contrast, a hash function that is CPU or GPU-mineable can be attacked with
+//    an AWS fleet early on. This also may result in a more eco-friendly chain, since
+//    the hash power will be more bottlenecked by up-front CapEx rather than ongoing
+//    electricity cost, as is the case with GPU-mined coins.
+//
+// Note that our pursuit of (2) above runs counter to existing dogma which seeks to
+// prioritize "ASIC-resistance" in hash functions.
+//
+// Given the above, the hash function chosen is a simple twist on sha3
+// that we don't think any ASIC exists for currently. Note that creating an ASIC for
+// this should be relatively straightforward, however, which allows us to satisfy
+// property (2) above.
+func ProofOfWorkHash(inputBytes []byte, version uint32) *BlockHash {
+	output := BlockHash{}
+
+	if version == HeaderVersion0 {
+		hashBytes := desohash.DeSoHashV0(inputBytes)
+		copy(output[:], hashBytes[:])
+	} else if version == HeaderVersion1 {
+		hashBytes := desohash.DeSoHashV1(inputBytes)
+		copy(output[:], hashBytes[:])
+	} else {
+		// If we don't recognize the version, we return the v0 hash. We do
+		// this to avoid having to return an error or panic.
+		hashBytes := desohash.DeSoHashV0(inputBytes)
+		copy(output[:], hashBytes[:])
+	}
+
+	return &output
+}
+
+func Sha256DoubleHash(input []byte) *BlockHash {
+	hashBytes := merkletree.Sha256DoubleHash(input)
+	ret := &BlockHash{}
+	copy(ret[:], hashBytes[:])
+	return ret
+}
+
+func HashToBigint(hash *BlockHash) *big.Int {
+	// No need to check errors since the string is necessarily a valid hex
+	// string.
+	val, itWorked := new(big.Int).SetString(hex.EncodeToString(hash[:]), 16)
+	if !itWorked {
+		glog.Errorf("Failed in converting []byte (%#v) to bigint.", hash)
+	}
+	return val
+}
+
+func BigintToHash(bigint *big.Int) *BlockHash {
+	hexStr := bigint.Text(16)
+	if len(hexStr)%2 != 0 {
+		// If we have an odd number of bytes add one to the beginning (remember
+		// the bigints are big-endian.
+		hexStr = "0" + hexStr
+	}
+	hexBytes, err := hex.DecodeString(hexStr)
+	if err != nil {
+		glog.Errorf("Failed in converting bigint (%#v) with hex "+
+			"string (%s) to hash.", bigint, hexStr)
+	}
+	if len(hexBytes) > HashSizeBytes {
+		glog.Errorf("BigintToHash: Bigint %v overflows the hash size %d", bigint, HashSizeBytes)
+		return nil
+	}
+
+	var retBytes BlockHash
+	copy(retBytes[HashSizeBytes-len(hexBytes):], hexBytes)
+	return &retBytes
+}
+
+func BytesToBigint(bb []byte) *big.Int {
+	val, itWorked := new(big.Int).SetString(hex.EncodeToString(bb), 16)
+	if !itWorked {
+		glog.Errorf("Failed in converting []byte (%#v) to bigint.", bb)
+	}
+	return val
+}
+
+func BigintToBytes(bigint *big.Int) []byte {
+	hexStr := bigint.Text(16)
+	if len(hexStr)%2 != 0 {
+		// If we have an odd number of bytes add one to the beginning (remember
+		// the bigints are big-endian.
+		hexStr = "0" + hexStr
+	}
+	hexBytes, err := hex.DecodeString(hexStr)
+	if err != nil {
+		glog.Errorf("Failed in converting bigint (%#v) with hex "+
+			"string (%s) to []byte.", bigint, hexStr)
+	}
+	return hexBytes
+}
+
+// FindLowestHash
+// Mine for a given number of iterations and return the lowest hash value
+// found and its associated nonce. Hashing starts at the value of the Nonce
+// set on the blockHeader field when it is passed and increments the value
+// of the passed blockHeader field as it iterates. This makes it easy to
+// continue a subsequent batch of iterations after we return.
+func FindLowestHash(
+	blockHeaderr *MsgDeSoHeader, iterations uint64) (
+	lowestHash *BlockHash, lowestNonce uint64, ee error) {
+	// Compute a hash of the header with the current nonce value.
+	bestNonce := blockHeaderr.Nonce
+	bestHash, err := blockHeaderr.Hash()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for iterations > 0 {
+		// Increment the nonce.
+		blockHeaderr.Nonce++
+
+		// Compute a new hash.
+		currentHash, err := blockHeaderr.Hash()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// See if it's better than what we currently have
+		if LessThan(currentHash, bestHash) {
+			bestHash = currentHash
+			bestNonce = blockHeaderr.Nonce
+		}
+
+		iterations--
+	}
+
+	// Increment the nonce one last time since we checked this hash.
+	blockHeaderr.Nonce++
+
+	return bestHash, bestNonce, nil
+}
+
+func LessThan(aa *BlockHash, bb *BlockHash) bool {
+	aaBigint := new(big.Int)
+	aaBigint.SetBytes(aa[:])
+	bbBigint := new(big.Int)
+	bbBigint.SetBytes(bb[:])
+
+	return aaBigint.Cmp(bbBigint) < 0
+}
+
+func ComputeTransactionHashes(txns []*MsgDeSoTxn) ([]*BlockHash, error) {
+	txHashes := make([]*BlockHash, len(txns))
+
+	for ii, currentTxn := range txns {
+		txHashes[ii] = currentTxn.Hash()
+	}
+
+	return txHashes, nil
+}
+
+func ComputeMerkleRoot(txns []*MsgDeSoTxn) (_merkle *BlockHash, _txHashes []*BlockHash, _err error) {
+	if len(txns) == 0 {
+		return nil, nil, fmt.Errorf("ComputeMerkleRoot: Block must contain at least one txn")
+	}
+
+	// Compute the hashes of all the transactions.
+	hashes := [][]byte{}
+	for _, txn := range txns {
+		txHash := txn.Hash()
+		hashes = append(hashes, txHash[:])
+	}
+
+	merkleTree := merkletree.NewTreeFromHashes(merkletree.Sha256DoubleHash, hashes)
+
+	rootHash := &BlockHash{}
+	copy(rootHash[:], merkleTree.Root.GetHash()[:])
+
+	txHashes := []*BlockHash{}
+	for _, leafNode := range merkleTree.Rows[0] {
+		currentHash := &BlockHash{}
+		copy(currentHash[:], leafNode.GetHash())
+		txHashes = append(txHashes, currentHash)
+	}
+
+	return rootHash, txHashes, nil
+}
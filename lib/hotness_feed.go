@@ -0,0 +1,375 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// The HotnessFeed ranks posts by their interactions, weighted by kind and decayed over time, so that
+// clients can page through the hottest posts with DbGetHotPostHashes. A post with weighted
+// interactions W that was posted at time T has a hotness of (1 + W) * 2^((T - now) / HalfLife). Since
+// the decay factor is the same for every post at any given time, posts are ranked by the log of their
+// hotness without the "now" term instead, log2(1 + W) + T / HalfLife, which never has to be updated
+// as time passes. The IndexQueue maintains the scores: whenever a block is connected or disconnected,
+// the posts its txns posted, commented on, reposted, liked or gave diamonds to are rescored from the
+// db. Only top-level posts that aren't hidden or vanilla reposts are ranked.
+//
+// T is the post's timestamp, capped at the time of the block the post was first scored in, so that a
+// post can't rank higher by claiming to be posted in the future. Posts that are written to the db
+// without going through the IndexQueue, e.g. by a hypersync, are only scored by Rebuild.
+
+// HotnessFeedParams are the weights of each kind of interaction and the time it takes for a post's
+// hotness to halve.
+type HotnessFeedParams struct {
+	LikeWeight        float64
+	DiamondWeight     float64
+	RepostWeight      float64
+	QuoteRepostWeight float64
+	CommentWeight     float64
+	HalfLife          time.Duration
+}
+
+// DefaultHotnessFeedParams returns the params the node uses unless it's configured otherwise.
+func DefaultHotnessFeedParams() *HotnessFeedParams {
+	return &HotnessFeedParams{
+		LikeWeight:        1,
+		DiamondWeight:     5,
+		RepostWeight:      2,
+		QuoteRepostWeight: 3,
+		CommentWeight:     2,
+		HalfLife:          DefaultHotnessFeedHalfLife,
+	}
+}
+
+// ParseHotnessFeedParams returns the default params with the weights overridden by the specs, which
+// are of the form <interaction>=<weight> with the interaction one of like, diamond, repost,
+// quote-repost or comment. A zero halfLife keeps the default half-life.
+func ParseHotnessFeedParams(weightSpecs []string, halfLife time.Duration) (*HotnessFeedParams, error) {
+	params := DefaultHotnessFeedParams()
+	for _, spec := range weightSpecs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("ParseHotnessFeedParams: Spec %v isn't of the form <interaction>=<weight>", spec)
+		}
+		weight, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || weight < 0 || math.IsInf(weight, 0) || math.IsNaN(weight) {
+			return nil, fmt.Errorf("ParseHotnessFeedParams: Invalid weight in spec %v", spec)
+		}
+		switch parts[0] {
+		case "like":
+			params.LikeWeight = weight
+		case "diamond":
+			params.DiamondWeight = weight
+		case "repost":
+			params.RepostWeight = weight
+		case "quote-repost":
+			params.QuoteRepostWeight = weight
+		case "comment":
+			params.CommentWeight = weight
+		default:
+			return nil, fmt.Errorf("ParseHotnessFeedParams: %v is not an interaction", parts[0])
+		}
+	}
+	if halfLife < 0 {
+		return nil, fmt.Errorf("ParseHotnessFeedParams: Half-life %v is negative", halfLife)
+	}
+	if halfLife > 0 {
+		params.HalfLife = halfLife
+	}
+	return params, nil
+}
+
+func (params *HotnessFeedParams) ToBytes() []byte {
+	var data []byte
+	for _, weight := range []float64{params.LikeWeight, params.DiamondWeight, params.RepostWeight,
+		params.QuoteRepostWeight, params.CommentWeight} {
+		data = append(data, EncodeUint64(math.Float64bits(weight))...)
+	}
+	return append(data, UintToBuf(uint64(params.HalfLife))...)
+}
+
+func (params *HotnessFeedParams) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+	for _, weight := range []*float64{&params.LikeWeight, &params.DiamondWeight, &params.RepostWeight,
+		&params.QuoteRepostWeight, &params.CommentWeight} {
+		weightBytes := make([]byte, 8)
+		if _, err := io.ReadFull(rr, weightBytes); err != nil {
+			return errors.Wrapf(err, "HotnessFeedParams.FromBytes: Problem reading weight")
+		}
+		*weight = math.Float64frombits(DecodeUint64(weightBytes))
+	}
+	halfLife, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "HotnessFeedParams.FromBytes: Problem reading HalfLife")
+	}
+	params.HalfLife = time.Duration(halfLife)
+	return nil
+}
+
+// HotnessFeedEntry is the score of a post in the hotness feed, and the timestamp it was computed with.
+type HotnessFeedEntry struct {
+	PostHash             *BlockHash
+	Score                float64
+	AnchorTimestampNanos uint64
+}
+
+func (entry *HotnessFeedEntry) ToBytes() []byte {
+	data := append([]byte{}, entry.PostHash[:]...)
+	data = append(data, EncodeUint64(math.Float64bits(entry.Score))...)
+	return append(data, UintToBuf(entry.AnchorTimestampNanos)...)
+}
+
+func (entry *HotnessFeedEntry) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+	var err error
+
+	entry.PostHash = &BlockHash{}
+	if _, err = io.ReadFull(rr, entry.PostHash[:]); err != nil {
+		return errors.Wrapf(err, "HotnessFeedEntry.FromBytes: Problem reading PostHash")
+	}
+	scoreBytes := make([]byte, 8)
+	if _, err = io.ReadFull(rr, scoreBytes); err != nil {
+		return errors.Wrapf(err, "HotnessFeedEntry.FromBytes: Problem reading Score")
+	}
+	entry.Score = math.Float64frombits(DecodeUint64(scoreBytes))
+	if entry.AnchorTimestampNanos, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "HotnessFeedEntry.FromBytes: Problem reading AnchorTimestampNanos")
+	}
+	return nil
+}
+
+// ComputeHotnessScore returns the score of the post, taking the post to be posted at
+// anchorTimestampNanos. Scores are never negative.
+func ComputeHotnessScore(params *HotnessFeedParams, postEntry *PostEntry, anchorTimestampNanos uint64) float64 {
+	weightedInteractions := params.LikeWeight*float64(postEntry.LikeCount) +
+		params.DiamondWeight*float64(postEntry.DiamondCount) +
+		params.RepostWeight*float64(postEntry.RepostCount) +
+		params.QuoteRepostWeight*float64(postEntry.QuoteRepostCount) +
+		params.CommentWeight*float64(postEntry.CommentCount)
+	return math.Log2(1+weightedInteractions) + float64(anchorTimestampNanos)/float64(params.HalfLife.Nanoseconds())
+}
+
+// IsPostRankedInHotnessFeed returns whether the post belongs in the hotness feed.
+func IsPostRankedInHotnessFeed(postEntry *PostEntry) bool {
+	return postEntry != nil && !postEntry.isDeleted && !postEntry.IsHidden && len(postEntry.ParentStakeID) == 0 &&
+		!IsVanillaRepost(postEntry)
+}
+
+// HotnessFeed maintains the scores of the hotness feed with the given params.
+type HotnessFeed struct {
+	db     *badger.DB
+	params *HotnessFeedParams
+}
+
+func NewHotnessFeed(db *badger.DB, params *HotnessFeedParams) *HotnessFeed {
+	return &HotnessFeed{
+		db:     db,
+		params: params,
+	}
+}
+
+// RegisterHandlers makes the IndexQueue maintain the hotness feed. Connects and disconnects are
+// handled the same way, since rescoring a post from the db is idempotent.
+func (hf *HotnessFeed) RegisterHandlers(indexQueue *IndexQueue) {
+	handler := func(task *IndexQueueTask) error {
+		return hf.db.Update(func(txn *badger.Txn) error {
+			block := GetBlockWithTxn(txn, nil, task.BlockHash)
+			if block == nil {
+				return fmt.Errorf("HotnessFeed: Block %v not found", task.BlockHash)
+			}
+			blockTimestampNanos := block.Header.TstampSecs * uint64(time.Second)
+			for _, postHash := range _getPostHashesInteractedWithByBlockWithTxn(txn, block) {
+				if err := hf.RescorePostWithTxn(txn, postHash, blockTimestampNanos); err != nil {
+					return errors.Wrapf(err, "HotnessFeed: ")
+				}
+			}
+			return nil
+		})
+	}
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockConnected, handler)
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockDisconnected, handler)
+}
+
+// _getPostHashesInteractedWithByBlockWithTxn returns the hashes of the posts whose interactions the
+// txns in the block may have changed, without duplicates. These are the posts that SubmitPost txns
+// created or modified, along with the posts they comment on or repost, the posts that Like txns
+// like or unlike, and the posts that transfers give diamonds to.
+func _getPostHashesInteractedWithByBlockWithTxn(txn *badger.Txn, block *MsgDeSoBlock) []*BlockHash {
+	var postHashes []*BlockHash
+	seenPostHashes := make(map[BlockHash]bool)
+	addPostHash := func(postHashBytes []byte) {
+		if len(postHashBytes) != HashSizeBytes {
+			return
+		}
+		postHash := NewBlockHash(postHashBytes)
+		if seenPostHashes[*postHash] {
+			return
+		}
+		seenPostHashes[*postHash] = true
+		postHashes = append(postHashes, postHash)
+	}
+
+	for _, blockTxn := range block.Txns {
+		switch blockTxn.TxnMeta.GetTxnType() {
+		case TxnTypeSubmitPost:
+			txnMeta := blockTxn.TxnMeta.(*SubmitPostMetadata)
+			postHash := blockTxn.Hash()
+			if len(txnMeta.PostHashToModify) == HashSizeBytes {
+				postHash = NewBlockHash(txnMeta.PostHashToModify)
+			}
+			addPostHash(postHash[:])
+			addPostHash(txnMeta.ParentStakeID)
+			addPostHash(blockTxn.ExtraData[RepostedPostHash])
+			// Edits don't repeat the parent or reposted post, so they're taken from the post too.
+			if postEntry := DBGetPostEntryByPostHashWithTxn(txn, nil, postHash); postEntry != nil {
+				addPostHash(postEntry.ParentStakeID)
+				if postEntry.RepostedPostHash != nil {
+					addPostHash(postEntry.RepostedPostHash[:])
+				}
+			}
+		case TxnTypeLike:
+			addPostHash(blockTxn.TxnMeta.(*LikeMetadata).LikedPostHash[:])
+		case TxnTypeBasicTransfer, TxnTypeCreatorCoinTransfer:
+			addPostHash(blockTxn.ExtraData[DiamondPostHashKey])
+		}
+	}
+	return postHashes
+}
+
+// RescorePostWithTxn replaces the score of the post with the one computed from the post currently in
+// the db. A post that's scored for the first time is anchored at its timestamp, capped at
+// maxAnchorTimestampNanos. If the post no longer exists or is no longer ranked, its score is removed.
+func (hf *HotnessFeed) RescorePostWithTxn(txn *badger.Txn, postHash *BlockHash, maxAnchorTimestampNanos uint64) error {
+	oldEntry, err := DbGetHotnessFeedEntryWithTxn(txn, postHash)
+	if err != nil {
+		return errors.Wrapf(err, "RescorePostWithTxn: ")
+	}
+	if oldEntry != nil {
+		if err = DbDeleteHotnessFeedEntryWithTxn(txn, oldEntry); err != nil {
+			return errors.Wrapf(err, "RescorePostWithTxn: ")
+		}
+	}
+
+	postEntry := DBGetPostEntryByPostHashWithTxn(txn, nil, postHash)
+	if !IsPostRankedInHotnessFeed(postEntry) {
+		return nil
+	}
+	anchorTimestampNanos := postEntry.TimestampNanos
+	if anchorTimestampNanos > maxAnchorTimestampNanos {
+		anchorTimestampNanos = maxAnchorTimestampNanos
+	}
+	if oldEntry != nil {
+		anchorTimestampNanos = oldEntry.AnchorTimestampNanos
+	}
+	newEntry := &HotnessFeedEntry{
+		PostHash:             postHash,
+		Score:                ComputeHotnessScore(hf.params, postEntry, anchorTimestampNanos),
+		AnchorTimestampNanos: anchorTimestampNanos,
+	}
+	if err = DbPutHotnessFeedEntryWithTxn(txn, newEntry); err != nil {
+		return errors.Wrapf(err, "RescorePostWithTxn: ")
+	}
+	return nil
+}
+
+// RebuildIfStale rebuilds the hotness feed unless its scores were computed with the same params and
+// kept up to date since.
+func (hf *HotnessFeed) RebuildIfStale() error {
+	storedParams, err := DbGetHotnessFeedParams(hf.db)
+	if err != nil {
+		return errors.Wrapf(err, "RebuildIfStale: ")
+	}
+	if storedParams != nil && bytes.Equal(storedParams.ToBytes(), hf.params.ToBytes()) {
+		return nil
+	}
+	glog.Infof("HotnessFeed.RebuildIfStale: Rescoring all posts. This may take a while...")
+	return hf.Rebuild()
+}
+
+// Rebuild drops the hotness feed and scores every post in the db again. Posts are anchored at their
+// timestamp, capped at the current time. It must not run concurrently with the IndexQueue.
+func (hf *HotnessFeed) Rebuild() error {
+	if err := DbPutHotnessFeedParams(hf.db, nil); err != nil {
+		return errors.Wrapf(err, "HotnessFeed.Rebuild: Problem marking the scores as stale")
+	}
+	for _, prefix := range [][]byte{Prefixes.PrefixHotnessScorePostHash, Prefixes.PrefixHotnessFeedEntryByPostHash} {
+		if err := _deleteKeysUnderPrefixInBatches(hf.db, prefix); err != nil {
+			return errors.Wrapf(err, "HotnessFeed.Rebuild: Problem deleting existing scores")
+		}
+	}
+
+	// Score the posts in batches so that we don't exceed badger's txn size limits.
+	nowNanos := uint64(time.Now().UnixNano())
+	var entries []*HotnessFeedEntry
+	numPosts := 0
+	writeEntries := func() error {
+		err := hf.db.Update(func(txn *badger.Txn) error {
+			for _, entry := range entries {
+				if err := DbPutHotnessFeedEntryWithTxn(txn, entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		numPosts += len(entries)
+		entries = nil
+		return err
+	}
+	err := hf.db.View(func(txn *badger.Txn) error {
+		iterator := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iterator.Close()
+
+		prefix := Prefixes.PrefixPostHashToPostEntry
+		for iterator.Seek(prefix); iterator.ValidForPrefix(prefix); iterator.Next() {
+			value, err := iterator.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			postEntry := &PostEntry{}
+			if exists, err := DecodeFromBytes(postEntry, bytes.NewReader(value)); !exists || err != nil {
+				RecordCorruptedEntry(hf.db, iterator.Item().KeyCopy(nil), fmt.Errorf("HotnessFeed.Rebuild: "+
+					"Problem decoding post: exists %v, error %v", exists, err))
+				continue
+			}
+			if !IsPostRankedInHotnessFeed(postEntry) {
+				continue
+			}
+			anchorTimestampNanos := postEntry.TimestampNanos
+			if anchorTimestampNanos > nowNanos {
+				anchorTimestampNanos = nowNanos
+			}
+			entries = append(entries, &HotnessFeedEntry{
+				PostHash:             postEntry.PostHash,
+				Score:                ComputeHotnessScore(hf.params, postEntry, anchorTimestampNanos),
+				AnchorTimestampNanos: anchorTimestampNanos,
+			})
+			if len(entries) >= HotnessFeedRebuildBatchSize {
+				if err := writeEntries(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = writeEntries()
+	}
+	if err != nil {
+		return errors.Wrapf(err, "HotnessFeed.Rebuild: Problem scoring posts")
+	}
+
+	if err = DbPutHotnessFeedParams(hf.db, hf.params); err != nil {
+		return errors.Wrapf(err, "HotnessFeed.Rebuild: Problem recording the params")
+	}
+	glog.Infof("HotnessFeed.Rebuild: Scored %v posts", numPosts)
+	return nil
+}
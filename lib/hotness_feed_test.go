@@ -0,0 +1,156 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHotnessFeedParams(t *testing.T) {
+	require := require.New(t)
+
+	params, err := ParseHotnessFeedParams([]string{"like=0.5", "quote-repost=4"}, time.Hour)
+	require.NoError(err)
+	expectedParams := DefaultHotnessFeedParams()
+	expectedParams.LikeWeight = 0.5
+	expectedParams.QuoteRepostWeight = 4
+	expectedParams.HalfLife = time.Hour
+	require.Equal(expectedParams, params)
+
+	decodedParams := &HotnessFeedParams{}
+	require.NoError(decodedParams.FromBytes(params.ToBytes()))
+	require.Equal(params, decodedParams)
+
+	params, err = ParseHotnessFeedParams(nil, 0)
+	require.NoError(err)
+	require.Equal(DefaultHotnessFeedParams(), params)
+
+	for _, spec := range []string{"like", "like=-1", "like=x", "follow=1"} {
+		_, err = ParseHotnessFeedParams([]string{spec}, 0)
+		require.Error(err, spec)
+	}
+}
+
+func TestComputeHotnessScore(t *testing.T) {
+	require := require.New(t)
+
+	params := DefaultHotnessFeedParams()
+	halfLifeNanos := uint64(params.HalfLife.Nanoseconds())
+
+	// A post with twice the hotness of another that was posted one half-life earlier scores the same.
+	oldPost := &PostEntry{LikeCount: 3}
+	newPost := &PostEntry{LikeCount: 1}
+	require.InDelta(ComputeHotnessScore(params, oldPost, 10*halfLifeNanos),
+		ComputeHotnessScore(params, newPost, 11*halfLifeNanos), 1e-9)
+
+	// Interactions are weighted by kind.
+	require.InDelta(ComputeHotnessScore(params, &PostEntry{LikeCount: 5}, 0),
+		ComputeHotnessScore(params, &PostEntry{DiamondCount: 1}, 0), 1e-9)
+
+	entry := &HotnessFeedEntry{
+		PostHash:             NewBlockHash(RandomBytes(HashSizeBytes)),
+		Score:                ComputeHotnessScore(params, oldPost, 123),
+		AnchorTimestampNanos: 123,
+	}
+	decodedEntry := &HotnessFeedEntry{}
+	require.NoError(decodedEntry.FromBytes(entry.ToBytes()))
+	require.Equal(entry, decodedEntry)
+}
+
+func TestHotnessFeed(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+	indexQueue := NewIndexQueue(db)
+	hotnessFeed := NewHotnessFeed(db, DefaultHotnessFeedParams())
+	require.NoError(hotnessFeed.RebuildIfStale())
+	hotnessFeed.RegisterHandlers(indexQueue)
+
+	// Mine a few blocks to give the sender some DESO to post with.
+	for ii := 0; ii < 2; ii++ {
+		_, err := miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+	}
+	chain.SetIndexQueue(indexQueue)
+
+	senderPkBytes, _, err := Base58CheckDecode(senderPkString)
+	require.NoError(err)
+	mineTxn := func(txn *MsgDeSoTxn) {
+		_signTxn(t, txn, senderPrivString)
+		_, err := mempool.ProcessTransaction(txn, false, false, 0, true)
+		require.NoError(err)
+		_, err = miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+		_, err = indexQueue.ProcessTasks(IndexQueueBatchSize)
+		require.NoError(err)
+	}
+	submitPost := func(parentPostHash *BlockHash, tstampNanos uint64) *BlockHash {
+		var parentStakeID []byte
+		if parentPostHash != nil {
+			parentStakeID = parentPostHash[:]
+		}
+		txn, _, _, _, err := chain.CreateSubmitPostTxn(senderPkBytes, nil, parentStakeID,
+			[]byte(`{"Body": "hot"}`), nil, false, tstampNanos, nil, false, 10, mempool, nil)
+		require.NoError(err)
+		mineTxn(txn)
+		return txn.Hash()
+	}
+	getHotPostHashes := func() []*BlockHash {
+		postHashes, _, err := DbGetHotPostHashes(db, 0, nil)
+		require.NoError(err)
+		return postHashes
+	}
+
+	// Without interactions, newer posts are hotter. Comments aren't ranked.
+	post1 := submitPost(nil, 1)
+	post1Height := uint64(chain.blockTip().Height)
+	post2 := submitPost(nil, 2)
+	require.Equal([]*BlockHash{post2, post1}, getHotPostHashes())
+
+	// A like or comment makes the older post hotter.
+	likeTxn, _, _, _, err := chain.CreateLikeTxn(senderPkBytes, *post1, false, 10, mempool, nil)
+	require.NoError(err)
+	mineTxn(likeTxn)
+	submitPost(post1, 3)
+	require.Equal([]*BlockHash{post1, post2}, getHotPostHashes())
+
+	// Pagination picks up where the previous page ended.
+	firstPage, nextStartKey, err := DbGetHotPostHashes(db, 1, nil)
+	require.NoError(err)
+	require.Equal([]*BlockHash{post1}, firstPage)
+	secondPage, nextStartKey, err := DbGetHotPostHashes(db, 1, nextStartKey)
+	require.NoError(err)
+	require.Equal([]*BlockHash{post2}, secondPage)
+	require.Nil(nextStartKey)
+
+	// A post claiming to be from the future is anchored at the time of its block instead.
+	futurePost := submitPost(nil, uint64(time.Now().Add(1000*time.Hour).UnixNano()))
+	futureEntry, err := DbGetHotnessFeedEntry(db, futurePost)
+	require.NoError(err)
+	require.Equal(chain.blockTip().Header.TstampSecs*uint64(time.Second), futureEntry.AnchorTimestampNanos)
+
+	// Disconnecting the blocks after post1 removes post2 and the interactions with post1.
+	require.NoError(chain.DisconnectBlocksToHeight(post1Height))
+	_, err = indexQueue.ProcessTasks(IndexQueueBatchSize)
+	require.NoError(err)
+	require.Equal([]*BlockHash{post1}, getHotPostHashes())
+	post1Entry, err := DbGetHotnessFeedEntry(db, post1)
+	require.NoError(err)
+	require.InDelta(ComputeHotnessScore(DefaultHotnessFeedParams(), &PostEntry{}, 1), post1Entry.Score, 1e-9)
+
+	// Changing the params rescores every post, and the feed isn't rebuilt again while they're the same.
+	newParams := DefaultHotnessFeedParams()
+	newParams.HalfLife = time.Hour
+	newHotnessFeed := NewHotnessFeed(db, newParams)
+	require.NoError(newHotnessFeed.RebuildIfStale())
+	post1Entry, err = DbGetHotnessFeedEntry(db, post1)
+	require.NoError(err)
+	require.InDelta(ComputeHotnessScore(newParams, &PostEntry{}, 1), post1Entry.Score, 1e-9)
+	storedParams, err := DbGetHotnessFeedParams(db)
+	require.NoError(err)
+	require.Equal(newParams, storedParams)
+}
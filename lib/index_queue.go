@@ -0,0 +1,192 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/deso-protocol/go-deadlock"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// IndexQueueTaskType determines which handlers an IndexQueueTask is dispatched to.
+type IndexQueueTaskType uint8
+
+const (
+	IndexQueueTaskTypeBlockConnected    IndexQueueTaskType = 0
+	IndexQueueTaskTypeBlockDisconnected IndexQueueTaskType = 1
+)
+
+func (taskType IndexQueueTaskType) String() string {
+	switch taskType {
+	case IndexQueueTaskTypeBlockConnected:
+		return "BlockConnected"
+	case IndexQueueTaskTypeBlockDisconnected:
+		return "BlockDisconnected"
+	default:
+		return fmt.Sprintf("IndexQueueTaskType(%d)", uint8(taskType))
+	}
+}
+
+// IndexQueueTask is a unit of work for the non-consensus indexes. Tasks are kept compact, i.e.
+// they only identify the block that was connected or disconnected, and handlers load anything
+// else they need from the db.
+type IndexQueueTask struct {
+	TaskType    IndexQueueTaskType
+	BlockHeight uint64
+	BlockHash   *BlockHash
+}
+
+func (task *IndexQueueTask) ToBytes() []byte {
+	data := []byte{byte(task.TaskType)}
+	data = append(data, UintToBuf(task.BlockHeight)...)
+	data = append(data, task.BlockHash[:]...)
+	return data
+}
+
+func (task *IndexQueueTask) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+
+	taskType, err := rr.ReadByte()
+	if err != nil {
+		return errors.Wrapf(err, "IndexQueueTask.FromBytes: Problem reading TaskType")
+	}
+	task.TaskType = IndexQueueTaskType(taskType)
+
+	task.BlockHeight, err = ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "IndexQueueTask.FromBytes: Problem reading BlockHeight")
+	}
+
+	task.BlockHash = &BlockHash{}
+	if _, err = io.ReadFull(rr, task.BlockHash[:]); err != nil {
+		return errors.Wrapf(err, "IndexQueueTask.FromBytes: Problem reading BlockHash")
+	}
+
+	return nil
+}
+
+// IndexQueueHandler updates one index for a task. Tasks are delivered at least once: a task
+// is redelivered if the node stops before it is deleted, or if another handler for the same
+// task fails. Handlers must therefore be idempotent.
+type IndexQueueHandler func(task *IndexQueueTask) error
+
+// IndexQueue consumes the durable work queue that block connects and disconnects write to,
+// so that non-consensus indexes like counters, trending and search don't slow down block
+// connects. The indexes are eventually consistent with the chain.
+type IndexQueue struct {
+	db *badger.DB
+
+	// handlersLock protects handlers.
+	handlersLock deadlock.RWMutex
+	handlers     map[IndexQueueTaskType][]IndexQueueHandler
+
+	// processLock makes sure only one ProcessTasks runs at a time, so tasks are handled in order.
+	processLock deadlock.Mutex
+
+	// Update wait group
+	updateWaitGroup sync.WaitGroup
+
+	// Shutdown channel
+	stopUpdateChannel chan struct{}
+}
+
+func NewIndexQueue(db *badger.DB) *IndexQueue {
+	return &IndexQueue{
+		db:                db,
+		handlers:          make(map[IndexQueueTaskType][]IndexQueueHandler),
+		stopUpdateChannel: make(chan struct{}),
+	}
+}
+
+// RegisterHandler adds a handler for tasks of the given type. Handlers should be registered
+// before Start is called, since tasks without any handlers are simply dropped.
+func (iq *IndexQueue) RegisterHandler(taskType IndexQueueTaskType, handler IndexQueueHandler) {
+	iq.handlersLock.Lock()
+	defer iq.handlersLock.Unlock()
+
+	iq.handlers[taskType] = append(iq.handlers[taskType], handler)
+}
+
+// ProcessTasks handles up to maxTasks tasks from the front of the queue and returns how many
+// it handled. It stops at the first task that a handler fails on and leaves it at the front
+// of the queue, so that it is retried before any of the tasks behind it.
+func (iq *IndexQueue) ProcessTasks(maxTasks int) (_numTasks int, _err error) {
+	iq.processLock.Lock()
+	defer iq.processLock.Unlock()
+
+	seqs, tasks, err := DbGetIndexQueueTasks(iq.db, maxTasks)
+	if err != nil {
+		return 0, errors.Wrapf(err, "IndexQueue.ProcessTasks: ")
+	}
+
+	for ii, task := range tasks {
+		iq.handlersLock.RLock()
+		handlers := iq.handlers[task.TaskType]
+		iq.handlersLock.RUnlock()
+
+		for _, handler := range handlers {
+			if err := handler(task); err != nil {
+				return ii, errors.Wrapf(err, "IndexQueue.ProcessTasks: Problem handling %v task for block %v at height %v",
+					task.TaskType, task.BlockHash, task.BlockHeight)
+			}
+		}
+
+		// Only delete the task once all of its handlers succeeded.
+		if err := DbDeleteIndexQueueTask(iq.db, nil, seqs[ii]); err != nil {
+			return ii, errors.Wrapf(err, "IndexQueue.ProcessTasks: ")
+		}
+	}
+
+	return len(tasks), nil
+}
+
+func (iq *IndexQueue) Start() {
+	glog.Info("IndexQueue: Starting worker thread")
+
+	iq.updateWaitGroup.Add(1)
+	go func() {
+		defer iq.updateWaitGroup.Done()
+
+		for {
+			// While the IndexQueue is paused, tasks keep piling up in the queue, and are processed
+			// once it's resumed.
+			var numTasks int
+			var err error
+			if DbGetSubsystemPaused(iq.db, SubsystemIndexQueue) {
+				glog.V(1).Infof("IndexQueue: Paused, not processing tasks")
+			} else {
+				numTasks, err = iq.ProcessTasks(IndexQueueBatchSize)
+				if err != nil {
+					glog.Errorf("IndexQueue: Problem processing tasks, will retry: %v", err)
+				}
+			}
+
+			// If we got a full batch there are probably more tasks waiting, so we
+			// keep going. Otherwise, we wait a bit for new tasks to be enqueued.
+			waitDuration := IndexQueuePollInterval
+			if err == nil && numTasks == IndexQueueBatchSize {
+				waitDuration = 0
+			}
+
+			select {
+			case <-iq.stopUpdateChannel:
+				return
+			case <-time.After(waitDuration):
+			}
+		}
+	}()
+}
+
+// Stop waits for the worker to finish the task it's on. Tasks left in the queue are
+// processed the next time the IndexQueue is started.
+func (iq *IndexQueue) Stop() {
+	glog.Info("IndexQueue: Stopping worker thread")
+
+	iq.stopUpdateChannel <- struct{}{}
+	iq.updateWaitGroup.Wait()
+}
@@ -0,0 +1,102 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexQueueTaskEncoding(t *testing.T) {
+	require := require.New(t)
+
+	task := &IndexQueueTask{
+		TaskType:    IndexQueueTaskTypeBlockDisconnected,
+		BlockHeight: 123456,
+		BlockHash:   NewBlockHash(RandomBytes(HashSizeBytes)),
+	}
+	decodedTask := &IndexQueueTask{}
+	require.NoError(decodedTask.FromBytes(task.ToBytes()))
+	require.Equal(task, decodedTask)
+
+	// Truncated tasks fail to decode.
+	taskBytes := task.ToBytes()
+	require.Error((&IndexQueueTask{}).FromBytes(taskBytes[:len(taskBytes)-1]))
+}
+
+func TestIndexQueue(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+
+	// Blocks connected before the queue is set don't enqueue anything.
+	_, err := miner.MineAndProcessSingleBlock(0, mempool)
+	require.NoError(err)
+	_, tasks, err := DbGetIndexQueueTasks(db, IndexQueueBatchSize)
+	require.NoError(err)
+	require.Empty(tasks)
+
+	indexQueue := NewIndexQueue(db)
+	chain.SetIndexQueue(indexQueue)
+
+	// Every block connected from now on enqueues a task, in order.
+	minedBlockHashes := []*BlockHash{}
+	minedBlockHeights := []uint64{}
+	for ii := 0; ii < 3; ii++ {
+		block, err := miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+		blockHash, err := block.Hash()
+		require.NoError(err)
+		minedBlockHashes = append(minedBlockHashes, blockHash)
+		minedBlockHeights = append(minedBlockHeights, block.Header.Height)
+	}
+	_, tasks, err = DbGetIndexQueueTasks(db, IndexQueueBatchSize)
+	require.NoError(err)
+	require.Equal(3, len(tasks))
+
+	// A failing handler leaves its task at the front of the queue.
+	handledTasks := []*IndexQueueTask{}
+	shouldFail := true
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockConnected, func(task *IndexQueueTask) error {
+		if shouldFail {
+			return fmt.Errorf("handler failed")
+		}
+		handledTasks = append(handledTasks, task)
+		return nil
+	})
+	numTasks, err := indexQueue.ProcessTasks(IndexQueueBatchSize)
+	require.Error(err)
+	require.Equal(0, numTasks)
+	_, tasks, err = DbGetIndexQueueTasks(db, IndexQueueBatchSize)
+	require.NoError(err)
+	require.Equal(3, len(tasks))
+
+	// Once the handler succeeds, the tasks are handled in order and deleted.
+	shouldFail = false
+	numTasks, err = indexQueue.ProcessTasks(2)
+	require.NoError(err)
+	require.Equal(2, numTasks)
+	numTasks, err = indexQueue.ProcessTasks(IndexQueueBatchSize)
+	require.NoError(err)
+	require.Equal(1, numTasks)
+	require.Equal(3, len(handledTasks))
+	for ii, task := range handledTasks {
+		require.Equal(IndexQueueTaskTypeBlockConnected, task.TaskType)
+		require.Equal(minedBlockHashes[ii], task.BlockHash)
+		require.Equal(minedBlockHeights[ii], task.BlockHeight)
+	}
+	_, tasks, err = DbGetIndexQueueTasks(db, IndexQueueBatchSize)
+	require.NoError(err)
+	require.Empty(tasks)
+
+	// Sequence numbers keep increasing after the queue has been drained.
+	_, err = miner.MineAndProcessSingleBlock(0, mempool)
+	require.NoError(err)
+	seqs, tasks, err := DbGetIndexQueueTasks(db, IndexQueueBatchSize)
+	require.NoError(err)
+	require.Equal(1, len(tasks))
+	require.Equal(uint64(3), seqs[0])
+}
@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/pkg/errors"
+)
+
+// Before DeSoEncoder, values were stored with encoding/gob. Nodes that haven't resynced since then
+// still have some of those values in their db, and the postgres messaging group members were gob-encoded
+// until recently. The helpers in this file decode a value in whichever of the two encodings it was written
+// in. Nothing is ever written back in gob: the next time a legacy value is written it's re-encoded with
+// the new binary format, so the legacy values naturally disappear over time. This is the only file that
+// should import encoding/gob, so that it's easy to drop once no legacy values are left.
+//
+// Sniffing the encoding is cheap and unambiguous. A DeSoEncoder value always starts with its existence
+// byte, which is either 0 or 1. A gob stream starts with the uvarint length of its first message, which
+// is a type definition, so it's always at least 2, or 0xf8 and above for long messages.
+
+// IsLegacyGobEncoding returns true if the value was encoded with encoding/gob rather than as a DeSoEncoder.
+func IsLegacyGobEncoding(data []byte) bool {
+	return len(data) > 0 && data[0] > 1
+}
+
+// DecodeFromBytesCompat is like DecodeFromBytes, except that it also accepts gob-encoded values.
+// _isLegacy is true if the value was gob-encoded, in which case callers may want to re-write it.
+func DecodeFromBytesCompat(encoder DeSoEncoder, data []byte) (_exists bool, _isLegacy bool, _err error) {
+	if !IsLegacyGobEncoding(data) {
+		exists, err := DecodeFromBytes(encoder, bytes.NewReader(data))
+		return exists, false, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(encoder); err != nil {
+		return false, true, errors.Wrapf(err, "DecodeFromBytesCompat: Problem decoding legacy "+
+			"gob value for encoder type %v", encoder.GetEncoderType())
+	}
+	return true, true, nil
+}
+
+// EncodeMessagingGroupMembers encodes a list of members in the binary format. It's used where the list is
+// stored on its own, i.e. outside of a MessagingGroupEntry.
+func EncodeMessagingGroupMembers(blockHeight uint64, members []*MessagingGroupMember) []byte {
+	// The leading zero byte can never start a gob stream, which is what tells the two formats apart.
+	data := []byte{0}
+	data = append(data, UintToBuf(uint64(len(members)))...)
+	for _, member := range members {
+		data = append(data, EncodeToBytes(blockHeight, member)...)
+	}
+	return data
+}
+
+// DecodeMessagingGroupMembers decodes a list of members encoded with either EncodeMessagingGroupMembers or,
+// for legacy values, encoding/gob.
+func DecodeMessagingGroupMembers(data []byte) (_members []*MessagingGroupMember, _isLegacy bool, _err error) {
+	var members []*MessagingGroupMember
+	if len(data) == 0 {
+		return members, false, nil
+	}
+	if IsLegacyGobEncoding(data) {
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&members); err != nil {
+			return nil, true, errors.Wrapf(err, "DecodeMessagingGroupMembers: Problem decoding legacy gob value")
+		}
+		return members, true, nil
+	}
+
+	rr := bytes.NewReader(data[1:])
+	numMembers, err := ReadUvarint(rr)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "DecodeMessagingGroupMembers: Problem reading number of members")
+	}
+	for ; numMembers > 0; numMembers-- {
+		member := &MessagingGroupMember{}
+		if exists, err := DecodeFromBytes(member, rr); !exists || err != nil {
+			return nil, false, errors.Wrapf(err, "DecodeMessagingGroupMembers: Problem reading member")
+		}
+		members = append(members, member)
+	}
+	return members, false, nil
+}
+
+// DecodeUtxoOperationsCompat decodes the utxo operations of a block, stored either as a
+// UtxoOperationBundle or, for blocks connected before the bundle existed, as a gob-encoded
+// [][]*UtxoOperation.
+func DecodeUtxoOperationsCompat(data []byte) (_utxoOps [][]*UtxoOperation, _isLegacy bool, _err error) {
+	if IsLegacyGobEncoding(data) {
+		var utxoOps [][]*UtxoOperation
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&utxoOps); err != nil {
+			return nil, true, errors.Wrapf(err, "DecodeUtxoOperationsCompat: Problem decoding legacy gob value")
+		}
+		return utxoOps, true, nil
+	}
+
+	utxoOpsBundle := &UtxoOperationBundle{}
+	if exists, err := DecodeFromBytes(utxoOpsBundle, bytes.NewReader(data)); !exists || err != nil {
+		return nil, false, errors.Wrapf(err, "DecodeUtxoOperationsCompat: Problem decoding utxoOpsBundle")
+	}
+	return utxoOpsBundle.UtxoOpBundle, false, nil
+}
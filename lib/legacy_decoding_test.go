@@ -0,0 +1,130 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeFromBytesCompat(t *testing.T) {
+	require := require.New(t)
+
+	messageEntry := &MessageEntry{
+		SenderPublicKey:    NewPublicKey(m0PkBytes),
+		RecipientPublicKey: NewPublicKey(m1PkBytes),
+		EncryptedText:      []byte{1, 2, 3},
+		TstampNanos:        123,
+		Version:            MessagesVersion2,
+		ExtraData:          map[string][]byte{"key": []byte("value")},
+	}
+
+	// Values encoded as DeSoEncoders decode as usual.
+	decodedEntry := &MessageEntry{}
+	exists, isLegacy, err := DecodeFromBytesCompat(decodedEntry, EncodeToBytes(0, messageEntry))
+	require.NoError(err)
+	require.True(exists)
+	require.False(isLegacy)
+	require.Equal(EncodeToBytes(0, messageEntry), EncodeToBytes(0, decodedEntry))
+
+	// Legacy gob values are sniffed and decoded to the same entry.
+	gobBytes := bytes.NewBuffer([]byte{})
+	require.NoError(gob.NewEncoder(gobBytes).Encode(messageEntry))
+	require.True(IsLegacyGobEncoding(gobBytes.Bytes()))
+	decodedEntry = &MessageEntry{}
+	exists, isLegacy, err = DecodeFromBytesCompat(decodedEntry, gobBytes.Bytes())
+	require.NoError(err)
+	require.True(exists)
+	require.True(isLegacy)
+	require.Equal(EncodeToBytes(0, messageEntry), EncodeToBytes(0, decodedEntry))
+
+	// Nil entries are still reported as missing.
+	exists, isLegacy, err = DecodeFromBytesCompat(&MessageEntry{}, EncodeToBytes(0, (*MessageEntry)(nil)))
+	require.NoError(err)
+	require.False(exists)
+	require.False(isLegacy)
+}
+
+func TestDecodeMessagingGroupMembers(t *testing.T) {
+	require := require.New(t)
+
+	members := []*MessagingGroupMember{
+		{
+			GroupMemberPublicKey: NewPublicKey(m0PkBytes),
+			GroupMemberKeyName:   BaseGroupKeyName(),
+			EncryptedKey:         RandomBytes(32),
+		},
+		{
+			GroupMemberPublicKey: NewPublicKey(m1PkBytes),
+			GroupMemberKeyName:   NewGroupKeyName([]byte("default-key")),
+			EncryptedKey:         RandomBytes(32),
+		},
+	}
+
+	encodedMembers := EncodeMessagingGroupMembers(0, members)
+	require.False(IsLegacyGobEncoding(encodedMembers))
+	decodedMembers, isLegacy, err := DecodeMessagingGroupMembers(encodedMembers)
+	require.NoError(err)
+	require.False(isLegacy)
+	require.Equal(members, decodedMembers)
+
+	gobBytes := bytes.NewBuffer([]byte{})
+	require.NoError(gob.NewEncoder(gobBytes).Encode(members))
+	decodedMembers, isLegacy, err = DecodeMessagingGroupMembers(gobBytes.Bytes())
+	require.NoError(err)
+	require.True(isLegacy)
+	require.Equal(members, decodedMembers)
+
+	decodedMembers, _, err = DecodeMessagingGroupMembers(EncodeMessagingGroupMembers(0, nil))
+	require.NoError(err)
+	require.Empty(decodedMembers)
+}
+
+func TestDecodeUtxoOperationsCompat(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	utxoKey := &UtxoKey{TxID: *NewBlockHash(RandomBytes(HashSizeBytes)), Index: 1}
+	utxoOps := [][]*UtxoOperation{
+		{
+			{Type: OperationTypeSpendUtxo, Key: utxoKey, Entry: &UtxoEntry{
+				AmountNanos: 10, PublicKey: m0PkBytes, BlockHeight: 5, UtxoType: UtxoTypeOutput, UtxoKey: utxoKey}},
+			{Type: OperationTypeAddUtxo, Key: utxoKey},
+		},
+		{
+			{Type: OperationTypeAddUtxo, Key: utxoKey},
+		},
+	}
+	expectedBytes := EncodeToBytes(0, &UtxoOperationBundle{UtxoOpBundle: utxoOps})
+
+	// Utxo operations are written as a UtxoOperationBundle.
+	blockHash := NewBlockHash(RandomBytes(HashSizeBytes))
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return PutUtxoOperationsForBlockWithTxn(txn, nil, 0, blockHash, utxoOps)
+	}))
+	decodedOps, err := GetUtxoOperationsForBlock(db, nil, blockHash)
+	require.NoError(err)
+	require.Equal(expectedBytes, EncodeToBytes(0, &UtxoOperationBundle{UtxoOpBundle: decodedOps}))
+
+	// Legacy gob-encoded utxo operations are still read.
+	gobBytes := bytes.NewBuffer([]byte{})
+	require.NoError(gob.NewEncoder(gobBytes).Encode(utxoOps))
+	legacyBlockHash := NewBlockHash(RandomBytes(HashSizeBytes))
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return DBSetWithTxn(txn, nil, _DbKeyForUtxoOps(legacyBlockHash), gobBytes.Bytes())
+	}))
+	decodedOps, err = GetUtxoOperationsForBlock(db, nil, legacyBlockHash)
+	require.NoError(err)
+	require.Equal(expectedBytes, EncodeToBytes(0, &UtxoOperationBundle{UtxoOpBundle: decodedOps}))
+	_, isLegacy, err := DecodeUtxoOperationsCompat(gobBytes.Bytes())
+	require.NoError(err)
+	require.True(isLegacy)
+}
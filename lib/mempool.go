@@ -1,19 +1,20 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
+	"bytes"
 	"container/heap"
 	"container/list"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"github.com/btcsuite/btcutil"
-	"github.com/gernest/mention"
 	"log"
 	"math"
 	"os"
 	"path/filepath"
-	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -233,6 +234,11 @@ type DeSoMempool struct {
 	// This field isn't reset with ResetPool. It requires an explicit call to
 	// UpdateReadOnlyView.
 	readOnlyUtxoView *UtxoView
+	// readOnlyMtx guards readOnlyUtxoView, readOnlyUniversalTransactionList and
+	// readOnlyUniversalTransactionMap. They're replaced rather than modified when the
+	// read-only view is regenerated, so readers only hold it long enough to get them,
+	// and never have to wait on mtx while the pool processes txns.
+	readOnlyMtx deadlock.RWMutex
 	// Keep a list of all transactions in the mempool. This is useful for dumping
 	// to the database periodically.
 	readOnlyUniversalTransactionList []*MempoolTx
@@ -255,6 +261,122 @@ type DeSoMempool struct {
 	// We pass a copy of the data dir flag to the tx pool so that we can instantiate
 	// temp badger db instances and dump mempool txns to them.
 	dataDir string
+
+	// feeEstimator tracks the fee rates of the txns in the pool and in recent blocks.
+	//
+	// This field isn't reset with ResetPool. Instead, ResetPool tells it which txns
+	// were added and removed.
+	feeEstimator *FeeEstimator
+
+	// Optional. When set, BitcoinExchange txns are only accepted once their Bitcoin txn is
+	// mined into the best Bitcoin header chain. See SetBitcoinHeaderManager.
+	bitcoinHeaderManager *BitcoinHeaderManager
+
+	// Optional. When set, new posts and comments from a public key that already made this many in
+	// the last hour's worth of blocks and the mempool are rejected. See SetMaxPostsPerHour.
+	maxPostsPerHour uint64
+
+	// Optional. Txns of these types are rejected, so the node never relays or mines them, but
+	// blocks with them are still accepted. See SetFilteredTxnTypes.
+	filteredTxnTypes map[TxnType]bool
+	// The number of txns of each type that were rejected because their type is filtered. It's
+	// protected by mtx.
+	numFilteredTxnsByType map[TxnType]uint64
+}
+
+// SetBitcoinHeaderManager makes the mempool check BitcoinExchange txns against the Bitcoin
+// header chain. It must be called before the mempool starts processing txns.
+func (mp *DeSoMempool) SetBitcoinHeaderManager(bitcoinHeaderManager *BitcoinHeaderManager) {
+	mp.bitcoinHeaderManager = bitcoinHeaderManager
+}
+
+// SetMaxPostsPerHour limits the number of new posts and comments the mempool accepts from a
+// public key to maxPostsPerHour per hour's worth of blocks. Like the fee rate limits, it only
+// applies to txns that are rate limited, which are the ones relayed to us. Zero disables the limit.
+// It must be called before the mempool starts processing txns.
+func (mp *DeSoMempool) SetMaxPostsPerHour(maxPostsPerHour uint64) {
+	mp.maxPostsPerHour = maxPostsPerHour
+}
+
+// SetFilteredTxnTypes makes the mempool reject txns of the given types, whether they're relayed
+// to us or submitted locally. Since only txns in the mempool are relayed and mined, this lets
+// operators run nodes that don't propagate some txn types, while still accepting blocks with them.
+// It must be called before the mempool starts processing txns.
+func (mp *DeSoMempool) SetFilteredTxnTypes(txnTypes []TxnType) {
+	mp.filteredTxnTypes = make(map[TxnType]bool)
+	for _, txnType := range txnTypes {
+		mp.filteredTxnTypes[txnType] = true
+	}
+}
+
+// GetNumFilteredTxnsByType returns the number of txns of each filtered type the mempool has
+// rejected since it started.
+func (mp *DeSoMempool) GetNumFilteredTxnsByType() map[TxnType]uint64 {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	numFilteredTxnsByType := make(map[TxnType]uint64)
+	for txnType, numFilteredTxns := range mp.numFilteredTxnsByType {
+		numFilteredTxnsByType[txnType] = numFilteredTxns
+	}
+	return numFilteredTxnsByType
+}
+
+// ParseTxnTypes returns the txn types with the given names, e.g. SUBMIT_POST, or numbers.
+func ParseTxnTypes(txnTypeStrings []string) ([]TxnType, error) {
+	var txnTypes []TxnType
+	for _, txnTypeString := range txnTypeStrings {
+		txnType := GetTxnTypeFromString(TxnString(strings.ToUpper(txnTypeString)))
+		if txnTypeNumber, err := strconv.Atoi(txnTypeString); err == nil && txnTypeNumber > 0 &&
+			TxnType(txnTypeNumber).GetTxnString() != TxnStringUndefined {
+			txnType = TxnType(txnTypeNumber)
+		}
+		if txnType == TxnTypeUnset {
+			return nil, fmt.Errorf("ParseTxnTypes: %v is not a txn type", txnTypeString)
+		}
+		txnTypes = append(txnTypes, txnType)
+	}
+	return txnTypes, nil
+}
+
+// _numRecentPostsForPublicKey returns the number of posts and comments the public key made in the
+// last hour's worth of blocks, including the block the mempool txns will go in. Posts in the db
+// are only counted when we're not running postgres, since the post counts are badger-only.
+func (mp *DeSoMempool) _numRecentPostsForPublicKey(publicKey []byte) (uint64, error) {
+	numPosts := uint64(0)
+	for _, mempoolTx := range mp.pubKeyToTxnMap[MakePkMapKey(publicKey)] {
+		if _isNewPostTxn(mempoolTx.Tx) && bytes.Equal(mempoolTx.Tx.PublicKey, publicKey) {
+			numPosts++
+		}
+	}
+	if mp.bc.postgres != nil {
+		return numPosts, nil
+	}
+
+	blocksPerHour := uint64(1)
+	if mp.bc.params.TimeBetweenBlocks > 0 && time.Hour/mp.bc.params.TimeBetweenBlocks > 1 {
+		blocksPerHour = uint64(time.Hour / mp.bc.params.TimeBetweenBlocks)
+	}
+	// The mempool's block is one of the blocks in the hour, so we look at the ones before it.
+	tipHeight := uint64(mp.bc.blockTip().Height)
+	startHeight := uint64(0)
+	if tipHeight+2 > blocksPerHour {
+		startHeight = tipHeight + 2 - blocksPerHour
+	}
+	numDbPosts, err := DbGetNumPostsForPublicKeyInBlockRange(mp.bc.db, mp.bc.snapshot, publicKey,
+		startHeight, tipHeight)
+	if err != nil {
+		return 0, errors.Wrapf(err, "_numRecentPostsForPublicKey: ")
+	}
+	return numPosts + numDbPosts, nil
+}
+
+// _isNewPostTxn returns whether the txn creates a post or comment, rather than modifying one.
+func _isNewPostTxn(txn *MsgDeSoTxn) bool {
+	if txn.TxnMeta == nil || txn.TxnMeta.GetTxnType() != TxnTypeSubmitPost {
+		return false
+	}
+	return len(txn.TxnMeta.(*SubmitPostMetadata).PostHashToModify) == 0
 }
 
 // See comment on RemoveUnconnectedTxn. The mempool lock must be called for writing
@@ -308,6 +430,18 @@ func (mp *DeSoMempool) removeUnconnectedTxn(tx *MsgDeSoTxn, removeRedeemers bool
 //
 // Note the write lock must be held before calling this function.
 func (mp *DeSoMempool) resetPool(newPool *DeSoMempool) {
+	// Let the fee estimator know which txns are leaving and joining the pool.
+	for txHash, mempoolTx := range mp.poolMap {
+		if _, exists := newPool.poolMap[txHash]; !exists {
+			mp.feeEstimator.RemoveMempoolTx(mempoolTx.Hash)
+		}
+	}
+	for txHash, mempoolTx := range newPool.poolMap {
+		if _, exists := mp.poolMap[txHash]; !exists {
+			mp.feeEstimator.AddMempoolTx(mempoolTx)
+		}
+	}
+
 	// Replace the internal mappings of the original pool with the mappings of the new
 	// pool.
 	mp.poolMap = newPool.poolMap
@@ -433,6 +567,13 @@ func (mp *DeSoMempool) UpdateAfterConnectBlock(blk *MsgDeSoBlock) (_txnsAddedToM
 	// At this point, the new pool should contain an up-to-date view of the transactions
 	// that should be in the mempool after connecting this block.
 
+	// Record the fee rates of the block's txns before they leave the pool.
+	if blockHash, err := blk.Hash(); err != nil {
+		glog.Warning(errors.Wrapf(err, "UpdateAfterConnectBlock: Problem hashing block: "))
+	} else {
+		mp.feeEstimator.ConnectBlock(blockHash, blk)
+	}
+
 	// Figure out what transactions are in the new pool but not in the old pool. These
 	// are transactions that were newly-added as a result of this block clearing up some
 	// dependencies and so we will likely want to relay these transactions.
@@ -542,6 +683,13 @@ func (mp *DeSoMempool) UpdateAfterDisconnectBlock(blk *MsgDeSoBlock) {
 	// the block's transactions added (with timestamps set before the transactions that
 	// were in the original pool.
 
+	// The block's fee rates no longer count as recent.
+	if blockHash, err := blk.Hash(); err != nil {
+		glog.Warning(errors.Wrapf(err, "UpdateAfterDisconnectBlock: Problem hashing block: "))
+	} else {
+		mp.feeEstimator.DisconnectBlock(blockHash)
+	}
+
 	// Replace the internal mappings of the original pool with the mappings of the new
 	// pool.
 	mp.resetPool(newPool)
@@ -549,8 +697,9 @@ func (mp *DeSoMempool) UpdateAfterDisconnectBlock(blk *MsgDeSoBlock) {
 
 // Acquires a read lock before returning the transactions.
 func (mp *DeSoMempool) GetTransactionsOrderedByTimeAdded() (_poolTxns []*MempoolTx, _unconnectedTxns []*UnconnectedTx, _err error) {
+	txnList, _ := mp.getReadOnlyUniversalTransactions()
 	poolTxns := []*MempoolTx{}
-	poolTxns = append(poolTxns, mp.readOnlyUniversalTransactionList...)
+	poolTxns = append(poolTxns, txnList...)
 
 	// Sort and return the txns.
 	sort.Slice(poolTxns, func(ii, jj int) bool {
@@ -569,7 +718,8 @@ func (mp *DeSoMempool) GetTransactionsOrderedByTimeAdded() (_poolTxns []*Mempool
 }
 
 func (mp *DeSoMempool) GetTransaction(txId *BlockHash) (txn *MempoolTx) {
-	return mp.readOnlyUniversalTransactionMap[*txId]
+	_, txnMap := mp.getReadOnlyUniversalTransactions()
+	return txnMap[*txId]
 }
 
 // GetTransactionsOrderedByTimeAdded returns all transactions in the mempool ordered
@@ -686,7 +836,8 @@ func (mp *DeSoMempool) isTransactionInPool(hash *BlockHash) bool {
 
 // Whether or not a txn is in the pool. Safe for concurrent access.
 func (mp *DeSoMempool) IsTransactionInPool(hash *BlockHash) bool {
-	_, exists := mp.readOnlyUniversalTransactionMap[*hash]
+	_, txnMap := mp.getReadOnlyUniversalTransactions()
+	_, exists := txnMap[*hash]
 	return exists
 }
 
@@ -758,7 +909,7 @@ func MakeDirIfNonExistent(filePath string) error {
 
 func (mp *DeSoMempool) OpenTempDBAndDumpTxns() error {
 	blockHeight := uint64(mp.bc.blockTip().Height + 1)
-	allTxns := mp.readOnlyUniversalTransactionList
+	allTxns, _ := mp.getReadOnlyUniversalTransactions()
 
 	tempMempoolDBDir := filepath.Join(mp.mempoolDir, "temp_mempool_dump")
 	glog.Infof("OpenTempDBAndDumpTxns: Opening new temp db %v", tempMempoolDBDir)
@@ -871,6 +1022,7 @@ func (mp *DeSoMempool) addTransaction(
 	}
 	// Add it to the universalTransactionList if it made it through the view
 	mp.universalTransactionList = append(mp.universalTransactionList, mempoolTx)
+	mp.feeEstimator.AddMempoolTx(mempoolTx)
 	if updateBackupView {
 		_, _, _, _, err = mp.backupUniversalUtxoView._connectTransaction(mempoolTx.Tx, mempoolTx.Hash, int64(mempoolTx.TxSizeBytes), height,
 			false /*verifySignatures*/, false /*ignoreUtxos*/)
@@ -883,6 +1035,27 @@ func (mp *DeSoMempool) addTransaction(
 	return mempoolTx, nil
 }
 
+// getReadOnlyUtxoView returns the current read-only view. It must not be modified,
+// since other readers may be using it.
+func (mp *DeSoMempool) getReadOnlyUtxoView() *UtxoView {
+	mp.readOnlyMtx.RLock()
+	defer mp.readOnlyMtx.RUnlock()
+
+	return mp.readOnlyUtxoView
+}
+
+// getReadOnlyUniversalTransactions returns the txns in the current read-only view, as
+// a list ordered by when they were added and as a map by their hash. Neither must be
+// modified.
+func (mp *DeSoMempool) getReadOnlyUniversalTransactions() (
+	[]*MempoolTx, map[BlockHash]*MempoolTx) {
+
+	mp.readOnlyMtx.RLock()
+	defer mp.readOnlyMtx.RUnlock()
+
+	return mp.readOnlyUniversalTransactionList, mp.readOnlyUniversalTransactionMap
+}
+
 func (mp *DeSoMempool) CheckSpend(op UtxoKey) *MsgDeSoTxn {
 	txR := mp.readOnlyOutpoints[op]
 
@@ -901,22 +1074,33 @@ func (mp *DeSoMempool) GetAugmentedUtxoViewForPublicKey(pkBytes []byte, optional
 }
 
 // GetAugmentedUniversalView creates a view that just connects everything
-// in the mempool...
-// TODO(performance): We should make a read-only version of the universal view that
-// you can get from the mempool.
+// in the mempool... Callers that only read from the view should use
+// GetReadOnlyUniversalView, which is much cheaper.
 func (mp *DeSoMempool) GetAugmentedUniversalView() (*UtxoView, error) {
 	if mp.stopped {
 		return nil, fmt.Errorf("GetAugmentedUniversalView: Problem getting UtxoView, Mempool is closed")
 	}
-	newView, err := mp.readOnlyUtxoView.CopyUtxoView()
+	newView, err := mp.getReadOnlyUtxoView().CopyUtxoView()
 	if err != nil {
 		return nil, err
 	}
 	return newView, nil
 }
 
+// GetReadOnlyUniversalView returns a read-only copy of the view that connects everything
+// in the mempool, as of the last time the read-only view was regenerated. It doesn't
+// acquire the mempool's lock, so it's safe to call from API handlers while the pool is
+// processing txns. See UtxoView.CopyReadOnly for what the returned view can be used for.
+func (mp *DeSoMempool) GetReadOnlyUniversalView() (*UtxoView, error) {
+	if mp.stopped {
+		return nil, fmt.Errorf("GetReadOnlyUniversalView: Problem getting UtxoView, Mempool is closed")
+	}
+	return mp.getReadOnlyUtxoView().CopyReadOnly(), nil
+}
+
 func (mp *DeSoMempool) FetchTransaction(txHash *BlockHash) *MempoolTx {
-	if mempoolTx, exists := mp.readOnlyUniversalTransactionMap[*txHash]; exists {
+	_, txnMap := mp.getReadOnlyUniversalTransactions()
+	if mempoolTx, exists := txnMap[*txHash]; exists {
 		return mempoolTx
 	}
 	return nil
@@ -1003,6 +1187,34 @@ func (mp *DeSoMempool) tryAcceptTransaction(
 		return nil, nil, TxErrorDuplicate
 	}
 
+	// Reject txns of the types we filter.
+	if tx.TxnMeta != nil && mp.filteredTxnTypes[tx.TxnMeta.GetTxnType()] {
+		mp.numFilteredTxnsByType[tx.TxnMeta.GetTxnType()]++
+		return nil, nil, errors.Wrapf(TxErrorTxnTypeFiltered, "tryAcceptTransaction: Txn type %v is filtered",
+			tx.TxnMeta.GetTxnType())
+	}
+
+	// Reject BitcoinExchange txns whose Bitcoin txn isn't in the Bitcoin header chain, if we
+	// have one.
+	if mp.bitcoinHeaderManager != nil && tx.TxnMeta != nil && tx.TxnMeta.GetTxnType() == TxnTypeBitcoinExchange {
+		if err := mp.bitcoinHeaderManager.ValidateBitcoinExchange(tx.TxnMeta.(*BitcoinExchangeMetadata)); err != nil {
+			return nil, nil, errors.Wrapf(err, "tryAcceptTransaction: ")
+		}
+	}
+
+	// Reject new posts from public keys that have been posting too much, if we rate limit posts.
+	if rateLimit && mp.maxPostsPerHour > 0 && _isNewPostTxn(tx) {
+		numRecentPosts, err := mp._numRecentPostsForPublicKey(tx.PublicKey)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "tryAcceptTransaction: ")
+		}
+		if numRecentPosts >= mp.maxPostsPerHour {
+			return nil, nil, errors.Wrapf(TxErrorPostRateLimitExceeded, "tryAcceptTransaction: Public key "+
+				"%v made %v posts in the last hour, which is the maximum", PkToStringBoth(tx.PublicKey),
+				numRecentPosts)
+		}
+	}
+
 	// Iterate over the transaction's inputs. If any of them don't have utxos in the
 	// UtxoView that are unspent at this point then the transaction is an unconnected
 	// txn. Use a map to ensure there are no duplicates.
@@ -1121,7 +1333,6 @@ func ComputeTransactionMetadata(txn *MsgDeSoTxn, utxoView *UtxoView, blockHash *
 	totalNanosPurchasedBefore uint64, usdCentsPerBitcoinBefore uint64, totalInput uint64, totalOutput uint64,
 	fees uint64, txnIndexInBlock uint64, utxoOps []*UtxoOperation, blockHeight uint64) *TransactionMetadata {
 
-	var err error
 	txnMeta := &TransactionMetadata{
 		TxnIndexInBlock: txnIndexInBlock,
 		TxnType:         txn.TxnMeta.GetTxnType().String(),
@@ -1145,14 +1356,13 @@ func ComputeTransactionMetadata(txn *MsgDeSoTxn, utxoView *UtxoView, blockHash *
 		},
 
 		TxnOutputs: txn.TxOutputs,
+		TxnInputs:  ComputeResolvedInputsTxindexMetadata(utxoOps, utxoView.Params),
 	}
 
 	if blockHash != nil {
 		txnMeta.BlockHashHex = hex.EncodeToString(blockHash[:])
 	}
 
-	extraData := txn.ExtraData
-
 	// Set the affected public keys for the basic transfer.
 	for _, output := range txn.TxOutputs {
 		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
@@ -1161,631 +1371,23 @@ func ComputeTransactionMetadata(txn *MsgDeSoTxn, utxoView *UtxoView, blockHash *
 		})
 	}
 
-	switch txn.TxnMeta.GetTxnType() {
-	case TxnTypeBitcoinExchange:
-		txnMeta.BitcoinExchangeTxindexMetadata, txnMeta.TransactorPublicKeyBase58Check, err =
-			_computeBitcoinExchangeFields(utxoView.Params, txn.TxnMeta.(*BitcoinExchangeMetadata),
-				totalNanosPurchasedBefore, usdCentsPerBitcoinBefore)
-		if err != nil {
-			glog.V(2).Infof(
-				"UpdateTxindex: Error computing BitcoinExchange txn metadata: %v", err)
-		} else {
-			// Set the nanos purchased before/after.
-			txnMeta.BitcoinExchangeTxindexMetadata.TotalNanosPurchasedBefore = totalNanosPurchasedBefore
-			txnMeta.BitcoinExchangeTxindexMetadata.TotalNanosPurchasedAfter = utxoView.NanosPurchased
-
-			// Always associate BitcoinExchange txns with the burn public key. This makes it
-			//		// easy to enumerate all burn txns in the block explorer.
-			txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-				PublicKeyBase58Check: BurnPubKeyBase58Check,
-				Metadata:             "BurnPublicKey",
-			})
-		}
-	case TxnTypeCreatorCoin:
-		// Get the txn metadata
-		realTxMeta := txn.TxnMeta.(*CreatorCoinMetadataa)
-
-		// Rosetta needs to know the change in DESOLockedNanos so it can model the change in
-		// total deso locked in the creator coin. Calculate this by comparing the current CreatorCoinEntry
-		// to the previous CreatorCoinEntry
-		profileEntry := utxoView.GetProfileEntryForPublicKey(realTxMeta.ProfilePublicKey)
-		var prevCoinEntry *CoinEntry
-		for _, op := range utxoOps {
-			if op.Type == OperationTypeCreatorCoin {
-				prevCoinEntry = op.PrevCoinEntry
-				break
-			}
-		}
-
-		desoLockedNanosDiff := int64(0)
-		if profileEntry == nil || prevCoinEntry == nil {
-			glog.Errorf("Update TxIndex: missing DESOLockedNanosDiff error: %v", txn.Hash().String())
-		} else {
-			desoLockedNanosDiff = int64(profileEntry.CreatorCoinEntry.DeSoLockedNanos - prevCoinEntry.DeSoLockedNanos)
-		}
-
-		// Set the amount of the buy/sell/add
-		txnMeta.CreatorCoinTxindexMetadata = &CreatorCoinTxindexMetadata{
-			DeSoToSellNanos:        realTxMeta.DeSoToSellNanos,
-			CreatorCoinToSellNanos: realTxMeta.CreatorCoinToSellNanos,
-			DeSoToAddNanos:         realTxMeta.DeSoToAddNanos,
-			DESOLockedNanosDiff:    desoLockedNanosDiff,
-		}
-
-		// Set the type of the operation.
-		if realTxMeta.OperationType == CreatorCoinOperationTypeBuy {
-			txnMeta.CreatorCoinTxindexMetadata.OperationType = "buy"
-		} else if realTxMeta.OperationType == CreatorCoinOperationTypeSell {
-			txnMeta.CreatorCoinTxindexMetadata.OperationType = "sell"
-		} else {
-			txnMeta.CreatorCoinTxindexMetadata.OperationType = "add"
-		}
-
-		// Set the affected public key to the owner of the creator coin so that they
-		// get notified.
-		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-			PublicKeyBase58Check: PkToString(realTxMeta.ProfilePublicKey, utxoView.Params),
-			Metadata:             "CreatorPublicKey",
-		})
-	case TxnTypeCreatorCoinTransfer:
-		realTxMeta := txn.TxnMeta.(*CreatorCoinTransferMetadataa)
-		creatorProfileEntry := utxoView.GetProfileEntryForPublicKey(realTxMeta.ProfilePublicKey)
-		txnMeta.CreatorCoinTransferTxindexMetadata = &CreatorCoinTransferTxindexMetadata{
-			CreatorUsername:            string(creatorProfileEntry.Username),
-			CreatorCoinToTransferNanos: realTxMeta.CreatorCoinToTransferNanos,
-		}
-
-		diamondLevelBytes, hasDiamondLevel := txn.ExtraData[DiamondLevelKey]
-		diamondPostHash, hasDiamondPostHash := txn.ExtraData[DiamondPostHashKey]
-		if hasDiamondLevel && hasDiamondPostHash {
-			diamondLevel, bytesRead := Varint(diamondLevelBytes)
-			if bytesRead <= 0 {
-				glog.Errorf("Update TxIndex: Error reading diamond level for txn: %v", txn.Hash().String())
-			} else {
-				txnMeta.CreatorCoinTransferTxindexMetadata.DiamondLevel = diamondLevel
-				txnMeta.CreatorCoinTransferTxindexMetadata.PostHashHex = hex.EncodeToString(diamondPostHash)
-			}
-		}
-
-		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-			PublicKeyBase58Check: PkToString(realTxMeta.ReceiverPublicKey, utxoView.Params),
-			Metadata:             "ReceiverPublicKey",
-		})
-	case TxnTypeUpdateProfile:
-		realTxMeta := txn.TxnMeta.(*UpdateProfileMetadata)
-
-		txnMeta.UpdateProfileTxindexMetadata = &UpdateProfileTxindexMetadata{}
-		if len(realTxMeta.ProfilePublicKey) == btcec.PubKeyBytesLenCompressed {
-			txnMeta.UpdateProfileTxindexMetadata.ProfilePublicKeyBase58Check =
-				PkToString(realTxMeta.ProfilePublicKey, utxoView.Params)
-		}
-		txnMeta.UpdateProfileTxindexMetadata.NewUsername = string(realTxMeta.NewUsername)
-		txnMeta.UpdateProfileTxindexMetadata.NewDescription = string(realTxMeta.NewDescription)
-		txnMeta.UpdateProfileTxindexMetadata.NewProfilePic = string(realTxMeta.NewProfilePic)
-		txnMeta.UpdateProfileTxindexMetadata.NewCreatorBasisPoints = realTxMeta.NewCreatorBasisPoints
-		txnMeta.UpdateProfileTxindexMetadata.NewStakeMultipleBasisPoints = realTxMeta.NewStakeMultipleBasisPoints
-		txnMeta.UpdateProfileTxindexMetadata.IsHidden = realTxMeta.IsHidden
-
-		// Add the ProfilePublicKey to the AffectedPublicKeys
-		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-			PublicKeyBase58Check: PkToString(realTxMeta.ProfilePublicKey, utxoView.Params),
-			Metadata:             "ProfilePublicKeyBase58Check",
-		})
-	case TxnTypeSubmitPost:
-		realTxMeta := txn.TxnMeta.(*SubmitPostMetadata)
-
-		txnMeta.SubmitPostTxindexMetadata = &SubmitPostTxindexMetadata{}
-		if len(realTxMeta.PostHashToModify) == HashSizeBytes {
-			txnMeta.SubmitPostTxindexMetadata.PostHashBeingModifiedHex = hex.EncodeToString(
-				realTxMeta.PostHashToModify)
-		}
-		if len(realTxMeta.ParentStakeID) == HashSizeBytes {
-			txnMeta.SubmitPostTxindexMetadata.ParentPostHashHex = hex.EncodeToString(
-				realTxMeta.ParentStakeID)
-		}
-		// If a post hash didn't get set then the hash of the transaction itself will
-		// end up being used as the post hash so set that here.
-		if txnMeta.SubmitPostTxindexMetadata.PostHashBeingModifiedHex == "" {
-			txnMeta.SubmitPostTxindexMetadata.PostHashBeingModifiedHex =
-				hex.EncodeToString(txn.Hash()[:])
-		}
-
-		// PosterPublicKeyBase58Check = TransactorPublicKeyBase58Check
-
-		// If ParentPostHashHex is set then get the parent posts public key and
-		// mark it as affected. We only check this if PostHashToModify is not set
-		// so we only generate a notification the first time someone comments on your post.
-		// ParentPosterPublicKeyBase58Check is in AffectedPublicKeys
-		if len(realTxMeta.PostHashToModify) == 0 && len(realTxMeta.ParentStakeID) == HashSizeBytes {
-			postHash := &BlockHash{}
-			copy(postHash[:], realTxMeta.ParentStakeID)
-			postEntry := utxoView.GetPostEntryForPostHash(postHash)
-			if postEntry == nil {
-				glog.V(2).Infof(
-					"UpdateTxindex: Error creating SubmitPostTxindexMetadata; "+
-						"missing parent post for hash %v: %v", postHash, err)
-			} else {
-				txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-					PublicKeyBase58Check: PkToString(postEntry.PosterPublicKey, utxoView.Params),
-					Metadata:             "ParentPosterPublicKeyBase58Check",
-				})
-			}
-		}
-
-		// The profiles that are mentioned are in the AffectedPublicKeys
-		// MentionedPublicKeyBase58Check in AffectedPublicKeys. We need to
-		// parse them out of the post and then look up their public keys.
-		//
-		// Start by trying to parse the body JSON
-		bodyObj := &DeSoBodySchema{}
-		if err = json.Unmarshal(realTxMeta.Body, &bodyObj); err != nil {
-			// Don't worry about bad posts unless we're debugging with high verbosity.
-			glog.V(2).Infof("UpdateTxindex: Error parsing post body for @ mentions: "+
-				"%v %v", string(realTxMeta.Body), err)
-		} else {
-			terminators := []rune(" ,.\n&*()-+~'\"[]{}")
-			dollarTagsFound := mention.GetTagsAsUniqueStrings('$', bodyObj.Body, terminators...)
-			atTagsFound := mention.GetTagsAsUniqueStrings('@', bodyObj.Body, terminators...)
-			tagsFound := atTagsFound
-			// We check that cashtag usernames have at least 1 non-numeric character
-			dollarTagRegex := regexp.MustCompile("\\w*[a-zA-Z_]\\w*")
-			for _, dollarTagFound := range dollarTagsFound {
-				if dollarTagRegex.MatchString(dollarTagFound) {
-					tagsFound = append(tagsFound, dollarTagFound)
-				}
-			}
-			for _, tag := range tagsFound {
-				profileFound := utxoView.GetProfileEntryForUsername([]byte(strings.ToLower(tag)))
-				// Don't worry about tags that don't line up to a profile.
-				if profileFound == nil {
-					continue
-				}
-				// If we found a profile then set it as an affected public key.
-				txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-					PublicKeyBase58Check: PkToString(profileFound.PublicKey, utxoView.Params),
-					Metadata:             "MentionedPublicKeyBase58Check",
-				})
-			}
-			// Additionally, we need to check if this post is a repost and
-			// fetch the original poster
-			if repostedPostHash, isRepost := extraData[RepostedPostHash]; isRepost {
-				repostedBlockHash := &BlockHash{}
-				copy(repostedBlockHash[:], repostedPostHash)
-				repostPost := utxoView.GetPostEntryForPostHash(repostedBlockHash)
-				if repostPost != nil {
-					txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-						PublicKeyBase58Check: PkToString(repostPost.PosterPublicKey, utxoView.Params),
-						Metadata:             "RepostedPublicKeyBase58Check",
-					})
-				}
-			}
-		}
-	case TxnTypeLike:
-		realTxMeta := txn.TxnMeta.(*LikeMetadata)
-
-		// LikerPublicKeyBase58Check = TransactorPublicKeyBase58Check
-
-		txnMeta.LikeTxindexMetadata = &LikeTxindexMetadata{
-			IsUnlike:    realTxMeta.IsUnlike,
-			PostHashHex: hex.EncodeToString(realTxMeta.LikedPostHash[:]),
-		}
-
-		// Get the public key of the poster and set it as having been affected
-		// by this like.
-		//
-		// PosterPublicKeyBase58Check in AffectedPublicKeys
-		postHash := &BlockHash{}
-		copy(postHash[:], realTxMeta.LikedPostHash[:])
-		postEntry := utxoView.GetPostEntryForPostHash(postHash)
-		if postEntry == nil {
-			glog.V(2).Infof(
-				"UpdateTxindex: Error creating LikeTxindexMetadata; "+
-					"missing post for hash %v: %v", postHash, err)
-		} else {
-			txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-				PublicKeyBase58Check: PkToString(postEntry.PosterPublicKey, utxoView.Params),
-				Metadata:             "PosterPublicKeyBase58Check",
-			})
-		}
-	case TxnTypeFollow:
-		realTxMeta := txn.TxnMeta.(*FollowMetadata)
-
-		txnMeta.FollowTxindexMetadata = &FollowTxindexMetadata{
-			IsUnfollow: realTxMeta.IsUnfollow,
-		}
-
-		// FollowerPublicKeyBase58Check = TransactorPublicKeyBase58Check
-
-		// FollowedPublicKeyBase58Check in AffectedPublicKeys
-		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-			PublicKeyBase58Check: PkToString(realTxMeta.FollowedPublicKey, utxoView.Params),
-			Metadata:             "FollowedPublicKeyBase58Check",
-		})
-	case TxnTypePrivateMessage:
-		realTxMeta := txn.TxnMeta.(*PrivateMessageMetadata)
-
-		txnMeta.PrivateMessageTxindexMetadata = &PrivateMessageTxindexMetadata{
-			TimestampNanos: realTxMeta.TimestampNanos,
-		}
-
-		// SenderPublicKeyBase58Check = TransactorPublicKeyBase58Check
-
-		// RecipientPublicKeyBase58Check in AffectedPublicKeys
-		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-			PublicKeyBase58Check: PkToString(realTxMeta.RecipientPublicKey, utxoView.Params),
-			Metadata:             "RecipientPublicKeyBase58Check",
-		})
-	case TxnTypeSwapIdentity:
-		realTxMeta := txn.TxnMeta.(*SwapIdentityMetadataa)
-
-		// Rosetta needs to know the current locked deso in each profile so it can model the swap of
-		// the creator coins. Rosetta models a swap identity as two INPUTs and two OUTPUTs effectively
-		// swapping the balances of total deso locked. If no profile exists, from/to is zero.
-		fromNanos := uint64(0)
-		fromProfile := utxoView.GetProfileEntryForPublicKey(realTxMeta.FromPublicKey)
-		if fromProfile != nil {
-			fromNanos = fromProfile.CreatorCoinEntry.DeSoLockedNanos
-		}
-
-		toNanos := uint64(0)
-		toProfile := utxoView.GetProfileEntryForPublicKey(realTxMeta.ToPublicKey)
-		if toProfile != nil {
-			toNanos = toProfile.CreatorCoinEntry.DeSoLockedNanos
-		}
-
-		txnMeta.SwapIdentityTxindexMetadata = &SwapIdentityTxindexMetadata{
-			FromPublicKeyBase58Check: PkToString(realTxMeta.FromPublicKey, utxoView.Params),
-			ToPublicKeyBase58Check:   PkToString(realTxMeta.ToPublicKey, utxoView.Params),
-			FromDeSoLockedNanos:      fromNanos,
-			ToDeSoLockedNanos:        toNanos,
-		}
-
-		// The to and from public keys are affected by this.
-
-		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-			PublicKeyBase58Check: PkToString(realTxMeta.FromPublicKey, utxoView.Params),
-			Metadata:             "FromPublicKeyBase58Check",
-		})
-		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-			PublicKeyBase58Check: PkToString(realTxMeta.ToPublicKey, utxoView.Params),
-			Metadata:             "ToPublicKeyBase58Check",
-		})
-	case TxnTypeNFTBid:
-		realTxMeta := txn.TxnMeta.(*NFTBidMetadata)
-
-		isBuyNow := false
-
-		utxoOp := utxoOps[len(utxoOps)-1]
-		var nftRoyaltiesMetadata NFTRoyaltiesMetadata
-		var ownerPublicKeyBase58Check string
-		var creatorPublicKeyBase58Check string
-		// We don't send notifications for standing offers.
-		if realTxMeta.SerialNumber != 0 {
-			nftKey := MakeNFTKey(realTxMeta.NFTPostHash, realTxMeta.SerialNumber)
-			nftEntry := utxoView.GetNFTEntryForNFTKey(&nftKey)
-			postEntry := utxoView.GetPostEntryForPostHash(nftEntry.NFTPostHash)
-
-			creatorPublicKeyBase58Check = PkToString(postEntry.PosterPublicKey, utxoView.Params)
-			ownerAtTimeOfBid := nftEntry.OwnerPKID
-
-			if utxoOp.PrevNFTEntry != nil && utxoOp.PrevNFTEntry.IsBuyNow {
-				isBuyNow = true
-				ownerAtTimeOfBid = utxoOp.PrevNFTEntry.OwnerPKID
-			}
-
-			ownerPublicKeyBase58Check = PkToString(utxoView.GetPublicKeyForPKID(ownerAtTimeOfBid), utxoView.Params)
-
-			txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-				PublicKeyBase58Check: ownerPublicKeyBase58Check,
-				Metadata:             "NFTOwnerPublicKeyBase58Check",
-			})
-
-			if isBuyNow {
-				nftRoyaltiesMetadata = NFTRoyaltiesMetadata{
-					CreatorCoinRoyaltyNanos:     utxoOp.NFTBidCreatorRoyaltyNanos,
-					CreatorRoyaltyNanos:         utxoOp.NFTBidCreatorDESORoyaltyNanos,
-					CreatorPublicKeyBase58Check: creatorPublicKeyBase58Check,
-					AdditionalCoinRoyaltiesMap: pubKeyRoyaltyPairToBase58CheckToRoyaltyNanosMap(
-						utxoOp.NFTBidAdditionalCoinRoyalties, utxoView.Params),
-					AdditionalDESORoyaltiesMap: pubKeyRoyaltyPairToBase58CheckToRoyaltyNanosMap(
-						utxoOp.NFTBidAdditionalDESORoyalties, utxoView.Params),
-				}
-			}
-		}
-
-		txnMeta.NFTBidTxindexMetadata = &NFTBidTxindexMetadata{
-			NFTPostHashHex:            hex.EncodeToString(realTxMeta.NFTPostHash[:]),
-			SerialNumber:              realTxMeta.SerialNumber,
-			BidAmountNanos:            realTxMeta.BidAmountNanos,
-			IsBuyNowBid:               isBuyNow,
-			NFTRoyaltiesMetadata:      &nftRoyaltiesMetadata,
-			OwnerPublicKeyBase58Check: ownerPublicKeyBase58Check,
-		}
-
-		if isBuyNow {
-			txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-				PublicKeyBase58Check: creatorPublicKeyBase58Check,
-				Metadata:             "NFTCreatorPublicKeyBase58Check",
-			})
-
-			for pubKeyIter, amountNanos := range txnMeta.NFTBidTxindexMetadata.NFTRoyaltiesMetadata.AdditionalCoinRoyaltiesMap {
-				pubKey := pubKeyIter
-				// Skip affected pub key if no royalty received
-				if amountNanos == 0 {
-					continue
-				}
-				txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-					PublicKeyBase58Check: pubKey,
-					Metadata:             "AdditionalNFTRoyaltyToCreatorPublicKeyBase58Check",
-				})
-			}
-
-			for pubKeyIter, amountNanos := range txnMeta.NFTBidTxindexMetadata.NFTRoyaltiesMetadata.AdditionalDESORoyaltiesMap {
-				pubKey := pubKeyIter
-				// Skip affected pub key if no royalty received
-				if amountNanos == 0 {
-					continue
-				}
-				txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-					PublicKeyBase58Check: pubKey,
-					Metadata:             "AdditionalNFTRoyaltyToCoinPublicKeyBase58Check",
-				})
-			}
-		}
-	case TxnTypeAcceptNFTBid:
-		realTxMeta := txn.TxnMeta.(*AcceptNFTBidMetadata)
-
-		utxoOp := utxoOps[len(utxoOps)-1]
-		creatorPublicKeyBase58Check := PkToString(utxoOp.PrevPostEntry.PosterPublicKey, utxoView.Params)
-
-		txnMeta.AcceptNFTBidTxindexMetadata = &AcceptNFTBidTxindexMetadata{
-			NFTPostHashHex: hex.EncodeToString(realTxMeta.NFTPostHash[:]),
-			SerialNumber:   realTxMeta.SerialNumber,
-			BidAmountNanos: realTxMeta.BidAmountNanos,
-			NFTRoyaltiesMetadata: &NFTRoyaltiesMetadata{
-				CreatorCoinRoyaltyNanos:     utxoOp.AcceptNFTBidCreatorRoyaltyNanos,
-				CreatorRoyaltyNanos:         utxoOp.AcceptNFTBidCreatorDESORoyaltyNanos,
-				CreatorPublicKeyBase58Check: creatorPublicKeyBase58Check,
-				AdditionalCoinRoyaltiesMap: pubKeyRoyaltyPairToBase58CheckToRoyaltyNanosMap(
-					utxoOp.AcceptNFTBidAdditionalCoinRoyalties, utxoView.Params),
-				AdditionalDESORoyaltiesMap: pubKeyRoyaltyPairToBase58CheckToRoyaltyNanosMap(
-					utxoOp.AcceptNFTBidAdditionalDESORoyalties, utxoView.Params),
-			},
-		}
-
-		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-			PublicKeyBase58Check: PkToString(utxoView.GetPublicKeyForPKID(realTxMeta.BidderPKID), utxoView.Params),
-			Metadata:             "NFTBidderPublicKeyBase58Check",
-		})
-
-		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-			PublicKeyBase58Check: creatorPublicKeyBase58Check,
-			Metadata:             "NFTCreatorPublicKeyBase58Check",
-		})
-
-		for pubKeyIter, amountNanos := range txnMeta.AcceptNFTBidTxindexMetadata.NFTRoyaltiesMetadata.AdditionalCoinRoyaltiesMap {
-			pubKey := pubKeyIter
-			// Skip affected pub key if no royalty received
-			if amountNanos == 0 {
-				continue
-			}
-			txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-				PublicKeyBase58Check: pubKey,
-				Metadata:             "AdditionalNFTRoyaltyToCreatorPublicKeyBase58Check",
-			})
-		}
-
-		for pubKeyIter, amountNanos := range txnMeta.AcceptNFTBidTxindexMetadata.NFTRoyaltiesMetadata.AdditionalDESORoyaltiesMap {
-			pubKey := pubKeyIter
-			// Skip affected pub key if no royalty received
-			if amountNanos == 0 {
-				continue
-			}
-			txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-				PublicKeyBase58Check: pubKey,
-				Metadata:             "AdditionalNFTRoyaltyToCoinPublicKeyBase58Check",
-			})
-		}
-	case TxnTypeCreateNFT:
-		realTxMeta := txn.TxnMeta.(*CreateNFTMetadata)
-
-		postEntry := utxoView.GetPostEntryForPostHash(realTxMeta.NFTPostHash)
-
-		additionalDESORoyaltiesMap := pkidRoyaltyMapToBase58CheckToRoyaltyMap(
-			postEntry.AdditionalNFTRoyaltiesToCreatorsBasisPoints, utxoView)
-		additionalCoinRoyaltiesMap := pkidRoyaltyMapToBase58CheckToRoyaltyMap(
-			postEntry.AdditionalNFTRoyaltiesToCoinsBasisPoints, utxoView)
-		txnMeta.CreateNFTTxindexMetadata = &CreateNFTTxindexMetadata{
-			NFTPostHashHex:             hex.EncodeToString(realTxMeta.NFTPostHash[:]),
-			AdditionalDESORoyaltiesMap: additionalDESORoyaltiesMap,
-			AdditionalCoinRoyaltiesMap: additionalCoinRoyaltiesMap,
-		}
-		for pubKeyIter := range additionalDESORoyaltiesMap {
-			pubKey := pubKeyIter
-			txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-				PublicKeyBase58Check: pubKey,
-				Metadata:             "AdditionalNFTRoyaltyToCreatorPublicKeyBase58Check",
-			})
-		}
-		for pubKeyIter := range additionalCoinRoyaltiesMap {
-			pubKey := pubKeyIter
-			txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-				PublicKeyBase58Check: pubKey,
-				Metadata:             "AdditionalNFTRoyaltyToCoinPublicKeyBase58Check",
-			})
-		}
-	case TxnTypeUpdateNFT:
-		realTxMeta := txn.TxnMeta.(*UpdateNFTMetadata)
-
-		postEntry := utxoView.GetPostEntryForPostHash(realTxMeta.NFTPostHash)
-
-		additionalDESORoyaltiesMap := pkidRoyaltyMapToBase58CheckToRoyaltyMap(
-			postEntry.AdditionalNFTRoyaltiesToCreatorsBasisPoints, utxoView)
-		additionalCoinRoyaltiesMap := pkidRoyaltyMapToBase58CheckToRoyaltyMap(
-			postEntry.AdditionalNFTRoyaltiesToCoinsBasisPoints, utxoView)
-		txnMeta.UpdateNFTTxindexMetadata = &UpdateNFTTxindexMetadata{
-			NFTPostHashHex: hex.EncodeToString(realTxMeta.NFTPostHash[:]),
-			IsForSale:      realTxMeta.IsForSale,
-		}
-		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-			PublicKeyBase58Check: PkToString(postEntry.PosterPublicKey, utxoView.Params),
-			Metadata:             "NFTCreatorPublicKeyBase58Check",
-		})
-		for pubKeyIter := range additionalDESORoyaltiesMap {
-			pubKey := pubKeyIter
-			txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-				PublicKeyBase58Check: pubKey,
-				Metadata:             "AdditionalNFTRoyaltyToCreatorPublicKeyBase58Check",
-			})
-		}
-		for pubKeyIter := range additionalCoinRoyaltiesMap {
-			pubKey := pubKeyIter
-			txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-				PublicKeyBase58Check: pubKey,
-				Metadata:             "AdditionalNFTRoyaltyToCoinPublicKeyBase58Check",
-			})
-		}
-	case TxnTypeNFTTransfer:
-		realTxMeta := txn.TxnMeta.(*NFTTransferMetadata)
-
-		txnMeta.NFTTransferTxindexMetadata = &NFTTransferTxindexMetadata{
-			NFTPostHashHex: hex.EncodeToString(realTxMeta.NFTPostHash[:]),
-			SerialNumber:   realTxMeta.SerialNumber,
-		}
-
-		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-			PublicKeyBase58Check: PkToString(realTxMeta.ReceiverPublicKey, utxoView.Params),
-			Metadata:             "NFTTransferRecipientPublicKeyBase58Check",
-		})
-	case TxnTypeAcceptNFTTransfer:
-		realTxMeta := txn.TxnMeta.(*AcceptNFTTransferMetadata)
-
-		txnMeta.AcceptNFTTransferTxindexMetadata = &AcceptNFTTransferTxindexMetadata{
-			NFTPostHashHex: hex.EncodeToString(realTxMeta.NFTPostHash[:]),
-			SerialNumber:   realTxMeta.SerialNumber,
-		}
-	case TxnTypeBurnNFT:
-		realTxMeta := txn.TxnMeta.(*BurnNFTMetadata)
-
-		txnMeta.BurnNFTTxindexMetadata = &BurnNFTTxindexMetadata{
-			NFTPostHashHex: hex.EncodeToString(realTxMeta.NFTPostHash[:]),
-			SerialNumber:   realTxMeta.SerialNumber,
-		}
-	case TxnTypeBasicTransfer:
-		diamondLevelBytes, hasDiamondLevel := txn.ExtraData[DiamondLevelKey]
-		diamondPostHash, hasDiamondPostHash := txn.ExtraData[DiamondPostHashKey]
-		if hasDiamondLevel && hasDiamondPostHash {
-			diamondLevel, bytesRead := Varint(diamondLevelBytes)
-			if bytesRead <= 0 {
-				glog.Errorf("Update TxIndex: Error reading diamond level for txn: %v", txn.Hash().String())
-			} else {
-				txnMeta.BasicTransferTxindexMetadata.DiamondLevel = diamondLevel
-				txnMeta.BasicTransferTxindexMetadata.PostHashHex = hex.EncodeToString(diamondPostHash)
-			}
-		}
-	case TxnTypeDAOCoin:
-		realTxMeta := txn.TxnMeta.(*DAOCoinMetadata)
-		creatorProfileEntry := utxoView.GetProfileEntryForPublicKey(realTxMeta.ProfilePublicKey)
-
-		var metadata string
-		var operationString string
-		switch realTxMeta.OperationType {
-		case DAOCoinOperationTypeMint:
-			metadata = "DAOCoinMintPublicKeyBase58Check"
-			operationString = "mint"
-		case DAOCoinOperationTypeBurn:
-			metadata = "DAOCoinBurnPublicKeyBase58Check"
-			operationString = "burn"
-		case DAOCoinOperationTypeDisableMinting:
-			metadata = "DAOCoinDisableMintingPublicKeyBase58Check"
-			operationString = "disable_minting"
-		case DAOCoinOperationTypeUpdateTransferRestrictionStatus:
-			metadata = "DAOCoinUpdateTransferRestrictionStatus"
-			operationString = "update_transfer_restriction_status"
-		}
-
-		txnMeta.DAOCoinTxindexMetadata = &DAOCoinTxindexMetadata{
-			CreatorUsername:           string(creatorProfileEntry.Username),
-			OperationType:             operationString,
-			CoinsToMintNanos:          &realTxMeta.CoinsToMintNanos,
-			CoinsToBurnNanos:          &realTxMeta.CoinsToBurnNanos,
-			TransferRestrictionStatus: realTxMeta.TransferRestrictionStatus.String(),
-		}
-
-		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-			PublicKeyBase58Check: PkToString(creatorProfileEntry.PublicKey, utxoView.Params),
-			Metadata:             metadata,
-		})
-	case TxnTypeDAOCoinTransfer:
-		realTxMeta := txn.TxnMeta.(*DAOCoinTransferMetadata)
-		creatorProfileEntry := utxoView.GetProfileEntryForPublicKey(realTxMeta.ProfilePublicKey)
-		txnMeta.DAOCoinTransferTxindexMetadata = &DAOCoinTransferTxindexMetadata{
-			CreatorUsername:        string(creatorProfileEntry.Username),
-			DAOCoinToTransferNanos: realTxMeta.DAOCoinToTransferNanos,
-		}
-
-		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-			PublicKeyBase58Check: PkToString(realTxMeta.ReceiverPublicKey, utxoView.Params),
-			Metadata:             "ReceiverPublicKey",
-		})
-	case TxnTypeDAOCoinLimitOrder:
-		realTxMeta := txn.TxnMeta.(*DAOCoinLimitOrderMetadata)
-
-		// We only update the mempool if the transactor submitted a new
-		// order. Not if the transactor cancelled an existing order.
-		if realTxMeta.CancelOrderID != nil {
-			break
-		}
-
-		if !realTxMeta.BuyingDAOCoinCreatorPublicKey.IsZeroPublicKey() {
-			txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-				PublicKeyBase58Check: PkToString(realTxMeta.BuyingDAOCoinCreatorPublicKey.ToBytes(), utxoView.Params),
-				Metadata:             "BuyingDAOCoinCreatorPublicKey",
-			})
-		}
-
-		if !realTxMeta.SellingDAOCoinCreatorPublicKey.IsZeroPublicKey() {
-			txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-				PublicKeyBase58Check: PkToString(realTxMeta.SellingDAOCoinCreatorPublicKey.ToBytes(), utxoView.Params),
-				Metadata:             "SellingDAOCoinCreatorPublicKey",
-			})
-		}
-
-		utxoOp := utxoOps[len(utxoOps)-1]
-		uniquePKIDMap := make(map[PKID]bool)
-		fulfilledOrderMetadata := []*FilledDAOCoinLimitOrderMetadata{}
-		for _, filledOrder := range utxoOp.FilledDAOCoinLimitOrders {
-			uniquePKIDMap[*filledOrder.TransactorPKID] = true
-			fulfilledOrderMetadata = append(fulfilledOrderMetadata, &FilledDAOCoinLimitOrderMetadata{
-				TransactorPublicKeyBase58Check: PkToString(
-					utxoView.GetPublicKeyForPKID(filledOrder.TransactorPKID), utxoView.Params),
-				BuyingDAOCoinCreatorPublicKey: PkToString(
-					utxoView.GetPublicKeyForPKID(filledOrder.BuyingDAOCoinCreatorPKID), utxoView.Params),
-				SellingDAOCoinCreatorPublicKey: PkToString(
-					utxoView.GetPublicKeyForPKID(filledOrder.SellingDAOCoinCreatorPKID), utxoView.Params),
-				CoinQuantityInBaseUnitsBought: filledOrder.CoinQuantityInBaseUnitsBought,
-				CoinQuantityInBaseUnitsSold:   filledOrder.CoinQuantityInBaseUnitsSold,
-				IsFulfilled:                   filledOrder.IsFulfilled,
-			})
-		}
-
-		for uniquePKID := range uniquePKIDMap {
-			txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
-				PublicKeyBase58Check: PkToString(utxoView.GetPublicKeyForPKID(&uniquePKID), utxoView.Params),
-				Metadata:             "FilledOrderPublicKey",
-			})
-		}
-
-		txnMeta.DAOCoinLimitOrderTxindexMetadata = &DAOCoinLimitOrderTxindexMetadata{
-			FilledDAOCoinLimitOrdersMetadata: fulfilledOrderMetadata,
-			BuyingDAOCoinCreatorPublicKey: PkToString(
-				realTxMeta.BuyingDAOCoinCreatorPublicKey.ToBytes(), utxoView.Params),
-			SellingDAOCoinCreatorPublicKey: PkToString(
-				realTxMeta.SellingDAOCoinCreatorPublicKey.ToBytes(), utxoView.Params),
-			ScaledExchangeRateCoinsToSellPerCoinToBuy: realTxMeta.ScaledExchangeRateCoinsToSellPerCoinToBuy,
-			QuantityToFillInBaseUnits:                 realTxMeta.QuantityToFillInBaseUnits,
-		}
-
+	// Everything beyond this point is specific to the txn type, so we hand off to
+	// the extractor registered for it, if any.
+	extractor := GetTransactionMetadataExtractor(txn.TxnMeta.GetTxnType())
+	if extractor == nil {
+		return txnMeta
+	}
+	blockCtx := &TransactionMetadataBlockContext{
+		BlockHash:                 blockHash,
+		BlockHeight:               blockHeight,
+		TxnIndexInBlock:           txnIndexInBlock,
+		UtxoOps:                   utxoOps,
+		TotalNanosPurchasedBefore: totalNanosPurchasedBefore,
+		USDCentsPerBitcoinBefore:  usdCentsPerBitcoinBefore,
+	}
+	if err := extractor.ComputeMetadata(utxoView, txn, blockCtx, txnMeta); err != nil {
+		glog.Errorf("ComputeTransactionMetadata: Problem computing %v metadata for txn %v: %v",
+			txn.TxnMeta.GetTxnType(), txn.Hash(), err)
 	}
 	return txnMeta
 }
@@ -2131,20 +1733,26 @@ func (mp *DeSoMempool) ProcessTransaction(tx *MsgDeSoTxn, allowUnconnectedTxn bo
 	mp.mtx.Lock()
 	defer mp.mtx.Unlock()
 
-	return mp.processTransaction(tx, allowUnconnectedTxn, rateLimit, peerID, verifySignatures)
+	acceptedTxs, err := mp.processTransaction(tx, allowUnconnectedTxn, rateLimit, peerID, verifySignatures)
+	// Duplicates are expected, since peers relay the same txns to us, so we don't log them.
+	if err != nil && errors.Cause(err) != TxErrorDuplicate {
+		mp.bc.ValidationFailureLog().RecordTxnFailure(tx.Hash(), uint64(mp.bc.blockTip().Height+1), peerID, err)
+	}
+	return acceptedTxs, err
 }
 
 // Returns an estimate of the number of txns in the mempool. This is an estimate because
 // it looks up the number from a readOnly view, which updates at regular intervals and
 // *not* every time a txn is added to the pool.
 func (mp *DeSoMempool) Count() int {
-	return len(mp.readOnlyUniversalTransactionList)
+	txnList, _ := mp.getReadOnlyUniversalTransactions()
+	return len(txnList)
 }
 
 // Returns the hashes of all the txns in the pool using the readOnly view, which could be
 // slightly out of date.
 func (mp *DeSoMempool) TxHashes() []*BlockHash {
-	poolMap := mp.readOnlyUniversalTransactionMap
+	_, poolMap := mp.getReadOnlyUniversalTransactions()
 	hashes := make([]*BlockHash, len(poolMap))
 	ii := 0
 	for hash := range poolMap {
@@ -2158,7 +1766,7 @@ func (mp *DeSoMempool) TxHashes() []*BlockHash {
 
 // Returns all MempoolTxs from the readOnly view.
 func (mp *DeSoMempool) MempoolTxs() []*MempoolTx {
-	poolMap := mp.readOnlyUniversalTransactionMap
+	_, poolMap := mp.getReadOnlyUniversalTransactions()
 	descs := make([]*MempoolTx, len(poolMap))
 	i := 0
 	for _, desc := range poolMap {
@@ -2170,7 +1778,7 @@ func (mp *DeSoMempool) MempoolTxs() []*MempoolTx {
 }
 
 func (mp *DeSoMempool) GetMempoolSummaryStats() (_summaryStatsMap map[string]*SummaryStats) {
-	allTxns := mp.readOnlyUniversalTransactionList
+	allTxns, _ := mp.getReadOnlyUniversalTransactions()
 
 	transactionSummaryStats := make(map[string]*SummaryStats)
 	for _, mempoolTx := range allTxns {
@@ -2301,10 +1909,6 @@ func (mp *DeSoMempool) regenerateReadOnlyView() error {
 		return fmt.Errorf("Error generating readOnlyUtxoView: %v", err)
 	}
 
-	// Update the view and bump the sequence number. This is how callers will
-	// know that the view was updated.
-	mp.readOnlyUtxoView = newView
-
 	newTxnList := []*MempoolTx{}
 	txMap := make(map[BlockHash]*MempoolTx)
 	for _, mempoolTx := range mp.universalTransactionList {
@@ -2312,8 +1916,13 @@ func (mp *DeSoMempool) regenerateReadOnlyView() error {
 		txMap[*mempoolTx.Hash] = mempoolTx
 	}
 
+	// Update the view and bump the sequence number. This is how callers will
+	// know that the view was updated.
+	mp.readOnlyMtx.Lock()
+	mp.readOnlyUtxoView = newView
 	mp.readOnlyUniversalTransactionList = newTxnList
 	mp.readOnlyUniversalTransactionMap = txMap
+	mp.readOnlyMtx.Unlock()
 
 	atomic.AddInt64(&mp.readOnlyUtxoViewSequenceNumber, 1)
 	return nil
@@ -2391,13 +2000,22 @@ func (mp *DeSoMempool) LoadTxnsFromDB() {
 	defer tempMempoolDB.Close()
 
 	// Get all saved mempool transactions from the DB.
-	dbMempoolTxnsOrderedByTime, err := DbGetAllMempoolTxnsSortedByTimeAdded(tempMempoolDB)
+	dbMempoolTxnsOrderedByTime, err := DbGetAllMempoolTxDbRecordsSortedByTimeAdded(tempMempoolDB)
 	if err != nil {
 		log.Fatalf("NewDeSoMempool: Failed to get mempoolTxs from the DB: %v", err)
 	}
 
-	for _, mempoolTxn := range dbMempoolTxnsOrderedByTime {
-		_, err := mp.processTransaction(mempoolTxn, false, false, 0, false)
+	// Txns that were validated against the block we're about to validate them against again
+	// already had their signatures checked, so we can skip that part. Everything else gets
+	// validated from scratch.
+	nextBlockHeight := uint64(mp.bc.blockTip().Height + 1)
+	numFastRestored := 0
+	for _, record := range dbMempoolTxnsOrderedByTime {
+		verifySignatures := !record.IsValidated || record.ValidatedBlockHeight != nextBlockHeight
+		if !verifySignatures {
+			numFastRestored++
+		}
+		_, err := mp.processTransaction(record.Tx, false, false, 0, verifySignatures)
 		if err != nil {
 			// Log errors but don't stop adding transactions. We do this because we'd prefer
 			// to drop a transaction here or there rather than lose the whole block because
@@ -2407,7 +2025,20 @@ func (mp *DeSoMempool) LoadTxnsFromDB() {
 		}
 	}
 	endTime := time.Now()
-	glog.Infof("LoadTxnsFromDB: Loaded %v txns in %v seconds", len(dbMempoolTxnsOrderedByTime), endTime.Sub(startTime).Seconds())
+	glog.Infof("LoadTxnsFromDB: Loaded %v txns (%v without verifying signatures) in %v seconds",
+		len(dbMempoolTxnsOrderedByTime), numFastRestored, endTime.Sub(startTime).Seconds())
+}
+
+// EstimateFeeRate returns the fee rate in nanos per KB that a txn should pay to be mined
+// within targetBlocks blocks. It's never below the network's minimum fee rate.
+func (mp *DeSoMempool) EstimateFeeRate(targetBlocks int) uint64 {
+	feeRate := mp.feeEstimator.EstimateFeeRate(targetBlocks)
+	if readOnlyUtxoView := mp.getReadOnlyUtxoView(); readOnlyUtxoView != nil &&
+		readOnlyUtxoView.GlobalParamsEntry.MinimumNetworkFeeNanosPerKB > feeRate {
+
+		feeRate = readOnlyUtxoView.GlobalParamsEntry.MinimumNetworkFeeNanosPerKB
+	}
+	return feeRate
 }
 
 func (mp *DeSoMempool) Stop() {
@@ -2433,6 +2064,7 @@ func NewDeSoMempool(_bc *Blockchain, _rateLimitFeerateNanosPerKB uint64,
 		unconnectedTxnsByPrev:           make(map[UtxoKey]map[BlockHash]*MsgDeSoTxn),
 		outpoints:                       make(map[UtxoKey]*MsgDeSoTxn),
 		pubKeyToTxnMap:                  make(map[PkMapKey]map[BlockHash]*MempoolTx),
+		numFilteredTxnsByType:           make(map[TxnType]uint64),
 		blockCypherAPIKey:               _blockCypherAPIKey,
 		backupUniversalUtxoView:         backupUtxoView,
 		universalUtxoView:               utxoView,
@@ -2442,6 +2074,7 @@ func NewDeSoMempool(_bc *Blockchain, _rateLimitFeerateNanosPerKB uint64,
 		readOnlyUniversalTransactionMap: make(map[BlockHash]*MempoolTx),
 		readOnlyOutpoints:               make(map[UtxoKey]*MsgDeSoTxn),
 		dataDir:                         _dataDir,
+		feeEstimator:                    NewFeeEstimator(_minFeerateNanosPerKB, _bc.params.MinerMaxBlockSizeBytes),
 	}
 
 	if newPool.mempoolDir != "" {
@@ -1,9 +1,14 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
 	"fmt"
+	"path/filepath"
+	"sync"
 	"testing"
 
+	"github.com/dgraph-io/badger/v3"
 	"github.com/stretchr/testify/require"
 )
 
@@ -96,6 +101,83 @@ func TestMempoolLongChainOfDependencies(t *testing.T) {
 	_, _ = require, senderPkBytes
 }
 
+func TestMempoolTxnDumpFastRestore(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, recipientPkBytes := _setupFiveBlocks(t)
+	nextBlockHeight := uint64(chain.blockTip().Height + 1)
+
+	newMempool := func(mempoolDir string) *DeSoMempool {
+		return NewDeSoMempool(
+			chain, 0, /* rateLimitFeeRateNanosPerKB */
+			0 /* minFeeRateNanosPerKB */, "", false,
+			"" /*dataDir*/, mempoolDir)
+	}
+
+	// Add a signed txn and an unsigned txn that spends it to the mempool.
+	signedTxn := _assembleBasicTransferTxnFullySigned(t, chain, 100, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	unsignedTxn := &MsgDeSoTxn{
+		TxInputs:  []*DeSoInput{{TxID: *signedTxn.Hash(), Index: 0}},
+		TxOutputs: []*DeSoOutput{{PublicKey: recipientPkBytes, AmountNanos: 1}},
+		TxnMeta:   &BasicTransferMetadata{},
+		PublicKey: recipientPkBytes,
+	}
+	mp := newMempool("")
+	for _, txn := range []*MsgDeSoTxn{signedTxn, unsignedTxn} {
+		_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, false /*verifySignatures*/)
+		require.NoError(err)
+	}
+	require.NoError(mp.RegenerateReadOnlyView())
+
+	// The dump records the fee and validation metadata along with the txns.
+	mp.mempoolDir = t.TempDir()
+	mp.DumpTxnsToDB()
+	latestDumpDir := filepath.Join(mp.mempoolDir, "latest_mempool_dump")
+	dumpDb, err := badger.Open(PerformanceBadgerOptions(latestDumpDir))
+	require.NoError(err)
+	records, err := DbGetAllMempoolTxDbRecordsSortedByTimeAdded(dumpDb)
+	require.NoError(err)
+	require.Len(records, 2)
+	for ii, txn := range []*MsgDeSoTxn{signedTxn, unsignedTxn} {
+		mempoolTx := mp.poolMap[*txn.Hash()]
+		require.Equal(txn.Hash(), records[ii].Tx.Hash())
+		require.Equal(mempoolTx.Fee, records[ii].Fee)
+		require.Equal(mempoolTx.FeePerKB, records[ii].FeePerKB)
+		require.True(records[ii].IsValidated)
+		require.Equal(nextBlockHeight, records[ii].ValidatedBlockHeight)
+	}
+
+	// Txns validated at the current height are restored without checking their signatures.
+	require.NoError(dumpDb.Close())
+	require.Equal(2, newMempool(mp.mempoolDir).Count())
+
+	// Txns validated at another height are validated from scratch, so the unsigned one is dropped.
+	dumpDb, err = badger.Open(PerformanceBadgerOptions(latestDumpDir))
+	require.NoError(err)
+	require.NoError(FlushMempoolToDb(dumpDb, nil, nextBlockHeight-1, mp.readOnlyUniversalTransactionList))
+	require.NoError(dumpDb.Close())
+	require.Equal(1, newMempool(mp.mempoolDir).Count())
+
+	// Legacy dumps only have the txn bytes, so their txns are also validated from scratch.
+	legacyDumpDir := t.TempDir()
+	legacyDb, err := badger.Open(PerformanceBadgerOptions(legacyDumpDir))
+	require.NoError(err)
+	for _, mempoolTx := range mp.readOnlyUniversalTransactionList {
+		txnBytes, err := mempoolTx.Tx.ToBytes(false /*preSignature*/)
+		require.NoError(err)
+		require.NoError(legacyDb.Update(func(txn *badger.Txn) error {
+			return txn.Set(_dbKeyForMempoolTxn(mempoolTx), txnBytes)
+		}))
+	}
+	records, err = DbGetAllMempoolTxDbRecordsSortedByTimeAdded(legacyDb)
+	require.NoError(err)
+	require.Len(records, 2)
+	require.Equal(signedTxn.Hash(), records[0].Tx.Hash())
+	require.False(records[0].IsValidated)
+	require.NoError(legacyDb.Close())
+}
+
 // Create a chain of transactions with zero fees. Have one public key just
 // send 1 DeSo to itself over and over again. Then run all the txns
 // through the mempool at once and verify that they are rejected when
@@ -422,3 +504,112 @@ func TestMempoolAugmentedUtxoViewTransactionChain(t *testing.T) {
 
 	_, _, _, _, _ = mempoolTx1, mempoolTx2, mempoolTx3, mempoolTx4, params
 }
+
+func TestMempoolReadOnlyUniversalView(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, recipientPkBytes := _setupFiveBlocks(t)
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 1, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	txn1Hash := txn1.Hash()
+
+	mp := NewDeSoMempool(
+		chain, 0, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", true,
+		"" /*dataDir*/, "")
+
+	// Read from read-only views while the txn is processed, which shouldn't need the
+	// mempool's lock.
+	done := make(chan struct{})
+	readerErrs := make(chan error, 4)
+	var wg sync.WaitGroup
+	for ii := 0; ii < cap(readerErrs); ii++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				utxoView, err := mp.GetReadOnlyUniversalView()
+				if err == nil {
+					_, err = utxoView.GetUnspentUtxoEntrysForPublicKey(recipientPkBytes)
+				}
+				if err != nil {
+					readerErrs <- err
+					return
+				}
+			}
+		}()
+	}
+	_, err := mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	close(done)
+	wg.Wait()
+	close(readerErrs)
+	for readerErr := range readerErrs {
+		require.NoError(readerErr)
+	}
+
+	// The read-only view has the recipient's payment.
+	utxoView, err := mp.GetReadOnlyUniversalView()
+	require.NoError(err)
+	utxoEntries, err := utxoView.GetUnspentUtxoEntrysForPublicKey(recipientPkBytes)
+	require.NoError(err)
+	require.Equal(1, len(utxoEntries))
+	require.Equal(*txn1Hash, utxoEntries[0].UtxoKey.TxID)
+
+	// It can't be used to connect or flush anything.
+	txn2 := _assembleBasicTransferTxnFullySigned(t, chain, 1, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	blockHeight := chain.blockTip().Height + 1
+	_, _, _, _, err = utxoView.ConnectTransaction(
+		txn2, txn2.Hash(), 0, blockHeight, true /*verifySignatures*/, false /*ignoreUtxos*/)
+	require.Equal(ErrUtxoViewReadOnly, err)
+	require.Equal(ErrUtxoViewReadOnly, utxoView.FlushToDb(uint64(blockHeight)))
+
+	// Whereas a copy of the augmented view can.
+	augmentedView, err := mp.GetAugmentedUniversalView()
+	require.NoError(err)
+	_, _, _, _, err = augmentedView.ConnectTransaction(
+		txn2, txn2.Hash(), 0, blockHeight, true /*verifySignatures*/, false /*ignoreUtxos*/)
+	require.NoError(err)
+}
+
+func TestMempoolFilteredTxnTypes(t *testing.T) {
+	require := require.New(t)
+
+	txnTypes, err := ParseTxnTypes([]string{"SUBMIT_POST", "like", "2"})
+	require.NoError(err)
+	require.Equal([]TxnType{TxnTypeSubmitPost, TxnTypeLike, TxnTypeBasicTransfer}, txnTypes)
+	for _, txnTypeString := range []string{"NOT_A_TXN_TYPE", "0", "1000"} {
+		_, err = ParseTxnTypes([]string{txnTypeString})
+		require.Error(err, txnTypeString)
+	}
+
+	chain, _, senderPkBytes, _ := _setupFiveBlocks(t)
+	mp := NewDeSoMempool(
+		chain, 0, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", true,
+		"" /*dataDir*/, "")
+	mp.SetFilteredTxnTypes([]TxnType{TxnTypeSubmitPost})
+
+	// Txns of filtered types are rejected and counted, and other txns are accepted as usual.
+	for ii := 0; ii < 2; ii++ {
+		postTxn, _, _, _, err := chain.CreateSubmitPostTxn(senderPkBytes, nil, nil, []byte("post"), nil,
+			false, uint64(ii+1), nil, false, 0, mp, nil)
+		require.NoError(err)
+		_signTxn(t, postTxn, senderPrivString)
+		_, err = mp.processTransaction(postTxn, false /*allowUnconnectedTxn*/, true /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.Error(err)
+		require.Contains(err.Error(), TxErrorTxnTypeFiltered)
+	}
+	transferTxn := _assembleBasicTransferTxnFullySigned(t, chain, 1, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	_, err = mp.processTransaction(transferTxn, false /*allowUnconnectedTxn*/, true /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(1, len(mp.poolMap))
+	require.Equal(map[TxnType]uint64{TxnTypeSubmitPost: 2}, mp.GetNumFilteredTxnsByType())
+}
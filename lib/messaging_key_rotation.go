@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Messaging clients rotate a group's messaging key by generating a new key and wrapping it for each
+// member, i.e. encrypting it to the member's messaging key. Members need the key of every epoch to
+// read the messages that were sent during it, so rotations are kept as an append-only history per
+// owner and group key name. Each rotation must be for the group's next epoch, so that two clients
+// rotating the same group can't both store the same epoch, and rotations must be appended in block
+// height order, so that the latest epoch is always the one for the highest block.
+
+// MessagingKeyRotationRecord is one epoch of a group's messaging key. WrappedKeys holds the new key
+// encrypted to each member, in the same form as the members of a MessagingGroupEntry.
+type MessagingKeyRotationRecord struct {
+	OwnerPublicKey     *PublicKey
+	GroupKeyName       *GroupKeyName
+	Epoch              uint64
+	BlockHeight        uint64
+	MessagingPublicKey *PublicKey
+	WrappedKeys        []*MessagingGroupMember
+}
+
+func (record *MessagingKeyRotationRecord) ToBytes() []byte {
+	data := append([]byte{}, record.OwnerPublicKey[:]...)
+	data = append(data, record.GroupKeyName[:]...)
+	data = append(data, UintToBuf(record.Epoch)...)
+	data = append(data, UintToBuf(record.BlockHeight)...)
+	data = append(data, record.MessagingPublicKey[:]...)
+	data = append(data, EncodeByteArray(EncodeMessagingGroupMembers(record.BlockHeight, record.WrappedKeys))...)
+	return data
+}
+
+func (record *MessagingKeyRotationRecord) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+	var err error
+
+	ownerPublicKey := make([]byte, PublicKeyLenCompressed)
+	if _, err = io.ReadFull(rr, ownerPublicKey); err != nil {
+		return errors.Wrapf(err, "MessagingKeyRotationRecord.FromBytes: Problem reading OwnerPublicKey")
+	}
+	record.OwnerPublicKey = NewPublicKey(ownerPublicKey)
+	groupKeyName := make([]byte, MaxMessagingKeyNameCharacters)
+	if _, err = io.ReadFull(rr, groupKeyName); err != nil {
+		return errors.Wrapf(err, "MessagingKeyRotationRecord.FromBytes: Problem reading GroupKeyName")
+	}
+	record.GroupKeyName = NewGroupKeyName(groupKeyName)
+	if record.Epoch, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "MessagingKeyRotationRecord.FromBytes: Problem reading Epoch")
+	}
+	if record.BlockHeight, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "MessagingKeyRotationRecord.FromBytes: Problem reading BlockHeight")
+	}
+	messagingPublicKey := make([]byte, PublicKeyLenCompressed)
+	if _, err = io.ReadFull(rr, messagingPublicKey); err != nil {
+		return errors.Wrapf(err, "MessagingKeyRotationRecord.FromBytes: Problem reading MessagingPublicKey")
+	}
+	record.MessagingPublicKey = NewPublicKey(messagingPublicKey)
+	wrappedKeysBytes, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MessagingKeyRotationRecord.FromBytes: Problem reading WrappedKeys")
+	}
+	if record.WrappedKeys, _, err = DecodeMessagingGroupMembers(wrappedKeysBytes); err != nil {
+		return errors.Wrapf(err, "MessagingKeyRotationRecord.FromBytes: Problem decoding WrappedKeys")
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessagingKeyRotations(t *testing.T) {
+	require := require.New(t)
+
+	db, _ := GetTestBadgerDb()
+	defer db.Close()
+
+	ownerPublicKey := NewPublicKey(RandomBytes(33))
+	groupKeyName := NewGroupKeyName([]byte("friends"))
+	otherGroupKeyName := NewGroupKeyName([]byte("family"))
+	wrappedKeys := []*MessagingGroupMember{{
+		GroupMemberPublicKey: NewPublicKey(RandomBytes(33)),
+		GroupMemberKeyName:   DefaultGroupKeyName(),
+		EncryptedKey:         RandomBytes(32),
+	}}
+
+	// Groups without rotations have no latest epoch.
+	latestRecord, err := DbGetLatestMessagingKeyRotation(db, ownerPublicKey, groupKeyName)
+	require.NoError(err)
+	require.Nil(latestRecord)
+
+	// The first rotation must be epoch 0.
+	_, err = DbAppendMessagingKeyRotation(db, ownerPublicKey, groupKeyName, 1, 10,
+		NewPublicKey(RandomBytes(33)), wrappedKeys)
+	require.Error(err)
+
+	// Each rotation must be for the next epoch.
+	for ii := uint64(0); ii < 3; ii++ {
+		record, err := DbAppendMessagingKeyRotation(db, ownerPublicKey, groupKeyName, ii, 10+ii,
+			NewPublicKey(RandomBytes(33)), wrappedKeys)
+		require.NoError(err)
+		require.Equal(ii, record.Epoch)
+	}
+	_, err = DbAppendMessagingKeyRotation(db, ownerPublicKey, otherGroupKeyName, 0, 5,
+		NewPublicKey(RandomBytes(33)), nil)
+	require.NoError(err)
+
+	// Epochs can't be repeated or skipped.
+	_, err = DbAppendMessagingKeyRotation(db, ownerPublicKey, groupKeyName, 2, 13,
+		NewPublicKey(RandomBytes(33)), wrappedKeys)
+	require.Error(err)
+	_, err = DbAppendMessagingKeyRotation(db, ownerPublicKey, groupKeyName, 4, 13,
+		NewPublicKey(RandomBytes(33)), wrappedKeys)
+	require.Error(err)
+
+	latestRecord, err = DbGetLatestMessagingKeyRotation(db, ownerPublicKey, groupKeyName)
+	require.NoError(err)
+	require.Equal(uint64(2), latestRecord.Epoch)
+	require.Equal(uint64(12), latestRecord.BlockHeight)
+	require.Equal(wrappedKeys[0].EncryptedKey, latestRecord.WrappedKeys[0].EncryptedKey)
+
+	record, err := DbGetMessagingKeyRotation(db, ownerPublicKey, groupKeyName, 1)
+	require.NoError(err)
+	require.Equal(uint64(11), record.BlockHeight)
+	record, err = DbGetMessagingKeyRotation(db, ownerPublicKey, groupKeyName, 3)
+	require.NoError(err)
+	require.Nil(record)
+
+	// Rotations can't go back in block height.
+	_, err = DbAppendMessagingKeyRotation(db, ownerPublicKey, groupKeyName, 3, 11,
+		NewPublicKey(RandomBytes(33)), wrappedKeys)
+	require.Error(err)
+
+	// The owner's export bundle has the rotations of all of their groups, ordered by group key
+	// name and then by epoch.
+	bundle, err := DbGetUserDataExportBundle(db, nil, ownerPublicKey[:])
+	require.NoError(err)
+	require.Nil(bundle.ProfileEntry)
+	require.Equal(4, len(bundle.MessagingKeyRotations))
+	require.Equal(*otherGroupKeyName, *bundle.MessagingKeyRotations[0].GroupKeyName)
+	for ii, record := range bundle.MessagingKeyRotations[1:] {
+		require.Equal(*groupKeyName, *record.GroupKeyName)
+		require.Equal(uint64(ii), record.Epoch)
+	}
+}
@@ -18,7 +18,9 @@ import (
 	"time"
 
 	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
 	"github.com/davecgh/go-spew/spew"
 	decredEC "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
 	merkletree "github.com/deso-protocol/go-merkle-tree"
@@ -33,6 +35,10 @@ import (
 // MaxMessagePayload is the maximum size alowed for a message payload.
 const MaxMessagePayload = (1024 * 1024 * 1000) // 1GB
 
+// ErrMessagePayloadTooLarge is returned by ReadMessage for a message whose payload is bigger
+// than MaxMessagePayload. Peers that send one are penalized, see PeerMisbehaviorOversizedMessage.
+var ErrMessagePayloadTooLarge = errors.New("message payload is too large")
+
 // MaxBlockRewardDataSizeBytes is the maximum size allowed for a BLOCK_REWARD's ExtraData field.
 var MaxBlockRewardDataSizeBytes = 250
 
@@ -89,7 +95,12 @@ const (
 	MsgTypeGetSnapshot  MsgType = 17
 	MsgTypeSnapshotData MsgType = 18
 
-	// NEXT_TAG = 19
+	// MsgTypeGetCompactBlocks is used to fetch blocks as compact blocks, and MsgTypeCompactBlock
+	// is the response. They're only sent to peers that negotiated CompactBlocksProtocolVersion.
+	MsgTypeGetCompactBlocks MsgType = 19
+	MsgTypeCompactBlock     MsgType = 20
+
+	// NEXT_TAG = 21
 
 	// Below are control messages used to signal to the Server from other parts of
 	// the code but not actually sent among peers.
@@ -165,6 +176,10 @@ func (msgType MsgType) String() string {
 		return "GET_SNAPSHOT"
 	case MsgTypeSnapshotData:
 		return "SNAPSHOT_DATA"
+	case MsgTypeGetCompactBlocks:
+		return "GET_COMPACT_BLOCKS"
+	case MsgTypeCompactBlock:
+		return "COMPACT_BLOCK"
 	default:
 		return fmt.Sprintf("UNRECOGNIZED(%d) - make sure String() is up to date", msgType)
 	}
@@ -227,8 +242,10 @@ const (
 	TxnTypeDAOCoin                      TxnType = 24
 	TxnTypeDAOCoinTransfer              TxnType = 25
 	TxnTypeDAOCoinLimitOrder            TxnType = 26
+	TxnTypePostAssociation              TxnType = 27
+	TxnTypeUserAssociation              TxnType = 28
 
-	// NEXT_ID = 27
+	// NEXT_ID = 29
 )
 
 type TxnString string
@@ -260,6 +277,8 @@ const (
 	TxnStringDAOCoin                      TxnString = "DAO_COIN"
 	TxnStringDAOCoinTransfer              TxnString = "DAO_COIN_TRANSFER"
 	TxnStringDAOCoinLimitOrder            TxnString = "DAO_COIN_LIMIT_ORDER"
+	TxnStringPostAssociation              TxnString = "POST_ASSOCIATION"
+	TxnStringUserAssociation              TxnString = "USER_ASSOCIATION"
 	TxnStringUndefined                    TxnString = "TXN_UNDEFINED"
 )
 
@@ -270,7 +289,8 @@ var (
 		TxnTypeCreatorCoin, TxnTypeSwapIdentity, TxnTypeUpdateGlobalParams, TxnTypeCreatorCoinTransfer,
 		TxnTypeCreateNFT, TxnTypeUpdateNFT, TxnTypeAcceptNFTBid, TxnTypeNFTBid, TxnTypeNFTTransfer,
 		TxnTypeAcceptNFTTransfer, TxnTypeBurnNFT, TxnTypeAuthorizeDerivedKey, TxnTypeMessagingGroup,
-		TxnTypeDAOCoin, TxnTypeDAOCoinTransfer, TxnTypeDAOCoinLimitOrder,
+		TxnTypeDAOCoin, TxnTypeDAOCoinTransfer, TxnTypeDAOCoinLimitOrder, TxnTypePostAssociation,
+		TxnTypeUserAssociation,
 	}
 	AllTxnString = []TxnString{
 		TxnStringUnset, TxnStringBlockReward, TxnStringBasicTransfer, TxnStringBitcoinExchange, TxnStringPrivateMessage,
@@ -278,7 +298,8 @@ var (
 		TxnStringCreatorCoin, TxnStringSwapIdentity, TxnStringUpdateGlobalParams, TxnStringCreatorCoinTransfer,
 		TxnStringCreateNFT, TxnStringUpdateNFT, TxnStringAcceptNFTBid, TxnStringNFTBid, TxnStringNFTTransfer,
 		TxnStringAcceptNFTTransfer, TxnStringBurnNFT, TxnStringAuthorizeDerivedKey, TxnStringMessagingGroup,
-		TxnStringDAOCoin, TxnStringDAOCoinTransfer, TxnStringDAOCoinLimitOrder,
+		TxnStringDAOCoin, TxnStringDAOCoinTransfer, TxnStringDAOCoinLimitOrder, TxnStringPostAssociation,
+		TxnStringUserAssociation,
 	}
 )
 
@@ -344,6 +365,10 @@ func (txnType TxnType) GetTxnString() TxnString {
 		return TxnStringDAOCoinTransfer
 	case TxnTypeDAOCoinLimitOrder:
 		return TxnStringDAOCoinLimitOrder
+	case TxnTypePostAssociation:
+		return TxnStringPostAssociation
+	case TxnTypeUserAssociation:
+		return TxnStringUserAssociation
 	default:
 		return TxnStringUndefined
 	}
@@ -403,6 +428,10 @@ func GetTxnTypeFromString(txnString TxnString) TxnType {
 		return TxnTypeDAOCoinTransfer
 	case TxnStringDAOCoinLimitOrder:
 		return TxnTypeDAOCoinLimitOrder
+	case TxnStringPostAssociation:
+		return TxnTypePostAssociation
+	case TxnStringUserAssociation:
+		return TxnTypeUserAssociation
 	default:
 		// TxnTypeUnset means we couldn't find a matching txn type
 		return TxnTypeUnset
@@ -470,6 +499,10 @@ func NewTxnMetadata(txType TxnType) (DeSoTxnMetadata, error) {
 		return (&DAOCoinTransferMetadata{}).New(), nil
 	case TxnTypeDAOCoinLimitOrder:
 		return (&DAOCoinLimitOrderMetadata{}).New(), nil
+	case TxnTypePostAssociation:
+		return (&PostAssociationMetadata{}).New(), nil
+	case TxnTypeUserAssociation:
+		return (&UserAssociationMetadata{}).New(), nil
 	default:
 		return nil, fmt.Errorf("NewTxnMetadata: Unrecognized TxnType: %v; make sure you add the new type of transaction to NewTxnMetadata", txType)
 	}
@@ -567,7 +600,7 @@ func ReadMessage(rr io.Reader, networkType NetworkType) (DeSoMessage, []byte, er
 	// Check that the payload length does not exceed the maximum value allowed.
 	// This prevents adversarial machines from overflowing our
 	if payloadLength > MaxMessagePayload {
-		return nil, nil, fmt.Errorf("ReadMessage: Payload size (%d) bytes is too "+
+		return nil, nil, errors.Wrapf(ErrMessagePayloadTooLarge, "ReadMessage: Payload size (%d) bytes is too "+
 			"large. Should be no larger than (%d) bytes", payloadLength, MaxMessagePayload)
 	}
 
@@ -674,6 +707,16 @@ func NewMessage(msgType MsgType) DeSoMessage {
 		{
 			return &MsgDeSoSnapshotData{}
 		}
+	case MsgTypeGetCompactBlocks:
+		{
+			return &MsgDeSoGetCompactBlocks{}
+		}
+	case MsgTypeCompactBlock:
+		{
+			return &MsgDeSoCompactBlock{
+				Header: NewMessage(MsgTypeHeader).(*MsgDeSoHeader),
+			}
+		}
 	default:
 		{
 			return nil
@@ -2273,6 +2316,230 @@ func (msg *MsgDeSoBlock) String() string {
 	return fmt.Sprintf("<Header: %v, %v>", msg.Header.String(), msg.BlockProducerInfo)
 }
 
+// ==================================================================
+// COMPACT BLOCK Messages
+// ==================================================================
+
+// MsgDeSoGetCompactBlocks is like MsgDeSoGetBlocks, but asks for the blocks as compact blocks.
+type MsgDeSoGetCompactBlocks struct {
+	HashList []*BlockHash
+}
+
+func (msg *MsgDeSoGetCompactBlocks) GetMsgType() MsgType {
+	return MsgTypeGetCompactBlocks
+}
+
+func (msg *MsgDeSoGetCompactBlocks) ToBytes(preSignature bool) ([]byte, error) {
+	// The encoding is the same as a GetBlocks message's.
+	data, err := (&MsgDeSoGetBlocks{HashList: msg.HashList}).ToBytes(preSignature)
+	if err != nil {
+		return nil, errors.Wrapf(err, "MsgDeSoGetCompactBlocks.ToBytes: ")
+	}
+	return data, nil
+}
+
+func (msg *MsgDeSoGetCompactBlocks) FromBytes(data []byte) error {
+	getBlocks := &MsgDeSoGetBlocks{}
+	if err := getBlocks.FromBytes(data); err != nil {
+		return errors.Wrapf(err, "MsgDeSoGetCompactBlocks.FromBytes: ")
+	}
+	*msg = MsgDeSoGetCompactBlocks{
+		HashList: getBlocks.HashList,
+	}
+	return nil
+}
+
+func (msg *MsgDeSoGetCompactBlocks) String() string {
+	return fmt.Sprintf("%v", msg.HashList)
+}
+
+// CompactBlockShortTxIDLen is the length of the short txn IDs in a compact block.
+const CompactBlockShortTxIDLen = 6
+
+// CompactBlockShortTxID identifies a txn of a compact block, see ComputeCompactBlockShortTxID.
+type CompactBlockShortTxID [CompactBlockShortTxIDLen]byte
+
+// CompactBlockPrefilledTxn is a txn that's sent in full in a compact block. Index is the position
+// of the txn in the block.
+type CompactBlockPrefilledTxn struct {
+	Index uint64
+	Txn   *MsgDeSoTxn
+}
+
+// MsgDeSoCompactBlock is a block in which most txns are replaced by short IDs, so that a peer can
+// rebuild the block from the txns in its mempool instead of downloading them again. The short IDs
+// fill the positions of the block that aren't prefilled, in order. See ReconstructBlock.
+type MsgDeSoCompactBlock struct {
+	Header            *MsgDeSoHeader
+	BlockProducerInfo *BlockProducerInfo
+
+	// ShortTxIDNonce salts the short IDs so that they differ for every compact block we send.
+	ShortTxIDNonce uint64
+	ShortTxIDs     []CompactBlockShortTxID
+	// PrefilledTxns are sorted by Index.
+	PrefilledTxns []*CompactBlockPrefilledTxn
+}
+
+func (msg *MsgDeSoCompactBlock) GetMsgType() MsgType {
+	return MsgTypeCompactBlock
+}
+
+// NumTxns returns the number of txns in the block.
+func (msg *MsgDeSoCompactBlock) NumTxns() uint64 {
+	return uint64(len(msg.ShortTxIDs) + len(msg.PrefilledTxns))
+}
+
+func (msg *MsgDeSoCompactBlock) ToBytes(preSignature bool) ([]byte, error) {
+	data := []byte{}
+
+	if msg.Header == nil {
+		return nil, fmt.Errorf("MsgDeSoCompactBlock.ToBytes: Header should not be nil")
+	}
+	hdrBytes, err := msg.Header.ToBytes(preSignature)
+	if err != nil {
+		return nil, errors.Wrapf(err, "MsgDeSoCompactBlock.ToBytes: Problem encoding header")
+	}
+	data = append(data, UintToBuf(uint64(len(hdrBytes)))...)
+	data = append(data, hdrBytes...)
+
+	blockProducerInfoBytes := []byte{}
+	if msg.BlockProducerInfo != nil {
+		blockProducerInfoBytes = msg.BlockProducerInfo.Serialize()
+	}
+	data = append(data, UintToBuf(uint64(len(blockProducerInfoBytes)))...)
+	data = append(data, blockProducerInfoBytes...)
+
+	data = append(data, UintToBuf(msg.ShortTxIDNonce)...)
+	data = append(data, UintToBuf(uint64(len(msg.ShortTxIDs)))...)
+	for _, shortTxID := range msg.ShortTxIDs {
+		data = append(data, shortTxID[:]...)
+	}
+
+	data = append(data, UintToBuf(uint64(len(msg.PrefilledTxns)))...)
+	for _, prefilledTxn := range msg.PrefilledTxns {
+		txnBytes, err := prefilledTxn.Txn.ToBytes(preSignature)
+		if err != nil {
+			return nil, errors.Wrapf(err, "MsgDeSoCompactBlock.ToBytes: Problem encoding prefilled txn")
+		}
+		data = append(data, UintToBuf(prefilledTxn.Index)...)
+		data = append(data, UintToBuf(uint64(len(txnBytes)))...)
+		data = append(data, txnBytes...)
+	}
+
+	return data, nil
+}
+
+func (msg *MsgDeSoCompactBlock) FromBytes(data []byte) error {
+	ret := NewMessage(MsgTypeCompactBlock).(*MsgDeSoCompactBlock)
+	rr := bytes.NewReader(data)
+
+	hdrLen, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem decoding header length")
+	}
+	if hdrLen > uint64(rr.Len()) {
+		return fmt.Errorf("MsgDeSoCompactBlock.FromBytes: Header length %d longer than the message", hdrLen)
+	}
+	hdrBytes := make([]byte, hdrLen)
+	if _, err = io.ReadFull(rr, hdrBytes); err != nil {
+		return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem reading header")
+	}
+	if err = ret.Header.FromBytes(hdrBytes); err != nil {
+		return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem converting header")
+	}
+
+	blockProducerInfoLen, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem decoding block producer info length")
+	}
+	if blockProducerInfoLen > 0 {
+		if blockProducerInfoLen > uint64(rr.Len()) {
+			return fmt.Errorf("MsgDeSoCompactBlock.FromBytes: Block producer info length %d longer "+
+				"than the message", blockProducerInfoLen)
+		}
+		blockProducerInfoBytes := make([]byte, blockProducerInfoLen)
+		if _, err = io.ReadFull(rr, blockProducerInfoBytes); err != nil {
+			return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem reading block producer info")
+		}
+		ret.BlockProducerInfo = &BlockProducerInfo{}
+		if err = ret.BlockProducerInfo.Deserialize(blockProducerInfoBytes); err != nil {
+			return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem decoding block producer info")
+		}
+	}
+
+	if ret.ShortTxIDNonce, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem decoding short txn ID nonce")
+	}
+	numShortTxIDs, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem decoding number of short txn IDs")
+	}
+	if numShortTxIDs > uint64(rr.Len())/CompactBlockShortTxIDLen {
+		return fmt.Errorf("MsgDeSoCompactBlock.FromBytes: %d short txn IDs don't fit in the message", numShortTxIDs)
+	}
+	ret.ShortTxIDs = make([]CompactBlockShortTxID, numShortTxIDs)
+	for ii := range ret.ShortTxIDs {
+		if _, err = io.ReadFull(rr, ret.ShortTxIDs[ii][:]); err != nil {
+			return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem reading short txn ID")
+		}
+	}
+
+	numPrefilledTxns, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem decoding number of prefilled txns")
+	}
+	if numPrefilledTxns > uint64(rr.Len()) {
+		return fmt.Errorf("MsgDeSoCompactBlock.FromBytes: %d prefilled txns don't fit in the message", numPrefilledTxns)
+	}
+	numTxns := numShortTxIDs + numPrefilledTxns
+	for ii := uint64(0); ii < numPrefilledTxns; ii++ {
+		prefilledTxn := &CompactBlockPrefilledTxn{}
+		if prefilledTxn.Index, err = ReadUvarint(rr); err != nil {
+			return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem decoding prefilled txn index")
+		}
+		// The indexes have to be increasing and within the block.
+		if prefilledTxn.Index >= numTxns ||
+			(ii > 0 && prefilledTxn.Index <= ret.PrefilledTxns[ii-1].Index) {
+			return fmt.Errorf("MsgDeSoCompactBlock.FromBytes: Invalid prefilled txn index %d in a block "+
+				"of %d txns", prefilledTxn.Index, numTxns)
+		}
+		txnLen, err := ReadUvarint(rr)
+		if err != nil {
+			return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem decoding prefilled txn length")
+		}
+		if txnLen > uint64(rr.Len()) {
+			return fmt.Errorf("MsgDeSoCompactBlock.FromBytes: Prefilled txn length %d longer than the message", txnLen)
+		}
+		txnBytes := make([]byte, txnLen)
+		if _, err = io.ReadFull(rr, txnBytes); err != nil {
+			return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem reading prefilled txn")
+		}
+		prefilledTxn.Txn = NewMessage(MsgTypeTxn).(*MsgDeSoTxn)
+		if err = prefilledTxn.Txn.FromBytes(txnBytes); err != nil {
+			return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem decoding prefilled txn")
+		}
+		ret.PrefilledTxns = append(ret.PrefilledTxns, prefilledTxn)
+	}
+
+	*msg = *ret
+	return nil
+}
+
+func (msg *MsgDeSoCompactBlock) Hash() (*BlockHash, error) {
+	if msg == nil || msg.Header == nil {
+		return nil, fmt.Errorf("MsgDeSoCompactBlock.Hash: nil compact block or nil header")
+	}
+	return msg.Header.Hash()
+}
+
+func (msg *MsgDeSoCompactBlock) String() string {
+	if msg == nil || msg.Header == nil {
+		return "<nil compact block or header>"
+	}
+	return fmt.Sprintf("<Header: %v, NumShortTxIDs: %d, NumPrefilledTxns: %d>",
+		msg.Header.String(), len(msg.ShortTxIDs), len(msg.PrefilledTxns))
+}
+
 // ==================================================================
 // SNAPSHOT Message
 // ==================================================================
@@ -3584,6 +3851,241 @@ func (txnData *LikeMetadata) New() DeSoTxnMetadata {
 	return &LikeMetadata{}
 }
 
+// ==================================================================
+// PostAssociationMetadata
+//
+// A post association is a generalized like: it associates the user with
+// a post under an AssociationType, e.g. a reaction, along with an
+// AssociationValue whose meaning depends on the type, e.g. which emoji
+// the user reacted with.
+// ==================================================================
+
+type PostAssociationMetadata struct {
+	// The user creating the association is assumed to be the originator of
+	// the top-level transaction.
+
+	// The post hash to associate with.
+	TargetPostHash *BlockHash
+
+	// The kind of association. A user can have one association of each type
+	// with a post, so creating an association replaces the existing one.
+	AssociationType []byte
+
+	// The data that goes with the association. Must be empty if IsDelete is set.
+	AssociationValue []byte
+
+	// Set to true when a user is requesting to delete an association.
+	IsDelete bool
+}
+
+func (txnData *PostAssociationMetadata) GetTxnType() TxnType {
+	return TxnTypePostAssociation
+}
+
+func (txnData *PostAssociationMetadata) ToBytes(preSignature bool) ([]byte, error) {
+	// Validate the metadata before encoding it.
+	//
+	// Post hash must be included and must have the expected length.
+	if len(txnData.TargetPostHash) != HashSizeBytes {
+		return nil, fmt.Errorf("PostAssociationMetadata.ToBytes: TargetPostHash "+
+			"has length %d != %d", len(txnData.TargetPostHash), HashSizeBytes)
+	}
+
+	data := []byte{}
+
+	// TargetPostHash
+	data = append(data, txnData.TargetPostHash[:]...)
+
+	// AssociationType
+	data = append(data, EncodeByteArray(txnData.AssociationType)...)
+
+	// AssociationValue
+	data = append(data, EncodeByteArray(txnData.AssociationValue)...)
+
+	// IsDelete
+	data = append(data, BoolToByte(txnData.IsDelete))
+
+	return data, nil
+}
+
+func (txnData *PostAssociationMetadata) FromBytes(data []byte) error {
+	ret := PostAssociationMetadata{}
+	rr := bytes.NewReader(data)
+
+	// TargetPostHash
+	ret.TargetPostHash = &BlockHash{}
+	_, err := io.ReadFull(rr, ret.TargetPostHash[:])
+	if err != nil {
+		return fmt.Errorf(
+			"PostAssociationMetadata.FromBytes: Error reading TargetPostHash: %v", err)
+	}
+
+	// AssociationType
+	associationTypeLen, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "PostAssociationMetadata.FromBytes: Problem "+
+			"decoding AssociationType length")
+	}
+	if associationTypeLen > MaxPostAssociationTypeLengthBytes {
+		return fmt.Errorf("PostAssociationMetadata.FromBytes: associationTypeLen %d "+
+			"exceeds max %d", associationTypeLen, MaxPostAssociationTypeLengthBytes)
+	}
+	ret.AssociationType = make([]byte, associationTypeLen)
+	_, err = io.ReadFull(rr, ret.AssociationType)
+	if err != nil {
+		return fmt.Errorf("PostAssociationMetadata.FromBytes: Error reading AssociationType: %v", err)
+	}
+
+	// AssociationValue
+	associationValueLen, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "PostAssociationMetadata.FromBytes: Problem "+
+			"decoding AssociationValue length")
+	}
+	if associationValueLen > MaxPostAssociationValueLengthBytes {
+		return fmt.Errorf("PostAssociationMetadata.FromBytes: associationValueLen %d "+
+			"exceeds max %d", associationValueLen, MaxPostAssociationValueLengthBytes)
+	}
+	ret.AssociationValue = make([]byte, associationValueLen)
+	_, err = io.ReadFull(rr, ret.AssociationValue)
+	if err != nil {
+		return fmt.Errorf("PostAssociationMetadata.FromBytes: Error reading AssociationValue: %v", err)
+	}
+
+	// IsDelete
+	ret.IsDelete, err = ReadBoolByte(rr)
+	if err != nil {
+		return errors.Wrapf(err, "PostAssociationMetadata.FromBytes: Problem reading IsDelete")
+	}
+
+	*txnData = ret
+
+	return nil
+}
+
+func (txnData *PostAssociationMetadata) New() DeSoTxnMetadata {
+	return &PostAssociationMetadata{}
+}
+
+// ==================================================================
+// UserAssociationMetadata
+//
+// A user association is an attestation that one user makes about
+// another under an AssociationType, e.g. an endorsement, along with an
+// AssociationValue whose meaning depends on the type.
+// ==================================================================
+
+type UserAssociationMetadata struct {
+	// The user creating the association is assumed to be the originator of
+	// the top-level transaction.
+
+	// The public key of the user to associate with.
+	TargetUserPublicKey []byte
+
+	// The kind of association. A user can have one association of each type
+	// with another user, so creating an association replaces the existing one.
+	AssociationType []byte
+
+	// The data that goes with the association. Must be empty if IsDelete is set.
+	AssociationValue []byte
+
+	// Set to true when a user is requesting to delete an association.
+	IsDelete bool
+}
+
+func (txnData *UserAssociationMetadata) GetTxnType() TxnType {
+	return TxnTypeUserAssociation
+}
+
+func (txnData *UserAssociationMetadata) ToBytes(preSignature bool) ([]byte, error) {
+	// Validate the metadata before encoding it.
+	//
+	// Public key must be included and must have the expected length.
+	if len(txnData.TargetUserPublicKey) != btcec.PubKeyBytesLenCompressed {
+		return nil, fmt.Errorf("UserAssociationMetadata.ToBytes: TargetUserPublicKey "+
+			"has length %d != %d", len(txnData.TargetUserPublicKey),
+			btcec.PubKeyBytesLenCompressed)
+	}
+
+	data := []byte{}
+
+	// TargetUserPublicKey
+	//
+	// We know the public key is set and has the expected length so we don't need
+	// to encode the length here.
+	data = append(data, txnData.TargetUserPublicKey...)
+
+	// AssociationType
+	data = append(data, EncodeByteArray(txnData.AssociationType)...)
+
+	// AssociationValue
+	data = append(data, EncodeByteArray(txnData.AssociationValue)...)
+
+	// IsDelete
+	data = append(data, BoolToByte(txnData.IsDelete))
+
+	return data, nil
+}
+
+func (txnData *UserAssociationMetadata) FromBytes(data []byte) error {
+	ret := UserAssociationMetadata{}
+	rr := bytes.NewReader(data)
+
+	// TargetUserPublicKey
+	ret.TargetUserPublicKey = make([]byte, btcec.PubKeyBytesLenCompressed)
+	_, err := io.ReadFull(rr, ret.TargetUserPublicKey)
+	if err != nil {
+		return fmt.Errorf(
+			"UserAssociationMetadata.FromBytes: Error reading TargetUserPublicKey: %v", err)
+	}
+
+	// AssociationType
+	associationTypeLen, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "UserAssociationMetadata.FromBytes: Problem "+
+			"decoding AssociationType length")
+	}
+	if associationTypeLen > MaxUserAssociationTypeLengthBytes {
+		return fmt.Errorf("UserAssociationMetadata.FromBytes: associationTypeLen %d "+
+			"exceeds max %d", associationTypeLen, MaxUserAssociationTypeLengthBytes)
+	}
+	ret.AssociationType = make([]byte, associationTypeLen)
+	_, err = io.ReadFull(rr, ret.AssociationType)
+	if err != nil {
+		return fmt.Errorf("UserAssociationMetadata.FromBytes: Error reading AssociationType: %v", err)
+	}
+
+	// AssociationValue
+	associationValueLen, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "UserAssociationMetadata.FromBytes: Problem "+
+			"decoding AssociationValue length")
+	}
+	if associationValueLen > MaxUserAssociationValueLengthBytes {
+		return fmt.Errorf("UserAssociationMetadata.FromBytes: associationValueLen %d "+
+			"exceeds max %d", associationValueLen, MaxUserAssociationValueLengthBytes)
+	}
+	ret.AssociationValue = make([]byte, associationValueLen)
+	_, err = io.ReadFull(rr, ret.AssociationValue)
+	if err != nil {
+		return fmt.Errorf("UserAssociationMetadata.FromBytes: Error reading AssociationValue: %v", err)
+	}
+
+	// IsDelete
+	ret.IsDelete, err = ReadBoolByte(rr)
+	if err != nil {
+		return errors.Wrapf(err, "UserAssociationMetadata.FromBytes: Problem reading IsDelete")
+	}
+
+	*txnData = ret
+
+	return nil
+}
+
+func (txnData *UserAssociationMetadata) New() DeSoTxnMetadata {
+	return &UserAssociationMetadata{}
+}
+
 // ==================================================================
 // FollowMetadata
 //
@@ -4974,6 +5476,52 @@ type TransactionSpendingLimit struct {
 	// ===== ENCODER MIGRATION UnlimitedDerivedKeysMigration =====
 	// IsUnlimited field determines whether this derived key has no spending limit.
 	IsUnlimited bool
+
+	// ===== ENCODER MIGRATION DerivedKeyTxnTypeScopingMigration =====
+	// AllowedTxnTypes scopes the derived key to a set of txn types. If it's empty, the key isn't
+	// scoped. Unlike the other limits, a scope can be set on an unlimited key.
+	AllowedTxnTypes TxnTypeBitmap
+
+	// TxnTypeDESOLimitMap is the amount of DESO the derived key can spend on each txn type, on
+	// top of the GlobalDESOLimit. Txn types that aren't in the map are only limited by the
+	// GlobalDESOLimit.
+	TxnTypeDESOLimitMap map[TxnType]uint64
+}
+
+// TxnTypeBitmap is a set of txn types, where txn type N is bit N. TxnTypes are smaller than 64.
+type TxnTypeBitmap uint64
+
+func NewTxnTypeBitmap(txnTypes ...TxnType) TxnTypeBitmap {
+	var bitmap TxnTypeBitmap
+	for _, txnType := range txnTypes {
+		bitmap |= 1 << uint64(txnType)
+	}
+	return bitmap
+}
+
+func (bitmap TxnTypeBitmap) Contains(txnType TxnType) bool {
+	return uint64(txnType) < 64 && bitmap&(1<<uint64(txnType)) != 0
+}
+
+// TxnTypes returns the txn types in the bitmap in ascending order.
+func (bitmap TxnTypeBitmap) TxnTypes() []TxnType {
+	var txnTypes []TxnType
+	for ii := uint64(0); ii < 64; ii++ {
+		if bitmap&(1<<ii) != 0 {
+			txnTypes = append(txnTypes, TxnType(ii))
+		}
+	}
+	return txnTypes
+}
+
+// Validate returns an error if the bitmap contains anything other than txn types a derived key can sign.
+func (bitmap TxnTypeBitmap) Validate() error {
+	validTxnTypes := NewTxnTypeBitmap(AllTxnTypes...) &^ NewTxnTypeBitmap(TxnTypeUnset, TxnTypeBlockReward)
+	if bitmap&^validTxnTypes != 0 {
+		return errors.Wrapf(RuleErrorDerivedKeyInvalidAllowedTxnTypes,
+			"TxnTypeBitmap.Validate: Bitmap %b contains invalid txn types", uint64(bitmap&^validTxnTypes))
+	}
+	return nil
 }
 
 // ToMetamaskString encodes the TransactionSpendingLimit into a Metamask-compatible string. The encoded string will
@@ -5119,6 +5667,33 @@ func (tsl *TransactionSpendingLimit) ToMetamaskString(params *DeSoParams) string
 		indentationCounter--
 	}
 
+	// TxnTypeDESOLimitMap
+	if len(tsl.TxnTypeDESOLimitMap) > 0 {
+		var txnTypeDESOLimitStr []string
+		str += _indt(indentationCounter) + "Transaction Type $DESO Limit: \n"
+		indentationCounter++
+		for txnType, limit := range tsl.TxnTypeDESOLimitMap {
+			txnTypeDESOLimitStr = append(txnTypeDESOLimitStr, _indt(indentationCounter)+txnType.String()+": "+
+				FormatScaledUint256AsDecimalString(big.NewInt(0).SetUint64(limit), big.NewInt(int64(NanosPerUnit)))+
+				" $DESO\n")
+		}
+		// Ensure deterministic ordering of the transaction type limit strings by doing a lexicographical sort.
+		sortStringsAndAddToLimitStr(txnTypeDESOLimitStr)
+		indentationCounter--
+	}
+
+	// AllowedTxnTypes
+	if tsl.AllowedTxnTypes != 0 {
+		var allowedTxnTypesStr []string
+		str += _indt(indentationCounter) + "Allowed Transaction Types: \n"
+		indentationCounter++
+		for _, txnType := range tsl.AllowedTxnTypes.TxnTypes() {
+			allowedTxnTypesStr = append(allowedTxnTypesStr, _indt(indentationCounter)+txnType.String()+"\n")
+		}
+		sortStringsAndAddToLimitStr(allowedTxnTypesStr)
+		indentationCounter--
+	}
+
 	// IsUnlimited
 	if tsl.IsUnlimited {
 		str += "Unlimited"
@@ -5237,6 +5812,27 @@ func (tsl *TransactionSpendingLimit) ToBytes(blockHeight uint64) ([]byte, error)
 		data = append(data, BoolToByte(tsl.IsUnlimited))
 	}
 
+	// AllowedTxnTypes and TxnTypeDESOLimitMap, gated by the encoder migration.
+	if MigrationTriggered(blockHeight, DerivedKeyTxnTypeScopingMigration) {
+		data = append(data, UintToBuf(uint64(tsl.AllowedTxnTypes))...)
+
+		txnTypeDESOLimitMapLength := uint64(len(tsl.TxnTypeDESOLimitMap))
+		data = append(data, UintToBuf(txnTypeDESOLimitMapLength)...)
+		if txnTypeDESOLimitMapLength > 0 {
+			keys := make([]TxnType, 0, txnTypeDESOLimitMapLength)
+			for key := range tsl.TxnTypeDESOLimitMap {
+				keys = append(keys, key)
+			}
+			sort.Slice(keys, func(ii, jj int) bool {
+				return keys[ii] < keys[jj]
+			})
+			for _, key := range keys {
+				data = append(data, UintToBuf(uint64(key))...)
+				data = append(data, UintToBuf(tsl.TxnTypeDESOLimitMap[key])...)
+			}
+		}
+	}
+
 	return data, nil
 }
 
@@ -5369,6 +5965,37 @@ func (tsl *TransactionSpendingLimit) FromBytes(blockHeight uint64, rr *bytes.Rea
 		}
 	}
 
+	if MigrationTriggered(blockHeight, DerivedKeyTxnTypeScopingMigration) {
+		allowedTxnTypes, err := ReadUvarint(rr)
+		if err != nil {
+			return errors.Wrapf(err, "TransactionSpendingLimit.FromBytes: Problem reading AllowedTxnTypes")
+		}
+		tsl.AllowedTxnTypes = TxnTypeBitmap(allowedTxnTypes)
+
+		txnTypeDESOLimitMapLen, err := ReadUvarint(rr)
+		if err != nil {
+			return errors.Wrapf(err, "TransactionSpendingLimit.FromBytes: Problem reading TxnTypeDESOLimitMap length")
+		}
+		// The map is left nil if it's empty, since most keys don't set it.
+		if txnTypeDESOLimitMapLen > 0 {
+			tsl.TxnTypeDESOLimitMap = make(map[TxnType]uint64)
+		}
+		for ii := uint64(0); ii < txnTypeDESOLimitMapLen; ii++ {
+			key, err := ReadUvarint(rr)
+			if err != nil {
+				return err
+			}
+			val, err := ReadUvarint(rr)
+			if err != nil {
+				return err
+			}
+			if _, exists := tsl.TxnTypeDESOLimitMap[TxnType(key)]; exists {
+				return fmt.Errorf("Txn Type DESO Limit Key already exists in map")
+			}
+			tsl.TxnTypeDESOLimitMap[TxnType(key)] = val
+		}
+	}
+
 	return nil
 }
 
@@ -5381,6 +6008,7 @@ func (tsl *TransactionSpendingLimit) Copy() *TransactionSpendingLimit {
 		NFTOperationLimitMap:         make(map[NFTOperationLimitKey]uint64),
 		DAOCoinLimitOrderLimitMap:    make(map[DAOCoinLimitOrderLimitKey]uint64),
 		IsUnlimited:                  tsl.IsUnlimited,
+		AllowedTxnTypes:              tsl.AllowedTxnTypes,
 	}
 
 	for txnType, txnCount := range tsl.TransactionCountLimitMap {
@@ -5403,27 +6031,14 @@ func (tsl *TransactionSpendingLimit) Copy() *TransactionSpendingLimit {
 		copyTSL.DAOCoinLimitOrderLimitMap[daoCoinLimitOrderLimitKey] = daoCoinLimitOrderCount
 	}
 
-	return copyTSL
-}
-
-func (bav *UtxoView) CheckIfValidUnlimitedSpendingLimit(tsl *TransactionSpendingLimit, blockHeight uint32) (_isUnlimited bool, _err error) {
-	AssertDependencyStructFieldNumbers(&TransactionSpendingLimit{}, 7)
-
-	if tsl.IsUnlimited && blockHeight < bav.Params.ForkHeights.DeSoUnlimitedDerivedKeysBlockHeight {
-		return false, RuleErrorUnlimitedDerivedKeyBeforeBlockHeight
-	}
-
-	if tsl.IsUnlimited && (tsl.GlobalDESOLimit > 0 ||
-		len(tsl.TransactionCountLimitMap) > 0 ||
-		len(tsl.CreatorCoinOperationLimitMap) > 0 ||
-		len(tsl.DAOCoinOperationLimitMap) > 0 ||
-		len(tsl.NFTOperationLimitMap) > 0 ||
-		len(tsl.DAOCoinLimitOrderLimitMap) > 0) {
-
-		return tsl.IsUnlimited, RuleErrorUnlimitedDerivedKeyNonEmptySpendingLimits
+	if tsl.TxnTypeDESOLimitMap != nil {
+		copyTSL.TxnTypeDESOLimitMap = make(map[TxnType]uint64)
+		for txnType, desoLimit := range tsl.TxnTypeDESOLimitMap {
+			copyTSL.TxnTypeDESOLimitMap[txnType] = desoLimit
+		}
 	}
 
-	return tsl.IsUnlimited, nil
+	return copyTSL
 }
 
 type NFTLimitOperation uint8
@@ -6131,6 +6746,33 @@ type DAOCoinLimitOrderMetadata struct {
 	// of the transaction AND ensures the internal balance model of the
 	// DAO Coin Limit Order transaction connection logic remains valid.
 	FeeNanos uint64
+
+	// If set, we will delete all of the transactor's open orders for the pair
+	// given by BuyingDAOCoinCreatorPublicKey and SellingDAOCoinCreatorPublicKey,
+	// on both sides of the book. No new order is submitted.
+	CancelAllForPair bool
+
+	// If set, we will delete the order with the given OrderID and submit a new
+	// order with the terms in this txn in its place. The new order must be for
+	// the same pair and the same side of the book as the order it replaces.
+	ReplaceOrderID *BlockHash
+
+	// If set on a market order, the order stops matching once the exchange rate of
+	// the next matching order is more than this many basis points worse than the
+	// exchange rate of the first order it matched. Zero means there's no bound.
+	MarketOrderMaxSlippageBasisPoints uint64
+
+	// If set, the order is a trigger order. It doesn't match when it's placed, and instead
+	// stays dormant until a fill on the pair happens at an exchange rate, in this order's
+	// coins to sell per coin to buy, at or above this one. Then it's placed on the book as
+	// a regular GoodTillCancelled order.
+	TriggerScaledExchangeRate *uint256.Int
+
+	// If set on a GoodTillCancelled order, the order expires after this block height,
+	// i.e. it can't be matched in later blocks, and it's pruned from the book when the
+	// next block connects. For a trigger order, it only applies once it's triggered.
+	// Zero means the order doesn't expire.
+	ExpirationBlockHeight uint32
 }
 
 func (txnData *DAOCoinLimitOrderMetadata) GetTxnType() TxnType {
@@ -6159,6 +6801,27 @@ func (txnData *DAOCoinLimitOrderMetadata) ToBytes(preSignature bool) ([]byte, er
 	}
 
 	data = append(data, UintToBuf(txnData.FeeNanos)...)
+
+	// CancelAllForPair and ReplaceOrderID were added after the fact, so they're only
+	// encoded if one of them is set, or if one of the fields that follow them is set.
+	// The same goes for each of the fields that follow them. This keeps the bytes of
+	// older txns unchanged.
+	encodeExpiration := txnData.ExpirationBlockHeight != 0
+	encodeTrigger := txnData.TriggerScaledExchangeRate != nil || encodeExpiration
+	encodeSlippage := txnData.MarketOrderMaxSlippageBasisPoints != 0 || encodeTrigger
+	if txnData.CancelAllForPair || txnData.ReplaceOrderID != nil || encodeSlippage {
+		data = append(data, BoolToByte(txnData.CancelAllForPair))
+		data = append(data, EncodeOptionalBlockHash(txnData.ReplaceOrderID)...)
+	}
+	if encodeSlippage {
+		data = append(data, UintToBuf(txnData.MarketOrderMaxSlippageBasisPoints)...)
+	}
+	if encodeTrigger {
+		data = append(data, EncodeOptionalUint256(txnData.TriggerScaledExchangeRate)...)
+	}
+	if encodeExpiration {
+		data = append(data, UintToBuf(uint64(txnData.ExpirationBlockHeight))...)
+	}
 	return data, nil
 }
 
@@ -6288,6 +6951,62 @@ func (txnData *DAOCoinLimitOrderMetadata) FromBytes(data []byte) error {
 		return fmt.Errorf("DAOCoinLimitOrderMetadata.FromBytes: Error reading FeeNanos: %v", err)
 	}
 
+	// Parse CancelAllForPair and ReplaceOrderID, which are only present if one of them is set
+	// or if MarketOrderMaxSlippageBasisPoints or TriggerScaledExchangeRate is set.
+	if rr.Len() > 0 {
+		ret.CancelAllForPair, err = ReadBoolByte(rr)
+		if err != nil {
+			return fmt.Errorf("DAOCoinLimitOrderMetadata.FromBytes: Error reading CancelAllForPair: %v", err)
+		}
+		ret.ReplaceOrderID, err = ReadOptionalBlockHash(rr)
+		if err != nil {
+			return fmt.Errorf("DAOCoinLimitOrderMetadata.FromBytes: Error reading ReplaceOrderID: %v", err)
+		}
+
+		// Parse MarketOrderMaxSlippageBasisPoints, which is only present if it's set
+		// or if TriggerScaledExchangeRate is set.
+		if rr.Len() > 0 {
+			ret.MarketOrderMaxSlippageBasisPoints, err = ReadUvarint(rr)
+			if err != nil {
+				return fmt.Errorf("DAOCoinLimitOrderMetadata.FromBytes: Error reading "+
+					"MarketOrderMaxSlippageBasisPoints: %v", err)
+			}
+
+			// Parse TriggerScaledExchangeRate, which is only present if it's set
+			// or if ExpirationBlockHeight is set.
+			if rr.Len() > 0 {
+				ret.TriggerScaledExchangeRate, err = ReadOptionalUint256(rr)
+				if err != nil {
+					return fmt.Errorf("DAOCoinLimitOrderMetadata.FromBytes: Error reading "+
+						"TriggerScaledExchangeRate: %v", err)
+				}
+
+				// Parse ExpirationBlockHeight, which is only present if it's set.
+				if rr.Len() > 0 {
+					expirationBlockHeight, err := ReadUvarint(rr)
+					if err != nil {
+						return fmt.Errorf("DAOCoinLimitOrderMetadata.FromBytes: Error reading "+
+							"ExpirationBlockHeight: %v", err)
+					}
+					if expirationBlockHeight == 0 || expirationBlockHeight > math.MaxUint32 {
+						return fmt.Errorf("DAOCoinLimitOrderMetadata.FromBytes: Invalid "+
+							"ExpirationBlockHeight %d", expirationBlockHeight)
+					}
+					ret.ExpirationBlockHeight = uint32(expirationBlockHeight)
+				} else if ret.TriggerScaledExchangeRate == nil {
+					return fmt.Errorf("DAOCoinLimitOrderMetadata.FromBytes: TriggerScaledExchangeRate " +
+						"is encoded but not set")
+				}
+			} else if ret.MarketOrderMaxSlippageBasisPoints == 0 {
+				return fmt.Errorf("DAOCoinLimitOrderMetadata.FromBytes: MarketOrderMaxSlippageBasisPoints " +
+					"is encoded but not set")
+			}
+		} else if !ret.CancelAllForPair && ret.ReplaceOrderID == nil {
+			return fmt.Errorf("DAOCoinLimitOrderMetadata.FromBytes: CancelAllForPair and ReplaceOrderID " +
+				"are encoded but neither is set")
+		}
+	}
+
 	*txnData = ret
 	return nil
 }
@@ -6368,6 +7087,62 @@ func DeserializePubKeyToUint64Map(data []byte) (map[PublicKey]uint64, error) {
 	return mm, nil
 }
 
+// SerializeTxnTypeToUint64Map encodes the number of key/value pairs followed by each
+// (txn type, uint64) pair, sorted by txn type so that the encoding is deterministic.
+func SerializeTxnTypeToUint64Map(mm map[TxnType]uint64) []byte {
+	data := []byte{}
+	data = append(data, UintToBuf(uint64(len(mm)))...)
+
+	keys := make([]TxnType, 0, len(mm))
+	for key := range mm {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(ii, jj int) bool {
+		return keys[ii] < keys[jj]
+	})
+	for _, key := range keys {
+		data = append(data, UintToBuf(uint64(key))...)
+		data = append(data, UintToBuf(mm[key])...)
+	}
+	return data
+}
+
+func DeserializeTxnTypeToUint64Map(data []byte) (map[TxnType]uint64, error) {
+	return ReadTxnTypeToUint64Map(bytes.NewReader(data))
+}
+
+func ReadTxnTypeToUint64Map(rr *bytes.Reader) (map[TxnType]uint64, error) {
+	numKeys, err := ReadUvarint(rr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ReadTxnTypeToUint64Map: Problem reading num keys")
+	}
+	if numKeys > uint64(len(AllTxnTypes)) {
+		return nil, fmt.Errorf("ReadTxnTypeToUint64Map: Got %d keys but there are only %d txn types",
+			numKeys, len(AllTxnTypes))
+	}
+	mm := make(map[TxnType]uint64, numKeys)
+	for ii := uint64(0); ii < numKeys; ii++ {
+		key, err := ReadUvarint(rr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ReadTxnTypeToUint64Map: Problem reading key")
+		}
+		if key > math.MaxUint8 {
+			return nil, fmt.Errorf("ReadTxnTypeToUint64Map: Txn type %d is out of range", key)
+		}
+		if _, exists := mm[TxnType(key)]; exists {
+			return nil, fmt.Errorf("ReadTxnTypeToUint64Map: Duplicate txn type %v", TxnType(key))
+		}
+		val, err := ReadUvarint(rr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ReadTxnTypeToUint64Map: Problem reading value for txn type %v",
+				TxnType(key))
+		}
+		mm[TxnType(key)] = val
+	}
+
+	return mm, nil
+}
+
 // ==================================================================
 // MessagingGroupMetadata
 // ==================================================================
@@ -6458,3 +7233,36 @@ func (txnData *MessagingGroupMetadata) FromBytes(data []byte) error {
 func (txnData *MessagingGroupMetadata) New() DeSoTxnMetadata {
 	return &MessagingGroupMetadata{}
 }
+
+func ExtractBitcoinPublicKeyFromBitcoinTransactionInputs(
+	bitcoinTransaction *wire.MsgTx, btcdParams *chaincfg.Params) (
+	_publicKey *btcec.PublicKey, _err error) {
+
+	for _, input := range bitcoinTransaction.TxIn {
+		// P2PKH follows the form: <sig len> <sig> <pubKeyLen> <pubKey>
+		if len(input.SignatureScript) == 0 {
+			continue
+		}
+		sigLen := input.SignatureScript[0]
+		pubKeyStart := sigLen + 2
+		pubKeyBytes := input.SignatureScript[pubKeyStart:]
+		addr, err := btcutil.NewAddressPubKey(pubKeyBytes, btcdParams)
+		if err != nil {
+			continue
+		}
+
+		// If we were able to successfully decode the bytes into a public key, return it.
+		if addr.PubKey() != nil {
+			return addr.PubKey(), nil
+		}
+
+		// If we get here it means we could not extract a public key from this
+		// particular input. This is OK as long as we can find a public key in
+		// one of the other inputs.
+	}
+
+	// If we get here it means we went through all the inputs and were not able to
+	// successfully decode a public key from the inputs. Error in this case.
+	return nil, fmt.Errorf("ExtractBitcoinPublicKeyFromBitcoinTransactionInputs: " +
+		"No valid public key found after scanning all input signature scripts")
+}
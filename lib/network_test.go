@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -1525,7 +1527,7 @@ func TestUnlimitedSpendingLimitMetamaskEncoding(t *testing.T) {
 	// Test the spending limit encoding using the standard scheme.
 	spendingLimitBytes, err := spendingLimit.ToBytes(1)
 	require.NoError(err)
-	require.Equal(true, reflect.DeepEqual(spendingLimitBytes, []byte{0, 0, 0, 0, 0, 0, 1}))
+	require.Equal(true, reflect.DeepEqual(spendingLimitBytes, []byte{0, 0, 0, 0, 0, 0, 1, 0, 0}))
 
 	// Test the spending limit encoding using the metamask scheme.
 	require.Equal(true, reflect.DeepEqual(
@@ -1534,6 +1536,58 @@ func TestUnlimitedSpendingLimitMetamaskEncoding(t *testing.T) {
 	))
 }
 
+// Test encoding of the derived key txn type scope and the per-txn type DESO limits.
+func TestTxnTypeScopingSpendingLimitEncoding(t *testing.T) {
+	require := require.New(t)
+
+	// Set the blockheights for encoder migration.
+	GlobalDeSoParams = DeSoTestnetParams
+	GlobalDeSoParams.ForkHeights.DeSoUnlimitedDerivedKeysBlockHeight = 0
+	for ii := range GlobalDeSoParams.EncoderMigrationHeightsList {
+		GlobalDeSoParams.EncoderMigrationHeightsList[ii].Height = 0
+	}
+
+	allowedTxnTypes := NewTxnTypeBitmap(TxnTypeBasicTransfer, TxnTypeSubmitPost, TxnTypeLike)
+	require.True(allowedTxnTypes.Contains(TxnTypeSubmitPost))
+	require.False(allowedTxnTypes.Contains(TxnTypeFollow))
+	require.Equal([]TxnType{TxnTypeBasicTransfer, TxnTypeSubmitPost, TxnTypeLike}, allowedTxnTypes.TxnTypes())
+	require.NoError(allowedTxnTypes.Validate())
+	require.Error(NewTxnTypeBitmap(TxnTypeBlockReward).Validate())
+	require.Error(TxnTypeBitmap(1 << 63).Validate())
+
+	spendingLimit := &TransactionSpendingLimit{
+		GlobalDESOLimit:          100,
+		TransactionCountLimitMap: map[TxnType]uint64{TxnTypeSubmitPost: 5},
+		AllowedTxnTypes:          allowedTxnTypes,
+		TxnTypeDESOLimitMap:      map[TxnType]uint64{TxnTypeBasicTransfer: 10, TxnTypeLike: 2},
+	}
+	spendingLimitBytes, err := spendingLimit.ToBytes(1)
+	require.NoError(err)
+
+	decodedSpendingLimit := &TransactionSpendingLimit{}
+	require.NoError(decodedSpendingLimit.FromBytes(1, bytes.NewReader(spendingLimitBytes)))
+	require.Equal(allowedTxnTypes, decodedSpendingLimit.AllowedTxnTypes)
+	require.Equal(spendingLimit.TxnTypeDESOLimitMap, decodedSpendingLimit.TxnTypeDESOLimitMap)
+
+	// A scope can be set on an unlimited key, but a per-txn type DESO limit can't.
+	blockHeight := uint32(1)
+	utxoView := &UtxoView{Params: &GlobalDeSoParams}
+	isUnlimited, err := utxoView.CheckIfValidUnlimitedSpendingLimit(&TransactionSpendingLimit{
+		IsUnlimited:     true,
+		AllowedTxnTypes: allowedTxnTypes,
+	}, blockHeight)
+	require.NoError(err)
+	require.True(isUnlimited)
+	_, err = utxoView.CheckIfValidUnlimitedSpendingLimit(&TransactionSpendingLimit{
+		IsUnlimited:         true,
+		TxnTypeDESOLimitMap: map[TxnType]uint64{TxnTypeBasicTransfer: 10},
+	}, blockHeight)
+	require.Equal(RuleErrorUnlimitedDerivedKeyNonEmptySpendingLimits, err)
+
+	// The scope shows up in the metamask string.
+	require.Contains(spendingLimit.ToMetamaskString(&GlobalDeSoParams), "Allowed Transaction Types: \n")
+}
+
 // Verify that DeSoSignature.SerializeCompact correctly encodes the signature into compact format.
 func TestDeSoSignature_SerializeCompact(t *testing.T) {
 	require := require.New(t)
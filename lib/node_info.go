@@ -0,0 +1,166 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime/debug"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// DBSchemaVersion is the version of the layout of the db. Bump it whenever a change makes existing
+// data directories unreadable, so that nodes refuse to open the data directories of the other
+// version instead of misreading them.
+const DBSchemaVersion uint64 = 1
+
+// NodeInfo records the node software and configuration that last opened a data directory. It's
+// checked and rewritten by CheckAndUpdateNodeInfo every time the node starts.
+type NodeInfo struct {
+	// SoftwareVersion is the version of the core module the node was built from, or "(devel)" for
+	// builds that don't have a version.
+	SoftwareVersion string
+	SchemaVersion   uint64
+	// PrefixRegistryHash identifies the set of db prefixes the node knew about. See
+	// ComputePrefixRegistryHash.
+	PrefixRegistryHash *BlockHash
+	NetworkType        NetworkType
+	GenesisBlockHash   *BlockHash
+}
+
+// NewNodeInfo returns the NodeInfo of this node when it runs with the params.
+func NewNodeInfo(params *DeSoParams) *NodeInfo {
+	return &NodeInfo{
+		SoftwareVersion:    GetNodeSoftwareVersion(),
+		SchemaVersion:      DBSchemaVersion,
+		PrefixRegistryHash: ComputePrefixRegistryHash(),
+		NetworkType:        params.NetworkType,
+		GenesisBlockHash:   MustDecodeHexBlockHash(params.GenesisBlockHashHex),
+	}
+}
+
+func (nodeInfo *NodeInfo) ToBytes() []byte {
+	data := EncodeByteArray([]byte(nodeInfo.SoftwareVersion))
+	data = append(data, UintToBuf(nodeInfo.SchemaVersion)...)
+	data = append(data, nodeInfo.PrefixRegistryHash[:]...)
+	data = append(data, UintToBuf(uint64(nodeInfo.NetworkType))...)
+	data = append(data, nodeInfo.GenesisBlockHash[:]...)
+	return data
+}
+
+func (nodeInfo *NodeInfo) FromBytes(rr io.Reader) error {
+	softwareVersion, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "NodeInfo.FromBytes: Problem reading SoftwareVersion")
+	}
+	nodeInfo.SoftwareVersion = string(softwareVersion)
+	if nodeInfo.SchemaVersion, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "NodeInfo.FromBytes: Problem reading SchemaVersion")
+	}
+	nodeInfo.PrefixRegistryHash = &BlockHash{}
+	if _, err = io.ReadFull(rr, nodeInfo.PrefixRegistryHash[:]); err != nil {
+		return errors.Wrapf(err, "NodeInfo.FromBytes: Problem reading PrefixRegistryHash")
+	}
+	networkType, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "NodeInfo.FromBytes: Problem reading NetworkType")
+	}
+	nodeInfo.NetworkType = NetworkType(networkType)
+	nodeInfo.GenesisBlockHash = &BlockHash{}
+	if _, err = io.ReadFull(rr, nodeInfo.GenesisBlockHash[:]); err != nil {
+		return errors.Wrapf(err, "NodeInfo.FromBytes: Problem reading GenesisBlockHash")
+	}
+	return nil
+}
+
+// GetNodeSoftwareVersion returns the version of the core module in the running binary.
+func GetNodeSoftwareVersion() string {
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	if buildInfo.Main.Path == "github.com/deso-protocol/core" {
+		return buildInfo.Main.Version
+	}
+	for _, dep := range buildInfo.Deps {
+		if dep.Path == "github.com/deso-protocol/core" {
+			return dep.Version
+		}
+	}
+	return "(devel)"
+}
+
+// ComputePrefixRegistryHash hashes the id, name and state flag of every db prefix. It changes
+// whenever a prefix is added, renamed or moved between state and non-state.
+func ComputePrefixRegistryHash() *BlockHash {
+	var registry bytes.Buffer
+	for _, prefixInfo := range PrefixRegistry.GetPrefixInfos() {
+		registry.WriteString(fmt.Sprintf("%v %v %v\n", prefixInfo.Prefix[0], prefixInfo.Name, prefixInfo.IsState))
+	}
+	return Sha256DoubleHash(registry.Bytes())
+}
+
+// ErrIncompatibleDataDirectory is returned when a data directory was created by a node whose
+// configuration can't read it.
+type ErrIncompatibleDataDirectory struct {
+	Reason string
+}
+
+func (err *ErrIncompatibleDataDirectory) Error() string {
+	return fmt.Sprintf("The data directory is incompatible with this node: %v", err.Reason)
+}
+
+// IsErrIncompatibleDataDirectory returns true if the error, or any error it wraps, is an
+// ErrIncompatibleDataDirectory.
+func IsErrIncompatibleDataDirectory(err error) bool {
+	var incompatibleErr *ErrIncompatibleDataDirectory
+	return errors.As(err, &incompatibleErr)
+}
+
+// CheckNodeInfoCompatibility returns an ErrIncompatibleDataDirectory if a node described by
+// nodeInfo can't open a data directory last opened by the node described by dbNodeInfo. Different
+// software versions and prefix registries are compatible, since new versions add prefixes.
+func CheckNodeInfoCompatibility(dbNodeInfo *NodeInfo, nodeInfo *NodeInfo) error {
+	if dbNodeInfo.NetworkType != nodeInfo.NetworkType {
+		return &ErrIncompatibleDataDirectory{Reason: fmt.Sprintf(
+			"it was created for %v but the node is running on %v", dbNodeInfo.NetworkType, nodeInfo.NetworkType)}
+	}
+	if *dbNodeInfo.GenesisBlockHash != *nodeInfo.GenesisBlockHash {
+		return &ErrIncompatibleDataDirectory{Reason: fmt.Sprintf(
+			"it was created for genesis block %v but the node's genesis block is %v",
+			dbNodeInfo.GenesisBlockHash, nodeInfo.GenesisBlockHash)}
+	}
+	if dbNodeInfo.SchemaVersion != nodeInfo.SchemaVersion {
+		return &ErrIncompatibleDataDirectory{Reason: fmt.Sprintf(
+			"it has db schema version %v but the node uses version %v. Resync into a new data directory",
+			dbNodeInfo.SchemaVersion, nodeInfo.SchemaVersion)}
+	}
+	return nil
+}
+
+// CheckAndUpdateNodeInfo is called when the node opens its db. It refuses data directories that were
+// last opened by an incompatible node, and otherwise records this node as the last one to open it.
+func CheckAndUpdateNodeInfo(handle DeSoDB, params *DeSoParams) (*NodeInfo, error) {
+	nodeInfo := NewNodeInfo(params)
+	err := handle.Update(func(txn DeSoDBTxn) error {
+		dbNodeInfo, err := DbGetNodeInfoWithTxn(txn)
+		if err != nil {
+			return err
+		}
+		if dbNodeInfo != nil {
+			if err = CheckNodeInfoCompatibility(dbNodeInfo, nodeInfo); err != nil {
+				return err
+			}
+			if *dbNodeInfo.PrefixRegistryHash != *nodeInfo.PrefixRegistryHash {
+				glog.Infof("CheckAndUpdateNodeInfo: The db prefixes changed since the data directory was "+
+					"last opened by software version %v", dbNodeInfo.SoftwareVersion)
+			}
+		}
+		return DbPutNodeInfoWithTxn(txn, nodeInfo)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "CheckAndUpdateNodeInfo: ")
+	}
+	return nodeInfo, nil
+}
@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeInfo(t *testing.T) {
+	require := require.New(t)
+
+	// The node info functions work on any DeSoDB, so the test runs on the in-memory backend.
+	db := NewMemDeSoDB()
+
+	// Data directories that were never opened don't have node info.
+	nodeInfo, err := DbGetNodeInfo(db)
+	require.NoError(err)
+	require.Nil(nodeInfo)
+
+	// The first node to open the data directory records itself.
+	expectedNodeInfo, err := CheckAndUpdateNodeInfo(db, &DeSoTestnetParams)
+	require.NoError(err)
+	require.Equal(DBSchemaVersion, expectedNodeInfo.SchemaVersion)
+	require.Equal(NetworkType_TESTNET, expectedNodeInfo.NetworkType)
+	nodeInfo, err = DbGetNodeInfo(db)
+	require.NoError(err)
+	require.Equal(expectedNodeInfo, nodeInfo)
+
+	// Reopening with the same params is fine, but not with the params of another network.
+	_, err = CheckAndUpdateNodeInfo(db, &DeSoTestnetParams)
+	require.NoError(err)
+	_, err = CheckAndUpdateNodeInfo(db, &DeSoMainnetParams)
+	require.True(IsErrIncompatibleDataDirectory(err))
+
+	// A data directory with another schema version is refused, but a different prefix registry
+	// isn't, since new versions add prefixes.
+	putNodeInfo := func(update func(nodeInfo *NodeInfo)) {
+		dbNodeInfo := NewNodeInfo(&DeSoTestnetParams)
+		update(dbNodeInfo)
+		require.NoError(db.Update(func(txn DeSoDBTxn) error {
+			return DbPutNodeInfoWithTxn(txn, dbNodeInfo)
+		}))
+	}
+	putNodeInfo(func(nodeInfo *NodeInfo) { nodeInfo.SchemaVersion = DBSchemaVersion + 1 })
+	_, err = CheckAndUpdateNodeInfo(db, &DeSoTestnetParams)
+	require.True(IsErrIncompatibleDataDirectory(err))
+
+	putNodeInfo(func(nodeInfo *NodeInfo) {
+		nodeInfo.SoftwareVersion = "v0.0.1"
+		nodeInfo.PrefixRegistryHash = &BlockHash{}
+	})
+	nodeInfo, err = CheckAndUpdateNodeInfo(db, &DeSoTestnetParams)
+	require.NoError(err)
+	require.Equal(ComputePrefixRegistryHash(), nodeInfo.PrefixRegistryHash)
+}
@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -128,6 +130,12 @@ type Peer struct {
 	// still needs a mempool download, this is false.
 	canReceiveInvMessagess bool
 
+	// invTokens is the number of inv items the peer can still send us under the
+	// inv rate limit, as of invTokensUpdatedAt. These are only accessed from the
+	// Server's messageHandler thread. See PeerBanManager.AllowInv.
+	invTokens          float64
+	invTokensUpdatedAt time.Time
+
 	// We process GetTransaction requests in a separate loop. This allows us
 	// to ensure that the responses are ordered.
 	mtxMessageQueue deadlock.RWMutex
@@ -184,7 +192,7 @@ func (pp *Peer) HandleGetTransactionsMsg(getTxnMsg *MsgDeSoGetTransactions) {
 		len(getTxnMsg.HashList), pp)
 
 	mempoolTxs := []*MempoolTx{}
-	txnMap := pp.srv.mempool.readOnlyUniversalTransactionMap
+	_, txnMap := pp.srv.mempool.getReadOnlyUniversalTransactions()
 	for _, txHash := range getTxnMsg.HashList {
 		mempoolTx, exists := txnMap[*txHash]
 		// If the transaction isn't in the pool, just continue without adding
@@ -352,6 +360,26 @@ func (pp *Peer) HelpHandleInv(msg *MsgDeSoInv) {
 	}
 }
 
+// _consumeInvTokens refills the peer's inv tokens at maxItemsPerSecond, and takes numItems of them
+// if it has enough. A peer starts out with a full bucket of PeerInvRateLimitBurst tokens.
+func (pp *Peer) _consumeInvTokens(numItems int, maxItemsPerSecond uint64, now time.Time) bool {
+	if pp.invTokensUpdatedAt.IsZero() {
+		pp.invTokens = PeerInvRateLimitBurst
+	} else if elapsed := now.Sub(pp.invTokensUpdatedAt); elapsed > 0 {
+		pp.invTokens += elapsed.Seconds() * float64(maxItemsPerSecond)
+		if pp.invTokens > PeerInvRateLimitBurst {
+			pp.invTokens = PeerInvRateLimitBurst
+		}
+	}
+	pp.invTokensUpdatedAt = now
+
+	if float64(numItems) > pp.invTokens {
+		return false
+	}
+	pp.invTokens -= float64(numItems)
+	return true
+}
+
 func (pp *Peer) HandleInv(msg *MsgDeSoInv) {
 	// Ignore invs while we're still syncing and before we've requested
 	// all mempool transactions from one of our peers to bootstrap.
@@ -402,6 +430,58 @@ func (pp *Peer) HandleGetBlocks(msg *MsgDeSoGetBlocks) {
 	}
 }
 
+// HandleGetCompactBlocks is like HandleGetBlocks except that it sends the blocks as compact blocks.
+// Txns the Peer has announced to us or that we've announced to the Peer are replaced by short IDs,
+// everything else is sent in full.
+func (pp *Peer) HandleGetCompactBlocks(msg *MsgDeSoGetCompactBlocks) {
+	// Nothing to do if the request is empty.
+	if len(msg.HashList) == 0 {
+		glog.V(1).Infof("Peer.HandleGetCompactBlocks: Received empty GetCompactBlocks "+
+			"request. No response needed for Peer %v", pp)
+		return
+	}
+
+	// Peers that didn't negotiate compact blocks shouldn't be asking for them.
+	if !pp.SupportsCompactBlocks() {
+		glog.Errorf("Peer.HandleGetCompactBlocks: Disconnecting peer %v because "+
+			"she asked for compact blocks without negotiating protocol version %d",
+			pp, CompactBlocksProtocolVersion)
+		pp.Disconnect()
+		return
+	}
+
+	isTxnKnownToPeer := func(txHash *BlockHash) bool {
+		return pp.knownInventory.Contains(InvVect{Type: InvTypeTx, Hash: *txHash})
+	}
+	for _, hashToSend := range msg.HashList {
+		blockToSend := pp.srv.blockchain.GetBlock(hashToSend)
+		if blockToSend == nil {
+			// Don't ask us for blocks before verifying that we have them with a
+			// GetHeaders request.
+			glog.Errorf("Peer.HandleGetCompactBlocks: Disconnecting peer %v because "+
+				"she asked for a block with hash %v that we don't have", pp, hashToSend)
+			pp.Disconnect()
+			return
+		}
+
+		nonce, err := wire.RandomUint64()
+		if err != nil {
+			glog.Errorf("Peer.HandleGetCompactBlocks: Problem generating nonce, sending "+
+				"full block %v to Peer %v instead: %v", hashToSend, pp, err)
+			pp.AddDeSoMessage(blockToSend, false)
+			continue
+		}
+		compactBlock, err := NewCompactBlock(blockToSend, nonce, isTxnKnownToPeer)
+		if err != nil {
+			glog.Errorf("Peer.HandleGetCompactBlocks: Problem building compact block, sending "+
+				"full block %v to Peer %v instead: %v", hashToSend, pp, err)
+			pp.AddDeSoMessage(blockToSend, false)
+			continue
+		}
+		pp.AddDeSoMessage(compactBlock, false)
+	}
+}
+
 // HandleGetSnapshot gets called whenever we receive a GetSnapshot message from a peer. This means
 // a peer is asking us to send him some data from our most recent snapshot. To respond to the peer we
 // will retrieve the chunk from our main and ancestral records db and attach it to the response message.
@@ -567,6 +647,12 @@ func (pp *Peer) StartDeSoMessageProcessor() {
 					"num hashes %v from peer %v", msgToProcess.DeSoMessage.GetMsgType(), len(msg.HashList), pp)
 				pp.HandleGetBlocks(msg)
 
+			} else if msgToProcess.DeSoMessage.GetMsgType() == MsgTypeGetCompactBlocks {
+				msg := msgToProcess.DeSoMessage.(*MsgDeSoGetCompactBlocks)
+				glog.V(1).Infof("StartDeSoMessageProcessor: RECEIVED message of type %v with "+
+					"num hashes %v from peer %v", msgToProcess.DeSoMessage.GetMsgType(), len(msg.HashList), pp)
+				pp.HandleGetCompactBlocks(msg)
+
 			} else if msgToProcess.DeSoMessage.GetMsgType() == MsgTypeGetSnapshot {
 				msg := msgToProcess.DeSoMessage.(*MsgDeSoGetSnapshot)
 				glog.V(1).Infof("StartDeSoMessageProcessor: RECEIVED message of type %v with start key %v "+
@@ -799,6 +885,20 @@ func (pp *Peer) _handleOutExpectedResponse(msg DeSoMessage) {
 		}
 	}
 
+	// A GetCompactBlocks message is answered the same way, except that each block can come
+	// back as a compact block. We still expect a MsgTypeBlock for it since a compact block
+	// we can't reconstruct is followed by a request for the full block.
+	if msg.GetMsgType() == MsgTypeGetCompactBlocks {
+		getCompactBlocks := msg.(*MsgDeSoGetCompactBlocks)
+		for ii := range getCompactBlocks.HashList {
+			pp._addExpectedResponse(&ExpectedResponse{
+				TimeExpected: time.Now().Add(
+					stallTimeout + time.Duration(int64(ii)*int64(stallTimeout))),
+				MessageType: MsgTypeBlock,
+			})
+		}
+	}
+
 	// If we're sending a GetHeaders message, the Peer should respond within
 	// a few seconds with a HeaderBundle.
 	if msg.GetMsgType() == MsgTypeGetHeaders {
@@ -890,6 +990,11 @@ out:
 				hash, _ := msg.(*MsgDeSoBlock).Hash()
 				delete(pp.blocksToSend, *hash)
 				pp.blocksToSendMtx.Unlock()
+			} else if msg.GetMsgType() == MsgTypeCompactBlock {
+				pp.blocksToSendMtx.Lock()
+				hash, _ := msg.(*MsgDeSoCompactBlock).Hash()
+				delete(pp.blocksToSend, *hash)
+				pp.blocksToSendMtx.Unlock()
 			}
 
 			// Before we send an addr message to the peer, filter out the addresses
@@ -937,20 +1042,25 @@ out:
 }
 
 func (pp *Peer) _maybeAddBlocksToSend(msg DeSoMessage) error {
-	// If the input is not a GetBlocks message, don't do anything.
-	if msg.GetMsgType() != MsgTypeGetBlocks {
+	// If the input is not a GetBlocks or GetCompactBlocks message, don't do anything.
+	var hashList []*BlockHash
+	switch getBlocks := msg.(type) {
+	case *MsgDeSoGetBlocks:
+		hashList = getBlocks.HashList
+	case *MsgDeSoGetCompactBlocks:
+		hashList = getBlocks.HashList
+	default:
 		return nil
 	}
 
-	// At this point, we're sure this is a GetBlocks message. Acquire the
-	// blocksToSend mutex and cast the message.
+	// At this point, we're sure blocks were requested. Acquire the
+	// blocksToSend mutex.
 	pp.blocksToSendMtx.Lock()
 	defer pp.blocksToSendMtx.Unlock()
-	getBlocks := msg.(*MsgDeSoGetBlocks)
 
 	// When blocks have been requested, add them to the list of blocks we're
 	// in the process of sending to the Peer.
-	for _, hash := range getBlocks.HashList {
+	for _, hash := range hashList {
 		pp.blocksToSend[*hash] = true
 	}
 
@@ -1014,8 +1124,13 @@ func (pp *Peer) _handleInExpectedResponse(rmsg DeSoMessage) error {
 	if msgType == MsgTypeBlock ||
 		msgType == MsgTypeHeaderBundle ||
 		msgType == MsgTypeTransactionBundle ||
-		msgType == MsgTypeSnapshotData {
+		msgType == MsgTypeSnapshotData ||
+		msgType == MsgTypeCompactBlock {
 
+		// A compact block stands in for the block we requested.
+		if msgType == MsgTypeCompactBlock {
+			msgType = MsgTypeBlock
+		}
 		expectedResponse := pp._removeEarliestExpectedResponse(msgType)
 		if expectedResponse == nil {
 			// We should never get one of these types of messages unless we've previously
@@ -1056,6 +1171,9 @@ out:
 		if err != nil {
 			glog.Errorf("Peer.inHandler: Can't read message from peer %v: %v", pp, err)
 
+			if errors.Cause(err) == ErrMessagePayloadTooLarge && pp.srv != nil {
+				pp.srv._recordPeerMisbehavior(pp, PeerMisbehaviorOversizedMessage)
+			}
 			break out
 		}
 
@@ -1166,6 +1284,14 @@ func (pp *Peer) Start() {
 	// Send our verack message now that the IO processing machinery has started.
 }
 
+// SupportsCompactBlocks returns true if we can exchange compact blocks with the Peer.
+func (pp *Peer) SupportsCompactBlocks() bool {
+	pp.PeerInfoMtx.Lock()
+	defer pp.PeerInfoMtx.Unlock()
+
+	return pp.negotiatedProtocolVersion >= CompactBlocksProtocolVersion
+}
+
 func (pp *Peer) IsSyncCandidate() bool {
 	isFullNode := (pp.serviceFlags & SFFullNodeDeprecated) != 0
 	// TODO: This is a bit of a messy way to determine whether the node was run with --hypersync
@@ -1502,3 +1628,13 @@ func (pp *Peer) _logAddPeer() {
 	logStr := fmt.Sprintf("ADDING (%s) (%s) peer (%v)", inboundStr, persistentStr, pp)
 	glog.V(1).Info(logStr)
 }
+
+// AllowInv returns whether the peer is under the inv rate limit after sending us an inv with
+// numItems items. Peers get maxInvItemsPerSecond items per second, up to PeerInvRateLimitBurst at
+// once. It must only be called from the Server's messageHandler thread.
+func (banManager *PeerBanManager) AllowInv(pp *Peer, numItems int) bool {
+	if banManager == nil || banManager.maxInvItemsPerSecond == 0 {
+		return true
+	}
+	return pp._consumeInvTokens(numItems, banManager.maxInvItemsPerSecond, time.Now())
+}
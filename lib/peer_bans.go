@@ -0,0 +1,335 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// PeerMisbehaviorType is a kind of misbehavior that counts towards banning a peer.
+type PeerMisbehaviorType uint8
+
+const (
+	// PeerMisbehaviorInvalidBlock is a block that failed validation for a reason other than
+	// being a duplicate.
+	PeerMisbehaviorInvalidBlock PeerMisbehaviorType = 0
+	// PeerMisbehaviorOversizedMessage is a message with a payload bigger than MaxMessagePayload.
+	PeerMisbehaviorOversizedMessage PeerMisbehaviorType = 1
+	// PeerMisbehaviorInvSpam is an inv that put the peer over the inv rate limit.
+	PeerMisbehaviorInvSpam PeerMisbehaviorType = 2
+)
+
+func (misbehavior PeerMisbehaviorType) String() string {
+	switch misbehavior {
+	case PeerMisbehaviorInvalidBlock:
+		return "InvalidBlock"
+	case PeerMisbehaviorOversizedMessage:
+		return "OversizedMessage"
+	case PeerMisbehaviorInvSpam:
+		return "InvSpam"
+	default:
+		return fmt.Sprintf("PeerMisbehaviorType(%d)", uint8(misbehavior))
+	}
+}
+
+// Score is how much the misbehavior adds to the peer's misbehavior score. With a ban threshold
+// of 100, one invalid block gets a peer banned, while it takes two oversized messages or ten inv
+// floods in quick succession.
+func (misbehavior PeerMisbehaviorType) Score() float64 {
+	switch misbehavior {
+	case PeerMisbehaviorInvalidBlock:
+		return 100
+	case PeerMisbehaviorOversizedMessage:
+		return 50
+	case PeerMisbehaviorInvSpam:
+		return 10
+	default:
+		return 0
+	}
+}
+
+// PeerBanEntry is a ban of an IP. Reason is either the misbehavior that got the peer banned or
+// the reason given to Server.BanPeer.
+type PeerBanEntry struct {
+	IP               net.IP
+	BannedUntilNanos uint64
+	Reason           string
+}
+
+func (entry *PeerBanEntry) ToBytes() []byte {
+	data := EncodeByteArray(entry.IP.To16())
+	data = append(data, UintToBuf(entry.BannedUntilNanos)...)
+	data = append(data, EncodeByteArray([]byte(entry.Reason))...)
+	return data
+}
+
+func (entry *PeerBanEntry) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+	var err error
+
+	ipBytes, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "PeerBanEntry.FromBytes: Problem reading IP")
+	}
+	if len(ipBytes) != net.IPv6len {
+		return fmt.Errorf("PeerBanEntry.FromBytes: IP has %d bytes instead of %d", len(ipBytes), net.IPv6len)
+	}
+	entry.IP = net.IP(ipBytes)
+	if entry.BannedUntilNanos, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "PeerBanEntry.FromBytes: Problem reading BannedUntilNanos")
+	}
+	reasonBytes, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "PeerBanEntry.FromBytes: Problem reading Reason")
+	}
+	entry.Reason = string(reasonBytes)
+
+	return nil
+}
+
+func (entry *PeerBanEntry) isExpired(now time.Time) bool {
+	return entry.BannedUntilNanos <= uint64(now.UnixNano())
+}
+
+// peerMisbehaviorScore is a peer's misbehavior score as of updatedAt. The score decays with
+// PeerMisbehaviorHalfLife.
+type peerMisbehaviorScore struct {
+	score     float64
+	updatedAt time.Time
+}
+
+func (score *peerMisbehaviorScore) decayedScore(now time.Time) float64 {
+	elapsed := now.Sub(score.updatedAt)
+	if elapsed <= 0 {
+		return score.score
+	}
+	return score.score * math.Pow(0.5, float64(elapsed)/float64(PeerMisbehaviorHalfLife))
+}
+
+// PeerBanManager keeps track of how much each peer IP misbehaves, and bans the IPs whose score
+// crosses the ban threshold. Bans are persisted in PrefixPeerBans so that they survive restarts,
+// while misbehavior scores are only kept in memory. The ConnectionManager refuses connections from
+// and to banned IPs, and the Server disconnects the peers of an IP when it gets banned.
+type PeerBanManager struct {
+	db *badger.DB
+
+	// banThreshold is the misbehavior score at which a peer is banned. If it's zero, peers are never
+	// banned automatically, but they can still be banned through the Server.
+	banThreshold uint64
+	// banDuration is how long automatic bans last, and the default for bans through the Server.
+	banDuration time.Duration
+	// maxInvItemsPerSecond is the rate of inv items a peer can send us before its invs are dropped
+	// and counted as PeerMisbehaviorInvSpam. If it's zero, invs aren't rate limited.
+	maxInvItemsPerSecond uint64
+
+	mtx sync.Mutex
+	// bans and scores are keyed by the String() of the IP.
+	bans   map[string]*PeerBanEntry
+	scores map[string]*peerMisbehaviorScore
+}
+
+// NewPeerBanManager loads the bans that haven't expired from the db, and deletes the ones that have.
+func NewPeerBanManager(db *badger.DB, banThreshold uint64, banDuration time.Duration,
+	maxInvItemsPerSecond uint64) (*PeerBanManager, error) {
+
+	banManager := &PeerBanManager{
+		db:                   db,
+		banThreshold:         banThreshold,
+		banDuration:          banDuration,
+		maxInvItemsPerSecond: maxInvItemsPerSecond,
+		bans:                 make(map[string]*PeerBanEntry),
+		scores:               make(map[string]*peerMisbehaviorScore),
+	}
+
+	entries, err := DbGetPeerBanEntries(db)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewPeerBanManager: Problem loading bans")
+	}
+	now := time.Now()
+	err = db.Update(func(txn *badger.Txn) error {
+		for _, entry := range entries {
+			if !entry.isExpired(now) {
+				banManager.bans[entry.IP.String()] = entry
+				continue
+			}
+			if err := DbDeletePeerBanEntryWithTxn(txn, entry.IP); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewPeerBanManager: Problem deleting expired bans")
+	}
+	return banManager, nil
+}
+
+// RecordMisbehavior adds the misbehavior to the IP's score, and bans the IP if the score crosses the
+// ban threshold. It returns whether the IP got banned.
+func (banManager *PeerBanManager) RecordMisbehavior(ip net.IP, misbehavior PeerMisbehaviorType) (_banned bool) {
+	return banManager._recordMisbehavior(ip, misbehavior, time.Now())
+}
+
+func (banManager *PeerBanManager) _recordMisbehavior(ip net.IP, misbehavior PeerMisbehaviorType,
+	now time.Time) (_banned bool) {
+
+	if banManager == nil || ip == nil {
+		return false
+	}
+
+	banManager.mtx.Lock()
+	defer banManager.mtx.Unlock()
+
+	ipKey := ip.String()
+	score, exists := banManager.scores[ipKey]
+	if !exists {
+		score = &peerMisbehaviorScore{}
+		banManager.scores[ipKey] = score
+	}
+	score.score = score.decayedScore(now) + misbehavior.Score()
+	score.updatedAt = now
+	glog.V(1).Infof("PeerBanManager.RecordMisbehavior: Peer %v misbehaved with %v, score is now %.2f",
+		ipKey, misbehavior, score.score)
+
+	if banManager.banThreshold == 0 || score.score < float64(banManager.banThreshold) {
+		return false
+	}
+	glog.Infof("PeerBanManager.RecordMisbehavior: Banning peer %v for %v because its misbehavior "+
+		"score %.2f reached the ban threshold %d, last misbehavior %v",
+		ipKey, banManager.banDuration, score.score, banManager.banThreshold, misbehavior)
+	if err := banManager._ban(ip, now.Add(banManager.banDuration), misbehavior.String()); err != nil {
+		glog.Errorf("PeerBanManager.RecordMisbehavior: %v", err)
+	}
+	// The peer is disconnected even if the ban couldn't be persisted.
+	return true
+}
+
+// GetMisbehaviorScore returns the IP's current, decayed misbehavior score.
+func (banManager *PeerBanManager) GetMisbehaviorScore(ip net.IP) float64 {
+	if banManager == nil {
+		return 0
+	}
+
+	banManager.mtx.Lock()
+	defer banManager.mtx.Unlock()
+
+	score, exists := banManager.scores[ip.String()]
+	if !exists {
+		return 0
+	}
+	return score.decayedScore(time.Now())
+}
+
+// Ban bans the IP for the duration, or for the ban duration the manager was configured with if the
+// duration is zero. Banning an IP that's already banned replaces its ban.
+func (banManager *PeerBanManager) Ban(ip net.IP, duration time.Duration, reason string) error {
+	if ip == nil {
+		return fmt.Errorf("PeerBanManager.Ban: IP is nil")
+	}
+	if duration == 0 {
+		duration = banManager.banDuration
+	}
+
+	banManager.mtx.Lock()
+	defer banManager.mtx.Unlock()
+
+	return banManager._ban(ip, time.Now().Add(duration), reason)
+}
+
+// _ban must be called with the mtx held.
+func (banManager *PeerBanManager) _ban(ip net.IP, bannedUntil time.Time, reason string) error {
+	entry := &PeerBanEntry{
+		IP:               ip.To16(),
+		BannedUntilNanos: uint64(bannedUntil.UnixNano()),
+		Reason:           reason,
+	}
+	// The ban is enforced even if it can't be persisted.
+	banManager.bans[ip.String()] = entry
+	err := banManager.db.Update(func(txn *badger.Txn) error {
+		return DbPutPeerBanEntryWithTxn(txn, entry)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "PeerBanManager._ban: Problem persisting ban of %v", ip)
+	}
+	return nil
+}
+
+// Unban lifts the IP's ban, if it has one, and resets its misbehavior score.
+func (banManager *PeerBanManager) Unban(ip net.IP) error {
+	if ip == nil {
+		return fmt.Errorf("PeerBanManager.Unban: IP is nil")
+	}
+
+	banManager.mtx.Lock()
+	defer banManager.mtx.Unlock()
+
+	delete(banManager.scores, ip.String())
+	return banManager._unban(ip)
+}
+
+// _unban must be called with the mtx held.
+func (banManager *PeerBanManager) _unban(ip net.IP) error {
+	delete(banManager.bans, ip.String())
+	err := banManager.db.Update(func(txn *badger.Txn) error {
+		return DbDeletePeerBanEntryWithTxn(txn, ip)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "PeerBanManager._unban: Problem deleting ban of %v", ip)
+	}
+	return nil
+}
+
+// IsBanned returns whether the IP is banned. Expired bans are deleted when they're looked up.
+func (banManager *PeerBanManager) IsBanned(ip net.IP) bool {
+	if banManager == nil || ip == nil {
+		return false
+	}
+
+	banManager.mtx.Lock()
+	defer banManager.mtx.Unlock()
+
+	entry, exists := banManager.bans[ip.String()]
+	if !exists {
+		return false
+	}
+	if !entry.isExpired(time.Now()) {
+		return true
+	}
+	glog.V(1).Infof("PeerBanManager.IsBanned: Ban of peer %v expired", ip)
+	if err := banManager._unban(ip); err != nil {
+		glog.Errorf("PeerBanManager.IsBanned: %v", err)
+	}
+	return false
+}
+
+// GetBans returns the bans that haven't expired, sorted by IP.
+func (banManager *PeerBanManager) GetBans() []*PeerBanEntry {
+	if banManager == nil {
+		return nil
+	}
+
+	banManager.mtx.Lock()
+	defer banManager.mtx.Unlock()
+
+	now := time.Now()
+	var entries []*PeerBanEntry
+	for _, entry := range banManager.bans {
+		if entry.isExpired(now) {
+			continue
+		}
+		entryCopy := *entry
+		entries = append(entries, &entryCopy)
+	}
+	sort.Slice(entries, func(ii, jj int) bool {
+		return bytes.Compare(entries[ii].IP, entries[jj].IP) < 0
+	})
+	return entries
+}
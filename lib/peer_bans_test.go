@@ -0,0 +1,142 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerBanManager(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	banManager, err := NewPeerBanManager(db, 100, time.Hour, 10)
+	require.NoError(err)
+
+	// Misbehavior adds up until the score reaches the threshold.
+	ip1 := net.ParseIP("10.0.0.1")
+	now := time.Now()
+	require.False(banManager._recordMisbehavior(ip1, PeerMisbehaviorOversizedMessage, now))
+	require.False(banManager.IsBanned(ip1))
+	require.True(banManager._recordMisbehavior(ip1, PeerMisbehaviorOversizedMessage, now))
+	require.True(banManager.IsBanned(ip1))
+	require.True(banManager.IsBanned(net.ParseIP("::ffff:10.0.0.1")))
+	bans := banManager.GetBans()
+	require.Equal(1, len(bans))
+	require.True(ip1.Equal(bans[0].IP))
+	require.Equal(PeerMisbehaviorOversizedMessage.String(), bans[0].Reason)
+	require.InDelta(uint64(now.Add(time.Hour).UnixNano()), bans[0].BannedUntilNanos, float64(time.Second))
+
+	// Scores decay, so misbehavior spread out over time doesn't get a peer banned.
+	ip2 := net.ParseIP("10.0.0.2")
+	require.False(banManager._recordMisbehavior(ip2, PeerMisbehaviorOversizedMessage, now))
+	require.False(banManager._recordMisbehavior(ip2, PeerMisbehaviorOversizedMessage, now.Add(PeerMisbehaviorHalfLife)))
+	require.InDelta(75, banManager.scores[ip2.String()].score, 0.01)
+	require.True(banManager._recordMisbehavior(ip2, PeerMisbehaviorInvalidBlock, now.Add(2*PeerMisbehaviorHalfLife)))
+
+	// Unbanning resets the score.
+	require.NoError(banManager.Unban(ip2))
+	require.False(banManager.IsBanned(ip2))
+	require.Zero(banManager.GetMisbehaviorScore(ip2))
+
+	// Manual bans are persisted along with automatic ones, and expired bans are dropped on load.
+	ip3 := net.ParseIP("2001:db8::3")
+	require.NoError(banManager.Ban(ip3, 0, "spam"))
+	ip4 := net.ParseIP("10.0.0.4")
+	require.NoError(banManager.Ban(ip4, time.Millisecond, "expiring"))
+	time.Sleep(10 * time.Millisecond)
+	entries, err := DbGetPeerBanEntries(db)
+	require.NoError(err)
+	require.Equal(3, len(entries))
+	banManager, err = NewPeerBanManager(db, 100, time.Hour, 10)
+	require.NoError(err)
+	bans = banManager.GetBans()
+	require.Equal(2, len(bans))
+	require.True(ip1.Equal(bans[0].IP))
+	require.True(ip3.Equal(bans[1].IP))
+	require.Equal("spam", bans[1].Reason)
+	require.False(banManager.IsBanned(ip4))
+	entries, err = DbGetPeerBanEntries(db)
+	require.NoError(err)
+	require.Equal(2, len(entries))
+
+	// A ban that expires while the node runs is deleted when it's looked up.
+	require.NoError(banManager.Ban(ip4, time.Millisecond, "expiring"))
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(2, len(banManager.GetBans()))
+	require.False(banManager.IsBanned(ip4))
+	require.NoError(db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(_dbKeyForPeerBan(ip4))
+		require.Equal(badger.ErrKeyNotFound, err)
+		return nil
+	}))
+
+	// Without a threshold, peers are never banned automatically.
+	banManager, err = NewPeerBanManager(db, 0, time.Hour, 0)
+	require.NoError(err)
+	ip5 := net.ParseIP("10.0.0.5")
+	for ii := 0; ii < 10; ii++ {
+		require.False(banManager.RecordMisbehavior(ip5, PeerMisbehaviorInvalidBlock))
+	}
+	require.False(banManager.IsBanned(ip5))
+	require.True(banManager.AllowInv(&Peer{}, 10*PeerInvRateLimitBurst))
+
+	// A nil manager allows everything.
+	var nilBanManager *PeerBanManager
+	require.False(nilBanManager.IsBanned(ip1))
+	require.False(nilBanManager.RecordMisbehavior(ip1, PeerMisbehaviorInvalidBlock))
+	require.True(nilBanManager.AllowInv(&Peer{}, 1))
+}
+
+func TestPeerInvRateLimit(t *testing.T) {
+	require := require.New(t)
+
+	// A peer starts out with a full burst and refills at the rate.
+	pp := &Peer{}
+	now := time.Now()
+	require.True(pp._consumeInvTokens(PeerInvRateLimitBurst-5, 10, now))
+	require.False(pp._consumeInvTokens(10, 10, now))
+	require.True(pp._consumeInvTokens(5, 10, now))
+	require.False(pp._consumeInvTokens(1, 10, now))
+	require.True(pp._consumeInvTokens(10, 10, now.Add(time.Second)))
+	require.False(pp._consumeInvTokens(1, 10, now.Add(time.Second)))
+
+	// The refill is capped at the burst.
+	require.False(pp._consumeInvTokens(PeerInvRateLimitBurst+1, 10, now.Add(time.Hour*24*365)))
+	require.True(pp._consumeInvTokens(PeerInvRateLimitBurst, 10, now.Add(time.Hour*24*365)))
+}
+
+func TestPeerBanEntryEncoding(t *testing.T) {
+	require := require.New(t)
+
+	entry := &PeerBanEntry{
+		IP:               net.ParseIP("192.168.1.1"),
+		BannedUntilNanos: 1234567890,
+		Reason:           "InvalidBlock",
+	}
+	decodedEntry := &PeerBanEntry{}
+	require.NoError(decodedEntry.FromBytes(entry.ToBytes()))
+	require.True(entry.IP.Equal(decodedEntry.IP))
+	require.Equal(entry.BannedUntilNanos, decodedEntry.BannedUntilNanos)
+	require.Equal(entry.Reason, decodedEntry.Reason)
+	require.Error(decodedEntry.FromBytes(EncodeByteArray([]byte{1, 2, 3, 4})))
+
+	// Payloads that are too large are recognizable so the peer can be penalized.
+	msgBytes := []byte{}
+	msgBytes = append(msgBytes, UintToBuf(uint64(NetworkType_MAINNET))...)
+	msgBytes = append(msgBytes, UintToBuf(uint64(MsgTypeInv))...)
+	msgBytes = append(msgBytes, make([]byte, 8)...)
+	msgBytes = append(msgBytes, UintToBuf(MaxMessagePayload+1)...)
+	_, _, err := ReadMessage(bytes.NewReader(msgBytes), NetworkType_MAINNET)
+	require.Error(err)
+	require.Equal(ErrMessagePayloadTooLarge, errors.Cause(err))
+}
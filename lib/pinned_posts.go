@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// Profile owners pin posts to their profile by setting PinnedPostOrderKey in the post's ExtraData,
+// and unpin them by setting it to an empty value, since keys can't be removed from a post's
+// ExtraData. The IndexQueue keeps an index of each poster's pinned posts by pin order, so that
+// clients can fetch a creator's pinned posts with DbGetPinnedPostHashesForPKID instead of scanning
+// their whole post history. Comments and hidden posts aren't pinned. The index is keyed by the PKID
+// the poster had when the post was last reindexed.
+
+// PinnedPostEntry is the index entry of a pinned post.
+type PinnedPostEntry struct {
+	PostHash   *BlockHash
+	PosterPKID *PKID
+	PinOrder   uint64
+}
+
+func (entry *PinnedPostEntry) ToBytes() []byte {
+	data := append([]byte{}, entry.PostHash[:]...)
+	data = append(data, entry.PosterPKID[:]...)
+	data = append(data, UintToBuf(entry.PinOrder)...)
+	return data
+}
+
+func (entry *PinnedPostEntry) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+	var err error
+
+	entry.PostHash = &BlockHash{}
+	if _, err = io.ReadFull(rr, entry.PostHash[:]); err != nil {
+		return errors.Wrapf(err, "PinnedPostEntry.FromBytes: Problem reading PostHash")
+	}
+	entry.PosterPKID = &PKID{}
+	if _, err = io.ReadFull(rr, entry.PosterPKID[:]); err != nil {
+		return errors.Wrapf(err, "PinnedPostEntry.FromBytes: Problem reading PosterPKID")
+	}
+	if entry.PinOrder, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "PinnedPostEntry.FromBytes: Problem reading PinOrder")
+	}
+	return nil
+}
+
+// GetPinnedPostOrder returns the pin order of the post, or false if the post isn't pinned. An empty
+// PinnedPostOrderKey value, or one that isn't a valid uvarint, leaves the post unpinned.
+func GetPinnedPostOrder(postEntry *PostEntry) (_pinOrder uint64, _isPinned bool) {
+	if postEntry == nil || postEntry.isDeleted || postEntry.IsHidden || len(postEntry.ParentStakeID) != 0 {
+		return 0, false
+	}
+	value, exists := postEntry.PostExtraData[PinnedPostOrderKey]
+	if !exists {
+		return 0, false
+	}
+	pinOrder, err := ReadUvarint(bytes.NewReader(value))
+	if err != nil {
+		return 0, false
+	}
+	return pinOrder, true
+}
+
+// RegisterPinnedPostsHandlers makes the IndexQueue maintain the pinned posts index. Connects and
+// disconnects are handled the same way, since reindexing a post from the db is idempotent.
+func RegisterPinnedPostsHandlers(indexQueue *IndexQueue) {
+	db := indexQueue.db
+	handler := func(task *IndexQueueTask) error {
+		return db.Update(func(txn *badger.Txn) error {
+			postHashes, err := _getPostHashesWrittenByBlockWithTxn(txn, task.BlockHash)
+			if err != nil {
+				return errors.Wrapf(err, "RegisterPinnedPostsHandlers: ")
+			}
+			for _, postHash := range postHashes {
+				if err := ReindexPinnedPostWithTxn(txn, postHash); err != nil {
+					return errors.Wrapf(err, "RegisterPinnedPostsHandlers: ")
+				}
+			}
+			return nil
+		})
+	}
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockConnected, handler)
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockDisconnected, handler)
+}
+
+// ReindexPinnedPostWithTxn replaces the index entry of the post with the one computed from the post
+// currently in the db. If the post no longer exists or is no longer pinned, its entry is removed.
+func ReindexPinnedPostWithTxn(txn *badger.Txn, postHash *BlockHash) error {
+	oldEntry, err := DbGetPinnedPostEntryWithTxn(txn, postHash)
+	if err != nil {
+		return errors.Wrapf(err, "ReindexPinnedPostWithTxn: ")
+	}
+	if oldEntry != nil {
+		if err = DbDeletePinnedPostEntryWithTxn(txn, oldEntry); err != nil {
+			return errors.Wrapf(err, "ReindexPinnedPostWithTxn: ")
+		}
+	}
+
+	postEntry := DBGetPostEntryByPostHashWithTxn(txn, nil, postHash)
+	pinOrder, isPinned := GetPinnedPostOrder(postEntry)
+	if !isPinned {
+		return nil
+	}
+	posterPKIDEntry := DBGetPKIDEntryForPublicKeyWithTxn(txn, nil, postEntry.PosterPublicKey)
+	if posterPKIDEntry == nil {
+		return nil
+	}
+	newEntry := &PinnedPostEntry{
+		PostHash:   postHash,
+		PosterPKID: posterPKIDEntry.PKID,
+		PinOrder:   pinOrder,
+	}
+	if err = DbPutPinnedPostEntryWithTxn(txn, newEntry); err != nil {
+		return errors.Wrapf(err, "ReindexPinnedPostWithTxn: ")
+	}
+	return nil
+}
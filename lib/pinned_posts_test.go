@@ -0,0 +1,75 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPinnedPostsIndex(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+	indexQueue := NewIndexQueue(db)
+	RegisterPinnedPostsHandlers(indexQueue)
+
+	// Mine a few blocks to give the sender some DESO to post with.
+	for ii := 0; ii < 2; ii++ {
+		_, err := miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+	}
+	chain.SetIndexQueue(indexQueue)
+
+	senderPkBytes, _, err := Base58CheckDecode(senderPkString)
+	require.NoError(err)
+	senderPKID := DBGetPKIDEntryForPublicKey(db, nil, senderPkBytes).PKID
+	submitPost := func(postHashToModify []byte, extraData map[string][]byte) *BlockHash {
+		txn, _, _, _, err := chain.CreateSubmitPostTxn(senderPkBytes, postHashToModify, nil,
+			[]byte(`{"Body": "post"}`), nil, false, 1, extraData, false, 10, mempool, nil)
+		require.NoError(err)
+		_signTxn(t, txn, senderPrivString)
+		_, err = mempool.ProcessTransaction(txn, false, false, 0, true)
+		require.NoError(err)
+		_, err = miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+		_, err = indexQueue.ProcessTasks(IndexQueueBatchSize)
+		require.NoError(err)
+		return txn.Hash()
+	}
+	getPinnedPostHashes := func() []*BlockHash {
+		postHashes, err := DbGetPinnedPostHashesForPKID(db, senderPKID, 0)
+		require.NoError(err)
+		return postHashes
+	}
+
+	// Pinned posts are returned by pin order, and unpinned posts aren't returned.
+	post1 := submitPost(nil, map[string][]byte{PinnedPostOrderKey: UintToBuf(2)})
+	post1Height := uint64(chain.blockTip().Height)
+	post2 := submitPost(nil, map[string][]byte{PinnedPostOrderKey: UintToBuf(1)})
+	submitPost(nil, nil)
+	require.Equal([]*BlockHash{post2, post1}, getPinnedPostHashes())
+
+	limitedPostHashes, err := DbGetPinnedPostHashesForPKID(db, senderPKID, 1)
+	require.NoError(err)
+	require.Equal([]*BlockHash{post2}, limitedPostHashes)
+
+	// Modifying a post's pin order moves it.
+	submitPost(post1[:], map[string][]byte{PinnedPostOrderKey: UintToBuf(0)})
+	require.Equal([]*BlockHash{post1, post2}, getPinnedPostHashes())
+
+	// Setting the pin order to an empty value unpins the post.
+	submitPost(post2[:], map[string][]byte{PinnedPostOrderKey: {}})
+	require.Equal([]*BlockHash{post1}, getPinnedPostHashes())
+
+	// Disconnecting the blocks after post1 removes post2, and reverts post1 to its old pin order.
+	require.NoError(chain.DisconnectBlocksToHeight(post1Height))
+	_, err = indexQueue.ProcessTasks(IndexQueueBatchSize)
+	require.NoError(err)
+	require.Equal([]*BlockHash{post1}, getPinnedPostHashes())
+	pinnedPostEntry, err := DbGetPinnedPostEntry(db, post1)
+	require.NoError(err)
+	require.Equal(uint64(2), pinnedPostEntry.PinOrder)
+}
@@ -0,0 +1,209 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// The post ExtraData index lets apps filter posts by metadata they keep in PostEntry ExtraData, e.g.
+// a region lock, without the node knowing about each app's keys. The node operator declares which
+// ExtraData keys are indexed, and an extractor turns the value under each key into the values the
+// post is indexed under. The IndexQueue maintains the index: whenever a block with SubmitPost txns is
+// connected or disconnected, the posts they wrote are reindexed from the db, so the index is
+// eventually consistent with the chain. Hidden posts aren't indexed.
+
+// PostExtraDataExtractor returns the values a post is indexed under, given the ExtraData value under
+// the indexed key. Returning no values leaves the post out of the index for that key.
+type PostExtraDataExtractor func(value []byte) [][]byte
+
+// PostExtraDataIndexSpec declares an indexed ExtraData key.
+type PostExtraDataIndexSpec struct {
+	Key       string
+	Extractor PostExtraDataExtractor
+}
+
+// ExtractPostExtraDataValue indexes the post under the raw ExtraData value.
+func ExtractPostExtraDataValue(value []byte) [][]byte {
+	if len(value) == 0 {
+		return nil
+	}
+	return [][]byte{value}
+}
+
+// ExtractPostExtraDataListValues indexes the post under each item of a comma-separated ExtraData
+// value, e.g. "US,CA" indexes it under "US" and under "CA".
+func ExtractPostExtraDataListValues(value []byte) [][]byte {
+	var values [][]byte
+	for _, item := range bytes.Split(value, []byte(",")) {
+		item = bytes.TrimSpace(item)
+		if len(item) > 0 {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// PostExtraDataListSuffix marks a key whose value is a comma-separated list in
+// ParsePostExtraDataIndexSpecs.
+const PostExtraDataListSuffix = ":list"
+
+// ParsePostExtraDataIndexSpecs parses specs of the form "key" or "key:list". Keys without the suffix
+// are indexed under their raw value, and keys with the ":list" suffix are indexed under each item
+// of their comma-separated value. Keys can contain colons, e.g. "app:regions:list" declares the key
+// "app:regions".
+func ParsePostExtraDataIndexSpecs(specStrings []string) ([]*PostExtraDataIndexSpec, error) {
+	var specs []*PostExtraDataIndexSpec
+	seenKeys := make(map[string]bool)
+	for _, specString := range specStrings {
+		spec := &PostExtraDataIndexSpec{Key: specString, Extractor: ExtractPostExtraDataValue}
+		if strings.HasSuffix(specString, PostExtraDataListSuffix) {
+			spec.Key = strings.TrimSuffix(specString, PostExtraDataListSuffix)
+			spec.Extractor = ExtractPostExtraDataListValues
+		}
+		if spec.Key == "" {
+			return nil, fmt.Errorf("ParsePostExtraDataIndexSpecs: Empty key in spec %v", specString)
+		}
+		if seenKeys[spec.Key] {
+			return nil, fmt.Errorf("ParsePostExtraDataIndexSpecs: Key %v is declared more than once", spec.Key)
+		}
+		seenKeys[spec.Key] = true
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// PostExtraDataIndexEntry is one (key, value) that a post is indexed under.
+type PostExtraDataIndexEntry struct {
+	Key   string
+	Value []byte
+}
+
+// ComputePostExtraDataIndexEntries returns the entries the post should be indexed under, in spec
+// order. Duplicate values for the same key are only returned once.
+func ComputePostExtraDataIndexEntries(postEntry *PostEntry, specs []*PostExtraDataIndexSpec) []*PostExtraDataIndexEntry {
+	if postEntry == nil || postEntry.isDeleted || postEntry.IsHidden {
+		return nil
+	}
+	var entries []*PostExtraDataIndexEntry
+	for _, spec := range specs {
+		value, exists := postEntry.PostExtraData[spec.Key]
+		if !exists {
+			continue
+		}
+		seenValues := make(map[string]bool)
+		for _, extractedValue := range spec.Extractor(value) {
+			if seenValues[string(extractedValue)] {
+				continue
+			}
+			seenValues[string(extractedValue)] = true
+			entries = append(entries, &PostExtraDataIndexEntry{Key: spec.Key, Value: extractedValue})
+		}
+	}
+	return entries
+}
+
+// RegisterPostExtraDataIndexHandlers makes the IndexQueue maintain the post ExtraData index for the
+// given specs. Connects and disconnects are handled the same way, since reindexing a post from the db
+// is idempotent.
+func RegisterPostExtraDataIndexHandlers(indexQueue *IndexQueue, specs []*PostExtraDataIndexSpec) {
+	db := indexQueue.db
+	handler := func(task *IndexQueueTask) error {
+		return db.Update(func(txn *badger.Txn) error {
+			return _reindexPostExtraDataForBlockWithTxn(txn, task.BlockHash, specs)
+		})
+	}
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockConnected, handler)
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockDisconnected, handler)
+}
+
+func _reindexPostExtraDataForBlockWithTxn(txn *badger.Txn, blockHash *BlockHash, specs []*PostExtraDataIndexSpec) error {
+	postHashes, err := _getPostHashesWrittenByBlockWithTxn(txn, blockHash)
+	if err != nil {
+		return errors.Wrapf(err, "_reindexPostExtraDataForBlockWithTxn: ")
+	}
+	for _, postHash := range postHashes {
+		if err := ReindexPostExtraDataWithTxn(txn, postHash, specs); err != nil {
+			return errors.Wrapf(err, "_reindexPostExtraDataForBlockWithTxn: ")
+		}
+	}
+	return nil
+}
+
+// _getPostHashesWrittenByBlockWithTxn returns the hashes of the posts that the SubmitPost txns in
+// the block created or modified, in txn order.
+func _getPostHashesWrittenByBlockWithTxn(txn *badger.Txn, blockHash *BlockHash) ([]*BlockHash, error) {
+	block := GetBlockWithTxn(txn, nil, blockHash)
+	if block == nil {
+		return nil, fmt.Errorf("_getPostHashesWrittenByBlockWithTxn: Block %v not found", blockHash)
+	}
+	var postHashes []*BlockHash
+	for _, blockTxn := range block.Txns {
+		if blockTxn.TxnMeta.GetTxnType() != TxnTypeSubmitPost {
+			continue
+		}
+		// A SubmitPost either modifies an existing post or creates one whose hash is the txn hash.
+		postHash := blockTxn.Hash()
+		if postHashToModify := blockTxn.TxnMeta.(*SubmitPostMetadata).PostHashToModify; len(postHashToModify) == HashSizeBytes {
+			postHash = NewBlockHash(postHashToModify)
+		}
+		postHashes = append(postHashes, postHash)
+	}
+	return postHashes, nil
+}
+
+// ReindexPostExtraDataWithTxn replaces the index entries of the post with the ones computed from the
+// post currently in the db. If the post no longer exists, its entries are removed.
+func ReindexPostExtraDataWithTxn(txn *badger.Txn, postHash *BlockHash, specs []*PostExtraDataIndexSpec) error {
+	oldEntries, err := DbGetPostExtraDataIndexEntriesForPostWithTxn(txn, postHash)
+	if err != nil {
+		return errors.Wrapf(err, "ReindexPostExtraDataWithTxn: ")
+	}
+	for _, entry := range oldEntries {
+		if err = DbDeletePostExtraDataIndexEntryWithTxn(txn, entry, postHash); err != nil {
+			return errors.Wrapf(err, "ReindexPostExtraDataWithTxn: ")
+		}
+	}
+
+	newEntries := ComputePostExtraDataIndexEntries(DBGetPostEntryByPostHashWithTxn(txn, nil, postHash), specs)
+	for _, entry := range newEntries {
+		if err = DbPutPostExtraDataIndexEntryWithTxn(txn, entry, postHash); err != nil {
+			return errors.Wrapf(err, "ReindexPostExtraDataWithTxn: ")
+		}
+	}
+	return DbPutPostExtraDataIndexEntriesForPostWithTxn(txn, postHash, newEntries)
+}
+
+// DbGetPostHashesForExtraDataValue returns the hashes of the posts indexed under the value for the
+// ExtraData key, in post hash order. At most limit hashes are returned, starting after
+// startAfterPostHash if it's set, so that callers can paginate.
+func DbGetPostHashesForExtraDataValue(handle *badger.DB, key string, value []byte,
+	startAfterPostHash *BlockHash, limit int) ([]*BlockHash, error) {
+
+	prefix := _dbPrefixForPostExtraDataIndexValue(key, value)
+	var postHashes []*BlockHash
+	err := handle.View(func(txn *badger.Txn) error {
+		iterator := txn.NewIterator(badger.IteratorOptions{PrefetchValues: false})
+		defer iterator.Close()
+
+		seekKey := prefix
+		if startAfterPostHash != nil {
+			seekKey = append(append([]byte{}, prefix...), startAfterPostHash[:]...)
+		}
+		for iterator.Seek(seekKey); iterator.ValidForPrefix(prefix) && len(postHashes) < limit; iterator.Next() {
+			postHash := NewBlockHash(iterator.Item().KeyCopy(nil)[len(prefix):])
+			if startAfterPostHash != nil && *postHash == *startAfterPostHash {
+				continue
+			}
+			postHashes = append(postHashes, postHash)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetPostHashesForExtraDataValue: ")
+	}
+	return postHashes, nil
+}
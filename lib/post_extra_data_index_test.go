@@ -0,0 +1,101 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePostExtraDataIndexSpecs(t *testing.T) {
+	require := require.New(t)
+
+	specs, err := ParsePostExtraDataIndexSpecs([]string{"app:region", "app:regions:list"})
+	require.NoError(err)
+	require.Equal(2, len(specs))
+	require.Equal("app:region", specs[0].Key)
+	require.Equal([][]byte{[]byte("US,CA")}, specs[0].Extractor([]byte("US,CA")))
+	require.Equal("app:regions", specs[1].Key)
+	require.Equal([][]byte{[]byte("US"), []byte("CA")}, specs[1].Extractor([]byte("US, CA,")))
+
+	_, err = ParsePostExtraDataIndexSpecs([]string{":list"})
+	require.Error(err)
+	_, err = ParsePostExtraDataIndexSpecs([]string{"regions", "regions:list"})
+	require.Error(err)
+}
+
+func TestPostExtraDataIndex(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+	specs, err := ParsePostExtraDataIndexSpecs([]string{"regions:list", "lang"})
+	require.NoError(err)
+	indexQueue := NewIndexQueue(db)
+	RegisterPostExtraDataIndexHandlers(indexQueue, specs)
+
+	// Mine a few blocks to give the sender some DESO to post with.
+	for ii := 0; ii < 2; ii++ {
+		_, err = miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+	}
+	chain.SetIndexQueue(indexQueue)
+
+	senderPkBytes, _, err := Base58CheckDecode(senderPkString)
+	require.NoError(err)
+	submitPost := func(postHashToModify []byte, extraData map[string][]byte) *BlockHash {
+		txn, _, _, _, err := chain.CreateSubmitPostTxn(senderPkBytes, postHashToModify, nil,
+			[]byte(`{"Body": "post"}`), nil, false, 1, extraData, false, 10, mempool, nil)
+		require.NoError(err)
+		_signTxn(t, txn, senderPrivString)
+		_, err = mempool.ProcessTransaction(txn, false, false, 0, true)
+		require.NoError(err)
+		_, err = miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+		_, err = indexQueue.ProcessTasks(IndexQueueBatchSize)
+		require.NoError(err)
+		return txn.Hash()
+	}
+	getPostHashes := func(key string, value string) []*BlockHash {
+		postHashes, err := DbGetPostHashesForExtraDataValue(db, key, []byte(value), nil, 100)
+		require.NoError(err)
+		return postHashes
+	}
+
+	post1 := submitPost(nil, map[string][]byte{"regions": []byte("US,CA"), "lang": []byte("en")})
+	post1Height := uint64(chain.blockTip().Height)
+	post2 := submitPost(nil, map[string][]byte{"regions": []byte("CA")})
+	submitPost(nil, map[string][]byte{"unindexed": []byte("US")})
+	require.Equal([]*BlockHash{post1}, getPostHashes("regions", "US"))
+	require.Equal(2, len(getPostHashes("regions", "CA")))
+	require.Equal([]*BlockHash{post1}, getPostHashes("lang", "en"))
+	require.Empty(getPostHashes("unindexed", "US"))
+	// The key and value are length-prefixed, so a value that's a prefix of another doesn't match it.
+	require.Empty(getPostHashes("regions", "U"))
+
+	// Pagination picks up after the last post hash returned.
+	firstPage, err := DbGetPostHashesForExtraDataValue(db, "regions", []byte("CA"), nil, 1)
+	require.NoError(err)
+	require.Equal(1, len(firstPage))
+	secondPage, err := DbGetPostHashesForExtraDataValue(db, "regions", []byte("CA"), firstPage[0], 1)
+	require.NoError(err)
+	require.Equal(1, len(secondPage))
+	require.NotEqual(*firstPage[0], *secondPage[0])
+
+	// Modifying a post reindexes it. ExtraData is merged on modification, so lang stays.
+	submitPost(post1[:], map[string][]byte{"regions": []byte("MX")})
+	require.Empty(getPostHashes("regions", "US"))
+	require.Equal([]*BlockHash{post2}, getPostHashes("regions", "CA"))
+	require.Equal([]*BlockHash{post1}, getPostHashes("regions", "MX"))
+	require.Equal([]*BlockHash{post1}, getPostHashes("lang", "en"))
+
+	// Disconnecting the blocks after post1 removes post2 from the index, and reverts post1 to the
+	// regions it was created with.
+	require.NoError(chain.DisconnectBlocksToHeight(post1Height))
+	_, err = indexQueue.ProcessTasks(IndexQueueBatchSize)
+	require.NoError(err)
+	require.Equal([]*BlockHash{post1}, getPostHashes("regions", "US"))
+	require.Equal([]*BlockHash{post1}, getPostHashes("regions", "CA"))
+	require.Empty(getPostHashes("regions", "MX"))
+}
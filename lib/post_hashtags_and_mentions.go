@@ -0,0 +1,246 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// The IndexQueue keeps an index of the #hashtags and @mentions in post bodies, so that clients can
+// search posts by hashtag with DbGetPostHashesForHashtag and fetch the posts mentioning a profile
+// with DbGetPostHashesMentioningPKID. Whenever a block with SubmitPost txns is connected or
+// disconnected, the posts they wrote are reindexed from the db, which covers post edits and
+// disconnects the same way. Hashtags are indexed lowercased, and mentions are indexed under the PKID
+// of the profile with the mentioned username when the post was last reindexed. Both posts and
+// comments are indexed, but hidden posts aren't.
+
+// PostHashtagsAndMentionsEntry is what a post is indexed under.
+type PostHashtagsAndMentionsEntry struct {
+	PostHash       *BlockHash
+	TimestampNanos uint64
+	Hashtags       []string
+	MentionedPKIDs []*PKID
+}
+
+func (entry *PostHashtagsAndMentionsEntry) ToBytes() []byte {
+	data := append([]byte{}, entry.PostHash[:]...)
+	data = append(data, UintToBuf(entry.TimestampNanos)...)
+	data = append(data, UintToBuf(uint64(len(entry.Hashtags)))...)
+	for _, hashtag := range entry.Hashtags {
+		data = append(data, EncodeByteArray([]byte(hashtag))...)
+	}
+	data = append(data, UintToBuf(uint64(len(entry.MentionedPKIDs)))...)
+	for _, mentionedPKID := range entry.MentionedPKIDs {
+		data = append(data, mentionedPKID[:]...)
+	}
+	return data
+}
+
+func (entry *PostHashtagsAndMentionsEntry) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+	var err error
+
+	entry.PostHash = &BlockHash{}
+	if _, err = io.ReadFull(rr, entry.PostHash[:]); err != nil {
+		return errors.Wrapf(err, "PostHashtagsAndMentionsEntry.FromBytes: Problem reading PostHash")
+	}
+	if entry.TimestampNanos, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "PostHashtagsAndMentionsEntry.FromBytes: Problem reading TimestampNanos")
+	}
+	numHashtags, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "PostHashtagsAndMentionsEntry.FromBytes: Problem reading number of hashtags")
+	}
+	if numHashtags > MaxPostHashtagsAndMentions {
+		return fmt.Errorf("PostHashtagsAndMentionsEntry.FromBytes: %v hashtags exceeds the maximum of %v",
+			numHashtags, MaxPostHashtagsAndMentions)
+	}
+	entry.Hashtags = nil
+	for ii := uint64(0); ii < numHashtags; ii++ {
+		hashtag, err := DecodeByteArray(rr)
+		if err != nil {
+			return errors.Wrapf(err, "PostHashtagsAndMentionsEntry.FromBytes: Problem reading hashtag")
+		}
+		entry.Hashtags = append(entry.Hashtags, string(hashtag))
+	}
+	numMentions, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "PostHashtagsAndMentionsEntry.FromBytes: Problem reading number of mentions")
+	}
+	if numMentions > MaxPostHashtagsAndMentions {
+		return fmt.Errorf("PostHashtagsAndMentionsEntry.FromBytes: %v mentions exceeds the maximum of %v",
+			numMentions, MaxPostHashtagsAndMentions)
+	}
+	entry.MentionedPKIDs = nil
+	for ii := uint64(0); ii < numMentions; ii++ {
+		mentionedPKID := &PKID{}
+		if _, err = io.ReadFull(rr, mentionedPKID[:]); err != nil {
+			return errors.Wrapf(err, "PostHashtagsAndMentionsEntry.FromBytes: Problem reading mentioned PKID")
+		}
+		entry.MentionedPKIDs = append(entry.MentionedPKIDs, mentionedPKID)
+	}
+	return nil
+}
+
+// GetPostBodyText returns the text of a post body. Bodies are usually a DeSoBodySchema, but bodies
+// that aren't are taken as plain text.
+func GetPostBodyText(body []byte) string {
+	bodyObj := &DeSoBodySchema{}
+	if err := json.Unmarshal(body, bodyObj); err == nil {
+		return bodyObj.Body
+	}
+	return string(body)
+}
+
+func _isHashtagRune(rr rune) bool {
+	return rr == '_' || unicode.IsLetter(rr) || unicode.IsDigit(rr)
+}
+
+func _isUsernameRune(rr rune) bool {
+	return rr == '_' || (rr >= 'a' && rr <= 'z') || (rr >= 'A' && rr <= 'Z') || (rr >= '0' && rr <= '9')
+}
+
+// ExtractHashtagsAndMentions returns the lowercased hashtags and the usernames mentioned in the text,
+// in the order they first appear, without duplicates and without the leading # or @. A # or @ only
+// starts a hashtag or mention at the start of the text or after a character that can't be part of
+// one, so e.g. emails aren't mentions. Hashtags are letters, digits and underscores, with at least
+// one letter, and mentions follow UsernameRegex. Hashtags and usernames that are too long are
+// skipped, and at most MaxPostHashtagsAndMentions of each are returned.
+func ExtractHashtagsAndMentions(text string) (_hashtags []string, _mentionedUsernames []string) {
+	var hashtags, mentionedUsernames []string
+	seenHashtags := make(map[string]bool)
+	seenUsernames := make(map[string]bool)
+
+	prevRune := rune(-1)
+	for ii := 0; ii < len(text); {
+		rr, size := utf8.DecodeRuneInString(text[ii:])
+		isStart := (rr == '#' || rr == '@') && (prevRune == -1 || !_isHashtagRune(prevRune))
+		prevRune = rr
+		ii += size
+		if !isStart {
+			continue
+		}
+
+		isHashtag := rr == '#'
+		isTokenRune := _isUsernameRune
+		if isHashtag {
+			isTokenRune = _isHashtagRune
+		}
+		end := ii
+		hasLetter := false
+		for end < len(text) {
+			tokenRune, tokenSize := utf8.DecodeRuneInString(text[end:])
+			if !isTokenRune(tokenRune) {
+				break
+			}
+			hasLetter = hasLetter || unicode.IsLetter(tokenRune)
+			end += tokenSize
+		}
+		token := text[ii:end]
+		if end > ii {
+			prevRune, _ = utf8.DecodeLastRuneInString(token)
+		}
+		ii = end
+
+		if isHashtag {
+			hashtag := strings.ToLower(token)
+			if !hasLetter || len(hashtag) > MaxHashtagLengthBytes || seenHashtags[hashtag] ||
+				len(hashtags) >= MaxPostHashtagsAndMentions {
+				continue
+			}
+			seenHashtags[hashtag] = true
+			hashtags = append(hashtags, hashtag)
+		} else {
+			// Usernames are unique case-insensitively, so mentions are deduplicated that way too.
+			lowercaseUsername := strings.ToLower(token)
+			if len(token) == 0 || len(token) > MaxUsernameLengthBytes || seenUsernames[lowercaseUsername] ||
+				len(mentionedUsernames) >= MaxPostHashtagsAndMentions {
+				continue
+			}
+			seenUsernames[lowercaseUsername] = true
+			mentionedUsernames = append(mentionedUsernames, token)
+		}
+	}
+	return hashtags, mentionedUsernames
+}
+
+// ComputePostHashtagsAndMentionsEntryWithTxn returns what the post should be indexed under, or nil if
+// it shouldn't be indexed. Mentions of usernames that don't have a profile are left out.
+func ComputePostHashtagsAndMentionsEntryWithTxn(txn *badger.Txn, postEntry *PostEntry) *PostHashtagsAndMentionsEntry {
+	if postEntry == nil || postEntry.isDeleted || postEntry.IsHidden {
+		return nil
+	}
+	hashtags, mentionedUsernames := ExtractHashtagsAndMentions(GetPostBodyText(postEntry.Body))
+	var mentionedPKIDs []*PKID
+	seenPKIDs := make(map[PKID]bool)
+	for _, username := range mentionedUsernames {
+		mentionedPKID := DBGetPKIDForUsernameWithTxn(txn, nil, []byte(username))
+		if mentionedPKID == nil || seenPKIDs[*mentionedPKID] {
+			continue
+		}
+		seenPKIDs[*mentionedPKID] = true
+		mentionedPKIDs = append(mentionedPKIDs, mentionedPKID)
+	}
+	if len(hashtags) == 0 && len(mentionedPKIDs) == 0 {
+		return nil
+	}
+	return &PostHashtagsAndMentionsEntry{
+		PostHash:       postEntry.PostHash,
+		TimestampNanos: postEntry.TimestampNanos,
+		Hashtags:       hashtags,
+		MentionedPKIDs: mentionedPKIDs,
+	}
+}
+
+// RegisterPostHashtagsAndMentionsHandlers makes the IndexQueue maintain the hashtag and mention
+// index. Connects and disconnects are handled the same way, since reindexing a post from the db is
+// idempotent.
+func RegisterPostHashtagsAndMentionsHandlers(indexQueue *IndexQueue) {
+	db := indexQueue.db
+	handler := func(task *IndexQueueTask) error {
+		return db.Update(func(txn *badger.Txn) error {
+			postHashes, err := _getPostHashesWrittenByBlockWithTxn(txn, task.BlockHash)
+			if err != nil {
+				return errors.Wrapf(err, "RegisterPostHashtagsAndMentionsHandlers: ")
+			}
+			for _, postHash := range postHashes {
+				if err := ReindexPostHashtagsAndMentionsWithTxn(txn, postHash); err != nil {
+					return errors.Wrapf(err, "RegisterPostHashtagsAndMentionsHandlers: ")
+				}
+			}
+			return nil
+		})
+	}
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockConnected, handler)
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockDisconnected, handler)
+}
+
+// ReindexPostHashtagsAndMentionsWithTxn replaces the index entries of the post with the ones computed
+// from the post currently in the db. If the post no longer exists, its entries are removed.
+func ReindexPostHashtagsAndMentionsWithTxn(txn *badger.Txn, postHash *BlockHash) error {
+	oldEntry, err := DbGetPostHashtagsAndMentionsEntryWithTxn(txn, postHash)
+	if err != nil {
+		return errors.Wrapf(err, "ReindexPostHashtagsAndMentionsWithTxn: ")
+	}
+	if oldEntry != nil {
+		if err = DbDeletePostHashtagsAndMentionsEntryWithTxn(txn, oldEntry); err != nil {
+			return errors.Wrapf(err, "ReindexPostHashtagsAndMentionsWithTxn: ")
+		}
+	}
+
+	newEntry := ComputePostHashtagsAndMentionsEntryWithTxn(txn, DBGetPostEntryByPostHashWithTxn(txn, nil, postHash))
+	if newEntry == nil {
+		return nil
+	}
+	if err = DbPutPostHashtagsAndMentionsEntryWithTxn(txn, newEntry); err != nil {
+		return errors.Wrapf(err, "ReindexPostHashtagsAndMentionsWithTxn: ")
+	}
+	return nil
+}
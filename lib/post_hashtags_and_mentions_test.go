@@ -0,0 +1,133 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractHashtagsAndMentions(t *testing.T) {
+	require := require.New(t)
+
+	hashtags, mentions := ExtractHashtagsAndMentions(
+		"#DeSo is #deso, says @Alice_1 to @bob and @alice_1. #2023 isn't a hashtag but #año is.")
+	require.Equal([]string{"deso", "año"}, hashtags)
+	require.Equal([]string{"Alice_1", "bob"}, mentions)
+
+	// A # or @ inside a word doesn't start a hashtag or mention, and neither do empty ones.
+	hashtags, mentions = ExtractHashtagsAndMentions("C# and bob@example.com, # and @ alone, (#tag) @x.")
+	require.Equal([]string{"tag"}, hashtags)
+	require.Equal([]string{"x"}, mentions)
+
+	// Usernames that are too long aren't mentions.
+	_, mentions = ExtractHashtagsAndMentions("@abcdefghijklmnopqrstuvwxyz")
+	require.Empty(mentions)
+
+	// Post bodies are usually a DeSoBodySchema, but plain text works too.
+	require.Equal("hi #there", GetPostBodyText([]byte(`{"Body": "hi #there", "ImageURLs": ["x"]}`)))
+	require.Equal("hi #there", GetPostBodyText([]byte("hi #there")))
+}
+
+func TestPostHashtagsAndMentionsEntryEncoding(t *testing.T) {
+	require := require.New(t)
+
+	entry := &PostHashtagsAndMentionsEntry{
+		PostHash:       NewBlockHash(RandomBytes(HashSizeBytes)),
+		TimestampNanos: 123,
+		Hashtags:       []string{"deso", "año"},
+		MentionedPKIDs: []*PKID{NewPKID(m0PkBytes), NewPKID(m1PkBytes)},
+	}
+	decodedEntry := &PostHashtagsAndMentionsEntry{}
+	require.NoError(decodedEntry.FromBytes(entry.ToBytes()))
+	require.Equal(entry, decodedEntry)
+}
+
+func TestPostHashtagsAndMentionsIndex(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+	indexQueue := NewIndexQueue(db)
+	RegisterPostHashtagsAndMentionsHandlers(indexQueue)
+
+	// Mine a few blocks to give the sender some DESO to post with.
+	for ii := 0; ii < 2; ii++ {
+		_, err := miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+	}
+	chain.SetIndexQueue(indexQueue)
+
+	senderPkBytes, _, err := Base58CheckDecode(senderPkString)
+	require.NoError(err)
+	mineTxn := func(txn *MsgDeSoTxn) {
+		_signTxn(t, txn, senderPrivString)
+		_, err := mempool.ProcessTransaction(txn, false, false, 0, true)
+		require.NoError(err)
+		_, err = miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+		_, err = indexQueue.ProcessTasks(IndexQueueBatchSize)
+		require.NoError(err)
+	}
+	submitPost := func(postHashToModify []byte, body string, tstampNanos uint64) *BlockHash {
+		txn, _, _, _, err := chain.CreateSubmitPostTxn(senderPkBytes, postHashToModify, nil,
+			[]byte(`{"Body": "`+body+`"}`), nil, false, tstampNanos, nil, false, 10, mempool, nil)
+		require.NoError(err)
+		mineTxn(txn)
+		return txn.Hash()
+	}
+	getHashtagPostHashes := func(hashtag string) []*BlockHash {
+		postHashes, _, err := DbGetPostHashesForHashtag(db, hashtag, 0, nil)
+		require.NoError(err)
+		return postHashes
+	}
+	getMentionPostHashes := func(mentionedPKID *PKID) []*BlockHash {
+		postHashes, _, err := DbGetPostHashesMentioningPKID(db, mentionedPKID, 0, nil)
+		require.NoError(err)
+		return postHashes
+	}
+
+	// Give the sender a profile so that they can be mentioned.
+	txn, _, _, _, err := chain.CreateUpdateProfileTxn(senderPkBytes, nil, "Sender", "", "",
+		1000, 12500, false, 0, nil, 10, mempool, nil)
+	require.NoError(err)
+	mineTxn(txn)
+	senderPKID := DBGetPKIDEntryForPublicKey(db, nil, senderPkBytes).PKID
+
+	// Hashtags are matched case-insensitively, newest post first, and mentions of usernames without
+	// a profile aren't indexed.
+	post1 := submitPost(nil, "#DeSo by @sender and @nobody", 1)
+	post1Height := uint64(chain.blockTip().Height)
+	post2 := submitPost(nil, "more #deso and #go", 2)
+	submitPost(nil, "no tags", 3)
+	require.Equal([]*BlockHash{post2, post1}, getHashtagPostHashes("deso"))
+	require.Equal([]*BlockHash{post2, post1}, getHashtagPostHashes("#DESO"))
+	require.Equal([]*BlockHash{post2}, getHashtagPostHashes("go"))
+	require.Empty(getHashtagPostHashes("g"))
+	require.Equal([]*BlockHash{post1}, getMentionPostHashes(senderPKID))
+
+	// Pagination picks up where the previous page ended.
+	firstPage, nextStartKey, err := DbGetPostHashesForHashtag(db, "deso", 1, nil)
+	require.NoError(err)
+	require.Equal([]*BlockHash{post2}, firstPage)
+	secondPage, nextStartKey, err := DbGetPostHashesForHashtag(db, "deso", 1, nextStartKey)
+	require.NoError(err)
+	require.Equal([]*BlockHash{post1}, secondPage)
+	require.Nil(nextStartKey)
+
+	// Editing a post reindexes it.
+	submitPost(post1[:], "now about #go", 1)
+	require.Equal([]*BlockHash{post2}, getHashtagPostHashes("deso"))
+	require.Equal([]*BlockHash{post2, post1}, getHashtagPostHashes("go"))
+	require.Empty(getMentionPostHashes(senderPKID))
+
+	// Disconnecting the blocks after post1 removes post2 from the index, and reverts post1 to the
+	// body it was created with.
+	require.NoError(chain.DisconnectBlocksToHeight(post1Height))
+	_, err = indexQueue.ProcessTasks(IndexQueueBatchSize)
+	require.NoError(err)
+	require.Equal([]*BlockHash{post1}, getHashtagPostHashes("deso"))
+	require.Empty(getHashtagPostHashes("go"))
+	require.Equal([]*BlockHash{post1}, getMentionPostHashes(senderPKID))
+}
@@ -1,8 +1,9 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
 	"bytes"
-	"encoding/gob"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -425,6 +426,8 @@ type PGMetadataDAOCoinLimitOrder struct {
 	OperationType                             uint8                                      `pg:",use_zero"`
 	FillType                                  uint8                                      `pg:",use_zero"`
 	CancelOrderID                             *BlockHash                                 `pg:",type:bytea"`
+	CancelAllForPair                          bool                                       `pg:",use_zero"`
+	ReplaceOrderID                            *BlockHash                                 `pg:",type:bytea"`
 	FeeNanos                                  uint64                                     `pg:",use_zero"`
 	BidderInputs                              []*PGMetadataDAOCoinLimitOrderBidderInputs `pg:"rel:has-many,join_fk:transaction_hash"`
 }
@@ -652,6 +655,21 @@ type PGMessagingGroup struct {
 	ExtraData map[string][]byte
 }
 
+func (messagingGroup *PGMessagingGroup) NewMessagingGroupEntry() (*MessagingGroupEntry, error) {
+	// Older rows have gob-encoded members. They're re-encoded the next time the group is flushed.
+	members, _, err := DecodeMessagingGroupMembers(messagingGroup.MessagingGroupMembers)
+	if err != nil {
+		return nil, fmt.Errorf("PGMessagingGroup.NewMessagingGroupEntry: Problem decoding members: %v", err)
+	}
+	return &MessagingGroupEntry{
+		GroupOwnerPublicKey:   messagingGroup.GroupOwnerPublicKey,
+		MessagingPublicKey:    messagingGroup.MessagingPublicKey,
+		MessagingGroupKeyName: messagingGroup.MessagingGroupKeyName,
+		MessagingGroupMembers: members,
+		ExtraData:             messagingGroup.ExtraData,
+	}, nil
+}
+
 type PGCreatorCoinBalance struct {
 	tableName struct{} `pg:"pg_creator_coin_balances"`
 
@@ -1329,7 +1347,20 @@ func (postgres *Postgres) InsertTransactionsTx(tx *pg.Tx, desoTxns []*MsgDeSoTxn
 				break
 			}
 
-			// Transactor is submitting a new order.
+			if txMeta.CancelAllForPair {
+				// Transactor is cancelling all of their orders for a pair.
+				metadataDAOCoinLimitOrder = append(metadataDAOCoinLimitOrder, &PGMetadataDAOCoinLimitOrder{
+					TransactionHash:                txnHash,
+					BuyingDAOCoinCreatorPublicKey:  txMeta.BuyingDAOCoinCreatorPublicKey,
+					SellingDAOCoinCreatorPublicKey: txMeta.SellingDAOCoinCreatorPublicKey,
+					CancelAllForPair:               true,
+					FeeNanos:                       txMeta.FeeNanos,
+				})
+
+				continue
+			}
+
+			// Transactor is submitting a new order, possibly replacing one of their existing orders.
 			metadataDAOCoinLimitOrder = append(metadataDAOCoinLimitOrder, &PGMetadataDAOCoinLimitOrder{
 				TransactionHash:                           txnHash,
 				BuyingDAOCoinCreatorPublicKey:             txMeta.BuyingDAOCoinCreatorPublicKey,
@@ -1338,6 +1369,7 @@ func (postgres *Postgres) InsertTransactionsTx(tx *pg.Tx, desoTxns []*MsgDeSoTxn
 				QuantityToFillInBaseUnits:                 txMeta.QuantityToFillInBaseUnits.Hex(),
 				OperationType:                             uint8(txMeta.OperationType),
 				FillType:                                  uint8(txMeta.FillType),
+				ReplaceOrderID:                            txMeta.ReplaceOrderID,
 				FeeNanos:                                  txMeta.FeeNanos,
 			})
 
@@ -1908,19 +1940,15 @@ func (postgres *Postgres) flushMessages(tx *pg.Tx, view *UtxoView) error {
 	return nil
 }
 
-func (postgres *Postgres) flushMessagingGroups(tx *pg.Tx, view *UtxoView) error {
+func (postgres *Postgres) flushMessagingGroups(tx *pg.Tx, view *UtxoView, blockHeight uint64) error {
 	var insertMessages []*PGMessagingGroup
 	var deleteMessages []*PGMessagingGroup
 	for _, groupEntry := range view.MessagingGroupKeyToMessagingGroupEntry {
-		messagingGroupMembersBytes := bytes.NewBuffer([]byte{})
-		if err := gob.NewEncoder(messagingGroupMembersBytes).Encode(groupEntry.MessagingGroupMembers); err != nil {
-			return err
-		}
 		pgGroupEntry := &PGMessagingGroup{
 			GroupOwnerPublicKey:   groupEntry.GroupOwnerPublicKey,
 			MessagingPublicKey:    groupEntry.MessagingPublicKey,
 			MessagingGroupKeyName: groupEntry.MessagingGroupKeyName,
-			MessagingGroupMembers: messagingGroupMembersBytes.Bytes(),
+			MessagingGroupMembers: EncodeMessagingGroupMembers(blockHeight, groupEntry.MessagingGroupMembers),
 			ExtraData:             groupEntry.ExtraData,
 		}
 		if groupEntry.isDeleted {
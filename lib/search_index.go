@@ -0,0 +1,513 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// The search index is an optional inverted index over post bodies and profile usernames and
+// descriptions, so that clients can search them with SearchPosts and SearchProfiles. Text is split
+// into lowercased terms of letters and digits, and a search returns the posts or profiles that have
+// every term of the query. The IndexQueue maintains the index: whenever a block with SubmitPost or
+// UpdateProfile txns is connected or disconnected, the posts and profiles they wrote are reindexed
+// from the db. Hidden posts and profiles aren't indexed. Posts and profiles that are written to the
+// db without going through the IndexQueue, e.g. by a hypersync, are only indexed by
+// RebuildSearchIndex.
+
+// SearchIndexPostEntry is what a post is indexed under.
+type SearchIndexPostEntry struct {
+	PostHash       *BlockHash
+	TimestampNanos uint64
+	Terms          []string
+}
+
+func (entry *SearchIndexPostEntry) ToBytes() []byte {
+	data := append([]byte{}, entry.PostHash[:]...)
+	data = append(data, UintToBuf(entry.TimestampNanos)...)
+	return append(data, _encodeSearchTerms(entry.Terms)...)
+}
+
+func (entry *SearchIndexPostEntry) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+	var err error
+
+	entry.PostHash = &BlockHash{}
+	if _, err = io.ReadFull(rr, entry.PostHash[:]); err != nil {
+		return errors.Wrapf(err, "SearchIndexPostEntry.FromBytes: Problem reading PostHash")
+	}
+	if entry.TimestampNanos, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "SearchIndexPostEntry.FromBytes: Problem reading TimestampNanos")
+	}
+	if entry.Terms, err = _decodeSearchTerms(rr); err != nil {
+		return errors.Wrapf(err, "SearchIndexPostEntry.FromBytes: ")
+	}
+	return nil
+}
+
+// SearchIndexProfileEntry is what a profile is indexed under.
+type SearchIndexProfileEntry struct {
+	PKID  *PKID
+	Terms []string
+}
+
+func (entry *SearchIndexProfileEntry) ToBytes() []byte {
+	data := append([]byte{}, entry.PKID[:]...)
+	return append(data, _encodeSearchTerms(entry.Terms)...)
+}
+
+func (entry *SearchIndexProfileEntry) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+	var err error
+
+	entry.PKID = &PKID{}
+	if _, err = io.ReadFull(rr, entry.PKID[:]); err != nil {
+		return errors.Wrapf(err, "SearchIndexProfileEntry.FromBytes: Problem reading PKID")
+	}
+	if entry.Terms, err = _decodeSearchTerms(rr); err != nil {
+		return errors.Wrapf(err, "SearchIndexProfileEntry.FromBytes: ")
+	}
+	return nil
+}
+
+func _encodeSearchTerms(terms []string) []byte {
+	data := UintToBuf(uint64(len(terms)))
+	for _, term := range terms {
+		data = append(data, EncodeByteArray([]byte(term))...)
+	}
+	return data
+}
+
+func _decodeSearchTerms(rr *bytes.Reader) ([]string, error) {
+	numTerms, err := ReadUvarint(rr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "_decodeSearchTerms: Problem reading number of terms")
+	}
+	if numTerms > MaxSearchTermsPerDocument {
+		return nil, fmt.Errorf("_decodeSearchTerms: %v terms exceeds the maximum of %v",
+			numTerms, MaxSearchTermsPerDocument)
+	}
+	var terms []string
+	for ii := uint64(0); ii < numTerms; ii++ {
+		term, err := DecodeByteArray(rr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "_decodeSearchTerms: Problem reading term")
+		}
+		terms = append(terms, string(term))
+	}
+	return terms, nil
+}
+
+// TokenizeSearchText splits the text into lowercased terms of letters and digits, in the order they
+// first appear and without duplicates. Terms shorter than MinSearchTermLengthBytes or longer than
+// MaxSearchTermLengthBytes are skipped, and at most MaxSearchTermsPerDocument terms are returned.
+func TokenizeSearchText(text string) []string {
+	var terms []string
+	seenTerms := make(map[string]bool)
+	words := strings.FieldsFunc(strings.ToLower(text), func(rr rune) bool {
+		return !unicode.IsLetter(rr) && !unicode.IsDigit(rr)
+	})
+	for _, word := range words {
+		if len(terms) >= MaxSearchTermsPerDocument {
+			break
+		}
+		if len(word) < MinSearchTermLengthBytes || len(word) > MaxSearchTermLengthBytes || seenTerms[word] {
+			continue
+		}
+		seenTerms[word] = true
+		terms = append(terms, word)
+	}
+	return terms
+}
+
+// ComputeSearchIndexPostEntry returns what the post should be indexed under, or nil if it shouldn't
+// be indexed.
+func ComputeSearchIndexPostEntry(postEntry *PostEntry) *SearchIndexPostEntry {
+	if postEntry == nil || postEntry.isDeleted || postEntry.IsHidden {
+		return nil
+	}
+	terms := TokenizeSearchText(GetPostBodyText(postEntry.Body))
+	if len(terms) == 0 {
+		return nil
+	}
+	return &SearchIndexPostEntry{
+		PostHash:       postEntry.PostHash,
+		TimestampNanos: postEntry.TimestampNanos,
+		Terms:          terms,
+	}
+}
+
+// ComputeSearchIndexProfileEntry returns what the profile should be indexed under, or nil if it
+// shouldn't be indexed. The username's terms come first, so that they're kept if the description
+// has too many.
+func ComputeSearchIndexProfileEntry(pkid *PKID, profileEntry *ProfileEntry) *SearchIndexProfileEntry {
+	if profileEntry == nil || profileEntry.isDeleted || profileEntry.IsHidden {
+		return nil
+	}
+	terms := TokenizeSearchText(string(profileEntry.Username) + " " + string(profileEntry.Description))
+	if len(terms) == 0 {
+		return nil
+	}
+	return &SearchIndexProfileEntry{
+		PKID:  pkid,
+		Terms: terms,
+	}
+}
+
+// RegisterSearchIndexHandlers makes the IndexQueue maintain the search index. Connects and
+// disconnects are handled the same way, since reindexing a post or profile from the db is
+// idempotent.
+func RegisterSearchIndexHandlers(indexQueue *IndexQueue) {
+	db := indexQueue.db
+	handler := func(task *IndexQueueTask) error {
+		return db.Update(func(txn *badger.Txn) error {
+			postHashes, err := _getPostHashesWrittenByBlockWithTxn(txn, task.BlockHash)
+			if err != nil {
+				return errors.Wrapf(err, "RegisterSearchIndexHandlers: ")
+			}
+			for _, postHash := range postHashes {
+				if err := ReindexPostForSearchWithTxn(txn, postHash); err != nil {
+					return errors.Wrapf(err, "RegisterSearchIndexHandlers: ")
+				}
+			}
+			profilePKIDs, err := _getProfilePKIDsWrittenByBlockWithTxn(txn, task.BlockHash)
+			if err != nil {
+				return errors.Wrapf(err, "RegisterSearchIndexHandlers: ")
+			}
+			for _, pkid := range profilePKIDs {
+				if err := ReindexProfileForSearchWithTxn(txn, pkid); err != nil {
+					return errors.Wrapf(err, "RegisterSearchIndexHandlers: ")
+				}
+			}
+			return nil
+		})
+	}
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockConnected, handler)
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockDisconnected, handler)
+}
+
+// _getProfilePKIDsWrittenByBlockWithTxn returns the PKIDs of the profiles that the UpdateProfile
+// txns in the block created or modified, in txn order.
+func _getProfilePKIDsWrittenByBlockWithTxn(txn *badger.Txn, blockHash *BlockHash) ([]*PKID, error) {
+	block := GetBlockWithTxn(txn, nil, blockHash)
+	if block == nil {
+		return nil, fmt.Errorf("_getProfilePKIDsWrittenByBlockWithTxn: Block %v not found", blockHash)
+	}
+	var pkids []*PKID
+	for _, blockTxn := range block.Txns {
+		if blockTxn.TxnMeta.GetTxnType() != TxnTypeUpdateProfile {
+			continue
+		}
+		// The param updater can update other users' profiles.
+		profilePublicKey := blockTxn.PublicKey
+		if txnProfilePublicKey := blockTxn.TxnMeta.(*UpdateProfileMetadata).ProfilePublicKey; len(txnProfilePublicKey) != 0 {
+			profilePublicKey = txnProfilePublicKey
+		}
+		pkidEntry := DBGetPKIDEntryForPublicKeyWithTxn(txn, nil, profilePublicKey)
+		if pkidEntry == nil {
+			continue
+		}
+		pkids = append(pkids, pkidEntry.PKID)
+	}
+	return pkids, nil
+}
+
+// ReindexPostForSearchWithTxn replaces the search index entries of the post with the ones computed
+// from the post currently in the db. If the post no longer exists, its entries are removed.
+func ReindexPostForSearchWithTxn(txn *badger.Txn, postHash *BlockHash) error {
+	oldEntry, err := DbGetSearchIndexPostEntryWithTxn(txn, postHash)
+	if err != nil {
+		return errors.Wrapf(err, "ReindexPostForSearchWithTxn: ")
+	}
+	if oldEntry != nil {
+		if err = DbDeleteSearchIndexPostEntryWithTxn(txn, oldEntry); err != nil {
+			return errors.Wrapf(err, "ReindexPostForSearchWithTxn: ")
+		}
+	}
+
+	newEntry := ComputeSearchIndexPostEntry(DBGetPostEntryByPostHashWithTxn(txn, nil, postHash))
+	if newEntry == nil {
+		return nil
+	}
+	if err = DbPutSearchIndexPostEntryWithTxn(txn, newEntry); err != nil {
+		return errors.Wrapf(err, "ReindexPostForSearchWithTxn: ")
+	}
+	return nil
+}
+
+// ReindexProfileForSearchWithTxn replaces the search index entries of the profile with the ones
+// computed from the profile currently in the db. If the profile no longer exists, its entries are
+// removed.
+func ReindexProfileForSearchWithTxn(txn *badger.Txn, pkid *PKID) error {
+	oldEntry, err := DbGetSearchIndexProfileEntryWithTxn(txn, pkid)
+	if err != nil {
+		return errors.Wrapf(err, "ReindexProfileForSearchWithTxn: ")
+	}
+	if oldEntry != nil {
+		if err = DbDeleteSearchIndexProfileEntryWithTxn(txn, oldEntry); err != nil {
+			return errors.Wrapf(err, "ReindexProfileForSearchWithTxn: ")
+		}
+	}
+
+	newEntry := ComputeSearchIndexProfileEntry(pkid, DBGetProfileEntryForPKIDWithTxn(txn, nil, pkid))
+	if newEntry == nil {
+		return nil
+	}
+	if err = DbPutSearchIndexProfileEntryWithTxn(txn, newEntry); err != nil {
+		return errors.Wrapf(err, "ReindexProfileForSearchWithTxn: ")
+	}
+	return nil
+}
+
+// _searchQueryTerms returns the terms of the query, with the longest one first. Longer terms tend to
+// be rarer, so scanning the postings of the longest term and looking up the others is cheapest.
+func _searchQueryTerms(query string) []string {
+	terms := TokenizeSearchText(query)
+	for ii := 1; ii < len(terms); ii++ {
+		if len(terms[ii]) > len(terms[0]) {
+			terms[0], terms[ii] = terms[ii], terms[0]
+		}
+	}
+	return terms
+}
+
+// SearchPosts returns the hashes of up to limit posts that have every term of the query, newest
+// first. At most MaxSearchScanKeys posts with the query's longest term are looked at, so a search
+// for common terms can return fewer posts than there are.
+func SearchPosts(handle *badger.DB, query string, limit int) ([]*BlockHash, error) {
+	terms := _searchQueryTerms(query)
+	if len(terms) == 0 || limit <= 0 {
+		return nil, nil
+	}
+
+	prefix := _dbPrefixForSearchTermPosts(terms[0])
+	keyLen := len(prefix) + 8 + HashSizeBytes
+	var postHashes []*BlockHash
+	err := handle.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Reverse = true
+		iterator := txn.NewIterator(opts)
+		defer iterator.Close()
+
+		// Since we iterate backwards, the seek key must be bigger than all the keys with the prefix.
+		seekKey := append([]byte{}, prefix...)
+		for len(seekKey) < keyLen {
+			seekKey = append(seekKey, 0xFF)
+		}
+		numScanned := 0
+		for iterator.Seek(seekKey); iterator.ValidForPrefix(prefix) && numScanned < MaxSearchScanKeys &&
+			len(postHashes) < limit; iterator.Next() {
+
+			numScanned++
+			key := iterator.Item().Key()
+			if len(key) != keyLen {
+				continue
+			}
+			tstampNanos := DecodeUint64(key[len(prefix) : len(prefix)+8])
+			postHash := NewBlockHash(key[len(prefix)+8:])
+			hasAllTerms := true
+			for _, term := range terms[1:] {
+				if _, err := DBGetWithTxn(txn, nil, _dbKeyForSearchTermPost(term, tstampNanos, postHash)); err != nil {
+					hasAllTerms = false
+					break
+				}
+			}
+			if hasAllTerms {
+				postHashes = append(postHashes, postHash)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "SearchPosts: ")
+	}
+	return postHashes, nil
+}
+
+// SearchProfiles returns the PKIDs of up to limit profiles that have every term of the query in
+// their username or description. The profile whose username is the query, if any, comes first, and
+// the others are in PKID order. At most MaxSearchScanKeys profiles with the query's longest term are
+// looked at.
+func SearchProfiles(handle *badger.DB, query string, limit int) ([]*PKID, error) {
+	terms := _searchQueryTerms(query)
+	if len(terms) == 0 || limit <= 0 {
+		return nil, nil
+	}
+
+	prefix := _dbPrefixForSearchTermProfiles(terms[0])
+	keyLen := len(prefix) + btcec.PubKeyBytesLenCompressed
+	var pkids []*PKID
+	err := handle.View(func(txn *badger.Txn) error {
+		username := strings.TrimPrefix(strings.TrimSpace(query), "@")
+		var usernamePKID *PKID
+		if UsernameRegex.MatchString(username) {
+			usernamePKID = DBGetPKIDForUsernameWithTxn(txn, nil, []byte(username))
+		}
+		if usernamePKID != nil {
+			entry, err := DbGetSearchIndexProfileEntryWithTxn(txn, usernamePKID)
+			if err != nil {
+				return err
+			}
+			if entry != nil {
+				pkids = append(pkids, usernamePKID)
+			}
+		}
+
+		iterator := txn.NewIterator(badger.IteratorOptions{PrefetchValues: false, Prefix: prefix})
+		defer iterator.Close()
+
+		numScanned := 0
+		for iterator.Seek(prefix); iterator.ValidForPrefix(prefix) && numScanned < MaxSearchScanKeys &&
+			len(pkids) < limit; iterator.Next() {
+
+			numScanned++
+			key := iterator.Item().Key()
+			if len(key) != keyLen {
+				continue
+			}
+			pkid := &PKID{}
+			copy(pkid[:], key[len(prefix):])
+			if usernamePKID != nil && *pkid == *usernamePKID {
+				continue
+			}
+			hasAllTerms := true
+			for _, term := range terms[1:] {
+				if _, err := DBGetWithTxn(txn, nil, _dbKeyForSearchTermProfile(term, pkid)); err != nil {
+					hasAllTerms = false
+					break
+				}
+			}
+			if hasAllTerms {
+				pkids = append(pkids, pkid)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "SearchProfiles: ")
+	}
+	return pkids, nil
+}
+
+// RebuildSearchIndex drops the search index and indexes every post and profile in the db again, then
+// marks the index as built. It must not run concurrently with the IndexQueue.
+func RebuildSearchIndex(handle *badger.DB) error {
+	if err := DbSetSearchIndexBuilt(handle, false); err != nil {
+		return errors.Wrapf(err, "RebuildSearchIndex: Problem marking the search index as stale")
+	}
+
+	for _, prefix := range [][]byte{
+		Prefixes.PrefixSearchTermTstampNanosPostHash, Prefixes.PrefixSearchPostEntryByPostHash,
+		Prefixes.PrefixSearchTermProfilePKID, Prefixes.PrefixSearchProfileEntryByPKID} {
+
+		staleKeys, _, err := DBGetPaginatedKeysAndValuesForPrefix(handle, prefix, prefix,
+			0 /*keyLen*/, 0 /*numToFetch*/, false /*reverse*/, false /*fetchValues*/)
+		if err != nil {
+			return errors.Wrapf(err, "RebuildSearchIndex: Problem reading existing index")
+		}
+		for start := 0; start < len(staleKeys); start += SearchIndexRebuildBatchSize * MaxSearchTermsPerDocument {
+			end := start + SearchIndexRebuildBatchSize*MaxSearchTermsPerDocument
+			if end > len(staleKeys) {
+				end = len(staleKeys)
+			}
+			err = handle.Update(func(txn *badger.Txn) error {
+				for _, key := range staleKeys[start:end] {
+					if err := DBDeleteWithTxn(txn, nil, key); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return errors.Wrapf(err, "RebuildSearchIndex: Problem deleting existing index")
+			}
+		}
+	}
+
+	// Index the posts and profiles in batches so that we don't exceed badger's txn size limits.
+	var postEntries []*SearchIndexPostEntry
+	var profileEntries []*SearchIndexProfileEntry
+	numPosts, numProfiles := 0, 0
+	writeEntries := func() error {
+		err := handle.Update(func(txn *badger.Txn) error {
+			for _, entry := range postEntries {
+				if err := DbPutSearchIndexPostEntryWithTxn(txn, entry); err != nil {
+					return err
+				}
+			}
+			for _, entry := range profileEntries {
+				if err := DbPutSearchIndexProfileEntryWithTxn(txn, entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		numPosts += len(postEntries)
+		numProfiles += len(profileEntries)
+		postEntries, profileEntries = nil, nil
+		return err
+	}
+	err := handle.View(func(txn *badger.Txn) error {
+		iterator := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iterator.Close()
+
+		for _, prefix := range [][]byte{Prefixes.PrefixPostHashToPostEntry, Prefixes.PrefixPKIDToProfileEntry} {
+			for iterator.Seek(prefix); iterator.ValidForPrefix(prefix); iterator.Next() {
+				key := iterator.Item().KeyCopy(nil)
+				value, err := iterator.Item().ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				if bytes.Equal(prefix, Prefixes.PrefixPostHashToPostEntry) {
+					postEntry := &PostEntry{}
+					if exists, err := DecodeFromBytes(postEntry, bytes.NewReader(value)); !exists || err != nil {
+						RecordCorruptedEntry(handle, key, fmt.Errorf("RebuildSearchIndex: Problem decoding "+
+							"post: exists %v, error %v", exists, err))
+						continue
+					}
+					if entry := ComputeSearchIndexPostEntry(postEntry); entry != nil {
+						postEntries = append(postEntries, entry)
+					}
+				} else {
+					profileEntry := &ProfileEntry{}
+					if exists, err := DecodeFromBytes(profileEntry, bytes.NewReader(value)); !exists || err != nil {
+						RecordCorruptedEntry(handle, key, fmt.Errorf("RebuildSearchIndex: Problem decoding "+
+							"profile: exists %v, error %v", exists, err))
+						continue
+					}
+					pkid := &PKID{}
+					copy(pkid[:], key[len(prefix):])
+					if entry := ComputeSearchIndexProfileEntry(pkid, profileEntry); entry != nil {
+						profileEntries = append(profileEntries, entry)
+					}
+				}
+				if len(postEntries)+len(profileEntries) >= SearchIndexRebuildBatchSize {
+					if err := writeEntries(); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = writeEntries()
+	}
+	if err != nil {
+		return errors.Wrapf(err, "RebuildSearchIndex: Problem indexing posts and profiles")
+	}
+
+	if err = DbSetSearchIndexBuilt(handle, true); err != nil {
+		return errors.Wrapf(err, "RebuildSearchIndex: Problem marking the search index as built")
+	}
+	glog.Infof("RebuildSearchIndex: Indexed %v posts and %v profiles", numPosts, numProfiles)
+	return nil
+}
@@ -0,0 +1,130 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenizeSearchText(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal([]string{"hello", "wörld", "deso", "in", "2023", "again"},
+		TokenizeSearchText("Hello, Wörld! #DeSo in 2023... hello again"))
+	require.Equal([]string{"bob", "example", "com"}, TokenizeSearchText("bob@example.com"))
+
+	// Terms that are too short or too long are skipped.
+	require.Empty(TokenizeSearchText("a b c abcdefghijabcdefghijabcdefghijabcdefghijx"))
+}
+
+func TestSearchIndexEntryEncoding(t *testing.T) {
+	require := require.New(t)
+
+	postEntry := &SearchIndexPostEntry{
+		PostHash:       NewBlockHash(RandomBytes(HashSizeBytes)),
+		TimestampNanos: 123,
+		Terms:          []string{"hello", "wörld"},
+	}
+	decodedPostEntry := &SearchIndexPostEntry{}
+	require.NoError(decodedPostEntry.FromBytes(postEntry.ToBytes()))
+	require.Equal(postEntry, decodedPostEntry)
+
+	profileEntry := &SearchIndexProfileEntry{
+		PKID:  NewPKID(m0PkBytes),
+		Terms: []string{"sender"},
+	}
+	decodedProfileEntry := &SearchIndexProfileEntry{}
+	require.NoError(decodedProfileEntry.FromBytes(profileEntry.ToBytes()))
+	require.Equal(profileEntry, decodedProfileEntry)
+}
+
+func TestSearchIndex(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+	indexQueue := NewIndexQueue(db)
+	RegisterSearchIndexHandlers(indexQueue)
+
+	// Mine a few blocks to give the sender some DESO to post with.
+	for ii := 0; ii < 2; ii++ {
+		_, err := miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+	}
+	chain.SetIndexQueue(indexQueue)
+
+	senderPkBytes, _, err := Base58CheckDecode(senderPkString)
+	require.NoError(err)
+	mineTxn := func(txn *MsgDeSoTxn) {
+		_signTxn(t, txn, senderPrivString)
+		_, err := mempool.ProcessTransaction(txn, false, false, 0, true)
+		require.NoError(err)
+		_, err = miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+		_, err = indexQueue.ProcessTasks(IndexQueueBatchSize)
+		require.NoError(err)
+	}
+	submitPost := func(postHashToModify []byte, body string, tstampNanos uint64) *BlockHash {
+		txn, _, _, _, err := chain.CreateSubmitPostTxn(senderPkBytes, postHashToModify, nil,
+			[]byte(`{"Body": "`+body+`"}`), nil, false, tstampNanos, nil, false, 10, mempool, nil)
+		require.NoError(err)
+		mineTxn(txn)
+		return txn.Hash()
+	}
+	searchPosts := func(query string) []*BlockHash {
+		postHashes, err := SearchPosts(db, query, 10)
+		require.NoError(err)
+		return postHashes
+	}
+	searchProfiles := func(query string) []*PKID {
+		pkids, err := SearchProfiles(db, query, 10)
+		require.NoError(err)
+		return pkids
+	}
+
+	txn, _, _, _, err := chain.CreateUpdateProfileTxn(senderPkBytes, nil, "Sender", "Posts about DeSo",
+		"", 1000, 12500, false, 0, nil, 10, mempool, nil)
+	require.NoError(err)
+	mineTxn(txn)
+	senderPKID := DBGetPKIDEntryForPublicKey(db, nil, senderPkBytes).PKID
+
+	// Every term of the query must match, case-insensitively, and the newest post comes first.
+	post1 := submitPost(nil, "Hello DeSo world", 1)
+	post1Height := uint64(chain.blockTip().Height)
+	post2 := submitPost(nil, "hello again, deso", 2)
+	submitPost(nil, "something else", 3)
+	require.Equal([]*BlockHash{post2, post1}, searchPosts("DESO hello"))
+	require.Equal([]*BlockHash{post1}, searchPosts("hello world"))
+	require.Empty(searchPosts("hello nothing"))
+	require.Empty(searchPosts("!"))
+	postHashes, err := SearchPosts(db, "hello", 1)
+	require.NoError(err)
+	require.Equal([]*BlockHash{post2}, postHashes)
+
+	// Profiles are matched on their username and description.
+	require.Equal([]*PKID{senderPKID}, searchProfiles("@sender"))
+	require.Equal([]*PKID{senderPKID}, searchProfiles("posts deso"))
+	require.Empty(searchProfiles("posts nothing"))
+
+	// Editing a post reindexes it.
+	submitPost(post1[:], "goodbye world", 1)
+	require.Equal([]*BlockHash{post2}, searchPosts("hello"))
+	require.Equal([]*BlockHash{post1}, searchPosts("goodbye"))
+
+	// Disconnecting the blocks after post1 removes post2 from the index, and reverts post1 to the
+	// body it was created with.
+	require.NoError(chain.DisconnectBlocksToHeight(post1Height))
+	_, err = indexQueue.ProcessTasks(IndexQueueBatchSize)
+	require.NoError(err)
+	require.Equal([]*BlockHash{post1}, searchPosts("hello"))
+	require.Empty(searchPosts("goodbye"))
+
+	// A rebuild indexes the same posts and profiles.
+	require.False(DbIsSearchIndexBuilt(db))
+	require.NoError(RebuildSearchIndex(db))
+	require.True(DbIsSearchIndexBuilt(db))
+	require.Equal([]*BlockHash{post1}, searchPosts("hello"))
+	require.Equal([]*PKID{senderPKID}, searchProfiles("sender"))
+}
@@ -0,0 +1,152 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// A selective hypersync only syncs the state prefixes in the server's allow-list, which is enough for
+// lightweight mirrors that only serve e.g. profiles and balances. Since the node doesn't have the rest
+// of the state, it can't verify the snapshot checksum or connect blocks, so it stays at the snapshot
+// height. The synced prefixes are recorded in PrefixSelectiveSyncStatePrefixes, and reads from the
+// other state prefixes fail with ErrPrefixNotSynced rather than quietly returning nothing.
+
+// ErrPrefixNotSynced is returned when reading a state prefix that was left out of a selective hypersync.
+type ErrPrefixNotSynced struct {
+	Prefix []byte
+}
+
+func (err *ErrPrefixNotSynced) Error() string {
+	return fmt.Sprintf("State prefix %v (%v) was not synced by this node", err.Prefix, dbPrefixName(err.Prefix[0]))
+}
+
+// IsErrPrefixNotSynced returns true if the error, or any error it wraps, is an ErrPrefixNotSynced.
+func IsErrPrefixNotSynced(err error) bool {
+	var notSyncedErr *ErrPrefixNotSynced
+	return errors.As(err, &notSyncedErr)
+}
+
+// unsyncedStatePrefixes holds a map[byte]bool of the state prefixes that this node doesn't have. It's
+// empty unless the node was selectively hypersynced.
+var unsyncedStatePrefixes atomic.Value
+
+// SetSyncedStatePrefixes marks every state prefix that isn't in syncedPrefixes as unsynced. Passing nil
+// marks all state prefixes as synced.
+func SetSyncedStatePrefixes(syncedPrefixes [][]byte) {
+	unsynced := make(map[byte]bool)
+	if syncedPrefixes != nil {
+		for _, prefix := range StatePrefixes.StatePrefixesList {
+			if !_containsPrefix(syncedPrefixes, prefix) {
+				unsynced[prefix[0]] = true
+			}
+		}
+	}
+	unsyncedStatePrefixes.Store(unsynced)
+}
+
+// CheckPrefixSynced returns an ErrPrefixNotSynced if the key falls under a state prefix that wasn't synced.
+func CheckPrefixSynced(key []byte) error {
+	unsynced, _ := unsyncedStatePrefixes.Load().(map[byte]bool)
+	if len(unsynced) == 0 || len(key) == 0 {
+		return nil
+	}
+	if unsynced[key[0]] {
+		return &ErrPrefixNotSynced{Prefix: []byte{key[0]}}
+	}
+	return nil
+}
+
+// CheckAllStatePrefixesSynced returns an ErrPrefixNotSynced for the first unsynced state prefix, if any.
+func CheckAllStatePrefixesSynced() error {
+	for _, prefix := range StatePrefixes.StatePrefixesList {
+		if err := CheckPrefixSynced(prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FilterStatePrefixes returns the state prefixes in the allow-list, in StatePrefixesList order, or all
+// state prefixes if the allow-list is empty. Prefixes that aren't state prefixes are ignored.
+func FilterStatePrefixes(allowList [][]byte) [][]byte {
+	if len(allowList) == 0 {
+		return StatePrefixes.StatePrefixesList
+	}
+	var prefixes [][]byte
+	for _, prefix := range StatePrefixes.StatePrefixesList {
+		if _containsPrefix(allowList, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// ParseStatePrefixes converts state prefix names, like "PrefixPKIDToProfileEntry", or prefix ids, like
+// "23", into prefixes.
+func ParseStatePrefixes(prefixNamesOrIds []string) ([][]byte, error) {
+	var prefixes [][]byte
+	for _, nameOrId := range prefixNamesOrIds {
+		found := false
+		for _, prefix := range StatePrefixes.StatePrefixesList {
+			if nameOrId == dbPrefixName(prefix[0]) || nameOrId == strconv.Itoa(int(prefix[0])) {
+				prefixes = append(prefixes, prefix)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("ParseStatePrefixes: %v is not a state prefix", nameOrId)
+		}
+	}
+	return prefixes, nil
+}
+
+// LoadSyncedStatePrefixes reads the synced state prefixes from the db and marks the rest as unsynced.
+func LoadSyncedStatePrefixes(handle *badger.DB) error {
+	syncedPrefixes, err := DbGetSyncedStatePrefixes(handle)
+	if err != nil {
+		return errors.Wrapf(err, "LoadSyncedStatePrefixes: ")
+	}
+	SetSyncedStatePrefixes(syncedPrefixes)
+	return nil
+}
+
+func _containsPrefix(prefixes [][]byte, prefix []byte) bool {
+	for _, otherPrefix := range prefixes {
+		if bytes.Equal(otherPrefix, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func _encodeStatePrefixes(prefixes [][]byte) []byte {
+	data := UintToBuf(uint64(len(prefixes)))
+	for _, prefix := range prefixes {
+		data = append(data, EncodeByteArray(prefix)...)
+	}
+	return data
+}
+
+func _decodeStatePrefixes(data []byte) ([][]byte, error) {
+	rr := bytes.NewReader(data)
+	numPrefixes, err := ReadUvarint(rr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "_decodeStatePrefixes: Problem reading number of prefixes")
+	}
+	// Make sure the slice isn't nil even if there are no prefixes, since nil means all prefixes.
+	prefixes := [][]byte{}
+	for ii := uint64(0); ii < numPrefixes; ii++ {
+		prefix, err := DecodeByteArray(rr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "_decodeStatePrefixes: Problem reading prefix")
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
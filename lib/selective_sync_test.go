@@ -0,0 +1,73 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectiveSync(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+	defer SetSyncedStatePrefixes(nil)
+
+	profilePrefix := Prefixes.PrefixPKIDToProfileEntry
+	balancePrefix := Prefixes.PrefixPublicKeyToDeSoBalanceNanos
+
+	// Prefixes can be given by name or id, and only state prefixes are accepted.
+	prefixes, err := ParseStatePrefixes([]string{"PrefixPKIDToProfileEntry", "52"})
+	require.NoError(err)
+	require.Equal([][]byte{profilePrefix, balancePrefix}, prefixes)
+	_, err = ParseStatePrefixes([]string{"PrefixSelectiveSyncStatePrefixes"})
+	require.Error(err)
+	require.Equal(StatePrefixes.StatePrefixesList, FilterStatePrefixes(nil))
+	require.Equal([][]byte{profilePrefix}, FilterStatePrefixes([][]byte{Prefixes.PrefixAdminKVAuditLog, profilePrefix}))
+
+	// A node that wasn't selectively synced has all of the state.
+	syncedPrefixes, err := DbGetSyncedStatePrefixes(db)
+	require.NoError(err)
+	require.Nil(syncedPrefixes)
+	require.NoError(LoadSyncedStatePrefixes(db))
+	require.NoError(CheckAllStatePrefixesSynced())
+
+	// Record that only profiles were synced, and reload them like a restarted node.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return DbPutSyncedStatePrefixesWithTxn(txn, nil, [][]byte{profilePrefix})
+	}))
+	syncedPrefixes, err = DbGetSyncedStatePrefixes(db)
+	require.NoError(err)
+	require.Equal([][]byte{profilePrefix}, syncedPrefixes)
+	require.NoError(LoadSyncedStatePrefixes(db))
+
+	// Reads from the synced prefix work as usual, and reads from the rest return ErrPrefixNotSynced.
+	profileKey := append(append([]byte{}, profilePrefix...), RandomBytes(33)...)
+	balanceKey := append(append([]byte{}, balancePrefix...), RandomBytes(33)...)
+	require.NoError(db.View(func(txn *badger.Txn) error {
+		_, err := DBGetWithTxn(txn, nil, profileKey)
+		require.Equal(badger.ErrKeyNotFound, err)
+		_, err = DBGetWithTxn(txn, nil, balanceKey)
+		require.True(IsErrPrefixNotSynced(err))
+		_, _, err = _enumerateKeysForPrefixWithTxn(txn, balancePrefix)
+		require.True(IsErrPrefixNotSynced(err))
+		return nil
+	}))
+	_, _, err = DBIteratePrefixKeys(db, balancePrefix, balancePrefix, SnapshotBatchSize)
+	require.True(IsErrPrefixNotSynced(err))
+	_, _, err = DBIteratePrefixKeys(db, profilePrefix, profilePrefix, SnapshotBatchSize)
+	require.NoError(err)
+
+	// Blocks can't be processed on partial state.
+	require.True(IsErrPrefixNotSynced(CheckAllStatePrefixesSynced()))
+	_, _, err = chain.ProcessBlock(&MsgDeSoBlock{Header: &MsgDeSoHeader{}}, false)
+	require.True(IsErrPrefixNotSynced(err))
+
+	// Clearing the unsynced prefixes lets blocks through again.
+	SetSyncedStatePrefixes(nil)
+	_, err = miner.MineAndProcessSingleBlock(0, mempool)
+	require.NoError(err)
+}
@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -60,6 +62,13 @@ type Server struct {
 	eventManager  *EventManager
 	TxIndex       *TXIndex
 
+	// peerBanManager scores the misbehavior of peers and keeps track of the IPs that are banned.
+	peerBanManager *PeerBanManager
+
+	// hyperSyncPrefixAllowList restricts hypersync to these state prefixes. If it's empty,
+	// all state prefixes are synced.
+	hyperSyncPrefixAllowList [][]byte
+
 	// All messages received from peers get sent from the ConnectionManager to the
 	// Server through this channel.
 	//
@@ -88,13 +97,12 @@ type Server struct {
 	// The waitGroup is used to manage the cleanup of the Server.
 	waitGroup deadlock.WaitGroup
 
-	// During initial block download, we request headers and blocks from a single
-	// peer. Note: These fields should only be accessed from the messageHandler thread.
-	//
-	// TODO: This could be much faster if we were to download blocks in parallel
-	// rather than from a single peer but it won't be a problem until later, at which
-	// point we can make the optimization.
-	SyncPeer *Peer
+	// During initial block download, we request headers from a single peer. Blocks
+	// are downloaded from several peers at once by the blockDownloader, unless it's
+	// turned off in which case they also come from the SyncPeer. Note: These fields
+	// should only be accessed from the messageHandler thread.
+	SyncPeer        *Peer
+	blockDownloader *BlockDownloader
 
 	// If we're syncing state using hypersync, we'll keep track of the progress using HyperSyncProgress.
 	// It stores information about all the prefixes that we're fetching. The way that HyperSyncProgress
@@ -223,6 +231,52 @@ func (srv *Server) GetConnectionManager() *ConnectionManager {
 }
 
 // TODO: The hallmark of a messy non-law-of-demeter-following interface...
+// GetBannedPeers returns the IPs that are currently banned, sorted by IP.
+func (srv *Server) GetBannedPeers() []*PeerBanEntry {
+	return srv.peerBanManager.GetBans()
+}
+
+// BanPeer bans the IP for the duration, or for the configured ban duration if the duration is
+// zero, and disconnects the peers connected from it.
+func (srv *Server) BanPeer(ip net.IP, duration time.Duration, reason string) error {
+	if err := srv.peerBanManager.Ban(ip, duration, reason); err != nil {
+		return errors.Wrapf(err, "Server.BanPeer: ")
+	}
+	srv._disconnectPeersWithIP(ip)
+	return nil
+}
+
+// UnbanPeer lifts the IP's ban and resets its misbehavior score.
+func (srv *Server) UnbanPeer(ip net.IP) error {
+	if err := srv.peerBanManager.Unban(ip); err != nil {
+		return errors.Wrapf(err, "Server.UnbanPeer: ")
+	}
+	return nil
+}
+
+// _recordPeerMisbehavior counts the misbehavior against the peer's IP, and disconnects all the peers
+// connected from the IP if it gets banned for it.
+func (srv *Server) _recordPeerMisbehavior(pp *Peer, misbehavior PeerMisbehaviorType) {
+	if pp == nil || pp.netAddr == nil {
+		return
+	}
+	if srv.peerBanManager.RecordMisbehavior(pp.netAddr.IP, misbehavior) {
+		srv._disconnectPeersWithIP(pp.netAddr.IP)
+	}
+}
+
+func (srv *Server) _disconnectPeersWithIP(ip net.IP) {
+	if srv.cmgr == nil {
+		return
+	}
+	for _, pp := range srv.cmgr.GetAllPeers() {
+		if pp.netAddr != nil && pp.netAddr.IP.Equal(ip) {
+			glog.Infof("Server._disconnectPeersWithIP: Disconnecting banned peer %v", pp)
+			pp.Disconnect()
+		}
+	}
+}
+
 func (srv *Server) GetMiner() *DeSoMiner {
 	return srv.miner
 }
@@ -365,7 +419,12 @@ func NewServer(
 	_trustedBlockProducerStartHeight uint64,
 	eventManager *EventManager,
 	_nodeMessageChan chan NodeMessage,
-	_forceChecksum bool) (
+	_forceChecksum bool,
+	_hyperSyncPrefixAllowList [][]byte,
+	_peerBanThreshold uint64,
+	_peerBanDuration time.Duration,
+	_maxInvItemsPerSecond uint64,
+	_maxBlockDownloadPeers uint64) (
 	_srv *Server, _err error, _shouldRestart bool) {
 
 	var err error
@@ -396,6 +455,18 @@ func NewServer(
 		snapshot:                     _snapshot,
 		nodeMessageChannel:           _nodeMessageChan,
 		forceChecksum:                _forceChecksum,
+		hyperSyncPrefixAllowList:     _hyperSyncPrefixAllowList,
+	}
+
+	// Load the peer bans before the connection manager can accept any connections.
+	srv.peerBanManager, err = NewPeerBanManager(_db, _peerBanThreshold, _peerBanDuration, _maxInvItemsPerSecond)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewServer: Problem initializing peer bans"), true
+	}
+
+	if _maxBlockDownloadPeers > 0 {
+		srv.blockDownloader = NewBlockDownloader(
+			int(_maxBlockDownloadPeers), BlockDownloadWindow, BlockDownloadStallTimeout)
 	}
 
 	// The same timesource is used in the chain data structure and in the connection
@@ -433,6 +504,10 @@ func NewServer(
 	if err != nil {
 		return nil, errors.Wrapf(err, "NewServer: Problem initializing blockchain"), true
 	}
+	// If the node was selectively hypersynced, reads from the state prefixes it skipped will error.
+	if err = LoadSyncedStatePrefixes(_db); err != nil {
+		return nil, errors.Wrapf(err, "NewServer: Problem loading synced state prefixes"), true
+	}
 
 	glog.V(1).Infof("Initialized chain: Best Header Height: %d, Header Hash: %s, Header CumWork: %s, Best Block Height: %d, Block Hash: %s, Block CumWork: %s",
 		_chain.headerTip().Height,
@@ -587,6 +662,16 @@ func (srv *Server) _handleGetHeaders(pp *Peer, msg *MsgDeSoGetHeaders) {
 		headers, blockTip.Hash, blockTip.Height, pp)
 }
 
+// hyperSyncPrefixes returns the state prefixes that hypersync should fetch.
+func (srv *Server) hyperSyncPrefixes() [][]byte {
+	return FilterStatePrefixes(srv.hyperSyncPrefixAllowList)
+}
+
+// isSelectiveHyperSync returns true if hypersync skips some of the state prefixes.
+func (srv *Server) isSelectiveHyperSync() bool {
+	return len(srv.hyperSyncPrefixes()) < len(StatePrefixes.StatePrefixesList)
+}
+
 // GetSnapshot is used for sending MsgDeSoGetSnapshot messages to peers. We will
 // check if the passed peer has been assigned to an in-progress prefix and if so,
 // we will request a snapshot data chunk from them. Otherwise, we will assign a
@@ -628,7 +713,7 @@ func (srv *Server) GetSnapshot(pp *Peer) {
 	// If peer isn't assigned to any prefix, we will assign him now.
 	if !syncingPrefix {
 		// We will assign the peer to a non-existent prefix.
-		for _, prefix = range StatePrefixes.StatePrefixesList {
+		for _, prefix = range srv.hyperSyncPrefixes() {
 			exists := false
 			for _, prefixProgress := range srv.HyperSyncProgress.PrefixProgress {
 				if reflect.DeepEqual(prefix, prefixProgress.Prefix) {
@@ -733,6 +818,11 @@ func (srv *Server) GetBlocksToStore(pp *Peer) {
 // corresponding peer. It is typically called after we have exited
 // SyncStateSyncingHeaders.
 func (srv *Server) GetBlocks(pp *Peer, maxHeight int) {
+	// While we're syncing blocks, the blockDownloader fetches them from all of our sync peers.
+	if srv.blockchain.chainState() == SyncStateSyncingBlocks && srv._assignBlockDownloads() {
+		return
+	}
+
 	// Fetch as many blocks as we can from this peer.
 	numBlocksToFetch := MaxBlocksInFlight - len(pp.requestedBlocks)
 	blockNodesToFetch := srv.blockchain.GetBlockNodesToFetch(
@@ -750,9 +840,18 @@ func (srv *Server) GetBlocks(pp *Peer, maxHeight int) {
 
 		pp.requestedBlocks[*node.Hash] = true
 	}
-	pp.AddDeSoMessage(&MsgDeSoGetBlocks{
-		HashList: hashList,
-	}, false)
+
+	// Once we're caught up, new blocks mostly consist of txns we already have in our mempool
+	// so we ask for compact blocks if the peer supports them. While syncing we need full blocks.
+	if !srv.blockchain.isSyncing() && pp.SupportsCompactBlocks() {
+		pp.AddDeSoMessage(&MsgDeSoGetCompactBlocks{
+			HashList: hashList,
+		}, false)
+	} else {
+		pp.AddDeSoMessage(&MsgDeSoGetBlocks{
+			HashList: hashList,
+		}, false)
+	}
 
 	glog.V(1).Infof("GetBlocks: Downloading %d blocks from header %v to header %v from peer %v",
 		len(blockNodesToFetch),
@@ -1052,6 +1151,51 @@ func (srv *Server) _handleGetBlocks(pp *Peer, msg *MsgDeSoGetBlocks) {
 	pp.AddDeSoMessage(msg, true /*inbound*/)
 }
 
+func (srv *Server) _handleGetCompactBlocks(pp *Peer, msg *MsgDeSoGetCompactBlocks) {
+	glog.V(1).Infof("srv._handleGetCompactBlocks: Called with message %v from Peer %v", msg, pp)
+
+	// Let the peer handle this
+	pp.AddDeSoMessage(msg, true /*inbound*/)
+}
+
+// _handleCompactBlock rebuilds a block we requested as a compact block out of the txns in our
+// mempool and processes it like any other block. If the block can't be rebuilt, we ask the
+// peer for the full block instead.
+func (srv *Server) _handleCompactBlock(pp *Peer, msg *MsgDeSoCompactBlock) {
+	blockHash, err := msg.Hash()
+	if err != nil {
+		srv._logAndDisconnectPeer(pp, nil, "Problem computing compact block hash")
+		return
+	}
+
+	// We only ever ask for compact blocks with GetCompactBlocks, so any other compact block is
+	// unsolicited. Ignore it rather than requesting the full block.
+	if _, exists := pp.requestedBlocks[*blockHash]; !exists {
+		glog.Errorf("Server._handleCompactBlock: Getting a compact block that we haven't requested "+
+			"before, block hash (%v) from Peer %v", blockHash, pp)
+		return
+	}
+
+	mempoolTxns := []*MsgDeSoTxn{}
+	for _, mempoolTx := range srv.mempool.MempoolTxs() {
+		mempoolTxns = append(mempoolTxns, mempoolTx.Tx)
+	}
+	blk, err := msg.ReconstructBlock(mempoolTxns)
+	if err != nil {
+		// The block stays in requestedBlocks until the full block arrives.
+		glog.V(1).Infof("Server._handleCompactBlock: Requesting full block %v from Peer %v "+
+			"because reconstructing it failed: %v", blockHash, pp, err)
+		pp.AddDeSoMessage(&MsgDeSoGetBlocks{
+			HashList: []*BlockHash{blockHash},
+		}, false)
+		return
+	}
+
+	glog.V(1).Infof("Server._handleCompactBlock: Reconstructed block %v with %d prefilled txns "+
+		"from Peer %v", blockHash, len(msg.PrefilledTxns), pp)
+	srv._handleBlock(pp, blk)
+}
+
 // _handleGetSnapshot gets called whenever we receive a GetSnapshot message from a peer. This means
 // a peer is asking us to send him some data from our most recent snapshot. To respond to the peer we
 // will retrieve the chunk from our main and ancestral records db and attach it to the response message.
@@ -1152,6 +1296,16 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 	prevChecksumBytes := make([]byte, len(srv.HyperSyncProgress.SnapshotMetadata.CurrentEpochChecksumBytes))
 	copy(prevChecksumBytes, srv.HyperSyncProgress.SnapshotMetadata.CurrentEpochChecksumBytes[:])
 	if len(srv.HyperSyncProgress.SnapshotMetadata.CurrentEpochChecksumBytes) == 0 {
+		// If we have a checkpoint for the snapshot height, the peer's checksum has to match it.
+		if err := CheckStateChecksumCheckpoint(srv.blockchain.params,
+			srv.HyperSyncProgress.SnapshotMetadata.SnapshotBlockHeight,
+			msg.SnapshotMetadata.CurrentEpochChecksumBytes); err != nil {
+
+			glog.Errorf("srv._handleSnapshot: Epoch checksum bytes received from peer don't match the "+
+				"checkpoint, disconnecting misbehaving peer (%v): %v", pp, err)
+			pp.Disconnect()
+			return
+		}
 		srv.HyperSyncProgress.SnapshotMetadata.CurrentEpochChecksumBytes = msg.SnapshotMetadata.CurrentEpochChecksumBytes
 	} else if !reflect.DeepEqual(srv.HyperSyncProgress.SnapshotMetadata.CurrentEpochChecksumBytes, msg.SnapshotMetadata.CurrentEpochChecksumBytes) {
 		// We should disconnect the peer because he is misbehaving
@@ -1260,7 +1414,7 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 	// and see what's left to do.
 
 	var completedPrefixes [][]byte
-	for _, prefix := range StatePrefixes.StatePrefixesList {
+	for _, prefix := range srv.hyperSyncPrefixes() {
 		completed := false
 		// Check if the prefix has been completed.
 		for _, prefixProgress := range srv.HyperSyncProgress.PrefixProgress {
@@ -1302,7 +1456,11 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 	if err != nil {
 		glog.Errorf("Server._handleSnapshot: Problem getting checksum bytes, error (%v)", err)
 	}
-	if reflect.DeepEqual(checksumBytes, srv.HyperSyncProgress.SnapshotMetadata.CurrentEpochChecksumBytes) {
+	if srv.isSelectiveHyperSync() {
+		// The checksum covers all state prefixes, so it can't match if we only synced some of them.
+		glog.Infof(CLog(Yellow, fmt.Sprintf("Server._handleSnapshot: Skipping the state checksum check "+
+			"because only prefixes %v were synced", completedPrefixes)))
+	} else if reflect.DeepEqual(checksumBytes, srv.HyperSyncProgress.SnapshotMetadata.CurrentEpochChecksumBytes) {
 		glog.Infof(CLog(Green, fmt.Sprintf("Server._handleSnapshot: State checksum matched "+
 			"what was expected!")))
 	} else {
@@ -1345,17 +1503,29 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 			if err != nil {
 				return err
 			}
+			if err = DbPutMainChainHashAtHeightWithTxn(txn, uint64(curretNode.Height), curretNode.Hash); err != nil {
+				return err
+			}
 		}
 		// We will also set the hash of the block at snapshot height as the best chain hash.
 		err := PutBestHashWithTxn(txn, srv.snapshot, msg.SnapshotMetadata.CurrentEpochBlockHash, ChainTypeDeSoBlock)
-		return err
+		if err != nil {
+			return err
+		}
+		// Record which prefixes we have, so that reads from the rest error out after a restart.
+		if srv.isSelectiveHyperSync() {
+			if err = DbPutSyncedStatePrefixesWithTxn(txn, nil, completedPrefixes); err != nil {
+				return err
+			}
+		}
+		return DbPutStateFlushHeightWithTxn(txn, srv.snapshot, msg.SnapshotMetadata.SnapshotBlockHeight)
 	})
 	if err != nil {
 		glog.Errorf("Server._handleSnapshot: Problem updating snapshot blocknodes, error: (%v)", err)
 	}
 	// We also reset the in-memory snapshot cache, because it is populated with stale records after
 	// we've initialized the chain with seed transactions.
-	srv.snapshot.DatabaseCache = lru.NewKVCache(DatabaseCacheSize)
+	srv.snapshot.ResetDatabaseCache()
 
 	// If we got here then we finished the snapshot sync so set appropriate flags.
 	srv.blockchain.syncingState = false
@@ -1380,6 +1550,30 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 	srv.snapshot.Status.CurrentBlockHeight = msg.SnapshotMetadata.SnapshotBlockHeight
 	srv.snapshot.Status.SaveStatus()
 
+	// Hypersync writes the utxos directly, so the utxo aggregates have to be rebuilt from them.
+	if err = DbBackfillUtxoAggregates(srv.blockchain.db, srv.snapshot); err != nil {
+		glog.Errorf("server._handleSnapshot: Problem backfilling utxo aggregates, error (%v)", err)
+	}
+	if err = DbBackfillHolderLeaderboards(srv.blockchain.db, srv.snapshot); err != nil {
+		glog.Errorf("server._handleSnapshot: Problem backfilling coin holder leaderboards, error (%v)", err)
+	}
+	if err = DbBackfillPostCounts(srv.blockchain.db, srv.snapshot); err != nil {
+		glog.Errorf("server._handleSnapshot: Problem backfilling post counts, error (%v)", err)
+	}
+	if err = DbBackfillDiamondAggregates(srv.blockchain.db, srv.snapshot); err != nil {
+		glog.Errorf("server._handleSnapshot: Problem backfilling diamond aggregates, error (%v)", err)
+	}
+
+	// Record the state checksum at the snapshot height, since we won't process that block. It only
+	// covers the full state if we synced every prefix.
+	if !srv.isSelectiveHyperSync() {
+		if err = srv.snapshot.PutStateChecksumAtHeight(msg.SnapshotMetadata.SnapshotBlockHeight,
+			checksumBytes); err != nil {
+			glog.Errorf("server._handleSnapshot: Problem saving the state checksum at the snapshot "+
+				"height, error (%v)", err)
+		}
+	}
+
 	glog.Infof("server._handleSnapshot: FINAL snapshot checksum is (%v) (%v)",
 		srv.snapshot.CurrentEpochSnapshotMetadata.CurrentEpochChecksumBytes,
 		hex.EncodeToString(srv.snapshot.CurrentEpochSnapshotMetadata.CurrentEpochChecksumBytes))
@@ -1387,6 +1581,16 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 	// Take care of any callbacks that need to run once the snapshot is completed.
 	srv.eventManager.snapshotCompleted()
 
+	// We can't connect blocks without the full state, so a selectively synced node stays at the
+	// snapshot height.
+	if srv.isSelectiveHyperSync() {
+		SetSyncedStatePrefixes(completedPrefixes)
+		glog.Infof(CLog(Yellow, fmt.Sprintf("Server._handleSnapshot: Finished selective hypersync of "+
+			"prefixes %v at height %v, not syncing blocks", completedPrefixes,
+			msg.SnapshotMetadata.SnapshotBlockHeight)))
+		return
+	}
+
 	// Now sync the remaining blocks.
 	if srv.blockchain.archivalMode {
 		srv.blockchain.downloadingHistoricalBlocks = true
@@ -1560,6 +1764,76 @@ func (srv *Server) _handleDonePeer(pp *Peer) {
 		srv.SyncPeer = nil
 		srv._startSync()
 	}
+
+	// Request the blocks we were downloading from the peer from our other peers.
+	if srv.blockDownloader != nil {
+		srv.blockDownloader.removePeer(pp)
+		if srv.SyncPeer != nil && srv.blockchain.chainState() == SyncStateSyncingBlocks {
+			srv._assignBlockDownloads()
+		}
+	}
+}
+
+// _blockDownloadPeers returns the peers the blockDownloader can download blocks from, starting
+// with the SyncPeer. Peers are only useful if they had more blocks than us when they connected.
+func (srv *Server) _blockDownloadPeers() []*Peer {
+	peers := []*Peer{}
+	if srv.SyncPeer != nil && srv.SyncPeer.Connected() {
+		peers = append(peers, srv.SyncPeer)
+	}
+	tipHeight := srv.blockchain.blockTip().Height
+	for _, pp := range srv.cmgr.GetAllPeers() {
+		if len(peers) >= srv.blockDownloader.maxPeers {
+			break
+		}
+		if pp == srv.SyncPeer || !pp.Connected() || pp.StartingBlockHeight() <= tipHeight ||
+			!pp.IsSyncCandidate() {
+
+			continue
+		}
+		peers = append(peers, pp)
+	}
+	return peers
+}
+
+// _assignBlockDownloads requests the next blocks we need from our block download peers, after
+// disconnecting the peer that's stalling the download if there is one. It returns false if the
+// blockDownloader can't be used, in which case blocks should be requested from the SyncPeer.
+func (srv *Server) _assignBlockDownloads() bool {
+	if srv.blockDownloader == nil {
+		return false
+	}
+	// The blockDownloader fetches the blocks of the best header chain, so our block tip has to be
+	// on it. If it isn't, GetBlocks finds where the chains forked.
+	blockTip := srv.blockchain.blockTip()
+	if _, exists := srv.blockchain.bestHeaderChainMap[*blockTip.Hash]; !exists {
+		return false
+	}
+
+	now := time.Now()
+	staller := srv.blockDownloader.stalledPeer(blockTip.Height, srv.blockchain.bestHeaderChain, now)
+	if staller != nil {
+		glog.Infof("Server._assignBlockDownloads: Disconnecting Peer %v because it's stalling the "+
+			"block download at height %v", staller, blockTip.Height+1)
+		srv.blockDownloader.removePeer(staller)
+		staller.Disconnect()
+	}
+
+	assignments := srv.blockDownloader.assignBlocks(
+		blockTip.Height, srv.blockchain.bestHeaderChain, srv._blockDownloadPeers(), now)
+	for pp, blockNodes := range assignments {
+		hashList := []*BlockHash{}
+		for _, node := range blockNodes {
+			hashList = append(hashList, node.Hash)
+			pp.requestedBlocks[*node.Hash] = true
+		}
+		pp.AddDeSoMessage(&MsgDeSoGetBlocks{
+			HashList: hashList,
+		}, false)
+		glog.V(1).Infof("Server._assignBlockDownloads: Downloading %d blocks from height %v to height %v "+
+			"from Peer %v", len(blockNodes), blockNodes[0].Height, blockNodes[len(blockNodes)-1].Height, pp)
+	}
+	return true
 }
 
 func (srv *Server) _relayTransactions() {
@@ -1570,7 +1844,7 @@ func (srv *Server) _relayTransactions() {
 	// For each peer, compute the transactions they're missing from the mempool and
 	// send them an inv.
 	allPeers := srv.cmgr.GetAllPeers()
-	txnList := srv.mempool.readOnlyUniversalTransactionList
+	txnList, _ := srv.mempool.getReadOnlyUniversalTransactions()
 	for _, pp := range allPeers {
 		if !pp.canReceiveInvMessagess {
 			glog.V(1).Infof("Skipping invs for peer %v because not ready "+
@@ -1758,6 +2032,12 @@ func (srv *Server) _handleBlock(pp *Peer, blk *MsgDeSoBlock) {
 		return
 	}
 
+	// A selectively synced node doesn't have the state to connect blocks. This isn't the peer's fault.
+	if err := CheckAllStatePrefixesSynced(); err != nil {
+		glog.V(1).Infof("Server._handleBlock: Ignoring block %v from Peer %v: %v", blk, pp, err)
+		return
+	}
+
 	// If we've set a maximum sync height and we've reached that height, then we will
 	// stop accepting new blocks.
 	if srv.blockchain.isTipMaxed(srv.blockchain.blockTip()) &&
@@ -1787,6 +2067,45 @@ func (srv *Server) _handleBlock(pp *Peer, blk *MsgDeSoBlock) {
 		glog.Errorf("_handleBlock: Called with nil peer, this should never happen.")
 	}
 
+	// Blocks from the blockDownloader can arrive out of order. The ones that can't be connected
+	// yet are staged until the blocks before them are.
+	if srv.blockDownloader != nil && srv.blockDownloader.removeInFlight(blockHash) &&
+		blockHeader.Height > uint64(srv.blockchain.blockTip().Height)+1 {
+
+		glog.V(1).Infof("Server._handleBlock: Staging block %v at height %v from Peer %v",
+			blockHash, blockHeader.Height, pp)
+		srv.blockDownloader.stageBlock(blk, blockHash, pp)
+		srv._assignBlockDownloads()
+		return
+	}
+
+	srv._processBlock(pp, blk, blockHash)
+	srv._connectStagedBlocks()
+}
+
+// _connectStagedBlocks connects the staged blocks that follow the block tip, in order.
+func (srv *Server) _connectStagedBlocks() {
+	if srv.blockDownloader == nil {
+		return
+	}
+	for {
+		staged := srv.blockDownloader.popStagedBlock(srv.blockchain.blockTip().Height + 1)
+		if staged == nil {
+			return
+		}
+		srv._processBlock(staged.Peer, staged.Block, staged.Hash)
+		// If the block didn't connect, it's requested again from another peer.
+		if *srv.blockchain.blockTip().Hash != *staged.Hash {
+			return
+		}
+	}
+}
+
+// _processBlock processes a block we received from the peer, and asks the peer for whatever we
+// need next.
+func (srv *Server) _processBlock(pp *Peer, blk *MsgDeSoBlock, blockHash *BlockHash) {
+	blockHeader := blk.Header
+
 	// Check that the mempool has not received a transaction that would forbid this block's signature pubkey.
 	// This is a minimal check, a more thorough check is made in the ProcessBlock function. This check is
 	// necessary because the ProcessBlock function only has access to mined transactions. Therefore, if an
@@ -1794,7 +2113,7 @@ func (srv *Server) _handleBlock(pp *Peer, blk *MsgDeSoBlock) {
 	// processing their blocks.
 	if len(srv.blockchain.trustedBlockProducerPublicKeys) > 0 && blockHeader.Height >= srv.blockchain.trustedBlockProducerStartHeight {
 		if blk.BlockProducerInfo != nil {
-			_, entryExists := srv.mempool.readOnlyUtxoView.ForbiddenPubKeyToForbiddenPubKeyEntry[MakePkMapKey(
+			_, entryExists := srv.mempool.getReadOnlyUtxoView().ForbiddenPubKeyToForbiddenPubKeyEntry[MakePkMapKey(
 				blk.BlockProducerInfo.PublicKey)]
 			if entryExists {
 				srv._logAndDisconnectPeer(pp, blk, "Got forbidden block signature public key.")
@@ -1807,6 +2126,7 @@ func (srv *Server) _handleBlock(pp *Peer, blk *MsgDeSoBlock) {
 
 	// Only verify signatures for recent blocks.
 	var isOrphan bool
+	var err error
 	if srv.blockchain.isSyncing() {
 		glog.V(1).Infof(CLog(Cyan, fmt.Sprintf("Server._handleBlock: Processing block %v WITHOUT "+
 			"signature checking because SyncState=%v for peer %v",
@@ -1833,6 +2153,12 @@ func (srv *Server) _handleBlock(pp *Peer, blk *MsgDeSoBlock) {
 			// out a way to be more strict about things.
 			glog.Warningf("Got duplicate block %v from peer %v", blk, pp)
 		} else {
+			srv.blockchain.ValidationFailureLog().RecordBlockFailure(
+				blockHash, blk.Header.Height, pp.ID, pp.Address(), err)
+			// Only rule errors are the peer's fault. Other errors, e.g. from the db, are ours.
+			if _, isRuleError := errors.Cause(err).(RuleError); isRuleError {
+				srv._recordPeerMisbehavior(pp, PeerMisbehaviorInvalidBlock)
+			}
 			srv._logAndDisconnectPeer(
 				pp, blk,
 				errors.Wrapf(err, "Error while processing block: ").Error())
@@ -1916,6 +2242,12 @@ func (srv *Server) _handleInv(peer *Peer, msg *MsgDeSoInv) {
 	if srv.blockchain.isTipMaxed(srv.blockchain.blockTip()) {
 		return
 	}
+	if !srv.peerBanManager.AllowInv(peer, len(msg.InvList)) {
+		glog.Warningf("_handleInv: Dropping inv message with %d items from peer %v because it's over "+
+			"the inv rate limit", len(msg.InvList), peer)
+		srv._recordPeerMisbehavior(peer, PeerMisbehaviorInvSpam)
+		return
+	}
 	peer.AddDeSoMessage(msg, true /*inbound*/)
 }
 
@@ -2011,8 +2343,12 @@ func (srv *Server) StartStatsdReporter() {
 				tags := []string{}
 
 				// Report mempool size
-				mempoolTotal := len(srv.mempool.readOnlyUniversalTransactionList)
+				mempoolTotal := srv.mempool.Count()
 				srv.statsdClient.Gauge("MEMPOOL.COUNT", float64(mempoolTotal), tags, 1)
+				for txnType, numFilteredTxns := range srv.mempool.GetNumFilteredTxnsByType() {
+					txnTypeTags := append(append([]string{}, tags...), "txn_type:"+txnType.String())
+					srv.statsdClient.Gauge("MEMPOOL.FILTERED_TXNS", float64(numFilteredTxns), txnTypeTags, 1)
+				}
 
 				// Report block + headers height
 				blocksHeight := srv.blockchain.BlockTip().Height
@@ -2021,6 +2357,35 @@ func (srv *Server) StartStatsdReporter() {
 				headersHeight := srv.blockchain.HeaderTip().Height
 				srv.statsdClient.Gauge("HEADERS.HEIGHT", float64(headersHeight), tags, 1)
 
+				// Report snapshot cache stats
+				if srv.snapshot != nil {
+					cacheMetrics := srv.snapshot.DatabaseCacheMetrics
+					srv.statsdClient.Gauge("SNAPSHOT.CACHE.HIT_RATE", cacheMetrics.HitRate(), tags, 1)
+					// The cache is resized on the main thread, so its size is read from the cache under its lock.
+					srv.statsdClient.Gauge("SNAPSHOT.CACHE.SIZE", float64(srv.snapshot.DatabaseCache.Limit()), tags, 1)
+					srv.statsdClient.Gauge("SNAPSHOT.CACHE.RESIZES",
+						float64(atomic.LoadUint64(&cacheMetrics.NumResizes)), tags, 1)
+					srv.statsdClient.Gauge("SNAPSHOT.CACHE.NEGATIVE_HITS",
+						float64(atomic.LoadUint64(&srv.snapshot.NegativeLookupCache.NumHits)), tags, 1)
+					srv.statsdClient.Gauge("SNAPSHOT.CACHE.HITS",
+						float64(atomic.LoadUint64(&cacheMetrics.TotalHits)), tags, 1)
+					srv.statsdClient.Gauge("SNAPSHOT.CACHE.MISSES",
+						float64(atomic.LoadUint64(&cacheMetrics.TotalMisses)), tags, 1)
+					srv.statsdClient.Gauge("SNAPSHOT.CACHE.RECORDS", float64(srv.snapshot.DatabaseCache.Len()), tags, 1)
+					for _, prefixStats := range cacheMetrics.GetPrefixStats() {
+						prefixTags := append(append([]string{}, tags...), "prefix:"+prefixStats.Name)
+						srv.statsdClient.Gauge("SNAPSHOT.CACHE.PREFIX.HITS", float64(prefixStats.Hits), prefixTags, 1)
+						srv.statsdClient.Gauge("SNAPSHOT.CACHE.PREFIX.MISSES", float64(prefixStats.Misses), prefixTags, 1)
+					}
+				}
+
+				// Report the corrupted entries batch getters skipped, and how many are still waiting
+				// to be repaired.
+				srv.statsdClient.Gauge("DB.CORRUPTED_ENTRIES.SKIPPED", float64(NumCorruptedEntriesSkipped()), tags, 1)
+				if reports, err := DbGetCorruptedEntryReports(srv.blockchain.db); err == nil {
+					srv.statsdClient.Gauge("DB.CORRUPTED_ENTRIES.REPORTED", float64(len(reports)), tags, 1)
+				}
+
 			case <-srv.mempool.quit:
 				break out
 			}
@@ -2133,6 +2498,10 @@ func (srv *Server) _handlePeerMessages(serverMessage *ServerMessage) {
 		srv._handleGetBlocks(serverMessage.Peer, msg)
 	case *MsgDeSoBlock:
 		srv._handleBlock(serverMessage.Peer, msg)
+	case *MsgDeSoGetCompactBlocks:
+		srv._handleGetCompactBlocks(serverMessage.Peer, msg)
+	case *MsgDeSoCompactBlock:
+		srv._handleCompactBlock(serverMessage.Peer, msg)
 	case *MsgDeSoGetSnapshot:
 		srv._handleGetSnapshot(serverMessage.Peer, msg)
 	case *MsgDeSoSnapshotData:
@@ -2353,6 +2722,11 @@ func (srv *Server) GetStatsdClient() *statsd.Client {
 	return srv.statsdClient
 }
 
+// GetDownloadServer returns a DownloadServer that serves this node's blocks and snapshot state.
+func (srv *Server) GetDownloadServer() *DownloadServer {
+	return NewDownloadServer(srv.blockchain, srv.snapshot)
+}
+
 // Start actually kicks off all of the management processes. Among other things, it causes
 // the ConnectionManager to actually start connecting to peers and receiving messages. If
 // requested, it also starts the miner.
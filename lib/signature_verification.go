@@ -0,0 +1,132 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/decred/dcrd/lru"
+)
+
+// Parsing a compressed public key means decompressing its point, which is a square root mod p and
+// costs about as much as a third of a signature check. The same keys sign most of the txns on the
+// chain, so parsed keys are kept in an LRU cache keyed by their 33-byte compressed encoding. Since
+// a key's encoding always parses to the same point, cached keys never have to be invalidated.
+var parsedPubKeyCache = lru.NewKVCache(ParsedPubKeyCacheSize)
+
+// ParsePubKeyCached is btcec.ParsePubKey on the S256 curve, with compressed keys served from a
+// cache. Callers share the returned key, so they must not modify it. Keys that fail to parse
+// aren't cached, and get the same error as btcec.ParsePubKey.
+func ParsePubKeyCached(pkBytes []byte) (*btcec.PublicKey, error) {
+	if len(pkBytes) != btcec.PubKeyBytesLenCompressed {
+		return btcec.ParsePubKey(pkBytes, btcec.S256())
+	}
+	var cacheKey [btcec.PubKeyBytesLenCompressed]byte
+	copy(cacheKey[:], pkBytes)
+	if cachedPk, exists := parsedPubKeyCache.Lookup(cacheKey); exists {
+		return cachedPk.(*btcec.PublicKey), nil
+	}
+	pk, err := btcec.ParsePubKey(pkBytes, btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+	parsedPubKeyCache.Add(cacheKey, pk)
+	return pk, nil
+}
+
+// Batch signature verification is opt-in. When it's enabled, ConnectBlock checks the signatures of
+// all the txns in a block in parallel before connecting them, and _verifySignature skips the check
+// for the ones that passed. The batch only does the part of the check that doesn't depend on the
+// view, i.e. the signature against the owner or derived key, so derived key authorizations are
+// still validated in order. Txns that fail the batch check are checked again when they're
+// connected, so that a block is rejected with the same error either way.
+var batchSignatureVerificationEnabled int32
+
+// EnableBatchSignatureVerification turns batch signature verification on or off.
+func EnableBatchSignatureVerification(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&batchSignatureVerificationEnabled, 1)
+	} else {
+		atomic.StoreInt32(&batchSignatureVerificationEnabled, 0)
+	}
+}
+
+func IsBatchSignatureVerificationEnabled() bool {
+	return atomic.LoadInt32(&batchSignatureVerificationEnabled) == 1
+}
+
+// TxnSignatureCheck is the result of checking a txn's signature in a batch.
+type TxnSignatureCheck struct {
+	// SignatureHash is the hash of the txn without its signature, which is what it's signed over.
+	// _verifySignature reuses it instead of serializing and hashing the txn again.
+	SignatureHash *BlockHash
+	// IsValid is set if the signature is valid for the key that _verifySignature would check it
+	// against.
+	IsValid bool
+}
+
+// VerifyTxnSignaturesInBatch checks the signatures of the txns in parallel. It returns a check for
+// every txn it could hash, and leaves out the others, e.g. block rewards.
+func VerifyTxnSignaturesInBatch(txns []*MsgDeSoTxn) map[*MsgDeSoTxn]*TxnSignatureCheck {
+	checks := make([]*TxnSignatureCheck, len(txns))
+	txnIndexes := make(chan int, len(txns))
+	for txnIndex := range txns {
+		txnIndexes <- txnIndex
+	}
+	close(txnIndexes)
+
+	numWorkers := runtime.NumCPU()
+	if numWorkers > len(txns) {
+		numWorkers = len(txns)
+	}
+	var wg sync.WaitGroup
+	for ii := 0; ii < numWorkers; ii++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for txnIndex := range txnIndexes {
+				checks[txnIndex] = _checkTxnSignature(txns[txnIndex])
+			}
+		}()
+	}
+	wg.Wait()
+
+	checksByTxn := make(map[*MsgDeSoTxn]*TxnSignatureCheck)
+	for txnIndex, txn := range txns {
+		if checks[txnIndex] != nil {
+			checksByTxn[txn] = checks[txnIndex]
+		}
+	}
+	return checksByTxn
+}
+
+// _checkTxnSignature returns nil if the txn can't be hashed. Other problems make the signature
+// invalid, since _verifySignature reports them when the txn is connected.
+func _checkTxnSignature(txn *MsgDeSoTxn) *TxnSignatureCheck {
+	if txn.TxnMeta == nil || txn.TxnMeta.GetTxnType() == TxnTypeBlockReward || txn.Signature.Sign == nil {
+		return nil
+	}
+	txBytes, err := txn.ToBytes(true /*preSignature*/)
+	if err != nil {
+		return nil
+	}
+	check := &TxnSignatureCheck{SignatureHash: Sha256DoubleHash(txBytes)}
+
+	signerPkBytes := txn.PublicKey
+	derivedPkBytes, isDerived, err := IsDerivedSignature(txn)
+	if err != nil {
+		return check
+	}
+	if isDerived {
+		signerPkBytes = derivedPkBytes
+	}
+	signerPk, err := ParsePubKeyCached(signerPkBytes)
+	if err != nil {
+		return check
+	}
+	check.IsValid = txn.Signature.Verify(check.SignatureHash[:], signerPk)
+	return check
+}
@@ -0,0 +1,89 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePubKeyCached(t *testing.T) {
+	require := require.New(t)
+
+	pkBytes, _, err := Base58CheckDecode(m0Pub)
+	require.NoError(err)
+	expectedPk, err := btcec.ParsePubKey(pkBytes, btcec.S256())
+	require.NoError(err)
+
+	// The second parse is served from the cache.
+	pk, err := ParsePubKeyCached(pkBytes)
+	require.NoError(err)
+	require.True(expectedPk.IsEqual(pk))
+	cachedPk, err := ParsePubKeyCached(pkBytes)
+	require.NoError(err)
+	require.Same(pk, cachedPk)
+
+	// Uncompressed keys are parsed but not cached.
+	uncompressedPk, err := ParsePubKeyCached(expectedPk.SerializeUncompressed())
+	require.NoError(err)
+	require.True(expectedPk.IsEqual(uncompressedPk))
+
+	// Invalid keys get the same error as btcec.ParsePubKey, every time.
+	invalidPkBytes := append([]byte{}, pkBytes...)
+	invalidPkBytes[0] = 0x05
+	_, expectedErr := btcec.ParsePubKey(invalidPkBytes, btcec.S256())
+	require.Error(expectedErr)
+	for ii := 0; ii < 2; ii++ {
+		_, err = ParsePubKeyCached(invalidPkBytes)
+		require.Equal(expectedErr, err)
+	}
+}
+
+func TestBatchSignatureVerification(t *testing.T) {
+	require := require.New(t)
+
+	EnableBatchSignatureVerification(true)
+	defer EnableBatchSignatureVerification(false)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+	block, err := miner.MineAndProcessSingleBlock(0, mempool)
+	require.NoError(err)
+
+	validTxn := _assembleBasicTransferTxnFullySigned(
+		t, chain, 10, 11, senderPkString, recipientPkString, senderPrivString, mempool)
+	invalidTxn := _assembleBasicTransferTxnFullySigned(
+		t, chain, 20, 11, senderPkString, recipientPkString, senderPrivString, mempool)
+	_signTxn(t, invalidTxn, m0Priv)
+
+	// Only the txn signed by its public key passes, and block rewards are left to ConnectBlock.
+	signatureChecks := VerifyTxnSignaturesInBatch([]*MsgDeSoTxn{block.Txns[0], validTxn, invalidTxn})
+	require.Len(signatureChecks, 2)
+	require.True(signatureChecks[validTxn].IsValid)
+	require.False(signatureChecks[invalidTxn].IsValid)
+
+	// The checks keep the hash the signature is over, so that it isn't computed again.
+	validTxnBytes, err := validTxn.ToBytes(true /*preSignature*/)
+	require.NoError(err)
+	require.Equal(Sha256DoubleHash(validTxnBytes), signatureChecks[validTxn].SignatureHash)
+
+	// A txn that fails the batch check gets the same error when it's connected.
+	utxoView, err := NewUtxoView(db, params, chain.postgres, chain.snapshot)
+	require.NoError(err)
+	utxoView.batchSignatureChecks = signatureChecks
+	blockHeight := chain.blockTip().Height + 1
+	_, err = utxoView._verifySignature(validTxn, blockHeight)
+	require.NoError(err)
+	_, err = utxoView._verifySignature(invalidTxn, blockHeight)
+	require.Equal(RuleErrorInvalidTransactionSignature, err)
+
+	// Blocks connect with batch verification enabled.
+	_, err = mempool.ProcessTransaction(validTxn, false, false, 0, true)
+	require.NoError(err)
+	block, err = miner.MineAndProcessSingleBlock(0, mempool)
+	require.NoError(err)
+	require.Len(block.Txns, 2)
+	require.Equal(uint64(blockHeight), chain.blockTip().Header.Height)
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/oleiade/lane"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/sync/semaphore"
 	"math"
 	"path/filepath"
@@ -51,6 +52,17 @@ var (
 	_prefixOperationChannelStatus = []byte{4}
 
 	_prefixMigrationStatus = []byte{5}
+
+	// This prefix saves the DatabaseCacheMetrics, so that cache statistics and the adaptive cache size persist
+	// across restarts.
+	// 	<prefix [1]byte> -> <DatabaseCacheMetrics>
+	_prefixDatabaseCacheMetrics = []byte{6}
+
+	// This prefix saves the state checksum after each block is processed, so that nodes can compare their
+	// state at a given height without exchanging a snapshot. If a block is reorged, the checksum for its
+	// height is overwritten when the new block at that height becomes the tip.
+	// 	<prefix [1]byte, blockHeight [8]byte> -> <checksum bytes [33]byte>
+	_prefixStateChecksumAtHeight = []byte{7}
 )
 
 // -------------------------------------------------------------------------------------
@@ -102,7 +114,18 @@ type Snapshot struct {
 	// DatabaseCache is used to store most recent DB records that we've read/written.
 	// This is a low-level optimization for ancestral records that
 	// saves us read time when we're writing to the DB during UtxoView flush.
-	DatabaseCache lru.KVCache
+	DatabaseCache *ResizableKVCache
+
+	// DatabaseCacheMetrics tracks the DatabaseCache hit rate and its current size. DatabaseCacheSizingPolicy
+	// is consulted every DatabaseCacheResizeBlockInterval blocks to grow or shrink the cache.
+	DatabaseCacheMetrics      *DatabaseCacheMetrics
+	DatabaseCacheSizingPolicy DatabaseCacheSizingPolicy
+
+	// NegativeLookupCache remembers state keys that were recently found missing in the db.
+	NegativeLookupCache *NegativeLookupCache
+
+	// flushSessionsByTxn maps a *badger.Txn to the *FlushSession staging the snapshot updates of its writes.
+	flushSessionsByTxn sync.Map
 
 	// AncestralFlushCounter is used to offset ancestral records flush to occur only after x blocks.
 	AncestralFlushCounter uint64
@@ -220,6 +243,12 @@ func NewSnapshot(mainDb *badger.DB, mainDbDirectory string, snapshotBlockHeightP
 		}
 	}
 
+	// Retrieve and initialize the database cache metrics.
+	cacheMetrics := &DatabaseCacheMetrics{}
+	if err := cacheMetrics.Initialize(snapshotDb, &snapshotDbMutex); err != nil {
+		return nil, errors.Wrapf(err, "NewSnapshot: Problem reading DatabaseCacheMetrics"), true
+	}
+
 	// Initialize the timer.
 	timer := &Timer{}
 	timer.Initialize()
@@ -228,7 +257,10 @@ func NewSnapshot(mainDb *badger.DB, mainDbDirectory string, snapshotBlockHeightP
 	snap := &Snapshot{
 		SnapshotDb:                   snapshotDb,
 		SnapshotDbMutex:              &snapshotDbMutex,
-		DatabaseCache:                lru.NewKVCache(DatabaseCacheSize),
+		DatabaseCache:                NewResizableKVCache(uint(cacheMetrics.CacheSize)),
+		DatabaseCacheMetrics:         cacheMetrics,
+		DatabaseCacheSizingPolicy:    NewAdaptiveDatabaseCacheSizingPolicy(),
+		NegativeLookupCache:          NewNegativeLookupCache(NegativeLookupCacheSize, NegativeLookupCacheTTL),
 		AncestralFlushCounter:        uint64(0),
 		SnapshotBlockHeightPeriod:    snapshotBlockHeightPeriod,
 		OperationChannel:             operationChannel,
@@ -237,6 +269,7 @@ func NewSnapshot(mainDb *badger.DB, mainDbDirectory string, snapshotBlockHeightP
 		CurrentEpochSnapshotMetadata: metadata,
 		AncestralMemory:              lane.NewDeque(),
 		Status:                       status,
+		mainDb:                       mainDb,
 		params:                       params,
 		isTxIndex:                    isTxIndex,
 		disableChecksum:              disableChecksum,
@@ -288,6 +321,20 @@ func (snap *Snapshot) Run() {
 				glog.Errorf("Snapshot.Run: Problem removing checksum bytes operation (%v)", operation)
 			}
 
+		case SnapshotOperationChecksumBatch:
+			for _, entry := range operation.checksumRemovedEntries {
+				if err := snap.Checksum.AddOrRemoveBytesWithMigrations(entry.Key, entry.Value,
+					snap.Status.CurrentBlockHeight, snap.Migrations.migrationChecksums, false); err != nil {
+					glog.Errorf("Snapshot.Run: Problem removing checksum bytes in batch operation (%v)", operation)
+				}
+			}
+			for _, entry := range operation.checksumAddedEntries {
+				if err := snap.Checksum.AddOrRemoveBytesWithMigrations(entry.Key, entry.Value,
+					snap.Status.CurrentBlockHeight, snap.Migrations.migrationChecksums, true); err != nil {
+					glog.Errorf("Snapshot.Run: Problem adding checksum bytes in batch operation (%v)", operation)
+				}
+			}
+
 		case SnapshotOperationChecksumPrint:
 			stateChecksum, err := snap.Checksum.ToBytes()
 			if err != nil {
@@ -326,104 +373,6 @@ func (snap *Snapshot) Stop() {
 	// It's important!!!
 }
 
-// ForceResetToLastSnapshot is a doomsday scenario recovery mode. It will be triggered if the node was shutdown midway,
-// resulting in a corrupted ancestral records or checksum. To recover from this situation, we will revert to the beginning
-// of the current snapshot epoch. We do this by disconnecting blocks from the tip to the epoch's start and resetting the checksum.
-func (snap *Snapshot) ForceResetToLastSnapshot(chain *Blockchain) error {
-	snap.stopped = true
-
-	// First we'll stop and reset the snapshot operation channel.
-	snap.OperationChannel.EnqueueOperation(&SnapshotOperation{
-		operationType: SnapshotOperationExit,
-	})
-	snap.WaitForAllOperationsToFinish()
-	snap.updateWaitGroup.Wait()
-	snap.OperationChannel.StateSemaphore = 0
-
-	// Now, we'll reset the snapshot db status semaphores.
-	snap.Status.MainDBSemaphore = 0
-	snap.Status.AncestralDBSemaphore = 0
-
-	// Now, disconnect the blocks to the beginning of the snapshot epoch, or equivalently, end of the last snapshot epoch.
-	lastEpochHeight := snap.CurrentEpochSnapshotMetadata.SnapshotBlockHeight
-	err := chain.DisconnectBlocksToHeight(lastEpochHeight)
-	if err != nil {
-		return errors.Wrapf(err, "ForceResetToLastSnapshot: Problem disconnecting blocks")
-	}
-
-	// Reset the state checksum to the one we got at the beginning of this epoch.
-	if len(snap.CurrentEpochSnapshotMetadata.CurrentEpochChecksumBytes) == 0 {
-		snap.Checksum.ResetChecksum()
-	} else {
-		err = snap.Checksum.FromBytes(snap.CurrentEpochSnapshotMetadata.CurrentEpochChecksumBytes)
-		if err != nil {
-			return errors.Wrapf(err, "ForceResetToLastSnapshot: problem resetting checksum bytes")
-		}
-	}
-	err = snap.Checksum.SaveChecksum()
-	if err != nil {
-		return errors.Wrapf(err, "ForceResetToLastSnapshot: Problem saving checksum")
-	}
-
-	// Similarly, we'll reset the encoder migration checksums.
-	snap.Migrations.ResetChecksums()
-	if err = snap.Migrations.SaveMigrations(); err != nil {
-		return errors.Wrapf(err, "ForceResetToLastSnapshot: Problem saving migrations")
-	}
-
-	// Save the operation channel status and the db status.
-	if err = snap.OperationChannel.SaveOperationChannel(); err != nil {
-		return errors.Errorf("ForceResetToLastSnapshot: Problem saving operation channel in database. Error: (%v)", err)
-	}
-	snap.Status.SaveStatus()
-
-	// Delete all ancestral records for the current snapshot epoch.
-	if err = snap.DeleteAncestralRecords(lastEpochHeight); err != nil {
-		return errors.Wrapf(err, "ForceResetToLastSnapshot: Problem deleting ancestral records at height (%v)", lastEpochHeight)
-	}
-
-	// Now we'll verify that the final state checksum matches the last snapshot checksum. We do it in a slightly hacky way
-	// where we create and start an empty migration. The StartMigration function will basically scan the entire node's
-	// db and compute the checksum. It's easier than having a separate function for this; although, we might have a
-	// dedicated function for this in the future for better code clarity.
-	glog.Infof(CLog(Yellow, "ForceResetToLastSnapshot: Finished node reset, will now proceed to verify state checksum."))
-	verificationMigration := EncoderMigration{}
-	verificationMigration.InitializeSingleHeight(chain.db, snap.SnapshotDb, snap.SnapshotDbMutex, lastEpochHeight, snap.params)
-	if err := verificationMigration.StartMigrations(); err != nil {
-		return errors.Wrapf(err, "ForceResetToLastSnapshot: Problem starting verification migration.")
-	}
-	if len(verificationMigration.migrationChecksums) != 1 {
-		return errors.Errorf("ForceResetToLastSnapshot: Number of migration checksums is invalid.")
-	}
-	verificationChecksum, err := verificationMigration.migrationChecksums[0].Checksum.ToBytes()
-	if err != nil {
-		return errors.Wrapf(err, "ForceResetToLastSnapshot: Problem getting verification migration.")
-	}
-	if len(snap.CurrentEpochSnapshotMetadata.CurrentEpochChecksumBytes) == 0 {
-		identitySum := &StateChecksum{}
-		identitySum.Initialize(nil, nil)
-		snap.CurrentEpochSnapshotMetadata.CurrentEpochChecksumBytes, err = identitySum.ToBytes()
-		if err != nil {
-			return errors.Wrapf(err, "ForceResetToLastSnapshot: Current epoch checksum was empty but failed to reset")
-		}
-	}
-	// Make sure the snapshot epoch checksum is equal to the checksum that we've computed during the StartMigrations,
-	// i.e. the checksum we got by scanning the entire db and manually recomputing the checksum from scratch.
-	// This check is very important, if it fails then it means that there is no way for us to recover and we should resync.
-	if !reflect.DeepEqual(snap.CurrentEpochSnapshotMetadata.CurrentEpochChecksumBytes, verificationChecksum) {
-
-		return errors.Errorf("ForceRestartToLastSnapshot: Snapshot epoch checksum: (%v), and verification "+
-			"checksum: (%v), are not equal. This means recovery failed. Unfortunatelly, we have to resync your node.",
-			snap.CurrentEpochSnapshotMetadata.CurrentEpochChecksumBytes, verificationChecksum)
-	}
-
-	if err := snap.SnapshotDb.Close(); err != nil {
-		return errors.Wrapf(err, "ForceResetToLastSnapshot: Problem closing snapshot db.")
-	}
-	glog.Infof(CLog(Yellow, "ForceResetToLastSnapshot: Finished rolling back blocks and recovering snapshot. Node should now be restarted."))
-	return nil
-}
-
 // StartAncestralRecordsFlush updates the ancestral records after a UtxoView flush. This function should be called in a
 // after all UtxoView flushes. shouldIncrement is usually set to true and indicates that we are supposed to update the
 // db semaphores. The semaphore are used to manage concurrency between the main and ancestral dbs.
@@ -476,6 +425,10 @@ func (snap *Snapshot) FinishProcessBlock(blockNode *BlockNode) {
 		snap.CurrentEpochSnapshotMetadata.CurrentEpochBlockHash = blockNode.Hash
 	}
 
+	if uint64(blockNode.Height)%DatabaseCacheResizeBlockInterval == 0 {
+		snap.AdjustDatabaseCacheSize()
+	}
+
 	snap.OperationChannel.EnqueueOperation(&SnapshotOperation{
 		operationType: SnapshotOperationProcessBlock,
 		blockNode:     blockNode,
@@ -509,6 +462,18 @@ func (snap *Snapshot) RemoveChecksumBytes(key []byte, value []byte) {
 	})
 }
 
+// UpdateChecksumBytesInBatch removes and adds the records to the state checksum with a single operation.
+func (snap *Snapshot) UpdateChecksumBytesInBatch(removedEntries []*DBEntry, addedEntries []*DBEntry) {
+	if len(removedEntries) == 0 && len(addedEntries) == 0 {
+		return
+	}
+	snap.OperationChannel.EnqueueOperation(&SnapshotOperation{
+		operationType:          SnapshotOperationChecksumBatch,
+		checksumRemovedEntries: removedEntries,
+		checksumAddedEntries:   addedEntries,
+	})
+}
+
 // WaitForAllOperationsToFinish will busy-wait for the snapshot channel to process all
 // current operations. Spinlocks are undesired but it's the easiest solution in this case,
 func (snap *Snapshot) WaitForAllOperationsToFinish() {
@@ -606,6 +571,32 @@ func (snap *Snapshot) PrepareAncestralRecord(key string, value []byte, existed b
 	return nil
 }
 
+// PrepareAncestralRecords is PrepareAncestralRecord for a batch of records, keyed by the hex of
+// their db keys. It looks up the last ancestral cache once for the whole batch.
+func (snap *Snapshot) PrepareAncestralRecords(records map[string]*AncestralRecordValue) error {
+	index := snap.AncestralFlushCounter
+
+	if snap.AncestralMemory.Empty() {
+		return fmt.Errorf("Snapshot.PrepareAncestralRecords: ancestral memory is empty. " +
+			"Did you forget to call Snapshot.PrepareAncestralRecordsFlush?")
+	}
+
+	latestAncestralCache := snap.AncestralMemory.Last().(*AncestralCache)
+	if latestAncestralCache.id != index {
+		return fmt.Errorf("Snapshot.PrepareAncestralRecords: last ancestral cache index (%v) is "+
+			"greater than current flush index (%v)", latestAncestralCache.id, index)
+	}
+
+	// As with single records, the first value prepared for a key in a flush is the one that's kept.
+	for key, record := range records {
+		if _, ok := latestAncestralCache.AncestralRecordsMap[key]; ok {
+			continue
+		}
+		latestAncestralCache.AncestralRecordsMap[key] = record
+	}
+	return nil
+}
+
 // FlushAncestralRecords updates the ancestral records after a UtxoView flush.
 // This function should be called in a go-routine after all UtxoView flushes.
 func (snap *Snapshot) FlushAncestralRecords() {
@@ -623,6 +614,12 @@ func (snap *Snapshot) FlushAncestralRecords() {
 		return
 	}
 
+	// If a test killed the write path, we act as if the node died before the ancestral records were written.
+	if err := _injectDBFault(DBFaultPointBeforeAncestralFlush); err != nil {
+		glog.Errorf("Snapshot.StartAncestralRecordsFlush: Skipping the flush: (%v)", err)
+		return
+	}
+
 	// Pull items off of the deque for writing. We say "last" as in oldest, i.e. the first element of AncestralMemory.
 	oldestAncestralCache := snap.AncestralMemory.First().(*AncestralCache)
 
@@ -648,6 +645,9 @@ func (snap *Snapshot) FlushAncestralRecords() {
 	glog.V(2).Infof("Snapshot.StartAncestralRecordsFlush: Finished sorting map keys")
 
 	// We launch a new read-write transaction to set the records.
+	_, dbSpan := StartDBSpan(context.Background(), "Snapshot.FlushAncestralRecords", _prefixAncestralRecord,
+		attribute.Int64("block.height", int64(blockHeight)),
+		attribute.Int("snapshot.ancestral_records", len(recordsKeyList)))
 	snap.SnapshotDbMutex.Lock()
 	err = snap.SnapshotDb.Update(func(txn *badger.Txn) error {
 		// This update is called after a change to the main db records and so the current checksum reflects the state of
@@ -703,6 +703,7 @@ func (snap *Snapshot) FlushAncestralRecords() {
 		return nil
 	})
 	snap.SnapshotDbMutex.Unlock()
+	dbSpan.End(err)
 	if err != nil {
 		// If any error occurred, then we should redo this memory write. During the restart, we will re-write all
 		// entries. If the error happened during a partial write, e.g. we didn't write all records in recordsKeyList,
@@ -722,9 +723,15 @@ func (snap *Snapshot) FlushAncestralRecords() {
 }
 
 // DeleteAncestralRecords is used to delete ancestral records for the provided height.
-func (snap *Snapshot) DeleteAncestralRecords(height uint64) error {
+func (snap *Snapshot) DeleteAncestralRecords(height uint64) (_err error) {
 	glog.V(2).Infof("Snapshot.DeleteAncestralRecords: Deleting snapshotDb for height (%v)", height)
 
+	_, dbSpan := StartDBSpan(context.Background(), "Snapshot.DeleteAncestralRecords", _prefixAncestralRecord,
+		attribute.Int64("block.height", int64(height)))
+	defer func() {
+		dbSpan.End(_err)
+	}()
+
 	snap.timer.Start("Snapshot.DeleteAncestralRecords")
 	var prefix []byte
 	prefix = append(prefix, _prefixAncestralRecord...)
@@ -751,6 +758,7 @@ func (snap *Snapshot) DeleteAncestralRecords(height uint64) error {
 	if err != nil {
 		return errors.Wrapf(err, "DeleteAncestralRecords: Problem iterating through the height")
 	}
+	dbSpan.SetAttributes(attribute.Int("db.deletes", len(keys)))
 	err = snap.SnapshotDb.Update(func(txn *badger.Txn) error {
 		for _, key := range keys {
 			err := txn.Delete(key)
@@ -861,6 +869,17 @@ func (snap *Snapshot) SnapshotProcessBlock(blockNode *BlockNode) {
 		}
 	}
 
+	// Record the state checksum at this height.
+	if !snap.disableChecksum {
+		checksumBytes, err := snap.Checksum.ToBytes()
+		if err != nil {
+			glog.Errorf("Snapshot.SnapshotProcessBlock: Problem getting checksum bytes: Error (%v)", err)
+		} else if err = snap.PutStateChecksumAtHeight(height, checksumBytes); err != nil {
+			glog.Errorf("Snapshot.SnapshotProcessBlock: Problem saving checksum at height (%v): Error (%v)",
+				height, err)
+		}
+	}
+
 	snap.CurrentEpochSnapshotMetadata.updateMutex.Lock()
 	defer snap.CurrentEpochSnapshotMetadata.updateMutex.Unlock()
 	if height == snap.CurrentEpochSnapshotMetadata.SnapshotBlockHeight {
@@ -905,6 +924,61 @@ func (snap *Snapshot) SnapshotProcessBlock(blockNode *BlockNode) {
 	}
 }
 
+func _getStateChecksumAtHeightKey(blockHeight uint64) []byte {
+	prefixCopy := append([]byte{}, _prefixStateChecksumAtHeight...)
+	return append(prefixCopy, EncodeUint64(blockHeight)...)
+}
+
+// PutStateChecksumAtHeight records the state checksum at the given block height in the snapshot db.
+func (snap *Snapshot) PutStateChecksumAtHeight(blockHeight uint64, checksumBytes []byte) error {
+	snap.SnapshotDbMutex.Lock()
+	defer snap.SnapshotDbMutex.Unlock()
+
+	return snap.SnapshotDb.Update(func(txn *badger.Txn) error {
+		return txn.Set(_getStateChecksumAtHeightKey(blockHeight), checksumBytes)
+	})
+}
+
+// GetStateChecksumAtHeight returns the state checksum that was recorded when the block at the given
+// height was processed, or nil if there isn't one. Checksums aren't recorded for blocks that were
+// synced with HyperSync, except for the block at the snapshot height.
+func (snap *Snapshot) GetStateChecksumAtHeight(blockHeight uint64) ([]byte, error) {
+	snap.SnapshotDbMutex.Lock()
+	defer snap.SnapshotDbMutex.Unlock()
+
+	var checksumBytes []byte
+	err := snap.SnapshotDb.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(_getStateChecksumAtHeightKey(blockHeight))
+		if err != nil {
+			return err
+		}
+		checksumBytes, err = item.ValueCopy(nil)
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetStateChecksumAtHeight: Problem reading checksum at height %v",
+			blockHeight)
+	}
+	return checksumBytes, nil
+}
+
+// CheckStateChecksumCheckpoint returns an error if params has a state checksum checkpoint at the given
+// block height and it doesn't match checksumBytes.
+func CheckStateChecksumCheckpoint(params *DeSoParams, blockHeight uint64, checksumBytes []byte) error {
+	checkpointBytes, exists := params.StateChecksumCheckpoints[blockHeight]
+	if !exists {
+		return nil
+	}
+	if !bytes.Equal(checkpointBytes, checksumBytes) {
+		return fmt.Errorf("CheckStateChecksumCheckpoint: Checksum (%v) at height (%v) doesn't match "+
+			"the checkpoint (%v)", checksumBytes, blockHeight, checkpointBytes)
+	}
+	return nil
+}
+
 // isState determines if a key is a state-related record.
 func (snap *Snapshot) isState(key []byte) bool {
 	if !snap.isTxIndex {
@@ -1049,6 +1123,8 @@ func (snap *Snapshot) SetSnapshotChunk(mainDb *badger.DB, mainDbMutex *deadlock.
 		//snap.timer.Start("SetSnapshotChunk.Set")
 		// TODO: Should we split the chunk into batches of 8MB so that we don't write too much data at once?
 		for _, dbEntry := range chunk {
+			// The write batch bypasses DBSetWithTxn so we invalidate the negative lookups ourselves.
+			snap.NegativeLookupCache.Invalidate(hex.EncodeToString(dbEntry.Key))
 			localErr := wb.Set(dbEntry.Key, dbEntry.Value) // Will create txns as needed.
 			if localErr != nil {
 				glog.Errorf("Snapshot.SetSnapshotChunk: Problem setting db entry in write batch")
@@ -1056,7 +1132,9 @@ func (snap *Snapshot) SetSnapshotChunk(mainDb *badger.DB, mainDbMutex *deadlock.
 				return
 			}
 		}
-		if localErr := wb.Flush(); localErr != nil {
+		localErr := wb.Flush()
+		snap.NegativeLookupCache.InvalidateAfterCommit(_lastCommitTs(mainDb))
+		if localErr != nil {
 			glog.Errorf("Snapshot.SetSnapshotChunk: Problem flushing write batch to db")
 			err = localErr
 			return
@@ -1694,6 +1772,9 @@ const (
 	SnapshotOperationChecksumAdd
 	// SnapshotOperationChecksumRemove operation is enqueued when we want to remove bytes to the state checksum.
 	SnapshotOperationChecksumRemove
+	// SnapshotOperationChecksumBatch operation is enqueued when we want to remove and add the records of a
+	// batched db write to the state checksum.
+	SnapshotOperationChecksumBatch
 	// SnapshotOperationChecksumPrint is called when we want to print the state checksum.
 	SnapshotOperationChecksumPrint
 	// SnapshotOperationExit is used to quit the snapshot loop
@@ -1723,6 +1804,12 @@ type SnapshotOperation struct {
 	checksumKey   []byte
 	checksumValue []byte
 
+	/* SnapshotOperationChecksumBatch */
+	// checksumRemovedEntries, checksumAddedEntries are the records we want to remove from and add to the
+	// state checksum, in that order.
+	checksumRemovedEntries []*DBEntry
+	checksumAddedEntries   []*DBEntry
+
 	/* SnapshotOperationChecksumPrint */
 	// printText is the text we want to put in the print statement.
 	printText string
@@ -2184,7 +2271,7 @@ func (migration *EncoderMigration) SaveMigrations() error {
 	})
 }
 
-func (migration *EncoderMigration) StartMigrations() error {
+func (migration *EncoderMigration) StartMigrations() (_err error) {
 
 	var outstandingChecksums []*EncoderMigrationChecksum
 
@@ -2202,6 +2289,12 @@ func (migration *EncoderMigration) StartMigrations() error {
 		return nil
 	}
 
+	_, dbSpan := StartDBSpan(context.Background(), "EncoderMigration.StartMigrations", nil,
+		attribute.Int("snapshot.outstanding_migrations", len(outstandingChecksums)))
+	defer func() {
+		dbSpan.End(_err)
+	}()
+
 	// If we get to this point, it means there are some new migrations that we need to process.
 	glog.Infof(CLog(Yellow, fmt.Sprintf("EncoderMigration: Found %v outstanding migrations. Proceeding to scan through the "+
 		"blockchain state. This is a one-time database update. It wouldn't be a good idea to terminate the node now. "+
@@ -2220,7 +2313,6 @@ func (migration *EncoderMigration) StartMigrations() error {
 	})
 
 	// Iterate through the whole db and re-calculate the checksum according to the outstanding migrations.
-	// TODO: Check if parallel gets are faster on fully synced node.
 	carrierChecksum := &StateChecksum{}
 	carrierChecksum.Initialize(nil, nil)
 
@@ -2262,27 +2354,19 @@ func (migration *EncoderMigration) StartMigrations() error {
 		}
 	}()
 
-	// Compute the checksums for all migrations, as needed.
-	err := migration.mainDb.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		for _, prefix := range prefixes {
-			startedPrefix = prefix
-			it := txn.NewIterator(opts)
-			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-				item := it.Item()
-				key := item.Key()
-				err := item.Value(func(value []byte) error {
-					return carrierChecksum.AddOrRemoveBytesWithMigrations(key, value, migration.currentBlockHeight,
-						outstandingChecksums, true)
-				})
-				if err != nil {
-					return err
-				}
-			}
-			it.Close()
+	// Compute the checksums for all migrations, as needed. The checksum is commutative so we can scan
+	// each prefix in parallel shards.
+	var err error
+	for _, prefix := range prefixes {
+		startedPrefix = prefix
+		err = ParallelScanPrefix(migration.mainDb, prefix, runtime.GOMAXPROCS(0), func(key []byte, value []byte) error {
+			return carrierChecksum.AddOrRemoveBytesWithMigrations(key, value, migration.currentBlockHeight,
+				outstandingChecksums, true)
+		})
+		if err != nil {
+			break
 		}
-		return nil
-	})
+	}
 	close(finishChannel)
 	if err != nil {
 		return errors.Wrapf(err, "EncoderMigration.StartMigrations: Something went wrong during "+
@@ -0,0 +1,666 @@
+package lib
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// -------------------------------------------------------------------------------------
+// DatabaseCacheMetrics
+// -------------------------------------------------------------------------------------
+
+// DatabaseCacheMetrics keeps track of how well the Snapshot's DatabaseCache is doing. Lookups are
+// counted in two ways: cumulatively, for the lifetime of the node, and in a window that's reset every
+// time the cache sizing policy is evaluated. The cumulative counters and the current cache size are
+// persisted in the snapshot db so that they survive restarts, and so that a node doesn't have to
+// re-learn the right cache size every time it boots.
+type DatabaseCacheMetrics struct {
+	// TotalHits and TotalMisses are the cumulative number of DatabaseCache lookups that did and
+	// didn't find a record, respectively.
+	TotalHits   uint64
+	TotalMisses uint64
+
+	// CacheSize is the current maximum number of records held by the DatabaseCache. It's only
+	// accessed on the main thread, other threads should call DatabaseCache.Limit instead.
+	CacheSize uint64
+
+	// NumResizes is the number of times the sizing policy has changed CacheSize. It's accessed
+	// atomically since it's reported from the statsd thread.
+	NumResizes uint64
+
+	// windowHits and windowMisses are the lookups since the last call to TakeWindowSample.
+	// All four counters are accessed atomically since lookups happen on many threads.
+	windowHits   uint64
+	windowMisses uint64
+
+	// prefixHits and prefixMisses break the lookups down by the prefix of the key. Unlike the totals,
+	// they aren't persisted, so they only cover the lookups since the node started.
+	prefixHits   [256]uint64
+	prefixMisses [256]uint64
+
+	snapshotDb      *badger.DB
+	snapshotDbMutex *sync.Mutex
+}
+
+func (metrics *DatabaseCacheMetrics) Initialize(snapshotDb *badger.DB, snapshotDbMutex *sync.Mutex) error {
+	metrics.CacheSize = uint64(DatabaseCacheSize)
+
+	metrics.snapshotDb = snapshotDb
+	metrics.snapshotDbMutex = snapshotDbMutex
+
+	if snapshotDb == nil || snapshotDbMutex == nil {
+		metrics.snapshotDbMutex = &sync.Mutex{}
+	} else if err := metrics.ReadMetrics(); err != nil {
+		return errors.Wrapf(err, "DatabaseCacheMetrics.Initialize: Can't read cache metrics from db")
+	}
+
+	// A size set with SetDatabaseCacheSizeLimits takes precedence over the persisted one, and the
+	// persisted size is kept within the current bounds, in case they were lowered since it was saved.
+	if databaseCacheSizeOverride > 0 {
+		metrics.CacheSize = uint64(databaseCacheSizeOverride)
+	}
+	if metrics.CacheSize > uint64(databaseCacheMaxSize) {
+		metrics.CacheSize = uint64(databaseCacheMaxSize)
+	}
+	if metrics.CacheSize < uint64(databaseCacheMinSize) {
+		metrics.CacheSize = uint64(databaseCacheMinSize)
+	}
+	return nil
+}
+
+// RecordLookup should be called on every DatabaseCache lookup. It's safe to call on a nil receiver.
+func (metrics *DatabaseCacheMetrics) RecordLookup(hit bool) {
+	if metrics == nil {
+		return
+	}
+	if hit {
+		atomic.AddUint64(&metrics.TotalHits, 1)
+		atomic.AddUint64(&metrics.windowHits, 1)
+	} else {
+		atomic.AddUint64(&metrics.TotalMisses, 1)
+		atomic.AddUint64(&metrics.windowMisses, 1)
+	}
+}
+
+// RecordLookupForKey is RecordLookup that also counts the lookup towards the key's prefix.
+func (metrics *DatabaseCacheMetrics) RecordLookupForKey(key []byte, hit bool) {
+	if metrics == nil {
+		return
+	}
+	metrics.RecordLookup(hit)
+	if len(key) == 0 {
+		return
+	}
+	if hit {
+		atomic.AddUint64(&metrics.prefixHits[key[0]], 1)
+	} else {
+		atomic.AddUint64(&metrics.prefixMisses[key[0]], 1)
+	}
+}
+
+// DatabaseCachePrefixStats are the DatabaseCache lookups of keys with one prefix.
+type DatabaseCachePrefixStats struct {
+	Prefix  byte
+	Name    string
+	Hits    uint64
+	Misses  uint64
+	HitRate float64
+}
+
+// GetPrefixStats returns the lookup counts of every prefix that was looked up since the node
+// started, ordered by prefix.
+func (metrics *DatabaseCacheMetrics) GetPrefixStats() []*DatabaseCachePrefixStats {
+	var prefixStats []*DatabaseCachePrefixStats
+	for prefix := 0; prefix < len(metrics.prefixHits); prefix++ {
+		hits := atomic.LoadUint64(&metrics.prefixHits[prefix])
+		misses := atomic.LoadUint64(&metrics.prefixMisses[prefix])
+		if hits+misses == 0 {
+			continue
+		}
+		prefixStats = append(prefixStats, &DatabaseCachePrefixStats{
+			Prefix:  byte(prefix),
+			Name:    dbPrefixName(byte(prefix)),
+			Hits:    hits,
+			Misses:  misses,
+			HitRate: _databaseCacheHitRate(hits, misses),
+		})
+	}
+	return prefixStats
+}
+
+// HitRate returns the cumulative fraction of lookups that were served from the cache.
+func (metrics *DatabaseCacheMetrics) HitRate() float64 {
+	return _databaseCacheHitRate(atomic.LoadUint64(&metrics.TotalHits), atomic.LoadUint64(&metrics.TotalMisses))
+}
+
+// TakeWindowSample returns the lookups since the previous sample and resets the window.
+func (metrics *DatabaseCacheMetrics) TakeWindowSample() *DatabaseCacheSample {
+	hits := atomic.SwapUint64(&metrics.windowHits, 0)
+	misses := atomic.SwapUint64(&metrics.windowMisses, 0)
+
+	memStats := &runtime.MemStats{}
+	runtime.ReadMemStats(memStats)
+
+	return &DatabaseCacheSample{
+		Hits:     hits,
+		Misses:   misses,
+		HitRate:  _databaseCacheHitRate(hits, misses),
+		MemStats: memStats,
+	}
+}
+
+func _databaseCacheHitRate(hits uint64, misses uint64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+func (metrics *DatabaseCacheMetrics) ToBytes() []byte {
+	var data []byte
+	data = append(data, UintToBuf(atomic.LoadUint64(&metrics.TotalHits))...)
+	data = append(data, UintToBuf(atomic.LoadUint64(&metrics.TotalMisses))...)
+	data = append(data, UintToBuf(metrics.CacheSize)...)
+	data = append(data, UintToBuf(atomic.LoadUint64(&metrics.NumResizes))...)
+	return data
+}
+
+func (metrics *DatabaseCacheMetrics) FromBytes(rr *bytes.Reader) error {
+	var err error
+	metrics.TotalHits, err = ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "DatabaseCacheMetrics: Problem reading TotalHits")
+	}
+
+	metrics.TotalMisses, err = ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "DatabaseCacheMetrics: Problem reading TotalMisses")
+	}
+
+	metrics.CacheSize, err = ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "DatabaseCacheMetrics: Problem reading CacheSize")
+	}
+
+	metrics.NumResizes, err = ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "DatabaseCacheMetrics: Problem reading NumResizes")
+	}
+	return nil
+}
+
+func (metrics *DatabaseCacheMetrics) SaveMetrics() error {
+	if metrics.snapshotDb == nil {
+		return nil
+	}
+	metrics.snapshotDbMutex.Lock()
+	defer metrics.snapshotDbMutex.Unlock()
+
+	return metrics.snapshotDb.Update(func(txn *badger.Txn) error {
+		return txn.Set(_prefixDatabaseCacheMetrics, metrics.ToBytes())
+	})
+}
+
+func (metrics *DatabaseCacheMetrics) ReadMetrics() error {
+	metrics.snapshotDbMutex.Lock()
+	defer metrics.snapshotDbMutex.Unlock()
+
+	err := metrics.snapshotDb.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(_prefixDatabaseCacheMetrics)
+		if err != nil {
+			return err
+		}
+		metricsBytes, err := item.ValueCopy(nil)
+		if err != nil {
+			return errors.Wrapf(err, "problem calling ValueCopy on the fetched item")
+		}
+		rr := bytes.NewReader(metricsBytes)
+		return metrics.FromBytes(rr)
+	})
+	if err != nil && err != badger.ErrKeyNotFound {
+		return errors.Wrapf(err, "DatabaseCacheMetrics.ReadMetrics: Problem reading metrics from db")
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------
+// DatabaseCache configuration
+// -------------------------------------------------------------------------------------
+
+// databaseCacheSizeOverride is the DatabaseCache size set with SetDatabaseCacheSizeLimits, or zero if
+// the size persisted in the snapshot db should be used. databaseCacheMinSize and databaseCacheMaxSize
+// bound the adaptive sizing, and default to DatabaseCacheMinSize and DatabaseCacheMaxSize.
+var (
+	databaseCacheSizeOverride uint
+	databaseCacheMinSize      = DatabaseCacheMinSize
+	databaseCacheMaxSize      = DatabaseCacheMaxSize
+)
+
+// SetDatabaseCacheSizeLimits overrides the initial DatabaseCache size and the most records the
+// adaptive sizing policy will let the cache hold. A zero leaves the corresponding setting at its
+// default. It has to be called before the Snapshot is created.
+func SetDatabaseCacheSizeLimits(size uint, maxSize uint) error {
+	if maxSize == 0 {
+		maxSize = DatabaseCacheMaxSize
+	}
+	if size > maxSize {
+		return fmt.Errorf("SetDatabaseCacheSizeLimits: Size (%v) is greater than the max size (%v)",
+			size, maxSize)
+	}
+	minSize := DatabaseCacheMinSize
+	if size > 0 && size < minSize {
+		minSize = size
+	}
+	if maxSize < minSize {
+		minSize = maxSize
+	}
+	databaseCacheSizeOverride = size
+	databaseCacheMinSize = minSize
+	databaseCacheMaxSize = maxSize
+	return nil
+}
+
+// databaseCacheDisabledPrefixes holds the map[byte]bool of the state prefixes whose records are
+// kept out of the DatabaseCache and the NegativeLookupCache.
+var databaseCacheDisabledPrefixes atomic.Value
+
+// SetDatabaseCacheDisabledPrefixes keeps the records of the given state prefixes out of the caches.
+// This is useful for prefixes with lots of churn and few repeated reads, whose records would
+// otherwise evict more useful ones. Passing nil enables caching for all prefixes.
+func SetDatabaseCacheDisabledPrefixes(prefixes [][]byte) {
+	disabledPrefixes := make(map[byte]bool)
+	for _, prefix := range prefixes {
+		if len(prefix) > 0 {
+			disabledPrefixes[prefix[0]] = true
+		}
+	}
+	databaseCacheDisabledPrefixes.Store(disabledPrefixes)
+}
+
+// IsDatabaseCacheEnabledForKey returns false if the key's prefix was disabled with
+// SetDatabaseCacheDisabledPrefixes.
+func IsDatabaseCacheEnabledForKey(key []byte) bool {
+	if len(key) == 0 {
+		return true
+	}
+	disabledPrefixes, ok := databaseCacheDisabledPrefixes.Load().(map[byte]bool)
+	return !ok || !disabledPrefixes[key[0]]
+}
+
+// -------------------------------------------------------------------------------------
+// DatabaseCacheSizingPolicy
+// -------------------------------------------------------------------------------------
+
+// DatabaseCacheSample is what a DatabaseCacheSizingPolicy gets to look at when deciding on the
+// next cache size. Hits, Misses, and HitRate cover the lookups since the previous sample.
+type DatabaseCacheSample struct {
+	Hits     uint64
+	Misses   uint64
+	HitRate  float64
+	MemStats *runtime.MemStats
+}
+
+// DatabaseCacheSizingPolicy decides how many records the DatabaseCache should hold. It's called
+// periodically by the Snapshot with the current size and a sample of recent cache activity, and
+// returns the size the cache should have going forward. Returning currentSize leaves the cache alone.
+type DatabaseCacheSizingPolicy interface {
+	NextCacheSize(currentSize uint64, sample *DatabaseCacheSample) uint64
+}
+
+// AdaptiveDatabaseCacheSizingPolicy is the default DatabaseCacheSizingPolicy. It grows the cache
+// when the hit rate is poor and there's memory to spare, and shrinks it whenever the runtime shows
+// signs of memory pressure, regardless of the hit rate.
+type AdaptiveDatabaseCacheSizingPolicy struct {
+	MinCacheSize uint64
+	MaxCacheSize uint64
+
+	// The cache is grown when the hit rate falls below LowHitRate. Samples with fewer than
+	// MinLookups lookups are ignored since their hit rate isn't meaningful.
+	LowHitRate float64
+	MinLookups uint64
+
+	// GrowFactor and ShrinkFactor are applied to the current size when resizing.
+	GrowFactor   float64
+	ShrinkFactor float64
+
+	// We consider the node to be under memory pressure if the GC is using more than
+	// MaxGCCPUFraction of the CPU, or if MemoryLimitBytes is set and the live heap is above
+	// HighMemoryWatermark of it. We only grow the cache if we're comfortably below both,
+	// i.e. below half of MaxGCCPUFraction and below LowMemoryWatermark of MemoryLimitBytes.
+	MaxGCCPUFraction    float64
+	MemoryLimitBytes    uint64
+	HighMemoryWatermark float64
+	LowMemoryWatermark  float64
+}
+
+func NewAdaptiveDatabaseCacheSizingPolicy() *AdaptiveDatabaseCacheSizingPolicy {
+	return &AdaptiveDatabaseCacheSizingPolicy{
+		MinCacheSize:        uint64(databaseCacheMinSize),
+		MaxCacheSize:        uint64(databaseCacheMaxSize),
+		LowHitRate:          0.5,
+		MinLookups:          10000,
+		GrowFactor:          1.5,
+		ShrinkFactor:        0.5,
+		MaxGCCPUFraction:    0.1,
+		MemoryLimitBytes:    0,
+		HighMemoryWatermark: 0.9,
+		LowMemoryWatermark:  0.7,
+	}
+}
+
+func (policy *AdaptiveDatabaseCacheSizingPolicy) NextCacheSize(currentSize uint64, sample *DatabaseCacheSample) uint64 {
+	var heapAlloc uint64
+	var gcCPUFraction float64
+	if sample.MemStats != nil {
+		heapAlloc = sample.MemStats.HeapAlloc
+		gcCPUFraction = sample.MemStats.GCCPUFraction
+	}
+
+	underPressure := gcCPUFraction > policy.MaxGCCPUFraction ||
+		(policy.MemoryLimitBytes > 0 &&
+			float64(heapAlloc) > policy.HighMemoryWatermark*float64(policy.MemoryLimitBytes))
+	if underPressure {
+		return policy._clamp(uint64(float64(currentSize) * policy.ShrinkFactor))
+	}
+
+	hasHeadroom := gcCPUFraction < policy.MaxGCCPUFraction/2 &&
+		(policy.MemoryLimitBytes == 0 ||
+			float64(heapAlloc) < policy.LowMemoryWatermark*float64(policy.MemoryLimitBytes))
+	if hasHeadroom && sample.Hits+sample.Misses >= policy.MinLookups && sample.HitRate < policy.LowHitRate {
+		return policy._clamp(uint64(float64(currentSize) * policy.GrowFactor))
+	}
+
+	return policy._clamp(currentSize)
+}
+
+func (policy *AdaptiveDatabaseCacheSizingPolicy) _clamp(size uint64) uint64 {
+	if size < policy.MinCacheSize {
+		return policy.MinCacheSize
+	}
+	if size > policy.MaxCacheSize {
+		return policy.MaxCacheSize
+	}
+	return size
+}
+
+// SetDatabaseCacheSizingPolicy replaces the policy used to resize the DatabaseCache. Passing nil
+// disables adaptive sizing and leaves the cache at its current size.
+func (snap *Snapshot) SetDatabaseCacheSizingPolicy(policy DatabaseCacheSizingPolicy) {
+	snap.DatabaseCacheSizingPolicy = policy
+}
+
+// ResetDatabaseCache drops all records from the DatabaseCache and the NegativeLookupCache, keeping
+// their current sizes. It's called after records were written to badger directly, so the
+// NegativeLookupCache also stops taking keys from txns that started before the reset.
+func (snap *Snapshot) ResetDatabaseCache() {
+	snap.DatabaseCache.Reset()
+	snap.NegativeLookupCache.Reset()
+	snap.InvalidateNegativeLookupsAfterCommit()
+}
+
+// AdjustDatabaseCacheSize samples the DatabaseCache metrics and lets the sizing policy pick a new
+// size for the cache. The cache is resized in place, so it keeps its most recently used records.
+// This is called from the main thread in between blocks.
+func (snap *Snapshot) AdjustDatabaseCacheSize() {
+	sample := snap.DatabaseCacheMetrics.TakeWindowSample()
+	if snap.DatabaseCacheSizingPolicy != nil {
+		currentSize := snap.DatabaseCacheMetrics.CacheSize
+		nextSize := snap.DatabaseCacheSizingPolicy.NextCacheSize(currentSize, sample)
+		if nextSize > 0 && nextSize != currentSize {
+			glog.V(1).Infof("Snapshot.AdjustDatabaseCacheSize: Resizing DatabaseCache from (%v) to (%v) "+
+				"records, window hit rate (%.3f), heap alloc (%v) bytes", currentSize, nextSize,
+				sample.HitRate, sample.MemStats.HeapAlloc)
+			snap.DatabaseCacheMetrics.CacheSize = nextSize
+			atomic.AddUint64(&snap.DatabaseCacheMetrics.NumResizes, 1)
+			snap.DatabaseCache.Resize(uint(nextSize))
+		}
+	}
+	if err := snap.DatabaseCacheMetrics.SaveMetrics(); err != nil {
+		glog.Errorf("Snapshot.AdjustDatabaseCacheSize: Problem saving cache metrics: %v", err)
+	}
+}
+
+// -------------------------------------------------------------------------------------
+// ResizableKVCache
+// -------------------------------------------------------------------------------------
+
+// ResizableKVCache is a concurrency-safe LRU key/value cache that, unlike lru.KVCache, can be
+// resized and reset in place. The DatabaseCache and the NegativeLookupCache are used from many
+// threads at once, so they can't be swapped out for a new cache while the node is running.
+type ResizableKVCache struct {
+	mtx      sync.Mutex
+	limit    uint
+	entries  map[interface{}]*list.Element
+	lruOrder *list.List
+}
+
+type resizableKVCacheEntry struct {
+	key   interface{}
+	value interface{}
+}
+
+func NewResizableKVCache(limit uint) *ResizableKVCache {
+	return &ResizableKVCache{
+		limit:    limit,
+		entries:  make(map[interface{}]*list.Element),
+		lruOrder: list.New(),
+	}
+}
+
+// Lookup returns the value for the key, and marks the key as the most recently used one.
+func (cache *ResizableKVCache) Lookup(key interface{}) (interface{}, bool) {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+
+	element, exists := cache.entries[key]
+	if !exists {
+		return nil, false
+	}
+	cache.lruOrder.MoveToFront(element)
+	return element.Value.(*resizableKVCacheEntry).value, true
+}
+
+// Add sets the value for the key, evicting the least recently used key if the cache is full.
+func (cache *ResizableKVCache) Add(key interface{}, value interface{}) {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+
+	if cache.limit == 0 {
+		return
+	}
+	if element, exists := cache.entries[key]; exists {
+		element.Value.(*resizableKVCacheEntry).value = value
+		cache.lruOrder.MoveToFront(element)
+		return
+	}
+	cache.entries[key] = cache.lruOrder.PushFront(&resizableKVCacheEntry{key: key, value: value})
+	cache._evictToLimit()
+}
+
+func (cache *ResizableKVCache) Delete(key interface{}) {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+
+	if element, exists := cache.entries[key]; exists {
+		cache.lruOrder.Remove(element)
+		delete(cache.entries, key)
+	}
+}
+
+func (cache *ResizableKVCache) Len() int {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+
+	return len(cache.entries)
+}
+
+// Limit returns the maximum number of entries. Resize can change it from another thread.
+func (cache *ResizableKVCache) Limit() uint {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+
+	return cache.limit
+}
+
+// Resize changes the maximum number of entries. Shrinking the cache evicts the least recently used
+// entries, and growing it keeps all of them.
+func (cache *ResizableKVCache) Resize(limit uint) {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+
+	cache.limit = limit
+	cache._evictToLimit()
+}
+
+// Reset removes all entries, keeping the current limit.
+func (cache *ResizableKVCache) Reset() {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+
+	cache.entries = make(map[interface{}]*list.Element)
+	cache.lruOrder.Init()
+}
+
+func (cache *ResizableKVCache) _evictToLimit() {
+	for uint(len(cache.entries)) > cache.limit {
+		oldest := cache.lruOrder.Back()
+		cache.lruOrder.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*resizableKVCacheEntry).key)
+	}
+}
+
+// -------------------------------------------------------------------------------------
+// NegativeLookupCache
+// -------------------------------------------------------------------------------------
+
+// NegativeLookupCache remembers state keys that were recently looked up and found missing, so that
+// repeated lookups of keys that don't exist, e.g. PKID mappings for fresh public keys or balance
+// entries for non-holders, don't all hit badger. It complements the DatabaseCache, which only holds
+// records that exist.
+//
+// A write drops the key from the cache before its txn commits, so a reader could find the key
+// missing in between and add it back. To prevent that, the cache stops taking new keys from the
+// first write until InvalidateAfterCommit is called once the txn has committed. The readers whose
+// txn started before the commit could still find the key missing afterwards, so the cache also
+// remembers the commit timestamp and only takes keys from txns that read at or after it. This
+// assumes that state writes are serialized, which they are under the ChainLock. Entries also
+// expire after a short TTL.
+type NegativeLookupCache struct {
+	// The cache maps hex-encoded keys to the time.Time at which the entry expires.
+	cache *ResizableKVCache
+	ttl   time.Duration
+
+	// mtx protects the fields below, and makes checking them and adding a key atomic.
+	mtx sync.Mutex
+	// hasUncommittedWrites is set by Invalidate and cleared by InvalidateAfterCommit.
+	hasUncommittedWrites bool
+	// minReadTs is the badger read timestamp a txn needs for the keys it found missing to be added.
+	minReadTs uint64
+
+	// NumHits is the number of lookups answered by the cache.
+	NumHits uint64
+}
+
+func NewNegativeLookupCache(size uint, ttl time.Duration) *NegativeLookupCache {
+	return &NegativeLookupCache{
+		cache: NewResizableKVCache(size),
+		ttl:   ttl,
+	}
+}
+
+// IsKnownMissing returns true if the key was recently found missing and hasn't been written since.
+func (negativeCache *NegativeLookupCache) IsKnownMissing(keyString string) bool {
+	if negativeCache == nil {
+		return false
+	}
+	expiry, exists := negativeCache.cache.Lookup(keyString)
+	if !exists {
+		return false
+	}
+	if time.Now().After(expiry.(time.Time)) {
+		negativeCache.cache.Delete(keyString)
+		return false
+	}
+	atomic.AddUint64(&negativeCache.NumHits, 1)
+	return true
+}
+
+// AddMissing records that the key doesn't exist in the db, as read by a badger txn with the given
+// read timestamp. The key isn't added if a write might not have been visible to the txn.
+func (negativeCache *NegativeLookupCache) AddMissing(keyString string, readTs uint64) {
+	if negativeCache == nil {
+		return
+	}
+	negativeCache.mtx.Lock()
+	defer negativeCache.mtx.Unlock()
+	if negativeCache.hasUncommittedWrites || readTs < negativeCache.minReadTs {
+		return
+	}
+	negativeCache.cache.Add(keyString, time.Now().Add(negativeCache.ttl))
+}
+
+// Invalidate should be called whenever the key is written, before the txn commits.
+func (negativeCache *NegativeLookupCache) Invalidate(keyString string) {
+	if negativeCache == nil {
+		return
+	}
+	negativeCache.mtx.Lock()
+	defer negativeCache.mtx.Unlock()
+	negativeCache.hasUncommittedWrites = true
+	negativeCache.cache.Delete(keyString)
+}
+
+// InvalidateAfterCommit should be called once the txns of the keys passed to Invalidate have been
+// committed or discarded. commitTs must be at or after the commit timestamps of these txns.
+func (negativeCache *NegativeLookupCache) InvalidateAfterCommit(commitTs uint64) {
+	if negativeCache == nil {
+		return
+	}
+	negativeCache.mtx.Lock()
+	defer negativeCache.mtx.Unlock()
+	negativeCache.hasUncommittedWrites = false
+	if commitTs > negativeCache.minReadTs {
+		negativeCache.minReadTs = commitTs
+	}
+}
+
+func (negativeCache *NegativeLookupCache) Reset() {
+	if negativeCache == nil {
+		return
+	}
+	negativeCache.mtx.Lock()
+	defer negativeCache.mtx.Unlock()
+	negativeCache.cache.Reset()
+}
+
+// InvalidateNegativeLookupsAfterCommit calls InvalidateAfterCommit on the NegativeLookupCache with
+// the timestamp of the last commit to the main db. It must be called after the state writes have
+// been committed. Snapshots without a main db, which are only built in tests, reset the cache instead.
+func (snap *Snapshot) InvalidateNegativeLookupsAfterCommit() {
+	if snap == nil {
+		return
+	}
+	if snap.mainDb == nil {
+		snap.NegativeLookupCache.Reset()
+		snap.NegativeLookupCache.InvalidateAfterCommit(0)
+		return
+	}
+	snap.NegativeLookupCache.InvalidateAfterCommit(_lastCommitTs(snap.mainDb))
+}
+
+// _lastCommitTs returns a timestamp at or after the commit timestamps of all the txns committed to
+// the db so far, which is the read timestamp a new txn gets.
+func _lastCommitTs(db *badger.DB) uint64 {
+	txn := db.NewTransaction(false)
+	defer txn.Discard()
+	return txn.ReadTs()
+}
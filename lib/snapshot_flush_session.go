@@ -0,0 +1,188 @@
+package lib
+
+import (
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+)
+
+// FlushSession keeps the snapshot consistent with the main db when a flush fails part of the way
+// through. Without a session, every DBSetWithTxn and DBDeleteWithTxn updates the ancestral records,
+// the state checksum and the DatabaseCache as soon as the record is written to the badger txn, so if
+// a later write fails and the txn is discarded, the snapshot reflects records that were never
+// committed. With a session, these updates are staged in memory while the txn is tracked, and are
+// only applied once the txn commits. If the txn fails, they're dropped.
+//
+// A session wraps one badger txn, which can contain any number of UtxoView flushes:
+//
+//	session := snap.NewFlushSession()
+//	err := db.Update(func(txn *badger.Txn) error {
+//		session.TrackTxn(txn)
+//		defer session.EndTrack()
+//		return view.FlushToDbWithTxn(txn, blockHeight)
+//	})
+//	session.Finish(err)
+//
+// All the methods are safe to call on a nil session, which is what callers get without a snapshot.
+type FlushSession struct {
+	snap *Snapshot
+	txn  *badger.Txn
+
+	// isTracking is set once TrackTxn has prepared the ancestral records flush. It's used to make
+	// sure that the flush is started exactly once, either by a commit or by a rollback.
+	isTracking bool
+	isFinished bool
+
+	// ancestralRecords are the values the state records had before the txn, keyed by the hex of
+	// their db keys. As with the ancestral cache, the first value staged for a key is the one kept.
+	ancestralRecords map[string]*AncestralRecordValue
+
+	// checksumRemovedEntries and checksumAddedEntries are the records to remove from and add to
+	// the state checksum once the txn commits.
+	checksumRemovedEntries []*DBEntry
+	checksumAddedEntries   []*DBEntry
+
+	// cachedRecords are the records to add to the DatabaseCache once the txn commits. A write
+	// drops the key from the cache right away, so that reads in the txn don't see a stale value.
+	cachedRecords map[string][]byte
+}
+
+// NewFlushSession returns nil if the snapshot is nil.
+func (snap *Snapshot) NewFlushSession() *FlushSession {
+	if snap == nil {
+		return nil
+	}
+	return &FlushSession{
+		snap:             snap,
+		ancestralRecords: make(map[string]*AncestralRecordValue),
+		cachedRecords:    make(map[string][]byte),
+	}
+}
+
+// flushSessionForTxn returns the FlushSession tracking the txn, or nil if there's none.
+func (snap *Snapshot) flushSessionForTxn(txn *badger.Txn) *FlushSession {
+	if snap == nil || txn == nil {
+		return nil
+	}
+	session, exists := snap.flushSessionsByTxn.Load(txn)
+	if !exists {
+		return nil
+	}
+	return session.(*FlushSession)
+}
+
+// TrackTxn prepares the ancestral records flush and makes the db write wrappers stage their
+// snapshot updates in the session until EndTrack is called. A session can only track one txn.
+func (session *FlushSession) TrackTxn(txn *badger.Txn) {
+	if session == nil || txn == nil || session.txn != nil || session.isFinished {
+		return
+	}
+	session.snap.PrepareAncestralRecordsFlush()
+	session.isTracking = true
+	session.txn = txn
+	session.snap.flushSessionsByTxn.Store(txn, session)
+}
+
+func (session *FlushSession) EndTrack() {
+	if session == nil || session.txn == nil {
+		return
+	}
+	session.snap.flushSessionsByTxn.Delete(session.txn)
+	session.txn = nil
+}
+
+// Finish commits the session if the txn was committed, i.e. if txnErr is nil, and rolls it back
+// otherwise. It must be called after the txn has been committed or discarded.
+func (session *FlushSession) Finish(txnErr error) {
+	if session == nil {
+		return
+	}
+	if txnErr != nil {
+		session.Rollback()
+		return
+	}
+	session.Commit()
+}
+
+// Commit applies the staged snapshot updates and starts the ancestral records flush.
+func (session *FlushSession) Commit() {
+	if !session._finish() {
+		return
+	}
+	snap := session.snap
+	if len(session.ancestralRecords) > 0 {
+		// This can only fail if the ancestral cache prepared by TrackTxn is gone, which means that
+		// the snapshot was reset while we were flushing. There's nothing to record the records in
+		// then, so we just log the error.
+		if err := snap.PrepareAncestralRecords(session.ancestralRecords); err != nil {
+			glog.Errorf("FlushSession.Commit: Problem preparing ancestral records: %v", err)
+		}
+	}
+	for keyString, value := range session.cachedRecords {
+		snap.DatabaseCache.Add(keyString, value)
+	}
+	if !snap.disableChecksum {
+		snap.UpdateChecksumBytesInBatch(session.checksumRemovedEntries, session.checksumAddedEntries)
+	}
+	// The staged writes dropped their keys from the NegativeLookupCache, which doesn't take keys
+	// again until we tell it that the txn committed.
+	snap.InvalidateNegativeLookupsAfterCommit()
+	snap.StartAncestralRecordsFlush(true)
+}
+
+// Rollback drops the staged snapshot updates. The ancestral records flush is still started, with
+// no records, so that the snapshot doesn't think the node died in the middle of a flush.
+func (session *FlushSession) Rollback() {
+	if !session._finish() {
+		return
+	}
+	session.snap.InvalidateNegativeLookupsAfterCommit()
+	session.snap.StartAncestralRecordsFlush(true)
+}
+
+// _finish marks the session as finished, and returns true if the staged updates should be
+// applied or dropped. If a test injected a fault, we act as if the node died and never get to
+// the ancestral flush, like UtxoView.FlushToDbWithTxn does without a session.
+func (session *FlushSession) _finish() bool {
+	if session == nil || session.isFinished {
+		return false
+	}
+	session.EndTrack()
+	session.isFinished = true
+	if !session.isTracking || _isDBFaultInjected() {
+		return false
+	}
+	return true
+}
+
+// _stageSet stages the snapshot updates of writing the value of a state record. existed is
+// false if the record didn't exist before the write.
+func (session *FlushSession) _stageSet(key []byte, keyString string, value []byte,
+	ancestralValue []byte, existed bool) {
+
+	session._stageAncestralRecord(keyString, &AncestralRecordValue{Value: ancestralValue, Existed: existed})
+	session.snap.DatabaseCache.Delete(keyString)
+	session.snap.NegativeLookupCache.Invalidate(keyString)
+	if IsDatabaseCacheEnabledForKey(key) {
+		session.cachedRecords[keyString] = value
+	}
+	if existed {
+		session.checksumRemovedEntries = append(session.checksumRemovedEntries, &DBEntry{Key: key, Value: ancestralValue})
+	}
+	session.checksumAddedEntries = append(session.checksumAddedEntries, &DBEntry{Key: key, Value: value})
+}
+
+// _stageDelete stages the snapshot updates of deleting a state record that existed.
+func (session *FlushSession) _stageDelete(key []byte, keyString string, ancestralValue []byte) {
+	session._stageAncestralRecord(keyString, &AncestralRecordValue{Value: ancestralValue, Existed: true})
+	session.snap.DatabaseCache.Delete(keyString)
+	session.snap.NegativeLookupCache.Invalidate(keyString)
+	delete(session.cachedRecords, keyString)
+	session.checksumRemovedEntries = append(session.checksumRemovedEntries, &DBEntry{Key: key, Value: ancestralValue})
+}
+
+func (session *FlushSession) _stageAncestralRecord(keyString string, record *AncestralRecordValue) {
+	if _, exists := session.ancestralRecords[keyString]; exists {
+		return
+	}
+	session.ancestralRecords[keyString] = record
+}
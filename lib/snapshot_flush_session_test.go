@@ -0,0 +1,116 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlushSession(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	params := DeSoTestnetParams
+	snap, err, _ := NewSnapshot(db, dir, SnapshotBlockHeightPeriod, false, false, &params, true)
+	require.NoError(err)
+	defer snap.Stop()
+
+	getChecksum := func() []byte {
+		snap.WaitForAllOperationsToFinish()
+		checksumBytes, err := snap.Checksum.ToBytes()
+		require.NoError(err)
+		return checksumBytes
+	}
+	balanceKey := _dbKeyForPublicKeyToDeSoBalanceNanos(m0PkBytes)
+	otherBalanceKey := _dbKeyForPublicKeyToDeSoBalanceNanos(m1PkBytes)
+	setBalancesInSession := func(txnErr error) error {
+		session := snap.NewFlushSession()
+		err := db.Update(func(txn *badger.Txn) error {
+			session.TrackTxn(txn)
+			defer session.EndTrack()
+			if err := DBSetWithTxn(txn, snap, balanceKey, EncodeUint64(100)); err != nil {
+				return err
+			}
+			// Reads in the txn see the staged write.
+			value, err := DBGetWithTxn(txn, snap, balanceKey)
+			require.NoError(err)
+			require.Equal(EncodeUint64(100), value)
+			if err := DBBatchSetWithTxn(txn, snap, []*DBEntry{{Key: otherBalanceKey, Value: EncodeUint64(200)}}); err != nil {
+				return err
+			}
+			return txnErr
+		})
+		session.Finish(err)
+		return err
+	}
+	initialChecksum := getChecksum()
+
+	// A txn that fails after some records were written leaves the snapshot untouched.
+	require.Error(setBalancesInSession(fmt.Errorf("the flush failed")))
+	require.Equal(initialChecksum, getChecksum())
+	require.False(snap.Status.IsFlushing())
+	for _, key := range [][]byte{balanceKey, otherBalanceKey} {
+		_, exists := snap.DatabaseCache.Lookup(hex.EncodeToString(key))
+		require.False(exists)
+		require.NoError(db.View(func(txn *badger.Txn) error {
+			_, err := DBGetWithTxn(txn, snap, key)
+			require.Equal(badger.ErrKeyNotFound, err)
+			return nil
+		}))
+	}
+
+	// Once the txn commits, the snapshot is updated.
+	require.NoError(setBalancesInSession(nil))
+	committedChecksum := getChecksum()
+	require.NotEqual(initialChecksum, committedChecksum)
+	require.False(snap.Status.IsFlushing())
+	value, exists := snap.DatabaseCache.Lookup(hex.EncodeToString(balanceKey))
+	require.True(exists)
+	require.Equal(EncodeUint64(100), value)
+
+	// The checksum is the same as if the records had been written without a session.
+	otherDb, otherDir := GetTestBadgerDb()
+	defer os.RemoveAll(otherDir)
+	otherSnap, err, _ := NewSnapshot(otherDb, otherDir, SnapshotBlockHeightPeriod, false, false, &params, true)
+	require.NoError(err)
+	defer otherSnap.Stop()
+	otherSnap.PrepareAncestralRecordsFlush()
+	require.NoError(otherDb.Update(func(txn *badger.Txn) error {
+		if err := DBSetWithTxn(txn, otherSnap, balanceKey, EncodeUint64(100)); err != nil {
+			return err
+		}
+		return DBSetWithTxn(txn, otherSnap, otherBalanceKey, EncodeUint64(200))
+	}))
+	otherSnap.StartAncestralRecordsFlush(true)
+	otherSnap.WaitForAllOperationsToFinish()
+	otherChecksum, err := otherSnap.Checksum.ToBytes()
+	require.NoError(err)
+	require.Equal(otherChecksum, committedChecksum)
+
+	// A UtxoView flush that fails is rolled back the same way.
+	view, err := NewUtxoView(db, &params, nil, snap)
+	require.NoError(err)
+	view.PublicKeyToDeSoBalanceNanos[*NewPublicKey(m0PkBytes)] = 300
+	SetDBMaxValueSizes(map[byte]int{Prefixes.PrefixPublicKeyToDeSoBalanceNanos[0]: 1})
+	err = view.FlushToDb(0)
+	SetDBMaxValueSizes(nil)
+	require.True(IsErrDBValueTooLarge(err))
+	require.Equal(committedChecksum, getChecksum())
+	require.False(snap.Status.IsFlushing())
+	balance, err := DbGetDeSoBalanceNanosForPublicKey(db, snap, m0PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(100), balance)
+
+	// A nil session does nothing.
+	var nilSession *FlushSession
+	nilSession.TrackTxn(nil)
+	nilSession.EndTrack()
+	nilSession.Finish(nil)
+}
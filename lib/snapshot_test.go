@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -15,6 +17,7 @@ import (
 	"golang.org/x/sync/semaphore"
 	"math"
 	"math/rand"
+	"os"
 	"reflect"
 	"runtime"
 	"sort"
@@ -733,3 +736,392 @@ func TestStateChecksumBirthdayParadox(t *testing.T) {
 	}
 	fmt.Println(totalElappsed)
 }
+
+func TestDatabaseCacheMetricsPersistence(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	var dbMutex sync.Mutex
+
+	metrics := &DatabaseCacheMetrics{}
+	require.NoError(metrics.Initialize(db, &dbMutex))
+	require.Equal(uint64(DatabaseCacheSize), metrics.CacheSize)
+
+	for ii := 0; ii < 3; ii++ {
+		metrics.RecordLookup(true)
+	}
+	metrics.RecordLookup(false)
+	require.Equal(0.75, metrics.HitRate())
+
+	sample := metrics.TakeWindowSample()
+	require.Equal(uint64(3), sample.Hits)
+	require.Equal(uint64(1), sample.Misses)
+	require.Equal(0.75, sample.HitRate)
+	require.NotNil(sample.MemStats)
+	// Taking a sample resets the window but not the totals.
+	sample = metrics.TakeWindowSample()
+	require.Equal(uint64(0), sample.Hits+sample.Misses)
+	require.Equal(0.75, metrics.HitRate())
+
+	metrics.CacheSize = 12345
+	metrics.NumResizes = 2
+	require.NoError(metrics.SaveMetrics())
+
+	readMetrics := &DatabaseCacheMetrics{}
+	require.NoError(readMetrics.Initialize(db, &dbMutex))
+	require.Equal(uint64(3), readMetrics.TotalHits)
+	require.Equal(uint64(1), readMetrics.TotalMisses)
+	require.Equal(uint64(12345), readMetrics.CacheSize)
+	require.Equal(uint64(2), readMetrics.NumResizes)
+
+	// A nil receiver should be a no-op.
+	var nilMetrics *DatabaseCacheMetrics
+	nilMetrics.RecordLookup(true)
+}
+
+func TestAdaptiveDatabaseCacheSizingPolicy(t *testing.T) {
+	require := require.New(t)
+
+	policy := NewAdaptiveDatabaseCacheSizingPolicy()
+	policy.MinCacheSize = 100
+	policy.MaxCacheSize = 1000
+	policy.MinLookups = 10
+	policy.MemoryLimitBytes = 1000
+
+	sample := func(hits uint64, misses uint64, heapAlloc uint64, gcCPUFraction float64) *DatabaseCacheSample {
+		return &DatabaseCacheSample{
+			Hits:    hits,
+			Misses:  misses,
+			HitRate: _databaseCacheHitRate(hits, misses),
+			MemStats: &runtime.MemStats{
+				HeapAlloc:     heapAlloc,
+				GCCPUFraction: gcCPUFraction,
+			},
+		}
+	}
+
+	// Low hit rate with headroom grows the cache.
+	require.Equal(uint64(300), policy.NextCacheSize(200, sample(2, 8, 100, 0)))
+	// Growth is capped at the max size.
+	require.Equal(uint64(1000), policy.NextCacheSize(900, sample(2, 8, 100, 0)))
+	// A good hit rate leaves the cache alone.
+	require.Equal(uint64(200), policy.NextCacheSize(200, sample(9, 1, 100, 0)))
+	// Too few lookups to judge the hit rate.
+	require.Equal(uint64(200), policy.NextCacheSize(200, sample(1, 3, 100, 0)))
+	// Low hit rate but not enough headroom to grow.
+	require.Equal(uint64(200), policy.NextCacheSize(200, sample(2, 8, 800, 0)))
+	require.Equal(uint64(200), policy.NextCacheSize(200, sample(2, 8, 100, 0.07)))
+	// Memory pressure shrinks the cache no matter the hit rate, down to the min size.
+	require.Equal(uint64(400), policy.NextCacheSize(800, sample(2, 8, 950, 0)))
+	require.Equal(uint64(400), policy.NextCacheSize(800, sample(9, 1, 100, 0.2)))
+	require.Equal(uint64(100), policy.NextCacheSize(150, sample(9, 1, 950, 0)))
+}
+
+func TestResizableKVCache(t *testing.T) {
+	require := require.New(t)
+
+	cache := NewResizableKVCache(3)
+	for ii := 0; ii < 3; ii++ {
+		cache.Add(ii, ii)
+	}
+	// Looking up 0 makes 1 the least recently used key, so it's evicted first.
+	_, exists := cache.Lookup(0)
+	require.True(exists)
+	cache.Add(3, 3)
+	_, exists = cache.Lookup(1)
+	require.False(exists)
+
+	// Growing the cache keeps every entry.
+	cache.Resize(5)
+	require.Equal(uint(5), cache.Limit())
+	require.Equal(3, cache.Len())
+	for _, key := range []int{0, 2, 3} {
+		value, exists := cache.Lookup(key)
+		require.True(exists)
+		require.Equal(key, value)
+	}
+
+	// Shrinking the cache keeps the most recently used entries.
+	cache.Resize(2)
+	require.Equal(2, cache.Len())
+	_, exists = cache.Lookup(0)
+	require.False(exists)
+	_, exists = cache.Lookup(2)
+	require.True(exists)
+	_, exists = cache.Lookup(3)
+	require.True(exists)
+
+	cache.Reset()
+	require.Equal(0, cache.Len())
+
+	// Resizing while other threads use the cache is safe.
+	var wg sync.WaitGroup
+	for ii := 0; ii < 4; ii++ {
+		wg.Add(1)
+		go func(thread int) {
+			defer wg.Done()
+			for jj := 0; jj < 1000; jj++ {
+				cache.Add(thread*1000+jj, jj)
+				cache.Lookup(jj)
+				if jj%100 == 0 {
+					cache.Resize(uint(jj%7 + 1))
+				}
+				cache.Limit()
+			}
+		}(ii)
+	}
+	wg.Wait()
+	require.LessOrEqual(cache.Len(), 7)
+}
+
+func TestNegativeLookupCache(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	params := DeSoTestnetParams
+	snap, err, _ := NewSnapshot(db, dir, SnapshotBlockHeightPeriod, false, false, &params, true)
+	require.NoError(err)
+	defer snap.Stop()
+
+	key := _dbKeyForPublicKeyToDeSoBalanceNanos(m0PkBytes)
+	keyString := hex.EncodeToString(key)
+	getBalance := func() error {
+		return db.View(func(txn *badger.Txn) error {
+			_, err := DBGetWithTxn(txn, snap, key)
+			return err
+		})
+	}
+
+	// The first lookup hits the db and remembers that the key is missing.
+	require.Equal(badger.ErrKeyNotFound, getBalance())
+	require.True(snap.NegativeLookupCache.IsKnownMissing(keyString))
+	numHits := snap.NegativeLookupCache.NumHits
+	require.Equal(badger.ErrKeyNotFound, getBalance())
+	require.Equal(numHits+1, snap.NegativeLookupCache.NumHits)
+
+	// Writing the key invalidates the negative lookup.
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return DBSetWithTxn(txn, snap, key, EncodeUint64(10))
+	}))
+	require.False(snap.NegativeLookupCache.IsKnownMissing(keyString))
+	require.NoError(getBalance())
+
+	// Until the write is known to be committed, missing keys aren't added, since the reader might
+	// not see the write yet.
+	otherKey := _dbKeyForPublicKeyToDeSoBalanceNanos(m1PkBytes)
+	otherKeyString := hex.EncodeToString(otherKey)
+	getOtherBalance := func(txn *badger.Txn) error {
+		_, err := DBGetWithTxn(txn, snap, otherKey)
+		return err
+	}
+	require.Equal(badger.ErrKeyNotFound, db.View(getOtherBalance))
+	require.False(snap.NegativeLookupCache.IsKnownMissing(otherKeyString))
+	snap.InvalidateNegativeLookupsAfterCommit()
+	require.Equal(badger.ErrKeyNotFound, db.View(getOtherBalance))
+	require.True(snap.NegativeLookupCache.IsKnownMissing(otherKeyString))
+
+	// A txn that started before a write was committed doesn't see it, so the keys it finds missing
+	// aren't added either. We evict the record from the DatabaseCache so that the lookup gets to the db.
+	staleTxn := db.NewTransaction(false)
+	defer staleTxn.Discard()
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return DBSetWithTxn(txn, snap, otherKey, EncodeUint64(10))
+	}))
+	snap.InvalidateNegativeLookupsAfterCommit()
+	snap.DatabaseCache.Delete(otherKeyString)
+	require.False(snap.NegativeLookupCache.IsKnownMissing(otherKeyString))
+	require.Equal(badger.ErrKeyNotFound, getOtherBalance(staleTxn))
+	require.False(snap.NegativeLookupCache.IsKnownMissing(otherKeyString))
+	require.NoError(db.View(getOtherBalance))
+
+	// Non-state keys are never cached.
+	nonStateKey := append([]byte{}, Prefixes.PrefixBlockHashToBlock...)
+	require.NoError(db.View(func(txn *badger.Txn) error {
+		_, err := DBGetWithTxn(txn, snap, nonStateKey)
+		require.Equal(badger.ErrKeyNotFound, err)
+		return nil
+	}))
+	require.False(snap.NegativeLookupCache.IsKnownMissing(hex.EncodeToString(nonStateKey)))
+
+	// Entries expire after the TTL.
+	expiringCache := NewNegativeLookupCache(10, time.Millisecond)
+	expiringCache.AddMissing(keyString, 0)
+	require.True(expiringCache.IsKnownMissing(keyString))
+	time.Sleep(5 * time.Millisecond)
+	require.False(expiringCache.IsKnownMissing(keyString))
+
+	// A nil cache never knows anything.
+	var nilCache *NegativeLookupCache
+	nilCache.AddMissing(keyString, 0)
+	require.False(nilCache.IsKnownMissing(keyString))
+}
+
+func TestDatabaseCacheConfiguration(t *testing.T) {
+	require := require.New(t)
+	defer SetDatabaseCacheDisabledPrefixes(nil)
+	defer func() { require.NoError(SetDatabaseCacheSizeLimits(0, 0)) }()
+
+	// The size can't be above the max size, and setting a size below the default min size lowers it.
+	require.Error(SetDatabaseCacheSizeLimits(2000, 1000))
+	require.NoError(SetDatabaseCacheSizeLimits(500, 1000))
+	metrics := &DatabaseCacheMetrics{}
+	require.NoError(metrics.Initialize(nil, nil))
+	require.Equal(uint64(500), metrics.CacheSize)
+	policy := NewAdaptiveDatabaseCacheSizingPolicy()
+	require.Equal(uint64(500), policy.MinCacheSize)
+	require.Equal(uint64(1000), policy.MaxCacheSize)
+
+	// A persisted size is kept within the max size.
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	var dbMutex sync.Mutex
+	require.NoError(metrics.Initialize(db, &dbMutex))
+	metrics.CacheSize = 5000
+	require.NoError(metrics.SaveMetrics())
+	require.NoError(SetDatabaseCacheSizeLimits(0, 1000))
+	require.NoError(metrics.Initialize(db, &dbMutex))
+	require.Equal(uint64(1000), metrics.CacheSize)
+
+	require.NoError(SetDatabaseCacheSizeLimits(0, 0))
+	params := DeSoTestnetParams
+	snap, err, _ := NewSnapshot(db, dir, SnapshotBlockHeightPeriod, false, false, &params, true)
+	require.NoError(err)
+	defer snap.Stop()
+
+	balanceKey := _dbKeyForPublicKeyToDeSoBalanceNanos(m0PkBytes)
+	utxoKey := _DbKeyForUtxoKey(&UtxoKey{TxID: *NewBlockHash(RandomBytes(HashSizeBytes)), Index: 0})
+	for _, key := range [][]byte{balanceKey, utxoKey} {
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			return DBSetWithTxn(txn, snap, key, EncodeUint64(10))
+		}))
+	}
+	getKey := func(key []byte) {
+		require.NoError(db.View(func(txn *badger.Txn) error {
+			_, err := DBGetWithTxn(txn, snap, key)
+			return err
+		}))
+	}
+
+	// Lookups are counted by prefix.
+	getKey(balanceKey)
+	getKey(utxoKey)
+	getKey(utxoKey)
+	prefixStats := snap.DatabaseCacheMetrics.GetPrefixStats()
+	require.Equal(2, len(prefixStats))
+	require.Equal(Prefixes.PrefixUtxoKeyToUtxoEntry[0], prefixStats[0].Prefix)
+	require.Equal("PrefixUtxoKeyToUtxoEntry", prefixStats[0].Name)
+	require.Equal(uint64(2), prefixStats[0].Hits)
+	require.Equal(Prefixes.PrefixPublicKeyToDeSoBalanceNanos[0], prefixStats[1].Prefix)
+	require.Equal(uint64(1), prefixStats[1].Hits)
+
+	// Disabling a prefix drops its records as they're written, and its lookups skip the cache.
+	SetDatabaseCacheDisabledPrefixes([][]byte{Prefixes.PrefixUtxoKeyToUtxoEntry})
+	require.False(IsDatabaseCacheEnabledForKey(utxoKey))
+	require.True(IsDatabaseCacheEnabledForKey(balanceKey))
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return DBSetWithTxn(txn, snap, utxoKey, EncodeUint64(20))
+	}))
+	_, exists := snap.DatabaseCache.Lookup(hex.EncodeToString(utxoKey))
+	require.False(exists)
+	getKey(utxoKey)
+	_, exists = snap.DatabaseCache.Lookup(hex.EncodeToString(utxoKey))
+	require.False(exists)
+	require.Equal(uint64(2), snap.DatabaseCacheMetrics.GetPrefixStats()[0].Hits+
+		snap.DatabaseCacheMetrics.GetPrefixStats()[0].Misses)
+
+	// Re-enabling the prefix caches the latest value.
+	SetDatabaseCacheDisabledPrefixes(nil)
+	getKey(utxoKey)
+	value, exists := snap.DatabaseCache.Lookup(hex.EncodeToString(utxoKey))
+	require.True(exists)
+	require.Equal(EncodeUint64(20), value)
+}
+
+func TestDatabaseCacheBoundedDuringBlockSync(t *testing.T) {
+	require := require.New(t)
+
+	const cacheLimit = 20
+	const numBlocks = 50
+
+	// Mine the blocks on one chain.
+	chain1, params, _ := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain1, params, true)
+	blocks := []*MsgDeSoBlock{}
+	for ii := 0; ii < numBlocks; ii++ {
+		block, err := miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+		blocks = append(blocks, block)
+	}
+
+	// Sync them into a fresh chain whose caches are much smaller than the state they write.
+	chain2, _, db2 := NewLowDifficultyBlockchain()
+	snap := chain2.snapshot
+	if snap == nil {
+		t.Skip("The snapshot cache is only used with a snapshot")
+	}
+	snap.SetDatabaseCacheSizingPolicy(nil)
+	snap.DatabaseCache.Resize(cacheLimit)
+	snap.NegativeLookupCache.cache.Resize(cacheLimit)
+	for _, block := range blocks {
+		_, _, err := chain2.ProcessBlock(block, true)
+		require.NoError(err)
+		require.LessOrEqual(snap.DatabaseCache.Len(), cacheLimit)
+		require.LessOrEqual(snap.NegativeLookupCache.cache.Len(), cacheLimit)
+	}
+	require.Equal(blocks[numBlocks-1].Header.Height, uint64(chain2.BlockTip().Height))
+
+	// Heavy churn on top of the synced state evicts old records instead of growing the cache.
+	for ii := 0; ii < 100*cacheLimit; ii++ {
+		key := _DbKeyForUtxoKey(&UtxoKey{TxID: *NewBlockHash(RandomBytes(HashSizeBytes)), Index: 0})
+		require.NoError(db2.Update(func(txn *badger.Txn) error {
+			if _, err := DBGetWithTxn(txn, snap, key); err != badger.ErrKeyNotFound {
+				return fmt.Errorf("expected the key to be missing, got: %v", err)
+			}
+			return DBSetWithTxn(txn, snap, key, RandomBytes(100))
+		}))
+	}
+	require.LessOrEqual(snap.DatabaseCache.Len(), cacheLimit)
+	require.LessOrEqual(snap.NegativeLookupCache.cache.Len(), cacheLimit)
+	require.Greater(snap.DatabaseCacheMetrics.TotalMisses, uint64(100*cacheLimit))
+}
+
+func TestStateChecksumAtHeight(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, _ := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+
+	for ii := 0; ii < 3; ii++ {
+		_, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
+		require.NoError(err)
+	}
+	chain.snapshot.WaitForAllOperationsToFinish()
+
+	// The checksum at the tip should match the current state checksum.
+	tipHeight := uint64(chain.BlockTip().Height)
+	currentChecksumBytes, err := chain.snapshot.Checksum.ToBytes()
+	require.NoError(err)
+	checksumBytes, err := chain.snapshot.GetStateChecksumAtHeight(tipHeight)
+	require.NoError(err)
+	require.Equal(currentChecksumBytes, checksumBytes)
+
+	// The earlier blocks changed the state, so their checksums should differ from the tip's.
+	prevChecksumBytes, err := chain.snapshot.GetStateChecksumAtHeight(tipHeight - 1)
+	require.NoError(err)
+	require.NotNil(prevChecksumBytes)
+	require.NotEqual(checksumBytes, prevChecksumBytes)
+
+	// Nothing is recorded for blocks we haven't processed.
+	checksumBytes, err = chain.snapshot.GetStateChecksumAtHeight(tipHeight + 1)
+	require.NoError(err)
+	require.Nil(checksumBytes)
+
+	// Checkpoints are only enforced at the heights they're defined for.
+	paramsCopy := *params
+	paramsCopy.StateChecksumCheckpoints = map[uint64][]byte{tipHeight: currentChecksumBytes}
+	require.NoError(CheckStateChecksumCheckpoint(&paramsCopy, tipHeight, currentChecksumBytes))
+	require.Error(CheckStateChecksumCheckpoint(&paramsCopy, tipHeight, prevChecksumBytes))
+	require.NoError(CheckStateChecksumCheckpoint(&paramsCopy, tipHeight-1, prevChecksumBytes))
+}
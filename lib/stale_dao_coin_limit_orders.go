@@ -0,0 +1,220 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/holiman/uint256"
+	"github.com/pkg/errors"
+)
+
+// StaleDAOCoinLimitOrder is an open DAO coin limit order whose transactor's balance of the coin it's
+// selling is less than the quantity it has left to sell. The matcher would cancel such an order as
+// soon as a fill needs more than the balance, so order books can leave it out.
+type StaleDAOCoinLimitOrder struct {
+	OrderID                   *BlockHash
+	TransactorPKID            *PKID
+	SellingDAOCoinCreatorPKID *PKID
+	BaseUnitsToSell           *uint256.Int
+	BalanceBaseUnits          *uint256.Int
+	// BlockHeight is the height of the block whose balance changes flagged the order.
+	BlockHeight uint64
+}
+
+func (staleOrder *StaleDAOCoinLimitOrder) ToBytes() []byte {
+	var data []byte
+	data = append(data, staleOrder.OrderID[:]...)
+	data = append(data, staleOrder.TransactorPKID[:]...)
+	data = append(data, staleOrder.SellingDAOCoinCreatorPKID[:]...)
+	data = append(data, EncodeUint256(staleOrder.BaseUnitsToSell)...)
+	data = append(data, EncodeUint256(staleOrder.BalanceBaseUnits)...)
+	data = append(data, UintToBuf(staleOrder.BlockHeight)...)
+	return data
+}
+
+func (staleOrder *StaleDAOCoinLimitOrder) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+	var err error
+
+	staleOrder.OrderID = &BlockHash{}
+	if _, err = io.ReadFull(rr, staleOrder.OrderID[:]); err != nil {
+		return errors.Wrapf(err, "StaleDAOCoinLimitOrder.FromBytes: Problem reading OrderID")
+	}
+	staleOrder.TransactorPKID = &PKID{}
+	if _, err = io.ReadFull(rr, staleOrder.TransactorPKID[:]); err != nil {
+		return errors.Wrapf(err, "StaleDAOCoinLimitOrder.FromBytes: Problem reading TransactorPKID")
+	}
+	staleOrder.SellingDAOCoinCreatorPKID = &PKID{}
+	if _, err = io.ReadFull(rr, staleOrder.SellingDAOCoinCreatorPKID[:]); err != nil {
+		return errors.Wrapf(err, "StaleDAOCoinLimitOrder.FromBytes: Problem reading SellingDAOCoinCreatorPKID")
+	}
+	if staleOrder.BaseUnitsToSell, err = DecodeUint256(rr); err != nil {
+		return errors.Wrapf(err, "StaleDAOCoinLimitOrder.FromBytes: Problem reading BaseUnitsToSell")
+	}
+	if staleOrder.BalanceBaseUnits, err = DecodeUint256(rr); err != nil {
+		return errors.Wrapf(err, "StaleDAOCoinLimitOrder.FromBytes: Problem reading BalanceBaseUnits")
+	}
+	if staleOrder.BlockHeight, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "StaleDAOCoinLimitOrder.FromBytes: Problem reading BlockHeight")
+	}
+	return nil
+}
+
+// RegisterStaleDAOCoinLimitOrderHandlers makes the IndexQueue flag the orders that became unfillable
+// whenever a block changes their transactors' balances. Stale orders are only flagged, not cancelled,
+// since cancelling them is up to consensus. Both handlers recompute the records of every transactor
+// the block touched from the current order book and balances, so they're idempotent, and an order
+// that's filled, cancelled, or funded again loses its flag the next time its transactor is touched.
+func RegisterStaleDAOCoinLimitOrderHandlers(indexQueue *IndexQueue) {
+	db := indexQueue.db
+	handler := func(task *IndexQueueTask) error {
+		return db.Update(func(txn *badger.Txn) error {
+			return _refreshStaleDAOCoinLimitOrdersForBlockWithTxn(txn, task.BlockHash, task.BlockHeight)
+		})
+	}
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockConnected, handler)
+	indexQueue.RegisterHandler(IndexQueueTaskTypeBlockDisconnected, handler)
+}
+
+func _refreshStaleDAOCoinLimitOrdersForBlockWithTxn(txn *badger.Txn, blockHash *BlockHash, blockHeight uint64) error {
+	transactorPKIDs, err := _getBalanceChangePKIDsForBlockWithTxn(txn, blockHash)
+	if err != nil {
+		return errors.Wrapf(err, "_refreshStaleDAOCoinLimitOrdersForBlockWithTxn: ")
+	}
+	for _, transactorPKID := range transactorPKIDs {
+		if err = _refreshStaleDAOCoinLimitOrdersForTransactorWithTxn(txn, transactorPKID, blockHeight); err != nil {
+			return errors.Wrapf(err, "_refreshStaleDAOCoinLimitOrdersForBlockWithTxn: ")
+		}
+	}
+	return nil
+}
+
+// _getBalanceChangePKIDsForBlockWithTxn returns the PKIDs whose balances the block may have changed.
+// The block's transactors and outputs are always included. The balance entries and filled orders in
+// the block's utxo ops are included too when the ops are still around, which isn't the case once
+// the block has been disconnected.
+func _getBalanceChangePKIDsForBlockWithTxn(txn *badger.Txn, blockHash *BlockHash) ([]*PKID, error) {
+	block := GetBlockWithTxn(txn, nil, blockHash)
+	if block == nil {
+		return nil, fmt.Errorf("_getBalanceChangePKIDsForBlockWithTxn: Block %v not found", blockHash)
+	}
+
+	pkidsSeen := make(map[PKID]bool)
+	pkids := []*PKID{}
+	addPKID := func(pkid *PKID) {
+		if pkid == nil || pkidsSeen[*pkid] {
+			return
+		}
+		pkidsSeen[*pkid] = true
+		pkids = append(pkids, pkid.NewPKID())
+	}
+	addPublicKey := func(publicKey []byte) {
+		if len(publicKey) == 0 {
+			return
+		}
+		if pkidEntry := DBGetPKIDEntryForPublicKeyWithTxn(txn, nil, publicKey); pkidEntry != nil {
+			addPKID(pkidEntry.PKID)
+		}
+	}
+	addBalanceEntry := func(balanceEntry *BalanceEntry) {
+		if balanceEntry != nil {
+			addPKID(balanceEntry.HODLerPKID)
+		}
+	}
+
+	for _, blockTxn := range block.Txns {
+		addPublicKey(blockTxn.PublicKey)
+		for _, output := range blockTxn.TxOutputs {
+			addPublicKey(output.PublicKey)
+		}
+	}
+
+	utxoOpsForBlock, err := GetUtxoOperationsForBlockWithTxn(txn, nil, blockHash)
+	if err == badger.ErrKeyNotFound {
+		return pkids, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "_getBalanceChangePKIDsForBlockWithTxn: Problem getting utxo ops for block %v", blockHash)
+	}
+	for _, utxoOpsForTxn := range utxoOpsForBlock {
+		for _, utxoOp := range utxoOpsForTxn {
+			addBalanceEntry(utxoOp.PrevTransactorBalanceEntry)
+			addBalanceEntry(utxoOp.PrevCreatorBalanceEntry)
+			addBalanceEntry(utxoOp.PrevSenderBalanceEntry)
+			addBalanceEntry(utxoOp.PrevReceiverBalanceEntry)
+			for hodlerPKID := range utxoOp.PrevBalanceEntries {
+				hodlerPKIDCopy := hodlerPKID
+				addPKID(&hodlerPKIDCopy)
+			}
+			for _, filledOrder := range utxoOp.FilledDAOCoinLimitOrders {
+				addPKID(filledOrder.TransactorPKID)
+			}
+		}
+	}
+	return pkids, nil
+}
+
+// _refreshStaleDAOCoinLimitOrdersForTransactorWithTxn replaces the transactor's stale order records
+// with the ones its current orders and balances call for.
+func _refreshStaleDAOCoinLimitOrdersForTransactorWithTxn(txn *badger.Txn, transactorPKID *PKID, blockHeight uint64) error {
+	if err := DbDeleteStaleDAOCoinLimitOrdersForTransactorWithTxn(txn, transactorPKID); err != nil {
+		return errors.Wrapf(err, "_refreshStaleDAOCoinLimitOrdersForTransactorWithTxn: ")
+	}
+	orders, err := DBGetAllDAOCoinLimitOrdersForThisTransactorWithTxn(txn, transactorPKID)
+	if err != nil {
+		return errors.Wrapf(err, "_refreshStaleDAOCoinLimitOrdersForTransactorWithTxn: ")
+	}
+
+	balancesBySellingCoin := make(map[PKID]*uint256.Int)
+	for _, order := range orders {
+		baseUnitsToSell, err := order.BaseUnitsToSellUint256()
+		if err != nil {
+			return errors.Wrapf(err, "_refreshStaleDAOCoinLimitOrdersForTransactorWithTxn: Problem getting "+
+				"quantity to sell for order %v", order.OrderID)
+		}
+		balance, exists := balancesBySellingCoin[*order.SellingDAOCoinCreatorPKID]
+		if !exists {
+			balance, err = _getDAOCoinBalanceInBaseUnitsWithTxn(txn, transactorPKID, order.SellingDAOCoinCreatorPKID)
+			if err != nil {
+				return errors.Wrapf(err, "_refreshStaleDAOCoinLimitOrdersForTransactorWithTxn: ")
+			}
+			balancesBySellingCoin[*order.SellingDAOCoinCreatorPKID] = balance
+		}
+		if !balance.Lt(baseUnitsToSell) {
+			continue
+		}
+		err = DbPutStaleDAOCoinLimitOrderWithTxn(txn, &StaleDAOCoinLimitOrder{
+			OrderID:                   order.OrderID.NewBlockHash(),
+			TransactorPKID:            transactorPKID.NewPKID(),
+			SellingDAOCoinCreatorPKID: order.SellingDAOCoinCreatorPKID.NewPKID(),
+			BaseUnitsToSell:           baseUnitsToSell,
+			BalanceBaseUnits:          balance.Clone(),
+			BlockHeight:               blockHeight,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "_refreshStaleDAOCoinLimitOrdersForTransactorWithTxn: ")
+		}
+	}
+	return nil
+}
+
+// _getDAOCoinBalanceInBaseUnitsWithTxn mirrors getAdjustedDAOCoinBalanceForUserInBaseUnits against
+// the db: the ZeroPKID stands for DESO.
+func _getDAOCoinBalanceInBaseUnitsWithTxn(txn *badger.Txn, hodlerPKID *PKID, daoCoinPKID *PKID) (*uint256.Int, error) {
+	if *daoCoinPKID == ZeroPKID {
+		publicKey := DBGetPublicKeyForPKIDWithTxn(txn, nil, hodlerPKID)
+		desoBalanceNanos, err := DbGetDeSoBalanceNanosForPublicKeyWithTxn(txn, nil, publicKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "_getDAOCoinBalanceInBaseUnitsWithTxn: ")
+		}
+		return uint256.NewInt().SetUint64(desoBalanceNanos), nil
+	}
+
+	balanceEntry := DbGetHolderPKIDCreatorPKIDToBalanceEntryWithTxn(txn, nil, hodlerPKID, daoCoinPKID, true)
+	if balanceEntry == nil || balanceEntry.isDeleted {
+		return uint256.NewInt(), nil
+	}
+	return balanceEntry.BalanceNanos.Clone(), nil
+}
@@ -0,0 +1,105 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaleDAOCoinLimitOrders(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+
+	// The miner's block reward output counts as a balance change.
+	block, err := miner.MineAndProcessSingleBlock(0, mempool)
+	require.NoError(err)
+	blockHash, err := block.Hash()
+	require.NoError(err)
+	senderPkBytes, _, err := Base58CheckDecode(senderPkString)
+	require.NoError(err)
+	require.NoError(db.View(func(txn *badger.Txn) error {
+		pkids, err := _getBalanceChangePKIDsForBlockWithTxn(txn, blockHash)
+		require.NoError(err)
+		require.Contains(pkids, PublicKeyToPKID(senderPkBytes))
+		return nil
+	}))
+
+	// m0 asks to sell 100 DESO nanos for m1's coin.
+	m0PkBytes, _, err := Base58CheckDecode(m0Pub)
+	require.NoError(err)
+	m1PkBytes, _, err := Base58CheckDecode(m1Pub)
+	require.NoError(err)
+	m0PKID := PublicKeyToPKID(m0PkBytes)
+	exchangeRate, err := CalculateScaledExchangeRateFromString("1.0")
+	require.NoError(err)
+	order := &DAOCoinLimitOrderEntry{
+		OrderID:                   NewBlockHash(RandomBytes(HashSizeBytes)),
+		TransactorPKID:            m0PKID,
+		BuyingDAOCoinCreatorPKID:  PublicKeyToPKID(m1PkBytes),
+		SellingDAOCoinCreatorPKID: &ZeroPKID,
+		ScaledExchangeRateCoinsToSellPerCoinToBuy: exchangeRate,
+		QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(100),
+		OperationType:                             DAOCoinLimitOrderOperationTypeASK,
+		FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+		BlockHeight:                               1,
+	}
+	blockHeight := uint64(chain.blockTip().Height)
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		return DBPutDAOCoinLimitOrderWithTxn(txn, nil, order, blockHeight)
+	}))
+
+	refreshWithBalance := func(balanceNanos uint64) {
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			if err := DbPutDeSoBalanceForPublicKeyWithTxn(txn, nil, m0PkBytes, balanceNanos); err != nil {
+				return err
+			}
+			return _refreshStaleDAOCoinLimitOrdersForTransactorWithTxn(txn, m0PKID, blockHeight)
+		}))
+	}
+
+	// The order is stale while m0 holds less than it's selling.
+	refreshWithBalance(50)
+	staleOrders, err := DbGetStaleDAOCoinLimitOrdersForTransactor(db, m0PKID)
+	require.NoError(err)
+	require.Equal([]*StaleDAOCoinLimitOrder{{
+		OrderID:                   order.OrderID,
+		TransactorPKID:            m0PKID,
+		SellingDAOCoinCreatorPKID: &ZeroPKID,
+		BaseUnitsToSell:           uint256.NewInt().SetUint64(100),
+		BalanceBaseUnits:          uint256.NewInt().SetUint64(50),
+		BlockHeight:               blockHeight,
+	}}, staleOrders)
+
+	// Refreshing again doesn't duplicate the record.
+	refreshWithBalance(50)
+	staleOrders, err = DbGetStaleDAOCoinLimitOrders(db)
+	require.NoError(err)
+	require.Len(staleOrders, 1)
+
+	// Funding the order again removes the flag.
+	refreshWithBalance(100)
+	staleOrders, err = DbGetStaleDAOCoinLimitOrders(db)
+	require.NoError(err)
+	require.Empty(staleOrders)
+
+	// So does cancelling it.
+	refreshWithBalance(0)
+	staleOrders, err = DbGetStaleDAOCoinLimitOrders(db)
+	require.NoError(err)
+	require.Len(staleOrders, 1)
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		if err := DBDeleteDAOCoinLimitOrderWithTxn(txn, nil, order); err != nil {
+			return err
+		}
+		return _refreshStaleDAOCoinLimitOrdersForTransactorWithTxn(txn, m0PKID, blockHeight)
+	}))
+	staleOrders, err = DbGetStaleDAOCoinLimitOrders(db)
+	require.NoError(err)
+	require.Empty(staleOrders)
+}
@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// The state change records are kept apart from state_change_stream.go, since the db write wrappers
+// record the changes in every build, including deso_embedded.
+
+type StateChangeOperationType uint8
+
+const (
+	StateChangeOperationTypeUpsert StateChangeOperationType = 0
+	StateChangeOperationTypeDelete StateChangeOperationType = 1
+)
+
+func (operationType StateChangeOperationType) MarshalJSON() ([]byte, error) {
+	if operationType == StateChangeOperationTypeDelete {
+		return json.Marshal("Delete")
+	}
+	return json.Marshal("Upsert")
+}
+
+// StateChangeRecord is one write to or delete from a state prefix. EntryType is the name of the
+// prefix, and EncoderBytes is the value written, which is empty for deletes. All the records with
+// the same FlushId were committed together.
+type StateChangeRecord struct {
+	FlushId       uint64
+	BlockHeight   uint64
+	OperationType StateChangeOperationType
+	Prefix        byte
+	EntryType     string
+	KeyBytes      []byte
+	EncoderBytes  []byte
+}
+
+// stateChangeRecordersByTxn maps a *badger.Txn to the *stateChangeRecorder recording its writes.
+// numStateChangeRecorders lets the db write wrappers skip the map lookup when nothing is recorded.
+var stateChangeRecordersByTxn sync.Map
+
+var numStateChangeRecorders int32
+
+// stateChangeRecorder collects the state changes made in one txn. All the methods are safe to call
+// on a nil recorder, which is what callers get when no one listens for state changes.
+type stateChangeRecorder struct {
+	flushId     uint64
+	blockHeight uint64
+
+	txn          *badger.Txn
+	stateChanges []*StateChangeRecord
+	mtx          sync.Mutex
+}
+
+// TrackTxn makes the recorder record every state change made in the txn until EndTrack is
+// called. A recorder can only track one txn.
+func (recorder *stateChangeRecorder) TrackTxn(txn *badger.Txn) {
+	if recorder == nil || txn == nil || recorder.txn != nil {
+		return
+	}
+	recorder.txn = txn
+	stateChangeRecordersByTxn.Store(txn, recorder)
+	atomic.AddInt32(&numStateChangeRecorders, 1)
+}
+
+func (recorder *stateChangeRecorder) EndTrack() {
+	if recorder == nil || recorder.txn == nil {
+		return
+	}
+	stateChangeRecordersByTxn.Delete(recorder.txn)
+	atomic.AddInt32(&numStateChangeRecorders, -1)
+	recorder.txn = nil
+}
+
+// _recordStateChange is called by the db write wrappers after every write and delete. It's a
+// no-op unless some recorder tracks the txn and the key is in a state prefix.
+func _recordStateChange(txn *badger.Txn, operationType StateChangeOperationType, key []byte, value []byte) {
+	if atomic.LoadInt32(&numStateChangeRecorders) == 0 || len(key) == 0 || !isStateKey(key) {
+		return
+	}
+	recorderIface, exists := stateChangeRecordersByTxn.Load(txn)
+	if !exists {
+		return
+	}
+	recorder := recorderIface.(*stateChangeRecorder)
+	recorder.mtx.Lock()
+	defer recorder.mtx.Unlock()
+
+	recorder.stateChanges = append(recorder.stateChanges, &StateChangeRecord{
+		FlushId:       recorder.flushId,
+		BlockHeight:   recorder.blockHeight,
+		OperationType: operationType,
+		Prefix:        key[0],
+		EntryType:     dbPrefixName(key[0]),
+		KeyBytes:      append([]byte{}, key...),
+		EncoderBytes:  append([]byte{}, value...),
+	})
+}
@@ -0,0 +1,132 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/deso-protocol/go-deadlock"
+	"github.com/golang/glog"
+)
+
+// State change streaming lets external services like Rosetta or indexers consume the mutations the
+// node makes to the state without reading the db. When the EventManager has OnStateChangesFlushed
+// handlers, every state record that a block connect, disconnect or reorg writes to or deletes from
+// badger is recorded, and the handlers get the records once the flush has been committed. State
+// that is downloaded by hypersync isn't streamed, and neither is state on postgres nodes.
+
+// StateChangeStreamBufferSize is the number of flushes that a StateChangeStreamServer buffers for
+// each client. Clients that fall further behind are disconnected rather than holding up the chain.
+var StateChangeStreamBufferSize = 1000
+
+// newStateChangeRecorder returns nil unless the EventManager has OnStateChangesFlushed handlers.
+// Flush ids are taken from the clock, so that they keep increasing across restarts.
+func (bc *Blockchain) newStateChangeRecorder(blockHeight uint64) *stateChangeRecorder {
+	if bc.eventManager == nil || !bc.eventManager.hasStateChangesFlushedHandlers() {
+		return nil
+	}
+	flushId := uint64(time.Now().UnixNano())
+	if flushId <= bc.lastStateChangeFlushId {
+		flushId = bc.lastStateChangeFlushId + 1
+	}
+	bc.lastStateChangeFlushId = flushId
+	return &stateChangeRecorder{
+		flushId:     flushId,
+		blockHeight: blockHeight,
+	}
+}
+
+// emitStateChanges hands the recorded state changes to the EventManager. It must only be called
+// once the txn has been committed.
+func (bc *Blockchain) emitStateChanges(recorder *stateChangeRecorder) {
+	if recorder == nil || bc.eventManager == nil {
+		return
+	}
+	bc.eventManager.stateChangesFlushed(&StateChangesEvent{
+		FlushId:      recorder.flushId,
+		BlockHeight:  recorder.blockHeight,
+		StateChanges: recorder.stateChanges,
+	})
+}
+
+// StateChangeStreamServer streams the state changes to HTTP clients as newline-delimited JSON, one
+// StateChangeRecord per line. Clients only get the changes flushed after they connect.
+type StateChangeStreamServer struct {
+	mtx     deadlock.Mutex
+	clients map[chan *StateChangesEvent]bool
+}
+
+// NewStateChangeStreamServer registers the server with the EventManager. It has to be called before
+// the node starts processing blocks.
+func NewStateChangeStreamServer(eventManager *EventManager) *StateChangeStreamServer {
+	server := &StateChangeStreamServer{
+		clients: make(map[chan *StateChangesEvent]bool),
+	}
+	eventManager.OnStateChangesFlushed(server._handleStateChangesFlushed)
+	return server
+}
+
+func (server *StateChangeStreamServer) _handleStateChangesFlushed(event *StateChangesEvent) {
+	server.mtx.Lock()
+	defer server.mtx.Unlock()
+
+	for client := range server.clients {
+		select {
+		case client <- event:
+		default:
+			// The client is too far behind. Closing its channel ends its stream, so that it
+			// knows it missed changes.
+			glog.Warningf("StateChangeStreamServer: Disconnecting client that fell more than %v "+
+				"flushes behind", StateChangeStreamBufferSize)
+			delete(server.clients, client)
+			close(client)
+		}
+	}
+}
+
+func (server *StateChangeStreamServer) _removeClient(client chan *StateChangesEvent) {
+	server.mtx.Lock()
+	defer server.mtx.Unlock()
+
+	if server.clients[client] {
+		delete(server.clients, client)
+		close(client)
+	}
+}
+
+func (server *StateChangeStreamServer) ServeHTTP(ww http.ResponseWriter, req *http.Request) {
+	flusher, ok := ww.(http.Flusher)
+	if !ok {
+		http.Error(ww, "Streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	client := make(chan *StateChangesEvent, StateChangeStreamBufferSize)
+	server.mtx.Lock()
+	server.clients[client] = true
+	server.mtx.Unlock()
+	defer server._removeClient(client)
+
+	ww.Header().Set("Content-Type", "application/x-ndjson")
+	ww.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(ww)
+	for {
+		select {
+		case event, ok := <-client:
+			if !ok {
+				return
+			}
+			for _, stateChange := range event.StateChanges {
+				if err := encoder.Encode(stateChange); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,100 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	chainlib "github.com/btcsuite/btcd/blockchain"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateChangeStream(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	if chain.postgres != nil {
+		t.Skip("State change streaming is only supported on badger")
+	}
+
+	// Reopen the chain with an EventManager that collects the state changes.
+	eventManager := NewEventManager()
+	stateChangesEvents := []*StateChangesEvent{}
+	eventManager.OnStateChangesFlushed(func(event *StateChangesEvent) {
+		stateChangesEvents = append(stateChangesEvents, event)
+	})
+	streamServer := httptest.NewServer(NewStateChangeStreamServer(eventManager))
+	defer streamServer.Close()
+	chain, err := NewBlockchain([]string{blockSignerPk}, 0, 0, params,
+		chainlib.NewMedianTime(), db, nil, eventManager, nil, false)
+	require.NoError(err)
+	mempool, miner := NewTestMiner(t, chain, params, true)
+
+	resp, err := http.Get(streamServer.URL)
+	require.NoError(err)
+	defer resp.Body.Close()
+	require.Equal(http.StatusOK, resp.StatusCode)
+
+	// Connecting a block streams the utxo its block reward created.
+	block, err := miner.MineAndProcessSingleBlock(0, mempool)
+	require.NoError(err)
+	require.Equal(1, len(stateChangesEvents))
+	rewardUtxoKey := _DbKeyForUtxoKey(&UtxoKey{TxID: *block.Txns[0].Hash(), Index: 0})
+	requireStateChange := func(event *StateChangesEvent, operationType StateChangeOperationType) {
+		require.Equal(block.Header.Height, event.BlockHeight)
+		for _, stateChange := range event.StateChanges {
+			require.Equal(event.FlushId, stateChange.FlushId)
+			require.True(isStateKey(stateChange.KeyBytes))
+			if string(stateChange.KeyBytes) != string(rewardUtxoKey) {
+				continue
+			}
+			require.Equal(operationType, stateChange.OperationType)
+			require.Equal("PrefixUtxoKeyToUtxoEntry", stateChange.EntryType)
+			if operationType == StateChangeOperationTypeUpsert {
+				require.NoError(db.View(func(txn *badger.Txn) error {
+					utxoEntryBytes, err := DBGetWithTxn(txn, nil, rewardUtxoKey)
+					require.NoError(err)
+					require.Equal(utxoEntryBytes, stateChange.EncoderBytes)
+					return nil
+				}))
+			}
+			return
+		}
+		require.FailNow("The block reward utxo wasn't streamed")
+	}
+	requireStateChange(stateChangesEvents[0], StateChangeOperationTypeUpsert)
+
+	// The HTTP clients get the same state changes.
+	reader := bufio.NewReader(resp.Body)
+	streamedRecordsChan := make(chan map[string]interface{})
+	go func() {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		record := make(map[string]interface{})
+		if json.Unmarshal(line, &record) == nil {
+			streamedRecordsChan <- record
+		}
+	}()
+	select {
+	case record := <-streamedRecordsChan:
+		require.Equal(float64(stateChangesEvents[0].FlushId), record["FlushId"])
+		require.Equal(float64(block.Header.Height), record["BlockHeight"])
+		require.Equal(stateChangesEvents[0].StateChanges[0].EntryType, record["EntryType"])
+	case <-time.After(10 * time.Second):
+		require.FailNow("Timed out waiting for the streamed state changes")
+	}
+
+	// Disconnecting the block streams the deletion of the utxo, with a later flush id.
+	require.NoError(chain.DisconnectBlocksToHeight(block.Header.Height - 1))
+	require.Equal(2, len(stateChangesEvents))
+	require.Greater(stateChangesEvents[1].FlushId, stateChangesEvents[0].FlushId)
+	requireStateChange(stateChangesEvents[1], StateChangeOperationTypeDelete)
+}
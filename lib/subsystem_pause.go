@@ -0,0 +1,27 @@
+package lib
+
+// Subsystem names an optional subsystem that an operator can pause with DbSetSubsystemPaused, e.g.
+// to shed load during an incident without restarting the node with different flags. The flag is
+// persisted, so a paused subsystem stays paused across restarts until it's resumed. Pausing only
+// delays work: the subsystems below consume durable queues or catch up with the chain, so nothing
+// is lost while they're paused.
+type Subsystem string
+
+const (
+	SubsystemIndexQueue Subsystem = "IndexQueue"
+	SubsystemTXIndex    Subsystem = "TXIndex"
+)
+
+var AllSubsystems = []Subsystem{
+	SubsystemIndexQueue,
+	SubsystemTXIndex,
+}
+
+func (subsystem Subsystem) IsValid() bool {
+	for _, validSubsystem := range AllSubsystems {
+		if subsystem == validSubsystem {
+			return true
+		}
+	}
+	return false
+}
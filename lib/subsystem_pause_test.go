@@ -0,0 +1,41 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubsystemPaused(t *testing.T) {
+	require := require.New(t)
+
+	db, _ := GetTestBadgerDb()
+	defer db.Close()
+
+	// Nothing is paused by default.
+	require.False(DbGetSubsystemPaused(db, SubsystemIndexQueue))
+	pausedSubsystems, err := DbGetPausedSubsystems(db)
+	require.NoError(err)
+	require.Empty(pausedSubsystems)
+
+	// Pausing a subsystem doesn't pause the others.
+	require.NoError(DbSetSubsystemPaused(db, SubsystemIndexQueue, true))
+	require.True(DbGetSubsystemPaused(db, SubsystemIndexQueue))
+	require.False(DbGetSubsystemPaused(db, SubsystemTXIndex))
+	pausedSubsystems, err = DbGetPausedSubsystems(db)
+	require.NoError(err)
+	require.Equal([]Subsystem{SubsystemIndexQueue}, pausedSubsystems)
+
+	// Pausing twice is a no-op, and resuming clears the flag.
+	require.NoError(DbSetSubsystemPaused(db, SubsystemIndexQueue, true))
+	require.NoError(DbSetSubsystemPaused(db, SubsystemIndexQueue, false))
+	require.False(DbGetSubsystemPaused(db, SubsystemIndexQueue))
+	pausedSubsystems, err = DbGetPausedSubsystems(db)
+	require.NoError(err)
+	require.Empty(pausedSubsystems)
+
+	// Unknown subsystems are rejected.
+	require.Error(DbSetSubsystemPaused(db, Subsystem("Trending"), true))
+}
@@ -1,3 +1,5 @@
+//go:build !deso_embedded
+
 package lib
 
 import (
@@ -32,6 +34,11 @@ type TXIndex struct {
 	// Update wait group
 	updateWaitGroup sync.WaitGroup
 
+	// updateChan wakes up the update thread when the core chain connects or disconnects
+	// a block, so that the txindex doesn't lag behind it. It has room for one wakeup,
+	// since a single Update catches up with any number of blocks.
+	updateChan chan struct{}
+
 	// Shutdown channel
 	stopUpdateChannel chan struct{}
 	killed            bool
@@ -50,6 +57,12 @@ func NewTXIndex(coreChain *Blockchain, params *DeSoParams, dataDirectory string)
 		glog.Fatal(err)
 	}
 
+	// Txindexes built before the txid to index mappings existed need them written before
+	// any mapping can be deleted.
+	if _, err := DbMigrateTxindexPublicKeyMappings(txIndexDb); err != nil {
+		return nil, fmt.Errorf("NewTXIndex: Error migrating txindex public key mappings: %v", err)
+	}
+
 	// See if we have a best chain hash stored in the txindex db.
 	bestBlockHashBeforeInit := DbGetBestHash(txIndexDb, nil, ChainTypeDeSoBlock)
 
@@ -133,19 +146,47 @@ func NewTXIndex(coreChain *Blockchain, params *DeSoParams, dataDirectory string)
 	if err != nil {
 		return nil, fmt.Errorf("NewTXIndex: Error initializing TxIndex: %v", err)
 	}
+	if err := coreChain.CheckTxindexTipConsistency(txIndexChain.BlockTip()); err != nil {
+		return nil, fmt.Errorf("NewTXIndex: %v", err)
+	}
+
+	// Txindexes built before the resolved inputs existed get them by replaying their blocks. A new
+	// txindex only has the genesis block at this point, so this just sets the migration marker.
+	txIndexBestChain, _ := txIndexChain.CopyBestChain()
+	if _, err := DbMigrateTxindexResolvedInputs(txIndexDb, txIndexBestChain, params); err != nil {
+		return nil, fmt.Errorf("NewTXIndex: Error migrating txindex resolved inputs: %v", err)
+	}
 
 	// At this point, we should have set up a blockchain object for our
 	// txindex, and initialized all of the seed txns and seed balances
 	// correctly. Attaching blocks to our txnindex blockchain or adding
 	// txns to our txindex should work smoothly now.
 
-	return &TXIndex{
+	txi := &TXIndex{
 		TXIndexChain:      txIndexChain,
 		CoreChain:         coreChain,
 		Params:            params,
 		stopUpdateChannel: make(chan struct{}),
+		updateChan:        make(chan struct{}, 1),
 		killed:            false,
-	}, nil
+	}
+
+	// Update the txindex as soon as the core chain's tip changes. Reorgs disconnect and connect
+	// blocks, and Update takes care of both. Chains without an EventManager are polled instead.
+	if coreChain.eventManager != nil {
+		coreChain.eventManager.OnBlockConnected(txi._handleBlockEvent)
+		coreChain.eventManager.OnBlockDisconnected(txi._handleBlockEvent)
+	}
+	return txi, nil
+}
+
+// _handleBlockEvent is called with the core chain's ChainLock held, so it only wakes up the update
+// thread rather than updating the txindex itself.
+func (txi *TXIndex) _handleBlockEvent(event *BlockEvent) {
+	select {
+	case txi.updateChan <- struct{}{}:
+	default:
+	}
 }
 
 func (txi *TXIndex) FinishedSyncing() bool {
@@ -166,7 +207,10 @@ func (txi *TXIndex) Start() {
 				txi.updateWaitGroup.Done()
 				return
 			default:
-				if txi.CoreChain.ChainState() == SyncStateFullyCurrent {
+				if DbGetSubsystemPaused(txi.CoreChain.DB(), SubsystemTXIndex) {
+					// The txindex catches up with the chain once it's resumed.
+					glog.V(1).Infof("TXIndex: Paused, not updating")
+				} else if txi.CoreChain.ChainState() == SyncStateFullyCurrent {
 					if !txi.CoreChain.IsFullyStored() {
 						glog.V(1).Infof("TXIndex: Waiting, blockchain is not fully stored")
 						break
@@ -182,7 +226,13 @@ func (txi *TXIndex) Start() {
 				break
 			}
 
-			time.Sleep(1 * time.Second)
+			// Wait for the core chain to connect or disconnect a block. We still poll every
+			// second, since the txindex can't catch up while the chain is syncing or the
+			// txindex is paused, and no event is sent when either ends.
+			select {
+			case <-txi.updateChan:
+			case <-time.After(1 * time.Second):
+			}
 		}
 	}()
 }
@@ -288,87 +338,11 @@ func (txi *TXIndex) Update() error {
 			glog.Infof(CLog(Yellow, "TxIndex: Update: Killed while detaching blocks"))
 			break
 		}
-		// Go through each txn in the block and delete its mappings from our
-		// txindex.
-		glog.V(1).Infof("Update: Detaching block (height: %d, hash: %v)",
-			blockToDetach.Height, blockToDetach.Hash)
-		blockMsg, err := GetBlock(blockToDetach.Hash, txi.TXIndexChain.DB(), nil)
-		if err != nil {
-			return fmt.Errorf("Update: Problem fetching detach block "+
-				"with hash %v: %v", blockToDetach.Hash, err)
-		}
-		blockHeight := uint64(txi.CoreChain.blockTip().Height)
-		err = txi.TXIndexChain.DB().Update(func(dbTxn *badger.Txn) error {
-			for _, txn := range blockMsg.Txns {
-				if err := DbDeleteTxindexTransactionMappingsWithTxn(dbTxn, nil,
-					blockHeight, txn, txi.Params); err != nil {
-
-					return fmt.Errorf("Update: Problem deleting "+
-						"transaction mappings for transaction %v: %v", txn.Hash(), err)
-				}
-			}
-			return nil
-		})
-		if err != nil {
+		// If the node died while detaching the block, its mappings may already be gone, in
+		// which case detaching it again has to repair its missing metadata.
+		if err := txi._detachBlock(blockToDetach, true /*repairMissingMetadata*/); err != nil {
 			return err
 		}
-
-		// Now that all the transactions have been deleted from our txindex,
-		// it's safe to disconnect the block from our txindex chain.
-		utxoView, err := NewUtxoView(txi.TXIndexChain.DB(), txi.Params, nil, nil)
-		if err != nil {
-			return fmt.Errorf(
-				"Update: Error initializing UtxoView: %v", err)
-		}
-		utxoOps, err := GetUtxoOperationsForBlock(
-			txi.TXIndexChain.DB(), nil, blockToDetach.Hash)
-		if err != nil {
-			return fmt.Errorf(
-				"Update: Error getting UtxoOps for block %v: %v", blockToDetach, err)
-		}
-		// Compute the hashes for all the transactions.
-		txHashes, err := ComputeTransactionHashes(blockMsg.Txns)
-		if err != nil {
-			return fmt.Errorf(
-				"Update: Error computing tx hashes for block %v: %v",
-				blockToDetach, err)
-		}
-		if err := utxoView.DisconnectBlock(blockMsg, txHashes, utxoOps, blockHeight); err != nil {
-			return fmt.Errorf("Update: Error detaching block "+
-				"%v from UtxoView: %v", blockToDetach, err)
-		}
-		if err := utxoView.FlushToDb(blockHeight); err != nil {
-			return fmt.Errorf("Update: Error flushing view to db for block "+
-				"%v: %v", blockToDetach, err)
-		}
-		// We have to flush a couple of extra things that the view doesn't flush...
-		if err := PutBestHash(txi.TXIndexChain.DB(), nil, utxoView.TipHash, ChainTypeDeSoBlock); err != nil {
-			return fmt.Errorf("Update: Error putting best hash for block "+
-				"%v: %v", blockToDetach, err)
-		}
-		err = txi.TXIndexChain.DB().Update(func(txn *badger.Txn) error {
-			if err := DeleteUtxoOperationsForBlockWithTxn(txn, nil, blockToDetach.Hash); err != nil {
-				return fmt.Errorf("Update: Error deleting UtxoOperations 1 for block %v, %v", blockToDetach.Hash, err)
-			}
-			if err := txn.Delete(BlockHashToBlockKey(blockToDetach.Hash)); err != nil {
-				return fmt.Errorf("Update: Error deleting UtxoOperations 2 for block %v %v", blockToDetach.Hash, err)
-			}
-			return nil
-		})
-		if err != nil {
-			return fmt.Errorf("Update: Error updating badgger: %v", err)
-		}
-		// Delete this block from the chain db so we don't get duplicate block errors.
-
-		// Remove this block from our bestChain data structures.
-		newBlockIndex := txi.TXIndexChain.CopyBlockIndex()
-		newBestChain, newBestChainMap := txi.TXIndexChain.CopyBestChain()
-		newBestChain = newBestChain[:len(newBestChain)-1]
-		delete(newBestChainMap, *(blockToDetach.Hash))
-		delete(newBlockIndex, *(blockToDetach.Hash))
-
-		txi.TXIndexChain.SetBestChainMap(newBestChain, newBestChainMap, newBlockIndex)
-
 		// At this point the entries for the block should have been removed
 		// from both our Txindex chain and our transaction index mappings.
 	}
@@ -385,66 +359,357 @@ func (txi *TXIndex) Update() error {
 			glog.Infof("Update: Txindex progress: block %d / %d",
 				blockToAttach.Height, blockTipNode.Height)
 		}
-		glog.V(2).Infof("Update: Attaching block (height: %d, hash: %v)",
-			blockToAttach.Height, blockToAttach.Hash)
-
-		blockMsg, err := GetBlock(blockToAttach.Hash, txi.CoreChain.DB(), nil)
-		if err != nil {
-			return fmt.Errorf("Update: Problem fetching attach block "+
-				"with hash %v: %v", blockToAttach.Hash, err)
+		if err := txi._attachBlock(blockToAttach); err != nil {
+			return err
 		}
+	}
 
-		// We use a view to simulate adding transactions to our chain. This allows
-		// us to extract custom metadata fields that we can show in our block explorer.
-		//
-		// Only set a BitcoinManager if we have one. This makes some tests pass.
-		utxoView, err := NewUtxoView(txi.TXIndexChain.DB(), txi.Params, nil, nil)
-		if err != nil {
-			return fmt.Errorf(
-				"Update: Error initializing UtxoView: %v", err)
-		}
+	glog.Infof("Update: Txindex update complete. New tip: (height: %d, hash: %v)",
+		txi.TXIndexChain.BlockTip().Height, txi.TXIndexChain.BlockTip().Hash)
 
-		// Do each block update in a single transaction so we're safe in case the node
-		// restarts.
-		blockHeight := uint64(txi.CoreChain.BlockTip().Height)
-		err = txi.TXIndexChain.DB().Update(func(dbTxn *badger.Txn) error {
-
-			// Iterate through each transaction in the block and do the following:
-			// - Connect it to the view
-			// - Compute its mapping values, which may include custom metadata fields
-			// - add all its mappings to the db.
-			for txnIndexInBlock, txn := range blockMsg.Txns {
-				txnMeta, err := ConnectTxnAndComputeTransactionMetadata(
-					txn, utxoView, blockToAttach.Hash, blockToAttach.Height, uint64(txnIndexInBlock))
-				if err != nil {
-					return fmt.Errorf("Update: Problem connecting txn %v to txindex: %v",
-						txn, err)
-				}
+	return nil
+}
 
-				err = DbPutTxindexTransactionMappingsWithTxn(dbTxn, nil, blockHeight,
-					txn, txi.Params, txnMeta)
-				if err != nil {
-					return fmt.Errorf("Update: Problem adding txn %v to txindex: %v",
-						txn, err)
+// _detachBlock deletes the mappings of the txns in the txindex tip and disconnects it from the txindex
+// chain. If repairMissingMetadata is set, txns whose TransactionMetadata is missing are cleaned up as
+// well as possible instead of failing the detach.
+func (txi *TXIndex) _detachBlock(blockToDetach *BlockNode, repairMissingMetadata bool) error {
+	// Go through each txn in the block and delete its mappings from our
+	// txindex.
+	glog.V(1).Infof("_detachBlock: Detaching block (height: %d, hash: %v)",
+		blockToDetach.Height, blockToDetach.Hash)
+	blockMsg, err := GetBlock(blockToDetach.Hash, txi.TXIndexChain.DB(), nil)
+	if err != nil {
+		return fmt.Errorf("_detachBlock: Problem fetching detach block "+
+			"with hash %v: %v", blockToDetach.Hash, err)
+	}
+	blockHeight := uint64(txi.CoreChain.blockTip().Height)
+	err = txi.TXIndexChain.DB().Update(func(dbTxn *badger.Txn) error {
+		for _, txn := range blockMsg.Txns {
+			if repairMissingMetadata && DbGetTxindexTransactionRefByTxIDWithTxn(dbTxn, nil, txn.Hash()) == nil {
+				// Without its metadata we can't tell which public keys the txn was indexed under, so we
+				// write placeholder metadata with the keys we can get from the txn itself and let the
+				// delete below clean up after it.
+				if err := DbPutTxindexTransactionWithTxn(dbTxn, nil, blockHeight, txn.Hash(),
+					_placeholderTxindexMetadata(txn, txi.Params)); err != nil {
+
+					return fmt.Errorf("_detachBlock: Problem repairing "+
+						"transaction metadata for transaction %v: %v", txn.Hash(), err)
 				}
 			}
-			return nil
-		})
-		if err != nil {
+			if err := DbDeleteTxindexTransactionMappingsWithTxn(dbTxn, nil,
+				blockHeight, txn, txi.Params); err != nil {
+
+				return fmt.Errorf("_detachBlock: Problem deleting "+
+					"transaction mappings for transaction %v: %v", txn.Hash(), err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Now that all the transactions have been deleted from our txindex,
+	// it's safe to disconnect the block from our txindex chain.
+	utxoView, err := NewUtxoView(txi.TXIndexChain.DB(), txi.Params, nil, nil)
+	if err != nil {
+		return fmt.Errorf(
+			"_detachBlock: Error initializing UtxoView: %v", err)
+	}
+	utxoOps, err := GetUtxoOperationsForBlock(
+		txi.TXIndexChain.DB(), nil, blockToDetach.Hash)
+	if err != nil {
+		return fmt.Errorf(
+			"_detachBlock: Error getting UtxoOps for block %v: %v", blockToDetach, err)
+	}
+	// Compute the hashes for all the transactions.
+	txHashes, err := ComputeTransactionHashes(blockMsg.Txns)
+	if err != nil {
+		return fmt.Errorf(
+			"_detachBlock: Error computing tx hashes for block %v: %v",
+			blockToDetach, err)
+	}
+	if err := utxoView.DisconnectBlock(blockMsg, txHashes, utxoOps, blockHeight); err != nil {
+		return fmt.Errorf("_detachBlock: Error detaching block "+
+			"%v from UtxoView: %v", blockToDetach, err)
+	}
+	if err := utxoView.FlushToDb(blockHeight); err != nil {
+		return fmt.Errorf("_detachBlock: Error flushing view to db for block "+
+			"%v: %v", blockToDetach, err)
+	}
+	// We have to flush a couple of extra things that the view doesn't flush...
+	err = txi.TXIndexChain.DB().Update(func(txn *badger.Txn) error {
+		if err := PutBestHashWithTxn(txn, nil, utxoView.TipHash, ChainTypeDeSoBlock); err != nil {
 			return err
 		}
+		return DbPutStateFlushHeightWithTxn(txn, nil, uint64(blockToDetach.Height)-1)
+	})
+	if err != nil {
+		return fmt.Errorf("_detachBlock: Error putting best hash for block "+
+			"%v: %v", blockToDetach, err)
+	}
+	err = txi.TXIndexChain.DB().Update(func(txn *badger.Txn) error {
+		if err := DeleteUtxoOperationsForBlockWithTxn(txn, nil, blockToDetach.Hash); err != nil {
+			return fmt.Errorf("_detachBlock: Error deleting UtxoOperations 1 for block %v, %v", blockToDetach.Hash, err)
+		}
+		if err := txn.Delete(BlockHashToBlockKey(blockToDetach.Hash)); err != nil {
+			return fmt.Errorf("_detachBlock: Error deleting UtxoOperations 2 for block %v %v", blockToDetach.Hash, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("_detachBlock: Error updating badgger: %v", err)
+	}
+	// Delete this block from the chain db so we don't get duplicate block errors.
 
-		// Now that we have added all the txns to our TxIndex db, attach the block
-		// to update our chain.
-		_, _, err = txi.TXIndexChain.ProcessBlock(blockMsg, false /*verifySignatures*/)
-		if err != nil {
-			return fmt.Errorf("Update: Problem attaching block %v: %v",
-				blockToAttach, err)
+	// Remove this block from our bestChain data structures.
+	newBlockIndex := txi.TXIndexChain.CopyBlockIndex()
+	newBestChain, newBestChainMap := txi.TXIndexChain.CopyBestChain()
+	newBestChain = newBestChain[:len(newBestChain)-1]
+	delete(newBestChainMap, *(blockToDetach.Hash))
+	delete(newBlockIndex, *(blockToDetach.Hash))
+
+	txi.TXIndexChain.SetBestChainMap(newBestChain, newBestChainMap, newBlockIndex)
+
+	// At this point the entries for the block should have been removed
+	// from both our Txindex chain and our transaction index mappings.
+	return nil
+}
+
+// _placeholderTxindexMetadata is the metadata _detachBlock writes for a txn whose metadata is missing. It
+// lists the transactor and the public keys of the txn's outputs.
+func _placeholderTxindexMetadata(txn *MsgDeSoTxn, params *DeSoParams) *TransactionMetadata {
+	txnMeta := &TransactionMetadata{
+		TxnType:                        txn.TxnMeta.GetTxnType().String(),
+		TransactorPublicKeyBase58Check: PkToString(txn.PublicKey, params),
+	}
+	for _, output := range txn.TxOutputs {
+		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+			PublicKeyBase58Check: PkToString(output.PublicKey, params),
+			Metadata:             "BasicTransferOutput",
+		})
+	}
+	return txnMeta
+}
+
+// _attachBlock connects a block of the main chain to the txindex chain and adds the mappings of its txns,
+// computing their TransactionMetadata on the way.
+func (txi *TXIndex) _attachBlock(blockToAttach *BlockNode) error {
+	glog.V(2).Infof("_attachBlock: Attaching block (height: %d, hash: %v)",
+		blockToAttach.Height, blockToAttach.Hash)
+
+	blockMsg, err := GetBlock(blockToAttach.Hash, txi.CoreChain.DB(), nil)
+	if err != nil {
+		return fmt.Errorf("_attachBlock: Problem fetching attach block "+
+			"with hash %v: %v", blockToAttach.Hash, err)
+	}
+
+	// We use a view to simulate adding transactions to our chain. This allows
+	// us to extract custom metadata fields that we can show in our block explorer.
+	//
+	// Only set a BitcoinManager if we have one. This makes some tests pass.
+	utxoView, err := NewUtxoView(txi.TXIndexChain.DB(), txi.Params, nil, nil)
+	if err != nil {
+		return fmt.Errorf(
+			"_attachBlock: Error initializing UtxoView: %v", err)
+	}
+
+	// Do each block update in a single transaction so we're safe in case the node
+	// restarts.
+	blockHeight := uint64(txi.CoreChain.BlockTip().Height)
+	err = txi.TXIndexChain.DB().Update(func(dbTxn *badger.Txn) error {
+
+		// Iterate through each transaction in the block and do the following:
+		// - Connect it to the view
+		// - Compute its mapping values, which may include custom metadata fields
+		// - add all its mappings to the db.
+		for txnIndexInBlock, txn := range blockMsg.Txns {
+			txnMeta, err := ConnectTxnAndComputeTransactionMetadata(
+				txn, utxoView, blockToAttach.Hash, blockToAttach.Height, uint64(txnIndexInBlock))
+			if err != nil {
+				return fmt.Errorf("_attachBlock: Problem connecting txn %v to txindex: %v",
+					txn, err)
+			}
+
+			err = DbPutTxindexTransactionMappingsWithTxn(dbTxn, nil, blockHeight,
+				txn, txi.Params, txnMeta)
+			if err != nil {
+				return fmt.Errorf("_attachBlock: Problem adding txn %v to txindex: %v",
+					txn, err)
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	glog.Infof("Update: Txindex update complete. New tip: (height: %d, hash: %v)",
-		txi.TXIndexChain.BlockTip().Height, txi.TXIndexChain.BlockTip().Hash)
+	// Now that we have added all the txns to our TxIndex db, attach the block
+	// to update our chain.
+	_, _, err = txi.TXIndexChain.ProcessBlock(blockMsg, false /*verifySignatures*/)
+	if err != nil {
+		return fmt.Errorf("_attachBlock: Problem attaching block %v: %v",
+			blockToAttach, err)
+	}
+	return nil
+}
+
+// Rebuild regenerates the txindex for the blocks from fromHeight to toHeight. It detaches the txindex
+// chain down to fromHeight - 1, deleting the mappings of every block it detaches, then replays the main
+// chain's blocks up to toHeight to regenerate their TransactionMetadata and public key mappings. Update
+// takes the txindex the rest of the way to the block tip afterwards.
+//
+// Detaching relies on the utxo operations and the view stored in the txindex db, so this repairs the
+// mappings but not the txindex's own copy of the state. If that's broken, the txindex has to be deleted
+// and rebuilt from the genesis block.
+func (txi *TXIndex) Rebuild(fromHeight uint64, toHeight uint64) error {
+	if fromHeight == 0 {
+		return fmt.Errorf("Rebuild: The genesis block's mappings are seeded by NewTXIndex and can't be " +
+			"rebuilt, fromHeight must be at least 1")
+	}
+	if fromHeight > toHeight {
+		return fmt.Errorf("Rebuild: fromHeight %v is greater than toHeight %v", fromHeight, toHeight)
+	}
+
+	txi.TXIndexLock.Lock()
+	defer txi.TXIndexLock.Unlock()
+
+	coreBestChain, _ := txi.CoreChain.CopyBestChain()
+	txindexBestChain, _ := txi.TXIndexChain.CopyBestChain()
+	if toHeight >= uint64(len(coreBestChain)) {
+		return fmt.Errorf("Rebuild: toHeight %v is past the block tip at height %v",
+			toHeight, len(coreBestChain)-1)
+	}
+	// The blocks below fromHeight are kept, so they have to be the main chain's.
+	if fromHeight > uint64(len(txindexBestChain)) {
+		return fmt.Errorf("Rebuild: The txindex tip at height %v is below fromHeight %v, run Update "+
+			"to index the blocks in between", len(txindexBestChain)-1, fromHeight)
+	}
+	if *txindexBestChain[fromHeight-1].Hash != *coreBestChain[fromHeight-1].Hash {
+		return fmt.Errorf("Rebuild: The txindex has block %v at height %v but the main chain has block "+
+			"%v, run Update to move the txindex onto the main chain", txindexBestChain[fromHeight-1].Hash,
+			fromHeight-1, coreBestChain[fromHeight-1].Hash)
+	}
 
+	glog.Infof("Rebuild: Rebuilding txindex from height %v to height %v", fromHeight, toHeight)
+	for ii := len(txindexBestChain) - 1; ii >= int(fromHeight); ii-- {
+		if txi.killed {
+			return fmt.Errorf("Rebuild: Killed while detaching blocks")
+		}
+		if err := txi._detachBlock(txindexBestChain[ii], true /*repairMissingMetadata*/); err != nil {
+			return fmt.Errorf("Rebuild: %v", err)
+		}
+	}
+	for height := fromHeight; height <= toHeight; height++ {
+		if txi.killed {
+			return fmt.Errorf("Rebuild: Killed while attaching blocks")
+		}
+		glog.V(1).Infof("Rebuild: Txindex progress: block %d / %d", height, toHeight)
+		if err := txi._attachBlock(coreBestChain[height]); err != nil {
+			return fmt.Errorf("Rebuild: %v", err)
+		}
+	}
+	glog.Infof("Rebuild: Txindex rebuild complete. New tip: (height: %d, hash: %v)",
+		txi.TXIndexChain.BlockTip().Height, txi.TXIndexChain.BlockTip().Hash)
 	return nil
 }
+
+// TxindexDiscrepancy is a difference between the txindex and the main chain's blocks found by Verify.
+type TxindexDiscrepancy struct {
+	BlockHeight uint64
+	BlockHash   *BlockHash
+	// TxID is nil when the discrepancy is about the block rather than one of its txns.
+	TxID        *BlockHash
+	Description string
+}
+
+func (discrepancy *TxindexDiscrepancy) String() string {
+	if discrepancy.TxID == nil {
+		return fmt.Sprintf("Block %v at height %v: %v", discrepancy.BlockHash, discrepancy.BlockHeight,
+			discrepancy.Description)
+	}
+	return fmt.Sprintf("Txn %v in block %v at height %v: %v", discrepancy.TxID, discrepancy.BlockHash,
+		discrepancy.BlockHeight, discrepancy.Description)
+}
+
+// Verify cross-checks the txindex against the main chain's blocks from fromHeight to toHeight and returns
+// the discrepancies it finds: blocks that aren't on the txindex chain, txns without TransactionMetadata or
+// whose metadata points to another block or position, and txns missing from the txns of their public
+// keys. The genesis block is skipped since its txns aren't indexed. The error is only set if the check
+// itself fails.
+func (txi *TXIndex) Verify(fromHeight uint64, toHeight uint64) ([]*TxindexDiscrepancy, error) {
+	if fromHeight == 0 {
+		fromHeight = 1
+	}
+
+	txi.TXIndexLock.RLock()
+	defer txi.TXIndexLock.RUnlock()
+
+	coreBestChain, _ := txi.CoreChain.CopyBestChain()
+	txindexBestChain, _ := txi.TXIndexChain.CopyBestChain()
+	if toHeight >= uint64(len(coreBestChain)) {
+		return nil, fmt.Errorf("Verify: toHeight %v is past the block tip at height %v",
+			toHeight, len(coreBestChain)-1)
+	}
+
+	discrepancies := []*TxindexDiscrepancy{}
+	// The txns of each public key are only fetched once.
+	txIDsByPublicKey := make(map[PkMapKey]map[BlockHash]bool)
+	for height := fromHeight; height <= toHeight; height++ {
+		blockNode := coreBestChain[height]
+		addDiscrepancy := func(txID *BlockHash, format string, args ...interface{}) {
+			discrepancies = append(discrepancies, &TxindexDiscrepancy{
+				BlockHeight: height,
+				BlockHash:   blockNode.Hash,
+				TxID:        txID,
+				Description: fmt.Sprintf(format, args...),
+			})
+		}
+
+		if height >= uint64(len(txindexBestChain)) {
+			addDiscrepancy(nil, "Not indexed, the txindex tip is at height %v", len(txindexBestChain)-1)
+			continue
+		}
+		if *txindexBestChain[height].Hash != *blockNode.Hash {
+			addDiscrepancy(nil, "The txindex chain has block %v at this height", txindexBestChain[height].Hash)
+			continue
+		}
+
+		blockMsg, err := GetBlock(blockNode.Hash, txi.CoreChain.DB(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("Verify: Problem fetching block with hash %v: %v", blockNode.Hash, err)
+		}
+		blockHashHex := hex.EncodeToString(blockNode.Hash[:])
+		for txnIndexInBlock, txn := range blockMsg.Txns {
+			txID := txn.Hash()
+			txnMeta := DbGetTxindexTransactionRefByTxID(txi.TXIndexChain.DB(), nil, txID)
+			if txnMeta == nil {
+				addDiscrepancy(txID, "Missing TransactionMetadata")
+				continue
+			}
+			if txnMeta.BlockHashHex != blockHashHex {
+				addDiscrepancy(txID, "TransactionMetadata has block hash %v", txnMeta.BlockHashHex)
+			}
+			if txnMeta.TxnIndexInBlock != uint64(txnIndexInBlock) {
+				addDiscrepancy(txID, "TransactionMetadata has index %v in the block instead of %v",
+					txnMeta.TxnIndexInBlock, txnIndexInBlock)
+			}
+			for publicKey := range _getPublicKeysForTxn(txn, txnMeta, txi.Params) {
+				txIDs, exists := txIDsByPublicKey[publicKey]
+				if !exists {
+					txIDs = make(map[BlockHash]bool)
+					for _, publicKeyTxID := range DbGetTxindexTxnsForPublicKey(txi.TXIndexChain.DB(), publicKey[:]) {
+						txIDs[*publicKeyTxID] = true
+					}
+					txIDsByPublicKey[publicKey] = txIDs
+				}
+				if !txIDs[*txID] {
+					addDiscrepancy(txID, "Missing from the txns of public key %v",
+						PkToString(publicKey[:], txi.Params))
+				}
+			}
+		}
+	}
+	return discrepancies, nil
+}
@@ -0,0 +1,884 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/gernest/mention"
+	"github.com/golang/glog"
+)
+
+// txindex_metadata.go contains the per-TxnType extractors that populate the
+// type-specific fields of a TransactionMetadata. ComputeTransactionMetadata fills in
+// the fields shared by every transaction and then hands off to the extractor that is
+// registered for the transaction's type. New transaction types can plug their txindex
+// metadata in by calling RegisterTransactionMetadataExtractor rather than by editing
+// the core txindex code.
+
+// TransactionMetadataBlockContext carries the values an extractor may need beyond the
+// transaction and the view it was connected to. Some of these, like the nanos purchased
+// before the txn, have to be captured before the txn is connected to the view.
+type TransactionMetadataBlockContext struct {
+	// BlockHash is nil for transactions that are not yet in a block, e.g. mempool txns.
+	BlockHash       *BlockHash
+	BlockHeight     uint64
+	TxnIndexInBlock uint64
+
+	// The UtxoOperations produced by connecting the transaction.
+	UtxoOps []*UtxoOperation
+
+	// View values from before the transaction was connected.
+	TotalNanosPurchasedBefore uint64
+	USDCentsPerBitcoinBefore  uint64
+}
+
+// TransactionMetadataExtractor computes the txindex metadata for a single TxnType. The
+// utxoView passed in has already had the transaction connected to it. Implementations
+// should set their type-specific field on txnMeta and append any AffectedPublicKeys.
+type TransactionMetadataExtractor interface {
+	ComputeMetadata(utxoView *UtxoView, txn *MsgDeSoTxn, block *TransactionMetadataBlockContext,
+		txnMeta *TransactionMetadata) error
+}
+
+// TransactionMetadataExtractorFunc allows an ordinary function to be used as a
+// TransactionMetadataExtractor.
+type TransactionMetadataExtractorFunc func(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error
+
+func (fn TransactionMetadataExtractorFunc) ComputeMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	return fn(utxoView, txn, block, txnMeta)
+}
+
+var (
+	transactionMetadataExtractorsLock sync.RWMutex
+	transactionMetadataExtractors     = getDefaultTransactionMetadataExtractors()
+)
+
+// RegisterTransactionMetadataExtractor sets the extractor used for txnType, replacing
+// any extractor that was previously registered. Passing a nil extractor removes the
+// registration, in which case only the common metadata is computed for the type.
+func RegisterTransactionMetadataExtractor(txnType TxnType, extractor TransactionMetadataExtractor) {
+	transactionMetadataExtractorsLock.Lock()
+	defer transactionMetadataExtractorsLock.Unlock()
+
+	if extractor == nil {
+		delete(transactionMetadataExtractors, txnType)
+		return
+	}
+	transactionMetadataExtractors[txnType] = extractor
+}
+
+// GetTransactionMetadataExtractor returns the extractor registered for txnType, or nil
+// if there isn't one.
+func GetTransactionMetadataExtractor(txnType TxnType) TransactionMetadataExtractor {
+	transactionMetadataExtractorsLock.RLock()
+	defer transactionMetadataExtractorsLock.RUnlock()
+
+	return transactionMetadataExtractors[txnType]
+}
+
+func getDefaultTransactionMetadataExtractors() map[TxnType]TransactionMetadataExtractor {
+	return map[TxnType]TransactionMetadataExtractor{
+		TxnTypeBitcoinExchange:     TransactionMetadataExtractorFunc(_computeBitcoinExchangeTxindexMetadata),
+		TxnTypeCreatorCoin:         TransactionMetadataExtractorFunc(_computeCreatorCoinTxindexMetadata),
+		TxnTypeCreatorCoinTransfer: TransactionMetadataExtractorFunc(_computeCreatorCoinTransferTxindexMetadata),
+		TxnTypeUpdateProfile:       TransactionMetadataExtractorFunc(_computeUpdateProfileTxindexMetadata),
+		TxnTypeSubmitPost:          TransactionMetadataExtractorFunc(_computeSubmitPostTxindexMetadata),
+		TxnTypeLike:                TransactionMetadataExtractorFunc(_computeLikeTxindexMetadata),
+		TxnTypeFollow:              TransactionMetadataExtractorFunc(_computeFollowTxindexMetadata),
+		TxnTypePrivateMessage:      TransactionMetadataExtractorFunc(_computePrivateMessageTxindexMetadata),
+		TxnTypeSwapIdentity:        TransactionMetadataExtractorFunc(_computeSwapIdentityTxindexMetadata),
+		TxnTypeNFTBid:              TransactionMetadataExtractorFunc(_computeNFTBidTxindexMetadata),
+		TxnTypeAcceptNFTBid:        TransactionMetadataExtractorFunc(_computeAcceptNFTBidTxindexMetadata),
+		TxnTypeCreateNFT:           TransactionMetadataExtractorFunc(_computeCreateNFTTxindexMetadata),
+		TxnTypeUpdateNFT:           TransactionMetadataExtractorFunc(_computeUpdateNFTTxindexMetadata),
+		TxnTypeNFTTransfer:         TransactionMetadataExtractorFunc(_computeNFTTransferTxindexMetadata),
+		TxnTypeAcceptNFTTransfer:   TransactionMetadataExtractorFunc(_computeAcceptNFTTransferTxindexMetadata),
+		TxnTypeBurnNFT:             TransactionMetadataExtractorFunc(_computeBurnNFTTxindexMetadata),
+		TxnTypeBasicTransfer:       TransactionMetadataExtractorFunc(_computeBasicTransferTxindexMetadata),
+		TxnTypeDAOCoin:             TransactionMetadataExtractorFunc(_computeDAOCoinTxindexMetadata),
+		TxnTypeDAOCoinTransfer:     TransactionMetadataExtractorFunc(_computeDAOCoinTransferTxindexMetadata),
+		TxnTypeDAOCoinLimitOrder:   TransactionMetadataExtractorFunc(_computeDAOCoinLimitOrderTxindexMetadata),
+		TxnTypePostAssociation:     TransactionMetadataExtractorFunc(_computePostAssociationTxindexMetadata),
+		TxnTypeUserAssociation:     TransactionMetadataExtractorFunc(_computeUserAssociationTxindexMetadata)}
+}
+
+func _computeBitcoinExchangeTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	var err error
+	txnMeta.BitcoinExchangeTxindexMetadata, txnMeta.TransactorPublicKeyBase58Check, err =
+		_computeBitcoinExchangeFields(utxoView.Params, txn.TxnMeta.(*BitcoinExchangeMetadata),
+			block.TotalNanosPurchasedBefore, block.USDCentsPerBitcoinBefore)
+	if err != nil {
+		glog.V(2).Infof(
+			"UpdateTxindex: Error computing BitcoinExchange txn metadata: %v", err)
+	} else {
+		// Set the nanos purchased before/after.
+		txnMeta.BitcoinExchangeTxindexMetadata.TotalNanosPurchasedBefore = block.TotalNanosPurchasedBefore
+		txnMeta.BitcoinExchangeTxindexMetadata.TotalNanosPurchasedAfter = utxoView.NanosPurchased
+
+		// Always associate BitcoinExchange txns with the burn public key. This makes it
+		//		// easy to enumerate all burn txns in the block explorer.
+		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+			PublicKeyBase58Check: BurnPubKeyBase58Check,
+			Metadata:             "BurnPublicKey",
+		})
+	}
+	return nil
+}
+
+func _computeCreatorCoinTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	utxoOps := block.UtxoOps
+
+	// Get the txn metadata
+	realTxMeta := txn.TxnMeta.(*CreatorCoinMetadataa)
+
+	// Rosetta needs to know the change in DESOLockedNanos so it can model the change in
+	// total deso locked in the creator coin. Calculate this by comparing the current CreatorCoinEntry
+	// to the previous CreatorCoinEntry
+	profileEntry := utxoView.GetProfileEntryForPublicKey(realTxMeta.ProfilePublicKey)
+	var prevCoinEntry *CoinEntry
+	for _, op := range utxoOps {
+		if op.Type == OperationTypeCreatorCoin {
+			prevCoinEntry = op.PrevCoinEntry
+			break
+		}
+	}
+
+	desoLockedNanosDiff := int64(0)
+	if profileEntry == nil || prevCoinEntry == nil {
+		glog.Errorf("Update TxIndex: missing DESOLockedNanosDiff error: %v", txn.Hash().String())
+	} else {
+		desoLockedNanosDiff = int64(profileEntry.CreatorCoinEntry.DeSoLockedNanos - prevCoinEntry.DeSoLockedNanos)
+	}
+
+	// Set the amount of the buy/sell/add
+	txnMeta.CreatorCoinTxindexMetadata = &CreatorCoinTxindexMetadata{
+		DeSoToSellNanos:        realTxMeta.DeSoToSellNanos,
+		CreatorCoinToSellNanos: realTxMeta.CreatorCoinToSellNanos,
+		DeSoToAddNanos:         realTxMeta.DeSoToAddNanos,
+		DESOLockedNanosDiff:    desoLockedNanosDiff,
+	}
+
+	// Set the type of the operation.
+	if realTxMeta.OperationType == CreatorCoinOperationTypeBuy {
+		txnMeta.CreatorCoinTxindexMetadata.OperationType = "buy"
+	} else if realTxMeta.OperationType == CreatorCoinOperationTypeSell {
+		txnMeta.CreatorCoinTxindexMetadata.OperationType = "sell"
+	} else {
+		txnMeta.CreatorCoinTxindexMetadata.OperationType = "add"
+	}
+
+	// Set the affected public key to the owner of the creator coin so that they
+	// get notified.
+	txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+		PublicKeyBase58Check: PkToString(realTxMeta.ProfilePublicKey, utxoView.Params),
+		Metadata:             "CreatorPublicKey",
+	})
+	return nil
+}
+
+func _computeCreatorCoinTransferTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	realTxMeta := txn.TxnMeta.(*CreatorCoinTransferMetadataa)
+	creatorProfileEntry := utxoView.GetProfileEntryForPublicKey(realTxMeta.ProfilePublicKey)
+	txnMeta.CreatorCoinTransferTxindexMetadata = &CreatorCoinTransferTxindexMetadata{
+		CreatorUsername:            string(creatorProfileEntry.Username),
+		CreatorCoinToTransferNanos: realTxMeta.CreatorCoinToTransferNanos,
+	}
+
+	diamondLevelBytes, hasDiamondLevel := txn.ExtraData[DiamondLevelKey]
+	diamondPostHash, hasDiamondPostHash := txn.ExtraData[DiamondPostHashKey]
+	if hasDiamondLevel && hasDiamondPostHash {
+		diamondLevel, bytesRead := Varint(diamondLevelBytes)
+		if bytesRead <= 0 {
+			glog.Errorf("Update TxIndex: Error reading diamond level for txn: %v", txn.Hash().String())
+		} else {
+			txnMeta.CreatorCoinTransferTxindexMetadata.DiamondLevel = diamondLevel
+			txnMeta.CreatorCoinTransferTxindexMetadata.PostHashHex = hex.EncodeToString(diamondPostHash)
+		}
+	}
+
+	txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+		PublicKeyBase58Check: PkToString(realTxMeta.ReceiverPublicKey, utxoView.Params),
+		Metadata:             "ReceiverPublicKey",
+	})
+	return nil
+}
+
+func _computeUpdateProfileTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	realTxMeta := txn.TxnMeta.(*UpdateProfileMetadata)
+
+	txnMeta.UpdateProfileTxindexMetadata = &UpdateProfileTxindexMetadata{}
+	if len(realTxMeta.ProfilePublicKey) == btcec.PubKeyBytesLenCompressed {
+		txnMeta.UpdateProfileTxindexMetadata.ProfilePublicKeyBase58Check =
+			PkToString(realTxMeta.ProfilePublicKey, utxoView.Params)
+	}
+	txnMeta.UpdateProfileTxindexMetadata.NewUsername = string(realTxMeta.NewUsername)
+	txnMeta.UpdateProfileTxindexMetadata.NewDescription = string(realTxMeta.NewDescription)
+	txnMeta.UpdateProfileTxindexMetadata.NewProfilePic = string(realTxMeta.NewProfilePic)
+	txnMeta.UpdateProfileTxindexMetadata.NewCreatorBasisPoints = realTxMeta.NewCreatorBasisPoints
+	txnMeta.UpdateProfileTxindexMetadata.NewStakeMultipleBasisPoints = realTxMeta.NewStakeMultipleBasisPoints
+	txnMeta.UpdateProfileTxindexMetadata.IsHidden = realTxMeta.IsHidden
+
+	// Add the ProfilePublicKey to the AffectedPublicKeys
+	txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+		PublicKeyBase58Check: PkToString(realTxMeta.ProfilePublicKey, utxoView.Params),
+		Metadata:             "ProfilePublicKeyBase58Check",
+	})
+	return nil
+}
+
+func _computeSubmitPostTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	extraData := txn.ExtraData
+
+	realTxMeta := txn.TxnMeta.(*SubmitPostMetadata)
+
+	txnMeta.SubmitPostTxindexMetadata = &SubmitPostTxindexMetadata{}
+	if len(realTxMeta.PostHashToModify) == HashSizeBytes {
+		txnMeta.SubmitPostTxindexMetadata.PostHashBeingModifiedHex = hex.EncodeToString(
+			realTxMeta.PostHashToModify)
+	}
+	if len(realTxMeta.ParentStakeID) == HashSizeBytes {
+		txnMeta.SubmitPostTxindexMetadata.ParentPostHashHex = hex.EncodeToString(
+			realTxMeta.ParentStakeID)
+	}
+	// If a post hash didn't get set then the hash of the transaction itself will
+	// end up being used as the post hash so set that here.
+	if txnMeta.SubmitPostTxindexMetadata.PostHashBeingModifiedHex == "" {
+		txnMeta.SubmitPostTxindexMetadata.PostHashBeingModifiedHex =
+			hex.EncodeToString(txn.Hash()[:])
+	}
+
+	// PosterPublicKeyBase58Check = TransactorPublicKeyBase58Check
+
+	// If ParentPostHashHex is set then get the parent posts public key and
+	// mark it as affected. We only check this if PostHashToModify is not set
+	// so we only generate a notification the first time someone comments on your post.
+	// ParentPosterPublicKeyBase58Check is in AffectedPublicKeys
+	if len(realTxMeta.PostHashToModify) == 0 && len(realTxMeta.ParentStakeID) == HashSizeBytes {
+		postHash := &BlockHash{}
+		copy(postHash[:], realTxMeta.ParentStakeID)
+		postEntry := utxoView.GetPostEntryForPostHash(postHash)
+		if postEntry == nil {
+			glog.V(2).Infof(
+				"UpdateTxindex: Error creating SubmitPostTxindexMetadata; "+
+					"missing parent post for hash %v", postHash)
+		} else {
+			txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+				PublicKeyBase58Check: PkToString(postEntry.PosterPublicKey, utxoView.Params),
+				Metadata:             "ParentPosterPublicKeyBase58Check",
+			})
+		}
+	}
+
+	// The profiles that are mentioned are in the AffectedPublicKeys
+	// MentionedPublicKeyBase58Check in AffectedPublicKeys. We need to
+	// parse them out of the post and then look up their public keys.
+	//
+	// Start by trying to parse the body JSON
+	bodyObj := &DeSoBodySchema{}
+	if err := json.Unmarshal(realTxMeta.Body, &bodyObj); err != nil {
+		// Don't worry about bad posts unless we're debugging with high verbosity.
+		glog.V(2).Infof("UpdateTxindex: Error parsing post body for @ mentions: "+
+			"%v %v", string(realTxMeta.Body), err)
+	} else {
+		terminators := []rune(" ,.\n&*()-+~'\"[]{}")
+		dollarTagsFound := mention.GetTagsAsUniqueStrings('$', bodyObj.Body, terminators...)
+		atTagsFound := mention.GetTagsAsUniqueStrings('@', bodyObj.Body, terminators...)
+		tagsFound := atTagsFound
+		// We check that cashtag usernames have at least 1 non-numeric character
+		dollarTagRegex := regexp.MustCompile("\\w*[a-zA-Z_]\\w*")
+		for _, dollarTagFound := range dollarTagsFound {
+			if dollarTagRegex.MatchString(dollarTagFound) {
+				tagsFound = append(tagsFound, dollarTagFound)
+			}
+		}
+		for _, tag := range tagsFound {
+			profileFound := utxoView.GetProfileEntryForUsername([]byte(strings.ToLower(tag)))
+			// Don't worry about tags that don't line up to a profile.
+			if profileFound == nil {
+				continue
+			}
+			// If we found a profile then set it as an affected public key.
+			txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+				PublicKeyBase58Check: PkToString(profileFound.PublicKey, utxoView.Params),
+				Metadata:             "MentionedPublicKeyBase58Check",
+			})
+		}
+		// Additionally, we need to check if this post is a repost and
+		// fetch the original poster
+		if repostedPostHash, isRepost := extraData[RepostedPostHash]; isRepost {
+			repostedBlockHash := &BlockHash{}
+			copy(repostedBlockHash[:], repostedPostHash)
+			repostPost := utxoView.GetPostEntryForPostHash(repostedBlockHash)
+			if repostPost != nil {
+				txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+					PublicKeyBase58Check: PkToString(repostPost.PosterPublicKey, utxoView.Params),
+					Metadata:             "RepostedPublicKeyBase58Check",
+				})
+			}
+		}
+	}
+	return nil
+}
+
+func _computeLikeTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	realTxMeta := txn.TxnMeta.(*LikeMetadata)
+
+	// LikerPublicKeyBase58Check = TransactorPublicKeyBase58Check
+
+	txnMeta.LikeTxindexMetadata = &LikeTxindexMetadata{
+		IsUnlike:    realTxMeta.IsUnlike,
+		PostHashHex: hex.EncodeToString(realTxMeta.LikedPostHash[:]),
+	}
+
+	// Get the public key of the poster and set it as having been affected
+	// by this like.
+	//
+	// PosterPublicKeyBase58Check in AffectedPublicKeys
+	postHash := &BlockHash{}
+	copy(postHash[:], realTxMeta.LikedPostHash[:])
+	postEntry := utxoView.GetPostEntryForPostHash(postHash)
+	if postEntry == nil {
+		glog.V(2).Infof(
+			"UpdateTxindex: Error creating LikeTxindexMetadata; "+
+				"missing post for hash %v", postHash)
+	} else {
+		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+			PublicKeyBase58Check: PkToString(postEntry.PosterPublicKey, utxoView.Params),
+			Metadata:             "PosterPublicKeyBase58Check",
+		})
+	}
+	return nil
+}
+
+func _computePostAssociationTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	realTxMeta := txn.TxnMeta.(*PostAssociationMetadata)
+
+	// Get the public key of the poster and set it as having been affected
+	// by this association.
+	//
+	// PosterPublicKeyBase58Check in AffectedPublicKeys
+	postEntry := utxoView.GetPostEntryForPostHash(realTxMeta.TargetPostHash)
+	if postEntry == nil {
+		glog.V(2).Infof(
+			"UpdateTxindex: Error computing PostAssociation txn metadata; "+
+				"missing post for hash %v", realTxMeta.TargetPostHash)
+	} else {
+		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+			PublicKeyBase58Check: PkToString(postEntry.PosterPublicKey, utxoView.Params),
+			Metadata:             "PosterPublicKeyBase58Check",
+		})
+	}
+	return nil
+}
+
+func _computeUserAssociationTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	realTxMeta := txn.TxnMeta.(*UserAssociationMetadata)
+
+	// TargetUserPublicKeyBase58Check in AffectedPublicKeys
+	txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+		PublicKeyBase58Check: PkToString(realTxMeta.TargetUserPublicKey, utxoView.Params),
+		Metadata:             "TargetUserPublicKeyBase58Check",
+	})
+	return nil
+}
+
+func _computeFollowTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	realTxMeta := txn.TxnMeta.(*FollowMetadata)
+
+	txnMeta.FollowTxindexMetadata = &FollowTxindexMetadata{
+		IsUnfollow: realTxMeta.IsUnfollow,
+	}
+
+	// FollowerPublicKeyBase58Check = TransactorPublicKeyBase58Check
+
+	// FollowedPublicKeyBase58Check in AffectedPublicKeys
+	txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+		PublicKeyBase58Check: PkToString(realTxMeta.FollowedPublicKey, utxoView.Params),
+		Metadata:             "FollowedPublicKeyBase58Check",
+	})
+	return nil
+}
+
+func _computePrivateMessageTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	realTxMeta := txn.TxnMeta.(*PrivateMessageMetadata)
+
+	txnMeta.PrivateMessageTxindexMetadata = &PrivateMessageTxindexMetadata{
+		TimestampNanos: realTxMeta.TimestampNanos,
+	}
+
+	// SenderPublicKeyBase58Check = TransactorPublicKeyBase58Check
+
+	// RecipientPublicKeyBase58Check in AffectedPublicKeys
+	txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+		PublicKeyBase58Check: PkToString(realTxMeta.RecipientPublicKey, utxoView.Params),
+		Metadata:             "RecipientPublicKeyBase58Check",
+	})
+	return nil
+}
+
+func _computeSwapIdentityTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	realTxMeta := txn.TxnMeta.(*SwapIdentityMetadataa)
+
+	// Rosetta needs to know the current locked deso in each profile so it can model the swap of
+	// the creator coins. Rosetta models a swap identity as two INPUTs and two OUTPUTs effectively
+	// swapping the balances of total deso locked. If no profile exists, from/to is zero.
+	fromNanos := uint64(0)
+	fromProfile := utxoView.GetProfileEntryForPublicKey(realTxMeta.FromPublicKey)
+	if fromProfile != nil {
+		fromNanos = fromProfile.CreatorCoinEntry.DeSoLockedNanos
+	}
+
+	toNanos := uint64(0)
+	toProfile := utxoView.GetProfileEntryForPublicKey(realTxMeta.ToPublicKey)
+	if toProfile != nil {
+		toNanos = toProfile.CreatorCoinEntry.DeSoLockedNanos
+	}
+
+	txnMeta.SwapIdentityTxindexMetadata = &SwapIdentityTxindexMetadata{
+		FromPublicKeyBase58Check: PkToString(realTxMeta.FromPublicKey, utxoView.Params),
+		ToPublicKeyBase58Check:   PkToString(realTxMeta.ToPublicKey, utxoView.Params),
+		FromDeSoLockedNanos:      fromNanos,
+		ToDeSoLockedNanos:        toNanos,
+	}
+
+	// The to and from public keys are affected by this.
+
+	txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+		PublicKeyBase58Check: PkToString(realTxMeta.FromPublicKey, utxoView.Params),
+		Metadata:             "FromPublicKeyBase58Check",
+	})
+	txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+		PublicKeyBase58Check: PkToString(realTxMeta.ToPublicKey, utxoView.Params),
+		Metadata:             "ToPublicKeyBase58Check",
+	})
+	return nil
+}
+
+func _computeNFTBidTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	utxoOps := block.UtxoOps
+
+	realTxMeta := txn.TxnMeta.(*NFTBidMetadata)
+
+	isBuyNow := false
+
+	utxoOp := utxoOps[len(utxoOps)-1]
+	var nftRoyaltiesMetadata NFTRoyaltiesMetadata
+	var ownerPublicKeyBase58Check string
+	var creatorPublicKeyBase58Check string
+	// We don't send notifications for standing offers.
+	if realTxMeta.SerialNumber != 0 {
+		nftKey := MakeNFTKey(realTxMeta.NFTPostHash, realTxMeta.SerialNumber)
+		nftEntry := utxoView.GetNFTEntryForNFTKey(&nftKey)
+		postEntry := utxoView.GetPostEntryForPostHash(nftEntry.NFTPostHash)
+
+		creatorPublicKeyBase58Check = PkToString(postEntry.PosterPublicKey, utxoView.Params)
+		ownerAtTimeOfBid := nftEntry.OwnerPKID
+
+		if utxoOp.PrevNFTEntry != nil && utxoOp.PrevNFTEntry.IsBuyNow {
+			isBuyNow = true
+			ownerAtTimeOfBid = utxoOp.PrevNFTEntry.OwnerPKID
+		}
+
+		ownerPublicKeyBase58Check = PkToString(utxoView.GetPublicKeyForPKID(ownerAtTimeOfBid), utxoView.Params)
+
+		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+			PublicKeyBase58Check: ownerPublicKeyBase58Check,
+			Metadata:             "NFTOwnerPublicKeyBase58Check",
+		})
+
+		if isBuyNow {
+			nftRoyaltiesMetadata = NFTRoyaltiesMetadata{
+				CreatorCoinRoyaltyNanos:     utxoOp.NFTBidCreatorRoyaltyNanos,
+				CreatorRoyaltyNanos:         utxoOp.NFTBidCreatorDESORoyaltyNanos,
+				CreatorPublicKeyBase58Check: creatorPublicKeyBase58Check,
+				AdditionalCoinRoyaltiesMap: pubKeyRoyaltyPairToBase58CheckToRoyaltyNanosMap(
+					utxoOp.NFTBidAdditionalCoinRoyalties, utxoView.Params),
+				AdditionalDESORoyaltiesMap: pubKeyRoyaltyPairToBase58CheckToRoyaltyNanosMap(
+					utxoOp.NFTBidAdditionalDESORoyalties, utxoView.Params),
+			}
+		}
+	}
+
+	txnMeta.NFTBidTxindexMetadata = &NFTBidTxindexMetadata{
+		NFTPostHashHex:            hex.EncodeToString(realTxMeta.NFTPostHash[:]),
+		SerialNumber:              realTxMeta.SerialNumber,
+		BidAmountNanos:            realTxMeta.BidAmountNanos,
+		IsBuyNowBid:               isBuyNow,
+		NFTRoyaltiesMetadata:      &nftRoyaltiesMetadata,
+		OwnerPublicKeyBase58Check: ownerPublicKeyBase58Check,
+	}
+
+	if isBuyNow {
+		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+			PublicKeyBase58Check: creatorPublicKeyBase58Check,
+			Metadata:             "NFTCreatorPublicKeyBase58Check",
+		})
+
+		for pubKeyIter, amountNanos := range txnMeta.NFTBidTxindexMetadata.NFTRoyaltiesMetadata.AdditionalCoinRoyaltiesMap {
+			pubKey := pubKeyIter
+			// Skip affected pub key if no royalty received
+			if amountNanos == 0 {
+				continue
+			}
+			txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+				PublicKeyBase58Check: pubKey,
+				Metadata:             "AdditionalNFTRoyaltyToCreatorPublicKeyBase58Check",
+			})
+		}
+
+		for pubKeyIter, amountNanos := range txnMeta.NFTBidTxindexMetadata.NFTRoyaltiesMetadata.AdditionalDESORoyaltiesMap {
+			pubKey := pubKeyIter
+			// Skip affected pub key if no royalty received
+			if amountNanos == 0 {
+				continue
+			}
+			txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+				PublicKeyBase58Check: pubKey,
+				Metadata:             "AdditionalNFTRoyaltyToCoinPublicKeyBase58Check",
+			})
+		}
+
+		_appendNFTOwnershipShareAffectedPublicKeys(utxoView, utxoOp.PrevNFTEntry, txnMeta)
+	}
+	return nil
+}
+
+func _computeAcceptNFTBidTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	utxoOps := block.UtxoOps
+
+	realTxMeta := txn.TxnMeta.(*AcceptNFTBidMetadata)
+
+	utxoOp := utxoOps[len(utxoOps)-1]
+	creatorPublicKeyBase58Check := PkToString(utxoOp.PrevPostEntry.PosterPublicKey, utxoView.Params)
+
+	txnMeta.AcceptNFTBidTxindexMetadata = &AcceptNFTBidTxindexMetadata{
+		NFTPostHashHex: hex.EncodeToString(realTxMeta.NFTPostHash[:]),
+		SerialNumber:   realTxMeta.SerialNumber,
+		BidAmountNanos: realTxMeta.BidAmountNanos,
+		NFTRoyaltiesMetadata: &NFTRoyaltiesMetadata{
+			CreatorCoinRoyaltyNanos:     utxoOp.AcceptNFTBidCreatorRoyaltyNanos,
+			CreatorRoyaltyNanos:         utxoOp.AcceptNFTBidCreatorDESORoyaltyNanos,
+			CreatorPublicKeyBase58Check: creatorPublicKeyBase58Check,
+			AdditionalCoinRoyaltiesMap: pubKeyRoyaltyPairToBase58CheckToRoyaltyNanosMap(
+				utxoOp.AcceptNFTBidAdditionalCoinRoyalties, utxoView.Params),
+			AdditionalDESORoyaltiesMap: pubKeyRoyaltyPairToBase58CheckToRoyaltyNanosMap(
+				utxoOp.AcceptNFTBidAdditionalDESORoyalties, utxoView.Params),
+		},
+	}
+
+	txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+		PublicKeyBase58Check: PkToString(utxoView.GetPublicKeyForPKID(realTxMeta.BidderPKID), utxoView.Params),
+		Metadata:             "NFTBidderPublicKeyBase58Check",
+	})
+
+	txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+		PublicKeyBase58Check: creatorPublicKeyBase58Check,
+		Metadata:             "NFTCreatorPublicKeyBase58Check",
+	})
+
+	for pubKeyIter, amountNanos := range txnMeta.AcceptNFTBidTxindexMetadata.NFTRoyaltiesMetadata.AdditionalCoinRoyaltiesMap {
+		pubKey := pubKeyIter
+		// Skip affected pub key if no royalty received
+		if amountNanos == 0 {
+			continue
+		}
+		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+			PublicKeyBase58Check: pubKey,
+			Metadata:             "AdditionalNFTRoyaltyToCreatorPublicKeyBase58Check",
+		})
+	}
+
+	for pubKeyIter, amountNanos := range txnMeta.AcceptNFTBidTxindexMetadata.NFTRoyaltiesMetadata.AdditionalDESORoyaltiesMap {
+		pubKey := pubKeyIter
+		// Skip affected pub key if no royalty received
+		if amountNanos == 0 {
+			continue
+		}
+		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+			PublicKeyBase58Check: pubKey,
+			Metadata:             "AdditionalNFTRoyaltyToCoinPublicKeyBase58Check",
+		})
+	}
+
+	_appendNFTOwnershipShareAffectedPublicKeys(utxoView, utxoOp.PrevNFTEntry, txnMeta)
+	return nil
+}
+
+// _appendNFTOwnershipShareAffectedPublicKeys adds the owners that got a share of the proceeds when
+// an NFT with ownership shares was sold.
+func _appendNFTOwnershipShareAffectedPublicKeys(utxoView *UtxoView, prevNFTEntry *NFTEntry,
+	txnMeta *TransactionMetadata) {
+
+	if prevNFTEntry == nil {
+		return
+	}
+	for ownerPKIDIter := range prevNFTEntry.OwnershipSharesBasisPoints {
+		ownerPKID := ownerPKIDIter
+		if ownerPKID == *prevNFTEntry.OwnerPKID {
+			continue
+		}
+		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+			PublicKeyBase58Check: PkToString(utxoView.GetPublicKeyForPKID(&ownerPKID), utxoView.Params),
+			Metadata:             "NFTOwnershipSharePublicKeyBase58Check",
+		})
+	}
+}
+
+func _computeCreateNFTTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	realTxMeta := txn.TxnMeta.(*CreateNFTMetadata)
+
+	postEntry := utxoView.GetPostEntryForPostHash(realTxMeta.NFTPostHash)
+
+	additionalDESORoyaltiesMap := pkidRoyaltyMapToBase58CheckToRoyaltyMap(
+		postEntry.AdditionalNFTRoyaltiesToCreatorsBasisPoints, utxoView)
+	additionalCoinRoyaltiesMap := pkidRoyaltyMapToBase58CheckToRoyaltyMap(
+		postEntry.AdditionalNFTRoyaltiesToCoinsBasisPoints, utxoView)
+	txnMeta.CreateNFTTxindexMetadata = &CreateNFTTxindexMetadata{
+		NFTPostHashHex:             hex.EncodeToString(realTxMeta.NFTPostHash[:]),
+		AdditionalDESORoyaltiesMap: additionalDESORoyaltiesMap,
+		AdditionalCoinRoyaltiesMap: additionalCoinRoyaltiesMap,
+	}
+	for pubKeyIter := range additionalDESORoyaltiesMap {
+		pubKey := pubKeyIter
+		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+			PublicKeyBase58Check: pubKey,
+			Metadata:             "AdditionalNFTRoyaltyToCreatorPublicKeyBase58Check",
+		})
+	}
+	for pubKeyIter := range additionalCoinRoyaltiesMap {
+		pubKey := pubKeyIter
+		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+			PublicKeyBase58Check: pubKey,
+			Metadata:             "AdditionalNFTRoyaltyToCoinPublicKeyBase58Check",
+		})
+	}
+	return nil
+}
+
+func _computeUpdateNFTTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	realTxMeta := txn.TxnMeta.(*UpdateNFTMetadata)
+
+	postEntry := utxoView.GetPostEntryForPostHash(realTxMeta.NFTPostHash)
+
+	additionalDESORoyaltiesMap := pkidRoyaltyMapToBase58CheckToRoyaltyMap(
+		postEntry.AdditionalNFTRoyaltiesToCreatorsBasisPoints, utxoView)
+	additionalCoinRoyaltiesMap := pkidRoyaltyMapToBase58CheckToRoyaltyMap(
+		postEntry.AdditionalNFTRoyaltiesToCoinsBasisPoints, utxoView)
+	txnMeta.UpdateNFTTxindexMetadata = &UpdateNFTTxindexMetadata{
+		NFTPostHashHex: hex.EncodeToString(realTxMeta.NFTPostHash[:]),
+		IsForSale:      realTxMeta.IsForSale,
+	}
+	txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+		PublicKeyBase58Check: PkToString(postEntry.PosterPublicKey, utxoView.Params),
+		Metadata:             "NFTCreatorPublicKeyBase58Check",
+	})
+	for pubKeyIter := range additionalDESORoyaltiesMap {
+		pubKey := pubKeyIter
+		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+			PublicKeyBase58Check: pubKey,
+			Metadata:             "AdditionalNFTRoyaltyToCreatorPublicKeyBase58Check",
+		})
+	}
+	for pubKeyIter := range additionalCoinRoyaltiesMap {
+		pubKey := pubKeyIter
+		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+			PublicKeyBase58Check: pubKey,
+			Metadata:             "AdditionalNFTRoyaltyToCoinPublicKeyBase58Check",
+		})
+	}
+	return nil
+}
+
+func _computeNFTTransferTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	realTxMeta := txn.TxnMeta.(*NFTTransferMetadata)
+
+	txnMeta.NFTTransferTxindexMetadata = &NFTTransferTxindexMetadata{
+		NFTPostHashHex: hex.EncodeToString(realTxMeta.NFTPostHash[:]),
+		SerialNumber:   realTxMeta.SerialNumber,
+	}
+
+	txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+		PublicKeyBase58Check: PkToString(realTxMeta.ReceiverPublicKey, utxoView.Params),
+		Metadata:             "NFTTransferRecipientPublicKeyBase58Check",
+	})
+	return nil
+}
+
+func _computeAcceptNFTTransferTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	realTxMeta := txn.TxnMeta.(*AcceptNFTTransferMetadata)
+
+	txnMeta.AcceptNFTTransferTxindexMetadata = &AcceptNFTTransferTxindexMetadata{
+		NFTPostHashHex: hex.EncodeToString(realTxMeta.NFTPostHash[:]),
+		SerialNumber:   realTxMeta.SerialNumber,
+	}
+	return nil
+}
+
+func _computeBurnNFTTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	realTxMeta := txn.TxnMeta.(*BurnNFTMetadata)
+
+	txnMeta.BurnNFTTxindexMetadata = &BurnNFTTxindexMetadata{
+		NFTPostHashHex: hex.EncodeToString(realTxMeta.NFTPostHash[:]),
+		SerialNumber:   realTxMeta.SerialNumber,
+	}
+	return nil
+}
+
+func _computeBasicTransferTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	diamondLevelBytes, hasDiamondLevel := txn.ExtraData[DiamondLevelKey]
+	diamondPostHash, hasDiamondPostHash := txn.ExtraData[DiamondPostHashKey]
+	if hasDiamondLevel && hasDiamondPostHash {
+		diamondLevel, bytesRead := Varint(diamondLevelBytes)
+		if bytesRead <= 0 {
+			glog.Errorf("Update TxIndex: Error reading diamond level for txn: %v", txn.Hash().String())
+		} else {
+			txnMeta.BasicTransferTxindexMetadata.DiamondLevel = diamondLevel
+			txnMeta.BasicTransferTxindexMetadata.PostHashHex = hex.EncodeToString(diamondPostHash)
+		}
+	}
+	return nil
+}
+
+func _computeDAOCoinTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	realTxMeta := txn.TxnMeta.(*DAOCoinMetadata)
+	creatorProfileEntry := utxoView.GetProfileEntryForPublicKey(realTxMeta.ProfilePublicKey)
+
+	var metadata string
+	var operationString string
+	switch realTxMeta.OperationType {
+	case DAOCoinOperationTypeMint:
+		metadata = "DAOCoinMintPublicKeyBase58Check"
+		operationString = "mint"
+	case DAOCoinOperationTypeBurn:
+		metadata = "DAOCoinBurnPublicKeyBase58Check"
+		operationString = "burn"
+	case DAOCoinOperationTypeDisableMinting:
+		metadata = "DAOCoinDisableMintingPublicKeyBase58Check"
+		operationString = "disable_minting"
+	case DAOCoinOperationTypeUpdateTransferRestrictionStatus:
+		metadata = "DAOCoinUpdateTransferRestrictionStatus"
+		operationString = "update_transfer_restriction_status"
+	}
+
+	txnMeta.DAOCoinTxindexMetadata = &DAOCoinTxindexMetadata{
+		CreatorUsername:           string(creatorProfileEntry.Username),
+		OperationType:             operationString,
+		CoinsToMintNanos:          &realTxMeta.CoinsToMintNanos,
+		CoinsToBurnNanos:          &realTxMeta.CoinsToBurnNanos,
+		TransferRestrictionStatus: realTxMeta.TransferRestrictionStatus.String(),
+	}
+
+	txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+		PublicKeyBase58Check: PkToString(creatorProfileEntry.PublicKey, utxoView.Params),
+		Metadata:             metadata,
+	})
+	return nil
+}
+
+func _computeDAOCoinTransferTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	realTxMeta := txn.TxnMeta.(*DAOCoinTransferMetadata)
+	creatorProfileEntry := utxoView.GetProfileEntryForPublicKey(realTxMeta.ProfilePublicKey)
+	txnMeta.DAOCoinTransferTxindexMetadata = &DAOCoinTransferTxindexMetadata{
+		CreatorUsername:        string(creatorProfileEntry.Username),
+		DAOCoinToTransferNanos: realTxMeta.DAOCoinToTransferNanos,
+	}
+
+	txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+		PublicKeyBase58Check: PkToString(realTxMeta.ReceiverPublicKey, utxoView.Params),
+		Metadata:             "ReceiverPublicKey",
+	})
+	return nil
+}
+
+func _computeDAOCoinLimitOrderTxindexMetadata(utxoView *UtxoView, txn *MsgDeSoTxn,
+	block *TransactionMetadataBlockContext, txnMeta *TransactionMetadata) error {
+	utxoOps := block.UtxoOps
+
+	realTxMeta := txn.TxnMeta.(*DAOCoinLimitOrderMetadata)
+
+	// We only update the mempool if the transactor submitted a new
+	// order. Not if the transactor cancelled existing orders.
+	if realTxMeta.CancelOrderID != nil || realTxMeta.CancelAllForPair {
+		return nil
+	}
+
+	if !realTxMeta.BuyingDAOCoinCreatorPublicKey.IsZeroPublicKey() {
+		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+			PublicKeyBase58Check: PkToString(realTxMeta.BuyingDAOCoinCreatorPublicKey.ToBytes(), utxoView.Params),
+			Metadata:             "BuyingDAOCoinCreatorPublicKey",
+		})
+	}
+
+	if !realTxMeta.SellingDAOCoinCreatorPublicKey.IsZeroPublicKey() {
+		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+			PublicKeyBase58Check: PkToString(realTxMeta.SellingDAOCoinCreatorPublicKey.ToBytes(), utxoView.Params),
+			Metadata:             "SellingDAOCoinCreatorPublicKey",
+		})
+	}
+
+	utxoOp := utxoOps[len(utxoOps)-1]
+	uniquePKIDMap := make(map[PKID]bool)
+	fulfilledOrderMetadata := []*FilledDAOCoinLimitOrderMetadata{}
+	for _, filledOrder := range utxoOp.FilledDAOCoinLimitOrders {
+		uniquePKIDMap[*filledOrder.TransactorPKID] = true
+		fulfilledOrderMetadata = append(fulfilledOrderMetadata, &FilledDAOCoinLimitOrderMetadata{
+			TransactorPublicKeyBase58Check: PkToString(
+				utxoView.GetPublicKeyForPKID(filledOrder.TransactorPKID), utxoView.Params),
+			BuyingDAOCoinCreatorPublicKey: PkToString(
+				utxoView.GetPublicKeyForPKID(filledOrder.BuyingDAOCoinCreatorPKID), utxoView.Params),
+			SellingDAOCoinCreatorPublicKey: PkToString(
+				utxoView.GetPublicKeyForPKID(filledOrder.SellingDAOCoinCreatorPKID), utxoView.Params),
+			CoinQuantityInBaseUnitsBought: filledOrder.CoinQuantityInBaseUnitsBought,
+			CoinQuantityInBaseUnitsSold:   filledOrder.CoinQuantityInBaseUnitsSold,
+			IsFulfilled:                   filledOrder.IsFulfilled,
+			FeeInBaseUnitsPaid:            filledOrder.FeeInBaseUnitsPaid,
+		})
+	}
+
+	for uniquePKID := range uniquePKIDMap {
+		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+			PublicKeyBase58Check: PkToString(utxoView.GetPublicKeyForPKID(&uniquePKID), utxoView.Params),
+			Metadata:             "FilledOrderPublicKey",
+		})
+	}
+
+	txnMeta.DAOCoinLimitOrderTxindexMetadata = &DAOCoinLimitOrderTxindexMetadata{
+		FilledDAOCoinLimitOrdersMetadata: fulfilledOrderMetadata,
+		BuyingDAOCoinCreatorPublicKey: PkToString(
+			realTxMeta.BuyingDAOCoinCreatorPublicKey.ToBytes(), utxoView.Params),
+		SellingDAOCoinCreatorPublicKey: PkToString(
+			realTxMeta.SellingDAOCoinCreatorPublicKey.ToBytes(), utxoView.Params),
+		ScaledExchangeRateCoinsToSellPerCoinToBuy: realTxMeta.ScaledExchangeRateCoinsToSellPerCoinToBuy,
+		QuantityToFillInBaseUnits:                 realTxMeta.QuantityToFillInBaseUnits,
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func _computeTestTransactionMetadata(t *testing.T, txnMeta DeSoTxnMetadata,
+	extraData map[string][]byte) *TransactionMetadata {
+
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	params := DeSoTestnetParams
+	utxoView, err := NewUtxoView(db, &params, nil, nil)
+	require.NoError(err)
+
+	txn := &MsgDeSoTxn{
+		TxInputs:  []*DeSoInput{},
+		TxOutputs: []*DeSoOutput{},
+		PublicKey: m0PkBytes,
+		TxnMeta:   txnMeta,
+		ExtraData: extraData,
+	}
+	return ComputeTransactionMetadata(txn, utxoView, nil, 0, 0,
+		0, 0, 0, 0, nil, 0)
+}
+
+func _hasAffectedPublicKey(txnMeta *TransactionMetadata, publicKey []byte, metadata string) bool {
+	for _, affectedPublicKey := range txnMeta.AffectedPublicKeys {
+		if affectedPublicKey.PublicKeyBase58Check == PkToString(publicKey, &DeSoTestnetParams) &&
+			affectedPublicKey.Metadata == metadata {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTransactionMetadataExtractorRegistry(t *testing.T) {
+	require := require.New(t)
+
+	// Every txn type that had metadata computed before the registry existed should
+	// have an extractor registered by default.
+	for _, txnType := range []TxnType{
+		TxnTypeBitcoinExchange, TxnTypeCreatorCoin, TxnTypeCreatorCoinTransfer, TxnTypeUpdateProfile,
+		TxnTypeSubmitPost, TxnTypeLike, TxnTypeFollow, TxnTypePrivateMessage, TxnTypeSwapIdentity,
+		TxnTypeNFTBid, TxnTypeAcceptNFTBid, TxnTypeCreateNFT, TxnTypeUpdateNFT, TxnTypeNFTTransfer,
+		TxnTypeAcceptNFTTransfer, TxnTypeBurnNFT, TxnTypeBasicTransfer, TxnTypeDAOCoin,
+		TxnTypeDAOCoinTransfer, TxnTypeDAOCoinLimitOrder,
+	} {
+		require.NotNil(GetTransactionMetadataExtractor(txnType), "missing extractor for %v", txnType)
+	}
+
+	// Override the follow extractor and make sure it's the one that gets called.
+	defaultExtractor := GetTransactionMetadataExtractor(TxnTypeFollow)
+	defer RegisterTransactionMetadataExtractor(TxnTypeFollow, defaultExtractor)
+
+	called := false
+	RegisterTransactionMetadataExtractor(TxnTypeFollow, TransactionMetadataExtractorFunc(
+		func(utxoView *UtxoView, txn *MsgDeSoTxn, block *TransactionMetadataBlockContext,
+			txnMeta *TransactionMetadata) error {
+			called = true
+			return nil
+		}))
+	txnMeta := _computeTestTransactionMetadata(t, &FollowMetadata{FollowedPublicKey: m1PkBytes}, nil)
+	require.True(called)
+	require.Nil(txnMeta.FollowTxindexMetadata)
+
+	// Removing the extractor leaves only the common metadata in place.
+	RegisterTransactionMetadataExtractor(TxnTypeFollow, nil)
+	require.Nil(GetTransactionMetadataExtractor(TxnTypeFollow))
+	txnMeta = _computeTestTransactionMetadata(t, &FollowMetadata{FollowedPublicKey: m1PkBytes}, nil)
+	require.Nil(txnMeta.FollowTxindexMetadata)
+	require.Equal(TxnTypeFollow.String(), txnMeta.TxnType)
+	require.NotNil(txnMeta.BasicTransferTxindexMetadata)
+}
+
+func TestFollowTxindexMetadataExtractor(t *testing.T) {
+	require := require.New(t)
+
+	txnMeta := _computeTestTransactionMetadata(t, &FollowMetadata{
+		FollowedPublicKey: m1PkBytes,
+		IsUnfollow:        true,
+	}, nil)
+	require.NotNil(txnMeta.FollowTxindexMetadata)
+	require.True(txnMeta.FollowTxindexMetadata.IsUnfollow)
+	require.True(_hasAffectedPublicKey(txnMeta, m1PkBytes, "FollowedPublicKeyBase58Check"))
+}
+
+func TestPrivateMessageTxindexMetadataExtractor(t *testing.T) {
+	require := require.New(t)
+
+	txnMeta := _computeTestTransactionMetadata(t, &PrivateMessageMetadata{
+		RecipientPublicKey: m1PkBytes,
+		TimestampNanos:     1234,
+	}, nil)
+	require.NotNil(txnMeta.PrivateMessageTxindexMetadata)
+	require.Equal(uint64(1234), txnMeta.PrivateMessageTxindexMetadata.TimestampNanos)
+	require.True(_hasAffectedPublicKey(txnMeta, m1PkBytes, "RecipientPublicKeyBase58Check"))
+}
+
+func TestLikeTxindexMetadataExtractor(t *testing.T) {
+	require := require.New(t)
+
+	// The liked post doesn't exist so the poster shouldn't be marked as affected.
+	postHash := &BlockHash{0x01, 0x02, 0x03}
+	txnMeta := _computeTestTransactionMetadata(t, &LikeMetadata{
+		LikedPostHash: postHash,
+	}, nil)
+	require.NotNil(txnMeta.LikeTxindexMetadata)
+	require.False(txnMeta.LikeTxindexMetadata.IsUnlike)
+	require.Equal(hex.EncodeToString(postHash[:]), txnMeta.LikeTxindexMetadata.PostHashHex)
+	require.Len(txnMeta.AffectedPublicKeys, 0)
+}
+
+func TestSwapIdentityTxindexMetadataExtractor(t *testing.T) {
+	require := require.New(t)
+
+	txnMeta := _computeTestTransactionMetadata(t, &SwapIdentityMetadataa{
+		FromPublicKey: m0PkBytes,
+		ToPublicKey:   m1PkBytes,
+	}, nil)
+	require.NotNil(txnMeta.SwapIdentityTxindexMetadata)
+	require.Equal(m0Pub, txnMeta.SwapIdentityTxindexMetadata.FromPublicKeyBase58Check)
+	require.Equal(m1Pub, txnMeta.SwapIdentityTxindexMetadata.ToPublicKeyBase58Check)
+	// Neither key has a profile so there is no locked DeSo to report.
+	require.Equal(uint64(0), txnMeta.SwapIdentityTxindexMetadata.FromDeSoLockedNanos)
+	require.Equal(uint64(0), txnMeta.SwapIdentityTxindexMetadata.ToDeSoLockedNanos)
+	require.True(_hasAffectedPublicKey(txnMeta, m0PkBytes, "FromPublicKeyBase58Check"))
+	require.True(_hasAffectedPublicKey(txnMeta, m1PkBytes, "ToPublicKeyBase58Check"))
+}
+
+func TestNFTTransferTxindexMetadataExtractors(t *testing.T) {
+	require := require.New(t)
+
+	postHash := &BlockHash{0x04, 0x05, 0x06}
+	postHashHex := hex.EncodeToString(postHash[:])
+
+	txnMeta := _computeTestTransactionMetadata(t, &NFTTransferMetadata{
+		NFTPostHash:       postHash,
+		SerialNumber:      2,
+		ReceiverPublicKey: m1PkBytes,
+	}, nil)
+	require.NotNil(txnMeta.NFTTransferTxindexMetadata)
+	require.Equal(postHashHex, txnMeta.NFTTransferTxindexMetadata.NFTPostHashHex)
+	require.Equal(uint64(2), txnMeta.NFTTransferTxindexMetadata.SerialNumber)
+	require.True(_hasAffectedPublicKey(txnMeta, m1PkBytes, "NFTTransferRecipientPublicKeyBase58Check"))
+
+	txnMeta = _computeTestTransactionMetadata(t, &AcceptNFTTransferMetadata{
+		NFTPostHash:  postHash,
+		SerialNumber: 3,
+	}, nil)
+	require.NotNil(txnMeta.AcceptNFTTransferTxindexMetadata)
+	require.Equal(postHashHex, txnMeta.AcceptNFTTransferTxindexMetadata.NFTPostHashHex)
+	require.Equal(uint64(3), txnMeta.AcceptNFTTransferTxindexMetadata.SerialNumber)
+
+	txnMeta = _computeTestTransactionMetadata(t, &BurnNFTMetadata{
+		NFTPostHash:  postHash,
+		SerialNumber: 4,
+	}, nil)
+	require.NotNil(txnMeta.BurnNFTTxindexMetadata)
+	require.Equal(postHashHex, txnMeta.BurnNFTTxindexMetadata.NFTPostHashHex)
+	require.Equal(uint64(4), txnMeta.BurnNFTTxindexMetadata.SerialNumber)
+}
+
+func TestBasicTransferTxindexMetadataExtractor(t *testing.T) {
+	require := require.New(t)
+
+	// Without diamond ExtraData nothing beyond the common metadata is set.
+	txnMeta := _computeTestTransactionMetadata(t, &BasicTransferMetadata{}, nil)
+	require.NotNil(txnMeta.BasicTransferTxindexMetadata)
+	require.Equal(int64(0), txnMeta.BasicTransferTxindexMetadata.DiamondLevel)
+	require.Equal("", txnMeta.BasicTransferTxindexMetadata.PostHashHex)
+
+	postHash := &BlockHash{0x07, 0x08, 0x09}
+	txnMeta = _computeTestTransactionMetadata(t, &BasicTransferMetadata{}, map[string][]byte{
+		DiamondLevelKey:    IntToBuf(3),
+		DiamondPostHashKey: postHash[:],
+	})
+	require.Equal(int64(3), txnMeta.BasicTransferTxindexMetadata.DiamondLevel)
+	require.Equal(hex.EncodeToString(postHash[:]), txnMeta.BasicTransferTxindexMetadata.PostHashHex)
+}
+
+func TestCreatorCoinTxindexMetadataExtractor(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	defer db.Close()
+	params := DeSoTestnetParams
+	utxoView, err := NewUtxoView(db, &params, nil, nil)
+	require.NoError(err)
+
+	// The diff in DESOLockedNanos is computed from the profile in the view and the coin entry
+	// in the creator coin operation, which precedes it in the utxo ops.
+	utxoView._setProfileEntryMappings(&ProfileEntry{
+		PublicKey:        m1PkBytes,
+		Username:         []byte("m1"),
+		CreatorCoinEntry: CoinEntry{DeSoLockedNanos: 150},
+	})
+	utxoOps := []*UtxoOperation{
+		{Type: OperationTypeSpendUtxo},
+		{Type: OperationTypeCreatorCoin, PrevCoinEntry: &CoinEntry{DeSoLockedNanos: 100}},
+	}
+	txn := &MsgDeSoTxn{
+		TxInputs:  []*DeSoInput{},
+		TxOutputs: []*DeSoOutput{},
+		PublicKey: m0PkBytes,
+		TxnMeta: &CreatorCoinMetadataa{
+			ProfilePublicKey: m1PkBytes,
+			OperationType:    CreatorCoinOperationTypeBuy,
+			DeSoToSellNanos:  50,
+		},
+	}
+	txnMeta := ComputeTransactionMetadata(txn, utxoView, nil, 0, 0,
+		0, 0, 0, 0, utxoOps, 0)
+	require.NotNil(txnMeta.CreatorCoinTxindexMetadata)
+	require.Equal("buy", txnMeta.CreatorCoinTxindexMetadata.OperationType)
+	require.Equal(uint64(50), txnMeta.CreatorCoinTxindexMetadata.DeSoToSellNanos)
+	require.Equal(int64(50), txnMeta.CreatorCoinTxindexMetadata.DESOLockedNanosDiff)
+	require.True(_hasAffectedPublicKey(txnMeta, m1PkBytes, "CreatorPublicKey"))
+}
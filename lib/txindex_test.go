@@ -0,0 +1,160 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	chainlib "github.com/btcsuite/btcd/blockchain"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxindexRebuildAndVerify(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, _ := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+	var blocks []*MsgDeSoBlock
+	for ii := 0; ii < 4; ii++ {
+		block, err := miner.MineAndProcessSingleBlock(0, mempool)
+		require.NoError(err)
+		blocks = append(blocks, block)
+	}
+	tipHeight := uint64(chain.BlockTip().Height)
+
+	txindexDir, err := ioutil.TempDir("", "txindex")
+	require.NoError(err)
+	defer os.RemoveAll(txindexDir)
+	txIndex, err := NewTXIndex(chain, params, txindexDir)
+	require.NoError(err)
+	defer txIndex.TXIndexChain.DB().Close()
+
+	// Blocks that haven't been indexed yet are reported.
+	discrepancies, err := txIndex.Verify(0, tipHeight)
+	require.NoError(err)
+	require.Len(discrepancies, int(tipHeight))
+	require.Nil(discrepancies[0].TxID)
+
+	require.NoError(txIndex.Update())
+	discrepancies, err = txIndex.Verify(0, tipHeight)
+	require.NoError(err)
+	require.Empty(discrepancies)
+
+	// Drop the metadata of a txn in the second block and its public key mappings.
+	txID := blocks[1].Txns[0].Hash()
+	txnMeta := DbGetTxindexTransactionRefByTxID(txIndex.TXIndexChain.DB(), nil, txID)
+	require.NotNil(txnMeta)
+	publicKeys := _getPublicKeysForTxn(blocks[1].Txns[0], txnMeta, params)
+	require.NotEmpty(publicKeys)
+	require.NoError(txIndex.TXIndexChain.DB().Update(func(txn *badger.Txn) error {
+		for publicKey := range publicKeys {
+			if err := DbDeleteTxindexPublicKeyToTxnMappingSingleWithTxn(txn, nil, publicKey[:], txID); err != nil {
+				return err
+			}
+		}
+		return DBDeleteWithTxn(txn, nil, DbTxindexTxIDKey(txID))
+	}))
+	discrepancies, err = txIndex.Verify(0, tipHeight)
+	require.NoError(err)
+	require.Len(discrepancies, 1)
+	require.Equal(txID, discrepancies[0].TxID)
+	require.Equal(uint64(blocks[1].Header.Height), discrepancies[0].BlockHeight)
+
+	// Restore the metadata without the mappings, which is also reported.
+	require.NoError(DbPutTxindexTransaction(txIndex.TXIndexChain.DB(), nil, tipHeight, txID, txnMeta))
+	discrepancies, err = txIndex.Verify(0, tipHeight)
+	require.NoError(err)
+	require.Len(discrepancies, len(publicKeys))
+	require.NoError(txIndex.TXIndexChain.DB().Update(func(txn *badger.Txn) error {
+		return DBDeleteWithTxn(txn, nil, DbTxindexTxIDKey(txID))
+	}))
+
+	// Rebuilding the blocks from the broken one repairs the index.
+	require.Error(txIndex.Rebuild(0, tipHeight))
+	require.Error(txIndex.Rebuild(2, tipHeight+1))
+	require.NoError(txIndex.Rebuild(uint64(blocks[1].Header.Height), tipHeight))
+	require.Equal(tipHeight, uint64(txIndex.TXIndexChain.BlockTip().Height))
+	discrepancies, err = txIndex.Verify(0, tipHeight)
+	require.NoError(err)
+	require.Empty(discrepancies)
+
+	// A rebuild can stop short of the tip, and Update indexes the rest.
+	require.NoError(txIndex.Rebuild(1, tipHeight-1))
+	discrepancies, err = txIndex.Verify(0, tipHeight)
+	require.NoError(err)
+	require.Len(discrepancies, 1)
+	require.NoError(txIndex.Update())
+	discrepancies, err = txIndex.Verify(0, tipHeight)
+	require.NoError(err)
+	require.Empty(discrepancies)
+}
+
+func TestTxindexFollowsBlockEvents(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	if chain.postgres != nil {
+		t.Skip("The txindex isn't supported on postgres")
+	}
+	// Reopen the chain with an EventManager so that the txindex can subscribe to it.
+	chain, err := NewBlockchain([]string{blockSignerPk}, 0, 0, params,
+		chainlib.NewMedianTime(), db, nil, NewEventManager(), nil, false)
+	require.NoError(err)
+	mempool, miner := NewTestMiner(t, chain, params, true)
+	_, err = miner.MineAndProcessSingleBlock(0, mempool)
+	require.NoError(err)
+
+	txindexDir, err := ioutil.TempDir("", "txindex")
+	require.NoError(err)
+	defer os.RemoveAll(txindexDir)
+	txIndex, err := NewTXIndex(chain, params, txindexDir)
+	require.NoError(err)
+	defer txIndex.TXIndexChain.DB().Close()
+	require.NoError(txIndex.Update())
+
+	// Connecting and disconnecting blocks wakes up the update thread.
+	block, err := miner.MineAndProcessSingleBlock(0, mempool)
+	require.NoError(err)
+	require.Len(txIndex.updateChan, 1)
+	<-txIndex.updateChan
+	require.NoError(chain.DisconnectBlocksToHeight(uint64(block.Header.Height) - 1))
+	require.Len(txIndex.updateChan, 1)
+	<-txIndex.updateChan
+
+	// If the node dies after the mappings of a block were written but before the block was attached
+	// to the txindex chain, resuming doesn't index the block's txns twice.
+	block, err = miner.MineAndProcessSingleBlock(0, mempool)
+	require.NoError(err)
+	blockHash, err := block.Hash()
+	require.NoError(err)
+	rewardTxn := block.Txns[0]
+	rewardPublicKey := rewardTxn.TxOutputs[0].PublicKey
+	numTxnsBefore := len(DbGetTxindexTxnsForPublicKey(txIndex.TXIndexChain.DB(), rewardPublicKey))
+	require.NoError(txIndex.TXIndexChain.DB().Update(func(txn *badger.Txn) error {
+		return DbPutTxindexTransactionMappingsWithTxn(txn, nil, uint64(block.Header.Height), rewardTxn,
+			params, &TransactionMetadata{
+				BlockHashHex:                   blockHash.String(),
+				TransactorPublicKeyBase58Check: PkToString(rewardTxn.PublicKey, params),
+				TxnOutputs:                     rewardTxn.TxOutputs,
+			})
+	}))
+	require.NoError(txIndex.Update())
+	require.Len(DbGetTxindexTxnsForPublicKey(txIndex.TXIndexChain.DB(), rewardPublicKey), numTxnsBefore+1)
+	tipHeight := uint64(chain.BlockTip().Height)
+	discrepancies, err := txIndex.Verify(0, tipHeight)
+	require.NoError(err)
+	require.Empty(discrepancies)
+
+	// If the node dies after the mappings of a block were deleted but before the block was detached,
+	// resuming detaches it.
+	require.NoError(txIndex.TXIndexChain.DB().Update(func(txn *badger.Txn) error {
+		return DbDeleteTxindexTransactionMappingsWithTxn(txn, nil, tipHeight, rewardTxn, params)
+	}))
+	require.NoError(chain.DisconnectBlocksToHeight(tipHeight - 1))
+	require.NoError(txIndex.Update())
+	require.Equal(tipHeight-1, uint64(txIndex.TXIndexChain.BlockTip().Height))
+	require.Len(DbGetTxindexTxnsForPublicKey(txIndex.TXIndexChain.DB(), rewardPublicKey), numTxnsBefore)
+}
@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"fmt"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// UserDataExportBundle holds the data a node stores for a public key, so that users can export it
+// and messaging clients can restore their groups elsewhere. MessagingKeyRotations has the rotations
+// of all the groups the public key owns, ordered by group key name and then by epoch.
+type UserDataExportBundle struct {
+	PublicKey             []byte
+	ProfileEntry          *ProfileEntry
+	DeSoBalanceNanos      uint64
+	MessagingGroupEntries []*MessagingGroupEntry
+	MessagingKeyRotations []*MessagingKeyRotationRecord
+}
+
+// DbGetUserDataExportBundleWithTxn reads the bundle in a single txn so that its parts are consistent.
+// ProfileEntry is nil if the public key has no profile.
+func DbGetUserDataExportBundleWithTxn(txn *badger.Txn, snap *Snapshot, publicKey []byte) (
+	*UserDataExportBundle, error) {
+
+	ownerPublicKey := NewPublicKey(publicKey)
+	if ownerPublicKey == nil {
+		return nil, fmt.Errorf("DbGetUserDataExportBundleWithTxn: Public key %v has the wrong length", publicKey)
+	}
+	bundle := &UserDataExportBundle{PublicKey: publicKey}
+	pkidEntry := DBGetPKIDEntryForPublicKeyWithTxn(txn, snap, publicKey)
+	if pkidEntry != nil {
+		bundle.ProfileEntry = DBGetProfileEntryForPKIDWithTxn(txn, snap, pkidEntry.PKID)
+	}
+
+	var err error
+	bundle.DeSoBalanceNanos, err = DbGetDeSoBalanceNanosForPublicKeyWithTxn(txn, snap, publicKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetUserDataExportBundleWithTxn: ")
+	}
+	bundle.MessagingGroupEntries, err = DBGetMessagingGroupEntriesForOwnerWithTxn(txn, ownerPublicKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetUserDataExportBundleWithTxn: ")
+	}
+	bundle.MessagingKeyRotations, err = DbGetMessagingKeyRotationsForOwnerWithTxn(txn, ownerPublicKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetUserDataExportBundleWithTxn: ")
+	}
+	return bundle, nil
+}
+
+func DbGetUserDataExportBundle(handle *badger.DB, snap *Snapshot, publicKey []byte) (
+	*UserDataExportBundle, error) {
+
+	var bundle *UserDataExportBundle
+	err := handle.View(func(txn *badger.Txn) error {
+		var err error
+		bundle, err = DbGetUserDataExportBundleWithTxn(txn, snap, publicKey)
+		return err
+	})
+	return bundle, err
+}
@@ -0,0 +1,256 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// MinUtxoOpsRetention is the fewest blocks below the tip that keep their utxo operations when
+// the retention policy is on. Reorgs are rarely more than a few blocks deep, so the operations
+// should almost never have to be regenerated.
+var MinUtxoOpsRetention = uint32(100)
+
+// SetUtxoOpsRetention makes the node delete the utxo operations of the main chain blocks that are
+// more than numBlocks blocks below the tip, while keeping their bodies. The operations are only
+// needed to disconnect the blocks in a reorg. When a deeper reorg needs them, they're regenerated
+// by reconnecting the blocks on top of the state of the current snapshot, so the retention policy
+// requires hypersync. A reorg that goes below the snapshot still can't be connected.
+func (bc *Blockchain) SetUtxoOpsRetention(numBlocks uint32) error {
+	bc.ChainLock.Lock()
+	defer bc.ChainLock.Unlock()
+
+	if numBlocks < MinUtxoOpsRetention {
+		return fmt.Errorf("SetUtxoOpsRetention: Retention of %v blocks is below the minimum of %v",
+			numBlocks, MinUtxoOpsRetention)
+	}
+	if bc.snapshot == nil {
+		return fmt.Errorf("SetUtxoOpsRetention: Pruning utxo operations requires hypersync")
+	}
+	if bc.postgres != nil {
+		return fmt.Errorf("SetUtxoOpsRetention: Pruning utxo operations isn't supported with postgres")
+	}
+
+	bc.utxoOpsRetention = numBlocks
+	return nil
+}
+
+// UtxoOpsPrunedBlockHeight returns the height of the highest main chain block whose utxo
+// operations were pruned by the retention policy, or zero if none were.
+func (bc *Blockchain) UtxoOpsPrunedBlockHeight() uint64 {
+	bc.ChainLock.RLock()
+	defer bc.ChainLock.RUnlock()
+
+	return bc.utxoOpsPrunedBlockHeight
+}
+
+// pruneUtxoOps deletes the utxo operations of up to maxBlocks of the main chain blocks that fell
+// out of the retention window, lowest first, and returns the number of blocks it pruned. It must
+// be called with the ChainLock held.
+func (bc *Blockchain) pruneUtxoOps(maxBlocks int) (_numPruned int, _err error) {
+	if bc.syncingState {
+		return 0, nil
+	}
+
+	tipHeight := uint64(bc.blockTip().Height)
+	if tipHeight <= uint64(bc.utxoOpsRetention) {
+		return 0, nil
+	}
+	horizon := tipHeight - uint64(bc.utxoOpsRetention)
+	startHeight := bc.utxoOpsPrunedBlockHeight + 1
+	if startHeight >= horizon {
+		return 0, nil
+	}
+	endHeight := horizon
+	if endHeight-startHeight > uint64(maxBlocks) {
+		endHeight = startHeight + uint64(maxBlocks)
+	}
+
+	err := bc.db.Update(func(txn *badger.Txn) error {
+		for height := startHeight; height < endHeight; height++ {
+			blockHash := bc.bestChain[height].Hash
+			if err := DeleteUtxoOperationsForBlockWithTxn(txn, bc.snapshot, blockHash); err != nil {
+				return errors.Wrapf(err, "Problem deleting utxo operations for block %v at height %v",
+					blockHash, height)
+			}
+		}
+		return DbPutUtxoOpsPrunedBlockHeightWithTxn(txn, endHeight-1)
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "pruneUtxoOps: ")
+	}
+
+	bc.utxoOpsPrunedBlockHeight = endHeight - 1
+	return int(endHeight - startHeight), nil
+}
+
+// GetOrRegenerateUtxoOperationsForBlock returns the utxo operations of the main chain block with
+// the given hash. If the retention policy pruned them, they're regenerated from the snapshot,
+// which can take a long time since it copies the whole snapshot state.
+func (bc *Blockchain) GetOrRegenerateUtxoOperationsForBlock(blockHash *BlockHash) ([][]*UtxoOperation, error) {
+	bc.ChainLock.Lock()
+	defer bc.ChainLock.Unlock()
+
+	blockNode, exists := bc.bestChainMap[*blockHash]
+	if !exists {
+		return nil, fmt.Errorf("GetOrRegenerateUtxoOperationsForBlock: Block %v isn't on the main chain", blockHash)
+	}
+	regeneratedUtxoOps, err := bc.regeneratePrunedUtxoOps([]*BlockNode{blockNode})
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetOrRegenerateUtxoOperationsForBlock: ")
+	}
+	if utxoOps, isRegenerated := regeneratedUtxoOps[*blockHash]; isRegenerated {
+		return utxoOps, nil
+	}
+	return GetUtxoOperationsForBlock(bc.db, bc.snapshot, blockHash)
+}
+
+// regeneratePrunedUtxoOps regenerates the utxo operations of the given main chain blocks that were
+// pruned by the retention policy, and returns them by block hash. It copies the state of the current
+// snapshot to a temporary db and reconnects the main chain blocks after the snapshot on top of it,
+// up to the highest pruned block. It returns nil if none of the blocks were pruned.
+//
+// It must be called with the ChainLock held, and before the caller modifies the db, since the
+// snapshot state is read from the main db combined with the ancestral records.
+func (bc *Blockchain) regeneratePrunedUtxoOps(blockNodes []*BlockNode) (
+	_utxoOpsByBlockHash map[BlockHash][][]*UtxoOperation, _err error) {
+
+	prunedBlockNodes := make(map[BlockHash]*BlockNode)
+	maxHeight := uint64(0)
+	for _, blockNode := range blockNodes {
+		height := uint64(blockNode.Height)
+		if height == 0 || height > bc.utxoOpsPrunedBlockHeight {
+			continue
+		}
+		if _, isMainChain := bc.bestChainMap[*blockNode.Hash]; !isMainChain {
+			continue
+		}
+		prunedBlockNodes[*blockNode.Hash] = blockNode
+		if height > maxHeight {
+			maxHeight = height
+		}
+	}
+	if len(prunedBlockNodes) == 0 {
+		return nil, nil
+	}
+
+	if bc.snapshot == nil || bc.snapshot.CurrentEpochSnapshotMetadata == nil {
+		return nil, fmt.Errorf("regeneratePrunedUtxoOps: Regenerating utxo operations requires hypersync")
+	}
+	if bc.syncingState {
+		return nil, fmt.Errorf("regeneratePrunedUtxoOps: Can't regenerate utxo operations while hypersyncing")
+	}
+	snapshotHeight := bc.snapshot.CurrentEpochSnapshotMetadata.SnapshotBlockHeight
+	for _, blockNode := range prunedBlockNodes {
+		if uint64(blockNode.Height) <= snapshotHeight {
+			return nil, fmt.Errorf("regeneratePrunedUtxoOps: Block %v at height %v is at or below the "+
+				"snapshot at height %v, so its utxo operations can't be regenerated",
+				blockNode.Hash, blockNode.Height, snapshotHeight)
+		}
+	}
+	glog.Infof("regeneratePrunedUtxoOps: Regenerating the utxo operations of %v blocks by reconnecting "+
+		"blocks %v to %v", len(prunedBlockNodes), snapshotHeight+1, maxHeight)
+
+	// The temporary db sits next to the main db, since it holds a copy of the whole state.
+	tempDir, err := os.MkdirTemp(filepath.Dir(bc.db.Opts().Dir), "utxo-ops-regeneration-")
+	if err != nil {
+		return nil, errors.Wrapf(err, "regeneratePrunedUtxoOps: Problem creating temporary directory")
+	}
+	defer os.RemoveAll(tempDir)
+	opts := PerformanceBadgerOptions(tempDir)
+	opts.ValueDir = tempDir
+	tempDb, err := badger.Open(opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "regeneratePrunedUtxoOps: Problem opening temporary db")
+	}
+	defer tempDb.Close()
+
+	if err = bc.copySnapshotStateToDb(tempDb); err != nil {
+		return nil, errors.Wrapf(err, "regeneratePrunedUtxoOps: ")
+	}
+
+	// The temporary db has no best hash, so the view is pointed at the snapshot block.
+	utxoView, err := NewUtxoView(tempDb, bc.params, nil, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "regeneratePrunedUtxoOps: Problem initializing UtxoView")
+	}
+	utxoView.TipHash = bc.bestChain[snapshotHeight].Hash
+
+	utxoOpsByBlockHash := make(map[BlockHash][][]*UtxoOperation)
+	for height := snapshotHeight + 1; height <= maxHeight; height++ {
+		blockNode := bc.bestChain[height]
+		block, err := GetBlock(blockNode.Hash, bc.db, bc.snapshot)
+		if err != nil {
+			return nil, errors.Wrapf(err, "regeneratePrunedUtxoOps: Problem fetching block %v at height %v",
+				blockNode.Hash, height)
+		}
+		txHashes, err := ComputeTransactionHashes(block.Txns)
+		if err != nil {
+			return nil, errors.Wrapf(err, "regeneratePrunedUtxoOps: Problem computing transaction hashes "+
+				"for block %v", blockNode.Hash)
+		}
+		// The signatures were verified when the block was first connected.
+		utxoOps, err := utxoView.ConnectBlock(block, txHashes, false /*verifySignatures*/, nil, height)
+		if err != nil {
+			return nil, errors.Wrapf(err, "regeneratePrunedUtxoOps: Problem reconnecting block %v at height %v",
+				blockNode.Hash, height)
+		}
+		if _, isPruned := prunedBlockNodes[*blockNode.Hash]; isPruned {
+			utxoOpsByBlockHash[*blockNode.Hash] = utxoOps
+		}
+		if err = utxoView.FlushToDb(height); err != nil {
+			return nil, errors.Wrapf(err, "regeneratePrunedUtxoOps: Problem flushing block %v at height %v",
+				blockNode.Hash, height)
+		}
+	}
+	return utxoOpsByBlockHash, nil
+}
+
+// copySnapshotStateToDb writes the state records of the current snapshot to the given db.
+func (bc *Blockchain) copySnapshotStateToDb(db *badger.DB) error {
+	// Let the pending ancestral records flushes finish so that the records match the main db.
+	bc.snapshot.WaitForAllOperationsToFinish()
+
+	for _, prefix := range StatePrefixes.StatePrefixesList {
+		startKey := prefix
+		for {
+			entries, isChunkFull, concurrencyFault, err := bc.snapshot.GetSnapshotChunk(bc.db, prefix, startKey)
+			if err != nil {
+				return errors.Wrapf(err, "copySnapshotStateToDb: Problem fetching chunk for prefix %v", prefix)
+			}
+			if concurrencyFault {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+
+			writeBatch := db.NewWriteBatch()
+			for _, entry := range entries {
+				if entry.IsEmpty() {
+					continue
+				}
+				if err = writeBatch.Set(entry.Key, entry.Value); err != nil {
+					writeBatch.Cancel()
+					return errors.Wrapf(err, "copySnapshotStateToDb: Problem writing chunk for prefix %v", prefix)
+				}
+			}
+			if err = writeBatch.Flush(); err != nil {
+				return errors.Wrapf(err, "copySnapshotStateToDb: Problem writing chunk for prefix %v", prefix)
+			}
+
+			if !isChunkFull || len(entries) == 0 {
+				break
+			}
+			// The smallest key that comes after the last entry is the last key with a zero byte appended.
+			lastKey := entries[len(entries)-1].Key
+			startKey = append(append([]byte{}, lastKey...), 0x00)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUtxoOpsRetention(t *testing.T) {
+	require := require.New(t)
+
+	oldMinUtxoOpsRetention := MinUtxoOpsRetention
+	MinUtxoOpsRetention = 2
+	defer func() {
+		MinUtxoOpsRetention = oldMinUtxoOpsRetention
+	}()
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true)
+	require.Error(chain.SetUtxoOpsRetention(1))
+	require.NoError(chain.SetUtxoOpsRetention(3))
+
+	// Keep the utxo operations of every block before they're pruned, to compare the regenerated
+	// ones against.
+	encodeUtxoOps := func(height uint64, utxoOps [][]*UtxoOperation) []byte {
+		return EncodeToBytes(height, &UtxoOperationBundle{UtxoOpBundle: utxoOps})
+	}
+	encodedUtxoOpsByHeight := make(map[uint64][]byte)
+	mineBlock := func() {
+		_, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
+		require.NoError(err)
+		tipNode := chain.blockTip()
+		utxoOps, err := GetUtxoOperationsForBlock(db, nil, tipNode.Hash)
+		require.NoError(err)
+		encodedUtxoOpsByHeight[uint64(tipNode.Height)] = encodeUtxoOps(uint64(tipNode.Height), utxoOps)
+	}
+
+	// The utxo operations of the blocks more than three blocks below the tip are pruned, but their
+	// bodies are kept.
+	for ii := 0; ii < 8; ii++ {
+		mineBlock()
+	}
+	require.Equal(uint64(4), chain.UtxoOpsPrunedBlockHeight())
+	require.Equal(uint64(4), DbGetUtxoOpsPrunedBlockHeight(db))
+	for _, blockNode := range chain.bestChain[1:] {
+		_, err := GetUtxoOperationsForBlock(db, nil, blockNode.Hash)
+		require.Equal(blockNode.Height <= 4, err != nil)
+		require.NotNil(chain.GetBlock(blockNode.Hash))
+	}
+
+	// The pruned utxo operations are regenerated from the snapshot, and match the original ones.
+	for _, blockNode := range chain.bestChain[1:] {
+		height := uint64(blockNode.Height)
+		utxoOps, err := chain.GetOrRegenerateUtxoOperationsForBlock(blockNode.Hash)
+		require.NoError(err)
+		require.Equal(encodedUtxoOpsByHeight[height], encodeUtxoOps(height, utxoOps))
+	}
+
+	// Blocks at or below the snapshot can't be regenerated.
+	chain.snapshot.CurrentEpochSnapshotMetadata.SnapshotBlockHeight = 3
+	_, err := chain.GetOrRegenerateUtxoOperationsForBlock(chain.bestChain[3].Hash)
+	require.Error(err)
+	chain.snapshot.CurrentEpochSnapshotMetadata.SnapshotBlockHeight = 0
+
+	// Disconnecting blocks whose utxo operations were pruned regenerates them, and the blocks
+	// connected after that keep theirs.
+	require.NoError(chain.DisconnectBlocksToHeight(2))
+	require.Equal(uint32(2), chain.blockTip().Height)
+	require.Equal(uint64(2), chain.UtxoOpsPrunedBlockHeight())
+	require.Equal(uint64(2), DbGetUtxoOpsPrunedBlockHeight(db))
+	mineBlock()
+	_, err = GetUtxoOperationsForBlock(db, nil, chain.blockTip().Hash)
+	require.NoError(err)
+}
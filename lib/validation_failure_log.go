@@ -0,0 +1,224 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// ValidationFailureLog persists the most recent block and txn rejections in PrefixValidationFailures,
+// so operators can see why their node diverged from the network or why a user's txns keep bouncing
+// long after the logs have rotated. The log is a ring buffer of ValidationFailureLogCapacity records.
+// Recording is best-effort: a failure to write the log is only logged, so that it never affects how
+// the block or txn itself is handled.
+type ValidationFailureLog struct {
+	db *badger.DB
+
+	mtx sync.Mutex
+	// nextSeq is loaded from the db on the first write.
+	nextSeq       uint64
+	nextSeqLoaded bool
+}
+
+type ValidationFailureType uint8
+
+const (
+	ValidationFailureTypeBlock ValidationFailureType = 0
+	ValidationFailureTypeTxn   ValidationFailureType = 1
+)
+
+func (failureType ValidationFailureType) String() string {
+	switch failureType {
+	case ValidationFailureTypeBlock:
+		return "Block"
+	case ValidationFailureTypeTxn:
+		return "Txn"
+	default:
+		return fmt.Sprintf("ValidationFailureType(%d)", uint8(failureType))
+	}
+}
+
+// ValidationFailureRecord describes one rejected block or txn. RuleError is the RuleError the
+// rejection was caused by, if any, and ErrorMessage is the full error. PeerAddr is empty if the
+// address of the peer isn't known, and PeerID is 0 if the block or txn didn't come from a peer.
+type ValidationFailureRecord struct {
+	Seq            uint64
+	Type           ValidationFailureType
+	Hash           *BlockHash
+	PeerID         uint64
+	PeerAddr       string
+	RuleError      RuleError
+	ErrorMessage   string
+	Height         uint64
+	TimestampNanos uint64
+}
+
+func (record *ValidationFailureRecord) ToBytes() []byte {
+	data := UintToBuf(record.Seq)
+	data = append(data, byte(record.Type))
+	data = append(data, record.Hash[:]...)
+	data = append(data, UintToBuf(record.PeerID)...)
+	data = append(data, EncodeByteArray([]byte(record.PeerAddr))...)
+	data = append(data, EncodeByteArray([]byte(record.RuleError))...)
+	data = append(data, EncodeByteArray([]byte(record.ErrorMessage))...)
+	data = append(data, UintToBuf(record.Height)...)
+	data = append(data, UintToBuf(record.TimestampNanos)...)
+	return data
+}
+
+func (record *ValidationFailureRecord) FromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+	var err error
+
+	if record.Seq, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "ValidationFailureRecord.FromBytes: Problem reading Seq")
+	}
+	failureType, err := rr.ReadByte()
+	if err != nil {
+		return errors.Wrapf(err, "ValidationFailureRecord.FromBytes: Problem reading Type")
+	}
+	record.Type = ValidationFailureType(failureType)
+	record.Hash = &BlockHash{}
+	if _, err = io.ReadFull(rr, record.Hash[:]); err != nil {
+		return errors.Wrapf(err, "ValidationFailureRecord.FromBytes: Problem reading Hash")
+	}
+	if record.PeerID, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "ValidationFailureRecord.FromBytes: Problem reading PeerID")
+	}
+	peerAddrBytes, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "ValidationFailureRecord.FromBytes: Problem reading PeerAddr")
+	}
+	record.PeerAddr = string(peerAddrBytes)
+	ruleErrorBytes, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "ValidationFailureRecord.FromBytes: Problem reading RuleError")
+	}
+	record.RuleError = RuleError(ruleErrorBytes)
+	errorMessageBytes, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "ValidationFailureRecord.FromBytes: Problem reading ErrorMessage")
+	}
+	record.ErrorMessage = string(errorMessageBytes)
+	if record.Height, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "ValidationFailureRecord.FromBytes: Problem reading Height")
+	}
+	if record.TimestampNanos, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "ValidationFailureRecord.FromBytes: Problem reading TimestampNanos")
+	}
+
+	return nil
+}
+
+func NewValidationFailureLog(db *badger.DB) *ValidationFailureLog {
+	return &ValidationFailureLog{
+		db: db,
+	}
+}
+
+// RecordBlockFailure records that the block was rejected with the error.
+func (failureLog *ValidationFailureLog) RecordBlockFailure(
+	blockHash *BlockHash, height uint64, peerID uint64, peerAddr string, err error) {
+
+	failureLog._record(ValidationFailureTypeBlock, blockHash, height, peerID, peerAddr, err)
+}
+
+// RecordTxnFailure records that the txn was rejected with the error. The height is the height of the
+// block the txn would have been mined in.
+func (failureLog *ValidationFailureLog) RecordTxnFailure(txHash *BlockHash, height uint64, peerID uint64, err error) {
+	failureLog._record(ValidationFailureTypeTxn, txHash, height, peerID, "", err)
+}
+
+func (failureLog *ValidationFailureLog) _record(failureType ValidationFailureType, hash *BlockHash,
+	height uint64, peerID uint64, peerAddr string, failureErr error) {
+
+	if failureLog == nil || failureLog.db == nil || hash == nil || failureErr == nil {
+		return
+	}
+	record := &ValidationFailureRecord{
+		Type:           failureType,
+		Hash:           hash,
+		PeerID:         peerID,
+		PeerAddr:       peerAddr,
+		ErrorMessage:   failureErr.Error(),
+		Height:         height,
+		TimestampNanos: uint64(time.Now().UnixNano()),
+	}
+	if ruleError, ok := errors.Cause(failureErr).(RuleError); ok {
+		record.RuleError = ruleError
+	}
+
+	failureLog.mtx.Lock()
+	defer failureLog.mtx.Unlock()
+
+	if !failureLog.nextSeqLoaded {
+		records, err := DbGetValidationFailureRecords(failureLog.db)
+		if err != nil {
+			glog.Errorf("ValidationFailureLog._record: Problem loading the log: %v", err)
+			return
+		}
+		if len(records) > 0 {
+			failureLog.nextSeq = records[len(records)-1].Seq + 1
+		}
+		failureLog.nextSeqLoaded = true
+	}
+
+	record.Seq = failureLog.nextSeq
+	err := failureLog.db.Update(func(txn *badger.Txn) error {
+		return DbPutValidationFailureRecordWithTxn(txn, record)
+	})
+	if err != nil {
+		glog.Errorf("ValidationFailureLog._record: Problem recording %v failure for %v: %v",
+			failureType, hash, err)
+		return
+	}
+	failureLog.nextSeq++
+}
+
+// GetRecentValidationFailures returns up to maxRecords of the most recent rejections, newest first.
+func (failureLog *ValidationFailureLog) GetRecentValidationFailures(maxRecords int) ([]*ValidationFailureRecord, error) {
+	return failureLog._getValidationFailures(maxRecords, func(record *ValidationFailureRecord) bool {
+		return true
+	})
+}
+
+// GetRecentValidationFailuresOfType is like GetRecentValidationFailures, but only returns blocks or txns.
+func (failureLog *ValidationFailureLog) GetRecentValidationFailuresOfType(
+	failureType ValidationFailureType, maxRecords int) ([]*ValidationFailureRecord, error) {
+
+	return failureLog._getValidationFailures(maxRecords, func(record *ValidationFailureRecord) bool {
+		return record.Type == failureType
+	})
+}
+
+// GetValidationFailuresForHash returns the logged rejections of the block or txn, newest first.
+func (failureLog *ValidationFailureLog) GetValidationFailuresForHash(hash *BlockHash) ([]*ValidationFailureRecord, error) {
+	return failureLog._getValidationFailures(ValidationFailureLogCapacity, func(record *ValidationFailureRecord) bool {
+		return *record.Hash == *hash
+	})
+}
+
+func (failureLog *ValidationFailureLog) _getValidationFailures(maxRecords int,
+	filter func(record *ValidationFailureRecord) bool) ([]*ValidationFailureRecord, error) {
+
+	if failureLog == nil || failureLog.db == nil {
+		return nil, nil
+	}
+	records, err := DbGetValidationFailureRecords(failureLog.db)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ValidationFailureLog._getValidationFailures: ")
+	}
+	var matchingRecords []*ValidationFailureRecord
+	for ii := len(records) - 1; ii >= 0 && len(matchingRecords) < maxRecords; ii-- {
+		if filter(records[ii]) {
+			matchingRecords = append(matchingRecords, records[ii])
+		}
+	}
+	return matchingRecords, nil
+}
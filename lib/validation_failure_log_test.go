@@ -0,0 +1,84 @@
+//go:build !deso_embedded
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidationFailureLog(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, recipientPkBytes := _setupFiveBlocks(t)
+	failureLog := chain.ValidationFailureLog()
+	mp := NewDeSoMempool(
+		chain, 0, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", true,
+		"" /*dataDir*/, "")
+
+	// Accepted txns and duplicates aren't logged.
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 1, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.ProcessTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	_, err = mp.ProcessTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	records, err := failureLog.GetRecentValidationFailures(10)
+	require.NoError(err)
+	require.Empty(records)
+
+	// A txn spending an unknown input is rejected and logged with its rule error and peer.
+	unconnectedTxn := &MsgDeSoTxn{
+		TxInputs:  []*DeSoInput{{TxID: *NewBlockHash(RandomBytes(HashSizeBytes)), Index: 0}},
+		TxOutputs: []*DeSoOutput{{PublicKey: recipientPkBytes, AmountNanos: 1}},
+		TxnMeta:   &BasicTransferMetadata{},
+		PublicKey: recipientPkBytes,
+	}
+	_, err = mp.ProcessTransaction(unconnectedTxn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 7 /*peerID*/, false /*verifySignatures*/)
+	require.Error(err)
+	records, err = failureLog.GetValidationFailuresForHash(unconnectedTxn.Hash())
+	require.NoError(err)
+	require.Equal(1, len(records))
+	require.Equal(ValidationFailureTypeTxn, records[0].Type)
+	require.Equal(TxErrorUnconnectedTxnNotAllowed, records[0].RuleError)
+	require.Equal(uint64(7), records[0].PeerID)
+	require.Equal(uint64(chain.blockTip().Height+1), records[0].Height)
+
+	// Block failures are logged with the peer's address, and errors that aren't rule errors have
+	// an empty rule error.
+	blockHash := NewBlockHash(RandomBytes(HashSizeBytes))
+	failureLog.RecordBlockFailure(blockHash, 6, 3, "127.0.0.1:17000",
+		RuleErrorMissingBlockProducerSignature)
+	records, err = failureLog.GetRecentValidationFailuresOfType(ValidationFailureTypeBlock, 10)
+	require.NoError(err)
+	require.Equal(1, len(records))
+	require.Equal(*blockHash, *records[0].Hash)
+	require.Equal("127.0.0.1:17000", records[0].PeerAddr)
+	require.Equal(RuleErrorMissingBlockProducerSignature, records[0].RuleError)
+
+	// A fresh log picks up the sequence where the db left off, and returns records newest first.
+	freshLog := NewValidationFailureLog(chain.db)
+	freshLog.RecordTxnFailure(txn1.Hash(), 6, 0, badger.ErrConflict)
+	records, err = freshLog.GetRecentValidationFailures(10)
+	require.NoError(err)
+	require.Equal(3, len(records))
+	require.Equal(uint64(2), records[0].Seq)
+	require.Equal(RuleError(""), records[0].RuleError)
+	require.Equal(badger.ErrConflict.Error(), records[0].ErrorMessage)
+	require.Equal(uint64(0), records[2].Seq)
+
+	// Once the ring buffer wraps, new records overwrite the oldest slots.
+	wrappedRecord := *records[0]
+	wrappedRecord.Seq = ValidationFailureLogCapacity
+	require.NoError(chain.db.Update(func(txn *badger.Txn) error {
+		return DbPutValidationFailureRecordWithTxn(txn, &wrappedRecord)
+	}))
+	records, err = DbGetValidationFailureRecords(chain.db)
+	require.NoError(err)
+	require.Equal(3, len(records))
+	require.Equal(uint64(1), records[0].Seq)
+	require.Equal(uint64(ValidationFailureLogCapacity), records[2].Seq)
+}
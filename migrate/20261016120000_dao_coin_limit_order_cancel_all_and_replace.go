@@ -0,0 +1,30 @@
+package migrate
+
+import (
+	"github.com/go-pg/pg/v10/orm"
+	migrations "github.com/robinjoseph08/go-pg-migrations/v3"
+)
+
+func init() {
+	up := func(db orm.DB) error {
+		_, err := db.Exec(`
+			ALTER TABLE pg_metadata_dao_coin_limit_orders
+				ADD COLUMN cancel_all_for_pair BOOL NOT NULL DEFAULT FALSE,
+				ADD COLUMN replace_order_id BYTEA;
+		`)
+		return err
+	}
+
+	down := func(db orm.DB) error {
+		_, err := db.Exec(`
+			ALTER TABLE pg_metadata_dao_coin_limit_orders
+				DROP COLUMN cancel_all_for_pair,
+				DROP COLUMN replace_order_id;
+		`)
+		return err
+	}
+
+	opts := migrations.MigrationOptions{}
+
+	migrations.Register("20261016120000_dao_coin_limit_order_cancel_all_and_replace", up, down, opts)
+}